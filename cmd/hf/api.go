@@ -1,24 +1,44 @@
 package main
 
 import (
+	"context"
+	"errors"
 	"fmt"
+	"io"
 	"net/http"
+	"slices"
+	"strings"
 	"time"
 
 	"github.com/gin-gonic/gin"
 	swaggerFiles "github.com/swaggo/files"
 	ginSwagger "github.com/swaggo/gin-swagger"
+	"github.com/thesabbir/hellfire/docs"
+	"github.com/thesabbir/hellfire/pkg/appliers"
 	"github.com/thesabbir/hellfire/pkg/audit"
 	"github.com/thesabbir/hellfire/pkg/auth"
 	"github.com/thesabbir/hellfire/pkg/bus"
+	"github.com/thesabbir/hellfire/pkg/certs"
 	"github.com/thesabbir/hellfire/pkg/config"
 	"github.com/thesabbir/hellfire/pkg/db"
+	"github.com/thesabbir/hellfire/pkg/dhcp"
+	"github.com/thesabbir/hellfire/pkg/dns"
 	apierrors "github.com/thesabbir/hellfire/pkg/errors"
+	"github.com/thesabbir/hellfire/pkg/graphql"
 	"github.com/thesabbir/hellfire/pkg/handlers"
 	"github.com/thesabbir/hellfire/pkg/hfconfig"
+	"github.com/thesabbir/hellfire/pkg/indicator"
+	"github.com/thesabbir/hellfire/pkg/integrity"
 	"github.com/thesabbir/hellfire/pkg/logger"
 	"github.com/thesabbir/hellfire/pkg/middleware"
+	"github.com/thesabbir/hellfire/pkg/rpc"
+	"github.com/thesabbir/hellfire/pkg/secrets"
+	"github.com/thesabbir/hellfire/pkg/snapshot"
+	"github.com/thesabbir/hellfire/pkg/syslog"
+	"github.com/thesabbir/hellfire/pkg/tasks"
+	"github.com/thesabbir/hellfire/pkg/transaction"
 	"github.com/thesabbir/hellfire/pkg/uci"
+	"github.com/thesabbir/hellfire/pkg/wanquality"
 )
 
 // @title Hellfire API
@@ -33,48 +53,114 @@ import (
 // @license.url https://www.gnu.org/licenses/gpl-3.0.html
 
 // @host localhost:8888
-// @BasePath /api
+// @BasePath /api/v1
 // @schemes http https
 
-func startAPIServer(port int, manager *config.Manager) error {
+// @securityDefinitions.apikey BearerAuth
+// @in header
+// @name Authorization
+// @description Session token from /auth/login, sent as "Bearer <token>"
+
+// @securityDefinitions.apikey APIKeyAuth
+// @in header
+// @name X-API-Key
+// @description API key from "hf apikey create"
+
+// startAPIServer serves the API with the Hellfire config (including its
+// environment overrides), and overrides applying the command-line flags to
+// it and to every reloaded config
+func startAPIServer(overrides func(*hfconfig.Config), manager *config.Manager, snapshotMgr *snapshot.Manager, txMgr *transaction.Manager) error {
 	// Load Hellfire configuration
 	hfConfig, err := hfconfig.Load("")
 	if err != nil {
 		logger.Warn("Failed to load Hellfire config, using defaults", "error", err)
 		hfConfig = hfconfig.DefaultConfig()
 	}
+	overrides(hfConfig)
 
 	// Validate configuration
 	if err := hfConfig.Validate(); err != nil {
 		return fmt.Errorf("invalid Hellfire configuration: %w", err)
 	}
 
-	// Use port from config if not specified
-	if port == 8888 {
-		port = hfConfig.API.Port
+	// A fresh install has no users: the first admin is created with a one-time token
+	if db.DB != nil {
+		if err := issueOnboardingToken(time.Duration(hfConfig.Security.OnboardingTokenTTL) * time.Second); err != nil {
+			logger.Warn("Failed to issue onboarding token", "error", err)
+		}
 	}
 
-	// Initialize handlers
-	_ = handlers.NewNetworkHandler()
-	_ = handlers.NewFirewallHandler()
-	_ = handlers.NewDHCPHandler(manager)
+	// Event buffering for handlers subscribed from here on
+	if err := bus.Configure(hfConfig.Events.Options()); err != nil {
+		return fmt.Errorf("invalid event bus configuration: %w", err)
+	}
 
-	gin.SetMode(gin.ReleaseMode)
-	r := gin.Default()
+	// Configure transaction apply order
+	txMgr.SetApplyOrder(hfConfig.Transaction.ApplyOrder)
+	txMgr.SetSkipApply(hfConfig.Transaction.SkipApply)
+	txMgr.SetRecovery(hfConfig.RecoveryNetwork(), hfConfig.RecoveryStateFile())
+	txMgr.SetProbes(hfConfig.ConfirmProbes())
+	txMgr.SetRequireRollbackReason(hfConfig.Transaction.RequireRollbackReason)
+	txMgr.SetSystemCapture(hfConfig.Transaction.CaptureSize * 1024)
+	setCanary(txMgr, hfConfig)
+
+	// Signal commits awaiting confirmation, and their rollback, on the device
+	if hfConfig.Indicator.Enabled {
+		if err := hfConfig.ConfirmIndicator().Validate(); err != nil {
+			logger.Warn("Confirmation indicator is misconfigured", "error", err)
+		}
+		txMgr.SetConfirmHook(indicator.New(hfConfig.ConfirmIndicator()).Signal)
+	}
+
+	// Rate limits and CORS, adjustable at runtime
+	settings, err := newAPISettings(hfConfig)
+	if err != nil {
+		return err
+	}
 
-	// Initialize rate limiters
-	globalLimiter := middleware.NewIPRateLimiter(
-		hfConfig.RateLimit.GlobalRequestsPerMinute,
-		hfConfig.RateLimit.GlobalBurst,
-	)
+	// Re-apply runtime-tunable settings when the Hellfire config changes
+	newConfigReloader(hfConfig, overrides, settings, txMgr, snapshotMgr).start()
+
+	// Start gRPC server alongside the REST API if enabled
+	if hfConfig.GRPC.Enabled {
+		var tlsCfg *rpc.TLSConfig
+		if hfConfig.GRPC.CertFile != "" {
+			tlsCfg = &rpc.TLSConfig{
+				CertFile:     hfConfig.GRPC.CertFile,
+				KeyFile:      hfConfig.GRPC.KeyFile,
+				ClientCAFile: hfConfig.GRPC.ClientCAFile,
+			}
+		} else {
+			logger.Warn("gRPC server is running without TLS")
+		}
 
-	authLimiter := middleware.NewIPRateLimiter(
-		hfConfig.RateLimit.AuthRequestsPerMinute,
-		hfConfig.RateLimit.AuthBurst,
-	)
+		grpcServer, err := rpc.NewServer(manager, snapshotMgr, txMgr, tlsCfg)
+		if err != nil {
+			return fmt.Errorf("failed to create gRPC server: %w", err)
+		}
 
-	// Initialize CSRF manager
-	csrfMgr := middleware.NewCSRFManager()
+		lis, err := listen(fmt.Sprintf(":%d", hfConfig.GRPC.Port), hfConfig.GRPC.VRF)
+		if err != nil {
+			return fmt.Errorf("failed to start gRPC server: %w", err)
+		}
+		go func() {
+			if err := grpcServer.Serve(lis); err != nil {
+				logger.Error("gRPC server stopped", "error", err)
+			}
+		}()
+	}
+
+	// Listen for knocks that open the management ports
+	if hfConfig.SPA.Enabled {
+		if err := startSPAServer(hfConfig.SPA); err != nil {
+			return err
+		}
+	}
+
+	// Initialize handlers
+	_ = handlers.NewNetworkHandler()
+	_ = handlers.NewFirewallHandler()
+	_ = handlers.NewDHCPHandler(manager)
 
 	// Start audit log cleanup scheduler (runs daily)
 	if hfConfig.Audit.Enabled {
@@ -85,22 +171,109 @@ func startAPIServer(port int, manager *config.Manager) error {
 	// Start session cleanup scheduler (runs every hour)
 	auth.StartSessionCleanupScheduler(1 * time.Hour)
 
+	// Restart DHCP clients that exit and report address changes, count quota
+	// usage, enforce exceeded quotas and watch the links; a simulated system
+	// has none of these
+	if !hfConfig.Transaction.Simulate {
+		appliers.DHCPClients.StartMonitor(appliers.DefaultDHCPMonitorInterval)
+		appliers.Quotas.StartMonitor(appliers.DefaultQuotaMonitorInterval)
+
+		// React to interfaces appearing and cables being plugged in or unplugged
+		startLinkMonitor(manager, txMgr)
+	}
+
+	// Measure WAN latency, jitter, loss and throughput
+	if hfConfig.WAN.Enabled {
+		wanMonitor = wanquality.NewMonitor(hfConfig.WANQuality())
+		wanMonitor.Start()
+	}
+
+	// Record certificates and report those about to expire
+	newCertInventory(hfConfig).StartMonitor(certs.DefaultCheckInterval)
+
+	// Warn when a DHCP pool is about to run out of addresses
+	dhcp.NewMonitor(func() ([]dhcp.Usage, error) {
+		return dhcpPoolUsage(manager)
+	}).Start(dhcp.DefaultCheckInterval)
+
+	// Receive system logs for the log viewer
+	if hfConfig.Syslog.Enabled {
+		collector := syslog.NewCollector(hfConfig.SyslogCollector())
+		queryLog := dns.NewQueryLog(dns.DefaultQueryLogSize)
+		collector.OnReceive(func(entry syslog.Entry) {
+			if entry.App == "dnsmasq" {
+				queryLog.Record(entry.Message, entry.Received)
+			}
+		})
+		if err := collector.Start(); err != nil {
+			logger.Error("Syslog collector not started", "error", err)
+		} else {
+			logCollector = collector
+			dnsQueryLog = queryLog
+		}
+	}
+
+	// Run the scheduled tasks
+	taskScheduler = newTaskScheduler()
+	taskScheduler.Start(tasks.DefaultRetention)
+
+	r, err := newRouter(hfConfig, settings, manager, snapshotMgr, txMgr)
+	if err != nil {
+		return err
+	}
+
+	// Complete an upgrade that restarted the service once it is serving
+	go func() {
+		time.Sleep(5 * time.Second)
+		verifyUpgrade(txMgr)
+	}()
+
+	return serveHTTP(newHTTPServer(r, hfConfig.API), hfConfig.API.ListenAddresses(), hfConfig.API.VRF)
+}
+
+// newRouter builds the HTTP router serving the REST API, documentation and web UI.
+// Rate limits and CORS come from settings so they can be changed at runtime.
+func newRouter(hfConfig *hfconfig.Config, settings *apiSettings, manager *config.Manager, snapshotMgr *snapshot.Manager, txMgr *transaction.Manager) (*gin.Engine, error) {
+	gin.SetMode(gin.ReleaseMode)
+	r := gin.Default()
+
+	// The client IP is the peer address: trusting X-Forwarded-For from any
+	// peer would let a client pick its IP and dodge the rate limits
+	if err := r.SetTrustedProxies(nil); err != nil {
+		return nil, err
+	}
+
+	// Initialize CSRF manager
+	csrfMgr := middleware.NewCSRFManager()
+
+	// Initialize idempotency store (replays retried writes carrying an Idempotency-Key)
+	idempotencyStore := middleware.NewIdempotencyStore()
+
 	// Security headers middleware (should be early in the chain)
-	r.Use(middleware.SecurityHeadersMiddleware())
+	r.Use(middleware.SecurityHeadersMiddleware(settings.headers.Load))
 
 	// CORS middleware (configured via Hellfire config)
-	if hfConfig.API.EnableCORS {
-		r.Use(corsMiddleware(hfConfig.API.AllowedOrigins))
-	}
+	r.Use(middleware.CORSMiddleware(settings.cors.Load))
 
 	// Request logging middleware (log all requests)
 	r.Use(middleware.RequestLoggingMiddleware())
 
+	// Audit context (IP and request ID) for the handlers' audit entries
+	r.Use(middleware.AuditContextMiddleware())
+
+	// Response compression (gzip/deflate)
+	if hfConfig.API.EnableCompression {
+		r.Use(middleware.CompressionMiddleware(middleware.DefaultCompressionMinSize))
+	}
+
 	// Content-Type validation
 	r.Use(middleware.ContentTypeValidationMiddleware())
 
+	// Request body size limit
+	r.Use(middleware.BodySizeLimitMiddleware(hfConfig.API.MaxBodySize))
+
 	// Global rate limiting
-	r.Use(middleware.RateLimitMiddleware(globalLimiter))
+	r.Use(middleware.RateLimitMiddleware(settings.globalLimiter))
 
 	// Swagger documentation (protected with authentication if enabled)
 	if hfConfig.Security.EnableSwagger {
@@ -112,47 +285,107 @@ func startAPIServer(port int, manager *config.Manager) error {
 
 		// OpenAPI JSON also requires auth
 		r.GET("/api/openapi.json", auth.AuthMiddleware(), func(c *gin.Context) {
-			c.File("./docs/swagger.json")
+			c.Data(http.StatusOK, "application/json", []byte(docs.SwaggerInfo.ReadDoc()))
 		})
 	}
 
 	// Health check (public)
-	r.GET("/health", healthHandler)
+	r.GET("/health", healthHandler(txMgr))
+
+	// Read-only GraphQL schema for dashboards on high-latency links
+	var graphQLSchema *graphql.Schema
+	if hfConfig.API.EnableGraphQL {
+		var err error
+		if graphQLSchema, err = newGraphQLSchema(settings, manager, snapshotMgr); err != nil {
+			return nil, fmt.Errorf("failed to build the GraphQL schema: %w", err)
+		}
+	}
 
-	// Public API routes
-	api := r.Group("/api")
-	{
+	// API routes, registered once per served path (see below)
+	registerAPI := func(api *gin.RouterGroup) {
 		// Bootstrap endpoint (public)
 		api.GET("/bootstrap", bootstrapHandler)
 
 		// Onboarding endpoint (public, only when no users exist)
-		api.POST("/onboarding", middleware.RateLimitMiddleware(authLimiter), onboardingHandler)
+		api.POST("/onboarding", middleware.RateLimitMiddleware(settings.authLimiter), onboardingHandler)
 
 		// Authentication endpoints
 		api.GET("/auth/csrf", middleware.GetCSRFTokenHandler(csrfMgr)) // Get CSRF token
-		api.POST("/auth/login", middleware.RateLimitMiddleware(authLimiter), loginHandler)
+		api.POST("/auth/login", middleware.RateLimitMiddleware(settings.authLimiter), loginHandler(settings))
 		api.POST("/auth/logout", auth.AuthMiddleware(), middleware.CSRFMiddleware(csrfMgr), logoutHandler)
 		api.GET("/auth/me", auth.AuthMiddleware(), meHandler)
+		api.PUT("/auth/me", auth.AuthMiddleware(), settings.rateLimits.Limit(middleware.RateLimitWrite),
+			middleware.CSRFMiddleware(csrfMgr), updateProfileHandler)
+		api.PUT("/auth/me/password", middleware.RateLimitMiddleware(settings.authLimiter), auth.AuthMiddleware(),
+			middleware.CSRFMiddleware(csrfMgr), changePasswordHandler)
+		api.POST("/auth/impersonate", middleware.RateLimitMiddleware(settings.authLimiter), auth.AuthMiddleware(),
+			auth.RequireRole(db.RoleAdmin), middleware.CSRFMiddleware(csrfMgr), impersonateHandler)
+		api.GET("/auth/devices", auth.AuthMiddleware(), listDevicesHandler)
+		api.DELETE("/auth/devices/:id", auth.AuthMiddleware(), middleware.CSRFMiddleware(csrfMgr), revokeDeviceHandler)
+
+		// Option schema, for forms
+		api.GET("/schema/:config", auth.AuthMiddleware(), configSchemaHandler)
+
+		// GraphQL queries (no CSRF: they can't change anything)
+		if graphQLSchema != nil {
+			api.POST("/graphql", auth.AuthMiddleware(), settings.rateLimits.Limit(middleware.RateLimitRead),
+				graphQLHandler(graphQLSchema))
+			api.GET("/graphql/schema", auth.AuthMiddleware(), graphQLSchemaHandler(graphQLSchema))
+		}
 
-		// Protected config routes (requires authentication + CSRF for state changes)
-		configRoutes := api.Group("/config", auth.AuthMiddleware())
+		// Protected config routes (requires authentication + CSRF for state changes).
+		// Writes in the config, snapshot and system groups accept an Idempotency-Key header.
+		configRoutes := api.Group("/config", auth.AuthMiddleware(), settings.rateLimits.LimitByMethod(),
+			middleware.IdempotencyMiddleware(idempotencyStore), configGenerationMiddleware)
 		{
 			// Read operations (no CSRF required)
-			configRoutes.GET("/:name", getConfigHandler(manager))
-			configRoutes.GET("/:name/:section", getSectionHandler(manager))
-			configRoutes.GET("/:name/:section/:option", getOptionHandler(manager))
-			configRoutes.GET("/changes", changesHandler(manager))
+			configRoutes.GET("/generation", configGenerationHandler)
+			configRoutes.GET("/locks", listConfigLocksHandler)
+			configRoutes.GET("/:name", getConfigHandler(settings, manager))
+			configRoutes.GET("/:name/:section", getSectionHandler(settings, manager))
+			configRoutes.GET("/:name/:section/:option", getOptionHandler(settings, manager))
+			configRoutes.GET("/changes", changesHandler(settings, manager))
 
 			// Write operations (CSRF required)
 			configRoutes.PUT("/:name/:section/:option",
 				middleware.CSRFMiddleware(csrfMgr),
 				auth.RequireRole(db.RoleAdmin, db.RoleOperator),
-				setOptionHandler(manager))
+				setOptionHandler(settings, manager))
+
+			configRoutes.PUT("/:name/lock",
+				middleware.CSRFMiddleware(csrfMgr),
+				auth.RequireRole(db.RoleAdmin, db.RoleOperator),
+				lockConfigHandler)
+
+			configRoutes.DELETE("/:name/lock",
+				middleware.CSRFMiddleware(csrfMgr),
+				auth.RequireRole(db.RoleAdmin, db.RoleOperator),
+				unlockConfigHandler)
+
+			configRoutes.POST("/batch",
+				middleware.CSRFMiddleware(csrfMgr),
+				auth.RequireRole(db.RoleAdmin, db.RoleOperator),
+				batchHandler(settings, manager))
 
 			configRoutes.POST("/commit",
 				middleware.CSRFMiddleware(csrfMgr),
 				auth.RequireRole(db.RoleAdmin, db.RoleOperator),
-				commitHandler(manager))
+				commitHandler(manager, txMgr, hfConfig.Transaction))
+
+			configRoutes.POST("/confirm",
+				middleware.CSRFMiddleware(csrfMgr),
+				auth.RequireRole(db.RoleAdmin, db.RoleOperator),
+				confirmHandler(txMgr))
+
+			configRoutes.POST("/keepalive",
+				middleware.CSRFMiddleware(csrfMgr),
+				auth.RequireRole(db.RoleAdmin, db.RoleOperator),
+				keepaliveHandler(txMgr))
+
+			configRoutes.POST("/rollback",
+				middleware.CSRFMiddleware(csrfMgr),
+				auth.RequireRole(db.RoleAdmin, db.RoleOperator),
+				rollbackPendingHandler(txMgr))
 
 			configRoutes.POST("/revert",
 				middleware.CSRFMiddleware(csrfMgr),
@@ -162,61 +395,360 @@ func startAPIServer(port int, manager *config.Manager) error {
 			configRoutes.POST("/validate",
 				middleware.CSRFMiddleware(csrfMgr),
 				auth.RequireRole(db.RoleAdmin, db.RoleOperator),
-				validateHandler(manager))
+				validateHandler(manager, txMgr))
 		}
-	}
 
-	// Serve static files from web UI build (for production)
-	r.Static("/assets", "./web/dist/assets")
-	r.StaticFile("/vite.svg", "./web/dist/vite.svg")
+		// Snapshot routes
+		snapshotRoutes := api.Group("/snapshots", auth.AuthMiddleware(), settings.rateLimits.LimitByMethod(),
+			middleware.IdempotencyMiddleware(idempotencyStore))
+		{
+			snapshotRoutes.GET("", listSnapshotsHandler(snapshotMgr))
+			snapshotRoutes.POST("/:id/stage",
+				middleware.CSRFMiddleware(csrfMgr),
+				auth.RequireRole(db.RoleAdmin, db.RoleOperator),
+				stageSnapshotHandler(manager, snapshotMgr))
+			snapshotRoutes.POST("/:id/rollback",
+				middleware.CSRFMiddleware(csrfMgr),
+				auth.RequireRole(db.RoleAdmin, db.RoleOperator),
+				rollbackSnapshotHandler(txMgr))
+			snapshotRoutes.POST("/:id/protect",
+				middleware.CSRFMiddleware(csrfMgr),
+				auth.RequireRole(db.RoleAdmin, db.RoleOperator),
+				protectSnapshotHandler(snapshotMgr))
+			snapshotRoutes.DELETE("/:id/protect",
+				middleware.CSRFMiddleware(csrfMgr),
+				auth.RequireRole(db.RoleAdmin, db.RoleOperator),
+				unprotectSnapshotHandler(snapshotMgr))
+		}
 
-	// SPA fallback - serve index.html for all other routes
-	r.NoRoute(func(c *gin.Context) {
-		c.File("./web/dist/index.html")
-	})
+		// Transaction routes
+		transactionRoutes := api.Group("/transactions", auth.AuthMiddleware(),
+			settings.rateLimits.Limit(middleware.RateLimitDiagnostics))
+		{
+			transactionRoutes.GET("/:txid/timeline", transactionTimelineHandler(snapshotMgr))
+			transactionRoutes.GET("/:txid/artifacts", transactionArtifactsHandler)
+		}
 
-	addr := fmt.Sprintf(":%d", port)
-	fmt.Printf("Starting API server on %s\n", addr)
-	return r.Run(addr)
+		// Health score, and as Prometheus metrics for scrapers with an API key
+		api.GET("/health/score", auth.AuthMiddleware(), settings.rateLimits.Limit(middleware.RateLimitDiagnostics),
+			healthScoreHandler(hfConfig, manager, txMgr))
+		api.GET("/metrics", auth.APIKeyMiddleware(), settings.rateLimits.Limit(middleware.RateLimitDiagnostics),
+			metricsHandler(hfConfig, manager, txMgr))
+
+		// Activity summary for dashboards
+		api.GET("/stats/summary", auth.AuthMiddleware(), auth.RequireRole(db.RoleAdmin, db.RoleOperator),
+			settings.rateLimits.Limit(middleware.RateLimitDiagnostics), statsSummaryHandler)
+
+		// WAN quality routes
+		wanRoutes := api.Group("/wan", auth.AuthMiddleware(),
+			settings.rateLimits.Limit(middleware.RateLimitDiagnostics))
+		{
+			wanRoutes.GET("/quality", wanQualityHandler)
+		}
+
+		// Certificate inventory routes (admin only)
+		certInventory := newCertInventory(hfConfig)
+		certRoutes := api.Group("/certs", auth.AuthMiddleware(), auth.RequireRole(db.RoleAdmin),
+			settings.rateLimits.LimitByMethod())
+		{
+			certRoutes.GET("", listCertsHandler(certInventory))
+			certRoutes.POST("",
+				middleware.CSRFMiddleware(csrfMgr),
+				uploadCertHandler(certInventory))
+			certRoutes.PUT("/:name",
+				middleware.CSRFMiddleware(csrfMgr),
+				rotateCertHandler(certInventory))
+		}
+
+		// System log routes
+		logRoutes := api.Group("/logs", auth.AuthMiddleware(), auth.RequireRole(db.RoleAdmin, db.RoleOperator),
+			settings.rateLimits.Limit(middleware.RateLimitDiagnostics))
+		{
+			logRoutes.GET("", logsHandler)
+		}
+
+		// Network setup wizard, after the admin user is created (admin only)
+		onboardingRoutes := api.Group("/onboarding/network", auth.AuthMiddleware(), auth.RequireRole(db.RoleAdmin),
+			settings.rateLimits.LimitByMethod())
+		{
+			onboardingRoutes.GET("", onboardingNetworkHandler(txMgr))
+			onboardingRoutes.POST("/preview",
+				middleware.CSRFMiddleware(csrfMgr),
+				onboardingPreviewHandler(manager, txMgr))
+			onboardingRoutes.POST("",
+				middleware.CSRFMiddleware(csrfMgr),
+				onboardingApplyHandler(manager, txMgr))
+		}
+
+		// Network device routes
+		networkRoutes := api.Group("/network", auth.AuthMiddleware(), settings.rateLimits.LimitByMethod())
+		{
+			networkRoutes.GET("/devices", networkDevicesHandler(manager))
+			networkRoutes.GET("/modems", modemsHandler(manager))
+		}
+
+		// DHCP routes
+		dhcpRoutes := api.Group("/dhcp", auth.AuthMiddleware(), settings.rateLimits.LimitByMethod())
+		{
+			dhcpRoutes.GET("/pools", dhcpPoolsHandler(manager))
+		}
+
+		// WireGuard routes; client configs hold private keys (admin only)
+		wireguardRoutes := api.Group("/wireguard", auth.AuthMiddleware(), settings.rateLimits.LimitByMethod())
+		{
+			wireguardRoutes.GET("/status", wireguardStatusHandler(manager))
+			wireguardRoutes.POST("/keys",
+				auth.RequireRole(db.RoleAdmin, db.RoleOperator),
+				middleware.CSRFMiddleware(csrfMgr),
+				generateWireGuardKeysHandler)
+			wireguardRoutes.POST("/:interface/peers/:peer/keys",
+				auth.RequireRole(db.RoleAdmin, db.RoleOperator),
+				middleware.CSRFMiddleware(csrfMgr),
+				generatePeerKeysHandler(settings, manager))
+			wireguardRoutes.GET("/:interface/peers/:peer/config",
+				auth.RequireRole(db.RoleAdmin),
+				peerConfigHandler(manager))
+		}
+
+		// DNS routes
+		dnsRoutes := api.Group("/dns", auth.AuthMiddleware(), settings.rateLimits.LimitByMethod())
+		{
+			dnsRoutes.GET("", dnsHandler(settings, manager))
+			dnsRoutes.GET("/records", dnsRecordsHandler(settings, manager))
+			dnsRoutes.POST("/records",
+				auth.RequireRole(db.RoleAdmin, db.RoleOperator),
+				middleware.CSRFMiddleware(csrfMgr),
+				addDNSRecordHandler(settings, manager))
+			dnsRoutes.PUT("/records/:id",
+				auth.RequireRole(db.RoleAdmin, db.RoleOperator),
+				middleware.CSRFMiddleware(csrfMgr),
+				updateDNSRecordHandler(settings, manager))
+			dnsRoutes.DELETE("/records/:id",
+				auth.RequireRole(db.RoleAdmin, db.RoleOperator),
+				middleware.CSRFMiddleware(csrfMgr),
+				deleteDNSRecordHandler(settings, manager))
+			dnsRoutes.GET("/hosts", dnsHostsHandler(settings, manager))
+			dnsRoutes.GET("/stats",
+				auth.RequireRole(db.RoleAdmin, db.RoleOperator),
+				dnsStatsHandler(manager))
+			dnsRoutes.POST("/hosts",
+				auth.RequireRole(db.RoleAdmin, db.RoleOperator),
+				middleware.CSRFMiddleware(csrfMgr),
+				addDNSHostHandler(settings, manager))
+			dnsRoutes.DELETE("/hosts/:id",
+				auth.RequireRole(db.RoleAdmin, db.RoleOperator),
+				middleware.CSRFMiddleware(csrfMgr),
+				deleteDNSHostHandler(settings, manager))
+		}
+
+		// Scheduled task routes (admin only)
+		taskRoutes := api.Group("/tasks", auth.AuthMiddleware(), auth.RequireRole(db.RoleAdmin),
+			settings.rateLimits.LimitByMethod())
+		{
+			taskRoutes.GET("", listTasksHandler)
+			taskRoutes.GET("/:name/runs", listTaskRunsHandler)
+			taskRoutes.POST("/:name/run",
+				middleware.CSRFMiddleware(csrfMgr),
+				runTaskHandler)
+		}
+
+		// Plugin command routes, for add-on daemons with an admin API key
+		pluginRoutes := api.Group("/plugins", auth.APIKeyMiddleware(), auth.RequireRole(db.RoleAdmin),
+			settings.rateLimits.LimitByMethod())
+		{
+			pluginRoutes.GET("", listPluginsHandler)
+			pluginRoutes.PUT("/:name", registerPluginHandler)
+			pluginRoutes.DELETE("/:name", unregisterPluginHandler)
+		}
+
+		// System administration routes (admin only)
+		systemRoutes := api.Group("/system", auth.AuthMiddleware(), auth.RequireRole(db.RoleAdmin),
+			settings.rateLimits.LimitByMethod(), middleware.IdempotencyMiddleware(idempotencyStore))
+		{
+			systemRoutes.GET("/apply-order", getApplyOrderHandler(txMgr))
+			systemRoutes.GET("/events", eventStatsHandler)
+			systemRoutes.GET("/degraded", degradedHandler(txMgr))
+			systemRoutes.GET("/dhcp-clients", dhcpClientsHandler)
+			systemRoutes.GET("/quotas", quotasHandler)
+			systemRoutes.GET("/links", linksHandler)
+			systemRoutes.GET("/storage", storageHandler(snapshotMgr))
+			systemRoutes.GET("/upgrade", getUpgradeHandler)
+			systemRoutes.POST("/upgrade",
+				middleware.CSRFMiddleware(csrfMgr),
+				startUpgradeHandler)
+			systemRoutes.POST("/upgrade/rollback",
+				middleware.CSRFMiddleware(csrfMgr),
+				rollbackUpgradeHandler(txMgr))
+			systemRoutes.DELETE("/degraded",
+				middleware.CSRFMiddleware(csrfMgr),
+				clearDegradedHandler(txMgr))
+			systemRoutes.PUT("/apply-order",
+				middleware.CSRFMiddleware(csrfMgr),
+				setApplyOrderHandler(txMgr))
+		}
+	}
+
+	// Per-route request timeouts (relative to the API prefix)
+	routeTimeouts := make(map[string]time.Duration, len(hfConfig.API.RouteTimeouts))
+	for route, timeout := range hfConfig.API.RouteTimeouts {
+		routeTimeouts[route] = seconds(timeout)
+	}
+	requestTimeout := seconds(hfConfig.API.RequestTimeout)
+
+	// Versioned API
+	registerAPI(r.Group("/api/v1",
+		middleware.APIVersionMiddleware(middleware.CurrentAPIVersion),
+		middleware.TimeoutMiddleware("/api/v1", requestTimeout, routeTimeouts)))
+
+	// Unversioned paths are kept as a deprecated alias of v1 so existing
+	// automation keeps working; responses point at the /api/v1 successor
+	registerAPI(r.Group("/api",
+		middleware.APIVersionMiddleware(middleware.CurrentAPIVersion),
+		middleware.DeprecatedPathMiddleware("/api", "/api/v1"),
+		middleware.TimeoutMiddleware("/api", requestTimeout, routeTimeouts)))
+
+	// Web UI (embedded build, or api.web_root) with SPA fallback for all other routes
+	webUI, err := webUIHandler(hfConfig.API.WebRoot)
+	if err != nil {
+		return nil, err
+	}
+	r.NoRoute(webUI)
+
+	return r, nil
 }
 
 // healthHandler godoc
 // @Summary Health check
-// @Description Check if the API server is running
+// @Description Check if the API server is running; status is "degraded" while a failed rollback keeps the system in safe mode
 // @Tags system
 // @Produce json
 // @Success 200 {object} map[string]string
 // @Router /health [get]
-func healthHandler(c *gin.Context) {
-	c.JSON(http.StatusOK, gin.H{"status": "ok"})
+func healthHandler(txMgr *transaction.Manager) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		status := "ok"
+		if txMgr.Degraded() != nil {
+			status = "degraded"
+		}
+		c.JSON(http.StatusOK, gin.H{"status": status})
+	}
 }
 
 // getConfigHandler godoc
 // @Summary Get configuration
-// @Description Get entire configuration file
+// @Description Get entire configuration file. Secret options (keys, passwords) are masked for non-admins, and shown encrypted to admins. Configs and sections the caller may not read are left out (see the acl sections of the Hellfire config), and each section's ".permissions" lists what the caller may do with it ("read", "write").
 // @Tags config
 // @Produce json
 // @Param name path string true "Configuration name (e.g., network, firewall)"
 // @Success 200 {object} map[string]interface{}
+// @Failure 404 {object} map[string]string
 // @Failure 500 {object} map[string]string
+// @Param If-None-Match header string false "ETag from a previous response"
+// @Header 200 {string} ETag "Checksum of the (staged) config"
+// @Success 304 "Config unchanged since the given ETag"
+// @Security BearerAuth
 // @Router /config/{name} [get]
-func getConfigHandler(manager *config.Manager) gin.HandlerFunc {
+func getConfigHandler(settings *apiSettings, manager *config.Manager) gin.HandlerFunc {
 	return func(c *gin.Context) {
 		name := c.Param("name")
 
+		if !configAccess(c, settings, name, nil).Read {
+			apierrors.NotFound(c, fmt.Errorf("no read access to config %s", name))
+			return
+		}
+		if configNotModified(c, manager, name) {
+			return
+		}
+
 		cfg, err := manager.Load(name)
 		if err != nil {
 			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 			return
 		}
+		if !canSeeSecrets(c) {
+			cfg = config.MaskSecrets(name, cfg)
+		}
 
-		c.JSON(http.StatusOK, configToJSON(cfg))
+		c.JSON(http.StatusOK, configToJSON(cfg, func(section *uci.Section) auth.ConfigAccess {
+			return configAccess(c, settings, name, section)
+		}))
 	}
 }
 
+// configAccess returns what the caller may do with a section of a config, or
+// with the config as a whole when section is nil
+func configAccess(c *gin.Context, settings *apiSettings, name string, section *uci.Section) auth.ConfigAccess {
+	if section == nil {
+		return settings.configACLs.Load().Access(auth.GetUser(c), name, "", "")
+	}
+	return settings.configACLs.Load().Access(auth.GetUser(c), name, section.Name, section.Type)
+}
+
+// pathAccess returns what the caller may do with the section a
+// config.section[.option] path refers to
+func pathAccess(c *gin.Context, settings *apiSettings, manager *config.Manager, path string) auth.ConfigAccess {
+	name, _, _ := strings.Cut(path, ".")
+	sectionName, sectionType := manager.SectionOf(path)
+	return configAccess(c, settings, name, &uci.Section{Name: sectionName, Type: sectionType})
+}
+
+// canSeeSecrets reports whether the caller may see secret option values.
+// Admins get them as stored (encrypted once committed); they are only
+// decrypted for the appliers.
+func canSeeSecrets(c *gin.Context) bool {
+	return auth.IsAdmin(auth.GetUser(c))
+}
+
+// configNotModified sets the ETag for a config from its checksum and answers
+// 304 Not Modified if the client already has that version. The checksum covers
+// the whole config, so section and option responses change with any edit to it.
+func configNotModified(c *gin.Context, manager *config.Manager, name string) bool {
+	checksum, err := manager.Checksum(name)
+	if err != nil {
+		// Serve without caching; loading the config reports the error
+		return false
+	}
+
+	etag := configETag(c, checksum)
+	c.Header("ETag", etag)
+	c.Header("Cache-Control", "private, no-cache")
+
+	for _, candidate := range strings.Split(c.GetHeader("If-None-Match"), ",") {
+		candidate = strings.TrimSpace(candidate)
+		if candidate == "*" || strings.TrimPrefix(candidate, "W/") == strings.TrimPrefix(etag, "W/") {
+			c.Status(http.StatusNotModified)
+			return true
+		}
+	}
+
+	return false
+}
+
+// configETag returns the ETag of a config with the given checksum
+func configETag(c *gin.Context, checksum string) string {
+	// Responses differ by role (masked secrets, permissions)
+	if user := auth.GetUser(c); user != nil {
+		checksum += "-" + string(user.Role)
+	}
+
+	// Weak, since compressed and uncompressed bodies share the tag
+	return fmt.Sprintf(`W/"%s"`, checksum)
+}
+
+// configRevision returns the checksum a write is based on from the ETag of
+// a config read, or the bare checksum. Empty and "*" check nothing.
+func configRevision(etag string) string {
+	etag = strings.Trim(strings.TrimPrefix(strings.TrimSpace(etag), "W/"), `"`)
+	if etag == "*" {
+		return ""
+	}
+	checksum, _, _ := strings.Cut(etag, "-")
+	return checksum
+}
+
 // getSectionHandler godoc
 // @Summary Get configuration section
-// @Description Get a specific section from configuration
+// @Description Get a specific section from configuration (secret options masked for non-admins), with the caller's ".permissions" on it
 // @Tags config
 // @Produce json
 // @Param name path string true "Configuration name"
@@ -224,12 +756,24 @@ func getConfigHandler(manager *config.Manager) gin.HandlerFunc {
 // @Success 200 {object} map[string]interface{}
 // @Failure 404 {object} map[string]string
 // @Failure 500 {object} map[string]string
+// @Param If-None-Match header string false "ETag from a previous response"
+// @Header 200 {string} ETag "Checksum of the (staged) config"
+// @Success 304 "Config unchanged since the given ETag"
+// @Security BearerAuth
 // @Router /config/{name}/{section} [get]
-func getSectionHandler(manager *config.Manager) gin.HandlerFunc {
+func getSectionHandler(settings *apiSettings, manager *config.Manager) gin.HandlerFunc {
 	return func(c *gin.Context) {
 		name := c.Param("name")
 		section := c.Param("section")
 
+		if !configAccess(c, settings, name, nil).Read {
+			apierrors.NotFound(c, fmt.Errorf("no read access to config %s", name))
+			return
+		}
+		if configNotModified(c, manager, name) {
+			return
+		}
+
 		cfg, err := manager.Load(name)
 		if err != nil {
 			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
@@ -245,18 +789,26 @@ func getSectionHandler(manager *config.Manager) gin.HandlerFunc {
 			}
 		}
 
-		if sec == nil {
+		access := auth.ConfigAccess{}
+		if sec != nil {
+			access = configAccess(c, settings, name, sec)
+		}
+		if !access.Read {
 			c.JSON(http.StatusNotFound, gin.H{"error": "section not found"})
 			return
 		}
+		if !canSeeSecrets(c) {
+			sec = sec.Clone()
+			config.MaskSectionSecrets(name, sec)
+		}
 
-		c.JSON(http.StatusOK, sectionToJSON(sec))
+		c.JSON(http.StatusOK, sectionToJSON(sec, access))
 	}
 }
 
 // getOptionHandler godoc
 // @Summary Get configuration option
-// @Description Get a specific option value from a section
+// @Description Get a specific option value from a section (masked for non-admins if secret)
 // @Tags config
 // @Produce json
 // @Param name path string true "Configuration name"
@@ -264,19 +816,34 @@ func getSectionHandler(manager *config.Manager) gin.HandlerFunc {
 // @Param option path string true "Option key"
 // @Success 200 {object} map[string]string
 // @Failure 404 {object} map[string]string
+// @Param If-None-Match header string false "ETag from a previous response"
+// @Header 200 {string} ETag "Checksum of the (staged) config"
+// @Success 304 "Config unchanged since the given ETag"
+// @Security BearerAuth
 // @Router /config/{name}/{section}/{option} [get]
-func getOptionHandler(manager *config.Manager) gin.HandlerFunc {
+func getOptionHandler(settings *apiSettings, manager *config.Manager) gin.HandlerFunc {
 	return func(c *gin.Context) {
 		name := c.Param("name")
 		section := c.Param("section")
 		option := c.Param("option")
 
 		path := fmt.Sprintf("%s.%s.%s", name, section, option)
+		if !pathAccess(c, settings, manager, path).Read {
+			apierrors.NotFound(c, fmt.Errorf("no read access to %s", path))
+			return
+		}
+		if configNotModified(c, manager, name) {
+			return
+		}
+
 		value, err := manager.Get(path)
 		if err != nil {
 			c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
 			return
 		}
+		if !canSeeSecrets(c) && (secrets.IsSealed(value) || manager.IsSensitiveOption(path)) {
+			value = secrets.Mask
+		}
 
 		c.JSON(http.StatusOK, gin.H{"value": value})
 	}
@@ -289,7 +856,7 @@ type SetOptionRequest struct {
 
 // setOptionHandler godoc
 // @Summary Set configuration option
-// @Description Set a configuration option value (staged, requires commit)
+// @Description Set a configuration option value (staged, requires commit). If someone else locked the config, the value is staged anyway with a warning naming them. Options with a fixed set of values (e.g. an interface proto or a firewall target) reject anything else, and boolean options store true/on/yes as 1 and false/off/no as 0. With If-Match, the value is only set if the config is unchanged since it was read; the response carries its new ETag.
 // @Tags config
 // @Accept json
 // @Produce json
@@ -297,11 +864,15 @@ type SetOptionRequest struct {
 // @Param section path string true "Section name"
 // @Param option path string true "Option key"
 // @Param request body SetOptionRequest true "Option value"
+// @Param If-Match header string false "ETag of the config as read; the write fails with 409 if it changed since"
 // @Success 200 {object} map[string]string
 // @Failure 400 {object} map[string]string
+// @Failure 403 {object} map[string]string
+// @Failure 409 {object} map[string]string
 // @Failure 500 {object} map[string]string
+// @Security BearerAuth
 // @Router /config/{name}/{section}/{option} [put]
-func setOptionHandler(manager *config.Manager) gin.HandlerFunc {
+func setOptionHandler(settings *apiSettings, manager *config.Manager) gin.HandlerFunc {
 	return func(c *gin.Context) {
 		name := c.Param("name")
 		section := c.Param("section")
@@ -315,32 +886,34 @@ func setOptionHandler(manager *config.Manager) gin.HandlerFunc {
 		}
 
 		path := fmt.Sprintf("%s.%s.%s", name, section, option)
-		if err := manager.Set(path, req.Value); err != nil {
+		if !pathAccess(c, settings, manager, path).Write {
+			apierrors.Forbidden(c, fmt.Errorf("no write access to %s", path))
+			return
+		}
+		if err := manager.SetIf(path, req.Value, configRevision(c.GetHeader("If-Match"))); err != nil {
 			// Audit log failure
-			user := auth.GetUser(c)
-			username := "unknown"
-			var userID *uint
-			if user != nil {
-				username = user.Username
-				userID = &user.ID
-			}
-			audit.LogFailure(audit.ActionConfigWrite, userID, username, path,
-				fmt.Sprintf("Failed to set %s", path), err)
+			audit.LogUserAction(middleware.AuditContext(c), audit.ActionConfigWrite, audit.StatusFailure, path,
+				fmt.Sprintf("Failed to set %s", path), nil, err)
 
+			var valueErr *config.ValueError
+			if errors.As(err, &valueErr) {
+				c.JSON(http.StatusBadRequest, gin.H{"error": valueErr.Error()})
+				return
+			}
+			if errors.Is(err, config.ErrConflict) {
+				apierrors.Conflict(c, err)
+				return
+			}
 			apierrors.OperationFailed(c, err)
 			return
 		}
+		if checksum, err := manager.Checksum(name); err == nil {
+			c.Header("ETag", configETag(c, checksum))
+		}
 
 		// Audit log success
-		user := auth.GetUser(c)
-		username := "unknown"
-		var userID *uint
-		if user != nil {
-			username = user.Username
-			userID = &user.ID
-		}
-		audit.LogSuccess(audit.ActionConfigWrite, userID, username, path,
-			fmt.Sprintf("Set %s = %s (staged)", path, req.Value))
+		audit.LogUserAction(middleware.AuditContext(c), audit.ActionConfigWrite, audit.StatusSuccess, path,
+			fmt.Sprintf("Set %s = %s (staged)", path, secrets.RedactValue(path, req.Value)), nil, nil)
 
 		// Publish event
 		bus.Publish(bus.Event{
@@ -349,30 +922,181 @@ func setOptionHandler(manager *config.Manager) gin.HandlerFunc {
 			Data:       map[string]string{"path": path, "value": req.Value},
 		})
 
-		c.JSON(http.StatusOK, gin.H{
+		response := gin.H{
 			"message": "value staged, commit to apply",
 			"path":    path,
 			"value":   req.Value,
-		})
+		}
+		if warnings := lockWarnings(c, name); len(warnings) > 0 {
+			response["warnings"] = warnings
+		}
+		c.JSON(http.StatusOK, response)
+	}
+}
+
+// BatchRequest represents the request body for a batch of staged mutations
+type BatchRequest struct {
+	Operations []config.Operation `json:"operations" binding:"required"`
+	// ETags of configs as read, by name; nothing is staged if one changed since
+	Revisions map[string]string `json:"revisions,omitempty"`
+}
+
+// batchHandler godoc
+// @Summary Batch configuration changes
+// @Description Apply an ordered list of set/delete/add_list operations across configs to the staging area atomically (all or nothing, requires commit). Configs someone else locked are staged anyway with a warning naming them. Nothing is staged, with 409, if a config in revisions changed since it was read.
+// @Tags config
+// @Accept json
+// @Produce json
+// @Param request body BatchRequest true "Operations"
+// @Success 200 {object} map[string]interface{}
+// @Failure 400 {object} map[string]interface{}
+// @Failure 403 {object} map[string]interface{}
+// @Failure 409 {object} map[string]interface{}
+// @Security BearerAuth
+// @Router /config/batch [post]
+func batchHandler(settings *apiSettings, manager *config.Manager) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var req BatchRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			apierrors.BadRequest(c, err)
+			return
+		}
+
+		if len(req.Operations) == 0 || len(req.Operations) > config.MaxBatchOperations {
+			apierrors.BadRequest(c, fmt.Errorf("batch must contain between 1 and %d operations", config.MaxBatchOperations))
+			return
+		}
+
+		for i, op := range req.Operations {
+			name := strings.SplitN(op.Path, ".", 2)[0]
+			if !config.ValidName(name) {
+				apierrors.BadRequest(c, fmt.Errorf("operation %d: invalid config name %q", i, name))
+				return
+			}
+			access := pathAccess(c, settings, manager, op.Path)
+			if op.Op == config.OpAdd {
+				// The path is config.type
+				_, sectionType, _ := strings.Cut(strings.TrimPrefix(op.Path, name), ".")
+				access = configAccess(c, settings, name, &uci.Section{Type: sectionType})
+			}
+			if !access.Write {
+				apierrors.Forbidden(c, fmt.Errorf("operation %d: no write access to %s", i, op.Path))
+				return
+			}
+		}
+
+		revisions := make(map[string]string, len(req.Revisions))
+		for name, etag := range req.Revisions {
+			if !config.ValidName(name) {
+				apierrors.BadRequest(c, fmt.Errorf("invalid config name %q in revisions", name))
+				return
+			}
+			revisions[name] = configRevision(etag)
+		}
+
+		staged, err := manager.BatchIf(req.Operations, revisions)
+		if err != nil {
+			audit.LogUserAction(middleware.AuditContext(c), audit.ActionConfigWrite, audit.StatusFailure, "config",
+				"Failed to stage batch of configuration changes", nil, err)
+
+			if errors.Is(err, config.ErrConflict) {
+				apierrors.Conflict(c, err)
+				return
+			}
+
+			var opErr *config.OperationError
+			if errors.As(err, &opErr) {
+				logger.Warn("Batch operation rejected", "index", opErr.Index, "error", err)
+				response := apierrors.Body(c, apierrors.CodeValidation)
+				response["operation"] = opErr.Index
+				var valueErr *config.ValueError
+				if errors.As(err, &valueErr) {
+					response["message"] = valueErr.Error()
+				}
+				c.JSON(http.StatusBadRequest, response)
+				return
+			}
+
+			apierrors.OperationFailed(c, err)
+			return
+		}
+
+		audit.LogUserAction(middleware.AuditContext(c), audit.ActionConfigWrite, audit.StatusSuccess, "config",
+			fmt.Sprintf("Staged %d operations across %v", len(req.Operations), staged), nil, nil)
+
+		for _, name := range staged {
+			bus.Publish(bus.Event{
+				Type:       bus.EventConfigChanged,
+				ConfigName: name,
+				Data:       req.Operations,
+			})
+		}
+
+		response := gin.H{
+			"message":    "changes staged, commit to apply",
+			"configs":    staged,
+			"operations": len(req.Operations),
+		}
+		if warnings := lockWarnings(c, staged...); len(warnings) > 0 {
+			response["warnings"] = warnings
+		}
+		c.JSON(http.StatusOK, response)
 	}
 }
 
+// CommitRequest represents the optional request body for committing changes
+type CommitRequest struct {
+	Message        string `json:"message" example:"Change WAN address"`
+	ConfirmTimeout int    `json:"confirm_timeout" example:"60"` // Seconds (0 = no confirmation required)
+	DryRun         bool   `json:"dry_run" example:"false"`      // Only report what the commit would do
+
+	// Roll back unless the client keeps sending keepalives after the apply
+	SafeRemote bool `json:"safe_remote" example:"false"`
+	// Apply to this router only, without the canary (e.g. while it is down)
+	SkipCanary bool `json:"skip_canary" example:"false"`
+}
+
+// DryRunResponse is the response to a dry-run commit
+type DryRunResponse struct {
+	Message string `json:"message" example:"dry run, nothing was changed"`
+	Valid   bool   `json:"valid"`
+	*transaction.DryRun
+}
+
 // commitHandler godoc
 // @Summary Commit changes
-// @Description Commit staged configuration changes to the system
+// @Description Commit staged configuration changes through the transaction manager (snapshot, apply, validate, rollback on failure).
+// @Description With "Accept: text/event-stream" the response streams "progress" events followed by a final "result" or "error" event.
+// @Description With "dry_run" nothing is snapshotted, written or applied: the response (a DryRunResponse) has the diff of each changed config, the apply order, the rulesets and files the appliers would load, and why the commit would fail, if it would.
+// @Description With "safe_remote" (or the transaction's safe_remote option) the client must POST /config/keepalive within "keepalive" seconds of the apply, and again within every such window until it confirms, or the changes are rolled back. Without a confirm timeout the first keepalive confirms the commit.
+// @Description With a canary configured, the changes are committed on the canary first and must leave it healthy; it is confirmed once they are applied here, and rolled back if they fail. "skip_canary" applies them here only.
 // @Tags config
+// @Accept json
 // @Produce json
-// @Success 200 {object} map[string]interface{}
+// @Produce text/event-stream
+// @Param request body CommitRequest false "Commit options"
+// @Success 200 {object} map[string]interface{} "Commit result, or a DryRunResponse"
+// @Failure 400 {object} map[string]string
 // @Failure 500 {object} map[string]string
-// @Router /commit [post]
-func commitHandler(manager *config.Manager) gin.HandlerFunc {
+// @Security BearerAuth
+// @Router /config/commit [post]
+func commitHandler(manager *config.Manager, txMgr *transaction.Manager, txCfg hfconfig.TransactionConfig) gin.HandlerFunc {
 	return func(c *gin.Context) {
-		user := auth.GetUser(c)
-		username := "unknown"
-		var userID *uint
-		if user != nil {
-			username = user.Username
-			userID = &user.ID
+		var req CommitRequest
+		if c.Request.ContentLength > 0 {
+			if err := c.ShouldBindJSON(&req); err != nil {
+				apierrors.BadRequest(c, err)
+				return
+			}
+		}
+
+		if req.ConfirmTimeout < 0 {
+			apierrors.BadRequest(c, fmt.Errorf("confirm_timeout must not be negative"))
+			return
+		}
+
+		if req.Message == "" {
+			req.Message = "Configuration change via API"
 		}
 
 		if !manager.HasChanges() {
@@ -382,29 +1106,257 @@ func commitHandler(manager *config.Manager) gin.HandlerFunc {
 
 		changes := manager.GetChanges()
 
-		if err := manager.Commit(); err != nil {
-			// Audit log failure
-			audit.LogFailure(audit.ActionConfigCommit, userID, username, "config",
-				"Failed to commit configuration changes", err)
+		if req.DryRun {
+			result, err := txMgr.DryRun(middleware.AuditContext(c))
+			if err != nil {
+				apierrors.OperationFailed(c, err)
+				return
+			}
+			audit.LogUserAction(middleware.AuditContext(c), audit.ActionConfigRead, audit.StatusSuccess, "config",
+				fmt.Sprintf("Dry run of configuration changes: %v", changes), nil, nil)
+			c.JSON(http.StatusOK, DryRunResponse{
+				Message: "dry run, nothing was changed",
+				Valid:   result.Valid(),
+				DryRun:  result,
+			})
+			return
+		}
 
-			apierrors.OperationFailed(c, err)
+		confirmTimeout := time.Duration(req.ConfirmTimeout) * time.Second
+		safeRemote := req.SafeRemote || txCfg.SafeRemote
+		commit := func(ctx context.Context) (gin.H, error) {
+			if safeRemote {
+				ctx = transaction.WithKeepalive(ctx, time.Duration(txCfg.KeepaliveTimeout)*time.Second)
+			}
+			if req.SkipCanary {
+				ctx = transaction.WithoutCanary(ctx)
+			}
+			if err := txMgr.Commit(ctx, req.Message, confirmTimeout, 0); err != nil {
+				// Audit log failure
+				audit.LogUserAction(ctx, audit.ActionConfigCommit, audit.StatusFailure, "config",
+					"Failed to commit configuration changes", nil, err)
+				return nil, err
+			}
+
+			// Audit log success
+			audit.LogUserAction(ctx, audit.ActionConfigCommit, audit.StatusSuccess, "config",
+				fmt.Sprintf("Committed configuration changes: %v", changes), nil, nil)
+
+			// Publish event; synchronous handlers (e.g. dnsmasq) run before we respond
+			handlerErr := bus.PublishSync(bus.Event{
+				Type: bus.EventConfigCommitted,
+				Data: changes,
+			})
+
+			response := gin.H{
+				"message":        "changes committed",
+				"configs":        changes,
+				"state":          txMgr.GetState(),
+				"transaction_id": txMgr.TxID(),
+			}
+			if generation, err := db.GetConfigGeneration(); err == nil {
+				response["generation"] = generation
+			}
+
+			if handlerErr != nil {
+				response["handler_errors"] = handlerErr.Error()
+			}
+
+			if req.ConfirmTimeout > 0 {
+				response["message"] = "changes applied, confirmation required"
+				response["confirm_timeout"] = req.ConfirmTimeout
+			}
+			if safeRemote {
+				if req.ConfirmTimeout == 0 {
+					response["message"] = "changes applied, keepalive required"
+				}
+				response["keepalive"] = txCfg.KeepaliveTimeout
+			}
+
+			return response, nil
+		}
+
+		// Clients asking for an event stream get progress while the commit runs
+		if strings.Contains(c.GetHeader("Accept"), "text/event-stream") {
+			streamCommit(c, commit)
 			return
 		}
 
-		// Audit log success
-		audit.LogSuccess(audit.ActionConfigCommit, userID, username, "config",
-			fmt.Sprintf("Committed configuration changes: %v", changes))
+		response, err := commit(middleware.AuditContext(c))
+		if err != nil {
+			commitFailed(c, err)
+			return
+		}
 
-		// Publish event
-		bus.Publish(bus.Event{
-			Type: bus.EventConfigCommitted,
-			Data: changes,
-		})
+		c.JSON(http.StatusOK, response)
+	}
+}
 
-		c.JSON(http.StatusOK, gin.H{
-			"message": "changes committed",
-			"configs": changes,
-		})
+// commitFailed responds to a failed commit. Broken references between
+// configs are the client's to fix and are listed; other failures get the
+// generic error.
+func commitFailed(c *gin.Context, err error) {
+	var refErr *integrity.Error
+	if errors.As(err, &refErr) {
+		response := apierrors.Body(c, apierrors.CodeValidation)
+		response["issues"] = refErr.Issues
+		c.JSON(http.StatusBadRequest, response)
+		return
+	}
+	apierrors.OperationFailed(c, err)
+}
+
+// streamCommit runs commit and streams its progress as server-sent events:
+// "progress" for each transaction.ProgressEvent, then a final "result" or "error"
+func streamCommit(c *gin.Context, commit func(context.Context) (gin.H, error)) {
+	events := make(chan transaction.ProgressEvent, 16)
+	done := c.Request.Context().Done()
+
+	ctx := transaction.WithProgress(middleware.AuditContext(c), func(event transaction.ProgressEvent) {
+		// Error details stay in the server log, like other API errors
+		event.Error = ""
+		select {
+		case events <- event:
+		case <-done:
+			// Client went away, keep committing without reporting
+		}
+	})
+
+	var response gin.H
+	var commitErr error
+	go func() {
+		defer close(events)
+		response, commitErr = commit(ctx)
+	}()
+
+	c.Header("Cache-Control", "no-cache")
+	c.Header("X-Accel-Buffering", "no")
+	c.Stream(func(w io.Writer) bool {
+		if event, ok := <-events; ok {
+			c.SSEvent("progress", event)
+			return true
+		}
+
+		if commitErr != nil {
+			logger.Error("Request error",
+				"path", c.Request.URL.Path,
+				"method", c.Request.Method,
+				"error", commitErr.Error(),
+				"client_ip", c.ClientIP())
+			var refErr *integrity.Error
+			if errors.As(commitErr, &refErr) {
+				response := apierrors.Body(c, apierrors.CodeValidation)
+				response["issues"] = refErr.Issues
+				c.SSEvent("error", response)
+			} else {
+				c.SSEvent("error", apierrors.Body(c, apierrors.CodeOperationFailed))
+			}
+		} else {
+			c.SSEvent("result", response)
+		}
+		return false
+	})
+}
+
+// confirmHandler godoc
+// @Summary Confirm pending changes
+// @Description Confirm a pending transaction to prevent automatic rollback
+// @Tags config
+// @Produce json
+// @Success 200 {object} map[string]string
+// @Failure 409 {object} map[string]string
+// @Security BearerAuth
+// @Router /config/confirm [post]
+func confirmHandler(txMgr *transaction.Manager) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if err := txMgr.Confirm(middleware.AuditContext(c)); err != nil {
+			audit.LogUserAction(middleware.AuditContext(c), audit.ActionTxConfirm, audit.StatusFailure, "config",
+				"Failed to confirm transaction", nil, err)
+
+			c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{"message": "changes confirmed"})
+	}
+}
+
+// keepaliveHandler godoc
+// @Summary Keep a safe remote commit
+// @Description Tell a pending safe remote commit that its client still reaches the router. Only the committing user can send keepalives. Without a confirm timeout the keepalive confirms the commit; otherwise the keepalive window starts over.
+// @Tags config
+// @Produce json
+// @Success 200 {object} map[string]interface{}
+// @Failure 403 {object} map[string]string
+// @Failure 409 {object} map[string]string
+// @Security BearerAuth
+// @Router /config/keepalive [post]
+func keepaliveHandler(txMgr *transaction.Manager) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		confirmed, err := txMgr.Keepalive(middleware.AuditContext(c))
+		if errors.Is(err, transaction.ErrNotCommitter) {
+			apierrors.Forbidden(c, err)
+			return
+		}
+		if err != nil {
+			c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+			return
+		}
+
+		if confirmed {
+			c.JSON(http.StatusOK, gin.H{"message": "changes confirmed", "confirmed": true})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"message": "keepalive received", "confirmed": false})
+	}
+}
+
+// RollbackRequest is the optional request body of a manual rollback
+type RollbackRequest struct {
+	Reason string `json:"reason" example:"Guests lost DNS"` // Recorded on the transaction; required with the transaction's require_rollback_reason option
+}
+
+// rollbackContext returns the audit context of a rollback request with the
+// reason from its body, or false after responding to an invalid body
+func rollbackContext(c *gin.Context) (context.Context, bool) {
+	var req RollbackRequest
+	if c.Request.ContentLength > 0 {
+		if err := c.ShouldBindJSON(&req); err != nil {
+			apierrors.BadRequest(c, err)
+			return nil, false
+		}
+	}
+	return transaction.WithRollbackReason(middleware.AuditContext(c), req.Reason), true
+}
+
+// rollbackPendingHandler godoc
+// @Summary Roll back pending changes
+// @Description Roll back a transaction awaiting confirmation now, rather than when its confirmation times out. The reason is recorded on the transaction.
+// @Tags config
+// @Accept json
+// @Produce json
+// @Param request body RollbackRequest false "Rollback reason"
+// @Success 200 {object} map[string]string
+// @Failure 400 {object} map[string]string "A reason is required"
+// @Failure 409 {object} map[string]string
+// @Security BearerAuth
+// @Router /config/rollback [post]
+func rollbackPendingHandler(txMgr *transaction.Manager) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		ctx, ok := rollbackContext(c)
+		if !ok {
+			return
+		}
+		if err := txMgr.RollbackPending(ctx); err != nil {
+			if errors.Is(err, transaction.ErrRollbackReasonRequired) {
+				apierrors.BadRequest(c, err)
+				return
+			}
+			c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{"message": "changes rolled back"})
 	}
 }
 
@@ -415,17 +1367,10 @@ func commitHandler(manager *config.Manager) gin.HandlerFunc {
 // @Produce json
 // @Success 200 {object} map[string]interface{}
 // @Failure 500 {object} map[string]string
-// @Router /revert [post]
+// @Security BearerAuth
+// @Router /config/revert [post]
 func revertHandler(manager *config.Manager) gin.HandlerFunc {
 	return func(c *gin.Context) {
-		user := auth.GetUser(c)
-		username := "unknown"
-		var userID *uint
-		if user != nil {
-			username = user.Username
-			userID = &user.ID
-		}
-
 		if !manager.HasChanges() {
 			c.JSON(http.StatusOK, gin.H{"message": "no changes to revert"})
 			return
@@ -435,16 +1380,16 @@ func revertHandler(manager *config.Manager) gin.HandlerFunc {
 
 		if err := manager.Revert(); err != nil {
 			// Audit log failure
-			audit.LogFailure(audit.ActionConfigRevert, userID, username, "config",
-				"Failed to revert configuration changes", err)
+			audit.LogUserAction(middleware.AuditContext(c), audit.ActionConfigRevert, audit.StatusFailure, "config",
+				"Failed to revert configuration changes", nil, err)
 
 			apierrors.OperationFailed(c, err)
 			return
 		}
 
 		// Audit log success
-		audit.LogSuccess(audit.ActionConfigRevert, userID, username, "config",
-			fmt.Sprintf("Reverted configuration changes: %v", changes))
+		audit.LogUserAction(middleware.AuditContext(c), audit.ActionConfigRevert, audit.StatusSuccess, "config",
+			fmt.Sprintf("Reverted configuration changes: %v", changes), nil, nil)
 
 		// Publish event
 		bus.Publish(bus.Event{
@@ -461,23 +1406,16 @@ func revertHandler(manager *config.Manager) gin.HandlerFunc {
 
 // validateHandler godoc
 // @Summary Validate staged changes
-// @Description Validate staged configuration changes without applying them (dry-run)
+// @Description Validate staged configuration changes without applying them (dry-run): each config must load and pass its applier's checks, e.g. that configured network interfaces exist
 // @Tags config
 // @Produce json
 // @Success 200 {object} map[string]interface{}
 // @Failure 400 {object} map[string]string
 // @Failure 500 {object} map[string]string
-// @Router /validate [post]
-func validateHandler(manager *config.Manager) gin.HandlerFunc {
+// @Security BearerAuth
+// @Router /config/validate [post]
+func validateHandler(manager *config.Manager, txMgr *transaction.Manager) gin.HandlerFunc {
 	return func(c *gin.Context) {
-		user := auth.GetUser(c)
-		username := "unknown"
-		var userID *uint
-		if user != nil {
-			username = user.Username
-			userID = &user.ID
-		}
-
 		if !manager.HasChanges() {
 			c.JSON(http.StatusOK, gin.H{
 				"valid":   true,
@@ -494,22 +1432,39 @@ func validateHandler(manager *config.Manager) gin.HandlerFunc {
 		allValid := true
 
 		for _, configName := range changes {
-			cfg, err := manager.Load(configName)
+			cfg, err := manager.LoadDecrypted(configName)
 			if err != nil {
 				validationErrors[configName] = append(validationErrors[configName], err.Error())
 				allValid = false
 				continue
 			}
 
-			// Additional validation can be added here
-			// For now, if it loads without error, it's considered valid
-			_ = cfg
+			// Check it against the system, as a commit would
+			if err := txMgr.Check(c.Request.Context(), configName, cfg); err != nil {
+				validationErrors[configName] = append(validationErrors[configName], err.Error())
+				allValid = false
+			}
+		}
+
+		// References between configs, as a commit checks them
+		var issues []integrity.Issue
+		if slices.ContainsFunc(changes, func(name string) bool { return slices.Contains(integrity.Configs, name) }) {
+			var err error
+			issues, err = integrity.Check(manager.LoadExpanded)
+			if err != nil {
+				apierrors.OperationFailed(c, err)
+				return
+			}
+			for _, issue := range issues {
+				validationErrors[issue.Config] = append(validationErrors[issue.Config], issue.String())
+				allValid = false
+			}
 		}
 
 		// Audit log validation attempt
 		if allValid {
-			audit.LogSuccess(audit.ActionConfigRead, userID, username, "config",
-				fmt.Sprintf("Validated configuration changes: %v", changes))
+			audit.LogUserAction(middleware.AuditContext(c), audit.ActionConfigRead, audit.StatusSuccess, "config",
+				fmt.Sprintf("Validated configuration changes: %v", changes), nil, nil)
 
 			c.JSON(http.StatusOK, gin.H{
 				"valid":   true,
@@ -517,12 +1472,13 @@ func validateHandler(manager *config.Manager) gin.HandlerFunc {
 				"configs": changes,
 			})
 		} else {
-			audit.LogFailure(audit.ActionConfigRead, userID, username, "config",
-				"Configuration validation failed", fmt.Errorf("validation errors: %v", validationErrors))
+			audit.LogUserAction(middleware.AuditContext(c), audit.ActionConfigRead, audit.StatusFailure, "config",
+				"Configuration validation failed", nil, fmt.Errorf("validation errors: %v", validationErrors))
 
 			c.JSON(http.StatusBadRequest, gin.H{
 				"valid":  false,
 				"errors": validationErrors,
+				"issues": issues,
 			})
 		}
 	}
@@ -534,10 +1490,16 @@ func validateHandler(manager *config.Manager) gin.HandlerFunc {
 // @Tags config
 // @Produce json
 // @Success 200 {object} map[string]interface{}
-// @Router /changes [get]
-func changesHandler(manager *config.Manager) gin.HandlerFunc {
+// @Security BearerAuth
+// @Router /config/changes [get]
+func changesHandler(settings *apiSettings, manager *config.Manager) gin.HandlerFunc {
 	return func(c *gin.Context) {
-		changes := manager.GetChanges()
+		changes := []string{}
+		for _, name := range manager.GetChanges() {
+			if configAccess(c, settings, name, nil).Read {
+				changes = append(changes, name)
+			}
+		}
 
 		c.JSON(http.StatusOK, gin.H{
 			"has_changes": manager.HasChanges(),
@@ -547,7 +1509,7 @@ func changesHandler(manager *config.Manager) gin.HandlerFunc {
 }
 
 // configToJSON converts UCI config to JSON-friendly map
-func configToJSON(cfg *uci.Config) map[string]interface{} {
+func configToJSON(cfg *uci.Config, access func(*uci.Section) auth.ConfigAccess) map[string]interface{} {
 	result := make(map[string]interface{})
 	typeCounts := make(map[string]int)
 
@@ -564,14 +1526,16 @@ func configToJSON(cfg *uci.Config) map[string]interface{} {
 			typeCounts[section.Type]++
 		}
 
-		result[key] = sectionToJSON(section)
+		if sectionAccess := access(section); sectionAccess.Read {
+			result[key] = sectionToJSON(section, sectionAccess)
+		}
 	}
 
 	return result
 }
 
 // sectionToJSON converts UCI section to JSON-friendly map
-func sectionToJSON(section *uci.Section) map[string]interface{} {
+func sectionToJSON(section *uci.Section, access auth.ConfigAccess) map[string]interface{} {
 	result := make(map[string]interface{})
 
 	// Add section type (important for parsing)
@@ -580,6 +1544,9 @@ func sectionToJSON(section *uci.Section) map[string]interface{} {
 		result[".name"] = section.Name
 	}
 
+	// What the caller may do with the section, for UI form gating
+	result[".permissions"] = access.Permissions()
+
 	// Add options
 	for k, v := range section.Options {
 		result[k] = v
@@ -628,37 +1595,42 @@ type loginResponse struct {
 // @Failure 400 {object} map[string]string
 // @Failure 401 {object} map[string]string
 // @Router /auth/login [post]
-func loginHandler(c *gin.Context) {
-	var req loginRequest
-	if err := c.ShouldBindJSON(&req); err != nil {
-		apierrors.BadRequest(c, err)
-		return
-	}
-
-	// Get client IP
-	ipAddress := c.ClientIP()
-	userAgent := c.Request.UserAgent()
+func loginHandler(settings *apiSettings) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var req loginRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			apierrors.BadRequest(c, err)
+			return
+		}
 
-	// Attempt login
-	session, err := auth.Login(req.Username, req.Password, ipAddress, userAgent)
-	if err != nil {
-		// Audit log failed login attempt
-		audit.LogFailure(audit.ActionUserLogin, nil, req.Username, "auth",
-			fmt.Sprintf("Failed login attempt from %s", ipAddress), err)
+		// Get client IP
+		ipAddress := c.ClientIP()
+		userAgent := c.Request.UserAgent()
 
-		apierrors.Unauthorized(c, err)
-		return
-	}
+		// Attempt login
+		session, err := auth.Login(req.Username, req.Password, ipAddress, userAgent)
+		if err != nil {
+			// Audit log failed login attempt
+			audit.LogWithContext(middleware.AuditContext(c), audit.ActionUserLogin, audit.StatusFailure, nil, req.Username, "auth",
+				fmt.Sprintf("Failed login attempt from %s", ipAddress), nil, err)
 
-	// Audit log successful login
-	audit.LogSuccess(audit.ActionUserLogin, &session.UserID, req.Username, "auth",
-		fmt.Sprintf("User logged in from %s", ipAddress))
+			apierrors.Unauthorized(c, err)
+			return
+		}
 
-	c.JSON(http.StatusOK, loginResponse{
-		Token:     session.Token,
-		User:      &session.User,
-		ExpiresAt: session.ExpiresAt,
-	})
+		// Audit log successful login, with the session it opened
+		ctx := audit.WithSession(middleware.AuditContext(c), session.ID)
+		audit.LogWithContext(ctx, audit.ActionUserLogin, audit.StatusSuccess, &session.UserID, req.Username, "auth",
+			fmt.Sprintf("User logged in from %s", ipAddress), nil, nil)
+		recordLoginDevice(ctx, settings, session)
+		removeLegacyPasswordFile()
+
+		c.JSON(http.StatusOK, loginResponse{
+			Token:     session.Token,
+			User:      &session.User,
+			ExpiresAt: session.ExpiresAt,
+		})
+	}
 }
 
 // logoutHandler godoc
@@ -677,19 +1649,11 @@ func logoutHandler(c *gin.Context) {
 		return
 	}
 
-	user := auth.GetUser(c)
-	username := "unknown"
-	var userID *uint
-	if user != nil {
-		username = user.Username
-		userID = &user.ID
-	}
-
 	// Delete session
 	if err := auth.DeleteSession(session.Token); err != nil {
 		// Audit log failure
-		audit.LogFailure(audit.ActionUserLogout, userID, username, "auth",
-			"Failed to logout", err)
+		audit.LogUserAction(middleware.AuditContext(c), audit.ActionUserLogout, audit.StatusFailure, "auth",
+			"Failed to logout", nil, err)
 
 		apierrors.OperationFailed(c, err)
 		return
@@ -697,18 +1661,18 @@ func logoutHandler(c *gin.Context) {
 
 	// Audit log successful logout
 	ipAddress := c.ClientIP()
-	audit.LogSuccess(audit.ActionUserLogout, userID, username, "auth",
-		fmt.Sprintf("User logged out from %s", ipAddress))
+	audit.LogUserAction(middleware.AuditContext(c), audit.ActionUserLogout, audit.StatusSuccess, "auth",
+		fmt.Sprintf("User logged out from %s", ipAddress), nil, nil)
 
 	c.JSON(http.StatusOK, gin.H{"message": "logged out successfully"})
 }
 
 // meHandler godoc
 // @Summary Get current user
-// @Description Get information about the current authenticated user
+// @Description Get the current authenticated user, the permissions of their role and, for session logins, when the session expires and which admin is impersonating the user, if any
 // @Tags auth
 // @Produce json
-// @Success 200 {object} db.User
+// @Success 200 {object} map[string]interface{}
 // @Failure 401 {object} map[string]string
 // @Router /auth/me [get]
 // @Security BearerAuth
@@ -722,10 +1686,19 @@ func meHandler(c *gin.Context) {
 	// Include permissions
 	permissions := auth.GetUserPermissions(user)
 
-	c.JSON(http.StatusOK, gin.H{
+	response := gin.H{
 		"user":        user,
 		"permissions": permissions,
-	})
+	}
+	if session := auth.GetSession(c); session != nil {
+		response["session"] = SessionInfo{
+			ExpiresAt:      session.ExpiresAt,
+			AbsoluteExpiry: session.AbsoluteExpiry,
+			ImpersonatedBy: session.Impersonator,
+		}
+	}
+
+	c.JSON(http.StatusOK, response)
 }
 
 // bootstrapHandler godoc
@@ -750,6 +1723,7 @@ func bootstrapHandler(c *gin.Context) {
 }
 
 type onboardingRequest struct {
+	Token    string `json:"token" binding:"required"` // One-time token printed by hf serve
 	Name     string `json:"name" binding:"required"`
 	Email    string `json:"email" binding:"required,email"`
 	Password string `json:"password" binding:"required,min=8"`
@@ -757,13 +1731,14 @@ type onboardingRequest struct {
 
 // onboardingHandler godoc
 // @Summary Create initial admin user
-// @Description Create the first admin user during system onboarding
+// @Description Create the first admin user during system onboarding, authorized by the one-time token hf serve prints at first start
 // @Tags system
 // @Accept json
 // @Produce json
 // @Param request body onboardingRequest true "Admin user details"
 // @Success 200 {object} loginResponse
 // @Failure 400 {object} map[string]string
+// @Failure 401 {object} map[string]string
 // @Failure 409 {object} map[string]string
 // @Router /onboarding [post]
 func onboardingHandler(c *gin.Context) {
@@ -785,6 +1760,12 @@ func onboardingHandler(c *gin.Context) {
 		return
 	}
 
+	// Enforce the password policy (the message says what's missing, not secret)
+	if err := auth.ValidatePasswordStrength(req.Password); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
 	// Hash password
 	hashedPassword, err := auth.HashPassword(req.Password)
 	if err != nil {
@@ -792,6 +1773,14 @@ func onboardingHandler(c *gin.Context) {
 		return
 	}
 
+	// The token is consumed even if creating the user fails below: it's one use
+	if err := auth.ConsumeOnboardingToken(req.Token); err != nil {
+		audit.LogWithContext(middleware.AuditContext(c), audit.ActionUserCreate, audit.StatusFailure, nil, req.Email, "onboarding",
+			fmt.Sprintf("Onboarding attempt with an invalid token from %s", c.ClientIP()), nil, err)
+		apierrors.Unauthorized(c, err)
+		return
+	}
+
 	// Create admin user
 	user := &db.User{
 		Username:     req.Email,
@@ -818,9 +1807,15 @@ func onboardingHandler(c *gin.Context) {
 	// Load user into session
 	session.User = *user
 
+	// The onboarding browser is the admin's first known device
+	if _, _, err := auth.RememberDevice(session); err != nil {
+		logger.Warn("Failed to record login device", "user_id", user.ID, "error", err)
+	}
+
 	// Audit log
-	audit.LogSuccess(audit.ActionUserCreate, &user.ID, user.Username, "onboarding",
-		fmt.Sprintf("Initial admin user created from %s", ipAddress))
+	audit.LogWithContext(middleware.AuditContext(c), audit.ActionUserCreate, audit.StatusSuccess, &user.ID, user.Username, "onboarding",
+		fmt.Sprintf("Initial admin user created from %s", ipAddress), nil, nil)
+	removeLegacyPasswordFile()
 
 	c.JSON(http.StatusOK, loginResponse{
 		Token:     session.Token,
@@ -828,34 +1823,3 @@ func onboardingHandler(c *gin.Context) {
 		ExpiresAt: session.ExpiresAt,
 	})
 }
-
-// corsMiddleware creates a CORS middleware with specified allowed origins
-func corsMiddleware(allowedOrigins []string) gin.HandlerFunc {
-	return func(c *gin.Context) {
-		origin := c.Request.Header.Get("Origin")
-
-		// Check if origin is allowed
-		allowed := false
-		for _, allowedOrigin := range allowedOrigins {
-			if origin == allowedOrigin {
-				allowed = true
-				break
-			}
-		}
-
-		if allowed {
-			c.Writer.Header().Set("Access-Control-Allow-Origin", origin)
-			c.Writer.Header().Set("Access-Control-Allow-Credentials", "true")
-		}
-
-		c.Writer.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization, X-API-Key, X-CSRF-Token")
-		c.Writer.Header().Set("Access-Control-Allow-Methods", "GET, POST, PUT, DELETE, OPTIONS")
-
-		if c.Request.Method == "OPTIONS" {
-			c.AbortWithStatus(204)
-			return
-		}
-
-		c.Next()
-	}
-}
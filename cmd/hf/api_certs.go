@@ -0,0 +1,159 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/thesabbir/hellfire/pkg/audit"
+	"github.com/thesabbir/hellfire/pkg/certs"
+	"github.com/thesabbir/hellfire/pkg/db"
+	apierrors "github.com/thesabbir/hellfire/pkg/errors"
+	"github.com/thesabbir/hellfire/pkg/hfconfig"
+	"github.com/thesabbir/hellfire/pkg/middleware"
+)
+
+// UploadCertificateRequest adds a certificate to the store
+type UploadCertificateRequest struct {
+	Name        string `json:"name" binding:"required"`
+	Certificate string `json:"certificate" binding:"required"` // PEM, leaf first
+	PrivateKey  string `json:"private_key" binding:"required"` // PEM
+}
+
+// RotateCertificateRequest replaces a certificate
+type RotateCertificateRequest struct {
+	Certificate string `json:"certificate" binding:"required"` // PEM, leaf first
+	PrivateKey  string `json:"private_key"`                    // PEM; omitted for CA bundles
+}
+
+// newCertInventory returns the inventory of the certificates in hfConfig and the store
+func newCertInventory(hfConfig *hfconfig.Config) *certs.Inventory {
+	return certs.NewInventory(hfConfig.CertificateReferences, hfConfig.Certs.StoreDir,
+		time.Duration(hfConfig.Certs.WarnDays)*24*time.Hour)
+}
+
+// listCertsHandler godoc
+// @Summary List certificates
+// @Description List the certificates hellfire uses or stores with their subjects and validity, soonest expiry first
+// @Tags certs
+// @Produce json
+// @Success 200 {object} map[string]interface{}
+// @Failure 403 {object} map[string]string
+// @Failure 500 {object} map[string]string
+// @Router /certs [get]
+// @Security BearerAuth
+func listCertsHandler(inventory *certs.Inventory) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if _, err := inventory.Sync(); err != nil {
+			apierrors.OperationFailed(c, err)
+			return
+		}
+
+		list, err := db.ListCertificates()
+		if err != nil {
+			apierrors.OperationFailed(c, err)
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{"certificates": list})
+	}
+}
+
+// uploadCertHandler godoc
+// @Summary Upload a certificate
+// @Description Add a certificate and its private key to the certificate store. The key must match the certificate.
+// @Tags certs
+// @Accept json
+// @Produce json
+// @Param request body UploadCertificateRequest true "Certificate and key"
+// @Success 201 {object} db.Certificate
+// @Failure 400 {object} map[string]string
+// @Failure 403 {object} map[string]string
+// @Failure 409 {object} map[string]string
+// @Failure 500 {object} map[string]string
+// @Router /certs [post]
+// @Security BearerAuth
+func uploadCertHandler(inventory *certs.Inventory) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var req UploadCertificateRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			apierrors.BadRequest(c, err)
+			return
+		}
+
+		cert, err := inventory.Upload(req.Name, []byte(req.Certificate), []byte(req.PrivateKey))
+		auditCertChange(c, audit.ActionCertUpload, req.Name, "upload", err)
+		if err != nil {
+			certError(c, err)
+			return
+		}
+
+		c.JSON(http.StatusCreated, cert)
+	}
+}
+
+// rotateCertHandler godoc
+// @Summary Rotate a certificate
+// @Description Replace a certificate (and its private key, unless it is a CA bundle). The previous files are kept with a .bak suffix. The gRPC server picks up a rotated server certificate on its next handshake; a rotated client CA applies after a restart.
+// @Tags certs
+// @Accept json
+// @Produce json
+// @Param name path string true "Certificate name"
+// @Param request body RotateCertificateRequest true "New certificate and key"
+// @Success 200 {object} db.Certificate
+// @Failure 400 {object} map[string]string
+// @Failure 403 {object} map[string]string
+// @Failure 404 {object} map[string]string
+// @Failure 500 {object} map[string]string
+// @Router /certs/{name} [put]
+// @Security BearerAuth
+func rotateCertHandler(inventory *certs.Inventory) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		name := c.Param("name")
+
+		var req RotateCertificateRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			apierrors.BadRequest(c, err)
+			return
+		}
+
+		cert, err := inventory.Rotate(name, []byte(req.Certificate), []byte(req.PrivateKey))
+		auditCertChange(c, audit.ActionCertRotate, name, "rotate", err)
+		if err != nil {
+			certError(c, err)
+			return
+		}
+
+		c.JSON(http.StatusOK, cert)
+	}
+}
+
+// auditCertChange records an upload or rotation
+func auditCertChange(c *gin.Context, action audit.Action, name, verb string, err error) {
+	resource := "cert:" + name
+	if err != nil {
+		audit.LogUserAction(middleware.AuditContext(c), action, audit.StatusFailure, resource,
+			fmt.Sprintf("Failed to %s certificate %s", verb, name), nil, err)
+		return
+	}
+	audit.LogUserAction(middleware.AuditContext(c), action, audit.StatusSuccess, resource,
+		fmt.Sprintf("Certificate %s: %s succeeded", name, verb), nil, nil)
+}
+
+// certError responds with the status for an inventory error; validation
+// errors are returned to the client so it can fix the upload
+func certError(c *gin.Context, err error) {
+	switch {
+	case errors.Is(err, certs.ErrInvalid):
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	case errors.Is(err, certs.ErrNotFound):
+		apierrors.NotFound(c, err)
+	case errors.Is(err, certs.ErrExists):
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+	default:
+		apierrors.OperationFailed(c, err)
+	}
+}
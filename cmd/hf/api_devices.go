@@ -0,0 +1,150 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+
+	"github.com/thesabbir/hellfire/pkg/audit"
+	"github.com/thesabbir/hellfire/pkg/auth"
+	"github.com/thesabbir/hellfire/pkg/bus"
+	"github.com/thesabbir/hellfire/pkg/db"
+	apierrors "github.com/thesabbir/hellfire/pkg/errors"
+	"github.com/thesabbir/hellfire/pkg/logger"
+	"github.com/thesabbir/hellfire/pkg/middleware"
+)
+
+// NewDeviceLogin is the data of an auth.new_device event
+type NewDeviceLogin struct {
+	UserID    uint      `json:"user_id"`
+	Username  string    `json:"username"`
+	IPAddress string    `json:"ip_address"`
+	UserAgent string    `json:"user_agent"`
+	Time      time.Time `json:"time"`
+}
+
+// KnownDeviceResponse is a device the user has logged in from
+type KnownDeviceResponse struct {
+	db.KnownDevice
+	Current bool `json:"current"` // The device of the requesting session
+}
+
+// recordLoginDevice remembers the device a login session was created from.
+// A device the user never logged in from is audited as user.new_device and,
+// unless it's the user's first device or the alert is disabled, published
+// as an auth.new_device event for notification handlers.
+func recordLoginDevice(auditCtx context.Context, settings *apiSettings, session *db.Session) {
+	newDevice, firstDevice, err := auth.RememberDevice(session)
+	if err != nil {
+		logger.Warn("Failed to record login device", "user_id", session.UserID, "error", err)
+		return
+	}
+	if !newDevice {
+		return
+	}
+
+	username := session.User.Username
+	audit.LogWithContext(auditCtx, audit.ActionUserNewDevice, audit.StatusSuccess, &session.UserID, username, "auth",
+		fmt.Sprintf("Login from a new device: %s (%s)", session.IPAddress, session.UserAgent), nil, nil)
+
+	if firstDevice || !settings.newDeviceAlert.Load() {
+		return
+	}
+	bus.Publish(bus.Event{
+		Type: bus.EventLoginNewDevice,
+		Data: NewDeviceLogin{
+			UserID:    session.UserID,
+			Username:  username,
+			IPAddress: session.IPAddress,
+			UserAgent: session.UserAgent,
+			Time:      session.CreatedAt,
+		},
+	})
+}
+
+// listDevicesHandler godoc
+// @Summary List known devices
+// @Description List the devices (IP address and User-Agent) the current user has logged in from, most recently seen first
+// @Tags auth
+// @Produce json
+// @Success 200 {array} KnownDeviceResponse
+// @Failure 401 {object} map[string]string
+// @Failure 500 {object} map[string]string
+// @Router /auth/devices [get]
+// @Security BearerAuth
+func listDevicesHandler(c *gin.Context) {
+	user := auth.GetUser(c)
+	if user == nil {
+		apierrors.Unauthorized(c, fmt.Errorf("not authenticated"))
+		return
+	}
+
+	devices, err := db.ListKnownDevices(user.ID)
+	if err != nil {
+		apierrors.OperationFailed(c, err)
+		return
+	}
+
+	// API key requests have no session, and so no current device
+	var fingerprint string
+	if session := auth.GetSession(c); session != nil {
+		fingerprint = session.Fingerprint
+	}
+
+	response := make([]KnownDeviceResponse, 0, len(devices))
+	for _, device := range devices {
+		response = append(response, KnownDeviceResponse{
+			KnownDevice: device,
+			Current:     fingerprint != "" && device.Fingerprint == fingerprint,
+		})
+	}
+	c.JSON(http.StatusOK, response)
+}
+
+// revokeDeviceHandler godoc
+// @Summary Revoke a known device
+// @Description Forget a device of the current user and log out its sessions; the next login from it counts as a new device
+// @Tags auth
+// @Produce json
+// @Param id path int true "Device ID"
+// @Success 200 {object} map[string]string
+// @Failure 400 {object} map[string]string
+// @Failure 401 {object} map[string]string
+// @Failure 404 {object} map[string]string
+// @Router /auth/devices/{id} [delete]
+// @Security BearerAuth
+func revokeDeviceHandler(c *gin.Context) {
+	user := auth.GetUser(c)
+	if user == nil {
+		apierrors.Unauthorized(c, fmt.Errorf("not authenticated"))
+		return
+	}
+
+	id, err := strconv.ParseUint(c.Param("id"), 10, 64)
+	if err != nil {
+		apierrors.BadRequest(c, fmt.Errorf("invalid device ID: %w", err))
+		return
+	}
+
+	if err := auth.RevokeDevice(user.ID, uint(id)); err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			apierrors.NotFound(c, fmt.Errorf("device not found"))
+			return
+		}
+		audit.LogUserAction(middleware.AuditContext(c), audit.ActionUserDeviceRevoke, audit.StatusFailure, c.Param("id"),
+			"Failed to revoke device", nil, err)
+		apierrors.OperationFailed(c, err)
+		return
+	}
+
+	audit.LogUserAction(middleware.AuditContext(c), audit.ActionUserDeviceRevoke, audit.StatusSuccess, c.Param("id"),
+		fmt.Sprintf("Device revoked from %s", c.ClientIP()), nil, nil)
+
+	c.JSON(http.StatusOK, gin.H{"message": "device revoked"})
+}
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/thesabbir/hellfire/pkg/config"
+	"github.com/thesabbir/hellfire/pkg/dhcp"
+	"github.com/thesabbir/hellfire/pkg/dns"
+	apierrors "github.com/thesabbir/hellfire/pkg/errors"
+)
+
+// DHCPPoolsResponse is the utilization of the DHCP pools
+type DHCPPoolsResponse struct {
+	Pools []dhcp.Usage `json:"pools"`
+}
+
+// dhcpPoolUsage computes the utilization of the pools of the committed dhcp
+// config from dnsmasq's lease file and the static leases
+func dhcpPoolUsage(manager *config.Manager) ([]dhcp.Usage, error) {
+	dhcpConfig, err := manager.LoadCommitted("dhcp")
+	if err != nil {
+		return nil, err
+	}
+	network, err := manager.LoadCommitted("network")
+	if err != nil {
+		return nil, err
+	}
+
+	pools, err := dhcp.Pools(dhcpConfig, network)
+	if err != nil {
+		return nil, err
+	}
+	leases, err := dhcp.ReadLeases(dhcp.LeaseFile(dhcpConfig))
+	if err != nil {
+		return nil, err
+	}
+	static, err := dns.StaticLeases(dhcpConfig)
+	if err != nil {
+		return nil, err
+	}
+	return dhcp.Utilization(pools, leases, static, time.Now()), nil
+}
+
+// dhcpPoolsHandler godoc
+// @Summary Get DHCP pool utilization
+// @Description List the address pools of the committed dhcp config with how many of their addresses are leased or reserved by static leases. Pools at or over their warn_percent (default 90) are exhausting.
+// @Tags dhcp
+// @Produce json
+// @Success 200 {object} DHCPPoolsResponse
+// @Failure 500 {object} map[string]string
+// @Router /dhcp/pools [get]
+// @Security BearerAuth
+func dhcpPoolsHandler(manager *config.Manager) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		usages, err := dhcpPoolUsage(manager)
+		if err != nil {
+			apierrors.OperationFailed(c, err)
+			return
+		}
+		c.JSON(http.StatusOK, DHCPPoolsResponse{Pools: usages})
+	}
+}
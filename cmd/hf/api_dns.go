@@ -0,0 +1,438 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/thesabbir/hellfire/pkg/audit"
+	"github.com/thesabbir/hellfire/pkg/bus"
+	"github.com/thesabbir/hellfire/pkg/config"
+	"github.com/thesabbir/hellfire/pkg/dns"
+	apierrors "github.com/thesabbir/hellfire/pkg/errors"
+	"github.com/thesabbir/hellfire/pkg/middleware"
+	"github.com/thesabbir/hellfire/pkg/uci"
+)
+
+const (
+	defaultDNSStatsLimit = 10
+	maxDNSStatsLimit     = 100
+)
+
+// dnsQueryLog keeps the queries dnsmasq logs to the syslog collector; nil
+// when the collector is disabled
+var dnsQueryLog *dns.QueryLog
+
+// DNSResponse is the DNS settings of the dhcp config besides its upstream
+// servers
+type DNSResponse struct {
+	Servers    []dns.Server `json:"servers"`     // Upstream servers, including conditional forwarders
+	LocalZones []string     `json:"local_zones"` // Zones answered from local records only
+	Records    []dns.Record `json:"records"`
+}
+
+// DNSRecordsResponse is the locally answered DNS records
+type DNSRecordsResponse struct {
+	Records []dns.Record `json:"records"`
+}
+
+// DNSRecordRequest is a DNS record to add or update
+type DNSRecordRequest struct {
+	Type  string `json:"type" binding:"required"` // A, AAAA or CNAME
+	Name  string `json:"name" binding:"required"`
+	Value string `json:"value" binding:"required"` // Address, or target name of a CNAME
+}
+
+// DNSHostsResponse is the hosts entries resolved locally
+type DNSHostsResponse struct {
+	Domain string     `json:"domain,omitempty"` // Local domain static leases are registered under
+	Hosts  []dns.Host `json:"hosts"`
+}
+
+// DNSHostRequest is a hosts entry to add
+type DNSHostRequest struct {
+	Name    string `json:"name" binding:"required"`
+	Address string `json:"address" binding:"required"`
+}
+
+// DNSStatsResponse is the statistics of the logged DNS queries
+type DNSStatsResponse struct {
+	Enabled    bool `json:"enabled"`    // Whether the committed dhcp config logs queries
+	Collecting bool `json:"collecting"` // Whether the syslog collector receives them
+	dns.QueryStats
+}
+
+// DNSRecordResponse is a staged DNS record
+type DNSRecordResponse struct {
+	Message string     `json:"message"`
+	Record  dns.Record `json:"record"`
+}
+
+// dnsHandler godoc
+// @Summary Get DNS settings
+// @Description Get the upstream servers (including conditional forwarders such as /corp.example/10.0.0.1), local zones and local records of the dhcp config, staged changes included
+// @Tags dns
+// @Produce json
+// @Success 200 {object} DNSResponse
+// @Failure 400 {object} map[string]string
+// @Failure 403 {object} map[string]string
+// @Failure 500 {object} map[string]string
+// @Router /dns [get]
+// @Security BearerAuth
+func dnsHandler(settings *apiSettings, manager *config.Manager) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		dhcp, ok := loadDNSConfig(c, settings, manager)
+		if !ok {
+			return
+		}
+
+		servers, err := dns.Servers(dhcp)
+		if err != nil {
+			dnsError(c, err)
+			return
+		}
+		zones, err := dns.LocalZones(dhcp)
+		if err != nil {
+			dnsError(c, err)
+			return
+		}
+		records, err := visibleRecords(c, settings, manager, dhcp)
+		if err != nil {
+			dnsError(c, err)
+			return
+		}
+
+		c.JSON(http.StatusOK, DNSResponse{
+			Servers:    servers,
+			LocalZones: zones,
+			Records:    records,
+		})
+	}
+}
+
+// dnsRecordsHandler godoc
+// @Summary List DNS records
+// @Description List the A, AAAA and CNAME records answered locally, staged changes included
+// @Tags dns
+// @Produce json
+// @Success 200 {object} DNSRecordsResponse
+// @Failure 400 {object} map[string]string
+// @Failure 403 {object} map[string]string
+// @Failure 500 {object} map[string]string
+// @Router /dns/records [get]
+// @Security BearerAuth
+func dnsRecordsHandler(settings *apiSettings, manager *config.Manager) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		dhcp, ok := loadDNSConfig(c, settings, manager)
+		if !ok {
+			return
+		}
+
+		records, err := visibleRecords(c, settings, manager, dhcp)
+		if err != nil {
+			dnsError(c, err)
+			return
+		}
+		c.JSON(http.StatusOK, DNSRecordsResponse{Records: records})
+	}
+}
+
+// addDNSRecordHandler godoc
+// @Summary Add a DNS record
+// @Description Stage a new A, AAAA or CNAME record. A name with a CNAME can't have other records.
+// @Tags dns
+// @Accept json
+// @Produce json
+// @Param record body DNSRecordRequest true "Record"
+// @Success 201 {object} DNSRecordResponse
+// @Failure 400 {object} map[string]string
+// @Failure 403 {object} map[string]string
+// @Failure 500 {object} map[string]string
+// @Router /dns/records [post]
+// @Security BearerAuth
+func addDNSRecordHandler(settings *apiSettings, manager *config.Manager) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var req DNSRecordRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		stageDNSRecord(c, settings, manager, http.StatusCreated, func(dhcp *uci.Config) (dns.Record, []config.Operation, error) {
+			return dns.AddRecord(dhcp, dns.Record{Type: req.Type, Name: req.Name, Value: req.Value})
+		})
+	}
+}
+
+// updateDNSRecordHandler godoc
+// @Summary Update a DNS record
+// @Description Stage changes to a record. A and AAAA records can change into each other, but not into a CNAME or back.
+// @Tags dns
+// @Accept json
+// @Produce json
+// @Param id path string true "Record ID"
+// @Param record body DNSRecordRequest true "Record"
+// @Success 200 {object} DNSRecordResponse
+// @Failure 400 {object} map[string]string
+// @Failure 403 {object} map[string]string
+// @Failure 404 {object} map[string]string
+// @Failure 500 {object} map[string]string
+// @Router /dns/records/{id} [put]
+// @Security BearerAuth
+func updateDNSRecordHandler(settings *apiSettings, manager *config.Manager) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var req DNSRecordRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		stageDNSRecord(c, settings, manager, http.StatusOK, func(dhcp *uci.Config) (dns.Record, []config.Operation, error) {
+			return dns.UpdateRecord(dhcp, c.Param("id"), dns.Record{Type: req.Type, Name: req.Name, Value: req.Value})
+		})
+	}
+}
+
+// deleteDNSRecordHandler godoc
+// @Summary Delete a DNS record
+// @Description Stage the removal of a record
+// @Tags dns
+// @Produce json
+// @Param id path string true "Record ID"
+// @Success 200 {object} DNSRecordResponse
+// @Failure 400 {object} map[string]string
+// @Failure 403 {object} map[string]string
+// @Failure 404 {object} map[string]string
+// @Failure 500 {object} map[string]string
+// @Router /dns/records/{id} [delete]
+// @Security BearerAuth
+func deleteDNSRecordHandler(settings *apiSettings, manager *config.Manager) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		stageDNSRecord(c, settings, manager, http.StatusOK, func(dhcp *uci.Config) (dns.Record, []config.Operation, error) {
+			return dns.DeleteRecord(dhcp, c.Param("id"))
+		})
+	}
+}
+
+// dnsHostsHandler godoc
+// @Summary List hosts entries
+// @Description List the names resolved locally to addresses: the A and AAAA records, and the static leases registered under the local domain. With format=hosts the entries are returned as an /etc/hosts file.
+// @Tags dns
+// @Produce json
+// @Produce plain
+// @Param format query string false "json (default) or hosts"
+// @Success 200 {object} DNSHostsResponse
+// @Failure 400 {object} map[string]string
+// @Failure 403 {object} map[string]string
+// @Failure 500 {object} map[string]string
+// @Router /dns/hosts [get]
+// @Security BearerAuth
+func dnsHostsHandler(settings *apiSettings, manager *config.Manager) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		dhcp, ok := loadDNSConfig(c, settings, manager)
+		if !ok {
+			return
+		}
+
+		hosts, err := dns.Hosts(dhcp)
+		if err != nil {
+			dnsError(c, err)
+			return
+		}
+		visible := []dns.Host{}
+		for _, host := range hosts {
+			if pathAccess(c, settings, manager, "dhcp."+host.ID).Read {
+				visible = append(visible, host)
+			}
+		}
+
+		if c.Query("format") == "hosts" {
+			c.Data(http.StatusOK, "text/plain; charset=utf-8", []byte(dns.HostsFile(visible)))
+			return
+		}
+		c.JSON(http.StatusOK, DNSHostsResponse{Domain: dns.LocalDomain(dhcp), Hosts: visible})
+	}
+}
+
+// addDNSHostHandler godoc
+// @Summary Add a hosts entry
+// @Description Stage an A or AAAA record, by the family of the address
+// @Tags dns
+// @Accept json
+// @Produce json
+// @Param host body DNSHostRequest true "Hosts entry"
+// @Success 201 {object} DNSRecordResponse
+// @Failure 400 {object} map[string]string
+// @Failure 403 {object} map[string]string
+// @Failure 500 {object} map[string]string
+// @Router /dns/hosts [post]
+// @Security BearerAuth
+func addDNSHostHandler(settings *apiSettings, manager *config.Manager) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var req DNSHostRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		stageDNSRecord(c, settings, manager, http.StatusCreated, func(dhcp *uci.Config) (dns.Record, []config.Operation, error) {
+			return dns.AddRecord(dhcp, dns.HostRecord(req.Name, req.Address))
+		})
+	}
+}
+
+// deleteDNSHostHandler godoc
+// @Summary Delete a hosts entry
+// @Description Stage the removal of the record of a hosts entry. Entries of static leases are removed with the lease, or by setting its dns option to 0.
+// @Tags dns
+// @Produce json
+// @Param id path string true "Hosts entry ID"
+// @Success 200 {object} DNSRecordResponse
+// @Failure 400 {object} map[string]string
+// @Failure 403 {object} map[string]string
+// @Failure 404 {object} map[string]string
+// @Failure 500 {object} map[string]string
+// @Router /dns/hosts/{id} [delete]
+// @Security BearerAuth
+func deleteDNSHostHandler(settings *apiSettings, manager *config.Manager) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		stageDNSRecord(c, settings, manager, http.StatusOK, func(dhcp *uci.Config) (dns.Record, []config.Operation, error) {
+			return dns.DeleteHost(dhcp, c.Param("id"))
+		})
+	}
+}
+
+// dnsStatsHandler godoc
+// @Summary Get DNS query statistics
+// @Description Count the queries dnsmasq logged to the syslog collector, with the top domains and clients and the blocked queries (answered locally with 0.0.0.0, :: or NXDOMAIN). Queries are logged with the logqueries option of the dnsmasq section, and kept in memory only.
+// @Tags dns
+// @Produce json
+// @Param since query string false "How far back to count, as a duration (default everything kept)"
+// @Param limit query int false "Number of top domains and clients (default 10, maximum 100)"
+// @Success 200 {object} DNSStatsResponse
+// @Failure 400 {object} map[string]string
+// @Failure 500 {object} map[string]string
+// @Router /dns/stats [get]
+// @Security BearerAuth
+func dnsStatsHandler(manager *config.Manager) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var since time.Time
+		if value := c.Query("since"); value != "" {
+			d, err := time.ParseDuration(value)
+			if err != nil || d <= 0 {
+				apierrors.BadRequest(c, fmt.Errorf("invalid since duration: %s", value))
+				return
+			}
+			since = time.Now().Add(-d)
+		}
+		limit := defaultDNSStatsLimit
+		if value := c.Query("limit"); value != "" {
+			n, err := strconv.Atoi(value)
+			if err != nil || n < 1 {
+				apierrors.BadRequest(c, fmt.Errorf("invalid limit: %s", value))
+				return
+			}
+			limit = min(n, maxDNSStatsLimit)
+		}
+
+		dhcp, err := manager.LoadCommitted("dhcp")
+		if err != nil {
+			apierrors.InternalServerError(c, err)
+			return
+		}
+		response := DNSStatsResponse{Collecting: dnsQueryLog != nil}
+		if dnsmasq := dhcp.GetSection("dnsmasq", ""); dnsmasq != nil {
+			logQueries, _ := dnsmasq.GetOption("logqueries")
+			response.Enabled = logQueries == "1"
+		}
+		response.QueryStats = dnsQueryLog.Stats(since, limit)
+		c.JSON(http.StatusOK, response)
+	}
+}
+
+// loadDNSConfig loads the dhcp config if the caller may read it, responding
+// with the error otherwise
+func loadDNSConfig(c *gin.Context, settings *apiSettings, manager *config.Manager) (*uci.Config, bool) {
+	if !configAccess(c, settings, "dhcp", nil).Read {
+		apierrors.Forbidden(c, errors.New("no read access to dhcp"))
+		return nil, false
+	}
+	dhcp, err := manager.Load("dhcp")
+	if err != nil {
+		apierrors.InternalServerError(c, err)
+		return nil, false
+	}
+	return dhcp, true
+}
+
+// visibleRecords returns the records of dhcp the caller may read
+func visibleRecords(c *gin.Context, settings *apiSettings, manager *config.Manager, dhcp *uci.Config) ([]dns.Record, error) {
+	records, err := dns.Records(dhcp)
+	if err != nil {
+		return nil, err
+	}
+	visible := []dns.Record{}
+	for _, record := range records {
+		if pathAccess(c, settings, manager, "dhcp."+record.ID).Read {
+			visible = append(visible, record)
+		}
+	}
+	return visible, nil
+}
+
+// stageDNSRecord stages the operations change returns for the staged dhcp
+// config, if the caller may write the record's section, auditing and
+// announcing the change. It responds with the record, or the error.
+func stageDNSRecord(c *gin.Context, settings *apiSettings, manager *config.Manager, status int,
+	change func(dhcp *uci.Config) (dns.Record, []config.Operation, error)) {
+	dhcp, err := manager.Load("dhcp")
+	if err != nil {
+		apierrors.InternalServerError(c, err)
+		return
+	}
+	record, ops, err := change(dhcp)
+	if err != nil {
+		dnsError(c, err)
+		return
+	}
+	path := "dhcp." + record.ID
+	if !pathAccess(c, settings, manager, path).Write {
+		apierrors.Forbidden(c, fmt.Errorf("no write access to %s", path))
+		return
+	}
+
+	message := fmt.Sprintf("Staged %s record %s", record.Type, record.Name)
+	if ops[0].Op == config.OpDelete {
+		message = fmt.Sprintf("Deleted %s record %s", record.Type, record.Name)
+	}
+	if _, err := manager.Batch(ops); err != nil {
+		audit.LogUserAction(middleware.AuditContext(c), audit.ActionConfigWrite, audit.StatusFailure, path,
+			message, nil, err)
+		apierrors.OperationFailed(c, err)
+		return
+	}
+	audit.LogUserAction(middleware.AuditContext(c), audit.ActionConfigWrite, audit.StatusSuccess, path,
+		message, nil, nil)
+
+	bus.Publish(bus.Event{
+		Type:       bus.EventConfigChanged,
+		ConfigName: "dhcp",
+		Data:       ops,
+	})
+
+	c.JSON(status, DNSRecordResponse{Message: "change staged, commit to apply", Record: record})
+}
+
+// dnsError responds with the status for a dns error; invalid records and
+// configs are returned to the client so it can fix them
+func dnsError(c *gin.Context, err error) {
+	switch {
+	case errors.Is(err, dns.ErrNotFound):
+		apierrors.NotFound(c, err)
+	case errors.Is(err, dns.ErrInvalid):
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	default:
+		apierrors.OperationFailed(c, err)
+	}
+}
@@ -0,0 +1,104 @@
+package main
+
+import (
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/thesabbir/hellfire/pkg/bus"
+	"github.com/thesabbir/hellfire/pkg/db"
+	apierrors "github.com/thesabbir/hellfire/pkg/errors"
+	"github.com/thesabbir/hellfire/pkg/logger"
+)
+
+// ConfigGenerationHeader carries the config generation on config responses
+const ConfigGenerationHeader = "X-Config-Generation"
+
+// generationPollInterval is how often a generation stream re-reads the
+// generation, for changes made outside the API server (e.g. hf commit)
+const generationPollInterval = 5 * time.Second
+
+// ConfigGenerationResponse is the config generation, bumped by every commit
+// and rollback of the committed configs
+type ConfigGenerationResponse struct {
+	Generation uint64 `json:"generation" example:"42"`
+}
+
+// configGenerationMiddleware sets the config generation header, so clients
+// can tell whether the views they cached are stale
+func configGenerationMiddleware(c *gin.Context) {
+	if generation, err := db.GetConfigGeneration(); err == nil {
+		c.Header(ConfigGenerationHeader, strconv.FormatUint(generation, 10))
+	} else {
+		logger.Debug("Failed to read the config generation", "error", err)
+	}
+	c.Next()
+}
+
+// configGenerationHandler godoc
+// @Summary Get config generation
+// @Description The config generation, a number bumped by every commit and rollback of the committed configs (also in the X-Config-Generation header of config responses). Clients caching config views drop them when it changes.
+// @Description With "Accept: text/event-stream" the response streams a "generation" event with the current generation, then one for each change until the request times out; EventSource reconnects by itself.
+// @Tags config
+// @Produce json
+// @Produce text/event-stream
+// @Success 200 {object} ConfigGenerationResponse
+// @Failure 500 {object} map[string]string
+// @Security BearerAuth
+// @Router /config/generation [get]
+func configGenerationHandler(c *gin.Context) {
+	if !strings.Contains(c.GetHeader("Accept"), "text/event-stream") {
+		generation, err := db.GetConfigGeneration()
+		if err != nil {
+			apierrors.OperationFailed(c, err)
+			return
+		}
+		c.JSON(http.StatusOK, ConfigGenerationResponse{Generation: generation})
+		return
+	}
+
+	// Watch before reading, so no change falls in between
+	events, stop := bus.Watch(16)
+	defer stop()
+	ticker := time.NewTicker(generationPollInterval)
+	defer ticker.Stop()
+
+	c.Header("Cache-Control", "no-cache")
+	c.Header("X-Accel-Buffering", "no")
+
+	var last uint64
+	sent := false
+	c.Stream(func(w io.Writer) bool {
+		// Every change is read back from the database, so events the watch
+		// dropped and commits of other processes aren't missed
+		if sent {
+			select {
+			case <-c.Request.Context().Done():
+				return false
+			case event, ok := <-events:
+				if !ok {
+					return false
+				}
+				if event.Type != bus.EventConfigGeneration {
+					return true
+				}
+			case <-ticker.C:
+			}
+		}
+
+		generation, err := db.GetConfigGeneration()
+		if err != nil {
+			c.SSEvent("error", apierrors.Body(c, apierrors.CodeOperationFailed))
+			return false
+		}
+		if !sent || generation > last {
+			c.SSEvent("generation", ConfigGenerationResponse{Generation: generation})
+			last, sent = generation, true
+		}
+		return true
+	})
+}
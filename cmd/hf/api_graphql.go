@@ -0,0 +1,494 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"slices"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+
+	"github.com/thesabbir/hellfire/pkg/auth"
+	"github.com/thesabbir/hellfire/pkg/config"
+	"github.com/thesabbir/hellfire/pkg/db"
+	apierrors "github.com/thesabbir/hellfire/pkg/errors"
+	"github.com/thesabbir/hellfire/pkg/graphql"
+	"github.com/thesabbir/hellfire/pkg/snapshot"
+	"github.com/thesabbir/hellfire/pkg/uci"
+)
+
+// Page sizes of the GraphQL list fields
+const (
+	graphQLDefaultLimit = 20
+	graphQLMaxLimit     = 100
+)
+
+// graphQLCallerKey is the context key of the gin context of a GraphQL request
+type graphQLCallerKey struct{}
+
+// graphQLCaller returns the gin context of the request a resolver runs for,
+// for the caller's identity and config ACLs
+func graphQLCaller(ctx context.Context) *gin.Context {
+	return ctx.Value(graphQLCallerKey{}).(*gin.Context)
+}
+
+// errAdminRequired is returned by the fields only admins may read
+var errAdminRequired = errors.New("admin role required")
+
+// graphQLConfig is a config as the caller may read it
+type graphQLConfig struct {
+	name string
+	cfg  *uci.Config
+}
+
+// graphQLSection is a config section with the caller's access to it
+type graphQLSection struct {
+	section *uci.Section
+	access  auth.ConfigAccess
+}
+
+// graphQLOption is a single option or list of a section
+type graphQLOption struct {
+	name   string
+	value  string
+	values []string
+}
+
+// gqlField is a field read from its source value, which must be a T
+func gqlField[T any](name, typ string, get func(T) any) *graphql.Field {
+	return &graphql.Field{Name: name, Type: typ, Resolve: func(_ context.Context, source any, _ map[string]any) (any, error) {
+		return get(source.(T)), nil
+	}}
+}
+
+// gqlLimit returns the page size a list field was asked for, within bounds
+func gqlLimit(args map[string]any) int {
+	limit, _ := args["limit"].(int)
+	if limit <= 0 {
+		return graphQLDefaultLimit
+	}
+	return min(limit, graphQLMaxLimit)
+}
+
+// pageArgs are the arguments of paginated list fields
+var pageArgs = []graphql.Arg{
+	{Name: "limit", Type: "Int", Default: graphQLDefaultLimit, Description: "Entries to return, at most 100"},
+	{Name: "offset", Type: "Int", Default: 0, Description: "Entries to skip, newest first"},
+}
+
+// newGraphQLSchema builds the read-only GraphQL schema over configs,
+// transactions, snapshots, users and the audit log. Configs are filtered
+// by the config ACLs and have their secrets masked like the REST API;
+// users and the audit log are for admins.
+func newGraphQLSchema(settings *apiSettings, manager *config.Manager, snapshotMgr *snapshot.Manager) (*graphql.Schema, error) {
+	loadConfig := func(c *gin.Context, name string) (*graphQLConfig, error) {
+		if !config.ValidName(name) || !configAccess(c, settings, name, nil).Read {
+			return nil, nil
+		}
+		cfg, err := manager.Load(name)
+		if err != nil {
+			return nil, err
+		}
+		if !canSeeSecrets(c) {
+			cfg = config.MaskSecrets(name, cfg)
+		}
+		return &graphQLConfig{name: name, cfg: cfg}, nil
+	}
+
+	loadSnapshot := func(id string) (any, error) {
+		snap, err := snapshotMgr.Load(id)
+		if err != nil {
+			return nil, err
+		}
+		return &snap.Metadata, nil
+	}
+
+	listTransactions := func(filters map[string]interface{}, args map[string]any) (any, error) {
+		if status, ok := args["status"].(string); ok {
+			filters["status"] = status
+		}
+		offset, _ := args["offset"].(int)
+		txs, _, err := db.ListTransactions(filters, gqlLimit(args), max(offset, 0))
+		if err != nil {
+			return nil, err
+		}
+		return pointers(txs), nil
+	}
+
+	transactionArgs := append(slices.Clone(pageArgs), graphql.Arg{Name: "status", Type: "String", Description: "Only transactions in this state"})
+
+	option := &graphql.Object{Name: "Option", Description: "An option of a config section", Fields: []*graphql.Field{
+		gqlField("name", "String!", func(o graphQLOption) any { return o.name }),
+		gqlField("value", "String!", func(o graphQLOption) any { return o.value }),
+	}}
+
+	optionList := &graphql.Object{Name: "OptionList", Description: "A list option of a config section", Fields: []*graphql.Field{
+		gqlField("name", "String!", func(o graphQLOption) any { return o.name }),
+		gqlField("values", "[String!]!", func(o graphQLOption) any { return o.values }),
+	}}
+
+	section := &graphql.Object{Name: "Section", Description: "A config section the caller may read", Fields: []*graphql.Field{
+		gqlField("name", "String", func(s *graphQLSection) any {
+			if s.section.Name == "" {
+				return nil
+			}
+			return s.section.Name
+		}),
+		gqlField("type", "String!", func(s *graphQLSection) any { return s.section.Type }),
+		gqlField("permissions", "[String!]!", func(s *graphQLSection) any { return s.access.Permissions() }),
+		gqlField("options", "[Option!]!", func(s *graphQLSection) any {
+			var options []graphQLOption
+			for _, name := range sortedKeys(s.section.Options) {
+				options = append(options, graphQLOption{name: name, value: s.section.Options[name]})
+			}
+			return options
+		}),
+		gqlField("lists", "[OptionList!]!", func(s *graphQLSection) any {
+			var lists []graphQLOption
+			for _, name := range sortedKeys(s.section.Lists) {
+				lists = append(lists, graphQLOption{name: name, values: s.section.Lists[name]})
+			}
+			return lists
+		}),
+		{Name: "option", Type: "String", Description: "The value of an option, if set",
+			Args: []graphql.Arg{{Name: "name", Type: "String!"}},
+			Resolve: func(_ context.Context, source any, args map[string]any) (any, error) {
+				if value, ok := source.(*graphQLSection).section.GetOption(args["name"].(string)); ok {
+					return value, nil
+				}
+				return nil, nil
+			}},
+	}}
+
+	cfgSections := func(ctx context.Context, cfg *graphQLConfig, match func(*uci.Section) bool) []*graphQLSection {
+		c := graphQLCaller(ctx)
+		var sections []*graphQLSection
+		for _, s := range cfg.cfg.Sections {
+			if !match(s) {
+				continue
+			}
+			if access := configAccess(c, settings, cfg.name, s); access.Read {
+				sections = append(sections, &graphQLSection{section: s, access: access})
+			}
+		}
+		return sections
+	}
+
+	configType := &graphql.Object{Name: "Config", Description: "A config, with staged changes, filtered by the config ACLs and with secrets masked for non-admins", Fields: []*graphql.Field{
+		gqlField("name", "String!", func(c *graphQLConfig) any { return c.name }),
+		{Name: "sections", Type: "[Section!]!", Description: "The sections in file order",
+			Args: []graphql.Arg{{Name: "type", Type: "String", Description: "Only sections of this type"}},
+			Resolve: func(ctx context.Context, source any, args map[string]any) (any, error) {
+				typ, filtered := args["type"].(string)
+				return cfgSections(ctx, source.(*graphQLConfig), func(s *uci.Section) bool {
+					return !filtered || s.Type == typ
+				}), nil
+			}},
+		{Name: "section", Type: "Section", Description: "A named section",
+			Args: []graphql.Arg{{Name: "name", Type: "String!"}},
+			Resolve: func(ctx context.Context, source any, args map[string]any) (any, error) {
+				sections := cfgSections(ctx, source.(*graphQLConfig), func(s *uci.Section) bool {
+					return s.Name == args["name"]
+				})
+				if len(sections) == 0 {
+					return nil, nil
+				}
+				return sections[0], nil
+			}},
+	}}
+
+	snapshotType := &graphql.Object{Name: "Snapshot", Description: "A snapshot of the configs taken before a commit", Fields: []*graphql.Field{
+		gqlField("id", "ID!", func(m *snapshot.Metadata) any { return m.ID }),
+		gqlField("timestamp", "String!", func(m *snapshot.Metadata) any { return m.Timestamp }),
+		gqlField("message", "String!", func(m *snapshot.Metadata) any { return m.Message }),
+		gqlField("configs", "[String!]!", func(m *snapshot.Metadata) any { return m.Configs }),
+		gqlField("version", "String!", func(m *snapshot.Metadata) any { return m.Version }),
+		gqlField("protected", "Boolean!", func(m *snapshot.Metadata) any { return m.Protected }),
+	}}
+
+	artifact := &graphql.Object{Name: "Artifact", Description: "A file or command script a transaction pushed to the system", Fields: []*graphql.Field{
+		gqlField("id", "ID!", func(a *db.TransactionArtifact) any { return a.ID }),
+		gqlField("created_at", "String!", func(a *db.TransactionArtifact) any { return a.CreatedAt }),
+		gqlField("stage", "String!", func(a *db.TransactionArtifact) any { return a.Stage }),
+		gqlField("config", "String!", func(a *db.TransactionArtifact) any { return a.Config }),
+		gqlField("name", "String!", func(a *db.TransactionArtifact) any { return a.Name }),
+		gqlField("sha256", "String!", func(a *db.TransactionArtifact) any { return a.SHA256 }),
+		gqlField("content", "String!", func(a *db.TransactionArtifact) any { return a.Content }),
+	}}
+
+	transactionType := &graphql.Object{Name: "Transaction", Description: "A commit of staged changes", Fields: []*graphql.Field{
+		gqlField("transaction_id", "ID!", func(tx *db.Transaction) any { return tx.TxID }),
+		gqlField("created_at", "String!", func(tx *db.Transaction) any { return tx.CreatedAt }),
+		gqlField("updated_at", "String!", func(tx *db.Transaction) any { return tx.UpdatedAt }),
+		gqlField("username", "String!", func(tx *db.Transaction) any { return tx.Username }),
+		gqlField("message", "String!", func(tx *db.Transaction) any { return tx.Message }),
+		gqlField("status", "String!", func(tx *db.Transaction) any { return tx.Status }),
+		gqlField("configs", "[String!]!", func(tx *db.Transaction) any {
+			configs := []string{}
+			_ = json.Unmarshal([]byte(tx.Configs), &configs)
+			return configs
+		}),
+		gqlField("snapshot_id", "String", func(tx *db.Transaction) any { return nilIfEmpty(tx.SnapshotID) }),
+		gqlField("confirmed_at", "String", func(tx *db.Transaction) any { return tx.ConfirmedAt }),
+		gqlField("completed_at", "String", func(tx *db.Transaction) any { return tx.CompletedAt }),
+		gqlField("rolled_back_at", "String", func(tx *db.Transaction) any { return tx.RolledBackAt }),
+		gqlField("error", "String", func(tx *db.Transaction) any { return nilIfEmpty(tx.Error) }),
+		gqlField("rollback_reason", "String", func(tx *db.Transaction) any { return nilIfEmpty(tx.RollbackReason) }),
+		gqlField("failed_applier", "String", func(tx *db.Transaction) any { return nilIfEmpty(tx.FailedApplier) }),
+		{Name: "snapshot", Type: "Snapshot", Description: "The snapshot taken before the changes, unless it has been pruned",
+			Resolve: func(_ context.Context, source any, _ map[string]any) (any, error) {
+				tx := source.(*db.Transaction)
+				if tx.SnapshotID == "" {
+					return nil, nil
+				}
+				snap, err := loadSnapshot(tx.SnapshotID)
+				if err != nil {
+					return nil, nil
+				}
+				return snap, nil
+			}},
+		{Name: "artifacts", Type: "[Artifact!]!", Description: "What the transaction pushed to the system, in order",
+			Resolve: func(_ context.Context, source any, _ map[string]any) (any, error) {
+				artifacts, err := db.ListTransactionArtifacts(source.(*db.Transaction).TxID)
+				if err != nil {
+					return nil, err
+				}
+				return pointers(artifacts), nil
+			}},
+	}}
+
+	auditEntry := &graphql.Object{Name: "AuditEntry", Description: "An entry of the audit log", Fields: []*graphql.Field{
+		gqlField("id", "ID!", func(l *db.AuditLog) any { return l.ID }),
+		gqlField("created_at", "String!", func(l *db.AuditLog) any { return l.CreatedAt }),
+		gqlField("username", "String!", func(l *db.AuditLog) any { return l.Username }),
+		gqlField("action", "String!", func(l *db.AuditLog) any { return l.Action }),
+		gqlField("resource", "String", func(l *db.AuditLog) any { return nilIfEmpty(l.Resource) }),
+		gqlField("status", "String!", func(l *db.AuditLog) any { return l.Status }),
+		gqlField("message", "String", func(l *db.AuditLog) any { return nilIfEmpty(l.Message) }),
+		gqlField("error", "String", func(l *db.AuditLog) any { return nilIfEmpty(l.Error) }),
+		gqlField("duration_ms", "Int", func(l *db.AuditLog) any { return l.Duration }),
+		gqlField("transaction_id", "String", func(l *db.AuditLog) any { return nilIfEmpty(l.TxID) }),
+		gqlField("request_id", "String", func(l *db.AuditLog) any { return nilIfEmpty(l.RequestID) }),
+		gqlField("impersonator", "String", func(l *db.AuditLog) any { return nilIfEmpty(l.Impersonator) }),
+		{Name: "transaction", Type: "Transaction", Description: "The transaction the entry belongs to, if any",
+			Resolve: func(_ context.Context, source any, _ map[string]any) (any, error) {
+				return findTransaction(source.(*db.AuditLog).TxID)
+			}},
+	}}
+
+	// The audit entries of a transaction are readable by anyone who can
+	// read the transaction, as in its timeline
+	transactionType.Fields = append(transactionType.Fields, &graphql.Field{
+		Name: "audit", Type: "[AuditEntry!]!", Description: "The audit entries of the transaction, oldest first",
+		Resolve: func(_ context.Context, source any, _ map[string]any) (any, error) {
+			logs, err := db.GetAuditLogsByTransaction(source.(*db.Transaction).TxID)
+			if err != nil {
+				return nil, err
+			}
+			return pointers(logs), nil
+		},
+	})
+
+	user := &graphql.Object{Name: "User", Description: "A user account", Fields: []*graphql.Field{
+		gqlField("id", "ID!", func(u *db.User) any { return u.ID }),
+		gqlField("username", "String!", func(u *db.User) any { return u.Username }),
+		gqlField("email", "String", func(u *db.User) any { return nilIfEmpty(u.Email) }),
+		gqlField("role", "String!", func(u *db.User) any { return string(u.Role) }),
+		gqlField("enabled", "Boolean!", func(u *db.User) any { return u.Enabled }),
+		gqlField("created_at", "String!", func(u *db.User) any { return u.CreatedAt }),
+		gqlField("last_login_at", "String", func(u *db.User) any { return u.LastLoginAt }),
+		{Name: "transactions", Type: "[Transaction!]!", Description: "The transactions of the user, newest first", Args: transactionArgs,
+			Resolve: func(_ context.Context, source any, args map[string]any) (any, error) {
+				return listTransactions(map[string]interface{}{"user_id": source.(*db.User).ID}, args)
+			}},
+	}}
+
+	query := &graphql.Object{Name: "Query", Fields: []*graphql.Field{
+		{Name: "me", Type: "User!", Description: "The authenticated user",
+			Resolve: func(ctx context.Context, _ any, _ map[string]any) (any, error) {
+				return auth.GetUser(graphQLCaller(ctx)), nil
+			}},
+		{Name: "configs", Type: "[Config!]!", Description: "The configs the caller may read",
+			Resolve: func(ctx context.Context, _ any, _ map[string]any) (any, error) {
+				names, err := manager.Names()
+				if err != nil {
+					return nil, err
+				}
+				var configs []*graphQLConfig
+				for _, name := range names {
+					cfg, err := loadConfig(graphQLCaller(ctx), name)
+					if err != nil {
+						return nil, err
+					}
+					if cfg != nil {
+						configs = append(configs, cfg)
+					}
+				}
+				return configs, nil
+			}},
+		{Name: "config", Type: "Config", Description: "A config (empty if it doesn't exist), or null if the caller may not read it",
+			Args: []graphql.Arg{{Name: "name", Type: "String!"}},
+			Resolve: func(ctx context.Context, _ any, args map[string]any) (any, error) {
+				return loadConfig(graphQLCaller(ctx), args["name"].(string))
+			}},
+		{Name: "transactions", Type: "[Transaction!]!", Description: "Transactions, newest first", Args: transactionArgs,
+			Resolve: func(_ context.Context, _ any, args map[string]any) (any, error) {
+				return listTransactions(map[string]interface{}{}, args)
+			}},
+		{Name: "transaction", Type: "Transaction", Description: "A transaction by ID",
+			Args: []graphql.Arg{{Name: "id", Type: "ID!"}},
+			Resolve: func(_ context.Context, _ any, args map[string]any) (any, error) {
+				return findTransaction(args["id"].(string))
+			}},
+		{Name: "snapshots", Type: "[Snapshot!]!", Description: "Snapshots, newest first",
+			Args: []graphql.Arg{{Name: "limit", Type: "Int", Default: graphQLDefaultLimit, Description: "Snapshots to return, at most 100"}},
+			Resolve: func(_ context.Context, _ any, args map[string]any) (any, error) {
+				snapshots, err := snapshotMgr.List()
+				if err != nil {
+					return nil, err
+				}
+				var result []*snapshot.Metadata
+				for _, snap := range snapshots[:min(len(snapshots), gqlLimit(args))] {
+					result = append(result, &snap.Metadata)
+				}
+				return result, nil
+			}},
+		{Name: "snapshot", Type: "Snapshot", Description: "A snapshot by ID",
+			Args: []graphql.Arg{{Name: "id", Type: "ID!"}},
+			Resolve: func(_ context.Context, _ any, args map[string]any) (any, error) {
+				return loadSnapshot(args["id"].(string))
+			}},
+		{Name: "users", Type: "[User!]", Description: "All users (admins only)",
+			Resolve: func(ctx context.Context, _ any, _ map[string]any) (any, error) {
+				if !auth.IsAdmin(auth.GetUser(graphQLCaller(ctx))) {
+					return nil, errAdminRequired
+				}
+				users, err := db.ListUsers()
+				if err != nil {
+					return nil, err
+				}
+				return pointers(users), nil
+			}},
+		{Name: "user", Type: "User", Description: "A user by name (admins only)",
+			Args: []graphql.Arg{{Name: "username", Type: "String!"}},
+			Resolve: func(ctx context.Context, _ any, args map[string]any) (any, error) {
+				if !auth.IsAdmin(auth.GetUser(graphQLCaller(ctx))) {
+					return nil, errAdminRequired
+				}
+				user, err := db.GetUserByUsername(args["username"].(string))
+				if errors.Is(err, gorm.ErrRecordNotFound) {
+					return nil, nil
+				}
+				return user, err
+			}},
+		{Name: "audit", Type: "[AuditEntry!]", Description: "Audit log entries, newest first (admins only)",
+			Args: append(slices.Clone(pageArgs),
+				graphql.Arg{Name: "action", Type: "String", Description: "Only entries of this action, e.g. config.commit"},
+				graphql.Arg{Name: "status", Type: "String", Description: "Only entries with this status: success or failure"},
+				graphql.Arg{Name: "resource", Type: "String", Description: "Only entries about this resource"},
+			),
+			Resolve: func(ctx context.Context, _ any, args map[string]any) (any, error) {
+				if !auth.IsAdmin(auth.GetUser(graphQLCaller(ctx))) {
+					return nil, errAdminRequired
+				}
+				filters := make(map[string]interface{})
+				for _, name := range []string{"action", "status", "resource"} {
+					if value, ok := args[name].(string); ok {
+						filters[name] = value
+					}
+				}
+				offset, _ := args["offset"].(int)
+				logs, _, err := db.ListAuditLogs(filters, gqlLimit(args), max(offset, 0))
+				if err != nil {
+					return nil, err
+				}
+				return pointers(logs), nil
+			}},
+	}}
+
+	return graphql.NewSchema(query, configType, section, option, optionList, transactionType, snapshotType, artifact, auditEntry, user)
+}
+
+// findTransaction loads a transaction by ID, or nil if there's none
+func findTransaction(txID string) (any, error) {
+	if txID == "" {
+		return nil, nil
+	}
+	tx, err := db.GetTransactionByID(txID)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return tx, nil
+}
+
+// pointers returns pointers to the elements of records, the source type
+// the resolvers of their fields expect
+func pointers[T any](records []T) []*T {
+	result := make([]*T, len(records))
+	for i := range records {
+		result[i] = &records[i]
+	}
+	return result
+}
+
+// nilIfEmpty returns nil for an unset string, which GraphQL reports as null
+func nilIfEmpty(s string) any {
+	if s == "" {
+		return nil
+	}
+	return s
+}
+
+// sortedKeys returns the keys of a map in order
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	slices.Sort(keys)
+	return keys
+}
+
+// graphQLHandler godoc
+// @Summary Run a GraphQL query
+// @Description Run a read-only GraphQL query over configs, transactions, snapshots, users and the audit log, so nested data (a transaction with its snapshot and audit entries) is fetched in one round trip. Supports fields, aliases, arguments, variables, fragments and @include/@skip; mutations are rejected. GET /graphql/schema returns the schema. Errors in the query fail it with only "errors"; fields that fail are null in "data" and listed in "errors". Only served when the API's graphql option is enabled.
+// @Tags graphql
+// @Accept json
+// @Produce json
+// @Param request body graphql.Request true "Query, operation name and variables"
+// @Success 200 {object} graphql.Response
+// @Failure 400 {object} map[string]string
+// @Router /graphql [post]
+// @Security BearerAuth
+func graphQLHandler(schema *graphql.Schema) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var req graphql.Request
+		if err := c.ShouldBindJSON(&req); err != nil {
+			apierrors.BadRequest(c, err)
+			return
+		}
+
+		ctx := context.WithValue(c.Request.Context(), graphQLCallerKey{}, c)
+		c.JSON(http.StatusOK, schema.Execute(ctx, req))
+	}
+}
+
+// graphQLSchemaHandler godoc
+// @Summary Get the GraphQL schema
+// @Description Describe the types and fields of the GraphQL endpoint in the schema definition language, for code generators and editors
+// @Tags graphql
+// @Produce plain
+// @Success 200 {string} string "Schema in SDL"
+// @Router /graphql/schema [get]
+// @Security BearerAuth
+func graphQLSchemaHandler(schema *graphql.Schema) gin.HandlerFunc {
+	sdl := schema.SDL()
+	return func(c *gin.Context) {
+		c.String(http.StatusOK, sdl)
+	}
+}
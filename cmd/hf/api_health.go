@@ -0,0 +1,150 @@
+package main
+
+import (
+	"net/http"
+	"path/filepath"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/thesabbir/hellfire/pkg/config"
+	"github.com/thesabbir/hellfire/pkg/db"
+	"github.com/thesabbir/hellfire/pkg/dhcp"
+	"github.com/thesabbir/hellfire/pkg/health"
+	"github.com/thesabbir/hellfire/pkg/hfconfig"
+	"github.com/thesabbir/hellfire/pkg/logger"
+	"github.com/thesabbir/hellfire/pkg/transaction"
+	"github.com/thesabbir/hellfire/pkg/util"
+)
+
+const (
+	// healthTransactionWindow is how far back transactions count towards the score
+	healthTransactionWindow = 24 * time.Hour
+
+	// maxHealthTransactions caps the transactions scored
+	maxHealthTransactions = 100
+)
+
+// healthScore runs the health checks. Checks without data are skipped.
+func healthScore(hfConfig *hfconfig.Config, manager *config.Manager, txMgr *transaction.Manager) health.Report {
+	var wan *health.Check // Skipped while WAN monitoring is disabled
+	if wanMonitor != nil {
+		wan = health.WAN(wanMonitor.Status())
+	}
+
+	return health.Compute(map[string]*health.Check{
+		health.CheckDrift:        driftCheck(manager),
+		health.CheckTransactions: transactionsCheck(txMgr),
+		health.CheckWAN:          wan,
+		health.CheckDisk:         diskCheck(),
+		health.CheckCerts:        certsCheck(hfConfig),
+	})
+}
+
+// transactionsCheck scores the transactions of the last day
+func transactionsCheck(txMgr *transaction.Manager) *health.Check {
+	recent, _, err := db.ListTransactions(map[string]interface{}{"from": time.Now().Add(-healthTransactionWindow)},
+		maxHealthTransactions, 0)
+	if err != nil {
+		logger.Warn("Health check failed", "check", health.CheckTransactions, "error", err)
+		return nil
+	}
+	return health.Transactions(recent, txMgr.Degraded() != nil)
+}
+
+// certsCheck scores the certificates as last recorded by the inventory
+func certsCheck(hfConfig *hfconfig.Config) *health.Check {
+	certificates, err := db.ListCertificates()
+	if err != nil {
+		logger.Warn("Health check failed", "check", health.CheckCerts, "error", err)
+		return nil
+	}
+	return health.Certs(certificates, time.Duration(hfConfig.Certs.WarnDays)*24*time.Hour, time.Now())
+}
+
+// driftCheck compares the config files with the last transaction: files
+// written since were changed outside hellfire. Hellfire's own config, which
+// is meant to be edited by hand, doesn't count. Skipped before the first
+// transaction and while one awaits confirmation.
+func driftCheck(manager *config.Manager) *health.Check {
+	last, _, err := db.ListTransactions(map[string]interface{}{}, 1, 0)
+	if err != nil {
+		logger.Warn("Health check failed", "check", health.CheckDrift, "error", err)
+		return nil
+	}
+	if len(last) == 0 || last[0].Status == string(transaction.StatePending) {
+		return nil
+	}
+
+	modified, err := manager.ModifiedSince(last[0].UpdatedAt)
+	if err != nil {
+		logger.Warn("Health check failed", "check", health.CheckDrift, "error", err)
+		return nil
+	}
+
+	drifted := make([]string, 0, len(modified))
+	for _, name := range modified {
+		if name != hellfireConfigName {
+			drifted = append(drifted, name)
+		}
+	}
+	return health.Drift(drifted)
+}
+
+// diskCheck scores the file systems of the config directory and the
+// database (which holds the snapshots by default)
+func diskCheck() *health.Check {
+	var usages []health.DiskUsage
+	for _, path := range []string{configDir, filepath.Dir(dbPath)} {
+		total, available, err := util.FileSystemUsage(path)
+		if err != nil {
+			continue // Not created yet
+		}
+		usages = append(usages, health.DiskUsage{Path: path, Total: total, Available: available})
+	}
+	return health.Disk(usages)
+}
+
+// healthScoreHandler godoc
+// @Summary Get health score
+// @Description Score from 0 to 100 of how much attention the router needs, combining config drift, failed transactions, WAN quality, free disk space and certificate expiry (checks without data are skipped)
+// @Tags system
+// @Produce json
+// @Success 200 {object} health.Report
+// @Failure 401 {object} map[string]string
+// @Router /health/score [get]
+// @Security BearerAuth
+func healthScoreHandler(hfConfig *hfconfig.Config, manager *config.Manager, txMgr *transaction.Manager) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		c.JSON(http.StatusOK, healthScore(hfConfig, manager, txMgr))
+	}
+}
+
+// metricsHandler godoc
+// @Summary Prometheus metrics
+// @Description The health score and the score of each check, and the size and utilization of the DHCP pools, as Prometheus gauges, for scraping with an API key
+// @Tags system
+// @Produce plain
+// @Success 200 {string} string "Prometheus text exposition format"
+// @Failure 401 {object} map[string]string
+// @Router /metrics [get]
+// @Security APIKeyAuth
+func metricsHandler(hfConfig *hfconfig.Config, manager *config.Manager, txMgr *transaction.Manager) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		c.Header("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		c.Status(http.StatusOK)
+		if err := healthScore(hfConfig, manager, txMgr).WritePrometheus(c.Writer); err != nil {
+			logger.Warn("Failed to write metrics", "error", err)
+		}
+
+		// Pools that can't be read are left out rather than failing the scrape
+		usages, err := dhcpPoolUsage(manager)
+		if err != nil {
+			logger.Warn("Failed to read DHCP pools for metrics", "error", err)
+			return
+		}
+		if err := dhcp.WritePrometheus(c.Writer, usages); err != nil {
+			logger.Warn("Failed to write metrics", "error", err)
+		}
+	}
+}
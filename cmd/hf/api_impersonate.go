@@ -0,0 +1,97 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+
+	"github.com/thesabbir/hellfire/pkg/audit"
+	"github.com/thesabbir/hellfire/pkg/auth"
+	"github.com/thesabbir/hellfire/pkg/db"
+	apierrors "github.com/thesabbir/hellfire/pkg/errors"
+	"github.com/thesabbir/hellfire/pkg/middleware"
+)
+
+type impersonateRequest struct {
+	Username string `json:"username" binding:"required"`
+	Duration int    `json:"duration,omitempty"` // Seconds, default 900, at most 3600
+}
+
+// ImpersonateResponse is the session an admin acts as another user with
+type ImpersonateResponse struct {
+	Token        string    `json:"token"`
+	User         *db.User  `json:"user"`         // The impersonated user
+	Impersonator *db.User  `json:"impersonator"` // The admin
+	ExpiresAt    time.Time `json:"expires_at"`
+}
+
+// SessionInfo describes the session of an /auth/me request
+type SessionInfo struct {
+	ExpiresAt      time.Time `json:"expires_at"`
+	AbsoluteExpiry time.Time `json:"absolute_expiry"`
+	ImpersonatedBy *db.User  `json:"impersonated_by,omitempty"`
+}
+
+// impersonateHandler godoc
+// @Summary Impersonate a user
+// @Description Open a short-lived session acting as another user, for troubleshooting what they see and can do (admin only). The session has the user's role, doesn't renew and can't impersonate further; audit entries made with it record both the user and the admin.
+// @Tags auth
+// @Accept json
+// @Produce json
+// @Param request body impersonateRequest true "User to impersonate"
+// @Success 200 {object} ImpersonateResponse
+// @Failure 400 {object} map[string]string
+// @Failure 401 {object} map[string]string
+// @Failure 403 {object} map[string]string
+// @Failure 404 {object} map[string]string
+// @Router /auth/impersonate [post]
+// @Security BearerAuth
+func impersonateHandler(c *gin.Context) {
+	session := auth.GetSession(c)
+	if session == nil {
+		// API keys aren't bound to a client to hand the session to
+		apierrors.Forbidden(c, fmt.Errorf("impersonation requires a login session"))
+		return
+	}
+	admin := &session.User
+
+	var req impersonateRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		apierrors.BadRequest(c, err)
+		return
+	}
+
+	target, err := db.GetUserByUsername(req.Username)
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			apierrors.NotFound(c, fmt.Errorf("user not found"))
+			return
+		}
+		apierrors.OperationFailed(c, err)
+		return
+	}
+
+	impersonated, err := auth.Impersonate(session, target, c.ClientIP(), c.Request.UserAgent(),
+		time.Duration(req.Duration)*time.Second)
+	if err != nil {
+		audit.LogUserAction(middleware.AuditContext(c), audit.ActionUserImpersonate, audit.StatusFailure,
+			fmt.Sprintf("user:%d", target.ID), fmt.Sprintf("Failed to impersonate %s", target.Username), nil, err)
+		apierrors.Forbidden(c, err)
+		return
+	}
+
+	audit.LogUserAction(middleware.AuditContext(c), audit.ActionUserImpersonate, audit.StatusSuccess,
+		fmt.Sprintf("user:%d", target.ID),
+		fmt.Sprintf("Impersonating %s until %s", target.Username, impersonated.ExpiresAt.Format(time.RFC3339)), nil, nil)
+
+	c.JSON(http.StatusOK, ImpersonateResponse{
+		Token:        impersonated.Token,
+		User:         &impersonated.User,
+		Impersonator: admin,
+		ExpiresAt:    impersonated.ExpiresAt,
+	})
+}
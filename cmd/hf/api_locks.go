@@ -0,0 +1,171 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/thesabbir/hellfire/pkg/audit"
+	"github.com/thesabbir/hellfire/pkg/auth"
+	"github.com/thesabbir/hellfire/pkg/config"
+	"github.com/thesabbir/hellfire/pkg/db"
+	apierrors "github.com/thesabbir/hellfire/pkg/errors"
+	"github.com/thesabbir/hellfire/pkg/logger"
+	"github.com/thesabbir/hellfire/pkg/middleware"
+)
+
+const (
+	defaultConfigLockTTL = 30 * time.Minute
+	maxConfigLockTTL     = 8 * time.Hour
+)
+
+// LockRequest is the optional request body for locking a config
+type LockRequest struct {
+	TTL  int    `json:"ttl" example:"1800"` // Seconds until the lock expires unless renewed (default 1800, maximum 28800)
+	Note string `json:"note" example:"Reworking the guest zone"`
+}
+
+// listConfigLocksHandler godoc
+// @Summary List config locks
+// @Description List the advisory locks operators hold on configs for long edits
+// @Tags config
+// @Produce json
+// @Success 200 {object} map[string][]db.ConfigLock
+// @Failure 500 {object} map[string]string
+// @Security BearerAuth
+// @Router /config/locks [get]
+func listConfigLocksHandler(c *gin.Context) {
+	locks, err := db.ListConfigLocks()
+	if err != nil {
+		apierrors.OperationFailed(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"locks": locks})
+}
+
+// lockConfigHandler godoc
+// @Summary Lock config
+// @Description Take an advisory lock on a config for a long edit, or renew one's own lock. Writes to the config by others still go through but carry a warning naming the holder. The lock expires after its TTL unless renewed.
+// @Tags config
+// @Accept json
+// @Produce json
+// @Param name path string true "Configuration name"
+// @Param request body LockRequest false "Lock options"
+// @Success 200 {object} db.ConfigLock
+// @Failure 400 {object} map[string]string
+// @Failure 409 {object} map[string]interface{} "Locked by another user, with their lock"
+// @Security BearerAuth
+// @Router /config/{name}/lock [put]
+func lockConfigHandler(c *gin.Context) {
+	name := c.Param("name")
+	if !config.ValidName(name) {
+		apierrors.BadRequest(c, fmt.Errorf("invalid config name %q", name))
+		return
+	}
+
+	var req LockRequest
+	if c.Request.ContentLength > 0 {
+		if err := c.ShouldBindJSON(&req); err != nil {
+			apierrors.BadRequest(c, err)
+			return
+		}
+	}
+	ttl := time.Duration(req.TTL) * time.Second
+	if ttl == 0 {
+		ttl = defaultConfigLockTTL
+	}
+	if ttl < 0 || ttl > maxConfigLockTTL {
+		apierrors.BadRequest(c, fmt.Errorf("ttl must be between 1 and %d seconds", int(maxConfigLockTTL.Seconds())))
+		return
+	}
+
+	user := auth.GetUser(c)
+	lock, err := db.AcquireConfigLock(&db.ConfigLock{
+		Config:    name,
+		Username:  user.Username,
+		Note:      req.Note,
+		ExpiresAt: time.Now().Add(ttl),
+	})
+	if errors.Is(err, db.ErrConfigLocked) {
+		response := apierrors.Body(c, apierrors.CodeConfigLocked)
+		response["lock"] = lock
+		c.JSON(http.StatusConflict, response)
+		return
+	}
+	if err != nil {
+		apierrors.OperationFailed(c, err)
+		return
+	}
+
+	audit.LogUserAction(middleware.AuditContext(c), audit.ActionConfigLock, audit.StatusSuccess, name,
+		fmt.Sprintf("Locked %s until %s", name, lock.ExpiresAt.Format(time.RFC3339)), nil, nil)
+	c.JSON(http.StatusOK, lock)
+}
+
+// unlockConfigHandler godoc
+// @Summary Unlock config
+// @Description Release one's own lock on a config. Admins can break the locks of others.
+// @Tags config
+// @Produce json
+// @Param name path string true "Configuration name"
+// @Success 200 {object} map[string]string
+// @Failure 403 {object} map[string]string
+// @Failure 404 {object} map[string]string
+// @Security BearerAuth
+// @Router /config/{name}/lock [delete]
+func unlockConfigHandler(c *gin.Context) {
+	name := c.Param("name")
+	lock, err := db.GetConfigLock(name)
+	if err != nil {
+		apierrors.OperationFailed(c, err)
+		return
+	}
+	if lock == nil {
+		apierrors.NotFound(c, fmt.Errorf("%s is not locked", name))
+		return
+	}
+
+	user := auth.GetUser(c)
+	broken := lock.Username != user.Username
+	if broken && user.Role != db.RoleAdmin {
+		apierrors.Forbidden(c, fmt.Errorf("%s is locked by %s", name, lock.Username))
+		return
+	}
+	if _, err := db.DeleteConfigLock(name); err != nil {
+		apierrors.OperationFailed(c, err)
+		return
+	}
+
+	details := fmt.Sprintf("Unlocked %s", name)
+	if broken {
+		details = fmt.Sprintf("Broke the lock of %s on %s", lock.Username, name)
+	}
+	audit.LogUserAction(middleware.AuditContext(c), audit.ActionConfigUnlock, audit.StatusSuccess, name, details, nil, nil)
+	c.JSON(http.StatusOK, gin.H{"message": details})
+}
+
+// lockWarnings warns about the configs locked by someone other than the
+// current user, for the responses of writes to them
+func lockWarnings(c *gin.Context, names ...string) []string {
+	user := auth.GetUser(c)
+	var warnings []string
+	for _, name := range names {
+		lock, err := db.GetConfigLock(name)
+		if err != nil {
+			logger.Debug("Failed to read the config lock", "config", name, "error", err)
+			continue
+		}
+		if lock == nil || (user != nil && lock.Username == user.Username) {
+			continue
+		}
+		warning := fmt.Sprintf("%s is locked by %s until %s", name, lock.Username, lock.ExpiresAt.Format(time.RFC3339))
+		if lock.Note != "" {
+			warning += ": " + lock.Note
+		}
+		warnings = append(warnings, warning)
+	}
+	return warnings
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	apierrors "github.com/thesabbir/hellfire/pkg/errors"
+	"github.com/thesabbir/hellfire/pkg/syslog"
+)
+
+const (
+	defaultLogEntries = 500
+	maxLogEntries     = 5000
+)
+
+// logCollector receives syslog messages in the API server; nil when disabled
+var logCollector *syslog.Collector
+
+// LogsResponse is the most recent system log messages
+type LogsResponse struct {
+	Enabled bool           `json:"enabled"`
+	Entries []syslog.Entry `json:"entries"` // Newest first
+}
+
+// logsHandler godoc
+// @Summary Get system logs
+// @Description List the most recent messages received by the syslog collector, newest first. Poll for new messages with after set to the newest ID seen.
+// @Tags logs
+// @Produce json
+// @Param app query string false "Only messages from this program (e.g. dnsmasq, kernel, hostapd)"
+// @Param host query string false "Only messages from this hostname"
+// @Param facility query string false "Only messages of this facility (e.g. kern, daemon)"
+// @Param severity query string false "Only this severity and more severe (name or 0-7)"
+// @Param since query string false "How far back to list, as a duration"
+// @Param after query int false "Only messages after this ID"
+// @Param q query string false "Only messages containing this text"
+// @Param limit query int false "Maximum number of messages (default 500, maximum 5000)"
+// @Success 200 {object} LogsResponse
+// @Failure 400 {object} map[string]string
+// @Failure 403 {object} map[string]string
+// @Router /logs [get]
+// @Security BearerAuth
+func logsHandler(c *gin.Context) {
+	filter := syslog.Filter{
+		App:      c.Query("app"),
+		Host:     c.Query("host"),
+		Facility: c.Query("facility"),
+		Contains: c.Query("q"),
+		Limit:    defaultLogEntries,
+	}
+
+	if value := c.Query("severity"); value != "" {
+		level, ok := syslog.ParseSeverity(value)
+		if !ok {
+			apierrors.BadRequest(c, fmt.Errorf("invalid severity: %s", value))
+			return
+		}
+		filter.MaxLevel = &level
+	}
+	if value := c.Query("since"); value != "" {
+		d, err := time.ParseDuration(value)
+		if err != nil || d <= 0 {
+			apierrors.BadRequest(c, fmt.Errorf("invalid since duration: %s", value))
+			return
+		}
+		filter.Since = time.Now().Add(-d)
+	}
+	if value := c.Query("after"); value != "" {
+		id, err := strconv.ParseUint(value, 10, 64)
+		if err != nil {
+			apierrors.BadRequest(c, fmt.Errorf("invalid after: %s", value))
+			return
+		}
+		filter.AfterID = id
+	}
+	if value := c.Query("limit"); value != "" {
+		n, err := strconv.Atoi(value)
+		if err != nil || n < 1 {
+			apierrors.BadRequest(c, fmt.Errorf("invalid limit: %s", value))
+			return
+		}
+		filter.Limit = min(n, maxLogEntries)
+	}
+
+	if logCollector == nil {
+		c.JSON(http.StatusOK, LogsResponse{Enabled: false, Entries: []syslog.Entry{}})
+		return
+	}
+
+	c.JSON(http.StatusOK, LogsResponse{
+		Enabled: true,
+		Entries: logCollector.Buffer().Query(filter),
+	})
+}
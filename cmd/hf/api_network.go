@@ -0,0 +1,103 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/thesabbir/hellfire/pkg/config"
+	apierrors "github.com/thesabbir/hellfire/pkg/errors"
+	"github.com/thesabbir/hellfire/pkg/modem"
+	"github.com/thesabbir/hellfire/pkg/netdetect"
+)
+
+// NetworkDevice is a network interface and how the network config uses it
+type NetworkDevice struct {
+	netdetect.Interface
+	Managed bool   `json:"managed"`         // Configured by an interface section
+	Proto   string `json:"proto,omitempty"` // The section's proto
+}
+
+// networkDevicesHandler godoc
+// @Summary List network devices
+// @Description List the physical network interfaces with their driver, speed, link state and MAC address, and whether an interface section of the network config (including staged changes) manages them
+// @Tags network
+// @Produce json
+// @Param all query bool false "Include loopback and virtual interfaces"
+// @Success 200 {object} map[string][]NetworkDevice
+// @Failure 500 {object} map[string]string
+// @Router /network/devices [get]
+// @Security BearerAuth
+func networkDevicesHandler(manager *config.Manager) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		interfaces, err := netdetect.Detect()
+		if err != nil {
+			apierrors.InternalServerError(c, err)
+			return
+		}
+
+		network, err := manager.Load("network")
+		if err != nil {
+			apierrors.InternalServerError(c, err)
+			return
+		}
+
+		all := c.Query("all") == "true" || c.Query("all") == "1"
+		devices := []NetworkDevice{}
+		for _, iface := range interfaces {
+			if !iface.Physical && !all {
+				continue
+			}
+			device := NetworkDevice{Interface: iface}
+			// Interface sections are named after the device they configure
+			if section := network.GetSection("interface", iface.Name); section != nil {
+				device.Managed = true
+				device.Proto, _ = section.GetOption("proto")
+			}
+			devices = append(devices, device)
+		}
+
+		c.JSON(http.StatusOK, gin.H{"devices": devices})
+	}
+}
+
+// ModemsResponse is the cellular modems with their signal and registration
+type ModemsResponse struct {
+	Available bool          `json:"available"` // ModemManager is installed
+	Modems    []modem.Modem `json:"modems"`
+	Missing   []string      `json:"missing"` // Modem interfaces whose modem isn't plugged in
+}
+
+// modemsHandler godoc
+// @Summary List cellular modems
+// @Description List the modems known to ModemManager with their state, signal quality, access technologies, registration, operator and connected bearer, the modem interface of the committed network config using each, and the modem interfaces whose modem is missing
+// @Tags network
+// @Produce json
+// @Success 200 {object} ModemsResponse
+// @Failure 500 {object} map[string]string
+// @Router /network/modems [get]
+// @Security BearerAuth
+func modemsHandler(manager *config.Manager) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		network, err := manager.LoadCommitted("network")
+		if err != nil {
+			apierrors.InternalServerError(c, err)
+			return
+		}
+
+		modems, missing, err := modem.Status(c.Request.Context(), network)
+		if errors.Is(err, modem.ErrUnavailable) {
+			c.JSON(http.StatusOK, ModemsResponse{Modems: []modem.Modem{}, Missing: []string{}})
+			return
+		}
+		if err != nil {
+			apierrors.InternalServerError(c, err)
+			return
+		}
+		if missing == nil {
+			missing = []string{}
+		}
+		c.JSON(http.StatusOK, ModemsResponse{Available: true, Modems: modems, Missing: missing})
+	}
+}
@@ -0,0 +1,226 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"slices"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/thesabbir/hellfire/pkg/audit"
+	"github.com/thesabbir/hellfire/pkg/bus"
+	"github.com/thesabbir/hellfire/pkg/config"
+	apierrors "github.com/thesabbir/hellfire/pkg/errors"
+	"github.com/thesabbir/hellfire/pkg/middleware"
+	"github.com/thesabbir/hellfire/pkg/netdetect"
+	"github.com/thesabbir/hellfire/pkg/onboarding"
+	"github.com/thesabbir/hellfire/pkg/transaction"
+	"github.com/thesabbir/hellfire/pkg/uci"
+)
+
+// defaultOnboardingConfirmTimeout is how long the initial network setup
+// waits for confirmation before rolling back: moving the LAN can cut off
+// the browser running the wizard
+const defaultOnboardingConfirmTimeout = 120
+
+// OnboardingNetworkResponse is what the setup wizard starts from
+type OnboardingNetworkResponse struct {
+	Interfaces      []netdetect.Interface `json:"interfaces"`
+	Proposal        onboarding.Setup      `json:"proposal"`
+	WirelessApplied bool                  `json:"wireless_applied"` // Whether a Wi-Fi setup takes effect
+}
+
+// OnboardingNetworkRequest is the network setup chosen in the wizard
+type OnboardingNetworkRequest struct {
+	onboarding.Setup
+	ConfirmTimeout int `json:"confirm_timeout"` // Seconds, default 120
+}
+
+// OnboardingPreviewResponse is the configs a setup would commit
+type OnboardingPreviewResponse struct {
+	Configs map[string]string `json:"configs"` // Name to UCI text
+	Notes   []string          `json:"notes,omitempty"`
+}
+
+// onboardingNetworkHandler godoc
+// @Summary Detect interfaces for the network setup
+// @Description Detect the network interfaces and propose a WAN/LAN assignment, LAN subnet, DHCP pool and Wi-Fi network for the setup wizard
+// @Tags system
+// @Produce json
+// @Success 200 {object} OnboardingNetworkResponse
+// @Failure 403 {object} map[string]string
+// @Failure 500 {object} map[string]string
+// @Router /onboarding/network [get]
+// @Security BearerAuth
+func onboardingNetworkHandler(txMgr *transaction.Manager) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		interfaces, err := netdetect.Detect()
+		if err != nil {
+			apierrors.InternalServerError(c, err)
+			return
+		}
+
+		c.JSON(http.StatusOK, OnboardingNetworkResponse{
+			Interfaces:      interfaces,
+			Proposal:        onboarding.Propose(interfaces),
+			WirelessApplied: wirelessApplied(txMgr),
+		})
+	}
+}
+
+// onboardingPreviewHandler godoc
+// @Summary Preview the network setup
+// @Description Validate a network setup and return the configs it would commit, without staging them
+// @Tags system
+// @Accept json
+// @Produce json
+// @Param request body OnboardingNetworkRequest true "Network setup"
+// @Success 200 {object} OnboardingPreviewResponse
+// @Failure 400 {object} map[string]string
+// @Failure 403 {object} map[string]string
+// @Router /onboarding/network/preview [post]
+// @Security BearerAuth
+func onboardingPreviewHandler(manager *config.Manager, txMgr *transaction.Manager) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var req OnboardingNetworkRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			apierrors.BadRequest(c, err)
+			return
+		}
+
+		configs, changed, err := buildOnboardingConfigs(manager, &req.Setup)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		response := OnboardingPreviewResponse{Configs: make(map[string]string), Notes: onboardingNotes(&req.Setup, txMgr)}
+		for _, name := range changed {
+			var buf bytes.Buffer
+			if err := uci.Write(&buf, configs[name]); err != nil {
+				apierrors.InternalServerError(c, err)
+				return
+			}
+			response.Configs[name] = buf.String()
+		}
+		c.JSON(http.StatusOK, response)
+	}
+}
+
+// onboardingApplyHandler godoc
+// @Summary Apply the network setup
+// @Description Stage and commit the configs of a network setup as one transaction. The commit always waits for confirmation (POST /config/confirm), so a setup that cuts off the wizard rolls back.
+// @Tags system
+// @Accept json
+// @Produce json
+// @Param request body OnboardingNetworkRequest true "Network setup"
+// @Success 200 {object} map[string]interface{}
+// @Failure 400 {object} map[string]string
+// @Failure 403 {object} map[string]string
+// @Failure 409 {object} map[string]string
+// @Failure 500 {object} map[string]string
+// @Router /onboarding/network [post]
+// @Security BearerAuth
+func onboardingApplyHandler(manager *config.Manager, txMgr *transaction.Manager) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var req OnboardingNetworkRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			apierrors.BadRequest(c, err)
+			return
+		}
+		if req.ConfirmTimeout < 0 {
+			apierrors.BadRequest(c, fmt.Errorf("confirm_timeout must not be negative"))
+			return
+		}
+		if req.ConfirmTimeout == 0 {
+			req.ConfirmTimeout = defaultOnboardingConfirmTimeout
+		}
+
+		// The setup is committed on its own, not with someone's pending edits
+		if manager.HasChanges() {
+			c.JSON(http.StatusConflict, gin.H{"error": "there are uncommitted changes; commit or revert them first"})
+			return
+		}
+
+		configs, changed, err := buildOnboardingConfigs(manager, &req.Setup)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		for _, name := range changed {
+			if err := manager.Stage(name, configs[name]); err != nil {
+				_ = manager.Revert()
+				apierrors.OperationFailed(c, err)
+				return
+			}
+		}
+
+		message := fmt.Sprintf("Initial network setup: WAN %s, LAN %s", req.WAN.Interface, req.LAN.Interface)
+		if err := txMgr.Commit(middleware.AuditContext(c), message, time.Duration(req.ConfirmTimeout)*time.Second, 0); err != nil {
+			_ = manager.Revert()
+			audit.LogUserAction(middleware.AuditContext(c), audit.ActionConfigCommit, audit.StatusFailure, "onboarding",
+				"Failed to commit initial network setup", nil, err)
+			commitFailed(c, err)
+			return
+		}
+
+		audit.LogUserAction(middleware.AuditContext(c), audit.ActionConfigCommit, audit.StatusSuccess, "onboarding",
+			fmt.Sprintf("Committed initial network setup: %v", changed), nil, nil)
+
+		handlerErr := bus.PublishSync(bus.Event{
+			Type: bus.EventConfigCommitted,
+			Data: changed,
+		})
+
+		response := gin.H{
+			"message":         "changes applied, confirmation required",
+			"configs":         changed,
+			"state":           txMgr.GetState(),
+			"confirm_timeout": req.ConfirmTimeout,
+		}
+		if handlerErr != nil {
+			response["handler_errors"] = handlerErr.Error()
+		}
+		if notes := onboardingNotes(&req.Setup, txMgr); len(notes) > 0 {
+			response["notes"] = notes
+		}
+		c.JSON(http.StatusOK, response)
+	}
+}
+
+// buildOnboardingConfigs validates a setup against the detected interfaces
+// and applies it to copies of the current configs
+func buildOnboardingConfigs(manager *config.Manager, setup *onboarding.Setup) (map[string]*uci.Config, []string, error) {
+	interfaces, err := netdetect.Detect()
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to detect interfaces: %w", err)
+	}
+	if err := setup.Validate(interfaces); err != nil {
+		return nil, nil, err
+	}
+
+	configs := make(map[string]*uci.Config)
+	for _, name := range onboarding.Configs {
+		cfg, err := manager.Load(name)
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to load config %s: %w", name, err)
+		}
+		configs[name] = cfg.Clone()
+	}
+	return configs, setup.Apply(configs), nil
+}
+
+func onboardingNotes(setup *onboarding.Setup, txMgr *transaction.Manager) []string {
+	if setup.WiFi != nil && !wirelessApplied(txMgr) {
+		return []string{"the wireless config is saved but not applied: this system has no wireless applier"}
+	}
+	return nil
+}
+
+// wirelessApplied reports whether an applier takes the wireless config
+func wirelessApplied(txMgr *transaction.Manager) bool {
+	return slices.Contains(txMgr.RegisteredAppliers(), "wireless")
+}
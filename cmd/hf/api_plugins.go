@@ -0,0 +1,114 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"os"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/thesabbir/hellfire/pkg/audit"
+	"github.com/thesabbir/hellfire/pkg/auth"
+	apierrors "github.com/thesabbir/hellfire/pkg/errors"
+	"github.com/thesabbir/hellfire/pkg/middleware"
+	"github.com/thesabbir/hellfire/pkg/plugin"
+)
+
+// RegisterPluginRequest is the request body for registering a plugin command
+type RegisterPluginRequest struct {
+	Path        string `json:"path" binding:"required" example:"/usr/lib/hellfire-vpnd/hf-vpn"` // Absolute path of the executable, modifiable by its owner only
+	Description string `json:"description" example:"Manage the VPN daemon"`                     // One line for hf --help
+}
+
+// listPluginsHandler godoc
+// @Summary List plugin commands
+// @Description List the plugin commands hf runs as its subcommands: those registered through the API and the hf-<name> executables on the server's PATH. Authenticated with an admin API key.
+// @Tags plugins
+// @Produce json
+// @Success 200 {object} map[string][]plugin.Command
+// @Failure 500 {object} map[string]string
+// @Security APIKeyAuth
+// @Router /plugins [get]
+func listPluginsHandler(c *gin.Context) {
+	registered, err := pluginRegistry.List()
+	if err != nil {
+		apierrors.OperationFailed(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"plugins": plugin.Merge(registered, plugin.Discover(os.Getenv("PATH")))})
+}
+
+// registerPluginHandler godoc
+// @Summary Register plugin command
+// @Description Register an executable as the hf subcommand of a name, replacing the one registered before, for an add-on daemon to ship its commands. A built-in command of the same name hides it. Authenticated with an admin API key.
+// @Tags plugins
+// @Accept json
+// @Produce json
+// @Param name path string true "Command name"
+// @Param request body RegisterPluginRequest true "Executable to run"
+// @Success 200 {object} plugin.Command
+// @Failure 400 {object} map[string]string
+// @Security APIKeyAuth
+// @Router /plugins/{name} [put]
+func registerPluginHandler(c *gin.Context) {
+	var req RegisterPluginRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		apierrors.BadRequest(c, err)
+		return
+	}
+
+	name := c.Param("name")
+	command := plugin.Command{Name: name, Path: req.Path, Description: req.Description}
+	if user := auth.GetUser(c); user != nil {
+		command.RegisteredBy = user.Username
+	}
+	if err := pluginRegistry.Register(command); err != nil {
+		audit.LogUserAction(middleware.AuditContext(c), audit.ActionPluginRegister, audit.StatusFailure, "plugin:"+name,
+			"Failed to register plugin command", nil, err)
+		apierrors.BadRequest(c, err)
+		return
+	}
+
+	audit.LogUserAction(middleware.AuditContext(c), audit.ActionPluginRegister, audit.StatusSuccess, "plugin:"+name,
+		fmt.Sprintf("Plugin command '%s' registered as %s", name, req.Path), nil, nil)
+
+	registered, err := pluginRegistry.List()
+	if err != nil {
+		apierrors.OperationFailed(c, err)
+		return
+	}
+	for _, command := range registered {
+		if command.Name == name {
+			c.JSON(http.StatusOK, command)
+			return
+		}
+	}
+	apierrors.OperationFailed(c, fmt.Errorf("plugin command %q was not registered", name))
+}
+
+// unregisterPluginHandler godoc
+// @Summary Unregister plugin command
+// @Description Remove a plugin command registered through the API. Authenticated with an admin API key.
+// @Tags plugins
+// @Produce json
+// @Param name path string true "Command name"
+// @Success 200 {object} map[string]string
+// @Failure 404 {object} map[string]string
+// @Security APIKeyAuth
+// @Router /plugins/{name} [delete]
+func unregisterPluginHandler(c *gin.Context) {
+	name := c.Param("name")
+	found, err := pluginRegistry.Unregister(name)
+	if err != nil {
+		apierrors.OperationFailed(c, err)
+		return
+	}
+	if !found {
+		apierrors.NotFound(c, fmt.Errorf("plugin command %q is not registered", name))
+		return
+	}
+
+	audit.LogUserAction(middleware.AuditContext(c), audit.ActionPluginUnregister, audit.StatusSuccess, "plugin:"+name,
+		fmt.Sprintf("Plugin command '%s' unregistered", name), nil, nil)
+	c.JSON(http.StatusOK, gin.H{"message": fmt.Sprintf("Plugin command '%s' unregistered", name)})
+}
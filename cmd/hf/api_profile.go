@@ -0,0 +1,130 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/thesabbir/hellfire/pkg/audit"
+	"github.com/thesabbir/hellfire/pkg/auth"
+	"github.com/thesabbir/hellfire/pkg/db"
+	apierrors "github.com/thesabbir/hellfire/pkg/errors"
+	"github.com/thesabbir/hellfire/pkg/middleware"
+)
+
+type changePasswordRequest struct {
+	CurrentPassword string `json:"current_password" binding:"required"`
+	NewPassword     string `json:"new_password" binding:"required"`
+}
+
+type updateProfileRequest struct {
+	Email string `json:"email" binding:"required,email"`
+}
+
+// changePasswordHandler godoc
+// @Summary Change own password
+// @Description Change the current user's password. The current password is required, the new one must meet the password policy, and all other sessions of the user are logged out.
+// @Tags auth
+// @Accept json
+// @Produce json
+// @Param request body changePasswordRequest true "Current and new password"
+// @Success 200 {object} map[string]string
+// @Failure 400 {object} map[string]string
+// @Failure 401 {object} map[string]string
+// @Failure 403 {object} map[string]string
+// @Router /auth/me/password [put]
+// @Security BearerAuth
+func changePasswordHandler(c *gin.Context) {
+	user := auth.GetUser(c)
+	if user == nil {
+		apierrors.Unauthorized(c, fmt.Errorf("not authenticated"))
+		return
+	}
+	if auth.GetImpersonator(c) != nil {
+		apierrors.Forbidden(c, fmt.Errorf("impersonated sessions can't change the password"))
+		return
+	}
+
+	var req changePasswordRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		apierrors.BadRequest(c, err)
+		return
+	}
+
+	// Enforce the password policy (the message says what's missing, not secret)
+	if err := auth.ValidatePasswordStrength(req.NewPassword); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	// API key requests have no session to keep
+	var keepToken string
+	if session := auth.GetSession(c); session != nil {
+		keepToken = session.Token
+	}
+
+	resource := fmt.Sprintf("user:%d", user.ID)
+	if err := auth.ChangePassword(user, req.CurrentPassword, req.NewPassword, keepToken); err != nil {
+		audit.LogUserAction(middleware.AuditContext(c), audit.ActionUserUpdate, audit.StatusFailure,
+			resource, "Failed to change own password", nil, err)
+		if errors.Is(err, auth.ErrWrongPassword) || errors.Is(err, auth.ErrPasswordUnchanged) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		apierrors.OperationFailed(c, err)
+		return
+	}
+
+	audit.LogUserAction(middleware.AuditContext(c), audit.ActionUserUpdate, audit.StatusSuccess,
+		resource, fmt.Sprintf("Password changed from %s, other sessions logged out", c.ClientIP()), nil, nil)
+
+	c.JSON(http.StatusOK, gin.H{"message": "password changed, other sessions logged out"})
+}
+
+// updateProfileHandler godoc
+// @Summary Update own profile
+// @Description Update the current user's profile (email address)
+// @Tags auth
+// @Accept json
+// @Produce json
+// @Param request body updateProfileRequest true "Profile fields"
+// @Success 200 {object} db.User
+// @Failure 400 {object} map[string]string
+// @Failure 401 {object} map[string]string
+// @Failure 403 {object} map[string]string
+// @Router /auth/me [put]
+// @Security BearerAuth
+func updateProfileHandler(c *gin.Context) {
+	user := auth.GetUser(c)
+	if user == nil {
+		apierrors.Unauthorized(c, fmt.Errorf("not authenticated"))
+		return
+	}
+	if auth.GetImpersonator(c) != nil {
+		apierrors.Forbidden(c, fmt.Errorf("impersonated sessions can't change the profile"))
+		return
+	}
+
+	var req updateProfileRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		apierrors.BadRequest(c, err)
+		return
+	}
+
+	resource := fmt.Sprintf("user:%d", user.ID)
+	previous := user.Email
+	user.Email = req.Email
+	if err := db.UpdateUser(user); err != nil {
+		audit.LogUserAction(middleware.AuditContext(c), audit.ActionUserUpdate, audit.StatusFailure,
+			resource, "Failed to update own profile", nil, err)
+		apierrors.OperationFailed(c, err)
+		return
+	}
+
+	audit.LogUserAction(middleware.AuditContext(c), audit.ActionUserUpdate, audit.StatusSuccess,
+		resource, "Profile updated", gin.H{"email": gin.H{"from": previous, "to": user.Email}}, nil)
+
+	c.JSON(http.StatusOK, user)
+}
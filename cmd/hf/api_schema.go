@@ -0,0 +1,37 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/thesabbir/hellfire/pkg/config"
+	apierrors "github.com/thesabbir/hellfire/pkg/errors"
+)
+
+// ConfigSchemaResponse is the schema of a config's options, for forms
+type ConfigSchemaResponse struct {
+	Config   string               `json:"config" example:"firewall"`
+	Sections []config.SectionInfo `json:"sections"`
+}
+
+// configSchemaHandler godoc
+// @Summary Get the schema of a config
+// @Description Describe the options of a config the schema knows by section type: their type (bool, enum or string), allowed values, default and help text, and whether they are secret. Setting an option validates it against the same schema, so forms built from it accept what the API does. Options not listed take any value.
+// @Tags config
+// @Produce json
+// @Param config path string true "Configuration name"
+// @Success 200 {object} ConfigSchemaResponse
+// @Failure 404 {object} map[string]string
+// @Router /schema/{config} [get]
+// @Security BearerAuth
+func configSchemaHandler(c *gin.Context) {
+	name := c.Param("config")
+	sections := config.ConfigSchema(name)
+	if sections == nil {
+		apierrors.NotFound(c, fmt.Errorf("no schema for config %q", name))
+		return
+	}
+	c.JSON(http.StatusOK, ConfigSchemaResponse{Config: name, Sections: sections})
+}
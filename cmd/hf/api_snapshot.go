@@ -0,0 +1,177 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/thesabbir/hellfire/pkg/audit"
+	"github.com/thesabbir/hellfire/pkg/config"
+	apierrors "github.com/thesabbir/hellfire/pkg/errors"
+	"github.com/thesabbir/hellfire/pkg/middleware"
+	"github.com/thesabbir/hellfire/pkg/snapshot"
+	"github.com/thesabbir/hellfire/pkg/transaction"
+)
+
+// listSnapshotsHandler godoc
+// @Summary List snapshots
+// @Description List all configuration snapshots, newest first
+// @Tags snapshots
+// @Produce json
+// @Success 200 {object} map[string]interface{}
+// @Failure 500 {object} map[string]string
+// @Router /snapshots [get]
+// @Security BearerAuth
+func listSnapshotsHandler(snapshotMgr *snapshot.Manager) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		snapshots, err := snapshotMgr.List()
+		if err != nil {
+			apierrors.OperationFailed(c, err)
+			return
+		}
+
+		result := make([]snapshot.Metadata, 0, len(snapshots))
+		for _, snap := range snapshots {
+			result = append(result, snap.Metadata)
+		}
+
+		c.JSON(http.StatusOK, gin.H{
+			"snapshots": result,
+			"count":     len(result),
+		})
+	}
+}
+
+// stageSnapshotHandler godoc
+// @Summary Stage snapshot contents
+// @Description Stage the configs stored in a snapshot so they can be reviewed and committed
+// @Tags snapshots
+// @Produce json
+// @Param id path string true "Snapshot ID"
+// @Success 200 {object} map[string]interface{}
+// @Failure 500 {object} map[string]string
+// @Router /snapshots/{id}/stage [post]
+// @Security BearerAuth
+func stageSnapshotHandler(manager *config.Manager, snapshotMgr *snapshot.Manager) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		id := c.Param("id")
+
+		configs, err := snapshotMgr.ReadConfigs(id)
+		if err != nil {
+			audit.LogUserAction(middleware.AuditContext(c), audit.ActionSnapshotRestore, audit.StatusFailure, id,
+				fmt.Sprintf("Failed to stage snapshot %s", id), nil, err)
+
+			apierrors.OperationFailed(c, err)
+			return
+		}
+
+		staged := make([]string, 0, len(configs))
+		for name, cfg := range configs {
+			if err := manager.Stage(name, cfg); err != nil {
+				apierrors.OperationFailed(c, err)
+				return
+			}
+			staged = append(staged, name)
+		}
+
+		audit.LogUserAction(middleware.AuditContext(c), audit.ActionSnapshotRestore, audit.StatusSuccess, id,
+			fmt.Sprintf("Staged snapshot %s: %v", id, staged), nil, nil)
+
+		c.JSON(http.StatusOK, gin.H{
+			"message":  "snapshot staged, commit to apply",
+			"snapshot": id,
+			"configs":  staged,
+		})
+	}
+}
+
+// rollbackSnapshotHandler godoc
+// @Summary Rollback to snapshot
+// @Description Restore and re-apply a snapshot as a new transaction, recorded with the reason for the rollback
+// @Tags snapshots
+// @Accept json
+// @Produce json
+// @Param id path string true "Snapshot ID"
+// @Param request body RollbackRequest false "Rollback reason"
+// @Success 200 {object} map[string]string
+// @Failure 400 {object} map[string]string "A reason is required"
+// @Failure 500 {object} map[string]string
+// @Router /snapshots/{id}/rollback [post]
+// @Security BearerAuth
+func rollbackSnapshotHandler(txMgr *transaction.Manager) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		id := c.Param("id")
+
+		ctx, ok := rollbackContext(c)
+		if !ok {
+			return
+		}
+		if err := txMgr.Rollback(ctx, id); err != nil {
+			if errors.Is(err, transaction.ErrRollbackReasonRequired) {
+				apierrors.BadRequest(c, err)
+				return
+			}
+			audit.LogUserAction(middleware.AuditContext(c), audit.ActionSnapshotRestore, audit.StatusFailure, id,
+				fmt.Sprintf("Failed to rollback to snapshot %s", id), nil, err)
+
+			apierrors.OperationFailed(c, err)
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{
+			"message":  "snapshot restored and applied",
+			"snapshot": id,
+		})
+	}
+}
+
+// protectSnapshotHandler godoc
+// @Summary Protect snapshot
+// @Description Protect a snapshot from automatic pruning, by count or by the quota of the snapshot directory
+// @Tags snapshots
+// @Produce json
+// @Param id path string true "Snapshot ID"
+// @Success 200 {object} snapshot.Metadata
+// @Failure 404 {object} map[string]string
+// @Router /snapshots/{id}/protect [post]
+// @Security BearerAuth
+func protectSnapshotHandler(snapshotMgr *snapshot.Manager) gin.HandlerFunc {
+	return setSnapshotProtection(snapshotMgr, true)
+}
+
+// unprotectSnapshotHandler godoc
+// @Summary Unprotect snapshot
+// @Description Let a protected snapshot be pruned automatically again
+// @Tags snapshots
+// @Produce json
+// @Param id path string true "Snapshot ID"
+// @Success 200 {object} snapshot.Metadata
+// @Failure 404 {object} map[string]string
+// @Router /snapshots/{id}/protect [delete]
+// @Security BearerAuth
+func unprotectSnapshotHandler(snapshotMgr *snapshot.Manager) gin.HandlerFunc {
+	return setSnapshotProtection(snapshotMgr, false)
+}
+
+func setSnapshotProtection(snapshotMgr *snapshot.Manager, protected bool) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		id := c.Param("id")
+		if _, err := snapshotMgr.Load(id); err != nil {
+			apierrors.NotFound(c, err)
+			return
+		}
+		snap, err := snapshotMgr.Protect(id, protected)
+		if err != nil {
+			apierrors.OperationFailed(c, err)
+			return
+		}
+
+		action, details := audit.ActionSnapshotProtect, fmt.Sprintf("Protected snapshot %s", id)
+		if !protected {
+			action, details = audit.ActionSnapshotUnprotect, fmt.Sprintf("Unprotected snapshot %s", id)
+		}
+		audit.LogUserAction(middleware.AuditContext(c), action, audit.StatusSuccess, id, details, nil, nil)
+		c.JSON(http.StatusOK, snap.Metadata)
+	}
+}
@@ -0,0 +1,127 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/thesabbir/hellfire/pkg/audit"
+	"github.com/thesabbir/hellfire/pkg/db"
+	apierrors "github.com/thesabbir/hellfire/pkg/errors"
+)
+
+const (
+	defaultStatsDays = 30
+	maxStatsDays     = 366
+)
+
+// DailyTransactions counts the transactions of a day by status
+type DailyTransactions struct {
+	Day    string           `json:"day" example:"2025-01-31"` // UTC
+	Total  int64            `json:"total"`
+	Status map[string]int64 `json:"status"`
+}
+
+// ActionCounts counts the audit entries of an action by outcome
+type ActionCounts struct {
+	Success int64 `json:"success"`
+	Failure int64 `json:"failure"`
+}
+
+// StatsSummary is the activity of the last days, for dashboards
+type StatsSummary struct {
+	From         time.Time               `json:"from"` // Start of the first day (UTC)
+	Days         int                     `json:"days"`
+	Transactions []DailyTransactions     `json:"transactions"` // Every day, oldest first
+	TxStatus     map[string]int64        `json:"transaction_status"`
+	AuditActions map[string]ActionCounts `json:"audit_actions"`
+	FailedLogins int64                   `json:"failed_logins"`
+	Rollbacks    int64                   `json:"rollbacks"`
+}
+
+// statsSummaryHandler godoc
+// @Summary Get activity summary
+// @Description Count the transactions of each day by status, the audit entries by action and outcome, failed logins and rollbacks over the last days, for an activity dashboard
+// @Tags system
+// @Produce json
+// @Param days query int false "Days to cover, including today (default 30, maximum 366)"
+// @Success 200 {object} StatsSummary
+// @Failure 400 {object} map[string]string
+// @Failure 500 {object} map[string]string
+// @Router /stats/summary [get]
+// @Security BearerAuth
+func statsSummaryHandler(c *gin.Context) {
+	days := defaultStatsDays
+	if value := c.Query("days"); value != "" {
+		n, err := strconv.Atoi(value)
+		if err != nil || n < 1 || n > maxStatsDays {
+			apierrors.BadRequest(c, fmt.Errorf("invalid days: %s (must be 1-%d)", value, maxStatsDays))
+			return
+		}
+		days = n
+	}
+
+	summary, err := statsSummary(time.Now(), days)
+	if err != nil {
+		apierrors.OperationFailed(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, summary)
+}
+
+// statsSummary aggregates the activity of the days up to and including now
+func statsSummary(now time.Time, days int) (*StatsSummary, error) {
+	now = now.UTC()
+	from := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, time.UTC).AddDate(0, 0, 1-days)
+
+	summary := &StatsSummary{
+		From:         from,
+		Days:         days,
+		Transactions: make([]DailyTransactions, days),
+		TxStatus:     make(map[string]int64),
+		AuditActions: make(map[string]ActionCounts),
+	}
+	index := make(map[string]int, days)
+	for i := range summary.Transactions {
+		day := from.AddDate(0, 0, i).Format(time.DateOnly)
+		summary.Transactions[i] = DailyTransactions{Day: day, Status: make(map[string]int64)}
+		index[day] = i
+	}
+
+	txCounts, err := db.CountTransactionsByDay(from)
+	if err != nil {
+		return nil, fmt.Errorf("failed to count transactions: %w", err)
+	}
+	for _, count := range txCounts {
+		if i, ok := index[count.Key]; ok {
+			summary.Transactions[i].Status[count.Status] += count.Count
+			summary.Transactions[i].Total += count.Count
+		}
+		summary.TxStatus[count.Status] += count.Count
+	}
+
+	auditCounts, err := db.CountAuditActions(from)
+	if err != nil {
+		return nil, fmt.Errorf("failed to count audit entries: %w", err)
+	}
+	for _, count := range auditCounts {
+		counts := summary.AuditActions[count.Key]
+		switch audit.Status(count.Status) {
+		case audit.StatusSuccess:
+			counts.Success += count.Count
+		case audit.StatusFailure:
+			counts.Failure += count.Count
+		}
+		summary.AuditActions[count.Key] = counts
+	}
+	summary.FailedLogins = summary.AuditActions[string(audit.ActionUserLogin)].Failure
+
+	if summary.Rollbacks, err = db.CountRollbacks(from); err != nil {
+		return nil, fmt.Errorf("failed to count rollbacks: %w", err)
+	}
+
+	return summary, nil
+}
@@ -0,0 +1,293 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"path/filepath"
+
+	"github.com/gin-gonic/gin"
+	"github.com/thesabbir/hellfire/pkg/appliers"
+	"github.com/thesabbir/hellfire/pkg/audit"
+	"github.com/thesabbir/hellfire/pkg/bus"
+	"github.com/thesabbir/hellfire/pkg/config"
+	apierrors "github.com/thesabbir/hellfire/pkg/errors"
+	"github.com/thesabbir/hellfire/pkg/hfconfig"
+	"github.com/thesabbir/hellfire/pkg/hotplug"
+	"github.com/thesabbir/hellfire/pkg/logger"
+	"github.com/thesabbir/hellfire/pkg/middleware"
+	"github.com/thesabbir/hellfire/pkg/snapshot"
+	"github.com/thesabbir/hellfire/pkg/transaction"
+	"github.com/thesabbir/hellfire/pkg/util"
+)
+
+// LinksResponse is the state of the network links and their recent events
+type LinksResponse struct {
+	Enabled bool            `json:"enabled"` // Links are watched (not on a simulated system)
+	Links   []hotplug.Link  `json:"links"`
+	Events  []hotplug.Event `json:"events"` // Newest first
+}
+
+// StorageResponse is the disk usage of the snapshots and of the file
+// systems holding the configs and the database
+type StorageResponse struct {
+	Snapshots   *snapshot.Storage `json:"snapshots"`
+	FileSystems []FileSystemUsage `json:"filesystems"`
+}
+
+// FileSystemUsage is the size and free space of a file system, in bytes
+type FileSystemUsage struct {
+	Path      string `json:"path"` // Directory on the file system
+	Total     uint64 `json:"total"`
+	Available uint64 `json:"available"`
+}
+
+// ApplyOrderRequest represents the request body for updating the apply order
+type ApplyOrderRequest struct {
+	Order []string `json:"order" binding:"required" example:"network,firewall,dhcp"`
+	Skip  []string `json:"skip" example:"hellfire"`
+}
+
+// getApplyOrderHandler godoc
+// @Summary Get apply order
+// @Description Get the order in which changed configs are applied, the skip-list, and registered appliers
+// @Tags system
+// @Produce json
+// @Success 200 {object} map[string]interface{}
+// @Failure 403 {object} map[string]string
+// @Router /system/apply-order [get]
+// @Security BearerAuth
+func getApplyOrderHandler(txMgr *transaction.Manager) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		order, skip := txMgr.GetApplyOrder()
+
+		c.JSON(http.StatusOK, gin.H{
+			"order":    order,
+			"skip":     skip,
+			"appliers": txMgr.RegisteredAppliers(),
+		})
+	}
+}
+
+// setApplyOrderHandler godoc
+// @Summary Update apply order
+// @Description Update the apply order and skip-list and persist them to the Hellfire config
+// @Tags system
+// @Accept json
+// @Produce json
+// @Param request body ApplyOrderRequest true "Apply order"
+// @Success 200 {object} map[string]interface{}
+// @Failure 400 {object} map[string]string
+// @Failure 403 {object} map[string]string
+// @Router /system/apply-order [put]
+// @Security BearerAuth
+func setApplyOrderHandler(txMgr *transaction.Manager) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var req ApplyOrderRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			apierrors.BadRequest(c, err)
+			return
+		}
+
+		txCfg := hfconfig.TransactionConfig{
+			ApplyOrder: req.Order,
+			SkipApply:  req.Skip,
+		}
+
+		if err := validateApplyOrder(txCfg); err != nil {
+			apierrors.ValidationError(c, err)
+			return
+		}
+
+		// Persist first so a restart keeps the new order
+		if err := hfconfig.SaveTransactionConfig("", txCfg); err != nil {
+			audit.LogUserAction(middleware.AuditContext(c), audit.ActionConfigWrite, audit.StatusFailure, "hellfire",
+				"Failed to update apply order", nil, err)
+
+			apierrors.OperationFailed(c, err)
+			return
+		}
+
+		txMgr.SetApplyOrder(txCfg.ApplyOrder)
+		txMgr.SetSkipApply(txCfg.SkipApply)
+
+		// Warn about configs in the order that nothing can apply
+		registered := make(map[string]bool)
+		for _, name := range txMgr.RegisteredAppliers() {
+			registered[name] = true
+		}
+
+		unregistered := []string{}
+		for _, name := range txCfg.ApplyOrder {
+			if !registered[name] {
+				unregistered = append(unregistered, name)
+				logger.Warn("Apply order references config without a registered applier", "config", name)
+			}
+		}
+
+		audit.LogUserAction(middleware.AuditContext(c), audit.ActionConfigWrite, audit.StatusSuccess, "hellfire",
+			fmt.Sprintf("Updated apply order: %v (skip: %v)", txCfg.ApplyOrder, txCfg.SkipApply), nil, nil)
+
+		c.JSON(http.StatusOK, gin.H{
+			"order":        txCfg.ApplyOrder,
+			"skip":         txCfg.SkipApply,
+			"unregistered": unregistered,
+		})
+	}
+}
+
+// validateApplyOrder validates config names in the apply order and skip-list
+func validateApplyOrder(txCfg hfconfig.TransactionConfig) error {
+	if len(txCfg.ApplyOrder) == 0 {
+		return fmt.Errorf("apply order cannot be empty")
+	}
+
+	for _, name := range append(append([]string{}, txCfg.ApplyOrder...), txCfg.SkipApply...) {
+		if !config.ValidName(name) {
+			return fmt.Errorf("invalid config name: %q", name)
+		}
+	}
+
+	cfg := hfconfig.DefaultConfig()
+	cfg.Transaction = txCfg
+	return cfg.Validate()
+}
+
+// eventStatsHandler godoc
+// @Summary Get event bus statistics
+// @Description Get published, delivered, dropped and spooled event counts for each event handler
+// @Tags system
+// @Produce json
+// @Success 200 {object} bus.Stats
+// @Failure 403 {object} map[string]string
+// @Router /system/events [get]
+// @Security BearerAuth
+func eventStatsHandler(c *gin.Context) {
+	c.JSON(http.StatusOK, bus.GetStats())
+}
+
+// degradedHandler godoc
+// @Summary Get safe mode status
+// @Description Report whether a failed rollback put the system in safe mode, why, and whether the recovery network was applied
+// @Tags system
+// @Produce json
+// @Success 200 {object} map[string]interface{}
+// @Failure 403 {object} map[string]string
+// @Router /system/degraded [get]
+// @Security BearerAuth
+func degradedHandler(txMgr *transaction.Manager) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		status := txMgr.Degraded()
+		c.JSON(http.StatusOK, gin.H{
+			"degraded": status != nil,
+			"status":   status,
+		})
+	}
+}
+
+// clearDegradedHandler godoc
+// @Summary Leave safe mode
+// @Description Clear the degraded flag once a working configuration has been restored
+// @Tags system
+// @Produce json
+// @Success 200 {object} map[string]string
+// @Failure 403 {object} map[string]string
+// @Failure 409 {object} map[string]string
+// @Router /system/degraded [delete]
+// @Security BearerAuth
+func clearDegradedHandler(txMgr *transaction.Manager) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if err := txMgr.ClearDegraded(middleware.AuditContext(c)); err != nil {
+			c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{"message": "degraded flag cleared"})
+	}
+}
+
+// dhcpClientsHandler godoc
+// @Summary Get DHCP client status
+// @Description List the supervised DHCP clients with their process state, current lease and interface addresses
+// @Tags system
+// @Produce json
+// @Success 200 {object} map[string]interface{}
+// @Failure 403 {object} map[string]string
+// @Router /system/dhcp-clients [get]
+// @Security BearerAuth
+func dhcpClientsHandler(c *gin.Context) {
+	c.JSON(http.StatusOK, gin.H{
+		"clients": appliers.DHCPClients.Status(c.Request.Context()),
+	})
+}
+
+// quotasHandler godoc
+// @Summary Get bandwidth quota status
+// @Description List the per-device quotas with their usage in the current period, next reset and whether they are enforced
+// @Tags system
+// @Produce json
+// @Success 200 {object} map[string]interface{}
+// @Failure 403 {object} map[string]string
+// @Failure 500 {object} map[string]string
+// @Router /system/quotas [get]
+// @Security BearerAuth
+func quotasHandler(c *gin.Context) {
+	quotas, err := appliers.Quotas.Status()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"quotas": quotas})
+}
+
+// linksHandler godoc
+// @Summary Get link state and events
+// @Description List the network links with their carrier state and the recent link events (interfaces appearing or disappearing, cables plugged in or unplugged), newest first
+// @Tags system
+// @Produce json
+// @Success 200 {object} LinksResponse
+// @Failure 403 {object} map[string]string
+// @Router /system/links [get]
+// @Security BearerAuth
+func linksHandler(c *gin.Context) {
+	if linkMonitor == nil {
+		c.JSON(http.StatusOK, LinksResponse{Links: []hotplug.Link{}, Events: []hotplug.Event{}})
+		return
+	}
+
+	c.JSON(http.StatusOK, LinksResponse{
+		Enabled: true,
+		Links:   linkMonitor.Links(),
+		Events:  linkMonitor.Events(),
+	})
+}
+
+// storageHandler godoc
+// @Summary Get disk usage
+// @Description Disk usage of the snapshots against their quota and the free space required to take one, and the size and free space of the file systems holding the configs, the database and the snapshots
+// @Tags system
+// @Produce json
+// @Success 200 {object} StorageResponse
+// @Failure 403 {object} map[string]string
+// @Failure 500 {object} map[string]string
+// @Router /system/storage [get]
+// @Security BearerAuth
+func storageHandler(snapshotMgr *snapshot.Manager) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		storage, err := snapshotMgr.Storage()
+		if err != nil {
+			apierrors.OperationFailed(c, err)
+			return
+		}
+
+		response := StorageResponse{Snapshots: storage, FileSystems: []FileSystemUsage{}}
+		for _, path := range []string{configDir, filepath.Dir(dbPath), storage.Dir} {
+			total, available, err := util.FileSystemUsage(path)
+			if err != nil {
+				continue // Not created yet
+			}
+			response.FileSystems = append(response.FileSystems, FileSystemUsage{Path: path, Total: total, Available: available})
+		}
+		c.JSON(http.StatusOK, response)
+	}
+}
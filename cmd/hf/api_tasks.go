@@ -0,0 +1,170 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/thesabbir/hellfire/pkg/db"
+	apierrors "github.com/thesabbir/hellfire/pkg/errors"
+	"github.com/thesabbir/hellfire/pkg/middleware"
+	"github.com/thesabbir/hellfire/pkg/tasks"
+	"github.com/thesabbir/hellfire/pkg/upgrade"
+)
+
+const (
+	defaultTaskRuns = 20
+	maxTaskRuns     = 500
+)
+
+// taskScheduler runs the scheduled tasks in the API server
+var taskScheduler *tasks.Scheduler
+
+// newTaskScheduler creates the scheduler for the tasks config with the
+// built-in actions
+func newTaskScheduler() *tasks.Scheduler {
+	return tasks.NewScheduler(filepath.Join(configDir, tasks.ConfigName), map[string]tasks.ActionFunc{
+		tasks.ActionReboot: func(ctx context.Context, task tasks.Task) (string, error) {
+			if simulate {
+				return "Not rebooting: the system is simulated\n", nil
+			}
+			output, err := exec.CommandContext(ctx, "systemctl", "reboot").CombinedOutput()
+			return string(output), err
+		},
+		tasks.ActionSnapshot: func(ctx context.Context, task tasks.Task) (string, error) {
+			entries, err := os.ReadDir(configDir)
+			if err != nil {
+				return "", fmt.Errorf("failed to list configs: %w", err)
+			}
+			var configs []string
+			for _, entry := range entries {
+				if entry.Type().IsRegular() && !strings.HasPrefix(entry.Name(), ".") {
+					configs = append(configs, entry.Name())
+				}
+			}
+
+			snap, err := snapshotMgr.Create("Scheduled task "+task.Name, configs)
+			if err != nil {
+				return "", err
+			}
+			return fmt.Sprintf("Created snapshot %s (%d configs)\n", snap.ID, len(snap.Metadata.Configs)), nil
+		},
+		tasks.ActionBackup: func(ctx context.Context, task tasks.Task) (string, error) {
+			path := filepath.Join(upgrade.DefaultBackupDir, "hellfire-"+time.Now().Format("20060102-150405")+".db")
+			if err := db.Backup(path); err != nil {
+				return "", err
+			}
+			return fmt.Sprintf("Backed up the database to %s\n", path), nil
+		},
+		tasks.ActionPruneSnapshots: func(ctx context.Context, task tasks.Task) (string, error) {
+			deleted, err := snapshotMgr.Prune(task.Keep)
+			if err != nil {
+				return "", err
+			}
+			return fmt.Sprintf("Deleted %d snapshots, kept the newest %d\n", len(deleted), task.Keep), nil
+		},
+	})
+}
+
+// listTasksHandler godoc
+// @Summary List scheduled tasks
+// @Description List the tasks of the tasks config with their schedules, next run and last run
+// @Tags tasks
+// @Produce json
+// @Success 200 {object} map[string]interface{}
+// @Failure 403 {object} map[string]string
+// @Failure 500 {object} map[string]string
+// @Failure 503 {object} map[string]string
+// @Router /tasks [get]
+// @Security BearerAuth
+func listTasksHandler(c *gin.Context) {
+	if taskScheduler == nil {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "task scheduler is not running"})
+		return
+	}
+
+	list, err := taskScheduler.Tasks()
+	if err != nil {
+		apierrors.OperationFailed(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"tasks": list})
+}
+
+// listTaskRunsHandler godoc
+// @Summary List the runs of a task
+// @Description List the recorded runs of a scheduled task with their status and output, newest first
+// @Tags tasks
+// @Produce json
+// @Param name path string true "Task name"
+// @Param limit query int false "Maximum number of runs (default 20, maximum 500)"
+// @Success 200 {object} map[string]interface{}
+// @Failure 400 {object} map[string]string
+// @Failure 403 {object} map[string]string
+// @Failure 500 {object} map[string]string
+// @Router /tasks/{name}/runs [get]
+// @Security BearerAuth
+func listTaskRunsHandler(c *gin.Context) {
+	limit := defaultTaskRuns
+	if value := c.Query("limit"); value != "" {
+		n, err := strconv.Atoi(value)
+		if err != nil || n < 1 {
+			apierrors.BadRequest(c, fmt.Errorf("invalid limit: %s", value))
+			return
+		}
+		limit = min(n, maxTaskRuns)
+	}
+
+	runs, err := db.ListTaskRuns(c.Param("name"), limit)
+	if err != nil {
+		apierrors.OperationFailed(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"runs": runs})
+}
+
+// runTaskHandler godoc
+// @Summary Run a task now
+// @Description Start a scheduled task now, whether or not it is enabled. The run is recorded and audited like scheduled runs.
+// @Tags tasks
+// @Produce json
+// @Param name path string true "Task name"
+// @Success 202 {object} map[string]string
+// @Failure 403 {object} map[string]string
+// @Failure 404 {object} map[string]string
+// @Failure 409 {object} map[string]string
+// @Failure 503 {object} map[string]string
+// @Router /tasks/{name}/run [post]
+// @Security BearerAuth
+func runTaskHandler(c *gin.Context) {
+	if taskScheduler == nil {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "task scheduler is not running"})
+		return
+	}
+
+	name := c.Param("name")
+	if err := taskScheduler.Run(middleware.AuditContext(c), name); err != nil {
+		switch {
+		case errors.Is(err, tasks.ErrNotFound):
+			apierrors.NotFound(c, err)
+		case errors.Is(err, tasks.ErrRunning):
+			c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+		default:
+			apierrors.OperationFailed(c, err)
+		}
+		return
+	}
+
+	c.JSON(http.StatusAccepted, gin.H{"message": "task started", "task": name})
+}
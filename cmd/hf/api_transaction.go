@@ -0,0 +1,180 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"sort"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+
+	"github.com/thesabbir/hellfire/pkg/audit"
+	"github.com/thesabbir/hellfire/pkg/db"
+	apierrors "github.com/thesabbir/hellfire/pkg/errors"
+	"github.com/thesabbir/hellfire/pkg/snapshot"
+)
+
+// TimelineEvent is a single entry in a transaction timeline
+type TimelineEvent struct {
+	Time     time.Time `json:"time"`
+	Source   string    `json:"source"` // "transaction", "snapshot" or "audit"
+	Action   string    `json:"action"`
+	Status   string    `json:"status,omitempty"`
+	Username string    `json:"username,omitempty"`
+	Message  string    `json:"message,omitempty"`
+	Error    string    `json:"error,omitempty"`
+}
+
+// TransactionTimeline is the chronological view of a transaction
+type TransactionTimeline struct {
+	Transaction *db.Transaction    `json:"transaction"`
+	Snapshot    *snapshot.Metadata `json:"snapshot,omitempty"`
+	Phases      map[string]int64   `json:"phases"`      // Phase name -> duration in ms
+	DurationMs  int64              `json:"duration_ms"` // From start until the transaction settled
+	Events      []TimelineEvent    `json:"events"`
+}
+
+// transactionTimelineHandler godoc
+// @Summary Get transaction timeline
+// @Description Merge a transaction record, its audit entries and snapshot metadata into a chronological timeline with per-phase durations
+// @Tags transactions
+// @Produce json
+// @Param txid path string true "Transaction ID"
+// @Success 200 {object} TransactionTimeline
+// @Failure 404 {object} map[string]string
+// @Failure 500 {object} map[string]string
+// @Router /transactions/{txid}/timeline [get]
+// @Security BearerAuth
+func transactionTimelineHandler(snapshotMgr *snapshot.Manager) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		txID := c.Param("txid")
+
+		tx, err := db.GetTransactionByID(txID)
+		if err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				apierrors.NotFound(c, err)
+				return
+			}
+			apierrors.OperationFailed(c, err)
+			return
+		}
+
+		timeline := TransactionTimeline{
+			Transaction: tx,
+			Phases:      make(map[string]int64),
+		}
+		if tx.Phases != "" {
+			_ = json.Unmarshal([]byte(tx.Phases), &timeline.Phases)
+		}
+
+		events := []TimelineEvent{{
+			Time:     tx.CreatedAt,
+			Source:   "transaction",
+			Action:   "transaction.created",
+			Username: tx.Username,
+			Message:  tx.Message,
+		}}
+
+		end := tx.UpdatedAt
+		if tx.ConfirmedAt != nil {
+			events = append(events, TimelineEvent{Time: *tx.ConfirmedAt, Source: "transaction", Action: "transaction.confirmed"})
+		}
+		if tx.CompletedAt != nil {
+			events = append(events, TimelineEvent{Time: *tx.CompletedAt, Source: "transaction", Action: "transaction.completed"})
+			end = *tx.CompletedAt
+		}
+		if tx.RolledBackAt != nil {
+			events = append(events, TimelineEvent{Time: *tx.RolledBackAt, Source: "transaction", Action: "transaction.rolledback", Message: tx.RollbackReason, Error: tx.Error})
+			end = *tx.RolledBackAt
+		}
+		timeline.DurationMs = end.Sub(tx.CreatedAt).Milliseconds()
+
+		logs, err := db.GetAuditLogsByTransaction(txID)
+		if err != nil {
+			apierrors.OperationFailed(c, err)
+			return
+		}
+
+		if tx.SnapshotID != "" {
+			// The snapshot may have been pruned since; the timeline is still useful without it
+			if snap, err := snapshotMgr.Load(tx.SnapshotID); err == nil {
+				timeline.Snapshot = &snap.Metadata
+				events = append(events, TimelineEvent{
+					Time:    snap.Metadata.Timestamp,
+					Source:  "snapshot",
+					Action:  "snapshot.created",
+					Message: snap.Metadata.Message,
+				})
+			}
+
+			snapshotLogs, _, err := db.ListAuditLogs(map[string]interface{}{
+				"action":   string(audit.ActionSnapshotCreate),
+				"resource": tx.SnapshotID,
+			}, 10, 0)
+			if err != nil {
+				apierrors.OperationFailed(c, err)
+				return
+			}
+			logs = append(logs, snapshotLogs...)
+		}
+
+		for _, log := range logs {
+			events = append(events, TimelineEvent{
+				Time:     log.CreatedAt,
+				Source:   "audit",
+				Action:   log.Action,
+				Status:   log.Status,
+				Username: log.Username,
+				Message:  log.Message,
+				Error:    log.Error,
+			})
+		}
+
+		sort.SliceStable(events, func(i, j int) bool {
+			return events[i].Time.Before(events[j].Time)
+		})
+		timeline.Events = events
+
+		c.JSON(http.StatusOK, timeline)
+	}
+}
+
+// TransactionArtifacts lists what a transaction pushed to the system
+type TransactionArtifacts struct {
+	TransactionID string                   `json:"transaction_id"`
+	Artifacts     []db.TransactionArtifact `json:"artifacts"`
+}
+
+// transactionArtifactsHandler godoc
+// @Summary Get transaction artifacts
+// @Description List the generated artifacts (nftables ruleset, dnsmasq config, interface commands) a transaction pushed to the system, byte for byte, in the order they were pushed
+// @Tags transactions
+// @Produce json
+// @Param txid path string true "Transaction ID"
+// @Success 200 {object} TransactionArtifacts
+// @Failure 404 {object} map[string]string
+// @Failure 500 {object} map[string]string
+// @Router /transactions/{txid}/artifacts [get]
+// @Security BearerAuth
+func transactionArtifactsHandler(c *gin.Context) {
+	txID := c.Param("txid")
+
+	if _, err := db.GetTransactionByID(txID); err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			apierrors.NotFound(c, err)
+			return
+		}
+		apierrors.OperationFailed(c, err)
+		return
+	}
+
+	artifacts, err := db.ListTransactionArtifacts(txID)
+	if err != nil {
+		apierrors.OperationFailed(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, TransactionArtifacts{TransactionID: txID, Artifacts: artifacts})
+}
@@ -0,0 +1,228 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/thesabbir/hellfire/pkg/audit"
+	apierrors "github.com/thesabbir/hellfire/pkg/errors"
+	"github.com/thesabbir/hellfire/pkg/hfconfig"
+	"github.com/thesabbir/hellfire/pkg/logger"
+	"github.com/thesabbir/hellfire/pkg/middleware"
+	"github.com/thesabbir/hellfire/pkg/transaction"
+	"github.com/thesabbir/hellfire/pkg/upgrade"
+	"github.com/thesabbir/hellfire/pkg/util"
+)
+
+// upgradeStreamTimeout bounds how long an upgrade's output is streamed; the
+// request deadline comes from the route timeout of /system/upgrade, which
+// defaults to as long
+const upgradeStreamTimeout = hfconfig.DefaultUpgradeTimeout * time.Second
+
+// UpgradeRequest starts an upgrade
+type UpgradeRequest struct {
+	Method    string   `json:"method" example:"apt"` // apt or image
+	Packages  []string `json:"packages,omitempty"`   // apt: packages to upgrade; empty upgrades the whole system
+	Image     string   `json:"image,omitempty"`      // image: path of the .deb on the router
+	Signature string   `json:"signature,omitempty"`  // image: signature file (default <image>.sig)
+	PublicKey string   `json:"public_key,omitempty"` // image: public key file
+	Message   string   `json:"message,omitempty"`    // Note recorded with the upgrade
+}
+
+// getUpgradeHandler godoc
+// @Summary Get the last upgrade
+// @Description Show the status of the last upgrade: its backups, versions and post-upgrade check results
+// @Tags system
+// @Produce json
+// @Success 200 {object} upgrade.State
+// @Failure 403 {object} map[string]string
+// @Failure 404 {object} map[string]string
+// @Router /system/upgrade [get]
+// @Security BearerAuth
+func getUpgradeHandler(c *gin.Context) {
+	state, err := newUpgrader().State()
+	if err != nil {
+		if errors.Is(err, upgrade.ErrNoUpgrade) {
+			apierrors.NotFound(c, err)
+			return
+		}
+		apierrors.OperationFailed(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, state)
+}
+
+// startUpgradeHandler godoc
+// @Summary Upgrade the system
+// @Description Snapshot the configs, back up the database and upgrade with apt-get or a signed image, then restart the service, which verifies the upgrade.
+// @Description The upgrade runs in its own systemd unit. With "Accept: text/event-stream" the installer's output is streamed as "output" events followed by a final "result" or "error" event; otherwise 202 is returned with the upgrade ID.
+// @Tags system
+// @Accept json
+// @Produce json
+// @Produce text/event-stream
+// @Param request body UpgradeRequest true "Upgrade options"
+// @Success 202 {object} map[string]string
+// @Failure 400 {object} map[string]string
+// @Failure 403 {object} map[string]string
+// @Failure 409 {object} map[string]string
+// @Failure 500 {object} map[string]string
+// @Router /system/upgrade [post]
+// @Security BearerAuth
+func startUpgradeHandler(c *gin.Context) {
+	var req UpgradeRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		apierrors.BadRequest(c, err)
+		return
+	}
+
+	opts := upgrade.Options{
+		ID:            util.GenerateUniqueID(),
+		Method:        req.Method,
+		Packages:      req.Packages,
+		Image:         req.Image,
+		SignatureFile: req.Signature,
+		PublicKeyFile: req.PublicKey,
+		Message:       req.Message,
+	}
+	if opts.Method == "" {
+		opts.Method = upgrade.MethodApt
+	}
+	if err := opts.Validate(); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	upgrader := newUpgrader()
+	if state, err := upgrader.State(); err == nil && state.Running() {
+		c.JSON(http.StatusConflict, gin.H{"error": upgrade.ErrInProgress.Error(), "id": state.ID})
+		return
+	}
+
+	if err := startUpgrade(opts); err != nil {
+		audit.LogUserAction(middleware.AuditContext(c), audit.ActionSystemUpgrade, audit.StatusFailure, "system",
+			"Failed to start upgrade", nil, err)
+		apierrors.OperationFailed(c, err)
+		return
+	}
+	audit.LogUserAction(middleware.AuditContext(c), audit.ActionSystemUpgrade, audit.StatusSuccess, "system",
+		fmt.Sprintf("Started %s upgrade %s", opts.Method, opts.ID), nil, nil)
+
+	if !strings.Contains(c.GetHeader("Accept"), "text/event-stream") {
+		c.JSON(http.StatusAccepted, gin.H{"message": "upgrade started", "id": opts.ID})
+		return
+	}
+
+	streamUpgrade(c, upgrader, opts.ID)
+}
+
+// streamUpgrade streams the output of an upgrade as server-sent events:
+// "output" for each line, then a final "result" or "error"
+func streamUpgrade(c *gin.Context, upgrader *upgrade.Upgrader, id string) {
+	ctx, cancel := context.WithTimeout(c.Request.Context(), upgradeStreamTimeout)
+	defer cancel()
+
+	lines := make(chan string, 64)
+	var state *upgrade.State
+	var followErr error
+	go func() {
+		defer close(lines)
+		w := &lineWriter{lines: lines, done: ctx.Done()}
+		state, followErr = upgrader.Follow(ctx, id, w)
+		w.Flush()
+	}()
+
+	c.Header("Cache-Control", "no-cache")
+	c.Header("X-Accel-Buffering", "no")
+	c.Stream(func(w io.Writer) bool {
+		if line, ok := <-lines; ok {
+			c.SSEvent("output", line)
+			return true
+		}
+
+		switch {
+		case followErr != nil:
+			logger.Error("Request error",
+				"path", c.Request.URL.Path,
+				"method", c.Request.Method,
+				"error", followErr.Error(),
+				"client_ip", c.ClientIP())
+			c.SSEvent("error", gin.H{"error": followErr.Error(), "id": id})
+		default:
+			c.SSEvent("result", state)
+		}
+		return false
+	})
+}
+
+// lineWriter sends each complete line written to it on a channel
+type lineWriter struct {
+	lines chan<- string
+	done  <-chan struct{}
+	buf   []byte
+}
+
+func (w *lineWriter) Write(p []byte) (int, error) {
+	w.buf = append(w.buf, p...)
+	for {
+		i := bytes.IndexByte(w.buf, '\n')
+		if i < 0 {
+			return len(p), nil
+		}
+		w.send(string(w.buf[:i]))
+		w.buf = w.buf[i+1:]
+	}
+}
+
+// Flush sends a final unterminated line
+func (w *lineWriter) Flush() {
+	if len(w.buf) > 0 {
+		w.send(string(w.buf))
+		w.buf = nil
+	}
+}
+
+func (w *lineWriter) send(line string) {
+	select {
+	case w.lines <- strings.TrimRight(line, "\r"):
+	case <-w.done:
+	}
+}
+
+// rollbackUpgradeHandler godoc
+// @Summary Roll back the configs of the last upgrade
+// @Description Restore and re-apply the config snapshot taken before the last upgrade. Packages are not downgraded and the database is not restored.
+// @Tags system
+// @Produce json
+// @Success 200 {object} upgrade.State
+// @Failure 403 {object} map[string]string
+// @Failure 404 {object} map[string]string
+// @Failure 409 {object} map[string]string
+// @Router /system/upgrade/rollback [post]
+// @Security BearerAuth
+func rollbackUpgradeHandler(txMgr *transaction.Manager) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		ctx := middleware.AuditContext(c)
+		state, err := newUpgrader().Rollback(func(snapshotID string) error {
+			return txMgr.Rollback(transaction.WithRollbackReason(ctx, "Restoring the configs from before the last upgrade"), snapshotID)
+		})
+		if err != nil {
+			if errors.Is(err, upgrade.ErrNoUpgrade) {
+				apierrors.NotFound(c, err)
+				return
+			}
+			c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+			return
+		}
+
+		c.JSON(http.StatusOK, state)
+	}
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/thesabbir/hellfire/pkg/db"
+	apierrors "github.com/thesabbir/hellfire/pkg/errors"
+	"github.com/thesabbir/hellfire/pkg/wanquality"
+)
+
+const (
+	defaultWANHistory = 24 * time.Hour
+	maxWANSamples     = 5000
+)
+
+// wanMonitor measures WAN quality in the API server; nil when disabled
+var wanMonitor *wanquality.Monitor
+
+// WANQualityResponse is the WAN quality history and the current state of each target
+type WANQualityResponse struct {
+	Enabled bool                      `json:"enabled"`
+	Targets []wanquality.TargetStatus `json:"targets"`
+	Samples []db.WANQualitySample     `json:"samples"` // Newest first
+}
+
+// wanQualityHandler godoc
+// @Summary Get WAN quality history
+// @Description List WAN latency, jitter, loss and throughput samples, newest first, with the current state of each ping target
+// @Tags wan
+// @Produce json
+// @Param since query string false "How far back to list, as a duration (default 24h)"
+// @Param target query string false "Only samples for this target"
+// @Param kind query string false "ping or speedtest"
+// @Param limit query int false "Maximum number of samples (default and maximum 5000)"
+// @Success 200 {object} WANQualityResponse
+// @Failure 400 {object} map[string]string
+// @Failure 500 {object} map[string]string
+// @Router /wan/quality [get]
+// @Security BearerAuth
+func wanQualityHandler(c *gin.Context) {
+	since := defaultWANHistory
+	if value := c.Query("since"); value != "" {
+		d, err := time.ParseDuration(value)
+		if err != nil || d <= 0 {
+			apierrors.BadRequest(c, fmt.Errorf("invalid since duration: %s", value))
+			return
+		}
+		since = d
+	}
+
+	limit := maxWANSamples
+	if value := c.Query("limit"); value != "" {
+		n, err := strconv.Atoi(value)
+		if err != nil || n < 1 {
+			apierrors.BadRequest(c, fmt.Errorf("invalid limit: %s", value))
+			return
+		}
+		limit = min(n, maxWANSamples)
+	}
+
+	filters := map[string]interface{}{"from": time.Now().Add(-since)}
+	if target := c.Query("target"); target != "" {
+		filters["target"] = target
+	}
+	if kind := c.Query("kind"); kind != "" {
+		if kind != wanquality.KindPing && kind != wanquality.KindSpeedtest {
+			apierrors.BadRequest(c, fmt.Errorf("invalid kind: %s", kind))
+			return
+		}
+		filters["kind"] = kind
+	}
+
+	samples, err := db.ListWANQualitySamples(filters, limit)
+	if err != nil {
+		apierrors.OperationFailed(c, err)
+		return
+	}
+
+	response := WANQualityResponse{
+		Enabled: wanMonitor != nil,
+		Targets: []wanquality.TargetStatus{},
+		Samples: samples,
+	}
+	if wanMonitor != nil {
+		response.Targets = wanMonitor.Status()
+	}
+	c.JSON(http.StatusOK, response)
+}
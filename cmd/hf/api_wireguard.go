@@ -0,0 +1,259 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/thesabbir/hellfire/pkg/audit"
+	"github.com/thesabbir/hellfire/pkg/bus"
+	"github.com/thesabbir/hellfire/pkg/config"
+	apierrors "github.com/thesabbir/hellfire/pkg/errors"
+	"github.com/thesabbir/hellfire/pkg/middleware"
+	"github.com/thesabbir/hellfire/pkg/wireguard"
+)
+
+// WireGuardStatusResponse is the state of the WireGuard interfaces
+type WireGuardStatusResponse struct {
+	Available  bool                  `json:"available"` // Whether the wg tool is installed; without it every interface is down
+	Interfaces []wireguard.Interface `json:"interfaces"`
+}
+
+// WireGuardKeysResponse is a generated key pair
+type WireGuardKeysResponse struct {
+	PrivateKey   string `json:"private_key"`
+	PublicKey    string `json:"public_key"`
+	PresharedKey string `json:"preshared_key,omitempty"`
+}
+
+// WireGuardPeerKeysResponse is the public key generated for a peer
+type WireGuardPeerKeysResponse struct {
+	Message   string `json:"message"`
+	Peer      string `json:"peer"` // Section of the peer
+	PublicKey string `json:"public_key"`
+}
+
+// WireGuardClientConfigResponse is a wg-quick config for a peer
+type WireGuardClientConfigResponse struct {
+	Filename string `json:"filename"`
+	Config   string `json:"config"`
+}
+
+// wireguardStatusHandler godoc
+// @Summary Get WireGuard status
+// @Description List the WireGuard interfaces of the network config and any others running, with each peer's endpoint, latest handshake and transfer counters
+// @Tags wireguard
+// @Produce json
+// @Success 200 {object} WireGuardStatusResponse
+// @Failure 500 {object} map[string]string
+// @Router /wireguard/status [get]
+// @Security BearerAuth
+func wireguardStatusHandler(manager *config.Manager) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		network, err := manager.Load("network")
+		if err != nil {
+			apierrors.InternalServerError(c, err)
+			return
+		}
+
+		interfaces, err := wireguard.Status(c.Request.Context(), network)
+		if err != nil && !errors.Is(err, wireguard.ErrUnavailable) {
+			apierrors.OperationFailed(c, err)
+			return
+		}
+
+		c.JSON(http.StatusOK, WireGuardStatusResponse{
+			Available:  err == nil,
+			Interfaces: interfaces,
+		})
+	}
+}
+
+// generateWireGuardKeysHandler godoc
+// @Summary Generate a WireGuard key pair
+// @Description Generate a key pair, and a preshared key if asked, without storing them
+// @Tags wireguard
+// @Produce json
+// @Param preshared query bool false "Also generate a preshared key"
+// @Success 200 {object} WireGuardKeysResponse
+// @Failure 403 {object} map[string]string
+// @Failure 500 {object} map[string]string
+// @Router /wireguard/keys [post]
+// @Security BearerAuth
+func generateWireGuardKeysHandler(c *gin.Context) {
+	pair, err := wireguard.GenerateKeyPair()
+	if err != nil {
+		apierrors.InternalServerError(c, err)
+		return
+	}
+
+	response := WireGuardKeysResponse{PrivateKey: pair.PrivateKey, PublicKey: pair.PublicKey}
+	if c.Query("preshared") == "true" || c.Query("preshared") == "1" {
+		if response.PresharedKey, err = wireguard.GeneratePresharedKey(); err != nil {
+			apierrors.InternalServerError(c, err)
+			return
+		}
+	}
+
+	c.JSON(http.StatusOK, response)
+}
+
+// generatePeerKeysHandler godoc
+// @Summary Generate keys for a WireGuard peer
+// @Description Generate a key pair for a peer and stage its private_key and public_key, and a preshared_key if asked, replacing any it had. The private key is encrypted when committed and only leaves the router in the peer's client config. The peer is a section name, description or @wireguard_<interface>[index].
+// @Tags wireguard
+// @Produce json
+// @Param interface path string true "WireGuard interface"
+// @Param peer path string true "Peer"
+// @Param preshared query bool false "Also generate a preshared key"
+// @Success 200 {object} WireGuardPeerKeysResponse
+// @Failure 403 {object} map[string]string
+// @Failure 404 {object} map[string]string
+// @Failure 500 {object} map[string]string
+// @Router /wireguard/{interface}/peers/{peer}/keys [post]
+// @Security BearerAuth
+func generatePeerKeysHandler(settings *apiSettings, manager *config.Manager) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		iface, peer := c.Param("interface"), c.Param("peer")
+
+		network, err := manager.Load("network")
+		if err != nil {
+			apierrors.InternalServerError(c, err)
+			return
+		}
+		_, ref, err := wireguard.FindPeer(network, iface, peer)
+		if err != nil {
+			wireguardError(c, err)
+			return
+		}
+		path := "network." + ref
+		if !pathAccess(c, settings, manager, path).Write {
+			apierrors.Forbidden(c, fmt.Errorf("no write access to %s", path))
+			return
+		}
+
+		pair, err := wireguard.GenerateKeyPair()
+		if err != nil {
+			apierrors.InternalServerError(c, err)
+			return
+		}
+		ops := []config.Operation{
+			{Op: config.OpSet, Path: path + ".private_key", Value: pair.PrivateKey},
+			{Op: config.OpSet, Path: path + ".public_key", Value: pair.PublicKey},
+		}
+		if c.Query("preshared") == "true" || c.Query("preshared") == "1" {
+			psk, err := wireguard.GeneratePresharedKey()
+			if err != nil {
+				apierrors.InternalServerError(c, err)
+				return
+			}
+			ops = append(ops, config.Operation{Op: config.OpSet, Path: path + ".preshared_key", Value: psk})
+		}
+
+		if _, err := manager.Batch(ops); err != nil {
+			audit.LogUserAction(middleware.AuditContext(c), audit.ActionConfigWrite, audit.StatusFailure, path,
+				fmt.Sprintf("Failed to generate WireGuard keys for %s", path), nil, err)
+			apierrors.OperationFailed(c, err)
+			return
+		}
+		audit.LogUserAction(middleware.AuditContext(c), audit.ActionConfigWrite, audit.StatusSuccess, path,
+			fmt.Sprintf("Generated WireGuard keys for %s", path), map[string]interface{}{"public_key": pair.PublicKey}, nil)
+
+		bus.Publish(bus.Event{
+			Type:       bus.EventConfigChanged,
+			ConfigName: "network",
+			Data:       ops,
+		})
+
+		c.JSON(http.StatusOK, WireGuardPeerKeysResponse{
+			Message:   "keys staged, commit to apply",
+			Peer:      ref,
+			PublicKey: pair.PublicKey,
+		})
+	}
+}
+
+// peerConfigHandler godoc
+// @Summary Export a WireGuard client config
+// @Description Render the wg-quick config of a peer, including its private key, for importing into a client; mobile apps can scan it as a QR code. The peer needs a private key (generate its keys first); its allowed IPs become the client's addresses. With format=conf the config is returned as a file.
+// @Tags wireguard
+// @Produce json
+// @Produce plain
+// @Param interface path string true "WireGuard interface"
+// @Param peer path string true "Peer"
+// @Param endpoint query string true "Host or host:port clients connect to; the port defaults to the interface's listen_port"
+// @Param dns query string false "Comma-separated DNS servers for the client"
+// @Param allowed_ips query string false "Comma-separated prefixes to route through the tunnel (default everything)"
+// @Param format query string false "json (default) or conf"
+// @Success 200 {object} WireGuardClientConfigResponse
+// @Failure 400 {object} map[string]string
+// @Failure 403 {object} map[string]string
+// @Failure 404 {object} map[string]string
+// @Failure 500 {object} map[string]string
+// @Router /wireguard/{interface}/peers/{peer}/config [get]
+// @Security BearerAuth
+func peerConfigHandler(manager *config.Manager) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		iface, peer := c.Param("interface"), c.Param("peer")
+
+		network, err := manager.LoadDecrypted("network")
+		if err != nil {
+			apierrors.InternalServerError(c, err)
+			return
+		}
+
+		opts := wireguard.ClientOptions{
+			Endpoint:   c.Query("endpoint"),
+			DNS:        splitList(c.Query("dns")),
+			AllowedIPs: splitList(c.Query("allowed_ips")),
+		}
+		conf, err := wireguard.ClientConfig(network, iface, peer, opts)
+		resource := fmt.Sprintf("wireguard:%s/%s", iface, peer)
+		if err != nil {
+			audit.LogUserAction(middleware.AuditContext(c), audit.ActionWireGuardExport, audit.StatusFailure, resource,
+				fmt.Sprintf("Failed to export WireGuard config of %s on %s", peer, iface), nil, err)
+			wireguardError(c, err)
+			return
+		}
+		audit.LogUserAction(middleware.AuditContext(c), audit.ActionWireGuardExport, audit.StatusSuccess, resource,
+			fmt.Sprintf("Exported WireGuard config of %s on %s", peer, iface), nil, nil)
+
+		filename := iface + "-" + strings.Trim(strings.NewReplacer("@", "", "[", "-", "]", "").Replace(peer), "-") + ".conf"
+		if c.Query("format") == "conf" {
+			c.Header("Content-Disposition", fmt.Sprintf("attachment; filename=%q", filename))
+			c.Header("Cache-Control", "no-store")
+			c.Data(http.StatusOK, "text/plain; charset=utf-8", []byte(conf))
+			return
+		}
+
+		c.Header("Cache-Control", "no-store")
+		c.JSON(http.StatusOK, WireGuardClientConfigResponse{Filename: filename, Config: conf})
+	}
+}
+
+// wireguardError responds with the status for a wireguard error; invalid
+// configs are returned to the client so it can fix them
+func wireguardError(c *gin.Context, err error) {
+	switch {
+	case errors.Is(err, wireguard.ErrNotFound):
+		apierrors.NotFound(c, err)
+	case errors.Is(err, wireguard.ErrInvalid):
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	default:
+		apierrors.OperationFailed(c, err)
+	}
+}
+
+// splitList splits a comma-separated query value, dropping empty items
+func splitList(value string) []string {
+	var items []string
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
@@ -40,9 +40,11 @@ var auditCleanupCmd = &cobra.Command{
 func init() {
 	// Audit list flags
 	auditListCmd.Flags().String("user", "", "Filter by username")
+	auditListCmd.Flags().String("impersonator", "", "Filter by impersonating admin")
 	auditListCmd.Flags().String("action", "", "Filter by action")
 	auditListCmd.Flags().String("status", "", "Filter by status (success/failure)")
 	auditListCmd.Flags().String("resource", "", "Filter by resource")
+	auditListCmd.Flags().String("request-id", "", "Filter by API request ID (X-Request-ID)")
 	auditListCmd.Flags().String("from", "", "Filter from date (YYYY-MM-DD)")
 	auditListCmd.Flags().String("to", "", "Filter to date (YYYY-MM-DD)")
 	auditListCmd.Flags().Int("limit", 50, "Maximum number of logs to show")
@@ -72,6 +74,10 @@ func runAuditList(cmd *cobra.Command, args []string) error {
 		filters["user_id"] = user.ID
 	}
 
+	if impersonator, _ := cmd.Flags().GetString("impersonator"); impersonator != "" {
+		filters["impersonator"] = impersonator
+	}
+
 	if action, _ := cmd.Flags().GetString("action"); action != "" {
 		filters["action"] = action
 	}
@@ -84,6 +90,10 @@ func runAuditList(cmd *cobra.Command, args []string) error {
 		filters["resource"] = resource
 	}
 
+	if requestID, _ := cmd.Flags().GetString("request-id"); requestID != "" {
+		filters["request_id"] = requestID
+	}
+
 	if fromStr, _ := cmd.Flags().GetString("from"); fromStr != "" {
 		from, err := time.Parse("2006-01-02", fromStr)
 		if err != nil {
@@ -128,10 +138,15 @@ func runAuditList(cmd *cobra.Command, args []string) error {
 			message = message[:37] + "..."
 		}
 
+		username := log.Username
+		if log.Impersonator != "" {
+			username += " (by " + log.Impersonator + ")"
+		}
+
 		fmt.Fprintf(w, "%d\t%s\t%s\t%s\t%s\t%s\t%s\n",
 			log.ID,
 			timestamp,
-			log.Username,
+			username,
 			log.Action,
 			log.Resource,
 			log.Status,
@@ -173,6 +188,13 @@ func runAuditShow(cmd *cobra.Command, args []string) error {
 		fmt.Printf(" (ID: %d)", *log.UserID)
 	}
 	fmt.Println()
+	if log.Impersonator != "" {
+		fmt.Printf("Impersonated by: %s", log.Impersonator)
+		if log.ImpersonatorID != nil {
+			fmt.Printf(" (ID: %d)", *log.ImpersonatorID)
+		}
+		fmt.Println()
+	}
 
 	fmt.Printf("Action:     %s\n", log.Action)
 	fmt.Printf("Status:     %s\n", log.Status)
@@ -193,6 +215,10 @@ func runAuditShow(cmd *cobra.Command, args []string) error {
 		fmt.Printf("Transaction: %s\n", log.TxID)
 	}
 
+	if log.RequestID != "" {
+		fmt.Printf("Request ID: %s\n", log.RequestID)
+	}
+
 	if log.Duration > 0 {
 		fmt.Printf("Duration:   %dms\n", log.Duration)
 	}
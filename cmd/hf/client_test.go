@@ -0,0 +1,2150 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/thesabbir/hellfire/pkg/appliers"
+	"github.com/thesabbir/hellfire/pkg/audit"
+	"github.com/thesabbir/hellfire/pkg/auth"
+	"github.com/thesabbir/hellfire/pkg/bus"
+	"github.com/thesabbir/hellfire/pkg/client"
+	"github.com/thesabbir/hellfire/pkg/config"
+	"github.com/thesabbir/hellfire/pkg/db"
+	"github.com/thesabbir/hellfire/pkg/dns"
+	"github.com/thesabbir/hellfire/pkg/hfconfig"
+	"github.com/thesabbir/hellfire/pkg/middleware"
+	"github.com/thesabbir/hellfire/pkg/plugin"
+	"github.com/thesabbir/hellfire/pkg/secrets"
+	"github.com/thesabbir/hellfire/pkg/snapshot"
+	"github.com/thesabbir/hellfire/pkg/transaction"
+	"github.com/thesabbir/hellfire/pkg/wireguard"
+)
+
+const testNetworkConfig = `
+config interface 'wan'
+	option proto 'dhcp'
+
+config interface 'lan'
+	option proto 'static'
+	option ipaddr '192.168.1.1'
+`
+
+// newTestServer starts the API against temporary directories and database.
+// No appliers are registered, so commits only touch the config files.
+func newTestServer(t *testing.T) *httptest.Server {
+	t.Helper()
+	return newTestServerWith(t, appliers.NewRegistry())
+}
+
+// newTestServerWith starts the API like newTestServer, applying commits with
+// the given appliers
+func newTestServerWith(t *testing.T, registry *appliers.Registry) *httptest.Server {
+	t.Helper()
+	return newTestServerConfig(t, registry, hfconfig.DefaultConfig())
+}
+
+// newTestServerConfig starts the API like newTestServerWith, with the given
+// Hellfire config
+func newTestServerConfig(t *testing.T, registry *appliers.Registry, hfConfig *hfconfig.Config) *httptest.Server {
+	t.Helper()
+
+	dir := t.TempDir()
+	configDir := filepath.Join(dir, "config")
+	if err := os.MkdirAll(configDir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(configDir, "network"), []byte(testNetworkConfig), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := db.Initialize(&db.Config{Path: filepath.Join(dir, "hellfire.db")}); err != nil {
+		t.Fatalf("Initialize database: %v", err)
+	}
+	t.Cleanup(func() { _ = db.Close() })
+
+	hash, err := auth.HashPassword("test-password")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := db.CreateUser(&db.User{Username: "admin", PasswordHash: hash, Role: db.RoleAdmin, Enabled: true}); err != nil {
+		t.Fatalf("CreateUser: %v", err)
+	}
+
+	configMgr := config.NewManager(configDir, filepath.Join(dir, "staging"))
+	configMgr.SetSecrets(secrets.NewStore(filepath.Join(dir, "secret.key")))
+	snapshotMgr := snapshot.NewManager(filepath.Join(dir, "snapshots"), configDir)
+	snapshotMgr.SetLimits(hfConfig.SnapshotLimits())
+	txMgr := transaction.NewManager(configMgr, snapshotMgr, registry)
+	setCanary(txMgr, hfConfig)
+	txMgr.SetRequireRollbackReason(hfConfig.Transaction.RequireRollbackReason)
+	txMgr.SetSystemCapture(hfConfig.Transaction.CaptureSize * 1024)
+
+	settings, err := newAPISettings(hfConfig)
+	if err != nil {
+		t.Fatalf("newAPISettings: %v", err)
+	}
+
+	router, err := newRouter(hfConfig, settings, configMgr, snapshotMgr, txMgr)
+	if err != nil {
+		t.Fatalf("newRouter: %v", err)
+	}
+
+	server := httptest.NewServer(router)
+	t.Cleanup(server.Close)
+
+	return server
+}
+
+func TestClientAgainstServer(t *testing.T) {
+	server := newTestServer(t)
+	ctx := context.Background()
+	c := client.New(server.URL)
+
+	// Unauthenticated requests are rejected
+	if _, err := c.GetConfig(ctx, "network"); !client.IsStatus(err, http.StatusUnauthorized) {
+		t.Fatalf("Expected 401 before login, got %v", err)
+	}
+
+	if _, err := c.Login(ctx, "admin", "test-password"); err != nil {
+		t.Fatalf("Login: %v", err)
+	}
+
+	me, err := c.Me(ctx)
+	if err != nil {
+		t.Fatalf("Me: %v", err)
+	}
+	if me.User.Username != "admin" || me.User.Role != string(db.RoleAdmin) {
+		t.Errorf("Unexpected user: %+v", me.User)
+	}
+
+	cfg, err := c.GetConfig(ctx, "network")
+	if err != nil {
+		t.Fatalf("GetConfig: %v", err)
+	}
+	if proto, _ := cfg["wan"].Option("proto"); proto != "dhcp" {
+		t.Errorf("Expected wan proto 'dhcp', got '%s'", proto)
+	}
+
+	// Stage changes through both write paths
+	if _, err := c.SetOption(ctx, "network", "wan", "proto", "static"); err != nil {
+		t.Fatalf("SetOption: %v", err)
+	}
+
+	batch, err := c.Batch(ctx, []client.Operation{
+		{Op: client.OpSet, Path: "network.wan.ipaddr", Value: "203.0.113.2"},
+		{Op: client.OpAddList, Path: "network.lan.dns", Value: "1.1.1.1"},
+	})
+	if err != nil {
+		t.Fatalf("Batch: %v", err)
+	}
+	if batch.Operations != 2 {
+		t.Errorf("Expected 2 operations, got %d", batch.Operations)
+	}
+
+	// A rejected operation reports its index
+	_, err = c.Batch(ctx, []client.Operation{
+		{Op: client.OpSet, Path: "network.wan.netmask", Value: "255.255.255.0"},
+		{Op: "rename", Path: "network.wan"},
+	})
+	apiErr, ok := err.(*client.APIError)
+	if !ok || apiErr.StatusCode != http.StatusBadRequest || apiErr.Operation == nil || *apiErr.Operation != 1 {
+		t.Fatalf("Expected 400 for operation 1, got %v", err)
+	}
+
+	changes, err := c.Changes(ctx)
+	if err != nil {
+		t.Fatalf("Changes: %v", err)
+	}
+	if !changes.HasChanges || len(changes.Configs) != 1 || changes.Configs[0] != "network" {
+		t.Errorf("Unexpected changes: %+v", changes)
+	}
+
+	validation, err := c.Validate(ctx)
+	if err != nil {
+		t.Fatalf("Validate: %v", err)
+	}
+	if !validation.Valid {
+		t.Errorf("Expected staged changes to be valid")
+	}
+
+	commit, err := c.Commit(ctx, client.CommitRequest{Message: "Static WAN", IdempotencyKey: "commit-1"})
+	if err != nil {
+		t.Fatalf("Commit: %v", err)
+	}
+	if len(commit.Configs) != 1 || commit.Configs[0] != "network" {
+		t.Errorf("Unexpected commit response: %+v", commit)
+	}
+
+	// Retrying with the same key replays the response instead of committing again
+	replay, err := c.Commit(ctx, client.CommitRequest{Message: "Static WAN", IdempotencyKey: "commit-1"})
+	if err != nil {
+		t.Fatalf("Commit retry: %v", err)
+	}
+	if replay.Message != commit.Message {
+		t.Errorf("Expected replayed response %q, got %q", commit.Message, replay.Message)
+	}
+
+	value, err := c.GetOption(ctx, "network", "wan", "ipaddr")
+	if err != nil {
+		t.Fatalf("GetOption: %v", err)
+	}
+	if value != "203.0.113.2" {
+		t.Errorf("Expected committed ipaddr '203.0.113.2', got '%s'", value)
+	}
+
+	snapshots, err := c.ListSnapshots(ctx)
+	if err != nil {
+		t.Fatalf("ListSnapshots: %v", err)
+	}
+	if snapshots.Count != 1 {
+		t.Fatalf("Expected 1 snapshot, got %d", snapshots.Count)
+	}
+
+	// Staging the pre-commit snapshot and reverting leaves nothing staged
+	if _, err := c.StageSnapshot(ctx, snapshots.Snapshots[0].ID); err != nil {
+		t.Fatalf("StageSnapshot: %v", err)
+	}
+	if _, err := c.Revert(ctx); err != nil {
+		t.Fatalf("Revert: %v", err)
+	}
+	changes, err = c.Changes(ctx)
+	if err != nil {
+		t.Fatalf("Changes: %v", err)
+	}
+	if changes.HasChanges {
+		t.Errorf("Expected no staged changes after revert, got %v", changes.Configs)
+	}
+
+	if err := c.Logout(ctx); err != nil {
+		t.Fatalf("Logout: %v", err)
+	}
+}
+
+func TestConfigETagAndCompression(t *testing.T) {
+	server := newTestServer(t)
+	ctx := context.Background()
+	// Sessions are bound to the user agent
+	c := client.New(server.URL, client.WithUserAgent("etag-test"))
+	if _, err := c.Login(ctx, "admin", "test-password"); err != nil {
+		t.Fatalf("Login: %v", err)
+	}
+
+	get := func(etag, encoding string) *http.Response {
+		t.Helper()
+		req, err := http.NewRequest(http.MethodGet, server.URL+"/api/v1/config/network", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.Header.Set("Authorization", "Bearer "+c.Token())
+		req.Header.Set("User-Agent", "etag-test")
+		req.Header.Set("Accept-Encoding", encoding)
+		if etag != "" {
+			req.Header.Set("If-None-Match", etag)
+		}
+		resp, err := http.DefaultTransport.RoundTrip(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { _ = resp.Body.Close() })
+		return resp
+	}
+
+	first := get("", "identity")
+	etag := first.Header.Get("ETag")
+	if first.StatusCode != http.StatusOK || etag == "" {
+		t.Fatalf("Expected 200 with ETag, got %d %q", first.StatusCode, etag)
+	}
+
+	if resp := get(etag, "identity"); resp.StatusCode != http.StatusNotModified {
+		t.Errorf("Expected 304 for matching ETag, got %d", resp.StatusCode)
+	}
+
+	// Staging a change changes the ETag
+	if _, err := c.SetOption(ctx, "network", "wan", "proto", "static"); err != nil {
+		t.Fatalf("SetOption: %v", err)
+	}
+	resp := get(etag, "gzip")
+	if resp.StatusCode != http.StatusOK || resp.Header.Get("ETag") == etag {
+		t.Errorf("Expected 200 with a new ETag after staging, got %d %q", resp.StatusCode, resp.Header.Get("ETag"))
+	}
+
+	// Small responses are not worth compressing
+	if resp.Header.Get("Content-Encoding") != "" {
+		t.Errorf("Expected small response uncompressed, got %q", resp.Header.Get("Content-Encoding"))
+	}
+}
+
+func TestOnboardingToken(t *testing.T) {
+	server := newTestServer(t)
+
+	// A fresh install: no users
+	if err := db.DB.Unscoped().Where("1 = 1").Delete(&db.User{}).Error; err != nil {
+		t.Fatal(err)
+	}
+	token, _, err := auth.IssueOnboardingToken(time.Minute)
+	if err != nil {
+		t.Fatalf("IssueOnboardingToken: %v", err)
+	}
+
+	onboard := func(token string) int {
+		t.Helper()
+		body := `{"token": "` + token + `", "name": "Admin", "email": "admin@example.com", "password": "Onboarding-Pass-1"}`
+		resp, err := http.Post(server.URL+"/api/v1/onboarding", "application/json", strings.NewReader(body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		_ = resp.Body.Close()
+		return resp.StatusCode
+	}
+
+	if status := onboard("wrong"); status != http.StatusUnauthorized {
+		t.Errorf("Expected 401 for a wrong token, got %d", status)
+	}
+	if status := onboard(token); status != http.StatusOK {
+		t.Fatalf("Expected 200 for the token, got %d", status)
+	}
+
+	// The token is used up, even if there are no users again
+	if err := db.DB.Unscoped().Where("1 = 1").Delete(&db.User{}).Error; err != nil {
+		t.Fatal(err)
+	}
+	if status := onboard(token); status != http.StatusUnauthorized {
+		t.Errorf("Expected 401 for a used token, got %d", status)
+	}
+}
+
+func TestRateLimitForwardedFor(t *testing.T) {
+	hfConfig := hfconfig.DefaultConfig()
+	hfConfig.RateLimit.Exempt = []string{"10.0.0.0/8"}
+	server := newTestServerConfig(t, appliers.NewRegistry(), hfConfig)
+
+	// Spoofed addresses neither get a fresh bucket nor match the exemption
+	status := 0
+	for i := range hfConfig.RateLimit.AuthBurst + 1 {
+		req, err := http.NewRequest(http.MethodPost, server.URL+"/api/v1/auth/login",
+			strings.NewReader(`{"username": "admin", "password": "wrong"}`))
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("X-Forwarded-For", fmt.Sprintf("10.0.0.%d", i+1))
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		_ = resp.Body.Close()
+		status = resp.StatusCode
+	}
+	if status != http.StatusTooManyRequests {
+		t.Errorf("Expected 429 with a spoofed X-Forwarded-For, got %d", status)
+	}
+}
+
+func TestLoginDevices(t *testing.T) {
+	server := newTestServer(t)
+	ctx := context.Background()
+
+	alerts := make(chan NewDeviceLogin, 4)
+	bus.Subscribe(bus.EventLoginNewDevice, func(event bus.Event) {
+		if login, ok := event.Data.(NewDeviceLogin); ok {
+			alerts <- login
+		}
+	})
+
+	laptop := client.New(server.URL, client.WithUserAgent("laptop"))
+	if _, err := laptop.Login(ctx, "admin", "test-password"); err != nil {
+		t.Fatalf("Login: %v", err)
+	}
+	phone := client.New(server.URL, client.WithUserAgent("phone"))
+	if _, err := phone.Login(ctx, "admin", "test-password"); err != nil {
+		t.Fatalf("Login: %v", err)
+	}
+
+	// The first device is expected; the second one is alerted
+	select {
+	case login := <-alerts:
+		if login.Username != "admin" || login.UserAgent != "phone" {
+			t.Errorf("Unexpected new device alert: %+v", login)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Expected a new device alert for the phone")
+	}
+
+	devices, err := laptop.Devices(ctx)
+	if err != nil {
+		t.Fatalf("Devices: %v", err)
+	}
+	if len(devices) != 2 {
+		t.Fatalf("Expected 2 devices, got %+v", devices)
+	}
+	var phoneID uint
+	for _, device := range devices {
+		if device.Current != (device.UserAgent == "laptop") {
+			t.Errorf("Expected only the laptop current, got %+v", device)
+		}
+		if device.UserAgent == "phone" {
+			phoneID = device.ID
+		}
+	}
+
+	// Revoking the phone logs it out
+	if err := laptop.RevokeDevice(ctx, phoneID); err != nil {
+		t.Fatalf("RevokeDevice: %v", err)
+	}
+	if _, err := phone.Me(ctx); !client.IsStatus(err, http.StatusUnauthorized) {
+		t.Errorf("Expected 401 for the revoked phone, got %v", err)
+	}
+	if err := laptop.RevokeDevice(ctx, phoneID); !client.IsStatus(err, http.StatusNotFound) {
+		t.Errorf("Expected 404 revoking it again, got %v", err)
+	}
+}
+
+func TestUpgradeRouteTimeout(t *testing.T) {
+	hfConfig := hfconfig.DefaultConfig()
+	if timeout := seconds(hfConfig.API.RouteTimeouts["/system/upgrade"]); timeout < upgradeStreamTimeout {
+		t.Errorf("Upgrade route timeout %s is shorter than the upgrade stream (%s)", timeout, upgradeStreamTimeout)
+	}
+
+	// The timeout is keyed by a route the API serves
+	server := newTestServerConfig(t, appliers.NewRegistry(), hfConfig)
+	found := false
+	for _, route := range server.Config.Handler.(*gin.Engine).Routes() {
+		found = found || route.Method == http.MethodPost && route.Path == "/api/v1/system/upgrade"
+	}
+	if !found {
+		t.Error("POST /api/v1/system/upgrade is not registered")
+	}
+}
+
+func TestCORSPreflight(t *testing.T) {
+	server := newTestServer(t)
+
+	preflight := func(origin string) *http.Response {
+		req, err := http.NewRequest(http.MethodOptions, server.URL+"/api/v1/config/commit", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.Header.Set("Origin", origin)
+		req.Header.Set("Access-Control-Request-Method", http.MethodPost)
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		return resp
+	}
+
+	resp := preflight("http://localhost:5173")
+	if resp.StatusCode != http.StatusNoContent ||
+		resp.Header.Get("Access-Control-Allow-Origin") != "http://localhost:5173" ||
+		resp.Header.Get("Access-Control-Max-Age") != "600" {
+		t.Errorf("Unexpected preflight response for an allowed origin: %d %v", resp.StatusCode, resp.Header)
+	}
+
+	// Loopback isn't one of the router's own addresses unless listed
+	resp = preflight("http://127.0.0.1:8888")
+	if resp.Header.Get("Access-Control-Allow-Origin") != "" {
+		t.Errorf("Expected a loopback origin refused, got %v", resp.Header)
+	}
+
+	resp = preflight("https://evil.example")
+	if resp.StatusCode != http.StatusNoContent || resp.Header.Get("Access-Control-Allow-Origin") != "" {
+		t.Errorf("Unexpected preflight response for another origin: %d %v", resp.StatusCode, resp.Header)
+	}
+}
+
+func TestCSPNonce(t *testing.T) {
+	server := newTestServer(t)
+
+	nonce := func() string {
+		resp, err := http.Get(server.URL + "/health")
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+
+		csp := resp.Header.Get("Content-Security-Policy")
+		_, rest, found := strings.Cut(csp, "'nonce-")
+		value, _, _ := strings.Cut(rest, "'")
+		if !found || value == "" || strings.Contains(csp, middleware.CSPNoncePlaceholder) {
+			t.Fatalf("Expected a nonce in the CSP, got %q", csp)
+		}
+		return value
+	}
+
+	if first, second := nonce(), nonce(); first == second {
+		t.Errorf("Expected a fresh nonce per request, got %q twice", first)
+	}
+}
+
+func TestLocalizedErrors(t *testing.T) {
+	server := newTestServer(t)
+
+	req, err := http.NewRequest(http.MethodGet, server.URL+"/api/v1/config/network", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Accept-Language", "de-CH, fr;q=0.9, en;q=0.8")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+
+	var body struct {
+		Error string `json:"error"`
+		Code  string `json:"code"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != http.StatusUnauthorized || body.Code != "authentication_required" ||
+		body.Error != "Anmeldung erforderlich" {
+		t.Errorf("Unexpected error response: %d %+v", resp.StatusCode, body)
+	}
+
+	// Without a preference the client gets English and the code
+	_, err = client.New(server.URL).GetConfig(context.Background(), "network")
+	var apiErr *client.APIError
+	if !errors.As(err, &apiErr) || apiErr.Code != "authentication_required" || apiErr.Message != "authentication required" {
+		t.Errorf("Unexpected error: %v", err)
+	}
+}
+
+func TestChangePasswordAndProfile(t *testing.T) {
+	server := newTestServer(t)
+	ctx := context.Background()
+
+	laptop := client.New(server.URL, client.WithUserAgent("laptop"))
+	if _, err := laptop.Login(ctx, "admin", "test-password"); err != nil {
+		t.Fatalf("Login: %v", err)
+	}
+	phone := client.New(server.URL, client.WithUserAgent("phone"))
+	if _, err := phone.Login(ctx, "admin", "test-password"); err != nil {
+		t.Fatalf("Login: %v", err)
+	}
+
+	if err := laptop.ChangePassword(ctx, "wrong-password", "N3w-Passw0rd!x"); !client.IsStatus(err, http.StatusBadRequest) {
+		t.Errorf("Expected 400 for a wrong current password, got %v", err)
+	}
+	if err := laptop.ChangePassword(ctx, "test-password", "weak"); !client.IsStatus(err, http.StatusBadRequest) {
+		t.Errorf("Expected 400 for a weak password, got %v", err)
+	}
+	if err := laptop.ChangePassword(ctx, "test-password", "N3w-Passw0rd!x"); err != nil {
+		t.Fatalf("ChangePassword: %v", err)
+	}
+
+	// The phone is logged out, the laptop keeps its session
+	if _, err := phone.Me(ctx); !client.IsStatus(err, http.StatusUnauthorized) {
+		t.Errorf("Expected 401 for the other session, got %v", err)
+	}
+
+	user, err := laptop.UpdateProfile(ctx, "admin@example.com")
+	if err != nil {
+		t.Fatalf("UpdateProfile: %v", err)
+	}
+	if user.Email != "admin@example.com" {
+		t.Errorf("Expected the new email, got %+v", user)
+	}
+	if _, err := laptop.UpdateProfile(ctx, "not-an-email"); !client.IsStatus(err, http.StatusBadRequest) {
+		t.Errorf("Expected 400 for an invalid email, got %v", err)
+	}
+}
+
+func TestImpersonation(t *testing.T) {
+	server := newTestServer(t)
+	ctx := context.Background()
+
+	hash, err := auth.HashPassword("operator-password")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := db.CreateUser(&db.User{Username: "oscar", PasswordHash: hash, Role: db.RoleOperator, Enabled: true}); err != nil {
+		t.Fatalf("CreateUser: %v", err)
+	}
+
+	admin := client.New(server.URL, client.WithUserAgent("console"))
+	if _, err := admin.Login(ctx, "admin", "test-password"); err != nil {
+		t.Fatalf("Login: %v", err)
+	}
+	session, err := admin.Impersonate(ctx, "oscar", 5*time.Minute)
+	if err != nil {
+		t.Fatalf("Impersonate: %v", err)
+	}
+	if session.User.Username != "oscar" || session.Impersonator.Username != "admin" {
+		t.Fatalf("Unexpected impersonation: %+v", session)
+	}
+
+	// The session acts as the operator, on the admin's client
+	oscar := client.New(server.URL, client.WithUserAgent("console"), client.WithToken(session.Token))
+	me, err := oscar.Me(ctx)
+	if err != nil {
+		t.Fatalf("Me: %v", err)
+	}
+	if me.User.Username != "oscar" || me.Session == nil || me.Session.ImpersonatedBy == nil ||
+		me.Session.ImpersonatedBy.Username != "admin" || !me.Session.AbsoluteExpiry.Equal(me.Session.ExpiresAt) {
+		t.Errorf("Unexpected impersonated session: %+v", me)
+	}
+	if _, err := oscar.Impersonate(ctx, "admin", 0); !client.IsStatus(err, http.StatusForbidden) {
+		t.Errorf("Expected 403 impersonating as an operator, got %v", err)
+	}
+	if _, err := oscar.UpdateProfile(ctx, "admin@example.com"); !client.IsStatus(err, http.StatusForbidden) {
+		t.Errorf("Expected 403 updating the impersonated profile, got %v", err)
+	}
+	if err := oscar.ChangePassword(ctx, "operator-password", "N3w-Passw0rd!x"); !client.IsStatus(err, http.StatusForbidden) {
+		t.Errorf("Expected 403 changing the impersonated password, got %v", err)
+	}
+
+	if _, err := oscar.SetOption(ctx, "network", "wan", "proto", "static"); err != nil {
+		t.Fatalf("SetOption: %v", err)
+	}
+	logs, _, err := db.ListAuditLogs(map[string]interface{}{"impersonator": "admin"}, 10, 0)
+	if err != nil {
+		t.Fatalf("ListAuditLogs: %v", err)
+	}
+	var found bool
+	for _, log := range logs {
+		if log.Action == string(audit.ActionConfigWrite) {
+			found = log.Username == "oscar" && log.ImpersonatorID != nil
+		}
+	}
+	if !found {
+		t.Errorf("Expected the change audited as oscar impersonated by admin, got %+v", logs)
+	}
+
+	// Impersonated sessions end with the admin's rights
+	if err := db.DB.Model(&db.User{}).Where("username = ?", "admin").Update("enabled", false).Error; err != nil {
+		t.Fatal(err)
+	}
+	if _, err := oscar.Me(ctx); !client.IsStatus(err, http.StatusUnauthorized) {
+		t.Errorf("Expected 401 after disabling the admin, got %v", err)
+	}
+}
+
+func TestAuditContext(t *testing.T) {
+	server := newTestServer(t)
+	ctx := context.Background()
+	c := client.New(server.URL)
+
+	if _, err := c.Login(ctx, "admin", "test-password"); err != nil {
+		t.Fatalf("Login: %v", err)
+	}
+	if _, err := c.SetOption(ctx, "network", "wan", "proto", "static"); err != nil {
+		t.Fatalf("SetOption: %v", err)
+	}
+
+	// Handlers don't pass the user or IP, the request's audit context does
+	requestIDs := make(map[string]bool)
+	for _, action := range []audit.Action{audit.ActionUserLogin, audit.ActionConfigWrite} {
+		logs, _, err := db.ListAuditLogs(map[string]interface{}{"action": string(action)}, 1, 0)
+		if err != nil || len(logs) != 1 {
+			t.Fatalf("ListAuditLogs(%s) = %v, %v", action, logs, err)
+		}
+		log := logs[0]
+		if log.Username != "admin" || log.UserID == nil || log.IPAddress != "127.0.0.1" {
+			t.Errorf("Expected %s audited as admin from 127.0.0.1, got %+v", action, log)
+		}
+		if log.RequestID == "" || requestIDs[log.RequestID] {
+			t.Errorf("Expected %s with its own request ID, got %q", action, log.RequestID)
+		}
+		requestIDs[log.RequestID] = true
+
+		// The login request also audits the new device
+		byRequest, _, err := db.ListAuditLogs(map[string]interface{}{"request_id": log.RequestID}, 10, 0)
+		if err != nil || len(byRequest) == 0 || byRequest[len(byRequest)-1].ID != log.ID {
+			t.Errorf("ListAuditLogs(request_id %s) = %+v, %v", log.RequestID, byRequest, err)
+		}
+	}
+}
+
+func TestHealthScore(t *testing.T) {
+	server := newTestServer(t)
+	ctx := context.Background()
+	c := client.New(server.URL)
+
+	if _, err := c.Login(ctx, "admin", "test-password"); err != nil {
+		t.Fatalf("Login: %v", err)
+	}
+
+	// A rolled back transaction halves the transactions check
+	for _, status := range []string{string(transaction.StateCompleted), transaction.StatusRolledBack} {
+		if err := db.CreateTransaction(&db.Transaction{TxID: "tx-" + status, Username: "admin", Status: status}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	report, err := c.HealthScore(ctx)
+	if err != nil {
+		t.Fatalf("HealthScore: %v", err)
+	}
+	var transactions *client.HealthCheck
+	for i, check := range report.Checks {
+		if check.Name == "transactions" {
+			transactions = &report.Checks[i]
+		}
+	}
+	if transactions == nil || transactions.Score != 0.5 || report.Score >= 100 {
+		t.Errorf("Unexpected report: %+v", report)
+	}
+
+	// Metrics take an API key, also as a bearer token for Prometheus
+	const apiKey = "hf_test-metrics-key"
+	keyHash := sha256.Sum256([]byte(apiKey))
+	bcryptHash, err := auth.HashPassword(apiKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := db.CreateAPIKey(&db.APIKey{Key: bcryptHash, KeyHash: hex.EncodeToString(keyHash[:]), KeyID: "metrics",
+		Name: "prometheus", UserID: 1, Enabled: true}); err != nil {
+		t.Fatalf("CreateAPIKey: %v", err)
+	}
+
+	req, _ := http.NewRequest(http.MethodGet, server.URL+"/api/v1/metrics", nil)
+	req.Header.Set("Authorization", "Bearer "+apiKey)
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	body, _ := io.ReadAll(resp.Body)
+	want := fmt.Sprintf("hellfire_health_score %d\n", report.Score)
+	if resp.StatusCode != http.StatusOK || !strings.Contains(string(body), want) ||
+		!strings.Contains(string(body), `hellfire_health_check_score{check="transactions"} 0.5`) {
+		t.Errorf("Unexpected metrics (%d):\n%s", resp.StatusCode, body)
+	}
+}
+
+func TestStatsSummary(t *testing.T) {
+	server := newTestServer(t)
+	ctx := context.Background()
+	c := client.New(server.URL)
+
+	if _, err := c.Login(ctx, "admin", "test-password"); err != nil {
+		t.Fatalf("Login: %v", err)
+	}
+
+	now := time.Now()
+	rolledBack := now.Add(-time.Minute)
+	for _, tx := range []*db.Transaction{
+		{TxID: "tx-today", Status: string(transaction.StateCompleted)},
+		{TxID: "tx-rolledback", Status: transaction.StatusRolledBack, RolledBackAt: &rolledBack},
+		{TxID: "tx-failed", Status: string(transaction.StateFailed), CreatedAt: now.AddDate(0, 0, -3)},
+		{TxID: "tx-old", Status: string(transaction.StateFailed), CreatedAt: now.AddDate(0, 0, -10)},
+	} {
+		tx.Username = "admin"
+		if err := db.CreateTransaction(tx); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for i := 0; i < 2; i++ {
+		if err := db.CreateAuditLog(&db.AuditLog{Username: "mallory", Action: string(audit.ActionUserLogin),
+			Status: string(audit.StatusFailure), Resource: "auth"}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	summary, err := c.StatsSummary(ctx, 7)
+	if err != nil {
+		t.Fatalf("StatsSummary: %v", err)
+	}
+	if summary.Days != 7 || len(summary.Transactions) != 7 {
+		t.Fatalf("Expected 7 days, got %d with %d entries", summary.Days, len(summary.Transactions))
+	}
+	today, threeDaysAgo := summary.Transactions[6], summary.Transactions[3]
+	if today.Day != now.UTC().Format(time.DateOnly) || today.Total != 2 || today.Status[transaction.StatusRolledBack] != 1 {
+		t.Errorf("Unexpected counts for today: %+v", today)
+	}
+	if threeDaysAgo.Total != 1 || threeDaysAgo.Status[string(transaction.StateFailed)] != 1 {
+		t.Errorf("Unexpected counts for three days ago: %+v", threeDaysAgo)
+	}
+	if summary.TxStatus[string(transaction.StateFailed)] != 1 || summary.Rollbacks != 1 || summary.FailedLogins != 2 {
+		t.Errorf("Unexpected totals: %+v", summary)
+	}
+	if logins := summary.AuditActions[string(audit.ActionUserLogin)]; logins.Success < 1 || logins.Failure != 2 {
+		t.Errorf("Unexpected login counts: %+v", logins)
+	}
+
+	if _, err := c.StatsSummary(ctx, 1000); !client.IsStatus(err, http.StatusBadRequest) {
+		t.Errorf("Expected 400 for too many days, got %v", err)
+	}
+}
+
+func TestConfigRevisionConflict(t *testing.T) {
+	server := newTestServer(t)
+	ctx := context.Background()
+	c := client.New(server.URL)
+	if _, err := c.Login(ctx, "admin", "test-password"); err != nil {
+		t.Fatalf("Login: %v", err)
+	}
+
+	_, revision, err := c.GetConfigRevision(ctx, "network")
+	if err != nil || revision == "" {
+		t.Fatalf("GetConfigRevision: %q, %v", revision, err)
+	}
+
+	// The first writer succeeds and gets the new revision
+	result, err := c.SetOptionIf(ctx, "network", "wan", "proto", "static", revision)
+	if err != nil {
+		t.Fatalf("SetOptionIf: %v", err)
+	}
+	if result.Revision == "" || result.Revision == revision {
+		t.Errorf("Expected a new revision after the change, got %q", result.Revision)
+	}
+
+	// A second writer that read the same revision conflicts
+	_, err = c.SetOptionIf(ctx, "network", "lan", "proto", "dhcp", revision)
+	if !client.IsStatus(err, http.StatusConflict) {
+		t.Fatalf("Expected 409 for a stale revision, got %v", err)
+	}
+	_, err = c.BatchIf(ctx, []client.Operation{{Op: client.OpSet, Path: "network.lan.proto", Value: "dhcp"}},
+		map[string]string{"network": revision})
+	if !client.IsStatus(err, http.StatusConflict) {
+		t.Fatalf("Expected 409 for a stale batch revision, got %v", err)
+	}
+
+	// Writes based on the current revision go through
+	if _, err := c.BatchIf(ctx, []client.Operation{{Op: client.OpSet, Path: "network.lan.proto", Value: "dhcp"}},
+		map[string]string{"network": result.Revision}); err != nil {
+		t.Fatalf("BatchIf: %v", err)
+	}
+	cfg, err := c.GetConfig(ctx, "network")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if wan, _ := cfg["wan"].Option("proto"); wan != "static" {
+		t.Errorf("Expected wan proto static, got %q", wan)
+	}
+	if lan, _ := cfg["lan"].Option("proto"); lan != "dhcp" {
+		t.Errorf("Expected lan proto dhcp, got %q", lan)
+	}
+	if _, err := c.Revert(ctx); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestConfigLocks(t *testing.T) {
+	server := newTestServer(t)
+	ctx := context.Background()
+
+	hash, err := auth.HashPassword("operator-password")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := db.CreateUser(&db.User{Username: "oscar", PasswordHash: hash, Role: db.RoleOperator, Enabled: true}); err != nil {
+		t.Fatalf("CreateUser: %v", err)
+	}
+	admin := client.New(server.URL)
+	if _, err := admin.Login(ctx, "admin", "test-password"); err != nil {
+		t.Fatalf("Login: %v", err)
+	}
+	oscar := client.New(server.URL)
+	if _, err := oscar.Login(ctx, "oscar", "operator-password"); err != nil {
+		t.Fatalf("Login: %v", err)
+	}
+
+	lock, err := oscar.LockConfig(ctx, "network", 10*time.Minute, "Renumbering the LAN")
+	if err != nil {
+		t.Fatalf("LockConfig: %v", err)
+	}
+	if lock.Username != "oscar" || lock.Config != "network" || time.Until(lock.ExpiresAt) > 10*time.Minute {
+		t.Errorf("Unexpected lock: %+v", lock)
+	}
+	if _, err := oscar.LockConfig(ctx, "network", 0, ""); err != nil {
+		t.Errorf("Expected the holder to renew the lock, got %v", err)
+	}
+	if _, err := admin.LockConfig(ctx, "network", 0, ""); !client.IsStatus(err, http.StatusConflict) {
+		t.Errorf("Expected 409 locking a locked config, got %v", err)
+	}
+
+	// Writes by others go through with a warning, the holder's without
+	result, err := admin.SetOption(ctx, "network", "wan", "proto", "static")
+	if err != nil {
+		t.Fatalf("SetOption: %v", err)
+	}
+	if len(result.Warnings) != 1 || !strings.Contains(result.Warnings[0], "locked by oscar") {
+		t.Errorf("Expected a lock warning, got %v", result.Warnings)
+	}
+	if result, err := oscar.SetOption(ctx, "network", "wan", "proto", "dhcp"); err != nil || len(result.Warnings) != 0 {
+		t.Errorf("Expected no warning for the holder, got %v (%v)", result, err)
+	}
+	if _, err := admin.Revert(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	// Admins can break the lock
+	locks, err := admin.ConfigLocks(ctx)
+	if err != nil || len(locks) != 1 {
+		t.Fatalf("ConfigLocks: %v, %v", locks, err)
+	}
+	if err := admin.UnlockConfig(ctx, "network"); err != nil {
+		t.Fatalf("UnlockConfig: %v", err)
+	}
+
+	// But operators can't
+	if _, err := admin.LockConfig(ctx, "network", 0, ""); err != nil {
+		t.Fatalf("LockConfig: %v", err)
+	}
+	if err := oscar.UnlockConfig(ctx, "network"); !client.IsStatus(err, http.StatusForbidden) {
+		t.Errorf("Expected 403 breaking a lock as an operator, got %v", err)
+	}
+}
+
+func TestConfigGeneration(t *testing.T) {
+	server := newTestServer(t)
+	ctx := context.Background()
+	c := client.New(server.URL)
+	if _, err := c.Login(ctx, "admin", "test-password"); err != nil {
+		t.Fatalf("Login: %v", err)
+	}
+
+	if generation, err := c.ConfigGeneration(ctx); err != nil || generation != 0 {
+		t.Fatalf("Expected generation 0 before the first commit, got %d (%v)", generation, err)
+	}
+
+	// The stream starts with the current generation and follows each commit
+	streamCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	req, _ := http.NewRequestWithContext(streamCtx, http.MethodGet, server.URL+"/api/v1/config/generation", nil)
+	req.Header.Set("Accept", "text/event-stream")
+	req.Header.Set("Authorization", "Bearer "+c.Token())
+	req.Header.Set("User-Agent", "hellfire-client") // Sessions are bound to it
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	generations := make(chan uint64, 4)
+	go func() {
+		scanner := bufio.NewScanner(resp.Body)
+		for scanner.Scan() {
+			if data, ok := strings.CutPrefix(scanner.Text(), "data:"); ok {
+				var event struct{ Generation uint64 }
+				if json.Unmarshal([]byte(data), &event) == nil {
+					generations <- event.Generation
+				}
+			}
+		}
+	}()
+	next := func() uint64 {
+		select {
+		case generation := <-generations:
+			return generation
+		case <-time.After(5 * time.Second):
+			t.Fatal("No generation event")
+			return 0
+		}
+	}
+	if generation := next(); generation != 0 {
+		t.Errorf("Expected the stream to start at generation 0, got %d", generation)
+	}
+
+	if _, err := c.SetOption(ctx, "network", "lan", "netmask", "255.255.255.0"); err != nil {
+		t.Fatalf("SetOption: %v", err)
+	}
+	result, err := c.Commit(ctx, client.CommitRequest{Message: "LAN netmask"})
+	if err != nil {
+		t.Fatalf("Commit: %v", err)
+	}
+	if result.Generation != 1 {
+		t.Errorf("Expected generation 1 after the commit, got %d", result.Generation)
+	}
+	if generation := next(); generation != 1 {
+		t.Errorf("Expected the stream to report generation 1, got %d", generation)
+	}
+
+	// Config responses carry it in a header
+	req, _ = http.NewRequest(http.MethodGet, server.URL+"/api/v1/config/network", nil)
+	req.Header.Set("Authorization", "Bearer "+c.Token())
+	req.Header.Set("User-Agent", "hellfire-client")
+	configResp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	configResp.Body.Close()
+	if header := configResp.Header.Get("X-Config-Generation"); header != "1" {
+		t.Errorf("Expected X-Config-Generation 1, got %q", header)
+	}
+}
+
+func TestSecretOptions(t *testing.T) {
+	server := newTestServer(t)
+	ctx := context.Background()
+
+	hash, err := auth.HashPassword("viewer-password")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := db.CreateUser(&db.User{Username: "vera", PasswordHash: hash, Role: db.RoleViewer, Enabled: true}); err != nil {
+		t.Fatalf("CreateUser: %v", err)
+	}
+
+	admin := client.New(server.URL)
+	if _, err := admin.Login(ctx, "admin", "test-password"); err != nil {
+		t.Fatalf("Login: %v", err)
+	}
+	viewer := client.New(server.URL)
+	if _, err := viewer.Login(ctx, "vera", "viewer-password"); err != nil {
+		t.Fatalf("Login: %v", err)
+	}
+
+	// The PPPoE password is in plaintext until committed, but masked for viewers
+	if _, err := admin.SetOption(ctx, "network", "wan", "password", "hunter2"); err != nil {
+		t.Fatalf("SetOption: %v", err)
+	}
+	if value, err := admin.GetOption(ctx, "network", "wan", "password"); err != nil || value != "hunter2" {
+		t.Errorf("Staged password = %q, %v", value, err)
+	}
+	if value, err := viewer.GetOption(ctx, "network", "wan", "password"); err != nil || value != secrets.Mask {
+		t.Errorf("Staged password for viewer = %q, %v", value, err)
+	}
+
+	// Diffs and audit entries never show it
+	dryRun, err := admin.DryRun(ctx)
+	if err != nil {
+		t.Fatalf("DryRun: %v", err)
+	}
+	if diff := dryRun.Diffs["network"]; !strings.Contains(diff, "+\toption 'password' '******** (changed)'\n") || strings.Contains(diff, "hunter2") {
+		t.Errorf("Expected the password masked in the diff, got:\n%s", diff)
+	}
+	logs, _, err := db.ListAuditLogs(map[string]interface{}{"action": string(audit.ActionConfigWrite)}, 10, 0)
+	if err != nil || len(logs) == 0 {
+		t.Fatalf("ListAuditLogs: %v, %d entries", err, len(logs))
+	}
+	for _, log := range logs {
+		if strings.Contains(log.Message, "hunter2") || strings.Contains(log.Details, "hunter2") {
+			t.Errorf("Password in audit entry: %+v", log)
+		}
+	}
+
+	if _, err := admin.Commit(ctx, client.CommitRequest{Message: "PPPoE password"}); err != nil {
+		t.Fatalf("Commit: %v", err)
+	}
+	value, err := admin.GetOption(ctx, "network", "wan", "password")
+	if err != nil || !secrets.IsSealed(value) || strings.Contains(value, "hunter2") {
+		t.Errorf("Committed password = %q, %v, want it encrypted", value, err)
+	}
+
+	cfg, err := viewer.GetConfig(ctx, "network")
+	if err != nil {
+		t.Fatalf("GetConfig: %v", err)
+	}
+	if wan := cfg["wan"]; wan["password"] != secrets.Mask || wan["proto"] != "dhcp" {
+		t.Errorf("Expected only the password masked for viewers, got %+v", wan)
+	}
+}
+
+func TestConfigACLs(t *testing.T) {
+	hfConfig := hfconfig.DefaultConfig()
+	hfConfig.ACLs = []hfconfig.ConfigACL{
+		{Config: "network", Section: "wan", Write: []string{"admin"}},
+		{Config: "network", Section: "lan", Read: []string{"admin", "operator"}},
+		{Config: "dhcp", Read: []string{"admin"}},
+	}
+	server := newTestServerConfig(t, appliers.NewRegistry(), hfConfig)
+	ctx := context.Background()
+
+	for username, role := range map[string]db.Role{"oscar": db.RoleOperator, "vera": db.RoleViewer} {
+		hash, err := auth.HashPassword(username + "-password")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := db.CreateUser(&db.User{Username: username, PasswordHash: hash, Role: role, Enabled: true}); err != nil {
+			t.Fatalf("CreateUser: %v", err)
+		}
+	}
+	operator := client.New(server.URL)
+	if _, err := operator.Login(ctx, "oscar", "oscar-password"); err != nil {
+		t.Fatalf("Login: %v", err)
+	}
+	viewer := client.New(server.URL)
+	if _, err := viewer.Login(ctx, "vera", "vera-password"); err != nil {
+		t.Fatalf("Login: %v", err)
+	}
+
+	// Operators see both interfaces but may only change the LAN
+	cfg, err := operator.GetConfig(ctx, "network")
+	if err != nil {
+		t.Fatalf("GetConfig: %v", err)
+	}
+	if cfg["wan"].Writable() || !cfg["lan"].Writable() || len(cfg["wan"].Permissions()) != 1 {
+		t.Errorf("Unexpected operator permissions: wan %v, lan %v", cfg["wan"].Permissions(), cfg["lan"].Permissions())
+	}
+	if _, err := operator.SetOption(ctx, "network", "wan", "proto", "static"); !client.IsStatus(err, http.StatusForbidden) {
+		t.Errorf("Expected 403 setting the WAN, got %v", err)
+	}
+	if _, err := operator.Batch(ctx, []client.Operation{{Op: config.OpSet, Path: "network.@interface[0].proto", Value: "static"}}); !client.IsStatus(err, http.StatusForbidden) {
+		t.Errorf("Expected 403 setting the WAN by reference, got %v", err)
+	}
+	if _, err := operator.SetOption(ctx, "network", "lan", "netmask", "255.255.255.0"); err != nil {
+		t.Errorf("SetOption lan: %v", err)
+	}
+	if _, err := operator.GetConfig(ctx, "dhcp"); !client.IsStatus(err, http.StatusNotFound) {
+		t.Errorf("Expected dhcp hidden from operators, got %v", err)
+	}
+
+	// Viewers don't see the LAN at all
+	cfg, err = viewer.GetConfig(ctx, "network")
+	if err != nil {
+		t.Fatalf("GetConfig: %v", err)
+	}
+	if _, ok := cfg["lan"]; ok || cfg["wan"].Writable() {
+		t.Errorf("Unexpected viewer config: %+v", cfg)
+	}
+	if _, err := viewer.GetSection(ctx, "network", "lan"); !client.IsStatus(err, http.StatusNotFound) {
+		t.Errorf("Expected 404 for the LAN section, got %v", err)
+	}
+	if _, err := viewer.GetOption(ctx, "network", "lan", "ipaddr"); !client.IsStatus(err, http.StatusNotFound) {
+		t.Errorf("Expected 404 for a LAN option, got %v", err)
+	}
+
+	// Task commands run as root, so only admins write the tasks config
+	if _, err := operator.SetOption(ctx, "tasks", "backup", "command", "id"); !client.IsStatus(err, http.StatusForbidden) {
+		t.Errorf("Expected 403 setting a task command, got %v", err)
+	}
+	if _, err := operator.Batch(ctx, []client.Operation{{Op: config.OpSet, Path: "tasks.backup.command", Value: "id"}}); !client.IsStatus(err, http.StatusForbidden) {
+		t.Errorf("Expected 403 setting a task command in a batch, got %v", err)
+	}
+}
+
+func TestWireGuard(t *testing.T) {
+	server := newTestServer(t)
+	ctx := context.Background()
+
+	hash, err := auth.HashPassword("operator-password")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := db.CreateUser(&db.User{Username: "oscar", PasswordHash: hash, Role: db.RoleOperator, Enabled: true}); err != nil {
+		t.Fatalf("CreateUser: %v", err)
+	}
+	admin := client.New(server.URL)
+	if _, err := admin.Login(ctx, "admin", "test-password"); err != nil {
+		t.Fatalf("Login: %v", err)
+	}
+	operator := client.New(server.URL)
+	if _, err := operator.Login(ctx, "oscar", "operator-password"); err != nil {
+		t.Fatalf("Login: %v", err)
+	}
+
+	serverKeys, err := admin.GenerateWireGuardKeys(ctx, false)
+	if err != nil {
+		t.Fatalf("GenerateWireGuardKeys: %v", err)
+	}
+	if _, err := admin.Batch(ctx, []client.Operation{
+		{Op: config.OpSet, Path: "network.wg0", Value: "interface"},
+		{Op: config.OpSet, Path: "network.wg0.proto", Value: "wireguard"},
+		{Op: config.OpSet, Path: "network.wg0.private_key", Value: serverKeys.PrivateKey},
+		{Op: config.OpSet, Path: "network.wg0.listen_port", Value: "51820"},
+		{Op: config.OpAdd, Path: "network.wireguard_wg0"},
+		{Op: config.OpSet, Path: "network.@wireguard_wg0[0].description", Value: "phone"},
+		{Op: config.OpAddList, Path: "network.@wireguard_wg0[0].allowed_ips", Value: "10.8.0.2/32"},
+	}); err != nil {
+		t.Fatalf("Batch: %v", err)
+	}
+
+	// Exporting needs the peer's keys first, and is admin only
+	opts := wireguard.ClientOptions{Endpoint: "vpn.example.com", DNS: []string{"10.8.0.1"}}
+	if _, err := admin.PeerConfig(ctx, "wg0", "phone", opts); !client.IsStatus(err, http.StatusBadRequest) {
+		t.Errorf("Expected 400 exporting a peer without keys, got %v", err)
+	}
+	publicKey, err := operator.GeneratePeerKeys(ctx, "wg0", "phone", true)
+	if err != nil {
+		t.Fatalf("GeneratePeerKeys: %v", err)
+	}
+	if _, err := operator.PeerConfig(ctx, "wg0", "phone", opts); !client.IsStatus(err, http.StatusForbidden) {
+		t.Errorf("Expected 403 exporting as an operator, got %v", err)
+	}
+	if _, err := admin.PeerConfig(ctx, "wg0", "tablet", opts); !client.IsStatus(err, http.StatusNotFound) {
+		t.Errorf("Expected 404 for an unknown peer, got %v", err)
+	}
+
+	if _, err := admin.Commit(ctx, client.CommitRequest{Message: "WireGuard"}); err != nil {
+		t.Fatalf("Commit: %v", err)
+	}
+	peerKey, err := admin.GetOption(ctx, "network", "@wireguard_wg0[0]", "private_key")
+	if err != nil || !secrets.IsSealed(peerKey) {
+		t.Errorf("Committed peer private key = %q, %v, want it encrypted", peerKey, err)
+	}
+
+	exported, err := admin.PeerConfig(ctx, "wg0", "phone", opts)
+	if err != nil {
+		t.Fatalf("PeerConfig: %v", err)
+	}
+	for _, want := range []string{
+		"Address = 10.8.0.2/32\n",
+		"DNS = 10.8.0.1\n",
+		"PublicKey = " + serverKeys.PublicKey + "\n",
+		"PresharedKey = ",
+		"Endpoint = vpn.example.com:51820\n",
+		"AllowedIPs = 0.0.0.0/0, ::/0\n",
+	} {
+		if !strings.Contains(exported.Config, want) {
+			t.Errorf("Expected %q in the client config:\n%s", want, exported.Config)
+		}
+	}
+	if strings.Contains(exported.Config, secrets.Prefix) || exported.Filename != "wg0-phone.conf" {
+		t.Errorf("Unexpected export %q:\n%s", exported.Filename, exported.Config)
+	}
+
+	// The status lists the configured peer whether or not wg is installed
+	status, err := operator.WireGuardStatus(ctx)
+	if err != nil {
+		t.Fatalf("WireGuardStatus: %v", err)
+	}
+	if len(status.Interfaces) == 0 || status.Interfaces[0].Name != "wg0" || len(status.Interfaces[0].Peers) != 1 {
+		t.Fatalf("Unexpected status: %+v", status)
+	}
+	if peer := status.Interfaces[0].Peers[0]; peer.Description != "phone" || peer.PublicKey != publicKey {
+		t.Errorf("Unexpected peer: %+v", peer)
+	}
+}
+
+func TestVPNKillSwitch(t *testing.T) {
+	sys := appliers.NewSimulatedSystem("wan", "lan")
+	registry := appliers.NewRegistry()
+	for _, applier := range sys.Appliers() {
+		registry.Register(applier)
+	}
+	server := newTestServerWith(t, registry)
+	ctx := context.Background()
+	c := client.New(server.URL)
+	if _, err := c.Login(ctx, "admin", "test-password"); err != nil {
+		t.Fatalf("Login: %v", err)
+	}
+
+	keys, err := c.GenerateWireGuardKeys(ctx, false)
+	if err != nil {
+		t.Fatalf("GenerateWireGuardKeys: %v", err)
+	}
+	vpn := []client.Operation{
+		{Op: config.OpSet, Path: "network.lan.netmask", Value: "255.255.255.0"},
+		{Op: config.OpSet, Path: "network.wg0", Value: "interface"},
+		{Op: config.OpSet, Path: "network.wg0.proto", Value: "wireguard"},
+		{Op: config.OpSet, Path: "network.wg0.private_key", Value: keys.PrivateKey},
+		{Op: config.OpSet, Path: "network.wg0.route_all", Value: "true"},
+		{Op: config.OpSet, Path: "network.wg0.kill_switch", Value: "1"},
+		{Op: config.OpAddList, Path: "network.wg0.split_tunnel", Value: "192.168.50.0/24"},
+	}
+
+	// The kill switch needs a WAN zone to block
+	if _, err := c.Batch(ctx, vpn); err != nil {
+		t.Fatalf("Batch: %v", err)
+	}
+	if _, err := c.Commit(ctx, client.CommitRequest{Message: "VPN"}); err == nil || len(sys.Applies()) > 0 {
+		t.Fatalf("Expected the commit refused without a WAN zone, got %v (applied %v)", err, sys.Applies())
+	}
+	if _, err := c.Batch(ctx, []client.Operation{
+		{Op: config.OpSet, Path: "firewall.wan", Value: "zone"},
+		{Op: config.OpSet, Path: "firewall.wan.name", Value: "wan"},
+		{Op: config.OpSet, Path: "firewall.wan.masq", Value: "1"},
+		{Op: config.OpAddList, Path: "firewall.wan.network", Value: "wan"},
+	}); err != nil {
+		t.Fatalf("Batch: %v", err)
+	}
+	if _, err := c.Commit(ctx, client.CommitRequest{Message: "VPN and WAN zone"}); err != nil {
+		t.Fatalf("Commit: %v", err)
+	}
+	if applies := sys.Applies(); !slices.Equal(applies, []string{"firewall", "network"}) {
+		t.Errorf("Expected the firewall applied before the network, got %v", applies)
+	}
+	if policies := sys.VPN(); len(policies) != 1 || !policies[0].RouteAll || !policies[0].KillSwitch {
+		t.Errorf("Expected wg0 routing all traffic, got %+v", policies)
+	}
+	if !strings.Contains(sys.Ruleset(), "oifname { \"wan\" } ip daddr != { 192.168.50.0/24 } drop\n") {
+		t.Errorf("Expected the kill switch in the ruleset, got:\n%s", sys.Ruleset())
+	}
+
+	// Lifting the kill switch re-applies the unchanged firewall too
+	if _, err := c.SetOption(ctx, "network", "wg0", "kill_switch", "0"); err != nil {
+		t.Fatalf("SetOption: %v", err)
+	}
+	dryRun, err := c.DryRun(ctx)
+	if err != nil {
+		t.Fatalf("DryRun: %v", err)
+	}
+	if !slices.Equal(dryRun.Apply, []string{"firewall", "network"}) || len(dryRun.Artifacts["firewall"]) != 1 {
+		t.Errorf("Unexpected dry run: %+v", dryRun)
+	}
+	if _, err := c.Commit(ctx, client.CommitRequest{Message: "No kill switch"}); err != nil {
+		t.Fatalf("Commit: %v", err)
+	}
+	if strings.Contains(sys.Ruleset(), "Kill switch") {
+		t.Errorf("Expected the kill switch lifted, got:\n%s", sys.Ruleset())
+	}
+
+	// Other network changes leave the firewall alone
+	applied := len(sys.Applies())
+	if _, err := c.SetOption(ctx, "network", "lan", "ipaddr", "192.168.2.1"); err != nil {
+		t.Fatalf("SetOption: %v", err)
+	}
+	if _, err := c.Commit(ctx, client.CommitRequest{Message: "Renumber LAN"}); err != nil {
+		t.Fatalf("Commit: %v", err)
+	}
+	if applies := sys.Applies()[applied:]; !slices.Equal(applies, []string{"network"}) {
+		t.Errorf("Expected only the network applied, got %v", applies)
+	}
+}
+
+func TestDNSRecords(t *testing.T) {
+	server := newTestServer(t)
+	ctx := context.Background()
+	c := client.New(server.URL)
+	if _, err := c.Login(ctx, "admin", "test-password"); err != nil {
+		t.Fatalf("Login: %v", err)
+	}
+
+	if _, err := c.Batch(ctx, []client.Operation{
+		{Op: config.OpSet, Path: "dhcp.main", Value: "dnsmasq"},
+		{Op: config.OpAddList, Path: "dhcp.main.server", Value: "1.1.1.1"},
+		{Op: config.OpAddList, Path: "dhcp.main.server", Value: "/corp.example/10.0.0.1"},
+		{Op: config.OpAddList, Path: "dhcp.main.local_zone", Value: "home.arpa"},
+	}); err != nil {
+		t.Fatalf("Batch: %v", err)
+	}
+
+	nas, err := c.AddDNSRecord(ctx, dns.Record{Type: dns.TypeA, Name: "nas.home.arpa", Value: "192.168.1.10"})
+	if err != nil {
+		t.Fatalf("AddDNSRecord: %v", err)
+	}
+	if nas.ID != "@domain[0]" {
+		t.Errorf("Expected the record @domain[0], got %s", nas.ID)
+	}
+	files, err := c.AddDNSRecord(ctx, dns.Record{Type: dns.TypeCNAME, Name: "files.home.arpa", Value: "nas.home.arpa"})
+	if err != nil {
+		t.Fatalf("AddDNSRecord: %v", err)
+	}
+
+	// A name with a CNAME can't have other records, and values must fit types
+	for _, record := range []dns.Record{
+		{Type: dns.TypeA, Name: "files.home.arpa", Value: "192.168.1.11"},
+		{Type: dns.TypeAAAA, Name: "printer.home.arpa", Value: "192.168.1.12"},
+		{Type: "MX", Name: "home.arpa", Value: "nas.home.arpa"},
+	} {
+		if _, err := c.AddDNSRecord(ctx, record); err == nil {
+			t.Errorf("Expected %+v refused", record)
+		}
+	}
+	if _, err := c.UpdateDNSRecord(ctx, files.ID, dns.Record{Type: dns.TypeA, Name: "files.home.arpa", Value: "192.168.1.11"}); err == nil {
+		t.Error("Expected a CNAME refused to become an A record")
+	}
+	if _, err := c.UpdateDNSRecord(ctx, nas.ID, dns.Record{Type: dns.TypeAAAA, Name: "nas.home.arpa", Value: "fd00::10"}); err != nil {
+		t.Fatalf("UpdateDNSRecord: %v", err)
+	}
+
+	settings, err := c.DNS(ctx)
+	if err != nil {
+		t.Fatalf("DNS: %v", err)
+	}
+	if len(settings.Servers) != 2 || !slices.Equal(settings.Servers[1].Domains, []string{"corp.example"}) ||
+		settings.Servers[1].Address != "10.0.0.1" {
+		t.Errorf("Unexpected servers: %+v", settings.Servers)
+	}
+	if !slices.Equal(settings.LocalZones, []string{"home.arpa"}) {
+		t.Errorf("Unexpected local zones: %v", settings.LocalZones)
+	}
+	want := []dns.Record{
+		{ID: "@domain[0]", Type: dns.TypeAAAA, Name: "nas.home.arpa", Value: "fd00::10"},
+		{ID: "@cname[0]", Type: dns.TypeCNAME, Name: "files.home.arpa", Value: "nas.home.arpa"},
+	}
+	if !slices.Equal(settings.Records, want) {
+		t.Errorf("Expected records %+v, got %+v", want, settings.Records)
+	}
+
+	if err := c.DeleteDNSRecord(ctx, files.ID); err != nil {
+		t.Fatalf("DeleteDNSRecord: %v", err)
+	}
+	if err := c.DeleteDNSRecord(ctx, files.ID); err == nil {
+		t.Error("Expected deleting a missing record to fail")
+	}
+	records, err := c.DNSRecords(ctx)
+	if err != nil {
+		t.Fatalf("DNSRecords: %v", err)
+	}
+	if !slices.Equal(records, want[:1]) {
+		t.Errorf("Expected records %+v, got %+v", want[:1], records)
+	}
+}
+
+func TestDNSHosts(t *testing.T) {
+	server := newTestServer(t)
+	ctx := context.Background()
+	c := client.New(server.URL)
+	if _, err := c.Login(ctx, "admin", "test-password"); err != nil {
+		t.Fatalf("Login: %v", err)
+	}
+
+	if _, err := c.Batch(ctx, []client.Operation{
+		{Op: config.OpSet, Path: "dhcp.main", Value: "dnsmasq"},
+		{Op: config.OpSet, Path: "dhcp.main.domain", Value: "lan"},
+		{Op: config.OpSet, Path: "dhcp.printer", Value: "host"},
+		{Op: config.OpSet, Path: "dhcp.printer.name", Value: "printer"},
+		{Op: config.OpSet, Path: "dhcp.printer.mac", Value: "02:00:00:00:00:01"},
+		{Op: config.OpSet, Path: "dhcp.printer.ip", Value: "192.168.1.20"},
+	}); err != nil {
+		t.Fatalf("Batch: %v", err)
+	}
+	nas, err := c.AddDNSHost(ctx, "nas", "fd00::10")
+	if err != nil {
+		t.Fatalf("AddDNSHost: %v", err)
+	}
+	if nas.Type != dns.TypeAAAA {
+		t.Errorf("Expected an AAAA record, got %+v", nas)
+	}
+
+	hosts, err := c.DNSHosts(ctx)
+	if err != nil {
+		t.Fatalf("DNSHosts: %v", err)
+	}
+	want := []dns.Host{
+		{ID: "@domain[0]", Address: "fd00::10", Names: []string{"nas"}},
+		{ID: "printer", Address: "192.168.1.20", Names: []string{"printer.lan", "printer"}, Lease: true},
+	}
+	if hosts.Domain != "lan" || len(hosts.Hosts) != 2 ||
+		!slices.Equal(hosts.Hosts[0].Names, want[0].Names) || !slices.Equal(hosts.Hosts[1].Names, want[1].Names) ||
+		hosts.Hosts[1].ID != want[1].ID || !hosts.Hosts[1].Lease {
+		t.Errorf("Expected hosts %+v, got %+v", want, hosts)
+	}
+
+	// Leases are registered until removed or their dns option is 0
+	if err := c.DeleteDNSHost(ctx, "printer"); err == nil {
+		t.Error("Expected deleting the entry of a static lease to fail")
+	}
+	if _, err := c.SetOption(ctx, "dhcp", "printer", "dns", "false"); err != nil {
+		t.Fatalf("SetOption: %v", err)
+	}
+	if err := c.DeleteDNSHost(ctx, nas.ID); err != nil {
+		t.Fatalf("DeleteDNSHost: %v", err)
+	}
+	if hosts, err := c.DNSHosts(ctx); err != nil || len(hosts.Hosts) != 0 {
+		t.Errorf("Expected no hosts entries, got %+v, %v", hosts, err)
+	}
+}
+
+func TestDNSStats(t *testing.T) {
+	server := newTestServer(t)
+	ctx := context.Background()
+	c := client.New(server.URL)
+	if _, err := c.Login(ctx, "admin", "test-password"); err != nil {
+		t.Fatalf("Login: %v", err)
+	}
+
+	stats, err := c.DNSStats(ctx, nil)
+	if err != nil {
+		t.Fatalf("DNSStats: %v", err)
+	}
+	if stats.Enabled || stats.Collecting || stats.Total != 0 {
+		t.Errorf("Expected no queries without the collector, got %+v", stats)
+	}
+
+	dnsQueryLog = dns.NewQueryLog(dns.DefaultQueryLogSize)
+	t.Cleanup(func() { dnsQueryLog = nil })
+	now := time.Now()
+	dnsQueryLog.Record("1 192.168.1.5/53214 query[A] ads.example from 192.168.1.5", now)
+	dnsQueryLog.Record("1 192.168.1.5/53214 config ads.example is NXDOMAIN", now)
+	dnsQueryLog.Record("2 192.168.1.6/53215 query[A] example.com from 192.168.1.6", now)
+
+	if _, err := c.Batch(ctx, []client.Operation{
+		{Op: config.OpAdd, Path: "dhcp.dnsmasq"},
+		{Op: config.OpSet, Path: "dhcp.@dnsmasq[0].logqueries", Value: "on"},
+	}); err != nil {
+		t.Fatalf("Batch: %v", err)
+	}
+	if _, err := c.Commit(ctx, client.CommitRequest{Message: "Log queries"}); err != nil {
+		t.Fatalf("Commit: %v", err)
+	}
+
+	stats, err = c.DNSStats(ctx, url.Values{"since": {"1h"}, "limit": {"1"}})
+	if err != nil {
+		t.Fatalf("DNSStats: %v", err)
+	}
+	if !stats.Enabled || !stats.Collecting || stats.Total != 2 || stats.Blocked != 1 ||
+		len(stats.TopClients) != 1 || !slices.Equal(stats.TopBlocked, []dns.Count{{Name: "ads.example", Queries: 1}}) {
+		t.Errorf("Unexpected stats: %+v", stats)
+	}
+	if _, err := c.DNSStats(ctx, url.Values{"since": {"soon"}}); err == nil {
+		t.Error("Expected an invalid since to fail")
+	}
+}
+
+func TestDHCPPools(t *testing.T) {
+	server := newTestServer(t)
+	ctx := context.Background()
+	c := client.New(server.URL)
+	if _, err := c.Login(ctx, "admin", "test-password"); err != nil {
+		t.Fatalf("Login: %v", err)
+	}
+
+	leaseFile := filepath.Join(t.TempDir(), "dnsmasq.leases")
+	expires := time.Now().Add(time.Hour).Unix()
+	leases := fmt.Sprintf("%d 02:00:00:00:00:01 192.168.1.100 phone *\n%d 02:00:00:00:00:02 192.168.1.101 * *\n", expires, expires)
+	if err := os.WriteFile(leaseFile, []byte(leases), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.Batch(ctx, []client.Operation{
+		{Op: config.OpSet, Path: "network.lan.netmask", Value: "255.255.255.0"},
+		{Op: config.OpAdd, Path: "dhcp.dnsmasq"},
+		{Op: config.OpSet, Path: "dhcp.@dnsmasq[0].leasefile", Value: leaseFile},
+		{Op: config.OpSet, Path: "dhcp.lan", Value: "dhcp"},
+		{Op: config.OpSet, Path: "dhcp.lan.interface", Value: "lan"},
+		{Op: config.OpSet, Path: "dhcp.lan.start", Value: "100"},
+		{Op: config.OpSet, Path: "dhcp.lan.limit", Value: "4"},
+		{Op: config.OpSet, Path: "dhcp.printer", Value: "host"},
+		{Op: config.OpSet, Path: "dhcp.printer.mac", Value: "02:00:00:00:00:03"},
+		{Op: config.OpSet, Path: "dhcp.printer.ip", Value: "192.168.1.102"},
+	}); err != nil {
+		t.Fatalf("Batch: %v", err)
+	}
+
+	// Only the committed pools count
+	if pools, err := c.DHCPPools(ctx); err != nil || len(pools) != 0 {
+		t.Fatalf("Expected no pools before the commit, got %+v, %v", pools, err)
+	}
+	if _, err := c.Commit(ctx, client.CommitRequest{Message: "LAN pool"}); err != nil {
+		t.Fatalf("Commit: %v", err)
+	}
+
+	pools, err := c.DHCPPools(ctx)
+	if err != nil {
+		t.Fatalf("DHCPPools: %v", err)
+	}
+	if len(pools) != 1 || pools[0].Start != "192.168.1.100" || pools[0].End != "192.168.1.103" ||
+		pools[0].Used != 3 || pools[0].Percent != 75 || pools[0].Exhausting {
+		t.Errorf("Unexpected pools: %+v", pools)
+	}
+}
+
+func TestSnapshotStorage(t *testing.T) {
+	server := newTestServer(t)
+	ctx := context.Background()
+	c := client.New(server.URL)
+	if _, err := c.Login(ctx, "admin", "test-password"); err != nil {
+		t.Fatalf("Login: %v", err)
+	}
+
+	if _, err := c.SetOption(ctx, "network", "wan", "ipaddr", "203.0.113.2"); err != nil {
+		t.Fatalf("SetOption: %v", err)
+	}
+	if _, err := c.Commit(ctx, client.CommitRequest{Message: "Static WAN"}); err != nil {
+		t.Fatalf("Commit: %v", err)
+	}
+	snapshots, err := c.ListSnapshots(ctx)
+	if err != nil || snapshots.Count != 1 {
+		t.Fatalf("ListSnapshots: %v (%+v)", err, snapshots)
+	}
+
+	snap, err := c.ProtectSnapshot(ctx, snapshots.Snapshots[0].ID, true)
+	if err != nil {
+		t.Fatalf("ProtectSnapshot: %v", err)
+	}
+	if !snap.Protected {
+		t.Errorf("Expected the snapshot protected, got %+v", snap)
+	}
+	if _, err := c.ProtectSnapshot(ctx, "missing", true); !client.IsStatus(err, http.StatusNotFound) {
+		t.Errorf("Expected protecting a missing snapshot to be not found, got %v", err)
+	}
+
+	storage, err := c.Storage(ctx)
+	if err != nil {
+		t.Fatalf("Storage: %v", err)
+	}
+	if storage.Snapshots == nil || storage.Snapshots.Snapshots != 1 || storage.Snapshots.Protected != 1 || storage.Snapshots.Used == 0 ||
+		storage.Snapshots.MinFree != hfconfig.DefaultSnapshotMinFree<<20 || len(storage.FileSystems) == 0 {
+		t.Errorf("Unexpected storage: %+v", storage)
+	}
+}
+
+func TestCommitAndRollbackSimulated(t *testing.T) {
+	sys := appliers.NewSimulatedSystem("wan", "lan")
+	registry := appliers.NewRegistry()
+	for _, applier := range sys.Appliers() {
+		registry.Register(applier)
+	}
+	server := newTestServerWith(t, registry)
+	ctx := context.Background()
+	c := client.New(server.URL)
+	if _, err := c.Login(ctx, "admin", "test-password"); err != nil {
+		t.Fatalf("Login: %v", err)
+	}
+
+	if _, err := c.SetOption(ctx, "network", "lan", "netmask", "255.255.255.0"); err != nil {
+		t.Fatalf("SetOption: %v", err)
+	}
+
+	// A dry run shows the commit without applying it
+	dryRun, err := c.DryRun(ctx)
+	if err != nil {
+		t.Fatalf("DryRun: %v", err)
+	}
+	if !dryRun.Valid || len(dryRun.Apply) != 1 || dryRun.Apply[0] != "network" {
+		t.Errorf("Unexpected dry run: %+v", dryRun)
+	}
+	if !strings.Contains(dryRun.Diffs["network"], "+\toption 'netmask' '255.255.255.0'\n") {
+		t.Errorf("Expected the netmask in the diff, got:\n%s", dryRun.Diffs["network"])
+	}
+	// The DHCP wan brings the DNS servers of its lease
+	if artifacts := dryRun.Artifacts["network"]; len(artifacts) != 2 || !strings.Contains(artifacts[0].Content, "ip addr add 192.168.1.1/24 dev lan\n") ||
+		!strings.Contains(artifacts[1].Content, "# and the DNS servers of the DHCP lease on wan\n") {
+		t.Errorf("Expected the lan address and the wan DNS servers in the network artifacts, got %+v", artifacts)
+	}
+	if lan, _ := sys.Link("lan"); lan.Proto != "" {
+		t.Errorf("Expected lan untouched by the dry run, got %+v", lan)
+	}
+
+	if _, err := c.Commit(ctx, client.CommitRequest{Message: "LAN netmask"}); err != nil {
+		t.Fatalf("Commit: %v", err)
+	}
+	if lan, _ := sys.Link("lan"); !lan.Up || lan.Address != "192.168.1.1/24" {
+		t.Errorf("Expected lan up with 192.168.1.1/24, got %+v", lan)
+	}
+	if wan, _ := sys.Link("wan"); wan.Proto != "dhcp" {
+		t.Errorf("Expected wan on DHCP, got %+v", wan)
+	}
+
+	// A change that doesn't validate is rolled back, on the system and on disk
+	sys.FailValidation("network", errors.New("address not assigned"))
+	if _, err := c.SetOption(ctx, "network", "lan", "ipaddr", "10.0.0.1"); err != nil {
+		t.Fatalf("SetOption: %v", err)
+	}
+	if _, err := c.Commit(ctx, client.CommitRequest{Message: "Renumber LAN"}); err == nil {
+		t.Fatal("Expected commit to fail validation")
+	}
+	if lan, _ := sys.Link("lan"); lan.Address != "192.168.1.1/24" {
+		t.Errorf("Expected lan address rolled back to 192.168.1.1/24, got %q", lan.Address)
+	}
+	if value, err := c.GetOption(ctx, "network", "lan", "ipaddr"); err != nil || value != "192.168.1.1" {
+		t.Errorf("Expected committed ipaddr rolled back to 192.168.1.1, got %q (%v)", value, err)
+	}
+	sys.FailValidation("network", nil)
+
+	// The rolled back transaction archives what it pushed, then what undid it
+	txs, _, err := db.ListTransactions(map[string]interface{}{"status": "rolledback"}, 1, 0)
+	if err != nil || len(txs) != 1 {
+		t.Fatalf("ListTransactions: %v (%d)", err, len(txs))
+	}
+	archive, err := c.TransactionArtifacts(ctx, txs[0].TxID)
+	if err != nil {
+		t.Fatalf("TransactionArtifacts: %v", err)
+	}
+	if len(archive.Artifacts) == 0 || archive.Artifacts[0].Stage != "apply" ||
+		!strings.Contains(archive.Artifacts[0].Content, "ip addr add 10.0.0.1/24 dev lan\n") {
+		t.Errorf("Expected the applied renumbering archived first, got %+v", archive.Artifacts)
+	}
+
+	// And the system state before the renumbering and after it, before the rollback
+	captures, err := db.ListTransactionCaptures(txs[0].TxID)
+	if err != nil || len(captures) != 2 || captures[0].Stage != transaction.CaptureBefore || captures[1].Stage != transaction.CaptureAfter {
+		t.Fatalf("Expected system state dumps before and after the apply, got %+v (%v)", captures, err)
+	}
+	for i, want := range []string{"lan: up proto static address 192.168.1.1/24", "lan: up proto static address 10.0.0.1/24"} {
+		if text, err := transaction.CaptureText(&captures[i]); err != nil || !strings.Contains(text, want) {
+			t.Errorf("Expected %q in the system state %s the apply, got:\n%s (%v)", want, captures[i].Stage, text, err)
+		}
+	}
+
+	// Interfaces without a device are refused before anything is applied
+	if _, err := c.Revert(ctx); err != nil {
+		t.Fatalf("Revert: %v", err)
+	}
+	if _, err := c.Batch(ctx, []client.Operation{
+		{Op: client.OpSet, Path: "network.eth9", Value: "interface"},
+		{Op: client.OpSet, Path: "network.eth9.proto", Value: "dhcp"},
+	}); err != nil {
+		t.Fatalf("Batch: %v", err)
+	}
+	if _, err := c.Commit(ctx, client.CommitRequest{Message: "Missing device"}); err == nil {
+		t.Fatal("Expected commit of a missing device to fail")
+	}
+	if _, ok := sys.Link("eth9"); ok {
+		t.Error("Expected no eth9 device")
+	}
+
+	// A simulated system's links are not watched
+	links, err := c.Links(ctx)
+	if err != nil {
+		t.Fatalf("Links: %v", err)
+	}
+	if links.Enabled || links.Links == nil || links.Events == nil {
+		t.Errorf("Expected no watched links, got %+v", links)
+	}
+}
+
+func TestSafeRemoteCommit(t *testing.T) {
+	sys := appliers.NewSimulatedSystem("wan", "lan")
+	registry := appliers.NewRegistry()
+	for _, applier := range sys.Appliers() {
+		registry.Register(applier)
+	}
+	hfConfig := hfconfig.DefaultConfig()
+	hfConfig.Transaction.KeepaliveTimeout = 1
+	server := newTestServerConfig(t, registry, hfConfig)
+	ctx := context.Background()
+	c := client.New(server.URL)
+	if _, err := c.Login(ctx, "admin", "test-password"); err != nil {
+		t.Fatalf("Login: %v", err)
+	}
+
+	if _, err := c.Keepalive(ctx); !client.IsStatus(err, http.StatusConflict) {
+		t.Errorf("Expected 409 without a pending commit, got %v", err)
+	}
+
+	// The client's keepalive after the apply confirms the commit
+	if _, err := c.SetOption(ctx, "network", "lan", "netmask", "255.255.255.0"); err != nil {
+		t.Fatalf("SetOption: %v", err)
+	}
+	result, err := c.Commit(ctx, client.CommitRequest{Message: "LAN netmask", SafeRemote: true})
+	if err != nil {
+		t.Fatalf("Commit: %v", err)
+	}
+	if result.Keepalive != 1 || result.State != "completed" {
+		t.Errorf("Expected the commit confirmed by a keepalive, got %+v", result)
+	}
+
+	// Without keepalives the changes are rolled back before the confirm timeout
+	if _, err := c.SetOption(ctx, "network", "lan", "ipaddr", "10.0.0.1"); err != nil {
+		t.Fatalf("SetOption: %v", err)
+	}
+	result, err = c.Commit(ctx, client.CommitRequest{Message: "Renumber LAN", SafeRemote: true, ConfirmTimeout: 60})
+	if err != nil {
+		t.Fatalf("Commit: %v", err)
+	}
+	if result.State != "pending" {
+		t.Errorf("Expected the commit pending, got %+v", result)
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		lan, _ := sys.Link("lan")
+		if lan.Address == "192.168.1.1/24" {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("Expected lan address rolled back to 192.168.1.1/24, got %q", lan.Address)
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+	if value, err := c.GetOption(ctx, "network", "lan", "ipaddr"); err != nil || value != "192.168.1.1" {
+		t.Errorf("Expected committed ipaddr rolled back to 192.168.1.1, got %q (%v)", value, err)
+	}
+}
+
+func TestRollbackReason(t *testing.T) {
+	sys := appliers.NewSimulatedSystem("wan", "lan")
+	registry := appliers.NewRegistry()
+	for _, applier := range sys.Appliers() {
+		registry.Register(applier)
+	}
+	hfConfig := hfconfig.DefaultConfig()
+	hfConfig.Transaction.RequireRollbackReason = true
+	server := newTestServerConfig(t, registry, hfConfig)
+	ctx := context.Background()
+	c := client.New(server.URL)
+	if _, err := c.Login(ctx, "admin", "test-password"); err != nil {
+		t.Fatalf("Login: %v", err)
+	}
+
+	// A failed apply records the error and the applier
+	if _, err := c.SetOption(ctx, "network", "lan", "ipaddr", "10.0.0.1"); err != nil {
+		t.Fatalf("SetOption: %v", err)
+	}
+	if _, err := c.Commit(ctx, client.CommitRequest{Message: "LAN without netmask"}); err == nil {
+		t.Fatal("Expected the commit to fail without a lan netmask")
+	}
+	txs, _, err := db.ListTransactions(map[string]interface{}{"status": "rolledback"}, 1, 0)
+	if err != nil || len(txs) != 1 {
+		t.Fatalf("Expected the failed commit rolled back, got %v (%v)", txs, err)
+	}
+	if txs[0].FailedApplier != "network" || !strings.Contains(txs[0].RollbackReason, "failed to apply network config") {
+		t.Errorf("Expected the failed applier and its error, got %q: %q", txs[0].FailedApplier, txs[0].RollbackReason)
+	}
+
+	// A manual rollback requires a reason
+	if _, err := c.SetOption(ctx, "network", "lan", "netmask", "255.255.255.0"); err != nil {
+		t.Fatalf("SetOption: %v", err)
+	}
+	result, err := c.Commit(ctx, client.CommitRequest{Message: "Renumber LAN", ConfirmTimeout: 60})
+	if err != nil {
+		t.Fatalf("Commit: %v", err)
+	}
+	if err := c.RollbackPending(ctx); !client.IsStatus(err, http.StatusBadRequest) {
+		t.Fatalf("Expected 400 rolling back without a reason, got %v", err)
+	}
+	if err := c.RollbackPendingWithReason(ctx, "Guests lost DNS"); err != nil {
+		t.Fatalf("RollbackPendingWithReason: %v", err)
+	}
+	timeline, err := c.Timeline(ctx, result.TransactionID)
+	if err != nil {
+		t.Fatalf("Timeline: %v", err)
+	}
+	if timeline.Transaction.Status != "rolledback" || timeline.Transaction.RollbackReason != "Guests lost DNS" {
+		t.Errorf("Expected the reason on the rolled back transaction, got %+v", timeline.Transaction)
+	}
+}
+
+func TestCanaryCommit(t *testing.T) {
+	newSystem := func() (*appliers.SimulatedSystem, *appliers.Registry) {
+		sys := appliers.NewSimulatedSystem("wan", "lan")
+		registry := appliers.NewRegistry()
+		for _, applier := range sys.Appliers() {
+			registry.Register(applier)
+		}
+		return sys, registry
+	}
+	canarySys, canaryRegistry := newSystem()
+	canaryServer := newTestServerWith(t, canaryRegistry)
+
+	passwordFile := filepath.Join(t.TempDir(), "canary-password")
+	if err := os.WriteFile(passwordFile, []byte("test-password\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	hfConfig := hfconfig.DefaultConfig()
+	hfConfig.Canary = hfconfig.CanaryConfig{
+		Enabled:        true,
+		URL:            canaryServer.URL,
+		Username:       "admin",
+		PasswordFile:   passwordFile,
+		ConfirmTimeout: 60,
+	}
+	sys, registry := newSystem()
+	server := newTestServerConfig(t, registry, hfConfig)
+
+	ctx := context.Background()
+	c := client.New(server.URL)
+	if _, err := c.Login(ctx, "admin", "test-password"); err != nil {
+		t.Fatalf("Login: %v", err)
+	}
+	canaryClient := client.New(canaryServer.URL)
+	if _, err := canaryClient.Login(ctx, "admin", "test-password"); err != nil {
+		t.Fatalf("Login to canary: %v", err)
+	}
+
+	// The canary applies the change first and is confirmed once this router has
+	for option, value := range map[string]string{"ipaddr": "10.0.0.1", "netmask": "255.255.255.0"} {
+		if _, err := c.SetOption(ctx, "network", "lan", option, value); err != nil {
+			t.Fatalf("SetOption: %v", err)
+		}
+	}
+	result, err := c.Commit(ctx, client.CommitRequest{Message: "Renumber LAN"})
+	if err != nil {
+		t.Fatalf("Commit: %v", err)
+	}
+	for name, system := range map[string]*appliers.SimulatedSystem{"router": sys, "canary": canarySys} {
+		if lan, _ := system.Link("lan"); lan.Address != "10.0.0.1/24" {
+			t.Errorf("Expected the %s's lan address 10.0.0.1/24, got %q", name, lan.Address)
+		}
+	}
+	tx, err := db.GetTransactionByID(result.TransactionID)
+	if err != nil {
+		t.Fatalf("GetTransactionByID(%q): %v", result.TransactionID, err)
+	}
+	if tx.Canary != canaryServer.URL || tx.CanaryStatus != transaction.CanaryConfirmed || tx.CanaryTxID == "" {
+		t.Errorf("Unexpected canary record: %q %q %q", tx.Canary, tx.CanaryStatus, tx.CanaryTxID)
+	}
+	if value, err := canaryClient.GetOption(ctx, "network", "lan", "ipaddr"); err != nil || value != "10.0.0.1" {
+		t.Errorf("Expected the canary's committed ipaddr 10.0.0.1, got %q (%v)", value, err)
+	}
+	// Both servers share the test database
+	if canaryTx, err := db.GetTransactionByID(tx.CanaryTxID); err != nil || canaryTx.Status != string(transaction.StateCompleted) {
+		t.Errorf("Expected the canary's transaction confirmed, got %+v (%v)", canaryTx, err)
+	}
+
+	// A canary with staged changes of its own fails the commit before it is applied here
+	if _, err := canaryClient.SetOption(ctx, "network", "wan", "proto", "static"); err != nil {
+		t.Fatalf("SetOption on canary: %v", err)
+	}
+	if _, err := c.SetOption(ctx, "network", "lan", "netmask", "255.255.0.0"); err != nil {
+		t.Fatalf("SetOption: %v", err)
+	}
+	if _, err := c.Commit(ctx, client.CommitRequest{Message: "Widen LAN"}); err == nil {
+		t.Fatal("Expected the commit to fail on the canary")
+	}
+	if lan, _ := sys.Link("lan"); lan.Address != "10.0.0.1/24" {
+		t.Errorf("Expected the lan address unchanged, got %q", lan.Address)
+	}
+
+	// Skipping the canary applies the change here only
+	result, err = c.Commit(ctx, client.CommitRequest{Message: "Widen LAN", SkipCanary: true})
+	if err != nil {
+		t.Fatalf("Commit: %v", err)
+	}
+	if lan, _ := sys.Link("lan"); lan.Address != "10.0.0.1/16" {
+		t.Errorf("Expected the lan address 10.0.0.1/16, got %q", lan.Address)
+	}
+	if lan, _ := canarySys.Link("lan"); lan.Address != "10.0.0.1/24" {
+		t.Errorf("Expected the canary's lan address unchanged, got %q", lan.Address)
+	}
+	if tx, err := db.GetTransactionByID(result.TransactionID); err != nil || tx.Canary != "" {
+		t.Errorf("Expected no canary on the transaction, got %+v (%v)", tx, err)
+	}
+}
+
+func TestModemSimulated(t *testing.T) {
+	sys := appliers.NewSimulatedSystem("wan", "lan")
+	registry := appliers.NewRegistry()
+	for _, applier := range sys.Appliers() {
+		registry.Register(applier)
+	}
+	server := newTestServerWith(t, registry)
+	ctx := context.Background()
+	c := client.New(server.URL)
+	if _, err := c.Login(ctx, "admin", "test-password"); err != nil {
+		t.Fatalf("Login: %v", err)
+	}
+
+	// A modem interface commits before its modem is plugged in
+	if _, err := c.Batch(ctx, []client.Operation{
+		{Op: client.OpSet, Path: "network.lan.netmask", Value: "255.255.255.0"},
+		{Op: client.OpSet, Path: "network.wwan0", Value: "interface"},
+		{Op: client.OpSet, Path: "network.wwan0.proto", Value: "modemmanager"},
+		{Op: client.OpSet, Path: "network.wwan0.apn", Value: "internet"},
+		{Op: client.OpSet, Path: "network.wwan0.pincode", Value: "1234"},
+		{Op: client.OpSet, Path: "network.wwan0.metric", Value: "100"},
+	}); err != nil {
+		t.Fatalf("Batch: %v", err)
+	}
+	dryRun, err := c.DryRun(ctx)
+	if err != nil {
+		t.Fatalf("DryRun: %v", err)
+	}
+	if content := dryRun.Artifacts["network"][0].Content; !strings.Contains(content, "--simple-connect=apn=internet,ip-type=ipv4v6,allow-roaming=no,pin=***\n") {
+		t.Errorf("Expected the modem connected with its PIN masked, got:\n%s", content)
+	}
+	if _, err := c.Commit(ctx, client.CommitRequest{Message: "Cellular backup"}); err != nil {
+		t.Fatalf("Commit: %v", err)
+	}
+	if _, ok := sys.Link("wwan0"); ok {
+		t.Error("Expected no wwan0 device")
+	}
+
+	modems, err := c.Modems(ctx)
+	if err != nil {
+		t.Fatalf("Modems: %v", err)
+	}
+	if modems.Available || modems.Modems == nil || modems.Missing == nil {
+		t.Errorf("Expected ModemManager unavailable, got %+v", modems)
+	}
+
+	// Bad settings are refused
+	if _, err := c.SetOption(ctx, "network", "wwan0", "pincode", "12ab"); err != nil {
+		t.Fatalf("SetOption: %v", err)
+	}
+	if _, err := c.Commit(ctx, client.CommitRequest{Message: "Bad PIN"}); err == nil {
+		t.Error("Expected commit of an invalid PIN to fail")
+	}
+}
+
+func TestPluginCommands(t *testing.T) {
+	server := newTestServer(t)
+
+	dir := t.TempDir()
+	saved := pluginRegistry
+	pluginRegistry = plugin.NewRegistry(filepath.Join(dir, "plugins.json"))
+	t.Cleanup(func() { pluginRegistry = saved })
+	tool := filepath.Join(dir, "vpnctl")
+	if err := os.WriteFile(tool, []byte("#!/bin/sh\n"), 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	// Daemons register their commands with an admin API key
+	const apiKey = "hf_test-plugin-key"
+	keyHash := sha256.Sum256([]byte(apiKey))
+	bcryptHash, err := auth.HashPassword(apiKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := db.CreateAPIKey(&db.APIKey{Key: bcryptHash, KeyHash: hex.EncodeToString(keyHash[:]), KeyID: "vpnd",
+		Name: "vpnd", UserID: 1, Enabled: true}); err != nil {
+		t.Fatalf("CreateAPIKey: %v", err)
+	}
+	request := func(method, path, body, key string) (int, string) {
+		t.Helper()
+		req, _ := http.NewRequest(method, server.URL+"/api/v1/plugins"+path, strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		if key != "" {
+			req.Header.Set("X-API-Key", key)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		data, _ := io.ReadAll(resp.Body)
+		return resp.StatusCode, string(data)
+	}
+
+	if status, _ := request(http.MethodGet, "", "", ""); status != http.StatusUnauthorized {
+		t.Errorf("Listing without an API key = %d, want 401", status)
+	}
+	status, body := request(http.MethodPut, "/vpn", `{"path":"`+tool+`","description":"Manage the VPN daemon"}`, apiKey)
+	if status != http.StatusOK || !strings.Contains(body, `"registered_by":"admin"`) {
+		t.Fatalf("Register = %d %s", status, body)
+	}
+	if status, body := request(http.MethodPut, "/bad", `{"path":"vpnctl"}`, apiKey); status != http.StatusBadRequest {
+		t.Errorf("Registering a relative path = %d %s, want 400", status, body)
+	}
+
+	status, body = request(http.MethodGet, "", "", apiKey)
+	var list struct {
+		Plugins []plugin.Command `json:"plugins"`
+	}
+	if status != http.StatusOK || json.Unmarshal([]byte(body), &list) != nil {
+		t.Fatalf("List = %d %s", status, body)
+	}
+	if i := slices.IndexFunc(list.Plugins, func(c plugin.Command) bool { return c.Name == "vpn" }); i < 0 ||
+		list.Plugins[i].Path != tool || list.Plugins[i].Source != plugin.SourceRegistered {
+		t.Errorf("Expected vpn registered, got %+v", list.Plugins)
+	}
+
+	if status, body := request(http.MethodDelete, "/vpn", "", apiKey); status != http.StatusOK {
+		t.Errorf("Unregister = %d %s", status, body)
+	}
+	if status, _ := request(http.MethodDelete, "/vpn", "", apiKey); status != http.StatusNotFound {
+		t.Errorf("Unregister again = %d, want 404", status)
+	}
+}
+
+func TestPluginEnv(t *testing.T) {
+	hfConfig := hfconfig.DefaultConfig()
+	hfConfig.API.Listen = []string{"unix:/run/hellfire/api.sock", "0.0.0.0:8443", "192.168.1.1:8888"}
+	env := pluginEnv(plugin.Command{Name: "vpn"}, hfConfig)
+	for _, want := range []string{"HF_PLUGIN=vpn", "HF_API_URL=http://127.0.0.1:8443", "HF_API_SOCKET=/run/hellfire/api.sock", "HF_CONFIG_DIR=" + configDir} {
+		if !slices.Contains(env, want) {
+			t.Errorf("Expected %s in %v", want, env)
+		}
+	}
+
+	hfConfig.API.Listen = []string{"[::]:8888"}
+	if url, socket := localAPI(hfConfig.API); url != "http://[::1]:8888" || socket != "" {
+		t.Errorf("localAPI() = %q, %q", url, socket)
+	}
+}
+
+func TestGraphQL(t *testing.T) {
+	hfConfig := hfconfig.DefaultConfig()
+	hfConfig.API.EnableGraphQL = true
+	hfConfig.ACLs = []hfconfig.ConfigACL{{Config: "network", Section: "lan", Read: []string{"admin"}}}
+	server := newTestServerConfig(t, appliers.NewRegistry(), hfConfig)
+	ctx := context.Background()
+
+	admin := client.New(server.URL)
+	if _, err := admin.Login(ctx, "admin", "test-password"); err != nil {
+		t.Fatalf("Login: %v", err)
+	}
+	if _, err := admin.SetOption(ctx, "network", "lan", "ipaddr", "192.168.2.1"); err != nil {
+		t.Fatalf("SetOption: %v", err)
+	}
+	if _, err := admin.Commit(ctx, client.CommitRequest{Message: "Move the LAN"}); err != nil {
+		t.Fatalf("Commit: %v", err)
+	}
+
+	// A transaction with its snapshot and audit entries in one request
+	const query = `query Dashboard($limit: Int) {
+		transactions(limit: $limit) {
+			transaction_id message configs
+			snapshot { id configs }
+			audit { action status }
+		}
+		network: config(name: "network") { lan: section(name: "lan") { option(name: "ipaddr") } }
+		users { username role }
+	}`
+	type data struct {
+		Transactions []struct {
+			ID       string   `json:"transaction_id"`
+			Message  string   `json:"message"`
+			Configs  []string `json:"configs"`
+			Snapshot *struct {
+				ID      string   `json:"id"`
+				Configs []string `json:"configs"`
+			} `json:"snapshot"`
+			Audit []struct {
+				Action string `json:"action"`
+				Status string `json:"status"`
+			} `json:"audit"`
+		} `json:"transactions"`
+		Network *struct {
+			LAN *struct {
+				IPAddr string `json:"option"`
+			} `json:"lan"`
+		} `json:"network"`
+		Users []struct {
+			Username string `json:"username"`
+		} `json:"users"`
+	}
+
+	var result data
+	if err := admin.GraphQL(ctx, query, map[string]any{"limit": 5}, &result); err != nil {
+		t.Fatalf("GraphQL: %v", err)
+	}
+	if len(result.Transactions) != 1 {
+		t.Fatalf("Expected 1 transaction, got %+v", result.Transactions)
+	}
+	tx := result.Transactions[0]
+	if tx.Message != "Move the LAN" || !slices.Equal(tx.Configs, []string{"network"}) || tx.Snapshot == nil ||
+		!slices.Contains(tx.Snapshot.Configs, "network") {
+		t.Errorf("Unexpected transaction: %+v", tx)
+	}
+	var actions []string
+	for _, entry := range tx.Audit {
+		actions = append(actions, entry.Action)
+	}
+	if !slices.Contains(actions, string(audit.ActionTxCommit)) {
+		t.Errorf("Expected the commit in the audit entries, got %v", actions)
+	}
+	if result.Network == nil || result.Network.LAN == nil || result.Network.LAN.IPAddr != "192.168.2.1" {
+		t.Errorf("Unexpected network config: %+v", result.Network)
+	}
+	if len(result.Users) != 1 || result.Users[0].Username != "admin" {
+		t.Errorf("Unexpected users: %+v", result.Users)
+	}
+
+	// Viewers get what they may read, and errors for the rest
+	hash, err := auth.HashPassword("vera-password")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := db.CreateUser(&db.User{Username: "vera", PasswordHash: hash, Role: db.RoleViewer, Enabled: true}); err != nil {
+		t.Fatalf("CreateUser: %v", err)
+	}
+	viewer := client.New(server.URL)
+	if _, err := viewer.Login(ctx, "vera", "vera-password"); err != nil {
+		t.Fatalf("Login: %v", err)
+	}
+	result = data{}
+	err = viewer.GraphQL(ctx, query, nil, &result)
+	var gqlErrs client.GraphQLErrors
+	if !errors.As(err, &gqlErrs) || len(gqlErrs) != 1 || gqlErrs[0].Message != "admin role required" {
+		t.Errorf("Expected users to be refused, got %v", err)
+	}
+	if len(result.Transactions) != 1 || result.Network == nil || result.Network.LAN != nil || result.Users != nil {
+		t.Errorf("Unexpected viewer data: %+v", result)
+	}
+
+	if err := viewer.GraphQL(ctx, `mutation { me { username } }`, nil, nil); !errors.As(err, &gqlErrs) {
+		t.Errorf("Expected mutations to be rejected, got %v", err)
+	}
+
+	// The endpoint is only served when enabled
+	c := client.New(newTestServer(t).URL)
+	if _, err := c.Login(ctx, "admin", "test-password"); err != nil {
+		t.Fatalf("Login: %v", err)
+	}
+	if err := c.GraphQL(ctx, `{ me { username } }`, nil, nil); !client.IsStatus(err, http.StatusNotFound) {
+		t.Errorf("Expected 404 without the graphql option, got %v", err)
+	}
+}
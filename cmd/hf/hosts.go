@@ -0,0 +1,134 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/spf13/cobra"
+
+	"github.com/thesabbir/hellfire/pkg/bus"
+	"github.com/thesabbir/hellfire/pkg/config"
+	"github.com/thesabbir/hellfire/pkg/dns"
+)
+
+var hostsCmd = &cobra.Command{
+	Use:   "hosts",
+	Short: "Manage local hostnames",
+	Long: `Manage the hostnames dnsmasq resolves locally. Entries are A and AAAA
+records of the dhcp config; static leases with a name are registered under
+the local domain unless their dns option is 0.`,
+}
+
+var hostsListCmd = &cobra.Command{
+	Use:   "list",
+	Short: "List hosts entries",
+	Args:  cobra.NoArgs,
+	RunE:  runHostsList,
+}
+
+var hostsAddCmd = &cobra.Command{
+	Use:   "add <name> <address>",
+	Short: "Stage a hosts entry",
+	Args:  cobra.ExactArgs(2),
+	RunE:  runHostsAdd,
+}
+
+var hostsDeleteCmd = &cobra.Command{
+	Use:   "delete <id>",
+	Short: "Stage the removal of a hosts entry",
+	Args:  cobra.ExactArgs(1),
+	RunE:  runHostsDelete,
+}
+
+func init() {
+	hostsListCmd.Flags().Bool("hosts-file", false, "Print the entries as an /etc/hosts file")
+
+	hostsCmd.AddCommand(
+		hostsListCmd,
+		hostsAddCmd,
+		hostsDeleteCmd,
+	)
+}
+
+func runHostsList(cmd *cobra.Command, args []string) error {
+	dhcp, err := manager.Load("dhcp")
+	if err != nil {
+		return err
+	}
+	hosts, err := dns.Hosts(dhcp)
+	if err != nil {
+		return err
+	}
+
+	if hostsFile, _ := cmd.Flags().GetBool("hosts-file"); hostsFile {
+		fmt.Print(dns.HostsFile(hosts))
+		return nil
+	}
+	if len(hosts) == 0 {
+		fmt.Println("No hosts entries")
+		return nil
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "ID\tADDRESS\tNAMES\tSOURCE")
+	fmt.Fprintln(w, "--\t-------\t-----\t------")
+	for _, host := range hosts {
+		source := "record"
+		if host.Lease {
+			source = "static lease"
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\n", host.ID, host.Address, strings.Join(host.Names, " "), source)
+	}
+	return w.Flush()
+}
+
+func runHostsAdd(cmd *cobra.Command, args []string) error {
+	dhcp, err := manager.Load("dhcp")
+	if err != nil {
+		return err
+	}
+	record, ops, err := dns.AddRecord(dhcp, dns.HostRecord(args[0], args[1]))
+	if err != nil {
+		return err
+	}
+	if err := stageHosts(ops); err != nil {
+		return err
+	}
+
+	fmt.Printf("Staged: %s %s (%s)\n", record.Name, record.Value, record.ID)
+	fmt.Println("Run 'hf commit' to apply changes")
+	return nil
+}
+
+func runHostsDelete(cmd *cobra.Command, args []string) error {
+	dhcp, err := manager.Load("dhcp")
+	if err != nil {
+		return err
+	}
+	record, ops, err := dns.DeleteHost(dhcp, args[0])
+	if err != nil {
+		return err
+	}
+	if err := stageHosts(ops); err != nil {
+		return err
+	}
+
+	fmt.Printf("Staged removal: %s %s\n", record.Name, record.Value)
+	fmt.Println("Run 'hf commit' to apply changes")
+	return nil
+}
+
+// stageHosts stages changes to the hosts entries
+func stageHosts(ops []config.Operation) error {
+	if _, err := manager.Batch(ops); err != nil {
+		return err
+	}
+	bus.Publish(bus.Event{
+		Type:       bus.EventConfigChanged,
+		ConfigName: "dhcp",
+		Data:       ops,
+	})
+	return nil
+}
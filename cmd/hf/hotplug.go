@@ -0,0 +1,145 @@
+package main
+
+import (
+	"context"
+	"slices"
+	"sync"
+
+	"github.com/thesabbir/hellfire/pkg/appliers"
+	"github.com/thesabbir/hellfire/pkg/bus"
+	"github.com/thesabbir/hellfire/pkg/config"
+	"github.com/thesabbir/hellfire/pkg/hotplug"
+	"github.com/thesabbir/hellfire/pkg/logger"
+	"github.com/thesabbir/hellfire/pkg/modem"
+	"github.com/thesabbir/hellfire/pkg/transaction"
+	"github.com/thesabbir/hellfire/pkg/uci"
+)
+
+// linkMonitor watches the links in the API server; nil on a simulated
+// system or where link events are not supported
+var linkMonitor *hotplug.Monitor
+
+// hotplugConfigs are re-applied when an interface they configure appears
+var hotplugConfigs = []string{"network", "dhcp"}
+
+// linkReactor re-applies the config of interfaces that appear (a modem
+// plugged in), renews the DHCP leases of interfaces whose cable is plugged in
+// and moves the default route away from WANs that lost their link
+type linkReactor struct {
+	manager *config.Manager
+	txMgr   *transaction.Manager
+	monitor *hotplug.Monitor
+	mu      sync.Mutex // Serializes failovers
+	moved   bool       // Routes of WANs without link were removed
+}
+
+// startLinkMonitor watches the links and reacts to their events
+func startLinkMonitor(manager *config.Manager, txMgr *transaction.Manager) {
+	monitor := hotplug.NewMonitor(hotplug.DefaultHistorySize)
+	reactor := &linkReactor{manager: manager, txMgr: txMgr, monitor: monitor}
+	monitor.OnEvent(reactor.react)
+	if err := monitor.Start(); err != nil {
+		logger.Warn("Link monitor not started", "error", err)
+		return
+	}
+	linkMonitor = monitor
+
+	// A commit installs the default routes of all gateways, even unplugged ones
+	bus.Subscribe(bus.EventTransactionCompleted, func(bus.Event) {
+		if network, err := manager.LoadCommittedDecrypted("network"); err == nil {
+			reactor.failover(context.Background(), network)
+		}
+	})
+	reactor.failover(context.Background(), nil)
+}
+
+// react handles a link event of an interface of the committed network config
+func (r *linkReactor) react(event hotplug.Event) {
+	network, err := r.manager.LoadCommittedDecrypted("network")
+	if err != nil {
+		return
+	}
+	section := network.GetSection("interface", event.Interface)
+	if section == nil {
+		return
+	}
+	proto, _ := section.GetOption("proto")
+	ctx := context.Background()
+
+	switch event.Type {
+	case hotplug.EventAdded:
+		// Created by hellfire itself (WireGuard) or never configured
+		if proto != "static" && proto != "dhcp" && !modem.IsProto(proto) {
+			return
+		}
+		if err := r.txMgr.Reapply(ctx, hotplugConfigs, "interface "+event.Interface+" appeared"); err != nil {
+			logger.Error("Failed to re-apply config for new interface", "interface", event.Interface, "error", err)
+		}
+	case hotplug.EventUp:
+		if proto == "dhcp" {
+			if err := appliers.DHCPClients.Renew(ctx, event.Interface); err != nil {
+				logger.Error("Failed to renew DHCP lease", "interface", event.Interface, "error", err)
+			}
+		}
+	case hotplug.EventRemoved:
+		return
+	}
+
+	gateways, err := appliers.Gateways(network)
+	if err == nil && slices.ContainsFunc(gateways, func(g appliers.Gateway) bool { return g.Interface == event.Interface }) {
+		r.failover(ctx, network)
+	}
+}
+
+// failover installs the default routes of the gateways with a link and
+// removes those of the others, along with the static routes on them.
+// network is loaded when nil.
+func (r *linkReactor) failover(ctx context.Context, network *uci.Config) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	// A commit or rollback being applied installs the routes itself
+	if state := r.txMgr.GetState(); state == transaction.StateInProgress || state == transaction.StatePending {
+		return
+	}
+	if network == nil {
+		var err error
+		if network, err = r.manager.LoadCommittedDecrypted("network"); err != nil {
+			return
+		}
+	}
+	gateways, err := appliers.Gateways(network)
+	if err != nil || len(gateways) < 2 {
+		// A single WAN has nothing to fail over to
+		return
+	}
+	routes, err := appliers.StaticRoutes(network)
+	if err != nil {
+		return
+	}
+
+	var down []string
+	for _, g := range gateways {
+		if link, ok := r.monitor.Link(g.Interface); ok && !link.Carrier {
+			down = append(down, g.Interface)
+		}
+	}
+	if len(down) == len(gateways) {
+		// Keep the routes rather than none
+		down = nil
+	}
+	if len(down) == 0 && !r.moved {
+		// The routes are as applied
+		return
+	}
+	if err := appliers.FailoverGateways(ctx, gateways, routes, down); err != nil {
+		logger.Error("Failed to update default routes", "down", down, "error", err)
+		return
+	}
+	r.moved = len(down) > 0
+	if r.moved {
+		logger.Info("Default routes moved off WANs without link", "down", down)
+	} else {
+		logger.Info("Default routes of all WANs restored")
+	}
+}
@@ -0,0 +1,104 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"strconv"
+	"time"
+
+	"github.com/spf13/cobra"
+	"github.com/thesabbir/hellfire/pkg/appliers"
+	"github.com/thesabbir/hellfire/pkg/audit"
+	"github.com/thesabbir/hellfire/pkg/hfconfig"
+	"github.com/thesabbir/hellfire/pkg/logger"
+	"github.com/thesabbir/hellfire/pkg/spa"
+)
+
+var knockCmd = &cobra.Command{
+	Use:   "knock <host>",
+	Short: "Open a router's management ports with single-packet authorization",
+	Long: `Send a single authenticated UDP packet to a router with SPA enabled, which
+opens its API and SSH ports to this machine's address for a limited time.
+The packet is signed for that address, so it opens nothing from anywhere
+else. Behind a NAT, sign it for the NAT's public address with --source, or
+for whatever address it arrives from with --any-source if the router has
+allow_nat enabled.`,
+	Args: cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		port, _ := cmd.Flags().GetInt("port")
+		keyFile, _ := cmd.Flags().GetString("key-file")
+		sourceFlag, _ := cmd.Flags().GetString("source")
+		anySource, _ := cmd.Flags().GetBool("any-source")
+
+		var source net.IP
+		switch {
+		case sourceFlag != "" && anySource:
+			return fmt.Errorf("--source and --any-source can't be used together")
+		case sourceFlag != "":
+			if source = net.ParseIP(sourceFlag); source == nil {
+				return fmt.Errorf("invalid source address %q", sourceFlag)
+			}
+		case anySource:
+			source = spa.AnySource
+		}
+
+		key, err := spa.LoadKey(keyFile)
+		if err != nil {
+			return fmt.Errorf("failed to load spa key: %w", err)
+		}
+
+		address := net.JoinHostPort(args[0], strconv.Itoa(port))
+		if err := spa.Knock(address, key, source); err != nil {
+			return fmt.Errorf("failed to knock: %w", err)
+		}
+
+		fmt.Printf("Knocked on %s\n", address)
+		return nil
+	},
+}
+
+func init() {
+	knockCmd.Flags().Int("port", spa.DefaultPort, "Router's knock port")
+	knockCmd.Flags().String("key-file", hfconfig.DefaultSPAKeyFile, "File with the shared spa key")
+	knockCmd.Flags().String("source", "", "Address to open the ports to, e.g. a NAT's public address (default this machine's)")
+	knockCmd.Flags().Bool("any-source", false, "Open the ports to whatever address the knock arrives from (needs allow_nat)")
+}
+
+// startSPAServer starts the knock listener, which adds knocking clients to
+// the firewall's SPA client sets
+func startSPAServer(cfg hfconfig.SPAConfig) error {
+	key, err := spa.LoadKey(cfg.KeyFile)
+	if err != nil {
+		return fmt.Errorf("failed to load spa key: %w", err)
+	}
+
+	server, err := spa.NewServer(spa.Config{
+		Listen:   fmt.Sprintf(":%d", cfg.Port),
+		Key:      key,
+		OpenFor:  time.Duration(cfg.OpenTimeout) * time.Second,
+		MaxSkew:  time.Duration(cfg.MaxSkew) * time.Second,
+		AllowNAT: cfg.AllowNAT,
+		Open: func(ctx context.Context, addr net.IP, duration time.Duration) error {
+			err := appliers.AllowSPAClient(ctx, addr, duration)
+			if err != nil {
+				audit.LogFailure(audit.ActionSystemKnock, nil, "spa", addr.String(),
+					"Failed to open management ports for knocking client", err)
+				return err
+			}
+			audit.LogSuccess(audit.ActionSystemKnock, nil, "spa", addr.String(),
+				fmt.Sprintf("Opened management ports for %s", duration))
+			return nil
+		},
+	})
+	if err != nil {
+		return err
+	}
+
+	go func() {
+		if err := server.ListenAndServe(); err != nil {
+			logger.Error("Knock listener stopped", "error", err)
+		}
+	}()
+	return nil
+}
@@ -0,0 +1,105 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"syscall"
+	"time"
+
+	"github.com/thesabbir/hellfire/pkg/hfconfig"
+)
+
+// newHTTPServer creates the API server with the configured timeouts
+func newHTTPServer(handler http.Handler, apiCfg hfconfig.APIConfig) *http.Server {
+	return &http.Server{
+		Handler:           handler,
+		ReadHeaderTimeout: seconds(apiCfg.ReadHeaderTimeout),
+		ReadTimeout:       seconds(apiCfg.ReadTimeout),
+		WriteTimeout:      seconds(apiCfg.WriteTimeout),
+		IdleTimeout:       seconds(apiCfg.IdleTimeout),
+	}
+}
+
+// serveHTTP serves on every listen address, binding TCP listeners to vrf if
+// set, and returns when any of them stops serving
+func serveHTTP(server *http.Server, addrs []string, vrf string) error {
+	listeners := make([]net.Listener, 0, len(addrs))
+	for _, addr := range addrs {
+		l, err := listen(addr, vrf)
+		if err != nil {
+			for _, opened := range listeners {
+				_ = opened.Close()
+			}
+			return err
+		}
+		listeners = append(listeners, l)
+	}
+
+	errs := make(chan error, len(listeners))
+	for i, l := range listeners {
+		fmt.Printf("Starting API server on %s\n", addrs[i])
+		go func(l net.Listener) {
+			errs <- server.Serve(l)
+		}(l)
+	}
+
+	err := <-errs
+	_ = server.Close()
+	return err
+}
+
+// seconds converts a timeout from the config file (0 = no timeout)
+func seconds(n int) time.Duration {
+	return time.Duration(n) * time.Second
+}
+
+// listen opens a TCP or unix socket listener for a configured listen address.
+// A TCP listener bound to a VRF device only accepts connections received on
+// the interfaces enslaved to it.
+func listen(addr, vrf string) (net.Listener, error) {
+	network, address := hfconfig.ParseListenAddress(addr)
+	if network != "unix" {
+		lc := net.ListenConfig{}
+		if vrf != "" {
+			lc.Control = func(_, _ string, c syscall.RawConn) error {
+				return bindToDevice(c, vrf)
+			}
+		}
+		l, err := lc.Listen(context.Background(), network, address)
+		if err != nil {
+			return nil, fmt.Errorf("failed to listen on %s: %w", addr, err)
+		}
+		return l, nil
+	}
+
+	// Replace a socket left behind by a previous run, but never a regular file
+	if info, err := os.Lstat(address); err == nil {
+		if info.Mode()&os.ModeSocket == 0 {
+			return nil, fmt.Errorf("failed to listen on %s: file exists and is not a socket", addr)
+		}
+		if err := os.Remove(address); err != nil {
+			return nil, fmt.Errorf("failed to remove stale socket %s: %w", address, err)
+		}
+	}
+
+	if err := os.MkdirAll(filepath.Dir(address), 0755); err != nil {
+		return nil, fmt.Errorf("failed to create socket directory: %w", err)
+	}
+
+	l, err := net.Listen(network, address)
+	if err != nil {
+		return nil, fmt.Errorf("failed to listen on %s: %w", addr, err)
+	}
+
+	// Owner and group only; grant access by adding users to the socket's group
+	if err := os.Chmod(address, 0660); err != nil {
+		_ = l.Close()
+		return nil, fmt.Errorf("failed to set socket permissions: %w", err)
+	}
+
+	return l, nil
+}
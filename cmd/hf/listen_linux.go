@@ -0,0 +1,20 @@
+package main
+
+import (
+	"fmt"
+	"syscall"
+)
+
+// bindToDevice binds a socket to a network device such as a VRF
+func bindToDevice(c syscall.RawConn, device string) error {
+	var sockErr error
+	if err := c.Control(func(fd uintptr) {
+		sockErr = syscall.SetsockoptString(int(fd), syscall.SOL_SOCKET, syscall.SO_BINDTODEVICE, device)
+	}); err != nil {
+		return err
+	}
+	if sockErr != nil {
+		return fmt.Errorf("failed to bind to %s: %w", device, sockErr)
+	}
+	return nil
+}
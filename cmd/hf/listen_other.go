@@ -0,0 +1,13 @@
+//go:build !linux
+
+package main
+
+import (
+	"errors"
+	"syscall"
+)
+
+// bindToDevice is only implemented on Linux
+func bindToDevice(_ syscall.RawConn, _ string) error {
+	return errors.New("binding to a vrf is only supported on Linux")
+}
@@ -1,22 +1,32 @@
 package main
 
 import (
+	"bufio"
 	"context"
-	"crypto/rand"
+	"errors"
 	"fmt"
-	"math/big"
+	"maps"
 	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"text/tabwriter"
 	"time"
 
 	"github.com/spf13/cobra"
 	"github.com/thesabbir/hellfire/pkg/appliers"
+	"github.com/thesabbir/hellfire/pkg/audit"
 	"github.com/thesabbir/hellfire/pkg/auth"
 	"github.com/thesabbir/hellfire/pkg/bus"
 	"github.com/thesabbir/hellfire/pkg/config"
 	"github.com/thesabbir/hellfire/pkg/db"
+	"github.com/thesabbir/hellfire/pkg/hfconfig"
 	"github.com/thesabbir/hellfire/pkg/logger"
+	"github.com/thesabbir/hellfire/pkg/secrets"
 	"github.com/thesabbir/hellfire/pkg/snapshot"
 	"github.com/thesabbir/hellfire/pkg/transaction"
+	"github.com/thesabbir/hellfire/pkg/uci"
+	"golang.org/x/term"
 )
 
 var (
@@ -24,6 +34,7 @@ var (
 	stagingDir      string
 	snapshotDir     string
 	dbPath          string
+	secretKeyFile   string
 	manager         *config.Manager
 	snapshotMgr     *snapshot.Manager
 	transactionMgr  *transaction.Manager
@@ -35,32 +46,73 @@ func main() {
 		Use:   "hf",
 		Short: "Hellfire - Debian Router Configuration Tool",
 		Long:  "A UCI-like configuration management tool for Debian routers",
-		PersistentPreRun: func(cmd *cobra.Command, args []string) {
+		PersistentPreRunE: func(cmd *cobra.Command, args []string) error {
+			// Configure logging first so everything below logs as configured
+			if logLevel != "" {
+				if _, err := logger.ParseLevel(logLevel); err != nil {
+					return err
+				}
+			}
+			hfConfig := loadHellfireConfig()
+			if err := configureLogging(hfConfig.Logging); err != nil {
+				logger.Warn("Failed to apply logging settings, logging to stdout", "error", err)
+				if err := configureLogging(hfconfig.DefaultConfig().Logging); err != nil {
+					return err
+				}
+			}
+
+			// Password policy for user commands
+			if err := applyPasswordPolicy(hfConfig.Security); err != nil {
+				logger.Warn("Failed to load password policy", "error", err)
+			}
+
+			// The demo server runs on a simulated system from its own directory
+			demo, _ := cmd.Flags().GetBool("demo")
+			if demo {
+				if err := setupDemo(cmd); err != nil {
+					return err
+				}
+			}
+			simulate = simulateBuild || demo || hfConfig.Transaction.Simulate
+			hfConfig.Transaction.Simulate = simulate
+
 			// Initialize database (optional - some commands don't need it)
 			if dbPath != "" {
 				if err := db.Initialize(&db.Config{Path: dbPath}); err != nil {
 					logger.Error("Failed to initialize database", "error", err)
 					// Don't exit - some commands can work without DB
-				} else {
-					// Bootstrap: create default admin user if no users exist
-					if err := bootstrapDefaultUser(); err != nil {
-						logger.Warn("Failed to bootstrap default user", "error", err)
-					}
 				}
 			}
 
 			// Initialize managers
 			manager = config.NewManager(configDir, stagingDir)
+			manager.SetSecrets(secrets.NewStore(secretKeyFile)) // The key is created on first use
 			snapshotMgr = snapshot.NewManager(snapshotDir, configDir)
+			snapshotMgr.SetLimits(hfConfig.SnapshotLimits())
 
 			// Initialize applier registry
 			applierRegistry = appliers.NewRegistry()
-			applierRegistry.Register(appliers.NewNetworkApplier())
-			applierRegistry.Register(appliers.NewFirewallApplier())
-			applierRegistry.Register(appliers.NewDHCPApplier())
+			if simulate {
+				registerSimulatedAppliers(applierRegistry)
+				setManagementAccess(hfConfig)
+			} else {
+				applierRegistry.Register(appliers.NewNetworkApplier())
+				applierRegistry.Register(newFirewallApplier())
+				applierRegistry.Register(appliers.NewDHCPApplier())
+				applierRegistry.Register(appliers.NewOpenVPNApplier())
+				applierRegistry.Register(appliers.NewIPsecApplier())
+			}
+			applierRegistry.Register(appliers.NewTasksApplier())
 
 			// Initialize transaction manager
 			transactionMgr = transaction.NewManager(manager, snapshotMgr, applierRegistry)
+			transactionMgr.SetRecovery(hfConfig.RecoveryNetwork(), hfConfig.RecoveryStateFile())
+			transactionMgr.SetProbes(hfConfig.ConfirmProbes())
+			transactionMgr.SetRequireRollbackReason(hfConfig.Transaction.RequireRollbackReason)
+			transactionMgr.SetSystemCapture(hfConfig.Transaction.CaptureSize * 1024)
+			setCanary(transactionMgr, hfConfig)
+
+			return nil
 		},
 		PersistentPostRun: func(cmd *cobra.Command, args []string) {
 			// Close database connection
@@ -70,22 +122,32 @@ func main() {
 		},
 	}
 
-	rootCmd.PersistentFlags().StringVar(&configDir, "config-dir", config.DefaultConfigDir, "Configuration directory")
-	rootCmd.PersistentFlags().StringVar(&stagingDir, "staging-dir", config.StagingDir, "Staging directory")
-	rootCmd.PersistentFlags().StringVar(&snapshotDir, "snapshot-dir", snapshot.DefaultSnapshotDir, "Snapshot directory")
-	rootCmd.PersistentFlags().StringVar(&dbPath, "db", db.DefaultDBPath, "Database file path")
+	// Flags default to their HF_* environment variables
+	rootCmd.PersistentFlags().StringVar(&configDir, "config-dir", envOr("HF_CONFIG_DIR", config.DefaultConfigDir), "Configuration directory (HF_CONFIG_DIR)")
+	rootCmd.PersistentFlags().StringVar(&stagingDir, "staging-dir", envOr("HF_STAGING_DIR", config.StagingDir), "Staging directory (HF_STAGING_DIR)")
+	rootCmd.PersistentFlags().StringVar(&snapshotDir, "snapshot-dir", envOr("HF_SNAPSHOT_DIR", snapshot.DefaultSnapshotDir), "Snapshot directory (HF_SNAPSHOT_DIR)")
+	rootCmd.PersistentFlags().StringVar(&dbPath, "db", envOr("HF_DB_PATH", db.DefaultDBPath), "Database file path (HF_DB_PATH)")
+	rootCmd.PersistentFlags().StringVar(&secretKeyFile, "secret-key", envOr("HF_SECRET_KEY", secrets.DefaultKeyFile), "Key file secret options are encrypted with (HF_SECRET_KEY)")
+	rootCmd.PersistentFlags().StringVar(&logLevel, "log-level", "", "Log level: debug, info, warn or error (overrides HF_LOG_LEVEL and the config file)")
+	rootCmd.PersistentFlags().StringVar(&logFormat, "log-format", "", "Log format: json or text (overrides HF_LOG_FORMAT and the config file)")
+	rootCmd.PersistentFlags().StringVar(&logFile, "log-file", "", "Log file (overrides HF_LOG_FILE and the config file)")
 
 	// Config management commands
 	rootCmd.AddCommand(showCmd)
 	rootCmd.AddCommand(getCmd)
+	rootCmd.AddCommand(varsCmd)
 	rootCmd.AddCommand(setCmd)
+	rootCmd.AddCommand(batchCmd)
+	rootCmd.AddCommand(importOpenWrtCmd)
 	rootCmd.AddCommand(exportCmd)
 	rootCmd.AddCommand(changesCmd)
+	rootCmd.AddCommand(hostsCmd)
 
 	// Transaction commands
 	rootCmd.AddCommand(commitCmd)
 	rootCmd.AddCommand(confirmCmd)
 	rootCmd.AddCommand(rollbackCmd)
+	rootCmd.AddCommand(txCmd)
 
 	// Snapshot commands
 	rootCmd.AddCommand(snapshotCmd)
@@ -103,6 +165,19 @@ func main() {
 	// API server
 	rootCmd.AddCommand(serveCmd)
 
+	// Single-packet authorization client
+	rootCmd.AddCommand(knockCmd)
+
+	// System upgrades
+	rootCmd.AddCommand(upgradeCmd)
+
+	// Plugin commands, after the built-in ones they can't replace
+	rootCmd.AddCommand(pluginCmd)
+	plugins := addPluginCommands(rootCmd)
+	if code, ok := dispatchPlugin(rootCmd, plugins, os.Args[1:]); ok {
+		os.Exit(code)
+	}
+
 	if err := rootCmd.Execute(); err != nil {
 		fmt.Fprintln(os.Stderr, err)
 		os.Exit(1)
@@ -114,11 +189,37 @@ var showCmd = &cobra.Command{
 	Short: "Show configuration",
 	Args:  cobra.ExactArgs(1),
 	RunE: func(cmd *cobra.Command, args []string) error {
-		configName := args[0]
-		return manager.Export(configName, os.Stdout)
+		return exportConfig(cmd, args[0])
 	},
 }
 
+// exportConfig writes a config to stdout with its sensitive values masked,
+// unless --reveal-secrets is given. Revealed secrets that are encrypted at
+// rest stay encrypted. With --resolved, variables and expressions are
+// written as their values.
+func exportConfig(cmd *cobra.Command, name string) error {
+	reveal, _ := cmd.Flags().GetBool("reveal-secrets")
+	if resolved, _ := cmd.Flags().GetBool("resolved"); resolved {
+		cfg, err := manager.LoadExpanded(name)
+		if err != nil {
+			return err
+		}
+		if !reveal {
+			cfg = config.MaskSecrets(name, cfg)
+		}
+		return uci.Write(os.Stdout, cfg)
+	}
+	if reveal {
+		return manager.Export(name, os.Stdout)
+	}
+
+	cfg, err := manager.Load(name)
+	if err != nil {
+		return err
+	}
+	return uci.Write(os.Stdout, config.MaskSecrets(name, cfg))
+}
+
 var getCmd = &cobra.Command{
 	Use:   "get <path>",
 	Short: "Get configuration value (e.g., network.wan.ipaddr)",
@@ -134,6 +235,31 @@ var getCmd = &cobra.Command{
 	},
 }
 
+var varsCmd = &cobra.Command{
+	Use:   "vars",
+	Short: "Show the variables of the globals config with their resolved values",
+	Long: `Show the variables of the globals config, staged or committed, with the
+variables they refer to substituted. Variables that can't be resolved, such
+as ones referring to themselves, are reported after the others.`,
+	Args: cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		values, err := manager.Variables()
+		if len(values) > 0 {
+			w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+			fmt.Fprintln(w, "NAME\tVALUE")
+			for _, name := range slices.Sorted(maps.Keys(values)) {
+				fmt.Fprintf(w, "${%s}\t%s\n", name, values[name])
+			}
+			if flushErr := w.Flush(); flushErr != nil {
+				return flushErr
+			}
+		} else if err == nil {
+			fmt.Println("No variables")
+		}
+		return err
+	},
+}
+
 var setCmd = &cobra.Command{
 	Use:   "set <path> <value>",
 	Short: "Set configuration value (e.g., network.wan.ipaddr 192.168.1.1)",
@@ -145,6 +271,10 @@ var setCmd = &cobra.Command{
 		if err := manager.Set(path, value); err != nil {
 			return err
 		}
+		// Report the value as stored (e.g. booleans as 1 or 0)
+		if stored, err := manager.Get(path); err == nil {
+			value = stored
+		}
 
 		// Publish event
 		bus.Publish(bus.Event{
@@ -166,33 +296,209 @@ var commitCmd = &cobra.Command{
 	RunE: func(cmd *cobra.Command, args []string) error {
 		message, _ := cmd.Flags().GetString("message")
 		confirmTimeout, _ := cmd.Flags().GetInt("confirm-timeout")
+		if dryRun, _ := cmd.Flags().GetBool("dry-run"); dryRun {
+			return dryRunStaged()
+		}
+		return commitStaged(message, confirmTimeout)
+	},
+}
 
-		if message == "" {
-			message = "Configuration change"
+// loadApplyOrder honors the apply order from the Hellfire config, if one exists
+func loadApplyOrder() {
+	if _, err := os.Stat(hfconfig.DefaultConfigPath); err == nil {
+		if hfConfig, err := hfconfig.Load(""); err == nil {
+			transactionMgr.SetApplyOrder(hfConfig.Transaction.ApplyOrder)
+			transactionMgr.SetSkipApply(hfConfig.Transaction.SkipApply)
+		}
+	}
+}
+
+// commitStaged commits the staged changes, reporting progress on the terminal
+func commitStaged(message string, confirmTimeout int) error {
+	if message == "" {
+		message = "Configuration change"
+	}
+
+	confirmTimeoutDur := time.Duration(confirmTimeout) * time.Second
+	loadApplyOrder()
+
+	progress := newCommitProgress()
+	ctx := transaction.WithProgress(audit.WithIP(context.Background(), sshClientAddress()), progress.Handle)
+
+	// Call Commit with both confirmTimeout and overallTimeout (set overall to 0 = no timeout)
+	err := transactionMgr.Commit(ctx, message, confirmTimeoutDur, 0)
+	progress.Stop()
+	if err != nil {
+		return err
+	}
+
+	if confirmTimeout > 0 {
+		fmt.Printf("Changes applied successfully.\n")
+		fmt.Printf("You have %d seconds to confirm or changes will be rolled back.\n", confirmTimeout)
+		fmt.Printf("Run 'hf confirm' to confirm changes.\n")
+	} else {
+		fmt.Println("Changes committed successfully")
+	}
+
+	return nil
+}
+
+// dryRunStaged shows what committing the staged changes would do: the diff
+// of each config, the apply order and what the appliers would load. It fails
+// if the commit would fail its checks.
+func dryRunStaged() error {
+	loadApplyOrder()
+	result, err := transactionMgr.DryRun(audit.WithIP(context.Background(), sshClientAddress()))
+	if err != nil {
+		return err
+	}
+
+	for _, name := range result.Configs {
+		if diff := result.Diffs[name]; diff != "" {
+			fmt.Print(diff)
+		} else {
+			fmt.Printf("%s: unchanged\n", name)
 		}
+	}
+	fmt.Println()
+	fmt.Printf("Snapshot before commit: %s\n", strings.Join(result.Configs, ", "))
+	if len(result.Apply) > 0 {
+		fmt.Printf("Apply order: %s\n", strings.Join(result.Apply, ", "))
+	}
+	if len(result.NotApplied) > 0 {
+		fmt.Printf("Written without applying: %s\n", strings.Join(result.NotApplied, ", "))
+	}
 
-		confirmTimeoutDur := time.Duration(confirmTimeout) * time.Second
+	for _, name := range result.Apply {
+		for _, artifact := range result.Artifacts[name] {
+			fmt.Printf("\n==> %s: %s\n%s", name, artifact.Name, artifact.Content)
+		}
+	}
+
+	if !result.Valid() {
+		fmt.Println()
+		fmt.Println("The commit would fail:")
+		for _, name := range result.Configs {
+			for _, msg := range result.Errors[name] {
+				fmt.Printf("  %s: %s\n", name, msg)
+			}
+		}
+		for _, issue := range result.Issues {
+			fmt.Printf("  %s\n", issue)
+		}
+		return fmt.Errorf("dry run failed; nothing was changed")
+	}
+
+	fmt.Println()
+	fmt.Println("Dry run: nothing was changed")
+	return nil
+}
+
+func init() {
+	showCmd.Flags().Bool("reveal-secrets", false, "Show passwords, keys and other secrets instead of masking them")
+	exportCmd.Flags().Bool("reveal-secrets", false, "Export passwords, keys and other secrets instead of masking them (for backups)")
+	showCmd.Flags().Bool("resolved", false, "Show variables and expressions as their values")
+	exportCmd.Flags().Bool("resolved", false, "Export variables and expressions as their values")
+}
 
-		// Call Commit with both confirmTimeout and overallTimeout (set overall to 0 = no timeout)
-		if err := transactionMgr.Commit(message, confirmTimeoutDur, 0); err != nil {
+func init() {
+	commitCmd.Flags().StringP("message", "m", "", "Commit message")
+	commitCmd.Flags().IntP("confirm-timeout", "t", 0, "Confirmation timeout in seconds (0 = no confirmation required)")
+	commitCmd.Flags().Bool("dry-run", false, "Show what the commit would do without changing anything")
+
+	rollbackCmd.Flags().StringP("reason", "r", "", "Why the changes are rolled back, recorded on the transaction")
+}
+
+var batchCmd = &cobra.Command{
+	Use:   "batch [file]",
+	Short: "Run a uci batch script",
+	Long: `Run a script of uci batch commands (set, add, add_list, del_list, delete and
+commit) from a file, or from stdin when no file or "-" is given.
+
+The script is staged as one changeset: if any line fails, nothing is staged.
+A commit line (or --commit) commits the changeset as a single transaction;
+without one the script is only checked. With --dry-run, the diff, apply
+order and generated rulesets and files of the commit are shown instead.`,
+	Args: cobra.MaximumNArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		message, _ := cmd.Flags().GetString("message")
+		confirmTimeout, _ := cmd.Flags().GetInt("confirm-timeout")
+		commit, _ := cmd.Flags().GetBool("commit")
+		dryRun, _ := cmd.Flags().GetBool("dry-run")
+
+		input := os.Stdin
+		if len(args) == 1 && args[0] != "-" {
+			f, err := os.Open(args[0])
+			if err != nil {
+				return err
+			}
+			defer f.Close()
+			input = f
+		}
+
+		script, err := config.ParseScript(input)
+		if err != nil {
 			return err
 		}
+		commit = commit || script.Commit
 
-		if confirmTimeout > 0 {
-			fmt.Printf("Changes applied successfully.\n")
-			fmt.Printf("You have %d seconds to confirm or changes will be rolled back.\n", confirmTimeout)
-			fmt.Printf("Run 'hf confirm' to confirm changes.\n")
-		} else {
-			fmt.Println("Changes committed successfully")
+		// A committed script is its own transaction, not mixed with other edits
+		if commit && manager.HasChanges() {
+			return fmt.Errorf("there are uncommitted changes; commit or revert them first")
 		}
 
+		staged, err := manager.Batch(script.Operations)
+		if err != nil {
+			var opErr *config.OperationError
+			if errors.As(err, &opErr) {
+				return fmt.Errorf("line %d: %s %s: %w (nothing was staged)",
+					script.Lines[opErr.Index], opErr.Op.Op, opErr.Op.Path, opErr.Err)
+			}
+			return err
+		}
+
+		for _, name := range staged {
+			bus.Publish(bus.Event{
+				Type:       bus.EventConfigChanged,
+				ConfigName: name,
+			})
+		}
+		if dryRun {
+			if len(staged) == 0 {
+				fmt.Println("The script changes nothing")
+				return nil
+			}
+			return dryRunStaged()
+		}
+		if !commit {
+			// Staged changes don't outlive this process
+			fmt.Printf("Checked %d changes to %s; nothing was applied as the script has no commit (use --commit)\n",
+				len(script.Operations), strings.Join(staged, ", "))
+			return nil
+		}
+		fmt.Printf("Staged %d changes to %s\n", len(script.Operations), strings.Join(staged, ", "))
+		if len(staged) == 0 {
+			return nil
+		}
+		if message == "" {
+			message = "Batch script"
+			if len(args) == 1 && args[0] != "-" {
+				message += " " + args[0]
+			}
+		}
+		if err := commitStaged(message, confirmTimeout); err != nil {
+			_ = manager.Revert()
+			return err
+		}
 		return nil
 	},
 }
 
 func init() {
-	commitCmd.Flags().StringP("message", "m", "", "Commit message")
-	commitCmd.Flags().IntP("confirm-timeout", "t", 0, "Confirmation timeout in seconds (0 = no confirmation required)")
+	batchCmd.Flags().Bool("commit", false, "Commit the changes even if the script doesn't")
+	batchCmd.Flags().Bool("dry-run", false, "Show what committing the script would do without changing anything")
+	batchCmd.Flags().StringP("message", "m", "", "Commit message")
+	batchCmd.Flags().IntP("confirm-timeout", "t", 0, "Confirmation timeout in seconds (0 = no confirmation required)")
 }
 
 var confirmCmd = &cobra.Command{
@@ -200,7 +506,7 @@ var confirmCmd = &cobra.Command{
 	Short: "Confirm pending configuration changes",
 	Long:  "Confirm changes that are waiting for confirmation (prevents auto-rollback)",
 	RunE: func(cmd *cobra.Command, args []string) error {
-		if err := transactionMgr.Confirm(); err != nil {
+		if err := transactionMgr.Confirm(context.Background()); err != nil {
 			return err
 		}
 
@@ -210,11 +516,17 @@ var confirmCmd = &cobra.Command{
 }
 
 var rollbackCmd = &cobra.Command{
-	Use:   "rollback",
+	Use:   "rollback [snapshot-id]",
 	Short: "Rollback to previous configuration",
-	Long:  "Rollback to the most recent snapshot",
+	Long:  "Rollback to the most recent snapshot, or to the given snapshot as a new transaction",
+	Args:  cobra.MaximumNArgs(1),
 	RunE: func(cmd *cobra.Command, args []string) error {
-		if err := transactionMgr.Rollback(); err != nil {
+		snapshotID := ""
+		if len(args) == 1 {
+			snapshotID = args[0]
+		}
+
+		if err := rollback(cmd, snapshotID); err != nil {
 			return err
 		}
 
@@ -223,13 +535,27 @@ var rollbackCmd = &cobra.Command{
 	},
 }
 
+// rollback rolls back with the --reason of cmd, asking for a reason on a
+// terminal if one is required but wasn't given
+func rollback(cmd *cobra.Command, snapshotID string) error {
+	reason, _ := cmd.Flags().GetString("reason")
+	err := transactionMgr.Rollback(transaction.WithRollbackReason(context.Background(), reason), snapshotID)
+	if errors.Is(err, transaction.ErrRollbackReasonRequired) && term.IsTerminal(int(os.Stdin.Fd())) {
+		fmt.Print("Reason for the rollback: ")
+		line, _ := bufio.NewReader(os.Stdin).ReadString('\n')
+		if reason = strings.TrimSpace(line); reason != "" {
+			err = transactionMgr.Rollback(transaction.WithRollbackReason(context.Background(), reason), snapshotID)
+		}
+	}
+	return err
+}
+
 var exportCmd = &cobra.Command{
 	Use:   "export <config>",
 	Short: "Export configuration to stdout",
 	Args:  cobra.ExactArgs(1),
 	RunE: func(cmd *cobra.Command, args []string) error {
-		configName := args[0]
-		return manager.Export(configName, os.Stdout)
+		return exportConfig(cmd, args[0])
 	},
 }
 
@@ -255,13 +581,46 @@ var serveCmd = &cobra.Command{
 	Use:   "serve",
 	Short: "Start web API server",
 	RunE: func(cmd *cobra.Command, args []string) error {
-		port, _ := cmd.Flags().GetInt("port")
-		return startAPIServer(port, manager)
+		flags := cmd.Flags()
+		port, _ := flags.GetInt("port")
+		webRoot, _ := flags.GetString("web-root")
+		listen, _ := flags.GetStringSlice("listen")
+		grpcPort, _ := flags.GetInt("grpc-port")
+		origins, _ := flags.GetStringSlice("allowed-origin")
+
+		// Flags that were given take precedence over the environment and the config file
+		overrides := func(cfg *hfconfig.Config) {
+			if flags.Changed("port") {
+				cfg.API.Port = port
+			}
+			if flags.Changed("listen") {
+				cfg.API.Listen = listen
+			}
+			if flags.Changed("web-root") {
+				cfg.API.WebRoot = webRoot
+			}
+			if flags.Changed("grpc-port") {
+				cfg.GRPC.Enabled = true
+				cfg.GRPC.Port = grpcPort
+			}
+			if flags.Changed("allowed-origin") {
+				cfg.API.AllowedOrigins = origins
+			}
+			// The appliers were chosen at startup; a reload can't switch them
+			cfg.Transaction.Simulate = simulate
+		}
+		return startAPIServer(overrides, manager, snapshotMgr, transactionMgr)
 	},
 }
 
 func init() {
-	serveCmd.Flags().Int("port", 8888, "API server port")
+	serveCmd.Flags().Int("port", hfconfig.DefaultAPIPort, "API server port (HF_API_PORT)")
+	serveCmd.Flags().StringSlice("listen", nil, "Listen address, host:port or unix:/path (repeatable; HF_API_LISTEN)")
+	serveCmd.Flags().String("web-root", "", "Serve the web UI from this directory instead of the embedded build (HF_API_WEB_ROOT)")
+	serveCmd.Flags().Int("grpc-port", hfconfig.DefaultGRPCPort, "Start the gRPC server on this port (HF_GRPC_ENABLED, HF_GRPC_PORT)")
+	serveCmd.Flags().StringSlice("allowed-origin", nil, "CORS origin (repeatable; HF_API_ALLOWED_ORIGINS)")
+	serveCmd.Flags().Bool("demo", false, "Serve a demo on a simulated system, without root and without changing this one")
+	serveCmd.Flags().String("demo-dir", "", "Keep the demo's configs, snapshots and database in this directory (default: a new temporary one)")
 }
 
 // Snapshot commands
@@ -287,7 +646,11 @@ var snapshotListCmd = &cobra.Command{
 
 		fmt.Println("Available snapshots:")
 		for i, snap := range snapshots {
-			fmt.Printf("%d. %s - %s\n", i+1, snap.ID, snap.Metadata.Message)
+			protected := ""
+			if snap.Metadata.Protected {
+				protected = " (protected)"
+			}
+			fmt.Printf("%d. %s - %s%s\n", i+1, snap.ID, snap.Metadata.Message, protected)
 			fmt.Printf("   Time: %s\n", snap.Metadata.Timestamp.Format("2006-01-02 15:04:05"))
 			fmt.Printf("   Configs: %v\n", snap.Metadata.Configs)
 			fmt.Println()
@@ -300,7 +663,10 @@ var snapshotListCmd = &cobra.Command{
 var snapshotRestoreCmd = &cobra.Command{
 	Use:   "restore <id>",
 	Short: "Restore a snapshot",
-	Args:  cobra.ExactArgs(1),
+	Long: "Restore a snapshot by copying its files into the config directory.\n" +
+		"Use --commit to stage its contents and commit them as a transaction instead, or\n" +
+		"--apply to roll back to the snapshot with automatic revert on failure.",
+	Args: cobra.ExactArgs(1),
 	RunE: func(cmd *cobra.Command, args []string) error {
 		id := args[0]
 
@@ -313,12 +679,71 @@ var snapshotRestoreCmd = &cobra.Command{
 		fmt.Printf("Restoring snapshot: %s\n", snap.Metadata.Message)
 		fmt.Printf("Created: %s\n", snap.Metadata.Timestamp.Format("2006-01-02 15:04:05"))
 
-		if err := snapshotMgr.Restore(id); err != nil {
+		// Snapshots of another router may not work on this one
+		if origin := snap.Metadata.Origin; origin != nil {
+			fmt.Printf("Imported from: %s (hellfire %s)\n", origin.Hostname, origin.Version)
+			configs, err := snapshotMgr.ReadConfigs(id)
+			if err != nil {
+				return err
+			}
+			force, _ := cmd.Flags().GetBool("force")
+			if err := checkPortability(configs, origin.Devices, force); err != nil {
+				return err
+			}
+		}
+
+		if apply, _ := cmd.Flags().GetBool("apply"); apply {
+			if err := rollback(cmd, id); err != nil {
+				return err
+			}
+
+			fmt.Println("Snapshot restored and applied successfully")
+			return nil
+		}
+
+		if commit, _ := cmd.Flags().GetBool("commit"); !commit {
+			if err := snapshotMgr.Restore(id); err != nil {
+				return err
+			}
+
+			fmt.Println("Snapshot files restored successfully")
+			fmt.Println("Note: Run 'hf <network|firewall|dhcp> apply' to apply the restored configuration,")
+			fmt.Println("or restore with --commit to apply it as a transaction")
+			return nil
+		}
+
+		// Stage the snapshot contents and commit them as a regular transaction
+		configs, err := snapshotMgr.ReadConfigs(id)
+		if err != nil {
+			return err
+		}
+
+		if len(configs) == 0 {
+			fmt.Println("Snapshot contains no configs, nothing to restore")
+			return nil
+		}
+
+		for name, cfg := range configs {
+			if err := manager.Stage(name, cfg); err != nil {
+				return fmt.Errorf("failed to stage %s: %w", name, err)
+			}
+		}
+
+		message, _ := cmd.Flags().GetString("message")
+		if message == "" {
+			message = fmt.Sprintf("Restore snapshot %s", id)
+		}
+
+		confirmTimeout, _ := cmd.Flags().GetInt("confirm-timeout")
+		ctx := audit.WithIP(context.Background(), sshClientAddress())
+		if err := transactionMgr.Commit(ctx, message, time.Duration(confirmTimeout)*time.Second, 0); err != nil {
 			return err
 		}
 
-		fmt.Println("Snapshot restored successfully")
-		fmt.Println("Note: Run 'hf commit' to apply the restored configuration")
+		fmt.Println("Snapshot restored and applied successfully")
+		if confirmTimeout > 0 {
+			fmt.Printf("You have %d seconds to confirm or changes will be rolled back.\n", confirmTimeout)
+		}
 		return nil
 	},
 }
@@ -348,12 +773,46 @@ var snapshotPruneCmd = &cobra.Command{
 	},
 }
 
+var snapshotProtectCmd = &cobra.Command{
+	Use:   "protect <id>",
+	Short: "Protect a snapshot from automatic pruning",
+	Args:  cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if _, err := snapshotMgr.Protect(args[0], true); err != nil {
+			return err
+		}
+		fmt.Printf("Snapshot %s is protected from pruning\n", args[0])
+		return nil
+	},
+}
+
+var snapshotUnprotectCmd = &cobra.Command{
+	Use:   "unprotect <id>",
+	Short: "Let a protected snapshot be pruned again",
+	Args:  cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if _, err := snapshotMgr.Protect(args[0], false); err != nil {
+			return err
+		}
+		fmt.Printf("Snapshot %s can be pruned again\n", args[0])
+		return nil
+	},
+}
+
 func init() {
 	snapshotCmd.AddCommand(snapshotListCmd)
 	snapshotCmd.AddCommand(snapshotRestoreCmd)
 	snapshotCmd.AddCommand(snapshotPruneCmd)
-
-	snapshotPruneCmd.Flags().Int("keep", 30, "Number of snapshots to keep")
+	snapshotCmd.AddCommand(snapshotProtectCmd)
+	snapshotCmd.AddCommand(snapshotUnprotectCmd)
+
+	snapshotPruneCmd.Flags().Int("keep", 30, "Number of snapshots to keep, besides the protected ones")
+	snapshotRestoreCmd.Flags().Bool("apply", false, "Roll back to the snapshot as a transaction, reverting on failure")
+	snapshotRestoreCmd.Flags().Bool("commit", false, "Stage the snapshot contents and commit them as a transaction")
+	snapshotRestoreCmd.Flags().StringP("message", "m", "", "Commit message (with --commit)")
+	snapshotRestoreCmd.Flags().IntP("confirm-timeout", "t", 0, "Confirmation timeout in seconds (with --commit, 0 = no confirmation required)")
+	snapshotRestoreCmd.Flags().StringP("reason", "r", "", "Why the snapshot is rolled back to (with --apply)")
+	snapshotRestoreCmd.Flags().Bool("force", false, "Restore a snapshot imported from another router despite portability issues")
 }
 
 // Network commands (for systemd)
@@ -368,7 +827,7 @@ var networkApplyCmd = &cobra.Command{
 	RunE: func(cmd *cobra.Command, args []string) error {
 		applier := appliers.NewNetworkApplier()
 
-		cfg, err := manager.Load("network")
+		cfg, err := manager.LoadDecrypted("network")
 		if err != nil {
 			return fmt.Errorf("failed to load network config: %w", err)
 		}
@@ -408,14 +867,18 @@ var firewallApplyCmd = &cobra.Command{
 	Use:   "apply",
 	Short: "Apply firewall rules",
 	RunE: func(cmd *cobra.Command, args []string) error {
-		applier := appliers.NewFirewallApplier()
+		applier := newFirewallApplier()
 
-		cfg, err := manager.Load("firewall")
+		cfg, err := manager.LoadDecrypted("firewall")
 		if err != nil {
 			return fmt.Errorf("failed to load firewall config: %w", err)
 		}
 
-		ctx := context.Background()
+		ctx := audit.WithIP(context.Background(), sshClientAddress())
+		// Enforce the kill switches of the network's VPN policies
+		if network, err := manager.Load("network"); err == nil {
+			ctx = appliers.WithNetworkConfig(ctx, network)
+		}
 		if err := applier.Apply(ctx, cfg); err != nil {
 			return fmt.Errorf("failed to apply firewall rules: %w", err)
 		}
@@ -462,7 +925,7 @@ var dhcpApplyCmd = &cobra.Command{
 	RunE: func(cmd *cobra.Command, args []string) error {
 		applier := appliers.NewDHCPApplier()
 
-		cfg, err := manager.Load("dhcp")
+		cfg, err := manager.LoadDecrypted("dhcp")
 		if err != nil {
 			return fmt.Errorf("failed to load dhcp config: %w", err)
 		}
@@ -481,90 +944,106 @@ func init() {
 	dhcpCmd.AddCommand(dhcpApplyCmd)
 }
 
-// bootstrapDefaultUser creates a default admin user if no users exist
-func bootstrapDefaultUser() error {
-	// Check if any users exist
-	count, err := db.CountUsers()
-	if err != nil {
-		return fmt.Errorf("failed to count users: %w", err)
+// newFirewallApplier creates the firewall applier with the management plane
+// rules from the Hellfire config
+func newFirewallApplier() *appliers.FirewallApplier {
+	applier := appliers.NewFirewallApplier()
+
+	if _, err := os.Stat(hfconfig.DefaultConfigPath); err != nil {
+		return applier
 	}
 
-	// If users exist, don't create default user
-	if count > 0 {
-		return nil
+	hfConfig, err := hfconfig.Load("")
+	if err != nil {
+		return applier
 	}
 
-	logger.Info("No users found, creating default admin user")
+	applier.SetManagementAccess(hfConfig.ManagementAccess())
+	return applier
+}
 
-	// Generate cryptographically secure random password
-	randomPassword, err := generateSecurePassword(16)
-	if err != nil {
-		return fmt.Errorf("failed to generate secure password: %w", err)
+// setManagementAccess updates the management plane rules of the registered
+// firewall applier; they take effect on the next firewall apply
+func setManagementAccess(hfConfig *hfconfig.Config) {
+	if applier, ok := applierRegistry.Get("firewall"); ok {
+		if firewall, ok := applier.(interface {
+			SetManagementAccess(*appliers.ManagementAccess)
+		}); ok {
+			firewall.SetManagementAccess(hfConfig.ManagementAccess())
+		}
 	}
+}
 
-	// Hash the password
-	passwordHash, err := auth.HashPassword(randomPassword)
+// setCanary sets the router the transaction manager applies commits to
+// first, if one is configured
+func setCanary(txMgr *transaction.Manager, hfConfig *hfconfig.Config) {
+	peer, err := hfConfig.CanaryPeer()
 	if err != nil {
-		return fmt.Errorf("failed to hash password: %w", err)
+		logger.Warn("Canary is misconfigured, commits are applied to this router only", "error", err)
+	}
+	if peer == nil {
+		txMgr.SetCanary(nil)
+		return
 	}
+	txMgr.SetCanary(peer)
+}
 
-	defaultUser := &db.User{
-		Username:     "admin",
-		PasswordHash: passwordHash,
-		Email:        "admin@localhost",
-		Role:         db.RoleAdmin,
-		Enabled:      true,
+// sshClientAddress returns the address of the SSH client running this
+// command, if any, so its session survives firewall changes it commits
+func sshClientAddress() string {
+	if client := strings.Fields(os.Getenv("SSH_CLIENT")); len(client) > 0 {
+		return client[0]
+	}
+	return ""
+}
+
+// issueOnboardingToken prints a one-time token for creating the first admin
+// user through POST /api/v1/onboarding, if no users exist. It goes to the
+// console (the journal under systemd), never to a file or the log.
+func issueOnboardingToken(ttl time.Duration) error {
+	count, err := db.CountUsers()
+	if err != nil {
+		return fmt.Errorf("failed to count users: %w", err)
+	}
+	if count > 0 {
+		return nil
 	}
 
-	if err := db.CreateUser(defaultUser); err != nil {
-		return fmt.Errorf("failed to create default user: %w", err)
+	token, expiresAt, err := auth.IssueOnboardingToken(ttl)
+	if err != nil {
+		return err
 	}
 
-	logger.Warn("Default admin user created with random password",
-		"username", "admin",
-		"warning", "SAVE THE PASSWORD SHOWN BELOW - IT CANNOT BE RETRIEVED!")
+	logger.Warn("No users found, issued a one-time onboarding token (printed to the console)",
+		"expires_at", expiresAt.Format(time.RFC3339))
 
 	fmt.Println()
 	fmt.Println("━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━")
-	fmt.Println("  DEFAULT ADMIN USER CREATED")
-	fmt.Println("  Username: admin")
-	fmt.Printf("  Password: %s\n", randomPassword)
+	fmt.Println("  NO USERS YET: CREATE THE ADMIN USER IN THE WEB UI")
+	fmt.Printf("  Onboarding token: %s\n", token)
+	fmt.Printf("  Valid until %s, for one use\n", expiresAt.Format(time.RFC3339))
 	fmt.Println()
-	fmt.Println("  ⚠️  SAVE THIS PASSWORD - IT WILL NOT BE SHOWN AGAIN!")
-	fmt.Println("  To change password later: hf user password admin")
+	fmt.Println("  Restart hf serve for a new token, or create the user with:")
+	fmt.Println("  hf user create <username> --role admin")
 	fmt.Println("━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━")
 	fmt.Println()
 
-	// Also write to a secure log file (owner-only readable)
-	logPath := "/var/lib/hellfire/initial-admin-password.txt"
-	logContent := fmt.Sprintf("Initial admin password: %s\nGenerated: %s\n",
-		randomPassword, time.Now().Format(time.RFC3339))
-
-	if err := os.WriteFile(logPath, []byte(logContent), 0600); err != nil {
-		logger.Warn("Failed to write password to log file",
-			"path", logPath,
-			"error", err)
-	} else {
-		fmt.Printf("  Password also saved to: %s\n", logPath)
-		fmt.Println()
-	}
-
 	return nil
 }
 
-// generateSecurePassword generates a cryptographically secure random password
-func generateSecurePassword(length int) (string, error) {
-	const charset = "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789!@#$%^&*()-_=+"
+// legacyPasswordFile is where earlier versions wrote the generated admin
+// password, next to the database
+func legacyPasswordFile() string {
+	return filepath.Join(filepath.Dir(dbPath), "initial-admin-password.txt")
+}
 
-	password := make([]byte, length)
-	for i := range password {
-		// Generate random index
-		num, err := rand.Int(rand.Reader, big.NewInt(int64(len(charset))))
-		if err != nil {
-			return "", fmt.Errorf("failed to generate random number: %w", err)
-		}
-		password[i] = charset[num.Int64()]
+// removeLegacyPasswordFile deletes the plaintext admin password file of
+// earlier versions once someone has logged in
+func removeLegacyPasswordFile() {
+	path := legacyPasswordFile()
+	if err := os.Remove(path); err == nil {
+		logger.Info("Removed the initial admin password file", "path", path)
+	} else if !os.IsNotExist(err) {
+		logger.Warn("Failed to remove the initial admin password file", "path", path, "error", err)
 	}
-
-	return string(password), nil
 }
@@ -0,0 +1,116 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/spf13/cobra"
+
+	"github.com/thesabbir/hellfire/pkg/bus"
+	"github.com/thesabbir/hellfire/pkg/openwrt"
+	"github.com/thesabbir/hellfire/pkg/portability"
+	"github.com/thesabbir/hellfire/pkg/uci"
+)
+
+var importOpenWrtCmd = &cobra.Command{
+	Use:   "import-openwrt <dir|tarball>",
+	Short: "Import an OpenWrt config backup",
+	Long: `Import the network, firewall, dhcp and wireless configs of an OpenWrt backup:
+an /etc/config directory, a root directory containing etc/config, or a
+sysupgrade backup tarball.
+
+OpenWrt interfaces are renamed to their devices, DHCP pools are converted
+to address ranges and options without a Hellfire equivalent are reported.
+The imported configs replace the current ones. Without --commit they are
+only shown for review; with it they are committed as one transaction.
+A portability report lists the interfaces missing here and the programs
+they need that aren't installed; committing is refused while it has any
+but warnings, unless --force is given.`,
+	Args: cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		commit, _ := cmd.Flags().GetBool("commit")
+		force, _ := cmd.Flags().GetBool("force")
+		message, _ := cmd.Flags().GetString("message")
+		confirmTimeout, _ := cmd.Flags().GetInt("confirm-timeout")
+
+		configs, err := openwrt.Load(args[0])
+		if err != nil {
+			return err
+		}
+		result := openwrt.Convert(configs)
+
+		var imported []string
+		for _, name := range openwrt.Configs {
+			cfg, ok := result.Configs[name]
+			if !ok {
+				continue
+			}
+			imported = append(imported, name)
+			if !commit {
+				fmt.Printf("==> %s\n", name)
+				if err := uci.Write(os.Stdout, cfg); err != nil {
+					return err
+				}
+				fmt.Println()
+			}
+		}
+
+		if len(result.Issues) > 0 {
+			fmt.Printf("Not imported (%d):\n", len(result.Issues))
+			for _, issue := range result.Issues {
+				fmt.Printf("  %s\n", issue)
+			}
+			fmt.Println()
+		}
+		imports := make(map[string]*uci.Config, len(imported))
+		for _, name := range imported {
+			imports[name] = result.Configs[name]
+		}
+		issues, err := portabilityReport(imports, nil)
+		if err != nil {
+			return err
+		}
+		fmt.Println()
+
+		if !commit {
+			fmt.Printf("Nothing was changed; run with --commit to replace %s with the configs above\n", strings.Join(imported, ", "))
+			return nil
+		}
+
+		if len(portability.Blocking(issues)) > 0 && !force {
+			return fmt.Errorf("fix the portability issues above before committing, or use --force")
+		}
+
+		// The import replaces whole configs, so it isn't mixed with other edits
+		if manager.HasChanges() {
+			return fmt.Errorf("there are uncommitted changes; commit or revert them first")
+		}
+		for _, name := range imported {
+			if err := manager.Stage(name, result.Configs[name]); err != nil {
+				_ = manager.Revert()
+				return fmt.Errorf("failed to stage %s: %w", name, err)
+			}
+			bus.Publish(bus.Event{
+				Type:       bus.EventConfigChanged,
+				ConfigName: name,
+			})
+		}
+
+		if message == "" {
+			message = "Import OpenWrt configs from " + args[0]
+		}
+		if err := commitStaged(message, confirmTimeout); err != nil {
+			_ = manager.Revert()
+			return err
+		}
+		return nil
+	},
+}
+
+func init() {
+	importOpenWrtCmd.Flags().Bool("commit", false, "Commit the imported configs instead of only showing them")
+	importOpenWrtCmd.Flags().Bool("force", false, "Commit despite portability issues, such as interfaces missing here")
+	importOpenWrtCmd.Flags().StringP("message", "m", "", "Commit message")
+	importOpenWrtCmd.Flags().IntP("confirm-timeout", "t", 0, "Confirmation timeout in seconds (0 = no confirmation required)")
+}
@@ -0,0 +1,319 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/netip"
+	"os"
+	"os/exec"
+	"os/signal"
+	"syscall"
+	"text/tabwriter"
+
+	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
+
+	"github.com/thesabbir/hellfire/pkg/audit"
+	"github.com/thesabbir/hellfire/pkg/hfconfig"
+	"github.com/thesabbir/hellfire/pkg/logger"
+	"github.com/thesabbir/hellfire/pkg/plugin"
+)
+
+// pluginGroup is the help group plugin commands are listed in
+const pluginGroup = "plugins"
+
+// pluginRegistry holds the plugin commands registered through the API
+var pluginRegistry = plugin.NewRegistry(plugin.DefaultRegistryFile)
+
+var pluginCmd = &cobra.Command{
+	Use:   "plugin",
+	Short: "Manage plugin commands",
+	Long: `Plugin commands run as subcommands of hf: 'hf foo' runs the hf-foo
+executable found on PATH, as git does, or the command an add-on daemon
+registered as foo through the API. Registered commands win over those on
+PATH; built-in commands win over both.
+
+Plugins get the arguments after their name, and in their environment the
+settings hf was run with (HF_CONFIG_DIR, HF_DB_PATH, ...), the address of
+the API (HF_API_URL or HF_API_SOCKET), and the caller's credentials
+(HF_API_KEY or HF_API_TOKEN) as they were given to hf.`,
+}
+
+var pluginListCmd = &cobra.Command{
+	Use:   "list",
+	Short: "List plugin commands",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		registered, err := pluginRegistry.List()
+		if err != nil {
+			return err
+		}
+		commands := plugin.Merge(registered, plugin.Discover(os.Getenv("PATH")))
+		if len(commands) == 0 {
+			fmt.Println("No plugin commands found")
+			return nil
+		}
+
+		w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(w, "NAME\tSOURCE\tPATH\tDESCRIPTION")
+		for _, command := range commands {
+			name := command.Name
+			if builtin, _, err := cmd.Root().Find([]string{name}); err == nil && builtin != cmd.Root() && builtin.GroupID != pluginGroup {
+				name += " (hidden by built-in)"
+			}
+			fmt.Fprintf(w, "%s\t%s\t%s\t%s\n", name, command.Source, command.Path, command.Description)
+		}
+		return w.Flush()
+	},
+}
+
+var pluginRegisterCmd = &cobra.Command{
+	Use:   "register <name> <path>",
+	Short: "Register a plugin command",
+	Long: `Register an executable as 'hf <name>'. It must be an absolute path that
+only root or the user running hf can modify, in directories they alone
+can write to. Add-on daemons register their commands with
+PUT /api/v1/plugins/{name} instead.`,
+	Args: cobra.ExactArgs(2),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		description, _ := cmd.Flags().GetString("description")
+		command := plugin.Command{Name: args[0], Path: args[1], Description: description, RegisteredBy: "system"}
+		if err := pluginRegistry.Register(command); err != nil {
+			audit.LogFailure(audit.ActionPluginRegister, nil, "system", "plugin:"+args[0], "Failed to register plugin command", err)
+			return err
+		}
+		audit.LogSuccess(audit.ActionPluginRegister, nil, "system", "plugin:"+args[0],
+			fmt.Sprintf("Plugin command '%s' registered as %s", args[0], args[1]))
+		fmt.Printf("Registered 'hf %s'\n", args[0])
+		return nil
+	},
+}
+
+var pluginUnregisterCmd = &cobra.Command{
+	Use:   "unregister <name>",
+	Short: "Unregister a plugin command",
+	Args:  cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		found, err := pluginRegistry.Unregister(args[0])
+		if err != nil {
+			return err
+		}
+		if !found {
+			return fmt.Errorf("plugin command %q is not registered", args[0])
+		}
+		audit.LogSuccess(audit.ActionPluginUnregister, nil, "system", "plugin:"+args[0],
+			fmt.Sprintf("Plugin command '%s' unregistered", args[0]))
+		fmt.Printf("Unregistered 'hf %s'\n", args[0])
+		return nil
+	},
+}
+
+func init() {
+	pluginRegisterCmd.Flags().StringP("description", "d", "", "One-line description shown in hf --help")
+
+	pluginCmd.AddCommand(pluginListCmd, pluginRegisterCmd, pluginUnregisterCmd)
+}
+
+// addPluginCommands adds the plugin commands to root, in their own help
+// group, except those a built-in command hides
+func addPluginCommands(root *cobra.Command) []plugin.Command {
+	registered, err := pluginRegistry.List()
+	if err != nil {
+		logger.Warn("Ignoring the registered plugin commands", "error", err)
+	}
+
+	var added []plugin.Command
+	for _, command := range plugin.Merge(registered, plugin.Discover(os.Getenv("PATH"))) {
+		// Cobra adds these when it runs
+		if command.Name == "help" || command.Name == "completion" {
+			continue
+		}
+		if builtin, _, err := root.Find([]string{command.Name}); err == nil && builtin != root {
+			logger.Debug("Plugin command hidden by a built-in command", "name", command.Name, "path", command.Path)
+			continue
+		}
+		added = append(added, command)
+
+		short := command.Description
+		if short == "" {
+			short = "Plugin command (" + command.Path + ")"
+		}
+		root.AddCommand(&cobra.Command{
+			Use:                command.Name,
+			Short:              short,
+			GroupID:            pluginGroup,
+			DisableFlagParsing: true,
+			// Reached when global flags hf doesn't know come first; the
+			// plugin needs none of the setup of the built-in commands
+			PersistentPreRunE: func(cmd *cobra.Command, args []string) error { return nil },
+			RunE: func(cmd *cobra.Command, args []string) error {
+				os.Exit(runPlugin(command, args))
+				return nil
+			},
+		})
+	}
+	if len(added) > 0 {
+		root.AddGroup(&cobra.Group{ID: pluginGroup, Title: "Plugin Commands:"})
+		// Help for a plugin command is the plugin's own
+		defaultHelp := root.HelpFunc()
+		root.SetHelpFunc(func(cmd *cobra.Command, args []string) {
+			if cmd.GroupID == pluginGroup {
+				if command := findPlugin(added, cmd.Name()); command != nil {
+					os.Exit(runPlugin(*command, []string{"--help"}))
+				}
+			}
+			defaultHelp(cmd, args)
+		})
+	}
+	return added
+}
+
+// dispatchPlugin runs the plugin command args name, after hf's global
+// flags, if it is one. It reports whether it ran one, with its exit code.
+func dispatchPlugin(root *cobra.Command, commands []plugin.Command, args []string) (int, bool) {
+	if len(commands) == 0 {
+		return 0, false
+	}
+
+	// The global flags set the settings passed on to the plugin
+	flags := pflag.NewFlagSet("hf", pflag.ContinueOnError)
+	flags.SetInterspersed(false)
+	flags.SetOutput(io.Discard)
+	flags.Usage = func() {}
+	flags.AddFlagSet(root.PersistentFlags())
+	if err := flags.Parse(args); err != nil || flags.NArg() == 0 {
+		return 0, false
+	}
+
+	command := findPlugin(commands, flags.Arg(0))
+	if command == nil {
+		return 0, false
+	}
+	return runPlugin(*command, flags.Args()[1:]), true
+}
+
+// findPlugin returns the plugin command of a name, or nil
+func findPlugin(commands []plugin.Command, name string) *plugin.Command {
+	for i := range commands {
+		if commands[i].Name == name {
+			return &commands[i]
+		}
+	}
+	return nil
+}
+
+// runPlugin runs a plugin command with the terminal of hf and returns its
+// exit code. Interrupts reach the plugin from the terminal; hf waits for it
+// to exit rather than dying first.
+func runPlugin(command plugin.Command, args []string) int {
+	if command.Source == plugin.SourceRegistered {
+		if err := plugin.CheckExecutable(command.Path); err != nil {
+			fmt.Fprintf(os.Stderr, "hf %s: refusing to run: %v\n", command.Name, err)
+			return 1
+		}
+	}
+
+	c := exec.Command(command.Path, args...)
+	c.Stdin, c.Stdout, c.Stderr = os.Stdin, os.Stdout, os.Stderr
+	c.Env = append(os.Environ(), pluginEnv(command, loadHellfireConfig())...)
+
+	if err := c.Start(); err != nil {
+		fmt.Fprintf(os.Stderr, "hf %s: %v\n", command.Name, err)
+		return 1
+	}
+
+	// SIGTERM, unlike an interrupt, is sent to hf alone
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		for sig := range signals {
+			if sig == syscall.SIGTERM {
+				_ = c.Process.Signal(sig)
+			}
+		}
+	}()
+	err := c.Wait()
+	signal.Stop(signals)
+	close(signals)
+
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) {
+		if code := exitErr.ExitCode(); code >= 0 {
+			return code
+		}
+		// Killed by a signal, reported as shells do
+		if status, ok := exitErr.Sys().(syscall.WaitStatus); ok && status.Signaled() {
+			return 128 + int(status.Signal())
+		}
+		return 1
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "hf %s: %v\n", command.Name, err)
+		return 1
+	}
+	return 0
+}
+
+// pluginEnv returns the environment a plugin gets on top of hf's: the
+// settings hf runs with, from its flags or environment, and where the API
+// is. The caller's HF_API_KEY or HF_API_TOKEN pass through unchanged.
+func pluginEnv(command plugin.Command, hfConfig *hfconfig.Config) []string {
+	env := []string{
+		"HF_PLUGIN=" + command.Name,
+		"HF_CONFIG_DIR=" + configDir,
+		"HF_STAGING_DIR=" + stagingDir,
+		"HF_SNAPSHOT_DIR=" + snapshotDir,
+		"HF_DB_PATH=" + dbPath,
+		"HF_SECRET_KEY=" + secretKeyFile,
+	}
+	if self, err := os.Executable(); err == nil {
+		env = append(env, "HF_BIN="+self)
+	}
+	for _, v := range []struct{ name, value string }{
+		{"HF_LOG_LEVEL", logLevel}, {"HF_LOG_FORMAT", logFormat}, {"HF_LOG_FILE", logFile},
+	} {
+		if v.value != "" {
+			env = append(env, v.name+"="+v.value)
+		}
+	}
+
+	url, socket := localAPI(hfConfig.API)
+	if url != "" {
+		env = append(env, "HF_API_URL="+url)
+	}
+	if socket != "" {
+		env = append(env, "HF_API_SOCKET="+socket)
+	}
+	return env
+}
+
+// localAPI returns the URL a local client reaches the API at, through its
+// first TCP listen address, and its first unix socket
+func localAPI(apiCfg hfconfig.APIConfig) (url, socket string) {
+	for _, addr := range apiCfg.ListenAddresses() {
+		network, address := hfconfig.ParseListenAddress(addr)
+		if network == "unix" {
+			if socket == "" {
+				socket = address
+			}
+			continue
+		}
+		if url != "" {
+			continue
+		}
+		host, port, err := net.SplitHostPort(address)
+		if err != nil {
+			continue
+		}
+		// Wildcard listeners are reached on loopback
+		if ip, err := netip.ParseAddr(host); host == "" || (err == nil && ip.IsUnspecified()) {
+			host = "127.0.0.1"
+			if err == nil && ip.Is6() {
+				host = "::1"
+			}
+		}
+		url = "http://" + net.JoinHostPort(host, port)
+	}
+	return url, socket
+}
@@ -0,0 +1,114 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	"golang.org/x/term"
+
+	"github.com/thesabbir/hellfire/pkg/transaction"
+)
+
+var spinnerFrames = []string{"⠋", "⠙", "⠹", "⠸", "⠼", "⠴", "⠦", "⠧", "⠇", "⠏"}
+
+// commitProgress renders transaction progress events on the terminal:
+// a spinner for the running step on a TTY, plain lines otherwise
+type commitProgress struct {
+	mu    sync.Mutex
+	label string
+	tty   bool
+	stop  chan struct{}
+	wg    sync.WaitGroup
+}
+
+// newCommitProgress creates a progress renderer and starts the spinner if stdout is a terminal
+func newCommitProgress() *commitProgress {
+	p := &commitProgress{
+		tty:  term.IsTerminal(int(os.Stdout.Fd())),
+		stop: make(chan struct{}),
+	}
+
+	if p.tty {
+		p.wg.Add(1)
+		go p.spin()
+	}
+
+	return p
+}
+
+// Handle renders a single progress event (use as a transaction.ProgressFunc)
+func (p *commitProgress) Handle(event transaction.ProgressEvent) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	label := progressLabel(event)
+
+	switch event.Status {
+	case transaction.ProgressStarted:
+		p.label = label
+		if !p.tty {
+			fmt.Printf("%s...\n", label)
+		}
+	case transaction.ProgressFinished, transaction.ProgressFailed:
+		p.label = ""
+		mark := "✓"
+		if event.Status == transaction.ProgressFailed {
+			mark = "✗"
+		}
+		if p.tty {
+			fmt.Print("\r\033[K")
+		}
+		fmt.Printf("%s %s\n", mark, label)
+	}
+}
+
+// Stop stops the spinner and clears its line
+func (p *commitProgress) Stop() {
+	close(p.stop)
+	p.wg.Wait()
+
+	if p.tty {
+		fmt.Print("\r\033[K")
+	}
+}
+
+// spin redraws the spinner for the running step until stopped
+func (p *commitProgress) spin() {
+	defer p.wg.Done()
+
+	ticker := time.NewTicker(100 * time.Millisecond)
+	defer ticker.Stop()
+
+	for frame := 0; ; frame++ {
+		select {
+		case <-p.stop:
+			return
+		case <-ticker.C:
+			p.mu.Lock()
+			if p.label != "" {
+				fmt.Printf("\r\033[K%s %s", spinnerFrames[frame%len(spinnerFrames)], p.label)
+			}
+			p.mu.Unlock()
+		}
+	}
+}
+
+// progressLabel describes a progress event's step for humans
+func progressLabel(event transaction.ProgressEvent) string {
+	switch event.Phase {
+	case transaction.PhaseSnapshot:
+		return "Creating snapshot"
+	case transaction.PhaseWrite:
+		return "Writing configuration"
+	case transaction.PhaseApply:
+		return fmt.Sprintf("Applying %s", event.Applier)
+	case transaction.PhaseValidate:
+		return fmt.Sprintf("Validating %s", event.Applier)
+	case transaction.PhaseRollback:
+		return "Rolling back"
+	default:
+		return event.Phase
+	}
+}
@@ -0,0 +1,294 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/signal"
+	"reflect"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/thesabbir/hellfire/pkg/audit"
+	"github.com/thesabbir/hellfire/pkg/bus"
+	"github.com/thesabbir/hellfire/pkg/hfconfig"
+	"github.com/thesabbir/hellfire/pkg/logger"
+	"github.com/thesabbir/hellfire/pkg/snapshot"
+	"github.com/thesabbir/hellfire/pkg/transaction"
+)
+
+// hellfireConfigName is the name of Hellfire's own config under the config directory
+const hellfireConfigName = "hellfire"
+
+// configPollInterval is how often the Hellfire config file is checked for changes
+const configPollInterval = 5 * time.Second
+
+// The --log-level, --log-format and --log-file flags; they override the
+// configured logging
+var (
+	logLevel  string
+	logFormat string
+	logFile   string
+)
+
+// configureLogging applies the logging settings from the Hellfire config
+func configureLogging(cfg hfconfig.LoggingConfig) error {
+	opts := cfg.Options()
+	if logLevel != "" {
+		opts.Level = logLevel
+	}
+	if logFormat != "" {
+		opts.Format = logFormat
+	}
+	if logFile != "" {
+		opts.File = logFile
+	}
+	return logger.Configure(opts)
+}
+
+// loadHellfireConfig returns the Hellfire config for CLI commands, or the
+// defaults (with the environment overrides) if the file doesn't exist or
+// is invalid
+func loadHellfireConfig() *hfconfig.Config {
+	if _, err := os.Stat(hfconfig.DefaultConfigPath); err == nil {
+		hfConfig, err := hfconfig.Load("")
+		if err == nil && hfConfig.Validate() == nil {
+			return hfConfig
+		}
+	}
+
+	hfConfig := hfconfig.DefaultConfig()
+	if err := hfconfig.ApplyEnv(hfConfig); err != nil {
+		logger.Warn("Ignoring invalid environment overrides", "error", err)
+	}
+	return hfConfig
+}
+
+// envOr returns an environment variable, or fallback when it isn't set; it
+// gives the defaults of flags that can be set from the environment
+func envOr(name, fallback string) string {
+	if value, ok := os.LookupEnv(name); ok {
+		return value
+	}
+	return fallback
+}
+
+// configReloader re-reads the Hellfire config when it changes on disk, when
+// the "hellfire" config is committed or on SIGHUP, and applies the settings
+// that can change without a restart
+type configReloader struct {
+	mu       sync.Mutex
+	path     string
+	current  *hfconfig.Config
+	modTime  time.Time
+	settings *apiSettings
+	txMgr    *transaction.Manager
+	snapshot *snapshot.Manager
+
+	// overrides re-applies command-line flags to every reloaded config
+	overrides func(*hfconfig.Config)
+}
+
+// newConfigReloader creates a reloader starting from the config the server was started with
+func newConfigReloader(current *hfconfig.Config, overrides func(*hfconfig.Config), settings *apiSettings, txMgr *transaction.Manager, snapshotMgr *snapshot.Manager) *configReloader {
+	r := &configReloader{
+		path:      hfconfig.DefaultConfigPath,
+		current:   current,
+		settings:  settings,
+		txMgr:     txMgr,
+		snapshot:  snapshotMgr,
+		overrides: overrides,
+	}
+
+	if info, err := os.Stat(r.path); err == nil {
+		r.modTime = info.ModTime()
+	}
+
+	return r
+}
+
+// start begins watching for SIGHUP, commits and file changes
+func (r *configReloader) start() {
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+
+	go func() {
+		for range hup {
+			r.reload("signal")
+		}
+	}()
+
+	bus.SubscribeWith(bus.EventConfigCommitted, func(bus.Event) {
+		r.reload("commit")
+	}, bus.SubscribeOptions{
+		Name:    "settings-reloader",
+		Configs: []string{hellfireConfigName},
+	})
+
+	go func() {
+		ticker := time.NewTicker(configPollInterval)
+		defer ticker.Stop()
+
+		for range ticker.C {
+			info, err := os.Stat(r.path)
+			if err != nil {
+				continue
+			}
+
+			r.mu.Lock()
+			changed := !info.ModTime().Equal(r.modTime)
+			r.mu.Unlock()
+
+			if changed {
+				r.reload("file")
+			}
+		}
+	}()
+}
+
+// reload re-reads the Hellfire config. An unreadable or invalid file is
+// logged and audited, and the current settings are kept.
+func (r *configReloader) reload(trigger string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if info, err := os.Stat(r.path); err == nil {
+		r.modTime = info.ModTime()
+	}
+
+	logger.Info("Reloading Hellfire configuration", "path", r.path, "trigger", trigger)
+
+	hfConfig, err := hfconfig.Reload(r.path)
+	if err == nil {
+		r.overrides(hfConfig)
+		err = hfConfig.Validate()
+	}
+	if err != nil {
+		logger.Error("Invalid Hellfire configuration, keeping current settings", "error", err)
+		audit.LogFailure(audit.ActionSystemReload, nil, "system", hellfireConfigName,
+			"Failed to reload Hellfire configuration", err)
+		return
+	}
+
+	// Always reconfigure logging so the log file is reopened after external rotation
+	if err := configureLogging(hfConfig.Logging); err != nil {
+		logger.Error("Failed to apply logging settings", "error", err)
+	}
+
+	changed := changedSections(r.current, hfConfig)
+	if len(changed) == 0 {
+		logger.Info("Hellfire configuration unchanged")
+		return
+	}
+
+	if err := r.settings.apply(hfConfig); err != nil {
+		logger.Error("Failed to apply API settings", "error", err)
+	}
+
+	r.txMgr.SetApplyOrder(hfConfig.Transaction.ApplyOrder)
+	r.txMgr.SetSkipApply(hfConfig.Transaction.SkipApply)
+	r.txMgr.SetRecovery(hfConfig.RecoveryNetwork(), hfConfig.RecoveryStateFile())
+	r.txMgr.SetProbes(hfConfig.ConfirmProbes())
+	r.txMgr.SetRequireRollbackReason(hfConfig.Transaction.RequireRollbackReason)
+	r.txMgr.SetSystemCapture(hfConfig.Transaction.CaptureSize * 1024)
+	setCanary(r.txMgr, hfConfig)
+	setManagementAccess(hfConfig)
+	r.snapshot.SetLimits(hfConfig.SnapshotLimits())
+
+	if restart := restartRequired(r.current, hfConfig); len(restart) > 0 {
+		logger.Warn("Some Hellfire settings only take effect after a restart", "settings", restart)
+	}
+
+	r.current = hfConfig
+
+	logger.Info("Hellfire configuration reloaded", "changed", changed)
+	audit.LogSuccess(audit.ActionSystemReload, nil, "system", hellfireConfigName,
+		fmt.Sprintf("Reloaded Hellfire configuration (%s): %s", trigger, strings.Join(changed, ", ")))
+
+	bus.Publish(bus.Event{
+		Type:       bus.EventSettingsReloaded,
+		ConfigName: hellfireConfigName,
+		Data:       changed,
+	})
+}
+
+// changedSections lists the config sections that differ between two configs
+func changedSections(from, to *hfconfig.Config) []string {
+	sections := []struct {
+		name     string
+		from, to interface{}
+	}{
+		{"api", from.API, to.API},
+		{"security", from.Security, to.Security},
+		{"audit", from.Audit, to.Audit},
+		{"ratelimit", from.RateLimit, to.RateLimit},
+		{"transaction", from.Transaction, to.Transaction},
+		{"grpc", from.GRPC, to.GRPC},
+		{"logging", from.Logging, to.Logging},
+		{"events", from.Events, to.Events},
+		{"recovery", from.Recovery, to.Recovery},
+		{"probe", from.Probe, to.Probe},
+		{"management", from.Management, to.Management},
+		{"spa", from.SPA, to.SPA},
+		{"wan", from.WAN, to.WAN},
+		{"certs", from.Certs, to.Certs},
+		{"snapshots", from.Snapshots, to.Snapshots},
+		{"syslog", from.Syslog, to.Syslog},
+		{"indicator", from.Indicator, to.Indicator},
+		{"headers", from.Headers, to.Headers},
+		{"acl", from.ACLs, to.ACLs},
+	}
+
+	var changed []string
+	for _, s := range sections {
+		if !reflect.DeepEqual(s.from, s.to) {
+			changed = append(changed, s.name)
+		}
+	}
+	return changed
+}
+
+// restartRequired lists changed settings that are only read at startup
+func restartRequired(from, to *hfconfig.Config) []string {
+	// Everything in the api section except CORS and the firewall access lists
+	// (which take effect on the next firewall apply) is fixed at startup
+	fromAPI, toAPI := from.API, to.API
+	fromAPI.EnableCORS, toAPI.EnableCORS = false, false
+	fromAPI.AllowedOrigins, toAPI.AllowedOrigins = nil, nil
+	fromAPI.CORSAllowSelf, toAPI.CORSAllowSelf = false, false
+	fromAPI.CORSMaxAge, toAPI.CORSMaxAge = 0, 0
+	fromAPI.AllowedZones, toAPI.AllowedZones = nil, nil
+	fromAPI.AllowedInterfaces, toAPI.AllowedInterfaces = nil, nil
+
+	var settings []string
+	if !reflect.DeepEqual(fromAPI, toAPI) {
+		settings = append(settings, "api")
+	}
+	if !reflect.DeepEqual(from.GRPC, to.GRPC) {
+		settings = append(settings, "grpc")
+	}
+	if !reflect.DeepEqual(from.Audit, to.Audit) {
+		settings = append(settings, "audit")
+	}
+	if !reflect.DeepEqual(from.Events, to.Events) {
+		settings = append(settings, "events")
+	}
+	if !reflect.DeepEqual(from.SPA, to.SPA) {
+		settings = append(settings, "spa")
+	}
+	if !reflect.DeepEqual(from.WAN, to.WAN) {
+		settings = append(settings, "wan")
+	}
+	if !reflect.DeepEqual(from.Certs, to.Certs) {
+		settings = append(settings, "certs")
+	}
+	if !reflect.DeepEqual(from.Syslog, to.Syslog) {
+		settings = append(settings, "syslog")
+	}
+	if !reflect.DeepEqual(from.Indicator, to.Indicator) {
+		settings = append(settings, "indicator")
+	}
+
+	return settings
+}
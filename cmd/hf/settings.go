@@ -0,0 +1,150 @@
+package main
+
+import (
+	"sync/atomic"
+
+	"github.com/thesabbir/hellfire/pkg/auth"
+	"github.com/thesabbir/hellfire/pkg/db"
+	"github.com/thesabbir/hellfire/pkg/hfconfig"
+	"github.com/thesabbir/hellfire/pkg/middleware"
+	"github.com/thesabbir/hellfire/pkg/tasks"
+)
+
+// apiSettings holds the API settings that can be changed while the server
+// runs; the router's middleware reads them on every request. Session
+// timeouts and the password policy are kept by the auth package and set
+// from here as well.
+type apiSettings struct {
+	globalLimiter *middleware.IPRateLimiter
+	authLimiter   *middleware.IPRateLimiter
+	rateLimits    *middleware.RateLimitPolicy
+	cors          atomic.Pointer[middleware.CORSPolicy]
+	headers       atomic.Pointer[middleware.SecurityHeaders]
+	configACLs    atomic.Pointer[auth.ConfigPolicy]
+
+	newDeviceAlert atomic.Bool // Publish auth.new_device on logins from unknown devices
+}
+
+// newAPISettings creates the runtime settings from the Hellfire config
+func newAPISettings(hfConfig *hfconfig.Config) (*apiSettings, error) {
+	s := &apiSettings{
+		globalLimiter: middleware.NewIPRateLimiter(
+			hfConfig.RateLimit.GlobalRequestsPerMinute,
+			hfConfig.RateLimit.GlobalBurst,
+		),
+		authLimiter: middleware.NewIPRateLimiter(
+			hfConfig.RateLimit.AuthRequestsPerMinute,
+			hfConfig.RateLimit.AuthBurst,
+		),
+		rateLimits: middleware.NewRateLimitPolicy(nil, nil),
+	}
+
+	if err := s.apply(hfConfig); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// apply updates the settings from a (validated) Hellfire config
+func (s *apiSettings) apply(hfConfig *hfconfig.Config) error {
+	// Localhost/management addresses that bypass rate limiting
+	exempt, err := middleware.ParseRateLimitExemptions(hfConfig.RateLimit.Exempt)
+	if err != nil {
+		return err
+	}
+
+	s.globalLimiter.SetLimit(hfConfig.RateLimit.GlobalRequestsPerMinute, hfConfig.RateLimit.GlobalBurst)
+	s.globalLimiter.SetExempt(exempt)
+	s.authLimiter.SetLimit(hfConfig.RateLimit.AuthRequestsPerMinute, hfConfig.RateLimit.AuthBurst)
+	s.authLimiter.SetExempt(exempt)
+
+	// Per-class limits (read, write, diagnostics) by client IP and by user
+	classes := make(map[string]middleware.RateLimitClass, len(hfConfig.RateLimit.Classes))
+	for name, class := range hfConfig.RateLimit.Classes {
+		classes[name] = middleware.RateLimitClass(class)
+	}
+	s.rateLimits.Update(classes, exempt)
+
+	// The router's own names and addresses are looked up again on each reload
+	var selfHosts []string
+	if hfConfig.API.CORSAllowSelf {
+		selfHosts = middleware.LocalHosts()
+	}
+	s.cors.Store(middleware.NewCORSPolicy(hfConfig.API.EnableCORS, hfConfig.API.AllowedOrigins,
+		selfHosts, hfConfig.API.ListenPorts(), hfConfig.API.CORSMaxAge))
+
+	s.headers.Store(securityHeaders(hfConfig.Headers))
+	s.configACLs.Store(configPolicy(hfConfig.ACLs))
+
+	s.newDeviceAlert.Store(hfConfig.Security.NewDeviceAlert)
+
+	// Idle and absolute session lifetimes
+	auth.SetSessionTimeouts(
+		seconds(hfConfig.Security.SessionTimeout),
+		seconds(hfConfig.Security.AbsoluteTimeout),
+	)
+
+	return applyPasswordPolicy(hfConfig.Security)
+}
+
+// securityHeaders returns the built-in security headers with the overrides
+// of the headers config
+func securityHeaders(cfg hfconfig.HeadersConfig) *middleware.SecurityHeaders {
+	headers := middleware.DefaultSecurityHeaders()
+	fields := map[string]*string{
+		"content_security_policy": &headers.ContentSecurityPolicy,
+		"frame_options":           &headers.FrameOptions,
+		"referrer_policy":         &headers.ReferrerPolicy,
+		"permissions_policy":      &headers.PermissionsPolicy,
+		"hsts":                    &headers.HSTS,
+	}
+	for name, value := range cfg.Overrides {
+		if field, ok := fields[name]; ok {
+			*field = value
+		}
+	}
+	return &headers
+}
+
+// configPolicy converts the acl sections into the API's access rules. Only
+// admins may write the tasks config whatever the acls say: its commands run
+// as root.
+func configPolicy(acls []hfconfig.ConfigACL) *auth.ConfigPolicy {
+	rules := make([]auth.ConfigRule, 0, len(acls)+1)
+	rules = append(rules, auth.ConfigRule{Config: tasks.ConfigName, Write: []db.Role{db.RoleAdmin}})
+	for _, acl := range acls {
+		rule := auth.ConfigRule{Config: acl.Config, Section: acl.Section}
+		for _, role := range acl.Read {
+			rule.Read = append(rule.Read, db.Role(role))
+		}
+		for _, role := range acl.Write {
+			rule.Write = append(rule.Write, db.Role(role))
+		}
+		rules = append(rules, rule)
+	}
+	return auth.NewConfigPolicy(rules)
+}
+
+// applyPasswordPolicy sets the password policy from the security config. If
+// the banned password file can't be read, the rest of the policy is still applied.
+func applyPasswordPolicy(sec hfconfig.SecurityConfig) error {
+	policy := auth.PasswordPolicy{
+		MinLength:      sec.MinPasswordLength,
+		RequireUpper:   sec.PasswordRequireUpper,
+		RequireLower:   sec.PasswordRequireLower,
+		RequireNumber:  sec.PasswordRequireNumber,
+		RequireSpecial: sec.PasswordRequireSpecial,
+		Banned:         sec.BannedPasswords,
+	}
+
+	var err error
+	if sec.BannedPasswordsFile != "" {
+		var banned []string
+		if banned, err = auth.ReadBannedPasswords(sec.BannedPasswordsFile); err == nil {
+			policy.Banned = append(append([]string{}, policy.Banned...), banned...)
+		}
+	}
+
+	auth.SetPasswordPolicy(policy)
+	return err
+}
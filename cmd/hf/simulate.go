@@ -0,0 +1,106 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/spf13/cobra"
+
+	"github.com/thesabbir/hellfire/pkg/appliers"
+	"github.com/thesabbir/hellfire/pkg/logger"
+	"github.com/thesabbir/hellfire/pkg/netdetect"
+	"github.com/thesabbir/hellfire/pkg/onboarding"
+	"github.com/thesabbir/hellfire/pkg/uci"
+)
+
+// simulate is whether commits apply to a simulated system instead of this
+// one: in builds with the simulate tag, with serve --demo, or when the
+// Hellfire config (or HF_SIMULATE) asks for it
+var simulate bool
+
+// demoInterfaces are the network devices of the demo server
+var demoInterfaces = []netdetect.Interface{
+	{Name: "eth0", Kind: netdetect.KindEthernet, Physical: true, MAC: "52:54:00:12:34:01", MTU: 1500, Up: true, Carrier: true, SpeedMbps: 1000, Driver: "virtio_net", Addresses: []string{"203.0.113.10/24"}, DefaultRoute: true},
+	{Name: "eth1", Kind: netdetect.KindEthernet, Physical: true, MAC: "52:54:00:12:34:02", MTU: 1500, Up: true, Carrier: true, SpeedMbps: 1000, Driver: "virtio_net", Addresses: []string{"192.168.1.1/24"}},
+	{Name: "lo", Kind: netdetect.KindLoopback, MTU: 65536, Up: true, Carrier: true, Addresses: []string{"127.0.0.1/8"}},
+}
+
+// registerSimulatedAppliers registers the appliers of a simulated system
+// with the network devices of this one (or the demo's)
+func registerSimulatedAppliers(registry *appliers.Registry) {
+	var devices []string
+	interfaces, err := netdetect.Detect()
+	if err != nil {
+		logger.Warn("Failed to detect network interfaces, simulating the demo ones", "error", err)
+		interfaces = demoInterfaces
+	}
+	for _, iface := range interfaces {
+		devices = append(devices, iface.Name)
+	}
+
+	for _, applier := range appliers.NewSimulatedSystem(devices...).Appliers() {
+		registry.Register(applier)
+	}
+	logger.Warn("Applying configuration to a simulated system; this system is not changed", "devices", devices)
+}
+
+// setupDemo prepares hf serve --demo: the simulated system gets the demo
+// network devices, and the configs, snapshots and database go to the demo
+// directory (a new temporary one unless --demo-dir is given) unless their
+// own flags are given. A new config directory is seeded with the setup the
+// onboarding wizard proposes for the demo devices.
+func setupDemo(cmd *cobra.Command) error {
+	flags := cmd.Flags()
+	dir, _ := flags.GetString("demo-dir")
+	if dir == "" {
+		tmp, err := os.MkdirTemp("", "hellfire-demo-")
+		if err != nil {
+			return fmt.Errorf("failed to create demo directory: %w", err)
+		}
+		dir = tmp
+	}
+
+	if !flags.Changed("config-dir") {
+		configDir = filepath.Join(dir, "config")
+	}
+	if !flags.Changed("snapshot-dir") {
+		snapshotDir = filepath.Join(dir, "snapshots")
+	}
+	if !flags.Changed("db") {
+		dbPath = filepath.Join(dir, "hellfire.db")
+	}
+	if !flags.Changed("secret-key") {
+		secretKeyFile = filepath.Join(dir, "secret.key")
+	}
+	if err := os.MkdirAll(configDir, 0755); err != nil {
+		return fmt.Errorf("failed to create demo config directory: %w", err)
+	}
+
+	netdetect.Simulate(demoInterfaces)
+
+	if _, err := os.Stat(filepath.Join(configDir, "network")); os.IsNotExist(err) {
+		setup := onboarding.Propose(demoInterfaces)
+		configs := make(map[string]*uci.Config)
+		for _, name := range setup.Apply(configs) {
+			if err := writeDemoConfig(filepath.Join(configDir, name), configs[name]); err != nil {
+				return fmt.Errorf("failed to seed demo config %s: %w", name, err)
+			}
+		}
+	}
+
+	logger.Info("Demo mode", "dir", dir, "config_dir", configDir)
+	return nil
+}
+
+func writeDemoConfig(path string, cfg *uci.Config) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	if err := uci.Write(f, cfg); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
@@ -0,0 +1,7 @@
+//go:build !simulate
+
+package main
+
+// simulateBuild is set in builds with the simulate tag, which apply every
+// commit to a simulated system
+const simulateBuild = false
@@ -0,0 +1,6 @@
+//go:build simulate
+
+package main
+
+// simulateBuild makes this build apply every commit to a simulated system
+const simulateBuild = true
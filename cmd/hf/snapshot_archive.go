@@ -0,0 +1,249 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"maps"
+	"os"
+	"slices"
+	"strings"
+
+	"github.com/spf13/cobra"
+	"golang.org/x/term"
+
+	"github.com/thesabbir/hellfire/pkg/integrity"
+	"github.com/thesabbir/hellfire/pkg/portability"
+	"github.com/thesabbir/hellfire/pkg/secrets"
+	"github.com/thesabbir/hellfire/pkg/snapshot"
+	"github.com/thesabbir/hellfire/pkg/uci"
+	"github.com/thesabbir/hellfire/pkg/util"
+)
+
+var snapshotExportCmd = &cobra.Command{
+	Use:   "export <id>",
+	Short: "Export a snapshot to clone it onto another router",
+	Long: `Export a snapshot as a gzipped tarball that 'hf snapshot import' reads on
+another router, e.g. replacement hardware.
+
+Secret options stay encrypted with this router's key, which the other
+router doesn't have, unless --reveal-secrets is given. The archive then
+holds them in plain text: keep it safe.`,
+	Args: cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		output, _ := cmd.Flags().GetString("output")
+		if output == "" {
+			output = "hellfire-" + args[0] + ".tar.gz"
+		}
+
+		var store *secrets.Store
+		if reveal, _ := cmd.Flags().GetBool("reveal-secrets"); reveal {
+			store = secrets.NewStore(secretKeyFile)
+		}
+
+		var buf bytes.Buffer
+		if err := snapshotMgr.Export(args[0], &buf, store); err != nil {
+			return err
+		}
+		if err := util.WriteFileAtomic(output, buf.Bytes(), 0600); err != nil {
+			return err
+		}
+		fmt.Printf("Snapshot %s exported to %s\n", args[0], output)
+		return nil
+	},
+}
+
+var snapshotImportCmd = &cobra.Command{
+	Use:   "import <file>",
+	Short: "Import a snapshot exported by another router",
+	Long: `Import a snapshot exported with 'hf snapshot export' as a new snapshot.
+
+Archives of a newer hellfire, or with options this version's schema
+rejects, are refused unless --force is given. Interfaces are named after
+their devices, so those the archive configures that don't exist here can
+be mapped onto others with --map old=new, or are asked for on a terminal;
+the firewall zones, rules, DHCP pools and routes on them follow.
+
+A portability report then lists what else keeps the snapshot from working
+here: missing interfaces, devices with other drivers, programs that aren't
+installed. Restoring it is refused while there are any but warnings,
+unless --force is given.
+
+The snapshot is only stored; restore it with 'hf snapshot restore --commit', or
+commit it right away with --restore.`,
+	Args: cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		force, _ := cmd.Flags().GetBool("force")
+		restore, _ := cmd.Flags().GetBool("restore")
+		mappings, _ := cmd.Flags().GetStringToString("map")
+		message, _ := cmd.Flags().GetString("message")
+		confirmTimeout, _ := cmd.Flags().GetInt("confirm-timeout")
+
+		f, err := os.Open(args[0])
+		if err != nil {
+			return err
+		}
+		archive, err := snapshot.ReadArchive(f)
+		f.Close()
+		if err != nil {
+			return err
+		}
+
+		meta := archive.Manifest.Snapshot
+		fmt.Printf("Snapshot %s of %s, exported by hellfire %s\n", meta.ID, archive.Manifest.Hostname, archive.Manifest.Version)
+		fmt.Printf("Created: %s\n", meta.Timestamp.Format("2006-01-02 15:04:05"))
+		fmt.Printf("Message: %s\n", meta.Message)
+		fmt.Printf("Configs: %v\n\n", meta.Configs)
+
+		store := secrets.NewStore(secretKeyFile)
+		warnings, err := archive.Check(store)
+		if err != nil {
+			if !force {
+				return fmt.Errorf("%w\nUse --force to import it anyway", err)
+			}
+			warnings = append(warnings, err.Error())
+		}
+		for _, warning := range warnings {
+			fmt.Printf("Warning: %s\n", warning)
+		}
+
+		if err := remapInterfaces(archive, mappings); err != nil {
+			return err
+		}
+		if restore {
+			if err := checkPortability(archive.Configs, archive.Manifest.Devices, force); err != nil {
+				return err
+			}
+		} else if _, err := portabilityReport(archive.Configs, archive.Manifest.Devices); err != nil {
+			return err
+		}
+
+		if restore && manager.HasChanges() {
+			return fmt.Errorf("there are uncommitted changes; commit or revert them first")
+		}
+		snap, err := snapshotMgr.Import(archive, "", store)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("Imported as snapshot %s\n", snap.ID)
+
+		if !restore {
+			fmt.Printf("Run 'hf snapshot restore --commit %s' to apply it\n", snap.ID)
+			return nil
+		}
+		configs, err := snapshotMgr.ReadConfigs(snap.ID)
+		if err != nil {
+			return err
+		}
+		for name, cfg := range configs {
+			if err := manager.Stage(name, cfg); err != nil {
+				_ = manager.Revert()
+				return fmt.Errorf("failed to stage %s: %w", name, err)
+			}
+		}
+		if message == "" {
+			message = fmt.Sprintf("Restore snapshot %s imported from %s", meta.ID, archive.Manifest.Hostname)
+		}
+		if err := commitStaged(message, confirmTimeout); err != nil {
+			_ = manager.Revert()
+			return err
+		}
+		return nil
+	},
+}
+
+// remapInterfaces renames the interfaces of an archive onto the devices of
+// this router: those given in mappings, then, on a terminal, those the
+// portability check finds missing, asking which device to use
+func remapInterfaces(archive *snapshot.Archive, mappings map[string]string) error {
+	network := archive.Configs["network"]
+	if network == nil {
+		if len(mappings) > 0 {
+			return fmt.Errorf("the snapshot has no network config to map interfaces in")
+		}
+		return nil
+	}
+
+	for _, from := range slices.Sorted(maps.Keys(mappings)) {
+		if err := integrity.RenameInterface(archive.Configs, from, mappings[from]); err != nil {
+			return err
+		}
+		fmt.Printf("Interface %s is mapped to %s\n", from, mappings[from])
+	}
+
+	if !term.IsTerminal(int(os.Stdin.Fd())) {
+		return nil
+	}
+	sys, err := portability.Local(transactionMgr.RegisteredAppliers())
+	if err != nil {
+		return err
+	}
+	reader := bufio.NewReader(os.Stdin)
+	for _, issue := range portability.Check(archive.Configs, archive.Manifest.Devices, sys) {
+		if issue.Kind != portability.KindInterface {
+			continue
+		}
+		for {
+			fmt.Printf("%s. Map it to (%s, empty keeps it): ", issue.Message, issue.Remedy)
+			line, err := reader.ReadString('\n')
+			to := strings.TrimSpace(line)
+			if to == "" {
+				if err != nil {
+					return nil // End of input: keep the rest
+				}
+				break
+			}
+			if err := integrity.RenameInterface(archive.Configs, issue.Subject, to); err != nil {
+				fmt.Printf("  %v\n", err)
+				continue
+			}
+			break
+		}
+	}
+	return nil
+}
+
+// portabilityReport prints what keeps configs made on another router from
+// working here. source lists the devices of that router, if known.
+func portabilityReport(configs map[string]*uci.Config, source []snapshot.Device) ([]portability.Issue, error) {
+	sys, err := portability.Local(transactionMgr.RegisteredAppliers())
+	if err != nil {
+		return nil, err
+	}
+	issues := portability.Check(configs, source, sys)
+	if len(issues) == 0 {
+		fmt.Println("Portability: no issues found")
+		return nil, nil
+	}
+	fmt.Printf("Portability issues (%d):\n", len(issues))
+	for _, issue := range issues {
+		fmt.Printf("  %s\n", issue)
+	}
+	return issues, nil
+}
+
+// checkPortability refuses to restore configs imported from another router
+// while they have blocking portability issues, unless forced
+func checkPortability(configs map[string]*uci.Config, source []snapshot.Device, force bool) error {
+	issues, err := portabilityReport(configs, source)
+	if err != nil {
+		return err
+	}
+	if len(portability.Blocking(issues)) > 0 && !force {
+		return fmt.Errorf("fix the issues above before restoring, or use --force")
+	}
+	return nil
+}
+
+func init() {
+	snapshotCmd.AddCommand(snapshotExportCmd)
+	snapshotCmd.AddCommand(snapshotImportCmd)
+
+	snapshotExportCmd.Flags().StringP("output", "o", "", "Archive to write (default hellfire-<id>.tar.gz)")
+	snapshotExportCmd.Flags().Bool("reveal-secrets", false, "Decrypt secret options so another router can use them")
+	snapshotImportCmd.Flags().StringToString("map", nil, "Map an interface of the snapshot onto a device of this router (old=new, repeatable)")
+	snapshotImportCmd.Flags().Bool("force", false, "Import a snapshot of a newer version or with options the schema rejects, and restore it despite portability issues")
+	snapshotImportCmd.Flags().Bool("restore", false, "Commit the imported snapshot right away")
+	snapshotImportCmd.Flags().StringP("message", "m", "", "Commit message (with --restore)")
+	snapshotImportCmd.Flags().IntP("confirm-timeout", "t", 0, "Confirmation timeout in seconds with --restore (0 = no confirmation required)")
+}
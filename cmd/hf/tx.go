@@ -0,0 +1,255 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/spf13/cobra"
+
+	"github.com/thesabbir/hellfire/pkg/db"
+	"github.com/thesabbir/hellfire/pkg/transaction"
+)
+
+var txCmd = &cobra.Command{
+	Use:   "tx",
+	Short: "View and retry transactions",
+	Long:  "View committed transactions and what they pushed to the system, and retry failed ones",
+}
+
+var txListCmd = &cobra.Command{
+	Use:   "list",
+	Short: "List transactions",
+	RunE:  runTxList,
+}
+
+var txShowCmd = &cobra.Command{
+	Use:   "show <txid>",
+	Short: "Show a transaction",
+	Long: `Show a transaction. With --artifacts, also print the exact artifacts
+(nftables ruleset, dnsmasq config, interface commands) it pushed to the
+system, including those re-applied to roll it back. With --system-state,
+print the state of the system (addresses, routes, ruleset, services) dumped
+before and after its configs were applied.`,
+	Args: cobra.ExactArgs(1),
+	RunE: runTxShow,
+}
+
+var txRetryCmd = &cobra.Command{
+	Use:   "retry <txid>",
+	Short: "Retry a failed transaction",
+	Long: `Stage the changes of a failed or rolled back transaction again and commit
+them as a new transaction, e.g. after a service that was briefly down is
+back. The configs it changed must not have changed since, unless --force
+is given, and nothing else may be staged.`,
+	Args: cobra.ExactArgs(1),
+	RunE: runTxRetry,
+}
+
+func init() {
+	txListCmd.Flags().String("status", "", "Filter by status (completed/failed/rolledback/pending)")
+	txListCmd.Flags().Int("limit", 50, "Maximum number of transactions to show")
+	txListCmd.Flags().Int("offset", 0, "Offset for pagination")
+
+	txShowCmd.Flags().Bool("artifacts", false, "Print the artifacts pushed to the system")
+	txShowCmd.Flags().String("config", "", "Only print the artifacts of this config")
+	txShowCmd.Flags().Bool("system-state", false, "Print the system state dumped before and after the apply")
+
+	txRetryCmd.Flags().Bool("force", false, "Retry even if the configs changed since the transaction")
+	txRetryCmd.Flags().StringP("message", "m", "", "Commit message (default: that of the transaction)")
+	txRetryCmd.Flags().IntP("confirm-timeout", "t", 0, "Confirm timeout in seconds (0 = no confirmation required)")
+
+	txCmd.AddCommand(
+		txListCmd,
+		txShowCmd,
+		txRetryCmd,
+	)
+}
+
+func runTxList(cmd *cobra.Command, args []string) error {
+	filters := make(map[string]interface{})
+	if status, _ := cmd.Flags().GetString("status"); status != "" {
+		filters["status"] = status
+	}
+
+	limit, _ := cmd.Flags().GetInt("limit")
+	offset, _ := cmd.Flags().GetInt("offset")
+
+	txs, total, err := db.ListTransactions(filters, limit, offset)
+	if err != nil {
+		return fmt.Errorf("failed to list transactions: %w", err)
+	}
+
+	if len(txs) == 0 {
+		fmt.Println("No transactions found")
+		return nil
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "TXID\tTIME\tUSER\tSTATUS\tCONFIGS\tMESSAGE\tROLLBACK REASON")
+	fmt.Fprintln(w, "----\t----\t----\t------\t-------\t-------\t---------------")
+
+	for _, tx := range txs {
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\t%s\n",
+			tx.TxID,
+			tx.CreatedAt.Format("2006-01-02 15:04:05"),
+			tx.Username,
+			tx.Status,
+			strings.Join(txConfigs(&tx), ","),
+			truncate(tx.Message, 40),
+			truncate(tx.RollbackReason, 40),
+		)
+	}
+
+	w.Flush()
+
+	fmt.Printf("\nShowing %d-%d of %d total transactions\n", offset+1, offset+len(txs), total)
+
+	if offset+len(txs) < int(total) {
+		fmt.Printf("Use --offset=%d to see more\n", offset+len(txs))
+	}
+
+	return nil
+}
+
+func runTxRetry(cmd *cobra.Command, args []string) error {
+	force, _ := cmd.Flags().GetBool("force")
+	tx, err := transactionMgr.StageRetry(args[0], force)
+	if err != nil {
+		return err
+	}
+
+	message, _ := cmd.Flags().GetString("message")
+	if message == "" {
+		message = tx.Message
+	}
+	confirmTimeout, _ := cmd.Flags().GetInt("confirm-timeout")
+	return commitStaged(fmt.Sprintf("Retry of %s: %s", tx.TxID, message), confirmTimeout)
+}
+
+func runTxShow(cmd *cobra.Command, args []string) error {
+	tx, err := db.GetTransactionByID(args[0])
+	if err != nil {
+		return fmt.Errorf("transaction not found: %w", err)
+	}
+
+	fmt.Printf("Transaction %s\n", tx.TxID)
+	fmt.Printf("━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━\n\n")
+
+	fmt.Printf("Started:    %s\n", tx.CreatedAt.Format(time.RFC3339))
+	fmt.Printf("User:       %s\n", tx.Username)
+	fmt.Printf("Status:     %s\n", tx.Status)
+	fmt.Printf("Message:    %s\n", tx.Message)
+	if configs := txConfigs(tx); len(configs) > 0 {
+		fmt.Printf("Configs:    %s\n", strings.Join(configs, ", "))
+	}
+	if tx.SnapshotID != "" {
+		fmt.Printf("Snapshot:   %s\n", tx.SnapshotID)
+	}
+	if tx.ConfirmedAt != nil {
+		fmt.Printf("Confirmed:  %s\n", tx.ConfirmedAt.Format(time.RFC3339))
+	}
+	if tx.CompletedAt != nil {
+		fmt.Printf("Completed:  %s\n", tx.CompletedAt.Format(time.RFC3339))
+	}
+	if tx.RolledBackAt != nil {
+		fmt.Printf("Rolled back: %s\n", tx.RolledBackAt.Format(time.RFC3339))
+	}
+	if tx.RollbackReason != "" {
+		fmt.Printf("Rollback reason: %s\n", tx.RollbackReason)
+	}
+	if tx.FailedApplier != "" {
+		fmt.Printf("Failed applier: %s\n", tx.FailedApplier)
+	}
+	if tx.Error != "" {
+		fmt.Printf("\nError:\n%s\n", tx.Error)
+	}
+
+	if err := printTxCaptures(cmd, tx.TxID); err != nil {
+		return err
+	}
+
+	artifacts, err := db.ListTransactionArtifacts(tx.TxID)
+	if err != nil {
+		return fmt.Errorf("failed to list artifacts: %w", err)
+	}
+
+	if show, _ := cmd.Flags().GetBool("artifacts"); !show {
+		if len(artifacts) > 0 {
+			fmt.Printf("\n%d artifact(s) archived, use --artifacts to print them\n", len(artifacts))
+		}
+		return nil
+	}
+
+	only, _ := cmd.Flags().GetString("config")
+	printed := 0
+	for _, artifact := range artifacts {
+		if only != "" && artifact.Config != only {
+			continue
+		}
+		fmt.Printf("\n=== %s: %s (%s, sha256 %s)\n", artifact.Config, artifact.Name, artifact.Stage, artifact.SHA256)
+		fmt.Print(artifact.Content)
+		if !strings.HasSuffix(artifact.Content, "\n") {
+			fmt.Println()
+		}
+		printed++
+	}
+	if printed == 0 {
+		fmt.Println("\nNo artifacts archived")
+	}
+
+	return nil
+}
+
+// printTxCaptures prints the system state dumps of a transaction with
+// --system-state, or says how many there are
+func printTxCaptures(cmd *cobra.Command, txID string) error {
+	captures, err := db.ListTransactionCaptures(txID)
+	if err != nil {
+		return fmt.Errorf("failed to list system state dumps: %w", err)
+	}
+
+	if show, _ := cmd.Flags().GetBool("system-state"); !show {
+		if len(captures) > 0 {
+			fmt.Printf("\n%d system state dump(s) taken, use --system-state to print them\n", len(captures))
+		}
+		return nil
+	}
+
+	if len(captures) == 0 {
+		fmt.Println("\nNo system state dumped")
+	}
+	for _, capture := range captures {
+		text, err := transaction.CaptureText(&capture)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("\n##### System state %s the apply (%s, %d bytes)\n", capture.Stage, capture.CreatedAt.Format(time.RFC3339), capture.Size)
+		fmt.Print(text)
+		if !strings.HasSuffix(text, "\n") {
+			fmt.Println()
+		}
+		if capture.Truncated {
+			fmt.Printf("... truncated at %d bytes\n", len(text))
+		}
+	}
+	return nil
+}
+
+// txConfigs decodes the changed configs of a transaction record
+func txConfigs(tx *db.Transaction) []string {
+	var configs []string
+	_ = json.Unmarshal([]byte(tx.Configs), &configs)
+	return configs
+}
+
+// truncate shortens text for a table column
+func truncate(text string, max int) string {
+	if len(text) > max {
+		return text[:max-3] + "..."
+	}
+	return text
+}
@@ -0,0 +1,246 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+	"time"
+
+	"github.com/spf13/cobra"
+	"github.com/thesabbir/hellfire/pkg/audit"
+	"github.com/thesabbir/hellfire/pkg/hfconfig"
+	"github.com/thesabbir/hellfire/pkg/logger"
+	"github.com/thesabbir/hellfire/pkg/transaction"
+	"github.com/thesabbir/hellfire/pkg/upgrade"
+)
+
+// postUpgradeCheckTimeout bounds the checks run by a restarted service
+const postUpgradeCheckTimeout = time.Minute
+
+var upgradeCmd = &cobra.Command{
+	Use:   "upgrade [package...]",
+	Short: "Upgrade hellfire and the system",
+	Long: `Snapshot the configs and back up the database, then upgrade with apt-get (the
+given packages, or the whole system) or install a signed image with --image.
+The service is restarted afterwards and checks that the system is healthy;
+if it isn't, 'hf upgrade rollback' restores the configs from before the upgrade.`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		opts := upgrade.Options{Method: upgrade.MethodApt, Packages: args}
+		opts.ID, _ = cmd.Flags().GetString("id")
+		opts.Message, _ = cmd.Flags().GetString("message")
+		if image, _ := cmd.Flags().GetString("image"); image != "" {
+			if len(args) > 0 {
+				return fmt.Errorf("packages can't be given with --image")
+			}
+			opts.Method = upgrade.MethodImage
+			opts.Image = image
+			opts.SignatureFile, _ = cmd.Flags().GetString("signature")
+			opts.PublicKeyFile, _ = cmd.Flags().GetString("public-key")
+		}
+
+		upgrader := newUpgrader()
+		state, err := upgrader.Run(context.Background(), opts, os.Stdout)
+		if err != nil {
+			if state != nil {
+				printUpgradeRollbackHint(state)
+			}
+			return err
+		}
+
+		if noRestart, _ := cmd.Flags().GetBool("no-restart"); noRestart {
+			fmt.Printf("Restart %s to complete the upgrade\n", upgrade.DefaultService)
+			return nil
+		}
+
+		fmt.Printf("==> Restarting %s\n", upgrade.DefaultService)
+		if err := upgrade.Restart(upgrade.DefaultService); err != nil {
+			return err
+		}
+
+		wait, _ := cmd.Flags().GetDuration("wait")
+		deadline := time.Now().Add(wait)
+		for state.Status == upgrade.StatusInstalled && time.Now().Before(deadline) {
+			time.Sleep(time.Second)
+			if current, err := upgrader.State(); err == nil && current.ID == state.ID {
+				state = current
+			}
+		}
+
+		switch state.Status {
+		case upgrade.StatusSucceeded:
+			fmt.Printf("Upgrade succeeded: %s -> %s\n", state.FromVersion, state.ToVersion)
+			return nil
+		case upgrade.StatusFailed:
+			for _, problem := range state.Problems {
+				fmt.Printf("  ✗ %s\n", problem)
+			}
+			printUpgradeRollbackHint(state)
+			return fmt.Errorf("post-upgrade checks failed")
+		default:
+			return fmt.Errorf("%s didn't verify the upgrade within %s; check 'hf upgrade status'", upgrade.DefaultService, wait)
+		}
+	},
+}
+
+var upgradeStatusCmd = &cobra.Command{
+	Use:   "status",
+	Short: "Show the last upgrade",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		state, err := newUpgrader().State()
+		if err != nil {
+			return err
+		}
+
+		fmt.Printf("Upgrade:  %s (%s)\n", state.ID, state.Method)
+		fmt.Printf("Status:   %s\n", state.Status)
+		fmt.Printf("Started:  %s\n", state.StartedAt.Format("2006-01-02 15:04:05"))
+		if state.FinishedAt != nil {
+			fmt.Printf("Finished: %s\n", state.FinishedAt.Format("2006-01-02 15:04:05"))
+		}
+		fmt.Printf("Version:  %s -> %s\n", state.FromVersion, state.ToVersion)
+		fmt.Printf("Snapshot: %s\n", state.SnapshotID)
+		if state.DBBackup != "" {
+			fmt.Printf("Database: %s\n", state.DBBackup)
+		}
+		fmt.Printf("Log:      %s\n", state.LogFile)
+		if state.Error != "" {
+			fmt.Printf("Error:    %s\n", state.Error)
+		}
+		for _, problem := range state.Problems {
+			fmt.Printf("  ✗ %s\n", problem)
+		}
+		return nil
+	},
+}
+
+var upgradeRollbackCmd = &cobra.Command{
+	Use:   "rollback",
+	Short: "Restore the configs from before the last upgrade",
+	Long: `Roll back to the config snapshot taken before the last upgrade and re-apply it.
+Installed packages are not downgraded and the database is not restored; its
+backup is listed by 'hf upgrade status'.`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		ctx := audit.WithIP(context.Background(), sshClientAddress())
+		state, err := newUpgrader().Rollback(func(snapshotID string) error {
+			return transactionMgr.Rollback(transaction.WithRollbackReason(ctx, "Restoring the configs from before the last upgrade"), snapshotID)
+		})
+		if err != nil {
+			return err
+		}
+
+		fmt.Printf("Restored configs from snapshot %s (before upgrade %s)\n", state.SnapshotID, state.ID)
+		return nil
+	},
+}
+
+func init() {
+	upgradeCmd.AddCommand(upgradeStatusCmd)
+	upgradeCmd.AddCommand(upgradeRollbackCmd)
+
+	upgradeCmd.Flags().String("image", "", "Install this signed .deb instead of upgrading with apt-get")
+	upgradeCmd.Flags().String("signature", "", "Detached Ed25519 signature of the image (default <image>.sig)")
+	upgradeCmd.Flags().String("public-key", upgrade.DefaultPublicKeyFile, "Public key images are signed with")
+	upgradeCmd.Flags().StringP("message", "m", "", "Note recorded with the upgrade")
+	upgradeCmd.Flags().Bool("no-restart", false, "Don't restart the service after installing")
+	upgradeCmd.Flags().Duration("wait", 2*time.Minute, "How long to wait for the restarted service to verify the upgrade")
+	upgradeCmd.Flags().String("id", "", "Upgrade ID (set by the API)")
+	_ = upgradeCmd.Flags().MarkHidden("id")
+}
+
+func newUpgrader() *upgrade.Upgrader {
+	return upgrade.NewUpgrader(snapshotMgr, configDir, upgrade.DefaultStateFile, upgrade.DefaultBackupDir)
+}
+
+func printUpgradeRollbackHint(state *upgrade.State) {
+	fmt.Printf("Run 'hf upgrade rollback' to restore the configs from snapshot %s\n", state.SnapshotID)
+	if state.DBBackup != "" {
+		fmt.Printf("The database was backed up to %s\n", state.DBBackup)
+	}
+}
+
+// startUpgrade runs 'hf upgrade' in a transient systemd unit, so the
+// installer survives the restart of the service that started it
+func startUpgrade(opts upgrade.Options) error {
+	exe, err := os.Executable()
+	if err != nil {
+		return err
+	}
+
+	args := []string{
+		"--unit", "hellfire-upgrade-" + opts.ID, "--collect", "--quiet", "--",
+		exe, "--config-dir", configDir, "--staging-dir", stagingDir,
+		"--snapshot-dir", snapshotDir, "--db", dbPath, "--secret-key", secretKeyFile,
+		"upgrade", "--id", opts.ID,
+	}
+	if opts.Message != "" {
+		args = append(args, "--message", opts.Message)
+	}
+	if opts.Method == upgrade.MethodImage {
+		args = append(args, "--image", opts.Image,
+			"--signature", opts.SignatureFile,
+			"--public-key", opts.PublicKeyFile)
+	} else {
+		args = append(append(args, "--"), opts.Packages...)
+	}
+
+	output, err := exec.Command("systemd-run", args...).CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("failed to start upgrade: %w: %s", err, strings.TrimSpace(string(output)))
+	}
+	return nil
+}
+
+// verifyUpgrade completes an upgrade waiting for this service to restart
+func verifyUpgrade(txMgr *transaction.Manager) {
+	state, err := newUpgrader().Verify(func() []string {
+		ctx, cancel := context.WithTimeout(context.Background(), postUpgradeCheckTimeout)
+		defer cancel()
+		return postUpgradeChecks(ctx, txMgr)
+	})
+	if err != nil && state == nil {
+		logger.Warn("Failed to verify upgrade", "error", err)
+	}
+}
+
+// postUpgradeChecks lists what is wrong after an upgrade: configs the new
+// version can't read, appliers whose state doesn't validate, safe mode
+func postUpgradeChecks(ctx context.Context, txMgr *transaction.Manager) []string {
+	var problems []string
+
+	hfConfig, err := hfconfig.Reload("")
+	if err == nil {
+		err = hfConfig.Validate()
+	}
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		problems = append(problems, fmt.Sprintf("hellfire config: %v", err))
+	}
+
+	entries, err := os.ReadDir(configDir)
+	if err != nil {
+		problems = append(problems, fmt.Sprintf("config directory: %v", err))
+	}
+	for _, entry := range entries {
+		name := entry.Name()
+		if !entry.Type().IsRegular() || strings.HasPrefix(name, ".") {
+			continue
+		}
+		if _, err := manager.Load(name); err != nil {
+			problems = append(problems, fmt.Sprintf("config %s: %v", name, err))
+			continue
+		}
+
+		if applier, ok := applierRegistry.Get(name); ok {
+			if err := applier.Validate(ctx); err != nil {
+				problems = append(problems, fmt.Sprintf("%s: %v", name, err))
+			}
+		}
+	}
+
+	if degraded := txMgr.Degraded(); degraded != nil {
+		problems = append(problems, "system is in safe mode: "+degraded.Reason)
+	}
+	return problems
+}
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io/fs"
+	"net/http"
+	"os"
+	"path"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	apierrors "github.com/thesabbir/hellfire/pkg/errors"
+	"github.com/thesabbir/hellfire/pkg/logger"
+	"github.com/thesabbir/hellfire/pkg/middleware"
+	"github.com/thesabbir/hellfire/web"
+)
+
+// webUIHandler serves the single-page web UI. Existing files are served
+// as-is and every other path falls back to index.html so client-side routes
+// work on reload. An empty root serves the build embedded in the binary.
+func webUIHandler(root string) (gin.HandlerFunc, error) {
+	fsys := web.Dist()
+	if root != "" {
+		info, err := os.Stat(root)
+		if err != nil {
+			return nil, fmt.Errorf("invalid web root: %w", err)
+		}
+		if !info.IsDir() {
+			return nil, fmt.Errorf("invalid web root: %s is not a directory", root)
+		}
+		fsys = os.DirFS(root)
+	}
+
+	if _, err := fs.Stat(fsys, "index.html"); err != nil {
+		logger.Warn("Web UI build not found, serving the API only", "web_root", root)
+	}
+
+	fileServer := http.FileServer(http.FS(fsys))
+
+	return func(c *gin.Context) {
+		// Unknown API routes get a JSON error rather than the UI
+		if strings.HasPrefix(c.Request.URL.Path, "/api/") ||
+			(c.Request.Method != http.MethodGet && c.Request.Method != http.MethodHead) {
+			apierrors.NotFound(c, nil)
+			return
+		}
+
+		name := strings.TrimPrefix(path.Clean(c.Request.URL.Path), "/")
+		if name != "" && name != "index.html" {
+			if info, err := fs.Stat(fsys, name); err == nil && !info.IsDir() {
+				fileServer.ServeHTTP(c.Writer, c.Request)
+				return
+			}
+		}
+
+		index, err := fs.ReadFile(fsys, "index.html")
+		if err != nil {
+			c.String(http.StatusNotFound, "web UI is not built (run 'just web-build' and rebuild hf, or set api.web_root)")
+			return
+		}
+
+		// Inline scripts and styles carry this request's CSP nonce, so the
+		// page can't be cached
+		index = bytes.ReplaceAll(index, []byte(middleware.CSPNoncePlaceholder), []byte(middleware.CSPNonce(c)))
+		c.Header("Cache-Control", "no-store")
+		c.Data(http.StatusOK, "text/html; charset=utf-8", index)
+	}, nil
+}
@@ -46,39 +46,33 @@ const docTemplate = `{
                 }
             }
         },
-        "/auth/login": {
-            "post": {
-                "description": "Authenticate user and get session token",
-                "consumes": [
-                    "application/json"
+        "/auth/devices": {
+            "get": {
+                "security": [
+                    {
+                        "BearerAuth": []
+                    }
                 ],
+                "description": "List the devices (IP address and User-Agent) the current user has logged in from, most recently seen first",
                 "produces": [
                     "application/json"
                 ],
                 "tags": [
                     "auth"
                 ],
-                "summary": "User login",
-                "parameters": [
-                    {
-                        "description": "Login credentials",
-                        "name": "credentials",
-                        "in": "body",
-                        "required": true,
-                        "schema": {
-                            "$ref": "#/definitions/main.loginRequest"
-                        }
-                    }
-                ],
+                "summary": "List known devices",
                 "responses": {
                     "200": {
                         "description": "OK",
                         "schema": {
-                            "$ref": "#/definitions/main.loginResponse"
+                            "type": "array",
+                            "items": {
+                                "$ref": "#/definitions/main.KnownDeviceResponse"
+                            }
                         }
                     },
-                    "400": {
-                        "description": "Bad Request",
+                    "401": {
+                        "description": "Unauthorized",
                         "schema": {
                             "type": "object",
                             "additionalProperties": {
@@ -86,8 +80,8 @@ const docTemplate = `{
                             }
                         }
                     },
-                    "401": {
-                        "description": "Unauthorized",
+                    "500": {
+                        "description": "Internal Server Error",
                         "schema": {
                             "type": "object",
                             "additionalProperties": {
@@ -98,21 +92,30 @@ const docTemplate = `{
                 }
             }
         },
-        "/auth/logout": {
-            "post": {
+        "/auth/devices/{id}": {
+            "delete": {
                 "security": [
                     {
                         "BearerAuth": []
                     }
                 ],
-                "description": "Invalidate current session",
+                "description": "Forget a device of the current user and log out its sessions; the next login from it counts as a new device",
                 "produces": [
                     "application/json"
                 ],
                 "tags": [
                     "auth"
                 ],
-                "summary": "User logout",
+                "summary": "Revoke a known device",
+                "parameters": [
+                    {
+                        "type": "integer",
+                        "description": "Device ID",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    }
+                ],
                 "responses": {
                     "200": {
                         "description": "OK",
@@ -123,6 +126,15 @@ const docTemplate = `{
                             }
                         }
                     },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": {
+                                "type": "string"
+                            }
+                        }
+                    },
                     "401": {
                         "description": "Unauthorized",
                         "schema": {
@@ -131,30 +143,62 @@ const docTemplate = `{
                                 "type": "string"
                             }
                         }
+                    },
+                    "404": {
+                        "description": "Not Found",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": {
+                                "type": "string"
+                            }
+                        }
                     }
                 }
             }
         },
-        "/auth/me": {
-            "get": {
+        "/auth/impersonate": {
+            "post": {
                 "security": [
                     {
                         "BearerAuth": []
                     }
                 ],
-                "description": "Get information about the current authenticated user",
+                "description": "Open a short-lived session acting as another user, for troubleshooting what they see and can do (admin only). The session has the user's role, doesn't renew and can't impersonate further; audit entries made with it record both the user and the admin.",
+                "consumes": [
+                    "application/json"
+                ],
                 "produces": [
                     "application/json"
                 ],
                 "tags": [
                     "auth"
                 ],
-                "summary": "Get current user",
+                "summary": "Impersonate a user",
+                "parameters": [
+                    {
+                        "description": "User to impersonate",
+                        "name": "request",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "$ref": "#/definitions/main.impersonateRequest"
+                        }
+                    }
+                ],
                 "responses": {
                     "200": {
                         "description": "OK",
                         "schema": {
-                            "$ref": "#/definitions/db.User"
+                            "$ref": "#/definitions/main.ImpersonateResponse"
+                        }
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": {
+                                "type": "string"
+                            }
                         }
                     },
                     "401": {
@@ -165,72 +209,107 @@ const docTemplate = `{
                                 "type": "string"
                             }
                         }
+                    },
+                    "403": {
+                        "description": "Forbidden",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": {
+                                "type": "string"
+                            }
+                        }
+                    },
+                    "404": {
+                        "description": "Not Found",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": {
+                                "type": "string"
+                            }
+                        }
                     }
                 }
             }
         },
-        "/bootstrap": {
-            "get": {
-                "description": "Get system metadata including initialization status",
+        "/auth/login": {
+            "post": {
+                "description": "Authenticate user and get session token",
+                "consumes": [
+                    "application/json"
+                ],
                 "produces": [
                     "application/json"
                 ],
                 "tags": [
-                    "system"
+                    "auth"
                 ],
-                "summary": "System bootstrap metadata",
-                "responses": {
-                    "200": {
-                        "description": "OK",
+                "summary": "User login",
+                "parameters": [
+                    {
+                        "description": "Login credentials",
+                        "name": "credentials",
+                        "in": "body",
+                        "required": true,
                         "schema": {
-                            "type": "object",
-                            "additionalProperties": true
+                            "$ref": "#/definitions/main.loginRequest"
                         }
                     }
-                }
-            }
-        },
-        "/changes": {
-            "get": {
-                "description": "Get list of staged configuration changes",
-                "produces": [
-                    "application/json"
-                ],
-                "tags": [
-                    "config"
                 ],
-                "summary": "Get staged changes",
                 "responses": {
                     "200": {
                         "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/main.loginResponse"
+                        }
+                    },
+                    "400": {
+                        "description": "Bad Request",
                         "schema": {
                             "type": "object",
-                            "additionalProperties": true
+                            "additionalProperties": {
+                                "type": "string"
+                            }
+                        }
+                    },
+                    "401": {
+                        "description": "Unauthorized",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": {
+                                "type": "string"
+                            }
                         }
                     }
                 }
             }
         },
-        "/commit": {
+        "/auth/logout": {
             "post": {
-                "description": "Commit staged configuration changes to the system",
+                "security": [
+                    {
+                        "BearerAuth": []
+                    }
+                ],
+                "description": "Invalidate current session",
                 "produces": [
                     "application/json"
                 ],
                 "tags": [
-                    "config"
+                    "auth"
                 ],
-                "summary": "Commit changes",
+                "summary": "User logout",
                 "responses": {
                     "200": {
                         "description": "OK",
                         "schema": {
                             "type": "object",
-                            "additionalProperties": true
+                            "additionalProperties": {
+                                "type": "string"
+                            }
                         }
                     },
-                    "500": {
-                        "description": "Internal Server Error",
+                    "401": {
+                        "description": "Unauthorized",
                         "schema": {
                             "type": "object",
                             "additionalProperties": {
@@ -241,25 +320,21 @@ const docTemplate = `{
                 }
             }
         },
-        "/config/{name}": {
+        "/auth/me": {
             "get": {
-                "description": "Get entire configuration file",
+                "security": [
+                    {
+                        "BearerAuth": []
+                    }
+                ],
+                "description": "Get the current authenticated user, the permissions of their role and, for session logins, when the session expires and which admin is impersonating the user, if any",
                 "produces": [
                     "application/json"
                 ],
                 "tags": [
-                    "config"
-                ],
-                "summary": "Get configuration",
-                "parameters": [
-                    {
-                        "type": "string",
-                        "description": "Configuration name (e.g., network, firewall)",
-                        "name": "name",
-                        "in": "path",
-                        "required": true
-                    }
+                    "auth"
                 ],
+                "summary": "Get current user",
                 "responses": {
                     "200": {
                         "description": "OK",
@@ -268,8 +343,8 @@ const docTemplate = `{
                             "additionalProperties": true
                         }
                     },
-                    "500": {
-                        "description": "Internal Server Error",
+                    "401": {
+                        "description": "Unauthorized",
                         "schema": {
                             "type": "object",
                             "additionalProperties": {
@@ -278,44 +353,53 @@ const docTemplate = `{
                         }
                     }
                 }
-            }
-        },
-        "/config/{name}/{section}": {
-            "get": {
-                "description": "Get a specific section from configuration",
+            },
+            "put": {
+                "security": [
+                    {
+                        "BearerAuth": []
+                    }
+                ],
+                "description": "Update the current user's profile (email address)",
+                "consumes": [
+                    "application/json"
+                ],
                 "produces": [
                     "application/json"
                 ],
                 "tags": [
-                    "config"
+                    "auth"
                 ],
-                "summary": "Get configuration section",
+                "summary": "Update own profile",
                 "parameters": [
                     {
-                        "type": "string",
-                        "description": "Configuration name",
-                        "name": "name",
-                        "in": "path",
-                        "required": true
-                    },
-                    {
-                        "type": "string",
-                        "description": "Section name or type",
-                        "name": "section",
-                        "in": "path",
-                        "required": true
+                        "description": "Profile fields",
+                        "name": "request",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "$ref": "#/definitions/main.updateProfileRequest"
+                        }
                     }
                 ],
                 "responses": {
                     "200": {
                         "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/db.User"
+                        }
+                    },
+                    "400": {
+                        "description": "Bad Request",
                         "schema": {
                             "type": "object",
-                            "additionalProperties": true
+                            "additionalProperties": {
+                                "type": "string"
+                            }
                         }
                     },
-                    "404": {
-                        "description": "Not Found",
+                    "401": {
+                        "description": "Unauthorized",
                         "schema": {
                             "type": "object",
                             "additionalProperties": {
@@ -323,8 +407,8 @@ const docTemplate = `{
                             }
                         }
                     },
-                    "500": {
-                        "description": "Internal Server Error",
+                    "403": {
+                        "description": "Forbidden",
                         "schema": {
                             "type": "object",
                             "additionalProperties": {
@@ -335,62 +419,14 @@ const docTemplate = `{
                 }
             }
         },
-        "/config/{name}/{section}/{option}": {
-            "get": {
-                "description": "Get a specific option value from a section",
-                "produces": [
-                    "application/json"
-                ],
-                "tags": [
-                    "config"
-                ],
-                "summary": "Get configuration option",
-                "parameters": [
-                    {
-                        "type": "string",
-                        "description": "Configuration name",
-                        "name": "name",
-                        "in": "path",
-                        "required": true
-                    },
-                    {
-                        "type": "string",
-                        "description": "Section name",
-                        "name": "section",
-                        "in": "path",
-                        "required": true
-                    },
+        "/auth/me/password": {
+            "put": {
+                "security": [
                     {
-                        "type": "string",
-                        "description": "Option key",
-                        "name": "option",
-                        "in": "path",
-                        "required": true
+                        "BearerAuth": []
                     }
                 ],
-                "responses": {
-                    "200": {
-                        "description": "OK",
-                        "schema": {
-                            "type": "object",
-                            "additionalProperties": {
-                                "type": "string"
-                            }
-                        }
-                    },
-                    "404": {
-                        "description": "Not Found",
-                        "schema": {
-                            "type": "object",
-                            "additionalProperties": {
-                                "type": "string"
-                            }
-                        }
-                    }
-                }
-            },
-            "put": {
-                "description": "Set a configuration option value (staged, requires commit)",
+                "description": "Change the current user's password. The current password is required, the new one must meet the password policy, and all other sessions of the user are logged out.",
                 "consumes": [
                     "application/json"
                 ],
@@ -398,38 +434,17 @@ const docTemplate = `{
                     "application/json"
                 ],
                 "tags": [
-                    "config"
+                    "auth"
                 ],
-                "summary": "Set configuration option",
+                "summary": "Change own password",
                 "parameters": [
                     {
-                        "type": "string",
-                        "description": "Configuration name",
-                        "name": "name",
-                        "in": "path",
-                        "required": true
-                    },
-                    {
-                        "type": "string",
-                        "description": "Section name",
-                        "name": "section",
-                        "in": "path",
-                        "required": true
-                    },
-                    {
-                        "type": "string",
-                        "description": "Option key",
-                        "name": "option",
-                        "in": "path",
-                        "required": true
-                    },
-                    {
-                        "description": "Option value",
+                        "description": "Current and new password",
                         "name": "request",
                         "in": "body",
                         "required": true,
                         "schema": {
-                            "$ref": "#/definitions/main.SetOptionRequest"
+                            "$ref": "#/definitions/main.changePasswordRequest"
                         }
                     }
                 ],
@@ -452,8 +467,17 @@ const docTemplate = `{
                             }
                         }
                     },
-                    "500": {
-                        "description": "Internal Server Error",
+                    "401": {
+                        "description": "Unauthorized",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": {
+                                "type": "string"
+                            }
+                        }
+                    },
+                    "403": {
+                        "description": "Forbidden",
                         "schema": {
                             "type": "object",
                             "additionalProperties": {
@@ -464,19 +488,61 @@ const docTemplate = `{
                 }
             }
         },
-        "/health": {
+        "/bootstrap": {
             "get": {
-                "description": "Check if the API server is running",
+                "description": "Get system metadata including initialization status",
                 "produces": [
                     "application/json"
                 ],
                 "tags": [
                     "system"
                 ],
-                "summary": "Health check",
+                "summary": "System bootstrap metadata",
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": true
+                        }
+                    }
+                }
+            }
+        },
+        "/certs": {
+            "get": {
+                "security": [
+                    {
+                        "BearerAuth": []
+                    }
+                ],
+                "description": "List the certificates hellfire uses or stores with their subjects and validity, soonest expiry first",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "certs"
+                ],
+                "summary": "List certificates",
                 "responses": {
                     "200": {
                         "description": "OK",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": true
+                        }
+                    },
+                    "403": {
+                        "description": "Forbidden",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": {
+                                "type": "string"
+                            }
+                        }
+                    },
+                    "500": {
+                        "description": "Internal Server Error",
                         "schema": {
                             "type": "object",
                             "additionalProperties": {
@@ -485,11 +551,14 @@ const docTemplate = `{
                         }
                     }
                 }
-            }
-        },
-        "/onboarding": {
+            },
             "post": {
-                "description": "Create the first admin user during system onboarding",
+                "security": [
+                    {
+                        "BearerAuth": []
+                    }
+                ],
+                "description": "Add a certificate and its private key to the certificate store. The key must match the certificate.",
                 "consumes": [
                     "application/json"
                 ],
@@ -497,25 +566,25 @@ const docTemplate = `{
                     "application/json"
                 ],
                 "tags": [
-                    "system"
+                    "certs"
                 ],
-                "summary": "Create initial admin user",
+                "summary": "Upload a certificate",
                 "parameters": [
                     {
-                        "description": "Admin user details",
+                        "description": "Certificate and key",
                         "name": "request",
                         "in": "body",
                         "required": true,
                         "schema": {
-                            "$ref": "#/definitions/main.onboardingRequest"
+                            "$ref": "#/definitions/main.UploadCertificateRequest"
                         }
                     }
                 ],
                 "responses": {
-                    "200": {
-                        "description": "OK",
+                    "201": {
+                        "description": "Created",
                         "schema": {
-                            "$ref": "#/definitions/main.loginResponse"
+                            "$ref": "#/definitions/db.Certificate"
                         }
                     },
                     "400": {
@@ -527,6 +596,15 @@ const docTemplate = `{
                             }
                         }
                     },
+                    "403": {
+                        "description": "Forbidden",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": {
+                                "type": "string"
+                            }
+                        }
+                    },
                     "409": {
                         "description": "Conflict",
                         "schema": {
@@ -535,26 +613,87 @@ const docTemplate = `{
                                 "type": "string"
                             }
                         }
+                    },
+                    "500": {
+                        "description": "Internal Server Error",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": {
+                                "type": "string"
+                            }
+                        }
                     }
                 }
             }
         },
-        "/revert": {
-            "post": {
-                "description": "Revert all staged configuration changes",
+        "/certs/{name}": {
+            "put": {
+                "security": [
+                    {
+                        "BearerAuth": []
+                    }
+                ],
+                "description": "Replace a certificate (and its private key, unless it is a CA bundle). The previous files are kept with a .bak suffix. The gRPC server picks up a rotated server certificate on its next handshake; a rotated client CA applies after a restart.",
+                "consumes": [
+                    "application/json"
+                ],
                 "produces": [
                     "application/json"
                 ],
                 "tags": [
-                    "config"
+                    "certs"
+                ],
+                "summary": "Rotate a certificate",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Certificate name",
+                        "name": "name",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "description": "New certificate and key",
+                        "name": "request",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "$ref": "#/definitions/main.RotateCertificateRequest"
+                        }
+                    }
                 ],
-                "summary": "Revert changes",
                 "responses": {
                     "200": {
                         "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/db.Certificate"
+                        }
+                    },
+                    "400": {
+                        "description": "Bad Request",
                         "schema": {
                             "type": "object",
-                            "additionalProperties": true
+                            "additionalProperties": {
+                                "type": "string"
+                            }
+                        }
+                    },
+                    "403": {
+                        "description": "Forbidden",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": {
+                                "type": "string"
+                            }
+                        }
+                    },
+                    "404": {
+                        "description": "Not Found",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": {
+                                "type": "string"
+                            }
                         }
                     },
                     "500": {
@@ -569,16 +708,35 @@ const docTemplate = `{
                 }
             }
         },
-        "/validate": {
+        "/config/batch": {
             "post": {
-                "description": "Validate staged configuration changes without applying them (dry-run)",
+                "security": [
+                    {
+                        "BearerAuth": []
+                    }
+                ],
+                "description": "Apply an ordered list of set/delete/add_list operations across configs to the staging area atomically (all or nothing, requires commit). Configs someone else locked are staged anyway with a warning naming them. Nothing is staged, with 409, if a config in revisions changed since it was read.",
+                "consumes": [
+                    "application/json"
+                ],
                 "produces": [
                     "application/json"
                 ],
                 "tags": [
                     "config"
                 ],
-                "summary": "Validate staged changes",
+                "summary": "Batch configuration changes",
+                "parameters": [
+                    {
+                        "description": "Operations",
+                        "name": "request",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "$ref": "#/definitions/main.BatchRequest"
+                        }
+                    }
+                ],
                 "responses": {
                     "200": {
                         "description": "OK",
@@ -591,133 +749,5971 @@ const docTemplate = `{
                         "description": "Bad Request",
                         "schema": {
                             "type": "object",
-                            "additionalProperties": {
-                                "type": "string"
-                            }
+                            "additionalProperties": true
                         }
                     },
-                    "500": {
-                        "description": "Internal Server Error",
+                    "403": {
+                        "description": "Forbidden",
                         "schema": {
                             "type": "object",
-                            "additionalProperties": {
-                                "type": "string"
-                            }
+                            "additionalProperties": true
+                        }
+                    },
+                    "409": {
+                        "description": "Conflict",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": true
                         }
                     }
                 }
             }
-        }
-    },
-    "definitions": {
-        "db.Role": {
-            "type": "string",
-            "enum": [
-                "admin",
-                "operator",
-                "viewer"
-            ],
+        },
+        "/config/changes": {
+            "get": {
+                "security": [
+                    {
+                        "BearerAuth": []
+                    }
+                ],
+                "description": "Get list of staged configuration changes",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "config"
+                ],
+                "summary": "Get staged changes",
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": true
+                        }
+                    }
+                }
+            }
+        },
+        "/config/commit": {
+            "post": {
+                "security": [
+                    {
+                        "BearerAuth": []
+                    }
+                ],
+                "description": "Commit staged configuration changes through the transaction manager (snapshot, apply, validate, rollback on failure).\nWith \"Accept: text/event-stream\" the response streams \"progress\" events followed by a final \"result\" or \"error\" event.\nWith \"dry_run\" nothing is snapshotted, written or applied: the response (a DryRunResponse) has the diff of each changed config, the apply order, the rulesets and files the appliers would load, and why the commit would fail, if it would.\nWith \"safe_remote\" (or the transaction's safe_remote option) the client must POST /config/keepalive within \"keepalive\" seconds of the apply, and again within every such window until it confirms, or the changes are rolled back. Without a confirm timeout the first keepalive confirms the commit.\nWith a canary configured, the changes are committed on the canary first and must leave it healthy; it is confirmed once they are applied here, and rolled back if they fail. \"skip_canary\" applies them here only.",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json",
+                    "text/event-stream"
+                ],
+                "tags": [
+                    "config"
+                ],
+                "summary": "Commit changes",
+                "parameters": [
+                    {
+                        "description": "Commit options",
+                        "name": "request",
+                        "in": "body",
+                        "schema": {
+                            "$ref": "#/definitions/main.CommitRequest"
+                        }
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "Commit result, or a DryRunResponse",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": true
+                        }
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": {
+                                "type": "string"
+                            }
+                        }
+                    },
+                    "500": {
+                        "description": "Internal Server Error",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": {
+                                "type": "string"
+                            }
+                        }
+                    }
+                }
+            }
+        },
+        "/config/confirm": {
+            "post": {
+                "security": [
+                    {
+                        "BearerAuth": []
+                    }
+                ],
+                "description": "Confirm a pending transaction to prevent automatic rollback",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "config"
+                ],
+                "summary": "Confirm pending changes",
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": {
+                                "type": "string"
+                            }
+                        }
+                    },
+                    "409": {
+                        "description": "Conflict",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": {
+                                "type": "string"
+                            }
+                        }
+                    }
+                }
+            }
+        },
+        "/config/generation": {
+            "get": {
+                "security": [
+                    {
+                        "BearerAuth": []
+                    }
+                ],
+                "description": "The config generation, a number bumped by every commit and rollback of the committed configs (also in the X-Config-Generation header of config responses). Clients caching config views drop them when it changes.\nWith \"Accept: text/event-stream\" the response streams a \"generation\" event with the current generation, then one for each change until the request times out; EventSource reconnects by itself.",
+                "produces": [
+                    "application/json",
+                    "text/event-stream"
+                ],
+                "tags": [
+                    "config"
+                ],
+                "summary": "Get config generation",
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/main.ConfigGenerationResponse"
+                        }
+                    },
+                    "500": {
+                        "description": "Internal Server Error",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": {
+                                "type": "string"
+                            }
+                        }
+                    }
+                }
+            }
+        },
+        "/config/keepalive": {
+            "post": {
+                "security": [
+                    {
+                        "BearerAuth": []
+                    }
+                ],
+                "description": "Tell a pending safe remote commit that its client still reaches the router. Only the committing user can send keepalives. Without a confirm timeout the keepalive confirms the commit; otherwise the keepalive window starts over.",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "config"
+                ],
+                "summary": "Keep a safe remote commit",
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": true
+                        }
+                    },
+                    "403": {
+                        "description": "Forbidden",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": {
+                                "type": "string"
+                            }
+                        }
+                    },
+                    "409": {
+                        "description": "Conflict",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": {
+                                "type": "string"
+                            }
+                        }
+                    }
+                }
+            }
+        },
+        "/config/locks": {
+            "get": {
+                "security": [
+                    {
+                        "BearerAuth": []
+                    }
+                ],
+                "description": "List the advisory locks operators hold on configs for long edits",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "config"
+                ],
+                "summary": "List config locks",
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": {
+                                "type": "array",
+                                "items": {
+                                    "$ref": "#/definitions/db.ConfigLock"
+                                }
+                            }
+                        }
+                    },
+                    "500": {
+                        "description": "Internal Server Error",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": {
+                                "type": "string"
+                            }
+                        }
+                    }
+                }
+            }
+        },
+        "/config/revert": {
+            "post": {
+                "security": [
+                    {
+                        "BearerAuth": []
+                    }
+                ],
+                "description": "Revert all staged configuration changes",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "config"
+                ],
+                "summary": "Revert changes",
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": true
+                        }
+                    },
+                    "500": {
+                        "description": "Internal Server Error",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": {
+                                "type": "string"
+                            }
+                        }
+                    }
+                }
+            }
+        },
+        "/config/rollback": {
+            "post": {
+                "security": [
+                    {
+                        "BearerAuth": []
+                    }
+                ],
+                "description": "Roll back a transaction awaiting confirmation now, rather than when its confirmation times out. The reason is recorded on the transaction.",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "config"
+                ],
+                "summary": "Roll back pending changes",
+                "parameters": [
+                    {
+                        "description": "Rollback reason",
+                        "name": "request",
+                        "in": "body",
+                        "schema": {
+                            "$ref": "#/definitions/main.RollbackRequest"
+                        }
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": {
+                                "type": "string"
+                            }
+                        }
+                    },
+                    "400": {
+                        "description": "A reason is required",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": {
+                                "type": "string"
+                            }
+                        }
+                    },
+                    "409": {
+                        "description": "Conflict",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": {
+                                "type": "string"
+                            }
+                        }
+                    }
+                }
+            }
+        },
+        "/config/validate": {
+            "post": {
+                "security": [
+                    {
+                        "BearerAuth": []
+                    }
+                ],
+                "description": "Validate staged configuration changes without applying them (dry-run): each config must load and pass its applier's checks, e.g. that configured network interfaces exist",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "config"
+                ],
+                "summary": "Validate staged changes",
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": true
+                        }
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": {
+                                "type": "string"
+                            }
+                        }
+                    },
+                    "500": {
+                        "description": "Internal Server Error",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": {
+                                "type": "string"
+                            }
+                        }
+                    }
+                }
+            }
+        },
+        "/config/{name}": {
+            "get": {
+                "security": [
+                    {
+                        "BearerAuth": []
+                    }
+                ],
+                "description": "Get entire configuration file. Secret options (keys, passwords) are masked for non-admins, and shown encrypted to admins. Configs and sections the caller may not read are left out (see the acl sections of the Hellfire config), and each section's \".permissions\" lists what the caller may do with it (\"read\", \"write\").",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "config"
+                ],
+                "summary": "Get configuration",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Configuration name (e.g., network, firewall)",
+                        "name": "name",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "type": "string",
+                        "description": "ETag from a previous response",
+                        "name": "If-None-Match",
+                        "in": "header"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": true
+                        },
+                        "headers": {
+                            "ETag": {
+                                "type": "string",
+                                "description": "Checksum of the (staged) config"
+                            }
+                        }
+                    },
+                    "304": {
+                        "description": "Config unchanged since the given ETag"
+                    },
+                    "404": {
+                        "description": "Not Found",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": {
+                                "type": "string"
+                            }
+                        }
+                    },
+                    "500": {
+                        "description": "Internal Server Error",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": {
+                                "type": "string"
+                            }
+                        }
+                    }
+                }
+            }
+        },
+        "/config/{name}/lock": {
+            "put": {
+                "security": [
+                    {
+                        "BearerAuth": []
+                    }
+                ],
+                "description": "Take an advisory lock on a config for a long edit, or renew one's own lock. Writes to the config by others still go through but carry a warning naming the holder. The lock expires after its TTL unless renewed.",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "config"
+                ],
+                "summary": "Lock config",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Configuration name",
+                        "name": "name",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "description": "Lock options",
+                        "name": "request",
+                        "in": "body",
+                        "schema": {
+                            "$ref": "#/definitions/main.LockRequest"
+                        }
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/db.ConfigLock"
+                        }
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": {
+                                "type": "string"
+                            }
+                        }
+                    },
+                    "409": {
+                        "description": "Locked by another user, with their lock",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": true
+                        }
+                    }
+                }
+            },
+            "delete": {
+                "security": [
+                    {
+                        "BearerAuth": []
+                    }
+                ],
+                "description": "Release one's own lock on a config. Admins can break the locks of others.",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "config"
+                ],
+                "summary": "Unlock config",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Configuration name",
+                        "name": "name",
+                        "in": "path",
+                        "required": true
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": {
+                                "type": "string"
+                            }
+                        }
+                    },
+                    "403": {
+                        "description": "Forbidden",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": {
+                                "type": "string"
+                            }
+                        }
+                    },
+                    "404": {
+                        "description": "Not Found",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": {
+                                "type": "string"
+                            }
+                        }
+                    }
+                }
+            }
+        },
+        "/config/{name}/{section}": {
+            "get": {
+                "security": [
+                    {
+                        "BearerAuth": []
+                    }
+                ],
+                "description": "Get a specific section from configuration (secret options masked for non-admins), with the caller's \".permissions\" on it",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "config"
+                ],
+                "summary": "Get configuration section",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Configuration name",
+                        "name": "name",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "type": "string",
+                        "description": "Section name or type",
+                        "name": "section",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "type": "string",
+                        "description": "ETag from a previous response",
+                        "name": "If-None-Match",
+                        "in": "header"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": true
+                        },
+                        "headers": {
+                            "ETag": {
+                                "type": "string",
+                                "description": "Checksum of the (staged) config"
+                            }
+                        }
+                    },
+                    "304": {
+                        "description": "Config unchanged since the given ETag"
+                    },
+                    "404": {
+                        "description": "Not Found",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": {
+                                "type": "string"
+                            }
+                        }
+                    },
+                    "500": {
+                        "description": "Internal Server Error",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": {
+                                "type": "string"
+                            }
+                        }
+                    }
+                }
+            }
+        },
+        "/config/{name}/{section}/{option}": {
+            "get": {
+                "security": [
+                    {
+                        "BearerAuth": []
+                    }
+                ],
+                "description": "Get a specific option value from a section (masked for non-admins if secret)",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "config"
+                ],
+                "summary": "Get configuration option",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Configuration name",
+                        "name": "name",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "type": "string",
+                        "description": "Section name",
+                        "name": "section",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "type": "string",
+                        "description": "Option key",
+                        "name": "option",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "type": "string",
+                        "description": "ETag from a previous response",
+                        "name": "If-None-Match",
+                        "in": "header"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": {
+                                "type": "string"
+                            }
+                        },
+                        "headers": {
+                            "ETag": {
+                                "type": "string",
+                                "description": "Checksum of the (staged) config"
+                            }
+                        }
+                    },
+                    "304": {
+                        "description": "Config unchanged since the given ETag"
+                    },
+                    "404": {
+                        "description": "Not Found",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": {
+                                "type": "string"
+                            }
+                        }
+                    }
+                }
+            },
+            "put": {
+                "security": [
+                    {
+                        "BearerAuth": []
+                    }
+                ],
+                "description": "Set a configuration option value (staged, requires commit). If someone else locked the config, the value is staged anyway with a warning naming them. Options with a fixed set of values (e.g. an interface proto or a firewall target) reject anything else, and boolean options store true/on/yes as 1 and false/off/no as 0. With If-Match, the value is only set if the config is unchanged since it was read; the response carries its new ETag.",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "config"
+                ],
+                "summary": "Set configuration option",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Configuration name",
+                        "name": "name",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "type": "string",
+                        "description": "Section name",
+                        "name": "section",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "type": "string",
+                        "description": "Option key",
+                        "name": "option",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "description": "Option value",
+                        "name": "request",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "$ref": "#/definitions/main.SetOptionRequest"
+                        }
+                    },
+                    {
+                        "type": "string",
+                        "description": "ETag of the config as read; the write fails with 409 if it changed since",
+                        "name": "If-Match",
+                        "in": "header"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": {
+                                "type": "string"
+                            }
+                        }
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": {
+                                "type": "string"
+                            }
+                        }
+                    },
+                    "403": {
+                        "description": "Forbidden",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": {
+                                "type": "string"
+                            }
+                        }
+                    },
+                    "409": {
+                        "description": "Conflict",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": {
+                                "type": "string"
+                            }
+                        }
+                    },
+                    "500": {
+                        "description": "Internal Server Error",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": {
+                                "type": "string"
+                            }
+                        }
+                    }
+                }
+            }
+        },
+        "/dhcp/pools": {
+            "get": {
+                "security": [
+                    {
+                        "BearerAuth": []
+                    }
+                ],
+                "description": "List the address pools of the committed dhcp config with how many of their addresses are leased or reserved by static leases. Pools at or over their warn_percent (default 90) are exhausting.",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "dhcp"
+                ],
+                "summary": "Get DHCP pool utilization",
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/main.DHCPPoolsResponse"
+                        }
+                    },
+                    "500": {
+                        "description": "Internal Server Error",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": {
+                                "type": "string"
+                            }
+                        }
+                    }
+                }
+            }
+        },
+        "/dns": {
+            "get": {
+                "security": [
+                    {
+                        "BearerAuth": []
+                    }
+                ],
+                "description": "Get the upstream servers (including conditional forwarders such as /corp.example/10.0.0.1), local zones and local records of the dhcp config, staged changes included",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "dns"
+                ],
+                "summary": "Get DNS settings",
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/main.DNSResponse"
+                        }
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": {
+                                "type": "string"
+                            }
+                        }
+                    },
+                    "403": {
+                        "description": "Forbidden",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": {
+                                "type": "string"
+                            }
+                        }
+                    },
+                    "500": {
+                        "description": "Internal Server Error",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": {
+                                "type": "string"
+                            }
+                        }
+                    }
+                }
+            }
+        },
+        "/dns/hosts": {
+            "get": {
+                "security": [
+                    {
+                        "BearerAuth": []
+                    }
+                ],
+                "description": "List the names resolved locally to addresses: the A and AAAA records, and the static leases registered under the local domain. With format=hosts the entries are returned as an /etc/hosts file.",
+                "produces": [
+                    "application/json",
+                    "text/plain"
+                ],
+                "tags": [
+                    "dns"
+                ],
+                "summary": "List hosts entries",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "json (default) or hosts",
+                        "name": "format",
+                        "in": "query"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/main.DNSHostsResponse"
+                        }
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": {
+                                "type": "string"
+                            }
+                        }
+                    },
+                    "403": {
+                        "description": "Forbidden",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": {
+                                "type": "string"
+                            }
+                        }
+                    },
+                    "500": {
+                        "description": "Internal Server Error",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": {
+                                "type": "string"
+                            }
+                        }
+                    }
+                }
+            },
+            "post": {
+                "security": [
+                    {
+                        "BearerAuth": []
+                    }
+                ],
+                "description": "Stage an A or AAAA record, by the family of the address",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "dns"
+                ],
+                "summary": "Add a hosts entry",
+                "parameters": [
+                    {
+                        "description": "Hosts entry",
+                        "name": "host",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "$ref": "#/definitions/main.DNSHostRequest"
+                        }
+                    }
+                ],
+                "responses": {
+                    "201": {
+                        "description": "Created",
+                        "schema": {
+                            "$ref": "#/definitions/main.DNSRecordResponse"
+                        }
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": {
+                                "type": "string"
+                            }
+                        }
+                    },
+                    "403": {
+                        "description": "Forbidden",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": {
+                                "type": "string"
+                            }
+                        }
+                    },
+                    "500": {
+                        "description": "Internal Server Error",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": {
+                                "type": "string"
+                            }
+                        }
+                    }
+                }
+            }
+        },
+        "/dns/hosts/{id}": {
+            "delete": {
+                "security": [
+                    {
+                        "BearerAuth": []
+                    }
+                ],
+                "description": "Stage the removal of the record of a hosts entry. Entries of static leases are removed with the lease, or by setting its dns option to 0.",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "dns"
+                ],
+                "summary": "Delete a hosts entry",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Hosts entry ID",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/main.DNSRecordResponse"
+                        }
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": {
+                                "type": "string"
+                            }
+                        }
+                    },
+                    "403": {
+                        "description": "Forbidden",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": {
+                                "type": "string"
+                            }
+                        }
+                    },
+                    "404": {
+                        "description": "Not Found",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": {
+                                "type": "string"
+                            }
+                        }
+                    },
+                    "500": {
+                        "description": "Internal Server Error",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": {
+                                "type": "string"
+                            }
+                        }
+                    }
+                }
+            }
+        },
+        "/dns/records": {
+            "get": {
+                "security": [
+                    {
+                        "BearerAuth": []
+                    }
+                ],
+                "description": "List the A, AAAA and CNAME records answered locally, staged changes included",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "dns"
+                ],
+                "summary": "List DNS records",
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/main.DNSRecordsResponse"
+                        }
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": {
+                                "type": "string"
+                            }
+                        }
+                    },
+                    "403": {
+                        "description": "Forbidden",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": {
+                                "type": "string"
+                            }
+                        }
+                    },
+                    "500": {
+                        "description": "Internal Server Error",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": {
+                                "type": "string"
+                            }
+                        }
+                    }
+                }
+            },
+            "post": {
+                "security": [
+                    {
+                        "BearerAuth": []
+                    }
+                ],
+                "description": "Stage a new A, AAAA or CNAME record. A name with a CNAME can't have other records.",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "dns"
+                ],
+                "summary": "Add a DNS record",
+                "parameters": [
+                    {
+                        "description": "Record",
+                        "name": "record",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "$ref": "#/definitions/main.DNSRecordRequest"
+                        }
+                    }
+                ],
+                "responses": {
+                    "201": {
+                        "description": "Created",
+                        "schema": {
+                            "$ref": "#/definitions/main.DNSRecordResponse"
+                        }
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": {
+                                "type": "string"
+                            }
+                        }
+                    },
+                    "403": {
+                        "description": "Forbidden",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": {
+                                "type": "string"
+                            }
+                        }
+                    },
+                    "500": {
+                        "description": "Internal Server Error",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": {
+                                "type": "string"
+                            }
+                        }
+                    }
+                }
+            }
+        },
+        "/dns/records/{id}": {
+            "put": {
+                "security": [
+                    {
+                        "BearerAuth": []
+                    }
+                ],
+                "description": "Stage changes to a record. A and AAAA records can change into each other, but not into a CNAME or back.",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "dns"
+                ],
+                "summary": "Update a DNS record",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Record ID",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "description": "Record",
+                        "name": "record",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "$ref": "#/definitions/main.DNSRecordRequest"
+                        }
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/main.DNSRecordResponse"
+                        }
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": {
+                                "type": "string"
+                            }
+                        }
+                    },
+                    "403": {
+                        "description": "Forbidden",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": {
+                                "type": "string"
+                            }
+                        }
+                    },
+                    "404": {
+                        "description": "Not Found",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": {
+                                "type": "string"
+                            }
+                        }
+                    },
+                    "500": {
+                        "description": "Internal Server Error",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": {
+                                "type": "string"
+                            }
+                        }
+                    }
+                }
+            },
+            "delete": {
+                "security": [
+                    {
+                        "BearerAuth": []
+                    }
+                ],
+                "description": "Stage the removal of a record",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "dns"
+                ],
+                "summary": "Delete a DNS record",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Record ID",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/main.DNSRecordResponse"
+                        }
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": {
+                                "type": "string"
+                            }
+                        }
+                    },
+                    "403": {
+                        "description": "Forbidden",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": {
+                                "type": "string"
+                            }
+                        }
+                    },
+                    "404": {
+                        "description": "Not Found",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": {
+                                "type": "string"
+                            }
+                        }
+                    },
+                    "500": {
+                        "description": "Internal Server Error",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": {
+                                "type": "string"
+                            }
+                        }
+                    }
+                }
+            }
+        },
+        "/dns/stats": {
+            "get": {
+                "security": [
+                    {
+                        "BearerAuth": []
+                    }
+                ],
+                "description": "Count the queries dnsmasq logged to the syslog collector, with the top domains and clients and the blocked queries (answered locally with 0.0.0.0, :: or NXDOMAIN). Queries are logged with the logqueries option of the dnsmasq section, and kept in memory only.",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "dns"
+                ],
+                "summary": "Get DNS query statistics",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "How far back to count, as a duration (default everything kept)",
+                        "name": "since",
+                        "in": "query"
+                    },
+                    {
+                        "type": "integer",
+                        "description": "Number of top domains and clients (default 10, maximum 100)",
+                        "name": "limit",
+                        "in": "query"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/main.DNSStatsResponse"
+                        }
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": {
+                                "type": "string"
+                            }
+                        }
+                    },
+                    "500": {
+                        "description": "Internal Server Error",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": {
+                                "type": "string"
+                            }
+                        }
+                    }
+                }
+            }
+        },
+        "/graphql": {
+            "post": {
+                "security": [
+                    {
+                        "BearerAuth": []
+                    }
+                ],
+                "description": "Run a read-only GraphQL query over configs, transactions, snapshots, users and the audit log, so nested data (a transaction with its snapshot and audit entries) is fetched in one round trip. Supports fields, aliases, arguments, variables, fragments and @include/@skip; mutations are rejected. GET /graphql/schema returns the schema. Errors in the query fail it with only \"errors\"; fields that fail are null in \"data\" and listed in \"errors\". Only served when the API's graphql option is enabled.",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "graphql"
+                ],
+                "summary": "Run a GraphQL query",
+                "parameters": [
+                    {
+                        "description": "Query, operation name and variables",
+                        "name": "request",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "$ref": "#/definitions/graphql.Request"
+                        }
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/graphql.Response"
+                        }
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": {
+                                "type": "string"
+                            }
+                        }
+                    }
+                }
+            }
+        },
+        "/graphql/schema": {
+            "get": {
+                "security": [
+                    {
+                        "BearerAuth": []
+                    }
+                ],
+                "description": "Describe the types and fields of the GraphQL endpoint in the schema definition language, for code generators and editors",
+                "produces": [
+                    "text/plain"
+                ],
+                "tags": [
+                    "graphql"
+                ],
+                "summary": "Get the GraphQL schema",
+                "responses": {
+                    "200": {
+                        "description": "Schema in SDL",
+                        "schema": {
+                            "type": "string"
+                        }
+                    }
+                }
+            }
+        },
+        "/health": {
+            "get": {
+                "description": "Check if the API server is running; status is \"degraded\" while a failed rollback keeps the system in safe mode",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "system"
+                ],
+                "summary": "Health check",
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": {
+                                "type": "string"
+                            }
+                        }
+                    }
+                }
+            }
+        },
+        "/health/score": {
+            "get": {
+                "security": [
+                    {
+                        "BearerAuth": []
+                    }
+                ],
+                "description": "Score from 0 to 100 of how much attention the router needs, combining config drift, failed transactions, WAN quality, free disk space and certificate expiry (checks without data are skipped)",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "system"
+                ],
+                "summary": "Get health score",
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/health.Report"
+                        }
+                    },
+                    "401": {
+                        "description": "Unauthorized",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": {
+                                "type": "string"
+                            }
+                        }
+                    }
+                }
+            }
+        },
+        "/logs": {
+            "get": {
+                "security": [
+                    {
+                        "BearerAuth": []
+                    }
+                ],
+                "description": "List the most recent messages received by the syslog collector, newest first. Poll for new messages with after set to the newest ID seen.",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "logs"
+                ],
+                "summary": "Get system logs",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Only messages from this program (e.g. dnsmasq, kernel, hostapd)",
+                        "name": "app",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "Only messages from this hostname",
+                        "name": "host",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "Only messages of this facility (e.g. kern, daemon)",
+                        "name": "facility",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "Only this severity and more severe (name or 0-7)",
+                        "name": "severity",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "How far back to list, as a duration",
+                        "name": "since",
+                        "in": "query"
+                    },
+                    {
+                        "type": "integer",
+                        "description": "Only messages after this ID",
+                        "name": "after",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "Only messages containing this text",
+                        "name": "q",
+                        "in": "query"
+                    },
+                    {
+                        "type": "integer",
+                        "description": "Maximum number of messages (default 500, maximum 5000)",
+                        "name": "limit",
+                        "in": "query"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/main.LogsResponse"
+                        }
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": {
+                                "type": "string"
+                            }
+                        }
+                    },
+                    "403": {
+                        "description": "Forbidden",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": {
+                                "type": "string"
+                            }
+                        }
+                    }
+                }
+            }
+        },
+        "/metrics": {
+            "get": {
+                "security": [
+                    {
+                        "APIKeyAuth": []
+                    }
+                ],
+                "description": "The health score and the score of each check, and the size and utilization of the DHCP pools, as Prometheus gauges, for scraping with an API key",
+                "produces": [
+                    "text/plain"
+                ],
+                "tags": [
+                    "system"
+                ],
+                "summary": "Prometheus metrics",
+                "responses": {
+                    "200": {
+                        "description": "Prometheus text exposition format",
+                        "schema": {
+                            "type": "string"
+                        }
+                    },
+                    "401": {
+                        "description": "Unauthorized",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": {
+                                "type": "string"
+                            }
+                        }
+                    }
+                }
+            }
+        },
+        "/network/devices": {
+            "get": {
+                "security": [
+                    {
+                        "BearerAuth": []
+                    }
+                ],
+                "description": "List the physical network interfaces with their driver, speed, link state and MAC address, and whether an interface section of the network config (including staged changes) manages them",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "network"
+                ],
+                "summary": "List network devices",
+                "parameters": [
+                    {
+                        "type": "boolean",
+                        "description": "Include loopback and virtual interfaces",
+                        "name": "all",
+                        "in": "query"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": {
+                                "type": "array",
+                                "items": {
+                                    "$ref": "#/definitions/main.NetworkDevice"
+                                }
+                            }
+                        }
+                    },
+                    "500": {
+                        "description": "Internal Server Error",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": {
+                                "type": "string"
+                            }
+                        }
+                    }
+                }
+            }
+        },
+        "/network/modems": {
+            "get": {
+                "security": [
+                    {
+                        "BearerAuth": []
+                    }
+                ],
+                "description": "List the modems known to ModemManager with their state, signal quality, access technologies, registration, operator and connected bearer, the modem interface of the committed network config using each, and the modem interfaces whose modem is missing",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "network"
+                ],
+                "summary": "List cellular modems",
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/main.ModemsResponse"
+                        }
+                    },
+                    "500": {
+                        "description": "Internal Server Error",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": {
+                                "type": "string"
+                            }
+                        }
+                    }
+                }
+            }
+        },
+        "/onboarding": {
+            "post": {
+                "description": "Create the first admin user during system onboarding, authorized by the one-time token hf serve prints at first start",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "system"
+                ],
+                "summary": "Create initial admin user",
+                "parameters": [
+                    {
+                        "description": "Admin user details",
+                        "name": "request",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "$ref": "#/definitions/main.onboardingRequest"
+                        }
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/main.loginResponse"
+                        }
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": {
+                                "type": "string"
+                            }
+                        }
+                    },
+                    "401": {
+                        "description": "Unauthorized",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": {
+                                "type": "string"
+                            }
+                        }
+                    },
+                    "409": {
+                        "description": "Conflict",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": {
+                                "type": "string"
+                            }
+                        }
+                    }
+                }
+            }
+        },
+        "/onboarding/network": {
+            "get": {
+                "security": [
+                    {
+                        "BearerAuth": []
+                    }
+                ],
+                "description": "Detect the network interfaces and propose a WAN/LAN assignment, LAN subnet, DHCP pool and Wi-Fi network for the setup wizard",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "system"
+                ],
+                "summary": "Detect interfaces for the network setup",
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/main.OnboardingNetworkResponse"
+                        }
+                    },
+                    "403": {
+                        "description": "Forbidden",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": {
+                                "type": "string"
+                            }
+                        }
+                    },
+                    "500": {
+                        "description": "Internal Server Error",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": {
+                                "type": "string"
+                            }
+                        }
+                    }
+                }
+            },
+            "post": {
+                "security": [
+                    {
+                        "BearerAuth": []
+                    }
+                ],
+                "description": "Stage and commit the configs of a network setup as one transaction. The commit always waits for confirmation (POST /config/confirm), so a setup that cuts off the wizard rolls back.",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "system"
+                ],
+                "summary": "Apply the network setup",
+                "parameters": [
+                    {
+                        "description": "Network setup",
+                        "name": "request",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "$ref": "#/definitions/main.OnboardingNetworkRequest"
+                        }
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": true
+                        }
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": {
+                                "type": "string"
+                            }
+                        }
+                    },
+                    "403": {
+                        "description": "Forbidden",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": {
+                                "type": "string"
+                            }
+                        }
+                    },
+                    "409": {
+                        "description": "Conflict",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": {
+                                "type": "string"
+                            }
+                        }
+                    },
+                    "500": {
+                        "description": "Internal Server Error",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": {
+                                "type": "string"
+                            }
+                        }
+                    }
+                }
+            }
+        },
+        "/onboarding/network/preview": {
+            "post": {
+                "security": [
+                    {
+                        "BearerAuth": []
+                    }
+                ],
+                "description": "Validate a network setup and return the configs it would commit, without staging them",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "system"
+                ],
+                "summary": "Preview the network setup",
+                "parameters": [
+                    {
+                        "description": "Network setup",
+                        "name": "request",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "$ref": "#/definitions/main.OnboardingNetworkRequest"
+                        }
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/main.OnboardingPreviewResponse"
+                        }
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": {
+                                "type": "string"
+                            }
+                        }
+                    },
+                    "403": {
+                        "description": "Forbidden",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": {
+                                "type": "string"
+                            }
+                        }
+                    }
+                }
+            }
+        },
+        "/plugins": {
+            "get": {
+                "security": [
+                    {
+                        "APIKeyAuth": []
+                    }
+                ],
+                "description": "List the plugin commands hf runs as its subcommands: those registered through the API and the hf-\u003cname\u003e executables on the server's PATH. Authenticated with an admin API key.",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "plugins"
+                ],
+                "summary": "List plugin commands",
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": {
+                                "type": "array",
+                                "items": {
+                                    "$ref": "#/definitions/plugin.Command"
+                                }
+                            }
+                        }
+                    },
+                    "500": {
+                        "description": "Internal Server Error",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": {
+                                "type": "string"
+                            }
+                        }
+                    }
+                }
+            }
+        },
+        "/plugins/{name}": {
+            "put": {
+                "security": [
+                    {
+                        "APIKeyAuth": []
+                    }
+                ],
+                "description": "Register an executable as the hf subcommand of a name, replacing the one registered before, for an add-on daemon to ship its commands. A built-in command of the same name hides it. Authenticated with an admin API key.",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "plugins"
+                ],
+                "summary": "Register plugin command",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Command name",
+                        "name": "name",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "description": "Executable to run",
+                        "name": "request",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "$ref": "#/definitions/main.RegisterPluginRequest"
+                        }
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/plugin.Command"
+                        }
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": {
+                                "type": "string"
+                            }
+                        }
+                    }
+                }
+            },
+            "delete": {
+                "security": [
+                    {
+                        "APIKeyAuth": []
+                    }
+                ],
+                "description": "Remove a plugin command registered through the API. Authenticated with an admin API key.",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "plugins"
+                ],
+                "summary": "Unregister plugin command",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Command name",
+                        "name": "name",
+                        "in": "path",
+                        "required": true
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": {
+                                "type": "string"
+                            }
+                        }
+                    },
+                    "404": {
+                        "description": "Not Found",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": {
+                                "type": "string"
+                            }
+                        }
+                    }
+                }
+            }
+        },
+        "/schema/{config}": {
+            "get": {
+                "security": [
+                    {
+                        "BearerAuth": []
+                    }
+                ],
+                "description": "Describe the options of a config the schema knows by section type: their type (bool, enum or string), allowed values, default and help text, and whether they are secret. Setting an option validates it against the same schema, so forms built from it accept what the API does. Options not listed take any value.",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "config"
+                ],
+                "summary": "Get the schema of a config",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Configuration name",
+                        "name": "config",
+                        "in": "path",
+                        "required": true
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/main.ConfigSchemaResponse"
+                        }
+                    },
+                    "404": {
+                        "description": "Not Found",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": {
+                                "type": "string"
+                            }
+                        }
+                    }
+                }
+            }
+        },
+        "/snapshots": {
+            "get": {
+                "security": [
+                    {
+                        "BearerAuth": []
+                    }
+                ],
+                "description": "List all configuration snapshots, newest first",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "snapshots"
+                ],
+                "summary": "List snapshots",
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": true
+                        }
+                    },
+                    "500": {
+                        "description": "Internal Server Error",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": {
+                                "type": "string"
+                            }
+                        }
+                    }
+                }
+            }
+        },
+        "/snapshots/{id}/protect": {
+            "post": {
+                "security": [
+                    {
+                        "BearerAuth": []
+                    }
+                ],
+                "description": "Protect a snapshot from automatic pruning, by count or by the quota of the snapshot directory",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "snapshots"
+                ],
+                "summary": "Protect snapshot",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Snapshot ID",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/snapshot.Metadata"
+                        }
+                    },
+                    "404": {
+                        "description": "Not Found",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": {
+                                "type": "string"
+                            }
+                        }
+                    }
+                }
+            },
+            "delete": {
+                "security": [
+                    {
+                        "BearerAuth": []
+                    }
+                ],
+                "description": "Let a protected snapshot be pruned automatically again",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "snapshots"
+                ],
+                "summary": "Unprotect snapshot",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Snapshot ID",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/snapshot.Metadata"
+                        }
+                    },
+                    "404": {
+                        "description": "Not Found",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": {
+                                "type": "string"
+                            }
+                        }
+                    }
+                }
+            }
+        },
+        "/snapshots/{id}/rollback": {
+            "post": {
+                "security": [
+                    {
+                        "BearerAuth": []
+                    }
+                ],
+                "description": "Restore and re-apply a snapshot as a new transaction, recorded with the reason for the rollback",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "snapshots"
+                ],
+                "summary": "Rollback to snapshot",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Snapshot ID",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "description": "Rollback reason",
+                        "name": "request",
+                        "in": "body",
+                        "schema": {
+                            "$ref": "#/definitions/main.RollbackRequest"
+                        }
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": {
+                                "type": "string"
+                            }
+                        }
+                    },
+                    "400": {
+                        "description": "A reason is required",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": {
+                                "type": "string"
+                            }
+                        }
+                    },
+                    "500": {
+                        "description": "Internal Server Error",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": {
+                                "type": "string"
+                            }
+                        }
+                    }
+                }
+            }
+        },
+        "/snapshots/{id}/stage": {
+            "post": {
+                "security": [
+                    {
+                        "BearerAuth": []
+                    }
+                ],
+                "description": "Stage the configs stored in a snapshot so they can be reviewed and committed",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "snapshots"
+                ],
+                "summary": "Stage snapshot contents",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Snapshot ID",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": true
+                        }
+                    },
+                    "500": {
+                        "description": "Internal Server Error",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": {
+                                "type": "string"
+                            }
+                        }
+                    }
+                }
+            }
+        },
+        "/stats/summary": {
+            "get": {
+                "security": [
+                    {
+                        "BearerAuth": []
+                    }
+                ],
+                "description": "Count the transactions of each day by status, the audit entries by action and outcome, failed logins and rollbacks over the last days, for an activity dashboard",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "system"
+                ],
+                "summary": "Get activity summary",
+                "parameters": [
+                    {
+                        "type": "integer",
+                        "description": "Days to cover, including today (default 30, maximum 366)",
+                        "name": "days",
+                        "in": "query"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/main.StatsSummary"
+                        }
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": {
+                                "type": "string"
+                            }
+                        }
+                    },
+                    "500": {
+                        "description": "Internal Server Error",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": {
+                                "type": "string"
+                            }
+                        }
+                    }
+                }
+            }
+        },
+        "/system/apply-order": {
+            "get": {
+                "security": [
+                    {
+                        "BearerAuth": []
+                    }
+                ],
+                "description": "Get the order in which changed configs are applied, the skip-list, and registered appliers",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "system"
+                ],
+                "summary": "Get apply order",
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": true
+                        }
+                    },
+                    "403": {
+                        "description": "Forbidden",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": {
+                                "type": "string"
+                            }
+                        }
+                    }
+                }
+            },
+            "put": {
+                "security": [
+                    {
+                        "BearerAuth": []
+                    }
+                ],
+                "description": "Update the apply order and skip-list and persist them to the Hellfire config",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "system"
+                ],
+                "summary": "Update apply order",
+                "parameters": [
+                    {
+                        "description": "Apply order",
+                        "name": "request",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "$ref": "#/definitions/main.ApplyOrderRequest"
+                        }
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": true
+                        }
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": {
+                                "type": "string"
+                            }
+                        }
+                    },
+                    "403": {
+                        "description": "Forbidden",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": {
+                                "type": "string"
+                            }
+                        }
+                    }
+                }
+            }
+        },
+        "/system/degraded": {
+            "get": {
+                "security": [
+                    {
+                        "BearerAuth": []
+                    }
+                ],
+                "description": "Report whether a failed rollback put the system in safe mode, why, and whether the recovery network was applied",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "system"
+                ],
+                "summary": "Get safe mode status",
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": true
+                        }
+                    },
+                    "403": {
+                        "description": "Forbidden",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": {
+                                "type": "string"
+                            }
+                        }
+                    }
+                }
+            },
+            "delete": {
+                "security": [
+                    {
+                        "BearerAuth": []
+                    }
+                ],
+                "description": "Clear the degraded flag once a working configuration has been restored",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "system"
+                ],
+                "summary": "Leave safe mode",
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": {
+                                "type": "string"
+                            }
+                        }
+                    },
+                    "403": {
+                        "description": "Forbidden",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": {
+                                "type": "string"
+                            }
+                        }
+                    },
+                    "409": {
+                        "description": "Conflict",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": {
+                                "type": "string"
+                            }
+                        }
+                    }
+                }
+            }
+        },
+        "/system/dhcp-clients": {
+            "get": {
+                "security": [
+                    {
+                        "BearerAuth": []
+                    }
+                ],
+                "description": "List the supervised DHCP clients with their process state, current lease and interface addresses",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "system"
+                ],
+                "summary": "Get DHCP client status",
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": true
+                        }
+                    },
+                    "403": {
+                        "description": "Forbidden",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": {
+                                "type": "string"
+                            }
+                        }
+                    }
+                }
+            }
+        },
+        "/system/events": {
+            "get": {
+                "security": [
+                    {
+                        "BearerAuth": []
+                    }
+                ],
+                "description": "Get published, delivered, dropped and spooled event counts for each event handler",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "system"
+                ],
+                "summary": "Get event bus statistics",
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/bus.Stats"
+                        }
+                    },
+                    "403": {
+                        "description": "Forbidden",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": {
+                                "type": "string"
+                            }
+                        }
+                    }
+                }
+            }
+        },
+        "/system/links": {
+            "get": {
+                "security": [
+                    {
+                        "BearerAuth": []
+                    }
+                ],
+                "description": "List the network links with their carrier state and the recent link events (interfaces appearing or disappearing, cables plugged in or unplugged), newest first",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "system"
+                ],
+                "summary": "Get link state and events",
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/main.LinksResponse"
+                        }
+                    },
+                    "403": {
+                        "description": "Forbidden",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": {
+                                "type": "string"
+                            }
+                        }
+                    }
+                }
+            }
+        },
+        "/system/quotas": {
+            "get": {
+                "security": [
+                    {
+                        "BearerAuth": []
+                    }
+                ],
+                "description": "List the per-device quotas with their usage in the current period, next reset and whether they are enforced",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "system"
+                ],
+                "summary": "Get bandwidth quota status",
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": true
+                        }
+                    },
+                    "403": {
+                        "description": "Forbidden",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": {
+                                "type": "string"
+                            }
+                        }
+                    },
+                    "500": {
+                        "description": "Internal Server Error",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": {
+                                "type": "string"
+                            }
+                        }
+                    }
+                }
+            }
+        },
+        "/system/storage": {
+            "get": {
+                "security": [
+                    {
+                        "BearerAuth": []
+                    }
+                ],
+                "description": "Disk usage of the snapshots against their quota and the free space required to take one, and the size and free space of the file systems holding the configs, the database and the snapshots",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "system"
+                ],
+                "summary": "Get disk usage",
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/main.StorageResponse"
+                        }
+                    },
+                    "403": {
+                        "description": "Forbidden",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": {
+                                "type": "string"
+                            }
+                        }
+                    },
+                    "500": {
+                        "description": "Internal Server Error",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": {
+                                "type": "string"
+                            }
+                        }
+                    }
+                }
+            }
+        },
+        "/system/upgrade": {
+            "get": {
+                "security": [
+                    {
+                        "BearerAuth": []
+                    }
+                ],
+                "description": "Show the status of the last upgrade: its backups, versions and post-upgrade check results",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "system"
+                ],
+                "summary": "Get the last upgrade",
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/upgrade.State"
+                        }
+                    },
+                    "403": {
+                        "description": "Forbidden",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": {
+                                "type": "string"
+                            }
+                        }
+                    },
+                    "404": {
+                        "description": "Not Found",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": {
+                                "type": "string"
+                            }
+                        }
+                    }
+                }
+            },
+            "post": {
+                "security": [
+                    {
+                        "BearerAuth": []
+                    }
+                ],
+                "description": "Snapshot the configs, back up the database and upgrade with apt-get or a signed image, then restart the service, which verifies the upgrade.\nThe upgrade runs in its own systemd unit. With \"Accept: text/event-stream\" the installer's output is streamed as \"output\" events followed by a final \"result\" or \"error\" event; otherwise 202 is returned with the upgrade ID.",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json",
+                    "text/event-stream"
+                ],
+                "tags": [
+                    "system"
+                ],
+                "summary": "Upgrade the system",
+                "parameters": [
+                    {
+                        "description": "Upgrade options",
+                        "name": "request",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "$ref": "#/definitions/main.UpgradeRequest"
+                        }
+                    }
+                ],
+                "responses": {
+                    "202": {
+                        "description": "Accepted",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": {
+                                "type": "string"
+                            }
+                        }
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": {
+                                "type": "string"
+                            }
+                        }
+                    },
+                    "403": {
+                        "description": "Forbidden",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": {
+                                "type": "string"
+                            }
+                        }
+                    },
+                    "409": {
+                        "description": "Conflict",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": {
+                                "type": "string"
+                            }
+                        }
+                    },
+                    "500": {
+                        "description": "Internal Server Error",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": {
+                                "type": "string"
+                            }
+                        }
+                    }
+                }
+            }
+        },
+        "/system/upgrade/rollback": {
+            "post": {
+                "security": [
+                    {
+                        "BearerAuth": []
+                    }
+                ],
+                "description": "Restore and re-apply the config snapshot taken before the last upgrade. Packages are not downgraded and the database is not restored.",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "system"
+                ],
+                "summary": "Roll back the configs of the last upgrade",
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/upgrade.State"
+                        }
+                    },
+                    "403": {
+                        "description": "Forbidden",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": {
+                                "type": "string"
+                            }
+                        }
+                    },
+                    "404": {
+                        "description": "Not Found",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": {
+                                "type": "string"
+                            }
+                        }
+                    },
+                    "409": {
+                        "description": "Conflict",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": {
+                                "type": "string"
+                            }
+                        }
+                    }
+                }
+            }
+        },
+        "/tasks": {
+            "get": {
+                "security": [
+                    {
+                        "BearerAuth": []
+                    }
+                ],
+                "description": "List the tasks of the tasks config with their schedules, next run and last run",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "tasks"
+                ],
+                "summary": "List scheduled tasks",
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": true
+                        }
+                    },
+                    "403": {
+                        "description": "Forbidden",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": {
+                                "type": "string"
+                            }
+                        }
+                    },
+                    "500": {
+                        "description": "Internal Server Error",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": {
+                                "type": "string"
+                            }
+                        }
+                    },
+                    "503": {
+                        "description": "Service Unavailable",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": {
+                                "type": "string"
+                            }
+                        }
+                    }
+                }
+            }
+        },
+        "/tasks/{name}/run": {
+            "post": {
+                "security": [
+                    {
+                        "BearerAuth": []
+                    }
+                ],
+                "description": "Start a scheduled task now, whether or not it is enabled. The run is recorded and audited like scheduled runs.",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "tasks"
+                ],
+                "summary": "Run a task now",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Task name",
+                        "name": "name",
+                        "in": "path",
+                        "required": true
+                    }
+                ],
+                "responses": {
+                    "202": {
+                        "description": "Accepted",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": {
+                                "type": "string"
+                            }
+                        }
+                    },
+                    "403": {
+                        "description": "Forbidden",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": {
+                                "type": "string"
+                            }
+                        }
+                    },
+                    "404": {
+                        "description": "Not Found",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": {
+                                "type": "string"
+                            }
+                        }
+                    },
+                    "409": {
+                        "description": "Conflict",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": {
+                                "type": "string"
+                            }
+                        }
+                    },
+                    "503": {
+                        "description": "Service Unavailable",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": {
+                                "type": "string"
+                            }
+                        }
+                    }
+                }
+            }
+        },
+        "/tasks/{name}/runs": {
+            "get": {
+                "security": [
+                    {
+                        "BearerAuth": []
+                    }
+                ],
+                "description": "List the recorded runs of a scheduled task with their status and output, newest first",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "tasks"
+                ],
+                "summary": "List the runs of a task",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Task name",
+                        "name": "name",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "type": "integer",
+                        "description": "Maximum number of runs (default 20, maximum 500)",
+                        "name": "limit",
+                        "in": "query"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": true
+                        }
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": {
+                                "type": "string"
+                            }
+                        }
+                    },
+                    "403": {
+                        "description": "Forbidden",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": {
+                                "type": "string"
+                            }
+                        }
+                    },
+                    "500": {
+                        "description": "Internal Server Error",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": {
+                                "type": "string"
+                            }
+                        }
+                    }
+                }
+            }
+        },
+        "/transactions/{txid}/artifacts": {
+            "get": {
+                "security": [
+                    {
+                        "BearerAuth": []
+                    }
+                ],
+                "description": "List the generated artifacts (nftables ruleset, dnsmasq config, interface commands) a transaction pushed to the system, byte for byte, in the order they were pushed",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "transactions"
+                ],
+                "summary": "Get transaction artifacts",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Transaction ID",
+                        "name": "txid",
+                        "in": "path",
+                        "required": true
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/main.TransactionArtifacts"
+                        }
+                    },
+                    "404": {
+                        "description": "Not Found",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": {
+                                "type": "string"
+                            }
+                        }
+                    },
+                    "500": {
+                        "description": "Internal Server Error",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": {
+                                "type": "string"
+                            }
+                        }
+                    }
+                }
+            }
+        },
+        "/transactions/{txid}/timeline": {
+            "get": {
+                "security": [
+                    {
+                        "BearerAuth": []
+                    }
+                ],
+                "description": "Merge a transaction record, its audit entries and snapshot metadata into a chronological timeline with per-phase durations",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "transactions"
+                ],
+                "summary": "Get transaction timeline",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Transaction ID",
+                        "name": "txid",
+                        "in": "path",
+                        "required": true
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/main.TransactionTimeline"
+                        }
+                    },
+                    "404": {
+                        "description": "Not Found",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": {
+                                "type": "string"
+                            }
+                        }
+                    },
+                    "500": {
+                        "description": "Internal Server Error",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": {
+                                "type": "string"
+                            }
+                        }
+                    }
+                }
+            }
+        },
+        "/wan/quality": {
+            "get": {
+                "security": [
+                    {
+                        "BearerAuth": []
+                    }
+                ],
+                "description": "List WAN latency, jitter, loss and throughput samples, newest first, with the current state of each ping target",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "wan"
+                ],
+                "summary": "Get WAN quality history",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "How far back to list, as a duration (default 24h)",
+                        "name": "since",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "Only samples for this target",
+                        "name": "target",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "ping or speedtest",
+                        "name": "kind",
+                        "in": "query"
+                    },
+                    {
+                        "type": "integer",
+                        "description": "Maximum number of samples (default and maximum 5000)",
+                        "name": "limit",
+                        "in": "query"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/main.WANQualityResponse"
+                        }
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": {
+                                "type": "string"
+                            }
+                        }
+                    },
+                    "500": {
+                        "description": "Internal Server Error",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": {
+                                "type": "string"
+                            }
+                        }
+                    }
+                }
+            }
+        },
+        "/wireguard/keys": {
+            "post": {
+                "security": [
+                    {
+                        "BearerAuth": []
+                    }
+                ],
+                "description": "Generate a key pair, and a preshared key if asked, without storing them",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "wireguard"
+                ],
+                "summary": "Generate a WireGuard key pair",
+                "parameters": [
+                    {
+                        "type": "boolean",
+                        "description": "Also generate a preshared key",
+                        "name": "preshared",
+                        "in": "query"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/main.WireGuardKeysResponse"
+                        }
+                    },
+                    "403": {
+                        "description": "Forbidden",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": {
+                                "type": "string"
+                            }
+                        }
+                    },
+                    "500": {
+                        "description": "Internal Server Error",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": {
+                                "type": "string"
+                            }
+                        }
+                    }
+                }
+            }
+        },
+        "/wireguard/status": {
+            "get": {
+                "security": [
+                    {
+                        "BearerAuth": []
+                    }
+                ],
+                "description": "List the WireGuard interfaces of the network config and any others running, with each peer's endpoint, latest handshake and transfer counters",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "wireguard"
+                ],
+                "summary": "Get WireGuard status",
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/main.WireGuardStatusResponse"
+                        }
+                    },
+                    "500": {
+                        "description": "Internal Server Error",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": {
+                                "type": "string"
+                            }
+                        }
+                    }
+                }
+            }
+        },
+        "/wireguard/{interface}/peers/{peer}/config": {
+            "get": {
+                "security": [
+                    {
+                        "BearerAuth": []
+                    }
+                ],
+                "description": "Render the wg-quick config of a peer, including its private key, for importing into a client; mobile apps can scan it as a QR code. The peer needs a private key (generate its keys first); its allowed IPs become the client's addresses. With format=conf the config is returned as a file.",
+                "produces": [
+                    "application/json",
+                    "text/plain"
+                ],
+                "tags": [
+                    "wireguard"
+                ],
+                "summary": "Export a WireGuard client config",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "WireGuard interface",
+                        "name": "interface",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "type": "string",
+                        "description": "Peer",
+                        "name": "peer",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "type": "string",
+                        "description": "Host or host:port clients connect to; the port defaults to the interface's listen_port",
+                        "name": "endpoint",
+                        "in": "query",
+                        "required": true
+                    },
+                    {
+                        "type": "string",
+                        "description": "Comma-separated DNS servers for the client",
+                        "name": "dns",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "Comma-separated prefixes to route through the tunnel (default everything)",
+                        "name": "allowed_ips",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "json (default) or conf",
+                        "name": "format",
+                        "in": "query"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/main.WireGuardClientConfigResponse"
+                        }
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": {
+                                "type": "string"
+                            }
+                        }
+                    },
+                    "403": {
+                        "description": "Forbidden",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": {
+                                "type": "string"
+                            }
+                        }
+                    },
+                    "404": {
+                        "description": "Not Found",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": {
+                                "type": "string"
+                            }
+                        }
+                    },
+                    "500": {
+                        "description": "Internal Server Error",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": {
+                                "type": "string"
+                            }
+                        }
+                    }
+                }
+            }
+        },
+        "/wireguard/{interface}/peers/{peer}/keys": {
+            "post": {
+                "security": [
+                    {
+                        "BearerAuth": []
+                    }
+                ],
+                "description": "Generate a key pair for a peer and stage its private_key and public_key, and a preshared_key if asked, replacing any it had. The private key is encrypted when committed and only leaves the router in the peer's client config. The peer is a section name, description or @wireguard_\u003cinterface\u003e[index].",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "wireguard"
+                ],
+                "summary": "Generate keys for a WireGuard peer",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "WireGuard interface",
+                        "name": "interface",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "type": "string",
+                        "description": "Peer",
+                        "name": "peer",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "type": "boolean",
+                        "description": "Also generate a preshared key",
+                        "name": "preshared",
+                        "in": "query"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/main.WireGuardPeerKeysResponse"
+                        }
+                    },
+                    "403": {
+                        "description": "Forbidden",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": {
+                                "type": "string"
+                            }
+                        }
+                    },
+                    "404": {
+                        "description": "Not Found",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": {
+                                "type": "string"
+                            }
+                        }
+                    },
+                    "500": {
+                        "description": "Internal Server Error",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": {
+                                "type": "string"
+                            }
+                        }
+                    }
+                }
+            }
+        }
+    },
+    "definitions": {
+        "bus.EventType": {
+            "type": "string",
+            "enum": [
+                "config.changed",
+                "config.committed",
+                "config.reverted",
+                "config.generation",
+                "snapshot.created",
+                "transaction.started",
+                "transaction.completed",
+                "transaction.failed",
+                "transaction.progress",
+                "rollback.started",
+                "probe.failed",
+                "settings.reloaded",
+                "system.degraded",
+                "system.recovered",
+                "dhcp.address_changed",
+                "dhcp.lease_renewed",
+                "dhcp.pool_exhausting",
+                "dhcp.pool_recovered",
+                "quota.exceeded",
+                "quota.reset",
+                "wan.degraded",
+                "wan.recovered",
+                "cert.expiring",
+                "cert.expired",
+                "upgrade.succeeded",
+                "upgrade.failed",
+                "task.failed",
+                "auth.new_device",
+                "link.added",
+                "link.removed",
+                "link.up",
+                "link.down"
+            ],
+            "x-enum-varnames": [
+                "EventConfigChanged",
+                "EventConfigCommitted",
+                "EventConfigReverted",
+                "EventConfigGeneration",
+                "EventSnapshotCreated",
+                "EventTransactionStarted",
+                "EventTransactionCompleted",
+                "EventTransactionFailed",
+                "EventTransactionProgress",
+                "EventRollbackStarted",
+                "EventProbeFailed",
+                "EventSettingsReloaded",
+                "EventSystemDegraded",
+                "EventSystemRecovered",
+                "EventDHCPAddressChanged",
+                "EventDHCPLeaseRenewed",
+                "EventDHCPPoolExhausting",
+                "EventDHCPPoolRecovered",
+                "EventQuotaExceeded",
+                "EventQuotaReset",
+                "EventWANDegraded",
+                "EventWANRecovered",
+                "EventCertExpiring",
+                "EventCertExpired",
+                "EventUpgradeSucceeded",
+                "EventUpgradeFailed",
+                "EventTaskFailed",
+                "EventLoginNewDevice",
+                "EventLinkAdded",
+                "EventLinkRemoved",
+                "EventLinkUp",
+                "EventLinkDown"
+            ]
+        },
+        "bus.HandlerStats": {
+            "type": "object",
+            "properties": {
+                "blocked": {
+                    "type": "integer"
+                },
+                "configs": {
+                    "type": "array",
+                    "items": {
+                        "type": "string"
+                    }
+                },
+                "delivered": {
+                    "type": "integer"
+                },
+                "dropped": {
+                    "type": "integer"
+                },
+                "event": {
+                    "description": "Event type or pattern",
+                    "allOf": [
+                        {
+                            "$ref": "#/definitions/bus.EventType"
+                        }
+                    ]
+                },
+                "failed": {
+                    "type": "integer"
+                },
+                "name": {
+                    "type": "string"
+                },
+                "overflow": {
+                    "$ref": "#/definitions/bus.OverflowPolicy"
+                },
+                "queue_size": {
+                    "type": "integer"
+                },
+                "queued": {
+                    "type": "integer"
+                },
+                "spooled": {
+                    "type": "integer"
+                },
+                "sync": {
+                    "type": "boolean"
+                }
+            }
+        },
+        "bus.OverflowPolicy": {
+            "type": "string",
+            "enum": [
+                "block",
+                "drop",
+                "disk"
+            ],
+            "x-enum-varnames": [
+                "OverflowBlock",
+                "OverflowDrop",
+                "OverflowDisk"
+            ]
+        },
+        "bus.Stats": {
+            "type": "object",
+            "properties": {
+                "handlers": {
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/bus.HandlerStats"
+                    }
+                },
+                "published": {
+                    "type": "integer"
+                },
+                "watcher_dropped": {
+                    "type": "integer"
+                }
+            }
+        },
+        "config.Operation": {
+            "type": "object",
+            "properties": {
+                "op": {
+                    "description": "set, delete, add_list, del_list or add",
+                    "type": "string",
+                    "example": "set"
+                },
+                "path": {
+                    "description": "config.section[.option]; sections can be @type[index]",
+                    "type": "string",
+                    "example": "network.wan.ipaddr"
+                },
+                "value": {
+                    "type": "string",
+                    "example": "192.168.1.1"
+                }
+            }
+        },
+        "config.OptionInfo": {
+            "type": "object",
+            "properties": {
+                "default": {
+                    "type": "string"
+                },
+                "enum": {
+                    "type": "array",
+                    "items": {
+                        "type": "string"
+                    }
+                },
+                "help": {
+                    "type": "string"
+                },
+                "name": {
+                    "type": "string"
+                },
+                "secret": {
+                    "type": "boolean"
+                },
+                "type": {
+                    "type": "string",
+                    "enum": [
+                        "bool",
+                        "enum",
+                        "string"
+                    ]
+                }
+            }
+        },
+        "config.SectionInfo": {
+            "type": "object",
+            "properties": {
+                "options": {
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/config.OptionInfo"
+                    }
+                },
+                "type": {
+                    "type": "string"
+                }
+            }
+        },
+        "db.Certificate": {
+            "type": "object",
+            "properties": {
+                "created_at": {
+                    "type": "string"
+                },
+                "dns_names": {
+                    "type": "array",
+                    "items": {
+                        "type": "string"
+                    }
+                },
+                "error": {
+                    "description": "Why the file couldn't be read",
+                    "type": "string"
+                },
+                "fingerprint": {
+                    "description": "SHA-256, hex",
+                    "type": "string"
+                },
+                "id": {
+                    "type": "integer"
+                },
+                "is_ca": {
+                    "type": "boolean"
+                },
+                "issuer": {
+                    "type": "string"
+                },
+                "key_path": {
+                    "type": "string"
+                },
+                "last_checked": {
+                    "type": "string"
+                },
+                "name": {
+                    "type": "string"
+                },
+                "not_after": {
+                    "type": "string"
+                },
+                "not_before": {
+                    "type": "string"
+                },
+                "path": {
+                    "type": "string"
+                },
+                "serial": {
+                    "type": "string"
+                },
+                "subject": {
+                    "type": "string"
+                },
+                "updated_at": {
+                    "type": "string"
+                },
+                "usage": {
+                    "description": "e.g. \"grpc-server\", \"uploaded\"",
+                    "type": "string"
+                }
+            }
+        },
+        "db.ConfigLock": {
+            "type": "object",
+            "properties": {
+                "config": {
+                    "type": "string"
+                },
+                "created_at": {
+                    "type": "string"
+                },
+                "expires_at": {
+                    "type": "string"
+                },
+                "note": {
+                    "type": "string"
+                },
+                "username": {
+                    "type": "string"
+                }
+            }
+        },
+        "db.Role": {
+            "type": "string",
+            "enum": [
+                "admin",
+                "operator",
+                "viewer"
+            ],
+            "x-enum-comments": {
+                "RoleAdmin": "Full access",
+                "RoleOperator": "Read + write (no user management)",
+                "RoleViewer": "Read-only"
+            },
+            "x-enum-descriptions": [
+                "Full access",
+                "Read + write (no user management)",
+                "Read-only"
+            ],
+            "x-enum-varnames": [
+                "RoleAdmin",
+                "RoleOperator",
+                "RoleViewer"
+            ]
+        },
+        "db.Transaction": {
+            "type": "object",
+            "properties": {
+                "canary": {
+                    "description": "Staged deployment: the canary router the changes were applied to first,\nits transaction and how it ended there",
+                    "type": "string"
+                },
+                "canary_status": {
+                    "description": "\"applied\", \"confirmed\", \"rolledback\" or \"failed\"",
+                    "type": "string"
+                },
+                "canary_transaction_id": {
+                    "type": "string"
+                },
+                "completed_at": {
+                    "type": "string"
+                },
+                "configs": {
+                    "description": "JSON array of changed configs",
+                    "type": "string"
+                },
+                "confirmed_at": {
+                    "type": "string"
+                },
+                "created_at": {
+                    "type": "string"
+                },
+                "error": {
+                    "type": "string"
+                },
+                "failed_applier": {
+                    "type": "string"
+                },
+                "id": {
+                    "type": "integer"
+                },
+                "message": {
+                    "type": "string"
+                },
+                "phases": {
+                    "description": "JSON object of phase name -\u003e duration in ms",
+                    "type": "string"
+                },
+                "rollback_reason": {
+                    "description": "Why the transaction was rolled back: the reason a user gave, or the\nerror that triggered it and the applier that failed, if any",
+                    "type": "string"
+                },
+                "rolled_back_at": {
+                    "type": "string"
+                },
+                "snapshot_id": {
+                    "type": "string"
+                },
+                "status": {
+                    "description": "\"pending\", \"committed\", \"failed\", \"rolledback\"",
+                    "type": "string"
+                },
+                "transaction_id": {
+                    "description": "Unique transaction ID",
+                    "type": "string"
+                },
+                "updated_at": {
+                    "type": "string"
+                },
+                "user": {
+                    "$ref": "#/definitions/db.User"
+                },
+                "user_id": {
+                    "type": "integer"
+                },
+                "username": {
+                    "description": "Denormalized",
+                    "type": "string"
+                }
+            }
+        },
+        "db.TransactionArtifact": {
+            "type": "object",
+            "properties": {
+                "config": {
+                    "description": "Config (and applier) that generated it",
+                    "type": "string"
+                },
+                "content": {
+                    "type": "string"
+                },
+                "created_at": {
+                    "type": "string"
+                },
+                "id": {
+                    "type": "integer"
+                },
+                "name": {
+                    "description": "Artifact name, e.g. a file path or \"nftables ruleset\"",
+                    "type": "string"
+                },
+                "sha256": {
+                    "description": "Hex SHA-256 of Content",
+                    "type": "string"
+                },
+                "stage": {
+                    "description": "\"apply\", or \"rollback\" when re-applied to undo the transaction",
+                    "type": "string"
+                },
+                "transaction_id": {
+                    "type": "string"
+                }
+            }
+        },
+        "db.User": {
+            "type": "object",
+            "properties": {
+                "created_at": {
+                    "type": "string"
+                },
+                "email": {
+                    "type": "string"
+                },
+                "enabled": {
+                    "type": "boolean"
+                },
+                "id": {
+                    "type": "integer"
+                },
+                "last_login_at": {
+                    "type": "string"
+                },
+                "role": {
+                    "$ref": "#/definitions/db.Role"
+                },
+                "updated_at": {
+                    "type": "string"
+                },
+                "username": {
+                    "type": "string"
+                }
+            }
+        },
+        "db.WANQualitySample": {
+            "type": "object",
+            "properties": {
+                "created_at": {
+                    "type": "string"
+                },
+                "degraded": {
+                    "description": "Over one of the configured thresholds",
+                    "type": "boolean"
+                },
+                "download_mbps": {
+                    "type": "number"
+                },
+                "error": {
+                    "type": "string"
+                },
+                "id": {
+                    "type": "integer"
+                },
+                "jitter_ms": {
+                    "description": "Mean deviation of the round trips",
+                    "type": "number"
+                },
+                "kind": {
+                    "description": "\"ping\" or \"speedtest\"",
+                    "type": "string"
+                },
+                "latency_ms": {
+                    "description": "Average round trip",
+                    "type": "number"
+                },
+                "loss_percent": {
+                    "type": "number"
+                },
+                "target": {
+                    "description": "Pinged host or test server",
+                    "type": "string"
+                },
+                "upload_mbps": {
+                    "type": "number"
+                }
+            }
+        },
+        "dhcp.Usage": {
+            "type": "object",
+            "properties": {
+                "end": {
+                    "type": "string"
+                },
+                "exhausting": {
+                    "description": "At or over its warn_percent",
+                    "type": "boolean"
+                },
+                "interface": {
+                    "type": "string"
+                },
+                "percent": {
+                    "type": "number"
+                },
+                "section": {
+                    "type": "string"
+                },
+                "size": {
+                    "type": "integer"
+                },
+                "start": {
+                    "type": "string"
+                },
+                "used": {
+                    "type": "integer"
+                },
+                "warn_percent": {
+                    "type": "integer"
+                }
+            }
+        },
+        "dns.Count": {
+            "type": "object",
+            "properties": {
+                "name": {
+                    "type": "string"
+                },
+                "queries": {
+                    "type": "integer"
+                }
+            }
+        },
+        "dns.Host": {
+            "type": "object",
+            "properties": {
+                "address": {
+                    "type": "string"
+                },
+                "id": {
+                    "description": "Record or static lease ID",
+                    "type": "string"
+                },
+                "lease": {
+                    "description": "Registered from a static lease; managed with the lease",
+                    "type": "boolean"
+                },
+                "names": {
+                    "type": "array",
+                    "items": {
+                        "type": "string"
+                    }
+                }
+            }
+        },
+        "dns.Record": {
+            "type": "object",
+            "properties": {
+                "id": {
+                    "description": "Section name, or @domain[index] / @cname[index]",
+                    "type": "string"
+                },
+                "name": {
+                    "type": "string"
+                },
+                "type": {
+                    "type": "string"
+                },
+                "value": {
+                    "description": "Address (A, AAAA) or target name (CNAME)",
+                    "type": "string"
+                }
+            }
+        },
+        "dns.Server": {
+            "type": "object",
+            "properties": {
+                "address": {
+                    "description": "Empty for domains answered locally only",
+                    "type": "string"
+                },
+                "domains": {
+                    "type": "array",
+                    "items": {
+                        "type": "string"
+                    }
+                },
+                "port": {
+                    "type": "integer"
+                }
+            }
+        },
+        "graphql.Error": {
+            "type": "object",
+            "properties": {
+                "locations": {
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/graphql.Location"
+                    }
+                },
+                "message": {
+                    "type": "string"
+                },
+                "path": {
+                    "type": "array",
+                    "items": {
+                        "type": "string"
+                    }
+                }
+            }
+        },
+        "graphql.Location": {
+            "type": "object",
+            "properties": {
+                "column": {
+                    "type": "integer"
+                },
+                "line": {
+                    "type": "integer"
+                }
+            }
+        },
+        "graphql.Request": {
+            "type": "object",
+            "properties": {
+                "operationName": {
+                    "type": "string"
+                },
+                "query": {
+                    "type": "string",
+                    "example": "{ transactions(limit: 5) { transaction_id status snapshot { id } audit { action status } } }"
+                },
+                "variables": {
+                    "type": "object",
+                    "additionalProperties": {}
+                }
+            }
+        },
+        "graphql.Response": {
+            "type": "object",
+            "properties": {
+                "data": {
+                    "type": "object"
+                },
+                "errors": {
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/graphql.Error"
+                    }
+                }
+            }
+        },
+        "health.Check": {
+            "type": "object",
+            "properties": {
+                "message": {
+                    "type": "string"
+                },
+                "name": {
+                    "type": "string"
+                },
+                "score": {
+                    "description": "0 (failing) to 1 (healthy)",
+                    "type": "number"
+                },
+                "weight": {
+                    "type": "number"
+                }
+            }
+        },
+        "health.Report": {
+            "type": "object",
+            "properties": {
+                "checks": {
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/health.Check"
+                    }
+                },
+                "score": {
+                    "description": "0 to 100",
+                    "type": "integer"
+                },
+                "skipped": {
+                    "description": "Checks without data (e.g. WAN monitoring disabled), left out of the score",
+                    "type": "array",
+                    "items": {
+                        "type": "string"
+                    }
+                },
+                "status": {
+                    "type": "string"
+                },
+                "time": {
+                    "type": "string"
+                }
+            }
+        },
+        "hotplug.Event": {
+            "type": "object",
+            "properties": {
+                "carrier": {
+                    "type": "boolean"
+                },
+                "interface": {
+                    "type": "string"
+                },
+                "time": {
+                    "type": "string"
+                },
+                "type": {
+                    "$ref": "#/definitions/hotplug.EventType"
+                },
+                "up": {
+                    "type": "boolean"
+                }
+            }
+        },
+        "hotplug.EventType": {
+            "type": "string",
+            "enum": [
+                "added",
+                "removed",
+                "up",
+                "down"
+            ],
             "x-enum-comments": {
-                "RoleAdmin": "Full access",
-                "RoleOperator": "Read + write (no user management)",
-                "RoleViewer": "Read-only"
+                "EventAdded": "The interface appeared",
+                "EventDown": "The link was lost (cable unplugged)",
+                "EventRemoved": "The interface disappeared",
+                "EventUp": "The link was detected (cable plugged in)"
             },
+            "x-enum-descriptions": [
+                "The interface appeared",
+                "The interface disappeared",
+                "The link was detected (cable plugged in)",
+                "The link was lost (cable unplugged)"
+            ],
             "x-enum-varnames": [
-                "RoleAdmin",
-                "RoleOperator",
-                "RoleViewer"
+                "EventAdded",
+                "EventRemoved",
+                "EventUp",
+                "EventDown"
             ]
         },
-        "db.User": {
+        "hotplug.Link": {
+            "type": "object",
+            "properties": {
+                "carrier": {
+                    "description": "Link detected",
+                    "type": "boolean"
+                },
+                "index": {
+                    "type": "integer"
+                },
+                "name": {
+                    "type": "string"
+                },
+                "up": {
+                    "description": "Administratively up",
+                    "type": "boolean"
+                }
+            }
+        },
+        "main.ActionCounts": {
+            "type": "object",
+            "properties": {
+                "failure": {
+                    "type": "integer"
+                },
+                "success": {
+                    "type": "integer"
+                }
+            }
+        },
+        "main.ApplyOrderRequest": {
+            "type": "object",
+            "required": [
+                "order"
+            ],
+            "properties": {
+                "order": {
+                    "type": "array",
+                    "items": {
+                        "type": "string"
+                    },
+                    "example": [
+                        "network",
+                        "firewall",
+                        "dhcp"
+                    ]
+                },
+                "skip": {
+                    "type": "array",
+                    "items": {
+                        "type": "string"
+                    },
+                    "example": [
+                        "hellfire"
+                    ]
+                }
+            }
+        },
+        "main.BatchRequest": {
+            "type": "object",
+            "required": [
+                "operations"
+            ],
+            "properties": {
+                "operations": {
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/config.Operation"
+                    }
+                },
+                "revisions": {
+                    "description": "ETags of configs as read, by name; nothing is staged if one changed since",
+                    "type": "object",
+                    "additionalProperties": {
+                        "type": "string"
+                    }
+                }
+            }
+        },
+        "main.CommitRequest": {
+            "type": "object",
+            "properties": {
+                "confirm_timeout": {
+                    "description": "Seconds (0 = no confirmation required)",
+                    "type": "integer",
+                    "example": 60
+                },
+                "dry_run": {
+                    "description": "Only report what the commit would do",
+                    "type": "boolean",
+                    "example": false
+                },
+                "message": {
+                    "type": "string",
+                    "example": "Change WAN address"
+                },
+                "safe_remote": {
+                    "description": "Roll back unless the client keeps sending keepalives after the apply",
+                    "type": "boolean",
+                    "example": false
+                },
+                "skip_canary": {
+                    "description": "Apply to this router only, without the canary (e.g. while it is down)",
+                    "type": "boolean",
+                    "example": false
+                }
+            }
+        },
+        "main.ConfigGenerationResponse": {
+            "type": "object",
+            "properties": {
+                "generation": {
+                    "type": "integer",
+                    "example": 42
+                }
+            }
+        },
+        "main.ConfigSchemaResponse": {
+            "type": "object",
+            "properties": {
+                "config": {
+                    "type": "string",
+                    "example": "firewall"
+                },
+                "sections": {
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/config.SectionInfo"
+                    }
+                }
+            }
+        },
+        "main.DHCPPoolsResponse": {
+            "type": "object",
+            "properties": {
+                "pools": {
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/dhcp.Usage"
+                    }
+                }
+            }
+        },
+        "main.DNSHostRequest": {
+            "type": "object",
+            "required": [
+                "address",
+                "name"
+            ],
+            "properties": {
+                "address": {
+                    "type": "string"
+                },
+                "name": {
+                    "type": "string"
+                }
+            }
+        },
+        "main.DNSHostsResponse": {
+            "type": "object",
+            "properties": {
+                "domain": {
+                    "description": "Local domain static leases are registered under",
+                    "type": "string"
+                },
+                "hosts": {
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/dns.Host"
+                    }
+                }
+            }
+        },
+        "main.DNSRecordRequest": {
+            "type": "object",
+            "required": [
+                "name",
+                "type",
+                "value"
+            ],
+            "properties": {
+                "name": {
+                    "type": "string"
+                },
+                "type": {
+                    "description": "A, AAAA or CNAME",
+                    "type": "string"
+                },
+                "value": {
+                    "description": "Address, or target name of a CNAME",
+                    "type": "string"
+                }
+            }
+        },
+        "main.DNSRecordResponse": {
+            "type": "object",
+            "properties": {
+                "message": {
+                    "type": "string"
+                },
+                "record": {
+                    "$ref": "#/definitions/dns.Record"
+                }
+            }
+        },
+        "main.DNSRecordsResponse": {
+            "type": "object",
+            "properties": {
+                "records": {
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/dns.Record"
+                    }
+                }
+            }
+        },
+        "main.DNSResponse": {
+            "type": "object",
+            "properties": {
+                "local_zones": {
+                    "description": "Zones answered from local records only",
+                    "type": "array",
+                    "items": {
+                        "type": "string"
+                    }
+                },
+                "records": {
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/dns.Record"
+                    }
+                },
+                "servers": {
+                    "description": "Upstream servers, including conditional forwarders",
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/dns.Server"
+                    }
+                }
+            }
+        },
+        "main.DNSStatsResponse": {
+            "type": "object",
+            "properties": {
+                "blocked": {
+                    "type": "integer"
+                },
+                "collecting": {
+                    "description": "Whether the syslog collector receives them",
+                    "type": "boolean"
+                },
+                "enabled": {
+                    "description": "Whether the committed dhcp config logs queries",
+                    "type": "boolean"
+                },
+                "since": {
+                    "description": "Oldest query counted",
+                    "type": "string"
+                },
+                "top_blocked": {
+                    "description": "Blocked domains",
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/dns.Count"
+                    }
+                },
+                "top_blocked_clients": {
+                    "description": "Clients by blocked queries",
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/dns.Count"
+                    }
+                },
+                "top_clients": {
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/dns.Count"
+                    }
+                },
+                "top_domains": {
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/dns.Count"
+                    }
+                },
+                "total": {
+                    "type": "integer"
+                }
+            }
+        },
+        "main.DailyTransactions": {
+            "type": "object",
+            "properties": {
+                "day": {
+                    "description": "UTC",
+                    "type": "string",
+                    "example": "2025-01-31"
+                },
+                "status": {
+                    "type": "object",
+                    "additionalProperties": {
+                        "type": "integer",
+                        "format": "int64"
+                    }
+                },
+                "total": {
+                    "type": "integer"
+                }
+            }
+        },
+        "main.FileSystemUsage": {
+            "type": "object",
+            "properties": {
+                "available": {
+                    "type": "integer"
+                },
+                "path": {
+                    "description": "Directory on the file system",
+                    "type": "string"
+                },
+                "total": {
+                    "type": "integer"
+                }
+            }
+        },
+        "main.ImpersonateResponse": {
+            "type": "object",
+            "properties": {
+                "expires_at": {
+                    "type": "string"
+                },
+                "impersonator": {
+                    "description": "The admin",
+                    "allOf": [
+                        {
+                            "$ref": "#/definitions/db.User"
+                        }
+                    ]
+                },
+                "token": {
+                    "type": "string"
+                },
+                "user": {
+                    "description": "The impersonated user",
+                    "allOf": [
+                        {
+                            "$ref": "#/definitions/db.User"
+                        }
+                    ]
+                }
+            }
+        },
+        "main.KnownDeviceResponse": {
+            "type": "object",
+            "properties": {
+                "created_at": {
+                    "description": "First login from the device",
+                    "type": "string"
+                },
+                "current": {
+                    "description": "The device of the requesting session",
+                    "type": "boolean"
+                },
+                "fingerprint": {
+                    "description": "SHA256(IP + UA), as in Session",
+                    "type": "string"
+                },
+                "id": {
+                    "type": "integer"
+                },
+                "ip_address": {
+                    "type": "string"
+                },
+                "last_seen_at": {
+                    "description": "Last login from the device",
+                    "type": "string"
+                },
+                "user_agent": {
+                    "type": "string"
+                },
+                "user_id": {
+                    "type": "integer"
+                }
+            }
+        },
+        "main.LinksResponse": {
+            "type": "object",
+            "properties": {
+                "enabled": {
+                    "description": "Links are watched (not on a simulated system)",
+                    "type": "boolean"
+                },
+                "events": {
+                    "description": "Newest first",
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/hotplug.Event"
+                    }
+                },
+                "links": {
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/hotplug.Link"
+                    }
+                }
+            }
+        },
+        "main.LockRequest": {
+            "type": "object",
+            "properties": {
+                "note": {
+                    "type": "string",
+                    "example": "Reworking the guest zone"
+                },
+                "ttl": {
+                    "description": "Seconds until the lock expires unless renewed (default 1800, maximum 28800)",
+                    "type": "integer",
+                    "example": 1800
+                }
+            }
+        },
+        "main.LogsResponse": {
+            "type": "object",
+            "properties": {
+                "enabled": {
+                    "type": "boolean"
+                },
+                "entries": {
+                    "description": "Newest first",
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/syslog.Entry"
+                    }
+                }
+            }
+        },
+        "main.ModemsResponse": {
+            "type": "object",
+            "properties": {
+                "available": {
+                    "description": "ModemManager is installed",
+                    "type": "boolean"
+                },
+                "missing": {
+                    "description": "Modem interfaces whose modem isn't plugged in",
+                    "type": "array",
+                    "items": {
+                        "type": "string"
+                    }
+                },
+                "modems": {
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/modem.Modem"
+                    }
+                }
+            }
+        },
+        "main.NetworkDevice": {
+            "type": "object",
+            "properties": {
+                "addresses": {
+                    "description": "CIDR",
+                    "type": "array",
+                    "items": {
+                        "type": "string"
+                    }
+                },
+                "carrier": {
+                    "description": "Link detected",
+                    "type": "boolean"
+                },
+                "default_route": {
+                    "description": "Carries the IPv4 default route",
+                    "type": "boolean"
+                },
+                "driver": {
+                    "type": "string"
+                },
+                "kind": {
+                    "type": "string"
+                },
+                "mac": {
+                    "type": "string"
+                },
+                "managed": {
+                    "description": "Configured by an interface section",
+                    "type": "boolean"
+                },
+                "mtu": {
+                    "type": "integer"
+                },
+                "name": {
+                    "type": "string"
+                },
+                "physical": {
+                    "description": "Backed by a device (NIC, radio)",
+                    "type": "boolean"
+                },
+                "proto": {
+                    "description": "The section's proto",
+                    "type": "string"
+                },
+                "speed_mbps": {
+                    "description": "0 when unknown",
+                    "type": "integer"
+                },
+                "up": {
+                    "description": "Administratively up",
+                    "type": "boolean"
+                }
+            }
+        },
+        "main.OnboardingNetworkRequest": {
+            "type": "object",
+            "properties": {
+                "confirm_timeout": {
+                    "description": "Seconds, default 120",
+                    "type": "integer"
+                },
+                "dhcp": {
+                    "$ref": "#/definitions/onboarding.DHCP"
+                },
+                "lan": {
+                    "$ref": "#/definitions/onboarding.LAN"
+                },
+                "wan": {
+                    "$ref": "#/definitions/onboarding.WAN"
+                },
+                "wifi": {
+                    "$ref": "#/definitions/onboarding.WiFi"
+                }
+            }
+        },
+        "main.OnboardingNetworkResponse": {
+            "type": "object",
+            "properties": {
+                "interfaces": {
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/netdetect.Interface"
+                    }
+                },
+                "proposal": {
+                    "$ref": "#/definitions/onboarding.Setup"
+                },
+                "wireless_applied": {
+                    "description": "Whether a Wi-Fi setup takes effect",
+                    "type": "boolean"
+                }
+            }
+        },
+        "main.OnboardingPreviewResponse": {
+            "type": "object",
+            "properties": {
+                "configs": {
+                    "description": "Name to UCI text",
+                    "type": "object",
+                    "additionalProperties": {
+                        "type": "string"
+                    }
+                },
+                "notes": {
+                    "type": "array",
+                    "items": {
+                        "type": "string"
+                    }
+                }
+            }
+        },
+        "main.RegisterPluginRequest": {
+            "type": "object",
+            "required": [
+                "path"
+            ],
+            "properties": {
+                "description": {
+                    "description": "One line for hf --help",
+                    "type": "string",
+                    "example": "Manage the VPN daemon"
+                },
+                "path": {
+                    "description": "Absolute path of the executable, modifiable by its owner only",
+                    "type": "string",
+                    "example": "/usr/lib/hellfire-vpnd/hf-vpn"
+                }
+            }
+        },
+        "main.RollbackRequest": {
+            "type": "object",
+            "properties": {
+                "reason": {
+                    "description": "Recorded on the transaction; required with the transaction's require_rollback_reason option",
+                    "type": "string",
+                    "example": "Guests lost DNS"
+                }
+            }
+        },
+        "main.RotateCertificateRequest": {
+            "type": "object",
+            "required": [
+                "certificate"
+            ],
+            "properties": {
+                "certificate": {
+                    "description": "PEM, leaf first",
+                    "type": "string"
+                },
+                "private_key": {
+                    "description": "PEM; omitted for CA bundles",
+                    "type": "string"
+                }
+            }
+        },
+        "main.SetOptionRequest": {
+            "type": "object",
+            "required": [
+                "value"
+            ],
+            "properties": {
+                "value": {
+                    "type": "string",
+                    "example": "192.168.1.1"
+                }
+            }
+        },
+        "main.StatsSummary": {
+            "type": "object",
+            "properties": {
+                "audit_actions": {
+                    "type": "object",
+                    "additionalProperties": {
+                        "$ref": "#/definitions/main.ActionCounts"
+                    }
+                },
+                "days": {
+                    "type": "integer"
+                },
+                "failed_logins": {
+                    "type": "integer"
+                },
+                "from": {
+                    "description": "Start of the first day (UTC)",
+                    "type": "string"
+                },
+                "rollbacks": {
+                    "type": "integer"
+                },
+                "transaction_status": {
+                    "type": "object",
+                    "additionalProperties": {
+                        "type": "integer",
+                        "format": "int64"
+                    }
+                },
+                "transactions": {
+                    "description": "Every day, oldest first",
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/main.DailyTransactions"
+                    }
+                }
+            }
+        },
+        "main.StorageResponse": {
+            "type": "object",
+            "properties": {
+                "filesystems": {
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/main.FileSystemUsage"
+                    }
+                },
+                "snapshots": {
+                    "$ref": "#/definitions/snapshot.Storage"
+                }
+            }
+        },
+        "main.TimelineEvent": {
+            "type": "object",
+            "properties": {
+                "action": {
+                    "type": "string"
+                },
+                "error": {
+                    "type": "string"
+                },
+                "message": {
+                    "type": "string"
+                },
+                "source": {
+                    "description": "\"transaction\", \"snapshot\" or \"audit\"",
+                    "type": "string"
+                },
+                "status": {
+                    "type": "string"
+                },
+                "time": {
+                    "type": "string"
+                },
+                "username": {
+                    "type": "string"
+                }
+            }
+        },
+        "main.TransactionArtifacts": {
+            "type": "object",
+            "properties": {
+                "artifacts": {
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/db.TransactionArtifact"
+                    }
+                },
+                "transaction_id": {
+                    "type": "string"
+                }
+            }
+        },
+        "main.TransactionTimeline": {
+            "type": "object",
+            "properties": {
+                "duration_ms": {
+                    "description": "From start until the transaction settled",
+                    "type": "integer"
+                },
+                "events": {
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/main.TimelineEvent"
+                    }
+                },
+                "phases": {
+                    "description": "Phase name -\u003e duration in ms",
+                    "type": "object",
+                    "additionalProperties": {
+                        "type": "integer",
+                        "format": "int64"
+                    }
+                },
+                "snapshot": {
+                    "$ref": "#/definitions/snapshot.Metadata"
+                },
+                "transaction": {
+                    "$ref": "#/definitions/db.Transaction"
+                }
+            }
+        },
+        "main.UpgradeRequest": {
+            "type": "object",
+            "properties": {
+                "image": {
+                    "description": "image: path of the .deb on the router",
+                    "type": "string"
+                },
+                "message": {
+                    "description": "Note recorded with the upgrade",
+                    "type": "string"
+                },
+                "method": {
+                    "description": "apt or image",
+                    "type": "string",
+                    "example": "apt"
+                },
+                "packages": {
+                    "description": "apt: packages to upgrade; empty upgrades the whole system",
+                    "type": "array",
+                    "items": {
+                        "type": "string"
+                    }
+                },
+                "public_key": {
+                    "description": "image: public key file",
+                    "type": "string"
+                },
+                "signature": {
+                    "description": "image: signature file (default \u003cimage\u003e.sig)",
+                    "type": "string"
+                }
+            }
+        },
+        "main.UploadCertificateRequest": {
+            "type": "object",
+            "required": [
+                "certificate",
+                "name",
+                "private_key"
+            ],
+            "properties": {
+                "certificate": {
+                    "description": "PEM, leaf first",
+                    "type": "string"
+                },
+                "name": {
+                    "type": "string"
+                },
+                "private_key": {
+                    "description": "PEM",
+                    "type": "string"
+                }
+            }
+        },
+        "main.WANQualityResponse": {
+            "type": "object",
+            "properties": {
+                "enabled": {
+                    "type": "boolean"
+                },
+                "samples": {
+                    "description": "Newest first",
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/db.WANQualitySample"
+                    }
+                },
+                "targets": {
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/wanquality.TargetStatus"
+                    }
+                }
+            }
+        },
+        "main.WireGuardClientConfigResponse": {
+            "type": "object",
+            "properties": {
+                "config": {
+                    "type": "string"
+                },
+                "filename": {
+                    "type": "string"
+                }
+            }
+        },
+        "main.WireGuardKeysResponse": {
+            "type": "object",
+            "properties": {
+                "preshared_key": {
+                    "type": "string"
+                },
+                "private_key": {
+                    "type": "string"
+                },
+                "public_key": {
+                    "type": "string"
+                }
+            }
+        },
+        "main.WireGuardPeerKeysResponse": {
+            "type": "object",
+            "properties": {
+                "message": {
+                    "type": "string"
+                },
+                "peer": {
+                    "description": "Section of the peer",
+                    "type": "string"
+                },
+                "public_key": {
+                    "type": "string"
+                }
+            }
+        },
+        "main.WireGuardStatusResponse": {
+            "type": "object",
+            "properties": {
+                "available": {
+                    "description": "Whether the wg tool is installed; without it every interface is down",
+                    "type": "boolean"
+                },
+                "interfaces": {
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/wireguard.Interface"
+                    }
+                }
+            }
+        },
+        "main.changePasswordRequest": {
+            "type": "object",
+            "required": [
+                "current_password",
+                "new_password"
+            ],
+            "properties": {
+                "current_password": {
+                    "type": "string"
+                },
+                "new_password": {
+                    "type": "string"
+                }
+            }
+        },
+        "main.impersonateRequest": {
+            "type": "object",
+            "required": [
+                "username"
+            ],
+            "properties": {
+                "duration": {
+                    "description": "Seconds, default 900, at most 3600",
+                    "type": "integer"
+                },
+                "username": {
+                    "type": "string"
+                }
+            }
+        },
+        "main.loginRequest": {
+            "type": "object",
+            "required": [
+                "password",
+                "username"
+            ],
+            "properties": {
+                "password": {
+                    "type": "string"
+                },
+                "username": {
+                    "type": "string"
+                }
+            }
+        },
+        "main.loginResponse": {
+            "type": "object",
+            "properties": {
+                "expires_at": {
+                    "type": "string"
+                },
+                "token": {
+                    "type": "string"
+                },
+                "user": {
+                    "$ref": "#/definitions/db.User"
+                }
+            }
+        },
+        "main.onboardingRequest": {
+            "type": "object",
+            "required": [
+                "email",
+                "name",
+                "password",
+                "token"
+            ],
+            "properties": {
+                "email": {
+                    "type": "string"
+                },
+                "name": {
+                    "type": "string"
+                },
+                "password": {
+                    "type": "string",
+                    "minLength": 8
+                },
+                "token": {
+                    "description": "One-time token printed by hf serve",
+                    "type": "string"
+                }
+            }
+        },
+        "main.updateProfileRequest": {
+            "type": "object",
+            "required": [
+                "email"
+            ],
+            "properties": {
+                "email": {
+                    "type": "string"
+                }
+            }
+        },
+        "modem.Bearer": {
+            "type": "object",
+            "properties": {
+                "connected": {
+                    "type": "boolean"
+                },
+                "interface": {
+                    "description": "Network device carrying it",
+                    "type": "string"
+                },
+                "ipv4": {
+                    "$ref": "#/definitions/modem.IPConfig"
+                },
+                "ipv6": {
+                    "$ref": "#/definitions/modem.IPConfig"
+                },
+                "path": {
+                    "type": "string"
+                }
+            }
+        },
+        "modem.IPConfig": {
+            "type": "object",
+            "properties": {
+                "address": {
+                    "type": "string"
+                },
+                "dns": {
+                    "type": "array",
+                    "items": {
+                        "type": "string"
+                    }
+                },
+                "gateway": {
+                    "type": "string"
+                },
+                "method": {
+                    "description": "static, dhcp or ppp",
+                    "type": "string"
+                },
+                "mtu": {
+                    "type": "integer"
+                },
+                "prefix": {
+                    "type": "integer"
+                }
+            }
+        },
+        "modem.Modem": {
+            "type": "object",
+            "properties": {
+                "access_technologies": {
+                    "type": "array",
+                    "items": {
+                        "type": "string"
+                    }
+                },
+                "bearer": {
+                    "description": "The connected bearer",
+                    "allOf": [
+                        {
+                            "$ref": "#/definitions/modem.Bearer"
+                        }
+                    ]
+                },
+                "device": {
+                    "description": "Sysfs path",
+                    "type": "string"
+                },
+                "failed_reason": {
+                    "type": "string"
+                },
+                "interface": {
+                    "description": "Configured interface section, if any",
+                    "type": "string"
+                },
+                "manufacturer": {
+                    "type": "string"
+                },
+                "model": {
+                    "type": "string"
+                },
+                "net_device": {
+                    "description": "The first net port",
+                    "type": "string"
+                },
+                "operator": {
+                    "type": "string"
+                },
+                "path": {
+                    "description": "D-Bus path, which mmcli -m takes",
+                    "type": "string"
+                },
+                "ports": {
+                    "description": "\"cdc-wdm0 (qmi)\", \"wwan0 (net)\"...",
+                    "type": "array",
+                    "items": {
+                        "type": "string"
+                    }
+                },
+                "registration": {
+                    "description": "home, roaming, searching, denied...",
+                    "type": "string"
+                },
+                "roaming": {
+                    "type": "boolean"
+                },
+                "signal_quality": {
+                    "description": "Percent",
+                    "type": "integer"
+                },
+                "state": {
+                    "description": "disabled, registered, connected...",
+                    "type": "string"
+                },
+                "unlock_required": {
+                    "description": "sim-pin, sim-puk...",
+                    "type": "string"
+                }
+            }
+        },
+        "netdetect.Interface": {
+            "type": "object",
+            "properties": {
+                "addresses": {
+                    "description": "CIDR",
+                    "type": "array",
+                    "items": {
+                        "type": "string"
+                    }
+                },
+                "carrier": {
+                    "description": "Link detected",
+                    "type": "boolean"
+                },
+                "default_route": {
+                    "description": "Carries the IPv4 default route",
+                    "type": "boolean"
+                },
+                "driver": {
+                    "type": "string"
+                },
+                "kind": {
+                    "type": "string"
+                },
+                "mac": {
+                    "type": "string"
+                },
+                "mtu": {
+                    "type": "integer"
+                },
+                "name": {
+                    "type": "string"
+                },
+                "physical": {
+                    "description": "Backed by a device (NIC, radio)",
+                    "type": "boolean"
+                },
+                "speed_mbps": {
+                    "description": "0 when unknown",
+                    "type": "integer"
+                },
+                "up": {
+                    "description": "Administratively up",
+                    "type": "boolean"
+                }
+            }
+        },
+        "onboarding.DHCP": {
             "type": "object",
             "properties": {
-                "created_at": {
+                "enabled": {
+                    "type": "boolean"
+                },
+                "leasetime": {
                     "type": "string"
                 },
-                "email": {
+                "limit": {
+                    "description": "Number of addresses",
+                    "type": "integer"
+                },
+                "start": {
+                    "description": "Offset of the first address in the LAN subnet",
+                    "type": "integer"
+                }
+            }
+        },
+        "onboarding.LAN": {
+            "type": "object",
+            "properties": {
+                "interface": {
                     "type": "string"
                 },
-                "enabled": {
+                "ipaddr": {
+                    "type": "string"
+                },
+                "netmask": {
+                    "type": "string"
+                }
+            }
+        },
+        "onboarding.Setup": {
+            "type": "object",
+            "properties": {
+                "dhcp": {
+                    "$ref": "#/definitions/onboarding.DHCP"
+                },
+                "lan": {
+                    "$ref": "#/definitions/onboarding.LAN"
+                },
+                "wan": {
+                    "$ref": "#/definitions/onboarding.WAN"
+                },
+                "wifi": {
+                    "$ref": "#/definitions/onboarding.WiFi"
+                }
+            }
+        },
+        "onboarding.WAN": {
+            "type": "object",
+            "properties": {
+                "dns": {
+                    "type": "array",
+                    "items": {
+                        "type": "string"
+                    }
+                },
+                "gateway": {
+                    "type": "string"
+                },
+                "interface": {
+                    "type": "string"
+                },
+                "ipaddr": {
+                    "type": "string"
+                },
+                "netmask": {
+                    "type": "string"
+                },
+                "proto": {
+                    "description": "dhcp or static",
+                    "type": "string"
+                }
+            }
+        },
+        "onboarding.WiFi": {
+            "type": "object",
+            "properties": {
+                "interface": {
+                    "type": "string"
+                },
+                "key": {
+                    "description": "WPA2 passphrase",
+                    "type": "string"
+                },
+                "ssid": {
+                    "type": "string"
+                }
+            }
+        },
+        "plugin.Command": {
+            "type": "object",
+            "properties": {
+                "description": {
+                    "description": "One line for hf --help",
+                    "type": "string"
+                },
+                "name": {
+                    "type": "string"
+                },
+                "path": {
+                    "description": "Executable run with the arguments",
+                    "type": "string"
+                },
+                "registered_at": {
+                    "type": "string"
+                },
+                "registered_by": {
+                    "type": "string"
+                },
+                "source": {
+                    "type": "string"
+                }
+            }
+        },
+        "snapshot.Device": {
+            "type": "object",
+            "properties": {
+                "driver": {
+                    "type": "string"
+                },
+                "kind": {
+                    "description": "As netdetect reports it",
+                    "type": "string"
+                },
+                "name": {
+                    "type": "string"
+                }
+            }
+        },
+        "snapshot.Metadata": {
+            "type": "object",
+            "properties": {
+                "checksums": {
+                    "description": "Config file name -\u003e SHA256 checksum",
+                    "type": "object",
+                    "additionalProperties": {
+                        "type": "string"
+                    }
+                },
+                "configs": {
+                    "description": "List of config files included",
+                    "type": "array",
+                    "items": {
+                        "type": "string"
+                    }
+                },
+                "id": {
+                    "description": "Snapshot ID (timestamp-based)",
+                    "type": "string"
+                },
+                "message": {
+                    "type": "string"
+                },
+                "origin": {
+                    "description": "Router an imported snapshot was exported from",
+                    "allOf": [
+                        {
+                            "$ref": "#/definitions/snapshot.Origin"
+                        }
+                    ]
+                },
+                "protected": {
+                    "description": "Never pruned automatically",
                     "type": "boolean"
                 },
+                "timestamp": {
+                    "type": "string"
+                },
+                "version": {
+                    "description": "Hellfire version that created this snapshot",
+                    "type": "string"
+                }
+            }
+        },
+        "snapshot.Origin": {
+            "type": "object",
+            "properties": {
+                "devices": {
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/snapshot.Device"
+                    }
+                },
+                "hostname": {
+                    "type": "string"
+                },
+                "snapshot": {
+                    "description": "ID of the snapshot there",
+                    "type": "string"
+                },
+                "version": {
+                    "description": "Hellfire version that exported it",
+                    "type": "string"
+                }
+            }
+        },
+        "snapshot.Storage": {
+            "type": "object",
+            "properties": {
+                "available": {
+                    "description": "Free on that file system",
+                    "type": "integer"
+                },
+                "dir": {
+                    "type": "string"
+                },
+                "min_free": {
+                    "description": "Bytes that must be free to take a snapshot",
+                    "type": "integer"
+                },
+                "protected": {
+                    "type": "integer"
+                },
+                "quota": {
+                    "description": "Bytes the snapshots may use; 0 is unlimited",
+                    "type": "integer"
+                },
+                "snapshots": {
+                    "type": "integer"
+                },
+                "total": {
+                    "description": "Size of the file system holding them",
+                    "type": "integer"
+                },
+                "used": {
+                    "description": "Bytes of the files of the snapshots, shared ones counted once",
+                    "type": "integer"
+                }
+            }
+        },
+        "syslog.Entry": {
+            "type": "object",
+            "properties": {
+                "app": {
+                    "type": "string"
+                },
+                "facility": {
+                    "type": "string"
+                },
+                "hostname": {
+                    "type": "string"
+                },
                 "id": {
+                    "description": "Increases with every message received",
                     "type": "integer"
                 },
-                "last_login_at": {
+                "level": {
+                    "description": "0 (emerg) to 7 (debug)",
+                    "type": "integer"
+                },
+                "message": {
                     "type": "string"
                 },
-                "role": {
-                    "$ref": "#/definitions/db.Role"
+                "pid": {
+                    "type": "string"
                 },
-                "updated_at": {
+                "received": {
                     "type": "string"
                 },
-                "username": {
+                "severity": {
+                    "type": "string"
+                },
+                "source": {
+                    "description": "Sender address",
+                    "type": "string"
+                },
+                "time": {
+                    "description": "From the message, or when it was received",
                     "type": "string"
                 }
             }
         },
-        "main.SetOptionRequest": {
+        "upgrade.State": {
             "type": "object",
-            "required": [
-                "value"
-            ],
             "properties": {
-                "value": {
-                    "type": "string",
-                    "example": "192.168.1.1"
+                "db_backup": {
+                    "description": "Database before the upgrade",
+                    "type": "string"
+                },
+                "error": {
+                    "type": "string"
+                },
+                "finished_at": {
+                    "type": "string"
+                },
+                "from_version": {
+                    "type": "string"
+                },
+                "id": {
+                    "type": "string"
+                },
+                "log_file": {
+                    "description": "Installer output",
+                    "type": "string"
+                },
+                "message": {
+                    "type": "string"
+                },
+                "method": {
+                    "type": "string"
+                },
+                "pid": {
+                    "description": "Process running the installer",
+                    "type": "integer"
+                },
+                "problems": {
+                    "description": "Failed post-upgrade checks",
+                    "type": "array",
+                    "items": {
+                        "type": "string"
+                    }
+                },
+                "snapshot_id": {
+                    "description": "Configs before the upgrade",
+                    "type": "string"
+                },
+                "started_at": {
+                    "type": "string"
+                },
+                "status": {
+                    "type": "string"
+                },
+                "to_version": {
+                    "description": "Set by the restarted service",
+                    "type": "string"
                 }
             }
         },
-        "main.loginRequest": {
+        "wanquality.TargetStatus": {
             "type": "object",
-            "required": [
-                "password",
-                "username"
-            ],
             "properties": {
-                "password": {
+                "degraded": {
+                    "type": "boolean"
+                },
+                "last": {
+                    "$ref": "#/definitions/db.WANQualitySample"
+                },
+                "reasons": {
+                    "type": "array",
+                    "items": {
+                        "type": "string"
+                    }
+                },
+                "since": {
+                    "description": "When the target entered its current state",
                     "type": "string"
                 },
-                "username": {
+                "target": {
                     "type": "string"
                 }
             }
         },
-        "main.loginResponse": {
+        "wireguard.Interface": {
             "type": "object",
             "properties": {
-                "expires_at": {
+                "listen_port": {
+                    "type": "integer"
+                },
+                "name": {
                     "type": "string"
                 },
-                "token": {
+                "peers": {
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/wireguard.Peer"
+                    }
+                },
+                "public_key": {
                     "type": "string"
                 },
-                "user": {
-                    "$ref": "#/definitions/db.User"
+                "up": {
+                    "description": "Running in the kernel",
+                    "type": "boolean"
                 }
             }
         },
-        "main.onboardingRequest": {
+        "wireguard.Peer": {
             "type": "object",
-            "required": [
-                "email",
-                "name",
-                "password"
-            ],
             "properties": {
-                "email": {
+                "allowed_ips": {
+                    "type": "array",
+                    "items": {
+                        "type": "string"
+                    }
+                },
+                "description": {
                     "type": "string"
                 },
-                "name": {
+                "endpoint": {
                     "type": "string"
                 },
-                "password": {
-                    "type": "string",
-                    "minLength": 8
+                "latest_handshake": {
+                    "description": "Unset if there hasn't been one",
+                    "type": "string"
+                },
+                "persistent_keepalive": {
+                    "description": "Seconds",
+                    "type": "integer"
+                },
+                "public_key": {
+                    "type": "string"
+                },
+                "rx_bytes": {
+                    "type": "integer"
+                },
+                "section": {
+                    "description": "Network config section, when configured",
+                    "type": "string"
+                },
+                "tx_bytes": {
+                    "type": "integer"
                 }
             }
         }
+    },
+    "securityDefinitions": {
+        "APIKeyAuth": {
+            "description": "API key from \"hf apikey create\"",
+            "type": "apiKey",
+            "name": "X-API-Key",
+            "in": "header"
+        },
+        "BearerAuth": {
+            "description": "Session token from /auth/login, sent as \"Bearer \u003ctoken\u003e\"",
+            "type": "apiKey",
+            "name": "Authorization",
+            "in": "header"
+        }
     }
 }`
 
@@ -725,7 +6721,7 @@ const docTemplate = `{
 var SwaggerInfo = &swag.Spec{
 	Version:          "1.0",
 	Host:             "localhost:8888",
-	BasePath:         "/api",
+	BasePath:         "/api/v1",
 	Schemes:          []string{"http", "https"},
 	Title:            "Hellfire API",
 	Description:      "UCI-like configuration management system for Debian routers",
@@ -0,0 +1,43 @@
+package appliers
+
+import (
+	"context"
+	"fmt"
+	"os/exec"
+	"strings"
+)
+
+// captureCommands runs commands that dump system state, one artifact per
+// command named after it. A command that fails still records its output,
+// followed by the error: systemctl status exits non-zero for stopped units.
+func captureCommands(ctx context.Context, commands ...[]string) []Artifact {
+	dumps := make([]Artifact, 0, len(commands))
+	for _, command := range commands {
+		output, err := exec.CommandContext(ctx, command[0], command[1:]...).CombinedOutput()
+		content := string(output)
+		if err != nil {
+			content += fmt.Sprintf("(%s: %v)\n", command[0], err)
+		}
+		dumps = append(dumps, Artifact{Name: strings.Join(command, " "), Content: content})
+	}
+	return dumps
+}
+
+// CaptureState dumps the addresses, routes and routing rules
+func (a *NetworkApplier) CaptureState(ctx context.Context) []Artifact {
+	return captureCommands(ctx,
+		[]string{"ip", "-d", "addr", "show"},
+		[]string{"ip", "route", "show", "table", "all"},
+		[]string{"ip", "-6", "route", "show", "table", "all"},
+		[]string{"ip", "rule", "show"})
+}
+
+// CaptureState dumps the loaded ruleset
+func (a *FirewallApplier) CaptureState(ctx context.Context) []Artifact {
+	return captureCommands(ctx, []string{"nft", "list", "ruleset"})
+}
+
+// CaptureState dumps the status of dnsmasq
+func (a *DHCPApplier) CaptureState(ctx context.Context) []Artifact {
+	return captureCommands(ctx, []string{"systemctl", "status", "--no-pager", "dnsmasq"})
+}
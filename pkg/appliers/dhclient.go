@@ -0,0 +1,592 @@
+package appliers
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/thesabbir/hellfire/pkg/bus"
+	"github.com/thesabbir/hellfire/pkg/logger"
+	"github.com/thesabbir/hellfire/pkg/util"
+)
+
+const (
+	// DefaultDHCPStateDir holds the PID and lease file of each supervised dhclient
+	DefaultDHCPStateDir = "/var/lib/hellfire/dhclient"
+
+	// DefaultDHCPMonitorInterval is how often clients and addresses are checked
+	DefaultDHCPMonitorInterval = 10 * time.Second
+
+	dhcpRestartMin = 2 * time.Second
+	dhcpRestartMax = 2 * time.Minute
+	dhcpStopWait   = 5 * time.Second
+)
+
+// DHCPClients supervises the DHCP clients started by the network applier
+var DHCPClients = NewDHCPSupervisor(DefaultDHCPStateDir)
+
+// DHCPLease is the current lease of a DHCP client, from its lease file
+type DHCPLease struct {
+	Interface string    `json:"interface"`
+	Address   string    `json:"address"`
+	Netmask   string    `json:"netmask,omitempty"`
+	Routers   []string  `json:"routers,omitempty"`
+	DNS       []string  `json:"dns,omitempty"`
+	Server    string    `json:"server,omitempty"`
+	Renew     time.Time `json:"renew,omitzero"`
+	Rebind    time.Time `json:"rebind,omitzero"`
+	Expire    time.Time `json:"expire,omitzero"`
+}
+
+// DHCPClientStatus reports a supervised DHCP client, its lease and the
+// addresses actually on the interface
+type DHCPClientStatus struct {
+	Interface string     `json:"interface"`
+	PID       int        `json:"pid,omitempty"`
+	Running   bool       `json:"running"`
+	Restarts  int        `json:"restarts"`
+	Addresses []string   `json:"addresses"`
+	Lease     *DHCPLease `json:"lease,omitempty"`
+}
+
+// DHCPAddressChange is published when the addresses of a DHCP interface change
+type DHCPAddressChange struct {
+	Interface string     `json:"interface"`
+	Previous  []string   `json:"previous"`
+	Current   []string   `json:"current"`
+	Lease     *DHCPLease `json:"lease,omitempty"`
+}
+
+// DHCPSupervisor runs one dhclient per interface in the foreground, restarts
+// clients that exit and watches interface addresses for changes. Clients left
+// running by another hellfire process are adopted through their PID files.
+type DHCPSupervisor struct {
+	mu        sync.Mutex
+	dir       string
+	clients   map[string]*dhcpClient
+	addresses map[string][]string  // Last seen addresses, for change events
+	expiry    map[string]time.Time // Last seen lease expiry, for renewal events
+	loadOnce  sync.Once
+	monitor   sync.Once
+}
+
+// dhcpClient is one supervised dhclient
+type dhcpClient struct {
+	pid       int
+	metric    int           // Route metric passed to dhclient-script; unknown (0) for adopted clients
+	exited    chan struct{} // Closed when our child exits; nil for adopted clients
+	started   time.Time
+	restarts  int
+	backoff   time.Duration
+	nextStart time.Time
+}
+
+// NewDHCPSupervisor creates a supervisor keeping its state in dir
+func NewDHCPSupervisor(dir string) *DHCPSupervisor {
+	return &DHCPSupervisor{
+		dir:       dir,
+		clients:   make(map[string]*dhcpClient),
+		addresses: make(map[string][]string),
+		expiry:    make(map[string]time.Time),
+	}
+}
+
+// Start (re)starts the DHCP client of an interface, releasing any lease it
+// holds. A metric above 0 is given to the routes it installs.
+func (s *DHCPSupervisor) Start(ctx context.Context, ifaceName string, metric int) error {
+	if err := util.ValidateInterfaceName(ifaceName); err != nil {
+		return fmt.Errorf("invalid interface name: %w", err)
+	}
+	s.load()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.stopLocked(ctx, ifaceName)
+
+	client := &dhcpClient{metric: metric, backoff: dhcpRestartMin}
+	if err := s.spawnLocked(ifaceName, client); err != nil {
+		return err
+	}
+	s.clients[ifaceName] = client
+	return nil
+}
+
+// Stop releases the lease of an interface and stops its DHCP client. It is a
+// no-op for interfaces without a supervised client.
+func (s *DHCPSupervisor) Stop(ctx context.Context, ifaceName string) {
+	s.load()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.stopLocked(ctx, ifaceName)
+
+	// Forget the interface; Start keeps these so a restarted client's new
+	// address is still reported as a change
+	delete(s.addresses, ifaceName)
+	delete(s.expiry, ifaceName)
+}
+
+// Renew restarts the DHCP client of an interface with its route metric, for
+// a lease from the network a cable was just plugged into. It is a no-op for
+// interfaces without a supervised client.
+func (s *DHCPSupervisor) Renew(ctx context.Context, ifaceName string) error {
+	if !s.Running(ifaceName) {
+		return nil
+	}
+	return s.Start(ctx, ifaceName, s.metric(ifaceName))
+}
+
+// Running reports whether an interface has a live supervised DHCP client
+func (s *DHCPSupervisor) Running(ifaceName string) bool {
+	s.load()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	client, ok := s.clients[ifaceName]
+	return ok && client.running()
+}
+
+// metric returns the route metric of an interface's DHCP client
+func (s *DHCPSupervisor) metric(ifaceName string) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if client, ok := s.clients[ifaceName]; ok {
+		return client.metric
+	}
+	return 0
+}
+
+// Status reports every supervised client, sorted by interface
+func (s *DHCPSupervisor) Status(ctx context.Context) []DHCPClientStatus {
+	s.load()
+
+	s.mu.Lock()
+	names := make([]string, 0, len(s.clients))
+	statuses := make(map[string]DHCPClientStatus, len(s.clients))
+	for name, client := range s.clients {
+		names = append(names, name)
+		statuses[name] = DHCPClientStatus{
+			Interface: name,
+			PID:       client.pid,
+			Running:   client.running(),
+			Restarts:  client.restarts,
+		}
+	}
+	s.mu.Unlock()
+
+	sort.Strings(names)
+	result := make([]DHCPClientStatus, 0, len(names))
+	for _, name := range names {
+		status := statuses[name]
+		status.Lease = s.lease(name)
+		addresses, err := interfaceAddresses(ctx, name)
+		if err != nil {
+			logger.Debug("Failed to read DHCP interface addresses", "interface", name, "error", err)
+		}
+		status.Addresses = addresses
+		result = append(result, status)
+	}
+	return result
+}
+
+// StartMonitor starts a background goroutine that restarts clients which
+// exited and publishes events when DHCP addresses change or leases renew
+func (s *DHCPSupervisor) StartMonitor(interval time.Duration) {
+	s.monitor.Do(func() {
+		go func() {
+			ticker := time.NewTicker(interval)
+			defer ticker.Stop()
+
+			logger.Info("Started DHCP client monitor", "interval", interval)
+
+			for {
+				s.check(context.Background())
+				<-ticker.C
+			}
+		}()
+	})
+}
+
+// check restarts dead clients, compares addresses and leases with the last
+// check and updates the resolv file with the DNS servers of the leases
+func (s *DHCPSupervisor) check(ctx context.Context) {
+	s.load()
+	now := time.Now()
+
+	s.mu.Lock()
+	names := make([]string, 0, len(s.clients))
+	for name, client := range s.clients {
+		names = append(names, name)
+		if client.running() || now.Before(client.nextStart) {
+			continue
+		}
+
+		logger.Warn("DHCP client exited, restarting", "interface", name, "restarts", client.restarts)
+		// Back off while it keeps crashing, but not after a long healthy run
+		if now.Sub(client.started) > dhcpRestartMax {
+			client.backoff = dhcpRestartMin
+		}
+		client.restarts++
+		client.nextStart = now.Add(client.backoff)
+		client.backoff = min(client.backoff*2, dhcpRestartMax)
+		if err := s.spawnLocked(name, client); err != nil {
+			logger.Error("Failed to restart DHCP client", "interface", name, "error", err)
+		}
+	}
+	s.mu.Unlock()
+
+	for _, name := range names {
+		addresses, err := interfaceAddresses(ctx, name)
+		if err != nil {
+			logger.Debug("Failed to read DHCP interface addresses", "interface", name, "error", err)
+			continue
+		}
+		lease := s.lease(name)
+
+		s.mu.Lock()
+		previous, seen := s.addresses[name]
+		s.addresses[name] = addresses
+		renewed := lease != nil && !s.expiry[name].IsZero() && lease.Expire.After(s.expiry[name])
+		if lease != nil {
+			s.expiry[name] = lease.Expire
+		}
+		s.mu.Unlock()
+
+		if seen && !slices.Equal(previous, addresses) {
+			logger.Info("DHCP interface address changed",
+				"interface", name,
+				"previous", previous,
+				"current", addresses)
+			bus.Publish(bus.Event{
+				Type:       bus.EventDHCPAddressChanged,
+				ConfigName: "network",
+				Data: DHCPAddressChange{
+					Interface: name,
+					Previous:  previous,
+					Current:   addresses,
+					Lease:     lease,
+				},
+			})
+		} else if renewed {
+			logger.Debug("DHCP lease renewed", "interface", name, "expire", lease.Expire)
+			bus.Publish(bus.Event{
+				Type:       bus.EventDHCPLeaseRenewed,
+				ConfigName: "network",
+				Data:       lease,
+			})
+		}
+	}
+
+	// Pass on the DNS servers of new leases
+	if err := Resolvers.Update(); err != nil {
+		logger.Warn("Failed to update DNS servers", "error", err)
+	}
+}
+
+// load adopts clients left running by another process, once
+func (s *DHCPSupervisor) load() {
+	s.loadOnce.Do(func() {
+		pidFiles, err := filepath.Glob(filepath.Join(s.dir, "*.pid"))
+		if err != nil {
+			return
+		}
+
+		s.mu.Lock()
+		defer s.mu.Unlock()
+
+		for _, pidFile := range pidFiles {
+			name := strings.TrimSuffix(filepath.Base(pidFile), ".pid")
+			if _, ok := s.clients[name]; ok || util.ValidateInterfaceName(name) != nil {
+				continue
+			}
+
+			pid := readPIDFile(pidFile)
+			if pid == 0 || !dhclientAlive(pid) {
+				// The monitor restarts it; Stop only cleans up
+				pid = 0
+			}
+			s.clients[name] = &dhcpClient{pid: pid, backoff: dhcpRestartMin}
+			logger.Debug("Adopted DHCP client", "interface", name, "pid", pid)
+		}
+	})
+}
+
+// spawnLocked starts dhclient in the foreground for an interface
+func (s *DHCPSupervisor) spawnLocked(ifaceName string, client *dhcpClient) error {
+	if err := os.MkdirAll(s.dir, 0755); err != nil {
+		return fmt.Errorf("failed to create DHCP state directory: %w", err)
+	}
+
+	pidFile := s.pidFile(ifaceName)
+	args := []string{"-d", "-pf", pidFile, "-lf", s.leaseFile(ifaceName)}
+	if client.metric > 0 {
+		// dhclient-script installs the routes with IF_METRIC
+		args = append(args, "-e", "IF_METRIC="+strconv.Itoa(client.metric))
+	}
+	cmd := exec.Command("dhclient", append(args, ifaceName)...)
+
+	// dhclient logs to stderr in the foreground; keep the output of the
+	// current run next to the lease
+	logFile, err := os.Create(filepath.Join(s.dir, ifaceName+".log"))
+	if err != nil {
+		return fmt.Errorf("failed to create DHCP client log: %w", err)
+	}
+	defer logFile.Close()
+	cmd.Stdout = logFile
+	cmd.Stderr = logFile
+	// Own process group, so the client outlives the CLI that started it and
+	// doesn't get the terminal's signals
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+
+	if err := cmd.Start(); err != nil {
+		return fmt.Errorf("failed to start dhcp client: %w", err)
+	}
+
+	exited := make(chan struct{})
+	go func() {
+		err := cmd.Wait()
+		logger.Debug("DHCP client exited", "interface", ifaceName, "error", err)
+		close(exited)
+	}()
+
+	client.pid = cmd.Process.Pid
+	client.exited = exited
+	client.started = time.Now()
+
+	// Written here too so other processes can adopt the client before
+	// dhclient gets around to it
+	if err := os.WriteFile(pidFile, []byte(strconv.Itoa(client.pid)+"\n"), 0644); err != nil {
+		logger.Warn("Failed to write DHCP client PID file", "interface", ifaceName, "error", err)
+	}
+
+	logger.Info("Started DHCP client", "interface", ifaceName, "pid", client.pid)
+	return nil
+}
+
+// stopLocked releases the lease and stops the client of an interface
+func (s *DHCPSupervisor) stopLocked(ctx context.Context, ifaceName string) {
+	client, ok := s.clients[ifaceName]
+	if !ok {
+		return
+	}
+	delete(s.clients, ifaceName)
+
+	if client.running() {
+		// dhclient -r sends a release and stops the client in the PID file
+		if err := runCommandContext(ctx, "dhclient", "-r", "-pf", s.pidFile(ifaceName),
+			"-lf", s.leaseFile(ifaceName), ifaceName); err != nil {
+			logger.Warn("Failed to release DHCP lease", "interface", ifaceName, "error", err)
+		}
+		client.terminate()
+	}
+
+	_ = os.Remove(s.pidFile(ifaceName))
+	logger.Info("Stopped DHCP client", "interface", ifaceName)
+}
+
+// lease returns the current lease of an interface, if any
+func (s *DHCPSupervisor) lease(ifaceName string) *DHCPLease {
+	data, err := os.ReadFile(s.leaseFile(ifaceName))
+	if err != nil {
+		return nil
+	}
+
+	var current *DHCPLease
+	for _, lease := range parseDHCPLeases(data) {
+		if lease.Interface == ifaceName {
+			current = &lease
+		}
+	}
+	return current
+}
+
+func (s *DHCPSupervisor) pidFile(ifaceName string) string {
+	return filepath.Join(s.dir, ifaceName+".pid")
+}
+
+func (s *DHCPSupervisor) leaseFile(ifaceName string) string {
+	return filepath.Join(s.dir, ifaceName+".leases")
+}
+
+// running reports whether the client process is alive
+func (c *dhcpClient) running() bool {
+	if c.exited != nil {
+		select {
+		case <-c.exited:
+			return false
+		default:
+			return true
+		}
+	}
+	return c.pid != 0 && dhclientAlive(c.pid)
+}
+
+// terminate makes sure the client is gone after a release
+func (c *dhcpClient) terminate() {
+	deadline := time.Now().Add(dhcpStopWait)
+	for c.running() && time.Now().Before(deadline) {
+		if c.exited != nil {
+			select {
+			case <-c.exited:
+			case <-time.After(100 * time.Millisecond):
+			}
+		} else {
+			time.Sleep(100 * time.Millisecond)
+		}
+	}
+
+	if c.running() {
+		_ = syscall.Kill(c.pid, syscall.SIGKILL)
+	}
+}
+
+// dhclientAlive reports whether pid is a running dhclient, so a stale PID
+// file reused by another process after a reboot isn't mistaken for one
+func dhclientAlive(pid int) bool {
+	comm, err := os.ReadFile(fmt.Sprintf("/proc/%d/comm", pid))
+	if err != nil {
+		return false
+	}
+	return strings.TrimSpace(string(comm)) == "dhclient"
+}
+
+// readPIDFile returns the PID in a PID file, or 0
+func readPIDFile(path string) int {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return 0
+	}
+	pid, err := strconv.Atoi(strings.TrimSpace(string(data)))
+	if err != nil || pid <= 0 {
+		return 0
+	}
+	return pid
+}
+
+// interfaceAddresses lists the IPv4 addresses of an interface in CIDR notation
+func interfaceAddresses(ctx context.Context, ifaceName string) ([]string, error) {
+	output, err := commandOutputContext(ctx, "ip", "-4", "-j", "addr", "show", "dev", ifaceName)
+	if err != nil {
+		return nil, err
+	}
+	state, err := parseLinkDump(output)
+	if err != nil {
+		return nil, err
+	}
+
+	addresses := make([]string, 0, len(state.Addresses))
+	for _, addr := range state.Addresses {
+		addresses = append(addresses, fmt.Sprintf("%s/%d", addr.Local, addr.PrefixLen))
+	}
+	return addresses, nil
+}
+
+// parseDHCPLeases parses a dhclient lease file. dhclient appends a block per
+// lease, so the last lease of an interface is its current one.
+func parseDHCPLeases(data []byte) []DHCPLease {
+	var leases []DHCPLease
+	var lease *DHCPLease
+
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if i := strings.Index(line, "#"); i >= 0 {
+			line = strings.TrimSpace(line[:i])
+		}
+
+		switch {
+		case line == "":
+			continue
+		case strings.HasPrefix(line, "lease") && strings.HasSuffix(line, "{"):
+			lease = &DHCPLease{}
+			continue
+		case line == "}":
+			if lease != nil {
+				leases = append(leases, *lease)
+			}
+			lease = nil
+			continue
+		case lease == nil:
+			continue
+		}
+
+		fields := strings.Fields(strings.TrimSuffix(line, ";"))
+		if len(fields) < 2 {
+			continue
+		}
+
+		switch fields[0] {
+		case "interface":
+			lease.Interface = strings.Trim(fields[1], `"`)
+		case "fixed-address":
+			lease.Address = fields[1]
+		case "renew":
+			lease.Renew = parseLeaseTime(fields[1:])
+		case "rebind":
+			lease.Rebind = parseLeaseTime(fields[1:])
+		case "expire":
+			lease.Expire = parseLeaseTime(fields[1:])
+		case "option":
+			if len(fields) < 3 {
+				continue
+			}
+			value := strings.Trim(strings.Join(fields[2:], " "), `"`)
+			switch fields[1] {
+			case "subnet-mask":
+				lease.Netmask = value
+			case "routers":
+				lease.Routers = splitLeaseList(value)
+			case "domain-name-servers":
+				lease.DNS = splitLeaseList(value)
+			case "dhcp-server-identifier":
+				lease.Server = value
+			}
+		}
+	}
+
+	return leases
+}
+
+// parseLeaseTime parses a lease time: "<weekday> yyyy/mm/dd hh:mm:ss" in
+// UTC, "epoch <seconds>" or "never" (zero time)
+func parseLeaseTime(fields []string) time.Time {
+	switch {
+	case fields[0] == "epoch" && len(fields) >= 2:
+		secs, err := strconv.ParseInt(fields[1], 10, 64)
+		if err != nil {
+			return time.Time{}
+		}
+		return time.Unix(secs, 0).UTC()
+	case len(fields) >= 3:
+		t, err := time.Parse("2006/01/02 15:04:05", fields[1]+" "+fields[2])
+		if err != nil {
+			return time.Time{}
+		}
+		return t
+	default:
+		return time.Time{}
+	}
+}
+
+// splitLeaseList splits a comma-separated option value
+func splitLeaseList(value string) []string {
+	var items []string
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
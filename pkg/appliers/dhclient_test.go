@@ -0,0 +1,51 @@
+package appliers
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestParseDHCPLeases(t *testing.T) {
+	leases := `default-duid "\000\001\000\001";
+lease {
+  interface "eth0";
+  fixed-address 10.0.0.5;
+  option subnet-mask 255.255.255.0;
+  option routers 10.0.0.1;
+  expire 4 2026/10/15 10:00:00;
+}
+lease {
+  interface "eth0";
+  fixed-address 10.0.0.7;
+  option subnet-mask 255.255.255.0;
+  option routers 10.0.0.1;
+  option domain-name-servers 10.0.0.1,9.9.9.9;
+  option dhcp-server-identifier 10.0.0.1;
+  option domain-name "lan";
+  renew 5 2026/10/16 15:02:11;
+  rebind 5 2026/10/16 15:06:00;
+  expire epoch 1792169235; # Fri Oct 16 15:07:15 2026
+}
+`
+
+	parsed := parseDHCPLeases([]byte(leases))
+	if len(parsed) != 2 {
+		t.Fatalf("expected 2 leases, got %d", len(parsed))
+	}
+
+	want := DHCPLease{
+		Interface: "eth0",
+		Address:   "10.0.0.7",
+		Netmask:   "255.255.255.0",
+		Routers:   []string{"10.0.0.1"},
+		DNS:       []string{"10.0.0.1", "9.9.9.9"},
+		Server:    "10.0.0.1",
+		Renew:     time.Date(2026, 10, 16, 15, 2, 11, 0, time.UTC),
+		Rebind:    time.Date(2026, 10, 16, 15, 6, 0, 0, time.UTC),
+		Expire:    time.Unix(1792169235, 0).UTC(),
+	}
+	if !reflect.DeepEqual(parsed[1], want) {
+		t.Errorf("lease = %+v, want %+v", parsed[1], want)
+	}
+}
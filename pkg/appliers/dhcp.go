@@ -3,11 +3,17 @@ package appliers
 import (
 	"bytes"
 	"context"
+	"errors"
 	"fmt"
+	"math/rand/v2"
+	"net"
 	"os"
 	"os/exec"
 	"path/filepath"
+	"strings"
+	"time"
 
+	"github.com/thesabbir/hellfire/pkg/dns"
 	"github.com/thesabbir/hellfire/pkg/logger"
 	"github.com/thesabbir/hellfire/pkg/uci"
 	"github.com/thesabbir/hellfire/pkg/util"
@@ -15,11 +21,18 @@ import (
 
 const (
 	DnsmasqConfigPath = "/etc/dnsmasq.d/hellfire.conf"
+
+	// dnsmasqAnswerAttempts is how many times Validate queries each
+	// interface, a second apart, while a restarted dnsmasq starts up
+	dnsmasqAnswerAttempts = 5
 )
 
 // DHCPApplier applies DHCP/DNS configuration
 type DHCPApplier struct {
 	previousConfig string
+	saved          bool     // Whether Apply has captured the previous config
+	hadPrevious    bool     // Whether a dnsmasq config existed before Apply
+	interfaces     []string // Interfaces the last Apply serves DHCP on, for Validate
 }
 
 // NewDHCPApplier creates a new DHCP applier
@@ -55,10 +68,21 @@ func (a *DHCPApplier) Apply(ctx context.Context, config *uci.Config) error {
 		return fmt.Errorf("failed to restart dnsmasq: %w", err)
 	}
 
+	a.interfaces = servedInterfaces(config)
 	return nil
 }
 
-// Validate validates that dnsmasq is running
+// Render generates the dnsmasq config Apply would write
+func (a *DHCPApplier) Render(ctx context.Context, config *uci.Config) ([]Artifact, error) {
+	dnsmasqConfig, err := a.generateDnsmasqConfig(config)
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate dnsmasq config: %w", err)
+	}
+	return []Artifact{{Name: DnsmasqConfigPath, Content: dnsmasqConfig}}, nil
+}
+
+// Validate validates that dnsmasq is running and answers on every interface
+// it serves DHCP on
 func (a *DHCPApplier) Validate(ctx context.Context) error {
 	// Check if dnsmasq is running using systemctl (Debian Trixie+ with systemd)
 	cmd := exec.CommandContext(ctx, "systemctl", "is-active", "dnsmasq")
@@ -66,19 +90,108 @@ func (a *DHCPApplier) Validate(ctx context.Context) error {
 		return fmt.Errorf("dnsmasq is not running")
 	}
 
-	return nil
+	var errs []error
+	for _, iface := range a.interfaces {
+		if err := dnsmasqAnswers(ctx, iface); err != nil {
+			errs = append(errs, fmt.Errorf("dnsmasq is not answering on %s: %w", iface, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// servedInterfaces lists the interfaces with an enabled DHCP pool
+func servedInterfaces(config *uci.Config) []string {
+	var interfaces []string
+	for _, pool := range config.GetSectionsByType("dhcp") {
+		iface, ok := pool.GetOption("interface")
+		if !ok {
+			continue
+		}
+		if ignore, ok := pool.GetOption("ignore"); ok && ignore == "1" {
+			continue
+		}
+		interfaces = append(interfaces, iface)
+	}
+	return interfaces
+}
+
+// dnsmasqAnswers queries dnsmasq on the first IPv4 address of an interface,
+// retrying while it starts up
+func dnsmasqAnswers(ctx context.Context, iface string) error {
+	addresses, err := interfaceAddresses(ctx, iface)
+	if err != nil {
+		return err
+	}
+	if len(addresses) == 0 {
+		return fmt.Errorf("interface has no IPv4 address")
+	}
+	addr, _, _ := strings.Cut(addresses[0], "/")
+
+	for attempt := 1; ; attempt++ {
+		err = queryDNSVersion(ctx, addr)
+		if err == nil || attempt == dnsmasqAnswerAttempts {
+			return err
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(time.Second):
+		}
+	}
+}
+
+// queryDNSVersion sends a "version.bind" CHAOS TXT query, which dnsmasq
+// answers itself without forwarding, and waits for a response to it
+func queryDNSVersion(ctx context.Context, addr string) error {
+	var dialer net.Dialer
+	conn, err := dialer.DialContext(ctx, "udp", net.JoinHostPort(addr, "53"))
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	_ = conn.SetDeadline(time.Now().Add(time.Second))
+
+	id := uint16(rand.Uint32())
+	query := []byte{
+		byte(id >> 8), byte(id), // ID
+		0x01, 0x00, // Recursion desired
+		0x00, 0x01, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, // One question
+		7, 'v', 'e', 'r', 's', 'i', 'o', 'n', 4, 'b', 'i', 'n', 'd', 0,
+		0x00, 0x10, // TXT
+		0x00, 0x03, // CHAOS
+	}
+	if _, err := conn.Write(query); err != nil {
+		return err
+	}
+
+	response := make([]byte, 512)
+	for {
+		n, err := conn.Read(response)
+		if err != nil {
+			return err
+		}
+		// Any response to our query counts, whatever its rcode
+		if n >= 12 && response[0] == byte(id>>8) && response[1] == byte(id) && response[2]&0x80 != 0 {
+			return nil
+		}
+	}
 }
 
 // Rollback rolls back DHCP changes
 func (a *DHCPApplier) Rollback(ctx context.Context) error {
-	if a.previousConfig == "" {
-		return fmt.Errorf("no previous config to restore")
+	if !a.saved {
+		return ErrNoRollbackState
 	}
 
 	logger.Info("Rolling back DHCP configuration")
 
-	// Restore previous config
-	if err := a.writeDnsmasqConfig(a.previousConfig); err != nil {
+	// Restore previous config, or remove ours if there was none
+	if a.hadPrevious {
+		if err := a.writeDnsmasqConfig(a.previousConfig); err != nil {
+			return err
+		}
+	} else if err := os.Remove(DnsmasqConfigPath); err != nil && !os.IsNotExist(err) {
 		return err
 	}
 
@@ -92,12 +205,16 @@ func (a *DHCPApplier) saveCurrentConfig() error {
 	if err != nil {
 		if os.IsNotExist(err) {
 			a.previousConfig = ""
+			a.hadPrevious = false
+			a.saved = true
 			return nil
 		}
 		return err
 	}
 
 	a.previousConfig = string(data)
+	a.hadPrevious = true
+	a.saved = true
 	return nil
 }
 
@@ -151,6 +268,19 @@ func (a *DHCPApplier) generateDnsmasqConfig(config *uci.Config) (string, error)
 		}
 	}
 
+	// Static leases
+	leases, err := dns.StaticLeases(config)
+	if err != nil {
+		return "", err
+	}
+	for _, lease := range leases {
+		if lease.Name != "" {
+			buf.WriteString(fmt.Sprintf("dhcp-host=%s,%s,%s\n", lease.MAC, lease.IP, lease.Name))
+		} else {
+			buf.WriteString(fmt.Sprintf("dhcp-host=%s,%s\n", lease.MAC, lease.IP))
+		}
+	}
+
 	// Process DNS settings
 	dnsmasq := config.GetSection("dnsmasq", "")
 	if dnsmasq != nil {
@@ -172,20 +302,51 @@ func (a *DHCPApplier) generateDnsmasqConfig(config *uci.Config) (string, error)
 			buf.WriteString(fmt.Sprintf("local=/%s/\n", local))
 		}
 
-		// Upstream DNS servers
-		servers := dnsmasq.GetList("server")
-		for _, server := range servers {
-			// Validate DNS server IP
-			if err := util.ValidateIPAddress(server); err != nil {
-				return "", fmt.Errorf("invalid DNS server %s: %w", server, err)
-			}
-			buf.WriteString(fmt.Sprintf("server=%s\n", server))
+		// Query log, with the client and serial of each query for the stats
+		if logQueries, _ := dnsmasq.GetOption("logqueries"); logQueries == "1" {
+			buf.WriteString("log-queries=extra\n")
 		}
 	}
 
+	// Where dnsmasq finds its default upstream servers: nowhere with
+	// noresolv, the resolvfile option, or the DNS servers of the interfaces
+	// once the network applier has written them (/etc/resolv.conf otherwise)
+	if resolv, err := resolvFile(dnsmasq); err != nil {
+		return "", err
+	} else if resolv != "" {
+		buf.WriteString(resolv + "\n")
+	}
+
+	// Upstream servers, conditional forwarders, local zones and records
+	records, err := dns.Dnsmasq(config)
+	if err != nil {
+		return "", err
+	}
+	buf.WriteString(records)
+
 	return buf.String(), nil
 }
 
+// resolvFile returns the dnsmasq directive choosing the upstream servers of
+// a dnsmasq section, which may be nil; empty for dnsmasq's default
+func resolvFile(dnsmasq *uci.Section) (string, error) {
+	if dnsmasq != nil {
+		if noresolv, _ := dnsmasq.GetOption("noresolv"); noresolv == "1" {
+			return "no-resolv", nil
+		}
+		if path, ok := dnsmasq.GetOption("resolvfile"); ok {
+			if !filepath.IsAbs(path) || strings.ContainsAny(path, " \t\r\n") {
+				return "", fmt.Errorf("invalid resolvfile %q: must be an absolute path", path)
+			}
+			return "resolv-file=" + path, nil
+		}
+	}
+	if Resolvers.Active() {
+		return "resolv-file=" + Resolvers.Path(), nil
+	}
+	return "", nil
+}
+
 // writeDnsmasqConfig writes dnsmasq configuration to file
 func (a *DHCPApplier) writeDnsmasqConfig(config string) error {
 	// Ensure directory exists with restricted permissions
@@ -3,10 +3,17 @@ package appliers
 import (
 	"bytes"
 	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"net"
 	"os/exec"
+	"strconv"
 	"strings"
+	"sync"
+	"time"
 
+	"github.com/thesabbir/hellfire/pkg/audit"
 	"github.com/thesabbir/hellfire/pkg/logger"
 	"github.com/thesabbir/hellfire/pkg/uci"
 	"github.com/thesabbir/hellfire/pkg/util"
@@ -14,7 +21,57 @@ import (
 
 // FirewallApplier applies firewall configuration
 type FirewallApplier struct {
-	previousRules string // Store previous ruleset for rollback
+	previousRules  string  // Store previous ruleset for rollback
+	previousQuotas []Quota // Quotas enforced before Apply, restored on rollback
+	appliedRules   string  // Ruleset loaded by the last Apply, for Validate
+
+	mu         sync.Mutex // Guards management, which is updated on config reload
+	management *ManagementAccess
+}
+
+// nftChain is the part of a chain Validate compares between the generated
+// ruleset and what the kernel has loaded
+type nftChain struct {
+	Hook   string
+	Policy string
+	Rules  int
+}
+
+// ManagementAccess describes the input rules protecting the management
+// plane. Loopback is always allowed.
+type ManagementAccess struct {
+	Services []ManagementService
+
+	// Ports on which the client committing a change (the address recorded
+	// with audit.WithIP) is always accepted, so applying a ruleset can't cut
+	// it off mid-transaction
+	AntiLockout []int
+
+	// SPA, when set, closes ports to everyone but recently authorized clients
+	SPA *SPAGate
+}
+
+// SPAGate keeps Ports closed except to addresses in the SPA client sets,
+// which the knock listener on ListenPort fills with time-limited entries
+type SPAGate struct {
+	ListenPort int
+	Ports      []int
+}
+
+// Sets holding the addresses of clients that sent a valid knock
+const (
+	SPASetIPv4 = "spa_clients4"
+	SPASetIPv6 = "spa_clients6"
+)
+
+// ManagementService restricts a set of management ports to traffic arriving
+// on the given interfaces or firewall zones (resolved through the zone's
+// network list), or accepts them from anywhere if neither is set
+type ManagementService struct {
+	Name       string
+	Ports      []int
+	Zones      []string
+	Interfaces []string
 }
 
 // NewFirewallApplier creates a new firewall applier
@@ -27,6 +84,13 @@ func (a *FirewallApplier) Name() string {
 	return "firewall"
 }
 
+// SetManagementAccess restricts the management ports in generated rulesets (nil removes the restriction)
+func (a *FirewallApplier) SetManagementAccess(access *ManagementAccess) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.management = access
+}
+
 // Apply applies firewall configuration
 func (a *FirewallApplier) Apply(ctx context.Context, config *uci.Config) error {
 	// Save current ruleset for rollback
@@ -35,46 +99,202 @@ func (a *FirewallApplier) Apply(ctx context.Context, config *uci.Config) error {
 	}
 
 	// Generate nftables configuration
-	nftConfig, err := a.generateNftables(config)
+	nftConfig, err := a.generateNftables(config, committingClient(ctx), networkConfig(ctx))
 	if err != nil {
 		return fmt.Errorf("failed to generate nftables config: %w", err)
 	}
+	quotas, err := parseQuotas(config)
+	if err != nil {
+		return err
+	}
+
+	// Loading the ruleset restarts the quota counters; count the traffic so far
+	a.previousQuotas = Quotas.Definitions()
+	if len(a.previousQuotas) > 0 {
+		if err := Quotas.Sync(ctx); err != nil {
+			logger.Warn("Failed to update quota usage", "error", err)
+		}
+	}
 
 	// Apply nftables rules
+	a.appliedRules = ""
 	if err := a.applyNftables(ctx, nftConfig); err != nil {
 		return fmt.Errorf("failed to apply nftables rules: %w", err)
 	}
+	a.appliedRules = nftConfig
+
+	if err := Quotas.Configure(ctx, quotas); err != nil {
+		return fmt.Errorf("failed to enforce quotas: %w", err)
+	}
 
 	return nil
 }
 
-// Validate validates that firewall rules are loaded
+// Render generates the ruleset Apply would load
+func (a *FirewallApplier) Render(ctx context.Context, config *uci.Config) ([]Artifact, error) {
+	nftConfig, err := a.generateNftables(config, committingClient(ctx), networkConfig(ctx))
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate nftables config: %w", err)
+	}
+	if _, err := parseQuotas(config); err != nil {
+		return nil, err
+	}
+	return []Artifact{{Name: "nftables ruleset", Content: nftConfig}}, nil
+}
+
+// Validate checks that the loaded router table matches the ruleset of the
+// last Apply: the same chains, hooks and policies, and the same number of
+// rules in each chain
 func (a *FirewallApplier) Validate(ctx context.Context) error {
-	// Check that nftables rules are loaded
-	cmd := exec.CommandContext(ctx, "nft", "list", "ruleset")
-	output, err := cmd.CombinedOutput()
+	if a.appliedRules == "" {
+		// Nothing applied in this process; ensure some rules are loaded
+		output, err := exec.CommandContext(ctx, "nft", "list", "ruleset").CombinedOutput()
+		if err != nil {
+			return fmt.Errorf("failed to validate firewall: %w", err)
+		}
+		if len(output) == 0 {
+			return fmt.Errorf("no firewall rules loaded")
+		}
+		return nil
+	}
+
+	output, err := exec.CommandContext(ctx, "nft", "-j", "list", "table", "inet", "router").Output()
 	if err != nil {
-		return fmt.Errorf("failed to validate firewall: %w", err)
+		return fmt.Errorf("router table is not loaded: %w", err)
 	}
+	loaded, err := parseNftTableDump(output)
+	if err != nil {
+		return err
+	}
+
+	return compareChains(generatedChains(a.appliedRules), loaded)
+}
 
-	// Basic check: ensure we have some rules
-	if len(output) == 0 {
-		return fmt.Errorf("no firewall rules loaded")
+// compareChains reports every difference between the expected and loaded chains
+func compareChains(expected, loaded map[string]nftChain) error {
+	var errs []error
+	for name, want := range expected {
+		got, ok := loaded[name]
+		switch {
+		case !ok:
+			errs = append(errs, fmt.Errorf("chain %s is missing", name))
+		case got.Hook != want.Hook || got.Policy != want.Policy:
+			errs = append(errs, fmt.Errorf("chain %s has hook %q policy %q, expected hook %q policy %q",
+				name, got.Hook, got.Policy, want.Hook, want.Policy))
+		case got.Rules != want.Rules:
+			errs = append(errs, fmt.Errorf("chain %s has %d rules, expected %d", name, got.Rules, want.Rules))
+		}
 	}
+	for name := range loaded {
+		if _, ok := expected[name]; !ok {
+			errs = append(errs, fmt.Errorf("unexpected chain %s", name))
+		}
+	}
+	return errors.Join(errs...)
+}
 
-	return nil
+// generatedChains extracts the chains of the router table from a generated ruleset
+func generatedChains(nftConfig string) map[string]nftChain {
+	chains := make(map[string]nftChain)
+	inTable := false
+	current := ""
+
+	for _, line := range strings.Split(nftConfig, "\n") {
+		line = strings.TrimSpace(line)
+		switch {
+		case line == "" || strings.HasPrefix(line, "#"):
+		case line == "table inet router {":
+			inTable = true
+		case !inTable:
+		case line == "}":
+			if current == "" {
+				inTable = false
+			}
+			current = ""
+		case strings.HasPrefix(line, "chain ") && strings.HasSuffix(line, "{"):
+			current = strings.TrimSpace(strings.TrimSuffix(strings.TrimPrefix(line, "chain "), "{"))
+			chains[current] = nftChain{}
+		case current == "":
+		case strings.HasPrefix(line, "type "):
+			chain := chains[current]
+			fields := strings.Fields(strings.NewReplacer(";", " ").Replace(line))
+			for i := 0; i+1 < len(fields); i++ {
+				switch fields[i] {
+				case "hook":
+					chain.Hook = fields[i+1]
+				case "policy":
+					chain.Policy = fields[i+1]
+				}
+			}
+			chains[current] = chain
+		default:
+			chain := chains[current]
+			chain.Rules++
+			chains[current] = chain
+		}
+	}
+
+	return chains
+}
+
+// parseNftTableDump parses the chains of `nft -j list table` output
+func parseNftTableDump(data []byte) (map[string]nftChain, error) {
+	var dump struct {
+		Nftables []struct {
+			Chain *struct {
+				Name   string `json:"name"`
+				Hook   string `json:"hook"`
+				Policy string `json:"policy"`
+			} `json:"chain"`
+			Rule *struct {
+				Chain string `json:"chain"`
+			} `json:"rule"`
+		} `json:"nftables"`
+	}
+	if err := json.Unmarshal(data, &dump); err != nil {
+		return nil, fmt.Errorf("failed to parse nftables dump: %w", err)
+	}
+
+	chains := make(map[string]nftChain)
+	for _, object := range dump.Nftables {
+		switch {
+		case object.Chain != nil:
+			chain := chains[object.Chain.Name]
+			chain.Hook = object.Chain.Hook
+			chain.Policy = object.Chain.Policy
+			chains[object.Chain.Name] = chain
+		case object.Rule != nil:
+			chain := chains[object.Rule.Chain]
+			chain.Rules++
+			chains[object.Rule.Chain] = chain
+		}
+	}
+	return chains, nil
 }
 
 // Rollback rolls back firewall changes
 func (a *FirewallApplier) Rollback(ctx context.Context) error {
 	if a.previousRules == "" {
-		return fmt.Errorf("no previous rules to restore")
+		return ErrNoRollbackState
 	}
 
 	logger.Info("Rolling back firewall configuration")
 
-	// Restore previous rules
-	return a.applyNftables(ctx, a.previousRules)
+	if len(Quotas.Definitions()) > 0 {
+		if err := Quotas.Sync(ctx); err != nil {
+			logger.Warn("Failed to update quota usage", "error", err)
+		}
+	}
+
+	// Restore previous rules (the saved listing doesn't flush, so replace the whole ruleset)
+	if err := a.applyNftables(ctx, "flush ruleset\n"+a.previousRules); err != nil {
+		return err
+	}
+
+	if err := Quotas.Configure(ctx, a.previousQuotas); err != nil {
+		return fmt.Errorf("failed to restore quotas: %w", err)
+	}
+	return nil
 }
 
 // saveCurrentRules saves the current nftables ruleset
@@ -99,13 +319,53 @@ func validatePolicy(policy string) error {
 }
 
 // generateNftables generates nftables configuration from UCI config
-func (a *FirewallApplier) generateNftables(config *uci.Config) (string, error) {
+// client is the committing client's address for the anti-lockout rule, if known.
+// network is the network config whose VPN kill switches are enforced, if any.
+func (a *FirewallApplier) generateNftables(config *uci.Config, client net.IP, network *uci.Config) (string, error) {
 	var buf bytes.Buffer
 
 	buf.WriteString("#!/usr/sbin/nft -f\n\n")
 	buf.WriteString("flush ruleset\n\n")
 	buf.WriteString("table inet router {\n")
 
+	a.mu.Lock()
+	management := a.management
+	a.mu.Unlock()
+
+	vrfs, err := VRFs(network)
+	if err != nil {
+		return "", err
+	}
+	members := VRFMembers(vrfs)
+
+	macSets, macRules, err := macFilter(config, members)
+	if err != nil {
+		return "", err
+	}
+	buf.WriteString(macSets)
+
+	quotas, err := parseQuotas(config)
+	if err != nil {
+		return "", err
+	}
+	quotaObjects, quotaChainRules := quotaRules(quotas)
+
+	policies, err := VPNPolicies(network)
+	if err != nil {
+		return "", err
+	}
+	killSwitches, err := killSwitchRules(config, policies)
+	if err != nil {
+		return "", err
+	}
+	buf.WriteString(quotaObjects)
+
+	// Clients allowed through the single-packet authorization gate
+	if management != nil && management.SPA != nil {
+		buf.WriteString(fmt.Sprintf("\tset %s { type ipv4_addr; flags timeout; }\n", SPASetIPv4))
+		buf.WriteString(fmt.Sprintf("\tset %s { type ipv6_addr; flags timeout; }\n\n", SPASetIPv6))
+	}
+
 	// Get defaults
 	defaults := config.GetSection("defaults", "")
 	inputPolicy := "accept"
@@ -138,16 +398,30 @@ func (a *FirewallApplier) generateNftables(config *uci.Config) (string, error) {
 	buf.WriteString(fmt.Sprintf("\t\ttype filter hook input priority filter; policy %s;\n\n", inputPolicy))
 	buf.WriteString("\t\t# Allow loopback\n")
 	buf.WriteString("\t\tiif lo accept\n\n")
+	buf.WriteString(macRules)
 	buf.WriteString("\t\t# Allow established/related\n")
 	buf.WriteString("\t\tct state established,related accept\n\n")
 	buf.WriteString("\t\t# Allow ICMP\n")
 	buf.WriteString("\t\tip protocol icmp accept\n")
 	buf.WriteString("\t\tip6 nexthdr icmpv6 accept\n")
+
+	if management != nil {
+		rules, err := managementRules(config, management, client, members)
+		if err != nil {
+			return "", err
+		}
+		buf.WriteString(rules)
+	}
+
 	buf.WriteString("\t}\n\n")
 
 	// Forward chain with rules
 	buf.WriteString("\tchain forward {\n")
 	buf.WriteString(fmt.Sprintf("\t\ttype filter hook forward priority filter; policy %s;\n\n", forwardPolicy))
+	buf.WriteString(macRules)
+	buf.WriteString(quotaChainRules)
+	// Before established flows, which the kill switch cuts off too
+	buf.WriteString(killSwitches)
 	buf.WriteString("\t\t# Allow established/related\n")
 	buf.WriteString("\t\tct state established,related accept\n\n")
 
@@ -168,7 +442,11 @@ func (a *FirewallApplier) generateNftables(config *uci.Config) (string, error) {
 			if err := util.ValidateInterfaceName(src); err != nil {
 				return "", fmt.Errorf("invalid source interface %s: %w", src, err)
 			}
-			ruleStr += fmt.Sprintf("iifname \"%s\" ", src)
+			if members[src] != "" {
+				ruleStr += fmt.Sprintf("meta sdifname \"%s\" ", src)
+			} else {
+				ruleStr += fmt.Sprintf("iifname \"%s\" ", src)
+			}
 		}
 
 		// Destination interface
@@ -270,6 +548,247 @@ func (a *FirewallApplier) generateNftables(config *uci.Config) (string, error) {
 	return buf.String(), nil
 }
 
+// macFilter renders each zone's mac_allow and mac_deny lists as sets of
+// ether addresses, and the rules dropping traffic from the zone's interfaces
+// whose source MAC isn't allowed or is denied. members maps interfaces
+// enslaved to a VRF to it.
+func macFilter(config *uci.Config, members map[string]string) (sets, rules string, err error) {
+	var setBuf, ruleBuf bytes.Buffer
+
+	for _, zone := range config.GetSectionsByType("zone") {
+		allow := zone.GetList("mac_allow")
+		deny := zone.GetList("mac_deny")
+		if len(allow) == 0 && len(deny) == 0 {
+			continue
+		}
+
+		name, _ := zone.GetOption("name")
+		if !validSetName(name) {
+			return "", "", fmt.Errorf("zone name %q can't be used for MAC filter sets (letters, digits and underscores only)", name)
+		}
+
+		interfaces := make([]string, 0)
+		for _, network := range zone.GetList("network") {
+			if err := util.ValidateInterfaceName(network); err != nil {
+				return "", "", fmt.Errorf("invalid network interface %s: %w", network, err)
+			}
+			interfaces = append(interfaces, network)
+		}
+		if len(interfaces) == 0 {
+			return "", "", fmt.Errorf("zone %s has MAC filter lists but no networks", name)
+		}
+
+		ruleBuf.WriteString(fmt.Sprintf("\t\t# MAC filter for zone: %s\n", name))
+		for _, list := range []struct {
+			kind string
+			macs []string
+			rule string
+		}{
+			{"allow", allow, "%s ether saddr != @%s drop\n"},
+			{"deny", deny, "%s ether saddr @%s drop\n"},
+		} {
+			if len(list.macs) == 0 {
+				continue
+			}
+
+			elements := make([]string, 0, len(list.macs))
+			for _, mac := range list.macs {
+				if err := util.ValidateMAC(mac); err != nil {
+					return "", "", fmt.Errorf("invalid mac_%s entry in zone %s: %w", list.kind, name, err)
+				}
+				elements = append(elements, strings.ToLower(mac))
+			}
+
+			set := fmt.Sprintf("mac_%s_%s", list.kind, name)
+			setBuf.WriteString(fmt.Sprintf("\tset %s { type ether_addr; elements = { %s } }\n", set, strings.Join(elements, ", ")))
+			for _, match := range receivedOn(interfaces, members) {
+				ruleBuf.WriteString("\t\t" + fmt.Sprintf(list.rule, match, set))
+			}
+		}
+		ruleBuf.WriteString("\n")
+	}
+
+	if setBuf.Len() > 0 {
+		setBuf.WriteString("\n")
+	}
+	return setBuf.String(), ruleBuf.String(), nil
+}
+
+// validSetName reports whether name can be part of an nft set name
+func validSetName(name string) bool {
+	if name == "" {
+		return false
+	}
+	for _, r := range name {
+		if !(r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || r == '_') {
+			return false
+		}
+	}
+	return true
+}
+
+// managementRules generates the anti-lockout rule and, for each management
+// service, input rules that only accept its ports from the allowed
+// interfaces and drop them everywhere else. members maps interfaces enslaved
+// to a VRF to it.
+func managementRules(config *uci.Config, access *ManagementAccess, client net.IP, members map[string]string) (string, error) {
+	var buf bytes.Buffer
+
+	if len(access.AntiLockout) > 0 && client != nil && !client.IsLoopback() {
+		family := "ip"
+		if client.To4() == nil {
+			family = "ip6"
+		}
+		buf.WriteString(fmt.Sprintf("\n\t\t# Anti-lockout: keep the committing client (%s) connected\n", client))
+		buf.WriteString(fmt.Sprintf("\t\t%s saddr %s tcp dport { %s } accept\n", family, client, portSet(access.AntiLockout)))
+	}
+
+	// Before the service rules, so knocking is required even from allowed zones
+	if gate := access.SPA; gate != nil && len(gate.Ports) > 0 {
+		ports := portSet(gate.Ports)
+		buf.WriteString("\n\t\t# Single-packet authorization: closed until a client knocks\n")
+		buf.WriteString(fmt.Sprintf("\t\tudp dport %d accept\n", gate.ListenPort))
+		buf.WriteString(fmt.Sprintf("\t\tip saddr != @%s tcp dport { %s } drop\n", SPASetIPv4, ports))
+		buf.WriteString(fmt.Sprintf("\t\tip6 saddr != @%s tcp dport { %s } drop\n", SPASetIPv6, ports))
+	}
+
+	for _, service := range access.Services {
+		if len(service.Ports) == 0 {
+			continue
+		}
+
+		interfaces, err := serviceInterfaces(config, service)
+		if err != nil {
+			return "", err
+		}
+		ports := portSet(service.Ports)
+
+		if len(interfaces) == 0 {
+			buf.WriteString(fmt.Sprintf("\n\t\t# Management %s: from anywhere\n", service.Name))
+			buf.WriteString(fmt.Sprintf("\t\ttcp dport { %s } accept\n", ports))
+			continue
+		}
+
+		buf.WriteString(fmt.Sprintf("\n\t\t# Management %s: only from allowed zones/interfaces\n", service.Name))
+		for _, match := range receivedOn(interfaces, members) {
+			buf.WriteString(fmt.Sprintf("\t\t%s tcp dport { %s } accept\n", match, ports))
+		}
+		buf.WriteString(fmt.Sprintf("\t\ttcp dport { %s } drop\n", ports))
+	}
+
+	// Authorized clients get in even where the input policy drops
+	if gate := access.SPA; gate != nil && len(gate.Ports) > 0 {
+		ports := portSet(gate.Ports)
+		buf.WriteString(fmt.Sprintf("\t\tip saddr @%s tcp dport { %s } accept\n", SPASetIPv4, ports))
+		buf.WriteString(fmt.Sprintf("\t\tip6 saddr @%s tcp dport { %s } accept\n", SPASetIPv6, ports))
+	}
+
+	return buf.String(), nil
+}
+
+// AllowSPAClient adds a client to the SPA client sets for the given duration
+func AllowSPAClient(ctx context.Context, addr net.IP, duration time.Duration) error {
+	set := SPASetIPv4
+	if addr.To4() == nil {
+		set = SPASetIPv6
+	}
+
+	element := fmt.Sprintf("{ %s timeout %ds }", addr, max(int(duration.Seconds()), 1))
+	return runCommandContext(ctx, "nft", "add", "element", "inet", "router", set, element)
+}
+
+// serviceInterfaces resolves the interface names a management service is
+// allowed from, or none if it isn't restricted
+func serviceInterfaces(config *uci.Config, service ManagementService) ([]string, error) {
+	interfaces := make([]string, 0, len(service.Interfaces))
+	seen := make(map[string]bool)
+	addInterface := func(name string) error {
+		if err := util.ValidateInterfaceName(name); err != nil {
+			return fmt.Errorf("invalid management interface %s: %w", name, err)
+		}
+		if !seen[name] {
+			seen[name] = true
+			interfaces = append(interfaces, name)
+		}
+		return nil
+	}
+
+	for _, name := range service.Interfaces {
+		if err := addInterface(name); err != nil {
+			return nil, err
+		}
+	}
+
+	for _, zoneName := range service.Zones {
+		found := false
+		for _, zone := range config.GetSectionsByType("zone") {
+			if name, _ := zone.GetOption("name"); name != zoneName {
+				continue
+			}
+			found = true
+			for _, network := range zone.GetList("network") {
+				if err := addInterface(network); err != nil {
+					return nil, err
+				}
+			}
+		}
+
+		// Refuse rather than generate rules that lock everyone out
+		if !found {
+			return nil, fmt.Errorf("management zone %s is not defined in the firewall config", zoneName)
+		}
+	}
+
+	if len(interfaces) == 0 && (len(service.Zones) > 0 || len(service.Interfaces) > 0) {
+		return nil, fmt.Errorf("management access restriction for %s resolves to no interfaces", service.Name)
+	}
+
+	return interfaces, nil
+}
+
+// receivedOn returns the matches for traffic received on interfaces. The
+// input and forward hooks see the traffic of an interface enslaved to a VRF
+// as received on the VRF device, so it is matched by its slave device
+// (sdifname) instead.
+func receivedOn(interfaces []string, members map[string]string) []string {
+	var direct, enslaved []string
+	for _, name := range interfaces {
+		if members[name] != "" {
+			enslaved = append(enslaved, fmt.Sprintf("\"%s\"", name))
+		} else {
+			direct = append(direct, fmt.Sprintf("\"%s\"", name))
+		}
+	}
+
+	var matches []string
+	if len(direct) > 0 {
+		matches = append(matches, fmt.Sprintf("iifname { %s }", strings.Join(direct, ", ")))
+	}
+	if len(enslaved) > 0 {
+		matches = append(matches, fmt.Sprintf("meta sdifname { %s }", strings.Join(enslaved, ", ")))
+	}
+	return matches
+}
+
+// portSet formats ports as the elements of an nft anonymous set
+func portSet(ports []int) string {
+	elements := make([]string, 0, len(ports))
+	for _, port := range ports {
+		elements = append(elements, strconv.Itoa(port))
+	}
+	return strings.Join(elements, ", ")
+}
+
+// committingClient returns the address of the client committing the change,
+// as recorded for the audit log, or nil if unknown
+func committingClient(ctx context.Context) net.IP {
+	addr := audit.IPFromContext(ctx)
+	if host, _, err := net.SplitHostPort(addr); err == nil {
+		addr = host
+	}
+	return net.ParseIP(addr)
+}
+
 // applyNftables applies nftables configuration
 func (a *FirewallApplier) applyNftables(ctx context.Context, nftConfig string) error {
 	cmd := exec.CommandContext(ctx, "nft", "-f", "-")
@@ -0,0 +1,248 @@
+package appliers
+
+import (
+	"net"
+	"strings"
+	"testing"
+
+	"github.com/thesabbir/hellfire/pkg/uci"
+)
+
+func TestFirewallChainsMatchDump(t *testing.T) {
+	config, err := uci.Parse(strings.NewReader(`
+config defaults
+	option input 'drop'
+
+config rule
+	option name 'Allow-SSH'
+	option src 'eth0'
+	option proto 'tcp'
+	option dest_port '22'
+
+config zone
+	option name 'wan'
+	option masq '1'
+	list network 'eth1'
+`))
+	if err != nil {
+		t.Fatalf("parse config: %v", err)
+	}
+
+	nftConfig, err := NewFirewallApplier().generateNftables(config, nil, nil)
+	if err != nil {
+		t.Fatalf("generateNftables: %v", err)
+	}
+
+	expected := generatedChains(nftConfig)
+	want := map[string]nftChain{
+		"input":       {Hook: "input", Policy: "drop", Rules: 4},
+		"forward":     {Hook: "forward", Policy: "drop", Rules: 3},
+		"output":      {Hook: "output", Policy: "accept"},
+		"prerouting":  {Hook: "prerouting", Policy: "accept"},
+		"postrouting": {Hook: "postrouting", Policy: "accept", Rules: 1},
+	}
+	if len(expected) != len(want) {
+		t.Fatalf("generated chains = %+v, want %+v", expected, want)
+	}
+	for name, chain := range want {
+		if expected[name] != chain {
+			t.Errorf("chain %s = %+v, want %+v", name, expected[name], chain)
+		}
+	}
+
+	// Trimmed `nft -j list table inet router` output missing one forward rule
+	dump := `{"nftables": [{"metainfo": {"version": "1.0.9", "json_schema_version": 1}},
+		{"table": {"family": "inet", "name": "router", "handle": 1}},
+		{"chain": {"family": "inet", "table": "router", "name": "input", "handle": 1, "type": "filter", "hook": "input", "prio": 0, "policy": "drop"}},
+		{"chain": {"family": "inet", "table": "router", "name": "forward", "handle": 2, "type": "filter", "hook": "forward", "prio": 0, "policy": "drop"}},
+		{"chain": {"family": "inet", "table": "router", "name": "output", "handle": 3, "type": "filter", "hook": "output", "prio": 0, "policy": "accept"}},
+		{"chain": {"family": "inet", "table": "router", "name": "prerouting", "handle": 4, "type": "nat", "hook": "prerouting", "prio": -100, "policy": "accept"}},
+		{"chain": {"family": "inet", "table": "router", "name": "postrouting", "handle": 5, "type": "nat", "hook": "postrouting", "prio": 100, "policy": "accept"}},
+		{"rule": {"family": "inet", "table": "router", "chain": "input", "handle": 6}},
+		{"rule": {"family": "inet", "table": "router", "chain": "input", "handle": 7}},
+		{"rule": {"family": "inet", "table": "router", "chain": "input", "handle": 8}},
+		{"rule": {"family": "inet", "table": "router", "chain": "input", "handle": 9}},
+		{"rule": {"family": "inet", "table": "router", "chain": "forward", "handle": 10}},
+		{"rule": {"family": "inet", "table": "router", "chain": "forward", "handle": 11}},
+		{"rule": {"family": "inet", "table": "router", "chain": "postrouting", "handle": 12}}]}`
+
+	loaded, err := parseNftTableDump([]byte(dump))
+	if err != nil {
+		t.Fatalf("parseNftTableDump: %v", err)
+	}
+
+	err = compareChains(expected, loaded)
+	if err == nil || !strings.Contains(err.Error(), "chain forward has 2 rules, expected 3") {
+		t.Errorf("compareChains = %v, want forward rule count mismatch", err)
+	}
+
+	loaded["forward"] = expected["forward"]
+	if err := compareChains(expected, loaded); err != nil {
+		t.Errorf("compareChains after fix = %v", err)
+	}
+}
+
+func TestManagementRules(t *testing.T) {
+	config, err := uci.Parse(strings.NewReader(`
+config zone
+	option name 'lan'
+	list network 'br-lan'
+`))
+	if err != nil {
+		t.Fatalf("parse config: %v", err)
+	}
+
+	access := &ManagementAccess{
+		Services: []ManagementService{
+			{Name: "api", Ports: []int{8888}, Zones: []string{"lan"}},
+			{Name: "ssh", Ports: []int{22}},
+		},
+		AntiLockout: []int{22, 8888},
+	}
+
+	rules, err := managementRules(config, access, net.ParseIP("203.0.113.7"), nil)
+	if err != nil {
+		t.Fatalf("managementRules: %v", err)
+	}
+	for _, want := range []string{
+		"ip saddr 203.0.113.7 tcp dport { 22, 8888 } accept\n",
+		"iifname { \"br-lan\" } tcp dport { 8888 } accept\n\t\ttcp dport { 8888 } drop\n",
+		"tcp dport { 22 } accept\n",
+	} {
+		if !strings.Contains(rules, want) {
+			t.Errorf("rules missing %q:\n%s", want, rules)
+		}
+	}
+
+	// Loopback clients are accepted anyway
+	rules, _ = managementRules(config, access, net.ParseIP("127.0.0.1"), nil)
+	if strings.Contains(rules, "saddr") {
+		t.Errorf("unexpected anti-lockout rule for loopback:\n%s", rules)
+	}
+
+	access.SPA = &SPAGate{ListenPort: 62201, Ports: []int{22, 8888}}
+	rules, err = managementRules(config, access, nil, nil)
+	if err != nil {
+		t.Fatalf("managementRules with SPA: %v", err)
+	}
+	gate := strings.Index(rules, "ip saddr != @spa_clients4 tcp dport { 22, 8888 } drop")
+	service := strings.Index(rules, "iifname { \"br-lan\" }")
+	if gate < 0 || service < gate || !strings.Contains(rules, "udp dport 62201 accept") {
+		t.Errorf("SPA gate missing or after the service rules:\n%s", rules)
+	}
+
+	// Interfaces enslaved to a VRF are matched by their slave device
+	rules, _ = managementRules(config, access, nil, map[string]string{"br-lan": "mgmt"})
+	if !strings.Contains(rules, "meta sdifname { \"br-lan\" } tcp dport { 8888 } accept\n") || strings.Contains(rules, "iifname") {
+		t.Errorf("VRF member not matched by sdifname:\n%s", rules)
+	}
+
+	access.Services[0].Zones = []string{"dmz"}
+	if _, err := managementRules(config, access, nil, nil); err == nil {
+		t.Error("expected an error for an undefined management zone")
+	}
+}
+
+func TestMACFilter(t *testing.T) {
+	config, err := uci.Parse(strings.NewReader(`
+config zone
+	option name 'lan'
+	list network 'br-lan'
+	list mac_allow '02:00:00:00:00:01'
+	list mac_allow '02:00:00:00:00:0A'
+	list mac_deny '02:00:00:00:00:02'
+
+config zone
+	option name 'wan'
+	list network 'eth1'
+`))
+	if err != nil {
+		t.Fatalf("parse config: %v", err)
+	}
+
+	sets, rules, err := macFilter(config, nil)
+	if err != nil {
+		t.Fatalf("macFilter: %v", err)
+	}
+	for _, want := range []string{
+		"set mac_allow_lan { type ether_addr; elements = { 02:00:00:00:00:01, 02:00:00:00:00:0a } }\n",
+		"set mac_deny_lan { type ether_addr; elements = { 02:00:00:00:00:02 } }\n",
+	} {
+		if !strings.Contains(sets, want) {
+			t.Errorf("sets missing %q:\n%s", want, sets)
+		}
+	}
+	for _, want := range []string{
+		"iifname { \"br-lan\" } ether saddr != @mac_allow_lan drop\n",
+		"iifname { \"br-lan\" } ether saddr @mac_deny_lan drop\n",
+	} {
+		if !strings.Contains(rules, want) {
+			t.Errorf("rules missing %q:\n%s", want, rules)
+		}
+	}
+	if strings.Contains(sets+rules, "wan") {
+		t.Errorf("unexpected MAC filter for a zone without lists:\n%s%s", sets, rules)
+	}
+
+	config.GetSectionsByType("zone")[1].AddListValue("mac_deny", "not-a-mac")
+	if _, _, err := macFilter(config, nil); err == nil {
+		t.Error("expected an error for an invalid MAC address")
+	}
+}
+
+func TestKillSwitch(t *testing.T) {
+	firewall, err := uci.Parse(strings.NewReader(`
+config zone
+	option name 'wan'
+	option masq '1'
+	list network 'wan'
+	list network 'wg0'
+`))
+	if err != nil {
+		t.Fatalf("parse firewall: %v", err)
+	}
+	network, err := uci.Parse(strings.NewReader(`
+config interface 'wg0'
+	option proto 'wireguard'
+	option route_all '1'
+	option kill_switch '1'
+	list split_tunnel '192.168.50.0/24'
+`))
+	if err != nil {
+		t.Fatalf("parse network: %v", err)
+	}
+
+	nftConfig, err := NewFirewallApplier().generateNftables(firewall, nil, network)
+	if err != nil {
+		t.Fatalf("generateNftables: %v", err)
+	}
+	for _, rule := range []string{
+		"oifname { \"wan\" } ip daddr != { 192.168.50.0/24 } drop\n",
+		"oifname { \"wan\" } meta nfproto ipv6 drop\n",
+	} {
+		if !strings.Contains(nftConfig, rule) {
+			t.Errorf("ruleset missing %q:\n%s", rule, nftConfig)
+		}
+	}
+	if strings.Index(nftConfig, "# Kill switch: wg0") > strings.LastIndex(nftConfig, "# Allow established/related") {
+		t.Error("kill switch after the established rule of the forward chain")
+	}
+
+	commands := vpnRoutingCommands([]VPNPolicy{{Interface: "wg0", RouteAll: true, KillSwitch: true}})
+	if len(commands) == 0 || strings.Join(commands[0], " ") != "wg set wg0 fwmark 51820" {
+		t.Errorf("routing commands = %v", commands)
+	}
+
+	// The kill switch needs a WAN zone besides the tunnel
+	zone := firewall.GetSectionsByType("zone")[0]
+	zone.DeleteOption("network")
+	zone.AddListValue("network", "wg0")
+	if _, err := NewFirewallApplier().generateNftables(firewall, nil, network); err == nil {
+		t.Error("expected an error without a zone to block")
+	}
+
+	network.GetSection("interface", "wg0").SetOption("proto", "static")
+	if _, err := VPNPolicies(network); err == nil {
+		t.Error("expected an error for a kill switch on a static interface")
+	}
+}
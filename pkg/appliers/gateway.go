@@ -0,0 +1,346 @@
+package appliers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/thesabbir/hellfire/pkg/logger"
+	"github.com/thesabbir/hellfire/pkg/uci"
+	"github.com/thesabbir/hellfire/pkg/util"
+)
+
+// Default routes of static interfaces. Each goes into the main table with
+// the interface's metric, so the lowest metric wins and the others take over
+// when it goes away, and into a table of its own, looked up for traffic from
+// the interface's subnet so replies leave through the WAN they came in on.
+// Those of interfaces in a VRF only go into the VRF's table.
+const (
+	// RouteProto marks the routes the network applier owns (`proto 104` in
+	// ip route). Routes of other protocols are never replaced or removed.
+	RouteProto = 104
+
+	// GatewayTableBase + n is the table of the nth static interface with a
+	// gateway, unless its ip4table option picks one
+	GatewayTableBase = 52000
+
+	gatewayRulePriority = 20000 // from <subnet> lookup <table>
+)
+
+// Gateway is the default route of a static interface
+type Gateway struct {
+	Interface string `json:"interface"`
+	Address   string `json:"address"` // Of the interface
+	Subnet    string `json:"subnet"`  // CIDR
+	Gateway   string `json:"gateway"`
+	Metric    int    `json:"metric"`
+	Table     int    `json:"table"`
+	VRF       string `json:"vrf,omitempty"` // The VRF the interface is in, whose table this is
+}
+
+// gatewayRule is a source routing rule, as reported by `ip -j rule show`
+type gatewayRule struct {
+	Src    string `json:"src"`
+	SrcLen int    `json:"srclen,omitempty"`
+	Table  string `json:"table"`
+}
+
+// Gateways reads the default routes of the static interfaces of a network
+// config, in section order. Two of them in the same routing domain can't
+// share a metric, where one would replace the other, and those outside a
+// VRF can't share a table.
+func Gateways(network *uci.Config) ([]Gateway, error) {
+	vrfs, err := VRFs(network)
+	if err != nil {
+		return nil, err
+	}
+	tables := vrfTables(vrfs)
+
+	var gateways []Gateway
+	numbered := 0 // Gateways outside a VRF
+	for _, section := range network.GetSectionsByType("interface") {
+		proto, _ := section.GetOption("proto")
+		gateway, ok := section.GetOption("gateway")
+		if section.Name == "" || proto != "static" || !ok {
+			continue
+		}
+		ipaddr, _ := section.GetOption("ipaddr")
+		netmask, _ := section.GetOption("netmask")
+		if util.ValidateIPAddress(ipaddr) != nil || util.ValidateNetmask(netmask) != nil || util.ValidateIPAddress(gateway) != nil {
+			// Reported by the interface itself
+			continue
+		}
+		link, err := parseLinkOptions(section)
+		if err != nil {
+			continue
+		}
+
+		_, subnet, _ := net.ParseCIDR(fmt.Sprintf("%s/%d", ipaddr, convertNetmaskToCIDR(netmask)))
+		g := Gateway{
+			Interface: section.Name,
+			Address:   ipaddr,
+			Subnet:    subnet.String(),
+			Gateway:   gateway,
+			Metric:    link.Metric,
+			Table:     GatewayTableBase + numbered + 1,
+		}
+		if vrf := interfaceVRF(section); vrf != "" {
+			if _, ok := section.GetOption("ip4table"); ok {
+				return nil, fmt.Errorf("interface %s: ip4table can't be set in vrf %s, whose table it uses", section.Name, vrf)
+			}
+			g.VRF, g.Table = vrf, tables[vrf]
+		} else {
+			numbered++
+		}
+		if table, ok := section.GetOption("ip4table"); ok {
+			n, err := strconv.Atoi(table)
+			if err != nil || n < 1 || n > 0x7fffffff || n == 253 || n == 254 || n == 255 || n == VPNTable {
+				return nil, fmt.Errorf("interface %s: invalid ip4table %q: must be a table number other than 253-255 and %d", section.Name, table, VPNTable)
+			}
+			g.Table = n
+		}
+
+		for _, other := range gateways {
+			if other.VRF != g.VRF {
+				continue
+			}
+			if other.Metric == g.Metric {
+				return nil, fmt.Errorf("interface %s: %s already has a default route with metric %d; give one of them another metric", section.Name, other.Interface, g.Metric)
+			}
+			if g.VRF == "" && other.Table == g.Table {
+				return nil, fmt.Errorf("interface %s: %s already uses routing table %d", section.Name, other.Interface, g.Table)
+			}
+		}
+		if g.VRF == "" {
+			for _, vrf := range vrfs {
+				if vrf.Table == g.Table {
+					return nil, fmt.Errorf("interface %s: vrf %s already uses routing table %d", section.Name, vrf.Name, g.Table)
+				}
+			}
+		}
+		gateways = append(gateways, g)
+	}
+	return gateways, nil
+}
+
+// gatewayRoutes lists the routes the network applier owns for gateways
+func gatewayRoutes(gateways []Gateway) []routeState {
+	var routes []routeState
+	proto := strconv.Itoa(RouteProto)
+	for _, g := range gateways {
+		table := strconv.Itoa(g.Table)
+		if g.VRF != "" {
+			// The kernel routes the subnet in the VRF's table
+			routes = append(routes,
+				routeState{Family: "inet", Dst: "default", Gateway: g.Gateway, Dev: g.Interface, Protocol: proto, Metric: g.Metric, Table: table})
+			continue
+		}
+		routes = append(routes,
+			routeState{Family: "inet", Dst: "default", Gateway: g.Gateway, Dev: g.Interface, Protocol: proto, Metric: g.Metric},
+			routeState{Family: "inet", Dst: g.Subnet, Dev: g.Interface, Protocol: proto, Scope: "link", PrefSrc: g.Address, Table: table},
+			routeState{Family: "inet", Dst: "default", Gateway: g.Gateway, Dev: g.Interface, Protocol: proto, Table: table})
+	}
+	return routes
+}
+
+// gatewayRules lists the source routing rules of gateways; the VRF's own
+// rule routes the traffic of its interfaces
+func gatewayRules(gateways []Gateway) []gatewayRule {
+	var rules []gatewayRule
+	for _, g := range gateways {
+		if g.VRF != "" {
+			continue
+		}
+		ip, subnet, _ := net.ParseCIDR(g.Subnet)
+		ones, _ := subnet.Mask.Size()
+		rules = append(rules, gatewayRule{Src: ip.String(), SrcLen: ones, Table: strconv.Itoa(g.Table)})
+	}
+	return rules
+}
+
+// sameRoute reports whether two routes are the same route: in the same
+// table, to the same destination, through the same device and gateway and
+// with the same metric
+func sameRoute(a, b routeState) bool {
+	return a.Dst == b.Dst && a.Gateway == b.Gateway && a.Dev == b.Dev && a.Metric == b.Metric && a.Table == b.Table
+}
+
+// captureOwnedRoutes dumps the IPv4 routes the network applier owns, in all tables
+func captureOwnedRoutes(ctx context.Context) ([]routeState, error) {
+	routes, err := captureRoutes(ctx, "inet", "table", "all", "proto", strconv.Itoa(RouteProto))
+	if err != nil {
+		return nil, err
+	}
+	for i := range routes {
+		routes[i].Protocol = strconv.Itoa(RouteProto)
+	}
+	return routes, nil
+}
+
+// captureGatewayRules dumps the source routing rules of the gateways
+func captureGatewayRules(ctx context.Context) ([]gatewayRule, error) {
+	output, err := commandOutputContext(ctx, "ip", "-4", "-j", "rule", "show", "priority", strconv.Itoa(gatewayRulePriority))
+	if err != nil {
+		return nil, err
+	}
+	var rules []gatewayRule
+	if err := json.Unmarshal(output, &rules); err != nil {
+		return nil, fmt.Errorf("failed to parse rule dump: %w", err)
+	}
+	return rules, nil
+}
+
+// syncOwnedRoutes makes routes the owned routes: owned routes not among them
+// are removed, and the others replaced in place, so a default route that
+// stays is never missing. A route of another protocol in the way of one
+// (the same destination and metric in the same table) is left alone and the
+// owned route added behind it.
+func syncOwnedRoutes(ctx context.Context, routes []routeState) error {
+	owned, err := captureOwnedRoutes(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to read routes: %w", err)
+	}
+	for _, route := range owned {
+		if slices.ContainsFunc(routes, func(r routeState) bool { return sameRoute(r, route) }) {
+			continue
+		}
+		args := route.replaceArgs(route.Dev)
+		args[2] = "del"
+		if err := runCommandContext(ctx, "ip", args...); err != nil {
+			return fmt.Errorf("failed to remove route %s via %s: %w", route.Dst, route.Dev, err)
+		}
+	}
+
+	var errs []error
+	for _, route := range routes {
+		args := route.replaceArgs(route.Dev)
+		foreign, err := foreignRoute(ctx, route)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		if foreign != nil {
+			logger.Warn("Route of another protocol takes precedence; give the interface another metric",
+				"dst", route.Dst, "dev", route.Dev, "metric", route.Metric,
+				"other_dev", foreign.Dev, "other_protocol", foreign.Protocol)
+			if slices.ContainsFunc(owned, func(r routeState) bool { return sameRoute(r, route) }) {
+				continue
+			}
+			args[2] = "append"
+		}
+		if err := runCommandContext(ctx, "ip", args...); err != nil {
+			errs = append(errs, fmt.Errorf("failed to add route %s via %s: %w", route.Dst, route.Dev, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// foreignRoute returns a route of another protocol in the main table that
+// replacing route would replace, if any. The gateway tables are hellfire's.
+func foreignRoute(ctx context.Context, route routeState) (*routeState, error) {
+	if route.Table != "" {
+		return nil, nil
+	}
+	routes, err := captureRoutes(ctx, "inet", route.Dst)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read routes: %w", err)
+	}
+	for _, r := range routes {
+		if r.Metric == route.Metric && r.Protocol != route.Protocol && !(r.Gateway == route.Gateway && r.Dev == route.Dev) {
+			return &r, nil
+		}
+	}
+	return nil, nil
+}
+
+// replaceGatewayRules replaces the source routing rules of the gateways
+func replaceGatewayRules(ctx context.Context, rules []gatewayRule) error {
+	priority := strconv.Itoa(gatewayRulePriority)
+	// Each del removes one rule; stop once there are none left
+	for range 256 {
+		if runCommandContext(ctx, "ip", "-4", "rule", "del", "priority", priority) != nil {
+			break
+		}
+	}
+	for _, rule := range rules {
+		if err := runCommandContext(ctx, "ip", rule.addArgs()...); err != nil {
+			return fmt.Errorf("failed to add rule from %s: %w", rule.from(), err)
+		}
+	}
+	return nil
+}
+
+func (r gatewayRule) from() string {
+	if r.SrcLen == 0 {
+		return r.Src
+	}
+	return r.Src + "/" + strconv.Itoa(r.SrcLen)
+}
+
+// addArgs returns the `ip rule add` arguments of the rule
+func (r gatewayRule) addArgs() []string {
+	return []string{"-4", "rule", "add", "from", r.from(), "lookup", r.Table, "priority", strconv.Itoa(gatewayRulePriority)}
+}
+
+// applyGateways installs the default routes of gateways and their tables and
+// the static routes, and removes those no longer configured
+func applyGateways(ctx context.Context, gateways []Gateway, routes []StaticRoute) error {
+	if err := syncOwnedRoutes(ctx, ownedRoutes(gateways, routes, nil)); err != nil {
+		return err
+	}
+	return replaceGatewayRules(ctx, gatewayRules(gateways))
+}
+
+// FailoverGateways removes the routes of the gateways and static routes whose
+// interface lost its link and installs those of the others, so traffic moves
+// to the default route with the next metric while a WAN cable is unplugged
+// and back once it is plugged in again. The source routing rules stay.
+func FailoverGateways(ctx context.Context, gateways []Gateway, routes []StaticRoute, down []string) error {
+	return syncOwnedRoutes(ctx, ownedRoutes(gateways, routes, down))
+}
+
+// gatewayCommands lists the commands applyGateways runs, for Render
+func gatewayCommands(gateways []Gateway) []string {
+	var commands []string
+	for _, route := range gatewayRoutes(gateways) {
+		commands = append(commands, "ip "+strings.Join(route.replaceArgs(route.Dev), " "))
+	}
+	for _, rule := range gatewayRules(gateways) {
+		commands = append(commands, "ip "+strings.Join(rule.addArgs(), " "))
+	}
+	return commands
+}
+
+// validateGateways checks that the table routes and rules of gateways are
+// installed; the main table's default routes are checked per interface
+func validateGateways(ctx context.Context, gateways []Gateway) error {
+	if len(gateways) == 0 {
+		return nil
+	}
+	owned, err := captureOwnedRoutes(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to read routes: %w", err)
+	}
+	for _, route := range gatewayRoutes(gateways) {
+		if route.Table != "" && !slices.ContainsFunc(owned, func(r routeState) bool { return sameRoute(r, route) }) {
+			return fmt.Errorf("interface %s: route %s in table %s is not installed", route.Dev, route.Dst, route.Table)
+		}
+	}
+	rules, err := captureGatewayRules(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to read rules: %w", err)
+	}
+	for _, g := range gateways {
+		for _, rule := range gatewayRules([]Gateway{g}) {
+			if !slices.Contains(rules, rule) {
+				return fmt.Errorf("interface %s: rule from %s lookup %s is not installed", g.Interface, rule.from(), rule.Table)
+			}
+		}
+	}
+	return nil
+}
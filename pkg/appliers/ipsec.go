@@ -0,0 +1,431 @@
+package appliers
+
+import (
+	"bytes"
+	"context"
+	"encoding/hex"
+	"fmt"
+	"net/netip"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/thesabbir/hellfire/pkg/logger"
+	"github.com/thesabbir/hellfire/pkg/uci"
+	"github.com/thesabbir/hellfire/pkg/util"
+)
+
+// Site-to-site IPsec tunnels are the tunnel sections of the ipsec config,
+// named after the connection. They are rendered to a swanctl config of
+// their own in swanctl's conf.d, which strongSwan reads along with the
+// connections configured by hand, and loaded with swanctl --load-all.
+
+const (
+	IPsecConfigName = "ipsec"
+
+	// ipsecUpAttempts is how many times Validate checks the tunnels that
+	// initiate, a second apart, while their SAs are negotiated
+	ipsecUpAttempts = 20
+)
+
+// SwanctlConfigPath is where the tunnels are rendered
+var SwanctlConfigPath = "/etc/swanctl/conf.d/hellfire.conf"
+
+// IPsecTunnel is an enabled tunnel section
+type IPsecTunnel struct {
+	Name          string         `json:"name"`
+	KeyExchange   string         `json:"keyexchange"`    // "ikev2" or "ikev1"
+	LocalAddress  string         `json:"local_address"`  // Empty for any
+	RemoteAddress string         `json:"remote_address"` // Host name or address of the peer
+	LocalID       string         `json:"local_id"`
+	RemoteID      string         `json:"remote_id"`
+	Auth          string         `json:"auth"` // "psk" or "pubkey"
+	PSK           string         `json:"-"`
+	Cert          string         `json:"cert"` // Our certificate, with pubkey
+	LocalSubnets  []netip.Prefix `json:"local_subnets"`
+	RemoteSubnets []netip.Prefix `json:"remote_subnets"`
+	IKEProposals  string         `json:"ike_proposals"`
+	ESPProposals  string         `json:"esp_proposals"`
+	DPDDelay      int            `json:"dpd_delay"`    // Seconds between dead peer checks
+	StartAction   string         `json:"start_action"` // "start", "trap" or "none"
+}
+
+var (
+	ipsecNamePattern     = regexp.MustCompile(`^[a-zA-Z0-9_]{1,32}$`)
+	ipsecProposalPattern = regexp.MustCompile(`^[a-z0-9_-]+(,[a-z0-9_-]+)*$`)
+)
+
+// IPsecTunnels reads the enabled tunnel sections of an ipsec config, in
+// section order. Each needs the address of its peer, the subnets on both
+// ends, and a pre-shared key or a certificate.
+func IPsecTunnels(config *uci.Config) ([]IPsecTunnel, error) {
+	if config == nil {
+		return nil, nil
+	}
+
+	var tunnels []IPsecTunnel
+	for _, section := range config.GetSectionsByType("tunnel") {
+		if enabled, ok := section.GetOption("enabled"); ok && enabled == "0" {
+			continue
+		}
+		tunnel, err := parseIPsecTunnel(section)
+		if err != nil {
+			return nil, fmt.Errorf("ipsec %s: %w", section.Name, err)
+		}
+		tunnels = append(tunnels, *tunnel)
+	}
+	return tunnels, nil
+}
+
+// parseIPsecTunnel reads and checks a tunnel section
+func parseIPsecTunnel(section *uci.Section) (*IPsecTunnel, error) {
+	if !ipsecNamePattern.MatchString(section.Name) {
+		return nil, fmt.Errorf("tunnel sections must be named with up to 32 letters, digits and underscores")
+	}
+
+	option := func(name, fallback string) string {
+		if value, ok := section.GetOption(name); ok && value != "" {
+			return value
+		}
+		return fallback
+	}
+	tunnel := &IPsecTunnel{
+		Name:          section.Name,
+		KeyExchange:   option("keyexchange", "ikev2"),
+		LocalAddress:  option("local_address", ""),
+		RemoteAddress: option("remote_address", ""),
+		LocalID:       option("local_id", ""),
+		RemoteID:      option("remote_id", ""),
+		Auth:          option("auth", "psk"),
+		PSK:           option("psk", ""),
+		Cert:          option("cert", ""),
+		IKEProposals:  option("ike_proposals", "aes256-sha256-modp2048"),
+		ESPProposals:  option("esp_proposals", "aes256-sha256"),
+		StartAction:   option("start_action", "start"),
+	}
+
+	if tunnel.KeyExchange != "ikev2" && tunnel.KeyExchange != "ikev1" {
+		return nil, fmt.Errorf("invalid keyexchange %q: must be ikev2 or ikev1", tunnel.KeyExchange)
+	}
+	for _, address := range []string{tunnel.LocalAddress, tunnel.RemoteAddress} {
+		if address != "" && util.ValidateIPAddress(address) != nil && util.ValidateHostname(address) != nil {
+			return nil, fmt.Errorf("invalid address %q: must be a host name or address", address)
+		}
+	}
+	if tunnel.RemoteAddress == "" {
+		return nil, fmt.Errorf("remote_address is required")
+	}
+	for _, id := range []string{tunnel.LocalID, tunnel.RemoteID} {
+		if strings.ContainsAny(id, "\"\\{}\r\n") {
+			return nil, fmt.Errorf("invalid identity %q", id)
+		}
+	}
+
+	switch tunnel.Auth {
+	case "psk":
+		if tunnel.PSK == "" {
+			return nil, fmt.Errorf("psk is required with auth psk")
+		}
+	case "pubkey":
+		if !filepath.IsAbs(tunnel.Cert) || strings.ContainsAny(tunnel.Cert, " \t\r\n\"'{}") {
+			return nil, fmt.Errorf("invalid cert %q: auth pubkey needs the absolute path of our certificate", tunnel.Cert)
+		}
+		if tunnel.RemoteID == "" {
+			return nil, fmt.Errorf("remote_id is required with auth pubkey")
+		}
+	default:
+		return nil, fmt.Errorf("invalid auth %q: must be psk or pubkey", tunnel.Auth)
+	}
+
+	var err error
+	if tunnel.LocalSubnets, err = ipsecSubnets(section, "local_subnet"); err != nil {
+		return nil, err
+	}
+	if tunnel.RemoteSubnets, err = ipsecSubnets(section, "remote_subnet"); err != nil {
+		return nil, err
+	}
+
+	for _, proposals := range []string{tunnel.IKEProposals, tunnel.ESPProposals} {
+		if !ipsecProposalPattern.MatchString(proposals) {
+			return nil, fmt.Errorf("invalid proposals %q", proposals)
+		}
+	}
+	delay, err := strconv.Atoi(option("dpd_delay", "30"))
+	if err != nil || delay < 0 || delay > 3600 {
+		return nil, fmt.Errorf("invalid dpd_delay %q: must be 0-3600 seconds", option("dpd_delay", "30"))
+	}
+	tunnel.DPDDelay = delay
+	if tunnel.StartAction != "start" && tunnel.StartAction != "trap" && tunnel.StartAction != "none" {
+		return nil, fmt.Errorf("invalid start_action %q: must be start, trap or none", tunnel.StartAction)
+	}
+	return tunnel, nil
+}
+
+// ipsecSubnets reads a list of subnets a tunnel carries, at least one
+func ipsecSubnets(section *uci.Section, option string) ([]netip.Prefix, error) {
+	var subnets []netip.Prefix
+	for _, value := range section.GetList(option) {
+		for _, field := range strings.Fields(value) {
+			prefix, err := netip.ParsePrefix(field)
+			if err != nil || prefix.Masked() != prefix {
+				return nil, fmt.Errorf("invalid %s %q: must be a network in CIDR notation", option, field)
+			}
+			subnets = append(subnets, prefix)
+		}
+	}
+	if len(subnets) == 0 {
+		return nil, fmt.Errorf("%s is required", option)
+	}
+	return subnets, nil
+}
+
+// renderSwanctl generates the swanctl config of the tunnels
+func renderSwanctl(tunnels []IPsecTunnel) string {
+	var b strings.Builder
+	b.WriteString("# Generated by Hellfire\n\nconnections {\n")
+	for _, tunnel := range tunnels {
+		fmt.Fprintf(&b, "\t%s {\n", tunnel.Name)
+		version := "2"
+		if tunnel.KeyExchange == "ikev1" {
+			version = "1"
+		}
+		fmt.Fprintf(&b, "\t\tversion = %s\n", version)
+		if tunnel.LocalAddress != "" {
+			fmt.Fprintf(&b, "\t\tlocal_addrs = %s\n", tunnel.LocalAddress)
+		}
+		fmt.Fprintf(&b, "\t\tremote_addrs = %s\n", tunnel.RemoteAddress)
+		fmt.Fprintf(&b, "\t\tproposals = %s\n", tunnel.IKEProposals)
+		if tunnel.DPDDelay > 0 {
+			fmt.Fprintf(&b, "\t\tdpd_delay = %ds\n", tunnel.DPDDelay)
+		}
+
+		b.WriteString("\t\tlocal {\n")
+		fmt.Fprintf(&b, "\t\t\tauth = %s\n", tunnel.Auth)
+		if tunnel.LocalID != "" {
+			fmt.Fprintf(&b, "\t\t\tid = \"%s\"\n", tunnel.LocalID)
+		}
+		if tunnel.Auth == "pubkey" {
+			fmt.Fprintf(&b, "\t\t\tcerts = %s\n", tunnel.Cert)
+		}
+		b.WriteString("\t\t}\n")
+		b.WriteString("\t\tremote {\n")
+		fmt.Fprintf(&b, "\t\t\tauth = %s\n", tunnel.Auth)
+		if tunnel.RemoteID != "" {
+			fmt.Fprintf(&b, "\t\t\tid = \"%s\"\n", tunnel.RemoteID)
+		}
+		b.WriteString("\t\t}\n")
+
+		b.WriteString("\t\tchildren {\n")
+		fmt.Fprintf(&b, "\t\t\t%s {\n", tunnel.Name)
+		fmt.Fprintf(&b, "\t\t\t\tlocal_ts = %s\n", joinPrefixes(tunnel.LocalSubnets))
+		fmt.Fprintf(&b, "\t\t\t\tremote_ts = %s\n", joinPrefixes(tunnel.RemoteSubnets))
+		fmt.Fprintf(&b, "\t\t\t\tesp_proposals = %s\n", tunnel.ESPProposals)
+		fmt.Fprintf(&b, "\t\t\t\tstart_action = %s\n", tunnel.StartAction)
+		if tunnel.DPDDelay > 0 {
+			b.WriteString("\t\t\t\tdpd_action = restart\n")
+		}
+		b.WriteString("\t\t\t}\n\t\t}\n\t}\n")
+	}
+	b.WriteString("}\n")
+
+	// Keys are written in hex, so any byte is safe
+	b.WriteString("\nsecrets {\n")
+	for _, tunnel := range tunnels {
+		if tunnel.Auth != "psk" {
+			continue
+		}
+		fmt.Fprintf(&b, "\tike-%s {\n", tunnel.Name)
+		if tunnel.RemoteID != "" {
+			fmt.Fprintf(&b, "\t\tid = \"%s\"\n", tunnel.RemoteID)
+		} else {
+			fmt.Fprintf(&b, "\t\tid = %s\n", tunnel.RemoteAddress)
+		}
+		fmt.Fprintf(&b, "\t\tsecret = 0x%s\n", hex.EncodeToString([]byte(tunnel.PSK)))
+		b.WriteString("\t}\n")
+	}
+	b.WriteString("}\n")
+	return b.String()
+}
+
+// joinPrefixes returns prefixes as a comma separated list
+func joinPrefixes(prefixes []netip.Prefix) string {
+	s := make([]string, len(prefixes))
+	for i, prefix := range prefixes {
+		s[i] = prefix.String()
+	}
+	return strings.Join(s, ",")
+}
+
+// IPsecApplier loads the site-to-site tunnels of the ipsec config into
+// strongSwan
+type IPsecApplier struct {
+	previousConfig string
+	saved          bool          // Whether Apply has captured the previous config
+	hadPrevious    bool          // Whether a swanctl config existed before Apply
+	tunnels        []IPsecTunnel // Tunnels the last Apply loads, for Validate
+}
+
+// NewIPsecApplier creates a new IPsec applier
+func NewIPsecApplier() *IPsecApplier {
+	return &IPsecApplier{}
+}
+
+// Name returns the applier name
+func (a *IPsecApplier) Name() string {
+	return IPsecConfigName
+}
+
+// Check checks that swanctl is installed and the certificates of the
+// tunnels exist
+func (a *IPsecApplier) Check(ctx context.Context, config *uci.Config) error {
+	tunnels, err := IPsecTunnels(config)
+	if err != nil || len(tunnels) == 0 {
+		return err
+	}
+	if _, err := exec.LookPath("swanctl"); err != nil {
+		return fmt.Errorf("ipsec tunnels need strongSwan's swanctl: %w", err)
+	}
+	for _, tunnel := range tunnels {
+		if tunnel.Cert == "" {
+			continue
+		}
+		if _, err := os.Stat(tunnel.Cert); err != nil {
+			return fmt.Errorf("ipsec %s: %w", tunnel.Name, err)
+		}
+	}
+	return nil
+}
+
+// Render generates the swanctl config Apply would write
+func (a *IPsecApplier) Render(ctx context.Context, config *uci.Config) ([]Artifact, error) {
+	tunnels, err := IPsecTunnels(config)
+	if err != nil {
+		return nil, err
+	}
+	return []Artifact{{Name: SwanctlConfigPath, Content: renderSwanctl(tunnels)}}, nil
+}
+
+// Apply writes the swanctl config of the tunnels and loads it, replacing
+// the connections and keys strongSwan had loaded
+func (a *IPsecApplier) Apply(ctx context.Context, config *uci.Config) error {
+	tunnels, err := IPsecTunnels(config)
+	if err != nil {
+		return err
+	}
+
+	// Save current config for rollback
+	data, err := os.ReadFile(SwanctlConfigPath)
+	switch {
+	case err == nil:
+		a.previousConfig, a.hadPrevious = string(data), true
+	case os.IsNotExist(err):
+		a.previousConfig, a.hadPrevious = "", false
+	default:
+		return fmt.Errorf("failed to read the swanctl config: %w", err)
+	}
+	a.saved = true
+	a.tunnels = tunnels
+
+	if err := writeSwanctlConfig(renderSwanctl(tunnels)); err != nil {
+		return err
+	}
+	// Without tunnels, strongSwan needn't be installed
+	if _, err := exec.LookPath("swanctl"); err != nil && len(tunnels) == 0 {
+		return nil
+	}
+	return loadSwanctl(ctx)
+}
+
+// Validate checks that every tunnel is loaded and that those that initiate
+// have established their SAs
+func (a *IPsecApplier) Validate(ctx context.Context) error {
+	if len(a.tunnels) == 0 {
+		return nil
+	}
+
+	conns, err := commandOutputContext(ctx, "swanctl", "--list-conns")
+	if err != nil {
+		return fmt.Errorf("failed to list the loaded ipsec connections: %w", err)
+	}
+	for _, tunnel := range a.tunnels {
+		if !strings.Contains(string(conns), tunnel.Name+": ") {
+			return fmt.Errorf("ipsec %s is not loaded", tunnel.Name)
+		}
+		if tunnel.StartAction != "start" {
+			continue
+		}
+		if err := ipsecEstablished(ctx, tunnel.Name); err != nil {
+			return fmt.Errorf("ipsec %s: %w", tunnel.Name, err)
+		}
+	}
+	return nil
+}
+
+// ipsecEstablished waits for the IKE SA of a connection to be established
+// with its child SA installed
+func ipsecEstablished(ctx context.Context, name string) error {
+	for attempt := 1; ; attempt++ {
+		output, err := commandOutputContext(ctx, "swanctl", "--list-sas", "--ike", name)
+		if err == nil && bytes.Contains(output, []byte("ESTABLISHED")) && bytes.Contains(output, []byte("INSTALLED")) {
+			return nil
+		}
+		if attempt == ipsecUpAttempts {
+			return fmt.Errorf("the tunnel was not established")
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(time.Second):
+		}
+	}
+}
+
+// Rollback rolls back IPsec changes
+func (a *IPsecApplier) Rollback(ctx context.Context) error {
+	if !a.saved {
+		return ErrNoRollbackState
+	}
+
+	logger.Info("Rolling back IPsec configuration")
+
+	// Restore previous config, or remove ours if there was none
+	if a.hadPrevious {
+		if err := writeSwanctlConfig(a.previousConfig); err != nil {
+			return err
+		}
+	} else if err := os.Remove(SwanctlConfigPath); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return loadSwanctl(ctx)
+}
+
+// CaptureState dumps the connections strongSwan has loaded and its SAs
+func (a *IPsecApplier) CaptureState(ctx context.Context) []Artifact {
+	return captureCommands(ctx, []string{"swanctl", "--list-conns"}, []string{"swanctl", "--list-sas"})
+}
+
+// writeSwanctlConfig writes the swanctl config, readable by root only as it
+// holds the pre-shared keys
+func writeSwanctlConfig(content string) error {
+	if err := os.MkdirAll(filepath.Dir(SwanctlConfigPath), 0755); err != nil {
+		return err
+	}
+	if err := util.WriteFileAtomic(SwanctlConfigPath, []byte(content), 0600); err != nil {
+		return fmt.Errorf("failed to write swanctl config: %w", err)
+	}
+	return nil
+}
+
+// loadSwanctl loads the swanctl config into strongSwan, unloading the
+// connections and keys it no longer has
+func loadSwanctl(ctx context.Context) error {
+	if err := runCommandContext(ctx, "swanctl", "--load-all", "--clear"); err != nil {
+		return fmt.Errorf("failed to load the swanctl config: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,81 @@
+package appliers
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/thesabbir/hellfire/pkg/uci"
+)
+
+func TestIPsecRender(t *testing.T) {
+	config, err := uci.Parse(strings.NewReader(`
+config tunnel 'branch'
+	option remote_address 'branch.example.com'
+	option local_id 'hq.example.com'
+	option remote_id 'branch.example.com'
+	option psk 'say "friend"'
+	list local_subnet '192.168.1.0/24'
+	list remote_subnet '192.168.2.0/24 192.168.3.0/24'
+
+config tunnel 'partner'
+	option keyexchange 'ikev1'
+	option auth 'pubkey'
+	option cert '/etc/swanctl/x509/hq.pem'
+	option remote_address '198.51.100.7'
+	option remote_id 'CN=partner'
+	option start_action 'trap'
+	option dpd_delay '0'
+	list local_subnet '10.1.0.0/16'
+	list remote_subnet '10.2.0.0/16'
+
+config tunnel 'old'
+	option enabled '0'
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	artifacts, err := NewIPsecApplier().Render(context.Background(), config)
+	if err != nil {
+		t.Fatalf("Render: %v", err)
+	}
+	if len(artifacts) != 1 || artifacts[0].Name != SwanctlConfigPath {
+		t.Fatalf("artifacts = %+v", artifacts)
+	}
+	content := artifacts[0].Content
+	for _, line := range []string{
+		"\tbranch {\n\t\tversion = 2\n\t\tremote_addrs = branch.example.com\n",
+		"\t\t\t\tlocal_ts = 192.168.1.0/24\n\t\t\t\tremote_ts = 192.168.2.0/24,192.168.3.0/24\n",
+		"\t\tdpd_delay = 30s\n",
+		"\tike-branch {\n\t\tid = \"branch.example.com\"\n\t\tsecret = 0x7361792022667269656e6422\n",
+		"\t\t\tauth = pubkey\n\t\t\tcerts = /etc/swanctl/x509/hq.pem\n",
+		"\t\t\t\tstart_action = trap\n\t\t\t}\n",
+	} {
+		if !strings.Contains(content, line) {
+			t.Errorf("config lacks %q:\n%s", line, content)
+		}
+	}
+	if strings.Contains(content, "ike-partner") || strings.Contains(content, "\told {") {
+		t.Errorf("config has a secret for a pubkey tunnel or a disabled tunnel:\n%s", content)
+	}
+}
+
+func TestIPsecInvalid(t *testing.T) {
+	for name, section := range map[string]string{
+		"no remote":         "option psk 'x'\n\tlist local_subnet '10.0.0.0/8'\n\tlist remote_subnet '10.1.0.0/16'",
+		"no psk":            "option remote_address '192.0.2.1'\n\tlist local_subnet '10.0.0.0/8'\n\tlist remote_subnet '10.1.0.0/16'",
+		"no remote subnet":  "option remote_address '192.0.2.1'\n\toption psk 'x'\n\tlist local_subnet '10.0.0.0/8'",
+		"host bits":         "option remote_address '192.0.2.1'\n\toption psk 'x'\n\tlist local_subnet '10.0.0.1/8'\n\tlist remote_subnet '10.1.0.0/16'",
+		"quoted identity":   "option remote_address '192.0.2.1'\n\toption psk 'x'\n\toption remote_id 'a\" }'\n\tlist local_subnet '10.0.0.0/8'\n\tlist remote_subnet '10.1.0.0/16'",
+		"pubkey without id": "option remote_address '192.0.2.1'\n\toption auth 'pubkey'\n\toption cert '/c.pem'\n\tlist local_subnet '10.0.0.0/8'\n\tlist remote_subnet '10.1.0.0/16'",
+	} {
+		config, err := uci.Parse(strings.NewReader("config tunnel 'vpn'\n\t" + section + "\n"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := IPsecTunnels(config); err == nil {
+			t.Errorf("%s: accepted", name)
+		}
+	}
+}
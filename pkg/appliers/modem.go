@@ -0,0 +1,258 @@
+package appliers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+
+	"github.com/thesabbir/hellfire/pkg/logger"
+	"github.com/thesabbir/hellfire/pkg/modem"
+	"github.com/thesabbir/hellfire/pkg/uci"
+	"github.com/thesabbir/hellfire/pkg/util"
+)
+
+// DefaultModemStateDir holds the connection of each modem interface
+const DefaultModemStateDir = "/var/lib/hellfire/modems"
+
+// Modems keeps the modem connections made by the network applier
+var Modems = NewModemConnections(DefaultModemStateDir)
+
+// modemConnection is a connection made by the network applier, kept in
+// <interface>.json so `hf commit` and the API server share it
+type modemConnection struct {
+	Modem    string         `json:"modem"`    // D-Bus path
+	Settings modem.Settings `json:"settings"` // Without the PIN and password
+	Bearer   *modem.Bearer  `json:"bearer"`
+}
+
+// ModemConnections connects and disconnects the modems of modem interfaces
+// and remembers their bearers, whose DNS servers are used like those of a
+// DHCP lease
+type ModemConnections struct {
+	mu       sync.Mutex
+	dir      string
+	settings map[string]modem.Settings // With secrets, of the connections this process made
+}
+
+// NewModemConnections creates modem connections keeping their state in dir
+func NewModemConnections(dir string) *ModemConnections {
+	return &ModemConnections{dir: dir, settings: make(map[string]modem.Settings)}
+}
+
+// Connect (re)connects the modem of an interface and returns its bearer
+func (m *ModemConnections) Connect(ctx context.Context, settings modem.Settings) (*modem.Bearer, error) {
+	if err := util.ValidateInterfaceName(settings.Interface); err != nil {
+		return nil, fmt.Errorf("invalid interface name: %w", err)
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	connected, err := modem.Connect(ctx, settings)
+	if err != nil {
+		return nil, err
+	}
+	if connected.Bearer.Interface != "" && connected.Bearer.Interface != settings.Interface {
+		_ = modem.Disconnect(ctx, connected.Path)
+		return nil, fmt.Errorf("modem %s connected on %s, not %s", connected.Path, connected.Bearer.Interface, settings.Interface)
+	}
+
+	data, err := json.MarshalIndent(modemConnection{Modem: connected.Path, Settings: settings, Bearer: connected.Bearer}, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	if err := writeFileAtomic(m.statePath(settings.Interface), data); err != nil {
+		return nil, fmt.Errorf("failed to write modem state: %w", err)
+	}
+	m.settings[settings.Interface] = settings
+
+	logger.Info("Modem connected",
+		"interface", settings.Interface,
+		"modem", connected.Path,
+		"operator", connected.Operator,
+		"signal", connected.SignalQuality)
+	return connected.Bearer, nil
+}
+
+// Disconnect drops the connection of an interface's modem. It is a no-op for
+// interfaces without one.
+func (m *ModemConnections) Disconnect(ctx context.Context, ifaceName string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	conn, err := m.load(ifaceName)
+	if err != nil || conn == nil {
+		return err
+	}
+	if err := modem.Disconnect(ctx, conn.Modem); err != nil && !errors.Is(err, modem.ErrUnavailable) {
+		return err
+	}
+	delete(m.settings, ifaceName)
+	if err := os.Remove(m.statePath(ifaceName)); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	logger.Info("Modem disconnected", "interface", ifaceName, "modem", conn.Modem)
+	return nil
+}
+
+// Connected reports whether an interface's modem was connected by the
+// network applier
+func (m *ModemConnections) Connected(ifaceName string) bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	conn, _ := m.load(ifaceName)
+	return conn != nil
+}
+
+// Settings returns the settings, with secrets, an interface's modem was
+// connected with by this process
+func (m *ModemConnections) Settings(ifaceName string) (modem.Settings, bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	settings, ok := m.settings[ifaceName]
+	return settings, ok
+}
+
+// bearer returns the bearer an interface's modem was connected with
+func (m *ModemConnections) bearer(ifaceName string) *modem.Bearer {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	conn, _ := m.load(ifaceName)
+	if conn == nil {
+		return nil
+	}
+	return conn.Bearer
+}
+
+// load reads an interface's connection, nil without one; callers hold mu
+func (m *ModemConnections) load(ifaceName string) (*modemConnection, error) {
+	data, err := os.ReadFile(m.statePath(ifaceName))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read modem state: %w", err)
+	}
+	var conn modemConnection
+	if err := json.Unmarshal(data, &conn); err != nil {
+		return nil, fmt.Errorf("failed to parse modem state: %w", err)
+	}
+	return &conn, nil
+}
+
+func (m *ModemConnections) statePath(ifaceName string) string {
+	return filepath.Join(m.dir, ifaceName+".json")
+}
+
+// isModem reports whether an interface section is a cellular modem
+func isModem(section *uci.Section) bool {
+	proto, _ := section.GetOption("proto")
+	return modem.IsProto(proto)
+}
+
+// applyModemInterface connects the modem of an interface and configures its
+// network device from the bearer: the static addresses and default route
+// ModemManager reports, or a DHCP client. A modem that isn't plugged in is
+// configured once its device appears.
+func (a *NetworkApplier) applyModemInterface(ctx context.Context, ifaceName string, section *uci.Section) error {
+	settings, err := modem.ParseSettings(section)
+	if err != nil {
+		return err
+	}
+	link, err := parseLinkOptions(section)
+	if err != nil {
+		return err
+	}
+	if _, err := net.InterfaceByName(ifaceName); err != nil {
+		logger.Warn("Modem is not present, its interface is configured once it appears", "interface", ifaceName)
+		return nil
+	}
+
+	bearer, err := Modems.Connect(ctx, settings)
+	if err != nil {
+		return fmt.Errorf("failed to connect modem: %w", err)
+	}
+	if link.MTU == 0 && bearer.IPv4 != nil {
+		link.MTU = bearer.IPv4.MTU
+	}
+	if err := link.apply(ctx, ifaceName); err != nil {
+		return err
+	}
+
+	if err := runCommandContext(ctx, "ip", "addr", "flush", "dev", ifaceName); err != nil {
+		return fmt.Errorf("failed to flush interface: %w", err)
+	}
+	if err := runCommandContext(ctx, "ip", "link", "set", ifaceName, "up"); err != nil {
+		return fmt.Errorf("failed to bring interface up: %w", err)
+	}
+
+	for _, family := range []struct {
+		flag   string
+		config *modem.IPConfig
+	}{{"-4", bearer.IPv4}, {"-6", bearer.IPv6}} {
+		config := family.config
+		if config == nil {
+			continue
+		}
+		switch config.Method {
+		case "static":
+			if err := runCommandContext(ctx, "ip", family.flag, "addr", "add", config.CIDR(), "dev", ifaceName); err != nil {
+				return fmt.Errorf("failed to add address: %w", err)
+			}
+			args := []string{family.flag, "route", "replace", "default"}
+			if config.Gateway != "" {
+				args = append(args, "via", config.Gateway)
+			}
+			args = append(args, "dev", ifaceName, "metric", strconv.Itoa(link.Metric))
+			if err := runCommandContext(ctx, "ip", args...); err != nil {
+				return fmt.Errorf("failed to add default route: %w", err)
+			}
+		case "dhcp":
+			if family.flag == "-4" {
+				if err := DHCPClients.Start(ctx, ifaceName, link.Metric); err != nil {
+					return err
+				}
+			}
+		default:
+			return fmt.Errorf("bearer ip method %q is not supported", config.Method)
+		}
+	}
+	return link.addIP6Addresses(ctx, ifaceName)
+}
+
+// validateModem checks that a modem interface is up with its bearer's
+// addresses; a modem that isn't plugged in is skipped
+func validateModem(ifaceName string, state *interfaceState) error {
+	if !state.Up {
+		return fmt.Errorf("link is down")
+	}
+	bearer := Modems.bearer(ifaceName)
+	if bearer == nil {
+		return fmt.Errorf("modem is not connected")
+	}
+	if config := bearer.IPv4; config != nil && config.Method == "dhcp" && !DHCPClients.Running(ifaceName) {
+		return fmt.Errorf("dhcp client is not running")
+	}
+	for _, config := range []*modem.IPConfig{bearer.IPv4, bearer.IPv6} {
+		if config == nil || config.Method != "static" {
+			continue
+		}
+		found := false
+		for _, addr := range state.Addresses {
+			if fmt.Sprintf("%s/%d", addr.Local, addr.PrefixLen) == config.CIDR() {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return fmt.Errorf("address %s is not configured", config.CIDR())
+		}
+	}
+	return nil
+}
@@ -3,13 +3,19 @@ package appliers
 import (
 	"bytes"
 	"context"
+	"errors"
 	"fmt"
+	"net"
 	"os/exec"
+	"slices"
+	"strconv"
 	"strings"
 
 	"github.com/thesabbir/hellfire/pkg/logger"
+	"github.com/thesabbir/hellfire/pkg/modem"
 	"github.com/thesabbir/hellfire/pkg/uci"
 	"github.com/thesabbir/hellfire/pkg/util"
+	"github.com/thesabbir/hellfire/pkg/wireguard"
 )
 
 const (
@@ -19,13 +25,49 @@ const (
 
 // NetworkApplier applies network configuration
 type NetworkApplier struct {
-	previousState map[string]string // Store previous interface states for rollback
+	previousState  map[string]*interfaceState // Store previous interface states for rollback
+	previousRoutes []routeState               // Default routes before Apply, which may remove them
+	applied        []appliedInterface         // What the last Apply configured, for Validate
+
+	vpn              []VPNPolicy // Policies routed by the last Apply
+	vpnKnown         bool        // Whether an Apply has routed vpn in this process
+	previousVPN      []VPNPolicy // vpn before the last Apply
+	previousVPNKnown bool        // Whether previousVPN is how traffic was routed
+
+	previousResolvers []InterfaceDNS // DNS servers before the last Apply, nil if never applied
+	resolversSaved    bool           // Whether Apply has captured previousResolvers
+
+	gateways      []Gateway     // Default routes of the last Apply
+	routes        []StaticRoute // Static routes of the last Apply
+	previousOwned []routeState  // Routes owned by hellfire before the last Apply
+	previousRules []gatewayRule // Gateway rules before the last Apply
+	gatewaysSaved bool          // Whether Apply has captured previousOwned and previousRules
+
+	vrfs         []VRF          // VRF devices of the last Apply
+	previousVRFs map[string]int // VRF devices and their tables before the last Apply
+	vrfsSaved    bool           // Whether Apply has captured previousVRFs
+
+	vlans         []VLAN          // VLAN devices of the last Apply
+	previousVLANs map[string]VLAN // VLAN devices before the last Apply
+	vlansSaved    bool            // Whether Apply has captured previousVLANs
+}
+
+// appliedInterface is the configuration Apply gave an interface
+type appliedInterface struct {
+	Name    string
+	Proto   string
+	Address string // CIDR, static and wireguard only
+	Gateway string // Static only
+	VRF     string // Static only
+	Link    linkOptions
+
+	Neighbors []neighborState // Static ARP/NDP entries from neighbor sections
 }
 
 // NewNetworkApplier creates a new network applier
 func NewNetworkApplier() *NetworkApplier {
 	return &NetworkApplier{
-		previousState: make(map[string]string),
+		previousState: make(map[string]*interfaceState),
 	}
 }
 
@@ -39,6 +81,89 @@ func (a *NetworkApplier) Apply(ctx context.Context, config *uci.Config) error {
 	// Get all interface sections
 	interfaces := config.GetSectionsByType("interface")
 
+	neighbors, err := parseNeighbors(config)
+	if err != nil {
+		return err
+	}
+	policies, err := VPNPolicies(config)
+	if err != nil {
+		return err
+	}
+	resolvers, err := InterfaceResolvers(config)
+	if err != nil {
+		return err
+	}
+	gateways, err := Gateways(config)
+	if err != nil {
+		return err
+	}
+	staticRoutes, err := StaticRoutes(config)
+	if err != nil {
+		return err
+	}
+	vrfs, err := VRFs(config)
+	if err != nil {
+		return err
+	}
+	vlans, err := VLANs(config)
+	if err != nil {
+		return err
+	}
+
+	// Only state replaced by this apply is rolled back
+	a.previousState = make(map[string]*interfaceState)
+	a.applied = nil
+	routes, err := captureRoutes(ctx, "inet", "default")
+	if err != nil {
+		logger.Warn("Failed to save default routes", "error", err)
+	}
+	a.previousRoutes = routes
+	a.gateways, a.routes = nil, nil
+	a.previousOwned, err = captureOwnedRoutes(ctx)
+	if err == nil {
+		a.previousRules, err = captureGatewayRules(ctx)
+	}
+	a.gatewaysSaved = err == nil
+	if err != nil {
+		logger.Warn("Failed to save gateway routes", "error", err)
+	}
+	a.previousVPN, a.previousVPNKnown = a.vpn, a.vpnKnown
+	if !a.vpnKnown {
+		// Routing left by an earlier process can't be restored
+		a.previousVPNKnown = !vpnRouted(ctx)
+	}
+	a.previousResolvers, err = Resolvers.Interfaces()
+	a.resolversSaved = err == nil
+	if err != nil {
+		logger.Warn("Failed to save DNS servers", "error", err)
+	}
+	a.vlans, a.previousVLANs, a.vlansSaved = nil, nil, false
+	if len(vlans) > 0 {
+		a.previousVLANs, err = captureVLANs(ctx)
+		a.vlansSaved = err == nil
+		if err != nil {
+			logger.Warn("Failed to save VLAN devices", "error", err)
+		}
+	}
+	a.vrfs, a.previousVRFs, a.vrfsSaved = nil, nil, false
+	if len(vrfs) > 0 {
+		a.previousVRFs, err = captureVRFs(ctx)
+		a.vrfsSaved = err == nil
+		if err != nil {
+			logger.Warn("Failed to save VRF devices", "error", err)
+		}
+	}
+
+	// The VLANs and VRFs are there before their interfaces are configured
+	if err := applyVLANs(ctx, vlans, a.previousVLANs); err != nil {
+		return err
+	}
+	a.vlans = vlans
+	if err := applyVRFs(ctx, vrfTables(vrfs), a.previousVRFs); err != nil {
+		return err
+	}
+	a.vrfs = vrfs
+
 	for _, iface := range interfaces {
 		// Check context cancellation
 		select {
@@ -60,26 +185,527 @@ func (a *NetworkApplier) Apply(ctx context.Context, config *uci.Config) error {
 				"error", err)
 		}
 
+		current := ""
+		if state := a.previousState[ifaceName]; state != nil {
+			current = state.VRF
+		}
+		if err := setInterfaceVRF(ctx, ifaceName, interfaceVRF(iface), current); err != nil {
+			return fmt.Errorf("failed to apply interface %s: %w", ifaceName, err)
+		}
+
 		// Apply interface configuration
-		if err := a.applyInterface(ctx, ifaceName, iface); err != nil {
+		if err := a.applyInterface(ctx, config, ifaceName, iface); err != nil {
 			return fmt.Errorf("failed to apply interface %s: %w", ifaceName, err)
 		}
+
+		applied := newAppliedInterface(ifaceName, iface)
+		applied.Neighbors = neighbors[ifaceName]
+		if err := applied.applyNeighbors(ctx); err != nil {
+			return fmt.Errorf("failed to apply neighbors on %s: %w", ifaceName, err)
+		}
+		a.applied = append(a.applied, applied)
+	}
+
+	// Default and static routes once the interfaces have their addresses
+	if err := applyGateways(ctx, gateways, staticRoutes); err != nil {
+		return fmt.Errorf("failed to apply routes: %w", err)
+	}
+	a.gateways, a.routes = gateways, staticRoutes
+
+	// Route through the tunnels once they're up
+	if err := applyVPNRouting(ctx, policies); err != nil {
+		return err
+	}
+	a.vpn, a.vpnKnown = policies, true
+
+	// dnsmasq forwards to the servers of the interfaces that are now up
+	if err := Resolvers.Configure(resolvers); err != nil {
+		return fmt.Errorf("failed to configure DNS servers: %w", err)
 	}
 
 	return nil
 }
 
-// Validate validates that interfaces are configured correctly
+// Check checks that every configured interface exists and that neighbors
+// refer to configured interfaces. WireGuard interfaces are created by Apply,
+// so instead their config is checked and the wg tool must be installed;
+// VLANs are too, on a device that must exist; modems may be unplugged, but
+// ModemManager must be installed.
+func (a *NetworkApplier) Check(ctx context.Context, config *uci.Config) error {
+	if _, err := parseNeighbors(config); err != nil {
+		return err
+	}
+	if _, err := VPNPolicies(config); err != nil {
+		return err
+	}
+	if _, err := InterfaceResolvers(config); err != nil {
+		return err
+	}
+	if _, err := Gateways(config); err != nil {
+		return err
+	}
+	if _, err := StaticRoutes(config); err != nil {
+		return err
+	}
+	if _, err := VRFs(config); err != nil {
+		return err
+	}
+	vlans, err := VLANs(config)
+	if err != nil {
+		return err
+	}
+
+	var errs []error
+	for _, vlan := range vlans {
+		// A VLAN is created by Apply on a device that must exist already
+		if isVLAN(vlans, vlan.Device) {
+			continue
+		}
+		if _, err := net.InterfaceByName(vlan.Device); err != nil {
+			errs = append(errs, fmt.Errorf("vlan %s: no such network device %s", vlan.Name, vlan.Device))
+		}
+	}
+	for _, iface := range config.GetSectionsByType("interface") {
+		if iface.Name == "" {
+			continue
+		}
+		if err := util.ValidateInterfaceName(iface.Name); err != nil {
+			errs = append(errs, fmt.Errorf("interface %s: %w", iface.Name, err))
+			continue
+		}
+		if isWireGuard(iface) {
+			if err := checkWireGuard(config, iface.Name); err != nil {
+				errs = append(errs, fmt.Errorf("interface %s: %w", iface.Name, err))
+			}
+			continue
+		}
+		if _, err := parseLinkOptions(iface); err != nil {
+			errs = append(errs, fmt.Errorf("interface %s: %w", iface.Name, err))
+		}
+		if isModem(iface) {
+			// The device appears when the modem is plugged in
+			if err := checkModem(iface); err != nil {
+				errs = append(errs, fmt.Errorf("interface %s: %w", iface.Name, err))
+			}
+			continue
+		}
+		if isVLAN(vlans, iface.Name) {
+			continue
+		}
+		if _, err := net.InterfaceByName(iface.Name); err != nil {
+			errs = append(errs, fmt.Errorf("interface %s: no such network device", iface.Name))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// Render lists the commands Apply runs to configure the interfaces
+func (a *NetworkApplier) Render(ctx context.Context, config *uci.Config) ([]Artifact, error) {
+	return renderNetwork(config)
+}
+
+// renderNetwork checks the interface sections as Apply does and lists the ip
+// commands it runs for them
+func renderNetwork(config *uci.Config) ([]Artifact, error) {
+	neighbors, err := parseNeighbors(config)
+	if err != nil {
+		return nil, err
+	}
+	policies, err := VPNPolicies(config)
+	if err != nil {
+		return nil, err
+	}
+	resolvers, err := InterfaceResolvers(config)
+	if err != nil {
+		return nil, err
+	}
+	gateways, err := Gateways(config)
+	if err != nil {
+		return nil, err
+	}
+	routes, err := StaticRoutes(config)
+	if err != nil {
+		return nil, err
+	}
+	vrfs, err := VRFs(config)
+	if err != nil {
+		return nil, err
+	}
+	vlans, err := VLANs(config)
+	if err != nil {
+		return nil, err
+	}
+
+	var buf strings.Builder
+	if len(vlans) > 0 {
+		buf.WriteString("# vlan devices\n")
+		for _, command := range vlanCommands(vlans) {
+			buf.WriteString(command + "\n")
+		}
+	}
+	if len(vrfs) > 0 {
+		buf.WriteString("# vrf devices\n")
+		for _, command := range vrfCommands(vrfs) {
+			buf.WriteString(command + "\n")
+		}
+	}
+	for _, section := range config.GetSectionsByType("interface") {
+		ifaceName := section.Name
+		if ifaceName == "" {
+			continue
+		}
+		if err := util.ValidateInterfaceName(ifaceName); err != nil {
+			return nil, fmt.Errorf("interface %s: invalid interface name: %w", ifaceName, err)
+		}
+		link, err := interfaceLink(ifaceName, section)
+		if err != nil {
+			return nil, fmt.Errorf("interface %s: %w", ifaceName, err)
+		}
+
+		fmt.Fprintf(&buf, "# %s (%s)\n", ifaceName, link.Proto)
+		if link.VRF != "" {
+			fmt.Fprintf(&buf, "ip link set dev %s master %s\n", ifaceName, link.VRF)
+		}
+		switch link.Proto {
+		case "static":
+			writeLinkCommands(&buf, ifaceName, link)
+			fmt.Fprintf(&buf, "ip addr flush dev %s\n", ifaceName)
+			fmt.Fprintf(&buf, "ip addr add %s dev %s\n", link.Address, ifaceName)
+			for _, address := range link.IP6Addresses {
+				fmt.Fprintf(&buf, "ip -6 addr replace %s dev %s\n", address, ifaceName)
+			}
+			fmt.Fprintf(&buf, "ip link set %s up\n", ifaceName)
+		case "dhcp":
+			writeLinkCommands(&buf, ifaceName, link)
+			fmt.Fprintf(&buf, "ip -6 addr flush dev %s scope global permanent\n", ifaceName)
+			for _, address := range link.IP6Addresses {
+				fmt.Fprintf(&buf, "ip -6 addr replace %s dev %s\n", address, ifaceName)
+			}
+			fmt.Fprintf(&buf, "ip link set %s up\n", ifaceName)
+			if link.Metric > 0 {
+				fmt.Fprintf(&buf, "dhclient -d -e IF_METRIC=%d %s\n", link.Metric, ifaceName)
+			} else {
+				fmt.Fprintf(&buf, "dhclient -d %s\n", ifaceName)
+			}
+		case "none":
+			fmt.Fprintf(&buf, "ip link set %s down\n", ifaceName)
+			continue
+		case modem.ProtoModemManager, modem.ProtoQMI:
+			// The preview masks the PIN and password, which the
+			// connect arguments mask anyway
+			unmasked := section.Clone()
+			for _, option := range []string{"pincode", "password"} {
+				if value, _ := unmasked.GetOption(option); value != "" {
+					unmasked.SetOption(option, "0000")
+				}
+			}
+			settings, err := modem.ParseSettings(unmasked)
+			if err != nil {
+				return nil, fmt.Errorf("interface %s: %w", ifaceName, err)
+			}
+			target := settings.Device
+			if target == "" {
+				target = "<modem of " + ifaceName + ">"
+			}
+			fmt.Fprintf(&buf, "mmcli -m %s --simple-connect=%s\n", target, settings.ConnectArgs(true))
+			writeLinkCommands(&buf, ifaceName, link)
+			fmt.Fprintf(&buf, "ip addr flush dev %s\n", ifaceName)
+			fmt.Fprintf(&buf, "ip link set %s up\n", ifaceName)
+			fmt.Fprintf(&buf, "# addresses and default route (metric %d) of the bearer\n", link.Metric)
+			for _, address := range link.IP6Addresses {
+				fmt.Fprintf(&buf, "ip -6 addr replace %s dev %s\n", address, ifaceName)
+			}
+		case wireguard.Proto:
+			// Keys stay out of artifacts
+			_, routes, err := wireguard.Setconf(config, ifaceName)
+			if err != nil {
+				return nil, fmt.Errorf("interface %s: %w", ifaceName, err)
+			}
+			addresses, _ := wireguard.Addresses(section)
+			fmt.Fprintf(&buf, "ip link add dev %s type wireguard\n", ifaceName)
+			fmt.Fprintf(&buf, "wg setconf %s <%d peers>\n", ifaceName, len(config.GetSectionsByType(wireguard.PeerType(ifaceName))))
+			fmt.Fprintf(&buf, "ip addr flush dev %s\n", ifaceName)
+			for _, address := range addresses {
+				fmt.Fprintf(&buf, "ip addr add %s dev %s\n", address, ifaceName)
+			}
+			fmt.Fprintf(&buf, "ip link set %s up\n", ifaceName)
+			for _, route := range routes {
+				fmt.Fprintf(&buf, "ip route replace %s dev %s\n", route, ifaceName)
+			}
+		}
+
+		fmt.Fprintf(&buf, "ip neigh flush dev %s nud permanent\n", ifaceName)
+		for _, neighbor := range neighbors[ifaceName] {
+			fmt.Fprintf(&buf, "ip %s\n", strings.Join(neighbor.replaceArgs(ifaceName), " "))
+		}
+	}
+
+	fmt.Fprintf(&buf, "# default routes (after removing other routes with proto %d and rules %d)\n", RouteProto, gatewayRulePriority)
+	for _, command := range gatewayCommands(gateways) {
+		buf.WriteString(command + "\n")
+	}
+	if len(routes) > 0 {
+		buf.WriteString("# static routes\n")
+		for _, command := range staticRouteCommands(routes) {
+			buf.WriteString(command + "\n")
+		}
+	}
+
+	if commands := vpnRoutingCommands(policies); len(commands) > 0 {
+		fmt.Fprintf(&buf, "# vpn routing (after flushing rules %d-%d and table %d)\n", vpnSplitPriority, vpnTablePriority, VPNTable)
+		for _, command := range commands {
+			buf.WriteString(strings.Join(command, " ") + "\n")
+		}
+	}
+	artifacts := []Artifact{{Name: "ip commands", Content: buf.String()}}
+
+	if len(resolvers) > 0 {
+		// Lease servers are only known once the clients have a lease
+		resolv := renderResolvConf(resolvers, func(string) []string { return nil })
+		for _, iface := range resolvers {
+			if iface.PeerDNS {
+				resolv += fmt.Sprintf("# and the DNS servers of the DHCP lease on %s\n", iface.Interface)
+			}
+		}
+		artifacts = append(artifacts, Artifact{Name: Resolvers.Path(), Content: resolv})
+	}
+	return artifacts, nil
+}
+
+// Validate checks the kernel state against the last Apply: static addresses
+// are on their interfaces, the default routes go via the configured gateways
+// with their tables and rules, DHCP interfaces are up with a running client, disabled ones are down and
+// the policy rules of a route_all tunnel are in place
 func (a *NetworkApplier) Validate(ctx context.Context) error {
-	// Basic validation: check that interfaces mentioned in config are up
-	// In production, you might want more sophisticated checks
+	var defaultRoutes []routeState
+	if slices.ContainsFunc(a.applied, func(iface appliedInterface) bool { return iface.Gateway != "" }) {
+		routes, err := captureRoutes(ctx, "inet", "default")
+		if err != nil {
+			return fmt.Errorf("failed to read default routes: %w", err)
+		}
+		defaultRoutes = routes
+	}
+
+	var errs []error
+	for _, iface := range a.applied {
+		if err := iface.validate(ctx, defaultRoutes); err != nil {
+			errs = append(errs, fmt.Errorf("interface %s: %w", iface.Name, err))
+		}
+	}
+	if err := validateGateways(ctx, a.gateways); err != nil {
+		errs = append(errs, err)
+	}
+	if err := validateStaticRoutes(ctx, a.routes); err != nil {
+		errs = append(errs, err)
+	}
+	if err := validateVRFs(ctx, a.vrfs); err != nil {
+		errs = append(errs, err)
+	}
+	if err := validateVLANs(ctx, a.vlans); err != nil {
+		errs = append(errs, err)
+	}
+	if i := slices.IndexFunc(a.vpn, func(p VPNPolicy) bool { return p.RouteAll }); i >= 0 && !vpnRouted(ctx) {
+		errs = append(errs, fmt.Errorf("interface %s: traffic is not routed through the tunnel", a.vpn[i].Interface))
+	}
+	return errors.Join(errs...)
+}
+
+// newAppliedInterface records the configuration of an applied interface section
+func newAppliedInterface(ifaceName string, section *uci.Section) appliedInterface {
+	iface := appliedInterface{Name: ifaceName}
+	iface.Proto, _ = section.GetOption("proto")
+
+	switch iface.Proto {
+	case "static":
+		ipaddr, _ := section.GetOption("ipaddr")
+		netmask, _ := section.GetOption("netmask")
+		iface.Address = fmt.Sprintf("%s/%d", ipaddr, convertNetmaskToCIDR(netmask))
+		iface.Gateway, _ = section.GetOption("gateway")
+		iface.VRF = interfaceVRF(section)
+		iface.Link, _ = parseLinkOptions(section)
+	case "dhcp", modem.ProtoModemManager, modem.ProtoQMI:
+		iface.Link, _ = parseLinkOptions(section)
+	case wireguard.Proto:
+		if addresses, _ := wireguard.Addresses(section); len(addresses) > 0 {
+			iface.Address = addresses[0]
+		}
+	}
+	return iface
+}
+
+// parseNeighbors reads the neighbor sections, which pin an IP address to a
+// MAC address on one of the configured interfaces
+func parseNeighbors(config *uci.Config) (map[string][]neighborState, error) {
+	neighbors := make(map[string][]neighborState)
+	for _, section := range config.GetSectionsByType("neighbor") {
+		ifaceName, _ := section.GetOption("interface")
+		ipaddr, _ := section.GetOption("ipaddr")
+		mac, _ := section.GetOption("mac")
+
+		iface := config.GetSection("interface", ifaceName)
+		if iface == nil {
+			return nil, fmt.Errorf("neighbor %s: interface %q is not configured", ipaddr, ifaceName)
+		}
+		if proto, _ := iface.GetOption("proto"); proto == "none" {
+			return nil, fmt.Errorf("neighbor %s: interface %s is disabled", ipaddr, ifaceName)
+		}
+		if net.ParseIP(ipaddr) == nil {
+			return nil, fmt.Errorf("neighbor on %s: invalid IP address %q", ifaceName, ipaddr)
+		}
+		if err := util.ValidateMAC(mac); err != nil {
+			return nil, fmt.Errorf("neighbor %s: %w", ipaddr, err)
+		}
+
+		neighbors[ifaceName] = append(neighbors[ifaceName], neighborState{
+			Dst:    ipaddr,
+			LLAddr: strings.ToLower(mac),
+		})
+	}
+	return neighbors, nil
+}
+
+// applyNeighbors replaces the interface's permanent neighbor entries with the
+// configured ones. Disabled interfaces have none: the kernel drops them with
+// the link.
+func (iface appliedInterface) applyNeighbors(ctx context.Context) error {
+	if iface.Proto == "none" {
+		return nil
+	}
+
+	if err := runCommandContext(ctx, "ip", "neigh", "flush", "dev", iface.Name, "nud", "permanent"); err != nil {
+		return fmt.Errorf("failed to flush static neighbors: %w", err)
+	}
+	for _, neighbor := range iface.Neighbors {
+		if err := runCommandContext(ctx, "ip", neighbor.replaceArgs(iface.Name)...); err != nil {
+			return fmt.Errorf("failed to add neighbor %s: %w", neighbor.Dst, err)
+		}
+	}
+	return nil
+}
+
+// validate checks one applied interface against its current state
+func (iface appliedInterface) validate(ctx context.Context, defaultRoutes []routeState) error {
+	if _, err := net.InterfaceByName(iface.Name); err != nil && modem.IsProto(iface.Proto) {
+		// Configured once the modem is plugged in
+		return nil
+	}
+
+	output, err := commandOutputContext(ctx, "ip", "-j", "-d", "addr", "show", "dev", iface.Name)
+	if err != nil {
+		return fmt.Errorf("failed to read interface state: %w", err)
+	}
+	state, err := parseLinkDump(output)
+	if err != nil {
+		return err
+	}
+
+	switch iface.Proto {
+	case "none":
+		if state.Up {
+			return fmt.Errorf("link is up, expected down")
+		}
+		return nil
+	case "dhcp":
+		if !state.Up {
+			return fmt.Errorf("link is down")
+		}
+		if err := iface.Link.validate(state); err != nil {
+			return err
+		}
+		// The lease itself may take a while; the client must be running
+		if !DHCPClients.Running(iface.Name) {
+			return fmt.Errorf("dhcp client is not running")
+		}
+		return iface.validateNeighbors(ctx)
+	case modem.ProtoModemManager, modem.ProtoQMI:
+		if err := validateModem(iface.Name, state); err != nil {
+			return err
+		}
+		return iface.validateNeighbors(ctx)
+	}
+
+	if !state.Up {
+		return fmt.Errorf("link is down")
+	}
+	if err := iface.Link.validate(state); err != nil {
+		return err
+	}
+	if state.VRF != iface.VRF {
+		if iface.VRF == "" {
+			return fmt.Errorf("interface is in vrf %s", state.VRF)
+		}
+		return fmt.Errorf("interface is not in vrf %s", iface.VRF)
+	}
+
+	if iface.Address != "" {
+		found := false
+		for _, addr := range state.Addresses {
+			if fmt.Sprintf("%s/%d", addr.Local, addr.PrefixLen) == iface.Address {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return fmt.Errorf("address %s is not configured", iface.Address)
+		}
+	}
+
+	// A VRF's default routes are checked with the gateway tables
+	if iface.Gateway != "" && iface.VRF == "" {
+		found := false
+		for _, route := range defaultRoutes {
+			if route.Gateway == iface.Gateway && route.Dev == iface.Name && route.Metric == iface.Link.Metric {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return fmt.Errorf("default route via %s with metric %d is not installed", iface.Gateway, iface.Link.Metric)
+		}
+	}
+
+	return iface.validateNeighbors(ctx)
+}
+
+// validateNeighbors checks that the configured static neighbors are installed
+func (iface appliedInterface) validateNeighbors(ctx context.Context) error {
+	if len(iface.Neighbors) == 0 {
+		return nil
+	}
+
+	installed, err := captureNeighbors(ctx, iface.Name)
+	if err != nil {
+		return fmt.Errorf("failed to read neighbors: %w", err)
+	}
+	for _, neighbor := range iface.Neighbors {
+		if !slices.Contains(installed, neighbor) {
+			return fmt.Errorf("static neighbor %s at %s is not installed", neighbor.Dst, neighbor.LLAddr)
+		}
+	}
 	return nil
 }
 
 // Rollback rolls back network changes
 func (a *NetworkApplier) Rollback(ctx context.Context) error {
+	if len(a.previousState) == 0 {
+		return ErrNoRollbackState
+	}
+
 	logger.Info("Starting network rollback", "interfaces", len(a.previousState))
 
+	var errs []error
+	if a.vlansSaved {
+		// VLANs this apply created go, taking their addresses with them
+		if err := restoreVLANs(ctx, a.previousVLANs, a.vlans); err != nil {
+			errs = append(errs, fmt.Errorf("failed to restore vlans: %w", err))
+		}
+	}
+	if a.vrfsSaved {
+		// The interfaces go back into the VRFs as they were
+		if err := restoreVRFs(ctx, a.previousVRFs, vrfTables(a.vrfs)); err != nil {
+			errs = append(errs, fmt.Errorf("failed to restore vrfs: %w", err))
+		}
+	}
 	for ifaceName, state := range a.previousState {
 		// Check context cancellation
 		select {
@@ -87,15 +713,85 @@ func (a *NetworkApplier) Rollback(ctx context.Context) error {
 			return ctx.Err()
 		default:
 		}
+		if _, existed := a.previousVLANs[ifaceName]; a.vlansSaved && isVLAN(a.vlans, ifaceName) && !existed {
+			continue // Removed with its VLAN
+		}
+
+		logger.Debug("Restoring interface state",
+			"interface", ifaceName,
+			"up", state.Up,
+			"addresses", len(state.Addresses),
+			"routes", len(state.Routes))
 
-		if err := a.restoreInterfaceState(ctx, ifaceName, state); err != nil {
+		if err := restoreInterfaceState(ctx, ifaceName, state); err != nil {
 			logger.Error("Failed to rollback interface",
 				"interface", ifaceName,
 				"error", err)
-			return fmt.Errorf("failed to rollback %s: %w", ifaceName, err)
+			errs = append(errs, fmt.Errorf("failed to rollback %s: %w", ifaceName, err))
+		}
+
+		// Put the DHCP client back the way it was; a running one renews the
+		// restored lease address
+		if state.DHCP && !DHCPClients.Running(ifaceName) {
+			if err := DHCPClients.Start(ctx, ifaceName, state.DHCPMetric); err != nil {
+				errs = append(errs, fmt.Errorf("failed to restart dhcp client on %s: %w", ifaceName, err))
+			}
+		} else if !state.DHCP {
+			DHCPClients.Stop(ctx, ifaceName)
+		}
+
+		// Reconnect a modem with its earlier settings, or drop the
+		// connection the apply made. One connected by another process
+		// stays as it is: its PIN and password aren't known.
+		if state.Modem != nil {
+			if _, err := Modems.Connect(ctx, *state.Modem); err != nil {
+				errs = append(errs, fmt.Errorf("failed to reconnect modem on %s: %w", ifaceName, err))
+			}
+		} else if !state.ModemConnected {
+			if err := Modems.Disconnect(ctx, ifaceName); err != nil {
+				errs = append(errs, fmt.Errorf("failed to disconnect modem on %s: %w", ifaceName, err))
+			}
 		}
 	}
 
+	// Default routes via interfaces outside the config are removed by a
+	// static gateway too
+	if err := restoreRoutes(ctx, a.previousRoutes); err != nil {
+		errs = append(errs, fmt.Errorf("failed to restore default routes: %w", err))
+	}
+	if a.gatewaysSaved {
+		if err := syncOwnedRoutes(ctx, a.previousOwned); err != nil {
+			errs = append(errs, fmt.Errorf("failed to restore gateway routes: %w", err))
+		}
+		if err := replaceGatewayRules(ctx, a.previousRules); err != nil {
+			errs = append(errs, fmt.Errorf("failed to restore gateway rules: %w", err))
+		}
+	}
+
+	// Without knowing how traffic was routed through a tunnel before, the
+	// restored config has to be applied again
+	if !a.previousVPNKnown {
+		errs = append(errs, fmt.Errorf("vpn routing before the apply: %w", ErrNoRollbackState))
+	} else if err := applyVPNRouting(ctx, a.previousVPN); err != nil {
+		errs = append(errs, fmt.Errorf("failed to restore vpn routing: %w", err))
+	} else {
+		a.vpn = a.previousVPN
+	}
+
+	if a.resolversSaved {
+		restore := Resolvers.Reset
+		if a.previousResolvers != nil {
+			restore = func() error { return Resolvers.Configure(a.previousResolvers) }
+		}
+		if err := restore(); err != nil {
+			errs = append(errs, fmt.Errorf("failed to restore DNS servers: %w", err))
+		}
+	}
+
+	if err := errors.Join(errs...); err != nil {
+		return err
+	}
+
 	logger.Info("Network rollback completed successfully")
 	return nil
 }
@@ -107,46 +803,23 @@ func (a *NetworkApplier) saveInterfaceState(ctx context.Context, ifaceName strin
 		return fmt.Errorf("invalid interface name: %w", err)
 	}
 
-	// Get current IP configuration
-	cmd := exec.CommandContext(ctx, "ip", "addr", "show", "dev", ifaceName)
-	output, err := cmd.CombinedOutput()
+	state, err := captureInterfaceState(ctx, ifaceName)
 	if err != nil {
 		return err
 	}
 
-	a.previousState[ifaceName] = string(output)
-	return nil
-}
-
-// restoreInterfaceState restores a saved interface state
-func (a *NetworkApplier) restoreInterfaceState(ctx context.Context, ifaceName, state string) error {
-	// For now, we'll parse the state and try to restore it
-	// This is a simplified implementation
-	logger.Debug("Restoring interface state",
-		"interface", ifaceName,
-		"state_length", len(state))
-
-	// Flush interface first
-	if err := runCommandContext(ctx, "ip", "addr", "flush", "dev", ifaceName); err != nil {
-		logger.Warn("Failed to flush interface during rollback",
-			"interface", ifaceName,
-			"error", err)
+	state.DHCP = DHCPClients.Running(ifaceName)
+	state.DHCPMetric = DHCPClients.metric(ifaceName)
+	state.ModemConnected = Modems.Connected(ifaceName)
+	if settings, ok := Modems.Settings(ifaceName); ok && state.ModemConnected {
+		state.Modem = &settings
 	}
-
-	// Bring interface down
-	if err := runCommandContext(ctx, "ip", "link", "set", ifaceName, "down"); err != nil {
-		logger.Warn("Failed to bring interface down during rollback",
-			"interface", ifaceName,
-			"error", err)
-	}
-
-	// TODO: Parse state and restore IPs, routes, etc.
-	// This requires more sophisticated state parsing
+	a.previousState[ifaceName] = state
 	return nil
 }
 
 // applyInterface applies configuration to a single interface
-func (a *NetworkApplier) applyInterface(ctx context.Context, ifaceName string, section *uci.Section) error {
+func (a *NetworkApplier) applyInterface(ctx context.Context, config *uci.Config, ifaceName string, section *uci.Section) error {
 	// Validate interface name to prevent command injection
 	if err := util.ValidateInterfaceName(ifaceName); err != nil {
 		return fmt.Errorf("invalid interface name: %w", err)
@@ -154,6 +827,17 @@ func (a *NetworkApplier) applyInterface(ctx context.Context, ifaceName string, s
 
 	proto, _ := section.GetOption("proto")
 
+	// Interfaces leaving DHCP release their lease first, and those that
+	// are no longer modems drop their connection
+	if proto != "dhcp" {
+		DHCPClients.Stop(ctx, ifaceName)
+	}
+	if !modem.IsProto(proto) {
+		if err := Modems.Disconnect(ctx, ifaceName); err != nil {
+			logger.Warn("Failed to disconnect modem", "interface", ifaceName, "error", err)
+		}
+	}
+
 	switch proto {
 	case "static":
 		return a.applyStaticInterface(ctx, ifaceName, section)
@@ -161,6 +845,10 @@ func (a *NetworkApplier) applyInterface(ctx context.Context, ifaceName string, s
 		return a.applyDHCPInterface(ctx, ifaceName, section)
 	case "none":
 		return a.applyNoneInterface(ctx, ifaceName)
+	case modem.ProtoModemManager, modem.ProtoQMI:
+		return a.applyModemInterface(ctx, ifaceName, section)
+	case wireguard.Proto:
+		return a.applyWireGuardInterface(ctx, config, ifaceName)
 	default:
 		return fmt.Errorf("unsupported protocol: %s", proto)
 	}
@@ -185,6 +873,14 @@ func (a *NetworkApplier) applyStaticInterface(ctx context.Context, ifaceName str
 		return fmt.Errorf("invalid netmask: %w", err)
 	}
 
+	link, err := parseLinkOptions(section)
+	if err != nil {
+		return err
+	}
+	if err := link.apply(ctx, ifaceName); err != nil {
+		return err
+	}
+
 	// Flush existing addresses
 	if err := runCommandContext(ctx, "ip", "addr", "flush", "dev", ifaceName); err != nil {
 		return fmt.Errorf("failed to flush interface: %w", err)
@@ -196,29 +892,21 @@ func (a *NetworkApplier) applyStaticInterface(ctx context.Context, ifaceName str
 	if err := runCommandContext(ctx, "ip", "addr", "add", addr, "dev", ifaceName); err != nil {
 		return fmt.Errorf("failed to add address: %w", err)
 	}
+	if err := link.addIP6Addresses(ctx, ifaceName); err != nil {
+		return err
+	}
 
 	// Bring interface up
 	if err := runCommandContext(ctx, "ip", "link", "set", ifaceName, "up"); err != nil {
 		return fmt.Errorf("failed to bring interface up: %w", err)
 	}
 
-	// Add gateway if specified
+	// The default route is added by applyGateways, once every interface
+	// has its address
 	if gateway, ok := section.GetOption("gateway"); ok {
-		// Validate gateway IP
 		if err := util.ValidateIPAddress(gateway); err != nil {
 			return fmt.Errorf("invalid gateway: %w", err)
 		}
-
-		// Remove existing default route (ignore errors)
-		_ = runCommandContext(ctx, "ip", "route", "del", "default")
-
-		// Add new default route
-		if err := runCommandContext(ctx, "ip", "route", "add", "default", "via", gateway, "dev", ifaceName); err != nil {
-			// Ignore error if route already exists
-			if !strings.Contains(err.Error(), "File exists") {
-				return fmt.Errorf("failed to add gateway: %w", err)
-			}
-		}
 	}
 
 	return nil
@@ -226,26 +914,243 @@ func (a *NetworkApplier) applyStaticInterface(ctx context.Context, ifaceName str
 
 // applyDHCPInterface configures a DHCP interface
 func (a *NetworkApplier) applyDHCPInterface(ctx context.Context, ifaceName string, section *uci.Section) error {
+	link, err := parseLinkOptions(section)
+	if err != nil {
+		return err
+	}
+	if err := link.apply(ctx, ifaceName); err != nil {
+		return err
+	}
+
+	// Static IPv6 addresses sit next to the leased and autoconfigured ones,
+	// which aren't permanent
+	if err := runCommandContext(ctx, "ip", "-6", "addr", "flush", "dev", ifaceName, "scope", "global", "permanent"); err != nil {
+		return fmt.Errorf("failed to flush IPv6 addresses: %w", err)
+	}
+	if err := link.addIP6Addresses(ctx, ifaceName); err != nil {
+		return err
+	}
+
 	// Bring interface up
 	if err := runCommandContext(ctx, "ip", "link", "set", ifaceName, "up"); err != nil {
 		return fmt.Errorf("failed to bring interface up: %w", err)
 	}
 
-	// Release existing DHCP lease (safer than pkill)
-	// dhclient -r will gracefully release and exit
-	_ = runCommandContext(ctx, "dhclient", "-r", ifaceName)
+	// Release any existing lease and start a supervised DHCP client, which
+	// installs its routes with the metric
+	return DHCPClients.Start(ctx, ifaceName, link.Metric)
+}
 
-	// Start DHCP client
-	if err := runCommandContext(ctx, "dhclient", ifaceName); err != nil {
-		return fmt.Errorf("failed to start dhcp client: %w", err)
+// applyNoneInterface brings down an interface
+func (a *NetworkApplier) applyNoneInterface(ctx context.Context, ifaceName string) error {
+	return runCommandContext(ctx, "ip", "link", "set", ifaceName, "down")
+}
+
+// applyWireGuardInterface creates a WireGuard interface if needed, loads its
+// keys and peers and brings it up with its addresses, routing the allowed
+// IPs of peers with route_allowed_ips. A rollback restores the addresses and
+// routes of an interface that already existed, not its peers.
+func (a *NetworkApplier) applyWireGuardInterface(ctx context.Context, config *uci.Config, ifaceName string) error {
+	conf, routes, err := wireguard.Setconf(config, ifaceName)
+	if err != nil {
+		return err
+	}
+	addresses, err := wireguard.Addresses(config.GetSection("interface", ifaceName))
+	if err != nil {
+		return err
 	}
 
+	if _, err := net.InterfaceByName(ifaceName); err != nil {
+		if err := runCommandContext(ctx, "ip", "link", "add", "dev", ifaceName, "type", "wireguard"); err != nil {
+			return fmt.Errorf("failed to create wireguard interface: %w", err)
+		}
+	}
+
+	// The keys are passed on stdin, not the command line
+	cmd := exec.CommandContext(ctx, "wg", "setconf", ifaceName, "/dev/stdin")
+	cmd.Stdin = strings.NewReader(conf)
+	if output, err := cmd.CombinedOutput(); err != nil {
+		return fmt.Errorf("failed to configure wireguard: %s: %w", strings.TrimSpace(string(output)), err)
+	}
+
+	if err := runCommandContext(ctx, "ip", "addr", "flush", "dev", ifaceName); err != nil {
+		return fmt.Errorf("failed to flush interface: %w", err)
+	}
+	for _, address := range addresses {
+		if err := runCommandContext(ctx, "ip", "addr", "add", address, "dev", ifaceName); err != nil {
+			return fmt.Errorf("failed to add address: %w", err)
+		}
+	}
+	if err := runCommandContext(ctx, "ip", "link", "set", ifaceName, "up"); err != nil {
+		return fmt.Errorf("failed to bring interface up: %w", err)
+	}
+	for _, route := range routes {
+		if err := runCommandContext(ctx, "ip", "route", "replace", route, "dev", ifaceName); err != nil {
+			return fmt.Errorf("failed to route %s: %w", route, err)
+		}
+	}
 	return nil
 }
 
-// applyNoneInterface brings down an interface
-func (a *NetworkApplier) applyNoneInterface(ctx context.Context, ifaceName string) error {
-	return runCommandContext(ctx, "ip", "link", "set", ifaceName, "down")
+// linkOptions are the optional link settings of a static or DHCP interface
+// section, OpenWrt's mtu, macaddr, metric and ip6addr options
+type linkOptions struct {
+	MTU          int      // 0 keeps the device's MTU
+	MAC          string   // Lowercase; empty keeps the device's address
+	Metric       int      // Of the default route
+	IP6Addresses []string // CIDR, canonical form
+}
+
+// minIPv6MTU is the smallest MTU IPv6 runs on
+const minIPv6MTU = 1280
+
+// parseLinkOptions reads and checks the link options of an interface section.
+// ip6addr is a list or a space-separated option, as in OpenWrt.
+func parseLinkOptions(section *uci.Section) (linkOptions, error) {
+	var link linkOptions
+	if mtu, ok := section.GetOption("mtu"); ok && mtu != "" {
+		n, err := strconv.Atoi(mtu)
+		if err != nil || n < 68 || n > 65535 {
+			return link, fmt.Errorf("invalid mtu %q: must be 68-65535", mtu)
+		}
+		link.MTU = n
+	}
+
+	if mac, ok := section.GetOption("macaddr"); ok && mac != "" {
+		hw, err := net.ParseMAC(mac)
+		if err != nil || len(hw) != 6 {
+			return link, fmt.Errorf("invalid macaddr %q", mac)
+		}
+		if hw[0]&1 != 0 {
+			return link, fmt.Errorf("invalid macaddr %q: multicast addresses can't be assigned", mac)
+		}
+		if hw.String() == "00:00:00:00:00:00" {
+			return link, fmt.Errorf("invalid macaddr %q", mac)
+		}
+		link.MAC = hw.String()
+	}
+
+	if metric, ok := section.GetOption("metric"); ok && metric != "" {
+		n, err := strconv.ParseUint(metric, 10, 32)
+		if err != nil {
+			return link, fmt.Errorf("invalid metric %q: must be 0-4294967295", metric)
+		}
+		link.Metric = int(n)
+	}
+
+	addresses := section.GetList("ip6addr")
+	if value, ok := section.GetOption("ip6addr"); ok {
+		addresses = append(strings.Fields(value), addresses...)
+	}
+	for _, address := range addresses {
+		ip, ipnet, err := net.ParseCIDR(address)
+		if err != nil || ip.To4() != nil {
+			return link, fmt.Errorf("invalid ip6addr %q: must be an IPv6 address with a prefix length", address)
+		}
+		ones, _ := ipnet.Mask.Size()
+		link.IP6Addresses = append(link.IP6Addresses, fmt.Sprintf("%s/%d", ip, ones))
+	}
+	if len(link.IP6Addresses) > 0 && link.MTU > 0 && link.MTU < minIPv6MTU {
+		return link, fmt.Errorf("mtu %d is too small for ip6addr: IPv6 needs at least %d", link.MTU, minIPv6MTU)
+	}
+	return link, nil
+}
+
+// apply sets the MAC address and MTU of a device, before its addresses are
+// added and it is brought up
+func (o linkOptions) apply(ctx context.Context, ifaceName string) error {
+	if o.MAC != "" {
+		if err := setLinkAddress(ctx, ifaceName, o.MAC); err != nil {
+			return fmt.Errorf("failed to set macaddr: %w", err)
+		}
+	}
+	if o.MTU > 0 {
+		if err := runCommandContext(ctx, "ip", "link", "set", "dev", ifaceName, "mtu", strconv.Itoa(o.MTU)); err != nil {
+			return fmt.Errorf("failed to set mtu: %w", err)
+		}
+	}
+	return nil
+}
+
+// addIP6Addresses adds the static IPv6 addresses to a device
+func (o linkOptions) addIP6Addresses(ctx context.Context, ifaceName string) error {
+	for _, address := range o.IP6Addresses {
+		if err := runCommandContext(ctx, "ip", "-6", "addr", "replace", address, "dev", ifaceName); err != nil {
+			return fmt.Errorf("failed to add ip6addr %s: %w", address, err)
+		}
+	}
+	return nil
+}
+
+// validate checks the link options against the state of the device
+func (o linkOptions) validate(state *interfaceState) error {
+	if o.MTU > 0 && state.MTU != o.MTU {
+		return fmt.Errorf("mtu is %d, expected %d", state.MTU, o.MTU)
+	}
+	if o.MAC != "" && state.MAC != o.MAC {
+		return fmt.Errorf("macaddr is %s, expected %s", state.MAC, o.MAC)
+	}
+	for _, address := range o.IP6Addresses {
+		if !slices.ContainsFunc(state.Addresses, func(addr addressState) bool {
+			return fmt.Sprintf("%s/%d", addr.Local, addr.PrefixLen) == address
+		}) {
+			return fmt.Errorf("address %s is not configured", address)
+		}
+	}
+	return nil
+}
+
+// writeLinkCommands writes the commands setting a device's MAC address and MTU
+func writeLinkCommands(buf *strings.Builder, ifaceName string, link SimulatedLink) {
+	if link.MAC != "" {
+		fmt.Fprintf(buf, "ip link set dev %s address %s\n", ifaceName, link.MAC)
+	}
+	if link.MTU > 0 {
+		fmt.Fprintf(buf, "ip link set dev %s mtu %d\n", ifaceName, link.MTU)
+	}
+}
+
+// setLinkAddress gives a device a MAC address. Most drivers only change it
+// while the link is down, so a busy device is taken down for the change and
+// left for the caller to bring back up.
+func setLinkAddress(ctx context.Context, ifaceName, mac string) error {
+	if iface, err := net.InterfaceByName(ifaceName); err == nil && iface.HardwareAddr.String() == mac {
+		return nil
+	}
+	if err := runCommandContext(ctx, "ip", "link", "set", "dev", ifaceName, "address", mac); err == nil {
+		return nil
+	}
+	if err := runCommandContext(ctx, "ip", "link", "set", "dev", ifaceName, "down"); err != nil {
+		return err
+	}
+	return runCommandContext(ctx, "ip", "link", "set", "dev", ifaceName, "address", mac)
+}
+
+// checkWireGuard checks a WireGuard interface's config and that the wg tool
+// is installed
+func checkWireGuard(config *uci.Config, ifaceName string) error {
+	if _, _, err := wireguard.Setconf(config, ifaceName); err != nil {
+		return err
+	}
+	if _, err := wireguard.Addresses(config.GetSection("interface", ifaceName)); err != nil {
+		return err
+	}
+	if _, err := exec.LookPath("wg"); err != nil {
+		return wireguard.ErrUnavailable
+	}
+	return nil
+}
+
+// checkModem checks a modem interface's settings and that ModemManager is
+// installed
+func checkModem(section *uci.Section) error {
+	if _, err := modem.ParseSettings(section); err != nil {
+		return err
+	}
+	if _, err := exec.LookPath("mmcli"); err != nil {
+		return modem.ErrUnavailable
+	}
+	return nil
 }
 
 // convertNetmaskToCIDR converts a netmask to CIDR notation
@@ -296,3 +1201,16 @@ func runCommandContext(ctx context.Context, name string, args ...string) error {
 	}
 	return nil
 }
+
+// commandOutputContext runs a command with context support and returns its output
+func commandOutputContext(ctx context.Context, name string, args ...string) ([]byte, error) {
+	cmd := exec.CommandContext(ctx, name, args...)
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+
+	output, err := cmd.Output()
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", stderr.String(), err)
+	}
+	return output, nil
+}
@@ -0,0 +1,317 @@
+package appliers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"slices"
+	"strconv"
+
+	"github.com/thesabbir/hellfire/pkg/logger"
+	"github.com/thesabbir/hellfire/pkg/modem"
+)
+
+// foreverLifetime is the address lifetime the kernel reports for permanent addresses
+const foreverLifetime = 4294967295
+
+// interfaceState is the link, address and route state of an interface, as
+// dumped by iproute2 from netlink in JSON form
+type interfaceState struct {
+	Up             bool
+	MTU            int
+	MAC            string
+	Master         string // Device the interface is enslaved to, if any
+	VRF            string // Master when it is a VRF
+	VRFTable       int    // Routing table of the VRF
+	Addresses      []addressState
+	Routes         []routeState    // Main or VRF table, excluding routes the kernel adds for addresses
+	DHCP           bool            // A supervised DHCP client was running
+	DHCPMetric     int             // Route metric of the DHCP client
+	Modem          *modem.Settings // Settings the modem was connected with, if known
+	ModemConnected bool            // The network applier had connected a modem
+	Neighbors      []neighborState // Permanent neighbor (static ARP/NDP) entries
+}
+
+// addressState is one address as reported by `ip -j addr show`
+type addressState struct {
+	Family            string `json:"family"`
+	Local             string `json:"local"`
+	PrefixLen         int    `json:"prefixlen"`
+	Broadcast         string `json:"broadcast,omitempty"`
+	Scope             string `json:"scope,omitempty"`
+	Label             string `json:"label,omitempty"`
+	ValidLifetime     uint32 `json:"valid_life_time"`
+	PreferredLifetime uint32 `json:"preferred_life_time"`
+}
+
+// routeState is one route as reported by `ip -j route show`
+type routeState struct {
+	Family   string   `json:"-"`
+	Type     string   `json:"type,omitempty"`
+	Dst      string   `json:"dst"`
+	Gateway  string   `json:"gateway,omitempty"`
+	Dev      string   `json:"dev,omitempty"`
+	Protocol string   `json:"protocol,omitempty"`
+	Scope    string   `json:"scope,omitempty"`
+	Metric   int      `json:"metric,omitempty"`
+	PrefSrc  string   `json:"prefsrc,omitempty"`
+	Table    string   `json:"table,omitempty"` // Empty for the main table
+	Flags    []string `json:"flags,omitempty"`
+}
+
+// neighborState is one permanent entry as reported by `ip -j neigh show`
+type neighborState struct {
+	Dst    string `json:"dst"`
+	LLAddr string `json:"lladdr"`
+}
+
+// linkDump is the part of `ip -j -d addr show dev X` needed to restore an interface
+type linkDump struct {
+	vrfDump
+	Flags    []string       `json:"flags"`
+	MTU      int            `json:"mtu"`
+	Address  string         `json:"address"`
+	Master   string         `json:"master"`
+	AddrInfo []addressState `json:"addr_info"`
+}
+
+// parseLinkDump parses `ip -j -d addr show dev X` output into the link state and addresses
+func parseLinkDump(data []byte) (*interfaceState, error) {
+	var links []linkDump
+	if err := json.Unmarshal(data, &links); err != nil {
+		return nil, fmt.Errorf("failed to parse address dump: %w", err)
+	}
+	if len(links) != 1 {
+		return nil, fmt.Errorf("expected one interface in address dump, got %d", len(links))
+	}
+
+	link := links[0]
+	state := &interfaceState{
+		Up:        slices.Contains(link.Flags, "UP"),
+		MTU:       link.MTU,
+		MAC:       link.Address,
+		Master:    link.Master,
+		Addresses: link.AddrInfo,
+	}
+	if link.LinkInfo.SlaveKind == "vrf" {
+		state.VRF = link.Master
+		state.VRFTable = link.LinkInfo.SlaveData.Table
+	}
+	return state, nil
+}
+
+// parseRouteDump parses `ip -j route show` output, leaving out the prefix
+// routes the kernel creates itself when an address is added
+func parseRouteDump(data []byte, family string) ([]routeState, error) {
+	var routes []routeState
+	if err := json.Unmarshal(data, &routes); err != nil {
+		return nil, fmt.Errorf("failed to parse route dump: %w", err)
+	}
+
+	kept := routes[:0]
+	for _, route := range routes {
+		if route.Protocol == "kernel" {
+			continue
+		}
+		route.Family = family
+		kept = append(kept, route)
+	}
+	return kept, nil
+}
+
+// captureInterfaceState dumps the link state, addresses and routes of an interface
+func captureInterfaceState(ctx context.Context, ifaceName string) (*interfaceState, error) {
+	output, err := commandOutputContext(ctx, "ip", "-j", "-d", "addr", "show", "dev", ifaceName)
+	if err != nil {
+		return nil, err
+	}
+	state, err := parseLinkDump(output)
+	if err != nil {
+		return nil, err
+	}
+
+	// The routes of an interface in a VRF are in the VRF's table
+	selector := []string{"dev", ifaceName}
+	table := ""
+	if state.VRF != "" {
+		table = strconv.Itoa(state.VRFTable)
+		selector = append(selector, "table", table)
+	}
+	for _, family := range []string{"inet", "inet6"} {
+		routes, err := captureRoutes(ctx, family, selector...)
+		if err != nil {
+			return nil, err
+		}
+		for i := range routes {
+			routes[i].Table = table
+		}
+		state.Routes = append(state.Routes, routes...)
+	}
+
+	neighbors, err := captureNeighbors(ctx, ifaceName)
+	if err != nil {
+		return nil, err
+	}
+	state.Neighbors = neighbors
+
+	return state, nil
+}
+
+// captureNeighbors dumps the permanent neighbor entries of an interface
+func captureNeighbors(ctx context.Context, ifaceName string) ([]neighborState, error) {
+	output, err := commandOutputContext(ctx, "ip", "-j", "neigh", "show", "dev", ifaceName, "nud", "permanent")
+	if err != nil {
+		return nil, err
+	}
+
+	var neighbors []neighborState
+	if err := json.Unmarshal(output, &neighbors); err != nil {
+		return nil, fmt.Errorf("failed to parse neighbor dump: %w", err)
+	}
+	return neighbors, nil
+}
+
+// captureRoutes dumps the main table routes of an address family matching the selector
+func captureRoutes(ctx context.Context, family string, selector ...string) ([]routeState, error) {
+	args := append([]string{familyFlag(family), "-j", "route", "show"}, selector...)
+	output, err := commandOutputContext(ctx, "ip", args...)
+	if err != nil {
+		return nil, err
+	}
+	return parseRouteDump(output, family)
+}
+
+// restoreInterfaceState replaces the interface's link state, addresses and
+// routes with the captured ones. Every step is attempted; failures are
+// returned together.
+func restoreInterfaceState(ctx context.Context, ifaceName string, state *interfaceState) error {
+	var errs []error
+	run := func(args ...string) {
+		if err := runCommandContext(ctx, "ip", args...); err != nil {
+			errs = append(errs, fmt.Errorf("ip %v: %w", args, err))
+		}
+	}
+
+	// Back into or out of a VRF first: that cycles the link
+	if state.VRF != "" {
+		run("link", "set", "dev", ifaceName, "master", state.VRF)
+	} else if state.Master == "" {
+		run("link", "set", "dev", ifaceName, "nomaster")
+	}
+
+	// Remove whatever the failed apply left behind
+	run("neigh", "flush", "dev", ifaceName, "nud", "permanent")
+	run("-4", "route", "flush", "dev", ifaceName)
+	run("-6", "route", "flush", "dev", ifaceName)
+	run("addr", "flush", "dev", ifaceName)
+
+	if state.MTU > 0 {
+		run("link", "set", "dev", ifaceName, "mtu", strconv.Itoa(state.MTU))
+	}
+	if state.MAC != "" {
+		if err := setLinkAddress(ctx, ifaceName, state.MAC); err != nil {
+			errs = append(errs, fmt.Errorf("failed to restore MAC address: %w", err))
+		}
+	}
+
+	for _, addr := range state.Addresses {
+		run(addr.addArgs(ifaceName)...)
+	}
+
+	if !state.Up {
+		// The kernel drops an interface's routes and neighbors while it is down
+		run("link", "set", "dev", ifaceName, "down")
+		return errors.Join(errs...)
+	}
+	run("link", "set", "dev", ifaceName, "up")
+
+	for _, route := range state.Routes {
+		run(route.replaceArgs(ifaceName)...)
+	}
+
+	for _, neighbor := range state.Neighbors {
+		run(neighbor.replaceArgs(ifaceName)...)
+	}
+
+	return errors.Join(errs...)
+}
+
+// restoreRoutes re-adds routes that were removed, e.g. default routes on
+// interfaces outside the applied config
+func restoreRoutes(ctx context.Context, routes []routeState) error {
+	var errs []error
+	for _, route := range routes {
+		args := route.replaceArgs(route.Dev)
+		if err := runCommandContext(ctx, "ip", args...); err != nil {
+			logger.Warn("Failed to restore route", "route", route.Dst, "dev", route.Dev, "error", err)
+			errs = append(errs, fmt.Errorf("ip %v: %w", args, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// addArgs returns the `ip addr replace` arguments that recreate the address
+func (a addressState) addArgs(ifaceName string) []string {
+	args := []string{familyFlag(a.Family), "addr", "replace",
+		a.Local + "/" + strconv.Itoa(a.PrefixLen), "dev", ifaceName}
+	if a.Broadcast != "" {
+		args = append(args, "broadcast", a.Broadcast)
+	}
+	if a.Scope != "" {
+		args = append(args, "scope", a.Scope)
+	}
+	if a.Label != "" && a.Label != ifaceName {
+		args = append(args, "label", a.Label)
+	}
+	if a.ValidLifetime != 0 && a.ValidLifetime != foreverLifetime {
+		args = append(args,
+			"valid_lft", strconv.FormatUint(uint64(a.ValidLifetime), 10),
+			"preferred_lft", strconv.FormatUint(uint64(a.PreferredLifetime), 10))
+	}
+	return args
+}
+
+// replaceArgs returns the `ip route replace` arguments that recreate the route
+func (r routeState) replaceArgs(ifaceName string) []string {
+	args := []string{familyFlag(r.Family), "route", "replace"}
+	if r.Type != "" && r.Type != "unicast" {
+		args = append(args, r.Type)
+	}
+	args = append(args, r.Dst)
+	if r.Gateway != "" {
+		args = append(args, "via", r.Gateway)
+	}
+	args = append(args, "dev", ifaceName)
+	if r.Protocol != "" {
+		args = append(args, "proto", r.Protocol)
+	}
+	if r.Scope != "" {
+		args = append(args, "scope", r.Scope)
+	}
+	if r.Metric != 0 {
+		args = append(args, "metric", strconv.Itoa(r.Metric))
+	}
+	if r.PrefSrc != "" {
+		args = append(args, "src", r.PrefSrc)
+	}
+	if slices.Contains(r.Flags, "onlink") {
+		args = append(args, "onlink")
+	}
+	if r.Table != "" && r.Table != "main" {
+		args = append(args, "table", r.Table)
+	}
+	return args
+}
+
+// replaceArgs returns the `ip neigh replace` arguments that recreate the entry
+func (n neighborState) replaceArgs(ifaceName string) []string {
+	return []string{"neigh", "replace", n.Dst, "lladdr", n.LLAddr, "dev", ifaceName, "nud", "permanent"}
+}
+
+func familyFlag(family string) string {
+	if family == "inet6" {
+		return "-6"
+	}
+	return "-4"
+}
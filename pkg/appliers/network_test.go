@@ -0,0 +1,760 @@
+package appliers
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"reflect"
+	"runtime"
+	"slices"
+	"strconv"
+	"strings"
+	"syscall"
+	"testing"
+
+	"github.com/thesabbir/hellfire/pkg/uci"
+)
+
+func TestParseLinkDump(t *testing.T) {
+	dump := `[{"ifindex":3,"ifname":"eth0","flags":["BROADCAST","MULTICAST","UP","LOWER_UP"],"mtu":1400,
+		"addr_info":[{"family":"inet","local":"10.0.0.2","prefixlen":24,"broadcast":"10.0.0.255","scope":"global",
+		"label":"eth0","valid_life_time":4294967295,"preferred_life_time":4294967295},
+		{"family":"inet6","local":"fe80::1","prefixlen":64,"scope":"link","valid_life_time":4294967295,"preferred_life_time":4294967295}]}]`
+
+	state, err := parseLinkDump([]byte(dump))
+	if err != nil {
+		t.Fatalf("parseLinkDump: %v", err)
+	}
+	if !state.Up || state.MTU != 1400 || len(state.Addresses) != 2 {
+		t.Fatalf("unexpected state: %+v", state)
+	}
+
+	got := strings.Join(state.Addresses[0].addArgs("eth0"), " ")
+	want := "-4 addr replace 10.0.0.2/24 dev eth0 broadcast 10.0.0.255 scope global"
+	if got != want {
+		t.Errorf("addArgs = %q, want %q", got, want)
+	}
+}
+
+func TestParseRouteDump(t *testing.T) {
+	dump := `[{"dst":"default","gateway":"10.0.0.1","dev":"eth0","protocol":"static","metric":100,"flags":[]},
+		{"dst":"10.0.0.0/24","dev":"eth0","protocol":"kernel","scope":"link","prefsrc":"10.0.0.2","flags":[]},
+		{"dst":"172.16.0.0/16","gateway":"10.0.0.254","dev":"eth0","flags":["onlink"]}]`
+
+	routes, err := parseRouteDump([]byte(dump), "inet")
+	if err != nil {
+		t.Fatalf("parseRouteDump: %v", err)
+	}
+	if len(routes) != 2 {
+		t.Fatalf("expected kernel route to be skipped, got %+v", routes)
+	}
+
+	got := strings.Join(routes[0].replaceArgs("eth0"), " ")
+	want := "-4 route replace default via 10.0.0.1 dev eth0 proto static metric 100"
+	if got != want {
+		t.Errorf("replaceArgs = %q, want %q", got, want)
+	}
+	got = strings.Join(routes[1].replaceArgs("eth0"), " ")
+	want = "-4 route replace 172.16.0.0/16 via 10.0.0.254 dev eth0 onlink"
+	if got != want {
+		t.Errorf("replaceArgs = %q, want %q", got, want)
+	}
+}
+
+// enterNetworkNamespace moves the test's thread, and the commands it runs,
+// into a new network namespace. The thread stays locked so it is discarded
+// when the test ends.
+func enterNetworkNamespace(t *testing.T) {
+	t.Helper()
+	if os.Geteuid() != 0 {
+		t.Skip("network namespaces require root")
+	}
+	if _, err := exec.LookPath("ip"); err != nil {
+		t.Skip("ip not installed")
+	}
+
+	runtime.LockOSThread()
+	if err := syscall.Unshare(syscall.CLONE_NEWNET); err != nil {
+		t.Skipf("cannot create network namespace: %v", err)
+	}
+}
+
+func mustIP(t *testing.T, args ...string) {
+	t.Helper()
+	if err := runCommandContext(context.Background(), "ip", args...); err != nil {
+		t.Fatalf("ip %s: %v", strings.Join(args, " "), err)
+	}
+}
+
+func TestNetworkCheck(t *testing.T) {
+	config, err := uci.Parse(strings.NewReader(`
+config interface 'lo'
+	option proto 'static'
+
+config interface 'hfmissing0'
+	option proto 'dhcp'
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = NewNetworkApplier().Check(context.Background(), config)
+	if err == nil || !strings.Contains(err.Error(), "interface hfmissing0: no such network device") {
+		t.Fatalf("Check() = %v, want missing hfmissing0", err)
+	}
+	if strings.Contains(err.Error(), "interface lo") {
+		t.Errorf("Check() reported lo, which exists: %v", err)
+	}
+}
+
+func TestParseLinkOptions(t *testing.T) {
+	section := uci.NewSection("interface", "lan")
+	section.SetOption("mtu", "1492")
+	section.SetOption("macaddr", "02:AA:BB:CC:DD:01")
+	section.SetOption("metric", "10")
+	section.SetOption("ip6addr", "fd00::1/64 fd00:1:0:0::1/48")
+	section.AddListValue("ip6addr", "2001:db8::1/64")
+
+	link, err := parseLinkOptions(section)
+	if err != nil {
+		t.Fatalf("parseLinkOptions: %v", err)
+	}
+	want := linkOptions{MTU: 1492, MAC: "02:aa:bb:cc:dd:01", Metric: 10, IP6Addresses: []string{"fd00::1/64", "fd00:1::1/48", "2001:db8::1/64"}}
+	if !reflect.DeepEqual(link, want) {
+		t.Errorf("link = %+v, want %+v", link, want)
+	}
+
+	for option, value := range map[string]string{
+		"mtu":     "67",
+		"macaddr": "01:00:5e:00:00:01",
+		"metric":  "-1",
+		"ip6addr": "192.168.1.1/24",
+	} {
+		invalid := uci.NewSection("interface", "lan")
+		invalid.SetOption(option, value)
+		if _, err := parseLinkOptions(invalid); err == nil || !strings.Contains(err.Error(), option) {
+			t.Errorf("%s %q: err = %v", option, value, err)
+		}
+	}
+
+	section.SetOption("mtu", "1200")
+	if _, err := parseLinkOptions(section); err == nil {
+		t.Error("expected an mtu below 1280 with ip6addr to fail")
+	}
+}
+
+func TestNetworkRollbackRestoresInterfaceState(t *testing.T) {
+	enterNetworkNamespace(t)
+	ctx := context.Background()
+
+	mustIP(t, "link", "add", "hf0", "type", "veth", "peer", "name", "hf1")
+	mustIP(t, "link", "set", "hf1", "up")
+	mustIP(t, "link", "set", "hf0", "mtu", "1400", "up")
+	mustIP(t, "addr", "add", "10.9.0.1/24", "dev", "hf0")
+	mustIP(t, "addr", "add", "10.9.1.1/24", "dev", "hf0", "label", "hf0:1")
+	mustIP(t, "route", "add", "default", "via", "10.9.0.254", "dev", "hf0", "metric", "10")
+	mustIP(t, "route", "add", "172.16.0.0/16", "via", "10.9.1.254", "dev", "hf0", "proto", "static")
+	mustIP(t, "neigh", "add", "10.9.0.7", "lladdr", "02:00:00:00:00:07", "dev", "hf0", "nud", "permanent")
+
+	// A down interface keeps its addresses
+	mustIP(t, "link", "add", "hf2", "type", "veth", "peer", "name", "hf3")
+	mustIP(t, "addr", "add", "10.9.2.1/24", "dev", "hf2")
+
+	before := map[string]*interfaceState{}
+	for _, name := range []string{"hf0", "hf2"} {
+		state, err := captureInterfaceState(ctx, name)
+		if err != nil {
+			t.Fatalf("capture %s: %v", name, err)
+		}
+		before[name] = state
+	}
+
+	config, err := uci.Parse(strings.NewReader(`
+config interface 'hf0'
+	option proto 'static'
+	option ipaddr '192.168.50.1'
+	option netmask '255.255.255.0'
+	option gateway '192.168.50.254'
+	option mtu '1420'
+	option macaddr '02:00:00:00:50:01'
+	option metric '20'
+	option ip6addr 'fd00:50::1/64'
+
+config interface 'hf2'
+	option proto 'static'
+	option ipaddr '192.168.60.1'
+	option netmask '255.255.255.0'
+
+config neighbor
+	option interface 'hf0'
+	option ipaddr '192.168.50.20'
+	option mac '02:AA:BB:CC:DD:20'
+`))
+	if err != nil {
+		t.Fatalf("parse config: %v", err)
+	}
+
+	applier := NewNetworkApplier()
+	if err := applier.Apply(ctx, config); err != nil {
+		t.Fatalf("Apply: %v", err)
+	}
+	if err := applier.Validate(ctx); err != nil {
+		t.Fatalf("Validate: %v", err)
+	}
+
+	state, err := captureInterfaceState(ctx, "hf0")
+	if err != nil {
+		t.Fatalf("capture hf0: %v", err)
+	}
+	if state.MTU != 1420 || state.MAC != "02:00:00:00:50:01" {
+		t.Errorf("hf0 mtu %d, macaddr %s", state.MTU, state.MAC)
+	}
+
+	neighbors, err := captureNeighbors(ctx, "hf0")
+	if err != nil {
+		t.Fatalf("capture neighbors: %v", err)
+	}
+	if want := []neighborState{{Dst: "192.168.50.20", LLAddr: "02:aa:bb:cc:dd:20"}}; !reflect.DeepEqual(neighbors, want) {
+		t.Errorf("static neighbors = %+v, want %+v", neighbors, want)
+	}
+
+	mustIP(t, "route", "del", "default")
+	if err := applier.Validate(ctx); err == nil || !strings.Contains(err.Error(), "default route") {
+		t.Errorf("Validate without default route = %v, want default route error", err)
+	}
+
+	if err := applier.Rollback(ctx); err != nil {
+		t.Fatalf("Rollback: %v", err)
+	}
+
+	for name, want := range before {
+		got, err := captureInterfaceState(ctx, name)
+		if err != nil {
+			t.Fatalf("capture %s: %v", name, err)
+		}
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("%s not restored:\n got  %+v\n want %+v", name, got, want)
+		}
+	}
+}
+
+func TestGateways(t *testing.T) {
+	config, err := uci.Parse(strings.NewReader(`
+config interface 'wan'
+	option proto 'static'
+	option ipaddr '192.0.2.10'
+	option netmask '255.255.255.0'
+	option gateway '192.0.2.1'
+
+config interface 'wan2'
+	option proto 'static'
+	option ipaddr '198.51.100.10'
+	option netmask '255.255.255.0'
+	option gateway '198.51.100.1'
+	option metric '10'
+	option ip4table '100'
+
+config interface 'lan'
+	option proto 'static'
+	option ipaddr '192.168.1.1'
+	option netmask '255.255.255.0'
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	gateways, err := Gateways(config)
+	if err != nil {
+		t.Fatalf("Gateways: %v", err)
+	}
+	want := []Gateway{
+		{Interface: "wan", Address: "192.0.2.10", Subnet: "192.0.2.0/24", Gateway: "192.0.2.1", Table: GatewayTableBase + 1},
+		{Interface: "wan2", Address: "198.51.100.10", Subnet: "198.51.100.0/24", Gateway: "198.51.100.1", Metric: 10, Table: 100},
+	}
+	if !reflect.DeepEqual(gateways, want) {
+		t.Errorf("gateways = %+v, want %+v", gateways, want)
+	}
+
+	// One default route would replace the other
+	config.GetSection("interface", "wan2").SetOption("metric", "0")
+	if _, err := Gateways(config); err == nil || !strings.Contains(err.Error(), "metric 0") {
+		t.Errorf("Gateways with the same metric = %v", err)
+	}
+}
+
+func TestVRFs(t *testing.T) {
+	config, err := uci.Parse(strings.NewReader(`
+config vrf 'mgmt'
+	option table '10'
+
+config interface 'eth0'
+	option proto 'static'
+	option ipaddr '192.0.2.10'
+	option netmask '255.255.255.0'
+	option gateway '192.0.2.1'
+	option vrf 'mgmt'
+
+config interface 'wan'
+	option proto 'static'
+	option ipaddr '198.51.100.10'
+	option netmask '255.255.255.0'
+	option gateway '198.51.100.1'
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	vrfs, err := VRFs(config)
+	if err != nil {
+		t.Fatalf("VRFs: %v", err)
+	}
+	if want := []VRF{{Name: "mgmt", Table: 10, Interfaces: []string{"eth0"}}}; !reflect.DeepEqual(vrfs, want) {
+		t.Errorf("vrfs = %+v, want %+v", vrfs, want)
+	}
+
+	// The VRF's gateway goes in its table and doesn't collide with the
+	// main table's default route
+	gateways, err := Gateways(config)
+	if err != nil {
+		t.Fatalf("Gateways: %v", err)
+	}
+	want := []Gateway{
+		{Interface: "eth0", Address: "192.0.2.10", Subnet: "192.0.2.0/24", Gateway: "192.0.2.1", Table: 10, VRF: "mgmt"},
+		{Interface: "wan", Address: "198.51.100.10", Subnet: "198.51.100.0/24", Gateway: "198.51.100.1", Table: GatewayTableBase + 1},
+	}
+	if !reflect.DeepEqual(gateways, want) {
+		t.Errorf("gateways = %+v, want %+v", gateways, want)
+	}
+	if rules := gatewayRules(gateways); len(rules) != 1 || rules[0].Table != strconv.Itoa(GatewayTableBase+1) {
+		t.Errorf("gateway rules = %+v", rules)
+	}
+
+	config.GetSection("vrf", "mgmt").SetOption("table", "254")
+	if _, err := VRFs(config); err == nil {
+		t.Error("expected an error for the main table")
+	}
+	config.GetSection("vrf", "mgmt").SetOption("table", "10")
+	config.GetSection("interface", "eth0").SetOption("vrf", "transit")
+	if _, err := VRFs(config); err == nil {
+		t.Error("expected an error for an undefined vrf")
+	}
+}
+
+func TestVLANs(t *testing.T) {
+	config, err := uci.Parse(strings.NewReader(`
+config device
+	option type '8021q'
+	option ifname 'eth0'
+	option vid '10'
+
+config device 'outer'
+	option type '8021ad'
+	option ifname 'eth1'
+	option vid '100'
+
+config device
+	option type '8021q'
+	option ifname 'outer'
+	option vid '20'
+	option name 'cust20'
+
+config device
+	option type 'bridge'
+	option name 'br-lan'
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	vlans, err := VLANs(config)
+	if err != nil {
+		t.Fatalf("VLANs: %v", err)
+	}
+	want := []VLAN{
+		{Name: "eth0.10", Device: "eth0", VID: 10, Protocol: "802.1Q"},
+		{Name: "outer", Device: "eth1", VID: 100, Protocol: "802.1ad"},
+		{Name: "cust20", Device: "outer", VID: 20, Protocol: "802.1Q"},
+	}
+	if !reflect.DeepEqual(vlans, want) {
+		t.Errorf("vlans = %+v, want %+v", vlans, want)
+	}
+	if commands := vlanCommands(vlans[:1]); commands[0] != "ip link add link eth0 name eth0.10 type vlan proto 802.1Q id 10" {
+		t.Errorf("commands = %v", commands)
+	}
+
+	for _, tt := range []struct {
+		option, value, want string
+	}{
+		{"vid", "4095", "invalid vid"},
+		{"ifname", "", "ifname is required"},
+		{"ifname", "cust20", "configured after it"},
+		{"name", "outer", "already configured"},
+	} {
+		section := config.GetSectionsByType("device")[0]
+		previous := section.Options[tt.option]
+		section.SetOption(tt.option, tt.value)
+		if _, err := VLANs(config); err == nil || !strings.Contains(err.Error(), tt.want) {
+			t.Errorf("%s %q: err = %v, want %q", tt.option, tt.value, err, tt.want)
+		}
+		if previous == "" {
+			section.DeleteOption(tt.option)
+		} else {
+			section.SetOption(tt.option, previous)
+		}
+	}
+}
+
+func TestNetworkVLANs(t *testing.T) {
+	enterNetworkNamespace(t)
+	ctx := context.Background()
+
+	mustIP(t, "link", "add", "hf4", "type", "veth", "peer", "name", "hf5")
+	mustIP(t, "link", "set", "hf4", "up")
+	// A VLAN that exists with another ID is recreated
+	if out, err := exec.Command("ip", "link", "add", "link", "hf4", "name", "hf4.20", "type", "vlan", "id", "21").CombinedOutput(); err != nil {
+		t.Skipf("cannot create VLANs: %s", out)
+	}
+
+	config, err := uci.Parse(strings.NewReader(`
+config device
+	option type '8021q'
+	option ifname 'hf4'
+	option vid '10'
+
+config device
+	option type '8021q'
+	option ifname 'hf4'
+	option vid '20'
+
+config interface 'hf4.10'
+	option proto 'static'
+	option ipaddr '192.168.10.1'
+	option netmask '255.255.255.0'
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	applier := NewNetworkApplier()
+	if err := applier.Check(ctx, config); err != nil {
+		t.Fatalf("Check: %v", err)
+	}
+	if err := applier.Apply(ctx, config); err != nil {
+		t.Fatalf("Apply: %v", err)
+	}
+	if err := applier.Validate(ctx); err != nil {
+		t.Fatalf("Validate: %v", err)
+	}
+	vlans, err := captureVLANs(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if vlans["hf4.10"].VID != 10 || vlans["hf4.20"].VID != 20 {
+		t.Errorf("vlans = %+v", vlans)
+	}
+
+	if err := applier.Rollback(ctx); err != nil {
+		t.Fatalf("Rollback: %v", err)
+	}
+	vlans, err = captureVLANs(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := map[string]VLAN{"hf4.20": {Name: "hf4.20", Device: "hf4", VID: 21, Protocol: "802.1Q"}}; !reflect.DeepEqual(vlans, want) {
+		t.Errorf("vlans after rollback = %+v, want %+v", vlans, want)
+	}
+}
+
+func TestNetworkGatewaysLeaveOtherRoutes(t *testing.T) {
+	enterNetworkNamespace(t)
+	ctx := context.Background()
+
+	for _, pair := range [][2]string{{"hf4", "hf5"}, {"hf6", "hf7"}, {"hf8", "hf9"}} {
+		mustIP(t, "link", "add", pair[0], "type", "veth", "peer", "name", pair[1])
+		mustIP(t, "link", "set", pair[1], "up")
+	}
+	// A default route hellfire doesn't own, on an interface outside the config
+	mustIP(t, "link", "set", "hf8", "up")
+	mustIP(t, "addr", "add", "10.9.8.1/24", "dev", "hf8")
+	mustIP(t, "route", "add", "default", "via", "10.9.8.254", "dev", "hf8", "metric", "5")
+
+	config, err := uci.Parse(strings.NewReader(`
+config interface 'hf4'
+	option proto 'static'
+	option ipaddr '192.168.4.1'
+	option netmask '255.255.255.0'
+	option gateway '192.168.4.254'
+	option metric '10'
+
+config interface 'hf6'
+	option proto 'static'
+	option ipaddr '192.168.6.1'
+	option netmask '255.255.255.0'
+	option gateway '192.168.6.254'
+	option metric '20'
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	applier := NewNetworkApplier()
+	if err := applier.Apply(ctx, config); err != nil {
+		t.Fatalf("Apply: %v", err)
+	}
+	if err := applier.Validate(ctx); err != nil {
+		t.Fatalf("Validate: %v", err)
+	}
+	defaults := func() []string {
+		t.Helper()
+		routes, err := captureRoutes(ctx, "inet", "default")
+		if err != nil {
+			t.Fatal(err)
+		}
+		var got []string
+		for _, route := range routes {
+			got = append(got, fmt.Sprintf("%s/%d", route.Dev, route.Metric))
+		}
+		return got
+	}
+	if got := defaults(); !reflect.DeepEqual(got, []string{"hf8/5", "hf4/10", "hf6/20"}) {
+		t.Errorf("default routes = %v", got)
+	}
+	rules, err := captureGatewayRules(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rules) != 2 || rules[1] != (gatewayRule{Src: "192.168.6.0", SrcLen: 24, Table: "52002"}) {
+		t.Errorf("rules = %+v", rules)
+	}
+
+	// Dropping a gateway removes its routes and rule only
+	config.GetSection("interface", "hf6").DeleteOption("gateway")
+	second := NewNetworkApplier()
+	if err := second.Apply(ctx, config); err != nil {
+		t.Fatalf("Apply without hf6 gateway: %v", err)
+	}
+	if got := defaults(); !reflect.DeepEqual(got, []string{"hf8/5", "hf4/10"}) {
+		t.Errorf("default routes without hf6 gateway = %v", got)
+	}
+	if rules, _ := captureGatewayRules(ctx); len(rules) != 1 {
+		t.Errorf("rules without hf6 gateway = %+v", rules)
+	}
+
+	if err := second.Rollback(ctx); err != nil {
+		t.Fatalf("Rollback: %v", err)
+	}
+	if got := defaults(); !reflect.DeepEqual(got, []string{"hf8/5", "hf4/10", "hf6/20"}) {
+		t.Errorf("default routes after rollback = %v", got)
+	}
+	if err := applier.Validate(ctx); err != nil {
+		t.Errorf("Validate after rollback: %v", err)
+	}
+
+	// A WAN that lost its link gives up its default route until it is back
+	config.GetSection("interface", "hf6").SetOption("gateway", "192.168.6.254")
+	gateways, err := Gateways(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := FailoverGateways(ctx, gateways, nil, []string{"hf4"}); err != nil {
+		t.Fatalf("FailoverGateways: %v", err)
+	}
+	if got := defaults(); !reflect.DeepEqual(got, []string{"hf8/5", "hf6/20"}) {
+		t.Errorf("default routes with hf4 down = %v", got)
+	}
+	if err := FailoverGateways(ctx, gateways, nil, nil); err != nil {
+		t.Fatalf("FailoverGateways: %v", err)
+	}
+	if got := defaults(); !reflect.DeepEqual(got, []string{"hf8/5", "hf4/10", "hf6/20"}) {
+		t.Errorf("default routes with hf4 back = %v", got)
+	}
+	if err := applier.Validate(ctx); err != nil {
+		t.Errorf("Validate after failover: %v", err)
+	}
+}
+
+func TestStaticRoutes(t *testing.T) {
+	config, err := uci.Parse(strings.NewReader(`
+config vrf 'mgmt'
+	option table '10'
+
+config interface 'lan'
+	option proto 'static'
+	option ipaddr '192.168.1.1'
+	option netmask '255.255.255.0'
+
+config interface 'wan'
+	option proto 'dhcp'
+
+config interface 'eth0'
+	option proto 'static'
+	option ipaddr '192.0.2.10'
+	option netmask '255.255.255.0'
+	option vrf 'mgmt'
+
+config route 'office'
+	option target '10.20.0.0'
+	option netmask '255.255.0.0'
+	option gateway '192.168.1.254'
+	option metric '5'
+
+config route
+	option interface 'wan'
+	option target '203.0.113.7'
+	option gateway '100.64.0.1'
+
+config route 'monitoring'
+	option interface 'eth0'
+	option target '198.18.0.0/15'
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	routes, err := StaticRoutes(config)
+	if err != nil {
+		t.Fatalf("StaticRoutes: %v", err)
+	}
+	want := []StaticRoute{
+		{Name: "office", Target: "10.20.0.0/16", Gateway: "192.168.1.254", Interface: "lan", Metric: 5},
+		{Name: "@route[1]", Target: "203.0.113.7/32", Gateway: "100.64.0.1", Interface: "wan", OnLink: true},
+		{Name: "monitoring", Target: "198.18.0.0/15", Interface: "eth0", Table: 10},
+	}
+	if !reflect.DeepEqual(routes, want) {
+		t.Errorf("routes = %+v, want %+v", routes, want)
+	}
+	if got := staticRouteCommands(routes[:1]); len(got) != 1 ||
+		got[0] != "ip -4 route replace 10.20.0.0/16 via 192.168.1.254 dev lan proto 104 metric 5" {
+		t.Errorf("commands = %q", got)
+	}
+
+	for _, tt := range []struct {
+		option, value, want string
+	}{
+		{"target", "10.20.1.0", "host bits"},
+		{"gateway", "10.0.0.1", "subnet of no static interface"},
+		{"gateway", "fe80::1", "invalid gateway"},
+		{"metric", "-1", "invalid metric"},
+		{"target", "", "target is required"},
+	} {
+		office := config.GetSection("route", "office")
+		previous, _ := office.GetOption(tt.option)
+		office.SetOption(tt.option, tt.value)
+		if _, err := StaticRoutes(config); err == nil || !strings.Contains(err.Error(), tt.want) {
+			t.Errorf("%s %q: err = %v, want %q", tt.option, tt.value, err, tt.want)
+		}
+		office.SetOption(tt.option, previous)
+	}
+
+	// The same target and metric in the same table would replace each other
+	duplicate := uci.NewSection("route", "duplicate")
+	duplicate.SetOption("target", "10.20.0.0/16")
+	duplicate.SetOption("interface", "lan")
+	duplicate.SetOption("metric", "5")
+	config.AddSection(duplicate)
+	if _, err := StaticRoutes(config); err == nil || !strings.Contains(err.Error(), "already goes to") {
+		t.Errorf("duplicate route: err = %v", err)
+	}
+}
+
+func TestNetworkStaticRoutes(t *testing.T) {
+	enterNetworkNamespace(t)
+	ctx := context.Background()
+
+	for _, pair := range [][2]string{{"hf4", "hf5"}, {"hf6", "hf7"}} {
+		mustIP(t, "link", "add", pair[0], "type", "veth", "peer", "name", pair[1])
+		mustIP(t, "link", "set", pair[1], "up")
+	}
+
+	config, err := uci.Parse(strings.NewReader(`
+config interface 'hf4'
+	option proto 'static'
+	option ipaddr '192.168.4.1'
+	option netmask '255.255.255.0'
+	option gateway '192.168.4.254'
+
+config interface 'hf6'
+	option proto 'static'
+	option ipaddr '192.168.6.1'
+	option netmask '255.255.255.0'
+
+config route 'office'
+	option target '10.20.0.0'
+	option netmask '255.255.0.0'
+	option gateway '192.168.6.254'
+	option metric '5'
+
+config route 'host'
+	option interface 'hf6'
+	option target '203.0.113.7'
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	applier := NewNetworkApplier()
+	if err := applier.Apply(ctx, config); err != nil {
+		t.Fatalf("Apply: %v", err)
+	}
+	if err := applier.Validate(ctx); err != nil {
+		t.Fatalf("Validate: %v", err)
+	}
+	owned := func() []string {
+		t.Helper()
+		routes, err := captureOwnedRoutes(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var got []string
+		for _, route := range routes {
+			// Leaving out the gateways' routes
+			if route.Dst != "default" && route.Table != strconv.Itoa(GatewayTableBase+1) {
+				got = append(got, fmt.Sprintf("%s/%s/%d", route.Dst, route.Dev, route.Metric))
+			}
+		}
+		slices.Sort(got)
+		return got
+	}
+	if got := owned(); !reflect.DeepEqual(got, []string{"10.20.0.0/16/hf6/5", "203.0.113.7/hf6/0"}) {
+		t.Errorf("static routes = %v", got)
+	}
+
+	// Removing a route section removes its route
+	config.RemoveSection(config.GetSection("route", "host"))
+	second := NewNetworkApplier()
+	if err := second.Apply(ctx, config); err != nil {
+		t.Fatalf("Apply without host route: %v", err)
+	}
+	if got := owned(); !reflect.DeepEqual(got, []string{"10.20.0.0/16/hf6/5"}) {
+		t.Errorf("static routes without host route = %v", got)
+	}
+	if err := second.Rollback(ctx); err != nil {
+		t.Fatalf("Rollback: %v", err)
+	}
+	if err := applier.Validate(ctx); err != nil {
+		t.Errorf("Validate after rollback: %v", err)
+	}
+
+	// Failover keeps the static routes of the interfaces still up
+	gateways, err := Gateways(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	routes, err := StaticRoutes(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := FailoverGateways(ctx, gateways, routes, []string{"hf4"}); err != nil {
+		t.Fatalf("FailoverGateways: %v", err)
+	}
+	if got := owned(); !reflect.DeepEqual(got, []string{"10.20.0.0/16/hf6/5"}) {
+		t.Errorf("static routes with hf4 down = %v", got)
+	}
+	if err := FailoverGateways(ctx, gateways, routes, []string{"hf6"}); err != nil {
+		t.Fatalf("FailoverGateways: %v", err)
+	}
+	if got := owned(); len(got) != 0 {
+		t.Errorf("static routes with hf6 down = %v", got)
+	}
+}
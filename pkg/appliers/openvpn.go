@@ -0,0 +1,519 @@
+package appliers
+
+import (
+	"context"
+	"fmt"
+	"maps"
+	"net/netip"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/thesabbir/hellfire/pkg/logger"
+	"github.com/thesabbir/hellfire/pkg/uci"
+	"github.com/thesabbir/hellfire/pkg/util"
+)
+
+// OpenVPN instances are the openvpn sections of the openvpn config, named
+// after the instance. Each runs as a unit of the openvpn-server@ or
+// openvpn-client@ template, with its config rendered to the template's
+// directory as hellfire-<name>.conf. Files with that prefix belong to the
+// applier; configs of other instances are left alone.
+
+const (
+	OpenVPNConfigName = "openvpn"
+
+	// openvpnPrefix marks the files (and unit instances) of the applier
+	openvpnPrefix = "hellfire-"
+
+	// openvpnUpAttempts is how many times Validate checks each tunnel, a
+	// second apart, while a restarted instance connects
+	openvpnUpAttempts = 15
+)
+
+// OpenVPNDir holds the server/ and client/ directories of the units' configs
+var OpenVPNDir = "/etc/openvpn"
+
+// OpenVPN modes, also the directories and unit templates of their instances
+const (
+	OpenVPNServer = "server"
+	OpenVPNClient = "client"
+)
+
+// OpenVPNInstance is an enabled openvpn section
+type OpenVPNInstance struct {
+	Name      string       `json:"name"`
+	Mode      string       `json:"mode"`     // OpenVPNServer or OpenVPNClient
+	Dev       string       `json:"dev"`      // Tunnel device, named after the instance by default
+	DevType   string       `json:"dev_type"` // "tun" or "tap"
+	Proto     string       `json:"proto"`    // "udp" or "tcp"
+	Port      int          `json:"port"`
+	Network   netip.Prefix `json:"network"` // Addresses a server hands out
+	Remotes   []string     `json:"remotes"` // "host port" a client connects to, in order
+	CA        string       `json:"ca"`
+	Cert      string       `json:"cert"`
+	Key       string       `json:"key"`
+	DH        string       `json:"dh"` // Server only; ECDH without
+	TLSCrypt  string       `json:"tls_crypt"`
+	Ciphers   string       `json:"ciphers"` // Data channel ciphers, colon separated
+	Push      []string     `json:"push"`    // Options a server pushes to its clients
+	Keepalive string       `json:"keepalive"`
+	Username  string       `json:"username"` // Client login, written to a credentials file
+	Password  string       `json:"-"`
+}
+
+// unit returns the systemd unit the instance runs as
+func (i *OpenVPNInstance) unit() string {
+	return "openvpn-" + i.Mode + "@" + openvpnPrefix + i.Name
+}
+
+// configPath returns where the config of the instance is rendered
+func (i *OpenVPNInstance) configPath() string {
+	return filepath.Join(OpenVPNDir, i.Mode, openvpnPrefix+i.Name+".conf")
+}
+
+// authPath returns where the login of a client is written
+func (i *OpenVPNInstance) authPath() string {
+	return filepath.Join(OpenVPNDir, i.Mode, openvpnPrefix+i.Name+".auth")
+}
+
+// files lists the credentials and other files the config refers to
+func (i *OpenVPNInstance) files() []string {
+	var files []string
+	for _, path := range []string{i.CA, i.Cert, i.Key, i.DH, i.TLSCrypt} {
+		if path != "" {
+			files = append(files, path)
+		}
+	}
+	return files
+}
+
+var (
+	openvpnNamePattern   = regexp.MustCompile(`^[a-zA-Z0-9_]{1,32}$`)
+	openvpnCipherPattern = regexp.MustCompile(`^[A-Za-z0-9-]+(:[A-Za-z0-9-]+)*$`)
+)
+
+// OpenVPNInstances reads the enabled openvpn sections of an openvpn config,
+// in section order. Servers need a certificate and key and the network they
+// hand out; clients at least one remote, and a certificate and key or a
+// username and password.
+func OpenVPNInstances(config *uci.Config) ([]OpenVPNInstance, error) {
+	if config == nil {
+		return nil, nil
+	}
+
+	var instances []OpenVPNInstance
+	devices := make(map[string]string)
+	for _, section := range config.GetSectionsByType("openvpn") {
+		if enabled, ok := section.GetOption("enabled"); ok && enabled == "0" {
+			continue
+		}
+		instance, err := parseOpenVPN(section)
+		if err != nil {
+			return nil, fmt.Errorf("openvpn %s: %w", section.Name, err)
+		}
+		if other, ok := devices[instance.Dev]; ok {
+			return nil, fmt.Errorf("openvpn %s: %s already uses device %s", instance.Name, other, instance.Dev)
+		}
+		devices[instance.Dev] = instance.Name
+		instances = append(instances, *instance)
+	}
+	return instances, nil
+}
+
+// parseOpenVPN reads and checks an openvpn section
+func parseOpenVPN(section *uci.Section) (*OpenVPNInstance, error) {
+	if !openvpnNamePattern.MatchString(section.Name) {
+		return nil, fmt.Errorf("openvpn sections must be named with up to 32 letters, digits and underscores")
+	}
+
+	option := func(name, fallback string) string {
+		if value, ok := section.GetOption(name); ok && value != "" {
+			return value
+		}
+		return fallback
+	}
+	instance := &OpenVPNInstance{
+		Name:      section.Name,
+		Mode:      option("mode", OpenVPNServer),
+		Dev:       option("dev", section.Name),
+		DevType:   option("dev_type", "tun"),
+		Proto:     option("proto", "udp"),
+		CA:        option("ca", ""),
+		Cert:      option("cert", ""),
+		Key:       option("key", ""),
+		DH:        option("dh", ""),
+		TLSCrypt:  option("tls_crypt", ""),
+		Ciphers:   option("ciphers", ""),
+		Push:      section.GetList("push"),
+		Keepalive: option("keepalive", "10 60"),
+		Username:  option("username", ""),
+		Password:  option("password", ""),
+	}
+
+	if instance.Mode != OpenVPNServer && instance.Mode != OpenVPNClient {
+		return nil, fmt.Errorf("invalid mode %q: must be server or client", instance.Mode)
+	}
+	if err := util.ValidateInterfaceName(instance.Dev); err != nil {
+		return nil, fmt.Errorf("invalid dev: %w", err)
+	}
+	if instance.DevType != "tun" && instance.DevType != "tap" {
+		return nil, fmt.Errorf("invalid dev_type %q: must be tun or tap", instance.DevType)
+	}
+	if instance.Proto != "udp" && instance.Proto != "tcp" {
+		return nil, fmt.Errorf("invalid proto %q: must be udp or tcp", instance.Proto)
+	}
+
+	port := option("port", "1194")
+	n, err := strconv.Atoi(port)
+	if err != nil || n < 1 || n > 65535 {
+		return nil, fmt.Errorf("invalid port %q", port)
+	}
+	instance.Port = n
+
+	for _, path := range instance.files() {
+		if !filepath.IsAbs(path) || strings.ContainsAny(path, " \t\r\n\"'") {
+			return nil, fmt.Errorf("invalid path %q: must be an absolute path without spaces or quotes", path)
+		}
+	}
+	if instance.CA == "" {
+		return nil, fmt.Errorf("ca is required")
+	}
+	if (instance.Cert == "") != (instance.Key == "") {
+		return nil, fmt.Errorf("cert and key go together")
+	}
+	if instance.Ciphers != "" && !openvpnCipherPattern.MatchString(instance.Ciphers) {
+		return nil, fmt.Errorf("invalid ciphers %q", instance.Ciphers)
+	}
+	if fields := strings.Fields(instance.Keepalive); len(fields) != 2 || !allDigits(fields[0]) || !allDigits(fields[1]) {
+		return nil, fmt.Errorf("invalid keepalive %q: must be the ping interval and timeout in seconds", instance.Keepalive)
+	}
+
+	switch instance.Mode {
+	case OpenVPNServer:
+		if instance.Cert == "" {
+			return nil, fmt.Errorf("a server needs a cert and key")
+		}
+		network, err := netip.ParsePrefix(option("network", ""))
+		if err != nil || !network.Addr().Is4() || network.Bits() > 29 || network.Masked() != network {
+			return nil, fmt.Errorf("invalid network %q: a server needs the IPv4 network it hands out, e.g. 10.8.0.0/24", option("network", ""))
+		}
+		instance.Network = network
+		for _, push := range instance.Push {
+			if push == "" || strings.ContainsAny(push, "\"\\\r\n") {
+				return nil, fmt.Errorf("invalid push %q", push)
+			}
+		}
+
+	case OpenVPNClient:
+		for _, remote := range section.GetList("remote") {
+			host, port, _ := strings.Cut(strings.TrimSpace(remote), " ")
+			if port == "" {
+				port = strconv.Itoa(instance.Port)
+			}
+			if util.ValidateIPAddress(host) != nil && util.ValidateHostname(host) != nil {
+				return nil, fmt.Errorf("invalid remote %q: must be a host name or address", remote)
+			}
+			if p, err := strconv.Atoi(strings.TrimSpace(port)); err != nil || p < 1 || p > 65535 {
+				return nil, fmt.Errorf("invalid remote %q: invalid port", remote)
+			}
+			instance.Remotes = append(instance.Remotes, host+" "+strings.TrimSpace(port))
+		}
+		if len(instance.Remotes) == 0 {
+			return nil, fmt.Errorf("a client needs a remote to connect to")
+		}
+		if instance.Cert == "" && instance.Username == "" {
+			return nil, fmt.Errorf("a client needs a cert and key, or a username and password")
+		}
+		if strings.ContainsAny(instance.Username+instance.Password, "\r\n") {
+			return nil, fmt.Errorf("username and password must be a single line")
+		}
+	}
+	return instance, nil
+}
+
+// allDigits reports whether s is a non-empty decimal number
+func allDigits(s string) bool {
+	_, err := strconv.ParseUint(s, 10, 31)
+	return err == nil
+}
+
+// renderOpenVPN generates the config of an instance
+func renderOpenVPN(instance *OpenVPNInstance) string {
+	var b strings.Builder
+	b.WriteString("# Generated by Hellfire\n\n")
+	fmt.Fprintf(&b, "dev %s\ndev-type %s\n", instance.Dev, instance.DevType)
+
+	proto := instance.Proto
+	if proto == "tcp" {
+		proto = "tcp-" + instance.Mode
+	}
+	fmt.Fprintf(&b, "proto %s\n", proto)
+
+	switch instance.Mode {
+	case OpenVPNServer:
+		fmt.Fprintf(&b, "port %d\n", instance.Port)
+		fmt.Fprintf(&b, "topology subnet\nserver %s %s\n", instance.Network.Addr(), prefixNetmask(instance.Network))
+		dh := instance.DH
+		if dh == "" {
+			dh = "none"
+		}
+		fmt.Fprintf(&b, "dh %s\n", dh)
+		for _, push := range instance.Push {
+			fmt.Fprintf(&b, "push \"%s\"\n", push)
+		}
+	case OpenVPNClient:
+		b.WriteString("client\nnobind\n")
+		for _, remote := range instance.Remotes {
+			fmt.Fprintf(&b, "remote %s\n", remote)
+		}
+		if instance.Username != "" {
+			fmt.Fprintf(&b, "auth-user-pass %s\n", instance.authPath())
+		}
+	}
+
+	fmt.Fprintf(&b, "ca %s\n", instance.CA)
+	if instance.Cert != "" {
+		fmt.Fprintf(&b, "cert %s\nkey %s\n", instance.Cert, instance.Key)
+	}
+	if instance.TLSCrypt != "" {
+		fmt.Fprintf(&b, "tls-crypt %s\n", instance.TLSCrypt)
+	}
+	if instance.Ciphers != "" {
+		fmt.Fprintf(&b, "data-ciphers %s\n", instance.Ciphers)
+	}
+	fmt.Fprintf(&b, "keepalive %s\npersist-key\npersist-tun\nverb 3\n", instance.Keepalive)
+	return b.String()
+}
+
+// prefixNetmask returns the dotted netmask of an IPv4 prefix
+func prefixNetmask(prefix netip.Prefix) string {
+	mask := ^uint32(0) << (32 - prefix.Bits())
+	return netip.AddrFrom4([4]byte{byte(mask >> 24), byte(mask >> 16), byte(mask >> 8), byte(mask)}).String()
+}
+
+// openvpnFiles renders the files of the instances, by path
+func openvpnFiles(instances []OpenVPNInstance) map[string]string {
+	files := make(map[string]string)
+	for i := range instances {
+		instance := &instances[i]
+		files[instance.configPath()] = renderOpenVPN(instance)
+		if instance.Mode == OpenVPNClient && instance.Username != "" {
+			files[instance.authPath()] = instance.Username + "\n" + instance.Password + "\n"
+		}
+	}
+	return files
+}
+
+// readOpenVPNFiles reads the files of the applier's instances
+func readOpenVPNFiles() (map[string]string, error) {
+	files := make(map[string]string)
+	for _, mode := range []string{OpenVPNServer, OpenVPNClient} {
+		paths, err := filepath.Glob(filepath.Join(OpenVPNDir, mode, openvpnPrefix+"*"))
+		if err != nil {
+			return nil, err
+		}
+		for _, path := range paths {
+			data, err := os.ReadFile(path)
+			if err != nil {
+				return nil, err
+			}
+			files[path] = string(data)
+		}
+	}
+	return files, nil
+}
+
+// openvpnUnit returns the unit of the instance a config file belongs to;
+// empty for the other files
+func openvpnUnit(path string) string {
+	name, ok := strings.CutSuffix(filepath.Base(path), ".conf")
+	if !ok {
+		return ""
+	}
+	return "openvpn-" + filepath.Base(filepath.Dir(path)) + "@" + name
+}
+
+// OpenVPNApplier runs the OpenVPN servers and clients of the openvpn config
+type OpenVPNApplier struct {
+	previous  map[string]string // Files before the last Apply, by path
+	saved     bool              // Whether Apply has captured the previous files
+	instances []OpenVPNInstance // Instances the last Apply runs, for Validate
+}
+
+// NewOpenVPNApplier creates a new OpenVPN applier
+func NewOpenVPNApplier() *OpenVPNApplier {
+	return &OpenVPNApplier{}
+}
+
+// Name returns the applier name
+func (a *OpenVPNApplier) Name() string {
+	return OpenVPNConfigName
+}
+
+// Check checks that the certificates and keys the instances use exist
+func (a *OpenVPNApplier) Check(ctx context.Context, config *uci.Config) error {
+	instances, err := OpenVPNInstances(config)
+	if err != nil {
+		return err
+	}
+	for _, instance := range instances {
+		for _, path := range instance.files() {
+			if _, err := os.Stat(path); err != nil {
+				return fmt.Errorf("openvpn %s: %w", instance.Name, err)
+			}
+		}
+	}
+	return nil
+}
+
+// Render generates the config of each instance, and the credentials file of
+// clients logging in with a password
+func (a *OpenVPNApplier) Render(ctx context.Context, config *uci.Config) ([]Artifact, error) {
+	instances, err := OpenVPNInstances(config)
+	if err != nil {
+		return nil, err
+	}
+	files := openvpnFiles(instances)
+	artifacts := make([]Artifact, 0, len(files))
+	for _, path := range slices.Sorted(maps.Keys(files)) {
+		artifacts = append(artifacts, Artifact{Name: path, Content: files[path]})
+	}
+	return artifacts, nil
+}
+
+// Apply writes the configs of the instances, restarts those that changed and
+// stops those no longer configured
+func (a *OpenVPNApplier) Apply(ctx context.Context, config *uci.Config) error {
+	instances, err := OpenVPNInstances(config)
+	if err != nil {
+		return err
+	}
+
+	previous, err := readOpenVPNFiles()
+	if err != nil {
+		return fmt.Errorf("failed to read the openvpn configs: %w", err)
+	}
+	a.previous = previous
+	a.saved = true
+	a.instances = instances
+
+	return syncOpenVPNFiles(ctx, previous, openvpnFiles(instances))
+}
+
+// Validate checks that every instance is running with its tunnel up,
+// waiting for clients to connect
+func (a *OpenVPNApplier) Validate(ctx context.Context) error {
+	for _, instance := range a.instances {
+		if err := exec.CommandContext(ctx, "systemctl", "is-active", "--quiet", instance.unit()).Run(); err != nil {
+			return fmt.Errorf("openvpn %s is not running", instance.Name)
+		}
+		if err := openvpnTunnelUp(ctx, instance.Dev); err != nil {
+			return fmt.Errorf("openvpn %s: %w", instance.Name, err)
+		}
+	}
+	return nil
+}
+
+// openvpnTunnelUp waits for the tunnel device of an instance to be up
+func openvpnTunnelUp(ctx context.Context, dev string) error {
+	for attempt := 1; ; attempt++ {
+		output, err := commandOutputContext(ctx, "ip", "link", "show", "dev", dev, "up")
+		if err == nil && len(output) > 0 {
+			return nil
+		}
+		if attempt == openvpnUpAttempts {
+			return fmt.Errorf("tunnel %s did not come up", dev)
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(time.Second):
+		}
+	}
+}
+
+// Rollback puts back the configs from before Apply and restarts or stops
+// the instances it changed
+func (a *OpenVPNApplier) Rollback(ctx context.Context) error {
+	if !a.saved {
+		return ErrNoRollbackState
+	}
+
+	logger.Info("Rolling back OpenVPN configuration")
+	current, err := readOpenVPNFiles()
+	if err != nil {
+		return fmt.Errorf("failed to read the openvpn configs: %w", err)
+	}
+	return syncOpenVPNFiles(ctx, current, a.previous)
+}
+
+// syncOpenVPNFiles turns the files of the applier from current into wanted,
+// stopping the instances whose config is removed and restarting those whose
+// files changed
+func syncOpenVPNFiles(ctx context.Context, current, wanted map[string]string) error {
+	restart := make(map[string]bool)
+	for _, path := range slices.Sorted(maps.Keys(current)) {
+		if _, ok := wanted[path]; ok {
+			continue
+		}
+		if unit := openvpnUnit(path); unit != "" {
+			if err := runCommandContext(ctx, "systemctl", "disable", "--now", unit); err != nil {
+				return fmt.Errorf("failed to stop %s: %w", unit, err)
+			}
+		}
+		if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+
+	for _, path := range slices.Sorted(maps.Keys(wanted)) {
+		if content, ok := current[path]; ok && content == wanted[path] {
+			continue
+		}
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			return err
+		}
+		// Credentials and keys may be inlined: owner only
+		if err := util.WriteFileAtomic(path, []byte(wanted[path]), 0600); err != nil {
+			return fmt.Errorf("failed to write %s: %w", path, err)
+		}
+		unit := openvpnUnit(path)
+		if unit == "" {
+			unit = openvpnUnit(strings.TrimSuffix(path, ".auth") + ".conf")
+		}
+		restart[unit] = true
+	}
+
+	for _, unit := range slices.Sorted(maps.Keys(restart)) {
+		if err := runCommandContext(ctx, "systemctl", "enable", unit); err != nil {
+			return fmt.Errorf("failed to enable %s: %w", unit, err)
+		}
+		if err := runCommandContext(ctx, "systemctl", "restart", unit); err != nil {
+			return fmt.Errorf("failed to restart %s: %w", unit, err)
+		}
+		logger.Info("OpenVPN instance restarted", "unit", unit)
+	}
+	return nil
+}
+
+// CaptureState dumps the status of the applier's instances
+func (a *OpenVPNApplier) CaptureState(ctx context.Context) []Artifact {
+	files, err := readOpenVPNFiles()
+	if err != nil {
+		return []Artifact{{Name: "openvpn", Content: err.Error() + "\n"}}
+	}
+	commands := [][]string{}
+	for _, path := range slices.Sorted(maps.Keys(files)) {
+		if unit := openvpnUnit(path); unit != "" {
+			commands = append(commands, []string{"systemctl", "status", "--no-pager", unit})
+		}
+	}
+	return captureCommands(ctx, commands...)
+}
@@ -0,0 +1,95 @@
+package appliers
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/thesabbir/hellfire/pkg/uci"
+)
+
+func TestOpenVPNRender(t *testing.T) {
+	dir := OpenVPNDir
+	OpenVPNDir = t.TempDir()
+	t.Cleanup(func() { OpenVPNDir = dir })
+
+	config, err := uci.Parse(strings.NewReader(`
+config openvpn 'office'
+	option mode 'server'
+	option proto 'tcp'
+	option port '1195'
+	option network '10.8.0.0/24'
+	option ca '/etc/openvpn/pki/ca.crt'
+	option cert '/etc/openvpn/pki/server.crt'
+	option key '/etc/openvpn/pki/server.key'
+	list push 'route 192.168.1.0 255.255.255.0'
+
+config openvpn 'vendor'
+	option mode 'client'
+	option dev 'tun1'
+	list remote 'vpn.example.com 443'
+	list remote '203.0.113.7'
+	option ca '/etc/openvpn/pki/vendor-ca.crt'
+	option username 'router'
+	option password 'secret'
+
+config openvpn 'old'
+	option enabled '0'
+	option mode 'bogus'
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	artifacts, err := NewOpenVPNApplier().Render(context.Background(), config)
+	if err != nil {
+		t.Fatalf("Render: %v", err)
+	}
+	files := make(map[string]string)
+	for _, artifact := range artifacts {
+		files[strings.TrimPrefix(artifact.Name, OpenVPNDir)] = artifact.Content
+	}
+	if len(files) != 3 {
+		t.Fatalf("rendered %d files, want 3: %v", len(files), files)
+	}
+
+	server := files["/server/hellfire-office.conf"]
+	for _, line := range []string{
+		"dev office\n", "proto tcp-server\n", "port 1195\n", "server 10.8.0.0 255.255.255.0\n",
+		"dh none\n", "push \"route 192.168.1.0 255.255.255.0\"\n", "cert /etc/openvpn/pki/server.crt\n",
+	} {
+		if !strings.Contains(server, line) {
+			t.Errorf("server config lacks %q:\n%s", line, server)
+		}
+	}
+
+	client := files["/client/hellfire-vendor.conf"]
+	for _, line := range []string{
+		"dev tun1\n", "proto udp\n", "remote vpn.example.com 443\n", "remote 203.0.113.7 1194\n",
+		"auth-user-pass " + OpenVPNDir + "/client/hellfire-vendor.auth\n",
+	} {
+		if !strings.Contains(client, line) {
+			t.Errorf("client config lacks %q:\n%s", line, client)
+		}
+	}
+	if auth := files["/client/hellfire-vendor.auth"]; auth != "router\nsecret\n" {
+		t.Errorf("auth file = %q", auth)
+	}
+}
+
+func TestOpenVPNInvalid(t *testing.T) {
+	for name, section := range map[string]string{
+		"server without network": "option ca '/ca.crt'\n\toption cert '/s.crt'\n\toption key '/s.key'",
+		"client without remote":  "option mode 'client'\n\toption ca '/ca.crt'\n\toption cert '/c.crt'\n\toption key '/c.key'",
+		"relative path":          "option ca 'ca.crt'\n\toption cert '/s.crt'\n\toption key '/s.key'\n\toption network '10.8.0.0/24'",
+		"host bits in network":   "option ca '/ca.crt'\n\toption cert '/s.crt'\n\toption key '/s.key'\n\toption network '10.8.0.1/24'",
+	} {
+		config, err := uci.Parse(strings.NewReader("config openvpn 'vpn'\n\t" + section + "\n"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := OpenVPNInstances(config); err == nil {
+			t.Errorf("%s: accepted", name)
+		}
+	}
+}
@@ -0,0 +1,570 @@
+package appliers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/thesabbir/hellfire/pkg/bus"
+	"github.com/thesabbir/hellfire/pkg/logger"
+	"github.com/thesabbir/hellfire/pkg/uci"
+	"github.com/thesabbir/hellfire/pkg/util"
+)
+
+const (
+	// DefaultQuotaStateFile holds quota definitions and usage, shared by
+	// `hf commit` and the API server
+	DefaultQuotaStateFile = "/var/lib/hellfire/quotas.json"
+
+	// DefaultQuotaMonitorInterval is how often usage is read from the counters
+	DefaultQuotaMonitorInterval = time.Minute
+
+	// QuotaSetExceeded holds the connection marks of devices over their quota
+	QuotaSetExceeded = "quota_exceeded"
+
+	// quotaMarkBase is the first connection mark given to quota devices
+	quotaMarkBase = 0x48460000
+
+	defaultThrottleRate = 64 // kbytes/second
+)
+
+// Quotas enforces the per-device quotas of the firewall config
+var Quotas = NewQuotaEnforcer(DefaultQuotaStateFile)
+
+// Quota limits the traffic forwarded for one device (by MAC or IP address)
+// per day or month. Downloads and uploads both count.
+type Quota struct {
+	Name      string `json:"name"`
+	MAC       string `json:"mac,omitempty"`
+	IP        string `json:"ip,omitempty"`
+	Limit     uint64 `json:"limit"`               // Bytes per period
+	Period    string `json:"period"`              // daily or monthly
+	ResetDay  int    `json:"reset_day,omitempty"` // Day of month monthly periods start on
+	ResetHour int    `json:"reset_hour"`          // Hour of day periods start at
+	Action    string `json:"action"`              // block or throttle
+	Rate      int    `json:"rate,omitempty"`      // Throttled rate in kbytes/second
+	Mark      uint32 `json:"mark"`                // Connection mark of the device's traffic
+}
+
+// QuotaStatus reports a quota's usage in the current period
+type QuotaStatus struct {
+	Quota
+	Used        uint64    `json:"used"`
+	Remaining   uint64    `json:"remaining"`
+	PeriodStart time.Time `json:"period_start"`
+	NextReset   time.Time `json:"next_reset"`
+	Exceeded    bool      `json:"exceeded"`
+}
+
+// quotaUsage is the persisted state of one quota
+type quotaUsage struct {
+	Quota
+	Used        uint64    `json:"used"`
+	Counter     uint64    `json:"counter"` // Last counter value read, to compute deltas
+	PeriodStart time.Time `json:"period_start"`
+	Exceeded    bool      `json:"exceeded"`
+}
+
+// QuotaEnforcer accumulates the byte counters of quota devices into a state
+// file, blocks or throttles devices over their quota by adding their
+// connection mark to the exceeded set, and lifts that when the period resets
+type QuotaEnforcer struct {
+	mu      sync.Mutex // Serializes state file access in this process; a file lock covers others
+	path    string
+	monitor sync.Once
+}
+
+// NewQuotaEnforcer creates an enforcer keeping its state in path
+func NewQuotaEnforcer(path string) *QuotaEnforcer {
+	return &QuotaEnforcer{path: path}
+}
+
+// Definitions returns the quotas currently enforced
+func (e *QuotaEnforcer) Definitions() []Quota {
+	usages, err := e.readState()
+	if err != nil {
+		logger.Warn("Failed to read quota state", "error", err)
+	}
+
+	var quotas []Quota
+	for _, usage := range usages {
+		quotas = append(quotas, usage.Quota)
+	}
+	return quotas
+}
+
+// Configure replaces the enforced quotas after a ruleset was loaded, keeping
+// the usage of quotas whose device didn't change. The loaded counters become
+// the new baseline and devices over their quota are blocked again.
+func (e *QuotaEnforcer) Configure(ctx context.Context, quotas []Quota) error {
+	if len(quotas) == 0 {
+		if _, err := os.Stat(e.path); errors.Is(err, os.ErrNotExist) {
+			return nil
+		}
+	}
+
+	counters, err := readQuotaCounters(ctx)
+	if err != nil && len(quotas) > 0 {
+		return err
+	}
+
+	now := time.Now()
+	return e.updateState(func(usages *[]quotaUsage) error {
+		previous := make(map[string]quotaUsage, len(*usages))
+		for _, usage := range *usages {
+			previous[usage.Name] = usage
+		}
+
+		configured := make([]quotaUsage, 0, len(quotas))
+		for _, quota := range quotas {
+			usage := quotaUsage{Quota: quota, PeriodStart: quota.periodStart(now)}
+			if old, ok := previous[quota.Name]; ok && old.MAC == quota.MAC && old.IP == quota.IP &&
+				old.PeriodStart.Equal(usage.PeriodStart) {
+				usage.Used = old.Used
+			}
+			usage.Counter = counters[quota.counterName()]
+			usage.Exceeded = usage.Used >= quota.Limit
+			if usage.Exceeded {
+				if err := setQuotaExceeded(ctx, quota.Mark, true); err != nil {
+					return err
+				}
+			}
+			configured = append(configured, usage)
+		}
+		*usages = configured
+		return nil
+	})
+}
+
+// Sync adds the traffic counted since the last check to each quota, resets
+// quotas whose period ended and enforces quotas that were exceeded
+func (e *QuotaEnforcer) Sync(ctx context.Context) error {
+	counters, err := readQuotaCounters(ctx)
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+	var events []bus.Event
+	err = e.updateState(func(usages *[]quotaUsage) error {
+		for i := range *usages {
+			usage := &(*usages)[i]
+
+			if start := usage.periodStart(now); start.After(usage.PeriodStart) {
+				usage.PeriodStart = start
+				usage.Used = 0
+				if usage.Exceeded {
+					// The mark may be gone with a reloaded ruleset already
+					_ = setQuotaExceeded(ctx, usage.Mark, false)
+					usage.Exceeded = false
+					logger.Info("Quota period reset, lifting enforcement", "quota", usage.Name)
+					events = append(events, bus.Event{Type: bus.EventQuotaReset, ConfigName: "firewall", Data: usage.status()})
+				}
+			}
+
+			if raw, ok := counters[usage.counterName()]; ok {
+				if raw >= usage.Counter {
+					usage.Used += raw - usage.Counter
+				} else {
+					// The ruleset was reloaded and the counter restarted
+					usage.Used += raw
+				}
+				usage.Counter = raw
+			}
+
+			if !usage.Exceeded && usage.Used >= usage.Limit {
+				if err := setQuotaExceeded(ctx, usage.Mark, true); err != nil {
+					logger.Error("Failed to enforce quota", "quota", usage.Name, "error", err)
+					continue
+				}
+				usage.Exceeded = true
+				logger.Warn("Quota exceeded",
+					"quota", usage.Name,
+					"used", usage.Used,
+					"limit", usage.Limit,
+					"action", usage.Action)
+				events = append(events, bus.Event{Type: bus.EventQuotaExceeded, ConfigName: "firewall", Data: usage.status()})
+			}
+		}
+		return nil
+	})
+
+	for _, event := range events {
+		bus.Publish(event)
+	}
+	return err
+}
+
+// Status reports the usage of every quota, in config order
+func (e *QuotaEnforcer) Status() ([]QuotaStatus, error) {
+	usages, err := e.readState()
+	if err != nil {
+		return nil, err
+	}
+
+	statuses := make([]QuotaStatus, 0, len(usages))
+	for _, usage := range usages {
+		statuses = append(statuses, usage.status())
+	}
+	return statuses, nil
+}
+
+// StartMonitor starts a background goroutine syncing usage from the counters
+func (e *QuotaEnforcer) StartMonitor(interval time.Duration) {
+	e.monitor.Do(func() {
+		go func() {
+			ticker := time.NewTicker(interval)
+			defer ticker.Stop()
+
+			logger.Info("Started quota monitor", "interval", interval)
+
+			for range ticker.C {
+				if len(e.Definitions()) == 0 {
+					continue
+				}
+				if err := e.Sync(context.Background()); err != nil {
+					logger.Warn("Failed to update quota usage", "error", err)
+				}
+			}
+		}()
+	})
+}
+
+// readState returns the persisted usages, none if there is no state file yet
+func (e *QuotaEnforcer) readState() ([]quotaUsage, error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	if _, err := os.Stat(e.path); errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	unlock, err := e.lock(syscall.LOCK_SH)
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+	return e.load()
+}
+
+// updateState runs fn on the persisted usages while holding the state file
+// lock, and saves them afterwards
+func (e *QuotaEnforcer) updateState(fn func(*[]quotaUsage) error) error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	if err := os.MkdirAll(filepath.Dir(e.path), 0755); err != nil {
+		return fmt.Errorf("failed to create quota state directory: %w", err)
+	}
+	unlock, err := e.lock(syscall.LOCK_EX)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	usages, err := e.load()
+	if err != nil {
+		return err
+	}
+
+	fnErr := fn(&usages)
+
+	data, err := json.MarshalIndent(usages, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp := e.path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0600); err != nil {
+		return fmt.Errorf("failed to write quota state: %w", err)
+	}
+	if err := os.Rename(tmp, e.path); err != nil {
+		return fmt.Errorf("failed to write quota state: %w", err)
+	}
+	return fnErr
+}
+
+// lock takes the state file lock shared with other hellfire processes
+func (e *QuotaEnforcer) lock(how int) (func(), error) {
+	file, err := os.OpenFile(e.path+".lock", os.O_CREATE|os.O_RDWR, 0600)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open quota state lock: %w", err)
+	}
+	if err := syscall.Flock(int(file.Fd()), how); err != nil {
+		file.Close()
+		return nil, fmt.Errorf("failed to lock quota state: %w", err)
+	}
+	return func() { file.Close() }, nil
+}
+
+// load reads the state file; callers hold the lock
+func (e *QuotaEnforcer) load() ([]quotaUsage, error) {
+	data, err := os.ReadFile(e.path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read quota state: %w", err)
+	}
+
+	var usages []quotaUsage
+	if err := json.Unmarshal(data, &usages); err != nil {
+		return nil, fmt.Errorf("failed to parse quota state: %w", err)
+	}
+	return usages, nil
+}
+
+func (u quotaUsage) status() QuotaStatus {
+	status := QuotaStatus{
+		Quota:       u.Quota,
+		Used:        u.Used,
+		PeriodStart: u.PeriodStart,
+		NextReset:   u.nextReset(u.PeriodStart),
+		Exceeded:    u.Exceeded,
+	}
+	if u.Used < u.Limit {
+		status.Remaining = u.Limit - u.Used
+	}
+	return status
+}
+
+// periodStart returns the start of the period containing now
+func (q Quota) periodStart(now time.Time) time.Time {
+	now = now.Local()
+	if q.Period == "daily" {
+		start := time.Date(now.Year(), now.Month(), now.Day(), q.ResetHour, 0, 0, 0, time.Local)
+		if now.Before(start) {
+			start = start.AddDate(0, 0, -1)
+		}
+		return start
+	}
+
+	start := time.Date(now.Year(), now.Month(), q.ResetDay, q.ResetHour, 0, 0, 0, time.Local)
+	if now.Before(start) {
+		start = start.AddDate(0, -1, 0)
+	}
+	return start
+}
+
+// nextReset returns the end of the period starting at start
+func (q Quota) nextReset(start time.Time) time.Time {
+	if q.Period == "daily" {
+		return start.AddDate(0, 0, 1)
+	}
+	return start.AddDate(0, 1, 0)
+}
+
+func (q Quota) counterName() string {
+	return "quota_" + q.Name
+}
+
+// parseQuotas reads the quota sections of the firewall config
+func parseQuotas(config *uci.Config) ([]Quota, error) {
+	var quotas []Quota
+	devices := make(map[string]string)
+
+	for i, section := range config.GetSectionsByType("quota") {
+		name, _ := section.GetOption("name")
+		if !validSetName(name) {
+			return nil, fmt.Errorf("invalid quota name %q (letters, digits and underscores only)", name)
+		}
+
+		quota := Quota{
+			Name:   name,
+			Period: "monthly",
+			Action: "block",
+			Mark:   quotaMarkBase + uint32(i) + 1,
+		}
+
+		mac, hasMAC := section.GetOption("mac")
+		ip, hasIP := section.GetOption("ip")
+		switch {
+		case hasMAC == hasIP:
+			return nil, fmt.Errorf("quota %s needs exactly one of mac or ip", name)
+		case hasMAC:
+			if err := util.ValidateMAC(mac); err != nil {
+				return nil, fmt.Errorf("quota %s: %w", name, err)
+			}
+			quota.MAC = strings.ToLower(mac)
+		default:
+			parsed := net.ParseIP(ip)
+			if parsed == nil {
+				return nil, fmt.Errorf("quota %s: invalid IP address %q", name, ip)
+			}
+			quota.IP = parsed.String()
+		}
+		device := quota.MAC + quota.IP
+		if other, ok := devices[device]; ok {
+			return nil, fmt.Errorf("quotas %s and %s are both for %s", other, name, device)
+		}
+		devices[device] = name
+
+		limit, _ := section.GetOption("limit")
+		bytes, err := parseByteSize(limit)
+		if err != nil || bytes == 0 {
+			return nil, fmt.Errorf("quota %s: invalid limit %q", name, limit)
+		}
+		quota.Limit = bytes
+
+		if period, ok := section.GetOption("period"); ok {
+			if period != "daily" && period != "monthly" {
+				return nil, fmt.Errorf("quota %s: period must be daily or monthly", name)
+			}
+			quota.Period = period
+		}
+		if quota.Period == "monthly" {
+			quota.ResetDay = 1
+			if day, ok := section.GetOption("reset_day"); ok {
+				quota.ResetDay, err = strconv.Atoi(day)
+				if err != nil || quota.ResetDay < 1 || quota.ResetDay > 28 {
+					return nil, fmt.Errorf("quota %s: reset_day must be between 1 and 28", name)
+				}
+			}
+		}
+		if hour, ok := section.GetOption("reset_hour"); ok {
+			quota.ResetHour, err = strconv.Atoi(hour)
+			if err != nil || quota.ResetHour < 0 || quota.ResetHour > 23 {
+				return nil, fmt.Errorf("quota %s: reset_hour must be between 0 and 23", name)
+			}
+		}
+
+		if action, ok := section.GetOption("action"); ok {
+			if action != "block" && action != "throttle" {
+				return nil, fmt.Errorf("quota %s: action must be block or throttle", name)
+			}
+			quota.Action = action
+		}
+		if quota.Action == "throttle" {
+			quota.Rate = defaultThrottleRate
+			if rate, ok := section.GetOption("throttle_rate"); ok {
+				quota.Rate, err = strconv.Atoi(rate)
+				if err != nil || quota.Rate < 1 {
+					return nil, fmt.Errorf("quota %s: invalid throttle_rate %q", name, rate)
+				}
+			}
+		}
+
+		quotas = append(quotas, quota)
+	}
+	return quotas, nil
+}
+
+// quotaRules renders the counters and exceeded set of the quotas, and the
+// forward rules marking each device's connections, counting them and
+// enforcing the quota while its mark is in the exceeded set
+func quotaRules(quotas []Quota) (objects, rules string) {
+	if len(quotas) == 0 {
+		return "", ""
+	}
+
+	var objectBuf, ruleBuf strings.Builder
+	objectBuf.WriteString(fmt.Sprintf("\tset %s { type mark; }\n", QuotaSetExceeded))
+	for _, quota := range quotas {
+		mark := fmt.Sprintf("0x%08x", quota.Mark)
+		objectBuf.WriteString(fmt.Sprintf("\tcounter %s { }\n", quota.counterName()))
+
+		ruleBuf.WriteString(fmt.Sprintf("\t\t# Quota: %s\n", quota.Name))
+		switch {
+		case quota.MAC != "":
+			ruleBuf.WriteString(fmt.Sprintf("\t\tether saddr %s ct mark set %s\n", quota.MAC, mark))
+		case net.ParseIP(quota.IP).To4() != nil:
+			ruleBuf.WriteString(fmt.Sprintf("\t\tip saddr %s ct mark set %s\n", quota.IP, mark))
+			ruleBuf.WriteString(fmt.Sprintf("\t\tip daddr %s ct mark set %s\n", quota.IP, mark))
+		default:
+			ruleBuf.WriteString(fmt.Sprintf("\t\tip6 saddr %s ct mark set %s\n", quota.IP, mark))
+			ruleBuf.WriteString(fmt.Sprintf("\t\tip6 daddr %s ct mark set %s\n", quota.IP, mark))
+		}
+		ruleBuf.WriteString(fmt.Sprintf("\t\tct mark %s counter name %s\n", mark, quota.counterName()))
+		if quota.Action == "throttle" {
+			ruleBuf.WriteString(fmt.Sprintf("\t\tct mark %s ct mark @%s limit rate over %d kbytes/second drop\n",
+				mark, QuotaSetExceeded, quota.Rate))
+		} else {
+			ruleBuf.WriteString(fmt.Sprintf("\t\tct mark %s ct mark @%s drop\n", mark, QuotaSetExceeded))
+		}
+		ruleBuf.WriteString("\n")
+	}
+	objectBuf.WriteString("\n")
+	return objectBuf.String(), ruleBuf.String()
+}
+
+// readQuotaCounters returns the byte counts of the quota counters in the router table
+func readQuotaCounters(ctx context.Context) (map[string]uint64, error) {
+	output, err := exec.CommandContext(ctx, "nft", "-j", "list", "counters", "table", "inet", "router").Output()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read quota counters: %w", err)
+	}
+	return parseNftCounters(output)
+}
+
+// parseNftCounters parses `nft -j list counters` output into bytes by counter name
+func parseNftCounters(data []byte) (map[string]uint64, error) {
+	var dump struct {
+		Nftables []struct {
+			Counter *struct {
+				Name  string `json:"name"`
+				Bytes uint64 `json:"bytes"`
+			} `json:"counter"`
+		} `json:"nftables"`
+	}
+	if err := json.Unmarshal(data, &dump); err != nil {
+		return nil, fmt.Errorf("failed to parse counter dump: %w", err)
+	}
+
+	counters := make(map[string]uint64)
+	for _, object := range dump.Nftables {
+		if object.Counter != nil {
+			counters[object.Counter.Name] = object.Counter.Bytes
+		}
+	}
+	return counters, nil
+}
+
+// setQuotaExceeded adds or removes a device's connection mark in the exceeded set
+func setQuotaExceeded(ctx context.Context, mark uint32, exceeded bool) error {
+	op := "delete"
+	if exceeded {
+		op = "add"
+	}
+	element := fmt.Sprintf("{ 0x%08x }", mark)
+	if err := runCommandContext(ctx, "nft", op, "element", "inet", "router", QuotaSetExceeded, element); err != nil {
+		return fmt.Errorf("failed to %s quota mark: %w", op, err)
+	}
+	return nil
+}
+
+// parseByteSize parses a size such as 500M or 20G (binary units) into bytes
+func parseByteSize(value string) (uint64, error) {
+	value = strings.TrimSpace(strings.ToUpper(value))
+	value = strings.TrimSuffix(strings.TrimSuffix(value, "B"), "I")
+
+	multiplier := uint64(1)
+	if value != "" {
+		switch value[len(value)-1] {
+		case 'K':
+			multiplier = 1 << 10
+		case 'M':
+			multiplier = 1 << 20
+		case 'G':
+			multiplier = 1 << 30
+		case 'T':
+			multiplier = 1 << 40
+		}
+		if multiplier > 1 {
+			value = value[:len(value)-1]
+		}
+	}
+
+	n, err := strconv.ParseUint(value, 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid size %q", value)
+	}
+	return n * multiplier, nil
+}
@@ -0,0 +1,78 @@
+package appliers
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/thesabbir/hellfire/pkg/uci"
+)
+
+func TestParseQuotas(t *testing.T) {
+	config, err := uci.Parse(strings.NewReader(`
+config quota
+	option name 'tv'
+	option mac '02:00:00:00:00:AA'
+	option limit '20G'
+
+config quota
+	option name 'guest'
+	option ip '10.0.0.50'
+	option limit '500M'
+	option period 'daily'
+	option reset_hour '4'
+	option action 'throttle'
+	option throttle_rate '128'
+`))
+	if err != nil {
+		t.Fatalf("parse config: %v", err)
+	}
+
+	quotas, err := parseQuotas(config)
+	if err != nil {
+		t.Fatalf("parseQuotas: %v", err)
+	}
+	if len(quotas) != 2 {
+		t.Fatalf("expected 2 quotas, got %+v", quotas)
+	}
+	tv, guest := quotas[0], quotas[1]
+	if tv.MAC != "02:00:00:00:00:aa" || tv.Limit != 20<<30 || tv.Period != "monthly" || tv.ResetDay != 1 || tv.Action != "block" {
+		t.Errorf("unexpected defaults: %+v", tv)
+	}
+	if guest.Limit != 500<<20 || guest.ResetHour != 4 || guest.Rate != 128 {
+		t.Errorf("unexpected quota: %+v", guest)
+	}
+
+	_, rules := quotaRules(quotas)
+	for _, want := range []string{
+		"ether saddr 02:00:00:00:00:aa ct mark set 0x48460001\n",
+		"ct mark 0x48460001 counter name quota_tv\n",
+		"ct mark 0x48460001 ct mark @quota_exceeded drop\n",
+		"ip daddr 10.0.0.50 ct mark set 0x48460002\n",
+		"ct mark 0x48460002 ct mark @quota_exceeded limit rate over 128 kbytes/second drop\n",
+	} {
+		if !strings.Contains(rules, want) {
+			t.Errorf("rules missing %q:\n%s", want, rules)
+		}
+	}
+
+	// Daily periods start at the reset hour, monthly ones on the reset day
+	now := time.Date(2026, 3, 10, 2, 30, 0, 0, time.Local)
+	if got, want := guest.periodStart(now), time.Date(2026, 3, 9, 4, 0, 0, 0, time.Local); !got.Equal(want) {
+		t.Errorf("daily periodStart = %v, want %v", got, want)
+	}
+	tv.ResetDay = 15
+	start := tv.periodStart(now)
+	if want := time.Date(2026, 2, 15, 0, 0, 0, 0, time.Local); !start.Equal(want) {
+		t.Errorf("monthly periodStart = %v, want %v", start, want)
+	}
+	if got, want := tv.nextReset(start), time.Date(2026, 3, 15, 0, 0, 0, 0, time.Local); !got.Equal(want) {
+		t.Errorf("nextReset = %v, want %v", got, want)
+	}
+
+	config.GetSectionsByType("quota")[1].SetOption("ip", "10.0.0.51")
+	config.GetSectionsByType("quota")[1].SetOption("mac", "02:00:00:00:00:bb")
+	if _, err := parseQuotas(config); err == nil {
+		t.Error("expected an error for a quota with both mac and ip")
+	}
+}
@@ -0,0 +1,104 @@
+package appliers
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/thesabbir/hellfire/pkg/logger"
+	"github.com/thesabbir/hellfire/pkg/util"
+)
+
+// RecoveryConfig is the minimal known-good configuration applied when a
+// rollback fails: a static address on the management interface and a
+// firewall that only lets management traffic in
+type RecoveryConfig struct {
+	Interface string
+	IPAddr    string
+	Netmask   string
+	Ports     []int // TCP ports accepted on Interface (e.g. SSH and the API)
+}
+
+// Validate checks the recovery settings before they are needed
+func (c RecoveryConfig) Validate() error {
+	if err := util.ValidateInterfaceName(c.Interface); err != nil {
+		return fmt.Errorf("invalid recovery interface: %w", err)
+	}
+	if err := util.ValidateIPAddress(c.IPAddr); err != nil {
+		return fmt.Errorf("invalid recovery address: %w", err)
+	}
+	if err := util.ValidateNetmask(c.Netmask); err != nil {
+		return fmt.Errorf("invalid recovery netmask: %w", err)
+	}
+	if len(c.Ports) == 0 {
+		return fmt.Errorf("recovery mode needs at least one management port")
+	}
+	for _, port := range c.Ports {
+		if port < 1 || port > 65535 {
+			return fmt.Errorf("invalid recovery management port: %d", port)
+		}
+	}
+	return nil
+}
+
+// ApplyRecovery applies the recovery configuration. The firewall is only
+// replaced once the address is up: its drop policies would otherwise lock
+// out whatever access is left.
+func ApplyRecovery(ctx context.Context, cfg RecoveryConfig) error {
+	if err := cfg.Validate(); err != nil {
+		return err
+	}
+
+	logger.Warn("Applying recovery network configuration",
+		"interface", cfg.Interface,
+		"address", cfg.IPAddr,
+		"ports", cfg.Ports)
+
+	addr := fmt.Sprintf("%s/%d", cfg.IPAddr, convertNetmaskToCIDR(cfg.Netmask))
+	for _, args := range [][]string{
+		{"addr", "flush", "dev", cfg.Interface},
+		{"addr", "add", addr, "dev", cfg.Interface},
+		{"link", "set", cfg.Interface, "up"},
+	} {
+		if err := runCommandContext(ctx, "ip", args...); err != nil {
+			return fmt.Errorf("ip %s: %w", strings.Join(args, " "), err)
+		}
+	}
+
+	if err := NewFirewallApplier().applyNftables(ctx, generateRecoveryRules(cfg)); err != nil {
+		return fmt.Errorf("failed to apply recovery firewall: %w", err)
+	}
+
+	return nil
+}
+
+// generateRecoveryRules replaces the whole ruleset with one that accepts
+// the management ports on the recovery interface and drops everything else
+// coming in or being forwarded
+func generateRecoveryRules(cfg RecoveryConfig) string {
+	ports := make([]string, 0, len(cfg.Ports))
+	for _, port := range cfg.Ports {
+		ports = append(ports, fmt.Sprintf("%d", port))
+	}
+
+	var buf bytes.Buffer
+	buf.WriteString("flush ruleset\n\n")
+	buf.WriteString("table inet recovery {\n")
+	buf.WriteString("\tchain input {\n")
+	buf.WriteString("\t\ttype filter hook input priority 0; policy drop;\n")
+	buf.WriteString("\t\tiif lo accept\n")
+	buf.WriteString("\t\tct state established,related accept\n")
+	buf.WriteString("\t\tmeta l4proto { icmp, ipv6-icmp } accept\n")
+	buf.WriteString(fmt.Sprintf("\t\tiifname \"%s\" tcp dport { %s } accept\n", cfg.Interface, strings.Join(ports, ", ")))
+	buf.WriteString("\t}\n\n")
+	buf.WriteString("\tchain forward {\n")
+	buf.WriteString("\t\ttype filter hook forward priority 0; policy drop;\n")
+	buf.WriteString("\t}\n\n")
+	buf.WriteString("\tchain output {\n")
+	buf.WriteString("\t\ttype filter hook output priority 0; policy accept;\n")
+	buf.WriteString("\t}\n")
+	buf.WriteString("}\n")
+
+	return buf.String()
+}
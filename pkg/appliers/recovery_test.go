@@ -0,0 +1,38 @@
+package appliers
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestApplyRecoveryAddressFailure(t *testing.T) {
+	// Fake ip and nft: ip fails, nft records that it ran
+	dir := t.TempDir()
+	marker := filepath.Join(dir, "nft-ran")
+	scripts := map[string]string{
+		"ip":  "#!/bin/sh\necho 'Cannot find device' >&2\nexit 1\n",
+		"nft": "#!/bin/sh\n: > " + marker + "\n",
+	}
+	for name, script := range scripts {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(script), 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	t.Setenv("PATH", dir)
+
+	err := ApplyRecovery(context.Background(), RecoveryConfig{
+		Interface: "br-lan",
+		IPAddr:    "192.168.1.1",
+		Netmask:   "255.255.255.0",
+		Ports:     []int{22},
+	})
+	if err == nil || !strings.Contains(err.Error(), "ip addr flush") {
+		t.Fatalf("ApplyRecovery = %v, want the ip failure", err)
+	}
+	if _, err := os.Stat(marker); err == nil {
+		t.Error("the recovery firewall was applied without the recovery address")
+	}
+}
@@ -2,12 +2,20 @@ package appliers
 
 import (
 	"context"
+	"errors"
+	"slices"
 	"sync"
 
 	"github.com/thesabbir/hellfire/pkg/uci"
 )
 
-// Applier is the interface for applying configuration changes
+// ErrNoRollbackState is returned by Rollback when the applier has not saved
+// any previous state (e.g. Apply was never called in this process)
+var ErrNoRollbackState = errors.New("no saved state to roll back to")
+
+// Applier is the interface for applying configuration changes.
+// Apply saves the state it replaces so that Rollback can restore it;
+// Rollback returns ErrNoRollbackState when there is nothing to restore.
 type Applier interface {
 	Name() string
 	Apply(ctx context.Context, config *uci.Config) error
@@ -15,6 +23,33 @@ type Applier interface {
 	Rollback(ctx context.Context) error
 }
 
+// Checker is implemented by appliers that can check a config against the
+// system before it is committed (e.g. that the interfaces it names exist),
+// so a commit that can't apply fails before anything is written
+type Checker interface {
+	Check(ctx context.Context, config *uci.Config) error
+}
+
+// Renderer is implemented by appliers that generate a ruleset, file or
+// commands from a config, so a dry run can show what Apply would load
+// without loading it. Render fails where Apply would on invalid configs.
+type Renderer interface {
+	Render(ctx context.Context, config *uci.Config) ([]Artifact, error)
+}
+
+// StateCapturer is implemented by appliers that can dump the state of the
+// parts of the system they configure, such as the addresses and routes or
+// the loaded ruleset, for debugging what an apply changed
+type StateCapturer interface {
+	CaptureState(ctx context.Context) []Artifact
+}
+
+// Artifact is something an applier generates from a config
+type Artifact struct {
+	Name    string `json:"name"` // What it is, e.g. the file it is written to
+	Content string `json:"content"`
+}
+
 // Registry manages registered appliers
 type Registry struct {
 	mu       sync.RWMutex
@@ -43,6 +78,50 @@ func (r *Registry) Get(name string) (Applier, bool) {
 	return applier, ok
 }
 
+// Check runs the Checker of the config's applier; configs without an
+// applier or whose applier has no Checker pass
+func (r *Registry) Check(ctx context.Context, name string, config *uci.Config) error {
+	applier, ok := r.Get(name)
+	if !ok {
+		return nil
+	}
+	checker, ok := applier.(Checker)
+	if !ok {
+		return nil
+	}
+	return checker.Check(ctx, config)
+}
+
+// Render runs the Renderer of the config's applier; configs without an
+// applier or whose applier has no Renderer have no artifacts
+func (r *Registry) Render(ctx context.Context, name string, config *uci.Config) ([]Artifact, error) {
+	applier, ok := r.Get(name)
+	if !ok {
+		return nil, nil
+	}
+	renderer, ok := applier.(Renderer)
+	if !ok {
+		return nil, nil
+	}
+	return renderer.Render(ctx, config)
+}
+
+// CaptureState dumps the system state of every applier with a
+// StateCapturer, in applier name order
+func (r *Registry) CaptureState(ctx context.Context) []Artifact {
+	names := r.List()
+	slices.Sort(names)
+
+	var dumps []Artifact
+	for _, name := range names {
+		applier, _ := r.Get(name)
+		if capturer, ok := applier.(StateCapturer); ok {
+			dumps = append(dumps, capturer.CaptureState(ctx)...)
+		}
+	}
+	return dumps
+}
+
 // List returns all registered applier names
 func (r *Registry) List() []string {
 	r.mu.RLock()
@@ -60,5 +139,7 @@ func DefaultRegistry() *Registry {
 	registry.Register(NewNetworkApplier())
 	registry.Register(NewFirewallApplier())
 	registry.Register(NewDHCPApplier())
+	registry.Register(NewOpenVPNApplier())
+	registry.Register(NewIPsecApplier())
 	return registry
 }
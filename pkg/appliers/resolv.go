@@ -0,0 +1,228 @@
+package appliers
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+
+	"github.com/thesabbir/hellfire/pkg/modem"
+	"github.com/thesabbir/hellfire/pkg/uci"
+)
+
+// DefaultResolvFile lists the upstream DNS servers of the interfaces, like
+// OpenWrt's resolv.conf.auto. dnsmasq forwards to them.
+const DefaultResolvFile = "/var/lib/hellfire/resolv.conf.auto"
+
+// Resolvers writes the DNS servers of the interfaces applied by the network
+// applier to the resolv file
+var Resolvers = NewResolverConfig(DefaultResolvFile)
+
+// InterfaceDNS is the DNS servers an interface brings
+type InterfaceDNS struct {
+	Interface string   `json:"interface"`
+	Servers   []string `json:"servers,omitempty"` // From list dns
+	PeerDNS   bool     `json:"peerdns"`           // The servers of the DHCP lease or modem bearer are used too
+}
+
+// InterfaceResolvers reads the DNS servers of the interfaces of a network
+// config: its dns entries (a list or space-separated) and, for DHCP and modem interfaces without peerdns
+// '0', the servers of the lease or bearer. Disabled interfaces bring none.
+func InterfaceResolvers(network *uci.Config) ([]InterfaceDNS, error) {
+	var interfaces []InterfaceDNS
+	for _, section := range network.GetSectionsByType("interface") {
+		proto, _ := section.GetOption("proto")
+		if section.Name == "" || proto == "none" {
+			continue
+		}
+
+		iface := InterfaceDNS{Interface: section.Name}
+		servers := section.GetList("dns")
+		if value, ok := section.GetOption("dns"); ok {
+			servers = append(strings.Fields(value), servers...)
+		}
+		for _, server := range servers {
+			if net.ParseIP(server) == nil {
+				return nil, fmt.Errorf("interface %s: invalid dns server %q", section.Name, server)
+			}
+			iface.Servers = append(iface.Servers, server)
+		}
+		peerdns, _ := section.GetOption("peerdns")
+		iface.PeerDNS = (proto == "dhcp" || modem.IsProto(proto)) && peerdns != "0"
+
+		if len(iface.Servers) > 0 || iface.PeerDNS {
+			interfaces = append(interfaces, iface)
+		}
+	}
+	return interfaces, nil
+}
+
+// ResolverConfig keeps the interfaces' DNS servers in a state file, shared
+// by `hf commit` and the API server, and renders them with the servers of
+// the current DHCP leases into the resolv file
+type ResolverConfig struct {
+	mu   sync.Mutex
+	path string
+}
+
+// NewResolverConfig creates a resolver config writing the resolv file at
+// path and its state next to it
+func NewResolverConfig(path string) *ResolverConfig {
+	return &ResolverConfig{path: path}
+}
+
+// Path returns the path of the resolv file
+func (r *ResolverConfig) Path() string {
+	return r.path
+}
+
+// Active reports whether the resolv file is written: an applied network
+// config had interfaces with DNS servers
+func (r *ResolverConfig) Active() bool {
+	interfaces, err := r.Interfaces()
+	return err == nil && len(interfaces) > 0
+}
+
+// Interfaces returns the interfaces whose DNS servers are used, nil if no
+// network config was applied
+func (r *ResolverConfig) Interfaces() ([]InterfaceDNS, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.load()
+}
+
+// Configure replaces the interfaces whose DNS servers are used and writes
+// the resolv file. Without interfaces and without earlier state nothing is
+// written, so the system's resolver stays as it was.
+func (r *ResolverConfig) Configure(interfaces []InterfaceDNS) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if len(interfaces) == 0 {
+		if _, err := os.Stat(r.statePath()); errors.Is(err, os.ErrNotExist) {
+			return nil
+		}
+		// Stored as [], not null, so the state reads back as applied
+		interfaces = []InterfaceDNS{}
+	}
+
+	data, err := json.MarshalIndent(interfaces, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := writeFileAtomic(r.statePath(), data); err != nil {
+		return fmt.Errorf("failed to write resolver state: %w", err)
+	}
+	return r.update(interfaces)
+}
+
+// Reset removes the resolv file and its state, as before the first Configure
+func (r *ResolverConfig) Reset() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	var errs []error
+	for _, path := range []string{r.statePath(), r.path} {
+		if err := os.Remove(path); err != nil && !errors.Is(err, os.ErrNotExist) {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// Update writes the resolv file again if the DNS servers of a lease changed
+func (r *ResolverConfig) Update() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if _, err := os.Stat(r.statePath()); errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	interfaces, err := r.load()
+	if err != nil {
+		return err
+	}
+	return r.update(interfaces)
+}
+
+// update renders the resolv file and writes it if it changed; callers hold mu
+func (r *ResolverConfig) update(interfaces []InterfaceDNS) error {
+	content := renderResolvConf(interfaces, func(iface string) []string {
+		if lease := DHCPClients.lease(iface); lease != nil {
+			return lease.DNS
+		}
+		if bearer := Modems.bearer(iface); bearer != nil {
+			return bearer.DNS()
+		}
+		return nil
+	})
+	if current, err := os.ReadFile(r.path); err == nil && string(current) == content {
+		return nil
+	}
+	if err := writeFileAtomic(r.path, []byte(content)); err != nil {
+		return fmt.Errorf("failed to write resolv file: %w", err)
+	}
+	return nil
+}
+
+// load reads the state file; callers hold mu
+func (r *ResolverConfig) load() ([]InterfaceDNS, error) {
+	data, err := os.ReadFile(r.statePath())
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read resolver state: %w", err)
+	}
+
+	var interfaces []InterfaceDNS
+	if err := json.Unmarshal(data, &interfaces); err != nil {
+		return nil, fmt.Errorf("failed to parse resolver state: %w", err)
+	}
+	return interfaces, nil
+}
+
+func (r *ResolverConfig) statePath() string {
+	return r.path + ".json"
+}
+
+// renderResolvConf lists the DNS servers of the interfaces in order, each
+// interface's configured servers before those of its lease, once each
+func renderResolvConf(interfaces []InterfaceDNS, leaseDNS func(iface string) []string) string {
+	var b strings.Builder
+	b.WriteString("# Generated by Hellfire from the interfaces' DNS servers\n")
+
+	var seen []string
+	for _, iface := range interfaces {
+		servers := slices.Clone(iface.Servers)
+		if iface.PeerDNS {
+			servers = append(servers, leaseDNS(iface.Interface)...)
+		}
+		for _, server := range servers {
+			if net.ParseIP(server) == nil || slices.Contains(seen, server) {
+				continue
+			}
+			seen = append(seen, server)
+			fmt.Fprintf(&b, "nameserver %s\n", server)
+		}
+	}
+	return b.String()
+}
+
+// writeFileAtomic replaces a file through a temporary file, creating its
+// directory
+func writeFileAtomic(path string, data []byte) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
@@ -0,0 +1,102 @@
+package appliers
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/thesabbir/hellfire/pkg/uci"
+)
+
+func TestResolvers(t *testing.T) {
+	network, err := uci.Parse(strings.NewReader(`
+config interface 'wan'
+	option proto 'dhcp'
+	list dns '1.1.1.1'
+
+config interface 'wan2'
+	option proto 'dhcp'
+	option peerdns '0'
+
+config interface 'lan'
+	option proto 'static'
+	option ipaddr '192.168.1.1'
+	option netmask '255.255.255.0'
+	option dns '192.168.1.53 1.1.1.1'
+
+config interface 'guest'
+	option proto 'none'
+	list dns '9.9.9.9'
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	interfaces, err := InterfaceResolvers(network)
+	if err != nil {
+		t.Fatalf("InterfaceResolvers: %v", err)
+	}
+	if len(interfaces) != 2 || !interfaces[0].PeerDNS || interfaces[1].Interface != "lan" || interfaces[1].PeerDNS {
+		t.Fatalf("interfaces = %+v", interfaces)
+	}
+
+	dir := t.TempDir()
+	previous := DHCPClients
+	DHCPClients = NewDHCPSupervisor(filepath.Join(dir, "dhclient"))
+	defer func() { DHCPClients = previous }()
+
+	resolvers := NewResolverConfig(filepath.Join(dir, "resolv.conf.auto"))
+	if err := resolvers.Configure(nil); err != nil {
+		t.Fatal(err)
+	}
+	if resolvers.Active() {
+		t.Fatal("expected no resolv file without DNS servers")
+	}
+
+	if err := resolvers.Configure(interfaces); err != nil {
+		t.Fatalf("Configure: %v", err)
+	}
+	read := func() string {
+		t.Helper()
+		data, err := os.ReadFile(resolvers.Path())
+		if err != nil {
+			t.Fatal(err)
+		}
+		return string(data)
+	}
+	if got := read(); !strings.HasSuffix(got, "\nnameserver 1.1.1.1\nnameserver 192.168.1.53\n") {
+		t.Errorf("resolv file:\n%s", got)
+	}
+
+	// The servers of a new lease come after the configured ones
+	if err := os.MkdirAll(filepath.Join(dir, "dhclient"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	lease := "lease {\n  interface \"wan\";\n  fixed-address 10.0.0.7;\n  option domain-name-servers 10.0.0.1,1.1.1.1;\n}\n"
+	if err := os.WriteFile(filepath.Join(dir, "dhclient", "wan.leases"), []byte(lease), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := resolvers.Update(); err != nil {
+		t.Fatalf("Update: %v", err)
+	}
+	if got := read(); !strings.HasSuffix(got, "\nnameserver 1.1.1.1\nnameserver 10.0.0.1\nnameserver 192.168.1.53\n") {
+		t.Errorf("resolv file with lease:\n%s", got)
+	}
+
+	// Once applied, an empty config still replaces the servers
+	if err := resolvers.Configure(nil); err != nil {
+		t.Fatal(err)
+	}
+	if got := read(); strings.Contains(got, "nameserver") || resolvers.Active() {
+		t.Errorf("resolv file without interfaces:\n%s", got)
+	}
+	if applied, _ := resolvers.Interfaces(); applied == nil {
+		t.Error("expected the empty config to read back as applied")
+	}
+
+	network.GetSection("interface", "lan").SetOption("dns", "dns.example")
+	if _, err := InterfaceResolvers(network); err == nil {
+		t.Error("expected an invalid dns server to fail")
+	}
+}
@@ -0,0 +1,272 @@
+package appliers
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/thesabbir/hellfire/pkg/modem"
+	"github.com/thesabbir/hellfire/pkg/uci"
+	"github.com/thesabbir/hellfire/pkg/util"
+)
+
+// Static routes are the route sections of the network config. They are
+// owned like the default routes of the gateways (proto RouteProto): Apply
+// installs them along with those and removes the ones no longer configured,
+// leaving routes added by hand or by routing daemons alone. Those on an
+// interface in a VRF go into the VRF's table.
+
+// StaticRoute is a route section
+type StaticRoute struct {
+	Name      string `json:"name"`
+	Target    string `json:"target"` // CIDR
+	Gateway   string `json:"gateway,omitempty"`
+	Interface string `json:"interface"`
+	Metric    int    `json:"metric"`
+	Table     int    `json:"table,omitempty"`  // The VRF's, 0 for the main table
+	OnLink    bool   `json:"onlink,omitempty"` // The gateway isn't in a known subnet of the interface
+}
+
+// StaticRoutes reads the route sections of a network config, in section
+// order. A route needs a target, and a gateway or an interface; without an
+// interface it goes out of the static interface whose subnet holds the
+// gateway. Two routes to the same target with the same metric in the same
+// table would replace each other, as would a default route with the metric
+// of a gateway's.
+func StaticRoutes(network *uci.Config) ([]StaticRoute, error) {
+	vrfs, err := VRFs(network)
+	if err != nil {
+		return nil, err
+	}
+	tables := vrfTables(vrfs)
+	gateways, err := Gateways(network)
+	if err != nil {
+		return nil, err
+	}
+
+	var routes []StaticRoute
+	for i, section := range network.GetSectionsByType("route") {
+		name := section.Name
+		if name == "" {
+			name = fmt.Sprintf("@route[%d]", i)
+		}
+		route, err := parseStaticRoute(network, section, name, tables)
+		if err != nil {
+			return nil, fmt.Errorf("route %s: %w", name, err)
+		}
+
+		for _, other := range routes {
+			if other.Target == route.Target && other.Metric == route.Metric && other.Table == route.Table {
+				return nil, fmt.Errorf("route %s: route %s already goes to %s with metric %d; give one of them another metric",
+					name, other.Name, route.Target, route.Metric)
+			}
+		}
+		if route.Target == "0.0.0.0/0" {
+			for _, g := range gateways {
+				table := 0
+				if g.VRF != "" {
+					table = g.Table
+				}
+				if table == route.Table && g.Metric == route.Metric {
+					return nil, fmt.Errorf("route %s: interface %s already has a default route with metric %d; give one of them another metric",
+						name, g.Interface, route.Metric)
+				}
+			}
+		}
+		routes = append(routes, *route)
+	}
+	return routes, nil
+}
+
+// parseStaticRoute reads and checks a route section
+func parseStaticRoute(network *uci.Config, section *uci.Section, name string, tables map[string]int) (*StaticRoute, error) {
+	route := &StaticRoute{Name: name}
+
+	target, _ := section.GetOption("target")
+	netmask, hasNetmask := section.GetOption("netmask")
+	if target == "" {
+		return nil, fmt.Errorf("target is required")
+	}
+	var subnet *net.IPNet
+	if strings.Contains(target, "/") {
+		if hasNetmask {
+			return nil, fmt.Errorf("target %s is in CIDR notation; remove the netmask", target)
+		}
+		ip, parsed, err := net.ParseCIDR(target)
+		if err != nil || ip.To4() == nil {
+			return nil, fmt.Errorf("invalid target %q: must be an IPv4 address or network", target)
+		}
+		if !ip.Equal(parsed.IP) {
+			return nil, fmt.Errorf("invalid target %s: the host bits must be zero", target)
+		}
+		subnet = parsed
+	} else {
+		ip := net.ParseIP(target)
+		if ip == nil || ip.To4() == nil {
+			return nil, fmt.Errorf("invalid target %q: must be an IPv4 address or network", target)
+		}
+		bits := 32
+		if hasNetmask {
+			if err := util.ValidateNetmask(netmask); err != nil {
+				return nil, err
+			}
+			bits = convertNetmaskToCIDR(netmask)
+		}
+		subnet = &net.IPNet{IP: ip.To4().Mask(net.CIDRMask(bits, 32)), Mask: net.CIDRMask(bits, 32)}
+		if !ip.Equal(subnet.IP) {
+			return nil, fmt.Errorf("invalid target %s: the host bits must be zero for netmask %s", target, netmask)
+		}
+	}
+	route.Target = subnet.String()
+
+	if gateway, ok := section.GetOption("gateway"); ok && gateway != "" {
+		ip := net.ParseIP(gateway)
+		if ip == nil || ip.To4() == nil || ip.IsUnspecified() {
+			return nil, fmt.Errorf("invalid gateway %q: must be an IPv4 address", gateway)
+		}
+		route.Gateway = ip.String()
+	}
+
+	if metric, ok := section.GetOption("metric"); ok {
+		n, err := strconv.Atoi(metric)
+		if err != nil || n < 0 {
+			return nil, fmt.Errorf("invalid metric %q: must be a non-negative number", metric)
+		}
+		route.Metric = n
+	}
+
+	ifaceName, _ := section.GetOption("interface")
+	if ifaceName == "" {
+		if route.Gateway == "" {
+			return nil, fmt.Errorf("a gateway or an interface is required")
+		}
+		ifaceName = gatewayInterface(network, net.ParseIP(route.Gateway))
+		if ifaceName == "" {
+			return nil, fmt.Errorf("gateway %s is in the subnet of no static interface; set the interface", route.Gateway)
+		}
+	}
+	iface := network.GetSection("interface", ifaceName)
+	if iface == nil {
+		return nil, fmt.Errorf("interface %q is not configured", ifaceName)
+	}
+	route.Interface = ifaceName
+
+	switch proto, _ := iface.GetOption("proto"); proto {
+	case "none":
+		return nil, fmt.Errorf("interface %s is disabled", ifaceName)
+	case "static":
+		// The kernel only takes a gateway in a subnet of the interface
+		if route.Gateway != "" && !inStaticSubnet(iface, net.ParseIP(route.Gateway)) {
+			return nil, fmt.Errorf("gateway %s is not in the subnet of interface %s", route.Gateway, ifaceName)
+		}
+	case "dhcp", modem.ProtoModemManager, modem.ProtoQMI:
+		// The subnet comes with the lease, which may not be there yet
+		route.OnLink = route.Gateway != ""
+	}
+	route.Table = tables[interfaceVRF(iface)]
+	return route, nil
+}
+
+// gatewayInterface returns the static interface whose subnet holds ip
+func gatewayInterface(network *uci.Config, ip net.IP) string {
+	for _, section := range network.GetSectionsByType("interface") {
+		if proto, _ := section.GetOption("proto"); proto == "static" && inStaticSubnet(section, ip) {
+			return section.Name
+		}
+	}
+	return ""
+}
+
+// inStaticSubnet reports whether ip is in the subnet of a static interface
+func inStaticSubnet(section *uci.Section, ip net.IP) bool {
+	ipaddr, _ := section.GetOption("ipaddr")
+	netmask, _ := section.GetOption("netmask")
+	if util.ValidateIPAddress(ipaddr) != nil || util.ValidateNetmask(netmask) != nil {
+		return false
+	}
+	_, subnet, err := net.ParseCIDR(fmt.Sprintf("%s/%d", ipaddr, convertNetmaskToCIDR(netmask)))
+	return err == nil && subnet.Contains(ip)
+}
+
+// staticRouteStates lists the routes the network applier owns for static
+// routes, as `ip -j route show` reports them
+func staticRouteStates(routes []StaticRoute) []routeState {
+	states := make([]routeState, 0, len(routes))
+	for _, route := range routes {
+		state := routeState{
+			Family:   "inet",
+			Dst:      routeDst(route.Target),
+			Gateway:  route.Gateway,
+			Dev:      route.Interface,
+			Protocol: strconv.Itoa(RouteProto),
+			Metric:   route.Metric,
+		}
+		if route.Table != 0 {
+			state.Table = strconv.Itoa(route.Table)
+		}
+		if route.OnLink {
+			state.Flags = []string{"onlink"}
+		}
+		states = append(states, state)
+	}
+	return states
+}
+
+// routeDst returns a target as ip reports it: default for 0.0.0.0/0 and
+// host routes without their prefix length
+func routeDst(target string) string {
+	switch {
+	case target == "0.0.0.0/0":
+		return "default"
+	case strings.HasSuffix(target, "/32"):
+		return strings.TrimSuffix(target, "/32")
+	}
+	return target
+}
+
+// ownedRoutes lists the routes the network applier owns for gateways and
+// static routes, leaving out those on the interfaces in down
+func ownedRoutes(gateways []Gateway, routes []StaticRoute, down []string) []routeState {
+	var up []Gateway
+	for _, g := range gateways {
+		if !slices.Contains(down, g.Interface) {
+			up = append(up, g)
+		}
+	}
+	owned := gatewayRoutes(up)
+	for _, route := range staticRouteStates(routes) {
+		if !slices.Contains(down, route.Dev) {
+			owned = append(owned, route)
+		}
+	}
+	return owned
+}
+
+// staticRouteCommands lists the commands that install static routes, for Render
+func staticRouteCommands(routes []StaticRoute) []string {
+	var commands []string
+	for _, route := range staticRouteStates(routes) {
+		commands = append(commands, "ip "+strings.Join(route.replaceArgs(route.Dev), " "))
+	}
+	return commands
+}
+
+// validateStaticRoutes checks that the static routes are installed
+func validateStaticRoutes(ctx context.Context, routes []StaticRoute) error {
+	if len(routes) == 0 {
+		return nil
+	}
+	owned, err := captureOwnedRoutes(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to read routes: %w", err)
+	}
+	for i, state := range staticRouteStates(routes) {
+		if !slices.ContainsFunc(owned, func(r routeState) bool { return sameRoute(r, state) }) {
+			return fmt.Errorf("route %s: %s on %s is not installed", routes[i].Name, routes[i].Target, routes[i].Interface)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,484 @@
+package appliers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"maps"
+	"slices"
+	"strings"
+	"sync"
+
+	"github.com/thesabbir/hellfire/pkg/modem"
+	"github.com/thesabbir/hellfire/pkg/uci"
+	"github.com/thesabbir/hellfire/pkg/util"
+	"github.com/thesabbir/hellfire/pkg/wireguard"
+)
+
+// SimulatedSystem stands in for the kernel and services the appliers
+// configure: network devices, the nftables ruleset and dnsmasq. Its appliers
+// check configs and generate rulesets like the real ones, but only change
+// this in-memory state, so commits and rollbacks can run without root (in
+// integration tests and the demo server).
+type SimulatedSystem struct {
+	mu       sync.Mutex
+	links    map[string]*SimulatedLink
+	ruleset  string
+	dnsmasq  string
+	vpn      []VPNPolicy      // Policies routed by the network
+	applies  []string         // Appliers in the order they applied
+	failures map[string]error // Validate errors to return, by applier name
+}
+
+// SimulatedLink is the state of a simulated network device
+type SimulatedLink struct {
+	Name      string
+	Up        bool
+	Proto     string            // Protocol of the last applied config; empty if never configured
+	Address   string            // CIDR, static and wireguard only
+	Gateway   string            // Default route via this device, static only
+	VRF       string            // VRF the device is enslaved to, static only
+	Neighbors map[string]string // Static neighbors, IP address -> MAC address
+
+	// Link options of static and DHCP interfaces
+	MTU          int      // 0 if not set
+	MAC          string   // Empty if not set
+	Metric       int      // Of the default route
+	IP6Addresses []string // CIDR
+}
+
+// NewSimulatedSystem creates a simulated system with the given network
+// devices, all down and unconfigured
+func NewSimulatedSystem(devices ...string) *SimulatedSystem {
+	s := &SimulatedSystem{
+		links:    make(map[string]*SimulatedLink),
+		failures: make(map[string]error),
+	}
+	for _, name := range devices {
+		s.links[name] = &SimulatedLink{Name: name}
+	}
+	return s
+}
+
+// Appliers returns the network, firewall and DHCP appliers of the simulated system
+func (s *SimulatedSystem) Appliers() []Applier {
+	return []Applier{
+		&simulatedNetwork{sys: s},
+		&simulatedFirewall{FirewallApplier: NewFirewallApplier(), sys: s},
+		&simulatedDHCP{DHCPApplier: NewDHCPApplier(), sys: s},
+	}
+}
+
+// Link returns the state of a device
+func (s *SimulatedSystem) Link(name string) (SimulatedLink, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	link, ok := s.links[name]
+	if !ok {
+		return SimulatedLink{}, false
+	}
+	return link.clone(), true
+}
+
+// Links lists the simulated devices by name
+func (s *SimulatedSystem) Links() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return slices.Sorted(maps.Keys(s.links))
+}
+
+// Ruleset returns the loaded nftables ruleset
+func (s *SimulatedSystem) Ruleset() string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.ruleset
+}
+
+// DnsmasqConfig returns the dnsmasq config dnsmasq runs with
+func (s *SimulatedSystem) DnsmasqConfig() string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.dnsmasq
+}
+
+// VPN returns the VPN policies the network routes
+func (s *SimulatedSystem) VPN() []VPNPolicy {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return slices.Clone(s.vpn)
+}
+
+// Applies lists the appliers that have applied a config, in order, once per
+// Apply; rollbacks aren't listed
+func (s *SimulatedSystem) Applies() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return slices.Clone(s.applies)
+}
+
+// FailValidation makes Validate of the named applier return err, as if the
+// applied state didn't take; a nil err clears the failure
+func (s *SimulatedSystem) FailValidation(applier string, err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err == nil {
+		delete(s.failures, applier)
+		return
+	}
+	s.failures[applier] = err
+}
+
+func (s *SimulatedSystem) validate(applier string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.failures[applier]
+}
+
+func (l *SimulatedLink) clone() SimulatedLink {
+	c := *l
+	c.Neighbors = maps.Clone(l.Neighbors)
+	c.IP6Addresses = slices.Clone(l.IP6Addresses)
+	return c
+}
+
+// simulatedNetwork configures the simulated devices
+type simulatedNetwork struct {
+	sys         *SimulatedSystem
+	previous    map[string]SimulatedLink // All devices before the last Apply
+	previousVPN []VPNPolicy
+}
+
+func (a *simulatedNetwork) Name() string {
+	return "network"
+}
+
+// Apply configures the devices, failing where the real applier would: on
+// invalid or missing devices, invalid addresses, DNS servers, gateways and
+// VPN policies
+func (a *simulatedNetwork) Apply(ctx context.Context, config *uci.Config) error {
+	neighbors, err := parseNeighbors(config)
+	if err != nil {
+		return err
+	}
+	policies, err := VPNPolicies(config)
+	if err != nil {
+		return err
+	}
+	if _, err := InterfaceResolvers(config); err != nil {
+		return err
+	}
+	if _, err := Gateways(config); err != nil {
+		return err
+	}
+
+	a.sys.mu.Lock()
+	defer a.sys.mu.Unlock()
+
+	a.sys.applies = append(a.sys.applies, a.Name())
+	a.previous = make(map[string]SimulatedLink, len(a.sys.links))
+	for name, link := range a.sys.links {
+		a.previous[name] = link.clone()
+	}
+	a.previousVPN = a.sys.vpn
+
+	for _, section := range config.GetSectionsByType("interface") {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		ifaceName := section.Name
+		if ifaceName == "" {
+			continue
+		}
+		if err := util.ValidateInterfaceName(ifaceName); err != nil {
+			return fmt.Errorf("failed to apply interface %s: invalid interface name: %w", ifaceName, err)
+		}
+		if isModem(section) {
+			if _, err := modem.ParseSettings(section); err != nil {
+				return fmt.Errorf("failed to apply interface %s: %w", ifaceName, err)
+			}
+		}
+		link, ok := a.sys.links[ifaceName]
+		if !ok && isWireGuard(section) {
+			// Created by Apply
+			link = &SimulatedLink{Name: ifaceName}
+			a.sys.links[ifaceName] = link
+		} else if !ok && isModem(section) {
+			// Configured once the modem is plugged in
+			continue
+		} else if !ok {
+			return fmt.Errorf("failed to apply interface %s: no such network device", ifaceName)
+		}
+		if isWireGuard(section) {
+			if _, _, err := wireguard.Setconf(config, ifaceName); err != nil {
+				return fmt.Errorf("failed to apply interface %s: %w", ifaceName, err)
+			}
+		}
+
+		applied, err := interfaceLink(ifaceName, section)
+		if err != nil {
+			return fmt.Errorf("failed to apply interface %s: %w", ifaceName, err)
+		}
+		if applied.Proto != "none" {
+			applied.Neighbors = make(map[string]string)
+			for _, neighbor := range neighbors[ifaceName] {
+				applied.Neighbors[neighbor.Dst] = neighbor.LLAddr
+			}
+		}
+		*link = applied
+	}
+	a.sys.vpn = policies
+	return nil
+}
+
+// interfaceLink is the state a device takes from its interface section,
+// checking the section as Apply does
+func interfaceLink(ifaceName string, section *uci.Section) (SimulatedLink, error) {
+	link := SimulatedLink{Name: ifaceName, Up: true}
+	link.Proto, _ = section.GetOption("proto")
+
+	switch link.Proto {
+	case "static":
+		ipaddr, hasIP := section.GetOption("ipaddr")
+		netmask, hasMask := section.GetOption("netmask")
+		if !hasIP || !hasMask {
+			return link, fmt.Errorf("static interface requires ipaddr and netmask")
+		}
+		if err := util.ValidateIPAddress(ipaddr); err != nil {
+			return link, fmt.Errorf("invalid IP address: %w", err)
+		}
+		if err := util.ValidateNetmask(netmask); err != nil {
+			return link, fmt.Errorf("invalid netmask: %w", err)
+		}
+		link.Address = fmt.Sprintf("%s/%d", ipaddr, convertNetmaskToCIDR(netmask))
+		if gateway, ok := section.GetOption("gateway"); ok {
+			if err := util.ValidateIPAddress(gateway); err != nil {
+				return link, fmt.Errorf("invalid gateway: %w", err)
+			}
+			link.Gateway = gateway
+		}
+		link.VRF = interfaceVRF(section)
+		return link, link.setOptions(section)
+	case "dhcp":
+		return link, link.setOptions(section)
+	case modem.ProtoModemManager, modem.ProtoQMI:
+		return link, link.setOptions(section)
+	case "none":
+		link.Up = false
+	case wireguard.Proto:
+		addresses, err := wireguard.Addresses(section)
+		if err != nil {
+			return link, err
+		}
+		if len(addresses) > 0 {
+			link.Address = addresses[0]
+		}
+	default:
+		return link, fmt.Errorf("unsupported protocol: %s", link.Proto)
+	}
+	return link, nil
+}
+
+// setOptions sets the link options of a static, DHCP or modem interface section
+func (l *SimulatedLink) setOptions(section *uci.Section) error {
+	options, err := parseLinkOptions(section)
+	if err != nil {
+		return err
+	}
+	l.MTU = options.MTU
+	l.MAC = options.MAC
+	l.Metric = options.Metric
+	l.IP6Addresses = options.IP6Addresses
+	return nil
+}
+
+// Check checks that every configured interface is a simulated device
+func (a *simulatedNetwork) Check(ctx context.Context, config *uci.Config) error {
+	if _, err := parseNeighbors(config); err != nil {
+		return err
+	}
+	if _, err := VPNPolicies(config); err != nil {
+		return err
+	}
+	if _, err := InterfaceResolvers(config); err != nil {
+		return err
+	}
+	if _, err := Gateways(config); err != nil {
+		return err
+	}
+
+	a.sys.mu.Lock()
+	defer a.sys.mu.Unlock()
+
+	var errs []error
+	for _, iface := range config.GetSectionsByType("interface") {
+		if iface.Name == "" {
+			continue
+		}
+		if err := util.ValidateInterfaceName(iface.Name); err != nil {
+			errs = append(errs, fmt.Errorf("interface %s: %w", iface.Name, err))
+			continue
+		}
+		if isWireGuard(iface) {
+			if _, _, err := wireguard.Setconf(config, iface.Name); err != nil {
+				errs = append(errs, fmt.Errorf("interface %s: %w", iface.Name, err))
+			}
+			continue
+		}
+		if _, err := parseLinkOptions(iface); err != nil {
+			errs = append(errs, fmt.Errorf("interface %s: %w", iface.Name, err))
+		}
+		if isModem(iface) {
+			if _, err := modem.ParseSettings(iface); err != nil {
+				errs = append(errs, fmt.Errorf("interface %s: %w", iface.Name, err))
+			}
+			continue
+		}
+		if _, ok := a.sys.links[iface.Name]; !ok {
+			errs = append(errs, fmt.Errorf("interface %s: no such network device", iface.Name))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// isWireGuard reports whether an interface section is a WireGuard tunnel
+func isWireGuard(section *uci.Section) bool {
+	proto, _ := section.GetOption("proto")
+	return proto == wireguard.Proto
+}
+
+func (a *simulatedNetwork) Render(ctx context.Context, config *uci.Config) ([]Artifact, error) {
+	return renderNetwork(config)
+}
+
+// CaptureState dumps the simulated devices, one per line
+func (a *simulatedNetwork) CaptureState(ctx context.Context) []Artifact {
+	a.sys.mu.Lock()
+	defer a.sys.mu.Unlock()
+
+	var dump strings.Builder
+	for _, name := range slices.Sorted(maps.Keys(a.sys.links)) {
+		link := a.sys.links[name]
+		state := "down"
+		if link.Up {
+			state = "up"
+		}
+		fmt.Fprintf(&dump, "%s: %s", name, state)
+		for _, field := range [][2]string{
+			{"proto", link.Proto}, {"address", link.Address}, {"gateway", link.Gateway}, {"vrf", link.VRF},
+			{"mac", link.MAC}, {"ipv6", strings.Join(link.IP6Addresses, ",")},
+		} {
+			if field[1] != "" {
+				fmt.Fprintf(&dump, " %s %s", field[0], field[1])
+			}
+		}
+		if link.MTU > 0 {
+			fmt.Fprintf(&dump, " mtu %d", link.MTU)
+		}
+		dump.WriteString("\n")
+	}
+	return []Artifact{{Name: "simulated links", Content: dump.String()}}
+}
+
+func (a *simulatedNetwork) Validate(ctx context.Context) error {
+	return a.sys.validate(a.Name())
+}
+
+func (a *simulatedNetwork) Rollback(ctx context.Context) error {
+	if a.previous == nil {
+		return ErrNoRollbackState
+	}
+
+	a.sys.mu.Lock()
+	defer a.sys.mu.Unlock()
+	for name, link := range a.previous {
+		*a.sys.links[name] = link.clone()
+	}
+	a.sys.vpn = a.previousVPN
+	return nil
+}
+
+// simulatedFirewall loads the generated ruleset into the simulated system.
+// It embeds the real applier for its Render and management access.
+type simulatedFirewall struct {
+	*FirewallApplier
+	sys      *SimulatedSystem
+	previous *string // Ruleset before the last Apply
+}
+
+func (a *simulatedFirewall) Apply(ctx context.Context, config *uci.Config) error {
+	artifacts, err := a.Render(ctx, config)
+	if err != nil {
+		return err
+	}
+
+	a.sys.mu.Lock()
+	defer a.sys.mu.Unlock()
+	a.sys.applies = append(a.sys.applies, a.Name())
+	previous := a.sys.ruleset
+	a.previous = &previous
+	a.sys.ruleset = artifacts[0].Content
+	return nil
+}
+
+// CaptureState dumps the simulated ruleset
+func (a *simulatedFirewall) CaptureState(ctx context.Context) []Artifact {
+	return []Artifact{{Name: "nftables ruleset", Content: a.sys.Ruleset()}}
+}
+
+func (a *simulatedFirewall) Validate(ctx context.Context) error {
+	return a.sys.validate(a.Name())
+}
+
+func (a *simulatedFirewall) Rollback(ctx context.Context) error {
+	if a.previous == nil {
+		return ErrNoRollbackState
+	}
+
+	a.sys.mu.Lock()
+	defer a.sys.mu.Unlock()
+	a.sys.ruleset = *a.previous
+	return nil
+}
+
+// simulatedDHCP "restarts" dnsmasq with the generated config. It embeds the
+// real applier for its Render.
+type simulatedDHCP struct {
+	*DHCPApplier
+	sys      *SimulatedSystem
+	previous *string // dnsmasq config before the last Apply
+}
+
+func (a *simulatedDHCP) Apply(ctx context.Context, config *uci.Config) error {
+	artifacts, err := a.Render(ctx, config)
+	if err != nil {
+		return err
+	}
+
+	a.sys.mu.Lock()
+	defer a.sys.mu.Unlock()
+	a.sys.applies = append(a.sys.applies, a.Name())
+	previous := a.sys.dnsmasq
+	a.previous = &previous
+	a.sys.dnsmasq = artifacts[0].Content
+	return nil
+}
+
+// CaptureState dumps the config the simulated dnsmasq runs with
+func (a *simulatedDHCP) CaptureState(ctx context.Context) []Artifact {
+	return []Artifact{{Name: "dnsmasq config", Content: a.sys.DnsmasqConfig()}}
+}
+
+func (a *simulatedDHCP) Validate(ctx context.Context) error {
+	return a.sys.validate(a.Name())
+}
+
+func (a *simulatedDHCP) Rollback(ctx context.Context) error {
+	if a.previous == nil {
+		return ErrNoRollbackState
+	}
+
+	a.sys.mu.Lock()
+	defer a.sys.mu.Unlock()
+	a.sys.dnsmasq = *a.previous
+	return nil
+}
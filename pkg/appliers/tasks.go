@@ -0,0 +1,44 @@
+package appliers
+
+import (
+	"context"
+
+	"github.com/thesabbir/hellfire/pkg/tasks"
+	"github.com/thesabbir/hellfire/pkg/uci"
+)
+
+// TasksApplier checks the scheduled tasks config. The scheduler re-reads the
+// committed file itself, so there is nothing to apply or roll back.
+type TasksApplier struct{}
+
+// NewTasksApplier creates a new scheduled tasks applier
+func NewTasksApplier() *TasksApplier {
+	return &TasksApplier{}
+}
+
+// Name returns the applier name
+func (a *TasksApplier) Name() string {
+	return tasks.ConfigName
+}
+
+// Apply refuses task definitions the scheduler couldn't run
+func (a *TasksApplier) Apply(ctx context.Context, config *uci.Config) error {
+	_, err := tasks.Parse(config)
+	return err
+}
+
+// Render checks the task definitions; the scheduler reads the config itself
+func (a *TasksApplier) Render(ctx context.Context, config *uci.Config) ([]Artifact, error) {
+	_, err := tasks.Parse(config)
+	return nil, err
+}
+
+// Validate has nothing to check: tasks run in the API service
+func (a *TasksApplier) Validate(ctx context.Context) error {
+	return nil
+}
+
+// Rollback has nothing to restore: the scheduler picks up the restored file
+func (a *TasksApplier) Rollback(ctx context.Context) error {
+	return nil
+}
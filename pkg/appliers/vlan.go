@@ -0,0 +1,256 @@
+package appliers
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"maps"
+	"strconv"
+	"strings"
+
+	"github.com/thesabbir/hellfire/pkg/uci"
+	"github.com/thesabbir/hellfire/pkg/util"
+)
+
+// VLAN subinterfaces are device sections of type 8021q (or 8021ad for
+// QinQ), as on OpenWrt: the tagged VLAN vid of the device ifname, named
+// <ifname>.<vid> unless the name option says otherwise. Interface sections
+// configure them like any other device.
+//
+//	config device
+//		option type '8021q'
+//		option ifname 'eth0'
+//		option vid '10'
+//		option name 'eth0.10'
+
+// VLAN protocols by device section type
+var vlanProtocols = map[string]string{
+	"8021q":  "802.1Q",
+	"8021ad": "802.1ad",
+}
+
+// VLAN is a VLAN device section of the network config
+type VLAN struct {
+	Name     string `json:"name"`
+	Device   string `json:"device"` // The device the VLAN is tagged on
+	VID      int    `json:"vid"`
+	Protocol string `json:"protocol"` // 802.1Q or 802.1ad
+}
+
+// vlanDump is the part of `ip -j -d link show type vlan` describing a VLAN
+type vlanDump struct {
+	IfName   string `json:"ifname"`
+	Link     string `json:"link"`
+	LinkInfo struct {
+		Kind string `json:"info_kind"`
+		Data struct {
+			Protocol string `json:"protocol"`
+			ID       int    `json:"id"`
+		} `json:"info_data"`
+	} `json:"linkinfo"`
+}
+
+// VLANs reads the VLAN device sections of a network config, in section
+// order. Device sections of other types are left to the system. A VLAN can
+// be tagged on another VLAN (QinQ) configured before it.
+func VLANs(network *uci.Config) ([]VLAN, error) {
+	if network == nil {
+		return nil, nil
+	}
+
+	var vlans []VLAN
+	for i, section := range network.GetSectionsByType("device") {
+		kind, _ := section.GetOption("type")
+		protocol, ok := vlanProtocols[kind]
+		if !ok {
+			continue
+		}
+		id := section.Name
+		if id == "" {
+			id = fmt.Sprintf("@device[%d]", i)
+		}
+
+		device, _ := section.GetOption("ifname")
+		if device == "" {
+			return nil, fmt.Errorf("device %s: ifname is required", id)
+		}
+		if err := util.ValidateInterfaceName(device); err != nil {
+			return nil, fmt.Errorf("device %s: invalid ifname: %w", id, err)
+		}
+		vid, _ := section.GetOption("vid")
+		n, err := strconv.Atoi(vid)
+		if err != nil || n < 1 || n > 4094 {
+			return nil, fmt.Errorf("device %s: invalid vid %q: must be 1-4094", id, vid)
+		}
+		name, ok := section.GetOption("name")
+		if !ok || name == "" {
+			name = section.Name
+		}
+		if name == "" {
+			name = fmt.Sprintf("%s.%d", device, n)
+		}
+		if err := util.ValidateInterfaceName(name); err != nil {
+			return nil, fmt.Errorf("device %s: invalid name: %w", id, err)
+		}
+		if name == device {
+			return nil, fmt.Errorf("device %s: a VLAN can't be tagged on itself", id)
+		}
+		if network.GetSection("vrf", name) != nil {
+			return nil, fmt.Errorf("device %s: a vrf has the same name", id)
+		}
+
+		for _, other := range vlans {
+			if other.Name == name {
+				return nil, fmt.Errorf("device %s: VLAN %s is already configured", id, name)
+			}
+			if other.Device == device && other.VID == n && other.Protocol == protocol {
+				return nil, fmt.Errorf("device %s: %s already tags VLAN %d on %s", id, other.Name, n, device)
+			}
+		}
+		// A VLAN tagged on a VLAN needs that one first
+		for _, later := range network.GetSectionsByType("device")[i+1:] {
+			laterName, _ := later.GetOption("name")
+			if laterName == "" {
+				laterName = later.Name
+			}
+			if laterKind, _ := later.GetOption("type"); vlanProtocols[laterKind] != "" && laterName == device {
+				return nil, fmt.Errorf("device %s: VLAN %s is tagged on %s, configured after it", id, name, device)
+			}
+		}
+		vlans = append(vlans, VLAN{Name: name, Device: device, VID: n, Protocol: protocol})
+	}
+	return vlans, nil
+}
+
+// isVLAN reports whether name is one of the VLANs
+func isVLAN(vlans []VLAN, name string) bool {
+	for _, vlan := range vlans {
+		if vlan.Name == name {
+			return true
+		}
+	}
+	return false
+}
+
+// captureVLANs dumps the VLAN devices
+func captureVLANs(ctx context.Context) (map[string]VLAN, error) {
+	output, err := commandOutputContext(ctx, "ip", "-j", "-d", "link", "show", "type", "vlan")
+	if err != nil {
+		return nil, err
+	}
+	var links []vlanDump
+	if err := json.Unmarshal(output, &links); err != nil {
+		return nil, fmt.Errorf("failed to parse vlan dump: %w", err)
+	}
+
+	vlans := make(map[string]VLAN, len(links))
+	for _, link := range links {
+		if link.IfName != "" && link.LinkInfo.Kind == "vlan" {
+			vlans[link.IfName] = VLAN{
+				Name:     link.IfName,
+				Device:   link.Link,
+				VID:      link.LinkInfo.Data.ID,
+				Protocol: link.LinkInfo.Data.Protocol,
+			}
+		}
+	}
+	return vlans, nil
+}
+
+// addArgs returns the ip arguments creating a VLAN
+func (v VLAN) addArgs() []string {
+	return []string{"link", "add", "link", v.Device, "name", v.Name, "type", "vlan", "proto", v.Protocol, "id", strconv.Itoa(v.VID)}
+}
+
+// applyVLANs creates the VLAN devices that are missing, recreates those
+// tagged differently and brings them up, in order. VLANs no longer
+// configured are left, like VRFs, for whatever else uses them.
+func applyVLANs(ctx context.Context, vlans []VLAN, existing map[string]VLAN) error {
+	existing = maps.Clone(existing)
+	for _, vlan := range vlans {
+		current, ok := existing[vlan.Name]
+		if ok && current != vlan {
+			// The tag of a VLAN can't be changed
+			if err := runCommandContext(ctx, "ip", "link", "del", "dev", vlan.Name); err != nil {
+				return fmt.Errorf("failed to remove vlan %s: %w", vlan.Name, err)
+			}
+			forgetVLAN(existing, vlan.Name)
+		}
+		if !ok || current != vlan {
+			if err := runCommandContext(ctx, "ip", vlan.addArgs()...); err != nil {
+				return fmt.Errorf("failed to create vlan %s: %w", vlan.Name, err)
+			}
+		}
+		if err := runCommandContext(ctx, "ip", "link", "set", "dev", vlan.Name, "up"); err != nil {
+			return fmt.Errorf("failed to bring vlan %s up: %w", vlan.Name, err)
+		}
+	}
+	return nil
+}
+
+// restoreVLANs puts back the VLAN devices an apply recreated and removes
+// those it created, VLANs tagged on them first
+func restoreVLANs(ctx context.Context, previous map[string]VLAN, applied []VLAN) error {
+	for i := len(applied) - 1; i >= 0; i-- {
+		vlan := applied[i]
+		if before, ok := previous[vlan.Name]; ok && before == vlan {
+			continue
+		}
+		if err := runCommandContext(ctx, "ip", "link", "del", "dev", vlan.Name); err != nil {
+			return fmt.Errorf("failed to remove vlan %s: %w", vlan.Name, err)
+		}
+	}
+	for _, vlan := range applied {
+		before, ok := previous[vlan.Name]
+		if !ok || before == vlan {
+			continue
+		}
+		if err := runCommandContext(ctx, "ip", before.addArgs()...); err != nil {
+			return fmt.Errorf("failed to recreate vlan %s: %w", vlan.Name, err)
+		}
+	}
+	return nil
+}
+
+// forgetVLAN drops a removed VLAN from existing, with the VLANs the kernel
+// removed along with it
+func forgetVLAN(existing map[string]VLAN, name string) {
+	delete(existing, name)
+	for other, vlan := range existing {
+		if vlan.Device == name {
+			forgetVLAN(existing, other)
+		}
+	}
+}
+
+// vlanCommands lists the commands applyVLANs runs, for Render
+func vlanCommands(vlans []VLAN) []string {
+	var commands []string
+	for _, vlan := range vlans {
+		commands = append(commands,
+			"ip "+strings.Join(vlan.addArgs(), " "),
+			fmt.Sprintf("ip link set dev %s up", vlan.Name))
+	}
+	return commands
+}
+
+// validateVLANs checks that the VLAN devices exist with their tags
+func validateVLANs(ctx context.Context, vlans []VLAN) error {
+	if len(vlans) == 0 {
+		return nil
+	}
+	existing, err := captureVLANs(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to read vlans: %w", err)
+	}
+	var missing []string
+	for _, vlan := range vlans {
+		if existing[vlan.Name] != vlan {
+			missing = append(missing, fmt.Sprintf("%s (%s %d on %s)", vlan.Name, vlan.Protocol, vlan.VID, vlan.Device))
+		}
+	}
+	if len(missing) > 0 {
+		return fmt.Errorf("vlan %s is not set up", strings.Join(missing, ", "))
+	}
+	return nil
+}
@@ -0,0 +1,244 @@
+package appliers
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"net"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/thesabbir/hellfire/pkg/uci"
+	"github.com/thesabbir/hellfire/pkg/util"
+	"github.com/thesabbir/hellfire/pkg/wireguard"
+)
+
+// Policy routing of a route_all tunnel, as wg-quick sets it up: traffic not
+// marked by the tunnel itself looks up VPNTable, which holds the default
+// route via the tunnel, while the main table still routes everything but its
+// default route and the split tunnel prefixes
+const (
+	VPNTable = 51820 // Routing table and fwmark of the tunnel's own packets
+
+	vpnSplitPriority    = 10000 // to <split_tunnel> lookup main
+	vpnSuppressPriority = 10001 // lookup main suppress_prefixlength 0
+	vpnTablePriority    = 10002 // not fwmark VPNTable lookup VPNTable
+
+	// The kill switch's blackhole default route in VPNTable sits behind the
+	// tunnel's, and takes over when the tunnel goes down
+	vpnBlackholeMetric = 65535
+)
+
+// VPNPolicy is what the options of a VPN interface section ask of the
+// routing and firewall: route_all sends all traffic through the tunnel,
+// kill_switch drops forwarded traffic that would leave through a
+// masquerading (WAN) zone instead, and split_tunnel lists the prefixes
+// exempt from both
+type VPNPolicy struct {
+	Interface   string   `json:"interface"`
+	RouteAll    bool     `json:"route_all"`
+	KillSwitch  bool     `json:"kill_switch"`
+	SplitTunnel []string `json:"split_tunnel,omitempty"`
+}
+
+// VPNPolicies reads the policies of the VPN interfaces of a network config,
+// in section order. Interfaces without any of the options have none. Only
+// one interface can route all traffic.
+func VPNPolicies(network *uci.Config) ([]VPNPolicy, error) {
+	if network == nil {
+		return nil, nil
+	}
+
+	var policies []VPNPolicy
+	routeAll := ""
+	for _, section := range network.GetSectionsByType("interface") {
+		if section.Name == "" {
+			continue
+		}
+		policy := VPNPolicy{Interface: section.Name}
+		routeAllOpt, _ := section.GetOption("route_all")
+		killSwitch, _ := section.GetOption("kill_switch")
+		policy.RouteAll = routeAllOpt == "1"
+		policy.KillSwitch = killSwitch == "1"
+		policy.SplitTunnel = section.GetList("split_tunnel")
+		if !policy.RouteAll && !policy.KillSwitch && len(policy.SplitTunnel) == 0 {
+			continue
+		}
+
+		if proto, _ := section.GetOption("proto"); proto != wireguard.Proto {
+			return nil, fmt.Errorf("interface %s: route_all, kill_switch and split_tunnel need a VPN interface", section.Name)
+		}
+		if err := util.ValidateInterfaceName(section.Name); err != nil {
+			return nil, fmt.Errorf("interface %s: %w", section.Name, err)
+		}
+		if !policy.RouteAll && !policy.KillSwitch {
+			return nil, fmt.Errorf("interface %s: split_tunnel needs route_all or kill_switch", section.Name)
+		}
+		for _, prefix := range policy.SplitTunnel {
+			if _, _, err := net.ParseCIDR(prefix); err != nil {
+				return nil, fmt.Errorf("interface %s: invalid split_tunnel prefix %q", section.Name, prefix)
+			}
+		}
+		if policy.RouteAll {
+			if routeAll != "" {
+				return nil, fmt.Errorf("interface %s: %s already routes all traffic", section.Name, routeAll)
+			}
+			routeAll = section.Name
+		}
+
+		policies = append(policies, policy)
+	}
+	return policies, nil
+}
+
+// vpnRoutingCommands lists the commands routing all traffic through the
+// route_all tunnel of policies, after the previous policy rules are flushed
+// (see flushVPNRouting)
+func vpnRoutingCommands(policies []VPNPolicy) [][]string {
+	i := slices.IndexFunc(policies, func(p VPNPolicy) bool { return p.RouteAll })
+	if i < 0 {
+		return nil
+	}
+	policy := policies[i]
+	table := strconv.Itoa(VPNTable)
+
+	commands := [][]string{{"wg", "set", policy.Interface, "fwmark", table}}
+	for _, family := range []string{"-4", "-6"} {
+		commands = append(commands, []string{"ip", family, "route", "replace", "default", "dev", policy.Interface, "table", table})
+		if policy.KillSwitch {
+			commands = append(commands, []string{"ip", family, "route", "replace", "blackhole", "default",
+				"metric", strconv.Itoa(vpnBlackholeMetric), "table", table})
+		}
+	}
+	for _, prefix := range policy.SplitTunnel {
+		family := "-4"
+		if ip, _, _ := net.ParseCIDR(prefix); ip.To4() == nil {
+			family = "-6"
+		}
+		commands = append(commands, []string{"ip", family, "rule", "add", "to", prefix, "lookup", "main",
+			"priority", strconv.Itoa(vpnSplitPriority)})
+	}
+	for _, family := range []string{"-4", "-6"} {
+		commands = append(commands,
+			[]string{"ip", family, "rule", "add", "lookup", "main", "suppress_prefixlength", "0",
+				"priority", strconv.Itoa(vpnSuppressPriority)},
+			[]string{"ip", family, "rule", "add", "not", "fwmark", table, "lookup", table,
+				"priority", strconv.Itoa(vpnTablePriority)})
+	}
+	return commands
+}
+
+// flushVPNRouting removes the policy rules and routes of any route_all
+// tunnel. Missing rules and an empty table aren't errors.
+func flushVPNRouting(ctx context.Context) {
+	for _, family := range []string{"-4", "-6"} {
+		for _, priority := range []int{vpnSplitPriority, vpnSuppressPriority, vpnTablePriority} {
+			// Each del removes one rule; stop once there are none left
+			for range 256 {
+				if runCommandContext(ctx, "ip", family, "rule", "del", "priority", strconv.Itoa(priority)) != nil {
+					break
+				}
+			}
+		}
+		_ = runCommandContext(ctx, "ip", family, "route", "flush", "table", strconv.Itoa(VPNTable))
+	}
+}
+
+// vpnRouted reports whether traffic is routed through a route_all tunnel
+func vpnRouted(ctx context.Context) bool {
+	for _, family := range []string{"-4", "-6"} {
+		output, err := commandOutputContext(ctx, "ip", family, "rule", "show", "priority", strconv.Itoa(vpnTablePriority))
+		if err == nil && len(bytes.TrimSpace(output)) > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// applyVPNRouting replaces the policy routing with that of policies
+func applyVPNRouting(ctx context.Context, policies []VPNPolicy) error {
+	flushVPNRouting(ctx)
+	for _, command := range vpnRoutingCommands(policies) {
+		if err := runCommandContext(ctx, command[0], command[1:]...); err != nil {
+			return fmt.Errorf("failed to route all traffic through the vpn: %w", err)
+		}
+	}
+	return nil
+}
+
+// killSwitchRules renders the forward chain rules of the kill switches:
+// traffic leaving through the interfaces of masquerading zones is dropped,
+// except to split tunnel prefixes. The tunnel itself may be in such a zone.
+func killSwitchRules(firewall *uci.Config, policies []VPNPolicy) (string, error) {
+	var wan []string
+	for _, zone := range firewall.GetSectionsByType("zone") {
+		if masq, _ := zone.GetOption("masq"); masq != "1" {
+			continue
+		}
+		for _, network := range zone.GetList("network") {
+			if err := util.ValidateInterfaceName(network); err != nil {
+				return "", fmt.Errorf("invalid network interface %s: %w", network, err)
+			}
+			if !slices.Contains(wan, network) {
+				wan = append(wan, network)
+			}
+		}
+	}
+
+	var rules string
+	for _, policy := range policies {
+		if !policy.KillSwitch {
+			continue
+		}
+		var oif []string
+		for _, iface := range wan {
+			if iface != policy.Interface {
+				oif = append(oif, strconv.Quote(iface))
+			}
+		}
+		if len(oif) == 0 {
+			return "", fmt.Errorf("kill switch of %s: no masquerading zone to block", policy.Interface)
+		}
+
+		var split4, split6 []string
+		for _, prefix := range policy.SplitTunnel {
+			if ip, _, _ := net.ParseCIDR(prefix); ip.To4() != nil {
+				split4 = append(split4, prefix)
+			} else {
+				split6 = append(split6, prefix)
+			}
+		}
+
+		oifSet := "{ " + strings.Join(oif, ", ") + " }"
+		rules += fmt.Sprintf("\t\t# Kill switch: %s\n", policy.Interface)
+		if len(split4) > 0 {
+			rules += fmt.Sprintf("\t\toifname %s ip daddr != { %s } drop\n", oifSet, strings.Join(split4, ", "))
+		} else {
+			rules += fmt.Sprintf("\t\toifname %s meta nfproto ipv4 drop\n", oifSet)
+		}
+		if len(split6) > 0 {
+			rules += fmt.Sprintf("\t\toifname %s ip6 daddr != { %s } drop\n", oifSet, strings.Join(split6, ", "))
+		} else {
+			rules += fmt.Sprintf("\t\toifname %s meta nfproto ipv6 drop\n", oifSet)
+		}
+		rules += "\n"
+	}
+	return rules, nil
+}
+
+type networkConfigKey struct{}
+
+// WithNetworkConfig returns a context carrying the network config being
+// applied, for appliers of other configs that generate rules from it: the
+// firewall renders the kill switches of its VPN policies. Without it they
+// have none.
+func WithNetworkConfig(ctx context.Context, network *uci.Config) context.Context {
+	return context.WithValue(ctx, networkConfigKey{}, network)
+}
+
+// networkConfig returns the network config of ctx, or nil
+func networkConfig(ctx context.Context) *uci.Config {
+	network, _ := ctx.Value(networkConfigKey{}).(*uci.Config)
+	return network
+}
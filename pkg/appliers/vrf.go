@@ -0,0 +1,237 @@
+package appliers
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"maps"
+	"strconv"
+	"strings"
+
+	"github.com/thesabbir/hellfire/pkg/uci"
+	"github.com/thesabbir/hellfire/pkg/util"
+)
+
+// VRF devices separate routing domains on one box, such as a management
+// plane and the transit plane: each has a routing table of its own, and the
+// interfaces enslaved to it only route through that table. The kernel puts
+// the routes of their subnets there and the network applier their gateways'
+// default routes.
+
+// VRF is a vrf section of the network config, named after its device
+type VRF struct {
+	Name       string   `json:"name"`
+	Table      int      `json:"table"`
+	Interfaces []string `json:"interfaces"` // Enslaved with their vrf option, in section order
+}
+
+// vrfDump is the part of `ip -j -d link show` describing a VRF device or one
+// enslaved to it
+type vrfDump struct {
+	IfName   string `json:"ifname"`
+	LinkInfo struct {
+		Kind      string `json:"info_kind"`
+		SlaveKind string `json:"info_slave_kind"`
+		Data      struct {
+			Table int `json:"table"`
+		} `json:"info_data"`
+		SlaveData struct {
+			Table int `json:"table"`
+		} `json:"info_slave_data"`
+	} `json:"linkinfo"`
+}
+
+// VRFs reads the vrf sections of a network config, in section order, with
+// the interfaces their vrf option enslaves to them. Each needs a table of its
+// own; only static interfaces can be enslaved.
+func VRFs(network *uci.Config) ([]VRF, error) {
+	if network == nil {
+		return nil, nil
+	}
+
+	var vrfs []VRF
+	for _, section := range network.GetSectionsByType("vrf") {
+		if section.Name == "" {
+			return nil, fmt.Errorf("vrf sections must be named after their device")
+		}
+		if err := util.ValidateInterfaceName(section.Name); err != nil {
+			return nil, fmt.Errorf("vrf %s: %w", section.Name, err)
+		}
+		if network.GetSection("interface", section.Name) != nil {
+			return nil, fmt.Errorf("vrf %s: an interface section has the same name", section.Name)
+		}
+
+		table, _ := section.GetOption("table")
+		n, err := strconv.Atoi(table)
+		if err != nil || n < 1 || n > 0x7fffffff || n == 253 || n == 254 || n == 255 || n == VPNTable {
+			return nil, fmt.Errorf("vrf %s: invalid table %q: must be a table number other than 253-255 and %d", section.Name, table, VPNTable)
+		}
+		for _, other := range vrfs {
+			if other.Table == n {
+				return nil, fmt.Errorf("vrf %s: %s already uses routing table %d", section.Name, other.Name, n)
+			}
+		}
+		vrfs = append(vrfs, VRF{Name: section.Name, Table: n, Interfaces: []string{}})
+	}
+
+	for _, section := range network.GetSectionsByType("interface") {
+		name, ok := section.GetOption("vrf")
+		if section.Name == "" || !ok || name == "" {
+			continue
+		}
+		i := -1
+		for j := range vrfs {
+			if vrfs[j].Name == name {
+				i = j
+			}
+		}
+		if i < 0 {
+			return nil, fmt.Errorf("interface %s: vrf %q is not configured", section.Name, name)
+		}
+		if proto, _ := section.GetOption("proto"); proto != "static" {
+			return nil, fmt.Errorf("interface %s: only static interfaces can be in a vrf", section.Name)
+		}
+		vrfs[i].Interfaces = append(vrfs[i].Interfaces, section.Name)
+	}
+	return vrfs, nil
+}
+
+// interfaceVRF returns the VRF an interface section is enslaved to, if any
+func interfaceVRF(section *uci.Section) string {
+	vrf, _ := section.GetOption("vrf")
+	return vrf
+}
+
+// vrfTables maps the VRFs of a network config to their tables
+func vrfTables(vrfs []VRF) map[string]int {
+	tables := make(map[string]int, len(vrfs))
+	for _, vrf := range vrfs {
+		tables[vrf.Name] = vrf.Table
+	}
+	return tables
+}
+
+// VRFMembers maps the interfaces enslaved to a VRF to its name
+func VRFMembers(vrfs []VRF) map[string]string {
+	members := make(map[string]string)
+	for _, vrf := range vrfs {
+		for _, iface := range vrf.Interfaces {
+			members[iface] = vrf.Name
+		}
+	}
+	return members
+}
+
+// captureVRFs dumps the VRF devices with their tables
+func captureVRFs(ctx context.Context) (map[string]int, error) {
+	output, err := commandOutputContext(ctx, "ip", "-j", "-d", "link", "show", "type", "vrf")
+	if err != nil {
+		return nil, err
+	}
+	var links []vrfDump
+	if err := json.Unmarshal(output, &links); err != nil {
+		return nil, fmt.Errorf("failed to parse vrf dump: %w", err)
+	}
+
+	vrfs := make(map[string]int, len(links))
+	for _, link := range links {
+		if link.IfName != "" && link.LinkInfo.Kind == "vrf" {
+			vrfs[link.IfName] = link.LinkInfo.Data.Table
+		}
+	}
+	return vrfs, nil
+}
+
+// applyVRFs creates the VRF devices that are missing, recreates those with
+// another table and brings them up. VRFs no longer configured are left, like
+// WireGuard devices, for whatever else uses them.
+func applyVRFs(ctx context.Context, tables map[string]int, existing map[string]int) error {
+	for name, table := range tables {
+		current, ok := existing[name]
+		if ok && current != table {
+			// The table of a VRF can't be changed; its interfaces are
+			// enslaved again after it is recreated
+			if err := runCommandContext(ctx, "ip", "link", "del", "dev", name); err != nil {
+				return fmt.Errorf("failed to remove vrf %s: %w", name, err)
+			}
+		}
+		if !ok || current != table {
+			if err := runCommandContext(ctx, "ip", "link", "add", "dev", name, "type", "vrf", "table", strconv.Itoa(table)); err != nil {
+				return fmt.Errorf("failed to create vrf %s: %w", name, err)
+			}
+		}
+		if err := runCommandContext(ctx, "ip", "link", "set", "dev", name, "up"); err != nil {
+			return fmt.Errorf("failed to bring vrf %s up: %w", name, err)
+		}
+	}
+	return nil
+}
+
+// restoreVRFs puts back the VRF devices captured before an apply and removes
+// those it created
+func restoreVRFs(ctx context.Context, previous map[string]int, applied map[string]int) error {
+	current := maps.Clone(previous)
+	maps.Copy(current, applied)
+	if err := applyVRFs(ctx, previous, current); err != nil {
+		return err
+	}
+	for name := range applied {
+		if _, ok := previous[name]; ok {
+			continue
+		}
+		if err := runCommandContext(ctx, "ip", "link", "del", "dev", name); err != nil {
+			return fmt.Errorf("failed to remove vrf %s: %w", name, err)
+		}
+	}
+	return nil
+}
+
+// setInterfaceVRF enslaves an interface to a VRF, or releases it from the
+// one it is in. Enslaving cycles the link, so this goes before its addresses.
+func setInterfaceVRF(ctx context.Context, ifaceName, vrf, current string) error {
+	if vrf == current {
+		return nil
+	}
+	if vrf == "" {
+		if err := runCommandContext(ctx, "ip", "link", "set", "dev", ifaceName, "nomaster"); err != nil {
+			return fmt.Errorf("failed to release interface from vrf %s: %w", current, err)
+		}
+		return nil
+	}
+	if err := runCommandContext(ctx, "ip", "link", "set", "dev", ifaceName, "master", vrf); err != nil {
+		return fmt.Errorf("failed to enslave interface to vrf %s: %w", vrf, err)
+	}
+	return nil
+}
+
+// vrfCommands lists the commands applyVRFs runs, for Render
+func vrfCommands(vrfs []VRF) []string {
+	var commands []string
+	for _, vrf := range vrfs {
+		commands = append(commands,
+			fmt.Sprintf("ip link add dev %s type vrf table %d", vrf.Name, vrf.Table),
+			fmt.Sprintf("ip link set dev %s up", vrf.Name))
+	}
+	return commands
+}
+
+// validateVRFs checks that the VRF devices exist with their tables
+func validateVRFs(ctx context.Context, vrfs []VRF) error {
+	if len(vrfs) == 0 {
+		return nil
+	}
+	existing, err := captureVRFs(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to read vrfs: %w", err)
+	}
+	var missing []string
+	for _, vrf := range vrfs {
+		if table, ok := existing[vrf.Name]; !ok || table != vrf.Table {
+			missing = append(missing, fmt.Sprintf("%s (table %d)", vrf.Name, vrf.Table))
+		}
+	}
+	if len(missing) > 0 {
+		return fmt.Errorf("vrf %s is not set up", strings.Join(missing, ", "))
+	}
+	return nil
+}
@@ -15,17 +15,22 @@ type Action string
 
 const (
 	// User actions
-	ActionUserLogin  Action = "user.login"
-	ActionUserLogout Action = "user.logout"
-	ActionUserCreate Action = "user.create"
-	ActionUserUpdate Action = "user.update"
-	ActionUserDelete Action = "user.delete"
+	ActionUserLogin        Action = "user.login"
+	ActionUserLogout       Action = "user.logout"
+	ActionUserCreate       Action = "user.create"
+	ActionUserUpdate       Action = "user.update"
+	ActionUserDelete       Action = "user.delete"
+	ActionUserNewDevice    Action = "user.new_device"
+	ActionUserDeviceRevoke Action = "user.device_revoke"
+	ActionUserImpersonate  Action = "user.impersonate"
 
 	// Config actions
 	ActionConfigRead   Action = "config.read"
 	ActionConfigWrite  Action = "config.write"
 	ActionConfigCommit Action = "config.commit"
 	ActionConfigRevert Action = "config.revert"
+	ActionConfigLock   Action = "config.lock"
+	ActionConfigUnlock Action = "config.unlock"
 
 	// Transaction actions
 	ActionTxStart    Action = "transaction.start"
@@ -37,6 +42,8 @@ const (
 	ActionSnapshotCreate Action = "snapshot.create"
 	ActionSnapshotDelete Action = "snapshot.delete"
 	ActionSnapshotRestore Action = "snapshot.restore"
+	ActionSnapshotProtect Action = "snapshot.protect"
+	ActionSnapshotUnprotect Action = "snapshot.unprotect"
 
 	// API key actions
 	ActionAPIKeyCreate Action = "apikey.create"
@@ -44,7 +51,25 @@ const (
 	ActionAPIKeyUpdate Action = "apikey.update"
 
 	// System actions
-	ActionSystemRestart Action = "system.restart"
+	ActionSystemRestart  Action = "system.restart"
+	ActionSystemReload   Action = "system.reload"
+	ActionSystemRecovery Action = "system.recovery"
+	ActionSystemKnock    Action = "system.knock"
+	ActionSystemUpgrade  Action = "system.upgrade"
+
+	// Certificate actions
+	ActionCertUpload Action = "cert.upload"
+	ActionCertRotate Action = "cert.rotate"
+
+	// Scheduled task actions
+	ActionTaskRun Action = "task.run"
+
+	// WireGuard actions
+	ActionWireGuardExport Action = "wireguard.export"
+
+	// Plugin actions
+	ActionPluginRegister   Action = "plugin.register"
+	ActionPluginUnregister Action = "plugin.unregister"
 )
 
 // Status represents the status of an action
@@ -61,6 +86,12 @@ const (
 	ContextKeyUsername = "audit_username"
 	ContextKeyIP       = "audit_ip"
 	ContextKeyTxID     = "audit_tx_id"
+
+	ContextKeyRequestID = "audit_request_id"
+	ContextKeySessionID = "audit_session_id"
+
+	ContextKeyImpersonatorID   = "audit_impersonator_id"
+	ContextKeyImpersonatorName = "audit_impersonator"
 )
 
 // Log creates an audit log entry
@@ -97,14 +128,18 @@ func LogWithContext(ctx context.Context, action Action, status Status, userID *u
 		}
 	}
 
-	// Marshal details to JSON if provided
+	impersonatorID, impersonator := ImpersonatorFromContext(ctx)
+	requestID := RequestIDFromContext(ctx)
+	sessionID := SessionFromContext(ctx)
+
+	// Marshal details to JSON if provided, without secrets
 	var detailsJSON string
 	if details != nil {
-		data, err := json.Marshal(details)
+		data, err := redactDetails(details)
 		if err != nil {
 			logger.Warn("Failed to marshal audit details", "error", err)
 		} else {
-			detailsJSON = string(data)
+			detailsJSON = data
 		}
 	}
 
@@ -126,6 +161,10 @@ func LogWithContext(ctx context.Context, action Action, status Status, userID *u
 		IPAddress: ipAddress,
 		Error:     errorMsg,
 		TxID:      txID,
+		RequestID: requestID,
+
+		ImpersonatorID: impersonatorID,
+		Impersonator:   impersonator,
 	}
 
 	// Save to database
@@ -154,6 +193,18 @@ func LogWithContext(ctx context.Context, action Action, status Status, userID *u
 		logFields = append(logFields, "tx_id", txID)
 	}
 
+	if impersonator != "" {
+		logFields = append(logFields, "impersonator", impersonator)
+	}
+
+	if requestID != "" {
+		logFields = append(logFields, "request_id", requestID)
+	}
+
+	if sessionID != 0 {
+		logFields = append(logFields, "session_id", sessionID)
+	}
+
 	if status == StatusSuccess {
 		logger.Info("Audit", logFields...)
 	} else {
@@ -176,9 +227,26 @@ func LogFailure(action Action, userID *uint, username, resource, message string,
 	return LogWithContext(context.Background(), action, StatusFailure, userID, username, resource, message, nil, err)
 }
 
-// LogUserAction logs a user action with automatic user info extraction
-func LogUserAction(ctx context.Context, action Action, status Status, resource, message string, details interface{}) error {
-	// Try to extract user from context
+// LogUserAction logs an action of the user in ctx (see WithUser), with the
+// IP, request and session the context carries
+func LogUserAction(ctx context.Context, action Action, status Status, resource, message string, details interface{}, err error) error {
+	userID, username := UserFromContext(ctx)
+	if username == "" {
+		username = "unknown"
+	}
+
+	return LogWithContext(ctx, action, status, userID, username, resource, message, details, err)
+}
+
+// WithUser creates a context with user information for audit logging
+func WithUser(ctx context.Context, userID uint, username string) context.Context {
+	ctx = context.WithValue(ctx, ContextKeyUserID, userID)
+	ctx = context.WithValue(ctx, ContextKeyUsername, username)
+	return ctx
+}
+
+// UserFromContext extracts the user information stored by WithUser
+func UserFromContext(ctx context.Context) (*uint, string) {
 	var userID *uint
 	var username string
 
@@ -194,21 +262,59 @@ func LogUserAction(ctx context.Context, action Action, status Status, resource,
 		}
 	}
 
-	return LogWithContext(ctx, action, status, userID, username, resource, message, details, nil)
+	return userID, username
 }
 
-// WithUser creates a context with user information for audit logging
-func WithUser(ctx context.Context, userID uint, username string) context.Context {
-	ctx = context.WithValue(ctx, ContextKeyUserID, userID)
-	ctx = context.WithValue(ctx, ContextKeyUsername, username)
+// WithImpersonator records in a context that its user is being impersonated
+// by an admin, so audit entries name both
+func WithImpersonator(ctx context.Context, userID uint, username string) context.Context {
+	ctx = context.WithValue(ctx, ContextKeyImpersonatorID, userID)
+	ctx = context.WithValue(ctx, ContextKeyImpersonatorName, username)
 	return ctx
 }
 
+// ImpersonatorFromContext extracts the admin stored by WithImpersonator
+func ImpersonatorFromContext(ctx context.Context) (*uint, string) {
+	username, _ := ctx.Value(ContextKeyImpersonatorName).(string)
+	if id, ok := ctx.Value(ContextKeyImpersonatorID).(uint); ok {
+		return &id, username
+	}
+	return nil, username
+}
+
 // WithIP creates a context with IP address for audit logging
 func WithIP(ctx context.Context, ip string) context.Context {
 	return context.WithValue(ctx, ContextKeyIP, ip)
 }
 
+// IPFromContext extracts the client address stored by WithIP
+func IPFromContext(ctx context.Context) string {
+	ip, _ := ctx.Value(ContextKeyIP).(string)
+	return ip
+}
+
+// WithRequestID creates a context with the ID of the API request being handled
+func WithRequestID(ctx context.Context, requestID string) context.Context {
+	return context.WithValue(ctx, ContextKeyRequestID, requestID)
+}
+
+// RequestIDFromContext returns the request ID stored by WithRequestID, or ""
+func RequestIDFromContext(ctx context.Context) string {
+	requestID, _ := ctx.Value(ContextKeyRequestID).(string)
+	return requestID
+}
+
+// WithSession creates a context with the ID of the login session acting
+func WithSession(ctx context.Context, sessionID uint) context.Context {
+	return context.WithValue(ctx, ContextKeySessionID, sessionID)
+}
+
+// SessionFromContext returns the session ID stored by WithSession, or 0
+func SessionFromContext(ctx context.Context) uint {
+	sessionID, _ := ctx.Value(ContextKeySessionID).(uint)
+	return sessionID
+}
+
 // WithTransaction creates a context with transaction ID for audit logging
 func WithTransaction(ctx context.Context, txID string) context.Context {
 	return context.WithValue(ctx, ContextKeyTxID, txID)
@@ -0,0 +1,50 @@
+package audit
+
+import (
+	"encoding/json"
+
+	"github.com/thesabbir/hellfire/pkg/secrets"
+)
+
+// redactDetails returns details as JSON with sensitive values masked: values
+// under a sensitive key (e.g. "password"), and the "value" of an object whose
+// "path" names a sensitive option (e.g. a batch operation)
+func redactDetails(details interface{}) (string, error) {
+	data, err := json.Marshal(details)
+	if err != nil {
+		return "", err
+	}
+
+	var decoded interface{}
+	if err := json.Unmarshal(data, &decoded); err != nil {
+		return "", err
+	}
+	data, err = json.Marshal(redact(decoded))
+	if err != nil {
+		return "", err
+	}
+	return string(data), nil
+}
+
+func redact(v interface{}) interface{} {
+	switch v := v.(type) {
+	case map[string]interface{}:
+		for key, value := range v {
+			if _, isString := value.(string); isString && secrets.IsSensitive(key) {
+				v[key] = secrets.Mask
+				continue
+			}
+			v[key] = redact(value)
+		}
+		if path, ok := v["path"].(string); ok {
+			if value, ok := v["value"].(string); ok {
+				v["value"] = secrets.RedactValue(path, value)
+			}
+		}
+	case []interface{}:
+		for i, value := range v {
+			v[i] = redact(value)
+		}
+	}
+	return v
+}
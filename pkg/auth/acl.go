@@ -0,0 +1,87 @@
+package auth
+
+import (
+	"slices"
+	"strings"
+
+	"github.com/thesabbir/hellfire/pkg/db"
+)
+
+// ConfigRule restricts a config, or some of its sections, to roles. Rules
+// only take access away from what a role has; admins aren't restricted, so
+// they can't be locked out.
+type ConfigRule struct {
+	Config  string
+	Section string    // Section name, "@type" for every section of a type, or empty for the whole config
+	Read    []db.Role // Roles that may read; empty for every role
+	Write   []db.Role // Roles that may write; empty for every role with config.write
+}
+
+// matches reports whether the rule covers a section ("" for the config itself)
+func (r ConfigRule) matches(config, sectionName, sectionType string) bool {
+	if r.Config != config {
+		return false
+	}
+	switch {
+	case r.Section == "":
+		return true
+	case strings.HasPrefix(r.Section, "@"):
+		return sectionType != "" && r.Section[1:] == sectionType
+	default:
+		return sectionName != "" && r.Section == sectionName
+	}
+}
+
+// ConfigAccess is what a user may do with a config or section
+type ConfigAccess struct {
+	Read  bool
+	Write bool
+}
+
+// Permissions lists the access as "read" and "write", for UI form gating
+func (a ConfigAccess) Permissions() []string {
+	perms := []string{}
+	if a.Read {
+		perms = append(perms, "read")
+	}
+	if a.Write {
+		perms = append(perms, "write")
+	}
+	return perms
+}
+
+// ConfigPolicy holds the per-config access rules
+type ConfigPolicy struct {
+	rules []ConfigRule
+}
+
+// NewConfigPolicy creates a policy from rules
+func NewConfigPolicy(rules []ConfigRule) *ConfigPolicy {
+	return &ConfigPolicy{rules: rules}
+}
+
+// Access returns what a user may do with a section of a config, or with the
+// config as a whole when sectionName and sectionType are empty. Section
+// rules apply on top of the config's rules.
+func (p *ConfigPolicy) Access(user *db.User, config, sectionName, sectionType string) ConfigAccess {
+	access := ConfigAccess{
+		Read:  HasPermission(user, PermConfigRead),
+		Write: HasPermission(user, PermConfigWrite),
+	}
+	if p == nil || IsAdmin(user) || !access.Read {
+		return access
+	}
+
+	for _, rule := range p.rules {
+		if !rule.matches(config, sectionName, sectionType) {
+			continue
+		}
+		if len(rule.Read) > 0 && !slices.Contains(rule.Read, user.Role) {
+			return ConfigAccess{}
+		}
+		if len(rule.Write) > 0 && !slices.Contains(rule.Write, user.Role) {
+			access.Write = false
+		}
+	}
+	return access
+}
@@ -0,0 +1,80 @@
+package auth
+
+import (
+	"sync"
+	"time"
+)
+
+const (
+	// apiKeyCacheTTL is how long a successful bcrypt verification is reused
+	apiKeyCacheTTL = 5 * time.Minute
+	// apiKeyCacheSize bounds the number of cached keys
+	apiKeyCacheSize = 1024
+)
+
+// apiKeyCache remembers which stored bcrypt hash a presented key (by its
+// SHA-256) was verified against, so repeated requests skip bcrypt. The key
+// is still looked up in the database on every request, so disabling,
+// expiring or deleting it takes effect immediately; a cached entry is only
+// used while the stored hash is unchanged.
+type apiKeyCache struct {
+	mu      sync.Mutex
+	entries map[string]apiKeyCacheEntry
+}
+
+type apiKeyCacheEntry struct {
+	bcryptHash string
+	expires    time.Time
+}
+
+var verifiedAPIKeys = &apiKeyCache{entries: make(map[string]apiKeyCacheEntry)}
+
+// verified reports whether keyHash was verified against bcryptHash recently
+func (c *apiKeyCache) verified(keyHash, bcryptHash string) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	entry, ok := c.entries[keyHash]
+	if !ok {
+		return false
+	}
+	if time.Now().After(entry.expires) || entry.bcryptHash != bcryptHash {
+		delete(c.entries, keyHash)
+		return false
+	}
+	return true
+}
+
+// add records a successful verification
+func (c *apiKeyCache) add(keyHash, bcryptHash string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	now := time.Now()
+	if len(c.entries) >= apiKeyCacheSize {
+		for hash, entry := range c.entries {
+			if now.After(entry.expires) {
+				delete(c.entries, hash)
+			}
+		}
+		// Still full: evict an arbitrary entry
+		for hash := range c.entries {
+			if len(c.entries) < apiKeyCacheSize {
+				break
+			}
+			delete(c.entries, hash)
+		}
+	}
+
+	c.entries[keyHash] = apiKeyCacheEntry{
+		bcryptHash: bcryptHash,
+		expires:    now.Add(apiKeyCacheTTL),
+	}
+}
+
+// remove drops a cached verification, e.g. once the key is disabled or deleted
+func (c *apiKeyCache) remove(keyHash string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	delete(c.entries, keyHash)
+}
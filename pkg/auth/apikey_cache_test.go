@@ -0,0 +1,95 @@
+package auth
+
+import (
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestAPIKeyCache(t *testing.T) {
+	for _, tt := range []struct {
+		name       string
+		setup      func(c *apiKeyCache)
+		keyHash    string
+		bcryptHash string
+		want       bool
+	}{
+		{
+			name:       "unknown key",
+			setup:      func(c *apiKeyCache) {},
+			keyHash:    "key",
+			bcryptHash: "hash",
+		},
+		{
+			name:       "verified key",
+			setup:      func(c *apiKeyCache) { c.add("key", "hash") },
+			keyHash:    "key",
+			bcryptHash: "hash",
+			want:       true,
+		},
+		{
+			name:       "stored hash changed",
+			setup:      func(c *apiKeyCache) { c.add("key", "hash") },
+			keyHash:    "key",
+			bcryptHash: "rotated",
+		},
+		{
+			name: "expired",
+			setup: func(c *apiKeyCache) {
+				c.entries["key"] = apiKeyCacheEntry{bcryptHash: "hash", expires: time.Now().Add(-time.Second)}
+			},
+			keyHash:    "key",
+			bcryptHash: "hash",
+		},
+		{
+			name: "removed",
+			setup: func(c *apiKeyCache) {
+				c.add("key", "hash")
+				c.remove("key")
+			},
+			keyHash:    "key",
+			bcryptHash: "hash",
+		},
+		{
+			name: "other key removed",
+			setup: func(c *apiKeyCache) {
+				c.add("key", "hash")
+				c.remove("other")
+			},
+			keyHash:    "key",
+			bcryptHash: "hash",
+			want:       true,
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			c := &apiKeyCache{entries: make(map[string]apiKeyCacheEntry)}
+			tt.setup(c)
+			if got := c.verified(tt.keyHash, tt.bcryptHash); got != tt.want {
+				t.Errorf("verified = %v, want %v", got, tt.want)
+			}
+			if !tt.want {
+				if _, ok := c.entries[tt.keyHash]; ok {
+					t.Errorf("entry for %s kept after a miss", tt.keyHash)
+				}
+			}
+		})
+	}
+}
+
+func TestAPIKeyCacheSize(t *testing.T) {
+	c := &apiKeyCache{entries: make(map[string]apiKeyCacheEntry)}
+	c.entries["expired"] = apiKeyCacheEntry{bcryptHash: "hash", expires: time.Now().Add(-time.Second)}
+	for i := range apiKeyCacheSize + 10 {
+		c.add(strconv.Itoa(i), "hash")
+	}
+
+	if len(c.entries) > apiKeyCacheSize {
+		t.Errorf("cache holds %d entries, want at most %d", len(c.entries), apiKeyCacheSize)
+	}
+	if _, ok := c.entries["expired"]; ok {
+		t.Error("expired entry kept while the cache was full")
+	}
+	if !c.verified(strconv.Itoa(apiKeyCacheSize+9), "hash") {
+		t.Error("latest key not cached")
+	}
+}
@@ -0,0 +1,55 @@
+package auth
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"gorm.io/gorm"
+
+	"github.com/thesabbir/hellfire/pkg/db"
+)
+
+// RememberDevice records the device a new session was created from, by the
+// session's fingerprint. newDevice reports that the user had never logged in
+// from it; firstDevice that it's the user's first known device at all, as on
+// a first login, which is not worth an alert.
+func RememberDevice(session *db.Session) (newDevice, firstDevice bool, err error) {
+	now := time.Now()
+
+	device, err := db.GetKnownDevice(session.UserID, session.Fingerprint)
+	if err == nil {
+		device.IPAddress = session.IPAddress
+		device.UserAgent = session.UserAgent
+		device.LastSeenAt = now
+		if err := db.SaveKnownDevice(device); err != nil {
+			return false, false, fmt.Errorf("failed to update known device: %w", err)
+		}
+		return false, false, nil
+	}
+	if !errors.Is(err, gorm.ErrRecordNotFound) {
+		return false, false, fmt.Errorf("failed to look up known device: %w", err)
+	}
+
+	known, err := db.CountKnownDevices(session.UserID)
+	if err != nil {
+		return false, false, fmt.Errorf("failed to count known devices: %w", err)
+	}
+
+	if err := db.SaveKnownDevice(&db.KnownDevice{
+		UserID:      session.UserID,
+		Fingerprint: session.Fingerprint,
+		IPAddress:   session.IPAddress,
+		UserAgent:   session.UserAgent,
+		LastSeenAt:  now,
+	}); err != nil {
+		return false, false, fmt.Errorf("failed to record known device: %w", err)
+	}
+	return true, known == 0, nil
+}
+
+// RevokeDevice forgets one of a user's devices and logs out its sessions;
+// the next login from it counts as a new device again
+func RevokeDevice(userID, deviceID uint) error {
+	return db.DeleteKnownDevice(userID, deviceID)
+}
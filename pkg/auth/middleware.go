@@ -3,11 +3,15 @@ package auth
 import (
 	"crypto/sha256"
 	"encoding/hex"
+	"errors"
 	"net/http"
 	"strings"
 
 	"github.com/gin-gonic/gin"
+	"github.com/thesabbir/hellfire/pkg/audit"
 	"github.com/thesabbir/hellfire/pkg/db"
+	apierrors "github.com/thesabbir/hellfire/pkg/errors"
+	"github.com/thesabbir/hellfire/pkg/logger"
 )
 
 const (
@@ -25,10 +29,7 @@ func AuthMiddleware() gin.HandlerFunc {
 		token := extractToken(c)
 
 		if token == "" {
-			c.JSON(http.StatusUnauthorized, gin.H{
-				"error": "authentication required",
-			})
-			c.Abort()
+			apierrors.Abort(c, http.StatusUnauthorized, apierrors.CodeAuthenticationRequired)
 			return
 		}
 
@@ -39,17 +40,27 @@ func AuthMiddleware() gin.HandlerFunc {
 		// Validate session with fingerprint check
 		session, err := ValidateSessionWithFingerprint(token, ipAddress, userAgent)
 		if err != nil {
-			c.JSON(http.StatusUnauthorized, gin.H{
-				"error": "invalid or expired session",
-			})
-			c.Abort()
+			apierrors.Abort(c, http.StatusUnauthorized, apierrors.CodeSessionExpired)
 			return
 		}
 
+		// Sliding expiration: activity keeps the session alive
+		if err := RenewSession(session); err != nil {
+			logger.Warn("Failed to renew session", "user_id", session.UserID, "error", err)
+		}
+
 		// Store user and session in context
 		c.Set(ContextKeyUser, &session.User)
 		c.Set(ContextKeySession, session)
 
+		// Audit entries made with this request name the user and session,
+		// and for an impersonated session the admin too
+		ctx := audit.WithSession(audit.WithUser(c.Request.Context(), session.User.ID, session.User.Username), session.ID)
+		if session.Impersonator != nil {
+			ctx = audit.WithImpersonator(ctx, session.Impersonator.ID, session.Impersonator.Username)
+		}
+		c.Request = c.Request.WithContext(ctx)
+
 		c.Next()
 	}
 }
@@ -59,10 +70,7 @@ func RequireRole(roles ...db.Role) gin.HandlerFunc {
 	return func(c *gin.Context) {
 		user := GetUser(c)
 		if user == nil {
-			c.JSON(http.StatusUnauthorized, gin.H{
-				"error": "authentication required",
-			})
-			c.Abort()
+			apierrors.Abort(c, http.StatusUnauthorized, apierrors.CodeAuthenticationRequired)
 			return
 		}
 
@@ -76,10 +84,7 @@ func RequireRole(roles ...db.Role) gin.HandlerFunc {
 		}
 
 		if !hasRole {
-			c.JSON(http.StatusForbidden, gin.H{
-				"error": "insufficient permissions",
-			})
-			c.Abort()
+			apierrors.Abort(c, http.StatusForbidden, apierrors.CodeForbidden)
 			return
 		}
 
@@ -107,6 +112,15 @@ func GetSession(c *gin.Context) *db.Session {
 	return nil
 }
 
+// GetImpersonator returns the admin acting as the user through an
+// impersonated session, or nil
+func GetImpersonator(c *gin.Context) *db.User {
+	if session := GetSession(c); session != nil {
+		return session.Impersonator
+	}
+	return nil
+}
+
 // extractToken extracts the token from the Authorization header or cookie
 func extractToken(c *gin.Context) string {
 	// Try Authorization header first (Bearer token)
@@ -130,59 +144,78 @@ func extractToken(c *gin.Context) string {
 // APIKeyMiddleware is a middleware that validates API keys
 func APIKeyMiddleware() gin.HandlerFunc {
 	return func(c *gin.Context) {
-		// Get API key from X-API-Key header
+		// Get API key from X-API-Key header, or as a bearer token for clients
+		// (like Prometheus) that can only set Authorization
 		apiKeyValue := c.GetHeader("X-API-Key")
+		if apiKeyValue == "" {
+			apiKeyValue, _ = strings.CutPrefix(c.GetHeader("Authorization"), "Bearer ")
+		}
 
 		if apiKeyValue == "" {
-			c.JSON(http.StatusUnauthorized, gin.H{
-				"error": "API key required",
-			})
-			c.Abort()
+			apierrors.Abort(c, http.StatusUnauthorized, apierrors.CodeAPIKeyRequired)
 			return
 		}
 
-		// Create SHA256 hash for fast lookup
-		keyHashBytes := sha256.Sum256([]byte(apiKeyValue))
-		keyHash := hex.EncodeToString(keyHashBytes[:])
-
-		// Fast O(1) lookup by hash
-		key, err := db.GetAPIKeyByKeyHash(keyHash)
+		user, err := ValidateAPIKey(apiKeyValue)
 		if err != nil {
-			c.JSON(http.StatusUnauthorized, gin.H{
-				"error": "invalid API key",
+			status := http.StatusUnauthorized
+			if errors.Is(err, ErrUserDisabled) {
+				status = http.StatusForbidden
+			}
+			c.JSON(status, gin.H{
+				"error": err.Error(),
 			})
 			c.Abort()
 			return
 		}
 
-		// Verify with bcrypt (prevents timing attacks on the actual key)
-		if err := VerifyPassword(apiKeyValue, key.Key); err != nil {
-			c.JSON(http.StatusUnauthorized, gin.H{
-				"error": "invalid API key",
-			})
-			c.Abort()
-			return
-		}
+		// Store user in context
+		c.Set(ContextKeyUser, user)
+		c.Request = c.Request.WithContext(audit.WithUser(c.Request.Context(), user.ID, user.Username))
 
-		// Update last used time (async, don't block)
-		go func() {
-			_ = db.UpdateAPIKeyLastUsed(key.ID)
-		}()
+		c.Next()
+	}
+}
 
-		// Check if user is enabled
-		if !key.User.Enabled {
-			c.JSON(http.StatusForbidden, gin.H{
-				"error": "user account is disabled",
-			})
-			c.Abort()
-			return
+// Errors returned by ValidateAPIKey
+var (
+	ErrInvalidAPIKey = errors.New("invalid API key")
+	ErrUserDisabled  = errors.New("user account is disabled")
+)
+
+// ValidateAPIKey checks a raw API key and returns the user it belongs to
+func ValidateAPIKey(apiKeyValue string) (*db.User, error) {
+	// Create SHA256 hash for fast lookup
+	keyHashBytes := sha256.Sum256([]byte(apiKeyValue))
+	keyHash := hex.EncodeToString(keyHashBytes[:])
+
+	// Fast O(1) lookup by hash (also rejects disabled, expired and deleted keys)
+	key, err := db.GetAPIKeyByKeyHash(keyHash)
+	if err != nil {
+		verifiedAPIKeys.remove(keyHash)
+		return nil, ErrInvalidAPIKey
+	}
+
+	// Verify with bcrypt (prevents timing attacks on the actual key), unless
+	// this key was verified against the same stored hash recently
+	if !verifiedAPIKeys.verified(keyHash, key.Key) {
+		if err := VerifyPassword(apiKeyValue, key.Key); err != nil {
+			return nil, ErrInvalidAPIKey
 		}
+		verifiedAPIKeys.add(keyHash, key.Key)
+	}
 
-		// Store user in context
-		c.Set(ContextKeyUser, &key.User)
+	// Update last used time (async, don't block)
+	go func() {
+		_ = db.UpdateAPIKeyLastUsed(key.ID)
+	}()
 
-		c.Next()
+	// Check if user is enabled
+	if !key.User.Enabled {
+		return nil, ErrUserDisabled
 	}
+
+	return &key.User, nil
 }
 
 // OptionalAuthMiddleware tries to authenticate but doesn't require it
@@ -196,6 +229,8 @@ func OptionalAuthMiddleware() gin.HandlerFunc {
 			if err == nil {
 				c.Set(ContextKeyUser, &session.User)
 				c.Set(ContextKeySession, session)
+				c.Request = c.Request.WithContext(audit.WithSession(
+					audit.WithUser(c.Request.Context(), session.User.ID, session.User.Username), session.ID))
 			}
 		}
 
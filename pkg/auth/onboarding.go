@@ -0,0 +1,55 @@
+package auth
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/thesabbir/hellfire/pkg/db"
+)
+
+// OnboardingTokenLength is the length of onboarding tokens in bytes
+const OnboardingTokenLength = 16
+
+// ErrInvalidOnboardingToken is returned for a wrong, used or expired onboarding token
+var ErrInvalidOnboardingToken = errors.New("invalid or expired onboarding token")
+
+// IssueOnboardingToken creates the one-time token that authorizes creating
+// the first admin user, replacing any previous one. The token is returned
+// to be shown to whoever has console access; only its hash is stored.
+func IssueOnboardingToken(ttl time.Duration) (string, time.Time, error) {
+	token, err := generateSecureToken(OnboardingTokenLength)
+	if err != nil {
+		return "", time.Time{}, fmt.Errorf("failed to generate onboarding token: %w", err)
+	}
+
+	expiresAt := time.Now().Add(ttl)
+	if err := db.ReplaceOnboardingToken(&db.OnboardingToken{
+		TokenHash: hashOnboardingToken(token),
+		ExpiresAt: expiresAt,
+	}); err != nil {
+		return "", time.Time{}, fmt.Errorf("failed to store onboarding token: %w", err)
+	}
+	return token, expiresAt, nil
+}
+
+// ConsumeOnboardingToken checks an onboarding token and invalidates it, so
+// it can't be used again
+func ConsumeOnboardingToken(token string) error {
+	ok, err := db.ConsumeOnboardingToken(hashOnboardingToken(strings.TrimSpace(token)))
+	if err != nil {
+		return fmt.Errorf("failed to check onboarding token: %w", err)
+	}
+	if !ok {
+		return ErrInvalidOnboardingToken
+	}
+	return nil
+}
+
+func hashOnboardingToken(token string) string {
+	hash := sha256.Sum256([]byte(strings.ToLower(token)))
+	return hex.EncodeToString(hash[:])
+}
@@ -1,10 +1,16 @@
 package auth
 
 import (
+	"bufio"
+	"errors"
 	"fmt"
+	"os"
 	"strings"
+	"sync"
 
 	"golang.org/x/crypto/bcrypt"
+
+	"github.com/thesabbir/hellfire/pkg/db"
 )
 
 const (
@@ -53,19 +59,135 @@ func VerifyPassword(password, hash string) error {
 	return nil
 }
 
-// ValidatePasswordStrength validates password strength
+var (
+	// ErrWrongPassword is returned when a password change gives the wrong current password
+	ErrWrongPassword = errors.New("current password is incorrect")
+
+	// ErrPasswordUnchanged is returned when the new password is the current one
+	ErrPasswordUnchanged = errors.New("new password must differ from the current one")
+)
+
+// ChangePassword sets a new password for user after checking their current
+// one and the password policy, then logs out all their sessions except the
+// one with keepToken (empty to log out everywhere)
+func ChangePassword(user *db.User, currentPassword, newPassword, keepToken string) error {
+	if err := VerifyPassword(currentPassword, user.PasswordHash); err != nil {
+		return ErrWrongPassword
+	}
+	if newPassword == currentPassword {
+		return ErrPasswordUnchanged
+	}
+	if err := ValidatePasswordStrength(newPassword); err != nil {
+		return err
+	}
+
+	hash, err := HashPassword(newPassword)
+	if err != nil {
+		return err
+	}
+	user.PasswordHash = hash
+	if err := db.UpdateUser(user); err != nil {
+		return fmt.Errorf("failed to update password: %w", err)
+	}
+
+	if err := db.DeleteOtherUserSessions(user.ID, keepToken); err != nil {
+		return fmt.Errorf("failed to log out other sessions: %w", err)
+	}
+	return nil
+}
+
+// MaxPasswordLength is the longest password bcrypt can hash
+const MaxPasswordLength = 72
+
+// PasswordPolicy describes the requirements for new passwords
+type PasswordPolicy struct {
+	MinLength      int
+	RequireUpper   bool
+	RequireLower   bool
+	RequireNumber  bool
+	RequireSpecial bool
+	Banned         []string // Rejected regardless of complexity (compared case-insensitively)
+}
+
+// DefaultPasswordPolicy returns the built-in policy: 12 characters with
+// upper and lower case letters, a number and a special character
+func DefaultPasswordPolicy() PasswordPolicy {
+	return PasswordPolicy{
+		MinLength:      12,
+		RequireUpper:   true,
+		RequireLower:   true,
+		RequireNumber:  true,
+		RequireSpecial: true,
+	}
+}
+
+var (
+	policyMu sync.RWMutex
+	policy   = DefaultPasswordPolicy()
+	banned   map[string]bool
+)
+
+// SetPasswordPolicy sets the policy enforced by ValidatePasswordStrength
+func SetPasswordPolicy(p PasswordPolicy) {
+	set := make(map[string]bool, len(p.Banned))
+	for _, password := range p.Banned {
+		set[strings.ToLower(password)] = true
+	}
+
+	policyMu.Lock()
+	defer policyMu.Unlock()
+	policy = p
+	banned = set
+}
+
+// CurrentPasswordPolicy returns the policy enforced by ValidatePasswordStrength
+func CurrentPasswordPolicy() PasswordPolicy {
+	policyMu.RLock()
+	defer policyMu.RUnlock()
+	return policy
+}
+
+// ReadBannedPasswords reads a banned password list, one password per line.
+// Blank lines and lines starting with # are ignored.
+func ReadBannedPasswords(path string) ([]string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open banned password list: %w", err)
+	}
+	defer f.Close()
+
+	var passwords []string
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		passwords = append(passwords, line)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read banned password list: %w", err)
+	}
+
+	return passwords, nil
+}
+
+// ValidatePasswordStrength validates a new password against the configured policy
 func ValidatePasswordStrength(password string) error {
-	const (
-		minLength = 12
-		maxLength = 72
-	)
+	policyMu.RLock()
+	p, isBanned := policy, banned[strings.ToLower(password)]
+	policyMu.RUnlock()
+
+	if len(password) < p.MinLength {
+		return fmt.Errorf("password must be at least %d characters", p.MinLength)
+	}
 
-	if len(password) < minLength {
-		return fmt.Errorf("password must be at least %d characters", minLength)
+	if len(password) > MaxPasswordLength {
+		return fmt.Errorf("password must be at most %d characters", MaxPasswordLength)
 	}
 
-	if len(password) > maxLength {
-		return fmt.Errorf("password must be at most %d characters", maxLength)
+	if isBanned {
+		return fmt.Errorf("password is too common, choose a different one")
 	}
 
 	// Check for complexity requirements
@@ -99,16 +221,16 @@ func ValidatePasswordStrength(password string) error {
 
 	// Build detailed error message
 	var missing []string
-	if !hasUpper {
+	if p.RequireUpper && !hasUpper {
 		missing = append(missing, "uppercase letter")
 	}
-	if !hasLower {
+	if p.RequireLower && !hasLower {
 		missing = append(missing, "lowercase letter")
 	}
-	if !hasNumber {
+	if p.RequireNumber && !hasNumber {
 		missing = append(missing, "number")
 	}
-	if !hasSpecial {
+	if p.RequireSpecial && !hasSpecial {
 		missing = append(missing, "special character")
 	}
 
@@ -5,6 +5,7 @@ import (
 	"crypto/sha256"
 	"encoding/hex"
 	"fmt"
+	"sync"
 	"time"
 
 	"github.com/thesabbir/hellfire/pkg/db"
@@ -22,10 +23,42 @@ const (
 	SessionTokenLength = 32
 )
 
-// CreateSession creates a new session for a user
+// Configured session timeouts (see SetSessionTimeouts)
+var (
+	timeoutsMu      sync.RWMutex
+	idleTimeout     = DefaultSessionDuration
+	absoluteTimeout = AbsoluteSessionDuration
+)
+
+// SetSessionTimeouts sets the idle and absolute session lifetimes for new
+// sessions and for renewals of existing ones. Zero keeps the default.
+func SetSessionTimeouts(idle, absolute time.Duration) {
+	if idle <= 0 {
+		idle = DefaultSessionDuration
+	}
+	if absolute <= 0 {
+		absolute = AbsoluteSessionDuration
+	}
+
+	timeoutsMu.Lock()
+	defer timeoutsMu.Unlock()
+	idleTimeout = idle
+	absoluteTimeout = absolute
+}
+
+// SessionTimeouts returns the configured idle and absolute session lifetimes
+func SessionTimeouts() (idle, absolute time.Duration) {
+	timeoutsMu.RLock()
+	defer timeoutsMu.RUnlock()
+	return idleTimeout, absoluteTimeout
+}
+
+// CreateSession creates a new session for a user. A zero duration uses the
+// configured idle timeout.
 func CreateSession(userID uint, ipAddress, userAgent string, duration time.Duration) (*db.Session, error) {
+	idle, absolute := SessionTimeouts()
 	if duration == 0 {
-		duration = DefaultSessionDuration
+		duration = idle
 	}
 
 	// Generate secure random token
@@ -43,7 +76,7 @@ func CreateSession(userID uint, ipAddress, userAgent string, duration time.Durat
 		Token:          token,
 		UserID:         userID,
 		ExpiresAt:      now.Add(duration),
-		AbsoluteExpiry: now.Add(AbsoluteSessionDuration),
+		AbsoluteExpiry: now.Add(absolute),
 		IPAddress:      ipAddress,
 		UserAgent:      userAgent,
 		Fingerprint:    fingerprint,
@@ -56,6 +89,30 @@ func CreateSession(userID uint, ipAddress, userAgent string, duration time.Durat
 	return session, nil
 }
 
+// RenewSession slides a session's idle expiry forward on authenticated
+// activity, never past its absolute expiry. The database is only written
+// once the expiry would move by more than a tenth of the idle timeout, so
+// busy clients don't cause a write per request.
+func RenewSession(session *db.Session) error {
+	idle, _ := SessionTimeouts()
+
+	expiresAt := time.Now().Add(idle)
+	if expiresAt.After(session.AbsoluteExpiry) {
+		expiresAt = session.AbsoluteExpiry
+	}
+
+	if expiresAt.Sub(session.ExpiresAt) < idle/10 {
+		return nil
+	}
+
+	if err := db.UpdateSessionExpiry(session.ID, expiresAt); err != nil {
+		return fmt.Errorf("failed to renew session: %w", err)
+	}
+
+	session.ExpiresAt = expiresAt
+	return nil
+}
+
 // generateFingerprint creates a SHA256 hash of IP + User-Agent
 func generateFingerprint(ipAddress, userAgent string) string {
 	data := fmt.Sprintf("%s|%s", ipAddress, userAgent)
@@ -79,6 +136,10 @@ func ValidateSession(token string) (*db.Session, error) {
 		return nil, fmt.Errorf("user account is disabled")
 	}
 
+	if err := checkImpersonator(session); err != nil {
+		return nil, err
+	}
+
 	return session, nil
 }
 
@@ -115,6 +176,10 @@ func ValidateSessionWithFingerprint(token, ipAddress, userAgent string) (*db.Ses
 		return nil, fmt.Errorf("user account is disabled")
 	}
 
+	if err := checkImpersonator(session); err != nil {
+		return nil, err
+	}
+
 	return session, nil
 }
 
@@ -167,7 +232,7 @@ func Login(username, password, ipAddress, userAgent string) (*db.Session, error)
 	}
 
 	// Create session
-	session, err := CreateSession(user.ID, ipAddress, userAgent, DefaultSessionDuration)
+	session, err := CreateSession(user.ID, ipAddress, userAgent, 0)
 	if err != nil {
 		return nil, fmt.Errorf("failed to create session: %w", err)
 	}
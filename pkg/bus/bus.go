@@ -1,7 +1,13 @@
 package bus
 
 import (
+	"errors"
+	"fmt"
+	"strings"
 	"sync"
+	"sync/atomic"
+
+	"github.com/thesabbir/hellfire/pkg/logger"
 )
 
 // EventType represents different types of configuration events
@@ -11,11 +17,35 @@ const (
 	EventConfigChanged        EventType = "config.changed"
 	EventConfigCommitted      EventType = "config.committed"
 	EventConfigReverted       EventType = "config.reverted"
+	EventConfigGeneration     EventType = "config.generation"
 	EventSnapshotCreated      EventType = "snapshot.created"
 	EventTransactionStarted   EventType = "transaction.started"
 	EventTransactionCompleted EventType = "transaction.completed"
 	EventTransactionFailed    EventType = "transaction.failed"
+	EventTransactionProgress  EventType = "transaction.progress"
 	EventRollbackStarted      EventType = "rollback.started"
+	EventProbeFailed          EventType = "probe.failed"
+	EventSettingsReloaded     EventType = "settings.reloaded"
+	EventSystemDegraded       EventType = "system.degraded"
+	EventSystemRecovered      EventType = "system.recovered"
+	EventDHCPAddressChanged   EventType = "dhcp.address_changed"
+	EventDHCPLeaseRenewed     EventType = "dhcp.lease_renewed"
+	EventDHCPPoolExhausting   EventType = "dhcp.pool_exhausting"
+	EventDHCPPoolRecovered    EventType = "dhcp.pool_recovered"
+	EventQuotaExceeded        EventType = "quota.exceeded"
+	EventQuotaReset           EventType = "quota.reset"
+	EventWANDegraded          EventType = "wan.degraded"
+	EventWANRecovered         EventType = "wan.recovered"
+	EventCertExpiring         EventType = "cert.expiring"
+	EventCertExpired          EventType = "cert.expired"
+	EventUpgradeSucceeded     EventType = "upgrade.succeeded"
+	EventUpgradeFailed        EventType = "upgrade.failed"
+	EventTaskFailed           EventType = "task.failed"
+	EventLoginNewDevice       EventType = "auth.new_device"
+	EventLinkAdded            EventType = "link.added"
+	EventLinkRemoved          EventType = "link.removed"
+	EventLinkUp               EventType = "link.up"
+	EventLinkDown             EventType = "link.down"
 )
 
 // Event represents a configuration event
@@ -28,84 +58,263 @@ type Event struct {
 // Handler is a function that handles events
 type Handler func(event Event)
 
-// Bus is a simple pub/sub event bus
+// ErrorHandler is a handler that reports whether its side effects succeeded
+type ErrorHandler func(event Event) error
+
+// OverflowPolicy decides what happens to an event when a handler's queue is full
+type OverflowPolicy string
+
+const (
+	// OverflowBlock makes Publish wait until the handler catches up
+	OverflowBlock OverflowPolicy = "block"
+	// OverflowDrop discards the event (it is counted and logged)
+	OverflowDrop OverflowPolicy = "drop"
+	// OverflowDisk spools the event to disk and delivers it once the queue drains
+	OverflowDisk OverflowPolicy = "disk"
+)
+
+// DefaultQueueSize is the number of events buffered per handler
+const DefaultQueueSize = 100
+
+// ParseOverflowPolicy parses an overflow policy name (block, drop or disk)
+func ParseOverflowPolicy(name string) (OverflowPolicy, error) {
+	switch policy := OverflowPolicy(strings.ToLower(name)); policy {
+	case OverflowBlock, OverflowDrop, OverflowDisk:
+		return policy, nil
+	default:
+		return "", fmt.Errorf("invalid event overflow policy: %s (must be block, drop or disk)", name)
+	}
+}
+
+// Options configures how events are buffered for handlers
+type Options struct {
+	QueueSize int            // Events buffered per handler
+	Overflow  OverflowPolicy // What to do when a handler's queue is full
+	SpoolDir  string         // Directory for spooled events (OverflowDisk)
+}
+
+// DefaultOptions returns the default bus options
+func DefaultOptions() Options {
+	return Options{
+		QueueSize: DefaultQueueSize,
+		Overflow:  OverflowBlock,
+	}
+}
+
+// SubscribeOptions overrides the bus options for one handler and narrows
+// the events it receives
+type SubscribeOptions struct {
+	Name      string         // Shown in stats and logs, and names the spool file
+	QueueSize int            // 0 uses the bus default
+	Overflow  OverflowPolicy // Empty uses the bus default
+
+	// Configs limits delivery to events about these configs, by ConfigName
+	// or by the configs listed in a commit or revert event's Data
+	Configs []string
+	// Filter, if set, must return true for the event to be delivered. It
+	// runs in the publisher's goroutine, so it should be quick.
+	Filter func(Event) bool
+}
+
+// Bus is a simple pub/sub event bus. Every handler has its own queue and
+// goroutine, so a slow handler only delays its own events.
 type Bus struct {
-	mu        sync.RWMutex
-	handlers  map[EventType][]Handler
-	chanSize  int
-	eventChan chan Event
-	wg        sync.WaitGroup
-	stopped   bool
+	mu           sync.RWMutex
+	opts         Options
+	subs         []*subscription
+	watchers     map[int]chan Event
+	nextWatch    int
+	done         chan struct{}
+	wg           sync.WaitGroup
+	stopped      bool
+	published    atomic.Uint64
+	watchDropped atomic.Uint64
 }
 
 // NewBus creates a new event bus
 func NewBus() *Bus {
-	b := &Bus{
-		handlers:  make(map[EventType][]Handler),
-		chanSize:  100,
-		eventChan: make(chan Event, 100),
+	return &Bus{
+		opts:     DefaultOptions(),
+		watchers: make(map[int]chan Event),
+		done:     make(chan struct{}),
 	}
-	b.start()
-	return b
 }
 
-// Subscribe subscribes a handler to an event type
+// Configure sets the queue size and overflow policy for handlers subscribed
+// afterwards; existing subscriptions keep their settings
+func (b *Bus) Configure(opts Options) error {
+	if opts.QueueSize < 1 {
+		return fmt.Errorf("event queue size must be at least 1")
+	}
+	if _, err := ParseOverflowPolicy(string(opts.Overflow)); err != nil {
+		return err
+	}
+	if opts.Overflow == OverflowDisk && opts.SpoolDir == "" {
+		return fmt.Errorf("event overflow policy disk requires a spool directory")
+	}
+
+	b.mu.Lock()
+	b.opts = opts
+	b.mu.Unlock()
+	return nil
+}
+
+// Subscribe subscribes a handler to an event type using the bus defaults.
+// The event type may be a pattern such as "config.*" or "*" (see path.Match).
 func (b *Bus) Subscribe(eventType EventType, handler Handler) {
+	b.SubscribeWith(eventType, handler, SubscribeOptions{})
+}
+
+// SubscribeWith subscribes a handler to an event type with its own queue settings
+func (b *Bus) SubscribeWith(eventType EventType, handler Handler, opts SubscribeOptions) {
+	b.subscribe(eventType, func(event Event) error {
+		handler(event)
+		return nil
+	}, opts, false)
+}
+
+// SubscribeSync subscribes a handler that runs in the publisher's goroutine,
+// in subscription order, before Publish returns. Its errors (and panics) are
+// returned by PublishSync. Queue settings in opts are ignored.
+func (b *Bus) SubscribeSync(eventType EventType, handler ErrorHandler, opts SubscribeOptions) {
+	b.subscribe(eventType, handler, opts, true)
+}
+
+func (b *Bus) subscribe(eventType EventType, handler ErrorHandler, opts SubscribeOptions, sync bool) {
 	b.mu.Lock()
 	defer b.mu.Unlock()
 
-	if b.handlers[eventType] == nil {
-		b.handlers[eventType] = make([]Handler, 0)
+	if opts.Name == "" {
+		n := 1
+		for _, s := range b.subs {
+			if s.eventType == eventType {
+				n++
+			}
+		}
+		opts.Name = fmt.Sprintf("%s#%d", eventType, n)
+	}
+	if opts.QueueSize < 1 {
+		opts.QueueSize = b.opts.QueueSize
+	}
+	if opts.Overflow == "" {
+		opts.Overflow = b.opts.Overflow
+	}
+
+	s := &subscription{
+		name:      opts.Name,
+		eventType: eventType,
+		handler:   handler,
+		sync:      sync,
+		configs:   opts.Configs,
+		filter:    opts.Filter,
 	}
-	b.handlers[eventType] = append(b.handlers[eventType], handler)
-}
 
-// Publish publishes an event to all subscribers
-func (b *Bus) Publish(event Event) {
-	if b.stopped {
+	b.subs = append(b.subs, s)
+
+	if sync {
 		return
 	}
 
-	select {
-	case b.eventChan <- event:
-	default:
-		// Event channel full, drop event
-		// In production, you might want to log this
+	s.overflow = opts.Overflow
+	s.queue = make(chan Event, opts.QueueSize)
+	s.wake = make(chan struct{}, 1)
+
+	if s.overflow == OverflowDisk {
+		sp, err := openSpool(b.opts.SpoolDir, s.name)
+		if err != nil {
+			logger.Error("Failed to open event spool, blocking on overflow instead",
+				"handler", s.name, "error", err)
+			s.overflow = OverflowBlock
+		} else {
+			s.spool = sp
+			if n := sp.pending(); n > 0 {
+				logger.Info("Replaying spooled events", "handler", s.name, "events", n)
+				s.notify()
+			}
+		}
 	}
-}
 
-// start starts the event processing goroutine
-func (b *Bus) start() {
 	b.wg.Add(1)
 	go func() {
 		defer b.wg.Done()
-		for event := range b.eventChan {
-			b.dispatch(event)
-		}
+		s.run(b.done)
 	}()
 }
 
-// dispatch dispatches an event to all registered handlers
-func (b *Bus) dispatch(event Event) {
+// Watch returns a channel receiving every published event and a function
+// that stops the watch. Events are dropped if the channel's buffer is full,
+// so a slow reader can't stall the bus.
+func (b *Bus) Watch(buffer int) (<-chan Event, func()) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	id := b.nextWatch
+	b.nextWatch++
+
+	ch := make(chan Event, buffer)
+	b.watchers[id] = ch
+
+	var once sync.Once
+	stop := func() {
+		once.Do(func() {
+			b.mu.Lock()
+			delete(b.watchers, id)
+			b.mu.Unlock()
+			close(ch)
+		})
+	}
+
+	return ch, stop
+}
+
+// Publish runs the synchronous handlers for an event and queues it for every
+// other subscribed handler. What happens when a handler's queue is full
+// depends on its overflow policy. Handler errors are only logged.
+func (b *Bus) Publish(event Event) {
+	_ = b.PublishSync(event)
+}
+
+// PublishSync is like Publish, but returns the errors of the synchronous
+// handlers, joined and prefixed with the handler names. Queued handlers run
+// later, so their results are only logged and counted in Stats.
+func (b *Bus) PublishSync(event Event) error {
 	b.mu.RLock()
-	handlers := b.handlers[event.Type]
+	if b.stopped {
+		b.mu.RUnlock()
+		return nil
+	}
+	var subs []*subscription
+	for _, s := range b.subs {
+		if s.matches(event) {
+			subs = append(subs, s)
+		}
+	}
+	for _, ch := range b.watchers {
+		select {
+		case ch <- event:
+		default:
+			// Watcher too slow, drop event
+			b.watchDropped.Add(1)
+		}
+	}
 	b.mu.RUnlock()
 
-	for _, handler := range handlers {
-		// Run handlers in goroutines to avoid blocking
-		go func(h Handler) {
-			defer func() {
-				// Recover from panics in handlers
-				if r := recover(); r != nil {
-					// In production, log this
-				}
-			}()
-			h(event)
-		}(handler)
+	b.published.Add(1)
+	var errs []error
+	for _, s := range subs {
+		if s.sync {
+			if err := s.deliver(event); err != nil {
+				errs = append(errs, fmt.Errorf("%s: %w", s.name, err))
+			}
+			continue
+		}
+		s.enqueue(event, b.done)
 	}
+	return errors.Join(errs...)
 }
 
-// Stop stops the event bus
+// Stop stops the event bus. Queued events are delivered first; spooled
+// events stay on disk and are replayed when the handler subscribes again.
 func (b *Bus) Stop() {
 	b.mu.Lock()
 	if b.stopped {
@@ -115,19 +324,89 @@ func (b *Bus) Stop() {
 	b.stopped = true
 	b.mu.Unlock()
 
-	close(b.eventChan)
+	close(b.done)
 	b.wg.Wait()
 }
 
+// Stats reports event counters for the bus and each handler
+type Stats struct {
+	Published      uint64         `json:"published"`
+	WatcherDropped uint64         `json:"watcher_dropped"`
+	Handlers       []HandlerStats `json:"handlers"`
+}
+
+// HandlerStats reports the queue state and counters of one handler
+type HandlerStats struct {
+	Name      string         `json:"name"`
+	Event     EventType      `json:"event"` // Event type or pattern
+	Configs   []string       `json:"configs,omitempty"`
+	Sync      bool           `json:"sync"`
+	Overflow  OverflowPolicy `json:"overflow,omitempty"`
+	Queued    int            `json:"queued"`
+	QueueSize int            `json:"queue_size"`
+	Spooled   int            `json:"spooled"`
+	Delivered uint64         `json:"delivered"`
+	Failed    uint64         `json:"failed"`
+	Dropped   uint64         `json:"dropped"`
+	Blocked   uint64         `json:"blocked"`
+}
+
+// Stats returns the current event counters
+func (b *Bus) Stats() Stats {
+	b.mu.RLock()
+	subs := b.subs
+	b.mu.RUnlock()
+
+	stats := Stats{
+		Published:      b.published.Load(),
+		WatcherDropped: b.watchDropped.Load(),
+		Handlers:       make([]HandlerStats, 0, len(subs)),
+	}
+	for _, s := range subs {
+		stats.Handlers = append(stats.Handlers, s.stats())
+	}
+	return stats
+}
+
 // GlobalBus is the global event bus instance
 var GlobalBus = NewBus()
 
+// Configure configures the global bus
+func Configure(opts Options) error {
+	return GlobalBus.Configure(opts)
+}
+
 // Subscribe subscribes to the global bus
 func Subscribe(eventType EventType, handler Handler) {
 	GlobalBus.Subscribe(eventType, handler)
 }
 
+// SubscribeWith subscribes to the global bus with per-handler queue settings
+func SubscribeWith(eventType EventType, handler Handler, opts SubscribeOptions) {
+	GlobalBus.SubscribeWith(eventType, handler, opts)
+}
+
+// SubscribeSync subscribes a synchronous handler to the global bus
+func SubscribeSync(eventType EventType, handler ErrorHandler, opts SubscribeOptions) {
+	GlobalBus.SubscribeSync(eventType, handler, opts)
+}
+
+// Watch watches all events on the global bus
+func Watch(buffer int) (<-chan Event, func()) {
+	return GlobalBus.Watch(buffer)
+}
+
 // Publish publishes to the global bus
 func Publish(event Event) {
 	GlobalBus.Publish(event)
 }
+
+// PublishSync publishes to the global bus and returns synchronous handler errors
+func PublishSync(event Event) error {
+	return GlobalBus.PublishSync(event)
+}
+
+// GetStats returns the event counters of the global bus
+func GetStats() Stats {
+	return GlobalBus.Stats()
+}
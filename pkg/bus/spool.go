@@ -0,0 +1,176 @@
+package bus
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"encoding/gob"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sync"
+)
+
+func init() {
+	// Data types carried by Hellfire's own events; basic types and slices of
+	// them are known to gob already
+	RegisterData(map[string]string{})
+	RegisterData(map[string]interface{}{})
+}
+
+// RegisterData registers a concrete Event.Data type so events carrying it
+// can be spooled to disk. Unregistered types are dropped on overflow.
+func RegisterData(value interface{}) {
+	gob.Register(value)
+}
+
+var unsafeSpoolChars = regexp.MustCompile(`[^A-Za-z0-9._-]`)
+
+// spool is an append-only file of length-prefixed gob-encoded events
+type spool struct {
+	mu    sync.Mutex
+	file  *os.File
+	count int
+}
+
+// openSpool opens the spool file for a handler, keeping events left by a
+// previous run so they are delivered now
+func openSpool(dir, name string) (*spool, error) {
+	if err := os.MkdirAll(dir, 0750); err != nil {
+		return nil, fmt.Errorf("failed to create spool directory: %w", err)
+	}
+
+	path := filepath.Join(dir, unsafeSpoolChars.ReplaceAllString(name, "_")+".spool")
+	file, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE|os.O_APPEND, 0600)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open spool file: %w", err)
+	}
+
+	s := &spool{file: file}
+	events, err := s.read()
+	if err != nil {
+		// Keep what could be read; a torn final record is expected after a crash
+		if err := s.rewrite(events); err != nil {
+			_ = file.Close()
+			return nil, err
+		}
+	}
+	s.count = len(events)
+
+	return s, nil
+}
+
+// maxSpoolRecord bounds a record's length so a corrupt header can't cause a huge allocation
+const maxSpoolRecord = 16 << 20
+
+// encodeRecord encodes an event as a length-prefixed gob record
+func encodeRecord(event Event) ([]byte, error) {
+	var record bytes.Buffer
+	record.Write(make([]byte, 4))
+	if err := gob.NewEncoder(&record).Encode(&event); err != nil {
+		return nil, fmt.Errorf("failed to encode event: %w", err)
+	}
+
+	data := record.Bytes()
+	binary.BigEndian.PutUint32(data, uint32(len(data)-4))
+	return data, nil
+}
+
+// append writes an event to the end of the spool
+func (s *spool) append(event Event) error {
+	record, err := encodeRecord(event)
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, err := s.file.Write(record); err != nil {
+		return fmt.Errorf("failed to write spool file: %w", err)
+	}
+	s.count++
+	return nil
+}
+
+// pending returns the number of spooled events
+func (s *spool) pending() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.count
+}
+
+// drain returns all spooled events and empties the spool. Events that can't
+// be decoded are discarded and reported in the error.
+func (s *spool) drain() ([]Event, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	events, readErr := s.read()
+	s.count = 0
+	if err := s.file.Truncate(0); err != nil {
+		return events, fmt.Errorf("failed to truncate spool file: %w", err)
+	}
+	return events, readErr
+}
+
+// read decodes every event in the file (must be called with lock held)
+func (s *spool) read() ([]Event, error) {
+	if _, err := s.file.Seek(0, io.SeekStart); err != nil {
+		return nil, fmt.Errorf("failed to read spool file: %w", err)
+	}
+
+	r := bufio.NewReader(s.file)
+	var events []Event
+	for {
+		var header [4]byte
+		if _, err := io.ReadFull(r, header[:]); err != nil {
+			if errors.Is(err, io.EOF) {
+				return events, nil
+			}
+			return events, fmt.Errorf("truncated spool record: %w", err)
+		}
+
+		size := binary.BigEndian.Uint32(header[:])
+		if size > maxSpoolRecord {
+			return events, fmt.Errorf("corrupt spool record (%d bytes)", size)
+		}
+
+		record := make([]byte, size)
+		if _, err := io.ReadFull(r, record); err != nil {
+			return events, fmt.Errorf("truncated spool record: %w", err)
+		}
+
+		var event Event
+		if err := gob.NewDecoder(bytes.NewReader(record)).Decode(&event); err != nil {
+			return events, fmt.Errorf("failed to decode spooled event: %w", err)
+		}
+		events = append(events, event)
+	}
+}
+
+// rewrite replaces the file contents with the given events (must be called with lock held)
+func (s *spool) rewrite(events []Event) error {
+	if err := s.file.Truncate(0); err != nil {
+		return fmt.Errorf("failed to truncate spool file: %w", err)
+	}
+	for _, event := range events {
+		record, err := encodeRecord(event)
+		if err != nil {
+			return err
+		}
+		if _, err := s.file.Write(record); err != nil {
+			return fmt.Errorf("failed to write spool file: %w", err)
+		}
+	}
+	return nil
+}
+
+func (s *spool) close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.file.Close()
+}
@@ -0,0 +1,218 @@
+package bus
+
+import (
+	"fmt"
+	"path"
+	"runtime/debug"
+	"slices"
+	"sync/atomic"
+
+	"github.com/thesabbir/hellfire/pkg/logger"
+)
+
+// subscription is a handler with its own event queue, drained by one
+// goroutine, or a synchronous handler run by the publisher
+type subscription struct {
+	name      string
+	eventType EventType
+	handler   ErrorHandler
+	sync      bool
+	configs   []string
+	filter    func(Event) bool
+	overflow  OverflowPolicy
+	queue     chan Event
+	wake      chan struct{} // Signals spooled events while the queue is empty
+	spool     *spool        // OverflowDisk only
+
+	delivered atomic.Uint64
+	failed    atomic.Uint64
+	dropped   atomic.Uint64
+	blocked   atomic.Uint64
+}
+
+// matches reports whether the event type matches the subscribed type or
+// pattern and the event passes the config and custom filters
+func (s *subscription) matches(event Event) bool {
+	if s.eventType != event.Type {
+		if ok, err := path.Match(string(s.eventType), string(event.Type)); err != nil || !ok {
+			return false
+		}
+	}
+
+	if len(s.configs) > 0 && !s.matchesConfig(event) {
+		return false
+	}
+
+	return s.filter == nil || s.filter(event)
+}
+
+// matchesConfig reports whether the event concerns one of the subscribed configs
+func (s *subscription) matchesConfig(event Event) bool {
+	names := []string{event.ConfigName}
+	if event.Type == EventConfigCommitted || event.Type == EventConfigReverted {
+		if changes, ok := event.Data.([]string); ok {
+			names = append(names, changes...)
+		}
+	}
+
+	for _, name := range names {
+		if name != "" && slices.Contains(s.configs, name) {
+			return true
+		}
+	}
+	return false
+}
+
+// enqueue queues an event, applying the overflow policy when the queue is full
+func (s *subscription) enqueue(event Event, done <-chan struct{}) {
+	// Once events are spooled, later ones are spooled too so they stay in order
+	if s.spool != nil && s.spool.pending() > 0 {
+		s.spoolEvent(event)
+		return
+	}
+
+	select {
+	case s.queue <- event:
+		return
+	default:
+	}
+
+	switch s.overflow {
+	case OverflowBlock:
+		s.blocked.Add(1)
+		logger.Debug("Event queue full, waiting for handler", "handler", s.name, "event", event.Type)
+		select {
+		case s.queue <- event:
+		case <-done:
+			s.drop(event, "bus stopped")
+		}
+	case OverflowDisk:
+		s.spoolEvent(event)
+	default:
+		s.drop(event, "queue full")
+	}
+}
+
+func (s *subscription) spoolEvent(event Event) {
+	if err := s.spool.append(event); err != nil {
+		s.drop(event, err.Error())
+		return
+	}
+	s.notify()
+}
+
+// notify wakes the handler goroutine if it is waiting on an empty queue
+func (s *subscription) notify() {
+	select {
+	case s.wake <- struct{}{}:
+	default:
+	}
+}
+
+func (s *subscription) drop(event Event, reason string) {
+	s.dropped.Add(1)
+	logger.Warn("Event dropped",
+		"handler", s.name,
+		"event", event.Type,
+		"config", event.ConfigName,
+		"reason", reason,
+		"dropped_total", s.dropped.Load())
+}
+
+// run delivers queued events until the bus stops, then delivers what is
+// still queued. Spooled events are delivered whenever the queue runs empty.
+func (s *subscription) run(done <-chan struct{}) {
+	if s.spool != nil {
+		defer func() {
+			if err := s.spool.close(); err != nil {
+				logger.Warn("Failed to close event spool", "handler", s.name, "error", err)
+			}
+		}()
+	}
+
+	for {
+		select {
+		case event := <-s.queue:
+			s.deliver(event)
+		case <-s.wake:
+		case <-done:
+			for {
+				select {
+				case event := <-s.queue:
+					s.deliver(event)
+				default:
+					return
+				}
+			}
+		}
+
+		if len(s.queue) == 0 {
+			s.replaySpool()
+		}
+	}
+}
+
+// replaySpool delivers spooled events until the spool is empty
+func (s *subscription) replaySpool() {
+	if s.spool == nil {
+		return
+	}
+
+	for s.spool.pending() > 0 {
+		events, err := s.spool.drain()
+		if err != nil {
+			logger.Error("Failed to read spooled events", "handler", s.name, "error", err)
+		}
+		for _, event := range events {
+			s.deliver(event)
+		}
+		if err != nil {
+			return
+		}
+	}
+}
+
+// deliver runs the handler for one event. Errors and panics are logged
+// and counted; a panic is returned as an error.
+func (s *subscription) deliver(event Event) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("handler panicked: %v", r)
+			logger.Error("Event handler panicked",
+				"handler", s.name,
+				"event", event.Type,
+				"panic", r,
+				"stack", string(debug.Stack()))
+		}
+
+		s.delivered.Add(1)
+		if err != nil {
+			s.failed.Add(1)
+		}
+	}()
+
+	if err = s.handler(event); err != nil {
+		logger.Error("Event handler failed", "handler", s.name, "event", event.Type, "error", err)
+	}
+	return err
+}
+
+func (s *subscription) stats() HandlerStats {
+	stats := HandlerStats{
+		Name:      s.name,
+		Event:     s.eventType,
+		Configs:   s.configs,
+		Sync:      s.sync,
+		Overflow:  s.overflow,
+		Queued:    len(s.queue),
+		QueueSize: cap(s.queue),
+		Delivered: s.delivered.Load(),
+		Failed:    s.failed.Load(),
+		Dropped:   s.dropped.Load(),
+		Blocked:   s.blocked.Load(),
+	}
+	if s.spool != nil {
+		stats.Spooled = s.spool.pending()
+	}
+	return stats
+}
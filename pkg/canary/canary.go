@@ -0,0 +1,122 @@
+// Package canary applies the commits of one router to another first, such
+// as the standby of an HA pair or one router of a fleet, through its API.
+// The canary commits the changes pending confirmation, must report a good
+// health score after they settle, and is only confirmed once the first
+// router applied them too; otherwise it rolls them back.
+package canary
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/thesabbir/hellfire/pkg/client"
+	"github.com/thesabbir/hellfire/pkg/config"
+	"github.com/thesabbir/hellfire/pkg/logger"
+)
+
+// Config holds the canary's address, credentials and health criteria
+type Config struct {
+	URL        string // API base URL, e.g. "https://192.168.1.2:8888"
+	Username   string
+	Password   string
+	HTTPClient *http.Client // For a private CA; nil uses the default client
+
+	ConfirmTimeout time.Duration // Rolled back on the canary unless confirmed by then
+	Settle         time.Duration // Wait after the commit before checking health
+	MinScore       int           // Lowest acceptable health score (0-100)
+}
+
+// Peer is a canary router reached through its API
+type Peer struct {
+	cfg    Config
+	client *client.Client
+}
+
+// New creates a canary for the router at cfg.URL
+func New(cfg Config) *Peer {
+	var opts []client.Option
+	if cfg.HTTPClient != nil {
+		opts = append(opts, client.WithHTTPClient(cfg.HTTPClient))
+	}
+	return &Peer{cfg: cfg, client: client.New(cfg.URL, opts...)}
+}
+
+// Name identifies the canary by its URL
+func (p *Peer) Name() string {
+	return p.cfg.URL
+}
+
+// Apply stages ops on the canary, commits them pending confirmation and
+// waits for the canary to settle and report a good health score. Changes
+// that fail or leave it unhealthy are rolled back.
+func (p *Peer) Apply(ctx context.Context, message string, ops []config.Operation) (string, error) {
+	if _, err := p.client.Login(ctx, p.cfg.Username, p.cfg.Password); err != nil {
+		return "", fmt.Errorf("failed to log in: %w", err)
+	}
+
+	// Never commit someone else's staged changes along with ours
+	changes, err := p.client.Changes(ctx)
+	if err != nil {
+		return "", fmt.Errorf("failed to read staged changes: %w", err)
+	}
+	if changes.HasChanges {
+		return "", fmt.Errorf("it has staged changes of its own (%v)", changes.Configs)
+	}
+
+	for start := 0; start < len(ops); start += config.MaxBatchOperations {
+		end := min(start+config.MaxBatchOperations, len(ops))
+		if _, err := p.client.Batch(ctx, ops[start:end]); err != nil {
+			_, _ = p.client.Revert(ctx)
+			return "", fmt.Errorf("failed to stage changes: %w", err)
+		}
+	}
+
+	result, err := p.client.Commit(ctx, client.CommitRequest{
+		Message:        message,
+		ConfirmTimeout: int(p.cfg.ConfirmTimeout.Seconds()),
+	})
+	if err != nil {
+		// A failed commit is rolled back by the canary itself
+		_, _ = p.client.Revert(ctx)
+		return "", fmt.Errorf("commit failed: %w", err)
+	}
+
+	if err := p.checkHealth(ctx); err != nil {
+		if rollbackErr := p.Rollback(ctx); rollbackErr != nil {
+			logger.Warn("Failed to roll back the canary, it rolls back when its confirmation times out",
+				"canary", p.Name(), "error", rollbackErr)
+		}
+		return result.TransactionID, err
+	}
+	return result.TransactionID, nil
+}
+
+// checkHealth waits for the canary to settle and checks its health score
+func (p *Peer) checkHealth(ctx context.Context) error {
+	select {
+	case <-time.After(p.cfg.Settle):
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+
+	report, err := p.client.HealthScore(ctx)
+	if err != nil {
+		return fmt.Errorf("health check failed: %w", err)
+	}
+	if report.Score < p.cfg.MinScore || report.Status == "critical" {
+		return fmt.Errorf("unhealthy after the changes: score %d (%s), want at least %d", report.Score, report.Status, p.cfg.MinScore)
+	}
+	return nil
+}
+
+// Confirm keeps the changes on the canary
+func (p *Peer) Confirm(ctx context.Context) error {
+	return p.client.Confirm(ctx)
+}
+
+// Rollback undoes the changes on the canary
+func (p *Peer) Rollback(ctx context.Context) error {
+	return p.client.RollbackPendingWithReason(ctx, "rolled back by the router the canary applies changes for")
+}
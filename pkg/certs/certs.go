@@ -0,0 +1,396 @@
+// Package certs keeps an inventory of the certificates hellfire uses: the
+// ones referenced by its config (such as the gRPC server certificate) and the
+// ones uploaded to its certificate store. It records their subjects and
+// validity in the database, warns before they expire and validates uploads
+// and rotations before writing them.
+package certs
+
+import (
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/thesabbir/hellfire/pkg/bus"
+	"github.com/thesabbir/hellfire/pkg/db"
+	"github.com/thesabbir/hellfire/pkg/logger"
+)
+
+const (
+	// DefaultStoreDir holds uploaded certificates as <name>.crt and <name>.key
+	DefaultStoreDir = "/etc/hellfire/certs"
+
+	// DefaultCheckInterval is how often expiry dates are checked
+	DefaultCheckInterval = 24 * time.Hour
+
+	// UsageUploaded is the usage of certificates in the store
+	UsageUploaded = "uploaded"
+)
+
+var (
+	ErrNotFound = errors.New("certificate not found")
+	ErrExists   = errors.New("certificate already exists")
+	ErrInvalid  = errors.New("invalid certificate")
+
+	namePattern = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9_.-]{0,63}$`)
+
+	// mu serializes syncs, uploads and rotations, across inventories of the
+	// same files
+	mu sync.Mutex
+)
+
+// Reference is a certificate file hellfire uses, with its private key if it
+// has one (CA bundles don't)
+type Reference struct {
+	Name     string
+	Usage    string
+	CertFile string
+	KeyFile  string
+}
+
+// Info describes the first (leaf) certificate of a PEM file
+type Info struct {
+	Subject     string
+	Issuer      string
+	DNSNames    []string
+	Serial      string
+	Fingerprint string // SHA-256 of the DER encoding
+	NotBefore   time.Time
+	NotAfter    time.Time
+	IsCA        bool
+}
+
+// Inventory tracks the referenced and uploaded certificates
+type Inventory struct {
+	references func() []Reference // Config references, read on every sync
+	storeDir   string
+	warnBefore time.Duration
+
+	monitor sync.Once
+}
+
+// NewInventory creates an inventory of the config references and the
+// certificates in storeDir, warning warnBefore their expiry
+func NewInventory(references func() []Reference, storeDir string, warnBefore time.Duration) *Inventory {
+	return &Inventory{
+		references: references,
+		storeDir:   storeDir,
+		warnBefore: warnBefore,
+	}
+}
+
+// Sync inspects every certificate, records it in the database (forgetting
+// certificates that are no longer referenced) and returns the records
+func (inv *Inventory) Sync() ([]db.Certificate, error) {
+	mu.Lock()
+	defer mu.Unlock()
+	return inv.syncLocked(time.Now())
+}
+
+// Check syncs the inventory and publishes events for certificates that
+// expire within the warning period or have expired
+func (inv *Inventory) Check() error {
+	now := time.Now()
+
+	mu.Lock()
+	records, err := inv.syncLocked(now)
+	mu.Unlock()
+	if err != nil {
+		return err
+	}
+
+	for _, record := range records {
+		if record.Error != "" {
+			logger.Warn("Certificate can't be read", "name", record.Name, "path", record.Path, "error", record.Error)
+			continue
+		}
+
+		switch {
+		case now.After(record.NotAfter):
+			logger.Error("Certificate expired", "name", record.Name, "not_after", record.NotAfter)
+			bus.Publish(bus.Event{Type: bus.EventCertExpired, ConfigName: "hellfire", Data: record})
+		case record.NotAfter.Sub(now) < inv.warnBefore:
+			logger.Warn("Certificate expires soon",
+				"name", record.Name,
+				"not_after", record.NotAfter,
+				"days_left", int(record.NotAfter.Sub(now).Hours()/24))
+			bus.Publish(bus.Event{Type: bus.EventCertExpiring, ConfigName: "hellfire", Data: record})
+		}
+	}
+	return nil
+}
+
+// StartMonitor checks expiry dates now and then every interval
+func (inv *Inventory) StartMonitor(interval time.Duration) {
+	inv.monitor.Do(func() {
+		go func() {
+			ticker := time.NewTicker(interval)
+			defer ticker.Stop()
+
+			logger.Info("Started certificate expiry monitor",
+				"interval", interval,
+				"warn_before", inv.warnBefore)
+
+			for {
+				if err := inv.Check(); err != nil {
+					logger.Error("Failed to check certificates", "error", err)
+				}
+				<-ticker.C
+			}
+		}()
+	})
+}
+
+// Upload adds a certificate and its private key to the store
+func (inv *Inventory) Upload(name string, certPEM, keyPEM []byte) (*db.Certificate, error) {
+	if !namePattern.MatchString(name) {
+		return nil, fmt.Errorf("%w name %q (letters, digits, '.', '_' and '-')", ErrInvalid, name)
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+
+	all, err := inv.all()
+	if err != nil {
+		return nil, err
+	}
+	if slices.ContainsFunc(all, func(ref Reference) bool { return ref.Name == name }) {
+		return nil, fmt.Errorf("%w: %s", ErrExists, name)
+	}
+
+	ref := Reference{
+		Name:     name,
+		Usage:    UsageUploaded,
+		CertFile: filepath.Join(inv.storeDir, name+".crt"),
+		KeyFile:  filepath.Join(inv.storeDir, name+".key"),
+	}
+	if err := install(ref, certPEM, keyPEM); err != nil {
+		return nil, err
+	}
+	return inv.recordLocked(ref)
+}
+
+// Rotate replaces a certificate (and its key, if it has one) with new PEM
+// data. The previous files are kept with a .bak suffix.
+func (inv *Inventory) Rotate(name string, certPEM, keyPEM []byte) (*db.Certificate, error) {
+	mu.Lock()
+	defer mu.Unlock()
+
+	all, err := inv.all()
+	if err != nil {
+		return nil, err
+	}
+	i := slices.IndexFunc(all, func(ref Reference) bool { return ref.Name == name })
+	if i < 0 {
+		return nil, fmt.Errorf("%w: %s", ErrNotFound, name)
+	}
+	ref := all[i]
+
+	if err := install(ref, certPEM, keyPEM); err != nil {
+		return nil, err
+	}
+	return inv.recordLocked(ref)
+}
+
+// all returns the config references followed by the stored certificates
+func (inv *Inventory) all() ([]Reference, error) {
+	var refs []Reference
+	if inv.references != nil {
+		refs = append(refs, inv.references()...)
+	}
+
+	stored, err := filepath.Glob(filepath.Join(inv.storeDir, "*.crt"))
+	if err != nil {
+		return nil, err
+	}
+	for _, certFile := range stored {
+		name := strings.TrimSuffix(filepath.Base(certFile), ".crt")
+		if slices.ContainsFunc(refs, func(ref Reference) bool { return ref.Name == name }) {
+			continue
+		}
+		ref := Reference{Name: name, Usage: UsageUploaded, CertFile: certFile}
+		if keyFile := strings.TrimSuffix(certFile, ".crt") + ".key"; fileExists(keyFile) {
+			ref.KeyFile = keyFile
+		}
+		refs = append(refs, ref)
+	}
+	return refs, nil
+}
+
+func (inv *Inventory) syncLocked(now time.Time) ([]db.Certificate, error) {
+	refs, err := inv.all()
+	if err != nil {
+		return nil, err
+	}
+
+	records := make([]db.Certificate, 0, len(refs))
+	names := make([]string, 0, len(refs))
+	for _, ref := range refs {
+		record := newRecord(ref, now)
+		if err := db.SaveCertificate(&record); err != nil {
+			return nil, err
+		}
+		records = append(records, record)
+		names = append(names, ref.Name)
+	}
+
+	if err := db.DeleteCertificatesExcept(names); err != nil {
+		return nil, err
+	}
+	return records, nil
+}
+
+func (inv *Inventory) recordLocked(ref Reference) (*db.Certificate, error) {
+	record := newRecord(ref, time.Now())
+	if err := db.SaveCertificate(&record); err != nil {
+		return nil, err
+	}
+	return &record, nil
+}
+
+// newRecord inspects a referenced certificate for the database
+func newRecord(ref Reference, now time.Time) db.Certificate {
+	record := db.Certificate{
+		Name:        ref.Name,
+		Usage:       ref.Usage,
+		Path:        ref.CertFile,
+		KeyPath:     ref.KeyFile,
+		LastChecked: now,
+	}
+
+	info, err := Inspect(ref.CertFile)
+	if err != nil {
+		record.Error = err.Error()
+		return record
+	}
+	record.Subject = info.Subject
+	record.Issuer = info.Issuer
+	record.DNSNames = info.DNSNames
+	record.Serial = info.Serial
+	record.Fingerprint = info.Fingerprint
+	record.NotBefore = info.NotBefore
+	record.NotAfter = info.NotAfter
+	record.IsCA = info.IsCA
+	return record
+}
+
+// Inspect reads the first certificate of a PEM file
+func Inspect(path string) (*Info, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	cert, err := parseLeaf(data)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return newInfo(cert), nil
+}
+
+func newInfo(cert *x509.Certificate) *Info {
+	sum := sha256.Sum256(cert.Raw)
+	return &Info{
+		Subject:     cert.Subject.String(),
+		Issuer:      cert.Issuer.String(),
+		DNSNames:    cert.DNSNames,
+		Serial:      cert.SerialNumber.Text(16),
+		Fingerprint: hex.EncodeToString(sum[:]),
+		NotBefore:   cert.NotBefore,
+		NotAfter:    cert.NotAfter,
+		IsCA:        cert.IsCA,
+	}
+}
+
+// parseLeaf returns the first certificate in PEM data
+func parseLeaf(data []byte) (*x509.Certificate, error) {
+	for {
+		var block *pem.Block
+		block, data = pem.Decode(data)
+		if block == nil {
+			return nil, fmt.Errorf("no PEM certificate found")
+		}
+		if block.Type == "CERTIFICATE" {
+			return x509.ParseCertificate(block.Bytes)
+		}
+	}
+}
+
+// install validates new PEM data for a reference and writes it over the
+// current files, keeping them as .bak
+func install(ref Reference, certPEM, keyPEM []byte) error {
+	if err := validate(ref, certPEM, keyPEM); err != nil {
+		return fmt.Errorf("%w: %v", ErrInvalid, err)
+	}
+
+	// Write the key first: a server reloading on the certificate's change
+	// must find a matching key
+	if ref.KeyFile != "" {
+		if err := replaceFile(ref.KeyFile, keyPEM, 0600); err != nil {
+			return err
+		}
+	}
+	return replaceFile(ref.CertFile, certPEM, 0644)
+}
+
+// validate checks that new PEM data is a current certificate and, for a
+// reference with a key file, its matching key. References without one (CA
+// bundles) must not be given a key.
+func validate(ref Reference, certPEM, keyPEM []byte) error {
+	cert, err := parseLeaf(certPEM)
+	if err != nil {
+		return err
+	}
+	if time.Now().After(cert.NotAfter) {
+		return fmt.Errorf("certificate expired on %s", cert.NotAfter.Format(time.RFC3339))
+	}
+
+	switch {
+	case ref.KeyFile == "" && len(keyPEM) > 0:
+		return fmt.Errorf("certificate %s doesn't take a private key", ref.Name)
+	case ref.KeyFile != "" && len(keyPEM) == 0:
+		return fmt.Errorf("certificate %s needs a private key", ref.Name)
+	case ref.KeyFile != "":
+		if _, err := tls.X509KeyPair(certPEM, keyPEM); err != nil {
+			return fmt.Errorf("private key doesn't match the certificate: %w", err)
+		}
+	}
+	return nil
+}
+
+// replaceFile atomically writes data to path, keeping the previous file as path.bak
+func replaceFile(path string, data []byte, perm os.FileMode) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+
+	if current, err := os.ReadFile(path); err == nil {
+		if err := os.WriteFile(path+".bak", current, perm); err != nil {
+			return fmt.Errorf("failed to back up %s: %w", path, err)
+		}
+	}
+
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, perm); err != nil {
+		return fmt.Errorf("failed to write %s: %w", path, err)
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		os.Remove(tmp)
+		return fmt.Errorf("failed to write %s: %w", path, err)
+	}
+	return nil
+}
+
+func fileExists(path string) bool {
+	_, err := os.Stat(path)
+	return err == nil
+}
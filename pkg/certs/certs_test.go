@@ -0,0 +1,130 @@
+package certs
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/thesabbir/hellfire/pkg/bus"
+	"github.com/thesabbir/hellfire/pkg/db"
+)
+
+// selfSigned returns a PEM certificate and key valid until notAfter
+func selfSigned(t *testing.T, name string, notAfter time.Time) (certPEM, keyPEM []byte) {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: name},
+		DNSNames:     []string{name},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     notAfter,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}),
+		pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER})
+}
+
+func TestInventory(t *testing.T) {
+	dir := t.TempDir()
+	if err := db.Initialize(&db.Config{Path: filepath.Join(dir, "hellfire.db")}); err != nil {
+		t.Fatalf("init db: %v", err)
+	}
+
+	// A referenced CA bundle that expires soon
+	caFile := filepath.Join(dir, "ca.crt")
+	caPEM, _ := selfSigned(t, "ca.example", time.Now().Add(48*time.Hour))
+	if err := os.WriteFile(caFile, caPEM, 0644); err != nil {
+		t.Fatal(err)
+	}
+	refs := func() []Reference {
+		return []Reference{{Name: "client-ca", Usage: "grpc-client-ca", CertFile: caFile}}
+	}
+	inv := NewInventory(refs, filepath.Join(dir, "store"), 30*24*time.Hour)
+
+	certPEM, keyPEM := selfSigned(t, "router.example", time.Now().Add(365*24*time.Hour))
+	if _, err := inv.Upload("../escape", certPEM, keyPEM); !errors.Is(err, ErrInvalid) {
+		t.Errorf("expected an invalid name error, got %v", err)
+	}
+	_, otherKey := selfSigned(t, "other.example", time.Now().Add(time.Hour))
+	if _, err := inv.Upload("router", certPEM, otherKey); !errors.Is(err, ErrInvalid) {
+		t.Errorf("expected a key mismatch error, got %v", err)
+	}
+
+	uploaded, err := inv.Upload("router", certPEM, keyPEM)
+	if err != nil {
+		t.Fatalf("Upload: %v", err)
+	}
+	if uploaded.Subject != "CN=router.example" || len(uploaded.DNSNames) != 1 || uploaded.Fingerprint == "" {
+		t.Errorf("unexpected record: %+v", uploaded)
+	}
+	if info, err := os.Stat(uploaded.KeyPath); err != nil || info.Mode().Perm() != 0600 {
+		t.Errorf("key file: %v, %v", info, err)
+	}
+	if _, err := inv.Upload("router", certPEM, keyPEM); !errors.Is(err, ErrExists) {
+		t.Errorf("expected an exists error, got %v", err)
+	}
+
+	// CA bundles are rotated without a key; the old file is kept
+	newCA, _ := selfSigned(t, "ca2.example", time.Now().Add(365*24*time.Hour))
+	if _, err := inv.Rotate("client-ca", newCA, keyPEM); !errors.Is(err, ErrInvalid) {
+		t.Errorf("expected a key to be refused for a CA bundle, got %v", err)
+	}
+	if _, err := inv.Rotate("missing", newCA, nil); !errors.Is(err, ErrNotFound) {
+		t.Errorf("expected a not found error, got %v", err)
+	}
+
+	// The CA expires within the warning period
+	events, stop := bus.Watch(4)
+	defer stop()
+	if err := inv.Check(); err != nil {
+		t.Fatalf("Check: %v", err)
+	}
+	select {
+	case event := <-events:
+		if event.Type != bus.EventCertExpiring {
+			t.Errorf("event = %s, want %s", event.Type, bus.EventCertExpiring)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("no cert.expiring event")
+	}
+
+	rotated, err := inv.Rotate("client-ca", newCA, nil)
+	if err != nil {
+		t.Fatalf("Rotate: %v", err)
+	}
+	if rotated.Subject != "CN=ca2.example" {
+		t.Errorf("rotated subject = %s", rotated.Subject)
+	}
+	if backup, err := os.ReadFile(caFile + ".bak"); err != nil || string(backup) != string(caPEM) {
+		t.Errorf("backup not kept: %v", err)
+	}
+
+	records, err := inv.Sync()
+	if err != nil {
+		t.Fatalf("Sync: %v", err)
+	}
+	if len(records) != 2 || records[0].Name != "client-ca" || records[1].Name != "router" {
+		t.Errorf("unexpected records: %+v", records)
+	}
+}
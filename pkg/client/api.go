@@ -0,0 +1,867 @@
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/thesabbir/hellfire/pkg/dhcp"
+	"github.com/thesabbir/hellfire/pkg/dns"
+	"github.com/thesabbir/hellfire/pkg/graphql"
+	"github.com/thesabbir/hellfire/pkg/onboarding"
+	"github.com/thesabbir/hellfire/pkg/wireguard"
+)
+
+// Auth
+
+// Bootstrap returns system metadata, including whether the system has users yet
+func (c *Client) Bootstrap(ctx context.Context) (*BootstrapInfo, error) {
+	var result BootstrapInfo
+	if err := c.do(ctx, http.MethodGet, "/bootstrap", nil, &result); err != nil {
+		return nil, err
+	}
+	return &result, nil
+}
+
+// Login authenticates and stores the session token for later requests
+func (c *Client) Login(ctx context.Context, username, password string) (*LoginResponse, error) {
+	body := map[string]string{"username": username, "password": password}
+
+	var result LoginResponse
+	if err := c.do(ctx, http.MethodPost, "/auth/login", body, &result); err != nil {
+		return nil, err
+	}
+
+	c.SetToken(result.Token)
+	return &result, nil
+}
+
+// Logout ends the current session
+func (c *Client) Logout(ctx context.Context) error {
+	if err := c.do(ctx, http.MethodPost, "/auth/logout", nil, nil); err != nil {
+		return err
+	}
+
+	c.SetToken("")
+	return nil
+}
+
+// Me returns the authenticated user and their permissions
+func (c *Client) Me(ctx context.Context) (*MeResponse, error) {
+	var result MeResponse
+	if err := c.do(ctx, http.MethodGet, "/auth/me", nil, &result); err != nil {
+		return nil, err
+	}
+	return &result, nil
+}
+
+// UpdateProfile changes the current user's email address
+func (c *Client) UpdateProfile(ctx context.Context, email string) (*User, error) {
+	body := map[string]string{"email": email}
+
+	var result User
+	if err := c.do(ctx, http.MethodPut, "/auth/me", body, &result); err != nil {
+		return nil, err
+	}
+	return &result, nil
+}
+
+// ChangePassword changes the current user's password, logging out their
+// other sessions; this client's session stays valid
+func (c *Client) ChangePassword(ctx context.Context, currentPassword, newPassword string) error {
+	body := map[string]string{"current_password": currentPassword, "new_password": newPassword}
+	return c.do(ctx, http.MethodPut, "/auth/me/password", body, nil)
+}
+
+// Impersonate opens a session acting as another user (admin only) for
+// duration (zero for the server default). The client keeps its own session;
+// use the returned token with a new client to act as the user.
+func (c *Client) Impersonate(ctx context.Context, username string, duration time.Duration) (*ImpersonateResponse, error) {
+	body := map[string]interface{}{"username": username, "duration": int(duration.Seconds())}
+
+	var result ImpersonateResponse
+	if err := c.do(ctx, http.MethodPost, "/auth/impersonate", body, &result); err != nil {
+		return nil, err
+	}
+	return &result, nil
+}
+
+// Devices lists the devices the current user has logged in from
+func (c *Client) Devices(ctx context.Context) ([]KnownDevice, error) {
+	var result []KnownDevice
+	if err := c.do(ctx, http.MethodGet, "/auth/devices", nil, &result); err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// RevokeDevice forgets a device of the current user and logs out its sessions
+func (c *Client) RevokeDevice(ctx context.Context, id uint) error {
+	return c.do(ctx, http.MethodDelete, "/auth/devices/"+strconv.FormatUint(uint64(id), 10), nil, nil)
+}
+
+// Config
+
+// GetConfig returns a whole config file (e.g. "network")
+func (c *Client) GetConfig(ctx context.Context, name string) (Config, error) {
+	var result Config
+	if err := c.do(ctx, http.MethodGet, "/config/"+url.PathEscape(name), nil, &result); err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// GetConfigRevision returns a configuration with its revision, to make
+// changes with SetOptionIf or BatchIf that fail if it changed meanwhile
+func (c *Client) GetConfigRevision(ctx context.Context, name string) (Config, string, error) {
+	var result Config
+	w := &withHeader{out: &result}
+	if err := c.do(ctx, http.MethodGet, "/config/"+url.PathEscape(name), nil, w); err != nil {
+		return nil, "", err
+	}
+	return result, w.header.Get("ETag"), nil
+}
+
+// GetSection returns a section by name or type
+func (c *Client) GetSection(ctx context.Context, name, section string) (Section, error) {
+	var result Section
+	if err := c.do(ctx, http.MethodGet, "/config/"+url.PathEscape(name)+"/"+url.PathEscape(section), nil, &result); err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// GetOption returns a single option value
+func (c *Client) GetOption(ctx context.Context, name, section, option string) (string, error) {
+	var result struct {
+		Value string `json:"value"`
+	}
+	if err := c.do(ctx, http.MethodGet, optionPath(name, section, option), nil, &result); err != nil {
+		return "", err
+	}
+	return result.Value, nil
+}
+
+// SetOption stages an option value; Commit applies it
+func (c *Client) SetOption(ctx context.Context, name, section, option, value string) (*SetOptionResponse, error) {
+	return c.SetOptionIf(ctx, name, section, option, value, "")
+}
+
+// SetOptionIf stages an option value provided the config is still at
+// revision; otherwise it fails with a 409 *APIError. An empty revision
+// always matches.
+func (c *Client) SetOptionIf(ctx context.Context, name, section, option, value, revision string) (*SetOptionResponse, error) {
+	body := map[string]string{"value": value}
+	var headers http.Header
+	if revision != "" {
+		headers = http.Header{"If-Match": []string{revision}}
+	}
+
+	var result SetOptionResponse
+	w := &withHeader{out: &result}
+	if err := c.doWithHeaders(ctx, http.MethodPut, optionPath(name, section, option), headers, body, w); err != nil {
+		return nil, err
+	}
+	result.Revision = w.header.Get("ETag")
+	return &result, nil
+}
+
+// Batch stages a list of operations atomically. A rejected operation is
+// reported as an *APIError with Operation set to its index.
+func (c *Client) Batch(ctx context.Context, ops []Operation) (*BatchResponse, error) {
+	return c.BatchIf(ctx, ops, nil)
+}
+
+// BatchIf stages operations like Batch, provided each config in revisions
+// is still at its revision; otherwise nothing is staged and it fails with a
+// 409 *APIError.
+func (c *Client) BatchIf(ctx context.Context, ops []Operation, revisions map[string]string) (*BatchResponse, error) {
+	body := map[string]interface{}{"operations": ops}
+	if len(revisions) > 0 {
+		body["revisions"] = revisions
+	}
+
+	var result BatchResponse
+	if err := c.do(ctx, http.MethodPost, "/config/batch", body, &result); err != nil {
+		return nil, err
+	}
+	return &result, nil
+}
+
+// ConfigLocks lists the locks held on configs
+func (c *Client) ConfigLocks(ctx context.Context) ([]ConfigLock, error) {
+	var result struct {
+		Locks []ConfigLock `json:"locks"`
+	}
+	if err := c.do(ctx, http.MethodGet, "/config/locks", nil, &result); err != nil {
+		return nil, err
+	}
+	return result.Locks, nil
+}
+
+// LockConfig takes an advisory lock on a config for ttl (0 for the server's
+// default), or renews the caller's lock. If someone else holds it, it fails
+// with a 409 *APIError.
+func (c *Client) LockConfig(ctx context.Context, name string, ttl time.Duration, note string) (*ConfigLock, error) {
+	body := map[string]interface{}{"ttl": int(ttl.Seconds()), "note": note}
+
+	var result ConfigLock
+	if err := c.do(ctx, http.MethodPut, "/config/"+url.PathEscape(name)+"/lock", body, &result); err != nil {
+		return nil, err
+	}
+	return &result, nil
+}
+
+// UnlockConfig releases the caller's lock on a config; admins can break
+// the locks of others
+func (c *Client) UnlockConfig(ctx context.Context, name string) error {
+	return c.do(ctx, http.MethodDelete, "/config/"+url.PathEscape(name)+"/lock", nil, nil)
+}
+
+// Changes lists the configs with staged changes
+func (c *Client) Changes(ctx context.Context) (*ChangesResponse, error) {
+	var result ChangesResponse
+	if err := c.do(ctx, http.MethodGet, "/config/changes", nil, &result); err != nil {
+		return nil, err
+	}
+	return &result, nil
+}
+
+// Commit applies staged changes as a transaction
+func (c *Client) Commit(ctx context.Context, req CommitRequest) (*CommitResponse, error) {
+	var headers http.Header
+	if req.IdempotencyKey != "" {
+		headers = http.Header{"Idempotency-Key": []string{req.IdempotencyKey}}
+	}
+
+	var result CommitResponse
+	if err := c.doWithHeaders(ctx, http.MethodPost, "/config/commit", headers, req, &result); err != nil {
+		return nil, err
+	}
+
+	// A keepalive over a new request proves the router is still reachable
+	// after the apply
+	if result.Keepalive > 0 && req.ConfirmTimeout == 0 {
+		keepalive, err := c.Keepalive(ctx)
+		if err != nil {
+			return &result, fmt.Errorf("changes applied but not confirmed, they will be rolled back: %w", err)
+		}
+		result.Message = keepalive.Message
+		result.State = "completed"
+	}
+	return &result, nil
+}
+
+// DryRun reports what committing the staged changes would do, without
+// changing anything
+func (c *Client) DryRun(ctx context.Context) (*DryRunResponse, error) {
+	var result DryRunResponse
+	if err := c.do(ctx, http.MethodPost, "/config/commit", CommitRequest{DryRun: true}, &result); err != nil {
+		return nil, err
+	}
+	return &result, nil
+}
+
+// Confirm confirms a pending transaction so it is not rolled back
+func (c *Client) Confirm(ctx context.Context) error {
+	return c.do(ctx, http.MethodPost, "/config/confirm", nil, nil)
+}
+
+// ConfigGeneration returns the config generation, which every commit and
+// rollback bumps
+func (c *Client) ConfigGeneration(ctx context.Context) (uint64, error) {
+	var result struct {
+		Generation uint64 `json:"generation"`
+	}
+	if err := c.do(ctx, http.MethodGet, "/config/generation", nil, &result); err != nil {
+		return 0, err
+	}
+	return result.Generation, nil
+}
+
+// RollbackPending rolls back a transaction awaiting confirmation
+func (c *Client) RollbackPending(ctx context.Context) error {
+	return c.RollbackPendingWithReason(ctx, "")
+}
+
+// RollbackPendingWithReason rolls back a transaction awaiting confirmation,
+// recording why on it
+func (c *Client) RollbackPendingWithReason(ctx context.Context, reason string) error {
+	var body interface{}
+	if reason != "" {
+		body = map[string]string{"reason": reason}
+	}
+	return c.do(ctx, http.MethodPost, "/config/rollback", body, nil)
+}
+
+// Keepalive tells a pending safe remote commit that this client still
+// reaches the router
+func (c *Client) Keepalive(ctx context.Context) (*KeepaliveResponse, error) {
+	var result KeepaliveResponse
+	if err := c.do(ctx, http.MethodPost, "/config/keepalive", nil, &result); err != nil {
+		return nil, err
+	}
+	return &result, nil
+}
+
+// Revert discards all staged changes
+func (c *Client) Revert(ctx context.Context) (*RevertResponse, error) {
+	var result RevertResponse
+	if err := c.do(ctx, http.MethodPost, "/config/revert", nil, &result); err != nil {
+		return nil, err
+	}
+	return &result, nil
+}
+
+// Validate checks staged changes without applying them
+func (c *Client) Validate(ctx context.Context) (*ValidateResponse, error) {
+	var result ValidateResponse
+	if err := c.do(ctx, http.MethodPost, "/config/validate", nil, &result); err != nil {
+		return nil, err
+	}
+	return &result, nil
+}
+
+// Snapshots and transactions
+
+// ListSnapshots lists snapshots, newest first
+func (c *Client) ListSnapshots(ctx context.Context) (*SnapshotList, error) {
+	var result SnapshotList
+	if err := c.do(ctx, http.MethodGet, "/snapshots", nil, &result); err != nil {
+		return nil, err
+	}
+	return &result, nil
+}
+
+// StageSnapshot stages the contents of a snapshot for review and commit
+func (c *Client) StageSnapshot(ctx context.Context, id string) (*StageSnapshotResponse, error) {
+	var result StageSnapshotResponse
+	if err := c.do(ctx, http.MethodPost, "/snapshots/"+url.PathEscape(id)+"/stage", nil, &result); err != nil {
+		return nil, err
+	}
+	return &result, nil
+}
+
+// RollbackSnapshot restores and applies a snapshot as a new transaction
+func (c *Client) RollbackSnapshot(ctx context.Context, id string) (*RollbackSnapshotResponse, error) {
+	return c.RollbackSnapshotWithReason(ctx, id, "")
+}
+
+// RollbackSnapshotWithReason restores and applies a snapshot as a new
+// transaction, recording why on it
+func (c *Client) RollbackSnapshotWithReason(ctx context.Context, id, reason string) (*RollbackSnapshotResponse, error) {
+	var body interface{}
+	if reason != "" {
+		body = map[string]string{"reason": reason}
+	}
+
+	var result RollbackSnapshotResponse
+	if err := c.do(ctx, http.MethodPost, "/snapshots/"+url.PathEscape(id)+"/rollback", body, &result); err != nil {
+		return nil, err
+	}
+	return &result, nil
+}
+
+// ProtectSnapshot protects a snapshot from automatic pruning, or lets it be
+// pruned again
+func (c *Client) ProtectSnapshot(ctx context.Context, id string, protected bool) (*SnapshotMetadata, error) {
+	method := http.MethodPost
+	if !protected {
+		method = http.MethodDelete
+	}
+
+	var result SnapshotMetadata
+	if err := c.do(ctx, method, "/snapshots/"+url.PathEscape(id)+"/protect", nil, &result); err != nil {
+		return nil, err
+	}
+	return &result, nil
+}
+
+// Timeline returns the timeline of a transaction
+func (c *Client) Timeline(ctx context.Context, txID string) (*TransactionTimeline, error) {
+	var result TransactionTimeline
+	if err := c.do(ctx, http.MethodGet, "/transactions/"+url.PathEscape(txID)+"/timeline", nil, &result); err != nil {
+		return nil, err
+	}
+	return &result, nil
+}
+
+// TransactionArtifacts returns what a transaction pushed to the system
+func (c *Client) TransactionArtifacts(ctx context.Context, txID string) (*TransactionArtifacts, error) {
+	var result TransactionArtifacts
+	if err := c.do(ctx, http.MethodGet, "/transactions/"+url.PathEscape(txID)+"/artifacts", nil, &result); err != nil {
+		return nil, err
+	}
+	return &result, nil
+}
+
+// GraphQL
+
+// GraphQL runs a query against the GraphQL endpoint and decodes its data
+// into out. Errors the server reports for the query or its fields are
+// returned as GraphQLErrors, after the data of the fields that resolved is
+// decoded.
+func (c *Client) GraphQL(ctx context.Context, query string, variables map[string]any, out interface{}) error {
+	var result struct {
+		Data   json.RawMessage `json:"data"`
+		Errors GraphQLErrors   `json:"errors"`
+	}
+	if err := c.do(ctx, http.MethodPost, "/graphql", graphql.Request{Query: query, Variables: variables}, &result); err != nil {
+		return err
+	}
+	if len(result.Data) > 0 && out != nil {
+		if err := json.Unmarshal(result.Data, out); err != nil {
+			return fmt.Errorf("failed to decode response: %w", err)
+		}
+	}
+	if len(result.Errors) > 0 {
+		return result.Errors
+	}
+	return nil
+}
+
+// System
+
+// GetApplyOrder returns the apply order, skip-list and registered appliers
+func (c *Client) GetApplyOrder(ctx context.Context) (*ApplyOrder, error) {
+	var result ApplyOrder
+	if err := c.do(ctx, http.MethodGet, "/system/apply-order", nil, &result); err != nil {
+		return nil, err
+	}
+	return &result, nil
+}
+
+// SetApplyOrder updates and persists the apply order and skip-list
+func (c *Client) SetApplyOrder(ctx context.Context, order, skip []string) (*ApplyOrder, error) {
+	body := map[string][]string{"order": order, "skip": skip}
+
+	var result ApplyOrder
+	if err := c.do(ctx, http.MethodPut, "/system/apply-order", body, &result); err != nil {
+		return nil, err
+	}
+	return &result, nil
+}
+
+// Degraded reports whether the system is in safe mode after a failed rollback
+func (c *Client) Degraded(ctx context.Context) (*DegradedStatus, error) {
+	var result DegradedStatus
+	if err := c.do(ctx, http.MethodGet, "/system/degraded", nil, &result); err != nil {
+		return nil, err
+	}
+	return &result, nil
+}
+
+// ClearDegraded leaves safe mode once a working configuration is restored
+func (c *Client) ClearDegraded(ctx context.Context) error {
+	return c.do(ctx, http.MethodDelete, "/system/degraded", nil, nil)
+}
+
+// DHCPClients lists the supervised DHCP clients and their leases
+func (c *Client) DHCPClients(ctx context.Context) ([]DHCPClientStatus, error) {
+	var result struct {
+		Clients []DHCPClientStatus `json:"clients"`
+	}
+	if err := c.do(ctx, http.MethodGet, "/system/dhcp-clients", nil, &result); err != nil {
+		return nil, err
+	}
+	return result.Clients, nil
+}
+
+// HealthScore returns the composite health score and its checks
+func (c *Client) HealthScore(ctx context.Context) (*HealthReport, error) {
+	var result HealthReport
+	if err := c.do(ctx, http.MethodGet, "/health/score", nil, &result); err != nil {
+		return nil, err
+	}
+	return &result, nil
+}
+
+// StatsSummary returns the activity of the last days (the server default
+// of 30 if zero): transactions per day, audit actions, failed logins and
+// rollbacks
+func (c *Client) StatsSummary(ctx context.Context, days int) (*StatsSummary, error) {
+	path := "/stats/summary"
+	if days > 0 {
+		path += "?days=" + strconv.Itoa(days)
+	}
+
+	var result StatsSummary
+	if err := c.do(ctx, http.MethodGet, path, nil, &result); err != nil {
+		return nil, err
+	}
+	return &result, nil
+}
+
+// Quotas lists the per-device bandwidth quotas and their usage
+func (c *Client) Quotas(ctx context.Context) ([]QuotaStatus, error) {
+	var result struct {
+		Quotas []QuotaStatus `json:"quotas"`
+	}
+	if err := c.do(ctx, http.MethodGet, "/system/quotas", nil, &result); err != nil {
+		return nil, err
+	}
+	return result.Quotas, nil
+}
+
+// Links lists the network links with their carrier state and the recent
+// link events, newest first
+func (c *Client) Links(ctx context.Context) (*LinksResponse, error) {
+	var result LinksResponse
+	if err := c.do(ctx, http.MethodGet, "/system/links", nil, &result); err != nil {
+		return nil, err
+	}
+	return &result, nil
+}
+
+// Storage returns the disk usage of the snapshots and of the file systems
+// holding the router's data
+func (c *Client) Storage(ctx context.Context) (*StorageResponse, error) {
+	var result StorageResponse
+	if err := c.do(ctx, http.MethodGet, "/system/storage", nil, &result); err != nil {
+		return nil, err
+	}
+	return &result, nil
+}
+
+// WANQuality lists the WAN quality samples of the last since (the server
+// default of 24 hours if zero) and the current state of each ping target
+func (c *Client) WANQuality(ctx context.Context, since time.Duration) (*WANQuality, error) {
+	path := "/wan/quality"
+	if since > 0 {
+		path += "?since=" + url.QueryEscape(since.String())
+	}
+
+	var result WANQuality
+	if err := c.do(ctx, http.MethodGet, path, nil, &result); err != nil {
+		return nil, err
+	}
+	return &result, nil
+}
+
+// Certificates lists the certificates the server uses or stores, soonest expiry first
+func (c *Client) Certificates(ctx context.Context) ([]Certificate, error) {
+	var result struct {
+		Certificates []Certificate `json:"certificates"`
+	}
+	if err := c.do(ctx, http.MethodGet, "/certs", nil, &result); err != nil {
+		return nil, err
+	}
+	return result.Certificates, nil
+}
+
+// UploadCertificate adds a certificate and its private key (both PEM) to the
+// server's certificate store
+func (c *Client) UploadCertificate(ctx context.Context, name, certPEM, keyPEM string) (*Certificate, error) {
+	body := map[string]string{"name": name, "certificate": certPEM, "private_key": keyPEM}
+
+	var result Certificate
+	if err := c.do(ctx, http.MethodPost, "/certs", body, &result); err != nil {
+		return nil, err
+	}
+	return &result, nil
+}
+
+// RotateCertificate replaces a certificate; keyPEM is empty for CA bundles
+func (c *Client) RotateCertificate(ctx context.Context, name, certPEM, keyPEM string) (*Certificate, error) {
+	body := map[string]string{"certificate": certPEM, "private_key": keyPEM}
+
+	var result Certificate
+	if err := c.do(ctx, http.MethodPut, "/certs/"+url.PathEscape(name), body, &result); err != nil {
+		return nil, err
+	}
+	return &result, nil
+}
+
+// Upgrade returns the last system upgrade
+func (c *Client) Upgrade(ctx context.Context) (*UpgradeState, error) {
+	var result UpgradeState
+	if err := c.do(ctx, http.MethodGet, "/system/upgrade", nil, &result); err != nil {
+		return nil, err
+	}
+	return &result, nil
+}
+
+// StartUpgrade starts a system upgrade and returns its ID; follow it with Upgrade
+func (c *Client) StartUpgrade(ctx context.Context, opts UpgradeOptions) (string, error) {
+	var result struct {
+		ID string `json:"id"`
+	}
+	if err := c.do(ctx, http.MethodPost, "/system/upgrade", opts, &result); err != nil {
+		return "", err
+	}
+	return result.ID, nil
+}
+
+// RollbackUpgrade restores the configs from before the last upgrade
+func (c *Client) RollbackUpgrade(ctx context.Context) (*UpgradeState, error) {
+	var result UpgradeState
+	if err := c.do(ctx, http.MethodPost, "/system/upgrade/rollback", nil, &result); err != nil {
+		return nil, err
+	}
+	return &result, nil
+}
+
+// Logs lists the most recent system log messages, newest first. query
+// holds the filters: app, host, facility, severity, since, after, q, limit.
+func (c *Client) Logs(ctx context.Context, query url.Values) (*LogsResponse, error) {
+	path := "/logs"
+	if len(query) > 0 {
+		path += "?" + query.Encode()
+	}
+
+	var result LogsResponse
+	if err := c.do(ctx, http.MethodGet, path, nil, &result); err != nil {
+		return nil, err
+	}
+	return &result, nil
+}
+
+// Tasks lists the scheduled tasks with their next and last runs
+func (c *Client) Tasks(ctx context.Context) ([]Task, error) {
+	var result struct {
+		Tasks []Task `json:"tasks"`
+	}
+	if err := c.do(ctx, http.MethodGet, "/tasks", nil, &result); err != nil {
+		return nil, err
+	}
+	return result.Tasks, nil
+}
+
+// TaskRuns lists the runs of a scheduled task, newest first
+func (c *Client) TaskRuns(ctx context.Context, name string, limit int) ([]TaskRun, error) {
+	var result struct {
+		Runs []TaskRun `json:"runs"`
+	}
+	path := "/tasks/" + url.PathEscape(name) + "/runs?limit=" + strconv.Itoa(limit)
+	if err := c.do(ctx, http.MethodGet, path, nil, &result); err != nil {
+		return nil, err
+	}
+	return result.Runs, nil
+}
+
+// RunTask starts a scheduled task now; follow it with TaskRuns
+func (c *Client) RunTask(ctx context.Context, name string) error {
+	return c.do(ctx, http.MethodPost, "/tasks/"+url.PathEscape(name)+"/run", nil, nil)
+}
+
+// NetworkDevices lists the physical network interfaces, or all of them,
+// and whether the network config manages them
+func (c *Client) NetworkDevices(ctx context.Context, all bool) ([]NetworkDevice, error) {
+	var result struct {
+		Devices []NetworkDevice `json:"devices"`
+	}
+	if err := c.do(ctx, http.MethodGet, "/network/devices?all="+strconv.FormatBool(all), nil, &result); err != nil {
+		return nil, err
+	}
+	return result.Devices, nil
+}
+
+// Modems lists the cellular modems with their signal and registration, and
+// the modem interfaces whose modem is missing
+func (c *Client) Modems(ctx context.Context) (*ModemsResponse, error) {
+	var result ModemsResponse
+	if err := c.do(ctx, http.MethodGet, "/network/modems", nil, &result); err != nil {
+		return nil, err
+	}
+	return &result, nil
+}
+
+// WireGuard
+
+// WireGuardStatus returns the WireGuard interfaces with the state of their peers
+func (c *Client) WireGuardStatus(ctx context.Context) (*WireGuardStatus, error) {
+	var result WireGuardStatus
+	if err := c.do(ctx, http.MethodGet, "/wireguard/status", nil, &result); err != nil {
+		return nil, err
+	}
+	return &result, nil
+}
+
+// GenerateWireGuardKeys generates a key pair on the server, and a preshared
+// key if asked, without storing them
+func (c *Client) GenerateWireGuardKeys(ctx context.Context, preshared bool) (*WireGuardKeys, error) {
+	var result WireGuardKeys
+	if err := c.do(ctx, http.MethodPost, "/wireguard/keys?preshared="+strconv.FormatBool(preshared), nil, &result); err != nil {
+		return nil, err
+	}
+	return &result, nil
+}
+
+// GeneratePeerKeys stages a new key pair (and preshared key) for a peer of
+// a WireGuard interface and returns its public key
+func (c *Client) GeneratePeerKeys(ctx context.Context, iface, peer string, preshared bool) (string, error) {
+	var result struct {
+		PublicKey string `json:"public_key"`
+	}
+	path := "/wireguard/" + url.PathEscape(iface) + "/peers/" + url.PathEscape(peer) + "/keys?preshared=" + strconv.FormatBool(preshared)
+	if err := c.do(ctx, http.MethodPost, path, nil, &result); err != nil {
+		return "", err
+	}
+	return result.PublicKey, nil
+}
+
+// PeerConfig returns the wg-quick client config of a peer
+func (c *Client) PeerConfig(ctx context.Context, iface, peer string, opts wireguard.ClientOptions) (*WireGuardClientConfig, error) {
+	query := url.Values{"endpoint": {opts.Endpoint}}
+	if len(opts.DNS) > 0 {
+		query.Set("dns", strings.Join(opts.DNS, ","))
+	}
+	if len(opts.AllowedIPs) > 0 {
+		query.Set("allowed_ips", strings.Join(opts.AllowedIPs, ","))
+	}
+
+	var result WireGuardClientConfig
+	path := "/wireguard/" + url.PathEscape(iface) + "/peers/" + url.PathEscape(peer) + "/config?" + query.Encode()
+	if err := c.do(ctx, http.MethodGet, path, nil, &result); err != nil {
+		return nil, err
+	}
+	return &result, nil
+}
+
+// DHCP
+
+// DHCPPools returns the utilization of the DHCP pools
+func (c *Client) DHCPPools(ctx context.Context) ([]dhcp.Usage, error) {
+	var result struct {
+		Pools []dhcp.Usage `json:"pools"`
+	}
+	if err := c.do(ctx, http.MethodGet, "/dhcp/pools", nil, &result); err != nil {
+		return nil, err
+	}
+	return result.Pools, nil
+}
+
+// DNS
+
+// DNS returns the upstream servers, local zones and local records
+func (c *Client) DNS(ctx context.Context) (*DNSSettings, error) {
+	var result DNSSettings
+	if err := c.do(ctx, http.MethodGet, "/dns", nil, &result); err != nil {
+		return nil, err
+	}
+	return &result, nil
+}
+
+// DNSRecords lists the locally answered DNS records
+func (c *Client) DNSRecords(ctx context.Context) ([]dns.Record, error) {
+	var result struct {
+		Records []dns.Record `json:"records"`
+	}
+	if err := c.do(ctx, http.MethodGet, "/dns/records", nil, &result); err != nil {
+		return nil, err
+	}
+	return result.Records, nil
+}
+
+// AddDNSRecord stages a new DNS record and returns it with its ID
+func (c *Client) AddDNSRecord(ctx context.Context, record dns.Record) (*dns.Record, error) {
+	var result DNSRecordResult
+	if err := c.do(ctx, http.MethodPost, "/dns/records", dnsRecordBody(record), &result); err != nil {
+		return nil, err
+	}
+	return &result.Record, nil
+}
+
+// UpdateDNSRecord stages changes to the DNS record with an ID
+func (c *Client) UpdateDNSRecord(ctx context.Context, id string, record dns.Record) (*dns.Record, error) {
+	var result DNSRecordResult
+	if err := c.do(ctx, http.MethodPut, "/dns/records/"+url.PathEscape(id), dnsRecordBody(record), &result); err != nil {
+		return nil, err
+	}
+	return &result.Record, nil
+}
+
+// DeleteDNSRecord stages the removal of the DNS record with an ID
+func (c *Client) DeleteDNSRecord(ctx context.Context, id string) error {
+	return c.do(ctx, http.MethodDelete, "/dns/records/"+url.PathEscape(id), nil, nil)
+}
+
+// DNSHosts returns the hosts entries resolved locally, including the static
+// leases registered under the local domain
+func (c *Client) DNSHosts(ctx context.Context) (*DNSHosts, error) {
+	var result DNSHosts
+	if err := c.do(ctx, http.MethodGet, "/dns/hosts", nil, &result); err != nil {
+		return nil, err
+	}
+	return &result, nil
+}
+
+// AddDNSHost stages a hosts entry and returns its record
+func (c *Client) AddDNSHost(ctx context.Context, name, address string) (*dns.Record, error) {
+	body := map[string]string{"name": name, "address": address}
+	var result DNSRecordResult
+	if err := c.do(ctx, http.MethodPost, "/dns/hosts", body, &result); err != nil {
+		return nil, err
+	}
+	return &result.Record, nil
+}
+
+// DeleteDNSHost stages the removal of the hosts entry with an ID
+func (c *Client) DeleteDNSHost(ctx context.Context, id string) error {
+	return c.do(ctx, http.MethodDelete, "/dns/hosts/"+url.PathEscape(id), nil, nil)
+}
+
+// DNSStats returns the statistics of the queries dnsmasq logged. query
+// holds the filters: since, limit.
+func (c *Client) DNSStats(ctx context.Context, query url.Values) (*DNSStats, error) {
+	path := "/dns/stats"
+	if len(query) > 0 {
+		path += "?" + query.Encode()
+	}
+
+	var result DNSStats
+	if err := c.do(ctx, http.MethodGet, path, nil, &result); err != nil {
+		return nil, err
+	}
+	return &result, nil
+}
+
+// dnsRecordBody is the request body for a record
+func dnsRecordBody(record dns.Record) map[string]string {
+	return map[string]string{"type": record.Type, "name": record.Name, "value": record.Value}
+}
+
+// Onboarding
+
+// OnboardingNetwork detects the interfaces and proposes a network setup
+func (c *Client) OnboardingNetwork(ctx context.Context) (*OnboardingNetwork, error) {
+	var result OnboardingNetwork
+	if err := c.do(ctx, http.MethodGet, "/onboarding/network", nil, &result); err != nil {
+		return nil, err
+	}
+	return &result, nil
+}
+
+// PreviewNetworkSetup validates a network setup and returns the configs it
+// would commit
+func (c *Client) PreviewNetworkSetup(ctx context.Context, setup onboarding.Setup) (*OnboardingPreview, error) {
+	var result OnboardingPreview
+	if err := c.do(ctx, http.MethodPost, "/onboarding/network/preview", setup, &result); err != nil {
+		return nil, err
+	}
+	return &result, nil
+}
+
+// ApplyNetworkSetup commits a network setup; it is rolled back unless
+// confirmed with Confirm within confirmTimeout seconds (0 for the default)
+func (c *Client) ApplyNetworkSetup(ctx context.Context, setup onboarding.Setup, confirmTimeout int) (*OnboardingResult, error) {
+	body := struct {
+		onboarding.Setup
+		ConfirmTimeout int `json:"confirm_timeout,omitempty"`
+	}{setup, confirmTimeout}
+
+	var result OnboardingResult
+	if err := c.do(ctx, http.MethodPost, "/onboarding/network", body, &result); err != nil {
+		return nil, err
+	}
+	return &result, nil
+}
+
+func optionPath(name, section, option string) string {
+	return "/config/" + url.PathEscape(name) + "/" + url.PathEscape(section) + "/" + url.PathEscape(option)
+}
@@ -0,0 +1,283 @@
+// Package client is a typed Go client for the Hellfire REST API (/api/v1).
+// It handles session authentication and CSRF tokens so callers only deal
+// with requests and typed responses.
+package client
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/thesabbir/hellfire/pkg/integrity"
+)
+
+const (
+	// APIPrefix is the path prefix of the API version this client speaks
+	APIPrefix = "/api/v1"
+
+	// APIVersion is sent in the API-Version header of every request
+	APIVersion = "1"
+
+	// DefaultTimeout is the HTTP timeout used when no client is supplied
+	DefaultTimeout = 60 * time.Second
+
+	// csrfRefreshMargin renews the CSRF token this long before it expires
+	csrfRefreshMargin = 1 * time.Minute
+)
+
+// Client talks to a Hellfire API server
+type Client struct {
+	baseURL    string
+	httpClient *http.Client
+	userAgent  string
+
+	mu          sync.Mutex
+	token       string
+	csrfToken   string
+	csrfExpires time.Time
+}
+
+// Option configures a Client
+type Option func(*Client)
+
+// WithHTTPClient sets the underlying HTTP client (e.g. for custom TLS)
+func WithHTTPClient(httpClient *http.Client) Option {
+	return func(c *Client) {
+		c.httpClient = httpClient
+	}
+}
+
+// WithToken sets a session token obtained earlier from Login
+func WithToken(token string) Option {
+	return func(c *Client) {
+		c.token = token
+	}
+}
+
+// WithUserAgent sets the User-Agent header. Sessions are bound to the
+// client's IP and user agent, so it must stay stable for a session.
+func WithUserAgent(userAgent string) Option {
+	return func(c *Client) {
+		c.userAgent = userAgent
+	}
+}
+
+// New creates a client for the server at baseURL (e.g. "http://192.168.1.1:8888")
+func New(baseURL string, opts ...Option) *Client {
+	c := &Client{
+		baseURL:    strings.TrimSuffix(baseURL, "/") + APIPrefix,
+		httpClient: &http.Client{Timeout: DefaultTimeout},
+		userAgent:  "hellfire-client",
+	}
+
+	for _, opt := range opts {
+		opt(c)
+	}
+
+	return c
+}
+
+// Token returns the current session token
+func (c *Client) Token() string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.token
+}
+
+// SetToken replaces the session token
+func (c *Client) SetToken(token string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.token = token
+}
+
+// APIError is returned for non-2xx responses
+type APIError struct {
+	StatusCode int
+	Code       string // Machine-readable error code, if the server sent one (e.g. "csrf_invalid")
+	Message    string
+	Operation  *int              // Index of the rejected operation for batch requests
+	Issues     []integrity.Issue // Broken config references that failed a commit
+}
+
+func (e *APIError) Error() string {
+	if e.Operation != nil {
+		return fmt.Sprintf("hellfire api: %s (status %d, operation %d)", e.Message, e.StatusCode, *e.Operation)
+	}
+	return fmt.Sprintf("hellfire api: %s (status %d)", e.Message, e.StatusCode)
+}
+
+// IsStatus reports whether err is an APIError with the given HTTP status
+func IsStatus(err error, status int) bool {
+	apiErr, ok := err.(*APIError)
+	return ok && apiErr.StatusCode == status
+}
+
+// do sends a request and decodes the JSON response into out (if non-nil).
+// State-changing requests carry a CSRF token, fetched on demand.
+func (c *Client) do(ctx context.Context, method, path string, body, out interface{}) error {
+	return c.doWithHeaders(ctx, method, path, nil, body, out)
+}
+
+func (c *Client) doWithHeaders(ctx context.Context, method, path string, headers http.Header, body, out interface{}) error {
+	err := c.send(ctx, method, path, headers, body, out)
+
+	// A rotated or expired CSRF token is refreshed once and the request retried
+	if apiErr, ok := err.(*APIError); ok && apiErr.StatusCode == http.StatusForbidden && isCSRFError(apiErr) {
+		c.mu.Lock()
+		c.csrfToken = ""
+		c.mu.Unlock()
+		err = c.send(ctx, method, path, headers, body, out)
+	}
+
+	return err
+}
+
+func (c *Client) send(ctx context.Context, method, path string, headers http.Header, body, out interface{}) error {
+	var reader io.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			return fmt.Errorf("failed to encode request: %w", err)
+		}
+		reader = bytes.NewReader(data)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, c.baseURL+path, reader)
+	if err != nil {
+		return fmt.Errorf("failed to create request: %w", err)
+	}
+
+	req.Header.Set("Accept", "application/json")
+	req.Header.Set("User-Agent", c.userAgent)
+	req.Header.Set("API-Version", APIVersion)
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	for key, values := range headers {
+		for _, value := range values {
+			req.Header.Add(key, value)
+		}
+	}
+
+	if token := c.Token(); token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+
+	if method != http.MethodGet && method != http.MethodHead {
+		csrfToken, err := c.csrf(ctx)
+		if err != nil {
+			return err
+		}
+		req.Header.Set("X-CSRF-Token", csrfToken)
+	}
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("request failed: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if w, ok := out.(*withHeader); ok {
+		w.header = resp.Header
+		out = w.out
+	}
+	return decodeResponse(resp, out)
+}
+
+// withHeader decodes a response into out and keeps its headers
+type withHeader struct {
+	out    interface{}
+	header http.Header
+}
+
+// csrf returns a valid CSRF token, fetching a new one when needed
+func (c *Client) csrf(ctx context.Context) (string, error) {
+	c.mu.Lock()
+	if c.csrfToken != "" && time.Now().Before(c.csrfExpires) {
+		token := c.csrfToken
+		c.mu.Unlock()
+		return token, nil
+	}
+	c.mu.Unlock()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, c.baseURL+"/auth/csrf", nil)
+	if err != nil {
+		return "", fmt.Errorf("failed to create request: %w", err)
+	}
+	req.Header.Set("User-Agent", c.userAgent)
+	req.Header.Set("API-Version", APIVersion)
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("failed to fetch CSRF token: %w", err)
+	}
+	defer resp.Body.Close()
+
+	var result struct {
+		Token     string `json:"csrf_token"`
+		ExpiresIn int    `json:"expires_in"`
+	}
+	if err := decodeResponse(resp, &result); err != nil {
+		return "", err
+	}
+
+	c.mu.Lock()
+	c.csrfToken = result.Token
+	c.csrfExpires = time.Now().Add(time.Duration(result.ExpiresIn)*time.Second - csrfRefreshMargin)
+	c.mu.Unlock()
+
+	return result.Token, nil
+}
+
+// decodeResponse turns error statuses into APIError and decodes the body into out
+func decodeResponse(resp *http.Response, out interface{}) error {
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return fmt.Errorf("failed to read response: %w", err)
+	}
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		apiErr := &APIError{StatusCode: resp.StatusCode, Message: http.StatusText(resp.StatusCode)}
+
+		var body struct {
+			Error     string            `json:"error"`
+			Code      string            `json:"code"`
+			Operation *int              `json:"operation"`
+			Issues    []integrity.Issue `json:"issues"`
+		}
+		if json.Unmarshal(data, &body) == nil && body.Error != "" {
+			apiErr.Message = body.Error
+			apiErr.Code = body.Code
+			apiErr.Operation = body.Operation
+			apiErr.Issues = body.Issues
+		}
+
+		return apiErr
+	}
+
+	if out == nil || len(data) == 0 {
+		return nil
+	}
+
+	if err := json.Unmarshal(data, out); err != nil {
+		return fmt.Errorf("failed to decode response: %w", err)
+	}
+
+	return nil
+}
+
+// isCSRFError reports whether the server rejected the CSRF token; older
+// servers only say so in the message
+func isCSRFError(err *APIError) bool {
+	if err.Code != "" {
+		return err.Code == "csrf_missing" || err.Code == "csrf_invalid"
+	}
+	return strings.Contains(err.Message, "CSRF")
+}
@@ -0,0 +1,677 @@
+package client
+
+import (
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/thesabbir/hellfire/pkg/config"
+	"github.com/thesabbir/hellfire/pkg/dns"
+	"github.com/thesabbir/hellfire/pkg/graphql"
+	"github.com/thesabbir/hellfire/pkg/integrity"
+	"github.com/thesabbir/hellfire/pkg/modem"
+	"github.com/thesabbir/hellfire/pkg/netdetect"
+	"github.com/thesabbir/hellfire/pkg/onboarding"
+	"github.com/thesabbir/hellfire/pkg/snapshot"
+	"github.com/thesabbir/hellfire/pkg/wireguard"
+)
+
+// User is an API user as returned by the auth endpoints
+type User struct {
+	ID          uint       `json:"id"`
+	CreatedAt   time.Time  `json:"created_at"`
+	UpdatedAt   time.Time  `json:"updated_at"`
+	Username    string     `json:"username"`
+	Email       string     `json:"email"`
+	Role        string     `json:"role"`
+	Enabled     bool       `json:"enabled"`
+	LastLoginAt *time.Time `json:"last_login_at,omitempty"`
+}
+
+// LoginResponse is returned by Login
+type LoginResponse struct {
+	Token     string    `json:"token"`
+	User      *User     `json:"user"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+// MeResponse is returned by Me
+type MeResponse struct {
+	User        *User        `json:"user"`
+	Permissions []string     `json:"permissions"`
+	Session     *SessionInfo `json:"session,omitempty"` // Not set for API keys
+}
+
+// SessionInfo describes the session of a Me request
+type SessionInfo struct {
+	ExpiresAt      time.Time `json:"expires_at"`
+	AbsoluteExpiry time.Time `json:"absolute_expiry"`
+	ImpersonatedBy *User     `json:"impersonated_by,omitempty"`
+}
+
+// ImpersonateResponse is returned by Impersonate
+type ImpersonateResponse struct {
+	Token        string    `json:"token"`
+	User         *User     `json:"user"`
+	Impersonator *User     `json:"impersonator"`
+	ExpiresAt    time.Time `json:"expires_at"`
+}
+
+// KnownDevice is a device a user has logged in from
+type KnownDevice struct {
+	ID          uint      `json:"id"`
+	CreatedAt   time.Time `json:"created_at"`
+	UserID      uint      `json:"user_id"`
+	Fingerprint string    `json:"fingerprint"`
+	IPAddress   string    `json:"ip_address"`
+	UserAgent   string    `json:"user_agent"`
+	LastSeenAt  time.Time `json:"last_seen_at"`
+	Current     bool      `json:"current"` // The device of this client's session
+}
+
+// BootstrapInfo is returned by Bootstrap
+type BootstrapInfo struct {
+	Initialized bool   `json:"initialized"`
+	Version     string `json:"version"`
+	System      string `json:"system"`
+}
+
+// Section is a config section: ".type" and ".name" plus its options
+// (string values) and lists ([]interface{} of strings)
+type Section map[string]interface{}
+
+// Type returns the section type
+func (s Section) Type() string {
+	t, _ := s[".type"].(string)
+	return t
+}
+
+// Name returns the section name (empty for unnamed sections)
+func (s Section) Name() string {
+	n, _ := s[".name"].(string)
+	return n
+}
+
+// Permissions returns what the client may do with the section: "read"
+// and, unless an acl or its role prevents it, "write"
+func (s Section) Permissions() []string {
+	values, _ := s[".permissions"].([]interface{})
+	perms := make([]string, 0, len(values))
+	for _, v := range values {
+		if perm, ok := v.(string); ok {
+			perms = append(perms, perm)
+		}
+	}
+	return perms
+}
+
+// Writable reports whether the client may change the section
+func (s Section) Writable() bool {
+	return slices.Contains(s.Permissions(), "write")
+}
+
+// Option returns a single-value option
+func (s Section) Option(key string) (string, bool) {
+	v, ok := s[key].(string)
+	return v, ok
+}
+
+// Config is a config file keyed by section name, or "<type>_<n>" for unnamed sections
+type Config map[string]Section
+
+// Operation is a single batch operation
+type Operation = config.Operation
+
+// Batch operation kinds
+const (
+	OpSet     = config.OpSet
+	OpDelete  = config.OpDelete
+	OpAddList = config.OpAddList
+)
+
+// MessageResponse is the generic response of simple write endpoints
+type MessageResponse struct {
+	Message string `json:"message"`
+}
+
+// SetOptionResponse is returned by SetOption
+type SetOptionResponse struct {
+	Message  string   `json:"message"`
+	Path     string   `json:"path"`
+	Value    string   `json:"value"`
+	Warnings []string `json:"warnings,omitempty"` // The config is locked by someone else
+	Revision string   `json:"-"`                  // The config's revision after the change
+}
+
+// BatchResponse is returned by Batch
+type BatchResponse struct {
+	Message    string   `json:"message"`
+	Configs    []string `json:"configs"`
+	Operations int      `json:"operations"`
+	Warnings   []string `json:"warnings,omitempty"` // Configs locked by someone else
+}
+
+// ConfigLock is an advisory lock on a config for a long edit
+type ConfigLock struct {
+	Config    string    `json:"config"`
+	CreatedAt time.Time `json:"created_at"`
+	Username  string    `json:"username"`
+	Note      string    `json:"note,omitempty"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+// ChangesResponse is returned by Changes
+type ChangesResponse struct {
+	HasChanges bool     `json:"has_changes"`
+	Configs    []string `json:"configs"`
+}
+
+// CommitRequest holds the options for Commit
+type CommitRequest struct {
+	Message        string `json:"message,omitempty"`
+	ConfirmTimeout int    `json:"confirm_timeout,omitempty"` // Seconds (0 = no confirmation required)
+	DryRun         bool   `json:"dry_run,omitempty"`         // Use DryRun instead, which decodes the result
+
+	// SafeRemote rolls the changes back unless this client keeps sending
+	// keepalives after the apply. Without a confirm timeout Commit sends the
+	// one that confirms them.
+	SafeRemote bool `json:"safe_remote,omitempty"`
+
+	// SkipCanary applies the changes to this router only, without its canary
+	SkipCanary bool `json:"skip_canary,omitempty"`
+
+	// IdempotencyKey makes retries of the same commit safe (optional)
+	IdempotencyKey string `json:"-"`
+}
+
+// CommitResponse is returned by Commit
+type CommitResponse struct {
+	Message        string   `json:"message"`
+	Configs        []string `json:"configs,omitempty"`
+	State          string   `json:"state,omitempty"`
+	ConfirmTimeout int      `json:"confirm_timeout,omitempty"`
+	Keepalive      int      `json:"keepalive,omitempty"` // Seconds within which to send each keepalive
+	TransactionID  string   `json:"transaction_id,omitempty"`
+	Generation     uint64   `json:"generation,omitempty"` // Config generation after the commit
+}
+
+// KeepaliveResponse is returned by Keepalive
+type KeepaliveResponse struct {
+	Message   string `json:"message"`
+	Confirmed bool   `json:"confirmed"`
+}
+
+// DryRunResponse is returned by DryRun: what committing the staged changes
+// would do. Nothing is snapshotted, written or applied.
+type DryRunResponse struct {
+	Message    string                `json:"message"`
+	Valid      bool                  `json:"valid"`                 // Whether the commit would pass its checks
+	Configs    []string              `json:"configs"`               // Changed configs, snapshotted before the commit
+	Apply      []string              `json:"apply"`                 // Configs applied, in order
+	NotApplied []string              `json:"not_applied,omitempty"` // Changed configs that are only written
+	Diffs      map[string]string     `json:"diffs"`                 // Unified diff of each changed config
+	Artifacts  map[string][]Artifact `json:"artifacts,omitempty"`   // What each applier would load
+	Errors     map[string][]string   `json:"errors,omitempty"`      // Why the commit would fail, by config
+	Issues     []integrity.Issue     `json:"issues,omitempty"`      // Broken references between configs
+}
+
+// Artifact is a ruleset, file or list of commands an applier generates
+type Artifact struct {
+	Name    string `json:"name"`
+	Content string `json:"content"`
+}
+
+// RevertResponse is returned by Revert
+type RevertResponse struct {
+	Message string   `json:"message"`
+	Configs []string `json:"configs,omitempty"`
+}
+
+// ValidateResponse is returned by Validate. Invalid changes are returned
+// as a *APIError with status 400 instead.
+type ValidateResponse struct {
+	Valid   bool     `json:"valid"`
+	Message string   `json:"message"`
+	Configs []string `json:"configs,omitempty"`
+}
+
+// SnapshotMetadata describes a snapshot
+type SnapshotMetadata = snapshot.Metadata
+
+// SnapshotList is returned by ListSnapshots
+type SnapshotList struct {
+	Snapshots []SnapshotMetadata `json:"snapshots"`
+	Count     int                `json:"count"`
+}
+
+// StageSnapshotResponse is returned by StageSnapshot
+type StageSnapshotResponse struct {
+	Message  string   `json:"message"`
+	Snapshot string   `json:"snapshot"`
+	Configs  []string `json:"configs"`
+}
+
+// RollbackSnapshotResponse is returned by RollbackSnapshot
+type RollbackSnapshotResponse struct {
+	Message  string `json:"message"`
+	Snapshot string `json:"snapshot"`
+}
+
+// Transaction is a transaction record
+type Transaction struct {
+	ID           uint       `json:"id"`
+	CreatedAt    time.Time  `json:"created_at"`
+	UpdatedAt    time.Time  `json:"updated_at"`
+	TxID         string     `json:"transaction_id"`
+	UserID       *uint      `json:"user_id,omitempty"`
+	Username     string     `json:"username"`
+	Message      string     `json:"message"`
+	Status       string     `json:"status"`
+	SnapshotID   string     `json:"snapshot_id,omitempty"`
+	Configs      string     `json:"configs"` // JSON array of changed configs
+	ConfirmedAt  *time.Time `json:"confirmed_at,omitempty"`
+	CompletedAt  *time.Time `json:"completed_at,omitempty"`
+	RolledBackAt *time.Time `json:"rolled_back_at,omitempty"`
+	Error        string     `json:"error,omitempty"`
+
+	RollbackReason string `json:"rollback_reason,omitempty"` // Given by the user, or what triggered the rollback
+	FailedApplier  string `json:"failed_applier,omitempty"`
+}
+
+// TimelineEvent is a single entry in a transaction timeline
+type TimelineEvent struct {
+	Time     time.Time `json:"time"`
+	Source   string    `json:"source"`
+	Action   string    `json:"action"`
+	Status   string    `json:"status,omitempty"`
+	Username string    `json:"username,omitempty"`
+	Message  string    `json:"message,omitempty"`
+	Error    string    `json:"error,omitempty"`
+}
+
+// TransactionTimeline is returned by Timeline
+type TransactionTimeline struct {
+	Transaction *Transaction      `json:"transaction"`
+	Snapshot    *SnapshotMetadata `json:"snapshot,omitempty"`
+	Phases      map[string]int64  `json:"phases"`
+	DurationMs  int64             `json:"duration_ms"`
+	Events      []TimelineEvent   `json:"events"`
+}
+
+// TransactionArtifact is a generated artifact a transaction pushed to the system
+type TransactionArtifact struct {
+	ID            uint      `json:"id"`
+	CreatedAt     time.Time `json:"created_at"`
+	TransactionID string    `json:"transaction_id"`
+	Stage         string    `json:"stage"` // "apply" or "rollback"
+	Config        string    `json:"config"`
+	Name          string    `json:"name"`
+	Content       string    `json:"content"`
+	SHA256        string    `json:"sha256"`
+}
+
+// TransactionArtifacts is returned by TransactionArtifacts
+type TransactionArtifacts struct {
+	TransactionID string                `json:"transaction_id"`
+	Artifacts     []TransactionArtifact `json:"artifacts"`
+}
+
+// ApplyOrder is the order in which changed configs are applied
+type ApplyOrder struct {
+	Order    []string `json:"order"`
+	Skip     []string `json:"skip"`
+	Appliers []string `json:"appliers,omitempty"` // Registered appliers (read-only)
+
+	// Unregistered lists configs in Order without an applier (set only)
+	Unregistered []string `json:"unregistered,omitempty"`
+}
+
+// DegradedStatus reports whether a failed rollback put the system in safe mode
+type DegradedStatus struct {
+	Degraded bool             `json:"degraded"`
+	Status   *DegradedDetails `json:"status"` // Nil unless degraded
+}
+
+// DegradedDetails describes why the system entered safe mode
+type DegradedDetails struct {
+	Since           time.Time `json:"since"`
+	Reason          string    `json:"reason"`
+	TxID            string    `json:"transaction_id,omitempty"`
+	RecoveryApplied bool      `json:"recovery_applied"` // The recovery network was applied
+	RecoveryError   string    `json:"recovery_error,omitempty"`
+}
+
+// HealthCheck is one check of a health score
+type HealthCheck struct {
+	Name    string  `json:"name"`
+	Score   float64 `json:"score"` // 0 (failing) to 1 (healthy)
+	Weight  float64 `json:"weight"`
+	Message string  `json:"message"`
+}
+
+// HealthReport is returned by HealthScore
+type HealthReport struct {
+	Score   int           `json:"score"`  // 0 to 100
+	Status  string        `json:"status"` // healthy, warning or critical
+	Checks  []HealthCheck `json:"checks"`
+	Skipped []string      `json:"skipped,omitempty"`
+	Time    time.Time     `json:"time"`
+}
+
+// DailyTransactions counts the transactions of a day by status
+type DailyTransactions struct {
+	Day    string           `json:"day"` // YYYY-MM-DD, UTC
+	Total  int64            `json:"total"`
+	Status map[string]int64 `json:"status"`
+}
+
+// ActionCounts counts the audit entries of an action by outcome
+type ActionCounts struct {
+	Success int64 `json:"success"`
+	Failure int64 `json:"failure"`
+}
+
+// StatsSummary is returned by StatsSummary
+type StatsSummary struct {
+	From         time.Time               `json:"from"`
+	Days         int                     `json:"days"`
+	Transactions []DailyTransactions     `json:"transactions"` // Every day, oldest first
+	TxStatus     map[string]int64        `json:"transaction_status"`
+	AuditActions map[string]ActionCounts `json:"audit_actions"`
+	FailedLogins int64                   `json:"failed_logins"`
+	Rollbacks    int64                   `json:"rollbacks"`
+}
+
+// DHCPClientStatus reports a supervised DHCP client and its lease
+type DHCPClientStatus struct {
+	Interface string     `json:"interface"`
+	PID       int        `json:"pid,omitempty"`
+	Running   bool       `json:"running"`
+	Restarts  int        `json:"restarts"`
+	Addresses []string   `json:"addresses"` // IPv4 addresses on the interface
+	Lease     *DHCPLease `json:"lease,omitempty"`
+}
+
+// DHCPLease is the current lease of a DHCP client
+type DHCPLease struct {
+	Interface string    `json:"interface"`
+	Address   string    `json:"address"`
+	Netmask   string    `json:"netmask,omitempty"`
+	Routers   []string  `json:"routers,omitempty"`
+	DNS       []string  `json:"dns,omitempty"`
+	Server    string    `json:"server,omitempty"`
+	Renew     time.Time `json:"renew,omitzero"`
+	Rebind    time.Time `json:"rebind,omitzero"`
+	Expire    time.Time `json:"expire,omitzero"`
+}
+
+// SnapshotStorage is the disk usage of the snapshots and the limits on it
+type SnapshotStorage = snapshot.Storage
+
+// StorageResponse is returned by Storage
+type StorageResponse struct {
+	Snapshots   *SnapshotStorage  `json:"snapshots"`
+	FileSystems []FileSystemUsage `json:"filesystems"`
+}
+
+// FileSystemUsage is the size and free space of a file system, in bytes
+type FileSystemUsage struct {
+	Path      string `json:"path"`
+	Total     uint64 `json:"total"`
+	Available uint64 `json:"available"`
+}
+
+// LinksResponse is the state of the network links and their recent events
+type LinksResponse struct {
+	Enabled bool        `json:"enabled"` // Links are watched (not on a simulated system)
+	Links   []Link      `json:"links"`
+	Events  []LinkEvent `json:"events"` // Newest first
+}
+
+// Link is the state of a network link
+type Link struct {
+	Index   int    `json:"index"`
+	Name    string `json:"name"`
+	Up      bool   `json:"up"`      // Administratively up
+	Carrier bool   `json:"carrier"` // Link detected
+}
+
+// LinkEvent is an interface appearing (added) or disappearing (removed), or
+// its link being detected (up) or lost (down)
+type LinkEvent struct {
+	Time      time.Time `json:"time"`
+	Type      string    `json:"type"`
+	Interface string    `json:"interface"`
+	Up        bool      `json:"up"`
+	Carrier   bool      `json:"carrier"`
+}
+
+// QuotaStatus reports a device's bandwidth quota and its usage in the current period
+type QuotaStatus struct {
+	Name        string    `json:"name"`
+	MAC         string    `json:"mac,omitempty"`
+	IP          string    `json:"ip,omitempty"`
+	Limit       uint64    `json:"limit"` // Bytes per period
+	Period      string    `json:"period"`
+	Action      string    `json:"action"`
+	Rate        int       `json:"rate,omitempty"` // Throttled rate in kbytes/second
+	Used        uint64    `json:"used"`
+	Remaining   uint64    `json:"remaining"`
+	PeriodStart time.Time `json:"period_start"`
+	NextReset   time.Time `json:"next_reset"`
+	Exceeded    bool      `json:"exceeded"`
+}
+
+// WANQuality is the WAN quality history and the current state of each ping target
+type WANQuality struct {
+	Enabled bool              `json:"enabled"`
+	Targets []WANTargetStatus `json:"targets"`
+	Samples []WANSample       `json:"samples"` // Newest first
+}
+
+// WANTargetStatus is the latest measurement of a ping target
+type WANTargetStatus struct {
+	Target   string    `json:"target"`
+	Degraded bool      `json:"degraded"`
+	Reasons  []string  `json:"reasons,omitempty"`
+	Since    time.Time `json:"since"`
+	Last     WANSample `json:"last"`
+}
+
+// WANSample is one ping round or throughput test
+type WANSample struct {
+	ID           uint      `json:"id"`
+	CreatedAt    time.Time `json:"created_at"`
+	Kind         string    `json:"kind"` // "ping" or "speedtest"
+	Target       string    `json:"target"`
+	LatencyMs    float64   `json:"latency_ms"`
+	JitterMs     float64   `json:"jitter_ms"`
+	LossPercent  float64   `json:"loss_percent"`
+	DownloadMbps float64   `json:"download_mbps,omitempty"`
+	UploadMbps   float64   `json:"upload_mbps,omitempty"`
+	Degraded     bool      `json:"degraded"`
+	Error        string    `json:"error,omitempty"`
+}
+
+// Certificate is a certificate the server uses or stores
+type Certificate struct {
+	Name        string    `json:"name"`
+	Usage       string    `json:"usage"`
+	Path        string    `json:"path"`
+	KeyPath     string    `json:"key_path,omitempty"`
+	Subject     string    `json:"subject,omitempty"`
+	Issuer      string    `json:"issuer,omitempty"`
+	DNSNames    []string  `json:"dns_names,omitempty"`
+	Serial      string    `json:"serial,omitempty"`
+	Fingerprint string    `json:"fingerprint,omitempty"` // SHA-256, hex
+	NotBefore   time.Time `json:"not_before"`
+	NotAfter    time.Time `json:"not_after"`
+	IsCA        bool      `json:"is_ca"`
+	LastChecked time.Time `json:"last_checked"`
+	Error       string    `json:"error,omitempty"` // Why the file couldn't be read
+}
+
+// UpgradeState is the record of the last system upgrade
+type UpgradeState struct {
+	ID          string     `json:"id"`
+	Method      string     `json:"method"` // "apt" or "image"
+	Status      string     `json:"status"` // installing, installed, succeeded, failed or rolled_back
+	Message     string     `json:"message,omitempty"`
+	FromVersion string     `json:"from_version"`
+	ToVersion   string     `json:"to_version,omitempty"`
+	SnapshotID  string     `json:"snapshot_id"`
+	DBBackup    string     `json:"db_backup,omitempty"`
+	LogFile     string     `json:"log_file"`
+	StartedAt   time.Time  `json:"started_at"`
+	FinishedAt  *time.Time `json:"finished_at,omitempty"`
+	Error       string     `json:"error,omitempty"`
+	Problems    []string   `json:"problems,omitempty"`
+}
+
+// UpgradeOptions selects what an upgrade installs
+type UpgradeOptions struct {
+	Method    string   `json:"method"`               // "apt" (default) or "image"
+	Packages  []string `json:"packages,omitempty"`   // apt: packages to upgrade; empty upgrades the whole system
+	Image     string   `json:"image,omitempty"`      // image: path of the .deb on the router
+	Signature string   `json:"signature,omitempty"`  // image: signature file
+	PublicKey string   `json:"public_key,omitempty"` // image: public key file
+	Message   string   `json:"message,omitempty"`
+}
+
+// LogEntry is a system log message received by the server
+type LogEntry struct {
+	ID       uint64    `json:"id"`
+	Time     time.Time `json:"time"`
+	Received time.Time `json:"received"`
+	Facility string    `json:"facility"`
+	Severity string    `json:"severity"`
+	Level    int       `json:"level"` // 0 (emerg) to 7 (debug)
+	Hostname string    `json:"hostname,omitempty"`
+	App      string    `json:"app,omitempty"`
+	PID      string    `json:"pid,omitempty"`
+	Message  string    `json:"message"`
+	Source   string    `json:"source"`
+}
+
+// LogsResponse is the most recent system log messages
+type LogsResponse struct {
+	Enabled bool       `json:"enabled"` // Whether the syslog collector runs
+	Entries []LogEntry `json:"entries"` // Newest first
+}
+
+// Task is a scheduled task with its next and last runs
+type Task struct {
+	Name     string     `json:"name"`
+	Schedule string     `json:"schedule"`          // Cron expression or alias like @daily
+	Command  string     `json:"command,omitempty"` // Shell command, or
+	Action   string     `json:"action,omitempty"`  // built-in action
+	Keep     int        `json:"keep,omitempty"`
+	Enabled  bool       `json:"enabled"`
+	Timeout  int        `json:"timeout"` // Seconds
+	NextRun  *time.Time `json:"next_run,omitempty"`
+	Running  bool       `json:"running"`
+	LastRun  *TaskRun   `json:"last_run,omitempty"`
+}
+
+// TaskRun is one run of a scheduled task
+type TaskRun struct {
+	ID         uint      `json:"id"`
+	Task       string    `json:"task"`
+	Trigger    string    `json:"trigger"` // "schedule" or "manual"
+	Status     string    `json:"status"`  // "success", "failure" or "timeout"
+	StartedAt  time.Time `json:"started_at"`
+	FinishedAt time.Time `json:"finished_at"`
+	DurationMs int64     `json:"duration_ms"`
+	Output     string    `json:"output,omitempty"`
+	Error      string    `json:"error,omitempty"`
+}
+
+// NetworkDevice is a network interface and how the network config uses it
+type NetworkDevice struct {
+	netdetect.Interface
+	Managed bool   `json:"managed"`         // Configured by an interface section
+	Proto   string `json:"proto,omitempty"` // The section's proto
+}
+
+// ModemsResponse is the cellular modems known to ModemManager
+type ModemsResponse struct {
+	Available bool          `json:"available"` // Whether the server has ModemManager
+	Modems    []modem.Modem `json:"modems"`
+	Missing   []string      `json:"missing"` // Modem interfaces whose modem isn't plugged in
+}
+
+// WireGuardStatus is the state of the WireGuard interfaces
+type WireGuardStatus struct {
+	Available  bool                  `json:"available"` // Whether the server has the wg tool
+	Interfaces []wireguard.Interface `json:"interfaces"`
+}
+
+// WireGuardKeys is a key pair generated by the server
+type WireGuardKeys struct {
+	PrivateKey   string `json:"private_key"`
+	PublicKey    string `json:"public_key"`
+	PresharedKey string `json:"preshared_key,omitempty"`
+}
+
+// WireGuardClientConfig is a wg-quick config for a peer
+type WireGuardClientConfig struct {
+	Filename string `json:"filename"`
+	Config   string `json:"config"`
+}
+
+// DNSSettings is the DNS settings of the dhcp config besides its upstream
+// servers
+type DNSSettings struct {
+	Servers    []dns.Server `json:"servers"`
+	LocalZones []string     `json:"local_zones"`
+	Records    []dns.Record `json:"records"`
+}
+
+// DNSHosts is the hosts entries resolved locally
+type DNSHosts struct {
+	Domain string     `json:"domain,omitempty"`
+	Hosts  []dns.Host `json:"hosts"`
+}
+
+// DNSStats is the statistics of the logged DNS queries
+type DNSStats struct {
+	Enabled    bool `json:"enabled"`    // Whether the committed dhcp config logs queries
+	Collecting bool `json:"collecting"` // Whether the syslog collector receives them
+	dns.QueryStats
+}
+
+// DNSRecordResult is a staged DNS record
+type DNSRecordResult struct {
+	Message string     `json:"message"`
+	Record  dns.Record `json:"record"`
+}
+
+// OnboardingNetwork is the detected interfaces and proposed network setup
+type OnboardingNetwork struct {
+	Interfaces      []netdetect.Interface `json:"interfaces"`
+	Proposal        onboarding.Setup      `json:"proposal"`
+	WirelessApplied bool                  `json:"wireless_applied"` // Whether a Wi-Fi setup takes effect
+}
+
+// OnboardingPreview is the configs a network setup would commit
+type OnboardingPreview struct {
+	Configs map[string]string `json:"configs"` // Name to UCI text
+	Notes   []string          `json:"notes,omitempty"`
+}
+
+// OnboardingResult is returned by ApplyNetworkSetup
+type OnboardingResult struct {
+	CommitResponse
+	Notes []string `json:"notes,omitempty"`
+}
+
+// GraphQLErrors are the errors a GraphQL query reported
+type GraphQLErrors []*graphql.Error
+
+func (e GraphQLErrors) Error() string {
+	messages := make([]string, len(e))
+	for i, err := range e {
+		messages[i] = err.Message
+	}
+	return "graphql: " + strings.Join(messages, "; ")
+}
@@ -0,0 +1,240 @@
+package config
+
+import (
+	"fmt"
+	"maps"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/thesabbir/hellfire/pkg/uci"
+)
+
+// Batch operation types
+const (
+	OpSet     = "set"      // Set an option (creates the section if missing), or a section's type when no option is given
+	OpDelete  = "delete"   // Delete an option/list, or a whole section when no option is given
+	OpAddList = "add_list" // Append a value to a list (creates the section if missing)
+	OpDelList = "del_list" // Remove a value from a list
+	OpAdd     = "add"      // Add an unnamed section; the path is config.type
+)
+
+// MaxBatchOperations limits the size of a single batch request from the APIs
+const MaxBatchOperations = 500
+
+// Operation is a single staged mutation in a batch
+type Operation struct {
+	Op    string `json:"op" example:"set"`                  // set, delete, add_list, del_list or add
+	Path  string `json:"path" example:"network.wan.ipaddr"` // config.section[.option]; sections can be @type[index]
+	Value string `json:"value,omitempty" example:"192.168.1.1"`
+}
+
+// OperationError reports which operation of a batch failed
+type OperationError struct {
+	Index int
+	Op    Operation
+	Err   error
+}
+
+func (e *OperationError) Error() string {
+	return fmt.Sprintf("operation %d (%s %s): %v", e.Index, e.Op.Op, e.Op.Path, e.Err)
+}
+
+func (e *OperationError) Unwrap() error {
+	return e.Err
+}
+
+// Batch applies operations in order to the staging area.
+// The operations are applied to copies of the affected configs, which are
+// only staged if every operation succeeds; on error nothing is staged.
+// Returns the names of the configs that were staged.
+func (m *Manager) Batch(ops []Operation) ([]string, error) {
+	return m.BatchIf(ops, nil)
+}
+
+// BatchIf applies operations like Batch, provided each config in revisions
+// still has the given checksum; otherwise it fails with ErrConflict and
+// nothing is staged.
+func (m *Manager) BatchIf(ops []Operation, revisions map[string]string) ([]string, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for _, name := range slices.Sorted(maps.Keys(revisions)) {
+		if err := m.checkRevision(name, revisions[name]); err != nil {
+			return nil, err
+		}
+	}
+
+	working := make(map[string]*uci.Config)
+	order := make([]string, 0)
+
+	for i, op := range ops {
+		configName, sectionName, optionName, err := parsePath(op.Path)
+		if err != nil {
+			return nil, &OperationError{Index: i, Op: op, Err: err}
+		}
+
+		config, ok := working[configName]
+		if !ok {
+			loaded, err := m.load(configName)
+			if err != nil {
+				return nil, &OperationError{Index: i, Op: op, Err: err}
+			}
+			config = loaded.Clone()
+			working[configName] = config
+			order = append(order, configName)
+		}
+
+		if err := applyOperation(config, configName, op.Op, sectionName, optionName, op.Value); err != nil {
+			return nil, &OperationError{Index: i, Op: op, Err: err}
+		}
+	}
+
+	for _, name := range order {
+		m.staged[name] = working[name]
+	}
+
+	return order, nil
+}
+
+// applyOperation applies a single batch operation to a config
+func applyOperation(config *uci.Config, configName, op, sectionName, optionName, value string) error {
+	section := findSection(config, sectionName)
+
+	switch op {
+	case OpSet, OpAddList:
+		if op == OpSet && optionName == "" {
+			return setSectionType(config, section, sectionName, value)
+		}
+		if optionName == "" {
+			return fmt.Errorf("option name required")
+		}
+		if section == nil {
+			if isSectionRef(sectionName) {
+				return fmt.Errorf("section not found: %s", sectionName)
+			}
+			section = uci.NewSection(sectionName, sectionName)
+			config.AddSection(section)
+		}
+		if op == OpSet {
+			value, err := NormalizeOption(configName, section.Type, optionName, value)
+			if err != nil {
+				return err
+			}
+			section.SetOption(optionName, value)
+		} else {
+			section.AddListValue(optionName, value)
+		}
+		return nil
+
+	case OpDelList:
+		if section == nil {
+			return fmt.Errorf("section not found: %s", sectionName)
+		}
+		if optionName == "" {
+			return fmt.Errorf("option name required")
+		}
+		section.Lists[optionName] = slices.DeleteFunc(section.GetList(optionName), func(v string) bool { return v == value })
+		if len(section.Lists[optionName]) == 0 {
+			delete(section.Lists, optionName)
+		}
+		return nil
+
+	case OpAdd:
+		if optionName != "" || isSectionRef(sectionName) {
+			return fmt.Errorf("add takes config.type")
+		}
+		config.AddSection(uci.NewSection(sectionName, ""))
+		return nil
+
+	case OpDelete:
+		if section == nil {
+			return fmt.Errorf("section not found: %s", sectionName)
+		}
+		if optionName == "" {
+			config.RemoveSection(section)
+			return nil
+		}
+		if !section.DeleteOption(optionName) {
+			return fmt.Errorf("option not found: %s", optionName)
+		}
+		return nil
+
+	default:
+		return fmt.Errorf("unknown operation: %s", op)
+	}
+}
+
+// setSectionType creates a named section of a type, or changes the type of
+// an existing one
+func setSectionType(config *uci.Config, section *uci.Section, sectionName, sectionType string) error {
+	if sectionType == "" {
+		return fmt.Errorf("section type required")
+	}
+	if section != nil {
+		section.Type = sectionType
+		return nil
+	}
+	if isSectionRef(sectionName) {
+		return fmt.Errorf("section not found: %s", sectionName)
+	}
+	config.AddSection(uci.NewSection(sectionType, sectionName))
+	return nil
+}
+
+// SectionOf returns the name and type of the section a config.section[.option]
+// path refers to, for access checks. A section that doesn't exist yet only
+// has the name given in the path, or the type of an @type[index] reference.
+func (m *Manager) SectionOf(path string) (name, sectionType string) {
+	configName, sectionName, _, err := parsePath(path)
+	if err != nil {
+		return "", ""
+	}
+
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	if cfg, err := m.load(configName); err == nil {
+		if section := findSection(cfg, sectionName); section != nil {
+			return section.Name, section.Type
+		}
+	}
+	if isSectionRef(sectionName) {
+		sectionType, _, _ := strings.Cut(sectionName[1:], "[")
+		return "", sectionType
+	}
+	return sectionName, ""
+}
+
+// isSectionRef reports whether a section name is an @type[index] reference
+func isSectionRef(sectionName string) bool {
+	return strings.HasPrefix(sectionName, "@")
+}
+
+// findSection finds a section by name (for named sections), by type (for
+// unnamed), or as @type[index] among the sections of a type, counting from
+// the end when the index is negative
+func findSection(config *uci.Config, sectionName string) *uci.Section {
+	if isSectionRef(sectionName) {
+		sectionType, rest, ok := strings.Cut(sectionName[1:], "[")
+		index, err := strconv.Atoi(strings.TrimSuffix(rest, "]"))
+		if !ok || err != nil || !strings.HasSuffix(rest, "]") {
+			return nil
+		}
+		sections := config.GetSectionsByType(sectionType)
+		if index < 0 {
+			index += len(sections)
+		}
+		if index < 0 || index >= len(sections) {
+			return nil
+		}
+		return sections[index]
+	}
+
+	for _, s := range config.Sections {
+		if s.Name == sectionName || (s.Name == "" && s.Type == sectionName) {
+			return s
+		}
+	}
+	return nil
+}
@@ -0,0 +1,106 @@
+package config
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/thesabbir/hellfire/pkg/uci"
+	"github.com/thesabbir/hellfire/pkg/util"
+)
+
+// racyWindow is how long after a file's modification time a cached parse is
+// re-verified by checksum, since a second write within the filesystem's
+// timestamp granularity would leave the modification time unchanged
+const racyWindow = 2 * time.Second
+
+// parsedConfig is a parsed config file with what identifies its contents
+type parsedConfig struct {
+	config   *uci.Config
+	modTime  time.Time
+	size     int64
+	checksum string
+	readAt   time.Time
+}
+
+// fresh reports whether the file described by info is known to be unchanged
+// without reading it
+func (p *parsedConfig) fresh(info os.FileInfo) bool {
+	return info.ModTime().Equal(p.modTime) &&
+		info.Size() == p.size &&
+		p.readAt.Sub(p.modTime) > racyWindow
+}
+
+// configCache holds parsed config files so repeated reads skip parsing.
+// Changes made outside the manager are detected by modification time, size
+// and, for recently modified files, checksum.
+type configCache struct {
+	mu      sync.Mutex
+	entries map[string]*parsedConfig
+}
+
+func newConfigCache() *configCache {
+	return &configCache{entries: make(map[string]*parsedConfig)}
+}
+
+// load returns a copy of the parsed config file, parsing it only if it
+// changed since it was cached. A missing file yields an empty config.
+func (c *configCache) load(dir, name string) (*uci.Config, error) {
+	path := filepath.Join(dir, name)
+
+	info, err := os.Stat(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			c.invalidate(name)
+			// Return empty config if file doesn't exist
+			return uci.NewConfig(), nil
+		}
+		return nil, fmt.Errorf("failed to open config %s: %w", name, err)
+	}
+
+	c.mu.Lock()
+	cached := c.entries[name]
+	c.mu.Unlock()
+
+	if cached != nil && cached.fresh(info) {
+		return cached.config.Clone(), nil
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open config %s: %w", name, err)
+	}
+	checksum := util.Checksum(data)
+
+	parsed := cached
+	if parsed == nil || parsed.checksum != checksum {
+		config, err := uci.Parse(bytes.NewReader(data))
+		if err != nil {
+			c.invalidate(name)
+			return nil, fmt.Errorf("failed to parse config %s: %w", name, err)
+		}
+		parsed = &parsedConfig{config: config, checksum: checksum}
+	}
+
+	c.mu.Lock()
+	c.entries[name] = &parsedConfig{
+		config:   parsed.config,
+		modTime:  info.ModTime(),
+		size:     int64(len(data)),
+		checksum: checksum,
+		readAt:   time.Now(),
+	}
+	c.mu.Unlock()
+
+	return parsed.config.Clone(), nil
+}
+
+// invalidate drops a cached config
+func (c *configCache) invalidate(name string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	delete(c.entries, name)
+}
@@ -0,0 +1,194 @@
+package config
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+
+	"github.com/thesabbir/hellfire/pkg/uci"
+)
+
+// diffContext is the number of unchanged lines shown around each change
+const diffContext = 3
+
+// maxDiffCells bounds the line comparison; larger changes are shown as the
+// whole old config replaced by the new one
+const maxDiffCells = 4 << 20
+
+// Diff returns a unified diff from the committed version of a config to the
+// staged one, both serialized as they would be written; it's empty if the
+// config isn't staged or the staged version is the same. Sensitive values
+// are masked, marking the ones that changed.
+func (m *Manager) Diff(name string) (string, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	staged, ok := m.staged[name]
+	if !ok {
+		return "", nil
+	}
+	committed, err := m.cache.load(m.configDir, name)
+	if err != nil {
+		return "", err
+	}
+
+	committed, staged = maskDiff(name, committed, staged)
+
+	from, err := serialize(committed)
+	if err != nil {
+		return "", fmt.Errorf("failed to serialize config %s: %w", name, err)
+	}
+	to, err := serialize(staged)
+	if err != nil {
+		return "", fmt.Errorf("failed to serialize staged config %s: %w", name, err)
+	}
+	return unifiedDiff("a/"+name, "b/"+name, from, to), nil
+}
+
+func serialize(cfg *uci.Config) ([]string, error) {
+	var buf bytes.Buffer
+	if err := uci.Write(&buf, cfg); err != nil {
+		return nil, err
+	}
+	text := strings.TrimSuffix(buf.String(), "\n")
+	if text == "" {
+		return nil, nil
+	}
+	return strings.Split(text, "\n"), nil
+}
+
+// diffLine is one line of an edit script: ' ' kept, '-' removed or '+' added
+type diffLine struct {
+	op   byte
+	text string
+}
+
+// unifiedDiff formats the changes from a to b in unified diff format
+func unifiedDiff(fromName, toName string, a, b []string) string {
+	lines := diffLines(a, b)
+
+	var out strings.Builder
+	for start := 0; start < len(lines); {
+		// Find the next change and the hunk around it
+		for start < len(lines) && lines[start].op == ' ' {
+			start++
+		}
+		if start == len(lines) {
+			break
+		}
+		if out.Len() == 0 {
+			fmt.Fprintf(&out, "--- %s\n+++ %s\n", fromName, toName)
+		}
+
+		first := max(start-diffContext, 0)
+		end := start
+		for i := start; i < len(lines); i++ {
+			if lines[i].op != ' ' {
+				end = i + 1
+			} else if i-end >= 2*diffContext {
+				break
+			}
+		}
+		last := min(end+diffContext, len(lines))
+
+		aStart, bStart := 1, 1
+		for _, l := range lines[:first] {
+			if l.op != '+' {
+				aStart++
+			}
+			if l.op != '-' {
+				bStart++
+			}
+		}
+		aCount, bCount := 0, 0
+		for _, l := range lines[first:last] {
+			if l.op != '+' {
+				aCount++
+			}
+			if l.op != '-' {
+				bCount++
+			}
+		}
+		fmt.Fprintf(&out, "@@ -%s +%s @@\n", hunkRange(aStart, aCount), hunkRange(bStart, bCount))
+		for _, l := range lines[first:last] {
+			out.WriteByte(l.op)
+			out.WriteString(l.text)
+			out.WriteByte('\n')
+		}
+		start = last
+	}
+	return out.String()
+}
+
+func hunkRange(start, count int) string {
+	if count == 0 {
+		start--
+	}
+	if count == 1 {
+		return fmt.Sprint(start)
+	}
+	return fmt.Sprintf("%d,%d", start, count)
+}
+
+// diffLines returns an edit script from a to b with the longest common
+// subsequence of lines kept
+func diffLines(a, b []string) []diffLine {
+	var prefix, suffix []diffLine
+	for len(a) > 0 && len(b) > 0 && a[0] == b[0] {
+		prefix = append(prefix, diffLine{' ', a[0]})
+		a, b = a[1:], b[1:]
+	}
+	for len(a) > 0 && len(b) > 0 && a[len(a)-1] == b[len(b)-1] {
+		suffix = append([]diffLine{{' ', a[len(a)-1]}}, suffix...)
+		a, b = a[:len(a)-1], b[:len(b)-1]
+	}
+
+	var middle []diffLine
+	if len(a)*len(b) > maxDiffCells {
+		for _, line := range a {
+			middle = append(middle, diffLine{'-', line})
+		}
+		for _, line := range b {
+			middle = append(middle, diffLine{'+', line})
+		}
+	} else {
+		// lcs[i][j] is the length of the longest common subsequence of a[i:] and b[j:]
+		lcs := make([][]int, len(a)+1)
+		for i := range lcs {
+			lcs[i] = make([]int, len(b)+1)
+		}
+		for i := len(a) - 1; i >= 0; i-- {
+			for j := len(b) - 1; j >= 0; j-- {
+				if a[i] == b[j] {
+					lcs[i][j] = lcs[i+1][j+1] + 1
+				} else {
+					lcs[i][j] = max(lcs[i+1][j], lcs[i][j+1])
+				}
+			}
+		}
+
+		i, j := 0, 0
+		for i < len(a) && j < len(b) {
+			switch {
+			case a[i] == b[j]:
+				middle = append(middle, diffLine{' ', a[i]})
+				i++
+				j++
+			case lcs[i+1][j] >= lcs[i][j+1]:
+				middle = append(middle, diffLine{'-', a[i]})
+				i++
+			default:
+				middle = append(middle, diffLine{'+', b[j]})
+				j++
+			}
+		}
+		for ; i < len(a); i++ {
+			middle = append(middle, diffLine{'-', a[i]})
+		}
+		for ; j < len(b); j++ {
+			middle = append(middle, diffLine{'+', b[j]})
+		}
+	}
+
+	return append(append(prefix, middle...), suffix...)
+}
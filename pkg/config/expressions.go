@@ -0,0 +1,260 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"maps"
+	"os"
+	"slices"
+	"strings"
+
+	"github.com/thesabbir/hellfire/pkg/expr"
+	"github.com/thesabbir/hellfire/pkg/uci"
+)
+
+// ExpressionsConfig holds the named expressions option values can use:
+// expression sections with the expression in their value option, e.g.
+//
+//	config expression 'lan_net'
+//		option value 'cidr(uci("network.lan.ipaddr"), uci("network.lan.netmask"))'
+const ExpressionsConfig = "expressions"
+
+// expressionEnv resolves the variables and the names in the expressions of
+// option values against configs, recording the configs they read
+type expressionEnv struct {
+	load      func(name string) (*uci.Config, error)
+	evaluator *expr.Evaluator
+	variables *variables
+	named     map[string]string
+	refs      map[string]bool
+	expanding []string // Options whose expressions are being expanded
+}
+
+// newExpressionEnv creates an environment reading configs with load
+func newExpressionEnv(load func(name string) (*uci.Config, error)) (*expressionEnv, error) {
+	env := &expressionEnv{load: load, named: make(map[string]string), refs: make(map[string]bool)}
+	cfg, err := load(ExpressionsConfig)
+	if err != nil {
+		return nil, err
+	}
+	for _, section := range cfg.GetSectionsByType("expression") {
+		if value, ok := section.GetOption("value"); ok && section.Name != "" {
+			env.named[section.Name] = value
+		}
+	}
+	env.variables, err = loadVariables(load)
+	if err != nil {
+		return nil, err
+	}
+	env.evaluator = expr.NewEvaluator(env)
+	return env, nil
+}
+
+// expandValue substitutes the variables of a value, then evaluates its
+// expressions
+func (e *expressionEnv) expandValue(value string) (string, error) {
+	if hasVariables(value) {
+		var err error
+		value, err = e.variables.substitute(value)
+		if e.variables.used {
+			e.refs[GlobalsConfig] = true
+		}
+		if err != nil {
+			return "", err
+		}
+	}
+	if !expr.HasTemplate(value) {
+		return value, nil
+	}
+	return e.evaluator.Expand(value)
+}
+
+// Option returns the value of an option for uci(); one with variables or
+// expressions of its own is expanded first. Secret options can't be read, as the value
+// would end up in an option that isn't.
+func (e *expressionEnv) Option(path string) (string, error) {
+	configName, sectionName, optionName, err := parsePath(path)
+	if err != nil {
+		return "", err
+	}
+	if optionName == "" {
+		return "", fmt.Errorf("option name required in %s", path)
+	}
+	cfg, err := e.load(configName)
+	if err != nil {
+		return "", err
+	}
+	e.refs[configName] = true
+
+	section := findSection(cfg, sectionName)
+	if section == nil {
+		return "", fmt.Errorf("section not found: %s.%s", configName, sectionName)
+	}
+	if IsSensitive(configName, section.Type, optionName) {
+		return "", fmt.Errorf("%s is secret", path)
+	}
+	value, ok := section.GetOption(optionName)
+	if !ok {
+		list := section.GetList(optionName)
+		if list == nil {
+			return "", fmt.Errorf("option not found: %s", path)
+		}
+		value = strings.Join(list, " ")
+	}
+	if configName == ExpressionsConfig || !hasTemplate(value) {
+		return value, nil
+	}
+
+	if slices.Contains(e.expanding, path) {
+		return "", fmt.Errorf("%s refers to itself: %s", path, strings.Join(append(e.expanding, path), " -> "))
+	}
+	e.expanding = append(e.expanding, path)
+	defer func() { e.expanding = e.expanding[:len(e.expanding)-1] }()
+	return e.expandValue(value)
+}
+
+// Named returns the source of a named expression
+func (e *expressionEnv) Named(name string) (string, bool) {
+	e.refs[ExpressionsConfig] = true
+	src, ok := e.named[name]
+	return src, ok
+}
+
+// expand returns a copy of cfg with the variables in its values substituted
+// and the expressions evaluated, reading the configs they refer to with
+// load, and the names of those configs. Secret options are left as they are; an expanded value is
+// normalized like a value set directly.
+func expand(name string, cfg *uci.Config, load func(name string) (*uci.Config, error)) (*uci.Config, []string, error) {
+	if name == ExpressionsConfig || !hasTemplates(cfg) {
+		return cfg, nil, nil
+	}
+	env, err := newExpressionEnv(load)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	cfg = cfg.Clone()
+	for _, section := range cfg.Sections {
+		for option, value := range section.Options {
+			if !hasTemplate(value) || IsSensitive(name, section.Type, option) {
+				continue
+			}
+			expanded, err := env.expandValue(value)
+			if err == nil {
+				expanded, err = NormalizeOption(name, section.Type, option, expanded)
+			}
+			if err != nil {
+				return nil, nil, fmt.Errorf("%s.%s.%s: %w", name, cfg.SectionID(section), option, err)
+			}
+			section.Options[option] = expanded
+		}
+		for option, values := range section.Lists {
+			if IsSensitive(name, section.Type, option) {
+				continue
+			}
+			for i, value := range values {
+				if !hasTemplate(value) {
+					continue
+				}
+				expanded, err := env.expandValue(value)
+				if err != nil {
+					return nil, nil, fmt.Errorf("%s.%s.%s: %w", name, cfg.SectionID(section), option, err)
+				}
+				values[i] = expanded
+			}
+		}
+	}
+
+	var refs []string
+	for ref := range env.refs {
+		refs = append(refs, ref)
+	}
+	slices.Sort(refs)
+	return cfg, refs, nil
+}
+
+// hasTemplates reports whether any value of a config refers to variables or
+// embeds expressions
+func hasTemplates(cfg *uci.Config) bool {
+	for _, section := range cfg.Sections {
+		for _, value := range section.Options {
+			if hasTemplate(value) {
+				return true
+			}
+		}
+		for _, values := range section.Lists {
+			if slices.ContainsFunc(values, hasTemplate) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// LoadExpanded loads a configuration, staged or committed, with the
+// variables and expressions in its values expanded but its secrets still
+// sealed
+func (m *Manager) LoadExpanded(name string) (*uci.Config, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	cfg, err := m.load(name)
+	if err != nil {
+		return nil, err
+	}
+	cfg, _, err = expand(name, cfg, m.load)
+	return cfg, err
+}
+
+// ExpressionDependents returns the unchanged configs with variables or
+// expressions that read one of the changed configs, which render differently once those are
+// committed. A config whose expressions fail is included, for the failure
+// to be reported when it is checked.
+func (m *Manager) ExpressionDependents(changed []string) ([]string, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	entries, err := os.ReadDir(m.configDir)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, fmt.Errorf("failed to read config directory: %w", err)
+	}
+	var dependents []string
+	for _, entry := range entries {
+		name := entry.Name()
+		if entry.IsDir() || !ValidName(name) || slices.Contains(changed, name) {
+			continue
+		}
+		cfg, err := m.load(name)
+		if err != nil {
+			continue
+		}
+		if _, refs, err := expand(name, cfg, m.load); err != nil ||
+			slices.ContainsFunc(refs, func(ref string) bool { return slices.Contains(changed, ref) }) {
+			dependents = append(dependents, name)
+		}
+	}
+	return dependents, nil
+}
+
+// CheckExpressions evaluates each named expression, staged or committed,
+// so one that fails is reported even while no option uses it
+func (m *Manager) CheckExpressions() error {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	env, err := newExpressionEnv(m.load)
+	if err != nil {
+		return err
+	}
+	var errs []error
+	for _, name := range slices.Sorted(maps.Keys(env.named)) {
+		if !expr.ValidName(name) {
+			errs = append(errs, fmt.Errorf("expression %s: invalid name: use letters, digits and underscores", name))
+			continue
+		}
+		if _, err := env.evaluator.Eval(name); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
@@ -0,0 +1,117 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/thesabbir/hellfire/pkg/uci"
+)
+
+func TestExpressions(t *testing.T) {
+	dir := t.TempDir()
+	for name, content := range map[string]string{
+		"network": `
+config interface 'lan'
+	option proto 'static'
+	option ipaddr '192.168.1.1'
+	option netmask '255.255.255.0'
+
+config interface 'wg0'
+	option proto 'wireguard'
+	option private_key 'c2VjcmV0'
+`,
+		"dhcp": `
+config dhcp 'lan'
+	option interface 'lan'
+	option start '{{ offset(lan_net, host(lan_net, 100)) }}'
+	option limit '{{ size(lan_net) / 2 }}'
+	option ignore '{{ "no" }}'
+	list dhcp_option '6,{{ uci("network.lan.ipaddr") }}'
+`,
+		"firewall": `
+config zone 'lan'
+	option name 'lan'
+`,
+		ExpressionsConfig: `
+config expression 'lan_net'
+	option value 'cidr(uci("network.lan.ipaddr"), uci("network.lan.netmask"))'
+`,
+	} {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	m := NewManager(dir, t.TempDir())
+
+	dhcp, err := m.LoadDecrypted("dhcp")
+	if err != nil {
+		t.Fatalf("LoadDecrypted: %v", err)
+	}
+	pool := dhcp.GetSection("dhcp", "lan")
+	if got := []string{pool.Options["start"], pool.Options["limit"], pool.Options["ignore"], pool.Lists["dhcp_option"][0]}; !reflect.DeepEqual(got, []string{"100", "128", "0", "6,192.168.1.1"}) {
+		t.Errorf("expanded pool = %v", got)
+	}
+	if raw, _ := m.Get("dhcp.lan.start"); !strings.Contains(raw, "{{") {
+		t.Errorf("Get returned the expanded value %q", raw)
+	}
+
+	// dhcp reads network and the named expressions, firewall reads nothing
+	dependents, err := m.ExpressionDependents([]string{"network"})
+	if err != nil || !reflect.DeepEqual(dependents, []string{"dhcp"}) {
+		t.Errorf("ExpressionDependents(network) = %v, %v", dependents, err)
+	}
+	if dependents, _ := m.ExpressionDependents([]string{"firewall"}); len(dependents) != 0 {
+		t.Errorf("ExpressionDependents(firewall) = %v", dependents)
+	}
+
+	// Staged values are read before they are committed
+	if err := m.Set("network.lan.ipaddr", "10.0.0.1"); err != nil {
+		t.Fatal(err)
+	}
+	if dhcp, err := m.LoadDecrypted("dhcp"); err != nil || dhcp.GetSection("dhcp", "lan").Lists["dhcp_option"][0] != "6,10.0.0.1" {
+		t.Errorf("LoadDecrypted with staged network = %v", err)
+	}
+	if dhcp, err := m.LoadCommittedDecrypted("dhcp"); err != nil || dhcp.GetSection("dhcp", "lan").Lists["dhcp_option"][0] != "6,192.168.1.1" {
+		t.Errorf("LoadCommittedDecrypted = %v", err)
+	}
+
+	for _, tt := range []struct {
+		path, value, want string
+	}{
+		{"dhcp.lan.start", `{{ uci("network.wg0.private_key") }}`, "is secret"},
+		{"dhcp.lan.start", `{{ uci("dhcp.lan.start") }}`, "refers to itself"},
+		{"dhcp.lan.ignore", `{{ "maybe" }}`, "invalid value"},
+		{"dhcp.lan.limit", `{{ missing }}`, `dhcp.lan.limit: unknown name "missing"`},
+	} {
+		previous, _ := m.Get(tt.path)
+		if err := m.Set(tt.path, tt.value); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := m.LoadDecrypted("dhcp"); err == nil || !strings.Contains(err.Error(), tt.want) {
+			t.Errorf("%s = %s: err = %v, want %q", tt.path, tt.value, err, tt.want)
+		}
+		if err := m.Set(tt.path, previous); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := m.CheckExpressions(); err != nil {
+		t.Errorf("CheckExpressions: %v", err)
+	}
+	expressions, err := m.Load(ExpressionsConfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+	broken := uci.NewSection("expression", "broken")
+	broken.SetOption("value", "1 +")
+	expressions.AddSection(broken)
+	if err := m.Stage(ExpressionsConfig, expressions); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.CheckExpressions(); err == nil || !strings.Contains(err.Error(), "expression broken") {
+		t.Errorf("CheckExpressions with a broken expression = %v", err)
+	}
+}
@@ -0,0 +1,163 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"maps"
+	"slices"
+	"strings"
+
+	"github.com/thesabbir/hellfire/pkg/expr"
+	"github.com/thesabbir/hellfire/pkg/uci"
+)
+
+// GlobalsConfig holds the variables option values refer to as ${name}:
+// variable sections with the text in their value option, e.g.
+//
+//	config variable 'wan_if'
+//		option value 'eth1'
+const GlobalsConfig = "globals"
+
+// variables substitutes the ${name} references of values, resolving the
+// references of the variables themselves once
+type variables struct {
+	values    map[string]string
+	resolved  map[string]string
+	resolving []string // Variables being resolved
+	used      bool     // Whether a value has referred to a variable
+}
+
+// variableError is an error resolving a variable
+type variableError struct {
+	name string
+	err  error
+}
+
+func (e *variableError) Error() string {
+	return fmt.Sprintf("variable %s: %v", e.name, e.err)
+}
+
+func (e *variableError) Unwrap() error {
+	return e.err
+}
+
+// loadVariables reads the variables of the globals config with load
+func loadVariables(load func(name string) (*uci.Config, error)) (*variables, error) {
+	cfg, err := load(GlobalsConfig)
+	if err != nil {
+		return nil, err
+	}
+	v := &variables{values: make(map[string]string), resolved: make(map[string]string)}
+	for _, section := range cfg.GetSectionsByType("variable") {
+		if value, ok := section.GetOption("value"); ok && section.Name != "" {
+			v.values[section.Name] = value
+		}
+	}
+	return v, nil
+}
+
+// hasVariables reports whether a value refers to variables
+func hasVariables(text string) bool {
+	return strings.Contains(text, "${")
+}
+
+// hasTemplate reports whether a value refers to variables or embeds
+// expressions
+func hasTemplate(text string) bool {
+	return hasVariables(text) || expr.HasTemplate(text)
+}
+
+// substitute replaces each ${name} in text with the variable's value; $${
+// stands for a literal ${
+func (v *variables) substitute(text string) (string, error) {
+	var b strings.Builder
+	for {
+		start := strings.Index(text, "${")
+		if start < 0 {
+			b.WriteString(text)
+			break
+		}
+		if start > 0 && text[start-1] == '$' {
+			b.WriteString(text[:start] + "{")
+			text = text[start+2:]
+			continue
+		}
+		end := strings.IndexByte(text[start+2:], '}')
+		if end < 0 {
+			return "", fmt.Errorf("unterminated ${ in %q", text)
+		}
+		b.WriteString(text[:start])
+
+		value, err := v.resolve(text[start+2 : start+2+end])
+		if err != nil {
+			return "", err
+		}
+		b.WriteString(value)
+		if b.Len() > expr.MaxLength {
+			return "", fmt.Errorf("substituted value is longer than %d bytes", expr.MaxLength)
+		}
+		text = text[start+2+end+1:]
+	}
+	return b.String(), nil
+}
+
+// resolve returns the value of a variable with its own references
+// substituted
+func (v *variables) resolve(name string) (string, error) {
+	v.used = true
+	if value, ok := v.resolved[name]; ok {
+		return value, nil
+	}
+	if !expr.ValidName(name) {
+		return "", fmt.Errorf("invalid variable name %q", name)
+	}
+	if i := slices.Index(v.resolving, name); i >= 0 {
+		return "", &variableError{name, fmt.Errorf("refers to itself: %s", strings.Join(append(v.resolving[i:], name), " -> "))}
+	}
+	value, ok := v.values[name]
+	if !ok {
+		return "", fmt.Errorf("unknown variable %q", name)
+	}
+
+	v.resolving = append(v.resolving, name)
+	defer func() { v.resolving = v.resolving[:len(v.resolving)-1] }()
+	value, err := v.substitute(value)
+	if err != nil {
+		// Reported for the variable it comes from
+		var varErr *variableError
+		if !errors.As(err, &varErr) {
+			err = &variableError{name, err}
+		}
+		return "", err
+	}
+	v.resolved[name] = value
+	return value, nil
+}
+
+// Variables returns the variables of the globals config, staged or
+// committed, with their references substituted. Variables that can't be
+// resolved are left out and reported in the error.
+func (m *Manager) Variables() (map[string]string, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	v, err := loadVariables(m.load)
+	if err != nil {
+		return nil, err
+	}
+	resolved := make(map[string]string, len(v.values))
+	var errs []error
+	for _, name := range slices.Sorted(maps.Keys(v.values)) {
+		if !expr.ValidName(name) {
+			errs = append(errs, fmt.Errorf("variable %s: invalid name: use letters, digits and underscores", name))
+			continue
+		}
+		value, err := v.resolve(name)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		resolved[name] = value
+	}
+	return resolved, errors.Join(errs...)
+}
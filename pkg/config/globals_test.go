@@ -0,0 +1,111 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestVariables(t *testing.T) {
+	dir := t.TempDir()
+	for name, content := range map[string]string{
+		"network": `
+config interface 'eth1'
+	option proto 'dhcp'
+
+config interface 'br-lan'
+	option proto 'static'
+	option ipaddr '{{ host("${lan_subnet}", 1) }}'
+	option netmask '{{ netmask("${lan_subnet}") }}'
+`,
+		"firewall": `
+config zone 'wan'
+	option name 'wan'
+	list network '${wan_if}'
+	option masq '${masq}'
+`,
+		"tasks": `
+config task 'backup'
+	option command 'tar czf $${HOME}/etc.tgz /etc'
+`,
+		GlobalsConfig: `
+config variable 'lan_subnet'
+	option value '192.168.7.0/24'
+
+config variable 'wan_if'
+	option value 'eth1'
+
+config variable 'masq'
+	option value 'yes'
+
+config variable 'uplink'
+	option value '${wan_if} via ${lan_subnet}'
+`,
+	} {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	m := NewManager(dir, t.TempDir())
+
+	network, err := m.LoadDecrypted("network")
+	if err != nil {
+		t.Fatalf("LoadDecrypted(network): %v", err)
+	}
+	lan := network.GetSection("interface", "br-lan")
+	if lan.Options["ipaddr"] != "192.168.7.1" || lan.Options["netmask"] != "255.255.255.0" {
+		t.Errorf("lan = %v", lan.Options)
+	}
+	firewall, err := m.LoadExpanded("firewall")
+	if err != nil {
+		t.Fatalf("LoadExpanded(firewall): %v", err)
+	}
+	// Expanded values are normalized like values set directly
+	if wan := firewall.GetSection("zone", "wan"); wan.Lists["network"][0] != "eth1" || wan.Options["masq"] != "1" {
+		t.Errorf("wan zone = %v %v", wan.Options, wan.Lists)
+	}
+	if tasks, err := m.LoadDecrypted("tasks"); err != nil || tasks.GetSection("task", "backup").Options["command"] != "tar czf ${HOME}/etc.tgz /etc" {
+		t.Errorf("escaped ${ = %v", err)
+	}
+
+	values, err := m.Variables()
+	if err != nil {
+		t.Fatalf("Variables: %v", err)
+	}
+	if values["uplink"] != "eth1 via 192.168.7.0/24" {
+		t.Errorf("uplink = %q", values["uplink"])
+	}
+	dependents, err := m.ExpressionDependents([]string{GlobalsConfig})
+	if err != nil || !reflect.DeepEqual(dependents, []string{"firewall", "network"}) {
+		t.Errorf("ExpressionDependents(globals) = %v, %v", dependents, err)
+	}
+
+	for _, tt := range []struct {
+		path, value, want string
+	}{
+		{"globals.wan_if.value", "${uplink}", "variable wan_if: refers to itself: wan_if -> uplink -> wan_if"},
+		{"globals.wan_if.value", "${missing}", `variable wan_if: unknown variable "missing"`},
+		{"globals.wan_if.value", "${wan", "unterminated ${"},
+		{"globals.masq.value", "maybe", "firewall.wan.masq: invalid value"},
+	} {
+		previous, _ := m.Get(tt.path)
+		if err := m.Set(tt.path, tt.value); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := m.LoadDecrypted("firewall"); err == nil || !strings.Contains(err.Error(), tt.want) {
+			t.Errorf("%s = %s: err = %v, want %q", tt.path, tt.value, err, tt.want)
+		}
+		if err := m.Set(tt.path, previous); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := m.Set("globals.wan_if.value", "${wan_if}"); err != nil {
+		t.Fatal(err)
+	}
+	if values, err := m.Variables(); err == nil || values["lan_subnet"] != "192.168.7.0/24" {
+		t.Errorf("Variables with a cycle = %v, %v", values, err)
+	}
+}
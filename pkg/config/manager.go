@@ -1,13 +1,20 @@
 package config
 
 import (
+	"bytes"
+	"errors"
 	"fmt"
 	"io"
 	"os"
 	"path/filepath"
+	"regexp"
+	"slices"
 	"sync"
+	"time"
 
+	"github.com/thesabbir/hellfire/pkg/secrets"
 	"github.com/thesabbir/hellfire/pkg/uci"
+	"github.com/thesabbir/hellfire/pkg/util"
 )
 
 const (
@@ -15,12 +22,26 @@ const (
 	StagingDir       = "/tmp/uci-staging"
 )
 
+// ErrConflict reports that a config changed since the revision a write was
+// based on was read
+var ErrConflict = errors.New("config changed since it was read")
+
+// namePattern matches valid UCI config file names
+var namePattern = regexp.MustCompile(`^[a-zA-Z0-9_\-]+$`)
+
+// ValidName reports whether name is a valid config file name (no paths or dots)
+func ValidName(name string) bool {
+	return namePattern.MatchString(name)
+}
+
 // Manager manages UCI configuration files with staging support
 type Manager struct {
 	configDir  string
 	stagingDir string
 	mu         sync.RWMutex
 	staged     map[string]*uci.Config // staged configs (not yet committed)
+	cache      *configCache           // parsed config files
+	secrets    *secrets.Store         // encrypts secret options on commit (optional)
 }
 
 // NewManager creates a new config manager
@@ -36,6 +57,7 @@ func NewManager(configDir, stagingDir string) *Manager {
 		configDir:  configDir,
 		stagingDir: stagingDir,
 		staged:     make(map[string]*uci.Config),
+		cache:      newConfigCache(),
 	}
 }
 
@@ -44,29 +66,77 @@ func (m *Manager) Load(name string) (*uci.Config, error) {
 	m.mu.RLock()
 	defer m.mu.RUnlock()
 
+	return m.load(name)
+}
+
+// LoadCommitted loads the committed version of a configuration, ignoring
+// any staged changes
+func (m *Manager) LoadCommitted(name string) (*uci.Config, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	return m.cache.load(m.configDir, name)
+}
+
+// load loads a configuration file (must be called with lock held)
+func (m *Manager) load(name string) (*uci.Config, error) {
 	// Check if there's a staged version
 	if staged, ok := m.staged[name]; ok {
 		return staged, nil
 	}
 
-	// Load from disk
-	path := filepath.Join(m.configDir, name)
-	f, err := os.Open(path)
-	if err != nil {
-		if os.IsNotExist(err) {
-			// Return empty config if file doesn't exist
-			return uci.NewConfig(), nil
+	// Load from disk (a copy of the cached parse if the file is unchanged)
+	return m.cache.load(m.configDir, name)
+}
+
+// Checksum returns the SHA256 checksum of a configuration as Load would
+// return it: the serialized staged version if there is one, otherwise the
+// file on disk (the same checksum snapshots record)
+func (m *Manager) Checksum(name string) (string, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	return m.checksum(name)
+}
+
+// checksum returns the checksum of a configuration (must be called with lock held)
+func (m *Manager) checksum(name string) (string, error) {
+	if staged, ok := m.staged[name]; ok {
+		var buf bytes.Buffer
+		if err := uci.Write(&buf, staged); err != nil {
+			return "", fmt.Errorf("failed to serialize staged config %s: %w", name, err)
 		}
-		return nil, fmt.Errorf("failed to open config %s: %w", name, err)
+		return util.Checksum(buf.Bytes()), nil
 	}
-	defer f.Close()
 
-	config, err := uci.Parse(f)
-	if err != nil {
-		return nil, fmt.Errorf("failed to parse config %s: %w", name, err)
+	return m.CommittedChecksum(name)
+}
+
+// CommittedChecksum returns the SHA256 checksum of the committed version of
+// a configuration, ignoring any staged changes
+func (m *Manager) CommittedChecksum(name string) (string, error) {
+	data, err := os.ReadFile(filepath.Join(m.configDir, name))
+	if err != nil && !os.IsNotExist(err) {
+		return "", fmt.Errorf("failed to read config %s: %w", name, err)
 	}
 
-	return config, nil
+	return util.Checksum(data), nil
+}
+
+// checkRevision fails with ErrConflict unless revision is the checksum of the
+// config; an empty revision always matches (must be called with lock held)
+func (m *Manager) checkRevision(name, revision string) error {
+	if revision == "" {
+		return nil
+	}
+	checksum, err := m.checksum(name)
+	if err != nil {
+		return err
+	}
+	if checksum != revision {
+		return fmt.Errorf("%w: %s", ErrConflict, name)
+	}
+	return nil
 }
 
 // Stage stages a configuration for commit
@@ -96,6 +166,10 @@ func (m *Manager) Commit() error {
 	for name, config := range m.staged {
 		path := filepath.Join(m.configDir, name)
 
+		if err := m.sealSecrets(name, config); err != nil {
+			return err
+		}
+
 		// Create temporary file
 		tmpPath := path + ".tmp"
 		f, err := os.Create(tmpPath)
@@ -112,6 +186,7 @@ func (m *Manager) Commit() error {
 		f.Close()
 
 		// Atomic rename
+		m.cache.invalidate(name)
 		if err := os.Rename(tmpPath, path); err != nil {
 			os.Remove(tmpPath)
 			return fmt.Errorf("failed to commit config %s: %w", name, err)
@@ -156,6 +231,53 @@ func (m *Manager) GetChanges() []string {
 	return changes
 }
 
+// ModifiedSince returns the configs whose files were written after t, sorted
+func (m *Manager) ModifiedSince(t time.Time) ([]string, error) {
+	entries, err := os.ReadDir(m.configDir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read config directory: %w", err)
+	}
+
+	var modified []string
+	for _, entry := range entries {
+		if entry.IsDir() || !ValidName(entry.Name()) {
+			continue
+		}
+		info, err := entry.Info()
+		if err != nil {
+			continue // Removed since listing
+		}
+		if info.ModTime().After(t) {
+			modified = append(modified, entry.Name())
+		}
+	}
+	return modified, nil
+}
+
+// Names returns the configs that exist, committed or only staged, sorted
+func (m *Manager) Names() ([]string, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	entries, err := os.ReadDir(m.configDir)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, fmt.Errorf("failed to read config directory: %w", err)
+	}
+	names := make([]string, 0, len(entries)+len(m.staged))
+	for _, entry := range entries {
+		if !entry.IsDir() && ValidName(entry.Name()) {
+			names = append(names, entry.Name())
+		}
+	}
+	for name := range m.staged {
+		if !slices.Contains(names, name) {
+			names = append(names, name)
+		}
+	}
+	slices.Sort(names)
+	return names, nil
+}
+
 // Get gets a value from a config using dot notation (e.g., "network.wan.ipaddr")
 func (m *Manager) Get(path string) (string, error) {
 	configName, sectionName, optionName, err := parsePath(path)
@@ -168,15 +290,7 @@ func (m *Manager) Get(path string) (string, error) {
 		return "", err
 	}
 
-	// Find section by name (for named sections) or by type (for unnamed)
-	var section *uci.Section
-	for _, s := range config.Sections {
-		if s.Name == sectionName || (s.Name == "" && s.Type == sectionName) {
-			section = s
-			break
-		}
-	}
-
+	section := findSection(config, sectionName)
 	if section == nil {
 		return "", fmt.Errorf("section not found: %s", sectionName)
 	}
@@ -195,35 +309,52 @@ func (m *Manager) Get(path string) (string, error) {
 
 // Set sets a value in a config using dot notation
 func (m *Manager) Set(path, value string) error {
+	return m.SetIf(path, value, "")
+}
+
+// SetIf sets a value like Set, provided the config's checksum is still
+// revision (ErrConflict otherwise; an empty revision skips the check). The
+// change is made to a copy of the config under the lock, so concurrent
+// writers to different sections don't lose each other's changes.
+func (m *Manager) SetIf(path, value, revision string) error {
 	configName, sectionName, optionName, err := parsePath(path)
 	if err != nil {
 		return err
 	}
 
-	config, err := m.Load(configName)
-	if err != nil {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if err := m.checkRevision(configName, revision); err != nil {
 		return err
 	}
 
-	// Find or create section
-	var section *uci.Section
-	for _, s := range config.Sections {
-		if s.Name == sectionName || (s.Name == "" && s.Type == sectionName) {
-			section = s
-			break
-		}
+	loaded, err := m.load(configName)
+	if err != nil {
+		return err
 	}
+	config := loaded.Clone()
 
+	// Find or create section
+	section := findSection(config, sectionName)
 	if section == nil {
+		if isSectionRef(sectionName) {
+			return fmt.Errorf("section not found: %s", sectionName)
+		}
 		// Create new section
 		section = uci.NewSection(sectionName, sectionName)
 		config.AddSection(section)
 	}
 
+	value, err = NormalizeOption(configName, section.Type, optionName, value)
+	if err != nil {
+		return err
+	}
 	section.SetOption(optionName, value)
 
 	// Stage the modified config
-	return m.Stage(configName, config)
+	m.staged[configName] = config
+	return nil
 }
 
 // Export exports a configuration to a writer
@@ -0,0 +1,68 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+)
+
+func TestSetConcurrentSections(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "firewall"), []byte("config zone 'lan'\n\toption name 'lan'\n\nconfig zone 'wan'\n\toption name 'wan'\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	m := NewManager(dir, t.TempDir())
+
+	var wg sync.WaitGroup
+	for i := range 50 {
+		for _, zone := range []string{"lan", "wan"} {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				if err := m.Set(fmt.Sprintf("firewall.%s.mtu_fix_%d", zone, i), "1"); err != nil {
+					t.Error(err)
+				}
+			}()
+		}
+	}
+	wg.Wait()
+
+	for i := range 50 {
+		for _, zone := range []string{"lan", "wan"} {
+			if _, err := m.Get(fmt.Sprintf("firewall.%s.mtu_fix_%d", zone, i)); err != nil {
+				t.Errorf("lost update: %v", err)
+			}
+		}
+	}
+}
+
+func TestSetIfRevision(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "firewall"), []byte("config zone 'lan'\n\toption name 'lan'\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	m := NewManager(dir, t.TempDir())
+
+	revision, err := m.Checksum("firewall")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := m.SetIf("firewall.lan.input", "ACCEPT", revision); err != nil {
+		t.Fatalf("SetIf with the current revision: %v", err)
+	}
+	if err := m.SetIf("firewall.lan.input", "DROP", revision); !errors.Is(err, ErrConflict) {
+		t.Fatalf("SetIf with a stale revision: err = %v, want ErrConflict", err)
+	}
+	if _, err := m.BatchIf([]Operation{{Op: OpSet, Path: "firewall.lan.output", Value: "DROP"}}, map[string]string{"firewall": revision}); !errors.Is(err, ErrConflict) {
+		t.Fatalf("BatchIf with a stale revision: err = %v, want ErrConflict", err)
+	}
+	if value, _ := m.Get("firewall.lan.input"); value != "ACCEPT" {
+		t.Errorf("input = %q, want ACCEPT", value)
+	}
+	if _, err := m.Get("firewall.lan.output"); err == nil {
+		t.Error("BatchIf staged changes despite the conflict")
+	}
+}
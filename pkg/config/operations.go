@@ -0,0 +1,179 @@
+package config
+
+import (
+	"maps"
+	"slices"
+	"strconv"
+
+	"github.com/thesabbir/hellfire/pkg/uci"
+)
+
+// Operations returns the batch operations that turn the committed version of
+// a config into the staged one, with secret values decrypted, so the same
+// change can be staged on another router. Named sections are matched by name
+// and unnamed ones by their position among the sections of their type.
+func (m *Manager) Operations(name string) ([]Operation, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	staged, ok := m.staged[name]
+	if !ok {
+		return nil, nil
+	}
+	committed, err := m.cache.load(m.configDir, name)
+	if err != nil {
+		return nil, err
+	}
+	if committed, err = m.decrypt(name, committed); err != nil {
+		return nil, err
+	}
+	if staged, err = m.decrypt(name, staged); err != nil {
+		return nil, err
+	}
+	return diffOperations(name, committed, staged), nil
+}
+
+// SealedOperations returns the batch operations that turn the committed
+// version of a config into the staged one, like Operations, but with secret
+// values encrypted as they would be committed, so the change can be kept and
+// staged again later without storing the secrets in plain text.
+func (m *Manager) SealedOperations(name string) ([]Operation, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	staged, ok := m.staged[name]
+	if !ok {
+		return nil, nil
+	}
+	committed, err := m.cache.load(m.configDir, name)
+	if err != nil {
+		return nil, err
+	}
+	staged = staged.Clone()
+	if err := m.sealSecrets(name, staged); err != nil {
+		return nil, err
+	}
+	return diffOperations(name, committed, staged), nil
+}
+
+// diffOperations lists the operations that turn from into to. Unnamed
+// sections come first, while their @type[index] paths still count the
+// sections of from; named sections are only added at the end.
+func diffOperations(name string, from, to *uci.Config) []Operation {
+	var ops []Operation
+
+	// Unnamed sections, by type in order of appearance
+	var types []string
+	for _, cfg := range []*uci.Config{from, to} {
+		for _, section := range cfg.Sections {
+			if section.Name == "" && !slices.Contains(types, section.Type) {
+				types = append(types, section.Type)
+			}
+		}
+	}
+	for _, sectionType := range types {
+		old, updated := unnamedSections(from, sectionType), unnamedSections(to, sectionType)
+		for i := 0; i < len(old) && i < len(updated); i++ {
+			ops = append(ops, sectionOperations(sectionRef(name, sectionType, old[i].index), old[i].section, updated[i].section)...)
+		}
+		// From the end, so the indexes of the sections left don't move
+		for i := len(old) - 1; i >= len(updated); i-- {
+			ops = append(ops, Operation{Op: OpDelete, Path: sectionRef(name, sectionType, old[i].index)})
+		}
+		// Added sections are the last of their type until the next is added
+		for i := len(old); i < len(updated); i++ {
+			ops = append(ops, Operation{Op: OpAdd, Path: name + "." + sectionType})
+			ops = append(ops, sectionOperations(sectionRef(name, sectionType, -1), uci.NewSection(sectionType, ""), updated[i].section)...)
+		}
+	}
+
+	// Named sections
+	for _, section := range from.Sections {
+		if section.Name != "" && namedSection(to, section.Name) == nil {
+			ops = append(ops, Operation{Op: OpDelete, Path: name + "." + section.Name})
+		}
+	}
+	for _, section := range to.Sections {
+		if section.Name == "" {
+			continue
+		}
+		path := name + "." + section.Name
+		old := namedSection(from, section.Name)
+		if old == nil || old.Type != section.Type {
+			ops = append(ops, Operation{Op: OpSet, Path: path, Value: section.Type})
+		}
+		if old == nil {
+			old = uci.NewSection(section.Type, section.Name)
+		}
+		ops = append(ops, sectionOperations(path, old, section)...)
+	}
+
+	return ops
+}
+
+// sectionOperations lists the operations that turn the options and lists of
+// from into those of to, for the section at path
+func sectionOperations(path string, from, to *uci.Section) []Operation {
+	var ops []Operation
+	for _, option := range slices.Sorted(maps.Keys(from.Options)) {
+		if _, ok := to.Options[option]; !ok {
+			ops = append(ops, Operation{Op: OpDelete, Path: path + "." + option})
+		}
+	}
+	for _, option := range slices.Sorted(maps.Keys(from.Lists)) {
+		if _, ok := to.Lists[option]; !ok {
+			ops = append(ops, Operation{Op: OpDelete, Path: path + "." + option})
+		}
+	}
+	for _, option := range slices.Sorted(maps.Keys(to.Options)) {
+		if value, ok := from.Options[option]; !ok || value != to.Options[option] {
+			ops = append(ops, Operation{Op: OpSet, Path: path + "." + option, Value: to.Options[option]})
+		}
+	}
+	for _, option := range slices.Sorted(maps.Keys(to.Lists)) {
+		values, ok := from.Lists[option]
+		if ok && slices.Equal(values, to.Lists[option]) {
+			continue
+		}
+		if ok {
+			ops = append(ops, Operation{Op: OpDelete, Path: path + "." + option})
+		}
+		for _, value := range to.Lists[option] {
+			ops = append(ops, Operation{Op: OpAddList, Path: path + "." + option, Value: value})
+		}
+	}
+	return ops
+}
+
+// namedSection finds a named section
+func namedSection(cfg *uci.Config, name string) *uci.Section {
+	for _, section := range cfg.Sections {
+		if section.Name == name {
+			return section
+		}
+	}
+	return nil
+}
+
+// indexedSection is an unnamed section with its index among all the
+// sections of its type
+type indexedSection struct {
+	section *uci.Section
+	index   int
+}
+
+// unnamedSections lists the unnamed sections of a type
+func unnamedSections(cfg *uci.Config, sectionType string) []indexedSection {
+	var sections []indexedSection
+	for i, section := range cfg.GetSectionsByType(sectionType) {
+		if section.Name == "" {
+			sections = append(sections, indexedSection{section, i})
+		}
+	}
+	return sections
+}
+
+// sectionRef returns the @type[index] path of an unnamed section
+func sectionRef(name, sectionType string, index int) string {
+	return name + ".@" + sectionType + "[" + strconv.Itoa(index) + "]"
+}
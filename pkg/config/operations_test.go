@@ -0,0 +1,87 @@
+package config
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/thesabbir/hellfire/pkg/uci"
+)
+
+func TestDiffOperations(t *testing.T) {
+	from, err := uci.Parse(strings.NewReader(`
+config defaults
+	option input 'ACCEPT'
+
+config zone 'lan'
+	option name 'lan'
+	list network 'br-lan'
+
+config rule
+	option name 'ssh'
+	option dest_port '22'
+
+config rule 'named'
+	option name 'named'
+
+config rule
+	option name 'ping'
+	list icmp_type 'echo-request'
+
+config zone 'guest'
+	option name 'guest'
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	to, err := uci.Parse(strings.NewReader(`
+config defaults
+	option input 'DROP'
+
+config zone 'lan'
+	option name 'lan'
+	list network 'br-lan'
+	list network 'wg0'
+
+config rule
+	option name 'ssh'
+	option dest_port '2222'
+
+config rule 'named'
+	option name 'named'
+	option target 'DROP'
+
+config redirect
+	option name 'web'
+
+config forwarding 'lan_wan'
+	option src 'lan'
+	option dest 'wan'
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ops := diffOperations("firewall", from, to)
+	result := from.Clone()
+	for _, op := range ops {
+		_, section, option, err := parsePath(op.Path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := applyOperation(result, "firewall", op.Op, section, option, op.Value); err != nil {
+			t.Fatalf("%s %s: %v", op.Op, op.Path, err)
+		}
+	}
+
+	var got, want bytes.Buffer
+	_ = uci.Write(&got, result)
+	_ = uci.Write(&want, to)
+	if got.String() != want.String() {
+		t.Errorf("operations %+v give:\n%s\nwant:\n%s", ops, got.String(), want.String())
+	}
+
+	if ops := diffOperations("firewall", to, to.Clone()); len(ops) != 0 {
+		t.Errorf("unexpected operations for an unchanged config: %+v", ops)
+	}
+}
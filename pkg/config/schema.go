@@ -0,0 +1,266 @@
+package config
+
+import (
+	"fmt"
+	"maps"
+	"slices"
+	"strings"
+
+	"github.com/thesabbir/hellfire/pkg/uci"
+)
+
+// OptionSchema describes the values an option takes
+type OptionSchema struct {
+	Enum []string // Allowed values, matched case-insensitively and stored as listed
+	Bool bool     // A boolean, stored as "1" or "0"
+
+	// Secret values (keys, passwords) are stored encrypted when a secret
+	// store is configured and masked for non-admins
+	Secret bool
+
+	Help    string // What the option does, for forms
+	Default string // The value an unset option behaves as, if any
+}
+
+// Schema lists the options with a fixed set of values, by
+// "config.section_type.option", where a section type ending in "_*" matches
+// every type with that prefix. Setting them to anything else fails
+// right away instead of when the config is applied. It also flags the
+// secret options and describes them for forms.
+var Schema = map[string]OptionSchema{
+	"network.interface.proto":         {Enum: []string{"static", "dhcp", "none", "wireguard", "modemmanager", "qmi"}, Help: "How the interface gets its address"},
+	"network.interface.password":      {Secret: true, Help: "PPPoE or modem password"},
+	"network.interface.private_key":   {Secret: true, Help: "WireGuard private key"},
+	"network.interface.route_all":     {Bool: true, Default: "0", Help: "Route all traffic through the tunnel"},
+	"network.interface.kill_switch":   {Bool: true, Default: "0", Help: "Drop forwarded traffic that would leave outside the tunnel"},
+	"network.interface.peerdns":       {Bool: true, Default: "1", Help: "Use the DNS servers of the DHCP lease or modem bearer"},
+	"network.interface.pincode":       {Secret: true, Help: "SIM PIN"},
+	"network.interface.allow_roaming": {Bool: true, Default: "0", Help: "Connect the modem while roaming"},
+	"network.interface.auth":          {Enum: []string{"none", "pap", "chap", "both"}, Help: "Modem bearer authentication"},
+	"network.interface.iptype":        {Enum: []string{"ipv4", "ipv6", "ipv4v6"}, Default: "ipv4v6", Help: "Address families of the modem bearer"},
+
+	// WireGuard peers, typed wireguard_<interface>
+	"network.wireguard_*.private_key":       {Secret: true, Help: "Private key of the peer, for its generated config"},
+	"network.wireguard_*.preshared_key":     {Secret: true, Help: "Preshared key shared with the peer"},
+	"network.wireguard_*.route_allowed_ips": {Bool: true, Default: "0", Help: "Route the peer's allowed IPs through the interface"},
+	"network.wireguard_*.disabled":          {Bool: true, Default: "0", Help: "Leave the peer out of the interface"},
+
+	"wireless.wifi-iface.key":         {Secret: true, Help: "Pre-shared key"},
+	"wireless.wifi-iface.auth_secret": {Secret: true, Help: "RADIUS authentication secret"},
+	"wireless.wifi-iface.acct_secret": {Secret: true, Help: "RADIUS accounting secret"},
+
+	"ddns.service.password": {Secret: true, Help: "Password of the DDNS account"},
+
+	"firewall.defaults.input":   {Enum: firewallPolicies, Help: "Policy for traffic to the router"},
+	"firewall.defaults.output":  {Enum: firewallPolicies, Help: "Policy for traffic from the router"},
+	"firewall.defaults.forward": {Enum: firewallPolicies, Help: "Policy for traffic through the router"},
+	"firewall.zone.input":       {Enum: firewallPolicies, Help: "Policy for traffic from the zone to the router"},
+	"firewall.zone.output":      {Enum: firewallPolicies, Help: "Policy for traffic from the router to the zone"},
+	"firewall.zone.forward":     {Enum: firewallPolicies, Help: "Policy for traffic forwarded between the zone's interfaces"},
+	"firewall.zone.masq":        {Bool: true, Default: "0", Help: "Masquerade traffic leaving through the zone"},
+	"firewall.rule.target":      {Enum: firewallPolicies, Help: "What to do with matching traffic"},
+	"firewall.rule.proto":       {Enum: []string{"tcp", "udp", "icmp", "icmpv6", "esp", "ah", "sctp", "all"}, Help: "Protocol to match"},
+	"firewall.quota.action":     {Enum: []string{"block", "throttle"}, Help: "What happens to a device over its quota"},
+
+	"dhcp.dhcp.ignore":               {Bool: true, Default: "0", Help: "Don't serve DHCP on the interface"},
+	"dhcp.host.dns":                  {Bool: true, Default: "0", Help: "Resolve the host's name to its address"},
+	"dhcp.dnsmasq.domainneeded":      {Bool: true, Help: "Don't forward names without a dot"},
+	"dhcp.dnsmasq.boguspriv":         {Bool: true, Help: "Don't forward reverse lookups of private addresses"},
+	"dhcp.dnsmasq.filterwin2k":       {Bool: true, Help: "Don't forward Windows dial-on-demand lookups"},
+	"dhcp.dnsmasq.localise_queries":  {Bool: true, Help: "Answer with the address of the interface a query came in on"},
+	"dhcp.dnsmasq.rebind_protection": {Bool: true, Help: "Reject upstream answers with private addresses"},
+	"dhcp.dnsmasq.rebind_localhost":  {Bool: true, Help: "Allow upstream answers with loopback addresses"},
+	"dhcp.dnsmasq.expandhosts":       {Bool: true, Help: "Add the local domain to names from the hosts file"},
+	"dhcp.dnsmasq.nonegcache":        {Bool: true, Help: "Don't cache negative answers"},
+	"dhcp.dnsmasq.authoritative":     {Bool: true, Help: "Be the only DHCP server on the network"},
+	"dhcp.dnsmasq.readethers":        {Bool: true, Help: "Read static leases from /etc/ethers"},
+	"dhcp.dnsmasq.localservice":      {Bool: true, Help: "Only answer DNS queries from local subnets"},
+	"dhcp.dnsmasq.logqueries":        {Bool: true, Help: "Log DNS queries"},
+	"dhcp.dnsmasq.noresolv":          {Bool: true, Help: "Don't read upstream servers from resolv.conf"},
+
+	"openvpn.openvpn.enabled":  {Bool: true, Default: "1", Help: "Run the instance"},
+	"openvpn.openvpn.mode":     {Enum: []string{"server", "client"}, Default: "server", Help: "Accept clients or connect to a server"},
+	"openvpn.openvpn.dev_type": {Enum: []string{"tun", "tap"}, Default: "tun", Help: "Routed (tun) or bridged (tap) tunnel"},
+	"openvpn.openvpn.proto":    {Enum: []string{"udp", "tcp"}, Default: "udp", Help: "Transport protocol"},
+	"openvpn.openvpn.password": {Secret: true, Help: "Password of the client's account"},
+
+	"ipsec.tunnel.enabled":      {Bool: true, Default: "1", Help: "Set up the tunnel"},
+	"ipsec.tunnel.keyexchange":  {Enum: []string{"ikev2", "ikev1"}, Default: "ikev2", Help: "IKE version"},
+	"ipsec.tunnel.auth":         {Enum: []string{"psk", "pubkey"}, Default: "psk", Help: "Authenticate with a pre-shared key or certificates"},
+	"ipsec.tunnel.start_action": {Enum: []string{"start", "trap", "none"}, Default: "start", Help: "Start the tunnel right away, on traffic, or only from the remote side"},
+	"ipsec.tunnel.psk":          {Secret: true, Help: "Pre-shared key"},
+
+	"tasks.task.enabled": {Bool: true, Default: "1", Help: "Run the task on its schedule"},
+	"tasks.task.action":  {Enum: []string{"reboot", "snapshot", "backup", "prune_snapshots"}, Help: "Built-in action to run, instead of a command"},
+}
+
+var firewallPolicies = []string{"ACCEPT", "DROP", "REJECT"}
+
+// Boolean spellings accepted for boolean options
+var (
+	trueValues  = []string{"1", "true", "on", "yes", "enabled"}
+	falseValues = []string{"0", "false", "off", "no", "disabled"}
+)
+
+// IsSecret reports whether an option is flagged secret in the schema
+func IsSecret(configName, sectionType, option string) bool {
+	return schemaFor(configName, sectionType, option).Secret
+}
+
+// schemaFor looks up an option's schema by its section type, then by the
+// type's prefix
+func schemaFor(configName, sectionType, option string) OptionSchema {
+	if schema, ok := Schema[configName+"."+sectionType+"."+option]; ok {
+		return schema
+	}
+	if prefix, _, ok := strings.Cut(sectionType, "_"); ok {
+		return Schema[configName+"."+prefix+"_*."+option]
+	}
+	return OptionSchema{}
+}
+
+// OptionInfo describes an option of the schema, for forms
+type OptionInfo struct {
+	Name    string   `json:"name"`
+	Type    string   `json:"type" enums:"bool,enum,string"`
+	Enum    []string `json:"enum,omitempty"`
+	Default string   `json:"default,omitempty"`
+	Help    string   `json:"help,omitempty"`
+	Secret  bool     `json:"secret,omitempty"`
+}
+
+// SectionInfo describes the options of a section type; a type ending in
+// "_*" matches every type with that prefix
+type SectionInfo struct {
+	Type    string       `json:"type"`
+	Options []OptionInfo `json:"options"`
+}
+
+// ConfigSchema returns the schema of a config's options by section type,
+// sorted by type and option, or nil if the schema has none. Options take
+// the values their type says: "1" or "0" for bool, one of the enum values
+// for enum, and anything for string.
+func ConfigSchema(configName string) []SectionInfo {
+	var sections []SectionInfo
+	for _, key := range slices.Sorted(maps.Keys(Schema)) {
+		name, rest, _ := strings.Cut(key, ".")
+		sectionType, option, _ := strings.Cut(rest, ".")
+		if name != configName {
+			continue
+		}
+
+		schema := Schema[key]
+		info := OptionInfo{Name: option, Type: "string", Default: schema.Default, Help: schema.Help, Secret: schema.Secret}
+		switch {
+		case schema.Bool:
+			info.Type = "bool"
+		case len(schema.Enum) > 0:
+			info.Type, info.Enum = "enum", schema.Enum
+		}
+		if n := len(sections); n == 0 || sections[n-1].Type != sectionType {
+			sections = append(sections, SectionInfo{Type: sectionType})
+		}
+		sections[len(sections)-1].Options = append(sections[len(sections)-1].Options, info)
+	}
+	return sections
+}
+
+// ValueError is a value an option doesn't accept
+type ValueError struct {
+	Path    string // config.section_type.option
+	Value   string
+	Allowed []string
+}
+
+func (e *ValueError) Error() string {
+	msg := fmt.Sprintf("invalid value %q for %s: must be one of %s", e.Value, e.Path, strings.Join(e.Allowed, ", "))
+	if suggestion := closest(e.Value, e.Allowed); suggestion != "" {
+		msg += fmt.Sprintf(" (did you mean %q?)", suggestion)
+	}
+	return msg
+}
+
+// NormalizeOption checks a value against the schema of its option and
+// returns it in canonical form; options without a schema take any value.
+// Values with variables or expressions are checked once they are expanded.
+func NormalizeOption(configName, sectionType, option, value string) (string, error) {
+	path := configName + "." + sectionType + "." + option
+	schema := schemaFor(configName, sectionType, option)
+	if (!schema.Bool && len(schema.Enum) == 0) || hasTemplate(value) {
+		return value, nil
+	}
+
+	if schema.Bool {
+		for _, v := range trueValues {
+			if strings.EqualFold(value, v) {
+				return "1", nil
+			}
+		}
+		for _, v := range falseValues {
+			if strings.EqualFold(value, v) {
+				return "0", nil
+			}
+		}
+		return "", &ValueError{Path: path, Value: value, Allowed: []string{"1", "0", "true", "false", "on", "off"}}
+	}
+
+	for _, v := range schema.Enum {
+		if strings.EqualFold(value, v) {
+			return v, nil
+		}
+	}
+	return "", &ValueError{Path: path, Value: value, Allowed: schema.Enum}
+}
+
+// CheckSchema checks every option of a config against the schema, for
+// configs that weren't edited option by option (e.g. staged whole)
+func CheckSchema(configName string, cfg *uci.Config) []error {
+	var errs []error
+	counts := make(map[string]int)
+	for _, section := range cfg.Sections {
+		id := section.Name
+		if id == "" {
+			id = fmt.Sprintf("@%s[%d]", section.Type, counts[section.Type])
+		}
+		counts[section.Type]++
+
+		for _, option := range slices.Sorted(maps.Keys(section.Options)) {
+			if _, err := NormalizeOption(configName, section.Type, option, section.Options[option]); err != nil {
+				errs = append(errs, fmt.Errorf("%s.%s: %w", id, option, err))
+			}
+		}
+	}
+	return errs
+}
+
+// closest returns the allowed value within two edits of value, if any
+func closest(value string, allowed []string) string {
+	best, bestDistance := "", 3
+	for _, candidate := range allowed {
+		if d := editDistance(strings.ToLower(value), strings.ToLower(candidate)); d < bestDistance {
+			best, bestDistance = candidate, d
+		}
+	}
+	return best
+}
+
+// editDistance is the Levenshtein distance between a and b
+func editDistance(a, b string) int {
+	prev := make([]int, len(b)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(a); i++ {
+		cur := make([]int, len(b)+1)
+		cur[0] = i
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			cur[j] = min(prev[j]+1, cur[j-1]+1, prev[j-1]+cost)
+		}
+		prev = cur
+	}
+	return prev[len(b)]
+}
@@ -0,0 +1,38 @@
+package config
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestConfigSchema(t *testing.T) {
+	sections := ConfigSchema("ipsec")
+	if len(sections) != 1 || sections[0].Type != "tunnel" {
+		t.Fatalf("ipsec schema = %+v", sections)
+	}
+	options := make(map[string]OptionInfo)
+	for _, option := range sections[0].Options {
+		options[option.Name] = option
+	}
+	if got := options["keyexchange"]; got.Type != "enum" || !reflect.DeepEqual(got.Enum, []string{"ikev2", "ikev1"}) || got.Default != "ikev2" || got.Help == "" {
+		t.Errorf("keyexchange = %+v", got)
+	}
+	if got := options["enabled"]; got.Type != "bool" || got.Default != "1" {
+		t.Errorf("enabled = %+v", got)
+	}
+	if got := options["psk"]; got.Type != "string" || !got.Secret {
+		t.Errorf("psk = %+v", got)
+	}
+
+	// Peer options are listed under the prefix their section types share
+	var types []string
+	for _, section := range ConfigSchema("network") {
+		types = append(types, section.Type)
+	}
+	if !reflect.DeepEqual(types, []string{"interface", "wireguard_*"}) {
+		t.Errorf("network section types = %v", types)
+	}
+	if sections := ConfigSchema("system"); sections != nil {
+		t.Errorf("system schema = %+v", sections)
+	}
+}
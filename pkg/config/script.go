@@ -0,0 +1,149 @@
+package config
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// Script is a parsed uci batch script
+type Script struct {
+	Operations []Operation
+	Lines      []int // Script line of each operation
+	Commit     bool  // The script ends with commit
+}
+
+// ScriptError reports a line of a batch script that can't be run
+type ScriptError struct {
+	Line int
+	Err  error
+}
+
+func (e *ScriptError) Error() string {
+	return fmt.Sprintf("line %d: %v", e.Line, e.Err)
+}
+
+func (e *ScriptError) Unwrap() error {
+	return e.Err
+}
+
+// ParseScript parses the commands of a `uci batch` script: set, add,
+// add_list, del_list, delete and commit, one per line, with shell-style
+// quoting. Blank lines and # comments are skipped.
+func ParseScript(r io.Reader) (*Script, error) {
+	script := &Script{}
+	scanner := bufio.NewScanner(r)
+	for lineNo := 1; scanner.Scan(); lineNo++ {
+		words, err := splitWords(scanner.Text())
+		if err != nil {
+			return nil, &ScriptError{Line: lineNo, Err: err}
+		}
+		if len(words) == 0 {
+			continue
+		}
+
+		op, err := parseCommand(words)
+		if err != nil {
+			return nil, &ScriptError{Line: lineNo, Err: err}
+		}
+		if op == nil {
+			script.Commit = true
+			continue
+		}
+		if script.Commit {
+			return nil, &ScriptError{Line: lineNo, Err: fmt.Errorf("changes after commit are not supported; split the script")}
+		}
+		script.Operations = append(script.Operations, *op)
+		script.Lines = append(script.Lines, lineNo)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return script, nil
+}
+
+// parseCommand turns a script command into an operation; commit returns
+// nil. The script is one changeset, so commit takes it all whatever configs
+// it names.
+func parseCommand(words []string) (*Operation, error) {
+	cmd, args := words[0], words[1:]
+	switch cmd {
+	case "commit":
+		return nil, nil
+
+	case OpSet, OpAddList, OpDelList:
+		if len(args) != 1 {
+			return nil, fmt.Errorf("usage: %s <config>.<section>[.<option>]=<value>", cmd)
+		}
+		path, value, ok := strings.Cut(args[0], "=")
+		if !ok {
+			return nil, fmt.Errorf("%s needs a value: %s", cmd, args[0])
+		}
+		return &Operation{Op: cmd, Path: path, Value: value}, nil
+
+	case OpAdd:
+		if len(args) != 2 {
+			return nil, fmt.Errorf("usage: add <config> <section-type>")
+		}
+		return &Operation{Op: OpAdd, Path: args[0] + "." + args[1]}, nil
+
+	case OpDelete:
+		if len(args) != 1 {
+			return nil, fmt.Errorf("usage: delete <config>.<section>[.<option>]")
+		}
+		path, value, _ := strings.Cut(args[0], "=")
+		if value != "" {
+			// uci deletes a single list value this way
+			return &Operation{Op: OpDelList, Path: path, Value: value}, nil
+		}
+		return &Operation{Op: OpDelete, Path: path}, nil
+
+	case "rename", "reorder", "revert", "import", "export", "show", "get", "changes":
+		return nil, fmt.Errorf("%s is not supported in batch scripts", cmd)
+
+	default:
+		return nil, fmt.Errorf("unknown command: %s", cmd)
+	}
+}
+
+// splitWords splits a line into words the way a shell would: whitespace
+// separates words, quotes group them, and # starts a comment
+func splitWords(line string) ([]string, error) {
+	var words []string
+	var word strings.Builder
+	inWord := false
+	var quote rune
+
+	for _, r := range line {
+		switch {
+		case quote != 0:
+			if r == quote {
+				quote = 0
+			} else {
+				word.WriteRune(r)
+			}
+		case r == '\'' || r == '"':
+			quote = r
+			inWord = true
+		case r == ' ' || r == '\t':
+			if inWord {
+				words = append(words, word.String())
+				word.Reset()
+				inWord = false
+			}
+		case r == '#' && !inWord:
+			return words, nil
+		default:
+			word.WriteRune(r)
+			inWord = true
+		}
+	}
+	if quote != 0 {
+		return nil, fmt.Errorf("unterminated quote")
+	}
+	if inWord {
+		words = append(words, word.String())
+	}
+	return words, nil
+}
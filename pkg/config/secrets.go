@@ -0,0 +1,200 @@
+package config
+
+import (
+	"fmt"
+
+	"github.com/thesabbir/hellfire/pkg/secrets"
+	"github.com/thesabbir/hellfire/pkg/uci"
+)
+
+// SetSecrets sets the store secret options are encrypted with when they are
+// committed. Without one they are written as given.
+func (m *Manager) SetSecrets(store *secrets.Store) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.secrets = store
+}
+
+// LoadDecrypted loads a configuration with its sealed values decrypted and
+// the expressions in its values evaluated, for appliers to render. Fails
+// if a value can't be decrypted rather than handing the ciphertext on.
+func (m *Manager) LoadDecrypted(name string) (*uci.Config, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	cfg, err := m.load(name)
+	if err != nil {
+		return nil, err
+	}
+	if cfg, err = m.decrypt(name, cfg); err != nil {
+		return nil, err
+	}
+	cfg, _, err = expand(name, cfg, m.load)
+	return cfg, err
+}
+
+// LoadCommittedDecrypted loads the committed version of a configuration,
+// ignoring any staged changes, with its sealed values decrypted and its
+// expressions evaluated against the committed configs
+func (m *Manager) LoadCommittedDecrypted(name string) (*uci.Config, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	cfg, err := m.cache.load(m.configDir, name)
+	if err != nil {
+		return nil, err
+	}
+	if cfg, err = m.decrypt(name, cfg); err != nil {
+		return nil, err
+	}
+	cfg, _, err = expand(name, cfg, func(name string) (*uci.Config, error) {
+		return m.cache.load(m.configDir, name)
+	})
+	return cfg, err
+}
+
+// decrypt returns a copy of cfg with its sealed values decrypted (must be
+// called with lock held)
+func (m *Manager) decrypt(name string, cfg *uci.Config) (*uci.Config, error) {
+	cfg = cfg.Clone()
+	for _, section := range cfg.Sections {
+		for option, value := range section.Options {
+			if !secrets.IsSealed(value) {
+				continue
+			}
+			path := SecretPath(name, section, option)
+			plaintext, err := m.secrets.Open(path, value)
+			if err != nil {
+				return nil, fmt.Errorf("%s: %w", path, err)
+			}
+			section.Options[option] = plaintext
+		}
+	}
+	return cfg, nil
+}
+
+// IsSensitive reports whether an option's value is redacted in output: it
+// is flagged secret in the schema or its name is registered as sensitive
+func IsSensitive(configName, sectionType, option string) bool {
+	return IsSecret(configName, sectionType, option) || secrets.IsSensitive(option)
+}
+
+// IsSensitiveOption reports whether the option at a dot-notation path is
+// sensitive, resolving the type of its section
+func (m *Manager) IsSensitiveOption(path string) bool {
+	configName, sectionName, optionName, err := parsePath(path)
+	if err != nil {
+		return false
+	}
+
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	cfg, err := m.load(configName)
+	if err != nil {
+		return false
+	}
+	section := findSection(cfg, sectionName)
+	if section == nil {
+		return secrets.IsSensitive(optionName)
+	}
+	return IsSensitive(configName, section.Type, optionName)
+}
+
+// sealSecrets encrypts the secret options of a config in place (must be
+// called with lock held)
+func (m *Manager) sealSecrets(name string, cfg *uci.Config) error {
+	if m.secrets == nil {
+		return nil
+	}
+
+	for _, section := range cfg.Sections {
+		for option, value := range section.Options {
+			if !IsSecret(name, section.Type, option) || secrets.IsSealed(value) {
+				continue
+			}
+			path := SecretPath(name, section, option)
+			sealed, err := m.secrets.Seal(path, value)
+			if err != nil {
+				return fmt.Errorf("failed to encrypt %s: %w", path, err)
+			}
+			section.Options[option] = sealed
+		}
+	}
+	return nil
+}
+
+// MaskSecrets returns a copy of a config with its sensitive and sealed
+// values replaced by secrets.Mask, for users who may not see them
+func MaskSecrets(name string, cfg *uci.Config) *uci.Config {
+	masked := cfg.Clone()
+	for _, section := range masked.Sections {
+		MaskSectionSecrets(name, section)
+	}
+	return masked
+}
+
+// MaskSectionSecrets replaces the sensitive and sealed values of a section
+// in place
+func MaskSectionSecrets(name string, section *uci.Section) {
+	for option, value := range section.Options {
+		if IsSensitive(name, section.Type, option) || secrets.IsSealed(value) {
+			section.Options[option] = secrets.Mask
+		}
+	}
+}
+
+// maskDiff masks the sensitive values of both versions of a config for a
+// diff. Values changed by the staged version are masked as "******** (changed)"
+// so the diff still shows the change.
+func maskDiff(name string, committed, staged *uci.Config) (*uci.Config, *uci.Config) {
+	from, to := MaskSecrets(name, committed), staged.Clone()
+
+	counts := make(map[string]int)
+	for _, section := range to.Sections {
+		var before *uci.Section
+		if section.Name != "" {
+			before = committed.GetSection(section.Type, section.Name)
+		} else {
+			before = nthSection(committed, section.Type, counts[section.Type])
+			counts[section.Type]++
+		}
+
+		for option, value := range section.Options {
+			if !IsSensitive(name, section.Type, option) && !secrets.IsSealed(value) {
+				continue
+			}
+			section.Options[option] = secrets.Mask
+			if before == nil || before.Options[option] != value {
+				section.Options[option] = secrets.Mask + " (changed)"
+			}
+		}
+	}
+	return from, to
+}
+
+// nthSection returns the index-th unnamed section of a type, or nil
+func nthSection(cfg *uci.Config, sectionType string, index int) *uci.Section {
+	for _, section := range cfg.Sections {
+		if section.Type != sectionType || section.Name != "" {
+			continue
+		}
+		if index == 0 {
+			return section
+		}
+		index--
+	}
+	return nil
+}
+
+// SecretPath returns the path a secret option's value is sealed for:
+// config.section.option, with "@type" for an anonymous section rather than
+// its index, so the path doesn't change when sections are added or reordered
+func SecretPath(name string, section *uci.Section, option string) string {
+	id := section.Name
+	if id == "" {
+		id = "@" + section.Type
+	}
+	return name + "." + id + "." + option
+}
@@ -75,9 +75,18 @@ func Initialize(cfg *Config) error {
 	if err := db.AutoMigrate(
 		&User{},
 		&Session{},
+		&KnownDevice{},
 		&APIKey{},
 		&AuditLog{},
+		&OnboardingToken{},
 		&Transaction{},
+		&TransactionArtifact{},
+		&TransactionCapture{},
+		&WANQualitySample{},
+		&Certificate{},
+		&TaskRun{},
+		&ConfigGeneration{},
+		&ConfigLock{},
 	); err != nil {
 		return fmt.Errorf("failed to migrate database: %w", err)
 	}
@@ -107,6 +116,21 @@ func Close() error {
 	return sqlDB.Close()
 }
 
+// Backup writes a consistent copy of the database to path
+func Backup(path string) error {
+	if DB == nil {
+		return fmt.Errorf("database not initialized")
+	}
+
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return err
+	}
+	if err := DB.Exec("VACUUM INTO ?", path).Error; err != nil {
+		return fmt.Errorf("failed to back up database: %w", err)
+	}
+	return os.Chmod(path, 0600)
+}
+
 // gormLogAdapter adapts GORM logger to our structured logger
 type gormLogAdapter struct{}
 
@@ -48,6 +48,10 @@ type Session struct {
 	IPAddress      string    `gorm:"index" json:"ip_address"`
 	UserAgent      string    `json:"user_agent"`
 	Fingerprint    string    `gorm:"not null;index" json:"fingerprint"` // SHA256(IP + UA)
+
+	// Set on sessions an admin opened to act as the user (impersonation)
+	ImpersonatorID *uint `gorm:"index" json:"impersonator_id,omitempty"`
+	Impersonator   *User `gorm:"foreignKey:ImpersonatorID" json:"impersonator,omitempty"`
 }
 
 // TableName overrides the table name
@@ -115,6 +119,11 @@ type AuditLog struct {
 	Error     string `gorm:"type:text" json:"error,omitempty"`             // Error message if failed
 	Duration  int64  `json:"duration_ms,omitempty"`                        // Duration in milliseconds
 	TxID      string `gorm:"index" json:"transaction_id,omitempty"`        // Transaction ID if applicable
+	RequestID string `gorm:"index" json:"request_id,omitempty"`            // API request ID (X-Request-ID) if applicable
+
+	// The admin acting as the user through an impersonated session
+	ImpersonatorID *uint  `gorm:"index" json:"impersonator_id,omitempty"`
+	Impersonator   string `gorm:"index" json:"impersonator,omitempty"` // Denormalized username
 }
 
 // TableName overrides the table name
@@ -122,6 +131,39 @@ func (AuditLog) TableName() string {
 	return "audit_logs"
 }
 
+// KnownDevice is a device (IP address and User-Agent fingerprint, as bound
+// to sessions) a user has logged in from
+type KnownDevice struct {
+	ID        uint      `gorm:"primarykey" json:"id"`
+	CreatedAt time.Time `json:"created_at"` // First login from the device
+
+	UserID      uint      `gorm:"not null;uniqueIndex:idx_known_device" json:"user_id"`
+	Fingerprint string    `gorm:"not null;uniqueIndex:idx_known_device" json:"fingerprint"` // SHA256(IP + UA), as in Session
+	IPAddress   string    `json:"ip_address"`
+	UserAgent   string    `json:"user_agent"`
+	LastSeenAt  time.Time `json:"last_seen_at"` // Last login from the device
+}
+
+// TableName overrides the table name
+func (KnownDevice) TableName() string {
+	return "known_devices"
+}
+
+// OnboardingToken is the one-time token that authorizes creating the first
+// admin user. Only its hash is stored; the token itself is printed once.
+type OnboardingToken struct {
+	ID        uint      `gorm:"primarykey" json:"id"`
+	CreatedAt time.Time `json:"created_at"`
+
+	TokenHash string    `gorm:"uniqueIndex;not null" json:"-"` // SHA256 of the token
+	ExpiresAt time.Time `gorm:"not null" json:"expires_at"`
+}
+
+// TableName overrides the table name
+func (OnboardingToken) TableName() string {
+	return "onboarding_tokens"
+}
+
 // Transaction represents a configuration transaction
 type Transaction struct {
 	ID        uint      `gorm:"primarykey" json:"id"`
@@ -140,9 +182,162 @@ type Transaction struct {
 	CompletedAt  *time.Time `json:"completed_at,omitempty"`
 	RolledBackAt *time.Time `json:"rolled_back_at,omitempty"`
 	Error        string     `gorm:"type:text" json:"error,omitempty"`
+	Phases       string     `gorm:"type:text" json:"phases,omitempty"` // JSON object of phase name -> duration in ms
+
+	// Why the transaction was rolled back: the reason a user gave, or the
+	// error that triggered it and the applier that failed, if any
+	RollbackReason string `gorm:"type:text" json:"rollback_reason,omitempty"`
+	FailedApplier  string `json:"failed_applier,omitempty"`
+
+	// JSON of the changes staged for the transaction, with secrets encrypted,
+	// so a failed transaction can be retried
+	Changes string `gorm:"type:text" json:"-"`
+
+	// Staged deployment: the canary router the changes were applied to first,
+	// its transaction and how it ended there
+	Canary       string `json:"canary,omitempty"`
+	CanaryTxID   string `json:"canary_transaction_id,omitempty"`
+	CanaryStatus string `json:"canary_status,omitempty"` // "applied", "confirmed", "rolledback" or "failed"
 }
 
 // TableName overrides the table name
 func (Transaction) TableName() string {
 	return "transactions"
 }
+
+// TransactionArtifact is a file or command script an applier generated and
+// pushed to the system during a transaction, archived byte for byte
+type TransactionArtifact struct {
+	ID        uint      `gorm:"primarykey" json:"id"`
+	CreatedAt time.Time `json:"created_at"`
+
+	TxID    string `gorm:"index;not null" json:"transaction_id"`
+	Stage   string `gorm:"not null" json:"stage"`  // "apply", or "rollback" when re-applied to undo the transaction
+	Config  string `gorm:"not null" json:"config"` // Config (and applier) that generated it
+	Name    string `gorm:"not null" json:"name"`   // Artifact name, e.g. a file path or "nftables ruleset"
+	Content string `gorm:"type:text" json:"content"`
+	SHA256  string `gorm:"not null" json:"sha256"` // Hex SHA-256 of Content
+}
+
+// TableName overrides the table name
+func (TransactionArtifact) TableName() string {
+	return "transaction_artifacts"
+}
+
+// TransactionCapture is a dump of the system state the appliers configure
+// (addresses, routes, the ruleset, services), taken before or after the
+// configs of a transaction were applied, gzipped
+type TransactionCapture struct {
+	ID        uint      `gorm:"primarykey" json:"id"`
+	CreatedAt time.Time `json:"created_at"`
+
+	TxID      string `gorm:"index;not null" json:"transaction_id"`
+	Stage     string `gorm:"not null" json:"stage"` // "before" or "after"
+	Data      []byte `json:"-"`                     // Gzipped text of the dump
+	Size      int    `json:"size"`                  // Bytes of the dump before compression
+	Truncated bool   `json:"truncated"`             // Cut at the size cap
+}
+
+// TableName overrides the table name
+func (TransactionCapture) TableName() string {
+	return "transaction_captures"
+}
+
+// WANQualitySample is one measurement of the WAN link: a ping round to a
+// target, or a throughput test
+type WANQualitySample struct {
+	ID        uint      `gorm:"primarykey" json:"id"`
+	CreatedAt time.Time `gorm:"index" json:"created_at"`
+
+	Kind         string  `gorm:"index;not null" json:"kind"`   // "ping" or "speedtest"
+	Target       string  `gorm:"index;not null" json:"target"` // Pinged host or test server
+	LatencyMs    float64 `json:"latency_ms"`                   // Average round trip
+	JitterMs     float64 `json:"jitter_ms"`                    // Mean deviation of the round trips
+	LossPercent  float64 `json:"loss_percent"`
+	DownloadMbps float64 `json:"download_mbps,omitempty"`
+	UploadMbps   float64 `json:"upload_mbps,omitempty"`
+	Degraded     bool    `json:"degraded"` // Over one of the configured thresholds
+	Error        string  `gorm:"type:text" json:"error,omitempty"`
+}
+
+// TableName overrides the table name
+func (WANQualitySample) TableName() string {
+	return "wan_quality_samples"
+}
+
+// Certificate is a certificate hellfire uses or stores, as last inspected
+type Certificate struct {
+	ID        uint      `gorm:"primarykey" json:"id"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+
+	Name        string    `gorm:"uniqueIndex;not null" json:"name"`
+	Usage       string    `gorm:"not null" json:"usage"` // e.g. "grpc-server", "uploaded"
+	Path        string    `gorm:"not null" json:"path"`
+	KeyPath     string    `json:"key_path,omitempty"`
+	Subject     string    `json:"subject,omitempty"`
+	Issuer      string    `json:"issuer,omitempty"`
+	DNSNames    []string  `gorm:"serializer:json" json:"dns_names,omitempty"`
+	Serial      string    `json:"serial,omitempty"`
+	Fingerprint string    `json:"fingerprint,omitempty"` // SHA-256, hex
+	NotBefore   time.Time `json:"not_before"`
+	NotAfter    time.Time `gorm:"index" json:"not_after"`
+	IsCA        bool      `json:"is_ca"`
+	LastChecked time.Time `json:"last_checked"`
+	Error       string    `gorm:"type:text" json:"error,omitempty"` // Why the file couldn't be read
+}
+
+// TableName overrides the table name
+func (Certificate) TableName() string {
+	return "certificates"
+}
+
+// TaskRun is one run of a scheduled task
+type TaskRun struct {
+	ID        uint      `gorm:"primarykey" json:"id"`
+	CreatedAt time.Time `gorm:"index" json:"created_at"`
+
+	Task       string    `gorm:"index;not null" json:"task"`
+	Trigger    string    `gorm:"not null" json:"trigger"` // "schedule" or "manual"
+	Status     string    `gorm:"not null" json:"status"`  // "success", "failure" or "timeout"
+	StartedAt  time.Time `json:"started_at"`
+	FinishedAt time.Time `json:"finished_at"`
+	DurationMs int64     `json:"duration_ms"`
+	Output     string    `gorm:"type:text" json:"output,omitempty"` // Combined output, truncated
+	Error      string    `gorm:"type:text" json:"error,omitempty"`
+}
+
+// TableName overrides the table name
+func (TaskRun) TableName() string {
+	return "task_runs"
+}
+
+// ConfigGeneration counts the changes to the committed configs (commits and
+// rollbacks), so clients can tell when their cached views are stale. It has
+// a single row.
+type ConfigGeneration struct {
+	ID         uint      `gorm:"primarykey" json:"-"`
+	UpdatedAt  time.Time `json:"updated_at"`
+	Generation uint64    `gorm:"not null" json:"generation"`
+}
+
+// TableName overrides the table name
+func (ConfigGeneration) TableName() string {
+	return "config_generation"
+}
+
+// ConfigLock is an advisory lock on a config, taken by an operator for a
+// long edit: writes by others still go through but carry a warning. It
+// expires unless renewed, and admins can break it.
+type ConfigLock struct {
+	Config    string    `gorm:"primarykey" json:"config"`
+	CreatedAt time.Time `json:"created_at"`
+	Username  string    `gorm:"not null" json:"username"`
+	Note      string    `json:"note,omitempty"`
+	ExpiresAt time.Time `gorm:"not null;index" json:"expires_at"`
+}
+
+// TableName overrides the table name
+func (ConfigLock) TableName() string {
+	return "config_locks"
+}
@@ -1,6 +1,7 @@
 package db
 
 import (
+	"errors"
 	"fmt"
 	"time"
 
@@ -99,7 +100,7 @@ func GetSessionByToken(token string) (*Session, error) {
 	}
 
 	var session Session
-	if err := DB.Preload("User").Where("token = ?", token).First(&session).Error; err != nil {
+	if err := DB.Preload("User").Preload("Impersonator").Where("token = ?", token).First(&session).Error; err != nil {
 		return nil, err
 	}
 
@@ -111,6 +112,14 @@ func GetSessionByToken(token string) (*Session, error) {
 	return &session, nil
 }
 
+// UpdateSessionExpiry moves a session's idle expiry
+func UpdateSessionExpiry(id uint, expiresAt time.Time) error {
+	if DB == nil {
+		return fmt.Errorf("database not initialized")
+	}
+	return DB.Model(&Session{}).Where("id = ?", id).Update("expires_at", expiresAt).Error
+}
+
 // DeleteSession deletes a session
 func DeleteSession(token string) error {
 	if DB == nil {
@@ -127,6 +136,14 @@ func DeleteUserSessions(userID uint) error {
 	return DB.Where("user_id = ?", userID).Delete(&Session{}).Error
 }
 
+// DeleteOtherUserSessions deletes all sessions for a user except the one with keepToken
+func DeleteOtherUserSessions(userID uint, keepToken string) error {
+	if DB == nil {
+		return fmt.Errorf("database not initialized")
+	}
+	return DB.Where("user_id = ? AND token <> ?", userID, keepToken).Delete(&Session{}).Error
+}
+
 // CleanupExpiredSessions removes expired sessions
 func CleanupExpiredSessions() (int64, error) {
 	if DB == nil {
@@ -137,6 +154,73 @@ func CleanupExpiredSessions() (int64, error) {
 	return result.RowsAffected, result.Error
 }
 
+// Known Device Operations
+
+// GetKnownDevice retrieves a user's device by fingerprint
+func GetKnownDevice(userID uint, fingerprint string) (*KnownDevice, error) {
+	if DB == nil {
+		return nil, fmt.Errorf("database not initialized")
+	}
+
+	var device KnownDevice
+	if err := DB.Where("user_id = ? AND fingerprint = ?", userID, fingerprint).First(&device).Error; err != nil {
+		return nil, err
+	}
+	return &device, nil
+}
+
+// SaveKnownDevice creates or updates a known device
+func SaveKnownDevice(device *KnownDevice) error {
+	if DB == nil {
+		return fmt.Errorf("database not initialized")
+	}
+	return DB.Save(device).Error
+}
+
+// CountKnownDevices counts a user's known devices
+func CountKnownDevices(userID uint) (int64, error) {
+	if DB == nil {
+		return 0, fmt.Errorf("database not initialized")
+	}
+
+	var count int64
+	if err := DB.Model(&KnownDevice{}).Where("user_id = ?", userID).Count(&count).Error; err != nil {
+		return 0, err
+	}
+	return count, nil
+}
+
+// ListKnownDevices lists a user's known devices, most recently seen first
+func ListKnownDevices(userID uint) ([]KnownDevice, error) {
+	if DB == nil {
+		return nil, fmt.Errorf("database not initialized")
+	}
+
+	var devices []KnownDevice
+	if err := DB.Where("user_id = ?", userID).Order("last_seen_at DESC").Find(&devices).Error; err != nil {
+		return nil, err
+	}
+	return devices, nil
+}
+
+// DeleteKnownDevice forgets a user's device and deletes the sessions bound to it
+func DeleteKnownDevice(userID, id uint) error {
+	if DB == nil {
+		return fmt.Errorf("database not initialized")
+	}
+
+	return DB.Transaction(func(tx *gorm.DB) error {
+		var device KnownDevice
+		if err := tx.Where("id = ? AND user_id = ?", id, userID).First(&device).Error; err != nil {
+			return err
+		}
+		if err := tx.Where("user_id = ? AND fingerprint = ?", userID, device.Fingerprint).Delete(&Session{}).Error; err != nil {
+			return err
+		}
+		return tx.Delete(&device).Error
+	})
+}
+
 // API Key Operations
 
 // CreateAPIKey creates a new API key
@@ -272,6 +356,12 @@ func ListAuditLogs(filters map[string]interface{}, limit, offset int) ([]AuditLo
 	if resource, ok := filters["resource"]; ok {
 		query = query.Where("resource = ?", resource)
 	}
+	if impersonator, ok := filters["impersonator"]; ok {
+		query = query.Where("impersonator = ?", impersonator)
+	}
+	if requestID, ok := filters["request_id"]; ok {
+		query = query.Where("request_id = ?", requestID)
+	}
 	if from, ok := filters["from"]; ok {
 		query = query.Where("created_at >= ?", from)
 	}
@@ -292,19 +382,45 @@ func ListAuditLogs(filters map[string]interface{}, limit, offset int) ([]AuditLo
 	return logs, count, nil
 }
 
-// GetAuditLogsByTransaction retrieves audit logs for a specific transaction
+// GetAuditLogsByTransaction retrieves audit logs for a specific transaction,
+// either tagged with its ID or using the transaction ID as their resource
 func GetAuditLogsByTransaction(txID string) ([]AuditLog, error) {
 	if DB == nil {
 		return nil, fmt.Errorf("database not initialized")
 	}
 
 	var logs []AuditLog
-	if err := DB.Where("transaction_id = ?", txID).Order("created_at ASC").Find(&logs).Error; err != nil {
+	if err := DB.Where("tx_id = ? OR resource = ?", txID, txID).Order("created_at ASC").Find(&logs).Error; err != nil {
 		return nil, err
 	}
 	return logs, nil
 }
 
+// Onboarding Token Operations
+
+// ReplaceOnboardingToken stores a new onboarding token, invalidating any previous one
+func ReplaceOnboardingToken(token *OnboardingToken) error {
+	if DB == nil {
+		return fmt.Errorf("database not initialized")
+	}
+	return DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("1 = 1").Delete(&OnboardingToken{}).Error; err != nil {
+			return err
+		}
+		return tx.Create(token).Error
+	})
+}
+
+// ConsumeOnboardingToken deletes the unexpired onboarding token with the
+// given hash, reporting whether there was one; a token can only be consumed once
+func ConsumeOnboardingToken(tokenHash string) (bool, error) {
+	if DB == nil {
+		return false, fmt.Errorf("database not initialized")
+	}
+	result := DB.Where("token_hash = ? AND expires_at > ?", tokenHash, time.Now()).Delete(&OnboardingToken{})
+	return result.RowsAffected == 1, result.Error
+}
+
 // Transaction Operations
 
 // CreateTransaction creates a new transaction record
@@ -374,6 +490,361 @@ func ListTransactions(filters map[string]interface{}, limit, offset int) ([]Tran
 	return transactions, count, nil
 }
 
+// CreateTransactionArtifacts archives the artifacts pushed during a transaction
+func CreateTransactionArtifacts(artifacts []TransactionArtifact) error {
+	if DB == nil {
+		return fmt.Errorf("database not initialized")
+	}
+	if len(artifacts) == 0 {
+		return nil
+	}
+	return DB.Create(&artifacts).Error
+}
+
+// ListTransactionArtifacts lists the artifacts of a transaction in the order they were pushed
+func ListTransactionArtifacts(txID string) ([]TransactionArtifact, error) {
+	if DB == nil {
+		return nil, fmt.Errorf("database not initialized")
+	}
+
+	var artifacts []TransactionArtifact
+	if err := DB.Where("tx_id = ?", txID).Order("id ASC").Find(&artifacts).Error; err != nil {
+		return nil, err
+	}
+	return artifacts, nil
+}
+
+// CreateTransactionCapture records a dump of the system state during a transaction
+func CreateTransactionCapture(capture *TransactionCapture) error {
+	if DB == nil {
+		return fmt.Errorf("database not initialized")
+	}
+	return DB.Create(capture).Error
+}
+
+// ListTransactionCaptures lists the system state dumps of a transaction in the order they were taken
+func ListTransactionCaptures(txID string) ([]TransactionCapture, error) {
+	if DB == nil {
+		return nil, fmt.Errorf("database not initialized")
+	}
+
+	var captures []TransactionCapture
+	if err := DB.Where("tx_id = ?", txID).Order("id ASC").Find(&captures).Error; err != nil {
+		return nil, err
+	}
+	return captures, nil
+}
+
+// WAN Quality Operations
+
+// CreateWANQualitySample records a WAN quality measurement
+func CreateWANQualitySample(sample *WANQualitySample) error {
+	if DB == nil {
+		return fmt.Errorf("database not initialized")
+	}
+	return DB.Create(sample).Error
+}
+
+// ListWANQualitySamples lists WAN quality measurements, newest first, with optional filters
+func ListWANQualitySamples(filters map[string]interface{}, limit int) ([]WANQualitySample, error) {
+	if DB == nil {
+		return nil, fmt.Errorf("database not initialized")
+	}
+
+	query := DB.Model(&WANQualitySample{})
+	if kind, ok := filters["kind"]; ok {
+		query = query.Where("kind = ?", kind)
+	}
+	if target, ok := filters["target"]; ok {
+		query = query.Where("target = ?", target)
+	}
+	if from, ok := filters["from"]; ok {
+		query = query.Where("created_at >= ?", from)
+	}
+
+	var samples []WANQualitySample
+	if err := query.Order("created_at DESC").Limit(limit).Find(&samples).Error; err != nil {
+		return nil, err
+	}
+	return samples, nil
+}
+
+// CleanupWANQualitySamples deletes WAN quality measurements older than the cutoff
+func CleanupWANQualitySamples(before time.Time) (int64, error) {
+	if DB == nil {
+		return 0, fmt.Errorf("database not initialized")
+	}
+
+	result := DB.Where("created_at < ?", before).Delete(&WANQualitySample{})
+	return result.RowsAffected, result.Error
+}
+
+// Certificate Operations
+
+// SaveCertificate creates or updates the record of a certificate by name
+func SaveCertificate(cert *Certificate) error {
+	if DB == nil {
+		return fmt.Errorf("database not initialized")
+	}
+
+	var existing Certificate
+	err := DB.Where("name = ?", cert.Name).First(&existing).Error
+	switch {
+	case err == nil:
+		cert.ID = existing.ID
+		cert.CreatedAt = existing.CreatedAt
+	case !errors.Is(err, gorm.ErrRecordNotFound):
+		return err
+	}
+	return DB.Save(cert).Error
+}
+
+// ListCertificates lists the recorded certificates, soonest expiry first
+func ListCertificates() ([]Certificate, error) {
+	if DB == nil {
+		return nil, fmt.Errorf("database not initialized")
+	}
+
+	var certs []Certificate
+	if err := DB.Order("not_after ASC").Find(&certs).Error; err != nil {
+		return nil, err
+	}
+	return certs, nil
+}
+
+// DeleteCertificatesExcept forgets the certificates not named
+func DeleteCertificatesExcept(names []string) error {
+	if DB == nil {
+		return fmt.Errorf("database not initialized")
+	}
+
+	query := DB.Where("1 = 1")
+	if len(names) > 0 {
+		query = DB.Where("name NOT IN ?", names)
+	}
+	return query.Delete(&Certificate{}).Error
+}
+
+// Task Run Operations
+
+// CreateTaskRun records a run of a scheduled task
+func CreateTaskRun(run *TaskRun) error {
+	if DB == nil {
+		return fmt.Errorf("database not initialized")
+	}
+	return DB.Create(run).Error
+}
+
+// ListTaskRuns lists the runs of a task, newest first
+func ListTaskRuns(task string, limit int) ([]TaskRun, error) {
+	if DB == nil {
+		return nil, fmt.Errorf("database not initialized")
+	}
+
+	var runs []TaskRun
+	if err := DB.Where("task = ?", task).Order("started_at DESC").Limit(limit).Find(&runs).Error; err != nil {
+		return nil, err
+	}
+	return runs, nil
+}
+
+// LatestTaskRuns returns the last run of each task that has run, by task name
+func LatestTaskRuns() (map[string]TaskRun, error) {
+	if DB == nil {
+		return nil, fmt.Errorf("database not initialized")
+	}
+
+	var runs []TaskRun
+	latest := DB.Model(&TaskRun{}).Select("MAX(id)").Group("task")
+	if err := DB.Where("id IN (?)", latest).Find(&runs).Error; err != nil {
+		return nil, err
+	}
+
+	byTask := make(map[string]TaskRun, len(runs))
+	for _, run := range runs {
+		byTask[run.Task] = run
+	}
+	return byTask, nil
+}
+
+// CleanupTaskRuns deletes task runs older than the cutoff
+func CleanupTaskRuns(before time.Time) (int64, error) {
+	if DB == nil {
+		return 0, fmt.Errorf("database not initialized")
+	}
+
+	result := DB.Where("started_at < ?", before).Delete(&TaskRun{})
+	return result.RowsAffected, result.Error
+}
+
+// Config Generation Operations
+
+// GetConfigGeneration returns the config generation, 0 before the first change
+func GetConfigGeneration() (uint64, error) {
+	if DB == nil {
+		return 0, fmt.Errorf("database not initialized")
+	}
+
+	var generation ConfigGeneration
+	if err := DB.Limit(1).Find(&generation).Error; err != nil {
+		return 0, err
+	}
+	return generation.Generation, nil
+}
+
+// BumpConfigGeneration increments the config generation and returns the new one
+func BumpConfigGeneration() (uint64, error) {
+	if DB == nil {
+		return 0, fmt.Errorf("database not initialized")
+	}
+
+	var generation ConfigGeneration
+	err := DB.Transaction(func(tx *gorm.DB) error {
+		result := tx.Model(&ConfigGeneration{}).Where("1 = 1").
+			Updates(map[string]interface{}{"generation": gorm.Expr("generation + 1"), "updated_at": time.Now()})
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			generation.Generation = 1
+			return tx.Create(&generation).Error
+		}
+		return tx.Limit(1).Find(&generation).Error
+	})
+	return generation.Generation, err
+}
+
+// Config Lock Operations
+
+// ErrConfigLocked is returned when another user holds the lock on a config
+var ErrConfigLocked = errors.New("config is locked by another user")
+
+// ListConfigLocks lists the unexpired config locks by config name
+func ListConfigLocks() ([]ConfigLock, error) {
+	if DB == nil {
+		return nil, fmt.Errorf("database not initialized")
+	}
+
+	var locks []ConfigLock
+	if err := DB.Where("expires_at > ?", time.Now()).Order("config").Find(&locks).Error; err != nil {
+		return nil, err
+	}
+	return locks, nil
+}
+
+// GetConfigLock returns the unexpired lock on a config, nil if there is none
+func GetConfigLock(config string) (*ConfigLock, error) {
+	if DB == nil {
+		return nil, fmt.Errorf("database not initialized")
+	}
+
+	var locks []ConfigLock
+	if err := DB.Where("config = ? AND expires_at > ?", config, time.Now()).Limit(1).Find(&locks).Error; err != nil {
+		return nil, err
+	}
+	if len(locks) == 0 {
+		return nil, nil
+	}
+	return &locks[0], nil
+}
+
+// AcquireConfigLock takes the lock on lock.Config for lock.Username, or
+// renews it if they hold it already. If another user holds it, it returns
+// their lock with ErrConfigLocked.
+func AcquireConfigLock(lock *ConfigLock) (*ConfigLock, error) {
+	if DB == nil {
+		return nil, fmt.Errorf("database not initialized")
+	}
+
+	var held []ConfigLock
+	err := DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("config = ? AND expires_at <= ?", lock.Config, time.Now()).Delete(&ConfigLock{}).Error; err != nil {
+			return err
+		}
+		if err := tx.Where("config = ?", lock.Config).Limit(1).Find(&held).Error; err != nil {
+			return err
+		}
+		if len(held) == 0 {
+			return tx.Create(lock).Error
+		}
+		if held[0].Username != lock.Username {
+			return ErrConfigLocked
+		}
+		return tx.Model(&held[0]).Updates(map[string]interface{}{"note": lock.Note, "expires_at": lock.ExpiresAt}).Error
+	})
+	if errors.Is(err, ErrConfigLocked) {
+		return &held[0], err
+	}
+	if err != nil {
+		return nil, err
+	}
+	return GetConfigLock(lock.Config)
+}
+
+// DeleteConfigLock releases the lock on a config, reporting whether there was one
+func DeleteConfigLock(config string) (bool, error) {
+	if DB == nil {
+		return false, fmt.Errorf("database not initialized")
+	}
+	result := DB.Where("config = ? AND expires_at > ?", config, time.Now()).Delete(&ConfigLock{})
+	return result.RowsAffected > 0, result.Error
+}
+
+// Statistics Operations
+
+// StatusCount is the number of records with a status, on a day or for an
+// action
+type StatusCount struct {
+	Key    string // Day (YYYY-MM-DD, UTC) or action
+	Status string
+	Count  int64
+}
+
+// CountTransactionsByDay counts the transactions created since from by UTC
+// day and status
+func CountTransactionsByDay(from time.Time) ([]StatusCount, error) {
+	if DB == nil {
+		return nil, fmt.Errorf("database not initialized")
+	}
+
+	var counts []StatusCount
+	err := DB.Model(&Transaction{}).
+		Select("date(created_at) AS key, status, COUNT(*) AS count").
+		Where("created_at >= ?", from).
+		Group("key, status").Order("key").
+		Scan(&counts).Error
+	return counts, err
+}
+
+// CountAuditActions counts the audit entries created since from by action
+// and status
+func CountAuditActions(from time.Time) ([]StatusCount, error) {
+	if DB == nil {
+		return nil, fmt.Errorf("database not initialized")
+	}
+
+	var counts []StatusCount
+	err := DB.Model(&AuditLog{}).
+		Select("action AS key, status, COUNT(*) AS count").
+		Where("created_at >= ?", from).
+		Group("action, status").Order("action").
+		Scan(&counts).Error
+	return counts, err
+}
+
+// CountRollbacks counts the transactions rolled back since from
+func CountRollbacks(from time.Time) (int64, error) {
+	if DB == nil {
+		return 0, fmt.Errorf("database not initialized")
+	}
+
+	var count int64
+	if err := DB.Model(&Transaction{}).Where("rolled_back_at >= ?", from).Count(&count).Error; err != nil {
+		return 0, err
+	}
+	return count, nil
+}
+
 // Utility Operations
 
 // CountUsers counts total users
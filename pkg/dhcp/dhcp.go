@@ -0,0 +1,346 @@
+// Package dhcp reads the address pools of the dhcp config and the leases
+// dnsmasq hands out from them, to tell how full each pool is and warn before
+// one runs out.
+package dhcp
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"net/netip"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/thesabbir/hellfire/pkg/bus"
+	"github.com/thesabbir/hellfire/pkg/dns"
+	"github.com/thesabbir/hellfire/pkg/logger"
+	"github.com/thesabbir/hellfire/pkg/uci"
+)
+
+const (
+	// DefaultLeaseFile is where dnsmasq keeps its leases unless the dnsmasq
+	// section sets leasefile
+	DefaultLeaseFile = "/var/lib/misc/dnsmasq.leases"
+
+	// DefaultWarnPercent is the utilization of a pool that raises an alert,
+	// unless its warn_percent option sets another
+	DefaultWarnPercent = 90
+
+	// DefaultCheckInterval is how often the monitor checks the pools
+	DefaultCheckInterval = time.Minute
+)
+
+// Lease is an IPv4 lease from the dnsmasq lease file
+type Lease struct {
+	Expires  time.Time `json:"expires,omitzero"` // Zero for infinite leases
+	MAC      string    `json:"mac"`
+	IP       string    `json:"ip"`
+	Hostname string    `json:"hostname,omitempty"`
+}
+
+// ParseLeases parses a dnsmasq lease file: "<expiry> <mac> <ip> <hostname>
+// <client-id>" per lease, * for unknown values. DHCPv6 leases are skipped.
+func ParseLeases(data []byte) []Lease {
+	var leases []Lease
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 4 {
+			continue
+		}
+		ip, err := netip.ParseAddr(fields[2])
+		if err != nil || !ip.Is4() {
+			continue
+		}
+		expiry, err := strconv.ParseInt(fields[0], 10, 64)
+		if err != nil {
+			continue
+		}
+
+		lease := Lease{MAC: strings.ToLower(fields[1]), IP: ip.String()}
+		if expiry > 0 {
+			lease.Expires = time.Unix(expiry, 0)
+		}
+		if fields[3] != "*" {
+			lease.Hostname = fields[3]
+		}
+		leases = append(leases, lease)
+	}
+	return leases
+}
+
+// LeaseFile returns the lease file of the dnsmasq section of a dhcp config
+func LeaseFile(dhcp *uci.Config) string {
+	if sections := dhcp.GetSectionsByType("dnsmasq"); len(sections) > 0 {
+		if path, ok := sections[0].GetOption("leasefile"); ok && path != "" {
+			return path
+		}
+	}
+	return DefaultLeaseFile
+}
+
+// ReadLeases reads a dnsmasq lease file. A missing file has no leases.
+func ReadLeases(path string) ([]Lease, error) {
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return ParseLeases(data), nil
+}
+
+// Pool is the address range of a dhcp section
+type Pool struct {
+	Section     string `json:"section"`
+	Interface   string `json:"interface"`
+	Start       string `json:"start"`
+	End         string `json:"end"`
+	Size        int    `json:"size"`
+	WarnPercent int    `json:"warn_percent"`
+}
+
+// Pools reads the IPv4 ranges of the dhcp sections that serve addresses. As
+// on OpenWrt, start is an offset into the interface's subnet (from the
+// network config) and limit the number of addresses; sections with an
+// address for start and limit are ranges from start to limit instead.
+func Pools(dhcp, network *uci.Config) ([]Pool, error) {
+	var pools []Pool
+	for i, section := range dhcp.GetSectionsByType("dhcp") {
+		iface, _ := section.GetOption("interface")
+		start, hasStart := section.GetOption("start")
+		limit, hasLimit := section.GetOption("limit")
+		if ignore, _ := section.GetOption("ignore"); ignore == "1" || iface == "" || !hasStart || !hasLimit {
+			continue
+		}
+
+		pool := Pool{Section: section.Name, Interface: iface, WarnPercent: DefaultWarnPercent}
+		if pool.Section == "" {
+			pool.Section = fmt.Sprintf("@dhcp[%d]", i)
+		}
+		if value, ok := section.GetOption("warn_percent"); ok {
+			percent, err := strconv.Atoi(value)
+			if err != nil || percent < 1 || percent > 100 {
+				return nil, fmt.Errorf("dhcp pool %s: invalid warn_percent %q (1-100)", pool.Section, value)
+			}
+			pool.WarnPercent = percent
+		}
+
+		first, last, err := poolRange(network, iface, start, limit)
+		if err != nil {
+			return nil, fmt.Errorf("dhcp pool %s: %w", pool.Section, err)
+		}
+		pool.Start, pool.End = first.String(), last.String()
+		pool.Size = int(addrValue(last) - addrValue(first) + 1)
+		pools = append(pools, pool)
+	}
+	return pools, nil
+}
+
+// poolRange returns the first and last address of a pool
+func poolRange(network *uci.Config, iface, start, limit string) (netip.Addr, netip.Addr, error) {
+	if first, err := netip.ParseAddr(start); err == nil {
+		last, err := netip.ParseAddr(limit)
+		if err != nil || !first.Is4() || !last.Is4() || last.Less(first) {
+			return first, last, fmt.Errorf("invalid range %s-%s", start, limit)
+		}
+		return first, last, nil
+	}
+
+	offset, err := strconv.ParseUint(start, 10, 32)
+	if err != nil {
+		return netip.Addr{}, netip.Addr{}, fmt.Errorf("invalid start %q", start)
+	}
+	count, err := strconv.ParseUint(limit, 10, 32)
+	if err != nil || count == 0 {
+		return netip.Addr{}, netip.Addr{}, fmt.Errorf("invalid limit %q", limit)
+	}
+	subnet, err := interfaceSubnet(network, iface)
+	if err != nil {
+		return netip.Addr{}, netip.Addr{}, err
+	}
+
+	// The network and broadcast addresses aren't handed out
+	base := addrValue(subnet.Masked().Addr())
+	broadcast := base | (1<<(32-subnet.Bits()) - 1)
+	first := base + offset
+	last := min(first+count-1, broadcast-1)
+	if first <= base || first > last {
+		return netip.Addr{}, netip.Addr{}, fmt.Errorf("start %s is outside %s", start, subnet.Masked())
+	}
+	return valueAddr(first), valueAddr(last), nil
+}
+
+// interfaceSubnet returns the IPv4 subnet of a static interface of the
+// network config
+func interfaceSubnet(network *uci.Config, iface string) (netip.Prefix, error) {
+	var section *uci.Section
+	if network != nil {
+		section = network.GetSection("interface", iface)
+	}
+	if section == nil {
+		return netip.Prefix{}, fmt.Errorf("interface %s not found", iface)
+	}
+	ipaddr, _ := section.GetOption("ipaddr")
+	netmask, _ := section.GetOption("netmask")
+	if prefix, err := netip.ParsePrefix(ipaddr); err == nil && prefix.Addr().Is4() {
+		return prefix, nil
+	}
+	addr, err := netip.ParseAddr(ipaddr)
+	if err != nil || !addr.Is4() {
+		return netip.Prefix{}, fmt.Errorf("interface %s has no IPv4 address", iface)
+	}
+	mask, err := netip.ParseAddr(netmask)
+	if err != nil || !mask.Is4() {
+		return netip.Prefix{}, fmt.Errorf("interface %s has no netmask", iface)
+	}
+	bits := 0
+	for v := addrValue(mask); v&(1<<31) != 0; v <<= 1 {
+		bits++
+	}
+	return netip.PrefixFrom(addr, bits), nil
+}
+
+func addrValue(addr netip.Addr) uint64 {
+	b := addr.As4()
+	return uint64(b[0])<<24 | uint64(b[1])<<16 | uint64(b[2])<<8 | uint64(b[3])
+}
+
+func valueAddr(v uint64) netip.Addr {
+	return netip.AddrFrom4([4]byte{byte(v >> 24), byte(v >> 16), byte(v >> 8), byte(v)})
+}
+
+// Usage is how full a pool is: the addresses of active leases and static
+// leases in its range count as used
+type Usage struct {
+	Pool
+	Used       int     `json:"used"`
+	Percent    float64 `json:"percent"`
+	Exhausting bool    `json:"exhausting"` // At or over its warn_percent
+}
+
+// Utilization computes the usage of each pool at a time
+func Utilization(pools []Pool, leases []Lease, static []dns.StaticLease, now time.Time) []Usage {
+	used := map[netip.Addr]bool{}
+	for _, lease := range leases {
+		if lease.Expires.IsZero() || lease.Expires.After(now) {
+			used[netip.MustParseAddr(lease.IP)] = true
+		}
+	}
+	for _, lease := range static {
+		if addr, err := netip.ParseAddr(lease.IP); err == nil {
+			used[addr] = true
+		}
+	}
+
+	usages := make([]Usage, 0, len(pools))
+	for _, pool := range pools {
+		usage := Usage{Pool: pool}
+		first, last := netip.MustParseAddr(pool.Start), netip.MustParseAddr(pool.End)
+		for addr := range used {
+			if !addr.Less(first) && !last.Less(addr) {
+				usage.Used++
+			}
+		}
+		usage.Percent = float64(usage.Used) * 100 / float64(pool.Size)
+		usage.Exhausting = usage.Percent >= float64(pool.WarnPercent)
+		usages = append(usages, usage)
+	}
+	return usages
+}
+
+// WritePrometheus writes the usage of pools as Prometheus gauges in the text
+// exposition format
+func WritePrometheus(w io.Writer, usages []Usage) error {
+	var b strings.Builder
+
+	b.WriteString("# HELP hellfire_dhcp_pool_size Addresses in each DHCP pool\n")
+	b.WriteString("# TYPE hellfire_dhcp_pool_size gauge\n")
+	for _, usage := range usages {
+		fmt.Fprintf(&b, "hellfire_dhcp_pool_size{pool=%q,interface=%q} %d\n", usage.Section, usage.Interface, usage.Size)
+	}
+
+	b.WriteString("# HELP hellfire_dhcp_pool_used Addresses of each DHCP pool leased or reserved\n")
+	b.WriteString("# TYPE hellfire_dhcp_pool_used gauge\n")
+	for _, usage := range usages {
+		fmt.Fprintf(&b, "hellfire_dhcp_pool_used{pool=%q,interface=%q} %d\n", usage.Section, usage.Interface, usage.Used)
+	}
+
+	b.WriteString("# HELP hellfire_dhcp_pool_utilization Share of each DHCP pool in use from 0 to 1\n")
+	b.WriteString("# TYPE hellfire_dhcp_pool_utilization gauge\n")
+	for _, usage := range usages {
+		fmt.Fprintf(&b, "hellfire_dhcp_pool_utilization{pool=%q,interface=%q} %g\n", usage.Section, usage.Interface, usage.Percent/100)
+	}
+
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+// Monitor checks the pools periodically and publishes dhcp.pool_exhausting
+// when one reaches its warn_percent, and dhcp.pool_recovered once it drops
+// below again
+type Monitor struct {
+	load func() ([]Usage, error)
+
+	mu         sync.Mutex
+	exhausting map[string]bool // By pool section
+	started    sync.Once
+}
+
+// NewMonitor creates a monitor of the pool usages load returns
+func NewMonitor(load func() ([]Usage, error)) *Monitor {
+	return &Monitor{load: load, exhausting: map[string]bool{}}
+}
+
+// Check loads the pool usages and publishes their changes
+func (m *Monitor) Check() error {
+	usages, err := m.load()
+	if err != nil {
+		return err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, usage := range usages {
+		if usage.Exhausting == m.exhausting[usage.Section] {
+			continue
+		}
+		m.exhausting[usage.Section] = usage.Exhausting
+		if usage.Exhausting {
+			logger.Warn("DHCP pool nearly exhausted",
+				"pool", usage.Section,
+				"interface", usage.Interface,
+				"used", usage.Used,
+				"size", usage.Size)
+			bus.Publish(bus.Event{Type: bus.EventDHCPPoolExhausting, ConfigName: "dhcp", Data: usage})
+		} else {
+			logger.Info("DHCP pool recovered", "pool", usage.Section, "interface", usage.Interface)
+			bus.Publish(bus.Event{Type: bus.EventDHCPPoolRecovered, ConfigName: "dhcp", Data: usage})
+		}
+	}
+	return nil
+}
+
+// Start checks the pools now and then every interval
+func (m *Monitor) Start(interval time.Duration) {
+	m.started.Do(func() {
+		go func() {
+			ticker := time.NewTicker(interval)
+			defer ticker.Stop()
+
+			logger.Info("Started DHCP pool monitor", "interval", interval)
+			for {
+				if err := m.Check(); err != nil {
+					logger.Error("Failed to check DHCP pools", "error", err)
+				}
+				<-ticker.C
+			}
+		}()
+	})
+}
@@ -0,0 +1,141 @@
+package dhcp
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/thesabbir/hellfire/pkg/bus"
+	"github.com/thesabbir/hellfire/pkg/dns"
+	"github.com/thesabbir/hellfire/pkg/uci"
+)
+
+func TestPools(t *testing.T) {
+	network, err := uci.Parse(strings.NewReader(`
+config interface 'lan'
+	option proto 'static'
+	option ipaddr '192.168.1.1'
+	option netmask '255.255.255.0'
+
+config interface 'guest'
+	option proto 'static'
+	option ipaddr '10.0.0.1/29'
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	dhcpConfig, err := uci.Parse(strings.NewReader(`
+config dhcp 'lan'
+	option interface 'lan'
+	option start '100'
+	option limit '150'
+
+config dhcp 'guest'
+	option interface 'guest'
+	option start '2'
+	option limit '100'
+	option warn_percent '50'
+
+config dhcp
+	option interface 'iot'
+	option start '172.16.0.10'
+	option limit '172.16.0.19'
+
+config dhcp 'wan'
+	option interface 'wan'
+	option ignore '1'
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	pools, err := Pools(dhcpConfig, network)
+	if err != nil {
+		t.Fatalf("Pools: %v", err)
+	}
+	want := []Pool{
+		{Section: "lan", Interface: "lan", Start: "192.168.1.100", End: "192.168.1.249", Size: 150, WarnPercent: 90},
+		{Section: "guest", Interface: "guest", Start: "10.0.0.2", End: "10.0.0.6", Size: 5, WarnPercent: 50},
+		{Section: "@dhcp[2]", Interface: "iot", Start: "172.16.0.10", End: "172.16.0.19", Size: 10, WarnPercent: 90},
+	}
+	if len(pools) != len(want) {
+		t.Fatalf("pools = %+v", pools)
+	}
+	for i := range want {
+		if pools[i] != want[i] {
+			t.Errorf("pool %d = %+v, want %+v", i, pools[i], want[i])
+		}
+	}
+
+	now := time.Unix(1700000000, 0)
+	leases := ParseLeases([]byte(
+		"1700003600 02:00:00:00:00:01 10.0.0.2 phone *\n" +
+			"1699999999 02:00:00:00:00:02 10.0.0.3 expired *\n" +
+			"0 02:00:00:00:00:03 10.0.0.4 * 01:02:00:00:00:00:03\n" +
+			"duid 00:01:00:01:2c:1f:00:00:02:00:00:00:00:01\n" +
+			"1700003600 1234 fd00::10 phone *\n"))
+	if len(leases) != 3 || leases[0].Hostname != "phone" || !leases[2].Expires.IsZero() {
+		t.Fatalf("leases = %+v", leases)
+	}
+	static := []dns.StaticLease{{MAC: "02:00:00:00:00:04", IP: "10.0.0.6"}, {MAC: "02:00:00:00:00:05", IP: "192.168.1.20"}}
+
+	usages := Utilization(pools, leases, static, now)
+	if usages[0].Used != 0 || usages[0].Exhausting {
+		t.Errorf("lan = %+v", usages[0])
+	}
+	if usages[1].Used != 3 || usages[1].Percent != 60 || !usages[1].Exhausting {
+		t.Errorf("guest = %+v", usages[1])
+	}
+
+	var metrics strings.Builder
+	if err := WritePrometheus(&metrics, usages); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(metrics.String(), `hellfire_dhcp_pool_utilization{pool="guest",interface="guest"} 0.6`) {
+		t.Errorf("metrics:\n%s", metrics.String())
+	}
+
+	// The subnet of an interface without an address is unknown
+	section := network.GetSection("interface", "guest")
+	section.SetOption("ipaddr", "")
+	if _, err := Pools(dhcpConfig, network); err == nil {
+		t.Error("expected a pool on an interface without an address to fail")
+	}
+}
+
+func TestMonitor(t *testing.T) {
+	events, stop := bus.Watch(8)
+	defer stop()
+
+	usage := Usage{Pool: Pool{Section: "lan", Interface: "lan", Size: 10, WarnPercent: 90}}
+	m := NewMonitor(func() ([]Usage, error) { return []Usage{usage}, nil })
+	check := func(used int) {
+		usage.Used = used
+		usage.Percent = float64(used) * 10
+		usage.Exhausting = usage.Percent >= 90
+		if err := m.Check(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	check(5)
+	check(9)
+	check(10)
+	check(3)
+
+	// Only the transitions are published, not the healthy first check
+	for _, want := range []bus.EventType{bus.EventDHCPPoolExhausting, bus.EventDHCPPoolRecovered} {
+		select {
+		case event := <-events:
+			if event.Type != want {
+				t.Errorf("event = %s, want %s", event.Type, want)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("no %s event", want)
+		}
+	}
+	select {
+	case event := <-events:
+		t.Errorf("unexpected event %s", event.Type)
+	default:
+	}
+}
@@ -0,0 +1,351 @@
+// Package dns reads the DNS settings of the dhcp config that dnsmasq serves
+// besides its upstream servers: conditional forwarders, local zones and the
+// A, AAAA and CNAME records answered locally.
+//
+// As on OpenWrt, forwarders are server list entries of the dnsmasq section
+// ("/corp.example/10.0.0.1"), and records are domain sections (name, ip) and
+// cname sections (cname, target). Local zones are the local_zone list of the
+// dnsmasq section: names under them are answered from local records only and
+// never forwarded.
+package dns
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/thesabbir/hellfire/pkg/uci"
+	"github.com/thesabbir/hellfire/pkg/util"
+)
+
+var (
+	ErrInvalid  = errors.New("invalid dns config")
+	ErrNotFound = errors.New("not found")
+)
+
+// Record types
+const (
+	TypeA     = "A"
+	TypeAAAA  = "AAAA"
+	TypeCNAME = "CNAME"
+)
+
+// Section types of the records
+const (
+	HostSection  = "domain" // A and AAAA: name, ip
+	CNAMESection = "cname"  // CNAME: cname, target
+)
+
+// Server is an upstream server of the dnsmasq section, for every domain or
+// only the listed ones (a conditional forwarder)
+type Server struct {
+	Domains []string `json:"domains,omitempty"`
+	Address string   `json:"address,omitempty"` // Empty for domains answered locally only
+	Port    int      `json:"port,omitempty"`
+}
+
+// String formats the server as a server list entry and dnsmasq server= value
+func (s Server) String() string {
+	value := s.Address
+	if s.Port != 0 {
+		value += "#" + strconv.Itoa(s.Port)
+	}
+	if len(s.Domains) > 0 {
+		value = "/" + strings.Join(s.Domains, "/") + "/" + value
+	}
+	return value
+}
+
+// ParseServer parses a server list entry: an address with an optional
+// #port, which /domain/.../ prefixes restrict to those domains. "/domain/"
+// without an address keeps the domain local.
+func ParseServer(value string) (Server, error) {
+	var server Server
+	address := value
+	if strings.HasPrefix(value, "/") {
+		i := strings.LastIndex(value, "/")
+		if i == 0 {
+			return server, fmt.Errorf("%w: server %s: no domain", ErrInvalid, value)
+		}
+		for _, domain := range strings.Split(value[1:i], "/") {
+			if err := util.ValidateHostname(domain); err != nil {
+				return server, fmt.Errorf("%w: server %s: %v", ErrInvalid, value, err)
+			}
+			server.Domains = append(server.Domains, strings.ToLower(domain))
+		}
+		address = value[i+1:]
+		if address == "" {
+			return server, nil
+		}
+	}
+
+	host, port, hasPort := strings.Cut(address, "#")
+	if net.ParseIP(host) == nil {
+		return server, fmt.Errorf("%w: server %s: invalid address %q", ErrInvalid, value, host)
+	}
+	server.Address = host
+	if hasPort {
+		p, err := strconv.Atoi(port)
+		if err != nil || p < 1 || p > 65535 {
+			return server, fmt.Errorf("%w: server %s: invalid port %q", ErrInvalid, value, port)
+		}
+		server.Port = p
+	}
+	return server, nil
+}
+
+// Servers parses the server list of the dnsmasq section
+func Servers(dhcp *uci.Config) ([]Server, error) {
+	dnsmasq := dnsmasqSection(dhcp)
+	if dnsmasq == nil {
+		return nil, nil
+	}
+	var servers []Server
+	for _, value := range dnsmasq.GetList("server") {
+		server, err := ParseServer(value)
+		if err != nil {
+			return nil, err
+		}
+		servers = append(servers, server)
+	}
+	return servers, nil
+}
+
+// dnsmasqSection returns the first dnsmasq section, named or not, or nil
+func dnsmasqSection(dhcp *uci.Config) *uci.Section {
+	if sections := dhcp.GetSectionsByType("dnsmasq"); len(sections) > 0 {
+		return sections[0]
+	}
+	return nil
+}
+
+// LocalZones returns the local_zone list of the dnsmasq section
+func LocalZones(dhcp *uci.Config) ([]string, error) {
+	dnsmasq := dnsmasqSection(dhcp)
+	if dnsmasq == nil {
+		return nil, nil
+	}
+	var zones []string
+	for _, zone := range dnsmasq.GetList("local_zone") {
+		if err := util.ValidateHostname(zone); err != nil {
+			return nil, fmt.Errorf("%w: local zone %s: %v", ErrInvalid, zone, err)
+		}
+		zones = append(zones, strings.ToLower(zone))
+	}
+	return zones, nil
+}
+
+// Record is a locally answered DNS record
+type Record struct {
+	ID    string `json:"id"` // Section name, or @domain[index] / @cname[index]
+	Type  string `json:"type"`
+	Name  string `json:"name"`
+	Value string `json:"value"` // Address (A, AAAA) or target name (CNAME)
+}
+
+// Records reads the records of the domain and cname sections, in order,
+// checking each and that names with a CNAME have no other record
+func Records(dhcp *uci.Config) ([]Record, error) {
+	var records []Record
+	for _, sectionType := range []string{HostSection, CNAMESection} {
+		for i, section := range dhcp.GetSectionsByType(sectionType) {
+			id := section.Name
+			if id == "" {
+				id = fmt.Sprintf("@%s[%d]", sectionType, i)
+			}
+			record := sectionRecord(section)
+			record.ID = id
+			if err := record.Check(); err != nil {
+				return nil, fmt.Errorf("record %s: %w", id, err)
+			}
+			records = append(records, record)
+		}
+	}
+
+	for _, record := range records {
+		if record.Type != TypeCNAME {
+			continue
+		}
+		for _, other := range records {
+			if other.ID != record.ID && other.Name == record.Name {
+				return nil, fmt.Errorf("%w: record %s: %s has a CNAME and another record (%s)", ErrInvalid, other.ID, record.Name, record.ID)
+			}
+		}
+	}
+	return records, nil
+}
+
+// sectionRecord reads a record from its section
+func sectionRecord(section *uci.Section) Record {
+	var record Record
+	if section.Type == CNAMESection {
+		record.Type = TypeCNAME
+		record.Name, _ = section.GetOption("cname")
+		record.Value, _ = section.GetOption("target")
+	} else {
+		record.Type = TypeA
+		record.Name, _ = section.GetOption("name")
+		record.Value, _ = section.GetOption("ip")
+		if ip := net.ParseIP(record.Value); ip != nil && ip.To4() == nil {
+			record.Type = TypeAAAA
+		}
+	}
+	record.Name = strings.ToLower(record.Name)
+	return record
+}
+
+// Check checks a record's name and that its value fits its type
+func (r Record) Check() error {
+	if err := util.ValidateHostname(r.Name); err != nil {
+		return fmt.Errorf("%w: name: %v", ErrInvalid, err)
+	}
+	switch r.Type {
+	case TypeA, TypeAAAA:
+		ip := net.ParseIP(r.Value)
+		if ip == nil {
+			return fmt.Errorf("%w: invalid address %q", ErrInvalid, r.Value)
+		}
+		if (ip.To4() != nil) != (r.Type == TypeA) {
+			return fmt.Errorf("%w: %s isn't an %s address", ErrInvalid, r.Value, r.Type)
+		}
+	case TypeCNAME:
+		if err := util.ValidateHostname(r.Value); err != nil {
+			return fmt.Errorf("%w: target: %v", ErrInvalid, err)
+		}
+		if strings.EqualFold(r.Value, r.Name) {
+			return fmt.Errorf("%w: %s is its own CNAME", ErrInvalid, r.Name)
+		}
+	default:
+		return fmt.Errorf("%w: unknown record type %q", ErrInvalid, r.Type)
+	}
+	return nil
+}
+
+// SectionType returns the type of the section holding the record
+func (r Record) SectionType() string {
+	if r.Type == TypeCNAME {
+		return CNAMESection
+	}
+	return HostSection
+}
+
+// Options returns the section options holding the record's name and value
+func (r Record) Options() (name, value string) {
+	if r.Type == TypeCNAME {
+		return "cname", "target"
+	}
+	return "name", "ip"
+}
+
+// FindRecord finds a record by its ID
+func FindRecord(dhcp *uci.Config, id string) (Record, error) {
+	records, err := Records(dhcp)
+	if err != nil {
+		return Record{}, err
+	}
+	i := slices.IndexFunc(records, func(r Record) bool { return r.ID == id })
+	if i < 0 {
+		return Record{}, fmt.Errorf("record %s: %w", id, ErrNotFound)
+	}
+	return records[i], nil
+}
+
+// RecordSection returns the section holding the record with an ID, or nil
+func RecordSection(dhcp *uci.Config, id string) *uci.Section {
+	for _, sectionType := range []string{HostSection, CNAMESection} {
+		for i, section := range dhcp.GetSectionsByType(sectionType) {
+			if section.Name == id || (section.Name == "" && fmt.Sprintf("@%s[%d]", sectionType, i) == id) {
+				return section
+			}
+		}
+	}
+	return nil
+}
+
+// SetRecord sets the options of a record section to a record
+func SetRecord(section *uci.Section, record Record) {
+	name, value := record.Options()
+	section.SetOption(name, record.Name)
+	section.SetOption(value, record.Value)
+}
+
+// Validate checks the servers, local zones, records and static leases of a
+// dhcp config, and that the names of registered leases have no CNAME
+func Validate(dhcp *uci.Config) error {
+	if _, err := Servers(dhcp); err != nil {
+		return err
+	}
+	if _, err := LocalZones(dhcp); err != nil {
+		return err
+	}
+	records, err := Records(dhcp)
+	if err != nil {
+		return err
+	}
+	hosts, err := Hosts(dhcp)
+	if err != nil {
+		return err
+	}
+
+	for _, host := range hosts {
+		if !host.Lease {
+			continue
+		}
+		for _, record := range records {
+			if record.Type == TypeCNAME && slices.Contains(host.Names, record.Name) {
+				return fmt.Errorf("%w: static lease %s: %s has a CNAME (%s)", ErrInvalid, host.ID, record.Name, record.ID)
+			}
+		}
+	}
+	return nil
+}
+
+// Dnsmasq renders the servers, local zones, records and names of static
+// leases as dnsmasq options
+func Dnsmasq(dhcp *uci.Config) (string, error) {
+	if err := Validate(dhcp); err != nil {
+		return "", err
+	}
+	servers, err := Servers(dhcp)
+	if err != nil {
+		return "", err
+	}
+	zones, err := LocalZones(dhcp)
+	if err != nil {
+		return "", err
+	}
+	records, err := Records(dhcp)
+	if err != nil {
+		return "", err
+	}
+
+	var buf strings.Builder
+	for _, server := range servers {
+		fmt.Fprintf(&buf, "server=%s\n", server)
+	}
+	for _, zone := range zones {
+		fmt.Fprintf(&buf, "local=/%s/\n", zone)
+	}
+	for _, record := range records {
+		if record.Type == TypeCNAME {
+			fmt.Fprintf(&buf, "cname=%s,%s\n", record.Name, record.Value)
+		} else {
+			fmt.Fprintf(&buf, "host-record=%s,%s\n", record.Name, record.Value)
+		}
+	}
+
+	// Registered static leases resolve whether or not the device is online
+	hosts, err := Hosts(dhcp)
+	if err != nil {
+		return "", err
+	}
+	for _, host := range hosts {
+		if host.Lease {
+			fmt.Fprintf(&buf, "host-record=%s,%s\n", strings.Join(host.Names, ","), host.Address)
+		}
+	}
+	return buf.String(), nil
+}
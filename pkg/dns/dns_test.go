@@ -0,0 +1,165 @@
+package dns
+
+import (
+	"errors"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/thesabbir/hellfire/pkg/uci"
+)
+
+func TestParseServer(t *testing.T) {
+	tests := []struct {
+		value string
+		want  Server
+	}{
+		{"1.1.1.1", Server{Address: "1.1.1.1"}},
+		{"2606:4700::1111#5353", Server{Address: "2606:4700::1111", Port: 5353}},
+		{"/corp.example/10.0.0.1", Server{Domains: []string{"corp.example"}, Address: "10.0.0.1"}},
+		{"/a.example/b.example/10.0.0.1#53", Server{Domains: []string{"a.example", "b.example"}, Address: "10.0.0.1", Port: 53}},
+		{"/home.arpa/", Server{Domains: []string{"home.arpa"}}},
+	}
+	for _, tt := range tests {
+		got, err := ParseServer(tt.value)
+		if err != nil || !slices.Equal(got.Domains, tt.want.Domains) || got.Address != tt.want.Address || got.Port != tt.want.Port {
+			t.Errorf("ParseServer(%q) = %+v, %v", tt.value, got, err)
+		}
+		if err == nil && got.String() != tt.value {
+			t.Errorf("%+v formats as %q, want %q", got, got.String(), tt.value)
+		}
+	}
+
+	for _, value := range []string{"", "/", "//10.0.0.1", "/corp.example/dns.example", "10.0.0.1#0", "10.0.0.1#port"} {
+		if _, err := ParseServer(value); !errors.Is(err, ErrInvalid) {
+			t.Errorf("ParseServer(%q) = %v, want invalid", value, err)
+		}
+	}
+}
+
+func TestRecords(t *testing.T) {
+	dhcp := uci.NewConfig()
+	host := uci.NewSection(HostSection, "nas")
+	host.SetOption("name", "NAS.home.arpa")
+	host.SetOption("ip", "fd00::10")
+	dhcp.AddSection(host)
+	alias := uci.NewSection(CNAMESection, "")
+	alias.SetOption("cname", "files.home.arpa")
+	alias.SetOption("target", "nas.home.arpa")
+	dhcp.AddSection(alias)
+
+	records, err := Records(dhcp)
+	if err != nil {
+		t.Fatalf("Records: %v", err)
+	}
+	want := []Record{
+		{ID: "nas", Type: TypeAAAA, Name: "nas.home.arpa", Value: "fd00::10"},
+		{ID: "@cname[0]", Type: TypeCNAME, Name: "files.home.arpa", Value: "nas.home.arpa"},
+	}
+	if !slices.Equal(records, want) {
+		t.Errorf("Records = %+v, want %+v", records, want)
+	}
+
+	conf, err := Dnsmasq(dhcp)
+	if err != nil || conf != "host-record=nas.home.arpa,fd00::10\ncname=files.home.arpa,nas.home.arpa\n" {
+		t.Errorf("Dnsmasq = %q, %v", conf, err)
+	}
+
+	// A CNAME is the only record of its name
+	other := uci.NewSection(HostSection, "")
+	other.SetOption("name", "files.home.arpa")
+	other.SetOption("ip", "192.168.1.11")
+	dhcp.AddSection(other)
+	if err := Validate(dhcp); !errors.Is(err, ErrInvalid) {
+		t.Errorf("Validate with a CNAME conflict = %v", err)
+	}
+}
+
+func TestHosts(t *testing.T) {
+	dhcp := uci.NewConfig()
+	dnsmasq := uci.NewSection("dnsmasq", "")
+	dnsmasq.SetOption("domain", "lan")
+	dhcp.AddSection(dnsmasq)
+	for _, lease := range []struct{ name, mac, ip, dns string }{
+		{"printer", "02:00:00:00:00:01", "192.168.1.20", ""},
+		{"camera", "02:00:00:00:00:02", "192.168.1.21", "0"},
+		{"", "02:00:00:00:00:03", "192.168.1.22", ""},
+	} {
+		section := uci.NewSection(LeaseSection, "")
+		section.SetOption("mac", lease.mac)
+		section.SetOption("ip", lease.ip)
+		if lease.name != "" {
+			section.SetOption("name", lease.name)
+		}
+		if lease.dns != "" {
+			section.SetOption("dns", lease.dns)
+		}
+		dhcp.AddSection(section)
+	}
+	record, ops, err := AddRecord(dhcp, HostRecord("nas.home.arpa", "192.168.1.10"))
+	if err != nil || record.ID != "@domain[0]" || len(ops) != 3 {
+		t.Fatalf("AddRecord = %+v, %v, %v", record, ops, err)
+	}
+	host := uci.NewSection(HostSection, "")
+	SetRecord(host, record)
+	dhcp.AddSection(host)
+
+	hosts, err := Hosts(dhcp)
+	if err != nil {
+		t.Fatalf("Hosts: %v", err)
+	}
+	if got := HostsFile(hosts); got != "192.168.1.10\tnas.home.arpa\n192.168.1.20\tprinter.lan printer\n" {
+		t.Errorf("HostsFile = %q", got)
+	}
+	conf, err := Dnsmasq(dhcp)
+	if err != nil || !strings.Contains(conf, "host-record=printer.lan,printer,192.168.1.20\n") || strings.Contains(conf, "camera") {
+		t.Errorf("Dnsmasq = %q, %v", conf, err)
+	}
+
+	if _, _, err := DeleteHost(dhcp, "@host[0]"); !errors.Is(err, ErrInvalid) {
+		t.Errorf("DeleteHost of a static lease = %v", err)
+	}
+	if _, _, err := AddRecord(dhcp, Record{Type: TypeCNAME, Name: "printer.lan", Value: "nas.home.arpa"}); !errors.Is(err, ErrInvalid) {
+		t.Errorf("AddRecord of a CNAME for a static lease = %v", err)
+	}
+}
+
+func TestQueryLog(t *testing.T) {
+	log := NewQueryLog(3)
+	start := time.Now()
+	for i, message := range []string{
+		"1 192.168.1.5/53214 query[A] Example.com from 192.168.1.5",
+		"1 192.168.1.5/53214 forwarded example.com to 1.1.1.1",
+		"2 192.168.1.5/53215 query[A] ads.example from 192.168.1.5",
+		"2 192.168.1.5/53215 config ads.example is 0.0.0.0",
+		"3 fd00::6/40000 query[AAAA] example.com from fd00::6",
+		"query[A] nas.lan from 192.168.1.7",
+		"config nas.lan is 192.168.1.10",
+		"started, version 2.90 cachesize 150",
+	} {
+		log.Record(message, start.Add(time.Duration(i)*time.Second))
+	}
+
+	// The first query was dropped to keep three
+	stats := log.Stats(time.Time{}, 10)
+	if stats.Total != 3 || stats.Blocked != 1 || !stats.Since.Equal(start.Add(2*time.Second)) {
+		t.Errorf("stats = %+v", stats)
+	}
+	wantClients := []Count{{"192.168.1.5", 1}, {"192.168.1.7", 1}, {"fd00::6", 1}}
+	if !slices.Equal(stats.TopClients, wantClients) {
+		t.Errorf("TopClients = %+v, want %+v", stats.TopClients, wantClients)
+	}
+	if !slices.Equal(stats.TopBlocked, []Count{{"ads.example", 1}}) ||
+		!slices.Equal(stats.TopBlockedClients, []Count{{"192.168.1.5", 1}}) {
+		t.Errorf("blocked = %+v, %+v", stats.TopBlocked, stats.TopBlockedClients)
+	}
+	if stats := log.Stats(start.Add(5*time.Second), 1); stats.Total != 1 || len(stats.TopDomains) != 1 || stats.TopDomains[0].Name != "nas.lan" {
+		t.Errorf("stats since = %+v", stats)
+	}
+
+	var none *QueryLog
+	if stats := none.Stats(time.Time{}, 10); stats.Total != 0 || stats.TopDomains == nil {
+		t.Errorf("stats of a nil log = %+v", stats)
+	}
+}
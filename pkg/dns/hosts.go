@@ -0,0 +1,116 @@
+package dns
+
+import (
+	"fmt"
+	"net"
+	"strings"
+
+	"github.com/thesabbir/hellfire/pkg/uci"
+	"github.com/thesabbir/hellfire/pkg/util"
+)
+
+// LeaseSection is the section type of static DHCP leases
+const LeaseSection = "host"
+
+// StaticLease is a host section: a fixed address for a MAC, and a name
+// registered in DNS unless its dns option is 0
+type StaticLease struct {
+	ID   string `json:"id"` // Section name, or @host[index]
+	Name string `json:"name,omitempty"`
+	MAC  string `json:"mac"`
+	IP   string `json:"ip"`
+	DNS  bool   `json:"dns"` // Whether the name is registered under the local domain
+}
+
+// StaticLeases reads the host sections of a dhcp config, in order
+func StaticLeases(dhcp *uci.Config) ([]StaticLease, error) {
+	var leases []StaticLease
+	for i, section := range dhcp.GetSectionsByType(LeaseSection) {
+		lease := StaticLease{ID: section.Name}
+		if lease.ID == "" {
+			lease.ID = fmt.Sprintf("@%s[%d]", LeaseSection, i)
+		}
+		lease.Name, _ = section.GetOption("name")
+		lease.MAC, _ = section.GetOption("mac")
+		lease.IP, _ = section.GetOption("ip")
+		registered, _ := section.GetOption("dns")
+		lease.DNS = lease.Name != "" && registered != "0"
+
+		if err := util.ValidateMAC(lease.MAC); err != nil {
+			return nil, fmt.Errorf("%w: static lease %s: %v", ErrInvalid, lease.ID, err)
+		}
+		if ip := net.ParseIP(lease.IP); ip == nil || ip.To4() == nil {
+			return nil, fmt.Errorf("%w: static lease %s: invalid IPv4 address %q", ErrInvalid, lease.ID, lease.IP)
+		}
+		if lease.Name != "" {
+			if err := util.ValidateHostname(lease.Name); err != nil {
+				return nil, fmt.Errorf("%w: static lease %s: %v", ErrInvalid, lease.ID, err)
+			}
+			lease.Name = strings.ToLower(lease.Name)
+		}
+		leases = append(leases, lease)
+	}
+	return leases, nil
+}
+
+// Host is a hosts file entry: an address and the names resolving to it,
+// from an A or AAAA record or a static lease
+type Host struct {
+	ID      string   `json:"id"` // Record or static lease ID
+	Address string   `json:"address"`
+	Names   []string `json:"names"`
+	Lease   bool     `json:"lease"` // Registered from a static lease; managed with the lease
+}
+
+// LocalDomain returns the domain option of the dnsmasq section, under which
+// static leases are registered, or "" if there is none
+func LocalDomain(dhcp *uci.Config) string {
+	dnsmasq := dnsmasqSection(dhcp)
+	if dnsmasq == nil {
+		return ""
+	}
+	domain, _ := dnsmasq.GetOption("domain")
+	return strings.ToLower(domain)
+}
+
+// Hosts lists the names dnsmasq resolves locally to addresses: the A and
+// AAAA records, then the static leases registered in DNS, by their name
+// and, for single-label names, their name under the local domain
+func Hosts(dhcp *uci.Config) ([]Host, error) {
+	records, err := Records(dhcp)
+	if err != nil {
+		return nil, err
+	}
+	leases, err := StaticLeases(dhcp)
+	if err != nil {
+		return nil, err
+	}
+
+	var hosts []Host
+	for _, record := range records {
+		if record.Type != TypeCNAME {
+			hosts = append(hosts, Host{ID: record.ID, Address: record.Value, Names: []string{record.Name}})
+		}
+	}
+	domain := LocalDomain(dhcp)
+	for _, lease := range leases {
+		if !lease.DNS {
+			continue
+		}
+		names := []string{lease.Name}
+		if domain != "" && !strings.Contains(lease.Name, ".") {
+			names = []string{lease.Name + "." + domain, lease.Name}
+		}
+		hosts = append(hosts, Host{ID: lease.ID, Address: lease.IP, Names: names, Lease: true})
+	}
+	return hosts, nil
+}
+
+// HostsFile formats hosts as an /etc/hosts file
+func HostsFile(hosts []Host) string {
+	var buf strings.Builder
+	for _, host := range hosts {
+		fmt.Fprintf(&buf, "%s\t%s\n", host.Address, strings.Join(host.Names, " "))
+	}
+	return buf.String()
+}
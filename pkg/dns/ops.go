@@ -0,0 +1,103 @@
+package dns
+
+import (
+	"fmt"
+	"net"
+
+	"github.com/thesabbir/hellfire/pkg/config"
+	"github.com/thesabbir/hellfire/pkg/uci"
+)
+
+// AddRecord checks that a record can be added to a dhcp config and returns
+// it with the ID it will have and the operations staging it
+func AddRecord(dhcp *uci.Config, record Record) (Record, []config.Operation, error) {
+	if err := record.Check(); err != nil {
+		return record, nil, err
+	}
+	sectionType := record.SectionType()
+	record.ID = fmt.Sprintf("@%s[%d]", sectionType, len(dhcp.GetSectionsByType(sectionType)))
+
+	staged := dhcp.Clone()
+	section := uci.NewSection(sectionType, "")
+	SetRecord(section, record)
+	staged.AddSection(section)
+	if err := Validate(staged); err != nil {
+		return record, nil, err
+	}
+
+	path := "dhcp." + record.ID
+	name, value := record.Options()
+	return record, []config.Operation{
+		{Op: config.OpAdd, Path: "dhcp." + sectionType},
+		{Op: config.OpSet, Path: path + "." + name, Value: record.Name},
+		{Op: config.OpSet, Path: path + "." + value, Value: record.Value},
+	}, nil
+}
+
+// UpdateRecord checks that the record with an ID can be changed to record
+// and returns the operations staging it. A and AAAA records can change into
+// each other, but not into a CNAME or back.
+func UpdateRecord(dhcp *uci.Config, id string, record Record) (Record, []config.Operation, error) {
+	record.ID = id
+	if err := record.Check(); err != nil {
+		return record, nil, err
+	}
+	current, err := FindRecord(dhcp, id)
+	if err != nil {
+		return record, nil, err
+	}
+	if current.SectionType() != record.SectionType() {
+		return record, nil, fmt.Errorf("%w: can't change a %s record into %s, delete and add it instead", ErrInvalid, current.Type, record.Type)
+	}
+
+	staged := dhcp.Clone()
+	SetRecord(RecordSection(staged, id), record)
+	if err := Validate(staged); err != nil {
+		return record, nil, err
+	}
+
+	path := "dhcp." + id
+	name, value := record.Options()
+	return record, []config.Operation{
+		{Op: config.OpSet, Path: path + "." + name, Value: record.Name},
+		{Op: config.OpSet, Path: path + "." + value, Value: record.Value},
+	}, nil
+}
+
+// DeleteRecord returns the record with an ID and the operation removing it
+func DeleteRecord(dhcp *uci.Config, id string) (Record, []config.Operation, error) {
+	record, err := FindRecord(dhcp, id)
+	if err != nil {
+		return record, nil, err
+	}
+	return record, []config.Operation{{Op: config.OpDelete, Path: "dhcp." + id}}, nil
+}
+
+// HostRecord returns the A or AAAA record of a hosts entry, by the family
+// of its address
+func HostRecord(name, address string) Record {
+	record := Record{Type: TypeA, Name: name, Value: address}
+	if ip := net.ParseIP(address); ip != nil && ip.To4() == nil {
+		record.Type = TypeAAAA
+	}
+	return record
+}
+
+// DeleteHost returns the record of the hosts entry with an ID and the
+// operation removing it. Entries of static leases go with their lease.
+func DeleteHost(dhcp *uci.Config, id string) (Record, []config.Operation, error) {
+	hosts, err := Hosts(dhcp)
+	if err != nil {
+		return Record{}, nil, err
+	}
+	for _, host := range hosts {
+		if host.ID == id && host.Lease {
+			return Record{}, nil, fmt.Errorf("%w: %s is registered by a static lease; delete the lease or set its dns option to 0", ErrInvalid, id)
+		}
+	}
+	record, ops, err := DeleteRecord(dhcp, id)
+	if err == nil && record.Type == TypeCNAME {
+		return record, nil, fmt.Errorf("hosts entry %s: %w", id, ErrNotFound)
+	}
+	return record, ops, err
+}
@@ -0,0 +1,205 @@
+package dns
+
+import (
+	"cmp"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DefaultQueryLogSize is the number of queries a query log keeps
+const DefaultQueryLogSize = 10000
+
+// blockedWindow is how many recent queries a blocked answer is matched
+// against; dnsmasq logs it right after the query
+const blockedWindow = 64
+
+// Query is a DNS query logged by dnsmasq
+type Query struct {
+	Time    time.Time `json:"time"`
+	Client  string    `json:"client"`
+	Domain  string    `json:"domain"`
+	Type    string    `json:"type"`
+	Blocked bool      `json:"blocked"` // Answered locally with 0.0.0.0, :: or NXDOMAIN
+	serial  string
+}
+
+// logLine is a parsed log-queries=extra line: the query's serial number and
+// client, the action (query[A], forwarded, reply, config...) and the rest
+type logLine struct {
+	serial string
+	client string
+	action string
+	fields []string
+}
+
+// parseLogLine parses a dnsmasq query log message, with or without the
+// serial and client prefix of log-queries=extra
+func parseLogLine(message string) (logLine, bool) {
+	fields := strings.Fields(message)
+	var line logLine
+	if len(fields) > 2 {
+		if _, err := strconv.ParseUint(fields[0], 10, 64); err == nil && strings.Contains(fields[1], "/") {
+			line.serial = fields[0]
+			line.client = fields[1][:strings.LastIndex(fields[1], "/")]
+			fields = fields[2:]
+		}
+	}
+	if len(fields) < 2 {
+		return line, false
+	}
+	line.action, line.fields = fields[0], fields[1:]
+	return line, true
+}
+
+// QueryLog keeps the most recent queries parsed from dnsmasq's query log
+type QueryLog struct {
+	mu      sync.RWMutex
+	queries []Query
+	next    int // Where the next query goes once the log is full
+}
+
+// NewQueryLog creates a query log keeping size queries
+func NewQueryLog(size int) *QueryLog {
+	return &QueryLog{queries: make([]Query, 0, size)}
+}
+
+// Record parses a dnsmasq log message received at a time: queries are
+// added, and blocked answers mark the query they answer. Other messages
+// are ignored.
+func (l *QueryLog) Record(message string, received time.Time) {
+	line, ok := parseLogLine(message)
+	if !ok {
+		return
+	}
+
+	switch {
+	case strings.HasPrefix(line.action, "query[") && strings.HasSuffix(line.action, "]"):
+		query := Query{
+			Time:   received,
+			Client: line.client,
+			Domain: strings.ToLower(line.fields[0]),
+			Type:   line.action[len("query[") : len(line.action)-1],
+			serial: line.serial,
+		}
+		// query[A] example.com from 192.168.1.5
+		if query.Client == "" && len(line.fields) == 3 && line.fields[1] == "from" {
+			query.Client = line.fields[2]
+		}
+		l.add(query)
+	case line.action == "config" && len(line.fields) == 3 && line.fields[1] == "is":
+		switch line.fields[2] {
+		case "0.0.0.0", "::", "NXDOMAIN":
+			l.markBlocked(line.serial, strings.ToLower(line.fields[0]))
+		}
+	}
+}
+
+// add stores a query, replacing the oldest when the log is full
+func (l *QueryLog) add(query Query) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if len(l.queries) < cap(l.queries) {
+		l.queries = append(l.queries, query)
+		return
+	}
+	l.queries[l.next] = query
+	l.next = (l.next + 1) % len(l.queries)
+}
+
+// markBlocked marks the latest recent query for a domain, with the serial
+// number if known, as blocked
+func (l *QueryLog) markBlocked(serial, domain string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	n := len(l.queries)
+	for i := 0; i < min(n, blockedWindow); i++ {
+		q := &l.queries[(l.next-1-i+2*n)%n]
+		if q.Domain == domain && q.serial == serial {
+			q.Blocked = true
+			return
+		}
+	}
+}
+
+// Count is the number of queries for a domain or from a client
+type Count struct {
+	Name    string `json:"name"`
+	Queries int    `json:"queries"`
+}
+
+// QueryStats summarizes the queries in a query log
+type QueryStats struct {
+	Since             time.Time `json:"since,omitzero"` // Oldest query counted
+	Total             int       `json:"total"`
+	Blocked           int       `json:"blocked"`
+	TopDomains        []Count   `json:"top_domains"`
+	TopClients        []Count   `json:"top_clients"`
+	TopBlocked        []Count   `json:"top_blocked"`         // Blocked domains
+	TopBlockedClients []Count   `json:"top_blocked_clients"` // Clients by blocked queries
+}
+
+// Stats counts the queries received at or after since (all if zero), with
+// the top n domains and clients. A nil log has no queries.
+func (l *QueryLog) Stats(since time.Time, n int) QueryStats {
+	var queries []Query
+	if l != nil {
+		l.mu.RLock()
+		defer l.mu.RUnlock()
+		queries = l.queries
+	}
+
+	domains := map[string]int{}
+	clients := map[string]int{}
+	blocked := map[string]int{}
+	blockedClients := map[string]int{}
+	var stats QueryStats
+	for _, q := range queries {
+		if q.Time.Before(since) {
+			continue
+		}
+		if stats.Since.IsZero() || q.Time.Before(stats.Since) {
+			stats.Since = q.Time
+		}
+		stats.Total++
+		domains[q.Domain]++
+		if q.Client != "" {
+			clients[q.Client]++
+		}
+		if q.Blocked {
+			stats.Blocked++
+			blocked[q.Domain]++
+			if q.Client != "" {
+				blockedClients[q.Client]++
+			}
+		}
+	}
+
+	stats.TopDomains = topCounts(domains, n)
+	stats.TopClients = topCounts(clients, n)
+	stats.TopBlocked = topCounts(blocked, n)
+	stats.TopBlockedClients = topCounts(blockedClients, n)
+	return stats
+}
+
+// topCounts returns the n largest counts, largest first, by name on ties
+func topCounts(counts map[string]int, n int) []Count {
+	top := make([]Count, 0, len(counts))
+	for name, queries := range counts {
+		top = append(top, Count{Name: name, Queries: queries})
+	}
+	slices.SortFunc(top, func(a, b Count) int {
+		if c := cmp.Compare(b.Queries, a.Queries); c != 0 {
+			return c
+		}
+		return strings.Compare(a.Name, b.Name)
+	})
+	if n > 0 && len(top) > n {
+		top = top[:n]
+	}
+	return top
+}
@@ -0,0 +1,201 @@
+package errors
+
+import (
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/gin-gonic/gin"
+)
+
+// DefaultLanguage is used when the client accepts none of the catalogs
+const DefaultLanguage = "en"
+
+var (
+	catalogMu sync.RWMutex
+
+	// catalogs maps language (lower-case primary subtag, e.g. "de") to the
+	// messages of each code. Codes missing from a catalog fall back to English.
+	catalogs = map[string]map[Code]string{
+		"en": {
+			CodeAuthentication:         ErrAuthentication,
+			CodeAuthenticationRequired: "authentication required",
+			CodeSessionExpired:         "invalid or expired session",
+			CodeAPIKeyRequired:         "API key required",
+			CodeUnauthorized:           ErrUnauthorized,
+			CodeForbidden:              ErrForbidden,
+			CodeNotFound:               ErrNotFound,
+			CodeBadRequest:             ErrBadRequest,
+			CodeInternalServer:         ErrInternalServer,
+			CodeValidation:             ErrValidation,
+			CodeRateLimit:              ErrRateLimit,
+			CodeCSRFMissing:            "CSRF token missing",
+			CodeInvalidCSRF:            ErrInvalidCSRF,
+			CodeInvalidInput:           ErrInvalidInput,
+			CodeOperationFailed:        ErrOperationFailed,
+			CodeConflict:               ErrConflict,
+			CodeConfigLocked:           ErrConfigLocked,
+		},
+		"de": {
+			CodeAuthentication:         "Authentifizierung fehlgeschlagen",
+			CodeAuthenticationRequired: "Anmeldung erforderlich",
+			CodeSessionExpired:         "Sitzung ungültig oder abgelaufen",
+			CodeAPIKeyRequired:         "API-Schlüssel erforderlich",
+			CodeUnauthorized:           "Zugriff nicht erlaubt",
+			CodeForbidden:              "Unzureichende Berechtigungen",
+			CodeNotFound:               "Ressource nicht gefunden",
+			CodeBadRequest:             "Ungültige Anfrage",
+			CodeInternalServer:         "Interner Serverfehler",
+			CodeValidation:             "Validierung fehlgeschlagen",
+			CodeRateLimit:              "Zu viele Anfragen, bitte später erneut versuchen",
+			CodeCSRFMissing:            "CSRF-Token fehlt",
+			CodeInvalidCSRF:            "CSRF-Token ungültig oder abgelaufen",
+			CodeInvalidInput:           "Ungültige Eingabe",
+			CodeOperationFailed:        "Vorgang fehlgeschlagen",
+			CodeConflict:               "die Konfiguration wurde seit dem Lesen geändert",
+			CodeConfigLocked:           "die Konfiguration ist von einem anderen Benutzer gesperrt",
+		},
+		"fr": {
+			CodeAuthentication:         "échec de l'authentification",
+			CodeAuthenticationRequired: "authentification requise",
+			CodeSessionExpired:         "session invalide ou expirée",
+			CodeAPIKeyRequired:         "clé d'API requise",
+			CodeUnauthorized:           "accès non autorisé",
+			CodeForbidden:              "permissions insuffisantes",
+			CodeNotFound:               "ressource introuvable",
+			CodeBadRequest:             "requête invalide",
+			CodeInternalServer:         "erreur interne du serveur",
+			CodeValidation:             "échec de la validation",
+			CodeRateLimit:              "trop de requêtes, veuillez réessayer plus tard",
+			CodeCSRFMissing:            "jeton CSRF manquant",
+			CodeInvalidCSRF:            "jeton CSRF invalide ou expiré",
+			CodeInvalidInput:           "saisie invalide",
+			CodeOperationFailed:        "échec de l'opération",
+			CodeConflict:               "la configuration a changé depuis sa lecture",
+			CodeConfigLocked:           "la configuration est verrouillée par un autre utilisateur",
+		},
+		"es": {
+			CodeAuthentication:         "error de autenticación",
+			CodeAuthenticationRequired: "se requiere autenticación",
+			CodeSessionExpired:         "sesión no válida o caducada",
+			CodeAPIKeyRequired:         "se requiere una clave de API",
+			CodeUnauthorized:           "acceso no autorizado",
+			CodeForbidden:              "permisos insuficientes",
+			CodeNotFound:               "recurso no encontrado",
+			CodeBadRequest:             "solicitud no válida",
+			CodeInternalServer:         "error interno del servidor",
+			CodeValidation:             "error de validación",
+			CodeRateLimit:              "demasiadas solicitudes, inténtelo de nuevo más tarde",
+			CodeCSRFMissing:            "falta el token CSRF",
+			CodeInvalidCSRF:            "token CSRF no válido o caducado",
+			CodeInvalidInput:           "entrada no válida",
+			CodeOperationFailed:        "la operación ha fallado",
+			CodeConflict:               "la configuración cambió desde que se leyó",
+			CodeConfigLocked:           "la configuración está bloqueada por otro usuario",
+		},
+	}
+)
+
+// RegisterCatalog adds or extends the message catalog of a language
+// (primary subtag, e.g. "pt")
+func RegisterCatalog(lang string, messages map[Code]string) {
+	lang = strings.ToLower(lang)
+
+	catalogMu.Lock()
+	defer catalogMu.Unlock()
+
+	catalog, ok := catalogs[lang]
+	if !ok {
+		catalog = make(map[Code]string, len(messages))
+		catalogs[lang] = catalog
+	}
+	for code, message := range messages {
+		catalog[code] = message
+	}
+}
+
+// Languages returns the languages that have a catalog
+func Languages() []string {
+	catalogMu.RLock()
+	defer catalogMu.RUnlock()
+
+	langs := make([]string, 0, len(catalogs))
+	for lang := range catalogs {
+		langs = append(langs, lang)
+	}
+	sort.Strings(langs)
+	return langs
+}
+
+// Message returns the message of code in lang, falling back to English
+// and then to the code itself
+func Message(lang string, code Code) string {
+	catalogMu.RLock()
+	defer catalogMu.RUnlock()
+
+	if message, ok := catalogs[strings.ToLower(lang)][code]; ok {
+		return message
+	}
+	if message, ok := catalogs[DefaultLanguage][code]; ok {
+		return message
+	}
+	return string(code)
+}
+
+// Localize returns the message of code in the language the request's
+// Accept-Language header prefers
+func Localize(c *gin.Context, code Code) string {
+	return Message(Language(c.GetHeader("Accept-Language")), code)
+}
+
+// Language picks the catalog language an Accept-Language header prefers
+// (e.g. "de-CH, de;q=0.9, en;q=0.8" is "de"), or DefaultLanguage
+func Language(acceptLanguage string) string {
+	type candidate struct {
+		lang string
+		q    float64
+	}
+
+	var candidates []candidate
+	for _, part := range strings.Split(acceptLanguage, ",") {
+		tag, params, _ := strings.Cut(strings.TrimSpace(part), ";")
+		q := 1.0
+		if value, found := strings.CutPrefix(strings.TrimSpace(params), "q="); found {
+			parsed, err := strconv.ParseFloat(value, 64)
+			if err != nil {
+				continue
+			}
+			q = parsed
+		}
+		primary, _, _ := strings.Cut(strings.ToLower(strings.TrimSpace(tag)), "-")
+		if primary == "" || primary == "*" || q <= 0 {
+			continue
+		}
+		candidates = append(candidates, candidate{lang: primary, q: q})
+	}
+	// Stable keeps the header's order for equal weights
+	sort.SliceStable(candidates, func(i, j int) bool { return candidates[i].q > candidates[j].q })
+
+	catalogMu.RLock()
+	defer catalogMu.RUnlock()
+	for _, candidate := range candidates {
+		if _, ok := catalogs[candidate.lang]; ok {
+			return candidate.lang
+		}
+	}
+	return DefaultLanguage
+}
+
+// codeOf finds the code of an English catalog message
+func codeOf(message string) (Code, bool) {
+	catalogMu.RLock()
+	defer catalogMu.RUnlock()
+
+	for code, text := range catalogs[DefaultLanguage] {
+		if text == message {
+			return code, true
+		}
+	}
+	return "", false
+}
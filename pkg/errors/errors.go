@@ -16,14 +16,78 @@ const (
 	ErrBadRequest      = "invalid request"
 	ErrInternalServer  = "internal server error"
 	ErrValidation      = "validation failed"
-	ErrRateLimit       = "rate limit exceeded"
-	ErrInvalidCSRF     = "invalid CSRF token"
+	ErrRateLimit       = "rate limit exceeded, please try again later"
+	ErrInvalidCSRF     = "invalid or expired CSRF token"
 	ErrInvalidInput    = "invalid input"
 	ErrOperationFailed = "operation failed"
+	ErrConflict        = "the configuration changed since it was read"
+	ErrConfigLocked    = "the configuration is locked by another user"
 )
 
-// RespondWithError sends a generic error response and logs the detailed error
+// Code is a machine-readable error code, sent as "code" next to the
+// (localized) message so clients can tell errors apart without parsing text
+type Code string
+
+// Error codes
+const (
+	CodeAuthentication         Code = "authentication_failed"
+	CodeAuthenticationRequired Code = "authentication_required"
+	CodeSessionExpired         Code = "session_expired"
+	CodeAPIKeyRequired         Code = "api_key_required"
+	CodeUnauthorized           Code = "unauthorized"
+	CodeForbidden              Code = "forbidden"
+	CodeNotFound               Code = "not_found"
+	CodeBadRequest             Code = "bad_request"
+	CodeInternalServer         Code = "internal_error"
+	CodeValidation             Code = "validation_failed"
+	CodeRateLimit              Code = "rate_limited"
+	CodeCSRFMissing            Code = "csrf_missing"
+	CodeInvalidCSRF            Code = "csrf_invalid"
+	CodeInvalidInput           Code = "invalid_input"
+	CodeOperationFailed        Code = "operation_failed"
+	CodeConflict               Code = "conflict"
+	CodeConfigLocked           Code = "config_locked"
+)
+
+// Respond sends an error response with code and its message in the
+// client's language, and logs the detailed error (not sent to the client)
+func Respond(c *gin.Context, statusCode int, code Code, detailedError error) {
+	if detailedError != nil {
+		logger.Error("Request error",
+			"path", c.Request.URL.Path,
+			"method", c.Request.Method,
+			"status", statusCode,
+			"code", code,
+			"error", detailedError.Error(),
+			"client_ip", c.ClientIP())
+	}
+
+	c.JSON(statusCode, Body(c, code))
+}
+
+// Abort is Respond without a detailed error, also stopping the handler chain
+func Abort(c *gin.Context, statusCode int, code Code) {
+	Respond(c, statusCode, code, nil)
+	c.Abort()
+}
+
+// Body returns the error response body for code, for handlers that add
+// fields of their own
+func Body(c *gin.Context, code Code) gin.H {
+	return gin.H{
+		"error": Localize(c, code),
+		"code":  code,
+	}
+}
+
+// RespondWithError sends a generic error response and logs the detailed
+// error. Messages of the built-in catalog get their code and translation.
 func RespondWithError(c *gin.Context, statusCode int, genericMessage string, detailedError error) {
+	if code, ok := codeOf(genericMessage); ok {
+		Respond(c, statusCode, code, detailedError)
+		return
+	}
+
 	// Log the detailed error for debugging (not sent to client)
 	if detailedError != nil {
 		logger.Error("Request error",
@@ -43,29 +107,33 @@ func RespondWithError(c *gin.Context, statusCode int, genericMessage string, det
 // Convenience functions for common error scenarios
 
 func BadRequest(c *gin.Context, err error) {
-	RespondWithError(c, http.StatusBadRequest, ErrBadRequest, err)
+	Respond(c, http.StatusBadRequest, CodeBadRequest, err)
 }
 
 func Unauthorized(c *gin.Context, err error) {
-	RespondWithError(c, http.StatusUnauthorized, ErrAuthentication, err)
+	Respond(c, http.StatusUnauthorized, CodeAuthentication, err)
 }
 
 func Forbidden(c *gin.Context, err error) {
-	RespondWithError(c, http.StatusForbidden, ErrForbidden, err)
+	Respond(c, http.StatusForbidden, CodeForbidden, err)
 }
 
 func NotFound(c *gin.Context, err error) {
-	RespondWithError(c, http.StatusNotFound, ErrNotFound, err)
+	Respond(c, http.StatusNotFound, CodeNotFound, err)
 }
 
 func InternalServerError(c *gin.Context, err error) {
-	RespondWithError(c, http.StatusInternalServerError, ErrInternalServer, err)
+	Respond(c, http.StatusInternalServerError, CodeInternalServer, err)
 }
 
 func ValidationError(c *gin.Context, err error) {
-	RespondWithError(c, http.StatusBadRequest, ErrValidation, err)
+	Respond(c, http.StatusBadRequest, CodeValidation, err)
 }
 
 func OperationFailed(c *gin.Context, err error) {
-	RespondWithError(c, http.StatusInternalServerError, ErrOperationFailed, err)
+	Respond(c, http.StatusInternalServerError, CodeOperationFailed, err)
+}
+
+func Conflict(c *gin.Context, err error) {
+	Respond(c, http.StatusConflict, CodeConflict, err)
 }
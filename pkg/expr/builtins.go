@@ -0,0 +1,237 @@
+package expr
+
+import (
+	"encoding/binary"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+)
+
+// builtin is a function expressions can call
+type builtin struct {
+	min, max int // Number of arguments; max -1 for any
+	call     func(e *Evaluator, args []Value) (Value, error)
+}
+
+// builtins are the functions expressions can call. None has side effects.
+var builtins map[string]builtin
+
+func init() {
+	builtins = map[string]builtin{
+		// uci(path[, default]): the option at config.section.option
+		"uci": {1, 2, func(e *Evaluator, args []Value) (Value, error) {
+			value, err := e.env.Option(String(args[0]))
+			if err != nil && len(args) == 2 {
+				return args[1], nil
+			}
+			return value, err
+		}},
+
+		// cidr(ip, netmask or prefix length): the network holding ip
+		"cidr": {2, 2, func(e *Evaluator, args []Value) (Value, error) {
+			ip, err := ipv4(args[0])
+			if err != nil {
+				return nil, err
+			}
+			bits, err := prefixLength(args[1])
+			if err != nil {
+				return nil, err
+			}
+			mask := net.CIDRMask(bits, 32)
+			return (&net.IPNet{IP: ip.Mask(mask), Mask: mask}).String(), nil
+		}},
+		"network": {1, 1, func(e *Evaluator, args []Value) (Value, error) {
+			subnet, err := ipv4Net(args[0])
+			if err != nil {
+				return nil, err
+			}
+			return subnet.IP.String(), nil
+		}},
+		"netmask": {1, 1, func(e *Evaluator, args []Value) (Value, error) {
+			subnet, err := ipv4Net(args[0])
+			if err != nil {
+				return nil, err
+			}
+			return net.IP(subnet.Mask).String(), nil
+		}},
+		"prefix": {1, 1, func(e *Evaluator, args []Value) (Value, error) {
+			subnet, err := ipv4Net(args[0])
+			if err != nil {
+				return nil, err
+			}
+			ones, _ := subnet.Mask.Size()
+			return int64(ones), nil
+		}},
+		"size": {1, 1, func(e *Evaluator, args []Value) (Value, error) {
+			subnet, err := ipv4Net(args[0])
+			if err != nil {
+				return nil, err
+			}
+			return subnetSize(subnet), nil
+		}},
+		"broadcast": {1, 1, func(e *Evaluator, args []Value) (Value, error) {
+			subnet, err := ipv4Net(args[0])
+			if err != nil {
+				return nil, err
+			}
+			return hostAddress(subnet, subnetSize(subnet)-1), nil
+		}},
+
+		// host(cidr, n): the nth address of a network, counting back from
+		// the broadcast address when n is negative (-1 is the last host)
+		"host": {2, 2, func(e *Evaluator, args []Value) (Value, error) {
+			subnet, err := ipv4Net(args[0])
+			if err != nil {
+				return nil, err
+			}
+			n, err := toInt(args[1])
+			if err != nil {
+				return nil, err
+			}
+			index := n
+			if n < 0 {
+				index += subnetSize(subnet) - 1
+			}
+			if index < 0 || index >= subnetSize(subnet) {
+				return nil, fmt.Errorf("%s has no address %d", subnet, n)
+			}
+			return hostAddress(subnet, index), nil
+		}},
+
+		// offset(cidr, ip): the position of ip in a network, as DHCP start
+		// options take it
+		"offset": {2, 2, func(e *Evaluator, args []Value) (Value, error) {
+			subnet, err := ipv4Net(args[0])
+			if err != nil {
+				return nil, err
+			}
+			ip, err := ipv4(args[1])
+			if err != nil {
+				return nil, err
+			}
+			if !subnet.Contains(ip) {
+				return nil, fmt.Errorf("%s is not in %s", ip, subnet)
+			}
+			return int64(binary.BigEndian.Uint32(ip) - binary.BigEndian.Uint32(subnet.IP)), nil
+		}},
+		"contains": {2, 2, func(e *Evaluator, args []Value) (Value, error) {
+			subnet, err := ipv4Net(args[0])
+			if err != nil {
+				return nil, err
+			}
+			ip, err := ipv4(args[1])
+			if err != nil {
+				return nil, err
+			}
+			return subnet.Contains(ip), nil
+		}},
+
+		"int": {1, 1, func(e *Evaluator, args []Value) (Value, error) { return toInt(args[0]) }},
+		"str": {1, 1, func(e *Evaluator, args []Value) (Value, error) { return String(args[0]), nil }},
+		"len": {1, 1, func(e *Evaluator, args []Value) (Value, error) { return int64(len(String(args[0]))), nil }},
+		"lower": {1, 1, func(e *Evaluator, args []Value) (Value, error) {
+			return strings.ToLower(String(args[0])), nil
+		}},
+		"upper": {1, 1, func(e *Evaluator, args []Value) (Value, error) {
+			return strings.ToUpper(String(args[0])), nil
+		}},
+		"replace": {3, 3, func(e *Evaluator, args []Value) (Value, error) {
+			s, old := String(args[0]), String(args[1])
+			if old == "" {
+				return nil, fmt.Errorf("empty string to replace")
+			}
+			return strings.ReplaceAll(s, old, String(args[2])), nil
+		}},
+
+		// word(list, n): the nth of the space-separated values, as uci()
+		// returns lists; "" past the end
+		"word": {2, 2, func(e *Evaluator, args []Value) (Value, error) {
+			n, err := toInt(args[1])
+			if err != nil {
+				return nil, err
+			}
+			words := strings.Fields(String(args[0]))
+			if n < 0 || n >= int64(len(words)) {
+				return "", nil
+			}
+			return words[n], nil
+		}},
+		"min": {1, -1, func(e *Evaluator, args []Value) (Value, error) { return extreme(args, -1) }},
+		"max": {1, -1, func(e *Evaluator, args []Value) (Value, error) { return extreme(args, 1) }},
+	}
+}
+
+// Functions lists the names of the built-in functions
+func Functions() []string {
+	names := make([]string, 0, len(builtins))
+	for name := range builtins {
+		names = append(names, name)
+	}
+	return names
+}
+
+// ipv4 converts a value to an IPv4 address
+func ipv4(v Value) (net.IP, error) {
+	ip := net.ParseIP(strings.TrimSpace(String(v))).To4()
+	if ip == nil {
+		return nil, fmt.Errorf("%q is not an IPv4 address", String(v))
+	}
+	return ip, nil
+}
+
+// ipv4Net converts a value to an IPv4 network; an address is a /32
+func ipv4Net(v Value) (*net.IPNet, error) {
+	s := strings.TrimSpace(String(v))
+	if !strings.Contains(s, "/") {
+		s += "/32"
+	}
+	ip, subnet, err := net.ParseCIDR(s)
+	if err != nil || ip.To4() == nil {
+		return nil, fmt.Errorf("%q is not an IPv4 network", String(v))
+	}
+	subnet.IP = subnet.IP.To4()
+	return subnet, nil
+}
+
+// prefixLength converts a netmask or a prefix length to a prefix length
+func prefixLength(v Value) (int, error) {
+	s := strings.TrimSpace(String(v))
+	if bits, err := strconv.Atoi(s); err == nil && bits >= 0 && bits <= 32 {
+		return bits, nil
+	}
+	if mask := net.ParseIP(s).To4(); mask != nil {
+		if bits, size := net.IPMask(mask).Size(); size == 32 {
+			return bits, nil
+		}
+	}
+	return 0, fmt.Errorf("%q is not a netmask or prefix length", s)
+}
+
+// subnetSize returns the number of addresses in a network
+func subnetSize(subnet *net.IPNet) int64 {
+	ones, _ := subnet.Mask.Size()
+	return int64(1) << (32 - ones)
+}
+
+// hostAddress returns the address index after a network's address
+func hostAddress(subnet *net.IPNet, index int64) string {
+	ip := make(net.IP, 4)
+	binary.BigEndian.PutUint32(ip, binary.BigEndian.Uint32(subnet.IP)+uint32(index))
+	return ip.String()
+}
+
+// extreme returns the smallest (sign -1) or largest (sign 1) number
+func extreme(args []Value, sign int64) (Value, error) {
+	var best int64
+	for i, arg := range args {
+		n, err := toInt(arg)
+		if err != nil {
+			return nil, err
+		}
+		if i == 0 || (sign < 0 && n < best) || (sign > 0 && n > best) {
+			best = n
+		}
+	}
+	return best, nil
+}
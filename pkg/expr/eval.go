@@ -0,0 +1,212 @@
+package expr
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// eval evaluates a parsed expression
+func (e *Evaluator) eval(n node) (Value, error) {
+	e.steps++
+	if e.steps > MaxSteps {
+		return nil, fmt.Errorf("evaluation exceeded %d steps", MaxSteps)
+	}
+
+	switch n := n.(type) {
+	case literal:
+		return n.value, nil
+	case name:
+		if len(e.stack) >= maxDepth {
+			return nil, fmt.Errorf("named expressions nested too deeply at %s", n.name)
+		}
+		return e.lookup(n.name)
+	case unaryOp:
+		v, err := e.eval(n.operand)
+		if err != nil {
+			return nil, err
+		}
+		if n.op == "!" {
+			b, err := toBool(v)
+			return !b, err
+		}
+		i, err := toInt(v)
+		return -i, err
+	case cond:
+		test, err := e.eval(n.test)
+		if err != nil {
+			return nil, err
+		}
+		b, err := toBool(test)
+		if err != nil {
+			return nil, err
+		}
+		if b {
+			return e.eval(n.then)
+		}
+		return e.eval(n.otherwise)
+	case binaryOp:
+		return e.binary(n)
+	case call:
+		fn, ok := builtins[n.name]
+		if !ok {
+			return nil, fmt.Errorf("unknown function %s", n.name)
+		}
+		args := make([]Value, len(n.args))
+		for i, arg := range n.args {
+			v, err := e.eval(arg)
+			if err != nil {
+				return nil, err
+			}
+			args[i] = v
+		}
+		if len(args) < fn.min || (fn.max >= 0 && len(args) > fn.max) {
+			return nil, fmt.Errorf("%s: wrong number of arguments (%d)", n.name, len(args))
+		}
+		v, err := fn.call(e, args)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", n.name, err)
+		}
+		if s, ok := v.(string); ok && len(s) > MaxLength {
+			return nil, fmt.Errorf("%s: result is longer than %d bytes", n.name, MaxLength)
+		}
+		return v, nil
+	}
+	return nil, fmt.Errorf("invalid expression")
+}
+
+// binary evaluates a binary operator; && and || short-circuit
+func (e *Evaluator) binary(n binaryOp) (Value, error) {
+	left, err := e.eval(n.left)
+	if err != nil {
+		return nil, err
+	}
+	if n.op == "&&" || n.op == "||" {
+		l, err := toBool(left)
+		if err != nil {
+			return nil, err
+		}
+		if l == (n.op == "||") {
+			return l, nil
+		}
+		right, err := e.eval(n.right)
+		if err != nil {
+			return nil, err
+		}
+		return toBool(right)
+	}
+	right, err := e.eval(n.right)
+	if err != nil {
+		return nil, err
+	}
+
+	switch n.op {
+	case "==", "!=":
+		equal := String(left) == String(right)
+		if typeName(left) == typeName(right) {
+			equal = left == right
+		}
+		return equal == (n.op == "=="), nil
+	case "+":
+		// Joins strings, adds numbers
+		_, ls := left.(string)
+		_, rs := right.(string)
+		if ls || rs {
+			s := String(left) + String(right)
+			if len(s) > MaxLength {
+				return nil, fmt.Errorf("string is longer than %d bytes", MaxLength)
+			}
+			return s, nil
+		}
+	case "<", "<=", ">", ">=":
+		if ls, ok := left.(string); ok {
+			if rs, ok := right.(string); ok {
+				return compare(n.op, strings.Compare(ls, rs)), nil
+			}
+		}
+	}
+
+	l, err := toInt(left)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", n.op, err)
+	}
+	r, err := toInt(right)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", n.op, err)
+	}
+	switch n.op {
+	case "+":
+		return l + r, nil
+	case "-":
+		return l - r, nil
+	case "*":
+		return l * r, nil
+	case "/", "%":
+		if r == 0 {
+			return nil, fmt.Errorf("division by zero")
+		}
+		if n.op == "/" {
+			return l / r, nil
+		}
+		return l % r, nil
+	}
+	switch {
+	case l < r:
+		return compare(n.op, -1), nil
+	case l > r:
+		return compare(n.op, 1), nil
+	}
+	return compare(n.op, 0), nil
+}
+
+// compare applies a comparison operator to the result of a three-way compare
+func compare(op string, c int) bool {
+	switch op {
+	case "<":
+		return c < 0
+	case "<=":
+		return c <= 0
+	case ">":
+		return c > 0
+	}
+	return c >= 0
+}
+
+// toBool converts a value to a bool: strings as config booleans
+func toBool(v Value) (bool, error) {
+	switch v := v.(type) {
+	case bool:
+		return v, nil
+	case int64:
+		return v != 0, nil
+	case string:
+		switch strings.ToLower(v) {
+		case "1", "true", "on", "yes", "enabled":
+			return true, nil
+		case "", "0", "false", "off", "no", "disabled":
+			return false, nil
+		}
+		return false, fmt.Errorf("%q is not a boolean", v)
+	}
+	return false, fmt.Errorf("%s is not a boolean", typeName(v))
+}
+
+// toInt converts a value to a number: strings holding one, and bools
+func toInt(v Value) (int64, error) {
+	switch v := v.(type) {
+	case int64:
+		return v, nil
+	case bool:
+		if v {
+			return 1, nil
+		}
+		return 0, nil
+	case string:
+		n, err := strconv.ParseInt(strings.TrimSpace(v), 10, 64)
+		if err != nil {
+			return 0, fmt.Errorf("%q is not a number", v)
+		}
+		return n, nil
+	}
+	return 0, fmt.Errorf("%s is not a number", typeName(v))
+}
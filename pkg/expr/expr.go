@@ -0,0 +1,163 @@
+// Package expr evaluates the small expressions config values can embed
+// between {{ and }}, such as a DHCP option derived from the LAN address. It
+// is a sandbox: expressions read config options and named expressions
+// through an Env and compute with the built-in functions, with no access
+// to files, commands or the network, and bounded work.
+package expr
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+const (
+	// MaxSteps bounds the operations one Evaluator runs
+	MaxSteps = 100000
+
+	// MaxLength bounds the strings expressions build and their source
+	MaxLength = 4096
+
+	// maxDepth bounds the nesting of expressions and named expressions
+	maxDepth = 32
+)
+
+// Env resolves the names an expression refers to
+type Env interface {
+	// Option returns the value of the option at a config.section.option
+	// path, a list's values joined by spaces
+	Option(path string) (string, error)
+
+	// Named returns the source of a named expression
+	Named(name string) (string, bool)
+}
+
+// Value is the result of an expression: a string, an int64 or a bool
+type Value any
+
+// Evaluator evaluates expressions against an Env, caching the named
+// expressions it evaluates
+type Evaluator struct {
+	env   Env
+	steps int
+	named map[string]Value
+	stack []string // Named expressions being evaluated
+}
+
+// NewEvaluator creates an evaluator reading from env
+func NewEvaluator(env Env) *Evaluator {
+	return &Evaluator{env: env, named: make(map[string]Value)}
+}
+
+// ValidName reports whether name can be referred to in expressions: a
+// letter or underscore, then letters, digits and underscores
+func ValidName(name string) bool {
+	if name == "" || name == "true" || name == "false" {
+		return false
+	}
+	for i, c := range name {
+		if c != '_' && !(c|0x20 >= 'a' && c|0x20 <= 'z') && !(i > 0 && c >= '0' && c <= '9') {
+			return false
+		}
+	}
+	return true
+}
+
+// HasTemplate reports whether a value embeds expressions
+func HasTemplate(text string) bool {
+	return strings.Contains(text, "{{")
+}
+
+// Expand replaces each {{ expression }} in text with its value
+func (e *Evaluator) Expand(text string) (string, error) {
+	var b strings.Builder
+	for {
+		start := strings.Index(text, "{{")
+		if start < 0 {
+			b.WriteString(text)
+			break
+		}
+		end := strings.Index(text[start+2:], "}}")
+		if end < 0 {
+			return "", fmt.Errorf("unterminated {{ in %q", text)
+		}
+		b.WriteString(text[:start])
+
+		value, err := e.Eval(text[start+2 : start+2+end])
+		if err != nil {
+			return "", err
+		}
+		b.WriteString(String(value))
+		if b.Len() > MaxLength {
+			return "", fmt.Errorf("expanded value is longer than %d bytes", MaxLength)
+		}
+		text = text[start+2+end+2:]
+	}
+	return b.String(), nil
+}
+
+// Eval evaluates an expression
+func (e *Evaluator) Eval(src string) (Value, error) {
+	if len(src) > MaxLength {
+		return nil, fmt.Errorf("expression is longer than %d bytes", MaxLength)
+	}
+	node, err := parse(src)
+	if err != nil {
+		return nil, fmt.Errorf("%w in %q", err, strings.TrimSpace(src))
+	}
+	return e.eval(node)
+}
+
+// lookup evaluates a named expression, once
+func (e *Evaluator) lookup(name string) (Value, error) {
+	if value, ok := e.named[name]; ok {
+		return value, nil
+	}
+	for i, n := range e.stack {
+		if n == name {
+			return nil, fmt.Errorf("expression %s refers to itself: %s", name, strings.Join(append(e.stack[i:], name), " -> "))
+		}
+	}
+	src, ok := e.env.Named(name)
+	if !ok {
+		return nil, fmt.Errorf("unknown name %q", name)
+	}
+
+	e.stack = append(e.stack, name)
+	defer func() { e.stack = e.stack[:len(e.stack)-1] }()
+	value, err := e.Eval(src)
+	if err != nil {
+		return nil, fmt.Errorf("expression %s: %w", name, err)
+	}
+	e.named[name] = value
+	return value, nil
+}
+
+// String formats a value as config values hold it
+func String(v Value) string {
+	switch v := v.(type) {
+	case string:
+		return v
+	case int64:
+		return strconv.FormatInt(v, 10)
+	case bool:
+		if v {
+			return "1"
+		}
+		return "0"
+	}
+	return fmt.Sprint(v)
+}
+
+// typeName names a value's type for errors
+func typeName(v Value) string {
+	switch v.(type) {
+	case string:
+		return "string"
+	case int64:
+		return "number"
+	case bool:
+		return "bool"
+	}
+	return fmt.Sprintf("%T", v)
+}
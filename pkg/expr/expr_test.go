@@ -0,0 +1,119 @@
+package expr
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+// testEnv serves options and named expressions from maps
+type testEnv struct {
+	options map[string]string
+	named   map[string]string
+}
+
+func (e testEnv) Option(path string) (string, error) {
+	if value, ok := e.options[path]; ok {
+		return value, nil
+	}
+	return "", fmt.Errorf("option not found: %s", path)
+}
+
+func (e testEnv) Named(name string) (string, bool) {
+	src, ok := e.named[name]
+	return src, ok
+}
+
+func TestExpand(t *testing.T) {
+	env := testEnv{
+		options: map[string]string{
+			"network.lan.ipaddr":  "192.168.1.1",
+			"network.lan.netmask": "255.255.255.0",
+			"network.lan.dns":     "1.1.1.1 9.9.9.9",
+		},
+		named: map[string]string{
+			"lan":      `cidr(uci("network.lan.ipaddr"), uci("network.lan.netmask"))`,
+			"pool":     `size(lan) / 2`,
+			"loop":     `loop2 + 1`,
+			"loop2":    `loop`,
+			"unparsed": `1 +`,
+		},
+	}
+
+	for _, tt := range []struct {
+		text, want string
+	}{
+		{"plain", "plain"},
+		{"{{ lan }}", "192.168.1.0/24"},
+		{"6,{{ uci(\"network.lan.ipaddr\") }}", "6,192.168.1.1"},
+		{"{{ offset(lan, host(lan, 100)) }}-{{ pool }}", "100-128"},
+		{"{{ host(lan, -1) }} {{ broadcast(lan) }}", "192.168.1.254 192.168.1.255"},
+		{"{{ netmask(\"10.0.0.0/8\") }}/{{ prefix(lan) }}", "255.0.0.0/24"},
+		{"{{ word(uci(\"network.lan.dns\"), 1) }}", "9.9.9.9"},
+		{"{{ uci(\"network.wan.ipaddr\", \"none\") }}", "none"},
+		{"{{ contains(lan, \"192.168.2.1\") ? \"yes\" : \"no\" }}", "no"},
+		{"{{ 2 + 3 * 4 - -1 }} {{ (2 + 3) % 4 }} {{ 7 / 2 }}", "15 1 3"},
+		{"{{ \"a\" + 1 }} {{ \"10\" + 1 == 11 }} {{ \"b\" > \"a\" && !false }}", "a1 0 1"},
+		{"{{ upper(replace(\"br-lan\", \"-\", \"_\")) }} {{ min(3, 1, 2) }} {{ max(3, 1, 2) }}", "BR_LAN 1 3"},
+		{"{{ \"{{\" }}", "{{"},
+	} {
+		got, err := NewEvaluator(env).Expand(tt.text)
+		if err != nil {
+			t.Errorf("Expand(%q): %v", tt.text, err)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("Expand(%q) = %q, want %q", tt.text, got, tt.want)
+		}
+	}
+
+	for _, tt := range []struct {
+		text, want string
+	}{
+		{"{{ lan", "unterminated"},
+		{"{{ }}", "empty expression"},
+		{"{{ 1 + }}", "unexpected end"},
+		{"{{ (1 }}", "expected )"},
+		{"{{ missing }}", "unknown name"},
+		{"{{ exec(\"reboot\") }}", "unknown function exec"},
+		{"{{ uci(\"network.wan.ipaddr\") }}", "option not found"},
+		{"{{ loop }}", "loop -> loop2 -> loop"},
+		{"{{ unparsed }}", "expression unparsed"},
+		{"{{ 1 / 0 }}", "division by zero"},
+		{"{{ host(lan, 300) }}", "has no address 300"},
+		{"{{ cidr(\"fe80::1\", 64) }}", "not an IPv4 address"},
+		{"{{ \"a\" - 1 }}", "not a number"},
+		{"{{ " + strings.Repeat("-", 100) + "1 }}", "nested too deeply"},
+	} {
+		if _, err := NewEvaluator(env).Expand(tt.text); err == nil || !strings.Contains(err.Error(), tt.want) {
+			t.Errorf("Expand(%q) = %v, want an error with %q", tt.text, err, tt.want)
+		}
+	}
+}
+
+func TestEvalLimits(t *testing.T) {
+	// Each named expression doubles the string of the one before
+	named := map[string]string{"s0": `"x"`}
+	for i := 1; i <= 20; i++ {
+		named[fmt.Sprintf("s%d", i)] = fmt.Sprintf("s%d + s%d", i-1, i-1)
+	}
+	if _, err := NewEvaluator(testEnv{named: named}).Eval("s20"); err == nil || !strings.Contains(err.Error(), "longer than") {
+		t.Errorf("Eval of a long string = %v", err)
+	}
+
+	var src strings.Builder
+	src.WriteString("0")
+	for range 1000 {
+		src.WriteString("+1")
+	}
+	e := NewEvaluator(testEnv{})
+	for i := 0; i < MaxSteps/1000; i++ {
+		if _, err := e.Eval(src.String()); err != nil {
+			if !strings.Contains(err.Error(), "steps") {
+				t.Fatalf("Eval: %v", err)
+			}
+			return
+		}
+	}
+	t.Error("Evaluator ran past its step limit")
+}
@@ -0,0 +1,906 @@
+// Package graphql executes read-only GraphQL queries against a schema whose
+// resolvers are written in Go.
+//
+// It implements the part of the language clients use to fetch nested data
+// in one request: fields, aliases, arguments, variables, named and inline
+// fragments and the @include and @skip directives. Mutations, subscriptions
+// and introspection other than __typename are not supported; the schema is
+// published as SDL instead.
+package graphql
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"math"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Limits on the work a request can ask for
+const (
+	MaxQueryLength = 32 << 10 // Bytes of query text
+	MaxDepth       = 8        // Nesting of object selections
+	MaxFields      = 10000    // Fields resolved per request
+)
+
+// scalars are the built-in scalar types
+var scalars = map[string]bool{"String": true, "Int": true, "Float": true, "Boolean": true, "ID": true}
+
+// ResolveFunc returns the value of a field of source, the value its parent
+// field resolved to (nil for the fields of the query type). Objects resolve
+// to any value their own fields' resolvers accept, lists to slices (a nil
+// slice is an empty list) and scalars to Go strings, numbers, booleans or
+// times.
+type ResolveFunc func(ctx context.Context, source any, args map[string]any) (any, error)
+
+// Arg is an argument of a field
+type Arg struct {
+	Name        string
+	Type        string // A scalar type, e.g. "Int" or "String!"
+	Default     any    // Used when the argument isn't given, if not nil
+	Description string
+}
+
+// Field is a field of an object type
+type Field struct {
+	Name        string
+	Type        string // The SDL type, e.g. "[Transaction!]!"
+	Args        []Arg
+	Description string
+	Resolve     ResolveFunc
+
+	typ  *typeRef
+	args map[string]*argDef
+}
+
+// argDef is an argument with its parsed type
+type argDef struct {
+	*Arg
+	typ *typeRef
+}
+
+// Object is an object type of a schema
+type Object struct {
+	Name        string
+	Description string
+	Fields      []*Field
+
+	fields map[string]*Field
+}
+
+// Schema is a query type and the object types its fields lead to
+type Schema struct {
+	query *Object
+	types map[string]*Object
+	order []*Object
+}
+
+// NewSchema checks the types of a schema: field types must name scalars or
+// the given objects, and arguments must be scalars with matching defaults
+func NewSchema(query *Object, types ...*Object) (*Schema, error) {
+	s := &Schema{query: query, types: make(map[string]*Object)}
+	for _, obj := range append([]*Object{query}, types...) {
+		if !validName(obj.Name) || scalars[obj.Name] {
+			return nil, fmt.Errorf("invalid type name %q", obj.Name)
+		}
+		if _, ok := s.types[obj.Name]; ok {
+			return nil, fmt.Errorf("type %s is defined more than once", obj.Name)
+		}
+		s.types[obj.Name] = obj
+		s.order = append(s.order, obj)
+	}
+
+	for _, obj := range s.order {
+		obj.fields = make(map[string]*Field, len(obj.Fields))
+		for _, f := range obj.Fields {
+			if !validName(f.Name) || strings.HasPrefix(f.Name, "__") {
+				return nil, fmt.Errorf("%s: invalid field name %q", obj.Name, f.Name)
+			}
+			if _, ok := obj.fields[f.Name]; ok {
+				return nil, fmt.Errorf("%s.%s is defined more than once", obj.Name, f.Name)
+			}
+			if f.Resolve == nil {
+				return nil, fmt.Errorf("%s.%s has no resolver", obj.Name, f.Name)
+			}
+			typ, err := parseType(f.Type)
+			if err != nil {
+				return nil, fmt.Errorf("%s.%s: %w", obj.Name, f.Name, err)
+			}
+			if name := typ.named(); !scalars[name] && s.types[name] == nil {
+				return nil, fmt.Errorf("%s.%s: unknown type %s", obj.Name, f.Name, name)
+			}
+			f.typ = typ
+
+			f.args = make(map[string]*argDef, len(f.Args))
+			for i := range f.Args {
+				arg := &f.Args[i]
+				if _, ok := f.args[arg.Name]; ok || !validName(arg.Name) {
+					return nil, fmt.Errorf("%s.%s: invalid or repeated argument %q", obj.Name, f.Name, arg.Name)
+				}
+				typ, err := parseType(arg.Type)
+				if err != nil || typ.elem != nil || !scalars[typ.name] {
+					return nil, fmt.Errorf("%s.%s(%s): arguments must be scalars", obj.Name, f.Name, arg.Name)
+				}
+				if arg.Default != nil {
+					if _, err := coerceVariable(typ, arg.Default); err != nil {
+						return nil, fmt.Errorf("%s.%s(%s): default: %w", obj.Name, f.Name, arg.Name, err)
+					}
+				}
+				f.args[arg.Name] = &argDef{arg, typ}
+			}
+			obj.fields[f.Name] = f
+		}
+	}
+	return s, nil
+}
+
+// typeRef is a parsed type reference
+type typeRef struct {
+	name    string   // The named type, if not a list
+	elem    *typeRef // The element type of a list
+	nonNull bool
+}
+
+// parseType parses an SDL type reference
+func parseType(text string) (*typeRef, error) {
+	t := &typeRef{}
+	if rest, ok := strings.CutSuffix(text, "!"); ok {
+		t.nonNull, text = true, rest
+	}
+	if inner, ok := strings.CutPrefix(text, "["); ok {
+		inner, ok = strings.CutSuffix(inner, "]")
+		if !ok {
+			return nil, fmt.Errorf("invalid type %q", text)
+		}
+		elem, err := parseType(inner)
+		if err != nil {
+			return nil, err
+		}
+		t.elem = elem
+		return t, nil
+	}
+	if !validName(text) {
+		return nil, fmt.Errorf("invalid type %q", text)
+	}
+	t.name = text
+	return t, nil
+}
+
+// named returns the named type at the bottom of a type reference
+func (t *typeRef) named() string {
+	for t.elem != nil {
+		t = t.elem
+	}
+	return t.name
+}
+
+func (t *typeRef) String() string {
+	s := t.name
+	if t.elem != nil {
+		s = "[" + t.elem.String() + "]"
+	}
+	if t.nonNull {
+		s += "!"
+	}
+	return s
+}
+
+// validName reports whether a name is a GraphQL name
+func validName(name string) bool {
+	if name == "" || isDigit(name[0]) {
+		return false
+	}
+	for i := 0; i < len(name); i++ {
+		if c := name[i]; c != '_' && !isLetter(c) && !isDigit(c) {
+			return false
+		}
+	}
+	return true
+}
+
+// SDL describes the schema in the GraphQL schema definition language
+func (s *Schema) SDL() string {
+	var b strings.Builder
+	for i, obj := range s.order {
+		if i > 0 {
+			b.WriteString("\n")
+		}
+		if obj.Description != "" {
+			b.WriteString(quote(obj.Description) + "\n")
+		}
+		b.WriteString("type " + obj.Name + " {\n")
+		for _, f := range obj.Fields {
+			if f.Description != "" {
+				b.WriteString("  " + quote(f.Description) + "\n")
+			}
+			b.WriteString("  " + f.Name)
+			if len(f.Args) > 0 {
+				// Arguments go on their own lines when any is described
+				described := false
+				args := make([]string, len(f.Args))
+				for j, arg := range f.Args {
+					args[j] = arg.Name + ": " + arg.Type
+					if arg.Default != nil {
+						args[j] += " = " + literal(arg.Default)
+					}
+					if arg.Description != "" {
+						args[j] = quote(arg.Description) + "\n    " + args[j]
+						described = true
+					}
+				}
+				if described {
+					b.WriteString("(\n    " + strings.Join(args, "\n    ") + "\n  )")
+				} else {
+					b.WriteString("(" + strings.Join(args, ", ") + ")")
+				}
+			}
+			b.WriteString(": " + f.Type + "\n")
+		}
+		b.WriteString("}\n")
+	}
+	return b.String()
+}
+
+// quote quotes a description or string value
+func quote(s string) string {
+	data, _ := json.Marshal(s)
+	return string(data)
+}
+
+// literal writes a default value as a GraphQL literal
+func literal(v any) string {
+	if s, ok := v.(string); ok {
+		return quote(s)
+	}
+	return fmt.Sprint(v)
+}
+
+// Request is a GraphQL request as clients send it
+type Request struct {
+	Query         string         `json:"query" example:"{ transactions(limit: 5) { transaction_id status snapshot { id } audit { action status } } }"`
+	OperationName string         `json:"operationName,omitempty"`
+	Variables     map[string]any `json:"variables,omitempty"`
+}
+
+// Response is the result of a request. Data is left out when the request
+// couldn't be executed at all; fields that failed are null in it and
+// reported in Errors.
+type Response struct {
+	Data   any      `json:"data,omitempty" swaggertype:"object"`
+	Errors []*Error `json:"errors,omitempty"`
+}
+
+// Error is an error of a request, located in the query and, for a field
+// that failed, by its path in the data
+type Error struct {
+	Message   string     `json:"message"`
+	Locations []Location `json:"locations,omitempty"`
+	Path      []any      `json:"path,omitempty" swaggertype:"array,string"`
+}
+
+func (e *Error) Error() string {
+	return e.Message
+}
+
+// object is the data of an object, keeping the order of its fields
+type object struct {
+	keys   []string
+	values map[string]any
+}
+
+func (o *object) set(key string, v any) {
+	o.keys = append(o.keys, key)
+	o.values[key] = v
+}
+
+func (o *object) MarshalJSON() ([]byte, error) {
+	var b bytes.Buffer
+	b.WriteByte('{')
+	for i, key := range o.keys {
+		if i > 0 {
+			b.WriteByte(',')
+		}
+		b.WriteString(quote(key) + ":")
+		data, err := json.Marshal(o.values[key])
+		if err != nil {
+			return nil, err
+		}
+		b.Write(data)
+	}
+	b.WriteByte('}')
+	return b.Bytes(), nil
+}
+
+// executor runs an operation
+type executor struct {
+	ctx       context.Context
+	schema    *Schema
+	doc       *document
+	variables map[string]any
+	errors    []*Error
+	fields    int
+}
+
+// Execute runs the query of a request. Errors in the query itself fail the
+// whole request; errors resolving fields are reported with the data of the
+// fields that succeeded.
+func (s *Schema) Execute(ctx context.Context, req Request) *Response {
+	if len(req.Query) > MaxQueryLength {
+		return failed(&Error{Message: fmt.Sprintf("the query is longer than %d bytes", MaxQueryLength)})
+	}
+	doc, err := parse(req.Query)
+	if err != nil {
+		return failed(err)
+	}
+	op, err := doc.operation(req.OperationName)
+	if err != nil {
+		return failed(err)
+	}
+	if op.kind != "query" {
+		return failed(&Error{Message: "only queries are supported", Locations: []Location{op.loc}})
+	}
+
+	e := &executor{ctx: ctx, schema: s, doc: doc}
+	if e.variables, err = e.coerceVariables(op, req.Variables); err != nil {
+		return failed(err)
+	}
+	if err := e.validate(op); err != nil {
+		return failed(err)
+	}
+
+	data, _ := e.selectionSet(s.query, nil, op.selections, nil)
+	resp := &Response{Errors: e.errors}
+	if data != nil {
+		resp.Data = data
+	}
+	return resp
+}
+
+// failed is the response to a request that couldn't be executed
+func failed(err error) *Response {
+	gqlErr, ok := err.(*Error)
+	if !ok {
+		gqlErr = &Error{Message: err.Error()}
+	}
+	return &Response{Errors: []*Error{gqlErr}}
+}
+
+// operation picks the operation of a document to run
+func (d *document) operation(name string) (*operation, error) {
+	if name == "" {
+		if len(d.operations) > 1 {
+			return nil, &Error{Message: "operationName is required when the query has several operations"}
+		}
+		return d.operations[0], nil
+	}
+	for _, op := range d.operations {
+		if op.name == name {
+			return op, nil
+		}
+	}
+	return nil, &Error{Message: fmt.Sprintf("unknown operation %q", name)}
+}
+
+// coerceVariables checks the variables of a request against the definitions
+// of the operation, filling in defaults
+func (e *executor) coerceVariables(op *operation, given map[string]any) (map[string]any, error) {
+	values := make(map[string]any, len(op.variables))
+	for _, def := range op.variables {
+		if _, ok := values[def.name]; ok {
+			return nil, &Error{Message: fmt.Sprintf("variable $%s is defined more than once", def.name), Locations: []Location{def.loc}}
+		}
+		typ, err := parseType(def.typ)
+		if err != nil || typ.elem != nil || !scalars[typ.name] {
+			return nil, &Error{Message: fmt.Sprintf("variable $%s: only scalar variables are supported", def.name), Locations: []Location{def.loc}}
+		}
+
+		v, ok := given[def.name]
+		switch {
+		case ok:
+			if v, err = coerceVariable(typ, v); err != nil {
+				return nil, &Error{Message: fmt.Sprintf("variable $%s: %v", def.name, err), Locations: []Location{def.loc}}
+			}
+		case def.defValue != nil:
+			if v, err = coerceLiteral(typ, *def.defValue, nil); err != nil {
+				return nil, &Error{Message: fmt.Sprintf("variable $%s: default: %v", def.name, err), Locations: []Location{def.loc}}
+			}
+		case typ.nonNull:
+			return nil, &Error{Message: fmt.Sprintf("variable $%s of type %s was not given", def.name, def.typ), Locations: []Location{def.loc}}
+		}
+		values[def.name] = v
+	}
+	return values, nil
+}
+
+// coerceVariable converts a variable value decoded from JSON to a scalar
+// type
+func coerceVariable(typ *typeRef, v any) (any, error) {
+	if v == nil {
+		if typ.nonNull {
+			return nil, fmt.Errorf("expected %s, got null", typ)
+		}
+		return nil, nil
+	}
+	switch typ.name {
+	case "Int":
+		var n float64
+		switch v := v.(type) {
+		case int:
+			n = float64(v)
+		case float64:
+			n = v
+		default:
+			return nil, fmt.Errorf("expected Int, got %T", v)
+		}
+		if n != math.Trunc(n) || n < math.MinInt32 || n > math.MaxInt32 {
+			return nil, fmt.Errorf("expected Int, got %v", v)
+		}
+		return int(n), nil
+	case "Float":
+		switch v := v.(type) {
+		case int:
+			return float64(v), nil
+		case float64:
+			return v, nil
+		}
+	case "String":
+		if s, ok := v.(string); ok {
+			return s, nil
+		}
+	case "ID":
+		switch v := v.(type) {
+		case string:
+			return v, nil
+		case int:
+			return strconv.Itoa(v), nil
+		case float64:
+			if v == math.Trunc(v) {
+				return strconv.FormatFloat(v, 'f', 0, 64), nil
+			}
+		}
+	case "Boolean":
+		if b, ok := v.(bool); ok {
+			return b, nil
+		}
+	}
+	return nil, fmt.Errorf("expected %s, got %T", typ.name, v)
+}
+
+// coerceLiteral converts a value written in the query to a scalar type,
+// looking variables up in variables
+func coerceLiteral(typ *typeRef, v value, variables map[string]any) (any, error) {
+	switch v.kind {
+	case variableValue:
+		val := variables[v.text]
+		if val == nil && typ.nonNull {
+			return nil, fmt.Errorf("expected %s, got null", typ)
+		}
+		return val, nil
+	case nullValue:
+		if typ.nonNull {
+			return nil, fmt.Errorf("expected %s, got null", typ)
+		}
+		return nil, nil
+	}
+
+	switch {
+	case typ.name == "Int" && v.kind == intValue:
+		n, err := strconv.ParseInt(v.text, 10, 32)
+		if err != nil {
+			return nil, fmt.Errorf("expected Int, got %s", v.text)
+		}
+		return int(n), nil
+	case typ.name == "Float" && (v.kind == intValue || v.kind == floatValue):
+		return strconv.ParseFloat(v.text, 64)
+	case typ.name == "String" && v.kind == stringValue:
+		return v.text, nil
+	case typ.name == "ID" && (v.kind == stringValue || v.kind == intValue):
+		return v.text, nil
+	case typ.name == "Boolean" && v.kind == boolValue:
+		return v.text == "true", nil
+	}
+	got := v.text
+	if v.kind == stringValue {
+		got = quote(v.text)
+	}
+	return nil, fmt.Errorf("expected %s, got %s", typ.name, got)
+}
+
+// validate checks the selections of an operation against the schema before
+// anything is resolved
+func (e *executor) validate(op *operation) error {
+	if len(op.directives) > 0 {
+		return &Error{Message: fmt.Sprintf("directive @%s is not supported on operations", op.directives[0].name), Locations: []Location{op.directives[0].loc}}
+	}
+	used := make(map[string]bool)
+	if err := e.validateSelections(e.schema.query, op.selections, 1, nil, used); err != nil {
+		return err
+	}
+	for name, f := range e.doc.fragments {
+		if !used[name] {
+			return &Error{Message: fmt.Sprintf("fragment %s is never used", name), Locations: []Location{f.loc}}
+		}
+	}
+	return nil
+}
+
+// validateSelections checks selections of an object type at a nesting depth,
+// within the fragments being spread
+func (e *executor) validateSelections(obj *Object, selections []*selection, depth int, spreading []string, used map[string]bool) error {
+	for _, s := range selections {
+		if err := e.validateDirectives(s.directives); err != nil {
+			return err
+		}
+
+		switch s.kind {
+		case spreadSelection:
+			f, ok := e.doc.fragments[s.name]
+			if !ok {
+				return &Error{Message: fmt.Sprintf("unknown fragment %s", s.name), Locations: []Location{s.loc}}
+			}
+			for _, name := range spreading {
+				if name == s.name {
+					return &Error{Message: fmt.Sprintf("fragment %s spreads itself", s.name), Locations: []Location{s.loc}}
+				}
+			}
+			used[s.name] = true
+			if f.typeCond != obj.Name {
+				return &Error{Message: fmt.Sprintf("fragment %s on %s can't be spread in %s", s.name, f.typeCond, obj.Name), Locations: []Location{s.loc}}
+			}
+			if err := e.validateSelections(obj, f.selections, depth, append(spreading, s.name), used); err != nil {
+				return err
+			}
+			continue
+		case inlineSelection:
+			if s.typeCond != "" && s.typeCond != obj.Name {
+				return &Error{Message: fmt.Sprintf("fragment on %s can't be spread in %s", s.typeCond, obj.Name), Locations: []Location{s.loc}}
+			}
+			if err := e.validateSelections(obj, s.selections, depth, spreading, used); err != nil {
+				return err
+			}
+			continue
+		}
+
+		if s.name == "__typename" {
+			if len(s.args) > 0 || s.selections != nil {
+				return &Error{Message: "__typename takes no arguments or selections", Locations: []Location{s.loc}}
+			}
+			continue
+		}
+		f, ok := obj.fields[s.name]
+		if !ok {
+			return &Error{Message: fmt.Sprintf("cannot query field %q on type %s", s.name, obj.Name), Locations: []Location{s.loc}}
+		}
+		if err := e.validateArgs(f, s); err != nil {
+			return err
+		}
+
+		fieldObj := e.schema.types[f.typ.named()]
+		switch {
+		case fieldObj == nil && s.selections != nil:
+			return &Error{Message: fmt.Sprintf("field %s of type %s has no fields to select", s.name, f.Type), Locations: []Location{s.loc}}
+		case fieldObj != nil && s.selections == nil:
+			return &Error{Message: fmt.Sprintf("field %s of type %s needs a selection of fields", s.name, f.Type), Locations: []Location{s.loc}}
+		case fieldObj != nil && depth >= MaxDepth:
+			return &Error{Message: fmt.Sprintf("the query is nested deeper than %d levels", MaxDepth), Locations: []Location{s.loc}}
+		case fieldObj != nil:
+			if err := e.validateSelections(fieldObj, s.selections, depth+1, spreading, used); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// validateArgs checks the arguments given to a field
+func (e *executor) validateArgs(f *Field, s *selection) error {
+	given := make(map[string]bool, len(s.args))
+	for _, arg := range s.args {
+		def, ok := f.args[arg.name]
+		if !ok {
+			return &Error{Message: fmt.Sprintf("unknown argument %s of field %s", arg.name, f.Name), Locations: []Location{arg.value.loc}}
+		}
+		if err := e.validateValue(def.typ, arg.value); err != nil {
+			return &Error{Message: fmt.Sprintf("argument %s of field %s: %v", arg.name, f.Name, err), Locations: []Location{arg.value.loc}}
+		}
+		given[arg.name] = true
+	}
+	for _, arg := range f.Args {
+		if !given[arg.Name] && arg.Default == nil && f.args[arg.Name].typ.nonNull {
+			return &Error{Message: fmt.Sprintf("field %s needs argument %s", f.Name, arg.Name), Locations: []Location{s.loc}}
+		}
+	}
+	return nil
+}
+
+// validateDirectives checks that only @include and @skip are used, with a
+// Boolean condition
+func (e *executor) validateDirectives(directives []directive) error {
+	for _, d := range directives {
+		if d.name != "include" && d.name != "skip" {
+			return &Error{Message: fmt.Sprintf("unknown directive @%s", d.name), Locations: []Location{d.loc}}
+		}
+		if len(d.args) != 1 || d.args[0].name != "if" {
+			return &Error{Message: fmt.Sprintf("directive @%s takes one argument, if", d.name), Locations: []Location{d.loc}}
+		}
+		if err := e.validateValue(&typeRef{name: "Boolean", nonNull: true}, d.args[0].value); err != nil {
+			return &Error{Message: fmt.Sprintf("directive @%s: %v", d.name, err), Locations: []Location{d.loc}}
+		}
+	}
+	return nil
+}
+
+// validateValue checks a literal against an argument type, or that the
+// variable it refers to is defined
+func (e *executor) validateValue(typ *typeRef, v value) error {
+	if v.kind == variableValue {
+		if _, ok := e.variables[v.text]; !ok {
+			return fmt.Errorf("variable $%s is not defined", v.text)
+		}
+		return nil
+	}
+	_, err := coerceLiteral(typ, v, nil)
+	return err
+}
+
+// included reports whether the @include and @skip directives of a selection
+// keep it
+func (e *executor) included(directives []directive) bool {
+	for _, d := range directives {
+		cond, _ := coerceLiteral(&typeRef{name: "Boolean"}, d.args[0].value, e.variables)
+		if b, _ := cond.(bool); b == (d.name == "skip") {
+			return false
+		}
+	}
+	return true
+}
+
+// collected is a field of the response with the selections that ask for it
+type collected struct {
+	key        string
+	selections []*selection
+}
+
+// collect gathers the fields selected on an object, expanding fragments and
+// merging fields selected more than once under the same key
+func (e *executor) collect(selections []*selection, fields []*collected, index map[string]*collected) ([]*collected, error) {
+	for _, s := range selections {
+		if !e.included(s.directives) {
+			continue
+		}
+		switch s.kind {
+		case spreadSelection:
+			var err error
+			if fields, err = e.collect(e.doc.fragments[s.name].selections, fields, index); err != nil {
+				return nil, err
+			}
+		case inlineSelection:
+			var err error
+			if fields, err = e.collect(s.selections, fields, index); err != nil {
+				return nil, err
+			}
+		default:
+			if c, ok := index[s.alias]; ok {
+				if first := c.selections[0]; first.name != s.name || !sameArgs(first.args, s.args) {
+					return nil, &Error{Message: fmt.Sprintf("%s selects different fields or arguments; use aliases", s.alias), Locations: []Location{first.loc, s.loc}}
+				}
+				c.selections = append(c.selections, s)
+				continue
+			}
+			c := &collected{key: s.alias, selections: []*selection{s}}
+			index[s.alias] = c
+			fields = append(fields, c)
+		}
+	}
+	return fields, nil
+}
+
+// sameArgs reports whether two fields are given the same arguments
+func sameArgs(a, b []argument) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for _, x := range a {
+		found := false
+		for _, y := range b {
+			if x.name == y.name && x.value.kind == y.value.kind && x.value.text == y.value.text {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	return true
+}
+
+// selectionSet resolves the selected fields of an object. It returns nil if
+// a non-null field couldn't be resolved, nulling the object.
+func (e *executor) selectionSet(obj *Object, source any, selections []*selection, path []any) (*object, bool) {
+	fields, err := e.collect(selections, nil, make(map[string]*collected))
+	if err != nil {
+		e.fail(err, path)
+		return nil, false
+	}
+
+	data := &object{values: make(map[string]any, len(fields))}
+	for _, c := range fields {
+		v, ok := e.field(obj, source, c, append(path[:len(path):len(path)], c.key))
+		if !ok {
+			return nil, false
+		}
+		data.set(c.key, v)
+	}
+	return data, true
+}
+
+// field resolves a field of an object. ok is false if the field is non-null
+// and couldn't be resolved.
+func (e *executor) field(obj *Object, source any, c *collected, path []any) (v any, ok bool) {
+	s := c.selections[0]
+	if s.name == "__typename" {
+		return obj.Name, true
+	}
+	f := obj.fields[s.name]
+
+	e.fields++
+	if e.fields > MaxFields {
+		if e.fields == MaxFields+1 {
+			e.fail(&Error{Message: fmt.Sprintf("the query resolves more than %d fields", MaxFields), Locations: []Location{s.loc}}, path)
+		}
+		return nil, !f.typ.nonNull
+	}
+	if err := e.ctx.Err(); err != nil {
+		e.fail(&Error{Message: err.Error(), Locations: []Location{s.loc}}, path)
+		return nil, !f.typ.nonNull
+	}
+
+	args := make(map[string]any, len(f.Args))
+	for _, arg := range f.Args {
+		if arg.Default != nil {
+			args[arg.Name], _ = coerceVariable(f.args[arg.Name].typ, arg.Default)
+		}
+	}
+	for _, arg := range s.args {
+		def := f.args[arg.name]
+		v, err := coerceLiteral(def.typ, arg.value, e.variables)
+		if err != nil {
+			e.fail(&Error{Message: fmt.Sprintf("argument %s: %v", arg.name, err), Locations: []Location{arg.value.loc}}, path)
+			return nil, !f.typ.nonNull
+		}
+		if v != nil || arg.value.kind == nullValue {
+			args[arg.name] = v
+		}
+	}
+
+	v, err := f.Resolve(e.ctx, source, args)
+	if err != nil {
+		e.fail(&Error{Message: err.Error(), Locations: []Location{s.loc}}, path)
+		return nil, !f.typ.nonNull
+	}
+
+	var selections []*selection
+	for _, s := range c.selections {
+		selections = append(selections, s.selections...)
+	}
+	return e.complete(f.typ, selections, v, path, s.loc)
+}
+
+// complete converts a resolved value to its type, resolving the selections
+// of objects
+func (e *executor) complete(typ *typeRef, selections []*selection, v any, path []any, loc Location) (any, bool) {
+	// A nil slice is an empty list, not a null one
+	slice := v != nil && reflect.ValueOf(v).Kind() == reflect.Slice
+	if !(typ.elem != nil && slice) && isNil(v) {
+		if typ.nonNull {
+			e.fail(&Error{Message: fmt.Sprintf("%s can't be null", typ), Locations: []Location{loc}}, path)
+			return nil, false
+		}
+		return nil, true
+	}
+
+	if typ.elem != nil {
+		rv := reflect.ValueOf(v)
+		if rv.Kind() != reflect.Slice && rv.Kind() != reflect.Array {
+			e.fail(&Error{Message: fmt.Sprintf("%s resolved to %T", typ, v), Locations: []Location{loc}}, path)
+			return nil, !typ.nonNull
+		}
+		items := make([]any, rv.Len())
+		for i := range items {
+			item, ok := e.complete(typ.elem, selections, rv.Index(i).Interface(), append(path[:len(path):len(path)], i), loc)
+			if !ok {
+				return nil, !typ.nonNull
+			}
+			items[i] = item
+		}
+		return items, true
+	}
+
+	if obj, ok := e.schema.types[typ.name]; ok {
+		data, ok := e.selectionSet(obj, v, selections, path)
+		if !ok {
+			return nil, !typ.nonNull
+		}
+		return data, true
+	}
+	scalar, err := serialize(typ.name, v)
+	if err != nil {
+		e.fail(&Error{Message: err.Error(), Locations: []Location{loc}}, path)
+		return nil, !typ.nonNull
+	}
+	return scalar, true
+}
+
+// fail records an error at a path of the response
+func (e *executor) fail(err error, path []any) {
+	gqlErr, ok := err.(*Error)
+	if !ok {
+		gqlErr = &Error{Message: err.Error()}
+	}
+	gqlErr.Path = path
+	e.errors = append(e.errors, gqlErr)
+}
+
+// isNil reports whether a resolved value is nil, including nil pointers,
+// slices and maps
+func isNil(v any) bool {
+	if v == nil {
+		return true
+	}
+	switch rv := reflect.ValueOf(v); rv.Kind() {
+	case reflect.Pointer, reflect.Slice, reflect.Map, reflect.Interface:
+		return rv.IsNil()
+	}
+	return false
+}
+
+// serialize converts a resolved value to a scalar type
+func serialize(name string, v any) (any, error) {
+	rv := reflect.ValueOf(v)
+	for rv.Kind() == reflect.Pointer {
+		rv = rv.Elem()
+	}
+	if t, ok := rv.Interface().(time.Time); ok && (name == "String" || name == "ID") {
+		return t.Format(time.RFC3339Nano), nil
+	}
+
+	switch name {
+	case "String", "ID":
+		switch rv.Kind() {
+		case reflect.String:
+			return rv.String(), nil
+		case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+			if name == "ID" {
+				return strconv.FormatInt(rv.Int(), 10), nil
+			}
+		case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+			if name == "ID" {
+				return strconv.FormatUint(rv.Uint(), 10), nil
+			}
+		}
+	case "Int":
+		switch rv.Kind() {
+		case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+			return rv.Int(), nil
+		case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+			return rv.Uint(), nil
+		}
+	case "Float":
+		switch rv.Kind() {
+		case reflect.Float32, reflect.Float64:
+			return rv.Float(), nil
+		case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+			return float64(rv.Int()), nil
+		}
+	case "Boolean":
+		if rv.Kind() == reflect.Bool {
+			return rv.Bool(), nil
+		}
+	}
+	return nil, fmt.Errorf("can't serialize %T as %s", v, name)
+}
@@ -0,0 +1,213 @@
+package graphql
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+type testAuthor struct {
+	Name  string
+	Books []*testBook
+}
+
+type testBook struct {
+	ID        uint
+	Title     string
+	Published *time.Time
+	Author    *testAuthor
+}
+
+func testSchema(t *testing.T) *Schema {
+	t.Helper()
+	published := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	ada := &testAuthor{Name: "Ada"}
+	ada.Books = []*testBook{
+		{ID: 1, Title: "Notes", Published: &published, Author: ada},
+		{ID: 2, Title: "Drafts", Author: ada},
+	}
+
+	book := &Object{Name: "Book", Fields: []*Field{
+		{Name: "id", Type: "ID!", Resolve: func(_ context.Context, src any, _ map[string]any) (any, error) {
+			return src.(*testBook).ID, nil
+		}},
+		{Name: "title", Type: "String!", Resolve: func(_ context.Context, src any, _ map[string]any) (any, error) {
+			return src.(*testBook).Title, nil
+		}},
+		{Name: "published", Type: "String", Resolve: func(_ context.Context, src any, _ map[string]any) (any, error) {
+			return src.(*testBook).Published, nil
+		}},
+		{Name: "author", Type: "Author!", Resolve: func(_ context.Context, src any, _ map[string]any) (any, error) {
+			return src.(*testBook).Author, nil
+		}},
+		{Name: "isbn", Type: "String!", Resolve: func(_ context.Context, src any, _ map[string]any) (any, error) {
+			return nil, errors.New("no isbn")
+		}},
+	}}
+	author := &Object{Name: "Author", Fields: []*Field{
+		{Name: "name", Type: "String!", Resolve: func(_ context.Context, src any, _ map[string]any) (any, error) {
+			return src.(*testAuthor).Name, nil
+		}},
+		{Name: "books", Type: "[Book!]!", Args: []Arg{{Name: "limit", Type: "Int", Default: 10, Description: "Books to return"}}, Resolve: func(_ context.Context, src any, args map[string]any) (any, error) {
+			books := src.(*testAuthor).Books
+			return books[:min(args["limit"].(int), len(books))], nil
+		}},
+	}}
+	query := &Object{Name: "Query", Description: "Reads of the library", Fields: []*Field{
+		{Name: "book", Type: "Book", Description: "A book by id", Args: []Arg{{Name: "id", Type: "ID!"}}, Resolve: func(_ context.Context, _ any, args map[string]any) (any, error) {
+			for _, b := range ada.Books {
+				if args["id"] == strconv.FormatUint(uint64(b.ID), 10) {
+					return b, nil
+				}
+			}
+			return nil, nil
+		}},
+		{Name: "authors", Type: "[Author!]!", Resolve: func(context.Context, any, map[string]any) (any, error) {
+			return []*testAuthor{ada}, nil
+		}},
+	}}
+
+	s, err := NewSchema(query, book, author)
+	if err != nil {
+		t.Fatalf("NewSchema: %v", err)
+	}
+	return s
+}
+
+func TestExecute(t *testing.T) {
+	s := testSchema(t)
+	for _, tt := range []struct {
+		name      string
+		query     string
+		variables map[string]any
+		want      string
+	}{
+		{
+			name:  "nested with aliases",
+			query: `{ authors { name first: books(limit: 1) { id title published } } }`,
+			want:  `{"data":{"authors":[{"name":"Ada","first":[{"id":"1","title":"Notes","published":"2024-05-01T12:00:00Z"}]}]}}`,
+		},
+		{
+			name: "variables and fragments",
+			query: `query Book($id: ID!, $withAuthor: Boolean = false) {
+				book(id: $id) { ...fields author @include(if: $withAuthor) { name } }
+			}
+			fragment fields on Book { __typename title ... on Book { published } }`,
+			variables: map[string]any{"id": "2"},
+			want:      `{"data":{"book":{"__typename":"Book","title":"Drafts","published":null}}}`,
+		},
+		{
+			name:      "skip",
+			query:     `query($skip: Boolean!) { book(id: 1) { id title @skip(if: $skip) } }`,
+			variables: map[string]any{"skip": true},
+			want:      `{"data":{"book":{"id":"1"}}}`,
+		},
+		{
+			name:  "missing object",
+			query: `{ book(id: "9") { title } }`,
+			want:  `{"data":{"book":null}}`,
+		},
+		{
+			name:  "failed non-null field nulls its parent",
+			query: `{ book(id: 1) { title isbn } authors { name } }`,
+			want:  `{"data":{"book":null,"authors":[{"name":"Ada"}]},"errors":[{"message":"no isbn","locations":[{"line":1,"column":23}],"path":["book","isbn"]}]}`,
+		},
+		{
+			name:  "unknown field",
+			query: `{ authors { name age } }`,
+			want:  `{"errors":[{"message":"cannot query field \"age\" on type Author","locations":[{"line":1,"column":18}]}]}`,
+		},
+		{
+			name:  "missing argument",
+			query: `{ book { title } }`,
+			want:  `{"errors":[{"message":"field book needs argument id","locations":[{"line":1,"column":3}]}]}`,
+		},
+		{
+			name:  "wrong argument type",
+			query: `{ authors { books(limit: "2") { id } } }`,
+			want:  `{"errors":[{"message":"argument limit of field books: expected Int, got \"2\"","locations":[{"line":1,"column":26}]}]}`,
+		},
+		{
+			name:  "leaf with selections",
+			query: `{ authors { name { first } } }`,
+			want:  `{"errors":[{"message":"field name of type String! has no fields to select","locations":[{"line":1,"column":13}]}]}`,
+		},
+		{
+			name:  "fragment cycle",
+			query: `{ authors { ...a } } fragment a on Author { books { author { ...a } } }`,
+			want:  `{"errors":[{"message":"fragment a spreads itself","locations":[{"line":1,"column":62}]}]}`,
+		},
+		{
+			name:  "mutation",
+			query: `mutation { authors { name } }`,
+			want:  `{"errors":[{"message":"only queries are supported","locations":[{"line":1,"column":1}]}]}`,
+		},
+		{
+			name:  "syntax error",
+			query: `{ authors { name }`,
+			want:  `{"errors":[{"message":"syntax error: unexpected end of query","locations":[{"line":1,"column":19}]}]}`,
+		},
+		{
+			name:  "missing variable",
+			query: `query($id: ID!) { book(id: $id) { title } }`,
+			want:  `{"errors":[{"message":"variable $id of type ID! was not given","locations":[{"line":1,"column":7}]}]}`,
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			resp := s.Execute(context.Background(), Request{Query: tt.query, Variables: tt.variables})
+			data, err := json.Marshal(resp)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(data) != tt.want {
+				t.Errorf("got  %s\nwant %s", data, tt.want)
+			}
+		})
+	}
+}
+
+func TestExecuteDepth(t *testing.T) {
+	s := testSchema(t)
+	query := "{ authors { name } }"
+	for range MaxDepth {
+		query = strings.Replace(query, "{ name }", "{ books { author { name } } }", 1)
+	}
+	resp := s.Execute(context.Background(), Request{Query: query})
+	if len(resp.Errors) != 1 || !strings.Contains(resp.Errors[0].Message, "nested deeper") {
+		t.Errorf("errors = %v", resp.Errors)
+	}
+}
+
+func TestSDL(t *testing.T) {
+	sdl := testSchema(t).SDL()
+	for _, want := range []string{
+		"\"Reads of the library\"\ntype Query {\n  \"A book by id\"\n  book(id: ID!): Book\n",
+		"  books(\n    \"Books to return\"\n    limit: Int = 10\n  ): [Book!]!\n",
+	} {
+		if !strings.Contains(sdl, want) {
+			t.Errorf("SDL lacks %q:\n%s", want, sdl)
+		}
+	}
+}
+
+func TestNewSchema(t *testing.T) {
+	resolve := func(context.Context, any, map[string]any) (any, error) { return nil, nil }
+	for _, tt := range []struct {
+		field *Field
+		want  string
+	}{
+		{&Field{Name: "x", Type: "Missing", Resolve: resolve}, "unknown type Missing"},
+		{&Field{Name: "x", Type: "[String", Resolve: resolve}, "invalid type"},
+		{&Field{Name: "x", Type: "String"}, "has no resolver"},
+		{&Field{Name: "x", Type: "String", Args: []Arg{{Name: "n", Type: "Int", Default: "ten"}}, Resolve: resolve}, "default: expected Int"},
+	} {
+		_, err := NewSchema(&Object{Name: "Query", Fields: []*Field{tt.field}})
+		if err == nil || !strings.Contains(err.Error(), tt.want) {
+			t.Errorf("NewSchema(%s: %s) = %v, want %q", tt.field.Name, tt.field.Type, err, tt.want)
+		}
+	}
+}
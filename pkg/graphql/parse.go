@@ -0,0 +1,644 @@
+package graphql
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// Location is a position in a query, 1-based
+type Location struct {
+	Line   int `json:"line"`
+	Column int `json:"column"`
+}
+
+// tokenKind is the kind of a lexical token
+type tokenKind int
+
+const (
+	tokenEOF tokenKind = iota
+	tokenPunct
+	tokenName
+	tokenInt
+	tokenFloat
+	tokenString
+)
+
+// token is a lexical token of a query
+type token struct {
+	kind tokenKind
+	text string // The punctuator, name, number or unquoted string
+	loc  Location
+}
+
+// lexer splits a query into tokens
+type lexer struct {
+	src  string
+	pos  int
+	line int
+	col  int
+}
+
+// next returns the next token, skipping whitespace, commas and comments
+func (l *lexer) next() (token, error) {
+	for l.pos < len(l.src) {
+		c := l.src[l.pos]
+		switch {
+		case c == '\n':
+			l.pos++
+			l.line, l.col = l.line+1, 1
+			continue
+		case c == ' ' || c == '\t' || c == '\r' || c == ',':
+			l.advance(1)
+			continue
+		case c == '#':
+			for l.pos < len(l.src) && l.src[l.pos] != '\n' {
+				l.advance(1)
+			}
+			continue
+		}
+		break
+	}
+
+	loc := Location{Line: l.line, Column: l.col}
+	if l.pos >= len(l.src) {
+		return token{kind: tokenEOF, loc: loc}, nil
+	}
+
+	c := l.src[l.pos]
+	switch {
+	case strings.HasPrefix(l.src[l.pos:], "..."):
+		l.advance(3)
+		return token{kind: tokenPunct, text: "...", loc: loc}, nil
+	case strings.IndexByte("!$()::=@[]{}|&", c) >= 0:
+		l.advance(1)
+		return token{kind: tokenPunct, text: string(c), loc: loc}, nil
+	case c == '_' || isLetter(c):
+		start := l.pos
+		for l.pos < len(l.src) && (l.src[l.pos] == '_' || isLetter(l.src[l.pos]) || isDigit(l.src[l.pos])) {
+			l.advance(1)
+		}
+		return token{kind: tokenName, text: l.src[start:l.pos], loc: loc}, nil
+	case c == '-' || isDigit(c):
+		return l.number(loc)
+	case c == '"':
+		return l.string(loc)
+	}
+	r, _ := utf8.DecodeRuneInString(l.src[l.pos:])
+	return token{}, &Error{Message: fmt.Sprintf("syntax error: unexpected character %q", r), Locations: []Location{loc}}
+}
+
+// advance moves n bytes forward on the current line
+func (l *lexer) advance(n int) {
+	l.pos += n
+	l.col += n
+}
+
+// number lexes an Int or Float value
+func (l *lexer) number(loc Location) (token, error) {
+	start := l.pos
+	if l.src[l.pos] == '-' {
+		l.advance(1)
+	}
+	digits := func() int {
+		n := 0
+		for l.pos < len(l.src) && isDigit(l.src[l.pos]) {
+			l.advance(1)
+			n++
+		}
+		return n
+	}
+	if digits() == 0 {
+		return token{}, &Error{Message: "syntax error: invalid number", Locations: []Location{loc}}
+	}
+	kind := tokenInt
+	if l.pos < len(l.src) && l.src[l.pos] == '.' {
+		l.advance(1)
+		kind = tokenFloat
+		if digits() == 0 {
+			return token{}, &Error{Message: "syntax error: invalid number", Locations: []Location{loc}}
+		}
+	}
+	if l.pos < len(l.src) && (l.src[l.pos] == 'e' || l.src[l.pos] == 'E') {
+		l.advance(1)
+		kind = tokenFloat
+		if l.pos < len(l.src) && (l.src[l.pos] == '+' || l.src[l.pos] == '-') {
+			l.advance(1)
+		}
+		if digits() == 0 {
+			return token{}, &Error{Message: "syntax error: invalid number", Locations: []Location{loc}}
+		}
+	}
+	return token{kind: kind, text: l.src[start:l.pos], loc: loc}, nil
+}
+
+// string lexes a double-quoted String value; block strings aren't supported
+func (l *lexer) string(loc Location) (token, error) {
+	if strings.HasPrefix(l.src[l.pos:], `"""`) {
+		return token{}, &Error{Message: "syntax error: block strings are not supported", Locations: []Location{loc}}
+	}
+	l.advance(1)
+	var b strings.Builder
+	for {
+		if l.pos >= len(l.src) || l.src[l.pos] == '\n' {
+			return token{}, &Error{Message: "syntax error: unterminated string", Locations: []Location{loc}}
+		}
+		c := l.src[l.pos]
+		switch c {
+		case '"':
+			l.advance(1)
+			return token{kind: tokenString, text: b.String(), loc: loc}, nil
+		case '\\':
+			if l.pos+1 >= len(l.src) {
+				return token{}, &Error{Message: "syntax error: unterminated string", Locations: []Location{loc}}
+			}
+			escape := l.src[l.pos+1]
+			if escape == 'u' {
+				if l.pos+6 > len(l.src) {
+					return token{}, &Error{Message: "syntax error: invalid unicode escape", Locations: []Location{loc}}
+				}
+				n, err := strconv.ParseUint(l.src[l.pos+2:l.pos+6], 16, 16)
+				if err != nil {
+					return token{}, &Error{Message: "syntax error: invalid unicode escape", Locations: []Location{loc}}
+				}
+				b.WriteRune(rune(n))
+				l.advance(6)
+				continue
+			}
+			unescaped, ok := map[byte]byte{'"': '"', '\\': '\\', '/': '/', 'b': '\b', 'f': '\f', 'n': '\n', 'r': '\r', 't': '\t'}[escape]
+			if !ok {
+				return token{}, &Error{Message: fmt.Sprintf("syntax error: invalid escape \\%c", escape), Locations: []Location{loc}}
+			}
+			b.WriteByte(unescaped)
+			l.advance(2)
+		default:
+			_, size := utf8.DecodeRuneInString(l.src[l.pos:])
+			b.WriteString(l.src[l.pos : l.pos+size])
+			l.advance(size)
+		}
+	}
+}
+
+func isLetter(c byte) bool {
+	return c|0x20 >= 'a' && c|0x20 <= 'z'
+}
+
+func isDigit(c byte) bool {
+	return c >= '0' && c <= '9'
+}
+
+// valueKind is the kind of an argument value
+type valueKind int
+
+const (
+	nullValue valueKind = iota
+	intValue
+	floatValue
+	stringValue
+	boolValue
+	enumValue
+	variableValue
+)
+
+// value is an argument value as written in a query
+type value struct {
+	kind valueKind
+	text string // The literal, or the variable's name
+	loc  Location
+}
+
+// argument is an argument of a field or directive
+type argument struct {
+	name  string
+	value value
+}
+
+// directive is a directive on a selection
+type directive struct {
+	name string
+	args []argument
+	loc  Location
+}
+
+// selectionKind is the kind of a selection
+type selectionKind int
+
+const (
+	fieldSelection selectionKind = iota
+	spreadSelection
+	inlineSelection
+)
+
+// selection is a field, a fragment spread or an inline fragment
+type selection struct {
+	kind       selectionKind
+	alias      string // Field alias, or the name
+	name       string // Field name or spread fragment name
+	typeCond   string // Inline fragment type condition, if any
+	args       []argument
+	directives []directive
+	selections []*selection // Sub-selections; nil for a leaf field or a spread
+	loc        Location
+}
+
+// variableDef is a variable an operation declares
+type variableDef struct {
+	name     string
+	typ      string
+	defValue *value
+	loc      Location
+}
+
+// operation is a query, mutation or subscription of a document
+type operation struct {
+	kind       string
+	name       string
+	variables  []variableDef
+	directives []directive
+	selections []*selection
+	loc        Location
+}
+
+// fragment is a named fragment of a document
+type fragment struct {
+	name       string
+	typeCond   string
+	selections []*selection
+	loc        Location
+}
+
+// document is a parsed query
+type document struct {
+	operations []*operation
+	fragments  map[string]*fragment
+}
+
+// parser builds a document from the tokens of a query
+type parser struct {
+	lexer *lexer
+	tok   token
+}
+
+// parse parses a query document
+func parse(src string) (*document, error) {
+	p := &parser{lexer: &lexer{src: strings.TrimPrefix(src, "\ufeff"), line: 1, col: 1}}
+	if err := p.advance(); err != nil {
+		return nil, err
+	}
+
+	doc := &document{fragments: make(map[string]*fragment)}
+	for p.tok.kind != tokenEOF {
+		switch {
+		case p.peek("{"):
+			selections, err := p.selectionSet()
+			if err != nil {
+				return nil, err
+			}
+			doc.operations = append(doc.operations, &operation{kind: "query", selections: selections, loc: selections[0].loc})
+		case p.tok.kind == tokenName && p.tok.text == "fragment":
+			f, err := p.fragment()
+			if err != nil {
+				return nil, err
+			}
+			if _, ok := doc.fragments[f.name]; ok {
+				return nil, &Error{Message: fmt.Sprintf("fragment %s is defined more than once", f.name), Locations: []Location{f.loc}}
+			}
+			doc.fragments[f.name] = f
+		case p.tok.kind == tokenName && (p.tok.text == "query" || p.tok.text == "mutation" || p.tok.text == "subscription"):
+			op, err := p.operation()
+			if err != nil {
+				return nil, err
+			}
+			doc.operations = append(doc.operations, op)
+		default:
+			return nil, p.unexpected()
+		}
+	}
+	if len(doc.operations) == 0 {
+		return nil, &Error{Message: "the query has no operation"}
+	}
+	return doc, nil
+}
+
+// advance reads the next token
+func (p *parser) advance() error {
+	tok, err := p.lexer.next()
+	if err != nil {
+		return err
+	}
+	p.tok = tok
+	return nil
+}
+
+// peek reports whether the current token is the punctuator punct
+func (p *parser) peek(punct string) bool {
+	return p.tok.kind == tokenPunct && p.tok.text == punct
+}
+
+// expect consumes the punctuator punct
+func (p *parser) expect(punct string) error {
+	if !p.peek(punct) {
+		return p.unexpected()
+	}
+	return p.advance()
+}
+
+// skip consumes the punctuator punct if it is next
+func (p *parser) skip(punct string) (bool, error) {
+	if !p.peek(punct) {
+		return false, nil
+	}
+	return true, p.advance()
+}
+
+// name consumes a name
+func (p *parser) name() (string, error) {
+	if p.tok.kind != tokenName {
+		return "", p.unexpected()
+	}
+	name := p.tok.text
+	return name, p.advance()
+}
+
+// unexpected reports the current token as a syntax error
+func (p *parser) unexpected() error {
+	what := fmt.Sprintf("%q", p.tok.text)
+	if p.tok.kind == tokenEOF {
+		what = "end of query"
+	}
+	return &Error{Message: "syntax error: unexpected " + what, Locations: []Location{p.tok.loc}}
+}
+
+// operation parses an operation with its keyword
+func (p *parser) operation() (*operation, error) {
+	op := &operation{kind: p.tok.text, loc: p.tok.loc}
+	if err := p.advance(); err != nil {
+		return nil, err
+	}
+	if p.tok.kind == tokenName {
+		op.name = p.tok.text
+		if err := p.advance(); err != nil {
+			return nil, err
+		}
+	}
+
+	if ok, err := p.skip("("); err != nil {
+		return nil, err
+	} else if ok {
+		for !p.peek(")") {
+			def := variableDef{loc: p.tok.loc}
+			if err := p.expect("$"); err != nil {
+				return nil, err
+			}
+			var err error
+			if def.name, err = p.name(); err != nil {
+				return nil, err
+			}
+			if err := p.expect(":"); err != nil {
+				return nil, err
+			}
+			if def.typ, err = p.typeRef(); err != nil {
+				return nil, err
+			}
+			if ok, err := p.skip("="); err != nil {
+				return nil, err
+			} else if ok {
+				v, err := p.value(true)
+				if err != nil {
+					return nil, err
+				}
+				def.defValue = &v
+			}
+			op.variables = append(op.variables, def)
+		}
+		if err := p.advance(); err != nil {
+			return nil, err
+		}
+	}
+
+	var err error
+	if op.directives, err = p.directives(); err != nil {
+		return nil, err
+	}
+	if op.selections, err = p.selectionSet(); err != nil {
+		return nil, err
+	}
+	return op, nil
+}
+
+// fragment parses a fragment definition
+func (p *parser) fragment() (*fragment, error) {
+	f := &fragment{loc: p.tok.loc}
+	if err := p.advance(); err != nil {
+		return nil, err
+	}
+	var err error
+	if f.name, err = p.name(); err != nil {
+		return nil, err
+	}
+	if f.name == "on" {
+		return nil, &Error{Message: "syntax error: a fragment can't be named on", Locations: []Location{f.loc}}
+	}
+	if on, err := p.name(); err != nil {
+		return nil, err
+	} else if on != "on" {
+		return nil, &Error{Message: `syntax error: expected "on"`, Locations: []Location{f.loc}}
+	}
+	if f.typeCond, err = p.name(); err != nil {
+		return nil, err
+	}
+	if f.selections, err = p.selectionSet(); err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+// typeRef parses a type reference, e.g. [String!]!
+func (p *parser) typeRef() (string, error) {
+	var typ string
+	if ok, err := p.skip("["); err != nil {
+		return "", err
+	} else if ok {
+		inner, err := p.typeRef()
+		if err != nil {
+			return "", err
+		}
+		if err := p.expect("]"); err != nil {
+			return "", err
+		}
+		typ = "[" + inner + "]"
+	} else {
+		name, err := p.name()
+		if err != nil {
+			return "", err
+		}
+		typ = name
+	}
+	if ok, err := p.skip("!"); err != nil {
+		return "", err
+	} else if ok {
+		typ += "!"
+	}
+	return typ, nil
+}
+
+// selectionSet parses the selections between braces
+func (p *parser) selectionSet() ([]*selection, error) {
+	if err := p.expect("{"); err != nil {
+		return nil, err
+	}
+	var selections []*selection
+	for !p.peek("}") {
+		s, err := p.selection()
+		if err != nil {
+			return nil, err
+		}
+		selections = append(selections, s)
+	}
+	if len(selections) == 0 {
+		return nil, &Error{Message: "syntax error: empty selection set", Locations: []Location{p.tok.loc}}
+	}
+	return selections, p.advance()
+}
+
+// selection parses a field or a fragment
+func (p *parser) selection() (*selection, error) {
+	s := &selection{loc: p.tok.loc}
+	var err error
+
+	if ok, err := p.skip("..."); err != nil {
+		return nil, err
+	} else if ok {
+		if p.tok.kind == tokenName && p.tok.text != "on" {
+			s.kind = spreadSelection
+			s.name = p.tok.text
+			if err := p.advance(); err != nil {
+				return nil, err
+			}
+			s.directives, err = p.directives()
+			return s, err
+		}
+		s.kind = inlineSelection
+		if p.tok.kind == tokenName {
+			if err := p.advance(); err != nil {
+				return nil, err
+			}
+			if s.typeCond, err = p.name(); err != nil {
+				return nil, err
+			}
+		}
+		if s.directives, err = p.directives(); err != nil {
+			return nil, err
+		}
+		s.selections, err = p.selectionSet()
+		return s, err
+	}
+
+	if s.name, err = p.name(); err != nil {
+		return nil, err
+	}
+	s.alias = s.name
+	if ok, err := p.skip(":"); err != nil {
+		return nil, err
+	} else if ok {
+		if s.name, err = p.name(); err != nil {
+			return nil, err
+		}
+	}
+	if s.args, err = p.arguments(false); err != nil {
+		return nil, err
+	}
+	if s.directives, err = p.directives(); err != nil {
+		return nil, err
+	}
+	if p.peek("{") {
+		if s.selections, err = p.selectionSet(); err != nil {
+			return nil, err
+		}
+	}
+	return s, nil
+}
+
+// arguments parses the arguments in parentheses, if any
+func (p *parser) arguments(constant bool) ([]argument, error) {
+	if ok, err := p.skip("("); err != nil || !ok {
+		return nil, err
+	}
+	var args []argument
+	for !p.peek(")") {
+		name, err := p.name()
+		if err != nil {
+			return nil, err
+		}
+		if err := p.expect(":"); err != nil {
+			return nil, err
+		}
+		v, err := p.value(constant)
+		if err != nil {
+			return nil, err
+		}
+		for _, arg := range args {
+			if arg.name == name {
+				return nil, &Error{Message: fmt.Sprintf("argument %s is given more than once", name), Locations: []Location{v.loc}}
+			}
+		}
+		args = append(args, argument{name: name, value: v})
+	}
+	if len(args) == 0 {
+		return nil, p.unexpected()
+	}
+	return args, p.advance()
+}
+
+// directives parses the directives at the current position
+func (p *parser) directives() ([]directive, error) {
+	var directives []directive
+	for p.peek("@") {
+		d := directive{loc: p.tok.loc}
+		if err := p.advance(); err != nil {
+			return nil, err
+		}
+		var err error
+		if d.name, err = p.name(); err != nil {
+			return nil, err
+		}
+		if d.args, err = p.arguments(false); err != nil {
+			return nil, err
+		}
+		directives = append(directives, d)
+	}
+	return directives, nil
+}
+
+// value parses a scalar, enum or variable value; lists and input objects
+// aren't supported as no argument takes them
+func (p *parser) value(constant bool) (value, error) {
+	v := value{text: p.tok.text, loc: p.tok.loc}
+	switch {
+	case p.peek("$") && !constant:
+		if err := p.advance(); err != nil {
+			return v, err
+		}
+		name, err := p.name()
+		v.kind, v.text = variableValue, name
+		return v, err
+	case p.peek("[") || p.peek("{"):
+		return v, &Error{Message: "list and input object values are not supported", Locations: []Location{v.loc}}
+	case p.tok.kind == tokenInt:
+		v.kind = intValue
+	case p.tok.kind == tokenFloat:
+		v.kind = floatValue
+	case p.tok.kind == tokenString:
+		v.kind = stringValue
+	case p.tok.kind == tokenName && (p.tok.text == "true" || p.tok.text == "false"):
+		v.kind = boolValue
+	case p.tok.kind == tokenName && p.tok.text == "null":
+		v.kind = nullValue
+	case p.tok.kind == tokenName:
+		v.kind = enumValue
+	default:
+		return v, p.unexpected()
+	}
+	return v, p.advance()
+}
@@ -23,42 +23,25 @@ func NewDHCPHandler(manager *config.Manager) *DHCPHandler {
 		manager:    manager,
 	}
 
-	// Subscribe to config events
-	bus.Subscribe(bus.EventConfigCommitted, h.handleCommit)
+	// Subscribe to commits that include this config; the commit waits for dnsmasq so failures reach the caller
+	bus.SubscribeSync(bus.EventConfigCommitted, h.handleCommit, bus.SubscribeOptions{
+		Name:    "dhcp",
+		Configs: []string{h.configName},
+	})
 
 	return h
 }
 
-// handleCommit handles committed configuration changes
-func (h *DHCPHandler) handleCommit(event bus.Event) {
-	// Check if this is a dhcp config change
-	changes, ok := event.Data.([]string)
-	if !ok {
-		return
-	}
-
-	dhcpChanged := false
-	for _, name := range changes {
-		if name == h.configName {
-			dhcpChanged = true
-			break
-		}
-	}
-
-	if !dhcpChanged {
-		return
-	}
-
+// handleCommit handles commits that include this config
+func (h *DHCPHandler) handleCommit(event bus.Event) error {
 	fmt.Println("DHCP configuration changed, applying dnsmasq configuration...")
 
-	if err := h.applyConfig(); err != nil {
-		fmt.Printf("Error applying DHCP config: %v\n", err)
-	}
+	return h.applyConfig()
 }
 
 // applyConfig applies the DHCP/DNS configuration
 func (h *DHCPHandler) applyConfig() error {
-	cfg, err := h.manager.Load(h.configName)
+	cfg, err := h.manager.LoadDecrypted(h.configName)
 	if err != nil {
 		return fmt.Errorf("failed to load dhcp config: %w", err)
 	}
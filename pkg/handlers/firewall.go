@@ -21,32 +21,17 @@ func NewFirewallHandler() *FirewallHandler {
 		configName: "firewall",
 	}
 
-	// Subscribe to config events
-	bus.Subscribe(bus.EventConfigCommitted, h.handleCommit)
+	// Subscribe to commits that include this config
+	bus.SubscribeWith(bus.EventConfigCommitted, h.handleCommit, bus.SubscribeOptions{
+		Name:    "firewall",
+		Configs: []string{h.configName},
+	})
 
 	return h
 }
 
-// handleCommit handles committed configuration changes
+// handleCommit handles commits that include this config
 func (h *FirewallHandler) handleCommit(event bus.Event) {
-	// Check if this is a firewall config change
-	changes, ok := event.Data.([]string)
-	if !ok {
-		return
-	}
-
-	firewallChanged := false
-	for _, name := range changes {
-		if name == h.configName {
-			firewallChanged = true
-			break
-		}
-	}
-
-	if !firewallChanged {
-		return
-	}
-
 	// Apply firewall rules (this would load the config and apply it)
 	// For now, we'll just log it
 	fmt.Println("Firewall configuration changed, would reload nftables rules here")
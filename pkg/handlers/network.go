@@ -20,32 +20,17 @@ func NewNetworkHandler() *NetworkHandler {
 		configName: "network",
 	}
 
-	// Subscribe to config events
-	bus.Subscribe(bus.EventConfigCommitted, h.handleCommit)
+	// Subscribe to commits that include this config
+	bus.SubscribeWith(bus.EventConfigCommitted, h.handleCommit, bus.SubscribeOptions{
+		Name:    "network",
+		Configs: []string{h.configName},
+	})
 
 	return h
 }
 
-// handleCommit handles committed configuration changes
+// handleCommit handles commits that include this config
 func (h *NetworkHandler) handleCommit(event bus.Event) {
-	// Check if this is a network config change
-	changes, ok := event.Data.([]string)
-	if !ok {
-		return
-	}
-
-	networkChanged := false
-	for _, name := range changes {
-		if name == h.configName {
-			networkChanged = true
-			break
-		}
-	}
-
-	if !networkChanged {
-		return
-	}
-
 	fmt.Println("Network configuration changed, would apply interface changes here")
 }
 
@@ -0,0 +1,229 @@
+// Package health rates how much attention a router needs: config drift,
+// failed transactions, WAN quality, free disk space and certificate expiry
+// are each scored from 0 to 1 and combined, by weight, into a score from 0
+// to 100 that fleets can sort routers by. The score is served as JSON and
+// as Prometheus gauges.
+package health
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/thesabbir/hellfire/pkg/db"
+	"github.com/thesabbir/hellfire/pkg/wanquality"
+)
+
+// Check names
+const (
+	CheckDrift        = "drift"
+	CheckTransactions = "transactions"
+	CheckWAN          = "wan"
+	CheckDisk         = "disk"
+	CheckCerts        = "certs"
+)
+
+// Weights are how much each check counts towards the score
+var Weights = map[string]float64{
+	CheckDrift:        20,
+	CheckTransactions: 20,
+	CheckWAN:          25,
+	CheckDisk:         15,
+	CheckCerts:        20,
+}
+
+// Statuses of a report
+const (
+	StatusHealthy  = "healthy"  // Score of at least 80
+	StatusWarning  = "warning"  // Score of at least 50
+	StatusCritical = "critical" // Below 50
+)
+
+// Disk space thresholds, as the available fraction of the file system
+const (
+	DiskLowFree      = 0.20 // Scores less than 1 below this
+	DiskCriticalFree = 0.05 // Scores 0 below this
+)
+
+// Check is the result of one check
+type Check struct {
+	Name    string  `json:"name"`
+	Score   float64 `json:"score"` // 0 (failing) to 1 (healthy)
+	Weight  float64 `json:"weight"`
+	Message string  `json:"message"`
+}
+
+// Report is the composite health score and its checks
+type Report struct {
+	Score   int       `json:"score"` // 0 to 100
+	Status  string    `json:"status"`
+	Checks  []Check   `json:"checks"`
+	Skipped []string  `json:"skipped,omitempty"` // Checks without data (e.g. WAN monitoring disabled), left out of the score
+	Time    time.Time `json:"time"`
+}
+
+// Compute combines the checks into a report. A nil check is skipped and its
+// weight is spread over the others.
+func Compute(checks map[string]*Check) Report {
+	report := Report{Checks: []Check{}, Time: time.Now()}
+
+	var total, weights float64
+	for name, check := range checks {
+		if check == nil {
+			report.Skipped = append(report.Skipped, name)
+			continue
+		}
+		check.Name = name
+		check.Score = math.Max(0, math.Min(1, check.Score))
+		check.Weight = Weights[name]
+		total += check.Score * check.Weight
+		weights += check.Weight
+		report.Checks = append(report.Checks, *check)
+	}
+	sort.Slice(report.Checks, func(i, j int) bool { return report.Checks[i].Name < report.Checks[j].Name })
+	sort.Strings(report.Skipped)
+
+	report.Score = 100
+	if weights > 0 {
+		report.Score = int(math.Round(100 * total / weights))
+	}
+	switch {
+	case report.Score >= 80:
+		report.Status = StatusHealthy
+	case report.Score >= 50:
+		report.Status = StatusWarning
+	default:
+		report.Status = StatusCritical
+	}
+	return report
+}
+
+// Drift scores config files changed outside hellfire: modified lists the
+// configs written after the last transaction
+func Drift(modified []string) *Check {
+	if len(modified) == 0 {
+		return &Check{Score: 1, Message: "configs match the last transaction"}
+	}
+	return &Check{Score: 0, Message: fmt.Sprintf("changed outside hellfire: %s", strings.Join(modified, ", "))}
+}
+
+// Transactions scores the share of failed and rolled back transactions among
+// recent ones; a system in safe mode after a failed rollback scores 0
+func Transactions(recent []db.Transaction, degraded bool) *Check {
+	if degraded {
+		return &Check{Score: 0, Message: "in safe mode after a failed rollback"}
+	}
+	if len(recent) == 0 {
+		return &Check{Score: 1, Message: "no recent transactions"}
+	}
+
+	failed := 0
+	for _, tx := range recent {
+		// transaction.StateFailed and transaction.StatusRolledBack
+		if tx.Status == "failed" || tx.Status == "rolledback" {
+			failed++
+		}
+	}
+	return &Check{
+		Score:   1 - float64(failed)/float64(len(recent)),
+		Message: fmt.Sprintf("%d of %d recent transactions failed or rolled back", failed, len(recent)),
+	}
+}
+
+// WAN scores the share of ping targets that aren't degraded, or returns nil
+// before any has been measured
+func WAN(targets []wanquality.TargetStatus) *Check {
+	if len(targets) == 0 {
+		return nil
+	}
+
+	var degraded []string
+	for _, target := range targets {
+		if target.Degraded {
+			degraded = append(degraded, target.Target)
+		}
+	}
+	check := &Check{Score: 1 - float64(len(degraded))/float64(len(targets)), Message: "all targets reachable"}
+	if len(degraded) > 0 {
+		check.Message = fmt.Sprintf("degraded: %s", strings.Join(degraded, ", "))
+	}
+	return check
+}
+
+// DiskUsage is the size and available space of a file system
+type DiskUsage struct {
+	Path      string
+	Total     uint64
+	Available uint64
+}
+
+// Disk scores the fullest of the file systems: 1 with at least DiskLowFree
+// available, falling to 0 at DiskCriticalFree. Returns nil without usages.
+func Disk(usages []DiskUsage) *Check {
+	var check *Check
+	for _, usage := range usages {
+		if usage.Total == 0 {
+			continue
+		}
+		free := float64(usage.Available) / float64(usage.Total)
+		score := (free - DiskCriticalFree) / (DiskLowFree - DiskCriticalFree)
+		if check == nil || score < check.Score {
+			check = &Check{Score: score, Message: fmt.Sprintf("%.0f%% available on %s", 100*free, usage.Path)}
+		}
+	}
+	return check
+}
+
+// Certs scores the certificates: 0 if any has expired or can't be read, 0.5
+// if any expires within warnBefore. Returns nil without certificates.
+func Certs(certs []db.Certificate, warnBefore time.Duration, now time.Time) *Check {
+	if len(certs) == 0 {
+		return nil
+	}
+
+	var broken, expiring []string
+	for _, cert := range certs {
+		switch {
+		case cert.Error != "" || now.After(cert.NotAfter):
+			broken = append(broken, cert.Name)
+		case cert.NotAfter.Sub(now) < warnBefore:
+			expiring = append(expiring, cert.Name)
+		}
+	}
+
+	switch {
+	case len(broken) > 0:
+		return &Check{Score: 0, Message: fmt.Sprintf("expired or unreadable: %s", strings.Join(broken, ", "))}
+	case len(expiring) > 0:
+		return &Check{Score: 0.5, Message: fmt.Sprintf("expiring soon: %s", strings.Join(expiring, ", "))}
+	}
+	return &Check{Score: 1, Message: fmt.Sprintf("%d certificates valid", len(certs))}
+}
+
+// WritePrometheus writes the report as Prometheus gauges in the text
+// exposition format
+func (r Report) WritePrometheus(w io.Writer) error {
+	var b strings.Builder
+
+	b.WriteString("# HELP hellfire_health_score Composite health score from 0 (needs attention) to 100\n")
+	b.WriteString("# TYPE hellfire_health_score gauge\n")
+	fmt.Fprintf(&b, "hellfire_health_score %d\n", r.Score)
+
+	b.WriteString("# HELP hellfire_health_check_score Score of each health check from 0 (failing) to 1\n")
+	b.WriteString("# TYPE hellfire_health_check_score gauge\n")
+	for _, check := range r.Checks {
+		fmt.Fprintf(&b, "hellfire_health_check_score{check=%q} %g\n", check.Name, check.Score)
+	}
+
+	b.WriteString("# HELP hellfire_health_check_weight Weight of each health check in the score\n")
+	b.WriteString("# TYPE hellfire_health_check_weight gauge\n")
+	for _, check := range r.Checks {
+		fmt.Fprintf(&b, "hellfire_health_check_weight{check=%q} %g\n", check.Name, check.Weight)
+	}
+
+	_, err := io.WriteString(w, b.String())
+	return err
+}
@@ -0,0 +1,75 @@
+package health
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/thesabbir/hellfire/pkg/db"
+	"github.com/thesabbir/hellfire/pkg/wanquality"
+)
+
+func TestCompute(t *testing.T) {
+	now := time.Now()
+	report := Compute(map[string]*Check{
+		CheckDrift:        Drift(nil),
+		CheckTransactions: Transactions([]db.Transaction{{Status: "completed"}, {Status: "rolledback"}}, false),
+		CheckWAN:          WAN(nil),
+		CheckDisk:         Disk([]DiskUsage{{Path: "/", Total: 100, Available: 50}, {Path: "/overlay", Total: 100, Available: 5}}),
+		CheckCerts:        Certs([]db.Certificate{{Name: "grpc", NotAfter: now.Add(24 * time.Hour)}}, 30*24*time.Hour, now),
+	})
+
+	// drift 1*20 + transactions 0.5*20 + disk 0*15 + certs 0.5*20, out of 75
+	if report.Score != 53 || report.Status != StatusWarning {
+		t.Errorf("score = %d (%s), want 53 (warning)", report.Score, report.Status)
+	}
+	if len(report.Skipped) != 1 || report.Skipped[0] != CheckWAN {
+		t.Errorf("skipped = %v, want [wan]", report.Skipped)
+	}
+	if len(report.Checks) != 4 || report.Checks[0].Name != CheckCerts || report.Checks[1].Message != "5% available on /overlay" {
+		t.Errorf("checks = %+v", report.Checks)
+	}
+
+	if report := Compute(nil); report.Score != 100 || report.Status != StatusHealthy {
+		t.Errorf("score without checks = %d (%s)", report.Score, report.Status)
+	}
+}
+
+func TestChecks(t *testing.T) {
+	if check := Transactions(nil, true); check.Score != 0 {
+		t.Errorf("degraded transactions score %g", check.Score)
+	}
+
+	check := WAN([]wanquality.TargetStatus{{Target: "1.1.1.1"}, {Target: "8.8.8.8", Degraded: true}})
+	if check.Score != 0.5 || check.Message != "degraded: 8.8.8.8" {
+		t.Errorf("WAN = %+v", check)
+	}
+
+	now := time.Now()
+	check = Certs([]db.Certificate{{Name: "old", NotAfter: now.Add(-time.Hour)}}, time.Hour, now)
+	if check.Score != 0 {
+		t.Errorf("expired certs score %g", check.Score)
+	}
+
+	if check := Drift([]string{"network"}); check.Score != 0 {
+		t.Errorf("drift score %g", check.Score)
+	}
+}
+
+func TestWritePrometheus(t *testing.T) {
+	report := Compute(map[string]*Check{CheckDrift: Drift(nil), CheckDisk: Disk([]DiskUsage{{Total: 100, Available: 50}})})
+
+	var b strings.Builder
+	if err := report.WritePrometheus(&b); err != nil {
+		t.Fatal(err)
+	}
+	for _, line := range []string{
+		"# TYPE hellfire_health_score gauge\nhellfire_health_score 100\n",
+		`hellfire_health_check_score{check="disk"} 1` + "\n",
+		`hellfire_health_check_weight{check="drift"} 20` + "\n",
+	} {
+		if !strings.Contains(b.String(), line) {
+			t.Errorf("missing %q in:\n%s", line, b.String())
+		}
+	}
+}
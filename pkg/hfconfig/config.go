@@ -1,13 +1,33 @@
 package hfconfig
 
 import (
+	"bytes"
+	"crypto/tls"
+	"crypto/x509"
 	"fmt"
+	"net"
+	"net/http"
+	"net/url"
 	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
 	"strconv"
 	"strings"
+	"time"
 
+	"github.com/thesabbir/hellfire/pkg/appliers"
+	"github.com/thesabbir/hellfire/pkg/bus"
+	"github.com/thesabbir/hellfire/pkg/canary"
+	"github.com/thesabbir/hellfire/pkg/certs"
+	"github.com/thesabbir/hellfire/pkg/indicator"
 	"github.com/thesabbir/hellfire/pkg/logger"
+	"github.com/thesabbir/hellfire/pkg/probe"
+	"github.com/thesabbir/hellfire/pkg/spa"
+	"github.com/thesabbir/hellfire/pkg/syslog"
 	"github.com/thesabbir/hellfire/pkg/uci"
+	"github.com/thesabbir/hellfire/pkg/util"
+	"github.com/thesabbir/hellfire/pkg/wanquality"
 )
 
 const (
@@ -17,38 +37,141 @@ const (
 	// Default values
 	DefaultAPIPort           = 8888
 	DefaultEnableCORS        = true
+	DefaultCORSMaxAge        = 600 // 10 minutes
+	DefaultCORSAllowSelf     = true
+	DefaultEnableCompression = true
+	DefaultEnableGraphQL     = false
 	DefaultMinPasswordLength = 12
 	DefaultSessionTimeout    = 86400  // 24 hours
 	DefaultAbsoluteTimeout   = 604800 // 7 days
 	DefaultMaxFailedLogins   = 5
 	DefaultEnableSwagger     = false
+	DefaultOnboardingTTL     = 1800 // 30 minutes
 	DefaultRetentionDays     = 90
 	DefaultGlobalRateLimit   = 100
 	DefaultAuthRateLimit     = 5
+	DefaultGRPCPort          = 8889
+	DefaultMaxBodySize       = 1 << 20 // 1 MiB
+	DefaultReadHeaderTimeout = 10      // seconds
+	DefaultReadTimeout       = 30      // seconds
+	DefaultWriteTimeout      = 60      // seconds
+	DefaultIdleTimeout       = 120     // seconds
+	DefaultRequestTimeout    = 30      // seconds
+	DefaultApplyRouteTimeout = 300     // seconds, for routes that apply configuration
+	DefaultUpgradeTimeout    = 3600    // seconds, for the upgrade route streaming its output
+	DefaultKeepaliveTimeout  = 30      // seconds between the keepalives of a safe remote commit
+	DefaultCaptureSize       = 1024    // kilobytes of each system state dump taken around applies
+	DefaultSnapshotMinFree   = 16      // megabytes free to take a snapshot
+	DefaultLogLevel          = "info"
+	DefaultLogFormat         = logger.FormatJSON
+	DefaultLogMaxSize        = 10 // megabytes
+	DefaultLogMaxBackups     = 3
+	DefaultEventQueueSize    = bus.DefaultQueueSize
+	DefaultEventOverflow     = string(bus.OverflowBlock)
+	DefaultEventSpoolDir     = "/var/lib/hellfire/events"
+	DefaultRecoveryInterface = "br-lan"
+	DefaultRecoveryIPAddr    = "192.168.1.1"
+	DefaultRecoveryNetmask   = "255.255.255.0"
+	DefaultRecoveryStateFile = "/var/lib/hellfire/degraded.json"
+	DefaultProbeDelay        = 5  // seconds
+	DefaultProbeInterval     = 10 // seconds
+	DefaultProbeTimeout      = 3  // seconds
+	DefaultProbeFailures     = 3
+	DefaultSSHPort           = 22
+	DefaultSPAPort           = spa.DefaultPort
+	DefaultSPAKeyFile        = "/etc/hellfire/spa.key"
+	DefaultSPAOpenTimeout    = 60 // seconds
+	DefaultSPAMaxSkew        = 30 // seconds
+	DefaultWANInterval       = 60 // seconds
+	DefaultWANPings          = 5
+	DefaultWANSpeedTool      = wanquality.ToolSpeedtest
+	DefaultWANMaxLatency     = 150 // milliseconds
+	DefaultWANMaxJitter      = 30  // milliseconds
+	DefaultWANMaxLoss        = 5   // percent
+	DefaultWANRetentionDays  = 30
+	DefaultCertStoreDir      = certs.DefaultStoreDir
+	DefaultCertWarnDays      = 30
+	DefaultSyslogListen      = "127.0.0.1:5514"
+	DefaultSyslogProtocol    = syslog.ProtocolUDP
+	DefaultSyslogBufferSize  = 10000
 )
 
 // Config represents Hellfire's configuration
 type Config struct {
-	API       APIConfig
-	Security  SecurityConfig
-	Audit     AuditConfig
-	RateLimit RateLimitConfig
+	API         APIConfig
+	Security    SecurityConfig
+	Audit       AuditConfig
+	RateLimit   RateLimitConfig
+	Transaction TransactionConfig
+	GRPC        GRPCConfig
+	Logging     LoggingConfig
+	Events      EventsConfig
+	Recovery    RecoveryConfig
+	Probe       ProbeConfig
+	Management  ManagementConfig
+	SPA         SPAConfig
+	WAN         WANConfig
+	Certs       CertsConfig
+	Snapshots   SnapshotsConfig
+	Syslog      SyslogConfig
+	Indicator   IndicatorConfig
+	Canary      CanaryConfig
+	Headers     HeadersConfig
+	ACLs        []ConfigACL
 }
 
 // APIConfig contains API server configuration
 type APIConfig struct {
 	Port           int
 	EnableCORS     bool
-	AllowedOrigins []string
+	AllowedOrigins []string // Exact origins or wildcard subdomains ("https://*.example.com")
+	CORSAllowSelf  bool     // Also allow the router's own host names and addresses
+	CORSMaxAge     int      // Seconds browsers may cache preflight results (0 = browser default)
+	WebRoot        string   // Serve the web UI from this directory instead of the embedded build
+
+	// Compress responses with gzip/deflate for clients that accept it
+	EnableCompression bool
+
+	// Serve the read-only GraphQL endpoint at /api/graphql
+	EnableGraphQL bool
+
+	// Listen addresses ("host:port" or "unix:/path"); empty listens on Port on all interfaces
+	Listen []string
+
+	// VRF device the TCP listeners are bound to, so the API is only reachable
+	// through the interfaces of that VRF (the management plane)
+	VRF string
+
+	// Firewall zones and interfaces allowed to reach the API and gRPC ports;
+	// when both are empty the firewall doesn't restrict management access
+	AllowedZones      []string
+	AllowedInterfaces []string
+
+	// Request limits (sizes in bytes, timeouts in seconds; 0 = no limit)
+	MaxBodySize       int64
+	ReadHeaderTimeout int
+	ReadTimeout       int
+	WriteTimeout      int
+	IdleTimeout       int
+	RequestTimeout    int            // Default per-request timeout
+	RouteTimeouts     map[string]int // Route relative to the API prefix (e.g. "/config/commit") -> timeout
 }
 
 // SecurityConfig contains security settings
 type SecurityConfig struct {
-	MinPasswordLength    int
-	SessionTimeout       int // seconds
-	AbsoluteTimeout      int // seconds
-	MaxFailedLogins      int
-	EnableSwagger        bool
+	MinPasswordLength      int
+	PasswordRequireUpper   bool
+	PasswordRequireLower   bool
+	PasswordRequireNumber  bool
+	PasswordRequireSpecial bool
+	BannedPasswords        []string // Passwords rejected outright
+	BannedPasswordsFile    string   // File with one banned password per line
+	SessionTimeout         int      // seconds
+	AbsoluteTimeout        int      // seconds
+	MaxFailedLogins        int
+	EnableSwagger          bool
+	OnboardingTokenTTL     int  // seconds the onboarding token printed at first start is valid
+	NewDeviceAlert         bool // Publish auth.new_device when a user logs in from an unknown device
 }
 
 // AuditConfig contains audit log settings
@@ -64,46 +187,275 @@ type RateLimitConfig struct {
 	GlobalBurst             int
 	AuthRequestsPerMinute   int
 	AuthBurst               int
+	Classes                 map[string]RateLimitClass // Route class ("read", "write", "diagnostics") -> limits
+	Exempt                  []string                  // IPs or CIDRs that bypass rate limiting
+}
+
+// RateLimitClass limits a class of API routes per client IP and per
+// authenticated user (0 requests per minute = no limit)
+type RateLimitClass struct {
+	RequestsPerMinute     int
+	Burst                 int
+	UserRequestsPerMinute int
+	UserBurst             int
+}
+
+// RateLimitClasses are the route classes that can be configured
+var RateLimitClasses = []string{"read", "write", "diagnostics"}
+
+// TransactionConfig contains transaction engine settings
+type TransactionConfig struct {
+	ApplyOrder []string // Configs applied first, in this order
+	SkipApply  []string // Configs that are committed but never applied
+
+	// Apply to a simulated system instead of this one (read at startup)
+	Simulate bool
+
+	// Make every API commit a safe remote commit, rolled back unless the
+	// committing client keeps sending keepalives (seconds between them)
+	SafeRemote       bool
+	KeepaliveTimeout int
+
+	// Manual rollbacks must say why (automatic ones record their trigger)
+	RequireRollbackReason bool
+
+	// Cap in kilobytes of the system state dumped on the transaction before
+	// and after applying its configs (0 = no dumps)
+	CaptureSize int
+}
+
+// GRPCConfig contains gRPC server settings
+type GRPCConfig struct {
+	Enabled       bool
+	Port          int
+	CertFile      string // Server certificate (TLS)
+	KeyFile       string // Server private key (TLS)
+	ClientCAFile  string // CA for client certificates (enables mTLS)
+	AllowInsecure bool   // Serve plaintext when no certificate is configured
+	VRF           string // Bind the listener to this VRF device
+}
+
+// LoggingConfig contains log output settings
+type LoggingConfig struct {
+	Level      string // debug, info, warn or error
+	Format     string // json or text
+	File       string // Log file path; empty logs to stdout
+	MaxSize    int    // Rotate the log file after this many megabytes (0 = never)
+	MaxBackups int    // Rotated log files to keep
+}
+
+// EventsConfig contains event bus buffering settings
+type EventsConfig struct {
+	QueueSize int    // Events buffered per handler
+	Overflow  string // block, drop or disk when a handler's queue is full
+	SpoolDir  string // Where events are spooled with the disk policy
+}
+
+// RecoveryConfig contains the safe-mode network applied when a rollback fails
+type RecoveryConfig struct {
+	Enabled    bool   // Apply the recovery network (the system is flagged degraded either way)
+	Interface  string // Management interface given the static address
+	IPAddr     string
+	Netmask    string
+	AllowPorts []int  // TCP ports accepted besides the API and gRPC ports (e.g. SSH)
+	StateFile  string // Where the degraded flag is persisted
+}
+
+// ProbeConfig contains the connectivity probes run while a commit awaits
+// confirmation (times in seconds)
+type ProbeConfig struct {
+	Enabled    bool
+	Delay      int      // Before the first round
+	Interval   int      // Between rounds
+	Timeout    int      // Per probe
+	Failures   int      // Failed rounds in a row before rolling back
+	Gateway    bool     // Ping the default gateway
+	DNSName    string   // Resolve this name
+	Endpoints  []string // HTTP(S) URLs or host:port reached over TCP
+	APIAddress string   // LAN address the API port must accept connections on
+}
+
+// ManagementConfig contains the firewall rules generated to protect
+// Hellfire's management plane besides the API zone lists in APIConfig
+type ManagementConfig struct {
+	AllowSSH      bool // Accept SSH from SSHZones/SSHInterfaces, or from anywhere if both are empty
+	SSHPort       int
+	SSHZones      []string
+	SSHInterfaces []string
+	AntiLockout   bool // Always accept the committing client on the management ports
+}
+
+// SPAConfig contains single-packet authorization settings: when enabled the
+// management ports stay closed until a client sends a valid knock
+type SPAConfig struct {
+	Enabled     bool
+	Port        int    // UDP port knocks are sent to
+	KeyFile     string // Shared HMAC key
+	OpenTimeout int    // Seconds a knock opens the ports for
+	MaxSkew     int    // Seconds of clock difference accepted
+	ProtectSSH  bool   // Gate the SSH port as well as the API and gRPC ports
+	AllowNAT    bool   // Accept knocks signed for any source address
+}
+
+// WANConfig contains the WAN quality monitor settings (times in seconds)
+type WANConfig struct {
+	Enabled       bool
+	Interval      int      // Between ping rounds
+	Targets       []string // Hosts pinged each round
+	Pings         int      // Echo requests per target and round
+	SpeedInterval int      // Between throughput tests; 0 disables them
+	SpeedTool     string   // iperf3 or speedtest (speedtest-cli)
+	IperfServer   string   // host or host:port, for iperf3
+	MaxLatency    int      // Milliseconds before the link counts as degraded
+	MaxJitter     int      // Milliseconds
+	MaxLoss       int      // Percent
+	RetentionDays int      // How long samples are kept
+}
+
+// CertsConfig contains the certificate inventory settings
+type CertsConfig struct {
+	StoreDir string // Where uploaded certificates are kept
+	WarnDays int    // Days before expiry a certificate is reported
+}
+
+// SnapshotsConfig contains the disk space limits of the snapshots
+type SnapshotsConfig struct {
+	MinFree int // Megabytes that must be free to take a snapshot
+	Quota   int // Megabytes the snapshots may use, pruning the oldest unprotected ones (0 = unlimited)
+}
+
+// SyslogConfig contains the embedded syslog collector settings
+type SyslogConfig struct {
+	Enabled    bool
+	Listen     string // host:port
+	Protocol   string // udp, tcp or both
+	BufferSize int    // Messages kept in memory
+}
+
+// IndicatorConfig contains the hooks signalling a commit awaiting
+// confirmation, and its rollback, on headless devices
+type IndicatorConfig struct {
+	Enabled      bool
+	Script       string // Run with pending, confirmed or rolled_back
+	Unit         string // systemd unit started while pending
+	RollbackUnit string // systemd unit started after a rollback
+	LED          string // Name under /sys/class/leds
+}
+
+// CanaryConfig contains the router every commit is applied to first, such
+// as the standby of an HA pair, through its API
+type CanaryConfig struct {
+	Enabled        bool
+	URL            string // API base URL of the canary
+	Username       string
+	PasswordFile   string // Holds the password of Username
+	CAFile         string // CA of the canary's API certificate, if private
+	Settle         int    // Seconds to wait after the commit before checking its health
+	MinScore       int    // Lowest health score the canary must report (0-100)
+	ConfirmTimeout int    // Seconds the canary waits for this router to apply the changes
+}
+
+// HeadersConfig overrides the security headers sent with every response,
+// keyed by option name (see SecurityHeaderOptions). Headers that aren't set
+// keep their built-in values; an empty value leaves the header out.
+type HeadersConfig struct {
+	Overrides map[string]string
+}
+
+// ConfigACL restricts who may read and write a config, or some of its
+// sections, through the API (acl sections). Admins aren't restricted.
+type ConfigACL struct {
+	Config  string
+	Section string   // Section name, "@type" for every section of a type, or empty for the whole config
+	Read    []string // Roles that may read; empty for every role
+	Write   []string // Roles that may write; empty for every role allowed to
+}
+
+// SecurityHeaderOptions are the options of the headers section
+var SecurityHeaderOptions = []string{
+	"content_security_policy",
+	"frame_options",
+	"referrer_policy",
+	"permissions_policy",
+	"hsts",
+}
+
+// Options returns the event bus options for this config
+func (c EventsConfig) Options() bus.Options {
+	return bus.Options{
+		QueueSize: c.QueueSize,
+		Overflow:  bus.OverflowPolicy(strings.ToLower(c.Overflow)),
+		SpoolDir:  c.SpoolDir,
+	}
 }
 
-// Load loads Hellfire configuration from UCI file
+// Options returns the logger options for this config
+func (c LoggingConfig) Options() logger.Options {
+	return logger.Options{
+		Level:      c.Level,
+		Format:     c.Format,
+		File:       c.File,
+		MaxSize:    c.MaxSize,
+		MaxBackups: c.MaxBackups,
+	}
+}
+
+// Load loads Hellfire configuration from UCI file, with the overrides of
+// the environment (see EnvVars)
 func Load(path string) (*Config, error) {
 	if path == "" {
 		path = DefaultConfigPath
 	}
 
-	// Try to load the config
-	file, err := os.Open(path)
+	config, err := Reload(path)
 	if err != nil {
 		logger.Warn("Failed to load Hellfire config, using defaults", "path", path, "error", err)
-		return DefaultConfig(), nil
+		config = DefaultConfig()
+		if err := ApplyEnv(config); err != nil {
+			logger.Warn("Ignoring invalid environment overrides", "error", err)
+		}
+	}
+
+	return config, nil
+}
+
+// Reload loads Hellfire configuration like Load, but returns an error instead
+// of falling back to defaults when the file can't be read or parsed, so a
+// running daemon can keep its current settings
+func Reload(path string) (*Config, error) {
+	if path == "" {
+		path = DefaultConfigPath
+	}
+
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open Hellfire config: %w", err)
 	}
 	defer file.Close()
 
 	cfg, err := uci.Parse(file)
 	if err != nil {
-		logger.Warn("Failed to parse Hellfire config, using defaults", "path", path, "error", err)
-		return DefaultConfig(), nil
+		return nil, fmt.Errorf("failed to parse Hellfire config: %w", err)
 	}
 
 	config := &Config{}
 
 	// Load API config
-	if apiSection := cfg.GetSection("server", "api"); apiSection != nil {
+	if apiSection := cfg.GetSection("api", "server"); apiSection != nil {
 		config.API = loadAPIConfig(apiSection)
 	} else {
 		config.API = defaultAPIConfig()
 	}
 
 	// Load security config
-	if secSection := cfg.GetSection("settings", "security"); secSection != nil {
+	if secSection := cfg.GetSection("security", "settings"); secSection != nil {
 		config.Security = loadSecurityConfig(secSection)
 	} else {
 		config.Security = defaultSecurityConfig()
 	}
 
 	// Load audit config
-	if auditSection := cfg.GetSection("retention", "audit"); auditSection != nil {
+	if auditSection := cfg.GetSection("audit", "retention"); auditSection != nil {
 		config.Audit = loadAuditConfig(auditSection)
 	} else {
 		config.Audit = defaultAuditConfig()
@@ -112,16 +464,141 @@ func Load(path string) (*Config, error) {
 	// Load rate limit config
 	config.RateLimit = loadRateLimitConfig(cfg)
 
+	// Load transaction config
+	if txSection := cfg.GetSection("transaction", "apply"); txSection != nil {
+		config.Transaction = loadTransactionConfig(txSection)
+	} else {
+		config.Transaction = defaultTransactionConfig()
+	}
+
+	// Load gRPC config
+	if grpcSection := cfg.GetSection("grpc", "server"); grpcSection != nil {
+		config.GRPC = loadGRPCConfig(grpcSection)
+	} else {
+		config.GRPC = defaultGRPCConfig()
+	}
+
+	// Load logging config
+	if logSection := cfg.GetSection("logging", "settings"); logSection != nil {
+		config.Logging = loadLoggingConfig(logSection)
+	} else {
+		config.Logging = defaultLoggingConfig()
+	}
+
+	// Load event bus config
+	if eventsSection := cfg.GetSection("events", "bus"); eventsSection != nil {
+		config.Events = loadEventsConfig(eventsSection)
+	} else {
+		config.Events = defaultEventsConfig()
+	}
+
+	// Load safe-mode recovery config
+	if recoverySection := cfg.GetSection("recovery", "safemode"); recoverySection != nil {
+		config.Recovery = loadRecoveryConfig(recoverySection)
+	} else {
+		config.Recovery = defaultRecoveryConfig()
+	}
+
+	// Load confirmation probe config
+	if probeSection := cfg.GetSection("probe", "confirm"); probeSection != nil {
+		config.Probe = loadProbeConfig(probeSection)
+	} else {
+		config.Probe = defaultProbeConfig()
+	}
+
+	// Load management plane firewall config
+	if managementSection := cfg.GetSection("management", "firewall"); managementSection != nil {
+		config.Management = loadManagementConfig(managementSection)
+	} else {
+		config.Management = defaultManagementConfig()
+	}
+
+	// Load single-packet authorization config
+	if spaSection := cfg.GetSection("spa", "knock"); spaSection != nil {
+		config.SPA = loadSPAConfig(spaSection)
+	} else {
+		config.SPA = defaultSPAConfig()
+	}
+
+	// Load WAN quality monitor config
+	if wanSection := cfg.GetSection("wan", "quality"); wanSection != nil {
+		config.WAN = loadWANConfig(wanSection)
+	} else {
+		config.WAN = defaultWANConfig()
+	}
+
+	// Load certificate inventory config
+	if certsSection := cfg.GetSection("certs", "inventory"); certsSection != nil {
+		config.Certs = loadCertsConfig(certsSection)
+	} else {
+		config.Certs = defaultCertsConfig()
+	}
+
+	// Load snapshot storage config
+	if snapshotsSection := cfg.GetSection("snapshots", "storage"); snapshotsSection != nil {
+		config.Snapshots = loadSnapshotsConfig(snapshotsSection)
+	} else {
+		config.Snapshots = defaultSnapshotsConfig()
+	}
+
+	// Load syslog collector config
+	if syslogSection := cfg.GetSection("syslog", "collector"); syslogSection != nil {
+		config.Syslog = loadSyslogConfig(syslogSection)
+	} else {
+		config.Syslog = defaultSyslogConfig()
+	}
+
+	// Load confirmation indicator config
+	if indicatorSection := cfg.GetSection("indicator", "confirm"); indicatorSection != nil {
+		config.Indicator = loadIndicatorConfig(indicatorSection)
+	} else {
+		config.Indicator = IndicatorConfig{}
+	}
+
+	// Load canary config
+	if canarySection := cfg.GetSection("canary", "peer"); canarySection != nil {
+		config.Canary = loadCanaryConfig(canarySection)
+	} else {
+		config.Canary = defaultCanaryConfig()
+	}
+
+	if headersSection := cfg.GetSection("headers", "security"); headersSection != nil {
+		config.Headers = loadHeadersConfig(headersSection)
+	}
+
+	// Load per-config access rules
+	for _, aclSection := range cfg.GetSectionsByType("acl") {
+		config.ACLs = append(config.ACLs, loadConfigACL(aclSection))
+	}
+
+	if err := ApplyEnv(config); err != nil {
+		logger.Warn("Ignoring invalid environment overrides", "error", err)
+	}
+
 	return config, nil
 }
 
 // DefaultConfig returns default configuration
 func DefaultConfig() *Config {
 	return &Config{
-		API:       defaultAPIConfig(),
-		Security:  defaultSecurityConfig(),
-		Audit:     defaultAuditConfig(),
-		RateLimit: defaultRateLimitConfig(),
+		API:         defaultAPIConfig(),
+		Security:    defaultSecurityConfig(),
+		Audit:       defaultAuditConfig(),
+		RateLimit:   defaultRateLimitConfig(),
+		Transaction: defaultTransactionConfig(),
+		GRPC:        defaultGRPCConfig(),
+		Logging:     defaultLoggingConfig(),
+		Events:      defaultEventsConfig(),
+		Recovery:    defaultRecoveryConfig(),
+		Probe:       defaultProbeConfig(),
+		Management:  defaultManagementConfig(),
+		SPA:         defaultSPAConfig(),
+		WAN:         defaultWANConfig(),
+		Certs:       defaultCertsConfig(),
+		Snapshots:   defaultSnapshotsConfig(),
+		Syslog:      defaultSyslogConfig(),
+		Indicator:   IndicatorConfig{},
+		Canary:      defaultCanaryConfig(),
 	}
 }
 
@@ -142,6 +619,66 @@ func loadAPIConfig(section *uci.Section) APIConfig {
 		cfg.AllowedOrigins = origins
 	}
 
+	if allowSelf, ok := section.GetOption("cors_allow_self"); ok {
+		cfg.CORSAllowSelf = allowSelf == "1" || strings.ToLower(allowSelf) == "true"
+	}
+
+	if maxAge, ok := section.GetOption("cors_max_age"); ok {
+		if n, err := strconv.Atoi(maxAge); err == nil {
+			cfg.CORSMaxAge = n
+		}
+	}
+
+	if webRoot, ok := section.GetOption("web_root"); ok {
+		cfg.WebRoot = webRoot
+	}
+
+	if compression, ok := section.GetOption("compression"); ok {
+		cfg.EnableCompression = compression == "1" || strings.ToLower(compression) == "true"
+	}
+
+	if graphql, ok := section.GetOption("graphql"); ok {
+		cfg.EnableGraphQL = graphql == "1" || strings.ToLower(graphql) == "true"
+	}
+
+	cfg.Listen = section.GetList("listen")
+	if vrf, ok := section.GetOption("vrf"); ok {
+		cfg.VRF = vrf
+	}
+	cfg.AllowedZones = section.GetList("allow_zone")
+	cfg.AllowedInterfaces = section.GetList("allow_interface")
+
+	if size, ok := section.GetOption("max_body_size"); ok {
+		if n, err := strconv.ParseInt(size, 10, 64); err == nil {
+			cfg.MaxBodySize = n
+		}
+	}
+
+	for option, target := range map[string]*int{
+		"read_header_timeout": &cfg.ReadHeaderTimeout,
+		"read_timeout":        &cfg.ReadTimeout,
+		"write_timeout":       &cfg.WriteTimeout,
+		"idle_timeout":        &cfg.IdleTimeout,
+		"request_timeout":     &cfg.RequestTimeout,
+	} {
+		if value, ok := section.GetOption(option); ok {
+			if t, err := strconv.Atoi(value); err == nil {
+				*target = t
+			}
+		}
+	}
+
+	// Route timeouts are "route=seconds" and override the defaults per route
+	for _, entry := range section.GetList("route_timeout") {
+		route, value, found := strings.Cut(entry, "=")
+		t, err := strconv.Atoi(value)
+		if !found || err != nil || !strings.HasPrefix(route, "/") {
+			logger.Warn("Ignoring invalid route_timeout", "value", entry)
+			continue
+		}
+		cfg.RouteTimeouts[route] = t
+	}
+
 	return cfg
 }
 
@@ -154,6 +691,23 @@ func loadSecurityConfig(section *uci.Section) SecurityConfig {
 		}
 	}
 
+	for option, value := range map[string]*bool{
+		"password_require_upper":   &cfg.PasswordRequireUpper,
+		"password_require_lower":   &cfg.PasswordRequireLower,
+		"password_require_number":  &cfg.PasswordRequireNumber,
+		"password_require_special": &cfg.PasswordRequireSpecial,
+	} {
+		if v, ok := section.GetOption(option); ok {
+			*value = v == "1" || strings.ToLower(v) == "true"
+		}
+	}
+
+	cfg.BannedPasswords = section.GetList("banned_password")
+
+	if file, ok := section.GetOption("banned_passwords_file"); ok {
+		cfg.BannedPasswordsFile = file
+	}
+
 	if timeout, ok := section.GetOption("session_timeout"); ok {
 		if t, err := strconv.Atoi(timeout); err == nil {
 			cfg.SessionTimeout = t
@@ -176,6 +730,16 @@ func loadSecurityConfig(section *uci.Section) SecurityConfig {
 		cfg.EnableSwagger = swagger == "1" || strings.ToLower(swagger) == "true"
 	}
 
+	if alert, ok := section.GetOption("new_device_alert"); ok {
+		cfg.NewDeviceAlert = alert == "1" || strings.ToLower(alert) == "true"
+	}
+
+	if ttl, ok := section.GetOption("onboarding_token_ttl"); ok {
+		if t, err := strconv.Atoi(ttl); err == nil {
+			cfg.OnboardingTokenTTL = t
+		}
+	}
+
 	return cfg
 }
 
@@ -203,7 +767,7 @@ func loadRateLimitConfig(cfg *uci.Config) RateLimitConfig {
 	rlCfg := defaultRateLimitConfig()
 
 	// Load global rate limit
-	if globalSection := cfg.GetSection("global", "ratelimit"); globalSection != nil {
+	if globalSection := cfg.GetSection("ratelimit", "global"); globalSection != nil {
 		if rpm, ok := globalSection.GetOption("requests_per_minute"); ok {
 			if r, err := strconv.Atoi(rpm); err == nil {
 				rlCfg.GlobalRequestsPerMinute = r
@@ -216,10 +780,12 @@ func loadRateLimitConfig(cfg *uci.Config) RateLimitConfig {
 				rlCfg.GlobalBurst = b
 			}
 		}
+
+		rlCfg.Exempt = globalSection.GetList("exempt")
 	}
 
 	// Load auth rate limit
-	if authSection := cfg.GetSection("auth", "ratelimit"); authSection != nil {
+	if authSection := cfg.GetSection("ratelimit", "auth"); authSection != nil {
 		if rpm, ok := authSection.GetOption("requests_per_minute"); ok {
 			if r, err := strconv.Atoi(rpm); err == nil {
 				rlCfg.AuthRequestsPerMinute = r
@@ -234,115 +800,1567 @@ func loadRateLimitConfig(cfg *uci.Config) RateLimitConfig {
 		}
 	}
 
+	// Load per-class limits
+	for _, class := range RateLimitClasses {
+		if section := cfg.GetSection("ratelimit", class); section != nil {
+			rlCfg.Classes[class] = loadRateLimitClass(section, rlCfg.Classes[class])
+		}
+	}
+
 	return rlCfg
 }
 
-func defaultAPIConfig() APIConfig {
-	return APIConfig{
-		Port:       DefaultAPIPort,
-		EnableCORS: DefaultEnableCORS,
-		AllowedOrigins: []string{
-			"http://localhost:5173",  // Default Vite dev server
-			"https://router.local",   // Default production
-		},
+func loadRateLimitClass(section *uci.Section, cfg RateLimitClass) RateLimitClass {
+	if rpm, ok := section.GetOption("requests_per_minute"); ok {
+		if r, err := strconv.Atoi(rpm); err == nil {
+			cfg.RequestsPerMinute = r
+			cfg.Burst = r // Default burst = requests per minute
+		}
 	}
-}
 
-func defaultSecurityConfig() SecurityConfig {
-	return SecurityConfig{
-		MinPasswordLength: DefaultMinPasswordLength,
-		SessionTimeout:    DefaultSessionTimeout,
-		AbsoluteTimeout:   DefaultAbsoluteTimeout,
-		MaxFailedLogins:   DefaultMaxFailedLogins,
-		EnableSwagger:     DefaultEnableSwagger,
+	if burst, ok := section.GetOption("burst"); ok {
+		if b, err := strconv.Atoi(burst); err == nil {
+			cfg.Burst = b
+		}
 	}
-}
 
-func defaultAuditConfig() AuditConfig {
-	return AuditConfig{
-		Enabled:       true,
-		RetentionDays: DefaultRetentionDays,
-		ArchivePath:   "/var/lib/hellfire/audit-archive",
+	if rpm, ok := section.GetOption("user_requests_per_minute"); ok {
+		if r, err := strconv.Atoi(rpm); err == nil {
+			cfg.UserRequestsPerMinute = r
+			cfg.UserBurst = r
+		}
 	}
-}
 
-func defaultRateLimitConfig() RateLimitConfig {
-	return RateLimitConfig{
-		GlobalRequestsPerMinute: DefaultGlobalRateLimit,
-		GlobalBurst:             DefaultGlobalRateLimit,
-		AuthRequestsPerMinute:   DefaultAuthRateLimit,
-		AuthBurst:               DefaultAuthRateLimit,
+	if burst, ok := section.GetOption("user_burst"); ok {
+		if b, err := strconv.Atoi(burst); err == nil {
+			cfg.UserBurst = b
+		}
 	}
+
+	return cfg
 }
 
-// CreateDefaultConfig creates a default Hellfire config file
-func CreateDefaultConfig(path string) error {
-	if path == "" {
-		path = DefaultConfigPath
+func loadTransactionConfig(section *uci.Section) TransactionConfig {
+	cfg := defaultTransactionConfig()
+
+	if order := section.GetList("order"); len(order) > 0 {
+		cfg.ApplyOrder = order
 	}
 
-	content := `# Hellfire Configuration
-# This file configures Hellfire itself using UCI format
+	if skip := section.GetList("skip"); len(skip) > 0 {
+		cfg.SkipApply = skip
+	}
 
-config api 'server'
-	option port '8888'
-	option enable_cors '1'
-	list allowed_origins 'http://localhost:5173'
-	list allowed_origins 'https://router.local'
+	if simulate, ok := section.GetOption("simulate"); ok {
+		cfg.Simulate = simulate == "1" || strings.ToLower(simulate) == "true"
+	}
 
-config security 'settings'
-	option min_password_length '12'
-	option session_timeout '86400'
-	option absolute_session_timeout '604800'
-	option max_failed_logins '5'
-	option enable_swagger '0'
+	if safe, ok := section.GetOption("safe_remote"); ok {
+		cfg.SafeRemote = safe == "1" || strings.ToLower(safe) == "true"
+	}
 
-config audit 'retention'
-	option enabled '1'
-	option retention_days '90'
-	option archive_path '/var/lib/hellfire/audit-archive'
+	if timeout, ok := section.GetOption("keepalive_timeout"); ok {
+		if t, err := strconv.Atoi(timeout); err == nil {
+			cfg.KeepaliveTimeout = t
+		}
+	}
 
-config ratelimit 'global'
-	option requests_per_minute '100'
-	option burst '100'
+	if require, ok := section.GetOption("require_rollback_reason"); ok {
+		cfg.RequireRollbackReason = require == "1" || strings.ToLower(require) == "true"
+	}
 
-config ratelimit 'auth'
-	option requests_per_minute '5'
-	option burst '5'
-`
+	if size, ok := section.GetOption("capture_size"); ok {
+		if n, err := strconv.Atoi(size); err == nil {
+			cfg.CaptureSize = n
+		}
+	}
 
-	return os.WriteFile(path, []byte(content), 0644)
+	return cfg
 }
 
-// Validate validates the configuration
-func (c *Config) Validate() error {
-	if c.API.Port < 1 || c.API.Port > 65535 {
-		return fmt.Errorf("invalid API port: %d", c.API.Port)
+func loadGRPCConfig(section *uci.Section) GRPCConfig {
+	cfg := defaultGRPCConfig()
+
+	if enabled, ok := section.GetOption("enabled"); ok {
+		cfg.Enabled = enabled == "1" || strings.ToLower(enabled) == "true"
 	}
 
-	if c.Security.MinPasswordLength < 8 {
-		return fmt.Errorf("minimum password length must be at least 8")
+	if port, ok := section.GetOption("port"); ok {
+		if p, err := strconv.Atoi(port); err == nil {
+			cfg.Port = p
+		}
 	}
 
-	if c.Security.SessionTimeout < 300 {
-		return fmt.Errorf("session timeout must be at least 300 seconds (5 minutes)")
+	if certFile, ok := section.GetOption("cert_file"); ok {
+		cfg.CertFile = certFile
 	}
 
-	if c.Security.AbsoluteTimeout < c.Security.SessionTimeout {
-		return fmt.Errorf("absolute timeout must be >= session timeout")
+	if keyFile, ok := section.GetOption("key_file"); ok {
+		cfg.KeyFile = keyFile
 	}
 
-	if c.Audit.RetentionDays < 1 {
-		return fmt.Errorf("audit retention must be at least 1 day")
+	if clientCA, ok := section.GetOption("client_ca_file"); ok {
+		cfg.ClientCAFile = clientCA
 	}
 
-	if c.RateLimit.GlobalRequestsPerMinute < 1 {
-		return fmt.Errorf("global rate limit must be at least 1 request per minute")
+	if allowInsecure, ok := section.GetOption("allow_insecure"); ok {
+		cfg.AllowInsecure = allowInsecure == "1" || strings.ToLower(allowInsecure) == "true"
 	}
 
-	if c.RateLimit.AuthRequestsPerMinute < 1 {
-		return fmt.Errorf("auth rate limit must be at least 1 request per minute")
+	if vrf, ok := section.GetOption("vrf"); ok {
+		cfg.VRF = vrf
+	}
+
+	return cfg
+}
+
+func loadLoggingConfig(section *uci.Section) LoggingConfig {
+	cfg := defaultLoggingConfig()
+
+	if level, ok := section.GetOption("level"); ok {
+		cfg.Level = level
+	}
+
+	if format, ok := section.GetOption("format"); ok {
+		cfg.Format = format
+	}
+
+	if file, ok := section.GetOption("file"); ok {
+		cfg.File = file
+	}
+
+	if size, ok := section.GetOption("max_size"); ok {
+		if n, err := strconv.Atoi(size); err == nil {
+			cfg.MaxSize = n
+		}
 	}
 
+	if backups, ok := section.GetOption("max_backups"); ok {
+		if n, err := strconv.Atoi(backups); err == nil {
+			cfg.MaxBackups = n
+		}
+	}
+
+	return cfg
+}
+
+func loadEventsConfig(section *uci.Section) EventsConfig {
+	cfg := defaultEventsConfig()
+
+	if size, ok := section.GetOption("queue_size"); ok {
+		if n, err := strconv.Atoi(size); err == nil {
+			cfg.QueueSize = n
+		}
+	}
+
+	if overflow, ok := section.GetOption("overflow"); ok {
+		cfg.Overflow = overflow
+	}
+
+	if dir, ok := section.GetOption("spool_dir"); ok {
+		cfg.SpoolDir = dir
+	}
+
+	return cfg
+}
+
+func loadRecoveryConfig(section *uci.Section) RecoveryConfig {
+	cfg := defaultRecoveryConfig()
+
+	if enabled, ok := section.GetOption("enabled"); ok {
+		cfg.Enabled = enabled == "1" || strings.ToLower(enabled) == "true"
+	}
+
+	if iface, ok := section.GetOption("interface"); ok {
+		cfg.Interface = iface
+	}
+
+	if ipaddr, ok := section.GetOption("ipaddr"); ok {
+		cfg.IPAddr = ipaddr
+	}
+
+	if netmask, ok := section.GetOption("netmask"); ok {
+		cfg.Netmask = netmask
+	}
+
+	if ports := section.GetList("allow_port"); len(ports) > 0 {
+		cfg.AllowPorts = nil
+		for _, port := range ports {
+			p, err := strconv.Atoi(port)
+			if err != nil {
+				logger.Warn("Ignoring invalid recovery allow_port", "value", port)
+				continue
+			}
+			cfg.AllowPorts = append(cfg.AllowPorts, p)
+		}
+	}
+
+	if stateFile, ok := section.GetOption("state_file"); ok {
+		cfg.StateFile = stateFile
+	}
+
+	return cfg
+}
+
+func loadProbeConfig(section *uci.Section) ProbeConfig {
+	cfg := defaultProbeConfig()
+
+	if enabled, ok := section.GetOption("enabled"); ok {
+		cfg.Enabled = enabled == "1" || strings.ToLower(enabled) == "true"
+	}
+
+	for option, target := range map[string]*int{
+		"delay":    &cfg.Delay,
+		"interval": &cfg.Interval,
+		"timeout":  &cfg.Timeout,
+		"failures": &cfg.Failures,
+	} {
+		if value, ok := section.GetOption(option); ok {
+			if n, err := strconv.Atoi(value); err == nil {
+				*target = n
+			}
+		}
+	}
+
+	if gateway, ok := section.GetOption("gateway"); ok {
+		cfg.Gateway = gateway == "1" || strings.ToLower(gateway) == "true"
+	}
+
+	if name, ok := section.GetOption("dns_name"); ok {
+		cfg.DNSName = name
+	}
+
+	cfg.Endpoints = section.GetList("endpoint")
+
+	if address, ok := section.GetOption("api_address"); ok {
+		cfg.APIAddress = address
+	}
+
+	return cfg
+}
+
+func defaultAPIConfig() APIConfig {
+	return APIConfig{
+		Port:              DefaultAPIPort,
+		EnableCORS:        DefaultEnableCORS,
+		CORSAllowSelf:     DefaultCORSAllowSelf,
+		CORSMaxAge:        DefaultCORSMaxAge,
+		EnableCompression: DefaultEnableCompression,
+		EnableGraphQL:     DefaultEnableGraphQL,
+		AllowedOrigins: []string{
+			"http://localhost:5173", // Default Vite dev server
+			"https://router.local",  // Default production
+		},
+		MaxBodySize:       DefaultMaxBodySize,
+		ReadHeaderTimeout: DefaultReadHeaderTimeout,
+		ReadTimeout:       DefaultReadTimeout,
+		WriteTimeout:      DefaultWriteTimeout,
+		IdleTimeout:       DefaultIdleTimeout,
+		RequestTimeout:    DefaultRequestTimeout,
+		RouteTimeouts: map[string]int{
+			"/config/commit":          DefaultApplyRouteTimeout,
+			"/snapshots/:id/rollback": DefaultApplyRouteTimeout,
+			"/system/upgrade":         DefaultUpgradeTimeout,
+		},
+	}
+}
+
+func defaultSecurityConfig() SecurityConfig {
+	return SecurityConfig{
+		MinPasswordLength:      DefaultMinPasswordLength,
+		PasswordRequireUpper:   true,
+		PasswordRequireLower:   true,
+		PasswordRequireNumber:  true,
+		PasswordRequireSpecial: true,
+		SessionTimeout:         DefaultSessionTimeout,
+		AbsoluteTimeout:        DefaultAbsoluteTimeout,
+		MaxFailedLogins:        DefaultMaxFailedLogins,
+		EnableSwagger:          DefaultEnableSwagger,
+		OnboardingTokenTTL:     DefaultOnboardingTTL,
+		NewDeviceAlert:         true,
+	}
+}
+
+func defaultAuditConfig() AuditConfig {
+	return AuditConfig{
+		Enabled:       true,
+		RetentionDays: DefaultRetentionDays,
+		ArchivePath:   "/var/lib/hellfire/audit-archive",
+	}
+}
+
+func defaultRateLimitConfig() RateLimitConfig {
+	return RateLimitConfig{
+		GlobalRequestsPerMinute: DefaultGlobalRateLimit,
+		GlobalBurst:             DefaultGlobalRateLimit,
+		AuthRequestsPerMinute:   DefaultAuthRateLimit,
+		AuthBurst:               DefaultAuthRateLimit,
+		Classes: map[string]RateLimitClass{
+			// Reads are only bounded by the global per-IP limit, plus a per-user cap
+			"read":        {UserRequestsPerMinute: 300, UserBurst: 100},
+			"write":       {RequestsPerMinute: 30, Burst: 10, UserRequestsPerMinute: 60, UserBurst: 20},
+			"diagnostics": {RequestsPerMinute: 10, Burst: 5, UserRequestsPerMinute: 20, UserBurst: 10},
+		},
+	}
+}
+
+func defaultTransactionConfig() TransactionConfig {
+	return TransactionConfig{
+		ApplyOrder:       []string{"network", "firewall", "dhcp"},
+		SkipApply:        []string{"hellfire"},
+		KeepaliveTimeout: DefaultKeepaliveTimeout,
+		CaptureSize:      DefaultCaptureSize,
+	}
+}
+
+func defaultGRPCConfig() GRPCConfig {
+	return GRPCConfig{
+		Enabled: false,
+		Port:    DefaultGRPCPort,
+	}
+}
+
+func loadManagementConfig(section *uci.Section) ManagementConfig {
+	cfg := defaultManagementConfig()
+
+	if allow, ok := section.GetOption("allow_ssh"); ok {
+		cfg.AllowSSH = allow == "1" || strings.ToLower(allow) == "true"
+	}
+
+	if port, ok := section.GetOption("ssh_port"); ok {
+		if p, err := strconv.Atoi(port); err == nil {
+			cfg.SSHPort = p
+		}
+	}
+
+	cfg.SSHZones = section.GetList("ssh_zone")
+	cfg.SSHInterfaces = section.GetList("ssh_interface")
+
+	if antiLockout, ok := section.GetOption("anti_lockout"); ok {
+		cfg.AntiLockout = antiLockout == "1" || strings.ToLower(antiLockout) == "true"
+	}
+
+	return cfg
+}
+
+func loadSPAConfig(section *uci.Section) SPAConfig {
+	cfg := defaultSPAConfig()
+
+	if enabled, ok := section.GetOption("enabled"); ok {
+		cfg.Enabled = enabled == "1" || strings.ToLower(enabled) == "true"
+	}
+
+	for option, target := range map[string]*int{
+		"port":         &cfg.Port,
+		"open_timeout": &cfg.OpenTimeout,
+		"max_skew":     &cfg.MaxSkew,
+	} {
+		if value, ok := section.GetOption(option); ok {
+			if n, err := strconv.Atoi(value); err == nil {
+				*target = n
+			}
+		}
+	}
+
+	if keyFile, ok := section.GetOption("key_file"); ok {
+		cfg.KeyFile = keyFile
+	}
+
+	if protect, ok := section.GetOption("protect_ssh"); ok {
+		cfg.ProtectSSH = protect == "1" || strings.ToLower(protect) == "true"
+	}
+
+	if allowNAT, ok := section.GetOption("allow_nat"); ok {
+		cfg.AllowNAT = allowNAT == "1" || strings.ToLower(allowNAT) == "true"
+	}
+
+	return cfg
+}
+
+func loadWANConfig(section *uci.Section) WANConfig {
+	cfg := defaultWANConfig()
+
+	if enabled, ok := section.GetOption("enabled"); ok {
+		cfg.Enabled = enabled == "1" || strings.ToLower(enabled) == "true"
+	}
+
+	for option, target := range map[string]*int{
+		"interval":           &cfg.Interval,
+		"pings":              &cfg.Pings,
+		"speedtest_interval": &cfg.SpeedInterval,
+		"max_latency":        &cfg.MaxLatency,
+		"max_jitter":         &cfg.MaxJitter,
+		"max_loss":           &cfg.MaxLoss,
+		"retention_days":     &cfg.RetentionDays,
+	} {
+		if value, ok := section.GetOption(option); ok {
+			if n, err := strconv.Atoi(value); err == nil {
+				*target = n
+			}
+		}
+	}
+
+	if targets := section.GetList("target"); len(targets) > 0 {
+		cfg.Targets = targets
+	}
+	if tool, ok := section.GetOption("speedtest_tool"); ok {
+		cfg.SpeedTool = strings.ToLower(tool)
+	}
+	if server, ok := section.GetOption("iperf_server"); ok {
+		cfg.IperfServer = server
+	}
+
+	return cfg
+}
+
+func loadCertsConfig(section *uci.Section) CertsConfig {
+	cfg := defaultCertsConfig()
+
+	if dir, ok := section.GetOption("store_dir"); ok {
+		cfg.StoreDir = dir
+	}
+
+	if days, ok := section.GetOption("warn_days"); ok {
+		if d, err := strconv.Atoi(days); err == nil {
+			cfg.WarnDays = d
+		}
+	}
+
+	return cfg
+}
+
+func loadSnapshotsConfig(section *uci.Section) SnapshotsConfig {
+	cfg := defaultSnapshotsConfig()
+
+	if free, ok := section.GetOption("min_free"); ok {
+		if n, err := strconv.Atoi(free); err == nil {
+			cfg.MinFree = n
+		}
+	}
+
+	if quota, ok := section.GetOption("quota"); ok {
+		if n, err := strconv.Atoi(quota); err == nil {
+			cfg.Quota = n
+		}
+	}
+
+	return cfg
+}
+
+func defaultLoggingConfig() LoggingConfig {
+	return LoggingConfig{
+		Level:      DefaultLogLevel,
+		Format:     DefaultLogFormat,
+		MaxSize:    DefaultLogMaxSize,
+		MaxBackups: DefaultLogMaxBackups,
+	}
+}
+
+func defaultEventsConfig() EventsConfig {
+	return EventsConfig{
+		QueueSize: DefaultEventQueueSize,
+		Overflow:  DefaultEventOverflow,
+		SpoolDir:  DefaultEventSpoolDir,
+	}
+}
+
+func defaultRecoveryConfig() RecoveryConfig {
+	return RecoveryConfig{
+		Enabled:    false,
+		Interface:  DefaultRecoveryInterface,
+		IPAddr:     DefaultRecoveryIPAddr,
+		Netmask:    DefaultRecoveryNetmask,
+		AllowPorts: []int{22},
+		StateFile:  DefaultRecoveryStateFile,
+	}
+}
+
+func defaultProbeConfig() ProbeConfig {
+	return ProbeConfig{
+		Enabled:  false,
+		Delay:    DefaultProbeDelay,
+		Interval: DefaultProbeInterval,
+		Timeout:  DefaultProbeTimeout,
+		Failures: DefaultProbeFailures,
+		Gateway:  true,
+	}
+}
+
+func defaultManagementConfig() ManagementConfig {
+	return ManagementConfig{
+		AllowSSH:    false,
+		SSHPort:     DefaultSSHPort,
+		AntiLockout: true,
+	}
+}
+
+func defaultSPAConfig() SPAConfig {
+	return SPAConfig{
+		Enabled:     false,
+		Port:        DefaultSPAPort,
+		KeyFile:     DefaultSPAKeyFile,
+		OpenTimeout: DefaultSPAOpenTimeout,
+		MaxSkew:     DefaultSPAMaxSkew,
+		ProtectSSH:  true,
+	}
+}
+
+func defaultWANConfig() WANConfig {
+	return WANConfig{
+		Enabled:       false,
+		Interval:      DefaultWANInterval,
+		Targets:       []string{"1.1.1.1", "8.8.8.8"},
+		Pings:         DefaultWANPings,
+		SpeedTool:     DefaultWANSpeedTool,
+		MaxLatency:    DefaultWANMaxLatency,
+		MaxJitter:     DefaultWANMaxJitter,
+		MaxLoss:       DefaultWANMaxLoss,
+		RetentionDays: DefaultWANRetentionDays,
+	}
+}
+
+// WANQuality returns the WAN quality monitor settings
+func (c *Config) WANQuality() wanquality.Config {
+	return wanquality.Config{
+		Interval:      time.Duration(c.WAN.Interval) * time.Second,
+		Targets:       c.WAN.Targets,
+		Pings:         c.WAN.Pings,
+		SpeedInterval: time.Duration(c.WAN.SpeedInterval) * time.Second,
+		SpeedTool:     c.WAN.SpeedTool,
+		IperfServer:   c.WAN.IperfServer,
+		MaxLatency:    float64(c.WAN.MaxLatency),
+		MaxJitter:     float64(c.WAN.MaxJitter),
+		MaxLoss:       float64(c.WAN.MaxLoss),
+		Retention:     time.Duration(c.WAN.RetentionDays) * 24 * time.Hour,
+	}
+}
+
+func loadSyslogConfig(section *uci.Section) SyslogConfig {
+	cfg := defaultSyslogConfig()
+
+	if enabled, ok := section.GetOption("enabled"); ok {
+		cfg.Enabled = enabled == "1" || strings.ToLower(enabled) == "true"
+	}
+	if listen, ok := section.GetOption("listen"); ok {
+		cfg.Listen = listen
+	}
+	if protocol, ok := section.GetOption("protocol"); ok {
+		cfg.Protocol = strings.ToLower(protocol)
+	}
+	if size, ok := section.GetOption("buffer_size"); ok {
+		if n, err := strconv.Atoi(size); err == nil {
+			cfg.BufferSize = n
+		}
+	}
+
+	return cfg
+}
+
+func defaultSyslogConfig() SyslogConfig {
+	return SyslogConfig{
+		Enabled:    false,
+		Listen:     DefaultSyslogListen,
+		Protocol:   DefaultSyslogProtocol,
+		BufferSize: DefaultSyslogBufferSize,
+	}
+}
+
+// SyslogCollector returns the syslog collector settings
+func (c *Config) SyslogCollector() syslog.Config {
+	return syslog.Config{
+		Listen:     c.Syslog.Listen,
+		Protocol:   c.Syslog.Protocol,
+		BufferSize: c.Syslog.BufferSize,
+	}
+}
+
+func loadIndicatorConfig(section *uci.Section) IndicatorConfig {
+	var cfg IndicatorConfig
+
+	if enabled, ok := section.GetOption("enabled"); ok {
+		cfg.Enabled = enabled == "1" || strings.ToLower(enabled) == "true"
+	}
+	for option, target := range map[string]*string{
+		"script":        &cfg.Script,
+		"unit":          &cfg.Unit,
+		"rollback_unit": &cfg.RollbackUnit,
+		"led":           &cfg.LED,
+	} {
+		if value, ok := section.GetOption(option); ok {
+			*target = value
+		}
+	}
+
+	return cfg
+}
+
+func defaultCanaryConfig() CanaryConfig {
+	return CanaryConfig{
+		Username:       "admin",
+		Settle:         30,
+		MinScore:       80,
+		ConfirmTimeout: 300,
+	}
+}
+
+func loadCanaryConfig(section *uci.Section) CanaryConfig {
+	cfg := defaultCanaryConfig()
+
+	if enabled, ok := section.GetOption("enabled"); ok {
+		cfg.Enabled = enabled == "1" || strings.ToLower(enabled) == "true"
+	}
+	for option, target := range map[string]*string{
+		"url":           &cfg.URL,
+		"username":      &cfg.Username,
+		"password_file": &cfg.PasswordFile,
+		"ca_file":       &cfg.CAFile,
+	} {
+		if value, ok := section.GetOption(option); ok {
+			*target = value
+		}
+	}
+	for option, target := range map[string]*int{
+		"settle":          &cfg.Settle,
+		"min_score":       &cfg.MinScore,
+		"confirm_timeout": &cfg.ConfirmTimeout,
+	} {
+		if value, ok := section.GetOption(option); ok {
+			if n, err := strconv.Atoi(value); err == nil {
+				*target = n
+			}
+		}
+	}
+
+	return cfg
+}
+
+func loadHeadersConfig(section *uci.Section) HeadersConfig {
+	cfg := HeadersConfig{Overrides: make(map[string]string)}
+	for _, name := range SecurityHeaderOptions {
+		if value, ok := section.GetOption(name); ok {
+			cfg.Overrides[name] = value
+		}
+	}
+	return cfg
+}
+
+func loadConfigACL(section *uci.Section) ConfigACL {
+	acl := ConfigACL{
+		Read:  section.GetList("read"),
+		Write: section.GetList("write"),
+	}
+	acl.Config, _ = section.GetOption("config")
+	acl.Section, _ = section.GetOption("section")
+	return acl
+}
+
+// ConfirmIndicator returns the confirmation indicator settings
+func (c *Config) ConfirmIndicator() indicator.Config {
+	return indicator.Config{
+		Script:       c.Indicator.Script,
+		Unit:         c.Indicator.Unit,
+		RollbackUnit: c.Indicator.RollbackUnit,
+		LED:          c.Indicator.LED,
+	}
+}
+
+// CanaryPeer returns the router commits are applied to first, or nil if
+// there is none or the system is simulated
+func (c *Config) CanaryPeer() (*canary.Peer, error) {
+	if !c.Canary.Enabled || c.Transaction.Simulate {
+		return nil, nil
+	}
+
+	password, err := os.ReadFile(c.Canary.PasswordFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read canary password: %w", err)
+	}
+
+	cfg := canary.Config{
+		URL:            strings.TrimSuffix(c.Canary.URL, "/"),
+		Username:       c.Canary.Username,
+		Password:       strings.TrimSpace(string(password)),
+		ConfirmTimeout: time.Duration(c.Canary.ConfirmTimeout) * time.Second,
+		Settle:         time.Duration(c.Canary.Settle) * time.Second,
+		MinScore:       c.Canary.MinScore,
+	}
+	if c.Canary.CAFile != "" {
+		pem, err := os.ReadFile(c.Canary.CAFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read canary CA: %w", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates in canary CA file %s", c.Canary.CAFile)
+		}
+		cfg.HTTPClient = &http.Client{
+			Timeout:   time.Minute,
+			Transport: &http.Transport{TLSClientConfig: &tls.Config{RootCAs: pool, MinVersion: tls.VersionTLS12}},
+		}
+	}
+
+	return canary.New(cfg), nil
+}
+
+func defaultSnapshotsConfig() SnapshotsConfig {
+	return SnapshotsConfig{
+		MinFree: DefaultSnapshotMinFree,
+	}
+}
+
+func defaultCertsConfig() CertsConfig {
+	return CertsConfig{
+		StoreDir: DefaultCertStoreDir,
+		WarnDays: DefaultCertWarnDays,
+	}
+}
+
+// SnapshotLimits returns the free space required to take a snapshot and the
+// quota of the snapshots, in bytes
+func (c *Config) SnapshotLimits() (minFree, quota uint64) {
+	return uint64(c.Snapshots.MinFree) << 20, uint64(c.Snapshots.Quota) << 20
+}
+
+// CertificateReferences returns the certificate files the config refers to
+func (c *Config) CertificateReferences() []certs.Reference {
+	var refs []certs.Reference
+	if c.GRPC.CertFile != "" {
+		refs = append(refs, certs.Reference{
+			Name:     "grpc-server",
+			Usage:    "grpc-server",
+			CertFile: c.GRPC.CertFile,
+			KeyFile:  c.GRPC.KeyFile,
+		})
+	}
+	if c.GRPC.ClientCAFile != "" {
+		refs = append(refs, certs.Reference{
+			Name:     "grpc-client-ca",
+			Usage:    "grpc-client-ca",
+			CertFile: c.GRPC.ClientCAFile,
+		})
+	}
+	return refs
+}
+
+// ConfirmProbes returns the probes and schedule for the transaction manager,
+// or no probes if they are disabled or the system is simulated
+func (c *Config) ConfirmProbes() ([]probe.Probe, probe.Options) {
+	opts := probe.Options{
+		Delay:    time.Duration(c.Probe.Delay) * time.Second,
+		Interval: time.Duration(c.Probe.Interval) * time.Second,
+		Timeout:  time.Duration(c.Probe.Timeout) * time.Second,
+		Failures: c.Probe.Failures,
+	}
+	if !c.Probe.Enabled || c.Transaction.Simulate {
+		return nil, opts
+	}
+
+	var probes []probe.Probe
+	if c.Probe.Gateway {
+		probes = append(probes, probe.Gateway{})
+	}
+	if c.Probe.DNSName != "" {
+		probes = append(probes, probe.DNS{Host: c.Probe.DNSName})
+	}
+	for _, endpoint := range c.Probe.Endpoints {
+		probes = append(probes, probe.Endpoint{Target: endpoint})
+	}
+	if c.Probe.APIAddress != "" {
+		probes = append(probes, probe.Listener{
+			Address: net.JoinHostPort(c.Probe.APIAddress, strconv.Itoa(c.API.Port)),
+		})
+	}
+
+	return probes, opts
+}
+
+// RecoveryNetwork returns the recovery network for the transaction manager,
+// accepting the management API ports as well, or nil if it is disabled or
+// the system is simulated
+func (c *Config) RecoveryNetwork() *appliers.RecoveryConfig {
+	if !c.Recovery.Enabled || c.Transaction.Simulate {
+		return nil
+	}
+
+	ports := append([]int{}, c.Recovery.AllowPorts...)
+	for _, port := range c.ManagementPorts() {
+		if !slices.Contains(ports, port) {
+			ports = append(ports, port)
+		}
+	}
+
+	return &appliers.RecoveryConfig{
+		Interface: c.Recovery.Interface,
+		IPAddr:    c.Recovery.IPAddr,
+		Netmask:   c.Recovery.Netmask,
+		Ports:     ports,
+	}
+}
+
+// RecoveryStateFile returns the file the degraded flag is persisted to; a
+// simulated system keeps it in memory so it doesn't flag the real one
+func (c *Config) RecoveryStateFile() string {
+	if c.Transaction.Simulate {
+		return ""
+	}
+	return c.Recovery.StateFile
+}
+
+// ManagementAccess returns the management plane rules for the firewall
+// applier: the API and gRPC ports only from the API's allowed zones and
+// interfaces (when any are set), SSH if allowed, the anti-lockout rule and
+// the single-packet authorization gate.
+// It returns nil when there is nothing to generate.
+func (c *Config) ManagementAccess() *appliers.ManagementAccess {
+	access := &appliers.ManagementAccess{}
+	ports := c.ManagementPorts()
+
+	if len(c.API.AllowedZones) > 0 || len(c.API.AllowedInterfaces) > 0 {
+		access.Services = append(access.Services, appliers.ManagementService{
+			Name:       "api",
+			Ports:      ports,
+			Zones:      c.API.AllowedZones,
+			Interfaces: c.API.AllowedInterfaces,
+		})
+	}
+
+	if c.Management.AllowSSH {
+		access.Services = append(access.Services, appliers.ManagementService{
+			Name:       "ssh",
+			Ports:      []int{c.Management.SSHPort},
+			Zones:      c.Management.SSHZones,
+			Interfaces: c.Management.SSHInterfaces,
+		})
+	}
+
+	if c.Management.AntiLockout {
+		access.AntiLockout = append(slices.Clone(ports), c.Management.SSHPort)
+		slices.Sort(access.AntiLockout)
+		access.AntiLockout = slices.Compact(access.AntiLockout)
+	}
+
+	if c.SPA.Enabled {
+		gated := slices.Clone(ports)
+		if c.SPA.ProtectSSH && !slices.Contains(gated, c.Management.SSHPort) {
+			gated = append(gated, c.Management.SSHPort)
+		}
+		access.SPA = &appliers.SPAGate{
+			ListenPort: c.SPA.Port,
+			Ports:      gated,
+		}
+	}
+
+	if len(access.Services) == 0 && len(access.AntiLockout) == 0 && access.SPA == nil {
+		return nil
+	}
+	return access
+}
+
+// SaveTransactionConfig persists the transaction section into the Hellfire
+// config file. Only the order and skip lists of the section are rewritten;
+// every other line, comments included, is kept as it is.
+func SaveTransactionConfig(path string, txCfg TransactionConfig) error {
+	if path == "" {
+		path = DefaultConfigPath
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("failed to open %s: %w", path, err)
+	}
+	if _, err := uci.Parse(bytes.NewReader(data)); err != nil {
+		return fmt.Errorf("failed to parse %s: %w", path, err)
+	}
+
+	section := uci.NewSection("transaction", "apply")
+	section.Lists["order"] = append([]string{}, txCfg.ApplyOrder...)
+	section.Lists["skip"] = append([]string{}, txCfg.SkipApply...)
+	var buf bytes.Buffer
+	cfg := uci.NewConfig()
+	cfg.AddSection(section)
+	if err := uci.Write(&buf, cfg); err != nil {
+		return fmt.Errorf("failed to write config: %w", err)
+	}
+	header, lists, _ := strings.Cut(buf.String(), "\n")
+
+	tmpPath := path + ".tmp"
+	content := []byte(replaceTransactionLists(string(data), header, lists))
+	if err := os.WriteFile(tmpPath, content, 0644); err != nil {
+		os.Remove(tmpPath)
+		return fmt.Errorf("failed to write config: %w", err)
+	}
+
+	if err := os.Rename(tmpPath, path); err != nil {
+		os.Remove(tmpPath)
+		return fmt.Errorf("failed to save config: %w", err)
+	}
+
+	return nil
+}
+
+// replaceTransactionLists swaps the order and skip list lines of the
+// transaction 'apply' section in content for lists, placing them after the
+// section's last setting, or appends the section when the file has none
+func replaceTransactionLists(content, header, lists string) string {
+	var kept []string
+	inSection, found := false, false
+	insertAt := -1 // Index in kept the lists go before
+	for _, line := range strings.SplitAfter(content, "\n") {
+		if line == "" {
+			continue
+		}
+		if !strings.HasSuffix(line, "\n") {
+			line += "\n"
+		}
+
+		fields := strings.Fields(line)
+		if len(fields) > 0 && fields[0] == "config" {
+			inSection = len(fields) >= 3 && fields[1] == "transaction" && strings.Trim(fields[2], `'"`) == "apply"
+			found = found || inSection
+		}
+		if !inSection || len(fields) == 0 || strings.HasPrefix(fields[0], "#") {
+			kept = append(kept, line)
+			continue
+		}
+		if len(fields) >= 2 && fields[0] == "list" {
+			if key := strings.Trim(fields[1], `'"`); key == "order" || key == "skip" {
+				continue
+			}
+		}
+		kept = append(kept, line)
+		insertAt = len(kept)
+	}
+
+	if !found {
+		if len(kept) > 0 {
+			kept = append(kept, "\n")
+		}
+		return strings.Join(kept, "") + header + "\n" + lists
+	}
+	return strings.Join(kept[:insertAt], "") + lists + strings.Join(kept[insertAt:], "")
+}
+
+// CreateDefaultConfig creates a default Hellfire config file
+func CreateDefaultConfig(path string) error {
+	if path == "" {
+		path = DefaultConfigPath
+	}
+
+	content := `# Hellfire Configuration
+# This file configures Hellfire itself using UCI format
+
+config api 'server'
+	option port '8888'
+	option enable_cors '1'
+	option compression '1'
+	option graphql '0'
+	list allowed_origins 'http://localhost:5173'
+	list allowed_origins 'https://router.local'
+	# list allowed_origins 'https://*.example.com'
+	option cors_allow_self '1'
+	option cors_max_age '600'
+	# option web_root '/usr/share/hellfire/web'
+	# list listen '192.168.1.1:8888'
+	# list listen 'unix:/run/hellfire/api.sock'
+	# option vrf 'mgmt'
+	# list allow_zone 'lan'
+	option max_body_size '1048576'
+	option read_timeout '30'
+	option write_timeout '60'
+	option request_timeout '30'
+	list route_timeout '/config/commit=300'
+	list route_timeout '/snapshots/:id/rollback=300'
+	list route_timeout '/system/upgrade=3600'
+
+config security 'settings'
+	option min_password_length '12'
+	option password_require_upper '1'
+	option password_require_lower '1'
+	option password_require_number '1'
+	option password_require_special '1'
+	# option banned_passwords_file '/etc/hellfire/banned-passwords.txt'
+	option session_timeout '86400'
+	option absolute_session_timeout '604800'
+	option max_failed_logins '5'
+	option enable_swagger '0'
+	option onboarding_token_ttl '1800'
+	option new_device_alert '1'
+
+config audit 'retention'
+	option enabled '1'
+	option retention_days '90'
+	option archive_path '/var/lib/hellfire/audit-archive'
+
+config ratelimit 'global'
+	option requests_per_minute '100'
+	option burst '100'
+
+config ratelimit 'auth'
+	option requests_per_minute '5'
+	option burst '5'
+
+config ratelimit 'write'
+	option requests_per_minute '30'
+	option burst '10'
+	option user_requests_per_minute '60'
+	option user_burst '20'
+
+config transaction 'apply'
+	list order 'network'
+	list order 'firewall'
+	list order 'dhcp'
+	list skip 'hellfire'
+	# option safe_remote '1'
+	option keepalive_timeout '30'
+	# option require_rollback_reason '1'
+	option capture_size '1024'
+
+config grpc 'server'
+	option enabled '0'
+	option port '8889'
+	# option cert_file '/etc/hellfire/grpc.crt'
+	# option key_file '/etc/hellfire/grpc.key'
+	# option client_ca_file '/etc/hellfire/clients-ca.crt'
+	# option vrf 'mgmt'
+
+config logging 'settings'
+	option level 'info'
+	option format 'json'
+	# option file '/var/log/hellfire/hellfire.log'
+	option max_size '10'
+	option max_backups '3'
+
+config events 'bus'
+	option queue_size '100'
+	# block, drop or disk
+	option overflow 'block'
+	option spool_dir '/var/lib/hellfire/events'
+
+# Applied when a rollback fails, so the router stays reachable; the system
+# is flagged degraded until a transaction succeeds or the flag is cleared.
+# Set the interface and address to this router's LAN before enabling it.
+config recovery 'safemode'
+	option enabled '0'
+	option interface 'br-lan'
+	option ipaddr '192.168.1.1'
+	option netmask '255.255.255.0'
+	list allow_port '22'
+	option state_file '/var/lib/hellfire/degraded.json'
+
+# While a commit awaits confirmation, roll it back as soon as these checks
+# keep failing instead of waiting for the confirmation timeout
+config probe 'confirm'
+	option enabled '0'
+	option delay '5'
+	option interval '10'
+	option timeout '3'
+	option failures '3'
+	option gateway '1'
+	# option dns_name 'example.com'
+	# list endpoint 'https://example.com/'
+	# option api_address '192.168.1.1'
+
+# Input rules protecting Hellfire itself; the API and gRPC ports are limited
+# by allow_zone/allow_interface in the api section
+config management 'firewall'
+	option allow_ssh '0'
+	option ssh_port '22'
+	# list ssh_zone 'lan'
+	# list ssh_interface 'br-lan'
+	# Always accept the client committing a change on the management ports
+	option anti_lockout '1'
+
+# Keep the management ports closed until a client knocks with 'hf knock'
+config spa 'knock'
+	option enabled '0'
+	option port '62201'
+	option key_file '/etc/hellfire/spa.key'
+	option open_timeout '60'
+	option max_skew '30'
+	option protect_ssh '1'
+	# Accept 'hf knock --any-source' from clients behind a NAT; such a knock
+	# opens the ports to whoever sends it first
+	option allow_nat '0'
+
+# Ping targets on a schedule and record latency, jitter and loss; optionally
+# run throughput tests. History is served at /api/v1/wan/quality
+config wan 'quality'
+	option enabled '0'
+	option interval '60'
+	list target '1.1.1.1'
+	list target '8.8.8.8'
+	option pings '5'
+	option speedtest_interval '0'
+	option speedtest_tool 'speedtest'
+	# option iperf_server 'iperf.example.com:5201'
+	option max_latency '150'
+	option max_jitter '30'
+	option max_loss '5'
+	option retention_days '30'
+
+# Certificates are listed at /api/v1/certs; uploads are stored in store_dir.
+# Certificates expiring within warn_days are reported daily
+config certs 'inventory'
+	option store_dir '/etc/hellfire/certs'
+	option warn_days '30'
+
+# Disk space of the configuration snapshots, in megabytes. Over the quota
+# the oldest snapshots are pruned, except protected ones.
+config snapshots 'storage'
+	option min_free '16'
+	# option quota '64'
+
+# Receive syslog messages (point rsyslog or other senders at listen) and
+# keep the most recent buffer_size in memory, served at /api/v1/logs
+config syslog 'collector'
+	option enabled '0'
+	option listen '127.0.0.1:5514'
+	option protocol 'udp'
+	option buffer_size '10000'
+
+# Blink an LED, start a unit or run a script while a commit awaits
+# confirmation, and again when unconfirmed changes are rolled back
+config indicator 'confirm'
+	option enabled '0'
+	# option led 'green:status'
+	# option script '/etc/hellfire/confirm-indicator'
+	# option unit 'hellfire-confirm-beep.service'
+	# option rollback_unit 'hellfire-rollback-beep.service'
+
+# Commit every change on a canary router (such as the HA standby) first: it
+# must still report a good health score after settling, and is confirmed
+# once this router applied the changes too, or rolled back if it can't
+config canary 'peer'
+	option enabled '0'
+	# option url 'https://192.168.1.2:8888'
+	# option username 'admin'
+	# option password_file '/etc/hellfire/canary-password'
+	# option ca_file '/etc/hellfire/canary-ca.crt'
+	option settle '30'
+	option min_score '80'
+	option confirm_timeout '300'
+
+# Override the security headers; an empty value leaves a header out.
+# __CSP_NONCE__ is replaced with a fresh nonce on every request.
+config headers 'security'
+	# option content_security_policy "default-src 'self'; script-src 'self' 'nonce-__CSP_NONCE__'; style-src 'self' 'unsafe-inline'; img-src 'self' data:"
+	# option frame_options 'SAMEORIGIN'
+	# option referrer_policy 'no-referrer'
+	# option permissions_policy 'camera=(), microphone=()'
+	# option hsts 'max-age=31536000; includeSubDomains'
+
+# Restrict configs, or sections of them ('@type' for every section of a
+# type), to roles in the API. Admins aren't restricted.
+# config acl
+#	option config 'wireless'
+#	list read 'operator'
+# config acl
+#	option config 'network'
+#	option section 'wan'
+#	list write 'admin'
+`
+
+	return os.WriteFile(path, []byte(content), 0644)
+}
+
+// Validate validates the configuration
+func (c *Config) Validate() error {
+	if c.API.Port < 1 || c.API.Port > 65535 {
+		return fmt.Errorf("invalid API port: %d", c.API.Port)
+	}
+
+	if err := c.API.validateServer(); err != nil {
+		return err
+	}
+
+	if c.Security.MinPasswordLength < 8 {
+		return fmt.Errorf("minimum password length must be at least 8")
+	}
+
+	if c.Security.MinPasswordLength > 72 {
+		return fmt.Errorf("minimum password length must be at most 72")
+	}
+
+	if c.Security.BannedPasswordsFile != "" && !filepath.IsAbs(c.Security.BannedPasswordsFile) {
+		return fmt.Errorf("banned_passwords_file must be an absolute path")
+	}
+
+	if c.Security.SessionTimeout < 300 {
+		return fmt.Errorf("session timeout must be at least 300 seconds (5 minutes)")
+	}
+
+	if c.Security.AbsoluteTimeout < c.Security.SessionTimeout {
+		return fmt.Errorf("absolute timeout must be >= session timeout")
+	}
+
+	if c.Security.OnboardingTokenTTL < 60 {
+		return fmt.Errorf("onboarding token TTL must be at least 60 seconds")
+	}
+
+	if c.Audit.RetentionDays < 1 {
+		return fmt.Errorf("audit retention must be at least 1 day")
+	}
+
+	if c.RateLimit.GlobalRequestsPerMinute < 1 {
+		return fmt.Errorf("global rate limit must be at least 1 request per minute")
+	}
+
+	if c.RateLimit.AuthRequestsPerMinute < 1 {
+		return fmt.Errorf("auth rate limit must be at least 1 request per minute")
+	}
+
+	for name, class := range c.RateLimit.Classes {
+		if class.RequestsPerMinute < 0 || class.Burst < 0 || class.UserRequestsPerMinute < 0 || class.UserBurst < 0 {
+			return fmt.Errorf("%s rate limit must not be negative", name)
+		}
+	}
+
+	for _, entry := range c.RateLimit.Exempt {
+		if _, _, err := net.ParseCIDR(entry); err != nil && net.ParseIP(entry) == nil {
+			return fmt.Errorf("invalid rate limit exemption %q: must be an IP address or CIDR", entry)
+		}
+	}
+
+	if _, err := logger.ParseLevel(c.Logging.Level); err != nil {
+		return err
+	}
+
+	if format := strings.ToLower(c.Logging.Format); format != logger.FormatJSON && format != logger.FormatText {
+		return fmt.Errorf("invalid log format: %s (must be json or text)", c.Logging.Format)
+	}
+
+	if c.Logging.File != "" && !filepath.IsAbs(c.Logging.File) {
+		return fmt.Errorf("log file path must be absolute")
+	}
+
+	if c.Logging.MaxSize < 0 || c.Logging.MaxBackups < 0 {
+		return fmt.Errorf("log max_size and max_backups must not be negative")
+	}
+
+	if c.Events.QueueSize < 1 {
+		return fmt.Errorf("event queue size must be at least 1")
+	}
+
+	if _, err := bus.ParseOverflowPolicy(c.Events.Overflow); err != nil {
+		return err
+	}
+
+	if c.Events.SpoolDir != "" && !filepath.IsAbs(c.Events.SpoolDir) {
+		return fmt.Errorf("event spool_dir must be an absolute path")
+	}
+
+	if strings.ToLower(c.Events.Overflow) == string(bus.OverflowDisk) && c.Events.SpoolDir == "" {
+		return fmt.Errorf("event overflow policy disk requires spool_dir")
+	}
+
+	if recovery := c.RecoveryNetwork(); recovery != nil {
+		if err := recovery.Validate(); err != nil {
+			return err
+		}
+	}
+
+	if c.Recovery.StateFile != "" && !filepath.IsAbs(c.Recovery.StateFile) {
+		return fmt.Errorf("recovery state_file must be an absolute path")
+	}
+
+	if err := c.Probe.validate(); err != nil {
+		return err
+	}
+
+	if err := c.Management.validate(); err != nil {
+		return err
+	}
+
+	if err := c.SPA.validate(); err != nil {
+		return err
+	}
+
+	if err := c.WAN.validate(); err != nil {
+		return err
+	}
+
+	if err := c.Certs.validate(); err != nil {
+		return err
+	}
+
+	if err := c.Syslog.validate(); err != nil {
+		return err
+	}
+
+	if err := c.Indicator.validate(); err != nil {
+		return err
+	}
+
+	if err := c.Canary.validate(); err != nil {
+		return err
+	}
+
+	if err := c.Headers.validate(); err != nil {
+		return err
+	}
+
+	for _, acl := range c.ACLs {
+		if err := acl.validate(); err != nil {
+			return err
+		}
+	}
+
+	seen := make(map[string]bool)
+	for _, name := range c.Transaction.ApplyOrder {
+		if name == "" {
+			return fmt.Errorf("apply order contains an empty config name")
+		}
+		if seen[name] {
+			return fmt.Errorf("apply order lists %s more than once", name)
+		}
+		seen[name] = true
+	}
+
+	if c.Transaction.KeepaliveTimeout < 1 || c.Transaction.KeepaliveTimeout > 3600 {
+		return fmt.Errorf("keepalive_timeout must be between 1 and 3600 seconds")
+	}
+
+	if c.Snapshots.MinFree < 0 {
+		return fmt.Errorf("snapshot min_free must not be negative")
+	}
+	if c.Snapshots.Quota < 0 {
+		return fmt.Errorf("snapshot quota must not be negative")
+	}
+
+	if c.Transaction.CaptureSize < 0 || c.Transaction.CaptureSize > 65536 {
+		return fmt.Errorf("capture_size must be between 0 and 65536 kilobytes")
+	}
+
+	if c.GRPC.Enabled {
+		if c.GRPC.Port < 1 || c.GRPC.Port > 65535 {
+			return fmt.Errorf("invalid gRPC port: %d", c.GRPC.Port)
+		}
+
+		if (c.GRPC.CertFile == "") != (c.GRPC.KeyFile == "") {
+			return fmt.Errorf("gRPC cert_file and key_file must be set together")
+		}
+
+		if c.GRPC.CertFile == "" && !c.GRPC.AllowInsecure {
+			return fmt.Errorf("gRPC requires cert_file and key_file (or allow_insecure '1')")
+		}
+
+		if c.GRPC.ClientCAFile != "" && c.GRPC.CertFile == "" {
+			return fmt.Errorf("gRPC client_ca_file requires TLS (cert_file and key_file)")
+		}
+
+		if c.GRPC.VRF != "" {
+			if err := util.ValidateInterfaceName(c.GRPC.VRF); err != nil {
+				return fmt.Errorf("invalid gRPC vrf: %w", err)
+			}
+		}
+	}
+
+	return nil
+}
+
+// validate checks the SSH port and access lists
+func (c ManagementConfig) validate() error {
+	if c.SSHPort < 1 || c.SSHPort > 65535 {
+		return fmt.Errorf("invalid management ssh_port: %d", c.SSHPort)
+	}
+
+	for _, zone := range c.SSHZones {
+		if zone == "" {
+			return fmt.Errorf("ssh zone name cannot be empty")
+		}
+	}
+
+	for _, iface := range c.SSHInterfaces {
+		if err := util.ValidateInterfaceName(iface); err != nil {
+			return fmt.Errorf("invalid ssh interface: %w", err)
+		}
+	}
+
+	return nil
+}
+
+// validate checks the knock port, key file and timeouts
+func (c SPAConfig) validate() error {
+	if c.Port < 1 || c.Port > 65535 {
+		return fmt.Errorf("invalid spa port: %d", c.Port)
+	}
+
+	if c.OpenTimeout < 1 || c.MaxSkew < 1 {
+		return fmt.Errorf("spa open_timeout and max_skew must be at least 1 second")
+	}
+
+	if c.Enabled && !filepath.IsAbs(c.KeyFile) {
+		return fmt.Errorf("spa key_file must be an absolute path")
+	}
+
+	return nil
+}
+
+// validate checks the WAN monitor schedule, targets and speed test tool
+func (c WANConfig) validate() error {
+	if c.Interval < 1 || c.Pings < 1 || c.SpeedInterval < 0 || c.RetentionDays < 0 {
+		return fmt.Errorf("wan interval and pings must be at least 1, speedtest_interval and retention_days must not be negative")
+	}
+
+	if c.Enabled && len(c.Targets) == 0 {
+		return fmt.Errorf("wan quality monitor needs at least one target")
+	}
+	for _, target := range c.Targets {
+		if err := util.ValidateHostname(target); err != nil && net.ParseIP(target) == nil {
+			return fmt.Errorf("invalid wan target %q", target)
+		}
+	}
+
+	switch c.SpeedTool {
+	case wanquality.ToolSpeedtest:
+	case wanquality.ToolIperf3:
+		if c.SpeedInterval > 0 && c.IperfServer == "" {
+			return fmt.Errorf("wan speedtest_tool iperf3 requires iperf_server")
+		}
+	default:
+		return fmt.Errorf("invalid wan speedtest_tool %q (must be speedtest or iperf3)", c.SpeedTool)
+	}
+
+	return nil
+}
+
+// validate checks the certificate store and warning period
+func (c CertsConfig) validate() error {
+	if !filepath.IsAbs(c.StoreDir) {
+		return fmt.Errorf("certs store_dir must be an absolute path")
+	}
+
+	if c.WarnDays < 1 {
+		return fmt.Errorf("certs warn_days must be at least 1")
+	}
+
+	return nil
+}
+
+// validate checks the syslog listen address, protocol and buffer size
+func (c SyslogConfig) validate() error {
+	host, port, err := net.SplitHostPort(c.Listen)
+	if err != nil {
+		return fmt.Errorf("invalid syslog listen address %q: %w", c.Listen, err)
+	}
+	if host != "" && net.ParseIP(host) == nil {
+		return fmt.Errorf("syslog listen host must be an IP address")
+	}
+	if n, err := strconv.Atoi(port); err != nil || n < 1 || n > 65535 {
+		return fmt.Errorf("invalid syslog listen port %q", port)
+	}
+
+	switch c.Protocol {
+	case syslog.ProtocolUDP, syslog.ProtocolTCP, syslog.ProtocolBoth:
+	default:
+		return fmt.Errorf("invalid syslog protocol %q (must be udp, tcp or both)", c.Protocol)
+	}
+
+	if c.BufferSize < 100 || c.BufferSize > 1000000 {
+		return fmt.Errorf("syslog buffer_size must be between 100 and 1000000")
+	}
+
+	return nil
+}
+
+// unitNamePattern matches the systemd units an indicator may start
+var unitNamePattern = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9@._:-]*\.(service|target)$`)
+
+// validate checks the indicator script path, unit and LED names
+func (c IndicatorConfig) validate() error {
+	if c.Script != "" && !filepath.IsAbs(c.Script) {
+		return fmt.Errorf("indicator script must be an absolute path")
+	}
+
+	for _, unit := range []string{c.Unit, c.RollbackUnit} {
+		if unit != "" && !unitNamePattern.MatchString(unit) {
+			return fmt.Errorf("invalid indicator unit name %q", unit)
+		}
+	}
+
+	if c.LED != "" && (strings.Contains(c.LED, "/") || c.LED == "." || c.LED == "..") {
+		return fmt.Errorf("invalid indicator led %q", c.LED)
+	}
+
+	return nil
+}
+
+// validate checks the canary address, credentials and health criteria
+func (c CanaryConfig) validate() error {
+	if !c.Enabled {
+		return nil
+	}
+
+	u, err := url.Parse(c.URL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return fmt.Errorf("canary url must be an http(s) URL, got %q", c.URL)
+	}
+	if c.Username == "" || c.PasswordFile == "" {
+		return fmt.Errorf("canary requires a username and password_file")
+	}
+	if !filepath.IsAbs(c.PasswordFile) || (c.CAFile != "" && !filepath.IsAbs(c.CAFile)) {
+		return fmt.Errorf("canary password_file and ca_file must be absolute paths")
+	}
+	if c.Settle < 0 || c.MinScore < 0 || c.MinScore > 100 {
+		return fmt.Errorf("canary settle must not be negative and min_score must be 0-100")
+	}
+	if c.ConfirmTimeout <= c.Settle {
+		return fmt.Errorf("canary confirm_timeout must be longer than settle")
+	}
+
+	return nil
+}
+
+// validate checks the probe schedule and targets
+func (c ProbeConfig) validate() error {
+	if c.Delay < 0 || c.Interval < 1 || c.Timeout < 1 {
+		return fmt.Errorf("probe delay must not be negative, interval and timeout must be at least 1 second")
+	}
+
+	if c.Failures < 1 {
+		return fmt.Errorf("probe failures must be at least 1")
+	}
+
+	if c.APIAddress != "" && net.ParseIP(c.APIAddress) == nil {
+		return fmt.Errorf("invalid probe api_address: %s", c.APIAddress)
+	}
+
+	for _, endpoint := range c.Endpoints {
+		if strings.HasPrefix(endpoint, "http://") || strings.HasPrefix(endpoint, "https://") {
+			if u, err := url.Parse(endpoint); err != nil || u.Host == "" {
+				return fmt.Errorf("invalid probe endpoint: %s", endpoint)
+			}
+			continue
+		}
+		if _, _, err := net.SplitHostPort(endpoint); err != nil {
+			return fmt.Errorf("invalid probe endpoint %s: must be a URL or host:port", endpoint)
+		}
+	}
+
+	if c.Enabled && !c.Gateway && c.DNSName == "" && len(c.Endpoints) == 0 && c.APIAddress == "" {
+		return fmt.Errorf("probes are enabled but none are configured")
+	}
+
+	return nil
+}
+
+func (a ConfigACL) validate() error {
+	if a.Config == "" || strings.ContainsAny(a.Config, "./") {
+		return fmt.Errorf("acl: invalid config name %q", a.Config)
+	}
+	for _, role := range append(append([]string{}, a.Read...), a.Write...) {
+		if role != "admin" && role != "operator" && role != "viewer" {
+			return fmt.Errorf("acl %s: invalid role %q (must be admin, operator or viewer)", a.Config, role)
+		}
+	}
+	return nil
+}
+
+func (c HeadersConfig) validate() error {
+	for name, value := range c.Overrides {
+		if strings.ContainsAny(value, "\r\n") {
+			return fmt.Errorf("invalid header %s: value must be a single line", name)
+		}
+	}
 	return nil
 }
@@ -0,0 +1,48 @@
+package hfconfig
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestSaveTransactionConfig(t *testing.T) {
+	txCfg := TransactionConfig{ApplyOrder: []string{"network", "firewall"}, SkipApply: []string{"dhcp"}}
+	for _, tt := range []struct {
+		name string
+		file string
+		want string
+	}{
+		{
+			name: "keeps comments around the section",
+			file: "# Hellfire\nconfig api 'server'\n\t# the port\n\toption port '8888'\n\n" +
+				"# apply settings\nconfig transaction 'apply'\n\t# keep network first\n\tlist order 'network'\n\toption simulate '0'\n\t# trailing\n",
+			want: "# Hellfire\nconfig api 'server'\n\t# the port\n\toption port '8888'\n\n" +
+				"# apply settings\nconfig transaction 'apply'\n\t# keep network first\n\toption simulate '0'\n" +
+				"\tlist 'order' 'network'\n\tlist 'order' 'firewall'\n\tlist 'skip' 'dhcp'\n\t# trailing\n",
+		},
+		{
+			name: "appends a missing section",
+			file: "# Hellfire\nconfig api 'server'\n\toption port '8888'",
+			want: "# Hellfire\nconfig api 'server'\n\toption port '8888'\n\n" +
+				"config transaction 'apply'\n\tlist 'order' 'network'\n\tlist 'order' 'firewall'\n\tlist 'skip' 'dhcp'\n",
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "hellfire")
+			if err := os.WriteFile(path, []byte(tt.file), 0644); err != nil {
+				t.Fatal(err)
+			}
+			if err := SaveTransactionConfig(path, txCfg); err != nil {
+				t.Fatalf("SaveTransactionConfig: %v", err)
+			}
+			got, err := os.ReadFile(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(got) != tt.want {
+				t.Errorf("got:\n%s\nwant:\n%s", got, tt.want)
+			}
+		})
+	}
+}
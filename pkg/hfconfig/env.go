@@ -0,0 +1,101 @@
+package hfconfig
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// EnvVar is an environment variable that overrides a setting of the config
+// file, for deployments (containers, tests) that shouldn't need one.
+// Precedence, highest first: command-line flags, environment variables,
+// the config file, defaults.
+type EnvVar struct {
+	Name        string
+	Description string
+	apply       func(cfg *Config, value string) error
+}
+
+// EnvVars are the supported environment variables
+var EnvVars = []EnvVar{
+	{"HF_API_PORT", "API port (api.server.port)", intVar(func(c *Config) *int { return &c.API.Port })},
+	{"HF_API_LISTEN", "Comma-separated listen addresses (api.server.listen)", listVar(func(c *Config) *[]string { return &c.API.Listen })},
+	{"HF_API_WEB_ROOT", "Web UI directory (api.server.web_root)", stringVar(func(c *Config) *string { return &c.API.WebRoot })},
+	{"HF_API_CORS", "Enable CORS (api.server.enable_cors)", boolVar(func(c *Config) *bool { return &c.API.EnableCORS })},
+	{"HF_API_ALLOWED_ORIGINS", "Comma-separated CORS origins (api.server.allowed_origins)", listVar(func(c *Config) *[]string { return &c.API.AllowedOrigins })},
+	{"HF_API_CORS_MAX_AGE", "CORS preflight cache seconds (api.server.cors_max_age)", intVar(func(c *Config) *int { return &c.API.CORSMaxAge })},
+	{"HF_API_SWAGGER", "Serve the Swagger UI (security.settings.enable_swagger)", boolVar(func(c *Config) *bool { return &c.Security.EnableSwagger })},
+	{"HF_GRPC_ENABLED", "Start the gRPC server (grpc.server.enabled)", boolVar(func(c *Config) *bool { return &c.GRPC.Enabled })},
+	{"HF_GRPC_PORT", "gRPC port (grpc.server.port)", intVar(func(c *Config) *int { return &c.GRPC.Port })},
+	{"HF_LOG_LEVEL", "Log level (logging.settings.level)", stringVar(func(c *Config) *string { return &c.Logging.Level })},
+	{"HF_LOG_FORMAT", "Log format, json or text (logging.settings.format)", stringVar(func(c *Config) *string { return &c.Logging.Format })},
+	{"HF_LOG_FILE", "Log file, empty for stdout (logging.settings.file)", stringVar(func(c *Config) *string { return &c.Logging.File })},
+	{"HF_AUDIT_ENABLED", "Audit log retention (audit.retention.enabled)", boolVar(func(c *Config) *bool { return &c.Audit.Enabled })},
+	{"HF_AUDIT_RETENTION_DAYS", "Audit log retention in days (audit.retention.retention_days)", intVar(func(c *Config) *int { return &c.Audit.RetentionDays })},
+	{"HF_WAN_ENABLED", "WAN quality monitoring (wan.quality.enabled)", boolVar(func(c *Config) *bool { return &c.WAN.Enabled })},
+	{"HF_SYSLOG_ENABLED", "Syslog collector (syslog.collector.enabled)", boolVar(func(c *Config) *bool { return &c.Syslog.Enabled })},
+	{"HF_SPA_ENABLED", "Single-packet authorization (spa.knock.enabled)", boolVar(func(c *Config) *bool { return &c.SPA.Enabled })},
+	{"HF_SIMULATE", "Apply to a simulated system (transaction.apply.simulate)", boolVar(func(c *Config) *bool { return &c.Transaction.Simulate })},
+}
+
+// ApplyEnv overrides settings with the environment variables that are set.
+// Invalid values are returned as errors and leave their setting unchanged,
+// like invalid values in the config file.
+func ApplyEnv(cfg *Config) error {
+	var errs []error
+	for _, v := range EnvVars {
+		value, ok := os.LookupEnv(v.Name)
+		if !ok {
+			continue
+		}
+		if err := v.apply(cfg, value); err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", v.Name, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+func stringVar(field func(*Config) *string) func(*Config, string) error {
+	return func(cfg *Config, value string) error {
+		*field(cfg) = value
+		return nil
+	}
+}
+
+func intVar(field func(*Config) *int) func(*Config, string) error {
+	return func(cfg *Config, value string) error {
+		n, err := strconv.Atoi(value)
+		if err != nil {
+			return fmt.Errorf("invalid number %q", value)
+		}
+		*field(cfg) = n
+		return nil
+	}
+}
+
+func boolVar(field func(*Config) *bool) func(*Config, string) error {
+	return func(cfg *Config, value string) error {
+		b, err := strconv.ParseBool(value)
+		if err != nil {
+			return fmt.Errorf("invalid boolean %q", value)
+		}
+		*field(cfg) = b
+		return nil
+	}
+}
+
+// listVar splits a comma-separated value; an empty value clears the list
+func listVar(field func(*Config) *[]string) func(*Config, string) error {
+	return func(cfg *Config, value string) error {
+		var list []string
+		for _, item := range strings.Split(value, ",") {
+			if item = strings.TrimSpace(item); item != "" {
+				list = append(list, item)
+			}
+		}
+		*field(cfg) = list
+		return nil
+	}
+}
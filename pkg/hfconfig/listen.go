@@ -0,0 +1,165 @@
+package hfconfig
+
+import (
+	"fmt"
+	"net"
+	"net/url"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/thesabbir/hellfire/pkg/util"
+)
+
+// UnixListenPrefix marks a listen address as a unix socket path
+const UnixListenPrefix = "unix:"
+
+// ListenAddresses returns the API listen addresses, defaulting to Port on all interfaces
+func (c *APIConfig) ListenAddresses() []string {
+	if len(c.Listen) > 0 {
+		return c.Listen
+	}
+	return []string{fmt.Sprintf(":%d", c.Port)}
+}
+
+// ParseListenAddress splits a listen address into a network ("tcp" or "unix") and address
+func ParseListenAddress(addr string) (network, address string) {
+	if strings.HasPrefix(addr, UnixListenPrefix) {
+		return "unix", strings.TrimPrefix(addr, UnixListenPrefix)
+	}
+	return "tcp", addr
+}
+
+// ListenPorts returns the TCP ports the API listens on, sorted
+func (c *APIConfig) ListenPorts() []int {
+	seen := make(map[int]bool)
+	for _, addr := range c.ListenAddresses() {
+		network, address := ParseListenAddress(addr)
+		if network != "tcp" {
+			continue
+		}
+		if _, portStr, err := net.SplitHostPort(address); err == nil {
+			if port, err := strconv.Atoi(portStr); err == nil {
+				seen[port] = true
+			}
+		}
+	}
+
+	ports := make([]int, 0, len(seen))
+	for port := range seen {
+		ports = append(ports, port)
+	}
+	sort.Ints(ports)
+	return ports
+}
+
+// ManagementPorts returns the TCP ports the API and (if enabled) gRPC server listen on
+func (c *Config) ManagementPorts() []int {
+	seen := make(map[int]bool)
+	for _, port := range c.API.ListenPorts() {
+		seen[port] = true
+	}
+
+	if c.GRPC.Enabled {
+		seen[c.GRPC.Port] = true
+	}
+
+	ports := make([]int, 0, len(seen))
+	for port := range seen {
+		ports = append(ports, port)
+	}
+	sort.Ints(ports)
+	return ports
+}
+
+// validateServer validates listen addresses, the management zone/interface lists and request limits
+func (c *APIConfig) validateServer() error {
+	for _, addr := range c.Listen {
+		network, address := ParseListenAddress(addr)
+		if network == "unix" {
+			if !filepath.IsAbs(address) {
+				return fmt.Errorf("invalid listen address %s: socket path must be absolute", addr)
+			}
+			continue
+		}
+
+		host, portStr, err := net.SplitHostPort(address)
+		if err != nil {
+			return fmt.Errorf("invalid listen address %s: %w", addr, err)
+		}
+		if host != "" && host != "localhost" && net.ParseIP(host) == nil {
+			return fmt.Errorf("invalid listen address %s: host must be an IP address", addr)
+		}
+		if port, err := strconv.Atoi(portStr); err != nil || port < 1 || port > 65535 {
+			return fmt.Errorf("invalid listen address %s: invalid port", addr)
+		}
+	}
+
+	if c.VRF != "" {
+		if err := util.ValidateInterfaceName(c.VRF); err != nil {
+			return fmt.Errorf("invalid vrf: %w", err)
+		}
+	}
+
+	for _, origin := range c.AllowedOrigins {
+		if err := validateOrigin(origin); err != nil {
+			return err
+		}
+	}
+
+	if c.CORSMaxAge < 0 || c.CORSMaxAge > 86400 {
+		return fmt.Errorf("cors_max_age must be between 0 and 86400 seconds")
+	}
+
+	for _, zone := range c.AllowedZones {
+		if zone == "" {
+			return fmt.Errorf("allowed zone name cannot be empty")
+		}
+	}
+
+	for _, iface := range c.AllowedInterfaces {
+		if err := util.ValidateInterfaceName(iface); err != nil {
+			return fmt.Errorf("invalid allowed interface: %w", err)
+		}
+	}
+
+	if c.MaxBodySize < 0 {
+		return fmt.Errorf("max_body_size must not be negative")
+	}
+
+	for name, timeout := range map[string]int{
+		"read_header_timeout": c.ReadHeaderTimeout,
+		"read_timeout":        c.ReadTimeout,
+		"write_timeout":       c.WriteTimeout,
+		"idle_timeout":        c.IdleTimeout,
+		"request_timeout":     c.RequestTimeout,
+	} {
+		if timeout < 0 {
+			return fmt.Errorf("%s must not be negative", name)
+		}
+	}
+
+	for route, timeout := range c.RouteTimeouts {
+		if timeout < 0 {
+			return fmt.Errorf("route timeout for %s must not be negative", route)
+		}
+	}
+
+	return nil
+}
+
+// validateOrigin checks a CORS origin: scheme://host[:port], where the host
+// may start with a "*." subdomain wildcard
+func validateOrigin(origin string) error {
+	u, err := url.Parse(origin)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" ||
+		(u.Path != "" && u.Path != "/") || u.RawQuery != "" || u.User != nil {
+		return fmt.Errorf("invalid allowed origin %q: must be scheme://host[:port]", origin)
+	}
+	host := strings.TrimPrefix(u.Hostname(), "*.")
+	if host == "" || strings.Contains(host, "*") {
+		return fmt.Errorf("invalid allowed origin %q: only a leading *. subdomain wildcard is supported", origin)
+	}
+	return nil
+}
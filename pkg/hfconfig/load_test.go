@@ -0,0 +1,51 @@
+package hfconfig
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestLoadSections(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "hellfire")
+	content := `
+config api 'server'
+	option port '9000'
+
+config security 'settings'
+	option min_password_length '16'
+
+config audit 'retention'
+	option retention_days '30'
+
+config ratelimit 'global'
+	option requests_per_minute '200'
+
+config ratelimit 'auth'
+	option requests_per_minute '10'
+	option burst '3'
+`
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	cfg, err := Load(path)
+	if err != nil {
+		t.Fatalf("Load: %v", err)
+	}
+	for _, tt := range []struct {
+		name      string
+		got, want int
+	}{
+		{"api.server.port", cfg.API.Port, 9000},
+		{"security.settings.min_password_length", cfg.Security.MinPasswordLength, 16},
+		{"audit.retention.retention_days", cfg.Audit.RetentionDays, 30},
+		{"ratelimit.global.requests_per_minute", cfg.RateLimit.GlobalRequestsPerMinute, 200},
+		{"ratelimit.auth.requests_per_minute", cfg.RateLimit.AuthRequestsPerMinute, 10},
+		{"ratelimit.auth.burst", cfg.RateLimit.AuthBurst, 3},
+	} {
+		if tt.got != tt.want {
+			t.Errorf("%s = %d, want %d", tt.name, tt.got, tt.want)
+		}
+	}
+}
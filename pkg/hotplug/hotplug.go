@@ -0,0 +1,233 @@
+// Package hotplug watches the kernel's network links for interfaces that
+// appear or disappear (a USB modem plugged in) and for cables plugged in or
+// unplugged, publishes them on the bus and keeps a history of them.
+package hotplug
+
+import (
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/thesabbir/hellfire/pkg/bus"
+	"github.com/thesabbir/hellfire/pkg/logger"
+)
+
+// DefaultHistorySize is the number of link events kept for the UI
+const DefaultHistorySize = 200
+
+// queueSize is the number of events waiting for the handlers; more are dropped
+const queueSize = 64
+
+// EventType is what happened to a link
+type EventType string
+
+const (
+	EventAdded   EventType = "added"   // The interface appeared
+	EventRemoved EventType = "removed" // The interface disappeared
+	EventUp      EventType = "up"      // The link was detected (cable plugged in)
+	EventDown    EventType = "down"    // The link was lost (cable unplugged)
+)
+
+// busEvents are the bus events link events are published as
+var busEvents = map[EventType]bus.EventType{
+	EventAdded:   bus.EventLinkAdded,
+	EventRemoved: bus.EventLinkRemoved,
+	EventUp:      bus.EventLinkUp,
+	EventDown:    bus.EventLinkDown,
+}
+
+// Link is the state of a network link
+type Link struct {
+	Index   int    `json:"index"`
+	Name    string `json:"name"`
+	Up      bool   `json:"up"`      // Administratively up
+	Carrier bool   `json:"carrier"` // Link detected
+}
+
+// Event is a change of a link
+type Event struct {
+	Time      time.Time `json:"time"`
+	Type      EventType `json:"type"`
+	Interface string    `json:"interface"`
+	Up        bool      `json:"up"`
+	Carrier   bool      `json:"carrier"`
+}
+
+// Monitor keeps the state of the links, turns their updates into events and
+// hands these to the handlers one at a time, in order
+type Monitor struct {
+	mu       sync.Mutex
+	links    map[int]Link // By index
+	synced   bool         // The links were listed once
+	history  []Event      // Oldest first
+	size     int
+	handlers []func(Event)
+	queue    chan Event
+	started  sync.Once
+}
+
+// NewMonitor creates a monitor keeping the last size events
+func NewMonitor(size int) *Monitor {
+	m := &Monitor{
+		links: make(map[int]Link),
+		size:  size,
+		queue: make(chan Event, queueSize),
+	}
+	go m.dispatch()
+	return m
+}
+
+// OnEvent registers fn to be called with each event. Call it before Start.
+func (m *Monitor) OnEvent(fn func(Event)) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.handlers = append(m.handlers, fn)
+}
+
+// Links returns the current links by name
+func (m *Monitor) Links() []Link {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	links := make([]Link, 0, len(m.links))
+	for _, link := range m.links {
+		links = append(links, link)
+	}
+	slices.SortFunc(links, func(a, b Link) int { return strings.Compare(a.Name, b.Name) })
+	return links
+}
+
+// Link returns the current state of the named link
+func (m *Monitor) Link(name string) (Link, bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for _, link := range m.links {
+		if link.Name == name {
+			return link, true
+		}
+	}
+	return Link{}, false
+}
+
+// Events returns the recorded events, newest first
+func (m *Monitor) Events() []Event {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	events := make([]Event, len(m.history))
+	copy(events, m.history)
+	slices.Reverse(events)
+	return events
+}
+
+// Sync replaces the links with a full listing. The first listing only sets
+// the state; later ones (after missed updates) report what changed.
+func (m *Monitor) Sync(links []Link) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	now := time.Now()
+	if m.synced {
+		current := make(map[int]bool, len(links))
+		for _, link := range links {
+			current[link.Index] = true
+		}
+		for index, link := range m.links {
+			if !current[index] {
+				m.remove(link, now)
+			}
+		}
+	}
+	for _, link := range links {
+		if m.synced {
+			m.update(link, now)
+		} else {
+			m.links[link.Index] = link
+		}
+	}
+	m.synced = true
+}
+
+// Update records a link's new state, from a link message
+func (m *Monitor) Update(link Link) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.update(link, time.Now())
+}
+
+// Remove records that a link went away
+func (m *Monitor) Remove(link Link) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if known, ok := m.links[link.Index]; ok {
+		m.remove(known, time.Now())
+	}
+}
+
+// update compares link with its known state (must be called with mu held)
+func (m *Monitor) update(link Link, now time.Time) {
+	known, ok := m.links[link.Index]
+	m.links[link.Index] = link
+	switch {
+	case !ok || known.Name != link.Name:
+		// New, or renamed (udev naming a new device) like a new one
+		if ok {
+			m.record(EventRemoved, known, now)
+		}
+		m.record(EventAdded, link, now)
+	case link.Carrier && !known.Carrier:
+		m.record(EventUp, link, now)
+	case !link.Carrier && known.Carrier:
+		m.record(EventDown, link, now)
+	}
+}
+
+// remove forgets a link (must be called with mu held)
+func (m *Monitor) remove(link Link, now time.Time) {
+	delete(m.links, link.Index)
+	m.record(EventRemoved, Link{Index: link.Index, Name: link.Name}, now)
+}
+
+// record keeps, publishes and queues an event (must be called with mu held)
+func (m *Monitor) record(eventType EventType, link Link, now time.Time) {
+	event := Event{Time: now, Type: eventType, Interface: link.Name, Up: link.Up, Carrier: link.Carrier}
+
+	m.history = append(m.history, event)
+	if len(m.history) > m.size {
+		m.history = slices.Delete(m.history, 0, len(m.history)-m.size)
+	}
+
+	logger.Info("Link event", "interface", link.Name, "event", string(eventType))
+	bus.Publish(bus.Event{Type: busEvents[eventType], ConfigName: "network", Data: event})
+
+	select {
+	case m.queue <- event:
+	default:
+		logger.Warn("Link event handlers are behind, event dropped", "interface", link.Name, "event", string(eventType))
+	}
+}
+
+// dispatch calls the handlers with the queued events
+func (m *Monitor) dispatch() {
+	for event := range m.queue {
+		m.mu.Lock()
+		handlers := slices.Clone(m.handlers)
+		m.mu.Unlock()
+
+		for _, handler := range handlers {
+			handler(event)
+		}
+	}
+}
+
+// Start lists the links and watches them for changes in the background
+func (m *Monitor) Start() error {
+	var err error
+	m.started.Do(func() {
+		err = m.watch()
+	})
+	return err
+}
@@ -0,0 +1,78 @@
+package hotplug
+
+import (
+	"testing"
+	"time"
+
+	"github.com/thesabbir/hellfire/pkg/bus"
+)
+
+func TestMonitor(t *testing.T) {
+	busEvents, stop := bus.Watch(16)
+	defer stop()
+
+	m := NewMonitor(3)
+	handled := make(chan Event, 16)
+	m.OnEvent(func(event Event) { handled <- event })
+
+	// The first listing sets the state without events
+	m.Sync([]Link{
+		{Index: 1, Name: "lo", Up: true, Carrier: true},
+		{Index: 2, Name: "eth0", Up: true, Carrier: true},
+	})
+	if len(m.Events()) != 0 {
+		t.Fatalf("events after the first listing: %+v", m.Events())
+	}
+
+	m.Update(Link{Index: 2, Name: "eth0", Up: true})                // Cable unplugged
+	m.Update(Link{Index: 2, Name: "eth0", Up: true})                // No change
+	m.Update(Link{Index: 3, Name: "wwan0"})                         // Modem plugged in
+	m.Update(Link{Index: 2, Name: "eth0", Up: true, Carrier: true}) // Cable plugged in
+	m.Remove(Link{Index: 3, Name: "wwan0"})
+	m.Remove(Link{Index: 3, Name: "wwan0"}) // Already gone
+
+	want := []struct {
+		event EventType
+		iface string
+		bus   bus.EventType
+	}{
+		{EventDown, "eth0", bus.EventLinkDown},
+		{EventAdded, "wwan0", bus.EventLinkAdded},
+		{EventUp, "eth0", bus.EventLinkUp},
+		{EventRemoved, "wwan0", bus.EventLinkRemoved},
+	}
+	for _, w := range want {
+		select {
+		case event := <-handled:
+			if event.Type != w.event || event.Interface != w.iface {
+				t.Errorf("handled %s %s, want %s %s", event.Type, event.Interface, w.event, w.iface)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("no %s event for %s", w.event, w.iface)
+		}
+		select {
+		case event := <-busEvents:
+			if event.Type != w.bus {
+				t.Errorf("published %s, want %s", event.Type, w.bus)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("no %s published", w.bus)
+		}
+	}
+
+	// Only the last events are kept, newest first
+	events := m.Events()
+	if len(events) != 3 || events[0].Type != EventRemoved || events[2].Type != EventAdded {
+		t.Errorf("events = %+v", events)
+	}
+
+	// A listing after missed messages reports the differences
+	m.Sync([]Link{{Index: 1, Name: "lo", Up: true, Carrier: true}, {Index: 4, Name: "eth1", Up: true, Carrier: true}})
+	events = m.Events()
+	if events[0].Type != EventAdded || events[0].Interface != "eth1" || events[1].Type != EventRemoved || events[1].Interface != "eth0" {
+		t.Errorf("events after a listing = %+v", events)
+	}
+	if links := m.Links(); len(links) != 2 || links[0].Name != "eth1" {
+		t.Errorf("links = %+v", links)
+	}
+}
@@ -0,0 +1,140 @@
+package hotplug
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"os"
+	"syscall"
+
+	"github.com/thesabbir/hellfire/pkg/logger"
+)
+
+// iffLowerUp is the IFF_LOWER_UP link flag: the link has a carrier
+const iffLowerUp = 0x10000
+
+// watch subscribes to the link messages of the kernel, lists the links and
+// reads the messages in the background
+func (m *Monitor) watch() error {
+	fd, err := syscall.Socket(syscall.AF_NETLINK, syscall.SOCK_RAW|syscall.SOCK_CLOEXEC, syscall.NETLINK_ROUTE)
+	if err != nil {
+		return fmt.Errorf("failed to open netlink socket: %w", err)
+	}
+	addr := &syscall.SockaddrNetlink{Family: syscall.AF_NETLINK, Groups: 1 << (syscall.RTNLGRP_LINK - 1)}
+	if err := syscall.Bind(fd, addr); err != nil {
+		syscall.Close(fd)
+		return fmt.Errorf("failed to subscribe to link messages: %w", err)
+	}
+
+	// Subscribed first, so no change is missed between the listing and the messages
+	if err := m.list(); err != nil {
+		syscall.Close(fd)
+		return err
+	}
+
+	go func() {
+		defer syscall.Close(fd)
+		logger.Info("Started link monitor")
+
+		buf := make([]byte, os.Getpagesize()*16)
+		for {
+			n, _, err := syscall.Recvfrom(fd, buf, 0)
+			switch {
+			case errors.Is(err, syscall.EINTR):
+				continue
+			case errors.Is(err, syscall.ENOBUFS):
+				// Messages were lost: list the links again
+				logger.Warn("Link messages overflowed, listing the links again")
+				if err := m.list(); err != nil {
+					logger.Error("Failed to list links", "error", err)
+				}
+				continue
+			case err != nil:
+				logger.Error("Link monitor stopped", "error", err)
+				return
+			}
+
+			for _, message := range parseLinkMessages(buf[:n]) {
+				if message.removed {
+					m.Remove(message.link)
+				} else {
+					m.Update(message.link)
+				}
+			}
+		}
+	}()
+	return nil
+}
+
+// list dumps the links of the system into the monitor
+func (m *Monitor) list() error {
+	data, err := syscall.NetlinkRIB(syscall.RTM_GETLINK, syscall.AF_UNSPEC)
+	if err != nil {
+		return fmt.Errorf("failed to list links: %w", err)
+	}
+	var links []Link
+	for _, message := range parseLinkMessages(data) {
+		if !message.removed {
+			links = append(links, message.link)
+		}
+	}
+	m.Sync(links)
+	return nil
+}
+
+// linkMessage is a RTM_NEWLINK or RTM_DELLINK message
+type linkMessage struct {
+	link    Link
+	removed bool
+}
+
+// parseLinkMessages reads the link messages of a netlink datagram; others
+// and malformed ones are skipped
+func parseLinkMessages(data []byte) []linkMessage {
+	messages, err := syscall.ParseNetlinkMessage(data)
+	if err != nil {
+		return nil
+	}
+
+	var links []linkMessage
+	for i := range messages {
+		message := &messages[i]
+		if message.Header.Type != syscall.RTM_NEWLINK && message.Header.Type != syscall.RTM_DELLINK {
+			continue
+		}
+		if len(message.Data) < syscall.SizeofIfInfomsg {
+			continue
+		}
+		// struct ifinfomsg: family, pad, type, index, flags, change
+		flags := binary.NativeEndian.Uint32(message.Data[8:12])
+		link := Link{
+			Index:   int(int32(binary.NativeEndian.Uint32(message.Data[4:8]))),
+			Up:      flags&syscall.IFF_UP != 0,
+			Carrier: flags&iffLowerUp != 0,
+		}
+
+		attrs, err := syscall.ParseNetlinkRouteAttr(message)
+		if err != nil {
+			continue
+		}
+		for _, attr := range attrs {
+			if attr.Attr.Type == syscall.IFLA_IFNAME {
+				link.Name = string(trimNUL(attr.Value))
+			}
+		}
+		if link.Name == "" {
+			continue
+		}
+		links = append(links, linkMessage{link: link, removed: message.Header.Type == syscall.RTM_DELLINK})
+	}
+	return links
+}
+
+func trimNUL(b []byte) []byte {
+	for i, c := range b {
+		if c == 0 {
+			return b[:i]
+		}
+	}
+	return b
+}
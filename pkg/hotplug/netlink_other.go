@@ -0,0 +1,10 @@
+//go:build !linux
+
+package hotplug
+
+import "errors"
+
+// watch is only implemented on Linux
+func (m *Monitor) watch() error {
+	return errors.New("link events are only supported on Linux")
+}
@@ -0,0 +1,210 @@
+// Package indicator gives a physical or visible sign on headless routers
+// that a commit is waiting for confirmation, and that unconfirmed changes
+// were rolled back: it blinks an LED, starts systemd units and runs a
+// script, which can drive a beeper, a GPIO line or a notification.
+package indicator
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/thesabbir/hellfire/pkg/logger"
+	"github.com/thesabbir/hellfire/pkg/transaction"
+)
+
+const (
+	// DefaultLEDDir is where the kernel exposes LEDs
+	DefaultLEDDir = "/sys/class/leds"
+
+	// LED triggers while pending and after a rollback
+	TriggerPending    = "timer"
+	TriggerRolledBack = "heartbeat"
+
+	// RolledBackDuration is how long a rollback is indicated
+	RolledBackDuration = 10 * time.Minute
+
+	// hookTimeout bounds the script and systemctl calls
+	hookTimeout = 30 * time.Second
+)
+
+// Config selects the indications
+type Config struct {
+	Script       string // Run with the state as its argument
+	Unit         string // systemd unit started while pending
+	RollbackUnit string // systemd unit started after a rollback
+	LED          string // LED name under LEDDir
+	LEDDir       string
+}
+
+// Indicator shows the confirmation state of commits
+type Indicator struct {
+	cfg Config
+
+	mu         sync.Mutex
+	idle       string      // LED trigger restored when nothing is indicated
+	clearTimer *time.Timer // Ends the rollback indication
+}
+
+// New creates an indicator and clears indications left by a previous run
+func New(cfg Config) *Indicator {
+	if cfg.LEDDir == "" {
+		cfg.LEDDir = DefaultLEDDir
+	}
+	ind := &Indicator{cfg: cfg, idle: "none"}
+
+	if cfg.LED != "" {
+		trigger, err := ind.currentTrigger()
+		switch {
+		case err != nil:
+			logger.Warn("Failed to read LED trigger", "led", cfg.LED, "error", err)
+		case trigger != TriggerPending && trigger != TriggerRolledBack:
+			ind.idle = trigger
+		}
+	}
+	ind.clear()
+	return ind
+}
+
+// Signal indicates a change of confirmation state; it is the transaction
+// manager's confirm hook
+func (ind *Indicator) Signal(event transaction.ConfirmEvent) {
+	ind.mu.Lock()
+	defer ind.mu.Unlock()
+
+	if ind.clearTimer != nil {
+		ind.clearTimer.Stop()
+		ind.clearTimer = nil
+	}
+
+	logger.Info("Indicating confirmation state", "state", event.State, "tx_id", event.TxID)
+	switch event.State {
+	case transaction.ConfirmPending:
+		ind.systemctl("stop", ind.cfg.RollbackUnit)
+		ind.setLED(TriggerPending)
+		ind.systemctl("start", ind.cfg.Unit)
+	case transaction.ConfirmConfirmed:
+		ind.systemctl("stop", ind.cfg.Unit)
+		ind.systemctl("stop", ind.cfg.RollbackUnit)
+		ind.setLED(ind.idle)
+	case transaction.ConfirmRolledBack:
+		ind.systemctl("stop", ind.cfg.Unit)
+		ind.setLED(TriggerRolledBack)
+		ind.systemctl("start", ind.cfg.RollbackUnit)
+		ind.clearTimer = time.AfterFunc(RolledBackDuration, func() {
+			ind.mu.Lock()
+			defer ind.mu.Unlock()
+			ind.clear()
+		})
+	}
+	ind.runScript(event)
+}
+
+// clear stops every indication (must be called with mu held, or before the
+// indicator is shared)
+func (ind *Indicator) clear() {
+	ind.systemctl("stop", ind.cfg.Unit)
+	ind.systemctl("stop", ind.cfg.RollbackUnit)
+	ind.setLED(ind.idle)
+}
+
+func (ind *Indicator) runScript(event transaction.ConfirmEvent) {
+	if ind.cfg.Script == "" {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), hookTimeout)
+	defer cancel()
+
+	cmd := exec.CommandContext(ctx, ind.cfg.Script, event.State)
+	cmd.Env = append(os.Environ(),
+		"HELLFIRE_CONFIRM_STATE="+event.State,
+		"HELLFIRE_TXID="+event.TxID,
+		"HELLFIRE_CONFIRM_TIMEOUT="+strconv.Itoa(int(event.Timeout.Seconds())),
+		"HELLFIRE_ROLLBACK_REASON="+event.Reason,
+		"HELLFIRE_ROLLBACK_DETAIL="+event.Detail,
+	)
+	if output, err := cmd.CombinedOutput(); err != nil {
+		logger.Warn("Confirm indicator script failed",
+			"script", ind.cfg.Script,
+			"state", event.State,
+			"error", err,
+			"output", strings.TrimSpace(string(output)))
+	}
+}
+
+func (ind *Indicator) systemctl(action, unit string) {
+	if unit == "" {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), hookTimeout)
+	defer cancel()
+
+	// Don't wait for units that take a while to start or stop
+	output, err := exec.CommandContext(ctx, "systemctl", action, "--no-block", unit).CombinedOutput()
+	if err != nil {
+		logger.Warn("Failed to "+action+" confirm indicator unit",
+			"unit", unit,
+			"error", err,
+			"output", strings.TrimSpace(string(output)))
+	}
+}
+
+func (ind *Indicator) setLED(trigger string) {
+	if ind.cfg.LED == "" {
+		return
+	}
+
+	path := filepath.Join(ind.cfg.LEDDir, ind.cfg.LED, "trigger")
+	if err := os.WriteFile(path, []byte(trigger), 0644); err != nil {
+		logger.Warn("Failed to set LED trigger", "led", ind.cfg.LED, "trigger", trigger, "error", err)
+	}
+}
+
+// currentTrigger reads the selected trigger, shown in brackets among the
+// available ones
+func (ind *Indicator) currentTrigger() (string, error) {
+	data, err := os.ReadFile(filepath.Join(ind.cfg.LEDDir, ind.cfg.LED, "trigger"))
+	if err != nil {
+		return "", err
+	}
+
+	for _, trigger := range strings.Fields(string(data)) {
+		if strings.HasPrefix(trigger, "[") && strings.HasSuffix(trigger, "]") {
+			return strings.Trim(trigger, "[]"), nil
+		}
+	}
+	return "", errors.New("no trigger selected")
+}
+
+// Validate checks that the LED exists and the script is executable
+func (cfg Config) Validate() error {
+	if cfg.Script != "" {
+		info, err := os.Stat(cfg.Script)
+		if err != nil {
+			return fmt.Errorf("indicator script: %w", err)
+		}
+		if info.Mode()&0111 == 0 {
+			return fmt.Errorf("indicator script %s is not executable", cfg.Script)
+		}
+	}
+
+	if cfg.LED != "" {
+		dir := cfg.LEDDir
+		if dir == "" {
+			dir = DefaultLEDDir
+		}
+		if _, err := os.Stat(filepath.Join(dir, cfg.LED, "trigger")); err != nil {
+			return fmt.Errorf("indicator LED %s: %w", cfg.LED, err)
+		}
+	}
+	return nil
+}
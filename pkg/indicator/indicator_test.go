@@ -0,0 +1,64 @@
+package indicator
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/thesabbir/hellfire/pkg/transaction"
+)
+
+func TestSignal(t *testing.T) {
+	dir := t.TempDir()
+	ledDir := filepath.Join(dir, "leds")
+	trigger := filepath.Join(ledDir, "status", "trigger")
+	if err := os.MkdirAll(filepath.Dir(trigger), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(trigger, []byte("none timer [default-on] heartbeat\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	log := filepath.Join(dir, "states")
+	script := filepath.Join(dir, "indicate")
+	if err := os.WriteFile(script, []byte("#!/bin/sh\necho \"$1 $HELLFIRE_CONFIRM_TIMEOUT $HELLFIRE_ROLLBACK_REASON\" >> "+log+"\n"), 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	cfg := Config{Script: script, LED: "status", LEDDir: ledDir}
+	if err := cfg.Validate(); err != nil {
+		t.Fatalf("Validate: %v", err)
+	}
+
+	ind := New(cfg)
+	assertTrigger := func(want string) {
+		t.Helper()
+		if data, _ := os.ReadFile(trigger); string(data) != want {
+			t.Errorf("trigger = %q, want %q", data, want)
+		}
+	}
+	assertTrigger("default-on")
+
+	ind.Signal(transaction.ConfirmEvent{State: transaction.ConfirmPending, Timeout: time.Minute})
+	assertTrigger(TriggerPending)
+	ind.Signal(transaction.ConfirmEvent{State: transaction.ConfirmRolledBack, Reason: transaction.RollbackReasonTimeout})
+	assertTrigger(TriggerRolledBack)
+	ind.Signal(transaction.ConfirmEvent{State: transaction.ConfirmPending, Timeout: time.Minute})
+	ind.Signal(transaction.ConfirmEvent{State: transaction.ConfirmConfirmed})
+	assertTrigger("default-on")
+
+	data, err := os.ReadFile(log)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := "pending 60 \nrolled_back 0 timeout\npending 60 \nconfirmed 0 \n"
+	if string(data) != want {
+		t.Errorf("script saw:\n%s\nwant:\n%s", data, want)
+	}
+
+	if err := (Config{LED: "missing", LEDDir: ledDir}).Validate(); err == nil || !strings.Contains(err.Error(), "missing") {
+		t.Errorf("Validate with a missing LED = %v", err)
+	}
+}
@@ -0,0 +1,188 @@
+// Package integrity checks the references between configs: firewall zones
+// and rules naming interfaces and zones, DHCP pools naming interfaces and
+// routes via gateways. A broken reference is reported before a commit
+// instead of failing (or silently doing nothing) at apply time.
+package integrity
+
+import (
+	"fmt"
+	"maps"
+	"net"
+	"slices"
+	"strings"
+
+	"github.com/thesabbir/hellfire/pkg/uci"
+)
+
+// Configs are the configs whose references are checked; a commit changing
+// any of them is checked
+var Configs = []string{"network", "firewall", "dhcp"}
+
+// Issue is a reference to something that doesn't exist
+type Issue struct {
+	Config  string `json:"config"`
+	Section string `json:"section"` // Name, or @type[index] for unnamed sections
+	Option  string `json:"option"`
+	Value   string `json:"value"`
+	Message string `json:"message"`
+}
+
+func (i Issue) String() string {
+	return fmt.Sprintf("%s.%s.%s: %s", i.Config, i.Section, i.Option, i.Message)
+}
+
+// Error is the issues that failed a check
+type Error struct {
+	Issues []Issue
+}
+
+func (e *Error) Error() string {
+	lines := make([]string, len(e.Issues))
+	for i, issue := range e.Issues {
+		lines[i] = issue.String()
+	}
+	return "broken config references:\n  " + strings.Join(lines, "\n  ")
+}
+
+// Check loads the configs and returns the broken references between them
+func Check(load func(name string) (*uci.Config, error)) ([]Issue, error) {
+	configs := make(map[string]*uci.Config, len(Configs))
+	for _, name := range Configs {
+		cfg, err := load(name)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load config %s: %w", name, err)
+		}
+		configs[name] = cfg
+	}
+
+	c := checker{
+		interfaces: make(map[string]*uci.Section),
+		zones:      make(map[string]bool),
+	}
+	for _, section := range configs["network"].GetSectionsByType("interface") {
+		c.interfaces[section.Name] = section
+	}
+	for _, zone := range configs["firewall"].GetSectionsByType("zone") {
+		if name, ok := zone.GetOption("name"); ok {
+			c.zones[name] = true
+		}
+	}
+
+	c.checkFirewall(configs["firewall"])
+	c.checkDHCP(configs["dhcp"])
+	c.checkRoutes(configs["network"])
+	return c.issues, nil
+}
+
+type checker struct {
+	interfaces map[string]*uci.Section // Interface sections by name
+	zones      map[string]bool
+	issues     []Issue
+}
+
+func (c *checker) report(config string, cfg *uci.Config, section *uci.Section, option, value, format string, args ...any) {
+	c.issues = append(c.issues, Issue{
+		Config:  config,
+		Section: cfg.SectionID(section),
+		Option:  option,
+		Value:   value,
+		Message: fmt.Sprintf(format, args...),
+	})
+}
+
+func (c *checker) checkFirewall(cfg *uci.Config) {
+	for _, zone := range cfg.GetSectionsByType("zone") {
+		for _, network := range zone.GetList("network") {
+			if c.interfaces[network] == nil {
+				c.report("firewall", cfg, zone, "network", network,
+					"interface %q is not configured in network", network)
+			}
+		}
+	}
+
+	// Rules match a zone or an interface
+	for _, rule := range cfg.GetSectionsByType("rule") {
+		for _, option := range []string{"src", "dest"} {
+			value, ok := rule.GetOption(option)
+			if !ok || value == "" || value == "*" || c.zones[value] || c.interfaces[value] != nil {
+				continue
+			}
+			c.report("firewall", cfg, rule, option, value,
+				"%q is neither a firewall zone nor a configured interface", value)
+		}
+	}
+
+	for _, forwarding := range cfg.GetSectionsByType("forwarding") {
+		for _, option := range []string{"src", "dest"} {
+			if value, _ := forwarding.GetOption(option); !c.zones[value] {
+				c.report("firewall", cfg, forwarding, option, value, "zone %q does not exist", value)
+			}
+		}
+	}
+}
+
+func (c *checker) checkDHCP(cfg *uci.Config) {
+	for _, pool := range cfg.GetSectionsByType("dhcp") {
+		iface, ok := pool.GetOption("interface")
+		if ok && c.interfaces[iface] == nil {
+			c.report("dhcp", cfg, pool, "interface", iface,
+				"interface %q is not configured in network", iface)
+		}
+	}
+}
+
+// checkRoutes checks that routes use a configured interface and a gateway
+// in a configured subnet: the route's interface, or else any interface
+func (c *checker) checkRoutes(cfg *uci.Config) {
+	for _, route := range cfg.GetSectionsByType("route") {
+		iface, hasIface := route.GetOption("interface")
+		if hasIface && c.interfaces[iface] == nil {
+			c.report("network", cfg, route, "interface", iface, "interface %q is not configured", iface)
+			continue
+		}
+
+		gateway, ok := route.GetOption("gateway")
+		if !ok || gateway == "" {
+			continue
+		}
+		ip := net.ParseIP(gateway)
+		if ip == nil {
+			c.report("network", cfg, route, "gateway", gateway, "invalid gateway address")
+			continue
+		}
+
+		candidates := slices.Collect(maps.Values(c.interfaces))
+		if hasIface {
+			candidates = []*uci.Section{c.interfaces[iface]}
+		}
+		// The subnet of a DHCP interface isn't known until it has a lease
+		if slices.ContainsFunc(candidates, func(s *uci.Section) bool {
+			proto, _ := s.GetOption("proto")
+			return proto == "dhcp"
+		}) {
+			continue
+		}
+		if !slices.ContainsFunc(candidates, func(s *uci.Section) bool { return inSubnet(s, ip) }) {
+			where := "any configured subnet"
+			if hasIface {
+				where = "the subnet of " + iface
+			}
+			c.report("network", cfg, route, "gateway", gateway, "gateway is not in %s", where)
+		}
+	}
+}
+
+// inSubnet reports whether ip is in the subnet of a static interface
+func inSubnet(section *uci.Section, ip net.IP) bool {
+	if proto, _ := section.GetOption("proto"); proto != "static" {
+		return false
+	}
+	ipaddr, _ := section.GetOption("ipaddr")
+	netmask, _ := section.GetOption("netmask")
+	addr, mask := net.ParseIP(ipaddr), net.ParseIP(netmask)
+	if addr == nil || mask == nil || mask.To4() == nil {
+		return false
+	}
+	subnet := net.IPNet{IP: addr.Mask(net.IPMask(mask.To4())), Mask: net.IPMask(mask.To4())}
+	return subnet.Contains(ip)
+}
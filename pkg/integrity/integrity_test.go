@@ -0,0 +1,169 @@
+package integrity
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/thesabbir/hellfire/pkg/uci"
+)
+
+func loader(t *testing.T, configs map[string]string) func(string) (*uci.Config, error) {
+	t.Helper()
+	return func(name string) (*uci.Config, error) {
+		return uci.Parse(strings.NewReader(configs[name]))
+	}
+}
+
+func TestCheckExamples(t *testing.T) {
+	issues, err := Check(func(name string) (*uci.Config, error) {
+		f, err := os.Open(filepath.Join("../../examples/config", name))
+		if err != nil {
+			return nil, err
+		}
+		defer f.Close()
+		return uci.Parse(f)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(issues) != 0 {
+		t.Errorf("example configs have broken references: %v", issues)
+	}
+}
+
+func TestCheck(t *testing.T) {
+	issues, err := Check(loader(t, map[string]string{
+		"network": `
+config interface 'eth0'
+	option proto 'dhcp'
+
+config interface 'eth1'
+	option proto 'static'
+	option ipaddr '10.0.0.1'
+	option netmask '255.255.255.0'
+
+config route
+	option interface 'eth1'
+	option target '10.1.0.0'
+	option gateway '10.0.0.254'
+
+config route
+	option interface 'eth1'
+	option target '10.2.0.0'
+	option gateway '192.168.9.1'
+
+config route
+	option interface 'eth0'
+	option gateway '172.16.0.1'
+`,
+		"firewall": `
+config zone
+	option name 'lan'
+	list network 'eth1'
+	list network 'eth2'
+
+config rule
+	option src 'lan'
+	option dest 'dmz'
+
+config forwarding
+	option src 'lan'
+	option dest 'wan'
+`,
+		"dhcp": `
+config dhcp 'lan'
+	option interface 'br-lan'
+`,
+	}))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var got []string
+	for _, issue := range issues {
+		got = append(got, issue.Config+"."+issue.Section+"."+issue.Option+"="+issue.Value)
+	}
+	want := []string{
+		"firewall.@zone[0].network=eth2",
+		"firewall.@rule[0].dest=dmz",
+		"firewall.@forwarding[0].dest=wan",
+		"dhcp.lan.interface=br-lan",
+		"network.@route[1].gateway=192.168.9.1",
+	}
+	if strings.Join(got, " ") != strings.Join(want, " ") {
+		t.Errorf("issues:\n got %v\nwant %v", got, want)
+	}
+}
+
+func TestRenameInterface(t *testing.T) {
+	configs := make(map[string]*uci.Config)
+	for name, text := range map[string]string{
+		"network": `
+config interface 'eth1'
+	option proto 'static'
+	option ipaddr '192.168.1.1'
+	option netmask '255.255.255.0'
+
+config interface 'wg0'
+	option proto 'wireguard'
+
+config wireguard_wg0
+	option public_key 'abc'
+
+config route
+	option interface 'eth1'
+	option target '10.0.0.0/8'
+	option gateway '192.168.1.254'
+`,
+		"firewall": `
+config zone
+	option name 'lan'
+	list network 'eth1'
+	list network 'wg0'
+
+config rule
+	option src 'eth1'
+	option target 'ACCEPT'
+`,
+		"dhcp": `
+config dhcp 'lan'
+	option interface 'eth1'
+`,
+	} {
+		cfg, err := uci.Parse(strings.NewReader(text))
+		if err != nil {
+			t.Fatal(err)
+		}
+		configs[name] = cfg
+	}
+
+	if err := RenameInterface(configs, "eth1", "wg0"); err == nil {
+		t.Error("renamed onto an existing interface")
+	}
+	if err := RenameInterface(configs, "eth1", "enp2s0"); err != nil {
+		t.Fatalf("RenameInterface: %v", err)
+	}
+	if err := RenameInterface(configs, "wg0", "wg1"); err != nil {
+		t.Fatalf("RenameInterface: %v", err)
+	}
+
+	issues, err := Check(func(name string) (*uci.Config, error) { return configs[name], nil })
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(issues) > 0 {
+		t.Errorf("issues after renaming: %v", issues)
+	}
+	if configs["network"].GetSection("interface", "enp2s0") == nil || len(configs["network"].GetSectionsByType("wireguard_wg1")) != 1 {
+		t.Errorf("network not renamed: %+v", configs["network"].Sections)
+	}
+	if networks := configs["firewall"].GetSectionsByType("zone")[0].GetList("network"); !slices.Equal(networks, []string{"enp2s0", "wg1"}) {
+		t.Errorf("zone networks = %v", networks)
+	}
+	if src, _ := configs["firewall"].GetSectionsByType("rule")[0].GetOption("src"); src != "enp2s0" {
+		t.Errorf("rule src = %q", src)
+	}
+}
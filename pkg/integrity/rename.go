@@ -0,0 +1,84 @@
+package integrity
+
+import (
+	"fmt"
+	"slices"
+
+	"github.com/thesabbir/hellfire/pkg/uci"
+	"github.com/thesabbir/hellfire/pkg/util"
+	"github.com/thesabbir/hellfire/pkg/wireguard"
+)
+
+// RenameInterface renames an interface of the network config along with the
+// references to it: its WireGuard peers, the routes, neighbors and DHCP
+// pools on it, the firewall zones covering it and the rules matching it.
+// Interfaces are named after their devices, so this moves a config onto
+// other hardware. Configs missing from configs are skipped.
+func RenameInterface(configs map[string]*uci.Config, from, to string) error {
+	network := configs["network"]
+	if network == nil || network.GetSection("interface", from) == nil {
+		return fmt.Errorf("interface %q is not configured in network", from)
+	}
+	if from == to {
+		return nil
+	}
+	if err := util.ValidateInterfaceName(to); err != nil {
+		return err
+	}
+	for _, section := range network.Sections {
+		if section.Name == to {
+			return fmt.Errorf("network already has a section named %q", to)
+		}
+	}
+
+	network.GetSection("interface", from).Name = to
+	for _, section := range network.Sections {
+		if section.Type == wireguard.PeerType(from) {
+			section.Type = wireguard.PeerType(to)
+		}
+		if section.Type == "device" {
+			// VLANs tagged on the interface
+			renameOption(section, "ifname", from, to)
+		}
+		renameOption(section, "interface", from, to)
+	}
+
+	if dhcp := configs["dhcp"]; dhcp != nil {
+		for _, section := range dhcp.Sections {
+			renameOption(section, "interface", from, to)
+		}
+	}
+
+	if firewall := configs["firewall"]; firewall != nil {
+		zones := make(map[string]bool)
+		for _, zone := range firewall.GetSectionsByType("zone") {
+			if name, ok := zone.GetOption("name"); ok {
+				zones[name] = true
+			}
+			if i := slices.Index(zone.GetList("network"), from); i >= 0 {
+				zone.Lists["network"][i] = to
+			}
+		}
+		// Rules match a zone or an interface; a zone of the same name wins
+		if !zones[from] {
+			for _, rule := range firewall.GetSectionsByType("rule") {
+				renameOption(rule, "src", from, to)
+				renameOption(rule, "dest", from, to)
+			}
+		}
+	}
+
+	if wireless := configs["wireless"]; wireless != nil {
+		for _, section := range wireless.GetSectionsByType("wifi-iface") {
+			renameOption(section, "network", from, to)
+		}
+	}
+	return nil
+}
+
+// renameOption replaces the value of an option equal to from
+func renameOption(section *uci.Section, option, from, to string) {
+	if value, ok := section.GetOption(option); ok && value == from {
+		section.SetOption(option, to)
+	}
+}
@@ -2,59 +2,150 @@
 package logger
 
 import (
+	"fmt"
+	"io"
 	"log/slog"
 	"os"
+	"strings"
+	"sync"
+	"sync/atomic"
 )
 
-var log *slog.Logger
+// Log output formats
+const (
+	FormatJSON = "json"
+	FormatText = "text"
+)
+
+// log is swapped atomically so logging can be reconfigured while the daemon runs
+var log atomic.Pointer[slog.Logger]
+
+var (
+	outputMu sync.Mutex
+	output   io.Closer // Log file opened by Configure, if any
+)
 
 func init() {
 	// Default to JSON handler for production
-	log = slog.New(slog.NewJSONHandler(os.Stdout, &slog.HandlerOptions{
+	log.Store(slog.New(slog.NewJSONHandler(os.Stdout, &slog.HandlerOptions{
 		Level: slog.LevelInfo,
-	}))
+	})))
+}
+
+// Options configures the logger
+type Options struct {
+	Level      string // debug, info, warn or error
+	Format     string // json or text
+	File       string // Log file path; empty logs to stdout
+	MaxSize    int    // Rotate the log file after this many megabytes (0 = never)
+	MaxBackups int    // Rotated files to keep
+}
+
+// Configure replaces the logger according to opts. It can be called again at
+// any time (e.g. on SIGHUP); a previously opened log file is closed, so
+// rotation by external tools is picked up as well.
+func Configure(opts Options) error {
+	level, err := ParseLevel(opts.Level)
+	if err != nil {
+		return err
+	}
+
+	var w io.Writer = os.Stdout
+	var file *rotatingFile
+	if opts.File != "" {
+		file, err = openRotatingFile(opts.File, int64(opts.MaxSize)*1024*1024, opts.MaxBackups)
+		if err != nil {
+			return err
+		}
+		w = file
+	}
+
+	handlerOpts := &slog.HandlerOptions{Level: level}
+	var handler slog.Handler
+	switch strings.ToLower(opts.Format) {
+	case "", FormatJSON:
+		handler = slog.NewJSONHandler(w, handlerOpts)
+	case FormatText:
+		handler = slog.NewTextHandler(w, handlerOpts)
+	default:
+		if file != nil {
+			_ = file.Close()
+		}
+		return fmt.Errorf("invalid log format: %s (must be json or text)", opts.Format)
+	}
+
+	log.Store(slog.New(handler))
+
+	outputMu.Lock()
+	previous := output
+	output = nil
+	if file != nil {
+		output = file
+	}
+	outputMu.Unlock()
+
+	if previous != nil {
+		_ = previous.Close()
+	}
+	return nil
+}
+
+// ParseLevel parses a log level name (debug, info, warn/warning, error)
+func ParseLevel(level string) (slog.Level, error) {
+	switch strings.ToLower(level) {
+	case "debug":
+		return slog.LevelDebug, nil
+	case "", "info":
+		return slog.LevelInfo, nil
+	case "warn", "warning":
+		return slog.LevelWarn, nil
+	case "error":
+		return slog.LevelError, nil
+	default:
+		return slog.LevelInfo, fmt.Errorf("invalid log level: %s (must be debug, info, warn or error)", level)
+	}
 }
 
 // SetLogger allows setting a custom logger
 func SetLogger(l *slog.Logger) {
-	log = l
+	log.Store(l)
 }
 
 // SetLevel sets the log level
 func SetLevel(level slog.Level) {
-	log = slog.New(slog.NewJSONHandler(os.Stdout, &slog.HandlerOptions{
+	log.Store(slog.New(slog.NewJSONHandler(os.Stdout, &slog.HandlerOptions{
 		Level: level,
-	}))
+	})))
 }
 
 // SetTextOutput sets the logger to use text output (for development)
 func SetTextOutput() {
-	log = slog.New(slog.NewTextHandler(os.Stdout, &slog.HandlerOptions{
+	log.Store(slog.New(slog.NewTextHandler(os.Stdout, &slog.HandlerOptions{
 		Level: slog.LevelInfo,
-	}))
+	})))
 }
 
 // Debug logs a debug message
 func Debug(msg string, args ...any) {
-	log.Debug(msg, args...)
+	log.Load().Debug(msg, args...)
 }
 
 // Info logs an info message
 func Info(msg string, args ...any) {
-	log.Info(msg, args...)
+	log.Load().Info(msg, args...)
 }
 
 // Warn logs a warning message
 func Warn(msg string, args ...any) {
-	log.Warn(msg, args...)
+	log.Load().Warn(msg, args...)
 }
 
 // Error logs an error message
 func Error(msg string, args ...any) {
-	log.Error(msg, args...)
+	log.Load().Error(msg, args...)
 }
 
 // With returns a new logger with the given attributes
 func With(args ...any) *slog.Logger {
-	return log.With(args...)
+	return log.Load().With(args...)
 }
@@ -0,0 +1,32 @@
+package middleware
+
+import (
+	"context"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/thesabbir/hellfire/pkg/audit"
+)
+
+// AuditContextMiddleware starts the request's audit context with the client
+// IP and the request ID, so every audit entry made while handling it can be
+// traced back to the request. It must run after RequestLoggingMiddleware;
+// the auth middlewares add the user and session.
+func AuditContextMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		ctx := audit.WithIP(c.Request.Context(), c.ClientIP())
+		if requestID := c.GetString(RequestIDKey); requestID != "" {
+			ctx = audit.WithRequestID(ctx, requestID)
+		}
+		c.Request = c.Request.WithContext(ctx)
+
+		c.Next()
+	}
+}
+
+// AuditContext returns the request's audit context (see AuditContextMiddleware).
+// It isn't canceled when the client goes away, so entries for work that
+// completes anyway are still written.
+func AuditContext(c *gin.Context) context.Context {
+	return context.WithoutCancel(c.Request.Context())
+}
@@ -0,0 +1,188 @@
+package middleware
+
+import (
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	corsAllowHeaders = "Content-Type, Authorization, X-API-Key, X-CSRF-Token, Idempotency-Key, API-Version"
+	corsAllowMethods = "GET, POST, PUT, PATCH, DELETE, OPTIONS"
+)
+
+// CORSPolicy decides which browser origins may call the API
+type CORSPolicy struct {
+	enabled  bool
+	origins  map[string]bool // Exact origins ("https://router.local")
+	patterns []originPattern // Wildcard subdomain origins ("https://*.example.com")
+	self     map[string]bool // Origins of the router itself: its names and addresses on the API's ports
+	maxAge   string          // Access-Control-Max-Age in seconds, empty to leave it to the browser
+}
+
+// originPattern matches origins with the given scheme and port whose host is
+// a subdomain of suffix (".example.com")
+type originPattern struct {
+	scheme string
+	suffix string
+	port   string
+}
+
+// NewCORSPolicy creates a CORS policy. An allowed origin is either exact
+// ("https://router.local") or has a wildcard subdomain ("https://*.example.com",
+// which doesn't match example.com itself). selfHosts are names and addresses
+// of the router (see LocalHosts), allowed over http on the ports the API
+// listens on, selfPorts; a UI served any other way must be listed. maxAge is
+// how long, in seconds, browsers may cache preflight results (0 to not say).
+func NewCORSPolicy(enabled bool, allowedOrigins, selfHosts []string, selfPorts []int, maxAge int) *CORSPolicy {
+	p := &CORSPolicy{
+		enabled: enabled,
+		origins: make(map[string]bool),
+		self:    make(map[string]bool),
+	}
+
+	for _, origin := range allowedOrigins {
+		scheme, host, port, ok := splitOrigin(origin)
+		if !ok {
+			continue
+		}
+		if suffix, found := strings.CutPrefix(host, "*"); found {
+			p.patterns = append(p.patterns, originPattern{scheme: scheme, suffix: suffix, port: port})
+			continue
+		}
+		p.origins[joinOrigin(scheme, host, port)] = true
+	}
+
+	for _, host := range selfHosts {
+		for _, port := range selfPorts {
+			portStr := strconv.Itoa(port)
+			if port == 80 {
+				portStr = ""
+			}
+			p.self[joinOrigin("http", strings.ToLower(host), portStr)] = true
+		}
+	}
+
+	if maxAge > 0 {
+		p.maxAge = strconv.Itoa(maxAge)
+	}
+	return p
+}
+
+// Allowed reports whether a browser origin may call the API
+func (p *CORSPolicy) Allowed(origin string) bool {
+	if !p.enabled {
+		return false
+	}
+
+	scheme, host, port, ok := splitOrigin(origin)
+	if !ok {
+		return false
+	}
+	if normalized := joinOrigin(scheme, host, port); p.origins[normalized] || p.self[normalized] {
+		return true
+	}
+	for _, pattern := range p.patterns {
+		if scheme == pattern.scheme && port == pattern.port &&
+			strings.HasSuffix(host, pattern.suffix) && len(host) > len(pattern.suffix) {
+			return true
+		}
+	}
+	return false
+}
+
+// splitOrigin splits "scheme://host[:port]" into its lower-cased parts,
+// leaving out the scheme's default port
+func splitOrigin(origin string) (scheme, host, port string, ok bool) {
+	u, err := url.Parse(strings.ToLower(strings.TrimSpace(origin)))
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" ||
+		(u.Path != "" && u.Path != "/") || u.RawQuery != "" || u.User != nil {
+		return "", "", "", false
+	}
+
+	port = u.Port()
+	if (u.Scheme == "http" && port == "80") || (u.Scheme == "https" && port == "443") {
+		port = ""
+	}
+	return u.Scheme, u.Hostname(), port, true
+}
+
+func joinOrigin(scheme, host, port string) string {
+	if strings.Contains(host, ":") {
+		host = "[" + host + "]"
+	}
+	if port != "" {
+		host += ":" + port
+	}
+	return scheme + "://" + host
+}
+
+// LocalHosts returns the router's host name (with and without its domain,
+// and as <name>.local for mDNS) and the addresses of its interfaces, so the
+// web UI can be reached by any of them without listing each as an origin.
+// Loopback addresses are left out: any local program could claim them, so
+// they have to be listed to be allowed.
+func LocalHosts() []string {
+	var hosts []string
+
+	if hostname, err := os.Hostname(); err == nil && hostname != "" {
+		short, _, _ := strings.Cut(hostname, ".")
+		hosts = append(hosts, hostname, short, short+".local")
+	}
+
+	if addrs, err := net.InterfaceAddrs(); err == nil {
+		for _, addr := range addrs {
+			if ipNet, ok := addr.(*net.IPNet); ok && !ipNet.IP.IsLinkLocalUnicast() && !ipNet.IP.IsLoopback() {
+				hosts = append(hosts, ipNet.IP.String())
+			}
+		}
+	}
+
+	return hosts
+}
+
+// CORSMiddleware handles CORS with the policy returned by policy, which is
+// called on every request so the policy can be swapped at runtime.
+// Preflight requests are answered here and cached for the policy's max age.
+func CORSMiddleware(policy func() *CORSPolicy) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		p := policy()
+		if !p.enabled {
+			c.Next()
+			return
+		}
+
+		origin := c.Request.Header.Get("Origin")
+		if origin == "" {
+			c.Next()
+			return
+		}
+
+		header := c.Writer.Header()
+		header.Add("Vary", "Origin")
+		allowed := p.Allowed(origin)
+		if allowed {
+			header.Set("Access-Control-Allow-Origin", origin)
+			header.Set("Access-Control-Allow-Credentials", "true")
+		}
+
+		if c.Request.Method == http.MethodOptions && c.Request.Header.Get("Access-Control-Request-Method") != "" {
+			if allowed {
+				header.Set("Access-Control-Allow-Headers", corsAllowHeaders)
+				header.Set("Access-Control-Allow-Methods", corsAllowMethods)
+				if p.maxAge != "" {
+					header.Set("Access-Control-Max-Age", p.maxAge)
+				}
+			}
+			c.AbortWithStatus(http.StatusNoContent)
+			return
+		}
+
+		c.Next()
+	}
+}
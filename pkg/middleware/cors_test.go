@@ -0,0 +1,30 @@
+package middleware
+
+import "testing"
+
+func TestCORSPolicyAllowed(t *testing.T) {
+	p := NewCORSPolicy(true, []string{"https://router.local", "https://*.example.com", "http://localhost:5173"},
+		[]string{"Router", "192.168.1.1", "fd00::1"}, []int{80, 8888}, 0)
+	for _, tt := range []struct {
+		origin string
+		want   bool
+	}{
+		{"https://router.local", true},
+		{"https://router.local:8443", false},
+		{"https://ui.example.com", true},
+		{"https://example.com", false},
+		{"http://localhost:5173", true},
+		{"http://192.168.1.1:8888", true},
+		{"http://192.168.1.1", true},
+		{"http://router:8888", true},
+		{"http://[fd00::1]:8888", true},
+		{"https://192.168.1.1:8888", false},
+		{"http://192.168.1.1:3000", false},
+		{"http://127.0.0.1:8888", false},
+		{"https://evil.example", false},
+	} {
+		if got := p.Allowed(tt.origin); got != tt.want {
+			t.Errorf("Allowed(%s) = %v, want %v", tt.origin, got, tt.want)
+		}
+	}
+}
@@ -8,6 +8,7 @@ import (
 	"time"
 
 	"github.com/gin-gonic/gin"
+	apierrors "github.com/thesabbir/hellfire/pkg/errors"
 )
 
 const (
@@ -113,19 +114,13 @@ func CSRFMiddleware(csrfMgr *CSRFManager) gin.HandlerFunc {
 		// Get token from header
 		token := c.GetHeader("X-CSRF-Token")
 		if token == "" {
-			c.JSON(http.StatusForbidden, gin.H{
-				"error": "CSRF token missing",
-			})
-			c.Abort()
+			apierrors.Abort(c, http.StatusForbidden, apierrors.CodeCSRFMissing)
 			return
 		}
 
 		// Validate token
 		if !csrfMgr.ValidateToken(token) {
-			c.JSON(http.StatusForbidden, gin.H{
-				"error": "invalid or expired CSRF token",
-			})
-			c.Abort()
+			apierrors.Abort(c, http.StatusForbidden, apierrors.CodeInvalidCSRF)
 			return
 		}
 
@@ -0,0 +1,206 @@
+package middleware
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/thesabbir/hellfire/pkg/auth"
+)
+
+const (
+	// IdempotencyKeyHeader is the request header carrying the client's idempotency key
+	IdempotencyKeyHeader = "Idempotency-Key"
+
+	// IdempotencyReplayedHeader is set on responses replayed from the store
+	IdempotencyReplayedHeader = "Idempotent-Replayed"
+
+	// IdempotencyKeyLifetime is how long a stored response can be replayed
+	IdempotencyKeyLifetime = 24 * time.Hour
+
+	// IdempotencyKeyMaxLength limits the size of client supplied keys
+	IdempotencyKeyMaxLength = 255
+)
+
+// idempotencyEntry is a stored request fingerprint and its response
+type idempotencyEntry struct {
+	fingerprint string
+	inProgress  bool
+	status      int
+	contentType string
+	body        []byte
+	expiry      time.Time
+}
+
+// IdempotencyStore remembers responses to mutating requests by idempotency key
+type IdempotencyStore struct {
+	entries map[string]*idempotencyEntry
+	mu      sync.Mutex
+}
+
+// NewIdempotencyStore creates a new idempotency store
+func NewIdempotencyStore() *IdempotencyStore {
+	store := &IdempotencyStore{
+		entries: make(map[string]*idempotencyEntry),
+	}
+
+	// Start cleanup goroutine
+	go store.cleanup()
+
+	return store
+}
+
+// cleanup removes expired entries periodically
+func (s *IdempotencyStore) cleanup() {
+	ticker := time.NewTicker(30 * time.Minute)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		now := time.Now()
+		s.mu.Lock()
+		for key, entry := range s.entries {
+			if !entry.inProgress && now.After(entry.expiry) {
+				delete(s.entries, key)
+			}
+		}
+		s.mu.Unlock()
+	}
+}
+
+// capturingWriter records the response body while passing it through
+type capturingWriter struct {
+	gin.ResponseWriter
+	body bytes.Buffer
+}
+
+func (w *capturingWriter) Write(data []byte) (int, error) {
+	w.body.Write(data)
+	return w.ResponseWriter.Write(data)
+}
+
+func (w *capturingWriter) WriteString(s string) (int, error) {
+	w.body.WriteString(s)
+	return w.ResponseWriter.WriteString(s)
+}
+
+// IdempotencyMiddleware replays the original response when a mutating request
+// is retried with the same Idempotency-Key, so retrying clients don't repeat
+// the operation. Must run after authentication: keys are scoped per user.
+// Only successful responses are stored, so a rejected or failed request can be retried.
+func IdempotencyMiddleware(store *IdempotencyStore) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		// Only mutating requests carrying a key are handled
+		key := c.GetHeader(IdempotencyKeyHeader)
+		if key == "" || c.Request.Method == "GET" || c.Request.Method == "HEAD" || c.Request.Method == "OPTIONS" {
+			c.Next()
+			return
+		}
+
+		if len(key) > IdempotencyKeyMaxLength {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"error": "Idempotency-Key too long",
+			})
+			c.Abort()
+			return
+		}
+
+		body, err := io.ReadAll(c.Request.Body)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"error": "invalid request",
+			})
+			c.Abort()
+			return
+		}
+		c.Request.Body = io.NopCloser(bytes.NewReader(body))
+
+		scope := "anonymous"
+		if user := auth.GetUser(c); user != nil {
+			scope = fmt.Sprintf("user:%d", user.ID)
+		}
+		storeKey := scope + ":" + key
+		fingerprint := requestFingerprint(c.Request.Method, c.Request.URL.Path, body)
+
+		store.mu.Lock()
+		entry, exists := store.entries[storeKey]
+		if exists && !entry.inProgress && time.Now().After(entry.expiry) {
+			delete(store.entries, storeKey)
+			exists = false
+		}
+
+		if exists {
+			store.mu.Unlock()
+
+			switch {
+			case entry.fingerprint != fingerprint:
+				c.JSON(http.StatusUnprocessableEntity, gin.H{
+					"error": "Idempotency-Key was already used for a different request",
+				})
+			case entry.inProgress:
+				c.JSON(http.StatusConflict, gin.H{
+					"error": "a request with this Idempotency-Key is still in progress",
+				})
+			default:
+				c.Header(IdempotencyReplayedHeader, "true")
+				c.Data(entry.status, entry.contentType, entry.body)
+			}
+			c.Abort()
+			return
+		}
+
+		entry = &idempotencyEntry{
+			fingerprint: fingerprint,
+			inProgress:  true,
+		}
+		store.entries[storeKey] = entry
+		store.mu.Unlock()
+
+		writer := &capturingWriter{ResponseWriter: c.Writer}
+		c.Writer = writer
+
+		// A panicking handler must not leave the key in progress for good
+		completed := false
+		defer func() {
+			if !completed {
+				store.mu.Lock()
+				delete(store.entries, storeKey)
+				store.mu.Unlock()
+			}
+		}()
+
+		c.Next()
+		completed = true
+
+		store.mu.Lock()
+		defer store.mu.Unlock()
+
+		status := writer.Status()
+		if status >= http.StatusBadRequest {
+			delete(store.entries, storeKey)
+			return
+		}
+
+		entry.inProgress = false
+		entry.status = status
+		entry.contentType = writer.Header().Get("Content-Type")
+		entry.body = writer.body.Bytes()
+		entry.expiry = time.Now().Add(IdempotencyKeyLifetime)
+	}
+}
+
+// requestFingerprint identifies a request by method, path and body
+func requestFingerprint(method, path string, body []byte) string {
+	h := sha256.New()
+	h.Write([]byte(method))
+	h.Write([]byte{0})
+	h.Write([]byte(path))
+	h.Write([]byte{0})
+	h.Write(body)
+	return hex.EncodeToString(h.Sum(nil))
+}
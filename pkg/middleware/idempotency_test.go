@@ -0,0 +1,33 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestIdempotencyMiddlewarePanic(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	calls := 0
+	r := gin.New()
+	r.Use(gin.Recovery(), IdempotencyMiddleware(NewIdempotencyStore()))
+	r.POST("/commit", func(c *gin.Context) {
+		calls++
+		if calls == 1 {
+			panic("apply failed")
+		}
+		c.JSON(http.StatusOK, gin.H{"calls": calls})
+	})
+
+	for _, want := range []int{http.StatusInternalServerError, http.StatusOK} {
+		req := httptest.NewRequest(http.MethodPost, "/commit", nil)
+		req.Header.Set(IdempotencyKeyHeader, "commit-1")
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		if w.Code != want {
+			t.Fatalf("status = %d, want %d: %s", w.Code, want, w.Body)
+		}
+	}
+}
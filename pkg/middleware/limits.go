@@ -0,0 +1,68 @@
+package middleware
+
+import (
+	"context"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/thesabbir/hellfire/pkg/logger"
+)
+
+// BodySizeLimitMiddleware rejects request bodies larger than maxBytes. Requests
+// that declare a larger Content-Length get 413 up front; chunked bodies are cut
+// off when the handler reads past the limit.
+func BodySizeLimitMiddleware(maxBytes int64) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if maxBytes <= 0 || c.Request.Body == nil {
+			c.Next()
+			return
+		}
+
+		if c.Request.ContentLength > maxBytes {
+			c.JSON(http.StatusRequestEntityTooLarge, gin.H{
+				"error": "request body too large",
+			})
+			c.Abort()
+			return
+		}
+
+		c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, maxBytes)
+		c.Next()
+	}
+}
+
+// TimeoutMiddleware bounds how long a request may take. The request context
+// gets a deadline and the connection's write deadline is moved to match, so
+// slow routes (commit, rollback) can be given more time than the server-wide
+// write timeout. Routes are matched by their pattern relative to prefix
+// (e.g. "/config/commit" under "/api/v1"); a timeout of 0 means no limit.
+func TimeoutMiddleware(prefix string, defaultTimeout time.Duration, routes map[string]time.Duration) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		timeout := defaultTimeout
+		if t, ok := routes[strings.TrimPrefix(c.FullPath(), prefix)]; ok {
+			timeout = t
+		}
+
+		if timeout <= 0 {
+			// Lift the server-wide write deadline as well
+			if err := http.NewResponseController(c.Writer).SetWriteDeadline(time.Time{}); err != nil {
+				logger.Debug("Failed to clear write deadline", "error", err)
+			}
+			c.Next()
+			return
+		}
+
+		deadline := time.Now().Add(timeout)
+		if err := http.NewResponseController(c.Writer).SetWriteDeadline(deadline); err != nil {
+			logger.Debug("Failed to set write deadline", "error", err)
+		}
+
+		ctx, cancel := context.WithDeadline(c.Request.Context(), deadline)
+		defer cancel()
+
+		c.Request = c.Request.WithContext(ctx)
+		c.Next()
+	}
+}
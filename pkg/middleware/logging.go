@@ -9,12 +9,16 @@ import (
 	"github.com/thesabbir/hellfire/pkg/logger"
 )
 
+// RequestIDKey is the gin context key of the request's ID, which is also
+// sent back in the X-Request-ID header
+const RequestIDKey = "request_id"
+
 // RequestLoggingMiddleware logs all HTTP requests with detailed information
 func RequestLoggingMiddleware() gin.HandlerFunc {
 	return func(c *gin.Context) {
 		// Generate unique request ID
 		requestID := uuid.New().String()
-		c.Set("request_id", requestID)
+		c.Set(RequestIDKey, requestID)
 
 		// Add request ID to response headers for traceability
 		c.Writer.Header().Set("X-Request-ID", requestID)
@@ -29,15 +33,16 @@ func RequestLoggingMiddleware() gin.HandlerFunc {
 		clientIP := c.ClientIP()
 		userAgent := c.Request.UserAgent()
 
-		// Get authenticated user if available
+		// Process request
+		c.Next()
+
+		// Get authenticated user if available (set by the auth middleware
+		// while the request was processed)
 		username := "anonymous"
 		if user := auth.GetUser(c); user != nil {
 			username = user.Username
 		}
 
-		// Process request
-		c.Next()
-
 		// Calculate duration
 		duration := time.Since(startTime)
 
@@ -2,20 +2,25 @@ package middleware
 
 import (
 	"fmt"
+	"net"
 	"net/http"
+	"strings"
 	"sync"
 	"time"
 
 	"github.com/gin-gonic/gin"
+	"github.com/thesabbir/hellfire/pkg/auth"
+	apierrors "github.com/thesabbir/hellfire/pkg/errors"
 	"golang.org/x/time/rate"
 )
 
-// IPRateLimiter manages rate limiters per IP address
+// IPRateLimiter manages rate limiters per IP address (or any other key)
 type IPRateLimiter struct {
-	ips map[string]*rate.Limiter
-	mu  *sync.RWMutex
-	r   rate.Limit // requests per second
-	b   int        // burst size
+	ips    map[string]*rate.Limiter
+	mu     *sync.RWMutex
+	r      rate.Limit // requests per second
+	b      int        // burst size
+	exempt RateLimitExemptions
 }
 
 // NewIPRateLimiter creates a new IP-based rate limiter
@@ -38,6 +43,35 @@ func NewIPRateLimiter(requestsPerMinute, burst int) *IPRateLimiter {
 	return limiter
 }
 
+// SetLimit changes the rate and burst, including for clients already being tracked
+func (i *IPRateLimiter) SetLimit(requestsPerMinute, burst int) {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+
+	i.r = rate.Limit(float64(requestsPerMinute) / 60.0)
+	i.b = burst
+	for _, limiter := range i.ips {
+		limiter.SetLimit(i.r)
+		limiter.SetBurst(i.b)
+	}
+}
+
+// SetExempt sets the addresses that bypass this limiter
+func (i *IPRateLimiter) SetExempt(exempt RateLimitExemptions) {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	i.exempt = exempt
+}
+
+// Allow reports whether a request from ip is allowed, consuming a token if so
+func (i *IPRateLimiter) Allow(ip string) bool {
+	i.mu.RLock()
+	exempt := i.exempt.Contains(ip)
+	i.mu.RUnlock()
+
+	return exempt || i.GetLimiter(ip).Allow()
+}
+
 // GetLimiter returns the rate limiter for the given IP
 func (i *IPRateLimiter) GetLimiter(ip string) *rate.Limiter {
 	i.mu.Lock()
@@ -74,11 +108,8 @@ func RateLimitMiddleware(limiter *IPRateLimiter) gin.HandlerFunc {
 	return func(c *gin.Context) {
 		ip := c.ClientIP()
 
-		if !limiter.GetLimiter(ip).Allow() {
-			c.JSON(http.StatusTooManyRequests, gin.H{
-				"error": "rate limit exceeded, please try again later",
-			})
-			c.Abort()
+		if !limiter.Allow(ip) {
+			apierrors.Abort(c, http.StatusTooManyRequests, apierrors.CodeRateLimit)
 			return
 		}
 
@@ -108,13 +139,178 @@ func RateLimitWithHeadersMiddleware(limiter *IPRateLimiter, requestsPerMinute in
 				reservation.Cancel() // Don't actually consume the token
 			}
 
-			c.JSON(http.StatusTooManyRequests, gin.H{
-				"error": "rate limit exceeded, please try again later",
-			})
+			apierrors.Abort(c, http.StatusTooManyRequests, apierrors.CodeRateLimit)
+			return
+		}
+
+		c.Next()
+	}
+}
+
+// RateLimitExemptions is a list of networks that bypass rate limiting
+type RateLimitExemptions []*net.IPNet
+
+// ParseRateLimitExemptions parses IP addresses and CIDRs
+func ParseRateLimitExemptions(entries []string) (RateLimitExemptions, error) {
+	exempt := make(RateLimitExemptions, 0, len(entries))
+	for _, entry := range entries {
+		if !strings.Contains(entry, "/") {
+			ip := net.ParseIP(entry)
+			if ip == nil {
+				return nil, fmt.Errorf("invalid rate limit exemption: %s", entry)
+			}
+			bits := 8 * net.IPv6len
+			if ip.To4() != nil {
+				ip, bits = ip.To4(), 8*net.IPv4len
+			}
+			exempt = append(exempt, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+			continue
+		}
+
+		_, network, err := net.ParseCIDR(entry)
+		if err != nil {
+			return nil, fmt.Errorf("invalid rate limit exemption: %w", err)
+		}
+		exempt = append(exempt, network)
+	}
+	return exempt, nil
+}
+
+// Contains reports whether ip is exempt
+func (e RateLimitExemptions) Contains(ip string) bool {
+	if len(e) == 0 {
+		return false
+	}
+
+	parsed := net.ParseIP(ip)
+	if parsed == nil {
+		return false
+	}
+
+	for _, network := range e {
+		if network.Contains(parsed) {
+			return true
+		}
+	}
+	return false
+}
+
+// Rate limit classes for API routes
+const (
+	RateLimitRead        = "read"
+	RateLimitWrite       = "write"
+	RateLimitDiagnostics = "diagnostics"
+)
+
+// RateLimitClass holds the limits for one class of routes. A zero rate
+// disables that limit.
+type RateLimitClass struct {
+	RequestsPerMinute     int
+	Burst                 int
+	UserRequestsPerMinute int
+	UserBurst             int
+}
+
+// RateLimitPolicy limits classes of routes per client IP and per
+// authenticated user, so one user can't exhaust the router from many
+// addresses and one address can't exhaust it with many accounts
+type RateLimitPolicy struct {
+	mu      sync.RWMutex
+	classes map[string]RateLimitClass
+	ip      map[string]*IPRateLimiter
+	user    map[string]*IPRateLimiter
+	exempt  RateLimitExemptions
+}
+
+// NewRateLimitPolicy creates limiters for each configured class
+func NewRateLimitPolicy(classes map[string]RateLimitClass, exempt RateLimitExemptions) *RateLimitPolicy {
+	p := &RateLimitPolicy{
+		ip:   make(map[string]*IPRateLimiter),
+		user: make(map[string]*IPRateLimiter),
+	}
+	p.Update(classes, exempt)
+	return p
+}
+
+// Update replaces the class limits and exemptions. Limiters are reused so
+// clients keep their current budget under the new limits.
+func (p *RateLimitPolicy) Update(classes map[string]RateLimitClass, exempt RateLimitExemptions) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	for name, class := range classes {
+		if limiter, ok := p.ip[name]; ok {
+			limiter.SetLimit(class.RequestsPerMinute, class.Burst)
+		} else if class.RequestsPerMinute > 0 {
+			p.ip[name] = NewIPRateLimiter(class.RequestsPerMinute, class.Burst)
+		}
+
+		if limiter, ok := p.user[name]; ok {
+			limiter.SetLimit(class.UserRequestsPerMinute, class.UserBurst)
+		} else if class.UserRequestsPerMinute > 0 {
+			p.user[name] = NewIPRateLimiter(class.UserRequestsPerMinute, class.UserBurst)
+		}
+	}
+
+	p.classes = classes
+	p.exempt = exempt
+}
+
+// Limit returns a middleware applying the given class. Place it after
+// authentication so the per-user limit can see the user.
+func (p *RateLimitPolicy) Limit(class string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if !p.allow(c, class) {
 			c.Abort()
 			return
 		}
+		c.Next()
+	}
+}
+
+// LimitByMethod applies the read class to safe methods and the write class to everything else
+func (p *RateLimitPolicy) LimitByMethod() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		class := RateLimitWrite
+		switch c.Request.Method {
+		case http.MethodGet, http.MethodHead, http.MethodOptions:
+			class = RateLimitRead
+		}
 
+		if !p.allow(c, class) {
+			c.Abort()
+			return
+		}
 		c.Next()
 	}
 }
+
+// allow checks both limits for the class and writes the 429 response if one is exceeded
+func (p *RateLimitPolicy) allow(c *gin.Context, class string) bool {
+	ip := c.ClientIP()
+
+	p.mu.RLock()
+	limits := p.classes[class]
+	ipLimiter := p.ip[class]
+	userLimiter := p.user[class]
+	exempt := p.exempt.Contains(ip)
+	p.mu.RUnlock()
+
+	if exempt {
+		return true
+	}
+
+	if limits.RequestsPerMinute > 0 && !ipLimiter.GetLimiter(ip).Allow() {
+		apierrors.Respond(c, http.StatusTooManyRequests, apierrors.CodeRateLimit, nil)
+		return false
+	}
+
+	if limits.UserRequestsPerMinute > 0 {
+		if user := auth.GetUser(c); user != nil && !userLimiter.GetLimiter(fmt.Sprintf("user:%d", user.ID)).Allow() {
+			apierrors.Respond(c, http.StatusTooManyRequests, apierrors.CodeRateLimit, nil)
+			return false
+		}
+	}
+
+	return true
+}
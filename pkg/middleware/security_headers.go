@@ -1,56 +1,118 @@
 package middleware
 
 import (
+	"crypto/rand"
+	"encoding/base64"
+	"strings"
+
 	"github.com/gin-gonic/gin"
 )
 
-// SecurityHeadersMiddleware adds security headers to all responses
-func SecurityHeadersMiddleware() gin.HandlerFunc {
-	return func(c *gin.Context) {
-		// Prevent MIME type sniffing
-		c.Header("X-Content-Type-Options", "nosniff")
+const (
+	// CSPNoncePlaceholder is replaced with the request's nonce in the
+	// Content-Security-Policy header and in the served index.html
+	CSPNoncePlaceholder = "__CSP_NONCE__"
 
-		// Prevent clickjacking attacks
-		c.Header("X-Frame-Options", "DENY")
-
-		// Enable XSS protection (legacy but still useful)
-		c.Header("X-XSS-Protection", "1; mode=block")
+	// cspNonceKey is the gin context key of the request's CSP nonce
+	cspNonceKey = "csp_nonce"
+)
 
-		// Control referrer information
-		c.Header("Referrer-Policy", "strict-origin-when-cross-origin")
+// SecurityHeaders are the security header values sent with every response.
+// An empty value leaves the header out.
+type SecurityHeaders struct {
+	ContentSecurityPolicy string // May contain CSPNoncePlaceholder
+	FrameOptions          string
+	ReferrerPolicy        string
+	PermissionsPolicy     string
+	HSTS                  string // Only sent over HTTPS
+}
 
-		// Content Security Policy
-		// Restrictive policy: only allow resources from same origin
-		csp := "default-src 'self'; " +
-			"script-src 'self'; " +
+// DefaultSecurityHeaders returns the built-in header values
+func DefaultSecurityHeaders() SecurityHeaders {
+	return SecurityHeaders{
+		// Restrictive policy: only allow resources from same origin, and
+		// inline scripts carrying the request's nonce
+		ContentSecurityPolicy: "default-src 'self'; " +
+			"script-src 'self' 'nonce-" + CSPNoncePlaceholder + "'; " +
 			"style-src 'self' 'unsafe-inline'; " + // unsafe-inline needed for some UI frameworks
 			"img-src 'self' data:; " + // data: for base64 images
 			"font-src 'self'; " +
 			"connect-src 'self'; " +
 			"frame-ancestors 'none'; " +
 			"base-uri 'self'; " +
-			"form-action 'self'"
-		c.Header("Content-Security-Policy", csp)
+			"form-action 'self'",
+
+		// Prevent clickjacking attacks
+		FrameOptions: "DENY",
+
+		// Control referrer information
+		ReferrerPolicy: "strict-origin-when-cross-origin",
 
-		// Permissions Policy (formerly Feature-Policy)
 		// Disable potentially dangerous features
-		permissions := "camera=(), " +
+		PermissionsPolicy: "camera=(), " +
 			"microphone=(), " +
 			"geolocation=(), " +
 			"payment=(), " +
 			"usb=(), " +
 			"magnetometer=(), " +
 			"gyroscope=(), " +
-			"accelerometer=()"
-		c.Header("Permissions-Policy", permissions)
+			"accelerometer=()",
+
+		// 1 year, includeSubDomains
+		HSTS: "max-age=31536000; includeSubDomains",
+	}
+}
 
-		// HSTS (HTTP Strict Transport Security)
-		// Only enable if using HTTPS (check if request is secure)
+// SecurityHeadersMiddleware adds security headers to all responses, with the
+// values returned by headers, which is called on every request so they can
+// be changed at runtime. Each request gets a fresh CSP nonce (see CSPNonce).
+func SecurityHeadersMiddleware(headers func() *SecurityHeaders) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		h := headers()
+
+		// Prevent MIME type sniffing
+		c.Header("X-Content-Type-Options", "nosniff")
+
+		// Enable XSS protection (legacy but still useful)
+		c.Header("X-XSS-Protection", "1; mode=block")
+
+		if h.ContentSecurityPolicy != "" {
+			csp := h.ContentSecurityPolicy
+			if strings.Contains(csp, CSPNoncePlaceholder) {
+				nonce := generateNonce()
+				c.Set(cspNonceKey, nonce)
+				csp = strings.ReplaceAll(csp, CSPNoncePlaceholder, nonce)
+			}
+			c.Header("Content-Security-Policy", csp)
+		}
+
+		setHeader(c, "X-Frame-Options", h.FrameOptions)
+		setHeader(c, "Referrer-Policy", h.ReferrerPolicy)
+		setHeader(c, "Permissions-Policy", h.PermissionsPolicy)
+
+		// HSTS only applies to HTTPS (directly or behind a TLS-terminating proxy)
 		if c.Request.TLS != nil || c.GetHeader("X-Forwarded-Proto") == "https" {
-			// max-age=31536000 (1 year), includeSubDomains
-			c.Header("Strict-Transport-Security", "max-age=31536000; includeSubDomains")
+			setHeader(c, "Strict-Transport-Security", h.HSTS)
 		}
 
 		c.Next()
 	}
 }
+
+// CSPNonce returns the request's CSP nonce, or "" if the policy doesn't use one
+func CSPNonce(c *gin.Context) string {
+	return c.GetString(cspNonceKey)
+}
+
+func setHeader(c *gin.Context, name, value string) {
+	if value != "" {
+		c.Header(name, value)
+	}
+}
+
+// generateNonce returns 128 random bits, base64 encoded
+func generateNonce() string {
+	b := make([]byte, 16)
+	_, _ = rand.Read(b) // crypto/rand.Read doesn't fail
+	return base64.StdEncoding.EncodeToString(b)
+}
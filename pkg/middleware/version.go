@@ -0,0 +1,70 @@
+package middleware
+
+import (
+	"net/http"
+	"regexp"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	// APIVersionHeader carries the requested API version on requests and the served version on responses
+	APIVersionHeader = "API-Version"
+
+	// CurrentAPIVersion is the newest API version
+	CurrentAPIVersion = "1"
+)
+
+// SupportedAPIVersions lists every API version the server can serve
+var SupportedAPIVersions = []string{"1"}
+
+// vendorMediaType matches versioned media types like application/vnd.hellfire.v1+json
+var vendorMediaType = regexp.MustCompile(`application/vnd\.hellfire\.v(\d+)\+json`)
+
+// APIVersionMiddleware marks responses with the API version a route group serves.
+// Clients may pin a version with the API-Version header or an
+// application/vnd.hellfire.vN+json Accept type; asking for a version the
+// group doesn't serve fails with 406 instead of silently getting another shape.
+func APIVersionMiddleware(version string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if requested := RequestedAPIVersion(c); requested != "" && requested != version {
+			c.JSON(http.StatusNotAcceptable, gin.H{
+				"error":     "unsupported API version",
+				"requested": requested,
+				"served":    version,
+				"supported": SupportedAPIVersions,
+			})
+			c.Abort()
+			return
+		}
+
+		c.Header(APIVersionHeader, version)
+		c.Next()
+	}
+}
+
+// RequestedAPIVersion returns the API version requested by the client, or "" if none
+func RequestedAPIVersion(c *gin.Context) string {
+	if version := strings.TrimPrefix(strings.TrimSpace(c.GetHeader(APIVersionHeader)), "v"); version != "" {
+		return version
+	}
+
+	if match := vendorMediaType.FindStringSubmatch(c.GetHeader("Accept")); match != nil {
+		return match[1]
+	}
+
+	return ""
+}
+
+// DeprecatedPathMiddleware marks responses from a deprecated path prefix and
+// points clients at the path that replaces it (oldPrefix is swapped for newPrefix).
+func DeprecatedPathMiddleware(oldPrefix, newPrefix string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		successor := newPrefix + strings.TrimPrefix(c.Request.URL.Path, oldPrefix)
+
+		c.Header("Deprecation", "true")
+		c.Header("Link", "<"+successor+">; rel=\"successor-version\"")
+		c.Next()
+	}
+}
@@ -0,0 +1,485 @@
+// Package modem connects cellular (LTE/5G) modems through ModemManager and
+// reports their signal and registration. Modems are configured in the network
+// config the OpenWrt way: an interface section, named after the modem's
+// network device (wwan0), with proto 'modemmanager' or 'qmi'. The state is
+// read from `mmcli -J`.
+package modem
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os/exec"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/thesabbir/hellfire/pkg/uci"
+)
+
+// Protos of modem interface sections. Both connect through ModemManager,
+// which drives QMI modems with libqmi; a qmi interface names the modem by
+// its control device (/dev/cdc-wdm0), as OpenWrt's uqmi does.
+const (
+	ProtoModemManager = "modemmanager"
+	ProtoQMI          = "qmi"
+)
+
+var (
+	// ErrUnavailable is returned when mmcli isn't installed
+	ErrUnavailable = errors.New("ModemManager (mmcli) not installed")
+
+	ErrNotFound = errors.New("modem not found")
+	ErrInvalid  = errors.New("invalid modem config")
+)
+
+// IP types and authentication methods of a connection
+var (
+	IPTypes = []string{"ipv4", "ipv6", "ipv4v6"}
+	Auths   = []string{"none", "pap", "chap", "both"}
+)
+
+// IsProto reports whether an interface proto is a modem's
+func IsProto(proto string) bool {
+	return proto == ProtoModemManager || proto == ProtoQMI
+}
+
+// Settings is how a modem interface connects
+type Settings struct {
+	Interface    string `json:"interface"` // Network device of the modem
+	Proto        string `json:"proto"`
+	Device       string `json:"device,omitempty"` // Sysfs path of the modem, or its control device for qmi
+	APN          string `json:"apn,omitempty"`
+	PIN          string `json:"-"`
+	Username     string `json:"username,omitempty"`
+	Password     string `json:"-"`
+	Auth         string `json:"auth,omitempty"`
+	IPType       string `json:"iptype"`
+	AllowRoaming bool   `json:"allow_roaming"`
+}
+
+// ParseSettings reads and checks the settings of a modem interface section:
+// device, apn, pincode, username, password, auth (none, pap, chap or both),
+// iptype (ipv4, ipv6 or ipv4v6, the default) and allow_roaming
+func ParseSettings(section *uci.Section) (Settings, error) {
+	s := Settings{Interface: section.Name, IPType: "ipv4v6"}
+	s.Proto, _ = section.GetOption("proto")
+	if !IsProto(s.Proto) {
+		return s, fmt.Errorf("%w: proto %q is not a modem's", ErrInvalid, s.Proto)
+	}
+
+	s.Device, _ = section.GetOption("device")
+	switch {
+	case s.Proto == ProtoQMI && !strings.HasPrefix(s.Device, "/dev/"):
+		return s, fmt.Errorf("%w: qmi interface needs the modem's control device (device '/dev/cdc-wdm0')", ErrInvalid)
+	case s.Device != "" && !filepath.IsAbs(s.Device):
+		return s, fmt.Errorf("%w: device %q must be the modem's sysfs path or control device", ErrInvalid, s.Device)
+	}
+
+	// Values are passed to ModemManager as key=value,key=value
+	for _, option := range []string{"apn", "username", "password"} {
+		value, _ := section.GetOption(option)
+		if strings.ContainsAny(value, ",=\"' \t") {
+			return s, fmt.Errorf("%w: %s can't contain commas, quotes, '=' or spaces", ErrInvalid, option)
+		}
+	}
+	s.APN, _ = section.GetOption("apn")
+	s.Username, _ = section.GetOption("username")
+	s.Password, _ = section.GetOption("password")
+
+	if pin, ok := section.GetOption("pincode"); ok && pin != "" {
+		if _, err := strconv.Atoi(pin); err != nil || len(pin) < 4 || len(pin) > 8 {
+			return s, fmt.Errorf("%w: pincode must be 4-8 digits", ErrInvalid)
+		}
+		s.PIN = pin
+	}
+	if auth, ok := section.GetOption("auth"); ok && auth != "" {
+		if !slices.Contains(Auths, auth) {
+			return s, fmt.Errorf("%w: auth must be one of %s", ErrInvalid, strings.Join(Auths, ", "))
+		}
+		s.Auth = auth
+	}
+	if s.Username != "" && s.Auth == "none" {
+		return s, fmt.Errorf("%w: username given with auth 'none'", ErrInvalid)
+	}
+	if ipType, ok := section.GetOption("iptype"); ok && ipType != "" {
+		if !slices.Contains(IPTypes, ipType) {
+			return s, fmt.Errorf("%w: iptype must be one of %s", ErrInvalid, strings.Join(IPTypes, ", "))
+		}
+		s.IPType = ipType
+	}
+	roaming, _ := section.GetOption("allow_roaming")
+	s.AllowRoaming = roaming == "1"
+	return s, nil
+}
+
+// ConnectArgs returns the mmcli --simple-connect properties of the settings.
+// With redact the PIN and password are masked, for display.
+func (s Settings) ConnectArgs(redact bool) string {
+	mask := func(value string) string {
+		if redact {
+			return "***"
+		}
+		return value
+	}
+
+	args := []string{"ip-type=" + s.IPType, "allow-roaming=" + yesNo(s.AllowRoaming)}
+	if s.APN != "" {
+		args = append([]string{"apn=" + s.APN}, args...)
+	}
+	if s.Username != "" {
+		args = append(args, "user="+s.Username)
+	}
+	if s.Password != "" {
+		args = append(args, "password="+mask(s.Password))
+	}
+	switch s.Auth {
+	case "none", "pap", "chap":
+		args = append(args, "allowed-auth="+s.Auth)
+	case "both":
+		args = append(args, "allowed-auth=pap|chap")
+	}
+	if s.PIN != "" {
+		args = append(args, "pin="+mask(s.PIN))
+	}
+	return strings.Join(args, ",")
+}
+
+// Modem is a modem as ModemManager reports it
+type Modem struct {
+	Path               string   `json:"path"`   // D-Bus path, which mmcli -m takes
+	Device             string   `json:"device"` // Sysfs path
+	Manufacturer       string   `json:"manufacturer,omitempty"`
+	Model              string   `json:"model,omitempty"`
+	State              string   `json:"state"` // disabled, registered, connected...
+	FailedReason       string   `json:"failed_reason,omitempty"`
+	UnlockRequired     string   `json:"unlock_required,omitempty"` // sim-pin, sim-puk...
+	Ports              []string `json:"ports"`                     // "cdc-wdm0 (qmi)", "wwan0 (net)"...
+	NetDevice          string   `json:"net_device,omitempty"`      // The first net port
+	SignalQuality      int      `json:"signal_quality"`            // Percent
+	AccessTechnologies []string `json:"access_technologies,omitempty"`
+	Registration       string   `json:"registration,omitempty"` // home, roaming, searching, denied...
+	Operator           string   `json:"operator,omitempty"`
+	Roaming            bool     `json:"roaming"`
+	Interface          string   `json:"interface,omitempty"` // Configured interface section, if any
+	Bearer             *Bearer  `json:"bearer,omitempty"`    // The connected bearer
+	Bearers            []string `json:"-"`
+}
+
+// Bearer is a data connection of a modem
+type Bearer struct {
+	Path      string    `json:"path"`
+	Interface string    `json:"interface,omitempty"` // Network device carrying it
+	Connected bool      `json:"connected"`
+	IPv4      *IPConfig `json:"ipv4,omitempty"`
+	IPv6      *IPConfig `json:"ipv6,omitempty"`
+}
+
+// IPConfig is how a bearer's addresses are configured
+type IPConfig struct {
+	Method  string   `json:"method"` // static, dhcp or ppp
+	Address string   `json:"address,omitempty"`
+	Prefix  int      `json:"prefix,omitempty"`
+	Gateway string   `json:"gateway,omitempty"`
+	DNS     []string `json:"dns,omitempty"`
+	MTU     int      `json:"mtu,omitempty"`
+}
+
+// CIDR returns the address with its prefix length
+func (c *IPConfig) CIDR() string {
+	return c.Address + "/" + strconv.Itoa(c.Prefix)
+}
+
+// DNS returns the DNS servers of the bearer
+func (b *Bearer) DNS() []string {
+	var servers []string
+	for _, config := range []*IPConfig{b.IPv4, b.IPv6} {
+		if config != nil {
+			servers = append(servers, config.DNS...)
+		}
+	}
+	return servers
+}
+
+// ParseModem parses the output of `mmcli -m <modem> -J`
+func ParseModem(output []byte) (*Modem, error) {
+	var dump struct {
+		Modem struct {
+			DBusPath string `json:"dbus-path"`
+			Generic  struct {
+				AccessTechnologies []string `json:"access-technologies"`
+				Bearers            []string `json:"bearers"`
+				Device             string   `json:"device"`
+				Manufacturer       string   `json:"manufacturer"`
+				Model              string   `json:"model"`
+				Ports              []string `json:"ports"`
+				SignalQuality      struct {
+					Value string `json:"value"`
+				} `json:"signal-quality"`
+				State             string `json:"state"`
+				StateFailedReason string `json:"state-failed-reason"`
+				UnlockRequired    string `json:"unlock-required"`
+			} `json:"generic"`
+			ThreeGPP struct {
+				OperatorName      string `json:"operator-name"`
+				RegistrationState string `json:"registration-state"`
+			} `json:"3gpp"`
+		} `json:"modem"`
+	}
+	if err := json.Unmarshal(output, &dump); err != nil {
+		return nil, fmt.Errorf("failed to parse modem: %w", err)
+	}
+
+	generic := dump.Modem.Generic
+	m := &Modem{
+		Path:           dump.Modem.DBusPath,
+		Device:         value(generic.Device),
+		Manufacturer:   value(generic.Manufacturer),
+		Model:          value(generic.Model),
+		State:          value(generic.State),
+		FailedReason:   value(generic.StateFailedReason),
+		UnlockRequired: value(generic.UnlockRequired),
+		Ports:          generic.Ports,
+		Registration:   value(dump.Modem.ThreeGPP.RegistrationState),
+		Operator:       value(dump.Modem.ThreeGPP.OperatorName),
+		Bearers:        generic.Bearers,
+	}
+	if m.Ports == nil {
+		m.Ports = []string{}
+	}
+	m.SignalQuality, _ = strconv.Atoi(generic.SignalQuality.Value)
+	for _, technology := range generic.AccessTechnologies {
+		if technology = value(technology); technology != "" {
+			m.AccessTechnologies = append(m.AccessTechnologies, technology)
+		}
+	}
+	m.Roaming = m.Registration == "roaming"
+	for _, port := range m.Ports {
+		if name, ok := strings.CutSuffix(port, " (net)"); ok {
+			m.NetDevice = name
+			break
+		}
+	}
+	return m, nil
+}
+
+// ParseBearer parses the output of `mmcli -b <bearer> -J`
+func ParseBearer(output []byte) (*Bearer, error) {
+	type ipConfig struct {
+		Method  string   `json:"method"`
+		Address string   `json:"address"`
+		Prefix  string   `json:"prefix"`
+		Gateway string   `json:"gateway"`
+		DNS     []string `json:"dns"`
+		MTU     string   `json:"mtu"`
+	}
+	var dump struct {
+		Bearer struct {
+			DBusPath string   `json:"dbus-path"`
+			IPv4     ipConfig `json:"ipv4-config"`
+			IPv6     ipConfig `json:"ipv6-config"`
+			Status   struct {
+				Connected string `json:"connected"`
+				Interface string `json:"interface"`
+			} `json:"status"`
+		} `json:"bearer"`
+	}
+	if err := json.Unmarshal(output, &dump); err != nil {
+		return nil, fmt.Errorf("failed to parse bearer: %w", err)
+	}
+
+	convert := func(c ipConfig) *IPConfig {
+		if value(c.Method) == "" {
+			return nil
+		}
+		config := &IPConfig{Method: c.Method, Address: value(c.Address), Gateway: value(c.Gateway)}
+		config.Prefix, _ = strconv.Atoi(c.Prefix)
+		config.MTU, _ = strconv.Atoi(c.MTU)
+		for _, server := range c.DNS {
+			if server = value(server); server != "" {
+				config.DNS = append(config.DNS, server)
+			}
+		}
+		return config
+	}
+	bearer := dump.Bearer
+	return &Bearer{
+		Path:      bearer.DBusPath,
+		Interface: value(bearer.Status.Interface),
+		Connected: bearer.Status.Connected == "yes",
+		IPv4:      convert(bearer.IPv4),
+		IPv6:      convert(bearer.IPv6),
+	}, nil
+}
+
+// Matches reports whether the settings name this modem: by its sysfs path or
+// one of its ports, or without a device by its network device
+func (m *Modem) Matches(s Settings) bool {
+	if s.Device == "" {
+		return m.NetDevice == s.Interface
+	}
+	if m.Device == s.Device {
+		return true
+	}
+	port := filepath.Base(s.Device)
+	return slices.ContainsFunc(m.Ports, func(p string) bool {
+		name, _, _ := strings.Cut(p, " ")
+		return name == port
+	})
+}
+
+// List reads the modems known to ModemManager with their connected bearer
+func List(ctx context.Context) ([]Modem, error) {
+	output, err := mmcli(ctx, "-L", "-J")
+	if err != nil {
+		return nil, err
+	}
+	var list struct {
+		Modems []string `json:"modem-list"`
+	}
+	if err := json.Unmarshal(output, &list); err != nil {
+		return nil, fmt.Errorf("failed to parse modem list: %w", err)
+	}
+
+	modems := make([]Modem, 0, len(list.Modems))
+	for _, path := range list.Modems {
+		m, err := load(ctx, path)
+		if err != nil {
+			return nil, err
+		}
+		modems = append(modems, *m)
+	}
+	return modems, nil
+}
+
+// Find returns the modem the settings name
+func Find(ctx context.Context, s Settings) (*Modem, error) {
+	modems, err := List(ctx)
+	if err != nil {
+		return nil, err
+	}
+	for i := range modems {
+		if modems[i].Matches(s) {
+			return &modems[i], nil
+		}
+	}
+	if s.Device != "" {
+		return nil, fmt.Errorf("%w: no modem at %s", ErrNotFound, s.Device)
+	}
+	return nil, fmt.Errorf("%w: no modem with network device %s", ErrNotFound, s.Interface)
+}
+
+// Status lists the modems, marking those of the modem interfaces of a network
+// config, and the modem interfaces whose modem is missing
+func Status(ctx context.Context, network *uci.Config) ([]Modem, []string, error) {
+	modems, err := List(ctx)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var missing []string
+	for _, section := range network.GetSectionsByType("interface") {
+		s, err := ParseSettings(section)
+		if err != nil {
+			continue
+		}
+		i := slices.IndexFunc(modems, func(m Modem) bool { return m.Matches(s) })
+		if i < 0 {
+			missing = append(missing, s.Interface)
+			continue
+		}
+		modems[i].Interface = s.Interface
+	}
+	return modems, missing, nil
+}
+
+// Connect connects the modem the settings name, unlocking its SIM and
+// registering first as needed, and returns it with its connected bearer. A
+// connection it already has is dropped first, so changed settings apply.
+// The PIN and password are passed on mmcli's command line.
+func Connect(ctx context.Context, s Settings) (*Modem, error) {
+	m, err := Find(ctx, s)
+	if err != nil {
+		return nil, err
+	}
+	_, _ = mmcli(ctx, "-m", m.Path, "--simple-disconnect")
+	if _, err := mmcli(ctx, "-m", m.Path, "--simple-connect="+s.ConnectArgs(false)); err != nil {
+		return nil, fmt.Errorf("failed to connect: %w", err)
+	}
+
+	if m, err = load(ctx, m.Path); err != nil {
+		return nil, err
+	}
+	if m.Bearer == nil {
+		return nil, fmt.Errorf("modem %s has no connected bearer", m.Path)
+	}
+	return m, nil
+}
+
+// Disconnect drops the connections of a modem
+func Disconnect(ctx context.Context, path string) error {
+	if _, err := mmcli(ctx, "-m", path, "--simple-disconnect"); err != nil {
+		return fmt.Errorf("failed to disconnect: %w", err)
+	}
+	return nil
+}
+
+// load reads a modem and its connected bearer
+func load(ctx context.Context, path string) (*Modem, error) {
+	output, err := mmcli(ctx, "-m", path, "-J")
+	if err != nil {
+		return nil, err
+	}
+	m, err := ParseModem(output)
+	if err != nil {
+		return nil, err
+	}
+	for _, bearerPath := range m.Bearers {
+		output, err := mmcli(ctx, "-b", bearerPath, "-J")
+		if err != nil {
+			return nil, err
+		}
+		bearer, err := ParseBearer(output)
+		if err != nil {
+			return nil, err
+		}
+		if bearer.Connected {
+			m.Bearer = bearer
+			break
+		}
+	}
+	return m, nil
+}
+
+// mmcli runs mmcli
+func mmcli(ctx context.Context, args ...string) ([]byte, error) {
+	if _, err := exec.LookPath("mmcli"); err != nil {
+		return nil, ErrUnavailable
+	}
+	cmd := exec.CommandContext(ctx, "mmcli", args...)
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	output, err := cmd.Output()
+	if err != nil {
+		return nil, fmt.Errorf("mmcli: %s: %w", strings.TrimSpace(stderr.String()), err)
+	}
+	return output, nil
+}
+
+// value maps mmcli's "--" for unknown to ""
+func value(s string) string {
+	if s == "--" {
+		return ""
+	}
+	return s
+}
+
+func yesNo(b bool) string {
+	if b {
+		return "yes"
+	}
+	return "no"
+}
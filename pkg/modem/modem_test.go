@@ -0,0 +1,113 @@
+package modem
+
+import (
+	"errors"
+	"slices"
+	"testing"
+
+	"github.com/thesabbir/hellfire/pkg/uci"
+)
+
+const modemJSON = `{"modem": {
+  "dbus-path": "/org/freedesktop/ModemManager1/Modem/0",
+  "generic": {
+    "access-technologies": ["lte"],
+    "bearers": ["/org/freedesktop/ModemManager1/Bearer/1"],
+    "device": "/sys/devices/platform/soc/1c1b000.usb/usb2/2-1",
+    "manufacturer": "Quectel",
+    "model": "EC25",
+    "ports": ["cdc-wdm0 (qmi)", "ttyUSB2 (at)", "wwan0 (net)"],
+    "signal-quality": {"recent": "yes", "value": "67"},
+    "state": "connected",
+    "state-failed-reason": "--",
+    "unlock-required": "--"
+  },
+  "3gpp": {"operator-name": "Example", "registration-state": "roaming"}
+}}`
+
+const bearerJSON = `{"bearer": {
+  "dbus-path": "/org/freedesktop/ModemManager1/Bearer/1",
+  "ipv4-config": {"address": "10.64.1.2", "dns": ["10.0.0.53", "10.0.1.53"], "gateway": "10.64.1.1", "method": "static", "mtu": "1500", "prefix": "30"},
+  "ipv6-config": {"address": "--", "dns": [], "gateway": "--", "method": "--", "mtu": "--", "prefix": "--"},
+  "status": {"connected": "yes", "interface": "wwan0"}
+}}`
+
+func TestParseModem(t *testing.T) {
+	m, err := ParseModem([]byte(modemJSON))
+	if err != nil {
+		t.Fatalf("ParseModem: %v", err)
+	}
+	if m.Path != "/org/freedesktop/ModemManager1/Modem/0" || m.NetDevice != "wwan0" || m.SignalQuality != 67 ||
+		m.Registration != "roaming" || !m.Roaming || m.Operator != "Example" || m.FailedReason != "" ||
+		!slices.Equal(m.AccessTechnologies, []string{"lte"}) || len(m.Bearers) != 1 {
+		t.Errorf("modem = %+v", m)
+	}
+
+	for _, s := range []Settings{
+		{Interface: "wwan0"},
+		{Interface: "wwan1", Device: "/dev/cdc-wdm0"},
+		{Interface: "wwan1", Device: "/sys/devices/platform/soc/1c1b000.usb/usb2/2-1"},
+	} {
+		if !m.Matches(s) {
+			t.Errorf("modem doesn't match %+v", s)
+		}
+	}
+	if m.Matches(Settings{Interface: "wwan1"}) || m.Matches(Settings{Interface: "wwan0", Device: "/dev/cdc-wdm1"}) {
+		t.Error("modem matches another modem's settings")
+	}
+}
+
+func TestParseBearer(t *testing.T) {
+	b, err := ParseBearer([]byte(bearerJSON))
+	if err != nil {
+		t.Fatalf("ParseBearer: %v", err)
+	}
+	if !b.Connected || b.Interface != "wwan0" || b.IPv6 != nil || b.IPv4 == nil {
+		t.Fatalf("bearer = %+v", b)
+	}
+	if b.IPv4.CIDR() != "10.64.1.2/30" || b.IPv4.Gateway != "10.64.1.1" || b.IPv4.MTU != 1500 {
+		t.Errorf("ipv4 = %+v", b.IPv4)
+	}
+	if dns := b.DNS(); !slices.Equal(dns, []string{"10.0.0.53", "10.0.1.53"}) {
+		t.Errorf("dns = %v", dns)
+	}
+}
+
+func TestParseSettings(t *testing.T) {
+	section := uci.NewSection("interface", "wwan0")
+	section.SetOption("proto", ProtoQMI)
+	section.SetOption("device", "/dev/cdc-wdm0")
+	section.SetOption("apn", "internet")
+	section.SetOption("pincode", "1234")
+	section.SetOption("username", "user")
+	section.SetOption("password", "secret")
+	section.SetOption("auth", "both")
+	section.SetOption("iptype", "ipv4")
+	section.SetOption("allow_roaming", "1")
+
+	s, err := ParseSettings(section)
+	if err != nil {
+		t.Fatalf("ParseSettings: %v", err)
+	}
+	want := "apn=internet,ip-type=ipv4,allow-roaming=yes,user=user,password=secret,allowed-auth=pap|chap,pin=1234"
+	if args := s.ConnectArgs(false); args != want {
+		t.Errorf("ConnectArgs = %q, want %q", args, want)
+	}
+	if args := s.ConnectArgs(true); args != "apn=internet,ip-type=ipv4,allow-roaming=yes,user=user,password=***,allowed-auth=pap|chap,pin=***" {
+		t.Errorf("redacted ConnectArgs = %q", args)
+	}
+
+	for option, value := range map[string]string{
+		"device":  "cdc-wdm0",
+		"apn":     "internet,pin=0000",
+		"pincode": "12a4",
+		"auth":    "mschap",
+		"iptype":  "ipv5",
+	} {
+		bad := section.Clone()
+		bad.SetOption(option, value)
+		if _, err := ParseSettings(bad); !errors.Is(err, ErrInvalid) {
+			t.Errorf("%s %q: err = %v, want ErrInvalid", option, value, err)
+		}
+	}
+}
@@ -0,0 +1,180 @@
+// Package netdetect discovers the network interfaces of the router from
+// sysfs: their kind, link state, driver and addresses.
+package netdetect
+
+import (
+	"bufio"
+	"net"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+const (
+	DefaultSysDir    = "/sys/class/net"
+	DefaultRouteFile = "/proc/net/route"
+)
+
+// Interface kinds
+const (
+	KindLoopback = "loopback"
+	KindEthernet = "ethernet"
+	KindWireless = "wireless"
+	KindBridge   = "bridge"
+	KindVLAN     = "vlan"
+	KindVirtual  = "virtual" // Tunnels, veth, dummy...
+)
+
+// Interface is a network interface as the kernel sees it
+type Interface struct {
+	Name         string   `json:"name"`
+	Kind         string   `json:"kind"`
+	Physical     bool     `json:"physical"` // Backed by a device (NIC, radio)
+	MAC          string   `json:"mac,omitempty"`
+	MTU          int      `json:"mtu"`
+	Up           bool     `json:"up"`                   // Administratively up
+	Carrier      bool     `json:"carrier"`              // Link detected
+	SpeedMbps    int      `json:"speed_mbps,omitempty"` // 0 when unknown
+	Driver       string   `json:"driver,omitempty"`
+	Addresses    []string `json:"addresses,omitempty"` // CIDR
+	DefaultRoute bool     `json:"default_route"`       // Carries the IPv4 default route
+}
+
+// simulated replaces the system's interfaces when set
+var simulated []Interface
+
+// Simulate makes Detect return the given interfaces instead of the system's,
+// for a server applying to a simulated system. Call it before Detect is used.
+func Simulate(interfaces []Interface) {
+	simulated = interfaces
+}
+
+// Detect lists the interfaces of the system, physical ones first
+func Detect() ([]Interface, error) {
+	if simulated != nil {
+		return slices.Clone(simulated), nil
+	}
+	return detect(DefaultSysDir, DefaultRouteFile)
+}
+
+func detect(sysDir, routeFile string) ([]Interface, error) {
+	entries, err := os.ReadDir(sysDir)
+	if err != nil {
+		return nil, err
+	}
+
+	routed := defaultRouteInterfaces(routeFile)
+	interfaces := make([]Interface, 0, len(entries))
+	for _, entry := range entries {
+		iface := inspect(filepath.Join(sysDir, entry.Name()))
+		iface.DefaultRoute = routed[iface.Name]
+		interfaces = append(interfaces, iface)
+	}
+
+	sort.SliceStable(interfaces, func(i, j int) bool {
+		if interfaces[i].Physical != interfaces[j].Physical {
+			return interfaces[i].Physical
+		}
+		return interfaces[i].Name < interfaces[j].Name
+	})
+	return interfaces, nil
+}
+
+// inspect reads an interface's sysfs directory
+func inspect(dir string) Interface {
+	iface := Interface{Name: filepath.Base(dir)}
+
+	iface.MAC = readString(dir, "address")
+	iface.MTU = readInt(dir, "mtu")
+	if flags, err := strconv.ParseInt(readString(dir, "flags"), 0, 64); err == nil {
+		iface.Up = flags&1 != 0 // IFF_UP
+	}
+	// Reading carrier fails while the interface is down
+	iface.Carrier = readString(dir, "carrier") == "1"
+	if speed := readInt(dir, "speed"); speed > 0 {
+		iface.SpeedMbps = speed
+	}
+
+	_, err := os.Stat(filepath.Join(dir, "device"))
+	iface.Physical = err == nil
+	if driver, err := os.Readlink(filepath.Join(dir, "device", "driver")); err == nil {
+		iface.Driver = filepath.Base(driver)
+	}
+
+	iface.Kind = kind(dir, iface)
+
+	if netIface, err := net.InterfaceByName(iface.Name); err == nil {
+		if addrs, err := netIface.Addrs(); err == nil {
+			for _, addr := range addrs {
+				iface.Addresses = append(iface.Addresses, addr.String())
+			}
+		}
+	}
+	return iface
+}
+
+func kind(dir string, iface Interface) string {
+	devType := ""
+	for _, line := range strings.Split(readString(dir, "uevent"), "\n") {
+		if value, ok := strings.CutPrefix(line, "DEVTYPE="); ok {
+			devType = value
+		}
+	}
+
+	switch {
+	case readString(dir, "type") == "772":
+		return KindLoopback
+	case devType == "wlan" || exists(dir, "wireless") || exists(dir, "phy80211"):
+		return KindWireless
+	case devType == "bridge" || exists(dir, "bridge"):
+		return KindBridge
+	case devType == "vlan":
+		return KindVLAN
+	case iface.Physical && readString(dir, "type") == "1":
+		return KindEthernet
+	default:
+		return KindVirtual
+	}
+}
+
+// defaultRouteInterfaces returns the interfaces with an IPv4 default route
+func defaultRouteInterfaces(routeFile string) map[string]bool {
+	routed := make(map[string]bool)
+
+	f, err := os.Open(routeFile)
+	if err != nil {
+		return routed
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		// Iface Destination Gateway Flags RefCnt Use Metric Mask...
+		fields := strings.Fields(scanner.Text())
+		if len(fields) >= 8 && fields[1] == "00000000" && fields[7] == "00000000" {
+			routed[fields[0]] = true
+		}
+	}
+	return routed
+}
+
+func readString(dir, name string) string {
+	data, err := os.ReadFile(filepath.Join(dir, name))
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSpace(string(data))
+}
+
+func readInt(dir, name string) int {
+	n, _ := strconv.Atoi(readString(dir, name))
+	return n
+}
+
+func exists(dir, name string) bool {
+	_, err := os.Stat(filepath.Join(dir, name))
+	return err == nil
+}
@@ -0,0 +1,403 @@
+// Package onboarding turns the answers of the initial setup wizard (which
+// port is WAN, the LAN subnet, DHCP pool and Wi-Fi network) into the
+// network, dhcp, firewall and wireless configs of a fresh router.
+package onboarding
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net"
+	"regexp"
+	"slices"
+
+	"github.com/thesabbir/hellfire/pkg/netdetect"
+	"github.com/thesabbir/hellfire/pkg/uci"
+	"github.com/thesabbir/hellfire/pkg/util"
+)
+
+// Defaults proposed for the LAN
+const (
+	DefaultLANAddress = "192.168.1.1"
+	DefaultLANNetmask = "255.255.255.0"
+	DefaultDHCPStart  = 100
+	DefaultDHCPLimit  = 150
+	DefaultLeaseTime  = "12h"
+	DefaultSSID       = "Hellfire"
+)
+
+// Configs written by a setup; wireless only when Wi-Fi is set up
+var Configs = []string{"network", "dhcp", "firewall", "wireless"}
+
+var leaseTimePattern = regexp.MustCompile(`^([0-9]+[smhdw]?|infinite)$`)
+
+// WAN is the uplink interface
+type WAN struct {
+	Interface string   `json:"interface"`
+	Proto     string   `json:"proto"` // dhcp or static
+	IPAddr    string   `json:"ipaddr,omitempty"`
+	Netmask   string   `json:"netmask,omitempty"`
+	Gateway   string   `json:"gateway,omitempty"`
+	DNS       []string `json:"dns,omitempty"`
+}
+
+// LAN is the local network interface
+type LAN struct {
+	Interface string `json:"interface"`
+	IPAddr    string `json:"ipaddr"`
+	Netmask   string `json:"netmask"`
+}
+
+// DHCP is the address pool served on the LAN
+type DHCP struct {
+	Enabled   bool   `json:"enabled"`
+	Start     int    `json:"start"` // Offset of the first address in the LAN subnet
+	Limit     int    `json:"limit"` // Number of addresses
+	LeaseTime string `json:"leasetime"`
+}
+
+// WiFi is an access point bridged to the LAN
+type WiFi struct {
+	Interface string `json:"interface"`
+	SSID      string `json:"ssid"`
+	Key       string `json:"key"` // WPA2 passphrase
+}
+
+// Setup is the initial network setup
+type Setup struct {
+	WAN  WAN   `json:"wan"`
+	LAN  LAN   `json:"lan"`
+	DHCP DHCP  `json:"dhcp"`
+	WiFi *WiFi `json:"wifi,omitempty"`
+}
+
+// Propose suggests a setup for the detected interfaces: the port with the
+// default route (or else the first with a link) is WAN, the next ethernet
+// port is LAN. The Wi-Fi key is left for the user to choose.
+func Propose(interfaces []netdetect.Interface) Setup {
+	setup := Setup{
+		WAN: WAN{Proto: "dhcp"},
+		LAN: LAN{IPAddr: DefaultLANAddress, Netmask: DefaultLANNetmask},
+		DHCP: DHCP{
+			Enabled:   true,
+			Start:     DefaultDHCPStart,
+			Limit:     DefaultDHCPLimit,
+			LeaseTime: DefaultLeaseTime,
+		},
+	}
+
+	var ports []netdetect.Interface
+	for _, iface := range interfaces {
+		if iface.Kind == netdetect.KindEthernet {
+			ports = append(ports, iface)
+		}
+	}
+
+	wan := slices.IndexFunc(ports, func(i netdetect.Interface) bool { return i.DefaultRoute })
+	if wan < 0 {
+		wan = slices.IndexFunc(ports, func(i netdetect.Interface) bool { return i.Carrier })
+	}
+	if wan < 0 && len(ports) > 0 {
+		wan = 0
+	}
+	if wan >= 0 {
+		setup.WAN.Interface = ports[wan].Name
+		ports = slices.Delete(ports, wan, wan+1)
+	}
+	if len(ports) > 0 {
+		setup.LAN.Interface = ports[0].Name
+	}
+
+	for _, iface := range interfaces {
+		if iface.Kind == netdetect.KindWireless {
+			setup.WiFi = &WiFi{Interface: iface.Name, SSID: DefaultSSID}
+			break
+		}
+	}
+	return setup
+}
+
+// Validate checks the setup. When interfaces is not nil, the chosen
+// interfaces must be among them.
+func (s *Setup) Validate(interfaces []netdetect.Interface) error {
+	var errs []error
+	known := func(name, kind string) error {
+		if err := util.ValidateInterfaceName(name); err != nil {
+			return err
+		}
+		if interfaces == nil {
+			return nil
+		}
+		i := slices.IndexFunc(interfaces, func(i netdetect.Interface) bool { return i.Name == name })
+		if i < 0 {
+			return fmt.Errorf("interface %s not found", name)
+		}
+		if kind != "" && interfaces[i].Kind != kind {
+			return fmt.Errorf("interface %s is not %s", name, kind)
+		}
+		return nil
+	}
+
+	// WAN
+	if err := known(s.WAN.Interface, ""); err != nil {
+		errs = append(errs, fmt.Errorf("wan: %w", err))
+	}
+	var wanNet *net.IPNet
+	switch s.WAN.Proto {
+	case "dhcp":
+	case "static":
+		var err error
+		if wanNet, err = subnet(s.WAN.IPAddr, s.WAN.Netmask); err != nil {
+			errs = append(errs, fmt.Errorf("wan: %w", err))
+		} else if s.WAN.Gateway != "" {
+			if gw := net.ParseIP(s.WAN.Gateway).To4(); gw == nil || !wanNet.Contains(gw) {
+				errs = append(errs, fmt.Errorf("wan: gateway %s is not in %s", s.WAN.Gateway, wanNet))
+			}
+		}
+		for _, server := range s.WAN.DNS {
+			if err := util.ValidateIPAddress(server); err != nil {
+				errs = append(errs, fmt.Errorf("wan: dns: %w", err))
+			}
+		}
+	default:
+		errs = append(errs, fmt.Errorf("wan: proto must be dhcp or static, got %q", s.WAN.Proto))
+	}
+
+	// LAN
+	if err := known(s.LAN.Interface, ""); err != nil {
+		errs = append(errs, fmt.Errorf("lan: %w", err))
+	} else if s.LAN.Interface == s.WAN.Interface {
+		errs = append(errs, errors.New("lan: must be a different interface than wan"))
+	}
+	lanNet, err := subnet(s.LAN.IPAddr, s.LAN.Netmask)
+	if err != nil {
+		errs = append(errs, fmt.Errorf("lan: %w", err))
+	} else if wanNet != nil && (lanNet.Contains(wanNet.IP) || wanNet.Contains(lanNet.IP)) {
+		errs = append(errs, fmt.Errorf("lan: subnet %s overlaps the wan subnet %s", lanNet, wanNet))
+	}
+
+	// DHCP
+	if s.DHCP.Enabled && lanNet != nil {
+		if _, _, err := s.dhcpRange(lanNet); err != nil {
+			errs = append(errs, fmt.Errorf("dhcp: %w", err))
+		}
+		if !leaseTimePattern.MatchString(s.DHCP.LeaseTime) {
+			errs = append(errs, fmt.Errorf("dhcp: invalid lease time %q", s.DHCP.LeaseTime))
+		}
+	}
+
+	// Wi-Fi
+	if s.WiFi != nil {
+		if err := known(s.WiFi.Interface, netdetect.KindWireless); err != nil {
+			errs = append(errs, fmt.Errorf("wifi: %w", err))
+		}
+		if len(s.WiFi.SSID) < 1 || len(s.WiFi.SSID) > 32 {
+			errs = append(errs, errors.New("wifi: ssid must be 1 to 32 bytes"))
+		}
+		if len(s.WiFi.Key) < 8 || len(s.WiFi.Key) > 63 {
+			errs = append(errs, errors.New("wifi: key must be 8 to 63 characters"))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// subnet parses an IPv4 host address and netmask; the address may not be
+// the network or broadcast address
+func subnet(ipaddr, netmask string) (*net.IPNet, error) {
+	ip := net.ParseIP(ipaddr).To4()
+	if ip == nil {
+		return nil, fmt.Errorf("invalid IPv4 address %q", ipaddr)
+	}
+	if err := util.ValidateNetmask(netmask); err != nil {
+		return nil, err
+	}
+	mask := net.IPMask(net.ParseIP(netmask).To4())
+	ones, bits := mask.Size()
+	if bits == 0 || ones < 8 || ones > 30 {
+		return nil, fmt.Errorf("netmask %s must be contiguous, /8 to /30", netmask)
+	}
+
+	network := &net.IPNet{IP: ip.Mask(mask), Mask: mask}
+	host := toUint32(ip) &^ toUint32(net.IP(mask))
+	if host == 0 || host == ^toUint32(net.IP(mask)) {
+		return nil, fmt.Errorf("%s is not a host address in %s", ipaddr, network)
+	}
+	return network, nil
+}
+
+// dhcpRange returns the first and last addresses of the pool, which must
+// fit in the LAN subnet
+func (s *Setup) dhcpRange(lanNet *net.IPNet) (net.IP, net.IP, error) {
+	if s.DHCP.Start < 1 || s.DHCP.Limit < 1 {
+		return nil, nil, errors.New("start and limit must be positive")
+	}
+	base := toUint32(lanNet.IP)
+	hosts := ^toUint32(net.IP(lanNet.Mask)) - 1 // Without network and broadcast
+	if uint64(s.DHCP.Start)+uint64(s.DHCP.Limit)-1 > uint64(hosts) {
+		return nil, nil, fmt.Errorf("pool of %d addresses from offset %d does not fit in %s",
+			s.DHCP.Limit, s.DHCP.Start, lanNet)
+	}
+	return fromUint32(base + uint32(s.DHCP.Start)), fromUint32(base + uint32(s.DHCP.Start+s.DHCP.Limit-1)), nil
+}
+
+func toUint32(ip net.IP) uint32 {
+	return binary.BigEndian.Uint32(ip.To4())
+}
+
+func fromUint32(n uint32) net.IP {
+	return binary.BigEndian.AppendUint32(nil, n)
+}
+
+// Apply writes a validated setup into the configs, creating missing ones,
+// and returns the names of the configs it changed. The interface sections
+// of the network config are replaced, as are the DHCP pools; firewall zones
+// wan and lan are pointed at the chosen interfaces.
+func (s *Setup) Apply(configs map[string]*uci.Config) []string {
+	get := func(name string) *uci.Config {
+		if configs[name] == nil {
+			configs[name] = uci.NewConfig()
+		}
+		return configs[name]
+	}
+
+	s.applyNetwork(get("network"))
+	s.applyDHCP(get("dhcp"))
+	applyFirewall(get("firewall"), s.WAN.Interface, s.LAN.Interface)
+	changed := []string{"network", "dhcp", "firewall"}
+
+	if s.WiFi != nil {
+		s.applyWireless(get("wireless"))
+		changed = append(changed, "wireless")
+	}
+	return changed
+}
+
+func (s *Setup) applyNetwork(cfg *uci.Config) {
+	// Interfaces other than loopback are replaced, along with the routes and
+	// neighbors pointing at them
+	keep := map[string]bool{"lo": true}
+	for _, section := range cfg.GetSectionsByType("interface") {
+		if !keep[section.Name] {
+			cfg.RemoveSection(section)
+		}
+	}
+	for _, kind := range []string{"route", "neighbor"} {
+		for _, section := range cfg.GetSectionsByType(kind) {
+			if iface, _ := section.GetOption("interface"); !keep[iface] {
+				cfg.RemoveSection(section)
+			}
+		}
+	}
+
+	wan := uci.NewSection("interface", s.WAN.Interface)
+	wan.SetOption("proto", s.WAN.Proto)
+	if s.WAN.Proto == "static" {
+		wan.SetOption("ipaddr", s.WAN.IPAddr)
+		wan.SetOption("netmask", s.WAN.Netmask)
+		if s.WAN.Gateway != "" {
+			wan.SetOption("gateway", s.WAN.Gateway)
+		}
+		for _, server := range s.WAN.DNS {
+			wan.AddListValue("dns", server)
+		}
+	}
+	cfg.AddSection(wan)
+
+	lan := uci.NewSection("interface", s.LAN.Interface)
+	lan.SetOption("proto", "static")
+	lan.SetOption("ipaddr", s.LAN.IPAddr)
+	lan.SetOption("netmask", s.LAN.Netmask)
+	cfg.AddSection(lan)
+}
+
+func (s *Setup) applyDHCP(cfg *uci.Config) {
+	for _, section := range cfg.GetSectionsByType("dhcp") {
+		cfg.RemoveSection(section)
+	}
+
+	lan := uci.NewSection("dhcp", s.LAN.Interface)
+	lan.SetOption("interface", s.LAN.Interface)
+	lanNet, err := subnet(s.LAN.IPAddr, s.LAN.Netmask)
+	if !s.DHCP.Enabled || err != nil {
+		lan.SetOption("ignore", "1")
+	} else if first, last, err := s.dhcpRange(lanNet); err == nil {
+		// The DHCP applier takes the range as addresses
+		lan.SetOption("start", first.String())
+		lan.SetOption("limit", last.String())
+		lan.SetOption("leasetime", s.DHCP.LeaseTime)
+	}
+	cfg.AddSection(lan)
+
+	wan := uci.NewSection("dhcp", s.WAN.Interface)
+	wan.SetOption("interface", s.WAN.Interface)
+	wan.SetOption("ignore", "1")
+	cfg.AddSection(wan)
+}
+
+func applyFirewall(cfg *uci.Config, wanIface, lanIface string) {
+	if cfg.GetSection("defaults", "") == nil {
+		defaults := uci.NewSection("defaults", "")
+		defaults.SetOption("input", "ACCEPT")
+		defaults.SetOption("output", "ACCEPT")
+		defaults.SetOption("forward", "DROP")
+		cfg.AddSection(defaults)
+	}
+
+	zones := map[string]*uci.Section{}
+	for _, zone := range cfg.GetSectionsByType("zone") {
+		name, _ := zone.GetOption("name")
+		zones[name] = zone
+		// An interface belongs to one zone
+		zone.Lists["network"] = slices.DeleteFunc(zone.GetList("network"), func(n string) bool {
+			return n == wanIface || n == lanIface
+		})
+	}
+
+	if zones["wan"] == nil {
+		zone := uci.NewSection("zone", "")
+		zone.SetOption("name", "wan")
+		zone.SetOption("input", "DROP")
+		zone.SetOption("output", "ACCEPT")
+		zone.SetOption("forward", "DROP")
+		zone.SetOption("masq", "1")
+		cfg.AddSection(zone)
+		zones["wan"] = zone
+	}
+	if zones["lan"] == nil {
+		zone := uci.NewSection("zone", "")
+		zone.SetOption("name", "lan")
+		zone.SetOption("input", "ACCEPT")
+		zone.SetOption("output", "ACCEPT")
+		zone.SetOption("forward", "ACCEPT")
+		cfg.AddSection(zone)
+		zones["lan"] = zone
+	}
+	zones["wan"].Lists["network"] = []string{wanIface}
+	zones["lan"].Lists["network"] = []string{lanIface}
+
+	for _, fwd := range cfg.GetSectionsByType("forwarding") {
+		src, _ := fwd.GetOption("src")
+		dest, _ := fwd.GetOption("dest")
+		if src == "lan" && dest == "wan" {
+			return
+		}
+	}
+	fwd := uci.NewSection("forwarding", "")
+	fwd.SetOption("src", "lan")
+	fwd.SetOption("dest", "wan")
+	cfg.AddSection(fwd)
+}
+
+func (s *Setup) applyWireless(cfg *uci.Config) {
+	if section := cfg.GetSection("wifi-iface", s.WiFi.Interface); section != nil {
+		cfg.RemoveSection(section)
+	}
+
+	ap := uci.NewSection("wifi-iface", s.WiFi.Interface)
+	ap.SetOption("mode", "ap")
+	ap.SetOption("network", s.LAN.Interface)
+	ap.SetOption("ssid", s.WiFi.SSID)
+	ap.SetOption("encryption", "psk2")
+	ap.SetOption("key", s.WiFi.Key)
+	cfg.AddSection(ap)
+}
@@ -0,0 +1,123 @@
+package onboarding
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/thesabbir/hellfire/pkg/netdetect"
+	"github.com/thesabbir/hellfire/pkg/uci"
+)
+
+var detected = []netdetect.Interface{
+	{Name: "eth0", Kind: netdetect.KindEthernet, Physical: true},
+	{Name: "eth1", Kind: netdetect.KindEthernet, Physical: true, Carrier: true},
+	{Name: "wlan0", Kind: netdetect.KindWireless, Physical: true},
+	{Name: "lo", Kind: netdetect.KindLoopback},
+}
+
+func TestPropose(t *testing.T) {
+	setup := Propose(detected)
+	if setup.WAN.Interface != "eth1" || setup.LAN.Interface != "eth0" {
+		t.Errorf("WAN %s, LAN %s; want eth1 (has carrier), eth0", setup.WAN.Interface, setup.LAN.Interface)
+	}
+	if setup.WiFi == nil || setup.WiFi.Interface != "wlan0" {
+		t.Errorf("WiFi = %+v, want wlan0", setup.WiFi)
+	}
+
+	// The proposal is valid once the Wi-Fi key is chosen
+	setup.WiFi.Key = "correct horse"
+	if err := setup.Validate(detected); err != nil {
+		t.Errorf("Validate() = %v", err)
+	}
+}
+
+func TestValidate(t *testing.T) {
+	tests := []struct {
+		name   string
+		modify func(*Setup)
+		want   string
+	}{
+		{"same interface", func(s *Setup) { s.LAN.Interface = s.WAN.Interface }, "different interface"},
+		{"unknown interface", func(s *Setup) { s.LAN.Interface = "eth9" }, "not found"},
+		{"overlap", func(s *Setup) {
+			s.WAN = WAN{Interface: "eth1", Proto: "static", IPAddr: "192.168.1.2", Netmask: "255.255.0.0"}
+		}, "overlaps"},
+		{"gateway outside", func(s *Setup) {
+			s.WAN = WAN{Interface: "eth1", Proto: "static", IPAddr: "10.0.0.2", Netmask: "255.255.255.0", Gateway: "10.0.1.1"}
+		}, "gateway"},
+		{"network address", func(s *Setup) { s.LAN.IPAddr = "192.168.1.0" }, "not a host address"},
+		{"pool too large", func(s *Setup) { s.DHCP.Limit = 200 }, "does not fit"},
+		{"wifi on ethernet", func(s *Setup) { s.WiFi.Interface = "eth0" }, "is not wireless"},
+		{"short key", func(s *Setup) { s.WiFi.Key = "short" }, "key"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			setup := Propose(detected)
+			setup.WiFi.Key = "correct horse"
+			tt.modify(&setup)
+			err := setup.Validate(detected)
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("Validate() = %v, want error containing %q", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestApply(t *testing.T) {
+	firewall, err := uci.Parse(strings.NewReader(`
+config zone
+	option name 'lan'
+	list network 'lan'
+	list network 'eth1'
+	option input 'ACCEPT'
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	network := uci.NewConfig()
+	old := uci.NewSection("interface", "lan")
+	old.SetOption("proto", "static")
+	network.AddSection(old)
+	route := uci.NewSection("route", "")
+	route.SetOption("interface", "lan")
+	network.AddSection(route)
+
+	setup := Propose(detected)
+	setup.WiFi.Key = "correct horse"
+	configs := map[string]*uci.Config{"network": network, "firewall": firewall}
+	changed := setup.Apply(configs)
+	if len(changed) != 4 {
+		t.Errorf("changed = %v, want all four configs", changed)
+	}
+
+	if configs["network"].GetSection("interface", "lan") != nil || len(configs["network"].GetSectionsByType("route")) != 0 {
+		t.Error("old interface and its route were kept")
+	}
+	if wan := configs["network"].GetSection("interface", "eth1"); wan == nil || wan.Options["proto"] != "dhcp" {
+		t.Errorf("wan interface = %+v", wan)
+	}
+	if lan := configs["network"].GetSection("interface", "eth0"); lan == nil || lan.Options["ipaddr"] != DefaultLANAddress {
+		t.Errorf("lan interface = %+v", lan)
+	}
+
+	pool := configs["dhcp"].GetSection("dhcp", "eth0")
+	if pool == nil || pool.Options["start"] != "192.168.1.100" || pool.Options["limit"] != "192.168.1.249" {
+		t.Errorf("dhcp pool = %+v", pool)
+	}
+
+	var zones []string
+	for _, zone := range configs["firewall"].GetSectionsByType("zone") {
+		zones = append(zones, zone.Options["name"]+"="+strings.Join(zone.GetList("network"), ","))
+	}
+	if got := strings.Join(zones, " "); got != "lan=eth0 wan=eth1" {
+		t.Errorf("zones = %s", got)
+	}
+	if len(configs["firewall"].GetSectionsByType("forwarding")) != 1 {
+		t.Error("missing lan to wan forwarding")
+	}
+
+	if ap := configs["wireless"].GetSection("wifi-iface", "wlan0"); ap == nil || ap.Options["network"] != "eth0" {
+		t.Errorf("wifi-iface = %+v", ap)
+	}
+}
@@ -0,0 +1,110 @@
+package openwrt
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"slices"
+
+	"github.com/thesabbir/hellfire/pkg/uci"
+)
+
+// maxConfigSize bounds a config read from a backup
+const maxConfigSize = 4 << 20
+
+// Load reads the configs of an OpenWrt backup: an /etc/config directory,
+// a root directory containing etc/config, or a sysupgrade backup tarball
+// (.tar.gz or .tar). Configs Hellfire doesn't import are ignored; the
+// result has at least one of Configs.
+func Load(src string) (map[string]*uci.Config, error) {
+	info, err := os.Stat(src)
+	if err != nil {
+		return nil, err
+	}
+
+	var configs map[string]*uci.Config
+	if info.IsDir() {
+		configs, err = loadDir(src)
+	} else {
+		configs, err = loadTarball(src)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if len(configs) == 0 {
+		return nil, fmt.Errorf("no OpenWrt configs (%v) found in %s", Configs, src)
+	}
+	return configs, nil
+}
+
+func loadDir(dir string) (map[string]*uci.Config, error) {
+	if _, err := os.Stat(filepath.Join(dir, "etc", "config")); err == nil {
+		dir = filepath.Join(dir, "etc", "config")
+	}
+
+	configs := make(map[string]*uci.Config)
+	for _, name := range Configs {
+		data, err := os.ReadFile(filepath.Join(dir, name))
+		if errors.Is(err, os.ErrNotExist) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		cfg, err := uci.Parse(bytes.NewReader(data))
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse %s: %w", name, err)
+		}
+		configs[name] = cfg
+	}
+	return configs, nil
+}
+
+func loadTarball(file string) (map[string]*uci.Config, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	// Sysupgrade backups are gzipped; accept plain tarballs too
+	var r io.Reader = f
+	if gz, err := gzip.NewReader(f); err == nil {
+		defer gz.Close()
+		r = gz
+	} else if _, err := f.Seek(0, io.SeekStart); err != nil {
+		return nil, err
+	}
+
+	configs := make(map[string]*uci.Config)
+	tr := tar.NewReader(r)
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to read %s: %w", file, err)
+		}
+
+		dir, name := path.Split(path.Clean(header.Name))
+		if header.Typeflag != tar.TypeReg || path.Base(path.Clean(dir)) != "config" || !slices.Contains(Configs, name) {
+			continue
+		}
+		if header.Size > maxConfigSize {
+			return nil, fmt.Errorf("%s is too large", header.Name)
+		}
+		cfg, err := uci.Parse(io.LimitReader(tr, maxConfigSize))
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse %s: %w", header.Name, err)
+		}
+		configs[name] = cfg
+	}
+	return configs, nil
+}
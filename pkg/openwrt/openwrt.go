@@ -0,0 +1,611 @@
+// Package openwrt imports OpenWrt /etc/config backups. OpenWrt names
+// interfaces logically (lan, wan) and points them at devices, counts DHCP
+// pools from the interface address and has many options Hellfire doesn't
+// implement; the import maps what has an equivalent and reports the rest.
+package openwrt
+
+import (
+	"encoding/binary"
+	"fmt"
+	"net"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/thesabbir/hellfire/pkg/config"
+	"github.com/thesabbir/hellfire/pkg/uci"
+	"github.com/thesabbir/hellfire/pkg/util"
+)
+
+// Configs are the OpenWrt configs that are imported
+var Configs = []string{"network", "firewall", "dhcp", "wireless"}
+
+// OpenWrt defaults for options Hellfire requires explicitly
+const (
+	defaultDHCPStart  = 100
+	defaultDHCPLimit  = 150
+	defaultRuleTarget = "DROP"
+)
+
+// Issue is something in an OpenWrt config that wasn't imported
+type Issue struct {
+	Config  string `json:"config"`
+	Section string `json:"section"` // Name, or @type[index] for unnamed sections
+	Option  string `json:"option,omitempty"`
+	Message string `json:"message"`
+}
+
+func (i Issue) String() string {
+	if i.Option == "" {
+		return fmt.Sprintf("%s.%s: %s", i.Config, i.Section, i.Message)
+	}
+	return fmt.Sprintf("%s.%s.%s: %s", i.Config, i.Section, i.Option, i.Message)
+}
+
+// Result is the Hellfire configs converted from an OpenWrt backup
+type Result struct {
+	Configs map[string]*uci.Config // By name, for the configs in the backup
+	Issues  []Issue
+}
+
+// Convert maps OpenWrt configs to Hellfire's. Interfaces are renamed to
+// their devices throughout, which is how Hellfire names them.
+func Convert(configs map[string]*uci.Config) *Result {
+	im := &importer{result: &Result{Configs: make(map[string]*uci.Config)}}
+	convert := map[string]func(*uci.Config) *uci.Config{
+		"network":  im.network,
+		"firewall": im.firewall,
+		"dhcp":     im.dhcp,
+		"wireless": im.wireless,
+	}
+	// In order: the others refer to the interfaces found in network
+	for _, name := range Configs {
+		if cfg, ok := configs[name]; ok {
+			im.name, im.in = name, cfg
+			im.result.Configs[name] = convert[name](cfg)
+		}
+	}
+	return im.result
+}
+
+type importer struct {
+	devices map[string]string     // Imported OpenWrt interfaces to their devices; nil without network
+	subnets map[string]*net.IPNet // Static subnets by device
+	result  *Result
+
+	name string      // Config being converted
+	in   *uci.Config // and its OpenWrt sections
+}
+
+func (im *importer) report(section *uci.Section, option, format string, args ...any) {
+	im.result.Issues = append(im.result.Issues, Issue{
+		Config:  im.name,
+		Section: im.in.SectionID(section),
+		Option:  option,
+		Message: fmt.Sprintf(format, args...),
+	})
+}
+
+// unsupported reports the options of a section other than the mapped ones
+func (im *importer) unsupported(section *uci.Section, mapped ...string) {
+	for _, option := range optionNames(section) {
+		if !slices.Contains(mapped, option) {
+			im.report(section, option, "not supported, dropped")
+		}
+	}
+}
+
+// device returns the device of an OpenWrt interface. Without a network
+// config the names are kept as they are.
+func (im *importer) device(name string) (string, bool) {
+	if im.devices == nil {
+		return name, true
+	}
+	device, ok := im.devices[name]
+	return device, ok
+}
+
+// copyOption copies an option in its canonical form, reporting values the
+// schema doesn't accept
+func (im *importer) copyOption(from, to *uci.Section, option string) {
+	value, ok := from.GetOption(option)
+	if !ok {
+		return
+	}
+	value, err := config.NormalizeOption(im.name, from.Type, option, value)
+	if err != nil {
+		im.report(from, option, "%v, dropped", err)
+		return
+	}
+	to.SetOption(option, value)
+}
+
+func (im *importer) network(in *uci.Config) *uci.Config {
+	out := uci.NewConfig()
+	im.devices = make(map[string]string)
+	im.subnets = make(map[string]*net.IPNet)
+
+	for _, section := range in.GetSectionsByType("interface") {
+		im.networkInterface(section, out)
+	}
+	for _, section := range in.GetSectionsByType("route") {
+		im.route(section, out)
+	}
+	for _, section := range in.Sections {
+		switch section.Type {
+		case "interface", "route":
+		case "device":
+			name, _ := section.GetOption("name")
+			switch kind, _ := section.GetOption("type"); kind {
+			case "bridge":
+				im.report(section, "", "bridge %s is not created: create it on the system before committing", name)
+			case "8021q", "8021ad":
+				im.vlan(section, out)
+			default:
+				im.report(section, "", "device settings are not supported")
+			}
+		default:
+			im.report(section, "", "%s sections are not supported", section.Type)
+		}
+	}
+	return out
+}
+
+// vlan imports a VLAN device section, named as OpenWrt names it
+func (im *importer) vlan(section *uci.Section, out *uci.Config) {
+	ifname, _ := section.GetOption("ifname")
+	vid, _ := section.GetOption("vid")
+	name, ok := section.GetOption("name")
+	if !ok {
+		name = ifname + "." + vid
+	}
+
+	vlan := uci.NewSection("device", "")
+	vlan.SetOption("name", name)
+	for _, option := range []string{"type", "ifname", "vid"} {
+		if value, ok := section.GetOption(option); ok {
+			vlan.SetOption(option, value)
+		}
+	}
+	im.unsupported(section, "name", "type", "ifname", "vid")
+	out.AddSection(vlan)
+}
+
+func (im *importer) networkInterface(section *uci.Section, out *uci.Config) {
+	device, ok := im.interfaceDevice(section)
+	if !ok || device == "lo" {
+		return
+	}
+
+	proto, _ := section.GetOption("proto")
+	if !slices.Contains([]string{"static", "dhcp", "none"}, proto) {
+		im.report(section, "proto", "protocol %q is not supported, interface not imported", proto)
+		return
+	}
+	if out.GetSection("interface", device) != nil {
+		im.report(section, "", "device %s is already configured by another interface, not imported", device)
+		return
+	}
+
+	iface := uci.NewSection("interface", device)
+	iface.SetOption("proto", proto)
+	if proto == "static" {
+		subnet, ok := im.staticAddress(section, iface)
+		if !ok {
+			return
+		}
+		im.subnets[device] = subnet
+		if gateway, ok := section.GetOption("gateway"); ok {
+			iface.SetOption("gateway", gateway)
+		}
+	}
+	for _, dns := range values(section, "dns") {
+		iface.AddListValue("dns", dns)
+	}
+	for _, option := range []string{"mtu", "macaddr", "metric", "ip4table", "peerdns"} {
+		im.copyOption(section, iface, option)
+	}
+	for _, address := range values(section, "ip6addr") {
+		iface.AddListValue("ip6addr", address)
+	}
+	im.unsupported(section, "proto", "ipaddr", "netmask", "gateway", "dns", "peerdns", "mtu", "macaddr", "metric", "ip4table", "ip6addr", "device", "ifname", "type")
+
+	out.AddSection(iface)
+	im.devices[section.Name] = device
+}
+
+// interfaceDevice returns the device of an OpenWrt interface: its device
+// option (ifname before OpenWrt 21.02), or br-<name> for old-style bridges
+func (im *importer) interfaceDevice(section *uci.Section) (string, bool) {
+	if kind, _ := section.GetOption("type"); kind == "bridge" {
+		device := "br-" + section.Name
+		im.report(section, "type", "bridge %s is not created: create it on the system before committing", device)
+		return device, true
+	}
+
+	device, ok := section.GetOption("device")
+	if !ok {
+		device, ok = section.GetOption("ifname")
+	}
+	if !ok {
+		device = section.Name
+	}
+	fields := strings.Fields(device)
+	if len(fields) == 0 {
+		im.report(section, "device", "no device, interface not imported")
+		return "", false
+	}
+	if len(fields) > 1 {
+		im.report(section, "device", "only the first device, %s, is imported", fields[0])
+	}
+
+	device = fields[0]
+	if strings.HasPrefix(device, "@") {
+		im.report(section, "device", "alias interfaces are not supported, interface not imported")
+		return "", false
+	}
+	if err := util.ValidateInterfaceName(device); err != nil {
+		im.report(section, "device", "%v, interface not imported", err)
+		return "", false
+	}
+	return device, true
+}
+
+// staticAddress sets the address of a static interface, taking the netmask
+// from a CIDR address if given that way
+func (im *importer) staticAddress(section, iface *uci.Section) (*net.IPNet, bool) {
+	addrs := values(section, "ipaddr")
+	if len(addrs) == 0 {
+		im.report(section, "ipaddr", "static interface without an address, not imported")
+		return nil, false
+	}
+	if len(addrs) > 1 {
+		im.report(section, "ipaddr", "only the first address, %s, is imported", addrs[0])
+	}
+
+	addr := addrs[0]
+	netmask, _ := section.GetOption("netmask")
+	if ip, subnet, err := net.ParseCIDR(addr); err == nil {
+		addr, netmask = ip.String(), net.IP(subnet.Mask).String()
+	}
+	ip, mask := net.ParseIP(addr).To4(), net.ParseIP(netmask).To4()
+	if ip == nil || mask == nil {
+		im.report(section, "ipaddr", "invalid IPv4 address %s/%s, interface not imported", addr, netmask)
+		return nil, false
+	}
+
+	iface.SetOption("ipaddr", addr)
+	iface.SetOption("netmask", netmask)
+	return &net.IPNet{IP: ip.Mask(net.IPMask(mask)), Mask: net.IPMask(mask)}, true
+}
+
+func (im *importer) route(section *uci.Section, out *uci.Config) {
+	name, _ := section.GetOption("interface")
+	device, ok := im.devices[name]
+	if !ok {
+		im.report(section, "interface", "interface %q was not imported, route not imported", name)
+		return
+	}
+
+	route := uci.NewSection("route", section.Name)
+	route.SetOption("interface", device)
+	target, _ := section.GetOption("target")
+	if _, subnet, err := net.ParseCIDR(target); err == nil {
+		route.SetOption("target", subnet.IP.String())
+		route.SetOption("netmask", net.IP(subnet.Mask).String())
+	} else {
+		route.SetOption("target", target)
+		if netmask, ok := section.GetOption("netmask"); ok {
+			route.SetOption("netmask", netmask)
+		}
+	}
+	for _, option := range []string{"gateway", "metric"} {
+		if value, ok := section.GetOption(option); ok {
+			route.SetOption(option, value)
+		}
+	}
+	im.unsupported(section, "interface", "target", "netmask", "gateway", "metric")
+	out.AddSection(route)
+}
+
+func (im *importer) firewall(in *uci.Config) *uci.Config {
+	out := uci.NewConfig()
+	for _, section := range in.Sections {
+		switch section.Type {
+		case "defaults":
+			defaults := uci.NewSection("defaults", "")
+			for _, option := range []string{"input", "output", "forward"} {
+				im.copyOption(section, defaults, option)
+			}
+			im.unsupported(section, "input", "output", "forward")
+			out.AddSection(defaults)
+		case "zone":
+			im.zone(section, out)
+		case "forwarding":
+			forwarding := uci.NewSection("forwarding", section.Name)
+			for _, option := range []string{"src", "dest"} {
+				im.copyOption(section, forwarding, option)
+			}
+			im.unsupported(section, "src", "dest")
+			out.AddSection(forwarding)
+		case "rule":
+			im.rule(section, out)
+		default:
+			im.report(section, "", "%s sections are not supported", section.Type)
+		}
+	}
+	return out
+}
+
+func (im *importer) zone(section *uci.Section, out *uci.Config) {
+	zone := uci.NewSection("zone", section.Name)
+	for _, option := range []string{"name", "input", "output", "forward", "masq"} {
+		im.copyOption(section, zone, option)
+	}
+	for _, name := range values(section, "network") {
+		device, ok := im.device(name)
+		if !ok {
+			im.report(section, "network", "interface %q was not imported, dropped", name)
+			continue
+		}
+		if !slices.Contains(zone.GetList("network"), device) {
+			zone.AddListValue("network", device)
+		}
+	}
+	im.unsupported(section, "name", "input", "output", "forward", "masq", "network")
+	out.AddSection(zone)
+}
+
+// rule imports a traffic rule. A rule is imported whole or not at all:
+// dropping a match such as src_ip would widen it. OpenWrt rules without a
+// proto match TCP and UDP; rules with several protocols become one rule each.
+func (im *importer) rule(section *uci.Section, out *uci.Config) {
+	if enabled, _ := section.GetOption("enabled"); enabled == "0" {
+		im.report(section, "enabled", "disabled, not imported")
+		return
+	}
+
+	mapped := []string{"name", "src", "dest", "proto", "src_port", "dest_port", "target", "enabled", "family"}
+	skip := false
+	for _, option := range optionNames(section) {
+		if !slices.Contains(mapped, option) {
+			im.report(section, option, "not supported, rule not imported")
+			skip = true
+		}
+	}
+	if family, ok := section.GetOption("family"); ok && family != "any" {
+		im.report(section, "family", "address family %q is not supported, rule not imported", family)
+		skip = true
+	}
+	for _, option := range []string{"src_port", "dest_port"} {
+		if len(values(section, option)) > 1 {
+			im.report(section, option, "port lists are not supported, rule not imported")
+			skip = true
+		}
+	}
+
+	target := defaultRuleTarget
+	if value, ok := section.GetOption("target"); ok {
+		var err error
+		if target, err = config.NormalizeOption("firewall", "rule", "target", value); err != nil {
+			im.report(section, "target", "%v, rule not imported", err)
+			skip = true
+		}
+	}
+
+	var protos []string
+	for _, proto := range values(section, "proto") {
+		switch proto = strings.ToLower(proto); proto {
+		case "tcpudp":
+			protos = append(protos, "tcp", "udp")
+		case "all":
+			protos = append(protos, "")
+		default:
+			if _, err := config.NormalizeOption("firewall", "rule", "proto", proto); err != nil {
+				im.report(section, "proto", "%v, rule not imported", err)
+				skip = true
+			}
+			protos = append(protos, proto)
+		}
+	}
+	if _, ok := section.GetOption("proto"); !ok {
+		protos = []string{"tcp", "udp"}
+	}
+	if skip {
+		return
+	}
+
+	for _, proto := range protos {
+		name := section.Name
+		if len(protos) > 1 {
+			name = ""
+		}
+		rule := uci.NewSection("rule", name)
+		for _, option := range []string{"name", "src", "dest", "src_port", "dest_port"} {
+			if value, ok := section.GetOption(option); ok {
+				rule.SetOption(option, value)
+			}
+		}
+		if proto != "" {
+			rule.SetOption("proto", proto)
+		}
+		rule.SetOption("target", target)
+		out.AddSection(rule)
+	}
+}
+
+func (im *importer) dhcp(in *uci.Config) *uci.Config {
+	out := uci.NewConfig()
+	for _, section := range in.Sections {
+		switch section.Type {
+		case "dnsmasq":
+			im.dnsmasq(section, out)
+		case "dhcp":
+			im.pool(section, out)
+		default:
+			im.report(section, "", "%s sections are not supported", section.Type)
+		}
+	}
+	return out
+}
+
+func (im *importer) dnsmasq(section *uci.Section, out *uci.Config) {
+	dnsmasq := uci.NewSection("dnsmasq", section.Name)
+	mapped := []string{"domain", "local", "server"}
+	for _, option := range optionNames(section) {
+		if _, ok := config.Schema["dhcp.dnsmasq."+option]; ok {
+			im.copyOption(section, dnsmasq, option)
+			mapped = append(mapped, option)
+		}
+	}
+
+	if domain, ok := section.GetOption("domain"); ok {
+		dnsmasq.SetOption("domain", domain)
+	}
+	// OpenWrt writes the local domain the way dnsmasq takes it: /lan/
+	if local, ok := section.GetOption("local"); ok {
+		dnsmasq.SetOption("local", strings.Trim(local, "/"))
+	}
+	for _, server := range values(section, "server") {
+		if net.ParseIP(server) == nil {
+			im.report(section, "server", "only plain upstream addresses are supported, %s dropped", server)
+			continue
+		}
+		dnsmasq.AddListValue("server", server)
+	}
+	im.unsupported(section, mapped...)
+	out.AddSection(dnsmasq)
+}
+
+// pool imports a DHCP pool, turning OpenWrt's start offset and size into
+// the first and last address Hellfire takes
+func (im *importer) pool(section *uci.Section, out *uci.Config) {
+	name, ok := section.GetOption("interface")
+	if !ok {
+		name = section.Name
+	}
+	device, ok := im.device(name)
+	if !ok {
+		im.report(section, "interface", "interface %q was not imported, pool not imported", name)
+		return
+	}
+
+	pool := uci.NewSection("dhcp", device)
+	pool.SetOption("interface", device)
+	mapped := []string{"interface", "ignore", "start", "limit", "leasetime", "dhcp_option"}
+	defer func() {
+		im.unsupported(section, mapped...)
+		out.AddSection(pool)
+	}()
+
+	im.copyOption(section, pool, "ignore")
+	if ignore, _ := pool.GetOption("ignore"); ignore == "1" {
+		return
+	}
+
+	start, limit := defaultDHCPStart, defaultDHCPLimit
+	for _, field := range []struct {
+		option string
+		n      *int
+	}{{"start", &start}, {"limit", &limit}} {
+		if value, ok := section.GetOption(field.option); ok {
+			parsed, err := strconv.Atoi(value)
+			if err != nil || parsed < 1 {
+				im.report(section, field.option, "invalid value %q, default %d used", value, *field.n)
+				continue
+			}
+			*field.n = parsed
+		}
+	}
+	if first, last, ok := poolRange(im.subnets[device], start, limit); ok {
+		pool.SetOption("start", first)
+		pool.SetOption("limit", last)
+	} else {
+		im.report(section, "start", "no range within a static subnet of %s, range not imported", device)
+	}
+
+	if leasetime, ok := section.GetOption("leasetime"); ok {
+		pool.SetOption("leasetime", leasetime)
+	}
+	if options := values(section, "dhcp_option"); len(options) > 0 {
+		pool.SetOption("dhcp_option", options[0])
+		if len(options) > 1 {
+			im.report(section, "dhcp_option", "only the first option, %s, is imported", options[0])
+		}
+	}
+}
+
+// poolRange returns the first and last address of a pool of limit addresses
+// starting at offset start in a subnet, ending before the broadcast address
+func poolRange(subnet *net.IPNet, start, limit int) (string, string, bool) {
+	if subnet == nil {
+		return "", "", false
+	}
+	base := binary.BigEndian.Uint32(subnet.IP.To4())
+	broadcast := base | ^binary.BigEndian.Uint32(subnet.Mask)
+	first, last := uint64(base)+uint64(start), uint64(base)+uint64(start)+uint64(limit)-1
+	if first >= uint64(broadcast) {
+		return "", "", false
+	}
+	last = min(last, uint64(broadcast)-1)
+	return uint32IP(uint32(first)), uint32IP(uint32(last)), true
+}
+
+func uint32IP(n uint32) string {
+	ip := make(net.IP, 4)
+	binary.BigEndian.PutUint32(ip, n)
+	return ip.String()
+}
+
+// wireless keeps the radios and networks as they are, with the networks
+// of wifi-iface sections renamed to their devices
+func (im *importer) wireless(in *uci.Config) *uci.Config {
+	out := uci.NewConfig()
+	for _, section := range in.Sections {
+		switch section.Type {
+		case "wifi-device":
+			out.AddSection(section.Clone())
+		case "wifi-iface":
+			iface := section.Clone()
+			iface.DeleteOption("network")
+			for _, name := range values(section, "network") {
+				device, ok := im.device(name)
+				if !ok {
+					im.report(section, "network", "interface %q was not imported, dropped", name)
+					continue
+				}
+				iface.SetOption("network", device)
+				break
+			}
+			out.AddSection(iface)
+		default:
+			im.report(section, "", "%s sections are not supported", section.Type)
+		}
+	}
+	return out
+}
+
+// values returns a list, or the words of an option, as OpenWrt accepts both
+func values(section *uci.Section, key string) []string {
+	if list := section.GetList(key); len(list) > 0 {
+		return list
+	}
+	value, _ := section.GetOption(key)
+	return strings.Fields(value)
+}
+
+// optionNames returns the sorted option and list names of a section
+func optionNames(section *uci.Section) []string {
+	names := make([]string, 0, len(section.Options)+len(section.Lists))
+	for name := range section.Options {
+		names = append(names, name)
+	}
+	for name := range section.Lists {
+		if _, ok := section.Options[name]; !ok {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	return names
+}
@@ -0,0 +1,227 @@
+package openwrt
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/thesabbir/hellfire/pkg/integrity"
+	"github.com/thesabbir/hellfire/pkg/uci"
+)
+
+// A stock OpenWrt 22.03 config, trimmed
+var backup = map[string]string{
+	"network": `
+config interface 'loopback'
+	option device 'lo'
+	option proto 'static'
+	option ipaddr '127.0.0.1'
+	option netmask '255.0.0.0'
+
+config globals 'globals'
+	option ula_prefix 'fd12:3456:789a::/48'
+
+config device
+	option name 'br-lan'
+	option type 'bridge'
+	list ports 'lan1'
+	list ports 'lan2'
+
+config device
+	option type '8021q'
+	option ifname 'wan'
+	option vid '10'
+
+config interface 'lan'
+	option device 'br-lan'
+	option proto 'static'
+	option ipaddr '192.168.1.1/24'
+	option ip6assign '60'
+
+config interface 'wan'
+	option device 'wan'
+	option proto 'dhcp'
+	option metric '10'
+
+config interface 'wan6'
+	option device 'wan'
+	option proto 'dhcpv6'
+`,
+	"firewall": `
+config defaults
+	option syn_flood '1'
+	option input 'ACCEPT'
+	option output 'ACCEPT'
+	option forward 'REJECT'
+
+config zone
+	option name 'lan'
+	list network 'lan'
+	option input 'ACCEPT'
+	option output 'ACCEPT'
+	option forward 'ACCEPT'
+
+config zone
+	option name 'wan'
+	list network 'wan'
+	list network 'wan6'
+	option input 'REJECT'
+	option output 'ACCEPT'
+	option forward 'REJECT'
+	option masq '1'
+	option mtu_fix '1'
+
+config forwarding
+	option src 'lan'
+	option dest 'wan'
+
+config rule
+	option name 'Allow-Ping'
+	option src 'wan'
+	option proto 'icmp'
+	option icmp_type 'echo-request'
+	option family 'ipv4'
+	option target 'ACCEPT'
+
+config rule
+	option name 'Allow-ISAKMP'
+	option src 'wan'
+	option dest 'lan'
+	option dest_port '500'
+	option proto 'udp'
+	option target 'ACCEPT'
+
+config rule
+	option name 'Allow-DNS'
+	option src 'lan'
+	option dest_port '53'
+	option target 'ACCEPT'
+`,
+	"dhcp": `
+config dnsmasq
+	option domainneeded '1'
+	option local '/lan/'
+	option domain 'lan'
+	option leasefile '/tmp/dhcp.leases'
+
+config dhcp 'lan'
+	option interface 'lan'
+	option start '100'
+	option limit '150'
+	option leasetime '12h'
+	option dhcpv6 'server'
+
+config dhcp 'wan'
+	option interface 'wan'
+	option ignore '1'
+`,
+}
+
+func parse(t *testing.T, configs map[string]string) map[string]*uci.Config {
+	t.Helper()
+	parsed := make(map[string]*uci.Config)
+	for name, text := range configs {
+		cfg, err := uci.Parse(strings.NewReader(text))
+		if err != nil {
+			t.Fatal(err)
+		}
+		parsed[name] = cfg
+	}
+	return parsed
+}
+
+func TestConvert(t *testing.T) {
+	result := Convert(parse(t, backup))
+	network, firewall, dhcp := result.Configs["network"], result.Configs["firewall"], result.Configs["dhcp"]
+
+	var ifaces []string
+	for _, iface := range network.GetSectionsByType("interface") {
+		ifaces = append(ifaces, iface.Name+"="+iface.Options["proto"]+" "+iface.Options["ipaddr"]+" "+iface.Options["netmask"])
+	}
+	if got := strings.Join(ifaces, ","); got != "br-lan=static 192.168.1.1 255.255.255.0,wan=dhcp  " {
+		t.Errorf("interfaces = %s", got)
+	}
+	if metric := network.GetSection("interface", "wan").Options["metric"]; metric != "10" {
+		t.Errorf("wan metric = %q", metric)
+	}
+	if devices := network.GetSectionsByType("device"); len(devices) != 1 || devices[0].Options["name"] != "wan.10" || devices[0].Options["vid"] != "10" {
+		t.Errorf("vlan devices = %+v", devices)
+	}
+
+	var zones []string
+	for _, zone := range firewall.GetSectionsByType("zone") {
+		zones = append(zones, zone.Options["name"]+"="+strings.Join(zone.GetList("network"), " "))
+	}
+	if got := strings.Join(zones, ","); got != "lan=br-lan,wan=wan" {
+		t.Errorf("zones = %s", got)
+	}
+
+	// Allow-Ping is dropped whole; Allow-DNS matches TCP and UDP like on OpenWrt
+	var rules []string
+	for _, rule := range firewall.GetSectionsByType("rule") {
+		rules = append(rules, rule.Options["name"]+"/"+rule.Options["proto"])
+	}
+	if got := strings.Join(rules, ","); got != "Allow-ISAKMP/udp,Allow-DNS/tcp,Allow-DNS/udp" {
+		t.Errorf("rules = %s", got)
+	}
+
+	pool := dhcp.GetSection("dhcp", "br-lan")
+	if pool == nil || pool.Options["start"] != "192.168.1.100" || pool.Options["limit"] != "192.168.1.249" {
+		t.Errorf("lan pool = %+v", pool)
+	}
+	if local := dhcp.GetSectionsByType("dnsmasq")[0].Options["local"]; local != "lan" {
+		t.Errorf("local = %q", local)
+	}
+
+	var issues []string
+	for _, issue := range result.Issues {
+		issues = append(issues, issue.Config+"."+issue.Section+"."+issue.Option)
+	}
+	for _, want := range []string{
+		"network.lan.ip6assign",
+		"network.wan6.proto",
+		"network.@device[0].",
+		"network.globals.",
+		"firewall.@defaults[0].syn_flood",
+		"firewall.@zone[1].network", // wan6
+		"firewall.@zone[1].mtu_fix",
+		"firewall.@rule[0].icmp_type",
+		"dhcp.@dnsmasq[0].leasefile",
+		"dhcp.lan.dhcpv6",
+	} {
+		if !strings.Contains(strings.Join(issues, " ")+" ", want+" ") {
+			t.Errorf("missing issue %s in %v", want, issues)
+		}
+	}
+
+	// The converted configs refer to each other consistently
+	broken, err := integrity.Check(func(name string) (*uci.Config, error) { return result.Configs[name], nil })
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(broken) != 0 {
+		t.Errorf("broken references: %v", broken)
+	}
+}
+
+func TestLoadDir(t *testing.T) {
+	dir := t.TempDir()
+	configDir := filepath.Join(dir, "etc", "config")
+	if err := os.MkdirAll(configDir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"network", "system"} {
+		if err := os.WriteFile(filepath.Join(configDir, name), []byte(backup["network"]), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	configs, err := Load(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(configs) != 1 || configs["network"] == nil {
+		t.Errorf("Load() = %v, want only network", configs)
+	}
+}
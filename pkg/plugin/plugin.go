@@ -0,0 +1,242 @@
+// Package plugin finds the commands add-on subsystems ship for hf, which
+// runs them as its own subcommands: hf-<name> executables on PATH, as git
+// does, and commands their daemons register through the API.
+package plugin
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/thesabbir/hellfire/pkg/util"
+)
+
+const (
+	// Prefix is the name prefix of plugin executables on PATH
+	Prefix = "hf-"
+
+	// DefaultRegistryFile holds the registered plugin commands
+	DefaultRegistryFile = "/var/lib/hellfire/plugins.json"
+)
+
+// Where a plugin command comes from
+const (
+	SourcePath       = "path"       // An hf-<name> executable on PATH
+	SourceRegistered = "registered" // Registered through the API
+)
+
+// Command is a plugin command
+type Command struct {
+	Name         string     `json:"name"`
+	Description  string     `json:"description,omitempty"` // One line for hf --help
+	Path         string     `json:"path"`                  // Executable run with the arguments
+	Source       string     `json:"source"`
+	RegisteredBy string     `json:"registered_by,omitempty"`
+	RegisteredAt *time.Time `json:"registered_at,omitempty"`
+}
+
+var namePattern = regexp.MustCompile(`^[a-z0-9][a-z0-9_-]{0,31}$`)
+
+// ValidName reports whether name can be a plugin command: up to 32 lower
+// case letters, digits, dashes and underscores
+func ValidName(name string) bool {
+	return namePattern.MatchString(name)
+}
+
+// Discover finds the hf-<name> executables in the directories of pathList
+// (as in $PATH), by name. The first of a name on the path wins, as it
+// would for the shell.
+func Discover(pathList string) []Command {
+	seen := make(map[string]bool)
+	var commands []Command
+	for _, dir := range filepath.SplitList(pathList) {
+		// Unlike the shell, never look in the working directory: an empty
+		// or relative entry would run whatever hf-<name> happens to be there
+		if !filepath.IsAbs(dir) {
+			continue
+		}
+		entries, err := os.ReadDir(dir)
+		if err != nil {
+			continue
+		}
+		for _, entry := range entries {
+			name, ok := strings.CutPrefix(entry.Name(), Prefix)
+			if !ok || !ValidName(name) || seen[name] {
+				continue
+			}
+			path := filepath.Join(dir, entry.Name())
+			if info, err := os.Stat(path); err != nil || !info.Mode().IsRegular() || info.Mode()&0111 == 0 {
+				continue
+			}
+			seen[name] = true
+			commands = append(commands, Command{Name: name, Path: path, Source: SourcePath})
+		}
+	}
+	slices.SortFunc(commands, func(a, b Command) int { return strings.Compare(a.Name, b.Name) })
+	return commands
+}
+
+// CheckExecutable checks that a registered command can be run safely: an
+// absolute path to an executable file that only root or the user running
+// hf can modify, as hf runs it with that user's privileges and API
+// credentials. The same goes for every directory above it, as given and
+// with symbolic links resolved, so the file can't be swapped for another;
+// a sticky directory such as /tmp may be writable by all, as no one else
+// can replace what it holds.
+func CheckExecutable(path string) error {
+	if !filepath.IsAbs(path) {
+		return fmt.Errorf("%q is not an absolute path", path)
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		return err
+	}
+	switch mode := info.Mode(); {
+	case !mode.IsRegular():
+		return fmt.Errorf("%s is not a file", path)
+	case mode&0111 == 0:
+		return fmt.Errorf("%s is not executable", path)
+	}
+
+	resolved, err := filepath.EvalSymlinks(path)
+	if err != nil {
+		return err
+	}
+	for _, p := range []string{filepath.Clean(path), resolved} {
+		for {
+			if err := checkOwner(p); err != nil {
+				return err
+			}
+			parent := filepath.Dir(p)
+			if parent == p {
+				break
+			}
+			p = parent
+		}
+	}
+	return nil
+}
+
+// checkOwner checks that only root or the user running hf can modify path
+func checkOwner(path string) error {
+	info, err := os.Stat(path)
+	if err != nil {
+		return err
+	}
+	if stat, ok := info.Sys().(*syscall.Stat_t); ok && stat.Uid != 0 && int(stat.Uid) != os.Geteuid() {
+		return fmt.Errorf("%s is owned by uid %d, not root", path, stat.Uid)
+	}
+	mode := info.Mode()
+	if mode&0022 != 0 && !(mode.IsDir() && mode&os.ModeSticky != 0) {
+		return fmt.Errorf("%s is writable by group or others", path)
+	}
+	return nil
+}
+
+// Registry keeps the commands registered through the API in a file, read
+// by the hf processes that list and run them
+type Registry struct {
+	path string
+	mu   sync.Mutex
+}
+
+// NewRegistry creates a registry stored in path
+func NewRegistry(path string) *Registry {
+	return &Registry{path: path}
+}
+
+// List returns the registered commands by name
+func (r *Registry) List() ([]Command, error) {
+	data, err := os.ReadFile(r.path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read the plugin registry: %w", err)
+	}
+	var commands []Command
+	if err := json.Unmarshal(data, &commands); err != nil {
+		return nil, fmt.Errorf("failed to parse the plugin registry: %w", err)
+	}
+	return commands, nil
+}
+
+// Register adds a command, or replaces the one of the same name
+func (r *Registry) Register(command Command) error {
+	if !ValidName(command.Name) {
+		return fmt.Errorf("invalid plugin name %q: use up to 32 lower case letters, digits, dashes and underscores", command.Name)
+	}
+	if strings.ContainsAny(command.Description, "\r\n") {
+		return fmt.Errorf("the description must be a single line")
+	}
+	if err := CheckExecutable(command.Path); err != nil {
+		return err
+	}
+	command.Source = SourceRegistered
+	if command.RegisteredAt == nil {
+		now := time.Now().UTC()
+		command.RegisteredAt = &now
+	}
+
+	return r.update(func(commands []Command) []Command {
+		commands = slices.DeleteFunc(commands, func(c Command) bool { return c.Name == command.Name })
+		commands = append(commands, command)
+		slices.SortFunc(commands, func(a, b Command) int { return strings.Compare(a.Name, b.Name) })
+		return commands
+	})
+}
+
+// Unregister removes a command, reporting whether it was registered
+func (r *Registry) Unregister(name string) (bool, error) {
+	found := false
+	err := r.update(func(commands []Command) []Command {
+		return slices.DeleteFunc(commands, func(c Command) bool {
+			found = found || c.Name == name
+			return c.Name == name
+		})
+	})
+	return found, err
+}
+
+// update rewrites the registry with the commands fn returns
+func (r *Registry) update(fn func([]Command) []Command) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	commands, err := r.List()
+	if err != nil {
+		return err
+	}
+	data, err := json.MarshalIndent(fn(commands), "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(r.path), 0755); err != nil {
+		return err
+	}
+	if err := util.WriteFileAtomic(r.path, append(data, '\n'), 0644); err != nil {
+		return fmt.Errorf("failed to write the plugin registry: %w", err)
+	}
+	return nil
+}
+
+// Merge combines the registered and discovered commands by name; a
+// registered command hides an executable on PATH of the same name
+func Merge(registered, discovered []Command) []Command {
+	commands := slices.Clone(registered)
+	for _, command := range discovered {
+		if !slices.ContainsFunc(registered, func(c Command) bool { return c.Name == command.Name }) {
+			commands = append(commands, command)
+		}
+	}
+	slices.SortFunc(commands, func(a, b Command) int { return strings.Compare(a.Name, b.Name) })
+	return commands
+}
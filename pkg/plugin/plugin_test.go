@@ -0,0 +1,162 @@
+package plugin
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// writeExecutable writes a script with the given mode
+func writeExecutable(t *testing.T, path string, mode os.FileMode) {
+	t.Helper()
+	if err := os.WriteFile(path, []byte("#!/bin/sh\n"), mode); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chmod(path, mode); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestDiscover(t *testing.T) {
+	first, second := t.TempDir(), t.TempDir()
+	writeExecutable(t, filepath.Join(first, "hf-vpn"), 0755)
+	writeExecutable(t, filepath.Join(second, "hf-vpn"), 0755) // Hidden by the first
+	writeExecutable(t, filepath.Join(second, "hf-backup"), 0755)
+	writeExecutable(t, filepath.Join(second, "hf-notes"), 0644)    // Not executable
+	writeExecutable(t, filepath.Join(second, "hf-Bad.name"), 0755) // Invalid name
+	writeExecutable(t, filepath.Join(second, "other-tool"), 0755)  // No prefix
+	if err := os.Mkdir(filepath.Join(second, "hf-dir"), 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	// An empty entry must not search the working directory
+	t.Chdir(t.TempDir())
+	writeExecutable(t, "hf-cwd", 0755)
+
+	commands := Discover(strings.Join([]string{first, "", filepath.Join(first, "missing"), second}, string(os.PathListSeparator)))
+	if len(commands) != 2 {
+		t.Fatalf("Discover() = %+v, want backup and vpn", commands)
+	}
+	if commands[0].Name != "backup" || commands[1].Name != "vpn" {
+		t.Errorf("Discover() = %+v, want backup and vpn by name", commands)
+	}
+	if commands[1].Path != filepath.Join(first, "hf-vpn") || commands[1].Source != SourcePath {
+		t.Errorf("vpn = %+v, want the first on the path", commands[1])
+	}
+}
+
+func TestRegistry(t *testing.T) {
+	dir := t.TempDir()
+	tool := filepath.Join(dir, "tool")
+	writeExecutable(t, tool, 0755)
+	registry := NewRegistry(filepath.Join(dir, "state", "plugins.json"))
+
+	if commands, err := registry.List(); err != nil || len(commands) != 0 {
+		t.Fatalf("List() of a new registry = %v, %v", commands, err)
+	}
+	if err := registry.Register(Command{Name: "vpn", Path: tool, Description: "Manage the VPN"}); err != nil {
+		t.Fatalf("Register: %v", err)
+	}
+	if err := registry.Register(Command{Name: "vpn", Path: tool, Description: "Manage VPNs"}); err != nil {
+		t.Fatalf("Register again: %v", err)
+	}
+	commands, err := registry.List()
+	if err != nil || len(commands) != 1 {
+		t.Fatalf("List() = %+v, %v, want one command", commands, err)
+	}
+	if commands[0].Description != "Manage VPNs" || commands[0].Source != SourceRegistered || commands[0].RegisteredAt == nil {
+		t.Errorf("Registered command = %+v", commands[0])
+	}
+
+	for _, invalid := range []Command{
+		{Name: "Bad Name", Path: tool},
+		{Name: "ok", Path: "tool"},
+		{Name: "ok", Path: filepath.Join(dir, "missing")},
+		{Name: "ok", Path: tool, Description: "two\nlines"},
+	} {
+		if err := registry.Register(invalid); err == nil {
+			t.Errorf("Register(%+v) succeeded", invalid)
+		}
+	}
+
+	writable := filepath.Join(dir, "writable")
+	writeExecutable(t, writable, 0777)
+	if err := registry.Register(Command{Name: "ok", Path: writable}); err == nil {
+		t.Error("Registered a world-writable executable")
+	}
+
+	if found, err := registry.Unregister("vpn"); err != nil || !found {
+		t.Errorf("Unregister(vpn) = %v, %v", found, err)
+	}
+	if found, err := registry.Unregister("vpn"); err != nil || found {
+		t.Errorf("Unregister(vpn) again = %v, %v", found, err)
+	}
+}
+
+func TestCheckExecutable(t *testing.T) {
+	dir := t.TempDir()
+	tool := filepath.Join(dir, "tool")
+	writeExecutable(t, tool, 0755)
+	if err := CheckExecutable(tool); err != nil {
+		t.Fatalf("CheckExecutable(%s) = %v", tool, err)
+	}
+
+	// Anyone who can write to a directory above the file can replace it
+	shared := filepath.Join(dir, "shared")
+	if err := os.MkdirAll(filepath.Join(shared, "bin"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chmod(shared, 0775); err != nil {
+		t.Fatal(err)
+	}
+	inShared := filepath.Join(shared, "bin", "tool")
+	writeExecutable(t, inShared, 0755)
+	if err := CheckExecutable(inShared); err == nil {
+		t.Error("Accepted an executable under a group-writable directory")
+	}
+
+	// Or, through a link, the file it points to
+	link := filepath.Join(dir, "link")
+	if err := os.Symlink(inShared, link); err != nil {
+		t.Fatal(err)
+	}
+	if err := CheckExecutable(link); err == nil {
+		t.Error("Accepted a link to an executable under a group-writable directory")
+	}
+
+	// Sticky directories, like /tmp, are fine
+	sticky := filepath.Join(dir, "sticky")
+	if err := os.Mkdir(sticky, 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chmod(sticky, 0777|os.ModeSticky); err != nil {
+		t.Fatal(err)
+	}
+	inSticky := filepath.Join(sticky, "tool")
+	writeExecutable(t, inSticky, 0755)
+	if err := CheckExecutable(inSticky); err != nil {
+		t.Errorf("CheckExecutable(%s) = %v", inSticky, err)
+	}
+
+	if os.Geteuid() == 0 {
+		if err := os.Chown(tool, 65534, 65534); err != nil {
+			t.Fatal(err)
+		}
+		if err := CheckExecutable(tool); err == nil {
+			t.Error("Accepted an executable owned by another user")
+		}
+	}
+}
+
+func TestMerge(t *testing.T) {
+	registered := []Command{{Name: "vpn", Path: "/usr/lib/vpnd/hf-vpn", Source: SourceRegistered}}
+	discovered := []Command{
+		{Name: "backup", Path: "/usr/bin/hf-backup", Source: SourcePath},
+		{Name: "vpn", Path: "/usr/bin/hf-vpn", Source: SourcePath},
+	}
+	commands := Merge(registered, discovered)
+	if len(commands) != 2 || commands[0].Name != "backup" || commands[1].Source != SourceRegistered {
+		t.Errorf("Merge() = %+v, want backup and the registered vpn", commands)
+	}
+}
@@ -0,0 +1,266 @@
+// Package portability checks that configs made on one router can be applied
+// on another, such as replacement hardware a snapshot or backup is restored
+// onto: that the interfaces they configure exist, on devices with the same
+// drivers, and that the programs and appliers they need are installed.
+package portability
+
+import (
+	"fmt"
+	"maps"
+	"os/exec"
+	"slices"
+	"strings"
+
+	"github.com/thesabbir/hellfire/pkg/modem"
+	"github.com/thesabbir/hellfire/pkg/netdetect"
+	"github.com/thesabbir/hellfire/pkg/snapshot"
+	"github.com/thesabbir/hellfire/pkg/uci"
+)
+
+// Kinds of issues
+const (
+	KindInterface = "interface" // A configured interface has no device here
+	KindDriver    = "driver"    // Its device here has another driver
+	KindBinary    = "binary"    // A program the config needs isn't installed
+	KindApplier   = "applier"   // Nothing applies the config here
+	KindDevice    = "device"    // No device of a kind the config needs
+)
+
+// Issue is something that keeps a config from working here
+type Issue struct {
+	Kind    string `json:"kind"`
+	Subject string `json:"subject"` // Interface, program or config concerned
+	Message string `json:"message"`
+	Remedy  string `json:"remedy"`
+	Warning bool   `json:"warning"` // May work anyway; not blocking
+}
+
+func (i Issue) String() string {
+	s := i.Message
+	if i.Remedy != "" {
+		s += ": " + i.Remedy
+	}
+	if i.Warning {
+		s = "(warning) " + s
+	}
+	return s
+}
+
+// Blocking returns the issues that aren't warnings
+func Blocking(issues []Issue) []Issue {
+	var blocking []Issue
+	for _, issue := range issues {
+		if !issue.Warning {
+			blocking = append(blocking, issue)
+		}
+	}
+	return blocking
+}
+
+// System is the router the configs are checked against
+type System struct {
+	Interfaces []netdetect.Interface
+	Appliers   []string                     // Registered appliers
+	LookPath   func(string) (string, error) // exec.LookPath by default
+}
+
+// Local describes this router, with its registered appliers
+func Local(appliers []string) (System, error) {
+	interfaces, err := netdetect.Detect()
+	if err != nil {
+		return System{}, fmt.Errorf("failed to list the network devices: %w", err)
+	}
+	return System{Interfaces: interfaces, Appliers: appliers, LookPath: exec.LookPath}, nil
+}
+
+// Configs whose programs and appliers are checked
+var appliedConfigs = []string{"network", "firewall", "dhcp", "wireless", "openvpn", "ipsec"}
+
+// Check lists what keeps configs from working on sys. source lists the
+// devices of the router they were made on, when known, to compare drivers
+// and suggest the devices to map missing interfaces onto.
+func Check(configs map[string]*uci.Config, source []snapshot.Device, sys System) []Issue {
+	lookPath := sys.LookPath
+	if lookPath == nil {
+		lookPath = exec.LookPath
+	}
+
+	local := make(map[string]netdetect.Interface)
+	for _, iface := range sys.Interfaces {
+		local[iface.Name] = iface
+	}
+	drivers := make(map[string]string)
+	for _, device := range source {
+		drivers[device.Name] = device.Driver
+	}
+
+	var issues []Issue
+	if network := configs["network"]; network != nil {
+		issues = append(issues, checkInterfaces(network, drivers, sys.Interfaces, local)...)
+	}
+
+	if wireless := configs["wireless"]; wireless != nil && len(wireless.GetSectionsByType("wifi-iface")) > 0 &&
+		!slices.ContainsFunc(sys.Interfaces, func(i netdetect.Interface) bool { return i.Kind == netdetect.KindWireless }) {
+		issues = append(issues, Issue{
+			Kind:    KindDevice,
+			Subject: "wireless",
+			Message: "the wireless config has access points but this router has no wireless device",
+			Remedy:  "add a radio, or remove the wifi-iface sections",
+		})
+	}
+
+	// Programs, in config order, each reported once
+	needed := make(map[string]string)
+	var programs []string
+	for _, name := range appliedConfigs {
+		cfg := configs[name]
+		if cfg == nil {
+			continue
+		}
+		for _, req := range requiredPrograms(name, cfg) {
+			if _, ok := needed[req.program]; !ok {
+				needed[req.program] = req.reason
+				programs = append(programs, req.program)
+			}
+		}
+
+		if sys.Appliers != nil && len(cfg.Sections) > 0 && !slices.Contains(sys.Appliers, name) {
+			issues = append(issues, Issue{
+				Kind:    KindApplier,
+				Subject: name,
+				Message: fmt.Sprintf("nothing applies the %s config on this router", name),
+				Remedy:  "it is restored but has no effect",
+				Warning: true,
+			})
+		}
+	}
+	for _, program := range programs {
+		if _, err := lookPath(program); err != nil {
+			issues = append(issues, Issue{
+				Kind:    KindBinary,
+				Subject: program,
+				Message: fmt.Sprintf("%s is not installed (needed for %s)", program, needed[program]),
+				Remedy:  "install it before restoring",
+			})
+		}
+	}
+	return issues
+}
+
+// checkInterfaces checks that the interfaces backed by a device have one
+// here, with the driver they had
+func checkInterfaces(network *uci.Config, drivers map[string]string, interfaces []netdetect.Interface, local map[string]netdetect.Interface) []Issue {
+	configured := make(map[string]bool)
+	for _, section := range network.GetSectionsByType("interface") {
+		configured[section.Name] = true
+	}
+
+	var issues []Issue
+	for _, section := range network.GetSectionsByType("interface") {
+		// Tunnels and modems are created by the appliers and ModemManager
+		proto, _ := section.GetOption("proto")
+		if proto != "static" && proto != "dhcp" && proto != "none" {
+			continue
+		}
+		name := section.Name
+		driver := drivers[name]
+
+		iface, ok := local[name]
+		if !ok {
+			message := fmt.Sprintf("%s doesn't exist here", name)
+			if driver != "" {
+				message = fmt.Sprintf("%s (%s) doesn't exist here", name, driver)
+			}
+			issues = append(issues, Issue{
+				Kind:    KindInterface,
+				Subject: name,
+				Message: message,
+				Remedy:  mapRemedy(driver, interfaces, configured),
+			})
+			continue
+		}
+		if driver != "" && iface.Driver != "" && iface.Driver != driver {
+			issues = append(issues, Issue{
+				Kind:    KindDriver,
+				Subject: name,
+				Message: fmt.Sprintf("%s was driven by %s, here by %s", name, driver, iface.Driver),
+				Remedy:  "check that it is the port you meant, or map the interface onto another",
+				Warning: true,
+			})
+		}
+	}
+	return issues
+}
+
+// mapRemedy suggests the unconfigured devices to map an interface onto,
+// those with its driver first
+func mapRemedy(driver string, interfaces []netdetect.Interface, configured map[string]bool) string {
+	var same, other []string
+	for _, iface := range interfaces {
+		if configured[iface.Name] || !iface.Physical || iface.Kind == netdetect.KindLoopback {
+			continue
+		}
+		if driver != "" && iface.Driver == driver {
+			same = append(same, iface.Name)
+		} else {
+			other = append(other, iface.Name)
+		}
+	}
+	candidates := append(same, other...)
+	if len(candidates) == 0 {
+		return "no unused device here to map it onto"
+	}
+	return "map it onto " + strings.Join(candidates, " or ")
+}
+
+// requirement is a program a config needs, and what for
+type requirement struct {
+	program string
+	reason  string
+}
+
+// requiredPrograms lists the programs the applier of a config runs for its
+// sections
+func requiredPrograms(name string, cfg *uci.Config) []requirement {
+	var reqs []requirement
+	switch name {
+	case "network":
+		reqs = append(reqs, requirement{"ip", "the interfaces"})
+		protos := make(map[string]bool)
+		for _, section := range cfg.GetSectionsByType("interface") {
+			proto, _ := section.GetOption("proto")
+			protos[proto] = true
+		}
+		for _, proto := range slices.Sorted(maps.Keys(protos)) {
+			switch proto {
+			case "dhcp":
+				reqs = append(reqs, requirement{"dhclient", "DHCP interfaces"})
+			case "wireguard":
+				reqs = append(reqs, requirement{"wg", "WireGuard interfaces"})
+			case modem.ProtoModemManager, modem.ProtoQMI:
+				reqs = append(reqs, requirement{"mmcli", "modem interfaces"})
+			}
+		}
+	case "firewall":
+		if len(cfg.Sections) > 0 {
+			reqs = append(reqs, requirement{"nft", "the firewall"})
+		}
+	case "dhcp":
+		if len(cfg.Sections) > 0 {
+			reqs = append(reqs, requirement{"dnsmasq", "DHCP and DNS"})
+		}
+	case "wireless":
+		if len(cfg.GetSectionsByType("wifi-iface")) > 0 {
+			reqs = append(reqs, requirement{"hostapd", "access points"})
+		}
+	case "openvpn":
+		if len(cfg.GetSectionsByType("openvpn")) > 0 {
+			reqs = append(reqs, requirement{"openvpn", "OpenVPN instances"})
+		}
+	case "ipsec":
+		if len(cfg.GetSectionsByType("tunnel")) > 0 {
+			reqs = append(reqs, requirement{"swanctl", "IPsec tunnels"})
+		}
+	}
+	return reqs
+}
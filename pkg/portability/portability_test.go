@@ -0,0 +1,79 @@
+package portability
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/thesabbir/hellfire/pkg/netdetect"
+	"github.com/thesabbir/hellfire/pkg/snapshot"
+	"github.com/thesabbir/hellfire/pkg/uci"
+)
+
+func TestCheck(t *testing.T) {
+	configs := make(map[string]*uci.Config)
+	for name, text := range map[string]string{
+		"network": `
+config interface 'eth0'
+	option proto 'dhcp'
+
+config interface 'eth1'
+	option proto 'static'
+	option ipaddr '192.168.1.1'
+	option netmask '255.255.255.0'
+
+config interface 'wg0'
+	option proto 'wireguard'
+`,
+		"firewall": "config defaults\n\toption input 'DROP'\n",
+		"wireless": "config wifi-iface 'ap'\n\toption ssid 'home'\n",
+	} {
+		cfg, err := uci.Parse(strings.NewReader(text))
+		if err != nil {
+			t.Fatal(err)
+		}
+		configs[name] = cfg
+	}
+
+	source := []snapshot.Device{{Name: "eth0", Kind: "ethernet", Driver: "igb"}, {Name: "eth1", Kind: "ethernet", Driver: "igb"}}
+	installed := map[string]bool{"ip": true, "nft": true, "dhclient": true}
+	sys := System{
+		Interfaces: []netdetect.Interface{
+			{Name: "lo", Kind: netdetect.KindLoopback},
+			{Name: "eth0", Kind: netdetect.KindEthernet, Physical: true, Driver: "r8169"},
+			{Name: "enp3s0", Kind: netdetect.KindEthernet, Physical: true, Driver: "e1000e"},
+			{Name: "enp2s0", Kind: netdetect.KindEthernet, Physical: true, Driver: "igb"},
+		},
+		Appliers: []string{"network", "firewall", "dhcp"},
+		LookPath: func(name string) (string, error) {
+			if installed[name] {
+				return "/usr/sbin/" + name, nil
+			}
+			return "", errors.New("not found")
+		},
+	}
+
+	issues := Check(configs, source, sys)
+	got := make(map[string]Issue)
+	for _, issue := range issues {
+		got[issue.Kind+" "+issue.Subject] = issue
+	}
+
+	if issue, ok := got["interface eth1"]; !ok || issue.Remedy != "map it onto enp2s0 or enp3s0" {
+		t.Errorf("missing eth1 reported as %+v", issue)
+	}
+	if issue, ok := got["driver eth0"]; !ok || !issue.Warning {
+		t.Errorf("driver change of eth0 reported as %+v", issue)
+	}
+	for _, key := range []string{"binary wg", "binary hostapd", "device wireless", "applier wireless"} {
+		if _, ok := got[key]; !ok {
+			t.Errorf("no %s issue in %v", key, issues)
+		}
+	}
+	if _, ok := got["binary nft"]; ok {
+		t.Errorf("installed program reported: %v", issues)
+	}
+	if blocking := Blocking(issues); len(blocking) != 4 {
+		t.Errorf("%d blocking issues, want 4: %v", len(blocking), blocking)
+	}
+}
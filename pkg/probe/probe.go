@@ -0,0 +1,190 @@
+// Package probe checks that the router is still usable after a configuration
+// change: the default gateway answers, names resolve, configured endpoints
+// can be reached and the management API is reachable from the LAN.
+package probe
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"os/exec"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Probe is a single connectivity check
+type Probe interface {
+	Name() string
+	Run(ctx context.Context) error
+}
+
+// Options controls how often probes run while a commit awaits confirmation
+type Options struct {
+	Delay    time.Duration // Before the first round, so links and DHCP can settle
+	Interval time.Duration // Between rounds
+	Timeout  time.Duration // Per probe
+	Failures int           // Consecutive failed rounds before rolling back
+}
+
+// Result is the outcome of one probe
+type Result struct {
+	Name     string `json:"name"`
+	OK       bool   `json:"ok"`
+	Error    string `json:"error,omitempty"`
+	Duration int64  `json:"duration_ms"`
+}
+
+// Run runs every probe, each with its own timeout, and reports whether all passed
+func Run(ctx context.Context, probes []Probe, timeout time.Duration) ([]Result, bool) {
+	results := make([]Result, 0, len(probes))
+	ok := true
+
+	for _, p := range probes {
+		probeCtx, cancel := context.WithTimeout(ctx, timeout)
+		start := time.Now()
+		err := p.Run(probeCtx)
+		cancel()
+
+		result := Result{
+			Name:     p.Name(),
+			OK:       err == nil,
+			Duration: time.Since(start).Milliseconds(),
+		}
+		if err != nil {
+			result.Error = err.Error()
+			ok = false
+		}
+		results = append(results, result)
+	}
+
+	return results, ok
+}
+
+// Gateway pings the IPv4 default gateway
+type Gateway struct{}
+
+// Name returns the probe name
+func (Gateway) Name() string {
+	return "gateway"
+}
+
+// Run fails if there is no default route or its gateway doesn't answer
+func (Gateway) Run(ctx context.Context) error {
+	output, err := exec.CommandContext(ctx, "ip", "-4", "-j", "route", "show", "default").Output()
+	if err != nil {
+		return fmt.Errorf("failed to read default route: %w", err)
+	}
+
+	var routes []struct {
+		Gateway string `json:"gateway"`
+	}
+	if err := json.Unmarshal(output, &routes); err != nil {
+		return fmt.Errorf("failed to parse default route: %w", err)
+	}
+
+	gateway := ""
+	for _, route := range routes {
+		if route.Gateway != "" {
+			gateway = route.Gateway
+			break
+		}
+	}
+	if gateway == "" {
+		return fmt.Errorf("no default gateway")
+	}
+
+	// ping's own deadline keeps it from outliving the context
+	wait := "1"
+	if deadline, ok := ctx.Deadline(); ok {
+		if secs := int(time.Until(deadline).Seconds()); secs > 1 {
+			wait = strconv.Itoa(secs)
+		}
+	}
+
+	if err := exec.CommandContext(ctx, "ping", "-c", "1", "-W", wait, gateway).Run(); err != nil {
+		return fmt.Errorf("gateway %s unreachable: %w", gateway, err)
+	}
+	return nil
+}
+
+// DNS resolves a host name with the system resolver
+type DNS struct {
+	Host string
+}
+
+// Name returns the probe name
+func (p DNS) Name() string {
+	return "dns:" + p.Host
+}
+
+// Run fails if the name doesn't resolve to any address
+func (p DNS) Run(ctx context.Context) error {
+	addrs, err := net.DefaultResolver.LookupHost(ctx, p.Host)
+	if err != nil {
+		return fmt.Errorf("failed to resolve %s: %w", p.Host, err)
+	}
+	if len(addrs) == 0 {
+		return fmt.Errorf("%s resolved to no addresses", p.Host)
+	}
+	return nil
+}
+
+// Endpoint reaches an HTTP(S) URL, or a host:port over TCP
+type Endpoint struct {
+	Target string
+}
+
+// Name returns the probe name
+func (p Endpoint) Name() string {
+	return "endpoint:" + p.Target
+}
+
+// Run fails if the endpoint can't be reached or answers with a server error
+func (p Endpoint) Run(ctx context.Context) error {
+	if !strings.HasPrefix(p.Target, "http://") && !strings.HasPrefix(p.Target, "https://") {
+		return dial(ctx, p.Target)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, p.Target, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to reach %s: %w", p.Target, err)
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode >= http.StatusInternalServerError {
+		return fmt.Errorf("%s answered %s", p.Target, resp.Status)
+	}
+	return nil
+}
+
+// Listener checks that a local management port accepts connections on a
+// LAN address, so the API is still reachable where admins connect from
+type Listener struct {
+	Address string // host:port
+}
+
+// Name returns the probe name
+func (p Listener) Name() string {
+	return "api:" + p.Address
+}
+
+// Run fails if nothing accepts connections on the address
+func (p Listener) Run(ctx context.Context) error {
+	return dial(ctx, p.Address)
+}
+
+func dial(ctx context.Context, address string) error {
+	var dialer net.Dialer
+	conn, err := dialer.DialContext(ctx, "tcp", address)
+	if err != nil {
+		return fmt.Errorf("failed to connect to %s: %w", address, err)
+	}
+	return conn.Close()
+}
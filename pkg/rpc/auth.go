@@ -0,0 +1,122 @@
+package rpc
+
+import (
+	"context"
+	"strings"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+
+	"github.com/thesabbir/hellfire/pkg/audit"
+	"github.com/thesabbir/hellfire/pkg/auth"
+	"github.com/thesabbir/hellfire/pkg/db"
+	"github.com/thesabbir/hellfire/pkg/logger"
+	"github.com/thesabbir/hellfire/pkg/rpc/hellfirev1"
+)
+
+// methodPermissions maps each RPC to the permission it requires
+var methodPermissions = map[string]auth.Permission{
+	hellfirev1.ConfigService_GetConfig_FullMethodName:             auth.PermConfigRead,
+	hellfirev1.ConfigService_GetChanges_FullMethodName:            auth.PermConfigRead,
+	hellfirev1.ConfigService_SetOption_FullMethodName:             auth.PermConfigWrite,
+	hellfirev1.ConfigService_Batch_FullMethodName:                 auth.PermConfigWrite,
+	hellfirev1.ConfigService_Revert_FullMethodName:                auth.PermConfigWrite,
+	hellfirev1.TransactionService_Commit_FullMethodName:           auth.PermConfigCommit,
+	hellfirev1.TransactionService_Confirm_FullMethodName:          auth.PermConfigCommit,
+	hellfirev1.TransactionService_Rollback_FullMethodName:         auth.PermConfigCommit,
+	hellfirev1.TransactionService_GetTransaction_FullMethodName:   auth.PermAuditRead,
+	hellfirev1.TransactionService_ListTransactions_FullMethodName: auth.PermAuditRead,
+	hellfirev1.SnapshotService_ListSnapshots_FullMethodName:       auth.PermSnapshotRead,
+	hellfirev1.EventService_WatchEvents_FullMethodName:            auth.PermConfigRead,
+}
+
+type userKey struct{}
+
+// userFromContext returns the user authenticated by the interceptors
+func userFromContext(ctx context.Context) *db.User {
+	user, _ := ctx.Value(userKey{}).(*db.User)
+	return user
+}
+
+// authenticate resolves the caller from the request metadata and checks it may call method.
+// Clients send either an API key ("x-api-key") or a session token ("authorization: Bearer <token>").
+func authenticate(ctx context.Context, method string) (context.Context, error) {
+	perm, ok := methodPermissions[method]
+	if !ok {
+		return nil, status.Error(codes.PermissionDenied, "insufficient permissions")
+	}
+
+	md, _ := metadata.FromIncomingContext(ctx)
+
+	var user, impersonator *db.User
+	if keys := md.Get("x-api-key"); len(keys) > 0 && keys[0] != "" {
+		u, err := auth.ValidateAPIKey(keys[0])
+		if err != nil {
+			return nil, status.Error(codes.Unauthenticated, err.Error())
+		}
+		user = u
+	} else if values := md.Get("authorization"); len(values) > 0 {
+		token, found := strings.CutPrefix(values[0], "Bearer ")
+		if !found || token == "" {
+			return nil, status.Error(codes.Unauthenticated, "authentication required")
+		}
+		session, err := auth.ValidateSession(token)
+		if err != nil {
+			return nil, status.Error(codes.Unauthenticated, "invalid or expired session")
+		}
+		if err := auth.RenewSession(session); err != nil {
+			logger.Warn("Failed to renew session", "user_id", session.UserID, "error", err)
+		}
+		user = &session.User
+		impersonator = session.Impersonator
+	} else {
+		return nil, status.Error(codes.Unauthenticated, "authentication required")
+	}
+
+	if err := auth.RequirePermission(user, perm); err != nil {
+		return nil, status.Error(codes.PermissionDenied, "insufficient permissions")
+	}
+
+	// Attribute audit entries and transactions to the caller
+	ctx = context.WithValue(ctx, userKey{}, user)
+	ctx = audit.WithUser(ctx, user.ID, user.Username)
+	if impersonator != nil {
+		ctx = audit.WithImpersonator(ctx, impersonator.ID, impersonator.Username)
+	}
+	if p, ok := peer.FromContext(ctx); ok {
+		ctx = audit.WithIP(ctx, p.Addr.String())
+	}
+
+	return ctx, nil
+}
+
+// unaryAuthInterceptor authenticates unary calls
+func unaryAuthInterceptor(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+	ctx, err := authenticate(ctx, info.FullMethod)
+	if err != nil {
+		return nil, err
+	}
+	return handler(ctx, req)
+}
+
+// authServerStream carries the authenticated context through a stream
+type authServerStream struct {
+	grpc.ServerStream
+	ctx context.Context
+}
+
+func (s *authServerStream) Context() context.Context {
+	return s.ctx
+}
+
+// streamAuthInterceptor authenticates streaming calls
+func streamAuthInterceptor(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+	ctx, err := authenticate(ss.Context(), info.FullMethod)
+	if err != nil {
+		return err
+	}
+	return handler(srv, &authServerStream{ServerStream: ss, ctx: ctx})
+}
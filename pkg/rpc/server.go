@@ -0,0 +1,160 @@
+// Package rpc implements the Hellfire gRPC API on top of the same config,
+// snapshot and transaction managers as the REST API.
+package rpc
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"net"
+	"os"
+	"sync"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/credentials/insecure"
+
+	"github.com/thesabbir/hellfire/pkg/config"
+	"github.com/thesabbir/hellfire/pkg/logger"
+	"github.com/thesabbir/hellfire/pkg/rpc/hellfirev1"
+	"github.com/thesabbir/hellfire/pkg/snapshot"
+	"github.com/thesabbir/hellfire/pkg/transaction"
+)
+
+// TLSConfig holds the certificate files for the gRPC listener
+type TLSConfig struct {
+	CertFile     string // Server certificate
+	KeyFile      string // Server private key
+	ClientCAFile string // CA for client certificates; enables mTLS when set
+}
+
+// Server is the Hellfire gRPC server
+type Server struct {
+	configManager      *config.Manager
+	snapshotManager    *snapshot.Manager
+	transactionManager *transaction.Manager
+	grpcServer         *grpc.Server
+}
+
+// NewServer creates a gRPC server. A nil tlsCfg serves plaintext.
+func NewServer(configManager *config.Manager, snapshotManager *snapshot.Manager, txManager *transaction.Manager, tlsCfg *TLSConfig) (*Server, error) {
+	creds := insecure.NewCredentials()
+	if tlsCfg != nil {
+		c, err := loadTLSCredentials(tlsCfg)
+		if err != nil {
+			return nil, err
+		}
+		creds = c
+	}
+
+	s := &Server{
+		configManager:      configManager,
+		snapshotManager:    snapshotManager,
+		transactionManager: txManager,
+		grpcServer: grpc.NewServer(
+			grpc.Creds(creds),
+			grpc.UnaryInterceptor(unaryAuthInterceptor),
+			grpc.StreamInterceptor(streamAuthInterceptor),
+		),
+	}
+
+	hellfirev1.RegisterConfigServiceServer(s.grpcServer, &configService{server: s})
+	hellfirev1.RegisterTransactionServiceServer(s.grpcServer, &transactionService{server: s})
+	hellfirev1.RegisterSnapshotServiceServer(s.grpcServer, &snapshotService{server: s})
+	hellfirev1.RegisterEventServiceServer(s.grpcServer, &eventService{})
+
+	return s, nil
+}
+
+// ListenAndServe listens on addr and serves until Stop is called
+func (s *Server) ListenAndServe(addr string) error {
+	lis, err := net.Listen("tcp", addr)
+	if err != nil {
+		return fmt.Errorf("failed to listen on %s: %w", addr, err)
+	}
+	return s.Serve(lis)
+}
+
+// Serve serves on a listener opened by the caller until Stop is called
+func (s *Server) Serve(lis net.Listener) error {
+	logger.Info("Starting gRPC server", "addr", lis.Addr().String())
+	return s.grpcServer.Serve(lis)
+}
+
+// Stop gracefully stops the server
+func (s *Server) Stop() {
+	s.grpcServer.GracefulStop()
+}
+
+// loadTLSCredentials builds server credentials, requiring client certificates when a client CA is set
+func loadTLSCredentials(cfg *TLSConfig) (credentials.TransportCredentials, error) {
+	reloader := &certReloader{certFile: cfg.CertFile, keyFile: cfg.KeyFile}
+	if err := reloader.load(); err != nil {
+		return nil, fmt.Errorf("failed to load gRPC certificate: %w", err)
+	}
+
+	tlsConfig := &tls.Config{
+		GetCertificate: reloader.getCertificate,
+		MinVersion:     tls.VersionTLS12,
+	}
+
+	if cfg.ClientCAFile != "" {
+		caPEM, err := os.ReadFile(cfg.ClientCAFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read gRPC client CA: %w", err)
+		}
+
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(caPEM) {
+			return nil, fmt.Errorf("no certificates found in %s", cfg.ClientCAFile)
+		}
+
+		tlsConfig.ClientCAs = pool
+		tlsConfig.ClientAuth = tls.RequireAndVerifyClientCert
+	}
+
+	return credentials.NewTLS(tlsConfig), nil
+}
+
+// certReloader serves the server certificate, reloading it when the
+// certificate file changes so rotated certificates apply without a restart
+type certReloader struct {
+	certFile, keyFile string
+
+	mu      sync.Mutex
+	cert    *tls.Certificate
+	modTime time.Time
+}
+
+func (r *certReloader) load() error {
+	info, err := os.Stat(r.certFile)
+	if err != nil {
+		return err
+	}
+	cert, err := tls.LoadX509KeyPair(r.certFile, r.keyFile)
+	if err != nil {
+		return err
+	}
+
+	r.cert = &cert
+	r.modTime = info.ModTime()
+	return nil
+}
+
+func (r *certReloader) getCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if info, err := os.Stat(r.certFile); err == nil && !info.ModTime().Equal(r.modTime) {
+		// Keep serving the previous certificate if the new one doesn't load,
+		// until the file changes again
+		if err := r.load(); err != nil {
+			r.modTime = info.ModTime()
+			logger.Warn("Failed to reload gRPC certificate", "file", r.certFile, "error", err)
+		} else {
+			logger.Info("Reloaded gRPC certificate", "file", r.certFile)
+		}
+	}
+	return r.cert, nil
+}
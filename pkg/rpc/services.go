@@ -0,0 +1,361 @@
+package rpc
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/timestamppb"
+	"gorm.io/gorm"
+
+	"github.com/thesabbir/hellfire/pkg/audit"
+	"github.com/thesabbir/hellfire/pkg/bus"
+	"github.com/thesabbir/hellfire/pkg/config"
+	"github.com/thesabbir/hellfire/pkg/db"
+	"github.com/thesabbir/hellfire/pkg/logger"
+	"github.com/thesabbir/hellfire/pkg/rpc/hellfirev1"
+	"github.com/thesabbir/hellfire/pkg/secrets"
+	"github.com/thesabbir/hellfire/pkg/transaction"
+	"github.com/thesabbir/hellfire/pkg/uci"
+)
+
+// operationFailed logs the detailed error and returns a generic one, like the REST API
+func operationFailed(method string, err error) error {
+	logger.Error("gRPC request error", "method", method, "error", err)
+	return status.Error(codes.Internal, "operation failed")
+}
+
+// configService implements hellfirev1.ConfigServiceServer
+type configService struct {
+	hellfirev1.UnimplementedConfigServiceServer
+	server *Server
+}
+
+func (s *configService) GetConfig(ctx context.Context, req *hellfirev1.GetConfigRequest) (*hellfirev1.Config, error) {
+	if !config.ValidName(req.GetName()) {
+		return nil, status.Error(codes.InvalidArgument, "invalid config name")
+	}
+
+	cfg, err := s.server.configManager.Load(req.GetName())
+	if err != nil {
+		return nil, operationFailed("GetConfig", err)
+	}
+	if user := userFromContext(ctx); user == nil || user.Role != db.RoleAdmin {
+		cfg = config.MaskSecrets(req.GetName(), cfg)
+	}
+
+	return configToProto(req.GetName(), cfg), nil
+}
+
+func (s *configService) SetOption(ctx context.Context, req *hellfirev1.SetOptionRequest) (*hellfirev1.SetOptionResponse, error) {
+	userID, username := audit.UserFromContext(ctx)
+
+	if !config.ValidName(strings.SplitN(req.GetPath(), ".", 2)[0]) {
+		return nil, status.Error(codes.InvalidArgument, "invalid config name")
+	}
+
+	if err := s.server.configManager.Set(req.GetPath(), req.GetValue()); err != nil {
+		audit.LogWithContext(ctx, audit.ActionConfigWrite, audit.StatusFailure, userID, username, req.GetPath(),
+			fmt.Sprintf("Failed to set %s", req.GetPath()), nil, err)
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+
+	audit.LogWithContext(ctx, audit.ActionConfigWrite, audit.StatusSuccess, userID, username, req.GetPath(),
+		fmt.Sprintf("Set %s = %s (staged)", req.GetPath(), secrets.RedactValue(req.GetPath(), req.GetValue())), nil, nil)
+
+	bus.Publish(bus.Event{
+		Type: bus.EventConfigChanged,
+		Data: map[string]string{"path": req.GetPath(), "value": req.GetValue()},
+	})
+
+	return &hellfirev1.SetOptionResponse{}, nil
+}
+
+func (s *configService) Batch(ctx context.Context, req *hellfirev1.BatchRequest) (*hellfirev1.BatchResponse, error) {
+	userID, username := audit.UserFromContext(ctx)
+
+	if len(req.GetOperations()) == 0 || len(req.GetOperations()) > config.MaxBatchOperations {
+		return nil, status.Errorf(codes.InvalidArgument, "batch must contain between 1 and %d operations", config.MaxBatchOperations)
+	}
+
+	ops := make([]config.Operation, 0, len(req.GetOperations()))
+	for i, op := range req.GetOperations() {
+		if !config.ValidName(strings.SplitN(op.GetPath(), ".", 2)[0]) {
+			return nil, status.Errorf(codes.InvalidArgument, "operation %d: invalid config name", i)
+		}
+		ops = append(ops, config.Operation{Op: op.GetOp(), Path: op.GetPath(), Value: op.GetValue()})
+	}
+
+	staged, err := s.server.configManager.Batch(ops)
+	if err != nil {
+		audit.LogWithContext(ctx, audit.ActionConfigWrite, audit.StatusFailure, userID, username, "config",
+			"Failed to stage batch of configuration changes", nil, err)
+
+		var opErr *config.OperationError
+		if errors.As(err, &opErr) {
+			return nil, status.Error(codes.InvalidArgument, err.Error())
+		}
+		return nil, operationFailed("Batch", err)
+	}
+
+	audit.LogWithContext(ctx, audit.ActionConfigWrite, audit.StatusSuccess, userID, username, "config",
+		fmt.Sprintf("Staged %d operations across %v", len(ops), staged), nil, nil)
+
+	return &hellfirev1.BatchResponse{Configs: staged}, nil
+}
+
+func (s *configService) GetChanges(ctx context.Context, req *hellfirev1.GetChangesRequest) (*hellfirev1.GetChangesResponse, error) {
+	return &hellfirev1.GetChangesResponse{Configs: s.server.configManager.GetChanges()}, nil
+}
+
+func (s *configService) Revert(ctx context.Context, req *hellfirev1.RevertRequest) (*hellfirev1.RevertResponse, error) {
+	userID, username := audit.UserFromContext(ctx)
+
+	if !s.server.configManager.HasChanges() {
+		return &hellfirev1.RevertResponse{}, nil
+	}
+
+	changes := s.server.configManager.GetChanges()
+	if err := s.server.configManager.Revert(); err != nil {
+		audit.LogWithContext(ctx, audit.ActionConfigRevert, audit.StatusFailure, userID, username, "config",
+			"Failed to revert configuration changes", nil, err)
+		return nil, operationFailed("Revert", err)
+	}
+
+	audit.LogWithContext(ctx, audit.ActionConfigRevert, audit.StatusSuccess, userID, username, "config",
+		fmt.Sprintf("Reverted configuration changes: %v", changes), nil, nil)
+
+	bus.Publish(bus.Event{
+		Type: bus.EventConfigReverted,
+		Data: changes,
+	})
+
+	return &hellfirev1.RevertResponse{}, nil
+}
+
+// transactionService implements hellfirev1.TransactionServiceServer
+type transactionService struct {
+	hellfirev1.UnimplementedTransactionServiceServer
+	server *Server
+}
+
+func (s *transactionService) Commit(ctx context.Context, req *hellfirev1.CommitRequest) (*hellfirev1.CommitResponse, error) {
+	userID, username := audit.UserFromContext(ctx)
+
+	if !s.server.configManager.HasChanges() {
+		return nil, status.Error(codes.FailedPrecondition, "no changes to commit")
+	}
+
+	message := req.GetMessage()
+	if message == "" {
+		message = "Configuration change via gRPC"
+	}
+
+	changes := s.server.configManager.GetChanges()
+	confirmTimeout := time.Duration(req.GetConfirmTimeoutSeconds()) * time.Second
+
+	// The commit must finish even if the client goes away mid-apply
+	if err := s.server.transactionManager.Commit(context.WithoutCancel(ctx), message, confirmTimeout, 0); err != nil {
+		audit.LogWithContext(ctx, audit.ActionConfigCommit, audit.StatusFailure, userID, username, "config",
+			"Failed to commit configuration changes", nil, err)
+		return nil, operationFailed("Commit", err)
+	}
+
+	audit.LogWithContext(ctx, audit.ActionConfigCommit, audit.StatusSuccess, userID, username, "config",
+		fmt.Sprintf("Committed configuration changes: %v", changes), nil, nil)
+
+	if err := bus.PublishSync(bus.Event{
+		Type: bus.EventConfigCommitted,
+		Data: changes,
+	}); err != nil {
+		logger.Warn("Commit handlers failed", "configs", changes, "error", err)
+	}
+
+	return &hellfirev1.CommitResponse{
+		State:   string(s.server.transactionManager.GetState()),
+		Configs: changes,
+	}, nil
+}
+
+func (s *transactionService) Confirm(ctx context.Context, req *hellfirev1.ConfirmRequest) (*hellfirev1.ConfirmResponse, error) {
+	if err := s.server.transactionManager.Confirm(ctx); err != nil {
+		return nil, status.Error(codes.FailedPrecondition, err.Error())
+	}
+
+	return &hellfirev1.ConfirmResponse{State: string(s.server.transactionManager.GetState())}, nil
+}
+
+// rollbackReasonMetadata carries the reason of a Rollback call
+const rollbackReasonMetadata = "x-rollback-reason"
+
+func (s *transactionService) Rollback(ctx context.Context, req *hellfirev1.RollbackRequest) (*hellfirev1.RollbackResponse, error) {
+	// The request has no reason field; it comes as metadata instead
+	rollbackCtx := context.WithoutCancel(ctx)
+	md, _ := metadata.FromIncomingContext(ctx)
+	if reasons := md.Get(rollbackReasonMetadata); len(reasons) > 0 {
+		rollbackCtx = transaction.WithRollbackReason(rollbackCtx, reasons[0])
+	}
+	if err := s.server.transactionManager.Rollback(rollbackCtx, req.GetSnapshotId()); err != nil {
+		if errors.Is(err, transaction.ErrRollbackReasonRequired) {
+			return nil, status.Error(codes.InvalidArgument, err.Error())
+		}
+		return nil, operationFailed("Rollback", err)
+	}
+
+	return &hellfirev1.RollbackResponse{State: string(s.server.transactionManager.GetState())}, nil
+}
+
+func (s *transactionService) GetTransaction(ctx context.Context, req *hellfirev1.GetTransactionRequest) (*hellfirev1.Transaction, error) {
+	tx, err := db.GetTransactionByID(req.GetId())
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, status.Error(codes.NotFound, "transaction not found")
+		}
+		return nil, operationFailed("GetTransaction", err)
+	}
+
+	return transactionToProto(tx), nil
+}
+
+func (s *transactionService) ListTransactions(ctx context.Context, req *hellfirev1.ListTransactionsRequest) (*hellfirev1.ListTransactionsResponse, error) {
+	filters := make(map[string]interface{})
+	if req.GetStatus() != "" {
+		filters["status"] = req.GetStatus()
+	}
+
+	limit := int(req.GetLimit())
+	if limit <= 0 || limit > 100 {
+		limit = 50
+	}
+
+	txs, total, err := db.ListTransactions(filters, limit, int(req.GetOffset()))
+	if err != nil {
+		return nil, operationFailed("ListTransactions", err)
+	}
+
+	resp := &hellfirev1.ListTransactionsResponse{Total: total}
+	for i := range txs {
+		resp.Transactions = append(resp.Transactions, transactionToProto(&txs[i]))
+	}
+
+	return resp, nil
+}
+
+// snapshotService implements hellfirev1.SnapshotServiceServer
+type snapshotService struct {
+	hellfirev1.UnimplementedSnapshotServiceServer
+	server *Server
+}
+
+func (s *snapshotService) ListSnapshots(ctx context.Context, req *hellfirev1.ListSnapshotsRequest) (*hellfirev1.ListSnapshotsResponse, error) {
+	snapshots, err := s.server.snapshotManager.List()
+	if err != nil {
+		return nil, operationFailed("ListSnapshots", err)
+	}
+
+	resp := &hellfirev1.ListSnapshotsResponse{}
+	for _, snap := range snapshots {
+		resp.Snapshots = append(resp.Snapshots, &hellfirev1.Snapshot{
+			Id:        snap.Metadata.ID,
+			Message:   snap.Metadata.Message,
+			Configs:   snap.Metadata.Configs,
+			Version:   snap.Metadata.Version,
+			Timestamp: timestamppb.New(snap.Metadata.Timestamp),
+		})
+	}
+
+	return resp, nil
+}
+
+// eventService implements hellfirev1.EventServiceServer
+type eventService struct {
+	hellfirev1.UnimplementedEventServiceServer
+}
+
+func (s *eventService) WatchEvents(req *hellfirev1.WatchEventsRequest, stream hellfirev1.EventService_WatchEventsServer) error {
+	wanted := make(map[string]bool, len(req.GetTypes()))
+	for _, t := range req.GetTypes() {
+		wanted[t] = true
+	}
+
+	events, stop := bus.Watch(100)
+	defer stop()
+
+	for {
+		select {
+		case <-stream.Context().Done():
+			return nil
+		case event, ok := <-events:
+			if !ok {
+				return nil
+			}
+			if len(wanted) > 0 && !wanted[string(event.Type)] {
+				continue
+			}
+
+			data, err := json.Marshal(event.Data)
+			if err != nil {
+				data = []byte("null")
+			}
+
+			if err := stream.Send(&hellfirev1.Event{
+				Type:       string(event.Type),
+				ConfigName: event.ConfigName,
+				DataJson:   string(data),
+				Time:       timestamppb.Now(),
+			}); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// configToProto converts a UCI config to its protobuf form
+func configToProto(name string, cfg *uci.Config) *hellfirev1.Config {
+	result := &hellfirev1.Config{Name: name}
+
+	for _, section := range cfg.Sections {
+		sec := &hellfirev1.Section{
+			Type:    section.Type,
+			Name:    section.Name,
+			Options: section.Options,
+			Lists:   make(map[string]*hellfirev1.ListValue, len(section.Lists)),
+		}
+		for key, values := range section.Lists {
+			sec.Lists[key] = &hellfirev1.ListValue{Values: values}
+		}
+		result.Sections = append(result.Sections, sec)
+	}
+
+	return result
+}
+
+// transactionToProto converts a transaction record to its protobuf form
+func transactionToProto(tx *db.Transaction) *hellfirev1.Transaction {
+	result := &hellfirev1.Transaction{
+		Id:         tx.TxID,
+		Username:   tx.Username,
+		Message:    tx.Message,
+		Status:     tx.Status,
+		SnapshotId: tx.SnapshotID,
+		Error:      tx.Error,
+		CreatedAt:  timestamppb.New(tx.CreatedAt),
+	}
+
+	_ = json.Unmarshal([]byte(tx.Configs), &result.Configs)
+
+	if tx.CompletedAt != nil {
+		result.CompletedAt = timestamppb.New(*tx.CompletedAt)
+	}
+	if tx.RolledBackAt != nil {
+		result.RolledBackAt = timestamppb.New(*tx.RolledBackAt)
+	}
+
+	return result
+}
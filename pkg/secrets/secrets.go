@@ -0,0 +1,179 @@
+// Package secrets encrypts config option values at rest. Values are sealed
+// with AES-256-GCM under a key kept in a file readable only by root, and
+// stored as "enc:v2:" followed by the base64 nonce and ciphertext, so a
+// sealed value is still a single UCI option. The option's path
+// (config.section.option) is authenticated with the value, so a sealed
+// value copied to another option doesn't open. Values sealed as "enc:v1:"
+// by earlier versions aren't bound to a path and still open anywhere.
+// TPM-backed keys aren't supported yet: protect the key file like the database.
+package secrets
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+const (
+	// DefaultKeyFile is where the key is created on first use
+	DefaultKeyFile = "/var/lib/hellfire/secret.key"
+
+	// Prefix marks a sealed value
+	Prefix = "enc:v2:"
+
+	// legacyPrefix marks a value sealed without its path
+	legacyPrefix = "enc:v1:"
+
+	// Mask replaces secret values shown to users who may not see them
+	Mask = "********"
+
+	keySize = 32 // AES-256
+)
+
+// ErrNoKey is returned when decrypting without a key
+var ErrNoKey = errors.New("no secret key configured")
+
+// IsSealed reports whether value was sealed by a Store
+func IsSealed(value string) bool {
+	return strings.HasPrefix(value, Prefix) || strings.HasPrefix(value, legacyPrefix)
+}
+
+// Store seals and opens values with the key in a file. The key is read, or
+// created if the file doesn't exist, on first use.
+type Store struct {
+	path string
+
+	once sync.Once
+	aead cipher.AEAD
+	err  error
+}
+
+// NewStore returns a store keyed by the file at path
+func NewStore(path string) *Store {
+	if path == "" {
+		path = DefaultKeyFile
+	}
+	return &Store{path: path}
+}
+
+// Path returns the key file
+func (s *Store) Path() string {
+	return s.path
+}
+
+// Seal encrypts the value of the option at path (config.section.option).
+// Sealed values are returned unchanged.
+func (s *Store) Seal(path, value string) (string, error) {
+	if IsSealed(value) {
+		return value, nil
+	}
+	aead, err := s.cipher()
+	if err != nil {
+		return "", err
+	}
+
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return "", fmt.Errorf("failed to generate nonce: %w", err)
+	}
+	sealed := aead.Seal(nonce, nonce, []byte(value), []byte(path))
+	return Prefix + base64.StdEncoding.EncodeToString(sealed), nil
+}
+
+// Open decrypts the sealed value of the option at path. Values that aren't
+// sealed are returned unchanged (e.g. staged but not yet committed).
+func (s *Store) Open(path, value string) (string, error) {
+	if !IsSealed(value) {
+		return value, nil
+	}
+	if s == nil {
+		return "", ErrNoKey
+	}
+	aead, err := s.cipher()
+	if err != nil {
+		return "", err
+	}
+
+	encoded, found := strings.CutPrefix(value, Prefix)
+	additional := []byte(path)
+	if !found {
+		encoded, additional = strings.TrimPrefix(value, legacyPrefix), nil
+	}
+	data, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil || len(data) < aead.NonceSize() {
+		return "", fmt.Errorf("malformed sealed value")
+	}
+	plaintext, err := aead.Open(nil, data[:aead.NonceSize()], data[aead.NonceSize():], additional)
+	if err != nil {
+		return "", fmt.Errorf("failed to decrypt value (wrong key %s, or sealed for another option?)", s.path)
+	}
+	return string(plaintext), nil
+}
+
+// cipher loads the key once
+func (s *Store) cipher() (cipher.AEAD, error) {
+	s.once.Do(func() {
+		var key []byte
+		key, s.err = loadOrCreateKey(s.path)
+		if s.err != nil {
+			return
+		}
+		block, err := aes.NewCipher(key)
+		if err != nil {
+			s.err = err
+			return
+		}
+		s.aead, s.err = cipher.NewGCM(block)
+	})
+	return s.aead, s.err
+}
+
+// loadOrCreateKey reads the key file, creating it with a random key if it
+// doesn't exist
+func loadOrCreateKey(path string) ([]byte, error) {
+	key, err := os.ReadFile(path)
+	if err == nil {
+		if len(key) != keySize {
+			return nil, fmt.Errorf("secret key %s must be %d bytes", path, keySize)
+		}
+		return key, nil
+	}
+	if !os.IsNotExist(err) {
+		return nil, fmt.Errorf("failed to read secret key: %w", err)
+	}
+
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return nil, fmt.Errorf("failed to create secret key directory: %w", err)
+	}
+	key = make([]byte, keySize)
+	if _, err := io.ReadFull(rand.Reader, key); err != nil {
+		return nil, fmt.Errorf("failed to generate secret key: %w", err)
+	}
+
+	// O_EXCL: if another process created the key first, use that one
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+	if os.IsExist(err) {
+		return loadOrCreateKey(path)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to create secret key: %w", err)
+	}
+	if _, err := f.Write(key); err != nil {
+		f.Close()
+		os.Remove(path)
+		return nil, fmt.Errorf("failed to write secret key: %w", err)
+	}
+	if err := f.Close(); err != nil {
+		os.Remove(path)
+		return nil, fmt.Errorf("failed to write secret key: %w", err)
+	}
+	return key, nil
+}
@@ -0,0 +1,99 @@
+package secrets
+
+import (
+	"encoding/base64"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+const testPath = "network.wan.password"
+
+func TestSealAndOpen(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "keys", "secret.key")
+	store := NewStore(path)
+
+	sealed, err := store.Seal(testPath, "hunter2")
+	if err != nil {
+		t.Fatalf("Seal: %v", err)
+	}
+	if !IsSealed(sealed) || sealed == Prefix+"hunter2" {
+		t.Fatalf("sealed = %q", sealed)
+	}
+	if again, _ := store.Seal(testPath, sealed); again != sealed {
+		t.Errorf("sealing a sealed value changed it")
+	}
+
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatalf("key file: %v", err)
+	}
+	if info.Mode().Perm() != 0600 || info.Size() != keySize {
+		t.Errorf("key file mode %v, size %d", info.Mode().Perm(), info.Size())
+	}
+
+	// A new store reads the same key
+	if plaintext, err := NewStore(path).Open(testPath, sealed); err != nil || plaintext != "hunter2" {
+		t.Errorf("Open = %q, %v", plaintext, err)
+	}
+	if plaintext, err := store.Open(testPath, "plain"); err != nil || plaintext != "plain" {
+		t.Errorf("Open of a plain value = %q, %v", plaintext, err)
+	}
+
+	if _, err := NewStore(filepath.Join(t.TempDir(), "other.key")).Open(testPath, sealed); err == nil {
+		t.Error("expected opening with another key to fail")
+	}
+	if _, err := store.Open("network.lan.password", sealed); err == nil {
+		t.Error("expected opening for another option to fail")
+	}
+	var none *Store
+	if _, err := none.Open(testPath, sealed); err != ErrNoKey {
+		t.Errorf("Open without a store = %v, want ErrNoKey", err)
+	}
+}
+
+func TestOpenLegacy(t *testing.T) {
+	store := NewStore(filepath.Join(t.TempDir(), "secret.key"))
+	aead, err := store.cipher()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Values sealed before they were bound to their option still open
+	nonce := make([]byte, aead.NonceSize())
+	legacy := legacyPrefix + base64.StdEncoding.EncodeToString(aead.Seal(nonce, nonce, []byte("hunter2"), nil))
+	if !IsSealed(legacy) {
+		t.Fatalf("legacy value %q not sealed", legacy)
+	}
+	if plaintext, err := store.Open(testPath, legacy); err != nil || plaintext != "hunter2" {
+		t.Errorf("Open = %q, %v", plaintext, err)
+	}
+}
+
+func TestIsSensitive(t *testing.T) {
+	for option, want := range map[string]bool{
+		"password":    true,
+		"key":         true,
+		"private_key": true,
+		"auth_secret": true,
+		"public_key":  false,
+		"keepalive":   false,
+		"ipaddr":      false,
+	} {
+		if got := IsSensitive(option); got != want {
+			t.Errorf("IsSensitive(%q) = %v, want %v", option, got, want)
+		}
+	}
+
+	RegisterSensitive("pincode")
+	if !IsSensitive("pincode") {
+		t.Error("registered name not sensitive")
+	}
+
+	if got := RedactValue("network.wan.password", "hunter2"); got != Mask {
+		t.Errorf("RedactValue = %q", got)
+	}
+	if got := RedactValue("network.wan.ipaddr", "10.0.0.1"); got != "10.0.0.1" {
+		t.Errorf("RedactValue = %q", got)
+	}
+}
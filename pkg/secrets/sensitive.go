@@ -0,0 +1,57 @@
+package secrets
+
+import (
+	"strings"
+	"sync"
+)
+
+var (
+	sensitiveMu sync.RWMutex
+
+	// sensitiveNames are the option names whose values are redacted in
+	// output, whether or not they are encrypted at rest
+	sensitiveNames = map[string]bool{
+		"key":      true,
+		"password": true,
+		"psk":      true,
+		"secret":   true,
+	}
+)
+
+// RegisterSensitive adds option names to redact
+func RegisterSensitive(names ...string) {
+	sensitiveMu.Lock()
+	defer sensitiveMu.Unlock()
+
+	for _, name := range names {
+		sensitiveNames[strings.ToLower(name)] = true
+	}
+}
+
+// IsSensitive reports whether an option holds a sensitive value: its name
+// is registered, or ends in "_" and a registered name (private_key,
+// auth_secret). Public keys aren't sensitive.
+func IsSensitive(option string) bool {
+	option = strings.ToLower(option)
+	if strings.HasPrefix(option, "public_") {
+		return false
+	}
+	if i := strings.LastIndex(option, "_"); i >= 0 {
+		option = option[i+1:]
+	}
+
+	sensitiveMu.RLock()
+	defer sensitiveMu.RUnlock()
+	return sensitiveNames[option]
+}
+
+// RedactValue returns Mask in place of the value of a sensitive or sealed
+// option at a dot-notation path (e.g. "network.wan.password"), for logs
+// and audit messages
+func RedactValue(path, value string) string {
+	option := path[strings.LastIndex(path, ".")+1:]
+	if IsSensitive(option) || IsSealed(value) {
+		return Mask
+	}
+	return value
+}
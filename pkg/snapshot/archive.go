@@ -0,0 +1,387 @@
+package snapshot
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"maps"
+	"os"
+	"path"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/thesabbir/hellfire/pkg/config"
+	"github.com/thesabbir/hellfire/pkg/logger"
+	"github.com/thesabbir/hellfire/pkg/netdetect"
+	"github.com/thesabbir/hellfire/pkg/secrets"
+	"github.com/thesabbir/hellfire/pkg/uci"
+	"github.com/thesabbir/hellfire/pkg/util"
+	"github.com/thesabbir/hellfire/pkg/version"
+)
+
+// ArchiveFormat is the layout of exported snapshots: a gzipped tarball of
+// manifest.json and the config files under configs/. Archives of a newer
+// format are refused.
+const ArchiveFormat = 1
+
+const (
+	manifestFile     = "manifest.json"
+	archiveConfigDir = "configs/"
+	maxArchiveFile   = 4 << 20
+)
+
+// ErrIncompatible is returned for archives this version can't import as they
+// are: of a newer format or version, or with options its schema rejects
+var ErrIncompatible = errors.New("incompatible snapshot archive")
+
+// Manifest describes an exported snapshot
+type Manifest struct {
+	Format   int       `json:"format"`
+	Version  string    `json:"version"` // Hellfire version that exported it
+	Hostname string    `json:"hostname"`
+	Exported time.Time `json:"exported"`
+	Snapshot Metadata  `json:"snapshot"` // Checksums are those of the exported files
+	Devices  []Device  `json:"devices,omitempty"`
+}
+
+// Device is a network device of the router a snapshot was exported from,
+// for matching its interfaces to the devices of another
+type Device struct {
+	Name   string `json:"name"`
+	Kind   string `json:"kind"` // As netdetect reports it
+	Driver string `json:"driver,omitempty"`
+}
+
+// Origin records where an imported snapshot comes from
+type Origin struct {
+	Hostname string   `json:"hostname"`
+	Version  string   `json:"version"`  // Hellfire version that exported it
+	Snapshot string   `json:"snapshot"` // ID of the snapshot there
+	Devices  []Device `json:"devices,omitempty"`
+}
+
+// Archive is an exported snapshot read back
+type Archive struct {
+	Manifest Manifest
+	Configs  map[string]*uci.Config
+}
+
+// Export writes a snapshot as an archive another router can import. Secret
+// values stay sealed with this router's key, which the other can't open,
+// unless store is given to decrypt them with.
+func (m *Manager) Export(id string, w io.Writer, store *secrets.Store) error {
+	snapshot, err := m.Load(id)
+	if err != nil {
+		return err
+	}
+	if err := m.ValidateSnapshot(snapshot); err != nil {
+		return fmt.Errorf("snapshot validation failed: %w", err)
+	}
+
+	files := make(map[string][]byte)
+	manifest := Manifest{
+		Format:   ArchiveFormat,
+		Version:  version.GetVersion(),
+		Exported: time.Now(),
+		Snapshot: snapshot.Metadata,
+	}
+	manifest.Hostname, _ = os.Hostname()
+	if interfaces, err := netdetect.Detect(); err != nil {
+		logger.Warn("Failed to list the network devices to export", "error", err)
+	} else {
+		for _, iface := range interfaces {
+			if iface.Kind != netdetect.KindLoopback {
+				manifest.Devices = append(manifest.Devices, Device{Name: iface.Name, Kind: iface.Kind, Driver: iface.Driver})
+			}
+		}
+	}
+	manifest.Snapshot.Checksums = make(map[string]string)
+	manifest.Snapshot.Protected = false
+	for _, name := range snapshot.Metadata.Configs {
+		data, err := os.ReadFile(filepath.Join(snapshot.Path, name))
+		if err != nil {
+			return err
+		}
+		if store != nil {
+			if data, err = openSecrets(name, data, store); err != nil {
+				return err
+			}
+		}
+		files[name] = data
+		manifest.Snapshot.Checksums[name] = util.Checksum(data)
+	}
+
+	gz := gzip.NewWriter(w)
+	tw := tar.NewWriter(gz)
+	manifestData, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := writeArchiveFile(tw, manifestFile, manifestData, manifest.Exported); err != nil {
+		return err
+	}
+	for _, name := range snapshot.Metadata.Configs {
+		if err := writeArchiveFile(tw, archiveConfigDir+name, files[name], manifest.Exported); err != nil {
+			return err
+		}
+	}
+	if err := tw.Close(); err != nil {
+		return err
+	}
+	return gz.Close()
+}
+
+// openSecrets decrypts the sealed values of a config file
+func openSecrets(name string, data []byte, store *secrets.Store) ([]byte, error) {
+	cfg, err := uci.Parse(bytes.NewReader(data))
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse %s: %w", name, err)
+	}
+	sealed := false
+	for _, section := range cfg.Sections {
+		for option, value := range section.Options {
+			if !secrets.IsSealed(value) {
+				continue
+			}
+			plaintext, err := store.Open(config.SecretPath(name, section, option), value)
+			if err != nil {
+				return nil, fmt.Errorf("failed to decrypt %s.%s.%s: %w", name, section.Name, option, err)
+			}
+			section.Options[option] = plaintext
+			sealed = true
+		}
+	}
+	if !sealed {
+		return data, nil
+	}
+	var buf bytes.Buffer
+	if err := uci.Write(&buf, cfg); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// writeArchiveFile adds a file readable by its owner only to an archive
+func writeArchiveFile(tw *tar.Writer, name string, data []byte, modTime time.Time) error {
+	header := &tar.Header{
+		Name:    name,
+		Mode:    0600,
+		Size:    int64(len(data)),
+		ModTime: modTime,
+	}
+	if err := tw.WriteHeader(header); err != nil {
+		return err
+	}
+	_, err := tw.Write(data)
+	return err
+}
+
+// ReadArchive reads an exported snapshot, checking that its files are
+// complete and intact. Whether this version can import it is up to Check.
+func ReadArchive(r io.Reader) (*Archive, error) {
+	gz, err := gzip.NewReader(r)
+	if err != nil {
+		return nil, fmt.Errorf("not a snapshot archive: %w", err)
+	}
+	defer gz.Close()
+
+	var manifest *Manifest
+	files := make(map[string][]byte)
+	tr := tar.NewReader(gz)
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to read archive: %w", err)
+		}
+		if header.Typeflag != tar.TypeReg {
+			continue
+		}
+		if header.Size > maxArchiveFile {
+			return nil, fmt.Errorf("%s is too large", header.Name)
+		}
+		data, err := io.ReadAll(io.LimitReader(tr, maxArchiveFile))
+		if err != nil {
+			return nil, fmt.Errorf("failed to read %s: %w", header.Name, err)
+		}
+
+		switch name := path.Clean(header.Name); {
+		case name == manifestFile:
+			manifest = &Manifest{}
+			if err := json.Unmarshal(data, manifest); err != nil {
+				return nil, fmt.Errorf("invalid manifest: %w", err)
+			}
+		case strings.HasPrefix(name, archiveConfigDir):
+			files[strings.TrimPrefix(name, archiveConfigDir)] = data
+		}
+	}
+
+	if manifest == nil {
+		return nil, fmt.Errorf("not a snapshot archive: no %s", manifestFile)
+	}
+	if manifest.Format < 1 {
+		return nil, fmt.Errorf("invalid archive format %d", manifest.Format)
+	}
+	if manifest.Format > ArchiveFormat {
+		return nil, fmt.Errorf("%w: format %d is newer than this version of hellfire reads (%d), update it first",
+			ErrIncompatible, manifest.Format, ArchiveFormat)
+	}
+
+	archive := &Archive{Manifest: *manifest, Configs: make(map[string]*uci.Config)}
+	for _, name := range manifest.Snapshot.Configs {
+		if !config.ValidName(name) {
+			return nil, fmt.Errorf("invalid config name %q", name)
+		}
+		data, ok := files[name]
+		if !ok {
+			return nil, fmt.Errorf("archive corrupted: %s missing", name)
+		}
+		if expected := manifest.Snapshot.Checksums[name]; util.Checksum(data) != expected {
+			return nil, fmt.Errorf("checksum mismatch for %s", name)
+		}
+		cfg, err := uci.Parse(bytes.NewReader(data))
+		if err != nil {
+			return nil, fmt.Errorf("archive corrupted: invalid UCI in %s: %w", name, err)
+		}
+		archive.Configs[name] = cfg
+	}
+	return archive, nil
+}
+
+// Check checks that the archive can be imported by this version of
+// hellfire: that it wasn't exported by a newer one and that its options pass
+// the schema. The error wraps ErrIncompatible. Secret values sealed with a
+// key other than store's can't be applied here and are warned about.
+func (a *Archive) Check(store *secrets.Store) (warnings []string, err error) {
+	var problems []string
+
+	exported, current := a.Manifest.Version, version.GetVersion()
+	if cmp, ok := compareVersions(exported, current); ok && cmp > 0 {
+		problems = append(problems, fmt.Sprintf("exported by hellfire %s, newer than this one (%s)", exported, current))
+	} else if !ok && exported != current {
+		warnings = append(warnings, fmt.Sprintf("exported by hellfire %s, this is %s", exported, current))
+	}
+
+	for _, name := range slices.Sorted(maps.Keys(a.Configs)) {
+		for _, err := range config.CheckSchema(name, a.Configs[name]) {
+			problems = append(problems, fmt.Sprintf("%s.%v", name, err))
+		}
+
+		for _, section := range a.Configs[name].Sections {
+			for _, option := range slices.Sorted(maps.Keys(section.Options)) {
+				value := section.Options[option]
+				if !secrets.IsSealed(value) {
+					continue
+				}
+				if store != nil {
+					if _, err := store.Open(config.SecretPath(name, section, option), value); err == nil {
+						continue
+					}
+				}
+				warnings = append(warnings, fmt.Sprintf("%s.%s.%s is encrypted with the key of the router it was exported from: set it again after importing",
+					name, section.Name, option))
+			}
+		}
+	}
+
+	if len(problems) > 0 {
+		return warnings, fmt.Errorf("%w:\n  %s", ErrIncompatible, strings.Join(problems, "\n  "))
+	}
+	return warnings, nil
+}
+
+// compareVersions compares two release versions (v1.2.3, optionally with
+// a -suffix); ok is false unless both are releases
+func compareVersions(a, b string) (cmp int, ok bool) {
+	parse := func(v string) ([3]int, bool) {
+		var parts [3]int
+		v, _, _ = strings.Cut(strings.TrimPrefix(v, "v"), "-")
+		fields := strings.Split(v, ".")
+		if len(fields) != 3 {
+			return parts, false
+		}
+		for i, field := range fields {
+			n, err := strconv.Atoi(field)
+			if err != nil || n < 0 {
+				return parts, false
+			}
+			parts[i] = n
+		}
+		return parts, true
+	}
+
+	va, okA := parse(a)
+	vb, okB := parse(b)
+	if !okA || !okB {
+		return 0, false
+	}
+	return slices.Compare(va[:], vb[:]), true
+}
+
+// Import stores the configs of an archive as a new snapshot, to be restored
+// like any other. Secret values given in plain text are sealed with store.
+func (m *Manager) Import(archive *Archive, message string, store *secrets.Store) (*Snapshot, error) {
+	if err := os.MkdirAll(m.snapshotDir, 0700); err != nil {
+		return nil, fmt.Errorf("failed to create snapshot directory: %w", err)
+	}
+	// Hidden, and without metadata, so never listed as a snapshot
+	dir, err := os.MkdirTemp(m.snapshotDir, ".import-")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(dir)
+
+	names := slices.Sorted(maps.Keys(archive.Configs))
+	for _, name := range names {
+		cfg := archive.Configs[name].Clone()
+		if store != nil {
+			if err := sealSecrets(name, cfg, store); err != nil {
+				return nil, err
+			}
+		}
+		var buf bytes.Buffer
+		if err := uci.Write(&buf, cfg); err != nil {
+			return nil, err
+		}
+		if err := os.WriteFile(filepath.Join(dir, name), buf.Bytes(), 0600); err != nil {
+			return nil, err
+		}
+	}
+
+	if message == "" {
+		message = fmt.Sprintf("Imported snapshot %s of %s: %s", archive.Manifest.Snapshot.ID,
+			archive.Manifest.Hostname, archive.Manifest.Snapshot.Message)
+	}
+	return m.create(message, names, dir, &Origin{
+		Hostname: archive.Manifest.Hostname,
+		Version:  archive.Manifest.Version,
+		Snapshot: archive.Manifest.Snapshot.ID,
+		Devices:  archive.Manifest.Devices,
+	})
+}
+
+// sealSecrets encrypts the secret options of a config given in plain text
+func sealSecrets(name string, cfg *uci.Config, store *secrets.Store) error {
+	for _, section := range cfg.Sections {
+		for option, value := range section.Options {
+			if !config.IsSecret(name, section.Type, option) || secrets.IsSealed(value) {
+				continue
+			}
+			sealed, err := store.Seal(config.SecretPath(name, section, option), value)
+			if err != nil {
+				return fmt.Errorf("failed to encrypt %s.%s.%s: %w", name, section.Name, option, err)
+			}
+			section.Options[option] = sealed
+		}
+	}
+	return nil
+}
@@ -0,0 +1,95 @@
+package snapshot
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/thesabbir/hellfire/pkg/secrets"
+	"github.com/thesabbir/hellfire/pkg/version"
+)
+
+func TestExportImport(t *testing.T) {
+	dir := t.TempDir()
+	configDir := filepath.Join(dir, "config")
+	if err := os.MkdirAll(configDir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	source := secrets.NewStore(filepath.Join(dir, "source.key"))
+	sealed, err := source.Seal("network.wan.password", "hunter2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	network := "config interface 'wan'\n\toption proto 'dhcp'\n\toption password '" + sealed + "'\n"
+	if err := os.WriteFile(filepath.Join(configDir, "network"), []byte(network), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	m := NewManager(filepath.Join(dir, "source"), configDir)
+	m.SetLimits(0, 0)
+	snap, err := m.Create("before the swap", []string{"network"})
+	if err != nil {
+		t.Fatalf("Create: %v", err)
+	}
+
+	// Sealed with the source's key, the secret is of no use elsewhere
+	var archive bytes.Buffer
+	if err := m.Export(snap.ID, &archive, nil); err != nil {
+		t.Fatalf("Export: %v", err)
+	}
+	read, err := ReadArchive(bytes.NewReader(archive.Bytes()))
+	if err != nil {
+		t.Fatalf("ReadArchive: %v", err)
+	}
+	target := secrets.NewStore(filepath.Join(dir, "target.key"))
+	if warnings, err := read.Check(target); err != nil || len(warnings) != 1 || !strings.Contains(warnings[0], "network.wan.password") {
+		t.Errorf("Check = %v, %v; want a warning about the sealed password", warnings, err)
+	}
+
+	// Revealed, it is sealed again with the target's key on import
+	archive.Reset()
+	if err := m.Export(snap.ID, &archive, source); err != nil {
+		t.Fatalf("Export: %v", err)
+	}
+	if read, err = ReadArchive(bytes.NewReader(archive.Bytes())); err != nil {
+		t.Fatalf("ReadArchive: %v", err)
+	}
+	if warnings, err := read.Check(target); err != nil || len(warnings) != 0 {
+		t.Errorf("Check = %v, %v", warnings, err)
+	}
+
+	other := NewManager(filepath.Join(dir, "target"), filepath.Join(dir, "target-config"))
+	other.SetLimits(0, 0)
+	imported, err := other.Import(read, "", target)
+	if err != nil {
+		t.Fatalf("Import: %v", err)
+	}
+	if !strings.Contains(imported.Metadata.Message, "before the swap") {
+		t.Errorf("message = %q", imported.Metadata.Message)
+	}
+	if origin := imported.Metadata.Origin; origin == nil || origin.Snapshot != snap.ID {
+		t.Errorf("origin = %+v, want the exported snapshot", origin)
+	}
+	configs, err := other.ReadConfigs(imported.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	password, _ := configs["network"].GetSection("interface", "wan").GetOption("password")
+	if plaintext, err := target.Open("network.wan.password", password); err != nil || plaintext != "hunter2" {
+		t.Errorf("imported password = %q, opens to %q (%v)", password, plaintext, err)
+	}
+	if snapshots, _ := other.List(); len(snapshots) != 1 {
+		t.Errorf("%d snapshots after import, want 1", len(snapshots))
+	}
+
+	// Archives of a newer version are refused
+	defer func(v string) { version.Version = v }(version.Version)
+	version.Version = "v1.0.0"
+	read.Manifest.Version = "v1.2.0"
+	if _, err := read.Check(target); !errors.Is(err, ErrIncompatible) {
+		t.Errorf("Check of a newer version = %v, want ErrIncompatible", err)
+	}
+}
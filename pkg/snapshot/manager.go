@@ -1,12 +1,12 @@
 package snapshot
 
 import (
-	"crypto/sha256"
 	"encoding/json"
 	"fmt"
 	"os"
 	"path/filepath"
 	"sort"
+	"sync"
 	"time"
 
 	"github.com/thesabbir/hellfire/pkg/logger"
@@ -24,10 +24,12 @@ const (
 type Metadata struct {
 	Timestamp time.Time         `json:"timestamp"`
 	Message   string            `json:"message"`
-	Configs   []string          `json:"configs"`   // List of config files included
-	ID        string            `json:"id"`        // Snapshot ID (timestamp-based)
-	Version   string            `json:"version"`   // Hellfire version that created this snapshot
-	Checksums map[string]string `json:"checksums"` // Config file name -> SHA256 checksum
+	Configs   []string          `json:"configs"`             // List of config files included
+	ID        string            `json:"id"`                  // Snapshot ID (timestamp-based)
+	Version   string            `json:"version"`             // Hellfire version that created this snapshot
+	Checksums map[string]string `json:"checksums"`           // Config file name -> SHA256 checksum
+	Protected bool              `json:"protected,omitempty"` // Never pruned automatically
+	Origin    *Origin           `json:"origin,omitempty"`    // Router an imported snapshot was exported from
 }
 
 // Snapshot represents a configuration snapshot
@@ -41,6 +43,10 @@ type Snapshot struct {
 type Manager struct {
 	snapshotDir string
 	configDir   string
+
+	mu      sync.Mutex // Guards the limits, which are updated on config reload
+	minFree uint64     // Bytes that must be free on the file system to snapshot
+	quota   uint64     // Bytes the snapshot directory may use; 0 is unlimited
 }
 
 // NewManager creates a new snapshot manager
@@ -51,19 +57,26 @@ func NewManager(snapshotDir, configDir string) *Manager {
 	return &Manager{
 		snapshotDir: snapshotDir,
 		configDir:   configDir,
+		minFree:     DefaultMinFree,
 	}
 }
 
 // Create creates a new snapshot of the current configuration
 func (m *Manager) Create(message string, configs []string) (*Snapshot, error) {
+	return m.create(message, configs, m.configDir, nil)
+}
+
+// create snapshots the configs found in srcDir
+func (m *Manager) create(message string, configs []string, srcDir string, origin *Origin) (*Snapshot, error) {
 	// Ensure snapshot directory exists before checking disk space
 	if err := os.MkdirAll(m.snapshotDir, 0700); err != nil {
 		return nil, fmt.Errorf("failed to create snapshot directory: %w", err)
 	}
 
-	// Check disk space (require at least 1GB available)
-	if err := util.CheckDiskSpace(m.snapshotDir, 1); err != nil {
-		return nil, fmt.Errorf("insufficient disk space: %w", err)
+	// Check disk space
+	minFree, _ := m.limits()
+	if err := util.CheckFreeSpace(m.snapshotDir, minFree); err != nil {
+		return nil, err
 	}
 
 	// Generate unique snapshot ID (includes milliseconds + random suffix)
@@ -80,13 +93,15 @@ func (m *Manager) Create(message string, configs []string) (*Snapshot, error) {
 	defer func() {
 		if !success {
 			os.RemoveAll(snapshotPath)
+			m.removeUnusedObjects()
 		}
 	}()
 
-	// Copy config files atomically
+	// Store config files, sharing unchanged ones with earlier snapshots
 	copiedConfigs := []string{}
+	checksums := make(map[string]string)
 	for _, configName := range configs {
-		srcPath := filepath.Join(m.configDir, configName)
+		srcPath := filepath.Join(srcDir, configName)
 		dstPath := filepath.Join(snapshotPath, configName)
 
 		// Check if source file exists
@@ -98,24 +113,14 @@ func (m *Manager) Create(message string, configs []string) (*Snapshot, error) {
 			return nil, fmt.Errorf("failed to stat config %s: %w", configName, err)
 		}
 
-		// Copy file atomically with permission preservation
-		if err := util.CopyFileAtomic(srcPath, dstPath); err != nil {
+		// Link (or copy) the file with its permissions
+		checksum, err := m.storeFile(srcPath, dstPath)
+		if err != nil {
 			return nil, fmt.Errorf("failed to copy config %s: %w", configName, err)
 		}
 
 		copiedConfigs = append(copiedConfigs, configName)
-	}
-
-	// Calculate checksums for all copied files
-	checksums := make(map[string]string)
-	for _, configName := range copiedConfigs {
-		filePath := filepath.Join(snapshotPath, configName)
-		data, err := os.ReadFile(filePath)
-		if err != nil {
-			return nil, fmt.Errorf("failed to read config for checksum: %w", err)
-		}
-		hash := sha256.Sum256(data)
-		checksums[configName] = fmt.Sprintf("%x", hash)
+		checksums[configName] = checksum
 	}
 
 	// Create metadata
@@ -126,20 +131,53 @@ func (m *Manager) Create(message string, configs []string) (*Snapshot, error) {
 		ID:        id,
 		Version:   version.GetVersion(),
 		Checksums: checksums,
+		Origin:    origin,
+	}
+
+	if err := writeMetadata(snapshotPath, metadata); err != nil {
+		return nil, err
+	}
+	success = true
+
+	// Auto-prune old snapshots if we have too many
+	snapshots, err := m.List()
+	if err != nil {
+		logger.Warn("Failed to list snapshots for auto-prune", "error", err)
+	} else if len(snapshots) > 100 {
+		deleted, err := m.Prune(100) // Keep last 100 snapshots
+		if err != nil {
+			logger.Warn("Failed to prune old snapshots", "error", err)
+		} else {
+			logger.Info("Auto-pruned old snapshots", "count", len(deleted))
+		}
 	}
+	m.enforceQuota(id)
 
-	// Write metadata atomically
+	logger.Info("Snapshot created",
+		"id", id,
+		"configs", len(copiedConfigs),
+		"version", metadata.Version)
+
+	return &Snapshot{
+		ID:       id,
+		Metadata: metadata,
+		Path:     snapshotPath,
+	}, nil
+}
+
+// writeMetadata writes the metadata of a snapshot atomically
+func writeMetadata(snapshotPath string, metadata Metadata) error {
 	metadataPath := filepath.Join(snapshotPath, MetadataFile)
 	tmpFile, err := os.CreateTemp(snapshotPath, ".metadata-*.json")
 	if err != nil {
-		return nil, fmt.Errorf("failed to create temp metadata file: %w", err)
+		return fmt.Errorf("failed to create temp metadata file: %w", err)
 	}
 	tmpPath := tmpFile.Name()
 
 	// Cleanup temp file on error
-	metaSuccess := false
+	success := false
 	defer func() {
-		if !metaSuccess {
+		if !success {
 			os.Remove(tmpPath)
 		}
 	}()
@@ -148,49 +186,25 @@ func (m *Manager) Create(message string, configs []string) (*Snapshot, error) {
 	encoder.SetIndent("", "  ")
 	if err := encoder.Encode(metadata); err != nil {
 		tmpFile.Close()
-		return nil, fmt.Errorf("failed to write metadata: %w", err)
+		return fmt.Errorf("failed to write metadata: %w", err)
 	}
 
 	if err := tmpFile.Sync(); err != nil {
 		tmpFile.Close()
-		return nil, fmt.Errorf("failed to sync metadata: %w", err)
+		return fmt.Errorf("failed to sync metadata: %w", err)
 	}
 
 	if err := tmpFile.Close(); err != nil {
-		return nil, fmt.Errorf("failed to close metadata file: %w", err)
+		return fmt.Errorf("failed to close metadata file: %w", err)
 	}
 
 	// Atomic rename
 	if err := os.Rename(tmpPath, metadataPath); err != nil {
-		return nil, fmt.Errorf("failed to rename metadata file: %w", err)
+		return fmt.Errorf("failed to rename metadata file: %w", err)
 	}
 
-	metaSuccess = true
 	success = true
-
-	// Auto-prune old snapshots if we have too many
-	snapshots, err := m.List()
-	if err != nil {
-		logger.Warn("Failed to list snapshots for auto-prune", "error", err)
-	} else if len(snapshots) > 100 {
-		deleted, err := m.Prune(100) // Keep last 100 snapshots
-		if err != nil {
-			logger.Warn("Failed to prune old snapshots", "error", err)
-		} else {
-			logger.Info("Auto-pruned old snapshots", "count", len(deleted))
-		}
-	}
-
-	logger.Info("Snapshot created",
-		"id", id,
-		"configs", len(copiedConfigs),
-		"version", metadata.Version)
-
-	return &Snapshot{
-		ID:       id,
-		Metadata: metadata,
-		Path:     snapshotPath,
-	}, nil
+	return nil
 }
 
 // List returns all snapshots, sorted by timestamp (newest first)
@@ -237,6 +251,10 @@ func (m *Manager) List() ([]*Snapshot, error) {
 
 // Load loads a snapshot by ID
 func (m *Manager) Load(id string) (*Snapshot, error) {
+	if err := validateID(id); err != nil {
+		return nil, err
+	}
+
 	snapshotPath := filepath.Join(m.snapshotDir, id)
 
 	// Check if snapshot exists
@@ -290,6 +308,36 @@ func (m *Manager) Restore(id string) error {
 	return nil
 }
 
+// ReadConfigs validates a snapshot and returns its parsed configs keyed by name
+func (m *Manager) ReadConfigs(id string) (map[string]*uci.Config, error) {
+	snapshot, err := m.Load(id)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := m.ValidateSnapshot(snapshot); err != nil {
+		return nil, fmt.Errorf("snapshot validation failed: %w", err)
+	}
+
+	configs := make(map[string]*uci.Config, len(snapshot.Metadata.Configs))
+	for _, configName := range snapshot.Metadata.Configs {
+		f, err := os.Open(filepath.Join(snapshot.Path, configName))
+		if err != nil {
+			return nil, fmt.Errorf("failed to open %s: %w", configName, err)
+		}
+
+		cfg, err := uci.Parse(f)
+		f.Close()
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse %s: %w", configName, err)
+		}
+
+		configs[configName] = cfg
+	}
+
+	return configs, nil
+}
+
 // ValidateSnapshot validates that a snapshot contains valid UCI config files
 func (m *Manager) ValidateSnapshot(snapshot *Snapshot) error {
 	for _, configName := range snapshot.Metadata.Configs {
@@ -311,8 +359,7 @@ func (m *Manager) ValidateSnapshot(snapshot *Snapshot) error {
 				if err != nil {
 					return fmt.Errorf("failed to read %s for checksum: %w", configName, err)
 				}
-				hash := sha256.Sum256(data)
-				actualChecksum := fmt.Sprintf("%x", hash)
+				actualChecksum := util.Checksum(data)
 				if actualChecksum != expectedChecksum {
 					return fmt.Errorf("checksum mismatch for %s: expected %s, got %s",
 						configName, expectedChecksum, actualChecksum)
@@ -339,16 +386,22 @@ func (m *Manager) ValidateSnapshot(snapshot *Snapshot) error {
 
 // Delete deletes a snapshot
 func (m *Manager) Delete(id string) error {
+	if err := validateID(id); err != nil {
+		return err
+	}
+
 	snapshotPath := filepath.Join(m.snapshotDir, id)
 
 	if err := os.RemoveAll(snapshotPath); err != nil {
 		return fmt.Errorf("failed to delete snapshot: %w", err)
 	}
 
+	m.removeUnusedObjects()
 	return nil
 }
 
-// Prune removes old snapshots, keeping only the specified number
+// Prune removes old snapshots, keeping only the specified number and the
+// protected ones
 func (m *Manager) Prune(keep int) ([]string, error) {
 	snapshots, err := m.List()
 	if err != nil {
@@ -362,6 +415,9 @@ func (m *Manager) Prune(keep int) ([]string, error) {
 	// Delete old snapshots
 	deleted := []string{}
 	for i := keep; i < len(snapshots); i++ {
+		if snapshots[i].Metadata.Protected {
+			continue
+		}
 		if err := m.Delete(snapshots[i].ID); err != nil {
 			return deleted, fmt.Errorf("failed to delete snapshot %s: %w", snapshots[i].ID, err)
 		}
@@ -371,6 +427,14 @@ func (m *Manager) Prune(keep int) ([]string, error) {
 	return deleted, nil
 }
 
+// validateID rejects snapshot IDs that could escape the snapshot directory
+func validateID(id string) error {
+	if id == "" || id == "." || id == ".." || filepath.Base(id) != id {
+		return fmt.Errorf("invalid snapshot ID: %q", id)
+	}
+	return nil
+}
+
 // GetLatest returns the most recent snapshot
 func (m *Manager) GetLatest() (*Snapshot, error) {
 	snapshots, err := m.List()
@@ -0,0 +1,173 @@
+package snapshot
+
+import (
+	"io/fs"
+	"os"
+	"path/filepath"
+	"syscall"
+
+	"github.com/thesabbir/hellfire/pkg/logger"
+	"github.com/thesabbir/hellfire/pkg/util"
+)
+
+// DefaultMinFree is the space that must be free on the file system to take
+// a snapshot, small enough for routers on flash
+const DefaultMinFree = 16 << 20
+
+// Storage is the disk usage of the snapshots and the limits on it
+type Storage struct {
+	Dir       string `json:"dir"`
+	Used      uint64 `json:"used"`      // Bytes of the files of the snapshots, shared ones counted once
+	Quota     uint64 `json:"quota"`     // Bytes the snapshots may use; 0 is unlimited
+	MinFree   uint64 `json:"min_free"`  // Bytes that must be free to take a snapshot
+	Total     uint64 `json:"total"`     // Size of the file system holding them
+	Available uint64 `json:"available"` // Free on that file system
+	Snapshots int    `json:"snapshots"`
+	Protected int    `json:"protected"`
+}
+
+// SetLimits sets the space that must be free on the file system to take a
+// snapshot, and the space the snapshot directory may use (0 is unlimited).
+// Over the quota, the oldest unprotected snapshots are pruned.
+func (m *Manager) SetLimits(minFree, quota uint64) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.minFree = minFree
+	m.quota = quota
+}
+
+// limits returns the free space required to snapshot and the quota
+func (m *Manager) limits() (minFree, quota uint64) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.minFree, m.quota
+}
+
+// Protect protects a snapshot from automatic pruning, or lifts it
+func (m *Manager) Protect(id string, protected bool) (*Snapshot, error) {
+	snapshot, err := m.Load(id)
+	if err != nil {
+		return nil, err
+	}
+	if snapshot.Metadata.Protected == protected {
+		return snapshot, nil
+	}
+	snapshot.Metadata.Protected = protected
+	if err := writeMetadata(snapshot.Path, snapshot.Metadata); err != nil {
+		return nil, err
+	}
+	return snapshot, nil
+}
+
+// Storage reports the disk usage of the snapshots
+func (m *Manager) Storage() (*Storage, error) {
+	snapshots, err := m.List()
+	if err != nil {
+		return nil, err
+	}
+	used, err := m.usage()
+	if err != nil {
+		return nil, err
+	}
+	total, available, err := util.FileSystemUsage(m.snapshotDir)
+	if err != nil {
+		return nil, err
+	}
+
+	minFree, quota := m.limits()
+	storage := &Storage{
+		Dir:       m.snapshotDir,
+		Used:      used,
+		Quota:     quota,
+		MinFree:   minFree,
+		Total:     total,
+		Available: available,
+		Snapshots: len(snapshots),
+	}
+	for _, snapshot := range snapshots {
+		if snapshot.Metadata.Protected {
+			storage.Protected++
+		}
+	}
+	return storage, nil
+}
+
+// usage adds up the size of the files in the snapshot directory, counting
+// those hardlinked to the object store once (directories aren't counted, as
+// their size depends on the file system)
+func (m *Manager) usage() (uint64, error) {
+	seen := make(map[uint64]bool)
+	var used uint64
+	err := filepath.WalkDir(m.snapshotDir, func(path string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			if os.IsNotExist(err) {
+				return nil // Removed meanwhile
+			}
+			return err
+		}
+		if entry.IsDir() {
+			return nil
+		}
+		info, err := entry.Info()
+		if err != nil {
+			return nil
+		}
+		if stat, ok := info.Sys().(*syscall.Stat_t); ok {
+			if seen[stat.Ino] {
+				return nil
+			}
+			seen[stat.Ino] = true
+		}
+		used += uint64(info.Size())
+		return nil
+	})
+	return used, err
+}
+
+// enforceQuota deletes the oldest unprotected snapshots while the snapshot
+// directory is over its quota, never the one just taken (keep)
+func (m *Manager) enforceQuota(keep string) {
+	_, quota := m.limits()
+	if quota == 0 {
+		return
+	}
+	used, err := m.usage()
+	if err != nil {
+		logger.Warn("Failed to read snapshot disk usage", "error", err)
+		return
+	}
+	if used <= quota {
+		return
+	}
+
+	snapshots, err := m.List()
+	if err != nil {
+		logger.Warn("Failed to list snapshots for the quota", "error", err)
+		return
+	}
+
+	var deleted []string
+	for i := len(snapshots) - 1; i >= 0 && used > quota; i-- {
+		snapshot := snapshots[i]
+		if snapshot.ID == keep || snapshot.Metadata.Protected {
+			continue
+		}
+		if err := m.Delete(snapshot.ID); err != nil {
+			logger.Warn("Failed to delete snapshot over the quota", "id", snapshot.ID, "error", err)
+			break
+		}
+		deleted = append(deleted, snapshot.ID)
+		if used, err = m.usage(); err != nil {
+			logger.Warn("Failed to read snapshot disk usage", "error", err)
+			break
+		}
+	}
+
+	if len(deleted) > 0 {
+		logger.Info("Pruned the oldest snapshots over the quota", "count", len(deleted), "quota", quota)
+	}
+	if used > quota {
+		logger.Warn("Snapshots are over their quota, the rest are protected or the latest",
+			"used", used, "quota", quota)
+	}
+}
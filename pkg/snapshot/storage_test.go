@@ -0,0 +1,58 @@
+package snapshot
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestQuotaPrunesOldestUnprotected(t *testing.T) {
+	dir := t.TempDir()
+	configDir := filepath.Join(dir, "config")
+	if err := os.MkdirAll(configDir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	m := NewManager(filepath.Join(dir, "snapshots"), configDir)
+	m.SetLimits(0, 14<<10)
+
+	// Every snapshot stores a distinct 4 KB config
+	var ids []string
+	for i := range 5 {
+		content := "config defaults\n\toption n '" + strings.Repeat(string(rune('a'+i)), 4<<10) + "'\n"
+		if err := os.WriteFile(filepath.Join(configDir, "firewall"), []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+		snap, err := m.Create("change", []string{"firewall"})
+		if err != nil {
+			t.Fatalf("Create: %v", err)
+		}
+		ids = append(ids, snap.ID)
+		if i == 0 {
+			if _, err := m.Protect(snap.ID, true); err != nil {
+				t.Fatalf("Protect: %v", err)
+			}
+		}
+	}
+
+	snapshots, err := m.List()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var kept []string
+	for _, snap := range snapshots {
+		kept = append(kept, snap.ID)
+	}
+	// The protected first one and the newest fit the quota, with the one before it
+	if want := []string{ids[4], ids[3], ids[0]}; strings.Join(kept, ",") != strings.Join(want, ",") {
+		t.Errorf("Expected snapshots %v kept, got %v", want, kept)
+	}
+
+	storage, err := m.Storage()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if storage.Used > 14<<10 || storage.Snapshots != 3 || storage.Protected != 1 {
+		t.Errorf("Expected 3 snapshots within the quota, 1 protected, got %+v", storage)
+	}
+}
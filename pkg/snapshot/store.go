@@ -0,0 +1,90 @@
+package snapshot
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"syscall"
+
+	"github.com/thesabbir/hellfire/pkg/logger"
+	"github.com/thesabbir/hellfire/pkg/util"
+)
+
+// ObjectsDir holds one copy of every distinct config file across snapshots,
+// named by checksum and permissions. Snapshot directories hardlink to these,
+// so unchanged files take no extra space.
+const ObjectsDir = ".objects"
+
+// storeFile adds a config file to the object store and links it into dst,
+// returning its checksum. If the file can't be hardlinked (e.g. the
+// filesystem doesn't support it), dst is written as a separate copy.
+func (m *Manager) storeFile(src, dst string) (string, error) {
+	info, err := os.Stat(src)
+	if err != nil {
+		return "", fmt.Errorf("failed to stat source: %w", err)
+	}
+
+	data, err := os.ReadFile(src)
+	if err != nil {
+		return "", fmt.Errorf("failed to read source: %w", err)
+	}
+	checksum := util.Checksum(data)
+	perm := info.Mode().Perm()
+
+	objectsDir := filepath.Join(m.snapshotDir, ObjectsDir)
+	objectPath := filepath.Join(objectsDir, fmt.Sprintf("%s-%04o", checksum, perm))
+
+	if err := m.ensureObject(objectsDir, objectPath, checksum, data, perm); err != nil {
+		logger.Warn("Failed to store snapshot object, copying instead", "path", objectPath, "error", err)
+	} else if err := os.Link(objectPath, dst); err == nil {
+		return checksum, nil
+	} else {
+		logger.Debug("Failed to link snapshot object, copying instead", "path", objectPath, "error", err)
+	}
+
+	if err := util.WriteFileAtomic(dst, data, perm); err != nil {
+		return "", err
+	}
+	return checksum, nil
+}
+
+// ensureObject writes the object unless an intact copy already exists. A
+// damaged object is replaced; snapshots linked to it keep the damaged copy,
+// which their checksums will report.
+func (m *Manager) ensureObject(objectsDir, objectPath, checksum string, data []byte, perm os.FileMode) error {
+	if existing, err := os.ReadFile(objectPath); err == nil && util.Checksum(existing) == checksum {
+		return nil
+	}
+
+	if err := os.MkdirAll(objectsDir, 0700); err != nil {
+		return fmt.Errorf("failed to create objects directory: %w", err)
+	}
+	return util.WriteFileAtomic(objectPath, data, perm)
+}
+
+// removeUnusedObjects deletes objects no snapshot links to anymore
+func (m *Manager) removeUnusedObjects() {
+	objectsDir := filepath.Join(m.snapshotDir, ObjectsDir)
+
+	entries, err := os.ReadDir(objectsDir)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			logger.Warn("Failed to read snapshot objects", "error", err)
+		}
+		return
+	}
+
+	for _, entry := range entries {
+		info, err := entry.Info()
+		if err != nil {
+			continue
+		}
+
+		// The store's own name is the only remaining link
+		if stat, ok := info.Sys().(*syscall.Stat_t); ok && stat.Nlink == 1 {
+			if err := os.Remove(filepath.Join(objectsDir, entry.Name())); err != nil {
+				logger.Warn("Failed to remove unused snapshot object", "name", entry.Name(), "error", err)
+			}
+		}
+	}
+}
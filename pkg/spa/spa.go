@@ -0,0 +1,236 @@
+// Package spa implements single-packet authorization for the management
+// ports: they stay closed in the firewall until a client sends one UDP packet
+// carrying a fresh timestamp, a nonce and the client's address authenticated
+// with a shared HMAC key, which opens them to that address for a limited time.
+// As with fwknop, a packet captured on the way is no use from anywhere else.
+package spa
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/thesabbir/hellfire/pkg/logger"
+)
+
+const (
+	// DefaultPort is the UDP port knocks are sent to
+	DefaultPort = 62201
+
+	// MinKeyLength is the minimum length of the shared key in bytes
+	MinKeyLength = 16
+
+	version    = 2
+	nonceSize  = 16
+	signedSize = 1 + 8 + nonceSize + net.IPv6len
+	packetSize = signedSize + sha256.Size
+)
+
+// AnySource signs a knock for whatever address it arrives from, for clients
+// behind a NAT that rewrites their address; the router must allow it
+var AnySource = net.IPv6unspecified
+
+var (
+	ErrMalformed = errors.New("malformed packet")
+	ErrSignature = errors.New("invalid signature")
+	ErrStale     = errors.New("timestamp outside the allowed window")
+	ErrReplayed  = errors.New("packet replayed")
+	ErrSource    = errors.New("packet signed for another source address")
+)
+
+// OpenFunc opens the management ports to addr for the given duration
+type OpenFunc func(ctx context.Context, addr net.IP, duration time.Duration) error
+
+// Config configures the knock listener
+type Config struct {
+	Listen  string        // UDP address, e.g. ":62201"
+	Key     []byte        // Shared HMAC key
+	OpenFor time.Duration // How long a valid knock opens the ports
+	MaxSkew time.Duration // Accepted clock difference between client and router
+	// AllowNAT accepts knocks signed for AnySource, opening the ports to the
+	// address they come from. Anyone who captures such a knock can use it
+	// first from their own address.
+	AllowNAT bool
+	Open     OpenFunc
+}
+
+// Server listens for knocks and opens the ports for clients that send a valid one
+type Server struct {
+	cfg Config
+
+	mu   sync.Mutex
+	seen map[[nonceSize]byte]time.Time // Nonces of accepted packets, until they go stale
+}
+
+// NewServer creates a knock listener
+func NewServer(cfg Config) (*Server, error) {
+	if len(cfg.Key) < MinKeyLength {
+		return nil, fmt.Errorf("spa key must be at least %d bytes", MinKeyLength)
+	}
+	if cfg.Open == nil {
+		return nil, fmt.Errorf("spa server needs an open function")
+	}
+
+	return &Server{
+		cfg:  cfg,
+		seen: make(map[[nonceSize]byte]time.Time),
+	}, nil
+}
+
+// ListenAndServe handles knocks until the listener fails
+func (s *Server) ListenAndServe() error {
+	conn, err := net.ListenPacket("udp", s.cfg.Listen)
+	if err != nil {
+		return fmt.Errorf("failed to listen for knocks: %w", err)
+	}
+	defer conn.Close()
+
+	logger.Info("Listening for single-packet authorization", "address", s.cfg.Listen)
+
+	buf := make([]byte, 512)
+	for {
+		n, from, err := conn.ReadFrom(buf)
+		if err != nil {
+			return err
+		}
+
+		addr, ok := from.(*net.UDPAddr)
+		if !ok {
+			continue
+		}
+
+		if err := s.Handle(buf[:n], addr.IP, time.Now()); err != nil {
+			logger.Warn("Rejected knock", "client", addr.IP.String(), "error", err)
+			continue
+		}
+		logger.Info("Opened management ports for knocking client",
+			"client", addr.IP.String(),
+			"duration", s.cfg.OpenFor)
+	}
+}
+
+// Handle verifies a knock from addr and opens the ports for it
+func (s *Server) Handle(packet []byte, addr net.IP, now time.Time) error {
+	nonce, source, err := Verify(s.cfg.Key, packet, now, s.cfg.MaxSkew)
+	if err != nil {
+		return err
+	}
+	if source.IsUnspecified() {
+		if !s.cfg.AllowNAT {
+			return fmt.Errorf("%w: signed for any address, and allow_nat is off", ErrSource)
+		}
+	} else if !source.Equal(addr) {
+		return fmt.Errorf("%w: signed for %s", ErrSource, source)
+	}
+
+	s.mu.Lock()
+	for seen, at := range s.seen {
+		if now.Sub(at) > 2*s.cfg.MaxSkew {
+			delete(s.seen, seen)
+		}
+	}
+	if _, replayed := s.seen[nonce]; replayed {
+		s.mu.Unlock()
+		return ErrReplayed
+	}
+	s.seen[nonce] = now
+	s.mu.Unlock()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := s.cfg.Open(ctx, addr, s.cfg.OpenFor); err != nil {
+		return fmt.Errorf("failed to open ports: %w", err)
+	}
+	return nil
+}
+
+// NewPacket builds a knock for the current time that opens the ports to
+// source, or to any address with AnySource
+func NewPacket(key []byte, source net.IP, now time.Time) ([]byte, error) {
+	source = source.To16()
+	if source == nil {
+		return nil, fmt.Errorf("invalid source address")
+	}
+
+	packet := make([]byte, signedSize, packetSize)
+	packet[0] = version
+	binary.BigEndian.PutUint64(packet[1:9], uint64(now.Unix()))
+	if _, err := rand.Read(packet[9 : 9+nonceSize]); err != nil {
+		return nil, err
+	}
+	copy(packet[9+nonceSize:], source)
+	return append(packet, sign(key, packet)...), nil
+}
+
+// Verify checks a knock's signature and timestamp and returns its nonce and
+// the source address it was signed for
+func Verify(key, packet []byte, now time.Time, maxSkew time.Duration) ([nonceSize]byte, net.IP, error) {
+	var nonce [nonceSize]byte
+	if len(packet) != packetSize || packet[0] != version {
+		return nonce, nil, ErrMalformed
+	}
+
+	if !hmac.Equal(packet[signedSize:], sign(key, packet[:signedSize])) {
+		return nonce, nil, ErrSignature
+	}
+
+	sent := time.Unix(int64(binary.BigEndian.Uint64(packet[1:9])), 0)
+	if skew := now.Sub(sent); skew > maxSkew || skew < -maxSkew {
+		return nonce, nil, ErrStale
+	}
+
+	copy(nonce[:], packet[9:9+nonceSize])
+	source := make(net.IP, net.IPv6len)
+	copy(source, packet[9+nonceSize:signedSize])
+	return nonce, source, nil
+}
+
+// Knock sends a knock to a router's knock port (host:port), signed for
+// source; nil signs it for the address this machine sends it from
+func Knock(address string, key []byte, source net.IP) error {
+	conn, err := net.Dial("udp", address)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	if source == nil {
+		source = conn.LocalAddr().(*net.UDPAddr).IP
+	}
+	packet, err := NewPacket(key, source, time.Now())
+	if err != nil {
+		return err
+	}
+
+	_, err = conn.Write(packet)
+	return err
+}
+
+// LoadKey reads a shared key from a file (surrounding whitespace is ignored)
+func LoadKey(path string) ([]byte, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	key := []byte(strings.TrimSpace(string(data)))
+	if len(key) < MinKeyLength {
+		return nil, fmt.Errorf("spa key in %s must be at least %d bytes", path, MinKeyLength)
+	}
+	return key, nil
+}
+
+func sign(key, data []byte) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write(data)
+	return mac.Sum(nil)
+}
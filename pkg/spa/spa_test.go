@@ -0,0 +1,136 @@
+package spa
+
+import (
+	"context"
+	"errors"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestServerHandle(t *testing.T) {
+	key := []byte("0123456789abcdef0123")
+	now := time.Unix(1_800_000_000, 0)
+
+	var opened []string
+	server, err := NewServer(Config{
+		Key:     key,
+		OpenFor: time.Minute,
+		MaxSkew: 30 * time.Second,
+		Open: func(ctx context.Context, addr net.IP, duration time.Duration) error {
+			opened = append(opened, addr.String())
+			return nil
+		},
+	})
+	if err != nil {
+		t.Fatalf("NewServer: %v", err)
+	}
+
+	client := net.ParseIP("198.51.100.4")
+	packet, err := NewPacket(key, client, now.Add(-10*time.Second))
+	if err != nil {
+		t.Fatalf("NewPacket: %v", err)
+	}
+
+	if err := server.Handle(packet, client, now); err != nil {
+		t.Fatalf("Handle valid knock: %v", err)
+	}
+	if err := server.Handle(packet, client, now); !errors.Is(err, ErrReplayed) {
+		t.Errorf("Handle replayed knock = %v, want ErrReplayed", err)
+	}
+
+	forged, _ := NewPacket([]byte("another key of 16+ bytes"), client, now)
+	if err := server.Handle(forged, client, now); !errors.Is(err, ErrSignature) {
+		t.Errorf("Handle forged knock = %v, want ErrSignature", err)
+	}
+
+	stale, _ := NewPacket(key, client, now.Add(-time.Minute))
+	if err := server.Handle(stale, client, now); !errors.Is(err, ErrStale) {
+		t.Errorf("Handle stale knock = %v, want ErrStale", err)
+	}
+
+	if err := server.Handle(packet[:20], client, now); !errors.Is(err, ErrMalformed) {
+		t.Errorf("Handle short packet = %v, want ErrMalformed", err)
+	}
+
+	// A captured knock is no use from another address
+	captured, _ := NewPacket(key, client, now)
+	if err := server.Handle(captured, net.ParseIP("203.0.113.9"), now); !errors.Is(err, ErrSource) {
+		t.Errorf("Handle knock from another address = %v, want ErrSource", err)
+	}
+
+	anywhere, _ := NewPacket(key, AnySource, now)
+	if err := server.Handle(anywhere, client, now); !errors.Is(err, ErrSource) {
+		t.Errorf("Handle knock for any address = %v, want ErrSource", err)
+	}
+
+	if len(opened) != 1 || opened[0] != "198.51.100.4" {
+		t.Errorf("opened = %v, want one opening for the client", opened)
+	}
+}
+
+func TestServerHandleNAT(t *testing.T) {
+	key := []byte("0123456789abcdef0123")
+	now := time.Unix(1_800_000_000, 0)
+
+	var opened []string
+	server, err := NewServer(Config{
+		Key:      key,
+		OpenFor:  time.Minute,
+		MaxSkew:  30 * time.Second,
+		AllowNAT: true,
+		Open: func(ctx context.Context, addr net.IP, duration time.Duration) error {
+			opened = append(opened, addr.String())
+			return nil
+		},
+	})
+	if err != nil {
+		t.Fatalf("NewServer: %v", err)
+	}
+
+	// IPv4 senders may show up as IPv4-mapped IPv6 addresses
+	public := net.ParseIP("203.0.113.9")
+	signed, _ := NewPacket(key, public, now)
+	if err := server.Handle(signed, public.To16(), now); err != nil {
+		t.Errorf("Handle knock signed for the NAT's address: %v", err)
+	}
+
+	anywhere, _ := NewPacket(key, AnySource, now)
+	if err := server.Handle(anywhere, net.ParseIP("198.51.100.4"), now); err != nil {
+		t.Errorf("Handle knock for any address: %v", err)
+	}
+
+	// A specific address must still match
+	other, _ := NewPacket(key, net.ParseIP("192.0.2.1"), now)
+	if err := server.Handle(other, public, now); !errors.Is(err, ErrSource) {
+		t.Errorf("Handle knock from another address = %v, want ErrSource", err)
+	}
+
+	if len(opened) != 2 || opened[0] != "203.0.113.9" || opened[1] != "198.51.100.4" {
+		t.Errorf("opened = %v, want the NAT's address and the knocking one", opened)
+	}
+}
+
+func TestKnock(t *testing.T) {
+	key := []byte("0123456789abcdef0123")
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	if err := Knock(conn.LocalAddr().String(), key, nil); err != nil {
+		t.Fatalf("Knock: %v", err)
+	}
+	buf := make([]byte, 512)
+	n, _, err := conn.ReadFrom(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Signed for the address the knock was sent from
+	_, source, err := Verify(key, buf[:n], time.Now(), time.Minute)
+	if err != nil || !source.Equal(net.IPv4(127, 0, 0, 1)) {
+		t.Errorf("Verify = %v, %v, want 127.0.0.1", source, err)
+	}
+}
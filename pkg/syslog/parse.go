@@ -0,0 +1,192 @@
+package syslog
+
+import (
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Severities, by level
+var severities = []string{"emerg", "alert", "crit", "err", "warning", "notice", "info", "debug"}
+
+// Facilities, by code
+var facilities = []string{
+	"kern", "user", "mail", "daemon", "auth", "syslog", "lpr", "news",
+	"uucp", "cron", "authpriv", "ftp", "ntp", "security", "console", "solaris-cron",
+	"local0", "local1", "local2", "local3", "local4", "local5", "local6", "local7",
+}
+
+// Messages without a priority are user.notice
+const defaultPriority = 1<<3 | 5
+
+// ParseSeverity parses a severity name (e.g. "warning" or "warn") or level (0-7)
+func ParseSeverity(value string) (int, bool) {
+	if n, err := strconv.Atoi(value); err == nil {
+		return n, n >= 0 && n < len(severities)
+	}
+
+	value = strings.ToLower(value)
+	switch value {
+	case "emergency", "panic":
+		return 0, true
+	case "critical":
+		return 2, true
+	case "error":
+		return 3, true
+	case "warn":
+		return 4, true
+	}
+	for level, name := range severities {
+		if name == value {
+			return level, true
+		}
+	}
+	return 0, false
+}
+
+// Parse parses an RFC 5424 or RFC 3164 (BSD) syslog message. Whatever can't
+// be parsed is kept in the message, so nothing is lost.
+func Parse(line string, received time.Time) Entry {
+	line = strings.TrimRight(line, "\r\n\x00")
+	entry := Entry{Time: received, Received: received}
+
+	priority, rest, ok := parsePriority(line)
+	if !ok {
+		priority, rest = defaultPriority, line
+	}
+	entry.Level = priority & 7
+	entry.Severity = severities[entry.Level]
+	if facility := priority >> 3; facility < len(facilities) {
+		entry.Facility = facilities[facility]
+	}
+
+	if strings.HasPrefix(rest, "1 ") {
+		parse5424(&entry, rest[2:])
+	} else {
+		parse3164(&entry, rest)
+	}
+	return entry
+}
+
+// parsePriority splits "<PRI>" off the start of a message
+func parsePriority(line string) (int, string, bool) {
+	if !strings.HasPrefix(line, "<") {
+		return 0, line, false
+	}
+	end := strings.IndexByte(line, '>')
+	if end < 2 || end > 4 {
+		return 0, line, false
+	}
+	priority, err := strconv.Atoi(line[1:end])
+	if err != nil || priority < 0 || priority > 191 {
+		return 0, line, false
+	}
+	return priority, line[end+1:], true
+}
+
+// parse5424 parses "TIMESTAMP HOSTNAME APP-NAME PROCID MSGID SD MSG"
+func parse5424(entry *Entry, rest string) {
+	fields := strings.SplitN(rest, " ", 6)
+	if len(fields) < 6 {
+		entry.Message = rest
+		return
+	}
+
+	if t, err := time.Parse(time.RFC3339Nano, fields[0]); err == nil {
+		entry.Time = t
+	}
+	entry.Hostname = nilValue(fields[1])
+	entry.App = nilValue(fields[2])
+	entry.PID = nilValue(fields[3])
+
+	// Skip the structured data: "-" or one or more [id param="value"...]
+	msg := fields[5]
+	if strings.HasPrefix(msg, "-") {
+		msg = msg[1:]
+	} else {
+		for strings.HasPrefix(msg, "[") {
+			end := structuredDataEnd(msg)
+			if end < 0 {
+				break
+			}
+			msg = msg[end+1:]
+		}
+	}
+	msg = strings.TrimPrefix(msg, " ")
+	entry.Message = strings.TrimPrefix(msg, "\ufeff") // BOM
+}
+
+// structuredDataEnd finds the "]" closing an SD element, skipping escaped
+// and quoted brackets
+func structuredDataEnd(s string) int {
+	quoted := false
+	for i := 1; i < len(s); i++ {
+		switch s[i] {
+		case '\\':
+			i++
+		case '"':
+			quoted = !quoted
+		case ']':
+			if !quoted {
+				return i
+			}
+		}
+	}
+	return -1
+}
+
+func nilValue(field string) string {
+	if field == "-" {
+		return ""
+	}
+	return field
+}
+
+// parse3164 parses "Mmm dd hh:mm:ss HOSTNAME TAG[PID]: MSG". Messages sent
+// from the local host often leave out the hostname.
+func parse3164(entry *Entry, rest string) {
+	const stamp = "Jan _2 15:04:05"
+	if len(rest) >= len(stamp) {
+		if t, err := time.ParseInLocation(stamp, rest[:len(stamp)], time.Local); err == nil {
+			// The year is missing: take the one that puts the time closest
+			// to when the message was received
+			t = t.AddDate(entry.Received.Year(), 0, 0)
+			if t.Sub(entry.Received) > 24*time.Hour {
+				t = t.AddDate(-1, 0, 0)
+			}
+			entry.Time = t
+			rest = strings.TrimPrefix(rest[len(stamp):], " ")
+
+			if host, after, ok := strings.Cut(rest, " "); ok && !isTag(host) {
+				entry.Hostname = host
+				rest = after
+			}
+		}
+	}
+
+	if tag, msg, ok := strings.Cut(rest, ": "); ok && isTag(tag+":") {
+		if name, pid, ok := strings.Cut(tag, "["); ok {
+			entry.App = name
+			entry.PID = strings.TrimSuffix(pid, "]")
+		} else {
+			entry.App = tag
+		}
+		rest = msg
+	}
+	entry.Message = rest
+}
+
+// isTag reports whether a word is a "tag:" or "tag[pid]:"
+func isTag(word string) bool {
+	if !strings.HasSuffix(word, ":") || len(word) < 2 {
+		return false
+	}
+	word = strings.TrimSuffix(word, ":")
+	if name, pid, ok := strings.Cut(word, "["); ok {
+		if !strings.HasSuffix(pid, "]") || name == "" {
+			return false
+		}
+		word = name
+	}
+	return !strings.ContainsAny(word, " []")
+}
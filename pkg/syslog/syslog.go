@@ -0,0 +1,317 @@
+// Package syslog is an embedded syslog receiver: it accepts RFC 5424 and
+// RFC 3164 messages over UDP and TCP and keeps the most recent ones in
+// memory, so system logs (dnsmasq, the kernel, hostapd...) can be read
+// through the API without shell access.
+package syslog
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/thesabbir/hellfire/pkg/logger"
+)
+
+// Protocols the collector listens on
+const (
+	ProtocolUDP  = "udp"
+	ProtocolTCP  = "tcp"
+	ProtocolBoth = "both"
+)
+
+const (
+	// maxMessageSize bounds a single message; longer ones are truncated
+	maxMessageSize = 64 * 1024
+
+	// maxTCPConnections bounds the concurrent TCP senders
+	maxTCPConnections = 64
+
+	// tcpIdleTimeout closes TCP connections that stop sending
+	tcpIdleTimeout = 10 * time.Minute
+)
+
+// Config controls where the collector listens and how much it keeps
+type Config struct {
+	Listen     string // host:port
+	Protocol   string // udp, tcp or both
+	BufferSize int    // Messages kept
+}
+
+// Entry is a received log message
+type Entry struct {
+	ID       uint64    `json:"id"`   // Increases with every message received
+	Time     time.Time `json:"time"` // From the message, or when it was received
+	Received time.Time `json:"received"`
+	Facility string    `json:"facility"`
+	Severity string    `json:"severity"`
+	Level    int       `json:"level"` // 0 (emerg) to 7 (debug)
+	Hostname string    `json:"hostname,omitempty"`
+	App      string    `json:"app,omitempty"`
+	PID      string    `json:"pid,omitempty"`
+	Message  string    `json:"message"`
+	Source   string    `json:"source"` // Sender address
+}
+
+// Filter selects entries. Zero values match everything.
+type Filter struct {
+	App      string    // Exact program name
+	Host     string    // Exact hostname
+	Facility string    // Facility name
+	MaxLevel *int      // This severity and more severe
+	Since    time.Time // Received at or after
+	AfterID  uint64    // Received after this entry, for polling
+	Contains string    // Case-insensitive substring of the message
+	Limit    int       // Newest entries returned
+}
+
+func (f Filter) matches(e *Entry) bool {
+	switch {
+	case e.ID <= f.AfterID:
+		return false
+	case f.App != "" && e.App != f.App:
+		return false
+	case f.Host != "" && e.Hostname != f.Host:
+		return false
+	case f.Facility != "" && e.Facility != f.Facility:
+		return false
+	case f.MaxLevel != nil && e.Level > *f.MaxLevel:
+		return false
+	case !f.Since.IsZero() && e.Received.Before(f.Since):
+		return false
+	case f.Contains != "" && !strings.Contains(strings.ToLower(e.Message), strings.ToLower(f.Contains)):
+		return false
+	}
+	return true
+}
+
+// Buffer keeps the most recent entries
+type Buffer struct {
+	mu      sync.RWMutex
+	entries []Entry
+	next    int // Where the next entry goes once the buffer is full
+	lastID  uint64
+}
+
+// NewBuffer creates a buffer keeping size entries
+func NewBuffer(size int) *Buffer {
+	return &Buffer{entries: make([]Entry, 0, size)}
+}
+
+// Add stores an entry, replacing the oldest when the buffer is full
+func (b *Buffer) Add(entry Entry) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.lastID++
+	entry.ID = b.lastID
+	if len(b.entries) < cap(b.entries) {
+		b.entries = append(b.entries, entry)
+		return
+	}
+	b.entries[b.next] = entry
+	b.next = (b.next + 1) % len(b.entries)
+}
+
+// Query returns the newest entries matching the filter, newest first
+func (b *Buffer) Query(f Filter) []Entry {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+
+	result := []Entry{}
+	n := len(b.entries)
+	for i := 0; i < n && (f.Limit <= 0 || len(result) < f.Limit); i++ {
+		// Walk back from the newest entry
+		e := &b.entries[(b.next-1-i+2*n)%n]
+		if f.matches(e) {
+			result = append(result, *e)
+		}
+	}
+	return result
+}
+
+// Len returns the number of entries kept
+func (b *Buffer) Len() int {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	return len(b.entries)
+}
+
+// Collector receives syslog messages into a buffer
+type Collector struct {
+	cfg      Config
+	buffer   *Buffer
+	conns    chan struct{} // TCP connection slots
+	handlers []func(Entry)
+}
+
+// NewCollector creates a collector
+func NewCollector(cfg Config) *Collector {
+	return &Collector{
+		cfg:    cfg,
+		buffer: NewBuffer(cfg.BufferSize),
+		conns:  make(chan struct{}, maxTCPConnections),
+	}
+}
+
+// Buffer returns the received messages
+func (c *Collector) Buffer() *Buffer {
+	return c.buffer
+}
+
+// OnReceive calls fn with every message received, in the goroutine
+// receiving it. Call it before Start.
+func (c *Collector) OnReceive(fn func(Entry)) {
+	c.handlers = append(c.handlers, fn)
+}
+
+// Start listens on the configured protocols and receives in the background
+func (c *Collector) Start() error {
+	var packetConn net.PacketConn
+	var listener net.Listener
+	var err error
+
+	if c.cfg.Protocol == ProtocolUDP || c.cfg.Protocol == ProtocolBoth {
+		if packetConn, err = net.ListenPacket("udp", c.cfg.Listen); err != nil {
+			return fmt.Errorf("failed to listen for syslog on udp %s: %w", c.cfg.Listen, err)
+		}
+		go c.serveUDP(packetConn)
+	}
+
+	if c.cfg.Protocol == ProtocolTCP || c.cfg.Protocol == ProtocolBoth {
+		if listener, err = net.Listen("tcp", c.cfg.Listen); err != nil {
+			if packetConn != nil {
+				packetConn.Close()
+			}
+			return fmt.Errorf("failed to listen for syslog on tcp %s: %w", c.cfg.Listen, err)
+		}
+		go c.serveTCP(listener)
+	}
+
+	logger.Info("Started syslog collector",
+		"listen", c.cfg.Listen,
+		"protocol", c.cfg.Protocol,
+		"buffer_size", c.cfg.BufferSize)
+	return nil
+}
+
+func (c *Collector) serveUDP(conn net.PacketConn) {
+	buf := make([]byte, maxMessageSize)
+	for {
+		n, addr, err := conn.ReadFrom(buf)
+		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				return
+			}
+			logger.Warn("Syslog receive failed", "error", err)
+			continue
+		}
+		c.receive(string(buf[:n]), addr)
+	}
+}
+
+func (c *Collector) serveTCP(listener net.Listener) {
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				return
+			}
+			logger.Warn("Syslog accept failed", "error", err)
+			time.Sleep(time.Second)
+			continue
+		}
+
+		select {
+		case c.conns <- struct{}{}:
+		default:
+			logger.Warn("Too many syslog connections, refusing", "remote", conn.RemoteAddr().String())
+			conn.Close()
+			continue
+		}
+
+		go func() {
+			defer func() { <-c.conns }()
+			defer conn.Close()
+			c.readStream(conn, conn.RemoteAddr())
+		}()
+	}
+}
+
+// readStream reads messages framed by octet counting ("LEN MSG", RFC 6587)
+// or by newlines
+func (c *Collector) readStream(conn net.Conn, addr net.Addr) {
+	r := bufio.NewReaderSize(conn, 4096)
+	for {
+		_ = conn.SetReadDeadline(time.Now().Add(tcpIdleTimeout))
+
+		first, err := r.Peek(1)
+		if err != nil {
+			return
+		}
+
+		var msg string
+		if first[0] >= '1' && first[0] <= '9' {
+			msg, err = readCounted(r)
+		} else {
+			msg, err = readLine(r)
+		}
+		if msg != "" {
+			c.receive(msg, addr)
+		}
+		if err != nil {
+			if !errors.Is(err, io.EOF) && !errors.Is(err, net.ErrClosed) {
+				logger.Debug("Syslog connection closed", "remote", addr.String(), "error", err)
+			}
+			return
+		}
+	}
+}
+
+func readCounted(r *bufio.Reader) (string, error) {
+	length, err := r.ReadString(' ')
+	if err != nil {
+		return "", err
+	}
+	n, err := strconv.Atoi(strings.TrimSuffix(length, " "))
+	if err != nil || n < 1 || n > maxMessageSize {
+		return "", fmt.Errorf("invalid message length %q", length)
+	}
+
+	buf := make([]byte, n)
+	if _, err := io.ReadFull(r, buf); err != nil {
+		return "", err
+	}
+	return string(buf), nil
+}
+
+func readLine(r *bufio.Reader) (string, error) {
+	var line []byte
+	for {
+		chunk, isPrefix, err := r.ReadLine()
+		if len(line) < maxMessageSize {
+			line = append(line, chunk[:min(len(chunk), maxMessageSize-len(line))]...)
+		}
+		if err != nil || !isPrefix {
+			return string(line), err
+		}
+	}
+}
+
+func (c *Collector) receive(msg string, addr net.Addr) {
+	entry := Parse(msg, time.Now())
+	if host, _, err := net.SplitHostPort(addr.String()); err == nil {
+		entry.Source = host
+	} else {
+		entry.Source = addr.String()
+	}
+	c.buffer.Add(entry)
+	for _, fn := range c.handlers {
+		fn(entry)
+	}
+}
@@ -0,0 +1,98 @@
+package syslog
+
+import (
+	"fmt"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestParse(t *testing.T) {
+	received := time.Date(2026, 1, 1, 0, 0, 30, 0, time.Local)
+
+	tests := []struct {
+		line  string
+		want  Entry
+		stamp time.Time
+	}{
+		{
+			line:  "<30>Dec 31 23:59:58 router dnsmasq[812]: query[A] example.com from 192.168.1.20",
+			want:  Entry{Facility: "daemon", Severity: "info", Level: 6, Hostname: "router", App: "dnsmasq", PID: "812", Message: "query[A] example.com from 192.168.1.20"},
+			stamp: time.Date(2025, 12, 31, 23, 59, 58, 0, time.Local),
+		},
+		{
+			line: "<4>Jan  1 00:00:01 kernel: [ 12.345] eth0: link up",
+			want: Entry{Facility: "kern", Severity: "warning", Level: 4, App: "kernel", Message: "[ 12.345] eth0: link up"},
+		},
+		{
+			line: `<165>1 2026-01-01T00:00:00.5Z ap1 hostapd 99 - [meta x="a]b"] wlan0: STA associated`,
+			want: Entry{Facility: "local4", Severity: "notice", Level: 5, Hostname: "ap1", App: "hostapd", PID: "99", Message: "wlan0: STA associated"},
+		},
+		{
+			line: "no priority here",
+			want: Entry{Facility: "user", Severity: "notice", Level: 5, Message: "no priority here"},
+		},
+	}
+	for _, tt := range tests {
+		got := Parse(tt.line, received)
+		if !tt.stamp.IsZero() && !got.Time.Equal(tt.stamp) {
+			t.Errorf("%q: time = %v, want %v", tt.line, got.Time, tt.stamp)
+		}
+		got.Time, got.Received = time.Time{}, time.Time{}
+		if got != tt.want {
+			t.Errorf("%q:\n got %+v\nwant %+v", tt.line, got, tt.want)
+		}
+	}
+}
+
+func TestBuffer(t *testing.T) {
+	b := NewBuffer(3)
+	for i := 1; i <= 5; i++ {
+		app := "dnsmasq"
+		if i%2 == 0 {
+			app = "kernel"
+		}
+		b.Add(Entry{App: app, Level: i, Message: fmt.Sprintf("message %d", i), Received: time.Now()})
+	}
+
+	all := b.Query(Filter{})
+	if len(all) != 3 || all[0].ID != 5 || all[2].ID != 3 {
+		t.Fatalf("Query = %+v, want entries 5, 4, 3", all)
+	}
+
+	if got := b.Query(Filter{App: "dnsmasq"}); len(got) != 2 || got[0].Message != "message 5" {
+		t.Errorf("Query by app = %+v", got)
+	}
+	level := 4
+	if got := b.Query(Filter{MaxLevel: &level}); len(got) != 2 || got[0].ID != 4 {
+		t.Errorf("Query by severity = %+v", got)
+	}
+	if got := b.Query(Filter{AfterID: 4, Contains: "MESSAGE"}); len(got) != 1 || got[0].ID != 5 {
+		t.Errorf("Query after 4 = %+v", got)
+	}
+	if got := b.Query(Filter{Limit: 1}); len(got) != 1 || got[0].ID != 5 {
+		t.Errorf("Query with limit = %+v", got)
+	}
+}
+
+func TestReadStream(t *testing.T) {
+	c := NewCollector(Config{BufferSize: 10})
+	client, server := net.Pipe()
+	done := make(chan struct{})
+	go func() {
+		c.readStream(server, &net.TCPAddr{IP: net.ParseIP("192.168.1.2"), Port: 514})
+		close(done)
+	}()
+
+	// Octet counting, then newline framing
+	msg := "<13>Jan  1 00:00:00 host app: counted"
+	fmt.Fprintf(client, "%d %s", len(msg), msg)
+	fmt.Fprint(client, "<13>Jan  1 00:00:00 host app: line one\n<13>Jan  1 00:00:00 host app: line two\n")
+	client.Close()
+	<-done
+
+	got := c.Buffer().Query(Filter{})
+	if len(got) != 3 || got[2].Message != "counted" || got[0].Message != "line two" || got[0].Source != "192.168.1.2" {
+		t.Errorf("received %+v", got)
+	}
+}
@@ -0,0 +1,426 @@
+// Package tasks runs scheduled router housekeeping — blocklist updates,
+// reboots, backups — defined as 'task' sections of the tasks config instead
+// of hand-edited crontabs. Each run is recorded in the database and audited.
+package tasks
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"regexp"
+	"strconv"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/thesabbir/hellfire/pkg/audit"
+	"github.com/thesabbir/hellfire/pkg/bus"
+	"github.com/thesabbir/hellfire/pkg/db"
+	"github.com/thesabbir/hellfire/pkg/logger"
+	"github.com/thesabbir/hellfire/pkg/uci"
+)
+
+const (
+	// ConfigName is the config the tasks are defined in
+	ConfigName = "tasks"
+
+	DefaultTimeout       = 5 * time.Minute
+	DefaultKeepSnapshots = 20
+	DefaultRetention     = 30 * 24 * time.Hour
+
+	// maxOutput bounds the output recorded for a run
+	maxOutput = 8 * 1024
+
+	// schedulerUser is the audit username of scheduled runs
+	schedulerUser = "scheduler"
+)
+
+// Built-in actions, run instead of a command
+const (
+	ActionReboot         = "reboot"          // Reboot the router
+	ActionSnapshot       = "snapshot"        // Snapshot every config
+	ActionBackup         = "backup"          // Back up the database
+	ActionPruneSnapshots = "prune_snapshots" // Delete all but the newest 'keep' snapshots
+)
+
+// Actions lists the built-in actions
+var Actions = []string{ActionReboot, ActionSnapshot, ActionBackup, ActionPruneSnapshots}
+
+// What started a run
+const (
+	TriggerSchedule = "schedule"
+	TriggerManual   = "manual"
+)
+
+// Run statuses
+const (
+	StatusSuccess = "success"
+	StatusFailure = "failure"
+	StatusTimeout = "timeout"
+)
+
+var (
+	ErrNotFound = errors.New("task not found")
+	ErrRunning  = errors.New("task is already running")
+)
+
+var taskNamePattern = regexp.MustCompile(`^[A-Za-z0-9_-]{1,64}$`)
+
+// Task is a command or built-in action run on a schedule
+type Task struct {
+	Name     string `json:"name"`
+	Schedule string `json:"schedule"`
+	Command  string `json:"command,omitempty"` // Run with /bin/sh -c
+	Action   string `json:"action,omitempty"`
+	Keep     int    `json:"keep,omitempty"` // prune_snapshots: snapshots kept
+	Enabled  bool   `json:"enabled"`
+	Timeout  int    `json:"timeout"` // Seconds
+
+	schedule *Schedule
+}
+
+// Next returns when the task next runs, or the zero time if it is disabled
+// or its schedule never fires
+func (t Task) Next(after time.Time) time.Time {
+	if !t.Enabled {
+		return time.Time{}
+	}
+	return t.schedule.Next(after)
+}
+
+// Status is a task with its next and last runs
+type Status struct {
+	Task
+	NextRun *time.Time  `json:"next_run,omitempty"`
+	Running bool        `json:"running"`
+	LastRun *db.TaskRun `json:"last_run,omitempty"`
+}
+
+// ActionFunc runs a built-in action and returns its output
+type ActionFunc func(ctx context.Context, task Task) (string, error)
+
+// Parse reads the task sections of the tasks config
+func Parse(config *uci.Config) ([]Task, error) {
+	var tasks []Task
+	names := make(map[string]bool)
+
+	for _, section := range config.GetSectionsByType("task") {
+		name, ok := section.GetOption("name")
+		if !ok {
+			name = section.Name
+		}
+		if !taskNamePattern.MatchString(name) {
+			return nil, fmt.Errorf("invalid task name %q (letters, digits, '-' and '_' only)", name)
+		}
+		if names[name] {
+			return nil, fmt.Errorf("duplicate task %s", name)
+		}
+		names[name] = true
+
+		task := Task{Name: name, Enabled: true, Timeout: int(DefaultTimeout.Seconds())}
+
+		expr, _ := section.GetOption("schedule")
+		schedule, err := ParseSchedule(expr)
+		if err != nil {
+			return nil, fmt.Errorf("task %s: %w", name, err)
+		}
+		task.Schedule, task.schedule = expr, schedule
+
+		command, hasCommand := section.GetOption("command")
+		action, hasAction := section.GetOption("action")
+		switch {
+		case hasCommand == hasAction:
+			return nil, fmt.Errorf("task %s needs exactly one of command or action", name)
+		case hasCommand:
+			task.Command = command
+		default:
+			if !isAction(action) {
+				return nil, fmt.Errorf("task %s: unknown action %q", name, action)
+			}
+			task.Action = action
+		}
+
+		if action == ActionPruneSnapshots {
+			task.Keep = DefaultKeepSnapshots
+			if keep, ok := section.GetOption("keep"); ok {
+				task.Keep, err = strconv.Atoi(keep)
+				if err != nil || task.Keep < 1 {
+					return nil, fmt.Errorf("task %s: keep must be a positive number", name)
+				}
+			}
+		}
+
+		if enabled, ok := section.GetOption("enabled"); ok {
+			task.Enabled = enabled == "1"
+		}
+		if timeout, ok := section.GetOption("timeout"); ok {
+			task.Timeout, err = strconv.Atoi(timeout)
+			if err != nil || task.Timeout < 1 {
+				return nil, fmt.Errorf("task %s: timeout must be a positive number of seconds", name)
+			}
+		}
+
+		tasks = append(tasks, task)
+	}
+	return tasks, nil
+}
+
+func isAction(name string) bool {
+	for _, action := range Actions {
+		if action == name {
+			return true
+		}
+	}
+	return false
+}
+
+// Scheduler runs the tasks of a config file when they are due. The file is
+// re-read when it changes, so committed changes take effect within a minute.
+type Scheduler struct {
+	path    string
+	actions map[string]ActionFunc
+
+	mu      sync.Mutex
+	tasks   []Task
+	modTime time.Time
+	size    int64
+	running map[string]bool
+}
+
+// NewScheduler creates a scheduler for the tasks in path, running built-in
+// actions with the given functions
+func NewScheduler(path string, actions map[string]ActionFunc) *Scheduler {
+	return &Scheduler{
+		path:    path,
+		actions: actions,
+		running: make(map[string]bool),
+	}
+}
+
+// Start runs due tasks at the start of every minute, and deletes runs older
+// than retention daily
+func (s *Scheduler) Start(retention time.Duration) {
+	go func() {
+		for {
+			now := time.Now()
+			time.Sleep(now.Truncate(time.Minute).Add(time.Minute).Sub(now))
+			s.tick(time.Now())
+		}
+	}()
+
+	go func() {
+		ticker := time.NewTicker(24 * time.Hour)
+		defer ticker.Stop()
+		for range ticker.C {
+			if _, err := db.CleanupTaskRuns(time.Now().Add(-retention)); err != nil {
+				logger.Warn("Failed to clean up task runs", "error", err)
+			}
+		}
+	}()
+}
+
+// tick starts the enabled tasks due in now's minute
+func (s *Scheduler) tick(now time.Time) {
+	for _, task := range s.load() {
+		if !task.Enabled || !task.schedule.Matches(now) {
+			continue
+		}
+		if err := s.start(context.Background(), task, TriggerSchedule); err != nil {
+			logger.Warn("Skipped scheduled task", "task", task.Name, "error", err)
+		}
+	}
+}
+
+// load re-reads the tasks config if it changed. An invalid config keeps the
+// tasks already loaded.
+func (s *Scheduler) load() []Task {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	info, err := os.Stat(s.path)
+	if err != nil {
+		if !errors.Is(err, os.ErrNotExist) {
+			logger.Warn("Failed to read tasks", "path", s.path, "error", err)
+			return s.tasks
+		}
+		s.tasks, s.modTime, s.size = nil, time.Time{}, 0
+		return nil
+	}
+	if info.ModTime().Equal(s.modTime) && info.Size() == s.size {
+		return s.tasks
+	}
+
+	tasks, err := readTasks(s.path)
+	if err != nil {
+		logger.Warn("Failed to load tasks, keeping the previous ones", "path", s.path, "error", err)
+		return s.tasks
+	}
+	s.tasks, s.modTime, s.size = tasks, info.ModTime(), info.Size()
+	return tasks
+}
+
+func readTasks(path string) ([]Task, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	config, err := uci.Parse(f)
+	if err != nil {
+		return nil, err
+	}
+	return Parse(config)
+}
+
+// Tasks lists the tasks with their next and last runs
+func (s *Scheduler) Tasks() ([]Status, error) {
+	tasks := s.load()
+	latest, err := db.LatestTaskRuns()
+	if err != nil {
+		return nil, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	statuses := make([]Status, 0, len(tasks))
+	for _, task := range tasks {
+		status := Status{Task: task, Running: s.running[task.Name]}
+		if next := task.Next(now); !next.IsZero() {
+			status.NextRun = &next
+		}
+		if run, ok := latest[task.Name]; ok {
+			status.LastRun = &run
+		}
+		statuses = append(statuses, status)
+	}
+	return statuses, nil
+}
+
+// Run starts a task now, whether or not it is enabled. The run is audited
+// with auditCtx, which names the user (see audit.WithUser).
+func (s *Scheduler) Run(auditCtx context.Context, name string) error {
+	for _, task := range s.load() {
+		if task.Name == name {
+			return s.start(auditCtx, task, TriggerManual)
+		}
+	}
+	return fmt.Errorf("%w: %s", ErrNotFound, name)
+}
+
+// start runs a task in the background unless it is still running
+func (s *Scheduler) start(auditCtx context.Context, task Task, trigger string) error {
+	s.mu.Lock()
+	if s.running[task.Name] {
+		s.mu.Unlock()
+		return ErrRunning
+	}
+	s.running[task.Name] = true
+	s.mu.Unlock()
+
+	go func() {
+		defer func() {
+			s.mu.Lock()
+			delete(s.running, task.Name)
+			s.mu.Unlock()
+		}()
+		s.execute(auditCtx, task, trigger)
+	}()
+	return nil
+}
+
+// execute runs a task, records the run and audits it with auditCtx (as
+// schedulerUser if it names no user)
+func (s *Scheduler) execute(auditCtx context.Context, task Task, trigger string) *db.TaskRun {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Duration(task.Timeout)*time.Second)
+	defer cancel()
+
+	run := &db.TaskRun{Task: task.Name, Trigger: trigger, Status: StatusSuccess, StartedAt: time.Now()}
+	logger.Info("Running task", "task", task.Name, "trigger", trigger)
+
+	var output string
+	var err error
+	if task.Command != "" {
+		output, err = runCommand(ctx, task)
+	} else if action, ok := s.actions[task.Action]; ok {
+		output, err = action(ctx, task)
+	} else {
+		err = fmt.Errorf("action %s is not available", task.Action)
+	}
+
+	run.FinishedAt = time.Now()
+	run.DurationMs = run.FinishedAt.Sub(run.StartedAt).Milliseconds()
+	run.Output = truncate(output)
+	switch {
+	case ctx.Err() == context.DeadlineExceeded:
+		run.Status = StatusTimeout
+		err = fmt.Errorf("timed out after %ds", task.Timeout)
+	case err != nil:
+		run.Status = StatusFailure
+	}
+	if err != nil {
+		run.Error = err.Error()
+	}
+
+	if dbErr := db.CreateTaskRun(run); dbErr != nil {
+		logger.Warn("Failed to record task run", "task", task.Name, "error", dbErr)
+	}
+
+	resource := ConfigName + "/" + task.Name
+	if err != nil {
+		logger.Warn("Task failed", "task", task.Name, "status", run.Status, "error", err)
+		audit.LogWithContext(auditCtx, audit.ActionTaskRun, audit.StatusFailure, nil, schedulerUser, resource,
+			fmt.Sprintf("Task %s failed (%s)", task.Name, trigger), nil, err)
+		bus.Publish(bus.Event{Type: bus.EventTaskFailed, ConfigName: ConfigName, Data: run})
+	} else {
+		audit.LogWithContext(auditCtx, audit.ActionTaskRun, audit.StatusSuccess, nil, schedulerUser, resource,
+			fmt.Sprintf("Task %s succeeded in %dms (%s)", task.Name, run.DurationMs, trigger), nil, nil)
+	}
+	return run
+}
+
+// runCommand runs the command of a task with /bin/sh, returning its
+// combined output
+func runCommand(ctx context.Context, task Task) (string, error) {
+	cmd := exec.CommandContext(ctx, "/bin/sh", "-c", task.Command)
+	cmd.Env = append(os.Environ(), "HELLFIRE_TASK="+task.Name)
+	// Kill the whole process group on timeout, and don't wait for
+	// background children holding the output open
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+	cmd.Cancel = func() error {
+		return syscall.Kill(-cmd.Process.Pid, syscall.SIGKILL)
+	}
+	cmd.WaitDelay = 5 * time.Second
+
+	output := &limitedBuffer{limit: maxOutput + 1}
+	cmd.Stdout = output
+	cmd.Stderr = output
+	err := cmd.Run()
+	return output.String(), err
+}
+
+// limitedBuffer keeps the first limit bytes written to it
+type limitedBuffer struct {
+	bytes.Buffer
+	limit int
+}
+
+func (b *limitedBuffer) Write(p []byte) (int, error) {
+	if room := b.limit - b.Len(); room < len(p) {
+		b.Buffer.Write(p[:max(room, 0)])
+		return len(p), nil
+	}
+	return b.Buffer.Write(p)
+}
+
+func truncate(output string) string {
+	if len(output) <= maxOutput {
+		return output
+	}
+	return output[:maxOutput] + "\n... (truncated)"
+}
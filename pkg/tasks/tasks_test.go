@@ -0,0 +1,117 @@
+package tasks
+
+import (
+	"context"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/thesabbir/hellfire/pkg/audit"
+	"github.com/thesabbir/hellfire/pkg/db"
+	"github.com/thesabbir/hellfire/pkg/uci"
+)
+
+func TestScheduleNext(t *testing.T) {
+	// Friday
+	from := time.Date(2026, 10, 16, 10, 30, 0, 0, time.UTC)
+
+	tests := []struct {
+		expr string
+		want time.Time
+	}{
+		{"*/15 * * * *", time.Date(2026, 10, 16, 10, 45, 0, 0, time.UTC)},
+		{"@daily", time.Date(2026, 10, 17, 0, 0, 0, 0, time.UTC)},
+		{"0 4 * * mon-wed", time.Date(2026, 10, 19, 4, 0, 0, 0, time.UTC)},
+		{"30 2 1 */3 *", time.Date(2027, 1, 1, 2, 30, 0, 0, time.UTC)},
+		{"0 0 * * 7", time.Date(2026, 10, 18, 0, 0, 0, 0, time.UTC)},
+		// Day of month or day of week, as in cron
+		{"0 12 20 * 6", time.Date(2026, 10, 17, 12, 0, 0, 0, time.UTC)},
+		{"0 0 30 feb *", time.Time{}},
+	}
+	for _, tt := range tests {
+		schedule, err := ParseSchedule(tt.expr)
+		if err != nil {
+			t.Errorf("ParseSchedule(%q): %v", tt.expr, err)
+			continue
+		}
+		if got := schedule.Next(from); !got.Equal(tt.want) {
+			t.Errorf("%q: Next = %v, want %v", tt.expr, got, tt.want)
+		}
+	}
+
+	for _, expr := range []string{"", "* * * *", "60 * * * *", "* * * * 8", "5-1 * * * *", "*/0 * * * *", "@often"} {
+		if _, err := ParseSchedule(expr); err == nil {
+			t.Errorf("ParseSchedule(%q) succeeded", expr)
+		}
+	}
+}
+
+func TestParse(t *testing.T) {
+	config, err := uci.Parse(strings.NewReader(`
+config task 'blocklist'
+	option schedule '0 3 * * *'
+	option command '/usr/local/sbin/update-blocklist'
+	option timeout '600'
+
+config task 'prune'
+	option schedule '@weekly'
+	option action 'prune_snapshots'
+	option keep '5'
+	option enabled '0'
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tasks, err := Parse(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(tasks) != 2 || tasks[0].Timeout != 600 || tasks[1].Keep != 5 || tasks[1].Enabled {
+		t.Errorf("Parse = %+v", tasks)
+	}
+
+	for _, invalid := range []string{
+		"config task 'a'\n\toption schedule '@daily'\n",
+		"config task 'a'\n\toption schedule '@daily'\n\toption command 'true'\n\toption action 'reboot'\n",
+		"config task 'a'\n\toption schedule '@daily'\n\toption action 'format'\n",
+		"config task 'a'\n\toption schedule 'daily'\n\toption command 'true'\n",
+	} {
+		config, err := uci.Parse(strings.NewReader(invalid))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := Parse(config); err == nil {
+			t.Errorf("Parse(%q) succeeded", invalid)
+		}
+	}
+}
+
+func TestExecute(t *testing.T) {
+	if err := db.Initialize(&db.Config{Path: filepath.Join(t.TempDir(), "hellfire.db")}); err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	s := NewScheduler(filepath.Join(t.TempDir(), "tasks"), nil)
+	run := s.execute(audit.WithUser(context.Background(), 1, "admin"), Task{Name: "hello", Command: "echo hello $HELLFIRE_TASK", Timeout: 5}, TriggerManual)
+	if run.Status != StatusSuccess || run.Output != "hello hello\n" {
+		t.Errorf("run = %+v", run)
+	}
+
+	run = s.execute(context.Background(), Task{Name: "slow", Command: "sleep 5", Timeout: 1}, TriggerSchedule)
+	if run.Status != StatusTimeout {
+		t.Errorf("run = %+v, want a timeout", run)
+	}
+
+	run = s.execute(context.Background(), Task{Name: "backup", Action: ActionBackup, Timeout: 5}, TriggerSchedule)
+	if run.Status != StatusFailure {
+		t.Errorf("run = %+v, want a failure without an action", run)
+	}
+
+	latest, err := db.LatestTaskRuns()
+	if err != nil || len(latest) != 3 || latest["hello"].Trigger != TriggerManual {
+		t.Errorf("LatestTaskRuns = %+v, %v", latest, err)
+	}
+}
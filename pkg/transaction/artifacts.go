@@ -0,0 +1,47 @@
+package transaction
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+
+	"github.com/thesabbir/hellfire/pkg/config"
+	"github.com/thesabbir/hellfire/pkg/db"
+	"github.com/thesabbir/hellfire/pkg/logger"
+	"github.com/thesabbir/hellfire/pkg/uci"
+)
+
+// archiveArtifacts renders a config with its applier just before it is
+// applied and archives the artifacts under the current transaction, so an
+// incident review can see exactly what was pushed to the system (must be
+// called with lock held). stage is PhaseApply or PhaseRollback. Archiving is
+// best effort: failures are logged and don't stop the transaction. Secret
+// options are masked so the archive doesn't hold them in plaintext.
+func (m *Manager) archiveArtifacts(ctx context.Context, stage, name string, cfg *uci.Config) {
+	if db.DB == nil || m.currentTxRecord == nil {
+		return
+	}
+
+	artifacts, err := m.applierRegistry.Render(ctx, name, config.MaskSecrets(name, cfg))
+	if err != nil {
+		// Apply fails the same way and records the error
+		logger.Warn("Failed to render artifacts for the archive", "applier", name, "error", err)
+		return
+	}
+
+	records := make([]db.TransactionArtifact, 0, len(artifacts))
+	for _, artifact := range artifacts {
+		sum := sha256.Sum256([]byte(artifact.Content))
+		records = append(records, db.TransactionArtifact{
+			TxID:    m.currentTxRecord.TxID,
+			Stage:   stage,
+			Config:  name,
+			Name:    artifact.Name,
+			Content: artifact.Content,
+			SHA256:  hex.EncodeToString(sum[:]),
+		})
+	}
+	if err := db.CreateTransactionArtifacts(records); err != nil {
+		logger.Warn("Failed to archive transaction artifacts", "applier", name, "error", err)
+	}
+}
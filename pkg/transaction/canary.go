@@ -0,0 +1,124 @@
+package transaction
+
+import (
+	"context"
+	"fmt"
+	"slices"
+
+	"github.com/thesabbir/hellfire/pkg/config"
+	"github.com/thesabbir/hellfire/pkg/db"
+	"github.com/thesabbir/hellfire/pkg/logger"
+)
+
+// PhaseCanary is the time spent applying a commit to the canary router and
+// waiting for it to prove healthy
+const PhaseCanary = "canary"
+
+// Canary statuses recorded on the transaction
+const (
+	CanaryApplied    = "applied"    // Applied and healthy, awaiting this router's apply
+	CanaryConfirmed  = "confirmed"  // Kept, as this router applied the changes too
+	CanaryRolledBack = "rolledback" // Rolled back, as this router couldn't apply them
+	CanaryFailed     = "failed"     // Failed to apply, or unhealthy after applying
+)
+
+// Canary is a second router, such as the standby of an HA pair, that gets
+// the changes of every commit first
+type Canary interface {
+	// Name identifies the canary in transaction records
+	Name() string
+	// Apply stages the operations on the canary, commits them there pending
+	// confirmation and checks that it is healthy with them, rolling them back
+	// if not. It returns the canary's transaction ID.
+	Apply(ctx context.Context, message string, ops []config.Operation) (string, error)
+	// Confirm keeps the changes applied by Apply
+	Confirm(ctx context.Context) error
+	// Rollback undoes the changes applied by Apply
+	Rollback(ctx context.Context) error
+}
+
+type skipCanaryKey struct{}
+
+// WithoutCanary returns a context for a commit applied to this router only,
+// e.g. while the canary is down
+func WithoutCanary(ctx context.Context) context.Context {
+	return context.WithValue(ctx, skipCanaryKey{}, true)
+}
+
+// SetCanary sets the router commits are applied to first (nil applies them
+// to this router only)
+func (m *Manager) SetCanary(canary Canary) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.canary = canary
+}
+
+// canaryFor returns the canary a commit is applied to first, if any (must be
+// called with lock held)
+func (m *Manager) canaryFor(ctx context.Context) Canary {
+	if skip, _ := ctx.Value(skipCanaryKey{}).(bool); skip {
+		return nil
+	}
+	return m.canary
+}
+
+// applyCanary applies the staged changes of the configs to the canary and
+// records the outcome on the transaction. It reports whether the canary now
+// awaits confirmation; configs that are never applied stay on this router.
+// (must be called with lock held)
+func (m *Manager) applyCanary(ctx context.Context, canary Canary, message string, configs []string) (bool, error) {
+	var ops []config.Operation
+	for _, name := range configs {
+		if slices.Contains(m.skipApply, name) {
+			continue
+		}
+		configOps, err := m.configManager.Operations(name)
+		if err != nil {
+			return false, fmt.Errorf("failed to read changes of %s: %w", name, err)
+		}
+		ops = append(ops, configOps...)
+	}
+	if len(ops) == 0 {
+		return false, nil
+	}
+
+	txID := m.currentTxRecord.TxID
+	logger.Info("Applying changes to the canary", "canary", canary.Name(), "operations", len(ops))
+	phaseStart := m.startPhase(ctx, PhaseCanary, "")
+	canaryTxID, err := canary.Apply(ctx, fmt.Sprintf("Canary of %s: %s", txID, message), ops)
+	m.endPhase(ctx, PhaseCanary, "", phaseStart, err)
+
+	m.currentTxRecord.Canary = canary.Name()
+	m.currentTxRecord.CanaryTxID = canaryTxID
+	m.currentTxRecord.CanaryStatus = CanaryApplied
+	if err != nil {
+		m.currentTxRecord.CanaryStatus = CanaryFailed
+		err = fmt.Errorf("canary %s failed: %w", canary.Name(), err)
+	}
+	if db.DB != nil {
+		_ = db.UpdateTransaction(m.currentTxRecord)
+	}
+	return err == nil, err
+}
+
+// finishCanary confirms the changes on the canary once this router applied
+// them, or rolls them back, and records it (must be called with lock held)
+func (m *Manager) finishCanary(ctx context.Context, canary Canary, confirm bool) error {
+	var err error
+	if confirm {
+		err = canary.Confirm(ctx)
+		m.currentTxRecord.CanaryStatus = CanaryConfirmed
+	} else {
+		err = canary.Rollback(ctx)
+		m.currentTxRecord.CanaryStatus = CanaryRolledBack
+	}
+	if err != nil {
+		// Left unconfirmed, the canary rolls back when its confirmation times out
+		logger.Warn("Failed to finish the commit on the canary", "canary", canary.Name(), "confirm", confirm, "error", err)
+		m.currentTxRecord.CanaryStatus = CanaryFailed
+	}
+	if db.DB != nil {
+		_ = db.UpdateTransaction(m.currentTxRecord)
+	}
+	return err
+}
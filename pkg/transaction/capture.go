@@ -0,0 +1,92 @@
+package transaction
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	"github.com/thesabbir/hellfire/pkg/db"
+	"github.com/thesabbir/hellfire/pkg/logger"
+)
+
+// Stages of the system state dumps recorded on a transaction
+const (
+	CaptureBefore = "before" // Before the first config is applied
+	CaptureAfter  = "after"  // Once all are applied, or one failed (before its rollback)
+)
+
+// DefaultCaptureMaxSize caps each system state dump before compression
+const DefaultCaptureMaxSize = 1 << 20
+
+// captureTimeout bounds the commands of a dump, so a hung one doesn't hold
+// up the commit
+const captureTimeout = 10 * time.Second
+
+// SetSystemCapture makes commits dump the system state the appliers
+// configure before and after applying their configs, capped at maxSize bytes
+// each (0 takes no dumps)
+func (m *Manager) SetSystemCapture(maxSize int) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.captureMaxSize = maxSize
+}
+
+// captureSystemState records a dump of the system state on the current
+// transaction (must be called with lock held). Like archiving artifacts it
+// is best effort: failures are logged and don't stop the transaction.
+func (m *Manager) captureSystemState(ctx context.Context, stage string) {
+	if db.DB == nil || m.currentTxRecord == nil || m.captureMaxSize <= 0 {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), captureTimeout)
+	defer cancel()
+
+	var dump strings.Builder
+	for _, artifact := range m.applierRegistry.CaptureState(ctx) {
+		fmt.Fprintf(&dump, "=== %s\n%s", artifact.Name, artifact.Content)
+		if !strings.HasSuffix(artifact.Content, "\n") {
+			dump.WriteString("\n")
+		}
+	}
+	text := dump.String()
+	if text == "" {
+		return
+	}
+
+	capture := &db.TransactionCapture{TxID: m.currentTxRecord.TxID, Stage: stage, Size: len(text)}
+	if len(text) > m.captureMaxSize {
+		text = text[:m.captureMaxSize]
+		capture.Truncated = true
+	}
+
+	var data bytes.Buffer
+	zw := gzip.NewWriter(&data)
+	_, _ = zw.Write([]byte(text))
+	if err := zw.Close(); err != nil {
+		logger.Warn("Failed to compress the system state", "stage", stage, "error", err)
+		return
+	}
+	capture.Data = data.Bytes()
+	if err := db.CreateTransactionCapture(capture); err != nil {
+		logger.Warn("Failed to record the system state", "stage", stage, "error", err)
+	}
+}
+
+// CaptureText decompresses a system state dump
+func CaptureText(capture *db.TransactionCapture) (string, error) {
+	zr, err := gzip.NewReader(bytes.NewReader(capture.Data))
+	if err != nil {
+		return "", fmt.Errorf("failed to decompress the system state: %w", err)
+	}
+	defer zr.Close()
+	text, err := io.ReadAll(zr)
+	if err != nil {
+		return "", fmt.Errorf("failed to decompress the system state: %w", err)
+	}
+	return string(text), nil
+}
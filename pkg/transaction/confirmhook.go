@@ -0,0 +1,85 @@
+package transaction
+
+import (
+	"time"
+
+	"github.com/thesabbir/hellfire/pkg/logger"
+)
+
+// Confirmation states reported to the confirm hook
+const (
+	ConfirmPending    = "pending"     // Changes applied, waiting for confirmation
+	ConfirmConfirmed  = "confirmed"   // Confirmed in time
+	ConfirmRolledBack = "rolled_back" // Not confirmed: timed out, probes or keepalives failed or rolled back by hand
+)
+
+// Why pending changes were rolled back
+const (
+	RollbackReasonTimeout = "timeout"
+	RollbackReasonProbes  = "probes"
+	RollbackReasonManual  = "manual"
+
+	// The client of a safe remote commit stopped sending keepalives
+	RollbackReasonKeepalive = "keepalive"
+)
+
+// confirmHookQueue bounds the confirm events waiting for a slow hook
+const confirmHookQueue = 16
+
+// ConfirmEvent is a change of a commit's confirmation state
+type ConfirmEvent struct {
+	State   string
+	TxID    string
+	Timeout time.Duration // Pending: how long there is to confirm
+	Reason  string        // Rolled back: timeout, probes, keepalive or manual
+	Detail  string        // Rolled back: what triggered it, e.g. the failed probes or the user's reason
+}
+
+// SetConfirmHook sets a function told when a commit starts waiting for
+// confirmation and how the wait ends, e.g. to blink an LED on a headless
+// router. Events are delivered in order, outside the transaction lock.
+func (m *Manager) SetConfirmHook(hook func(ConfirmEvent)) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.confirmEvents != nil {
+		close(m.confirmEvents)
+		m.confirmEvents = nil
+	}
+	if hook == nil {
+		return
+	}
+
+	events := make(chan ConfirmEvent, confirmHookQueue)
+	m.confirmEvents = events
+	go func() {
+		for event := range events {
+			hook(event)
+		}
+	}()
+}
+
+// notifyConfirm queues a confirm event for the hook (must be called with
+// lock held)
+func (m *Manager) notifyConfirm(state, reason string) {
+	if m.confirmEvents == nil {
+		return
+	}
+
+	event := ConfirmEvent{State: state, Reason: reason}
+	if m.currentTxRecord != nil {
+		event.TxID = m.currentTxRecord.TxID
+		if state == ConfirmRolledBack {
+			event.Detail = m.currentTxRecord.RollbackReason
+		}
+	}
+	if state == ConfirmPending && m.pendingConfirm != nil {
+		event.Timeout = m.pendingConfirm.Timeout
+	}
+
+	select {
+	case m.confirmEvents <- event:
+	default:
+		logger.Warn("Confirm hook is not keeping up, dropping event", "state", state)
+	}
+}
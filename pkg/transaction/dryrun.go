@@ -0,0 +1,134 @@
+package transaction
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"sort"
+	"strings"
+
+	"github.com/thesabbir/hellfire/pkg/appliers"
+	"github.com/thesabbir/hellfire/pkg/config"
+	"github.com/thesabbir/hellfire/pkg/integrity"
+)
+
+// DryRun is what committing the staged changes would do, worked out without
+// creating a snapshot, writing configs or applying anything
+type DryRun struct {
+	Configs    []string                       `json:"configs"`               // Changed configs, snapshotted before the commit
+	Apply      []string                       `json:"apply"`                 // Configs applied, in order; kill switch changes add the firewall, expressions the configs reading a changed one
+	NotApplied []string                       `json:"not_applied,omitempty"` // Changed configs that are only written
+	Diffs      map[string]string              `json:"diffs"`                 // Unified diff of each changed config
+	Artifacts  map[string][]appliers.Artifact `json:"artifacts,omitempty"`   // What each applier would load
+	Errors     map[string][]string            `json:"errors,omitempty"`      // Why the commit would fail, by config
+	Issues     []integrity.Issue              `json:"issues,omitempty"`      // Broken references between configs
+}
+
+// Valid reports whether the commit would pass its checks. It can still fail
+// when applied, e.g. if the system doesn't take a valid config.
+func (d *DryRun) Valid() bool {
+	return len(d.Errors) == 0 && len(d.Issues) == 0
+}
+
+func (d *DryRun) fail(name string, err error) {
+	if d.Errors == nil {
+		d.Errors = make(map[string][]string)
+	}
+	d.Errors[name] = append(d.Errors[name], strings.Split(err.Error(), "\n")...)
+}
+
+// DryRun runs the commit pipeline on the staged changes up to the point
+// where it would change anything: it plans the snapshot and apply order,
+// diffs each changed config, checks it against the schema and its applier's
+// checks, and renders the rulesets and files its applier would load. Configs
+// failing a check are reported in the result, not as an error.
+func (m *Manager) DryRun(ctx context.Context) (*DryRun, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	if !m.configManager.HasChanges() {
+		return nil, fmt.Errorf("no changes to commit")
+	}
+
+	changed := m.configManager.GetChanges()
+	sort.Strings(changed)
+	plan, _ := m.plan(m.withDependents(changed))
+	plan = m.vpnPlan(plan, m.killSwitchesChanged())
+	ctx = m.withNetwork(ctx)
+
+	result := &DryRun{
+		Configs:   changed,
+		Apply:     plan,
+		Diffs:     make(map[string]string),
+		Artifacts: make(map[string][]appliers.Artifact),
+	}
+	for _, name := range changed {
+		if !slices.Contains(plan, name) {
+			result.NotApplied = append(result.NotApplied, name)
+		}
+	}
+
+	if slices.ContainsFunc(changed, func(name string) bool { return slices.Contains(integrity.Configs, name) }) {
+		issues, err := integrity.Check(m.configManager.LoadExpanded)
+		if err != nil {
+			return nil, err
+		}
+		result.Issues = issues
+	}
+
+	for _, name := range changed {
+		diff, err := m.configManager.Diff(name)
+		if err != nil {
+			return nil, err
+		}
+		result.Diffs[name] = diff
+
+		cfg, err := m.configManager.LoadDecrypted(name)
+		if err != nil {
+			result.fail(name, err)
+			continue
+		}
+		for _, err := range config.CheckSchema(name, cfg) {
+			result.fail(name, err)
+		}
+		if !slices.Contains(plan, name) {
+			continue
+		}
+
+		if err := m.applierRegistry.Check(ctx, name, cfg); err != nil {
+			result.fail(name, err)
+		}
+		// The preview is shown to users, so render it without the secrets
+		artifacts, err := m.applierRegistry.Render(ctx, name, config.MaskSecrets(name, cfg))
+		if err != nil {
+			result.fail(name, err)
+			continue
+		}
+		if len(artifacts) > 0 {
+			result.Artifacts[name] = artifacts
+		}
+	}
+
+	// Unchanged configs re-applied for the kill switches or their
+	// expressions
+	for _, name := range plan {
+		if slices.Contains(changed, name) {
+			continue
+		}
+		cfg, err := m.configManager.LoadDecrypted(name)
+		if err != nil {
+			result.fail(name, err)
+			continue
+		}
+		artifacts, err := m.applierRegistry.Render(ctx, name, config.MaskSecrets(name, cfg))
+		if err != nil {
+			result.fail(name, err)
+			continue
+		}
+		if len(artifacts) > 0 {
+			result.Artifacts[name] = artifacts
+		}
+	}
+
+	return result, nil
+}
@@ -0,0 +1,26 @@
+package transaction
+
+import (
+	"slices"
+
+	"github.com/thesabbir/hellfire/pkg/logger"
+)
+
+// withDependents returns the changed configs along with the unchanged ones
+// that have an applier and expressions reading a changed config, which are
+// applied again to render the new values (must be called with lock held)
+func (m *Manager) withDependents(changed []string) []string {
+	dependents, err := m.configManager.ExpressionDependents(changed)
+	if err != nil {
+		logger.Warn("Failed to find the configs whose expressions read the changed ones", "error", err)
+		return changed
+	}
+
+	configs := slices.Clone(changed)
+	for _, name := range dependents {
+		if _, ok := m.applierRegistry.Get(name); ok {
+			configs = append(configs, name)
+		}
+	}
+	return configs
+}
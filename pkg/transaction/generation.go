@@ -0,0 +1,33 @@
+package transaction
+
+import (
+	"github.com/thesabbir/hellfire/pkg/bus"
+	"github.com/thesabbir/hellfire/pkg/db"
+	"github.com/thesabbir/hellfire/pkg/logger"
+)
+
+// GenerationEvent is the data of a config.generation event
+type GenerationEvent struct {
+	Generation uint64 `json:"generation"`
+	TxID       string `json:"transaction_id,omitempty"`
+}
+
+// configChanged bumps the config generation once the committed configs were
+// written or restored from a snapshot, and publishes the new one so clients
+// can drop their cached views
+func configChanged(txID string) {
+	if db.DB == nil {
+		return
+	}
+
+	generation, err := db.BumpConfigGeneration()
+	if err != nil {
+		logger.Warn("Failed to bump the config generation", "error", err)
+		return
+	}
+
+	bus.Publish(bus.Event{
+		Type: bus.EventConfigGeneration,
+		Data: GenerationEvent{Generation: generation, TxID: txID},
+	})
+}
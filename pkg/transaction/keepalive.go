@@ -0,0 +1,87 @@
+package transaction
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/thesabbir/hellfire/pkg/audit"
+	"github.com/thesabbir/hellfire/pkg/db"
+	"github.com/thesabbir/hellfire/pkg/logger"
+)
+
+// ErrNotCommitter is returned for a keepalive from another user than the one
+// whose commit awaits it
+var ErrNotCommitter = errors.New("keepalives must come from the committing user")
+
+type keepaliveKey struct{}
+
+// WithKeepalive returns a context that makes a commit safe for a remote
+// client, which may be cut off by the very changes it commits: after the
+// apply the client must send a keepalive (see Keepalive) within window, and
+// again within every window until the commit is confirmed, or the changes are
+// rolled back. Without a confirm timeout the first keepalive confirms the
+// commit, since it proves the client still reaches the router.
+func WithKeepalive(ctx context.Context, window time.Duration) context.Context {
+	return context.WithValue(ctx, keepaliveKey{}, window)
+}
+
+// keepaliveFromContext returns the keepalive window of a commit, 0 if it
+// doesn't wait for keepalives
+func keepaliveFromContext(ctx context.Context) time.Duration {
+	window, _ := ctx.Value(keepaliveKey{}).(time.Duration)
+	return window
+}
+
+// Keepalive tells the pending safe remote commit that its client still
+// reaches the router. It reports whether that confirmed the commit; otherwise
+// the keepalive window starts over. Only the committing user's keepalives
+// count, as it is their connectivity the commit is protecting.
+func (m *Manager) Keepalive(ctx context.Context) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.state != StatePending || m.pendingConfirm == nil || m.pendingConfirm.Keepalive == 0 {
+		return false, fmt.Errorf("no pending commit awaiting keepalives (state: %s)", m.state)
+	}
+	if _, username := audit.UserFromContext(ctx); username != m.username {
+		return false, ErrNotCommitter
+	}
+
+	m.pendingConfirm.LastKeepalive = time.Now()
+	if m.pendingConfirm.KeepaliveConfirms {
+		m.confirmPending(ctx, "Transaction confirmed by keepalive")
+		return true, nil
+	}
+
+	select {
+	case m.keepaliveCh <- struct{}{}:
+	default:
+		// The timer hasn't picked up the previous keepalive yet
+	}
+	return false, nil
+}
+
+// rollbackAfterKeepalive rolls back a pending commit whose client stopped
+// sending keepalives
+func (m *Manager) rollbackAfterKeepalive(cancelCh chan struct{}, window time.Duration) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	// Confirmed (or rolled back) in the meantime
+	if m.state != StatePending || m.confirmCancelCh != cancelCh {
+		return
+	}
+
+	err := fmt.Errorf("no keepalive from %s for %s", m.username, window)
+	logger.Warn("Committing client lost connectivity, rolling back changes...", "error", err)
+
+	if db.DB != nil && m.currentTxRecord != nil {
+		m.logAudit(audit.ActionTxRollback, audit.StatusFailure, m.currentTxRecord.TxID,
+			"Rolling back changes after the committing client stopped sending keepalives", nil, err)
+	}
+
+	_ = m.rollbackBecause(context.Background(), err.Error(), "")
+	m.notifyConfirm(ConfirmRolledBack, RollbackReasonKeepalive)
+}
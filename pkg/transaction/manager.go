@@ -3,7 +3,10 @@ package transaction
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"slices"
+	"sort"
 	"strings"
 	"sync"
 	"time"
@@ -13,8 +16,11 @@ import (
 	"github.com/thesabbir/hellfire/pkg/bus"
 	"github.com/thesabbir/hellfire/pkg/config"
 	"github.com/thesabbir/hellfire/pkg/db"
+	"github.com/thesabbir/hellfire/pkg/integrity"
 	"github.com/thesabbir/hellfire/pkg/logger"
+	"github.com/thesabbir/hellfire/pkg/probe"
 	"github.com/thesabbir/hellfire/pkg/snapshot"
+	"github.com/thesabbir/hellfire/pkg/uci"
 	"github.com/thesabbir/hellfire/pkg/util"
 )
 
@@ -29,6 +35,14 @@ const (
 	StateFailed     State = "failed"
 )
 
+// Commit phases timed per transaction (durations are stored on the transaction record)
+const (
+	PhaseSnapshot = "snapshot" // Pre-commit snapshot creation
+	PhaseWrite    = "write"    // Writing staged configs to disk
+	PhaseApply    = "apply"    // Applier Apply calls, summed
+	PhaseValidate = "validate" // Applier Validate calls, summed
+)
+
 // Manager manages configuration transactions
 type Manager struct {
 	configManager   *config.Manager
@@ -41,10 +55,24 @@ type Manager struct {
 	currentTxRecord *db.Transaction // Database transaction record
 	pendingConfirm  *pendingConfirmation
 	confirmCancelCh chan struct{}
-	timerWg         sync.WaitGroup // Track confirmation timer goroutines
-	applyOrder      []string       // Configurable order for applying configs
-	userID          *uint          // User ID for audit logging
-	username        string         // Username for audit logging
+	keepaliveCh     chan struct{}            // Keepalives of the pending safe remote commit
+	timerWg         sync.WaitGroup           // Track confirmation timer goroutines
+	applyOrder      []string                 // Configurable order for applying configs
+	skipApply       []string                 // Configs that are committed but never applied
+	touched         []string                 // Appliers invoked by the current transaction, in apply order
+	phases          map[string]int64         // Phase name -> duration in ms for the current transaction
+	userID          *uint                    // User ID of the current transaction (for audit logging)
+	username        string                   // Username of the current transaction (for audit logging)
+	auditCtx        context.Context          // Impersonating admin and request of the current transaction, if any
+	recovery        *appliers.RecoveryConfig // Applied when a rollback fails
+	degraded        *DegradedStatus          // Safe mode status when not persisted to a file
+	degradedFile    string                   // Where the degraded flag is persisted
+	probes          []probe.Probe            // Connectivity checks while awaiting confirmation
+	probeOpts       probe.Options
+	confirmEvents   chan ConfirmEvent // Queue of the confirm hook, nil without one
+	canary          Canary            // Router commits are applied to first, if any
+	requireReason   bool              // Manual rollbacks must give a reason
+	captureMaxSize  int               // Cap of the system state dumps around applies; 0 takes none
 }
 
 // pendingConfirmation holds information about a pending confirmation
@@ -52,6 +80,13 @@ type pendingConfirmation struct {
 	Snapshot  *snapshot.Snapshot
 	Timeout   time.Duration
 	StartTime time.Time
+	Probes    []probe.Result // Latest connectivity probe results
+
+	// Safe remote commits: the longest gap between the committing client's
+	// keepalives, when it last sent one and whether one confirms the commit
+	Keepalive         time.Duration
+	LastKeepalive     time.Time
+	KeepaliveConfirms bool
 }
 
 // NewManager creates a new transaction manager
@@ -62,6 +97,8 @@ func NewManager(configManager *config.Manager, snapshotManager *snapshot.Manager
 		applierRegistry: registry,
 		state:           StateIdle,
 		applyOrder:      []string{"network", "firewall", "dhcp"}, // Default order
+		skipApply:       []string{"hellfire"},
+		auditCtx:        context.Background(),
 	}
 }
 
@@ -72,22 +109,159 @@ func (m *Manager) SetApplyOrder(order []string) {
 	m.applyOrder = order
 }
 
-// SetUser sets the user context for audit logging
-func (m *Manager) SetUser(userID uint, username string) {
+// SetSkipApply sets the configs that are committed to disk but never applied
+func (m *Manager) SetSkipApply(skip []string) {
 	m.mu.Lock()
 	defer m.mu.Unlock()
-	m.userID = &userID
-	m.username = username
+	m.skipApply = skip
+}
+
+// GetApplyOrder returns the current apply order and skip-list
+func (m *Manager) GetApplyOrder() (order []string, skip []string) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return append([]string{}, m.applyOrder...), append([]string{}, m.skipApply...)
+}
+
+// RegisteredAppliers returns the names of all registered appliers, sorted
+func (m *Manager) RegisteredAppliers() []string {
+	names := m.applierRegistry.List()
+	sort.Strings(names)
+	return names
+}
+
+// Check runs the check of a config's applier, as Commit does before
+// writing anything (see appliers.Checker)
+func (m *Manager) Check(ctx context.Context, name string, cfg *uci.Config) error {
+	return m.applierRegistry.Check(ctx, name, cfg)
+}
+
+// checkChanges runs the appliers' checks on the staged configs that will be
+// applied (must be called with lock held)
+func (m *Manager) checkChanges(ctx context.Context) error {
+	changes := m.configManager.GetChanges()
+	ctx = m.withNetwork(ctx)
+
+	// Broken references between configs are reported option by option
+	if slices.ContainsFunc(changes, func(name string) bool { return slices.Contains(integrity.Configs, name) }) {
+		issues, err := integrity.Check(m.configManager.LoadExpanded)
+		if err != nil {
+			return err
+		}
+		if len(issues) > 0 {
+			return &integrity.Error{Issues: issues}
+		}
+	}
+
+	var errs []error
+	if slices.Contains(changes, config.ExpressionsConfig) {
+		if err := m.configManager.CheckExpressions(); err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", config.ExpressionsConfig, err))
+		}
+	}
+	if slices.Contains(changes, config.GlobalsConfig) {
+		if _, err := m.configManager.Variables(); err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", config.GlobalsConfig, err))
+		}
+	}
+	for _, name := range m.withDependents(changes) {
+		if slices.Contains(m.skipApply, name) {
+			continue
+		}
+		cfg, err := m.configManager.LoadDecrypted(name)
+		if err != nil {
+			return fmt.Errorf("failed to load config %s: %w", name, err)
+		}
+		if err := m.applierRegistry.Check(ctx, name, cfg); err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", name, err))
+		}
+	}
+
+	// The firewall is re-applied with new kill switches, whose rules must
+	// render
+	plan, _ := m.plan(changes)
+	if plan = m.vpnPlan(plan, m.killSwitchesChanged()); slices.Contains(plan, "firewall") && !slices.Contains(changes, "firewall") {
+		cfg, err := m.configManager.LoadDecrypted("firewall")
+		if err != nil {
+			return fmt.Errorf("failed to load config firewall: %w", err)
+		}
+		if _, err := m.applierRegistry.Render(ctx, "firewall", cfg); err != nil {
+			errs = append(errs, fmt.Errorf("firewall: %w", err))
+		}
+	}
+	if len(errs) > 0 {
+		return fmt.Errorf("check failed: %w", errors.Join(errs...))
+	}
+	return nil
+}
+
+// applyPlan returns the changed configs to apply, in order (must be called with lock held).
+// Changed configs that have no applier and are not explicitly skipped are
+// reported with a warning.
+func (m *Manager) applyPlan(changedConfigs []string) []string {
+	plan, unapplied := m.plan(changedConfigs)
+	for _, name := range unapplied {
+		if name == config.ExpressionsConfig || name == config.GlobalsConfig {
+			// Applied through the configs using them
+			continue
+		}
+		logger.Warn("Committed config has no registered applier, changes will not be applied",
+			"config", name)
+	}
+	return plan
+}
+
+// plan returns the changed configs to apply, in order, and those that are
+// not skipped but have no applier (must be called with lock held). Configs
+// listed in the apply order come first; any other changed config with a
+// registered applier follows in alphabetical order.
+func (m *Manager) plan(changedConfigs []string) (applicable, unapplied []string) {
+	changed := make(map[string]bool, len(changedConfigs))
+	for _, name := range changedConfigs {
+		changed[name] = true
+	}
+
+	for _, name := range m.skipApply {
+		delete(changed, name)
+	}
+
+	plan := make([]string, 0, len(changed))
+	for _, name := range m.applyOrder {
+		if changed[name] {
+			plan = append(plan, name)
+			delete(changed, name)
+		}
+	}
+
+	remaining := make([]string, 0, len(changed))
+	for name := range changed {
+		remaining = append(remaining, name)
+	}
+	sort.Strings(remaining)
+	plan = append(plan, remaining...)
+
+	applicable = make([]string, 0, len(plan))
+	for _, name := range plan {
+		if _, ok := m.applierRegistry.Get(name); !ok {
+			unapplied = append(unapplied, name)
+			continue
+		}
+		applicable = append(applicable, name)
+	}
+
+	return applicable, unapplied
 }
 
 // Commit commits staged configuration changes
+// The user attributed to the transaction is read from ctx (see audit.WithUser)
 // overallTimeout is the maximum time for the entire transaction (0 = no timeout)
 // confirmTimeout is how long to wait for user confirmation (0 = no confirmation needed)
-func (m *Manager) Commit(message string, confirmTimeout, overallTimeout time.Duration) error {
+// A context from WithKeepalive also rolls back when the client's keepalives stop.
+func (m *Manager) Commit(ctx context.Context, message string, confirmTimeout, overallTimeout time.Duration) error {
 	m.mu.Lock()
 	defer m.mu.Unlock()
 
-	if m.state != StateIdle {
+	if m.state != StateIdle && m.state != StateCompleted && m.state != StateFailed {
 		return fmt.Errorf("transaction already in progress (state: %s)", m.state)
 	}
 
@@ -96,8 +270,18 @@ func (m *Manager) Commit(message string, confirmTimeout, overallTimeout time.Dur
 		return fmt.Errorf("no changes to commit")
 	}
 
+	// Check the changes against the system before anything is written
+	if err := m.checkChanges(ctx); err != nil {
+		return err
+	}
+
+	// Bind the requesting user to this transaction while holding the lock
+	m.userID, m.username = audit.UserFromContext(ctx)
+	m.auditCtx = auditOrigin(ctx)
+	m.touched = nil
+	m.phases = make(map[string]int64)
+
 	// Create context with timeout if specified
-	ctx := context.Background()
 	if overallTimeout > 0 {
 		var cancel context.CancelFunc
 		ctx, cancel = context.WithTimeout(ctx, overallTimeout)
@@ -128,7 +312,7 @@ func (m *Manager) Commit(message string, confirmTimeout, overallTimeout time.Dur
 		}
 
 		// Audit log: transaction started
-		audit.Log(audit.ActionTxStart, audit.StatusSuccess, m.userID, m.username, txID, message, nil)
+		m.logAudit(audit.ActionTxStart, audit.StatusSuccess, txID, message, nil, nil)
 	}
 
 	// Publish event
@@ -139,23 +323,54 @@ func (m *Manager) Commit(message string, confirmTimeout, overallTimeout time.Dur
 
 	// Get list of changed configs
 	changedConfigs := m.configManager.GetChanges()
+	killSwitchesChanged := m.killSwitchesChanged()
 
-	// Update transaction record with changed configs
+	// Update transaction record with changed configs, and the changes to
+	// retry it with if it fails
 	if db.DB != nil {
 		configsJSON, _ := json.Marshal(changedConfigs)
 		m.currentTxRecord.Configs = string(configsJSON)
+		m.recordChanges(changedConfigs)
 		_ = db.UpdateTransaction(m.currentTxRecord)
 	}
 
+	// Apply the changes to the canary first; this router is left untouched
+	// if they fail there
+	canary := m.canaryFor(ctx)
+	canaryPending := false
+	if canary != nil {
+		var err error
+		canaryPending, err = m.applyCanary(ctx, canary, message, changedConfigs)
+		if err != nil {
+			m.state = StateFailed
+			if db.DB != nil {
+				m.currentTxRecord.Status = string(StateFailed)
+				m.currentTxRecord.Error = err.Error()
+				_ = db.UpdateTransaction(m.currentTxRecord)
+				m.logAudit(audit.ActionTxCommit, audit.StatusFailure, txID, "Changes failed on the canary", nil, err)
+			}
+			return err
+		}
+
+		// Whatever fails from here on rolls the canary back too
+		defer func() {
+			if canaryPending {
+				_ = m.finishCanary(context.WithoutCancel(ctx), canary, false)
+			}
+		}()
+	}
+
 	// Create snapshot before applying changes
+	phaseStart := m.startPhase(ctx, PhaseSnapshot, "")
 	snapshot, err := m.snapshotManager.Create(message, changedConfigs)
+	m.endPhase(ctx, PhaseSnapshot, "", phaseStart, err)
 	if err != nil {
 		m.state = StateFailed
 		if db.DB != nil {
 			m.currentTxRecord.Status = string(StateFailed)
 			m.currentTxRecord.Error = err.Error()
 			_ = db.UpdateTransaction(m.currentTxRecord)
-			audit.LogFailure(audit.ActionSnapshotCreate, m.userID, m.username, txID, "Failed to create snapshot", err)
+			m.logAudit(audit.ActionSnapshotCreate, audit.StatusFailure, txID, "Failed to create snapshot", nil, err)
 		}
 		return fmt.Errorf("failed to create snapshot: %w", err)
 	}
@@ -166,7 +381,7 @@ func (m *Manager) Commit(message string, confirmTimeout, overallTimeout time.Dur
 	if db.DB != nil {
 		m.currentTxRecord.SnapshotID = snapshot.ID
 		_ = db.UpdateTransaction(m.currentTxRecord)
-		audit.LogSuccess(audit.ActionSnapshotCreate, m.userID, m.username, snapshot.ID, "Snapshot created")
+		m.logAudit(audit.ActionSnapshotCreate, audit.StatusSuccess, snapshot.ID, "Snapshot created", nil, nil)
 	}
 
 	// Publish snapshot created event
@@ -176,94 +391,120 @@ func (m *Manager) Commit(message string, confirmTimeout, overallTimeout time.Dur
 	})
 
 	// Commit config changes (write to disk)
-	if err := m.configManager.Commit(); err != nil {
+	phaseStart = m.startPhase(ctx, PhaseWrite, "")
+	err = m.configManager.Commit()
+	m.endPhase(ctx, PhaseWrite, "", phaseStart, err)
+	if err != nil {
 		m.state = StateFailed
 		return fmt.Errorf("failed to commit config: %w", err)
 	}
+	configChanged(txID)
 
 	// Apply configurations in configured order
-	for _, applierName := range m.applyOrder {
+	ctx = m.withNetwork(ctx)
+	m.captureSystemState(ctx, CaptureBefore)
+	for _, applierName := range m.vpnPlan(m.applyPlan(m.withDependents(changedConfigs)), killSwitchesChanged) {
 		// Check context cancellation
 		select {
 		case <-ctx.Done():
-			m.rollbackInternal(ctx)
+			m.rollbackBecause(ctx, "commit canceled: "+ctx.Err().Error(), "")
 			m.state = StateFailed
 			return ctx.Err()
 		default:
 		}
 
-		// Skip if this config wasn't changed
-		configChanged := false
-		for _, changed := range changedConfigs {
-			if changed == applierName {
-				configChanged = true
-				break
-			}
-		}
-
-		if !configChanged {
-			continue
-		}
-
-		// Get applier
-		applier, ok := m.applierRegistry.Get(applierName)
-		if !ok {
-			// Applier not registered, skip
-			continue
-		}
+		applier, _ := m.applierRegistry.Get(applierName)
 
-		// Load config
-		cfg, err := m.configManager.Load(applierName)
+		// Load config, with its secrets decrypted for the applier
+		cfg, err := m.configManager.LoadDecrypted(applierName)
 		if err != nil {
 			// Rollback on error
-			m.rollbackInternal(ctx)
+			err = fmt.Errorf("failed to load config %s: %w", applierName, err)
+			m.captureSystemState(ctx, CaptureAfter)
+			m.rollbackBecause(ctx, err.Error(), applierName)
 			m.state = StateFailed
-			return fmt.Errorf("failed to load config %s: %w", applierName, err)
+			return err
 		}
 
-		// Apply configuration
+		// Apply configuration (record it first: a failed Apply may have partially applied)
 		logger.Info("Applying configuration", "applier", applierName)
-		if err := applier.Apply(ctx, cfg); err != nil {
+		m.touched = append(m.touched, applierName)
+		m.archiveArtifacts(ctx, PhaseApply, applierName, cfg)
+		phaseStart = m.startPhase(ctx, PhaseApply, applierName)
+		err = applier.Apply(ctx, cfg)
+		m.endPhase(ctx, PhaseApply, applierName, phaseStart, err)
+		if err != nil {
 			// Rollback on error
 			logger.Error("Failed to apply configuration", "applier", applierName, "error", err)
-			m.rollbackInternal(ctx)
+			err = fmt.Errorf("failed to apply %s config: %w", applierName, err)
+			m.captureSystemState(ctx, CaptureAfter)
+			m.rollbackBecause(ctx, err.Error(), applierName)
 			m.state = StateFailed
-			return fmt.Errorf("failed to apply %s config: %w", applierName, err)
+			return err
 		}
 
 		// Validate
 		logger.Info("Validating configuration", "applier", applierName)
-		if err := applier.Validate(ctx); err != nil {
+		phaseStart = m.startPhase(ctx, PhaseValidate, applierName)
+		err = applier.Validate(ctx)
+		m.endPhase(ctx, PhaseValidate, applierName, phaseStart, err)
+		if err != nil {
 			// Rollback on validation failure
 			logger.Error("Validation failed", "applier", applierName, "error", err)
-			m.rollbackInternal(ctx)
+			err = fmt.Errorf("validation failed for %s: %w", applierName, err)
+			m.captureSystemState(ctx, CaptureAfter)
+			m.rollbackBecause(ctx, err.Error(), applierName)
+			m.state = StateFailed
+			return err
+		}
+	}
+	m.captureSystemState(ctx, CaptureAfter)
+
+	// Both routers have the changes now: keep them on the canary
+	if canaryPending {
+		if err := m.finishCanary(ctx, canary, true); err != nil {
+			err = fmt.Errorf("failed to confirm the changes on canary %s: %w", canary.Name(), err)
+			m.rollbackBecause(ctx, err.Error(), "")
 			m.state = StateFailed
-			return fmt.Errorf("validation failed for %s: %w", applierName, err)
+			return err
 		}
+		canaryPending = false
 	}
 
-	// If confirm timeout is set, start confirmation timer
-	if confirmTimeout > 0 {
+	// If confirm timeout is set, or the client must keep sending keepalives,
+	// start confirmation timer
+	keepalive := keepaliveFromContext(ctx)
+	if confirmTimeout > 0 || keepalive > 0 {
 		m.state = StatePending
 		m.pendingConfirm = &pendingConfirmation{
 			Snapshot:  snapshot,
 			Timeout:   confirmTimeout,
 			StartTime: time.Now(),
+			Keepalive: keepalive,
+		}
+		if confirmTimeout == 0 {
+			// The first keepalive confirms, and must come within the window
+			m.pendingConfirm.Timeout = keepalive
+			m.pendingConfirm.KeepaliveConfirms = true
 		}
 
 		// Start confirmation timer in background with proper tracking
 		m.confirmCancelCh = make(chan struct{})
+		m.keepaliveCh = make(chan struct{}, 1)
+		cancelCh, keepaliveCh, probes, probeOpts := m.confirmCancelCh, m.keepaliveCh, m.probes, m.probeOpts
 		m.timerWg.Add(1)
 		go func() {
 			defer m.timerWg.Done()
-			m.confirmationTimer(confirmTimeout)
+			m.confirmationTimer(confirmTimeout, cancelCh, probes, probeOpts, keepalive, keepaliveCh)
 		}()
 
+		m.notifyConfirm(ConfirmPending, "")
 		return nil
 	}
 
 	// No confirmation needed, mark as completed
 	m.state = StateCompleted
+	m.touched = nil
 
 	// Update database transaction record
 	if db.DB != nil {
@@ -273,7 +514,7 @@ func (m *Manager) Commit(message string, confirmTimeout, overallTimeout time.Dur
 		_ = db.UpdateTransaction(m.currentTxRecord)
 
 		// Audit log: transaction completed
-		audit.LogSuccess(audit.ActionTxCommit, m.userID, m.username, txID, "Transaction completed successfully")
+		m.logAudit(audit.ActionTxCommit, audit.StatusSuccess, txID, "Transaction completed successfully", nil, nil)
 	}
 
 	bus.Publish(bus.Event{
@@ -281,13 +522,18 @@ func (m *Manager) Commit(message string, confirmTimeout, overallTimeout time.Dur
 		Data: changedConfigs,
 	})
 
+	if err := m.clearDegraded(m.auditCtx, m.userID, m.username, "Transaction completed"); err != nil {
+		logger.Warn("Failed to leave safe mode", "error", err)
+	}
+
 	logger.Info("Transaction completed successfully", "tx_id", txID)
 
 	return nil
 }
 
 // Confirm confirms pending changes
-func (m *Manager) Confirm() error {
+// The confirming user is read from ctx, falling back to the committing user
+func (m *Manager) Confirm(ctx context.Context) error {
 	m.mu.Lock()
 	defer m.mu.Unlock()
 
@@ -295,6 +541,13 @@ func (m *Manager) Confirm() error {
 		return fmt.Errorf("no pending confirmation (state: %s)", m.state)
 	}
 
+	m.confirmPending(ctx, "Transaction confirmed")
+	return nil
+}
+
+// confirmPending completes the pending transaction, auditing it with message
+// (must be called with lock held)
+func (m *Manager) confirmPending(ctx context.Context, message string) {
 	// Cancel the confirmation timer safely (prevents race condition)
 	if m.confirmCancelCh != nil {
 		util.SafeClose(m.confirmCancelCh)
@@ -304,6 +557,8 @@ func (m *Manager) Confirm() error {
 	// Mark as completed
 	m.state = StateCompleted
 	m.pendingConfirm = nil
+	m.touched = nil
+	m.notifyConfirm(ConfirmConfirmed, "")
 
 	// Update database transaction record
 	if db.DB != nil && m.currentTxRecord != nil {
@@ -314,7 +569,8 @@ func (m *Manager) Confirm() error {
 		_ = db.UpdateTransaction(m.currentTxRecord)
 
 		// Audit log: transaction confirmed
-		audit.LogSuccess(audit.ActionTxConfirm, m.userID, m.username, m.currentTxRecord.TxID, "Transaction confirmed")
+		userID, username, actx := m.actor(ctx)
+		audit.LogWithContext(actx, audit.ActionTxConfirm, audit.StatusSuccess, userID, username, m.currentTxRecord.TxID, message, nil, nil)
 	}
 
 	bus.Publish(bus.Event{
@@ -322,18 +578,94 @@ func (m *Manager) Confirm() error {
 		Data: "confirmed",
 	})
 
-	logger.Info("Transaction confirmed")
+	userID, username, actx := m.actor(ctx)
+	if err := m.clearDegraded(actx, userID, username, "Transaction confirmed"); err != nil {
+		logger.Warn("Failed to leave safe mode", "error", err)
+	}
 
-	return nil
+	logger.Info(message)
+}
+
+// RollbackPending rolls back the commit awaiting confirmation, rather than
+// waiting for its confirmation to time out
+func (m *Manager) RollbackPending(ctx context.Context) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.state != StatePending {
+		return fmt.Errorf("no pending confirmation (state: %s)", m.state)
+	}
+
+	if userID, username := audit.UserFromContext(ctx); username != "" {
+		m.userID, m.username = userID, username
+		m.auditCtx = auditOrigin(ctx)
+	}
+
+	reason, err := m.manualRollbackReason(ctx)
+	if err != nil {
+		return err
+	}
+
+	err = m.rollbackBecause(ctx, reason, "")
+	m.notifyConfirm(ConfirmRolledBack, RollbackReasonManual)
+	return err
 }
 
-// Rollback rolls back to the previous snapshot
-func (m *Manager) Rollback() error {
+// Rollback rolls back to a snapshot. An empty snapshotID rolls back to the
+// snapshot of the current transaction (or the latest snapshot); any other ID
+// is restored and re-applied as a transaction of its own (see rollbackTo).
+func (m *Manager) Rollback(ctx context.Context, snapshotID string) error {
 	m.mu.Lock()
 	defer m.mu.Unlock()
 
-	ctx := context.Background()
-	return m.rollbackInternal(ctx)
+	reason, err := m.manualRollbackReason(ctx)
+	if err != nil {
+		return err
+	}
+
+	if snapshotID != "" {
+		return m.rollbackTo(ctx, snapshotID, reason)
+	}
+
+	if userID, username := audit.UserFromContext(ctx); username != "" {
+		m.userID, m.username = userID, username
+		m.auditCtx = auditOrigin(ctx)
+	}
+
+	pending := m.state == StatePending
+	err = m.rollbackBecause(ctx, reason, "")
+	if pending {
+		m.notifyConfirm(ConfirmRolledBack, RollbackReasonManual)
+	}
+	return err
+}
+
+// actor returns the user from ctx, or the user that started the current
+// transaction, with the context to audit their actions in
+func (m *Manager) actor(ctx context.Context) (*uint, string, context.Context) {
+	if userID, username := audit.UserFromContext(ctx); username != "" {
+		return userID, username, auditOrigin(ctx)
+	}
+	return m.userID, m.username, m.auditCtx
+}
+
+// auditOrigin keeps only the impersonating admin and the request ID of ctx,
+// if any, so audit entries made after the request ended still name the admin
+// and can be traced back to the request that started the transaction
+func auditOrigin(ctx context.Context) context.Context {
+	origin := context.Background()
+	if id, username := audit.ImpersonatorFromContext(ctx); id != nil {
+		origin = audit.WithImpersonator(origin, *id, username)
+	}
+	if requestID := audit.RequestIDFromContext(ctx); requestID != "" {
+		origin = audit.WithRequestID(origin, requestID)
+	}
+	return origin
+}
+
+// logAudit records an audit entry for the user of the current transaction
+func (m *Manager) logAudit(action audit.Action, status audit.Status, resource, message string, details interface{}, err error) {
+	audit.LogWithContext(m.auditCtx, action, status, m.userID, m.username, resource, message, details, err)
 }
 
 // rollbackInternal performs the actual rollback (must be called with lock held)
@@ -351,19 +683,156 @@ func (m *Manager) rollbackInternal(ctx context.Context) error {
 		Type: bus.EventRollbackStarted,
 		Data: m.currentSnapshot.ID,
 	})
+	phaseStart := m.startPhase(ctx, PhaseRollback, "")
 
 	// Restore snapshot
 	if err := m.snapshotManager.Restore(m.currentSnapshot.ID); err != nil {
-		return fmt.Errorf("failed to restore snapshot: %w", err)
+		err = fmt.Errorf("failed to restore snapshot: %w", err)
+		m.endPhase(ctx, PhaseRollback, "", phaseStart, err)
+		m.enterSafeMode(err)
+		return err
+	}
+	if m.currentTxRecord != nil {
+		configChanged(m.currentTxRecord.TxID)
+	} else {
+		configChanged("")
+	}
+
+	// Undo applier changes, collecting errors
+	rollbackErrors, err := m.rollbackAppliers(ctx, m.currentSnapshot.Metadata.Configs)
+	if err != nil {
+		m.endPhase(ctx, PhaseRollback, "", phaseStart, err)
+		m.enterSafeMode(err)
+		return err
+	}
+
+	// Check if there were any errors
+	if len(rollbackErrors) > 0 {
+		m.state = StateFailed
+		err := fmt.Errorf("rollback partially failed: %s", strings.Join(rollbackErrors, "; "))
+		m.endPhase(ctx, PhaseRollback, "", phaseStart, err)
+		m.enterSafeMode(err)
+		return err
+	}
+	m.endPhase(ctx, PhaseRollback, "", phaseStart, nil)
+
+	rollbackEvent := RollbackEvent{SnapshotID: m.currentSnapshot.ID}
+	m.state = StateIdle
+	m.currentSnapshot = nil
+	m.pendingConfirm = nil
+
+	// Update database transaction record
+	if db.DB != nil && m.currentTxRecord != nil {
+		now := time.Now()
+		m.currentTxRecord.Status = StatusRolledBack
+		m.currentTxRecord.RolledBackAt = &now
+		_ = db.UpdateTransaction(m.currentTxRecord)
+
+		// Audit log: rollback completed
+		m.logAudit(audit.ActionTxRollback, audit.StatusSuccess, m.currentTxRecord.TxID, "Rollback completed successfully", nil, nil)
+	}
+
+	if m.currentTxRecord != nil {
+		rollbackEvent.TxID = m.currentTxRecord.TxID
+		rollbackEvent.Reason = m.currentTxRecord.RollbackReason
+		rollbackEvent.FailedApplier = m.currentTxRecord.FailedApplier
+	}
+	bus.Publish(bus.Event{
+		Type: bus.EventConfigReverted,
+		Data: rollbackEvent,
+	})
+
+	if err := m.clearDegraded(m.auditCtx, m.userID, m.username, "Rollback completed"); err != nil {
+		logger.Warn("Failed to leave safe mode", "error", err)
+	}
+
+	logger.Info("Rollback completed successfully")
+
+	return nil
+}
+
+// rollbackAppliers undoes the runtime changes made by the current transaction
+// (must be called with lock held, after the snapshot files have been restored).
+//
+// Appliers touched by the transaction are rolled back in reverse apply order
+// using their own Rollback. If an applier has no saved state or its Rollback
+// fails, the restored config is re-applied instead; this is only possible when
+// the snapshot holds a copy of that config. Snapshot configs that were not
+// touched are re-applied so the system matches the restored files.
+// Every failure is collected so one broken applier doesn't stop the others.
+func (m *Manager) rollbackAppliers(ctx context.Context, snapshotConfigs []string) ([]string, error) {
+	inSnapshot := make(map[string]bool, len(snapshotConfigs))
+	for _, name := range snapshotConfigs {
+		inSnapshot[name] = true
 	}
 
-	// Re-apply all configurations, collecting errors
 	var rollbackErrors []string
-	for _, configName := range m.currentSnapshot.Metadata.Configs {
+	handled := make(map[string]bool)
+
+	for i := len(m.touched) - 1; i >= 0; i-- {
+		name := m.touched[i]
+		if handled[name] {
+			continue
+		}
+		handled[name] = true
+
+		applier, ok := m.applierRegistry.Get(name)
+		if !ok {
+			continue
+		}
+
+		err := applier.Rollback(ctx)
+		if err == nil {
+			logger.Info("Applier rolled back", "applier", name)
+			continue
+		}
+
+		if !errors.Is(err, appliers.ErrNoRollbackState) {
+			logger.Warn("Applier rollback failed, re-applying restored config", "applier", name, "error", err)
+		}
+
+		if !inSnapshot[name] {
+			rollbackErrors = append(rollbackErrors,
+				fmt.Sprintf("%s: rollback failed and no snapshot copy to re-apply: %v", name, err))
+			continue
+		}
+
+		reapplyErrors, ctxErr := m.reapplyConfigs(ctx, PhaseRollback, []string{name})
+		if ctxErr != nil {
+			return rollbackErrors, ctxErr
+		}
+		rollbackErrors = append(rollbackErrors, reapplyErrors...)
+	}
+
+	var remaining []string
+	for _, name := range snapshotConfigs {
+		if !handled[name] {
+			remaining = append(remaining, name)
+		}
+	}
+
+	reapplyErrors, err := m.reapplyConfigs(ctx, PhaseRollback, remaining)
+	rollbackErrors = append(rollbackErrors, reapplyErrors...)
+	if err != nil {
+		return rollbackErrors, err
+	}
+
+	m.touched = nil
+	return rollbackErrors, nil
+}
+
+// reapplyConfigs loads and applies the given configs from disk, collecting
+// per-config errors instead of stopping at the first failure (must be called with lock held).
+// A non-nil error is only returned when the context is cancelled. stage is
+// the phase the pushed artifacts are archived under.
+func (m *Manager) reapplyConfigs(ctx context.Context, stage string, configs []string) ([]string, error) {
+	ctx = m.withNetwork(ctx)
+	var applyErrors []string
+	for _, configName := range configs {
 		// Check context cancellation
 		select {
 		case <-ctx.Done():
-			return ctx.Err()
+			return applyErrors, ctx.Err()
 		default:
 		}
 
@@ -372,72 +841,95 @@ func (m *Manager) rollbackInternal(ctx context.Context) error {
 			continue
 		}
 
-		// Load config
-		cfg, err := m.configManager.Load(configName)
+		// Load config, with its secrets decrypted for the applier
+		cfg, err := m.configManager.LoadDecrypted(configName)
 		if err != nil {
-			rollbackErrors = append(rollbackErrors,
+			applyErrors = append(applyErrors,
 				fmt.Sprintf("%s: failed to load: %v", configName, err))
 			continue
 		}
 
 		// Apply
+		m.archiveArtifacts(ctx, stage, configName, cfg)
 		if err := applier.Apply(ctx, cfg); err != nil {
-			rollbackErrors = append(rollbackErrors,
+			applyErrors = append(applyErrors,
 				fmt.Sprintf("%s: failed to apply: %v", configName, err))
 			continue
 		}
 	}
 
-	// Check if there were any errors
-	if len(rollbackErrors) > 0 {
-		m.state = StateFailed
-		return fmt.Errorf("rollback partially failed: %s", strings.Join(rollbackErrors, "; "))
-	}
-
-	m.state = StateIdle
-	m.currentSnapshot = nil
-	m.pendingConfirm = nil
+	return applyErrors, nil
+}
 
-	// Update database transaction record
-	if db.DB != nil && m.currentTxRecord != nil {
-		now := time.Now()
-		m.currentTxRecord.Status = "rolledback"
-		m.currentTxRecord.RolledBackAt = &now
-		_ = db.UpdateTransaction(m.currentTxRecord)
+// confirmationTimer waits for timeout (if any) and auto-rollback if not
+// confirmed. Meanwhile the connectivity probes run every interval; after
+// opts.Failures failed rounds in a row the changes are rolled back early.
+// With a keepalive window, they are also rolled back once no keepalive
+// arrives on keepaliveCh for that long.
+func (m *Manager) confirmationTimer(timeout time.Duration, cancelCh chan struct{}, probes []probe.Probe, opts probe.Options,
+	keepalive time.Duration, keepaliveCh chan struct{}) {
+	var timeoutC <-chan time.Time
+	if timeout > 0 {
+		timer := time.NewTimer(timeout)
+		defer timer.Stop()
+		timeoutC = timer.C
+	}
 
-		// Audit log: rollback completed
-		audit.LogSuccess(audit.ActionTxRollback, m.userID, m.username, m.currentTxRecord.TxID, "Rollback completed successfully")
+	var keepaliveTimer *time.Timer
+	var keepaliveC <-chan time.Time
+	if keepalive > 0 {
+		keepaliveTimer = time.NewTimer(keepalive)
+		defer keepaliveTimer.Stop()
+		keepaliveC = keepaliveTimer.C
 	}
 
-	bus.Publish(bus.Event{
-		Type: bus.EventConfigReverted,
-		Data: "rollback completed",
-	})
+	var probeTimer *time.Timer
+	var probeC <-chan time.Time
+	if len(probes) > 0 {
+		probeTimer = time.NewTimer(opts.Delay)
+		defer probeTimer.Stop()
+		probeC = probeTimer.C
+	}
+	failures := 0
 
-	logger.Info("Rollback completed successfully")
+	for {
+		select {
+		case <-timeoutC:
+			// Timeout reached, rollback
+			m.mu.Lock()
+			if m.state == StatePending && m.confirmCancelCh == cancelCh {
+				logger.Warn("Confirmation timeout reached, rolling back changes...")
+				ctx := context.Background()
+				_ = m.rollbackBecause(ctx, fmt.Sprintf("not confirmed within %s", timeout), "")
+				m.notifyConfirm(ConfirmRolledBack, RollbackReasonTimeout)
+			}
+			m.mu.Unlock()
+			return
+
+		case <-probeC:
+			results, ok := m.runProbes(cancelCh, probes, opts)
+			if ok {
+				failures = 0
+			} else {
+				failures++
+			}
+			if failures >= opts.Failures {
+				m.rollbackAfterProbes(cancelCh, results)
+				return
+			}
+			probeTimer.Reset(opts.Interval)
 
-	return nil
-}
+		case <-keepaliveC:
+			m.rollbackAfterKeepalive(cancelCh, keepalive)
+			return
 
-// confirmationTimer waits for timeout and auto-rollback if not confirmed
-func (m *Manager) confirmationTimer(timeout time.Duration) {
-	timer := time.NewTimer(timeout)
-	defer timer.Stop()
+		case <-keepaliveCh:
+			keepaliveTimer.Reset(keepalive)
 
-	select {
-	case <-timer.C:
-		// Timeout reached, rollback
-		m.mu.Lock()
-		if m.state == StatePending {
-			logger.Warn("Confirmation timeout reached, rolling back changes...")
-			ctx := context.Background()
-			_ = m.rollbackInternal(ctx)
+		case <-cancelCh:
+			// Confirmation received, do nothing
+			return
 		}
-		m.mu.Unlock()
-
-	case <-m.confirmCancelCh:
-		// Confirmation received, do nothing
-		return
 	}
 }
 
@@ -448,6 +940,17 @@ func (m *Manager) GetState() State {
 	return m.state
 }
 
+// TxID returns the ID of the current or last transaction
+func (m *Manager) TxID() string {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	if m.currentTxRecord == nil {
+		return ""
+	}
+	return m.currentTxRecord.TxID
+}
+
 // GetPendingConfirmation returns pending confirmation info if any
 func (m *Manager) GetPendingConfirmation() *pendingConfirmation {
 	m.mu.RLock()
@@ -0,0 +1,87 @@
+package transaction
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/thesabbir/hellfire/pkg/audit"
+	"github.com/thesabbir/hellfire/pkg/bus"
+	"github.com/thesabbir/hellfire/pkg/db"
+	"github.com/thesabbir/hellfire/pkg/logger"
+	"github.com/thesabbir/hellfire/pkg/probe"
+)
+
+// SetProbes sets the connectivity probes run while a commit awaits
+// confirmation; the commit is rolled back as soon as they keep failing
+// instead of when the confirmation times out (nil disables probing)
+func (m *Manager) SetProbes(probes []probe.Probe, opts probe.Options) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.probes = probes
+	m.probeOpts = opts
+}
+
+// runProbes runs one round of probes, stopping early if the commit is confirmed
+func (m *Manager) runProbes(cancelCh chan struct{}, probes []probe.Probe, opts probe.Options) ([]probe.Result, bool) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() {
+		select {
+		case <-cancelCh:
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+
+	results, ok := probe.Run(ctx, probes, opts.Timeout)
+
+	m.mu.Lock()
+	if m.pendingConfirm != nil && m.confirmCancelCh == cancelCh {
+		m.pendingConfirm.Probes = results
+	}
+	m.mu.Unlock()
+
+	if !ok && ctx.Err() == nil {
+		logger.Warn("Connectivity probe failed while awaiting confirmation", "failed", failedProbes(results))
+		bus.Publish(bus.Event{
+			Type: bus.EventProbeFailed,
+			Data: results,
+		})
+	}
+
+	return results, ok
+}
+
+// rollbackAfterProbes rolls back a pending commit whose probes kept failing
+func (m *Manager) rollbackAfterProbes(cancelCh chan struct{}, results []probe.Result) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	// Confirmed (or rolled back) in the meantime
+	if m.state != StatePending || m.confirmCancelCh != cancelCh {
+		return
+	}
+
+	err := fmt.Errorf("connectivity probes failed: %s", failedProbes(results))
+	logger.Warn("Connectivity probes keep failing, rolling back changes...", "error", err)
+
+	if db.DB != nil && m.currentTxRecord != nil {
+		m.logAudit(audit.ActionTxRollback, audit.StatusFailure, m.currentTxRecord.TxID,
+			"Rolling back unconfirmed changes after failed connectivity probes", nil, err)
+	}
+
+	_ = m.rollbackBecause(context.Background(), err.Error(), "")
+	m.notifyConfirm(ConfirmRolledBack, RollbackReasonProbes)
+}
+
+// failedProbes describes the failed probes of a round
+func failedProbes(results []probe.Result) string {
+	var failed []string
+	for _, result := range results {
+		if !result.OK {
+			failed = append(failed, fmt.Sprintf("%s (%s)", result.Name, result.Error))
+		}
+	}
+	return strings.Join(failed, ", ")
+}
@@ -0,0 +1,90 @@
+package transaction
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+
+	"github.com/thesabbir/hellfire/pkg/bus"
+)
+
+// PhaseRollback is reported while a failed transaction is being rolled back
+const PhaseRollback = "rollback"
+
+// Progress statuses
+const (
+	ProgressStarted  = "started"
+	ProgressFinished = "finished"
+	ProgressFailed   = "failed"
+)
+
+// ProgressEvent describes a step of a running transaction
+type ProgressEvent struct {
+	TxID    string    `json:"transaction_id"`
+	Phase   string    `json:"phase"`             // One of the Phase* constants
+	Applier string    `json:"applier,omitempty"` // Set for apply/validate phases
+	Status  string    `json:"status"`            // ProgressStarted, ProgressFinished or ProgressFailed
+	Error   string    `json:"error,omitempty"`
+	Time    time.Time `json:"time"`
+}
+
+// ProgressFunc receives progress events synchronously, in order
+type ProgressFunc func(ProgressEvent)
+
+type progressKey struct{}
+
+// WithProgress returns a context that reports transaction progress to fn.
+// fn is called while the manager holds its lock and must not call back into it.
+func WithProgress(ctx context.Context, fn ProgressFunc) context.Context {
+	return context.WithValue(ctx, progressKey{}, fn)
+}
+
+// startPhase reports a phase as started and returns its start time (must be called with lock held)
+func (m *Manager) startPhase(ctx context.Context, phase, applier string) time.Time {
+	m.reportProgress(ctx, phase, applier, ProgressStarted, nil)
+	return time.Now()
+}
+
+// endPhase records the phase duration and reports it as finished or failed (must be called with lock held)
+func (m *Manager) endPhase(ctx context.Context, phase, applier string, start time.Time, err error) {
+	if m.phases == nil {
+		m.phases = make(map[string]int64)
+	}
+	m.phases[phase] += time.Since(start).Milliseconds()
+
+	if m.currentTxRecord != nil {
+		phasesJSON, _ := json.Marshal(m.phases)
+		m.currentTxRecord.Phases = string(phasesJSON)
+	}
+
+	status := ProgressFinished
+	if err != nil {
+		status = ProgressFailed
+	}
+	m.reportProgress(ctx, phase, applier, status, err)
+}
+
+// reportProgress sends a progress event to the context callback (if any) and the event bus
+func (m *Manager) reportProgress(ctx context.Context, phase, applier, status string, err error) {
+	event := ProgressEvent{
+		Phase:   phase,
+		Applier: applier,
+		Status:  status,
+		Time:    time.Now(),
+	}
+	if m.currentTxRecord != nil {
+		event.TxID = m.currentTxRecord.TxID
+	}
+	if err != nil {
+		event.Error = err.Error()
+	}
+
+	if fn, ok := ctx.Value(progressKey{}).(ProgressFunc); ok && fn != nil {
+		fn(event)
+	}
+
+	bus.Publish(bus.Event{
+		Type: bus.EventTransactionProgress,
+		Data: event,
+	})
+}
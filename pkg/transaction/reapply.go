@@ -0,0 +1,47 @@
+package transaction
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/thesabbir/hellfire/pkg/appliers"
+	"github.com/thesabbir/hellfire/pkg/logger"
+)
+
+// Reapply applies committed configs again, as they are on disk, when the
+// system changed rather than the configs: an interface they configure
+// appeared. Staged changes are left staged. It is refused while a
+// transaction is in progress or awaiting confirmation, whose own apply or
+// rollback decides what is applied.
+func (m *Manager) Reapply(ctx context.Context, configs []string, reason string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.state == StateInProgress || m.state == StatePending {
+		return fmt.Errorf("transaction in progress (state: %s)", m.state)
+	}
+
+	if network, err := m.configManager.LoadCommitted("network"); err == nil {
+		ctx = appliers.WithNetworkConfig(ctx, network)
+	}
+
+	logger.Info("Re-applying configs", "configs", configs, "reason", reason)
+	var errs []error
+	for _, name := range m.applyPlan(configs) {
+		applier, _ := m.applierRegistry.Get(name)
+		cfg, err := m.configManager.LoadCommittedDecrypted(name)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%s: failed to load: %w", name, err))
+			continue
+		}
+		if err := applier.Apply(ctx, cfg); err != nil {
+			errs = append(errs, fmt.Errorf("%s: failed to apply: %w", name, err))
+			continue
+		}
+		if err := applier.Validate(ctx); err != nil {
+			errs = append(errs, fmt.Errorf("%s: validation failed: %w", name, err))
+		}
+	}
+	return errors.Join(errs...)
+}
@@ -0,0 +1,68 @@
+package transaction
+
+import (
+	"context"
+	"errors"
+	"strings"
+
+	"github.com/thesabbir/hellfire/pkg/audit"
+	"github.com/thesabbir/hellfire/pkg/db"
+)
+
+// ErrRollbackReasonRequired is returned by manual rollbacks without a reason
+// when SetRequireRollbackReason requires one
+var ErrRollbackReasonRequired = errors.New("a reason is required to roll back")
+
+// RollbackEvent is the data of the config.reverted event of a rollback, for
+// alerting on why it happened
+type RollbackEvent struct {
+	TxID          string `json:"transaction_id,omitempty"`
+	SnapshotID    string `json:"snapshot_id"`
+	Reason        string `json:"reason,omitempty"`
+	FailedApplier string `json:"failed_applier,omitempty"`
+}
+
+type rollbackReasonKey struct{}
+
+// WithRollbackReason returns a context for a manual rollback, recorded on the
+// transaction with the reason the user gave for it
+func WithRollbackReason(ctx context.Context, reason string) context.Context {
+	return context.WithValue(ctx, rollbackReasonKey{}, strings.TrimSpace(reason))
+}
+
+// SetRequireRollbackReason makes manual rollbacks fail with
+// ErrRollbackReasonRequired unless their context carries a reason
+func (m *Manager) SetRequireRollbackReason(require bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.requireReason = require
+}
+
+// manualRollbackReason returns the reason of a manual rollback from ctx, or
+// who asked for it if none was given (must be called with lock held)
+func (m *Manager) manualRollbackReason(ctx context.Context) (string, error) {
+	if reason, _ := ctx.Value(rollbackReasonKey{}).(string); reason != "" {
+		return reason, nil
+	}
+	if m.requireReason {
+		return "", ErrRollbackReasonRequired
+	}
+	if _, username := audit.UserFromContext(ctx); username != "" {
+		return "rolled back by " + username, nil
+	}
+	return "rolled back by hand", nil
+}
+
+// rollbackBecause rolls back like rollbackInternal, recording why on the
+// transaction: the reason, such as the error that triggered it, and the
+// applier that failed, if any (must be called with lock held)
+func (m *Manager) rollbackBecause(ctx context.Context, reason, failedApplier string) error {
+	if m.currentTxRecord != nil {
+		m.currentTxRecord.RollbackReason = reason
+		m.currentTxRecord.FailedApplier = failedApplier
+		if db.DB != nil {
+			_ = db.UpdateTransaction(m.currentTxRecord)
+		}
+	}
+	return m.rollbackInternal(ctx)
+}
@@ -0,0 +1,173 @@
+package transaction
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/thesabbir/hellfire/pkg/appliers"
+	"github.com/thesabbir/hellfire/pkg/audit"
+	"github.com/thesabbir/hellfire/pkg/bus"
+	"github.com/thesabbir/hellfire/pkg/db"
+	"github.com/thesabbir/hellfire/pkg/logger"
+	"github.com/thesabbir/hellfire/pkg/util"
+)
+
+// recoveryTimeout bounds applying the recovery network, which runs after
+// the transaction's own context may already have expired
+const recoveryTimeout = 30 * time.Second
+
+// DegradedStatus describes why the system is in safe mode
+type DegradedStatus struct {
+	Since           time.Time `json:"since"`
+	Reason          string    `json:"reason"`
+	TxID            string    `json:"transaction_id,omitempty"`
+	RecoveryApplied bool      `json:"recovery_applied"`         // The recovery network was applied
+	RecoveryError   string    `json:"recovery_error,omitempty"` // Why it could not be applied
+}
+
+// SetRecovery sets the network applied when a rollback fails (nil only flags
+// the system as degraded) and the file the degraded flag is persisted to, so
+// it survives restarts and is seen by every process ("" keeps it in memory)
+func (m *Manager) SetRecovery(recovery *appliers.RecoveryConfig, stateFile string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.recovery = recovery
+	m.degradedFile = stateFile
+}
+
+// Degraded returns the safe mode status, or nil if the system is not degraded
+func (m *Manager) Degraded() *DegradedStatus {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return m.loadDegraded()
+}
+
+// ClearDegraded leaves safe mode once the operator has restored a working
+// configuration. The user is read from ctx.
+func (m *Manager) ClearDegraded(ctx context.Context) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.loadDegraded() == nil {
+		return fmt.Errorf("system is not degraded")
+	}
+
+	userID, username, actx := m.actor(ctx)
+	return m.clearDegraded(actx, userID, username, "Degraded flag cleared")
+}
+
+// loadDegraded reads the degraded flag (must be called with lock held)
+func (m *Manager) loadDegraded() *DegradedStatus {
+	if m.degradedFile == "" {
+		return m.degraded
+	}
+
+	data, err := os.ReadFile(m.degradedFile)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			logger.Warn("Failed to read degraded state", "path", m.degradedFile, "error", err)
+			return m.degraded
+		}
+		return nil
+	}
+
+	var status DegradedStatus
+	if err := json.Unmarshal(data, &status); err != nil {
+		logger.Warn("Invalid degraded state file", "path", m.degradedFile, "error", err)
+		return m.degraded
+	}
+	return &status
+}
+
+// enterSafeMode applies the recovery network after a failed rollback and
+// flags the system as degraded (must be called with lock held)
+func (m *Manager) enterSafeMode(cause error) {
+	status := &DegradedStatus{
+		Since:  time.Now(),
+		Reason: cause.Error(),
+	}
+	if m.currentTxRecord != nil {
+		status.TxID = m.currentTxRecord.TxID
+	}
+
+	logger.Error("Rollback failed, entering safe mode", "error", cause)
+
+	if m.recovery != nil {
+		ctx, cancel := context.WithTimeout(context.Background(), recoveryTimeout)
+		err := appliers.ApplyRecovery(ctx, *m.recovery)
+		cancel()
+
+		if err != nil {
+			status.RecoveryError = err.Error()
+			logger.Error("Failed to apply recovery network", "error", err)
+		} else {
+			status.RecoveryApplied = true
+			logger.Warn("Recovery network applied, system is degraded until the configuration is fixed",
+				"interface", m.recovery.Interface,
+				"address", m.recovery.IPAddr)
+		}
+	}
+
+	m.degraded = status
+	if err := m.saveDegraded(status); err != nil {
+		logger.Error("Failed to persist degraded state", "path", m.degradedFile, "error", err)
+	}
+
+	if db.DB != nil {
+		m.logAudit(audit.ActionSystemRecovery, audit.StatusFailure, status.TxID,
+			"Rollback failed, system entered safe mode", status, nil)
+	}
+
+	bus.Publish(bus.Event{
+		Type: bus.EventSystemDegraded,
+		Data: status,
+	})
+}
+
+// clearDegraded leaves safe mode if the system is degraded, audited in
+// actx (must be called with lock held)
+func (m *Manager) clearDegraded(actx context.Context, userID *uint, username, message string) error {
+	if m.loadDegraded() == nil {
+		return nil
+	}
+
+	m.degraded = nil
+	if m.degradedFile != "" {
+		if err := os.Remove(m.degradedFile); err != nil && !os.IsNotExist(err) {
+			return fmt.Errorf("failed to clear degraded state: %w", err)
+		}
+	}
+
+	logger.Info("System left safe mode", "reason", message)
+	if db.DB != nil {
+		audit.LogWithContext(actx, audit.ActionSystemRecovery, audit.StatusSuccess, userID, username, "", message, nil, nil)
+	}
+
+	bus.Publish(bus.Event{
+		Type: bus.EventSystemRecovered,
+		Data: message,
+	})
+
+	return nil
+}
+
+// saveDegraded persists the degraded flag (must be called with lock held)
+func (m *Manager) saveDegraded(status *DegradedStatus) error {
+	if m.degradedFile == "" {
+		return nil
+	}
+
+	data, err := json.MarshalIndent(status, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(filepath.Dir(m.degradedFile), 0750); err != nil {
+		return err
+	}
+	return util.WriteFileAtomic(m.degradedFile, data, 0640)
+}
@@ -0,0 +1,90 @@
+package transaction
+
+import (
+	"encoding/json"
+	"fmt"
+	"maps"
+	"slices"
+
+	"github.com/thesabbir/hellfire/pkg/config"
+	"github.com/thesabbir/hellfire/pkg/db"
+	"github.com/thesabbir/hellfire/pkg/logger"
+)
+
+// Changes are the staged changes of a transaction, recorded so it can be
+// retried after a failure
+type Changes struct {
+	// Checksums of the committed configs the operations were made against
+	Base       map[string]string  `json:"base"`
+	Operations []config.Operation `json:"operations"`
+}
+
+// recordChanges records the staged changes of the configs on the current
+// transaction (must be called with lock held)
+func (m *Manager) recordChanges(configs []string) {
+	changes := Changes{Base: make(map[string]string, len(configs))}
+	for _, name := range configs {
+		checksum, err := m.configManager.CommittedChecksum(name)
+		if err != nil {
+			logger.Warn("Failed to record the changes of the transaction", "config", name, "error", err)
+			return
+		}
+		ops, err := m.configManager.SealedOperations(name)
+		if err != nil {
+			logger.Warn("Failed to record the changes of the transaction", "config", name, "error", err)
+			return
+		}
+		changes.Base[name] = checksum
+		changes.Operations = append(changes.Operations, ops...)
+	}
+
+	data, err := json.Marshal(changes)
+	if err != nil {
+		logger.Warn("Failed to record the changes of the transaction", "error", err)
+		return
+	}
+	m.currentTxRecord.Changes = string(data)
+}
+
+// StageRetry stages the recorded changes of a failed or rolled back
+// transaction again, to commit them as a new one. The configs they change
+// must not have changed since, unless force is set, and nothing else may be
+// staged.
+func (m *Manager) StageRetry(txID string, force bool) (*db.Transaction, error) {
+	tx, err := db.GetTransactionByID(txID)
+	if err != nil {
+		return nil, fmt.Errorf("transaction not found: %w", err)
+	}
+	if tx.Status != string(StateFailed) && tx.Status != StatusRolledBack {
+		return nil, fmt.Errorf("transaction %s is %s, only failed and rolled back transactions can be retried", txID, tx.Status)
+	}
+	if tx.Changes == "" {
+		return nil, fmt.Errorf("transaction %s has no recorded changes to retry", txID)
+	}
+
+	var changes Changes
+	if err := json.Unmarshal([]byte(tx.Changes), &changes); err != nil {
+		return nil, fmt.Errorf("failed to decode the changes of transaction %s: %w", txID, err)
+	}
+
+	if m.configManager.HasChanges() {
+		return nil, fmt.Errorf("staged changes exist, revert or commit them before retrying")
+	}
+
+	if !force {
+		for _, name := range slices.Sorted(maps.Keys(changes.Base)) {
+			checksum, err := m.configManager.CommittedChecksum(name)
+			if err != nil {
+				return nil, err
+			}
+			if checksum != changes.Base[name] {
+				return nil, fmt.Errorf("%s changed since transaction %s, retry with force to apply its changes anyway", name, txID)
+			}
+		}
+	}
+
+	if _, err := m.configManager.Batch(changes.Operations); err != nil {
+		return nil, fmt.Errorf("failed to stage the changes of transaction %s: %w", txID, err)
+	}
+	return tx, nil
+}
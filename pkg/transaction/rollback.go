@@ -0,0 +1,158 @@
+package transaction
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/thesabbir/hellfire/pkg/audit"
+	"github.com/thesabbir/hellfire/pkg/bus"
+	"github.com/thesabbir/hellfire/pkg/db"
+	"github.com/thesabbir/hellfire/pkg/logger"
+	"github.com/thesabbir/hellfire/pkg/util"
+)
+
+// StatusRolledBack is the transaction record status for a rolled back transaction
+const StatusRolledBack = "rolledback"
+
+// rollbackTo restores and re-applies an arbitrary snapshot as a transaction
+// with its own database record and audit trail (must be called with lock held).
+//
+// The current on-disk state of the affected configs is snapshotted first, so
+// if re-applying the chosen snapshot fails the previous state is restored and
+// re-applied on a best-effort basis. The reason is recorded on the transaction.
+func (m *Manager) rollbackTo(ctx context.Context, snapshotID, reason string) error {
+	if m.state == StateInProgress || m.state == StatePending {
+		return fmt.Errorf("transaction already in progress (state: %s)", m.state)
+	}
+
+	if m.configManager.HasChanges() {
+		return fmt.Errorf("staged changes exist, revert or commit them before rolling back")
+	}
+
+	target, err := m.snapshotManager.Load(snapshotID)
+	if err != nil {
+		return err
+	}
+
+	if err := m.snapshotManager.ValidateSnapshot(target); err != nil {
+		return fmt.Errorf("snapshot validation failed: %w", err)
+	}
+
+	m.userID, m.username = audit.UserFromContext(ctx)
+	m.auditCtx = auditOrigin(ctx)
+	m.state = StateInProgress
+
+	txID := util.GenerateUniqueID()
+	message := fmt.Sprintf("Rollback to snapshot %s", target.ID)
+	configsJSON, _ := json.Marshal(target.Metadata.Configs)
+
+	m.currentTxRecord = &db.Transaction{
+		TxID:           txID,
+		UserID:         m.userID,
+		Username:       m.username,
+		Message:        message,
+		Status:         string(StateInProgress),
+		SnapshotID:     target.ID,
+		Configs:        string(configsJSON),
+		RollbackReason: reason,
+	}
+
+	if db.DB != nil {
+		if err := db.CreateTransaction(m.currentTxRecord); err != nil {
+			logger.Warn("Failed to create transaction record", "error", err)
+		}
+		m.logAudit(audit.ActionTxStart, audit.StatusSuccess, txID, message, nil, nil)
+	}
+
+	bus.Publish(bus.Event{
+		Type: bus.EventRollbackStarted,
+		Data: target.ID,
+	})
+
+	// Snapshot the current state so this rollback can itself be undone
+	previous, err := m.snapshotManager.Create(fmt.Sprintf("Before rollback to %s", target.ID), target.Metadata.Configs)
+	if err != nil {
+		return m.failRollback(txID, fmt.Errorf("failed to snapshot current state: %w", err))
+	}
+
+	if err := m.snapshotManager.Restore(target.ID); err != nil {
+		return m.failRollback(txID, fmt.Errorf("failed to restore snapshot: %w", err))
+	}
+	configChanged(txID)
+
+	applyErrors, err := m.reapplyConfigs(ctx, PhaseApply, m.applyPlan(target.Metadata.Configs))
+	if err == nil && len(applyErrors) > 0 {
+		err = fmt.Errorf("rollback partially failed: %s", strings.Join(applyErrors, "; "))
+	}
+
+	if err != nil {
+		// Put the previous state back
+		logger.Error("Rollback to snapshot failed, restoring previous state",
+			"snapshot", target.ID,
+			"previous", previous.ID,
+			"error", err)
+
+		if restoreErr := m.snapshotManager.Restore(previous.ID); restoreErr != nil {
+			logger.Error("Failed to restore previous state", "error", restoreErr)
+			m.enterSafeMode(fmt.Errorf("failed to restore previous state: %w", restoreErr))
+		} else {
+			configChanged(txID)
+			if revertErrors, _ := m.reapplyConfigs(context.Background(), PhaseRollback, m.applyPlan(previous.Metadata.Configs)); len(revertErrors) > 0 {
+				logger.Error("Failed to re-apply previous state", "errors", strings.Join(revertErrors, "; "))
+				m.enterSafeMode(fmt.Errorf("failed to re-apply previous state: %s", strings.Join(revertErrors, "; ")))
+			}
+		}
+
+		return m.failRollback(txID, err)
+	}
+
+	m.state = StateIdle
+	m.currentSnapshot = nil
+
+	if db.DB != nil {
+		now := time.Now()
+		m.currentTxRecord.Status = string(StateCompleted)
+		m.currentTxRecord.CompletedAt = &now
+		_ = db.UpdateTransaction(m.currentTxRecord)
+
+		m.logAudit(audit.ActionSnapshotRestore, audit.StatusSuccess, target.ID,
+			fmt.Sprintf("Snapshot restored and applied (tx %s)", txID), nil, nil)
+		m.logAudit(audit.ActionTxRollback, audit.StatusSuccess, txID, message, nil, nil)
+	}
+
+	bus.Publish(bus.Event{
+		Type: bus.EventConfigReverted,
+		Data: target.ID,
+	})
+
+	if err := m.clearDegraded(m.auditCtx, m.userID, m.username, "Rolled back to snapshot "+target.ID); err != nil {
+		logger.Warn("Failed to leave safe mode", "error", err)
+	}
+
+	logger.Info("Rollback to snapshot completed", "snapshot", target.ID, "tx_id", txID)
+
+	return nil
+}
+
+// failRollback records a failed snapshot rollback (must be called with lock held)
+func (m *Manager) failRollback(txID string, err error) error {
+	m.state = StateFailed
+
+	if db.DB != nil && m.currentTxRecord != nil {
+		m.currentTxRecord.Status = string(StateFailed)
+		m.currentTxRecord.Error = err.Error()
+		_ = db.UpdateTransaction(m.currentTxRecord)
+
+		m.logAudit(audit.ActionTxRollback, audit.StatusFailure, txID, "Rollback to snapshot failed", nil, err)
+	}
+
+	bus.Publish(bus.Event{
+		Type: bus.EventTransactionFailed,
+		Data: err.Error(),
+	})
+
+	return err
+}
@@ -0,0 +1,61 @@
+package transaction
+
+import (
+	"context"
+	"reflect"
+	"slices"
+
+	"github.com/thesabbir/hellfire/pkg/appliers"
+)
+
+// killSwitchesChanged reports whether the staged network config changes the
+// VPN kill switches of the committed one, which the firewall enforces (must
+// be called with lock held)
+func (m *Manager) killSwitchesChanged() bool {
+	if !slices.Contains(m.configManager.GetChanges(), "network") || slices.Contains(m.skipApply, "network") {
+		return false
+	}
+	staged, err := m.configManager.Load("network")
+	if err != nil {
+		return false
+	}
+	committed, err := m.configManager.LoadCommitted("network")
+	if err != nil {
+		return true
+	}
+	before, _ := appliers.VPNPolicies(committed)
+	after, _ := appliers.VPNPolicies(staged)
+	return !reflect.DeepEqual(killSwitches(before), killSwitches(after))
+}
+
+// killSwitches returns the policies with a kill switch
+func killSwitches(policies []appliers.VPNPolicy) []appliers.VPNPolicy {
+	return slices.DeleteFunc(policies, func(p appliers.VPNPolicy) bool { return !p.KillSwitch })
+}
+
+// vpnPlan moves the firewall ahead of the network in a plan changing the VPN
+// kill switches, adding it when its own config is unchanged, so the kill
+// switch rules are loaded before traffic is rerouted and none leaks around
+// the tunnel while the network is applied (must be called with lock held)
+func (m *Manager) vpnPlan(plan []string, killSwitchesChanged bool) []string {
+	if !killSwitchesChanged || !slices.Contains(plan, "network") || slices.Contains(m.skipApply, "firewall") {
+		return plan
+	}
+	if _, ok := m.applierRegistry.Get("firewall"); !ok {
+		return plan
+	}
+
+	plan = slices.DeleteFunc(slices.Clone(plan), func(name string) bool { return name == "firewall" })
+	return slices.Insert(plan, slices.Index(plan, "network"), "firewall")
+}
+
+// withNetwork passes the network config, staged or committed, to the
+// appliers of other configs that generate rules from it (must be called
+// with lock held)
+func (m *Manager) withNetwork(ctx context.Context) context.Context {
+	network, err := m.configManager.LoadExpanded("network")
+	if err != nil {
+		return ctx
+	}
+	return appliers.WithNetworkConfig(ctx, network)
+}
@@ -2,183 +2,292 @@ package uci
 
 import (
 	"bufio"
+	"bytes"
 	"fmt"
 	"io"
+	"iter"
+	"sort"
 	"strings"
 )
 
+// maxLineLength is the longest line the parser accepts
+const maxLineLength = 1 << 20
+
+var (
+	prefixConfig = []byte("config ")
+	prefixOption = []byte("option ")
+	prefixList   = []byte("list ")
+)
+
 // Parse parses a UCI configuration from a reader
 func Parse(r io.Reader) (*Config, error) {
 	config := NewConfig()
+
+	for section, err := range Sections(r) {
+		if err != nil {
+			return nil, err
+		}
+		config.AddSection(section)
+	}
+
+	return config, nil
+}
+
+// Sections parses a UCI configuration from a reader and yields one section
+// at a time, so large configs can be processed without holding all of them
+// in memory. A parse error is yielded with a nil section and ends the iteration.
+func Sections(r io.Reader) iter.Seq2[*Section, error] {
+	return func(yield func(*Section, error) bool) {
+		p := newParser(r)
+		for {
+			section, err := p.next()
+			if err != nil {
+				yield(nil, err)
+				return
+			}
+			if section == nil || !yield(section, nil) {
+				return
+			}
+		}
+	}
+}
+
+// parser reads sections line by line. Option keys and section types repeat
+// across sections, so they are interned to keep large configs small.
+type parser struct {
+	scanner  *bufio.Scanner
+	lineNum  int
+	current  *Section
+	parts    []string
+	buf      []byte
+	interned map[string]string
+}
+
+func newParser(r io.Reader) *parser {
 	scanner := bufio.NewScanner(r)
+	scanner.Buffer(nil, maxLineLength)
 
-	var currentSection *Section
-	lineNum := 0
+	return &parser{
+		scanner:  scanner,
+		parts:    make([]string, 0, 4),
+		interned: make(map[string]string),
+	}
+}
 
-	for scanner.Scan() {
-		lineNum++
-		line := scanner.Text()
+// next returns the next complete section, or nil at the end of the input
+func (p *parser) next() (*Section, error) {
+	for p.scanner.Scan() {
+		p.lineNum++
 
 		// Trim whitespace
-		line = strings.TrimSpace(line)
+		line := bytes.TrimSpace(p.scanner.Bytes())
 
 		// Skip empty lines and comments
-		if line == "" || strings.HasPrefix(line, "#") {
+		if len(line) == 0 || line[0] == '#' {
 			continue
 		}
 
-		// Parse config line
-		if strings.HasPrefix(line, "config ") {
-			// Save previous section if exists
-			if currentSection != nil {
-				config.AddSection(currentSection)
-			}
-
+		switch {
+		case bytes.HasPrefix(line, prefixConfig):
 			// Parse: config <type> ['name']
-			parts := parseQuotedLine(line[7:]) // Skip "config "
+			parts := p.split(line[len(prefixConfig):])
 			if len(parts) < 1 {
-				return nil, fmt.Errorf("line %d: invalid config line", lineNum)
+				return nil, fmt.Errorf("line %d: invalid config line", p.lineNum)
 			}
 
-			sectionType := parts[0]
 			sectionName := ""
 			if len(parts) > 1 {
 				sectionName = parts[1]
 			}
 
-			currentSection = NewSection(sectionType, sectionName)
-			continue
-		}
+			// The previous section is complete
+			previous := p.current
+			p.current = NewSection(p.intern(parts[0]), sectionName)
+			if previous != nil {
+				return previous, nil
+			}
 
-		// Parse option line
-		if strings.HasPrefix(line, "option ") {
-			if currentSection == nil {
-				return nil, fmt.Errorf("line %d: option outside of section", lineNum)
+		case bytes.HasPrefix(line, prefixOption):
+			if p.current == nil {
+				return nil, fmt.Errorf("line %d: option outside of section", p.lineNum)
 			}
 
 			// Parse: option 'key' 'value'
-			parts := parseQuotedLine(line[7:]) // Skip "option "
+			parts := p.split(line[len(prefixOption):])
 			if len(parts) != 2 {
-				return nil, fmt.Errorf("line %d: invalid option line", lineNum)
+				return nil, fmt.Errorf("line %d: invalid option line", p.lineNum)
 			}
 
-			currentSection.SetOption(parts[0], parts[1])
-			continue
-		}
+			p.current.SetOption(p.intern(parts[0]), parts[1])
 
-		// Parse list line
-		if strings.HasPrefix(line, "list ") {
-			if currentSection == nil {
-				return nil, fmt.Errorf("line %d: list outside of section", lineNum)
+		case bytes.HasPrefix(line, prefixList):
+			if p.current == nil {
+				return nil, fmt.Errorf("line %d: list outside of section", p.lineNum)
 			}
 
 			// Parse: list 'key' 'value'
-			parts := parseQuotedLine(line[5:]) // Skip "list "
+			parts := p.split(line[len(prefixList):])
 			if len(parts) != 2 {
-				return nil, fmt.Errorf("line %d: invalid list line", lineNum)
+				return nil, fmt.Errorf("line %d: invalid list line", p.lineNum)
 			}
 
-			currentSection.AddListValue(parts[0], parts[1])
-			continue
-		}
+			p.current.AddListValue(p.intern(parts[0]), parts[1])
 
-		return nil, fmt.Errorf("line %d: unknown syntax: %s", lineNum, line)
-	}
-
-	// Add last section
-	if currentSection != nil {
-		config.AddSection(currentSection)
+		default:
+			return nil, fmt.Errorf("line %d: unknown syntax: %s", p.lineNum, line)
+		}
 	}
 
-	if err := scanner.Err(); err != nil {
+	if err := p.scanner.Err(); err != nil {
 		return nil, fmt.Errorf("scanner error: %w", err)
 	}
 
-	return config, nil
+	// Last section
+	last := p.current
+	p.current = nil
+	return last, nil
 }
 
-// parseQuotedLine splits a line into quoted or unquoted tokens
+// split splits a line into quoted or unquoted tokens
 // Example: "interface 'wan'" -> ["interface", "wan"]
-func parseQuotedLine(line string) []string {
-	var parts []string
-	var current strings.Builder
+// The returned slice is reused by the next call.
+func (p *parser) split(line []byte) []string {
+	// One allocation per line; tokens are substrings of it where possible
+	s := string(line)
+	parts := p.parts[:0]
+
+	start, end := -1, -1 // current token as s[start:end] while it is contiguous
+	joined := false      // current token is built in p.buf instead
 	inQuotes := false
-	quoteChar := rune(0)
+	quoteChar := byte(0)
 
-	for _, r := range line {
+	add := func(i int) {
 		switch {
-		case !inQuotes && (r == '\'' || r == '"'):
+		case start < 0:
+			start, end = i, i+1
+		case !joined && end == i:
+			end++
+		default:
+			if !joined {
+				p.buf = append(p.buf[:0], s[start:end]...)
+				joined = true
+			}
+			p.buf = append(p.buf, s[i])
+		}
+	}
+	flush := func() {
+		switch {
+		case joined:
+			parts = append(parts, string(p.buf))
+		case start >= 0:
+			parts = append(parts, s[start:end])
+		default:
+			parts = append(parts, "")
+		}
+		start, end, joined = -1, -1, false
+	}
+
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case !inQuotes && (c == '\'' || c == '"'):
 			// Start quoted section
 			inQuotes = true
-			quoteChar = r
-		case inQuotes && r == quoteChar:
+			quoteChar = c
+		case inQuotes && c == quoteChar:
 			// End quoted section
 			inQuotes = false
-			parts = append(parts, current.String())
-			current.Reset()
+			flush()
 			quoteChar = 0
 		case inQuotes:
 			// Inside quotes
-			current.WriteRune(r)
-		case r == ' ' || r == '\t':
+			add(i)
+		case c == ' ' || c == '\t':
 			// Whitespace outside quotes
-			if current.Len() > 0 {
-				parts = append(parts, current.String())
-				current.Reset()
+			if start >= 0 {
+				flush()
 			}
 		default:
 			// Regular character
-			current.WriteRune(r)
+			add(i)
 		}
 	}
 
 	// Add final token if exists
-	if current.Len() > 0 {
-		parts = append(parts, current.String())
+	if start >= 0 {
+		flush()
 	}
 
+	p.parts = parts
 	return parts
 }
 
+// intern returns a shared copy of s
+func (p *parser) intern(s string) string {
+	if interned, ok := p.interned[s]; ok {
+		return interned
+	}
+	s = strings.Clone(s)
+	p.interned[s] = s
+	return s
+}
+
 // Write writes a UCI configuration to a writer
 func Write(w io.Writer, config *Config) error {
+	bw := bufio.NewWriter(w)
+
 	for i, section := range config.Sections {
 		// Add blank line between sections (except before first)
 		if i > 0 {
-			if _, err := fmt.Fprintln(w); err != nil {
-				return err
-			}
+			bw.WriteByte('\n')
 		}
 
 		// Write section header
+		bw.WriteString("config ")
+		bw.WriteString(section.Type)
 		if section.Name != "" {
-			if _, err := fmt.Fprintf(w, "config %s '%s'\n", section.Type, section.Name); err != nil {
-				return err
-			}
-		} else {
-			if _, err := fmt.Fprintf(w, "config %s\n", section.Type); err != nil {
-				return err
-			}
+			bw.WriteString(" '")
+			bw.WriteString(section.Name)
+			bw.WriteByte('\'')
 		}
+		bw.WriteByte('\n')
 
-		// Write options
-		for key, value := range section.Options {
-			if _, err := fmt.Fprintf(w, "\toption '%s' '%s'\n", key, escapeQuotes(value)); err != nil {
-				return err
-			}
+		// Write options (sorted so the output is stable between writes)
+		for _, key := range sortedKeys(section.Options) {
+			writeLine(bw, "option", key, section.Options[key])
 		}
 
 		// Write lists
-		for key, values := range section.Lists {
-			for _, value := range values {
-				if _, err := fmt.Fprintf(w, "\tlist '%s' '%s'\n", key, escapeQuotes(value)); err != nil {
-					return err
-				}
+		for _, key := range sortedKeys(section.Lists) {
+			for _, value := range section.Lists[key] {
+				writeLine(bw, "list", key, value)
 			}
 		}
 	}
 
-	return nil
+	// bufio.Writer keeps the first write error and returns it here
+	return bw.Flush()
+}
+
+// writeLine writes an option or list line: \t<kind> '<key>' '<value>'
+func writeLine(w *bufio.Writer, kind, key, value string) {
+	w.WriteByte('\t')
+	w.WriteString(kind)
+	w.WriteString(" '")
+	w.WriteString(key)
+	w.WriteString("' '")
+	w.WriteString(escapeQuotes(value))
+	w.WriteString("'\n")
+}
+
+// sortedKeys returns the keys of m in sorted order
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
 }
 
 // escapeQuotes escapes single quotes in a string
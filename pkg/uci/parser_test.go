@@ -2,6 +2,8 @@ package uci
 
 import (
 	"bytes"
+	"fmt"
+	"io"
 	"strings"
 	"testing"
 )
@@ -133,3 +135,117 @@ config rule
 		t.Errorf("Section count mismatch: %d vs %d", len(config.Sections), len(config2.Sections))
 	}
 }
+
+// largeConfig builds a config with n host sections, like a big set of
+// static leases
+func largeConfig(n int) []byte {
+	var buf bytes.Buffer
+	buf.WriteString("config dnsmasq\n\toption domain 'lan'\n\toption local '/lan/'\n\n")
+	for i := 0; i < n; i++ {
+		fmt.Fprintf(&buf, "config host 'host%d'\n", i)
+		fmt.Fprintf(&buf, "\toption name 'device-%d'\n", i)
+		fmt.Fprintf(&buf, "\toption mac '02:00:00:%02x:%02x:%02x'\n", i>>16&0xff, i>>8&0xff, i&0xff)
+		fmt.Fprintf(&buf, "\toption ip '10.%d.%d.%d'\n", i>>16&0xff, i>>8&0xff, i&0xff)
+		buf.WriteString("\tlist tag 'known'\n\tlist tag 'static'\n\n")
+	}
+	return buf.Bytes()
+}
+
+func TestSections(t *testing.T) {
+	data := largeConfig(100)
+
+	config, err := Parse(bytes.NewReader(data))
+	if err != nil {
+		t.Fatalf("Parse error: %v", err)
+	}
+
+	var n int
+	for section, err := range Sections(bytes.NewReader(data)) {
+		if err != nil {
+			t.Fatalf("Sections error: %v", err)
+		}
+		if section.Type != config.Sections[n].Type || section.Name != config.Sections[n].Name {
+			t.Errorf("Section %d: got %s %q, want %s %q", n, section.Type, section.Name,
+				config.Sections[n].Type, config.Sections[n].Name)
+		}
+		n++
+	}
+	if n != len(config.Sections) {
+		t.Errorf("Expected %d sections, got %d", len(config.Sections), n)
+	}
+
+	// Stopping early must not report an error
+	for range Sections(bytes.NewReader(data)) {
+		break
+	}
+
+	for _, err := range Sections(strings.NewReader("option foo 'bar'\n")) {
+		if err == nil {
+			t.Error("Expected error for option outside of section")
+		}
+	}
+}
+
+func TestSectionID(t *testing.T) {
+	input := `
+config rule
+	option name 'ssh'
+
+config zone 'lan'
+
+config rule
+	option name 'http'
+`
+
+	config, err := Parse(strings.NewReader(input))
+	if err != nil {
+		t.Fatalf("Parse error: %v", err)
+	}
+
+	for i, want := range []string{"@rule[0]", "lan", "@rule[1]"} {
+		if got := config.SectionID(config.Sections[i]); got != want {
+			t.Errorf("Section %d: got %q, want %q", i, got, want)
+		}
+	}
+}
+
+func BenchmarkParse(b *testing.B) {
+	for _, n := range []int{10, 1000, 20000} {
+		data := largeConfig(n)
+		b.Run(fmt.Sprintf("sections=%d", n), func(b *testing.B) {
+			b.SetBytes(int64(len(data)))
+			b.ReportAllocs()
+			for b.Loop() {
+				if _, err := Parse(bytes.NewReader(data)); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+func BenchmarkSections(b *testing.B) {
+	data := largeConfig(20000)
+	b.SetBytes(int64(len(data)))
+	b.ReportAllocs()
+	for b.Loop() {
+		for _, err := range Sections(bytes.NewReader(data)) {
+			if err != nil {
+				b.Fatal(err)
+			}
+		}
+	}
+}
+
+func BenchmarkWrite(b *testing.B) {
+	config, err := Parse(bytes.NewReader(largeConfig(20000)))
+	if err != nil {
+		b.Fatal(err)
+	}
+	b.ReportAllocs()
+	for b.Loop() {
+		if err := Write(io.Discard, config); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
@@ -1,5 +1,10 @@
 package uci
 
+import (
+	"fmt"
+	"slices"
+)
+
 // Config represents a UCI configuration file
 type Config struct {
 	Sections []*Section
@@ -56,6 +61,16 @@ func (c *Config) GetSectionsByType(sectionType string) []*Section {
 	return sections
 }
 
+// SectionID names a section the way UCI does: by name, or by type and
+// index among the sections of that type
+func (c *Config) SectionID(section *Section) string {
+	if section.Name != "" {
+		return section.Name
+	}
+	index := slices.Index(c.GetSectionsByType(section.Type), section)
+	return fmt.Sprintf("@%s[%d]", section.Type, index)
+}
+
 // SetOption sets a single-value option in a section
 func (s *Section) SetOption(key, value string) {
 	s.Options[key] = value
@@ -79,3 +94,46 @@ func (s *Section) AddListValue(key, value string) {
 func (s *Section) GetList(key string) []string {
 	return s.Lists[key]
 }
+
+// RemoveSection removes a section from the config
+func (c *Config) RemoveSection(s *Section) bool {
+	for i, existing := range c.Sections {
+		if existing == s {
+			c.Sections = append(c.Sections[:i], c.Sections[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
+
+// Clone returns a deep copy of the config
+func (c *Config) Clone() *Config {
+	clone := &Config{
+		Sections: make([]*Section, 0, len(c.Sections)),
+	}
+	for _, s := range c.Sections {
+		clone.Sections = append(clone.Sections, s.Clone())
+	}
+	return clone
+}
+
+// Clone returns a deep copy of the section
+func (s *Section) Clone() *Section {
+	clone := NewSection(s.Type, s.Name)
+	for key, value := range s.Options {
+		clone.Options[key] = value
+	}
+	for key, values := range s.Lists {
+		clone.Lists[key] = append([]string(nil), values...)
+	}
+	return clone
+}
+
+// DeleteOption removes an option or list from a section
+func (s *Section) DeleteOption(key string) bool {
+	_, isOption := s.Options[key]
+	_, isList := s.Lists[key]
+	delete(s.Options, key)
+	delete(s.Lists, key)
+	return isOption || isList
+}
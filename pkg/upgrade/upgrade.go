@@ -0,0 +1,490 @@
+// Package upgrade upgrades hellfire and the system packages. Before anything
+// is installed the configs are snapshotted and the database is backed up;
+// the installer's output is streamed to the caller. After the service
+// restarts, the new version checks that the system is healthy and records
+// the outcome, and the configs of a failed upgrade can be rolled back.
+package upgrade
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/thesabbir/hellfire/pkg/bus"
+	"github.com/thesabbir/hellfire/pkg/db"
+	"github.com/thesabbir/hellfire/pkg/logger"
+	"github.com/thesabbir/hellfire/pkg/snapshot"
+	"github.com/thesabbir/hellfire/pkg/util"
+	"github.com/thesabbir/hellfire/pkg/version"
+)
+
+const (
+	// DefaultStateFile records the last upgrade
+	DefaultStateFile = "/var/lib/hellfire/upgrade.json"
+
+	// DefaultBackupDir holds the database backups taken before upgrades
+	DefaultBackupDir = "/var/lib/hellfire/backups"
+
+	// DefaultPublicKeyFile is the Ed25519 key upgrade images are signed with
+	DefaultPublicKeyFile = "/etc/hellfire/upgrade.pub"
+
+	// DefaultService is the systemd unit restarted after an upgrade
+	DefaultService = "hellfire-api"
+
+	// startTimeout bounds the wait for an upgrade started by another process
+	// to take its backups and record itself
+	startTimeout = 2 * time.Minute
+)
+
+// Upgrade methods
+const (
+	MethodApt   = "apt"   // apt-get from the configured repositories
+	MethodImage = "image" // A signed .deb installed with dpkg
+)
+
+// Upgrade statuses
+const (
+	StatusInstalling = "installing"
+	StatusInstalled  = "installed" // Waiting for the restarted service to verify it
+	StatusSucceeded  = "succeeded"
+	StatusFailed     = "failed"
+	StatusRolledBack = "rolled_back"
+)
+
+var (
+	ErrInProgress = errors.New("an upgrade is already in progress")
+	ErrNoUpgrade  = errors.New("no upgrade recorded")
+
+	// mu serializes state changes within the process; the installing
+	// status guards against other processes
+	mu sync.Mutex
+)
+
+// Options selects what is installed
+type Options struct {
+	ID            string // Upgrade ID; generated if empty
+	Method        string
+	Packages      []string // apt: packages to upgrade; empty upgrades the whole system
+	Image         string   // image: path of the .deb
+	SignatureFile string   // image: detached Ed25519 signature (default Image + ".sig")
+	PublicKeyFile string   // image: PEM public key (default DefaultPublicKeyFile)
+	Message       string
+}
+
+// State is the record of the last upgrade
+type State struct {
+	ID          string     `json:"id"`
+	Method      string     `json:"method"`
+	Status      string     `json:"status"`
+	Message     string     `json:"message,omitempty"`
+	FromVersion string     `json:"from_version"`
+	ToVersion   string     `json:"to_version,omitempty"` // Set by the restarted service
+	SnapshotID  string     `json:"snapshot_id"`          // Configs before the upgrade
+	DBBackup    string     `json:"db_backup,omitempty"`  // Database before the upgrade
+	LogFile     string     `json:"log_file"`             // Installer output
+	PID         int        `json:"pid,omitempty"`        // Process running the installer
+	StartedAt   time.Time  `json:"started_at"`
+	FinishedAt  *time.Time `json:"finished_at,omitempty"`
+	Error       string     `json:"error,omitempty"`
+	Problems    []string   `json:"problems,omitempty"` // Failed post-upgrade checks
+}
+
+// Upgrader runs upgrades and keeps their state
+type Upgrader struct {
+	snapshots *snapshot.Manager
+	configDir string
+	stateFile string
+	backupDir string
+}
+
+// NewUpgrader creates an upgrader snapshotting the configs in configDir
+func NewUpgrader(snapshots *snapshot.Manager, configDir, stateFile, backupDir string) *Upgrader {
+	return &Upgrader{
+		snapshots: snapshots,
+		configDir: configDir,
+		stateFile: stateFile,
+		backupDir: backupDir,
+	}
+}
+
+// State returns the last upgrade
+func (u *Upgrader) State() (*State, error) {
+	data, err := os.ReadFile(u.stateFile)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, ErrNoUpgrade
+		}
+		return nil, err
+	}
+
+	var state State
+	if err := json.Unmarshal(data, &state); err != nil {
+		return nil, fmt.Errorf("invalid upgrade state %s: %w", u.stateFile, err)
+	}
+	return &state, nil
+}
+
+// Run backs up the configs and database, then installs the upgrade, writing
+// the installer's output to out and to the upgrade's log file. On success
+// the upgrade is left installed, to be verified by the restarted service
+// (see Verify).
+func (u *Upgrader) Run(ctx context.Context, opts Options, out io.Writer) (*State, error) {
+	if err := opts.Validate(); err != nil {
+		return nil, err
+	}
+	if opts.ID == "" {
+		opts.ID = util.GenerateUniqueID()
+	}
+
+	if err := os.MkdirAll(u.backupDir, 0700); err != nil {
+		return nil, err
+	}
+	logFile := filepath.Join(u.backupDir, "upgrade-"+opts.ID+".log")
+	log, err := os.OpenFile(logFile, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0600)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create upgrade log: %w", err)
+	}
+	defer log.Close()
+	out = io.MultiWriter(out, log)
+
+	state, err := u.prepare(opts, logFile, out)
+	if err != nil {
+		fmt.Fprintf(out, "==> Failed: %v\n", err)
+		return nil, err
+	}
+
+	fmt.Fprintf(out, "==> Installing (%s)\n", opts.Method)
+	if err := install(ctx, opts, out); err != nil {
+		fmt.Fprintf(out, "==> Failed: %v\n", err)
+		return u.finish(state, StatusFailed, err, nil)
+	}
+
+	state.Status = StatusInstalled
+	if err := u.save(state); err != nil {
+		return nil, err
+	}
+	fmt.Fprintln(out, "==> Installed; the upgrade is verified when the service restarts")
+	return state, nil
+}
+
+// Follow copies the log of upgrade id to out as it grows, until the upgrade
+// is no longer installing or ctx ends, and returns its state. It waits up to
+// startTimeout for an upgrade started in another process to be recorded.
+func (u *Upgrader) Follow(ctx context.Context, id string, out io.Writer) (*State, error) {
+	ticker := time.NewTicker(500 * time.Millisecond)
+	defer ticker.Stop()
+
+	deadline := time.Now().Add(startTimeout)
+	var offset int64
+	for {
+		state, err := u.State()
+		if err != nil && !errors.Is(err, ErrNoUpgrade) {
+			return nil, err
+		}
+
+		if state != nil && state.ID == id {
+			if log, err := os.Open(state.LogFile); err == nil {
+				n, _ := io.Copy(out, io.NewSectionReader(log, offset, 1<<62))
+				offset += n
+				log.Close()
+			}
+			if state.Status != StatusInstalling {
+				return state, nil
+			}
+			if !state.Running() {
+				return state, fmt.Errorf("upgrade process %d exited without finishing", state.PID)
+			}
+		} else if time.Now().After(deadline) {
+			return nil, fmt.Errorf("upgrade %s didn't start", id)
+		}
+
+		select {
+		case <-ctx.Done():
+			return state, ctx.Err()
+		case <-ticker.C:
+		}
+	}
+}
+
+// Verify completes an installed upgrade in the restarted service: the
+// upgrade succeeds if check finds no problems, and fails otherwise. It
+// returns nil if no upgrade is waiting to be verified.
+func (u *Upgrader) Verify(check func() []string) (*State, error) {
+	state, err := u.State()
+	if err != nil || state.Status != StatusInstalled {
+		if errors.Is(err, ErrNoUpgrade) {
+			err = nil
+		}
+		return nil, err
+	}
+
+	state.ToVersion = version.GetVersion()
+	problems := check()
+	if len(problems) > 0 {
+		return u.finish(state, StatusFailed,
+			fmt.Errorf("post-upgrade checks failed: %s", strings.Join(problems, "; ")), problems)
+	}
+	return u.finish(state, StatusSucceeded, nil, nil)
+}
+
+// Rollback restores the configs snapshotted before the last upgrade with
+// restore (which re-applies them) and marks the upgrade rolled back. The
+// installed packages and the database are left alone.
+func (u *Upgrader) Rollback(restore func(snapshotID string) error) (*State, error) {
+	mu.Lock()
+	defer mu.Unlock()
+
+	state, err := u.State()
+	if err != nil {
+		return nil, err
+	}
+	switch {
+	case state.Running():
+		return nil, ErrInProgress
+	case state.Status == StatusRolledBack:
+		return nil, fmt.Errorf("upgrade %s is already rolled back", state.ID)
+	}
+
+	if err := restore(state.SnapshotID); err != nil {
+		return nil, fmt.Errorf("failed to restore snapshot %s: %w", state.SnapshotID, err)
+	}
+
+	state.Status = StatusRolledBack
+	if err := u.save(state); err != nil {
+		return nil, err
+	}
+	logger.Info("Rolled back configs after upgrade", "upgrade", state.ID, "snapshot", state.SnapshotID)
+	return state, nil
+}
+
+// Restart restarts the service after an upgrade without waiting for it, so a
+// service restarting itself can finish its response
+func Restart(service string) error {
+	output, err := exec.Command("systemctl", "restart", "--no-block", service).CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("failed to restart %s: %w: %s", service, err, strings.TrimSpace(string(output)))
+	}
+	return nil
+}
+
+// prepare records the new upgrade and takes the backups
+func (u *Upgrader) prepare(opts Options, logFile string, out io.Writer) (*State, error) {
+	mu.Lock()
+	defer mu.Unlock()
+
+	if current, err := u.State(); err == nil && current.Running() {
+		return nil, fmt.Errorf("%w (%s, started %s)", ErrInProgress, current.ID, current.StartedAt.Format(time.RFC3339))
+	}
+
+	state := &State{
+		ID:          opts.ID,
+		Method:      opts.Method,
+		Status:      StatusInstalling,
+		Message:     opts.Message,
+		FromVersion: version.GetVersion(),
+		LogFile:     logFile,
+		PID:         os.Getpid(),
+		StartedAt:   time.Now(),
+	}
+
+	fmt.Fprintln(out, "==> Snapshotting configs")
+	configs, err := u.configNames()
+	if err != nil {
+		return nil, err
+	}
+	snap, err := u.snapshots.Create("Before upgrade "+state.ID, configs)
+	if err != nil {
+		return nil, fmt.Errorf("failed to snapshot configs: %w", err)
+	}
+	state.SnapshotID = snap.ID
+	// Kept for hf upgrade rollback however many snapshots follow
+	if _, err := u.snapshots.Protect(snap.ID, true); err != nil {
+		logger.Warn("Failed to protect the upgrade snapshot from pruning", "snapshot", snap.ID, "error", err)
+	}
+	fmt.Fprintf(out, "    snapshot %s (%d configs)\n", snap.ID, len(snap.Metadata.Configs))
+
+	if db.DB != nil {
+		fmt.Fprintln(out, "==> Backing up the database")
+		state.DBBackup = filepath.Join(u.backupDir, "hellfire-"+state.ID+".db")
+		if err := db.Backup(state.DBBackup); err != nil {
+			return nil, err
+		}
+		fmt.Fprintf(out, "    %s\n", state.DBBackup)
+	}
+
+	if err := u.save(state); err != nil {
+		return nil, err
+	}
+	logger.Info("Starting upgrade", "upgrade", state.ID, "method", opts.Method, "snapshot", state.SnapshotID)
+	return state, nil
+}
+
+// finish records the outcome of an upgrade and publishes it
+func (u *Upgrader) finish(state *State, status string, cause error, problems []string) (*State, error) {
+	mu.Lock()
+	defer mu.Unlock()
+
+	now := time.Now()
+	state.Status = status
+	state.FinishedAt = &now
+	state.Problems = problems
+	if cause != nil {
+		state.Error = cause.Error()
+	}
+	if err := u.save(state); err != nil {
+		logger.Error("Failed to record upgrade state", "upgrade", state.ID, "error", err)
+	}
+
+	if status == StatusSucceeded {
+		logger.Info("Upgrade succeeded", "upgrade", state.ID, "from", state.FromVersion, "to", state.ToVersion)
+		bus.Publish(bus.Event{Type: bus.EventUpgradeSucceeded, ConfigName: "hellfire", Data: *state})
+		return state, nil
+	}
+
+	logger.Error("Upgrade failed", "upgrade", state.ID, "error", cause,
+		"rollback", "hf upgrade rollback restores snapshot "+state.SnapshotID)
+	bus.Publish(bus.Event{Type: bus.EventUpgradeFailed, ConfigName: "hellfire", Data: *state})
+	return state, cause
+}
+
+// Running reports whether the upgrade is still installing; an upgrade whose
+// process died (e.g. with a reboot) is not
+func (s *State) Running() bool {
+	return s.Status == StatusInstalling && s.PID > 0 && syscall.Kill(s.PID, 0) == nil
+}
+
+func (u *Upgrader) save(state *State) error {
+	data, err := json.MarshalIndent(state, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(u.stateFile), 0750); err != nil {
+		return err
+	}
+	return util.WriteFileAtomic(u.stateFile, data, 0640)
+}
+
+// configNames lists the config files in the config directory
+func (u *Upgrader) configNames() ([]string, error) {
+	entries, err := os.ReadDir(u.configDir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list configs: %w", err)
+	}
+
+	var names []string
+	for _, entry := range entries {
+		if entry.Type().IsRegular() && !strings.HasPrefix(entry.Name(), ".") {
+			names = append(names, entry.Name())
+		}
+	}
+	return names, nil
+}
+
+// Validate checks the options and fills in the image signature and key defaults
+func (o *Options) Validate() error {
+	switch o.Method {
+	case MethodApt:
+		for _, pkg := range o.Packages {
+			if pkg == "" || strings.HasPrefix(pkg, "-") {
+				return fmt.Errorf("invalid package name %q", pkg)
+			}
+		}
+	case MethodImage:
+		if o.Image == "" {
+			return fmt.Errorf("image upgrades need an image file")
+		}
+		if o.SignatureFile == "" {
+			o.SignatureFile = o.Image + ".sig"
+		}
+		if o.PublicKeyFile == "" {
+			o.PublicKeyFile = DefaultPublicKeyFile
+		}
+	default:
+		return fmt.Errorf("invalid upgrade method %q (must be apt or image)", o.Method)
+	}
+	return nil
+}
+
+// install runs the package manager. Existing config files are always kept.
+func install(ctx context.Context, opts Options, out io.Writer) error {
+	switch opts.Method {
+	case MethodImage:
+		if err := VerifyImage(opts.Image, opts.SignatureFile, opts.PublicKeyFile); err != nil {
+			return err
+		}
+		fmt.Fprintf(out, "    signature of %s verified\n", opts.Image)
+		return run(ctx, out, "dpkg", "--force-confold", "-i", opts.Image)
+
+	default:
+		if err := run(ctx, out, "apt-get", "update"); err != nil {
+			return err
+		}
+		args := []string{"-y", "-o", "Dpkg::Options::=--force-confold"}
+		if len(opts.Packages) > 0 {
+			args = append(append(args, "install", "--only-upgrade", "--"), opts.Packages...)
+		} else {
+			args = append(args, "upgrade")
+		}
+		return run(ctx, out, "apt-get", args...)
+	}
+}
+
+func run(ctx context.Context, out io.Writer, name string, args ...string) error {
+	fmt.Fprintf(out, "$ %s %s\n", name, strings.Join(args, " "))
+
+	cmd := exec.CommandContext(ctx, name, args...)
+	cmd.Env = append(os.Environ(), "DEBIAN_FRONTEND=noninteractive")
+	cmd.Stdout = out
+	cmd.Stderr = out
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("%s failed: %w", name, err)
+	}
+	return nil
+}
+
+// VerifyImage checks the detached Ed25519 signature of an upgrade image, as
+// made by "openssl pkeyutl -sign -rawin -inkey key.pem -in image -out image.sig"
+func VerifyImage(image, signatureFile, publicKeyFile string) error {
+	keyPEM, err := os.ReadFile(publicKeyFile)
+	if err != nil {
+		return fmt.Errorf("failed to read upgrade public key: %w", err)
+	}
+	block, _ := pem.Decode(keyPEM)
+	if block == nil {
+		return fmt.Errorf("no PEM public key in %s", publicKeyFile)
+	}
+	key, err := x509.ParsePKIXPublicKey(block.Bytes)
+	if err != nil {
+		return fmt.Errorf("invalid upgrade public key: %w", err)
+	}
+	publicKey, ok := key.(ed25519.PublicKey)
+	if !ok {
+		return fmt.Errorf("upgrade public key %s is not an Ed25519 key", publicKeyFile)
+	}
+
+	signature, err := os.ReadFile(signatureFile)
+	if err != nil {
+		return fmt.Errorf("failed to read image signature: %w", err)
+	}
+	data, err := os.ReadFile(image)
+	if err != nil {
+		return fmt.Errorf("failed to read image: %w", err)
+	}
+
+	if !ed25519.Verify(publicKey, data, signature) {
+		return fmt.Errorf("invalid signature for %s", image)
+	}
+	return nil
+}
@@ -0,0 +1,107 @@
+package upgrade
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/pem"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/thesabbir/hellfire/pkg/snapshot"
+)
+
+func TestVerifyImage(t *testing.T) {
+	dir := t.TempDir()
+	public, private, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	der, err := x509.MarshalPKIXPublicKey(public)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	image := filepath.Join(dir, "hellfire.deb")
+	keyFile := filepath.Join(dir, "upgrade.pub")
+	data := []byte("package contents")
+	for path, content := range map[string][]byte{
+		image:          data,
+		image + ".sig": ed25519.Sign(private, data),
+		keyFile:        pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der}),
+	} {
+		if err := os.WriteFile(path, content, 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := VerifyImage(image, image+".sig", keyFile); err != nil {
+		t.Errorf("VerifyImage: %v", err)
+	}
+
+	if err := os.WriteFile(image, []byte("tampered contents"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := VerifyImage(image, image+".sig", keyFile); err == nil {
+		t.Error("expected a tampered image to be refused")
+	}
+}
+
+func TestUpgradeLifecycle(t *testing.T) {
+	dir := t.TempDir()
+	configDir := filepath.Join(dir, "config")
+	if err := os.MkdirAll(configDir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(configDir, "network"), []byte("config interface 'lan'\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	snapshots := snapshot.NewManager(filepath.Join(dir, "snapshots"), configDir)
+	u := NewUpgrader(snapshots, configDir, filepath.Join(dir, "upgrade.json"), filepath.Join(dir, "backups"))
+
+	if _, err := u.State(); err != ErrNoUpgrade {
+		t.Fatalf("State without upgrades = %v, want ErrNoUpgrade", err)
+	}
+
+	// The image has no signature: the configs are snapshotted, then the
+	// install fails
+	opts := Options{Method: MethodImage, Image: filepath.Join(dir, "missing.deb"), PublicKeyFile: filepath.Join(dir, "none.pub")}
+	state, err := u.Run(context.Background(), opts, io.Discard)
+	if err == nil || state == nil || state.Status != StatusFailed {
+		t.Fatalf("Run = %+v, %v; want a failed upgrade", state, err)
+	}
+	if snap, err := snapshots.Load(state.SnapshotID); err != nil || len(snap.Metadata.Configs) != 1 {
+		t.Errorf("snapshot %s: %+v, %v", state.SnapshotID, snap, err)
+	}
+	if log, err := os.ReadFile(state.LogFile); err != nil || !strings.Contains(string(log), "==> Failed") {
+		t.Errorf("log = %q, %v", log, err)
+	}
+
+	// A restarted service verifies an installed upgrade
+	state.Status = StatusInstalled
+	state.Error = ""
+	if err := u.save(state); err != nil {
+		t.Fatal(err)
+	}
+	verified, err := u.Verify(func() []string { return []string{"config network: bad"} })
+	if err == nil || verified.Status != StatusFailed || len(verified.Problems) != 1 {
+		t.Errorf("Verify = %+v, %v; want failed", verified, err)
+	}
+	if again, err := u.Verify(func() []string { return nil }); again != nil || err != nil {
+		t.Errorf("Verify of a finished upgrade = %+v, %v", again, err)
+	}
+
+	var restored string
+	rolledBack, err := u.Rollback(func(id string) error {
+		restored = id
+		return nil
+	})
+	if err != nil || rolledBack.Status != StatusRolledBack || restored != state.SnapshotID {
+		t.Errorf("Rollback = %+v, %v (restored %q)", rolledBack, err, restored)
+	}
+}
@@ -2,6 +2,7 @@ package util
 
 import (
 	"crypto/rand"
+	"crypto/sha256"
 	"fmt"
 	"io"
 	"os"
@@ -70,6 +71,53 @@ func CopyFileAtomic(src, dst string) error {
 	return nil
 }
 
+// WriteFileAtomic writes data to a temp file in the destination directory and
+// renames it into place, so readers never see a partial file
+func WriteFileAtomic(path string, data []byte, perm os.FileMode) error {
+	tmpFile, err := os.CreateTemp(filepath.Dir(path), ".tmp-*")
+	if err != nil {
+		return fmt.Errorf("failed to create temp file: %w", err)
+	}
+	tmpPath := tmpFile.Name()
+
+	// Cleanup temp file on error
+	success := false
+	defer func() {
+		if !success {
+			tmpFile.Close()
+			os.Remove(tmpPath)
+		}
+	}()
+
+	if _, err := tmpFile.Write(data); err != nil {
+		return fmt.Errorf("failed to write temp file: %w", err)
+	}
+
+	if err := tmpFile.Sync(); err != nil {
+		return fmt.Errorf("failed to sync: %w", err)
+	}
+
+	if err := tmpFile.Close(); err != nil {
+		return fmt.Errorf("failed to close temp file: %w", err)
+	}
+
+	if err := os.Chmod(tmpPath, perm); err != nil {
+		return fmt.Errorf("failed to set permissions: %w", err)
+	}
+
+	if err := os.Rename(tmpPath, path); err != nil {
+		return fmt.Errorf("failed to rename: %w", err)
+	}
+
+	success = true
+	return nil
+}
+
+// Checksum returns the hex-encoded SHA256 checksum of data
+func Checksum(data []byte) string {
+	return fmt.Sprintf("%x", sha256.Sum256(data))
+}
+
 // GenerateUniqueID generates a unique ID for snapshots
 // Format: YYYYMMDD-HHMMSS-mmm-RRRR
 // Where mmm = milliseconds, RRRR = random hex suffix
@@ -91,24 +139,29 @@ func GenerateUniqueID() string {
 	return fmt.Sprintf("%s-%03d-%s", timestamp, ms, randSuffix)
 }
 
-// CheckDiskSpace checks if sufficient disk space is available
-// Returns error if less than requiredGB is available
-func CheckDiskSpace(path string, requiredGB uint64) error {
-	var stat syscall.Statfs_t
-	if err := syscall.Statfs(path, &stat); err != nil {
-		return fmt.Errorf("failed to check disk space: %w", err)
+// CheckFreeSpace checks that at least required bytes are available to
+// unprivileged users on the file system holding path
+func CheckFreeSpace(path string, required uint64) error {
+	_, available, err := FileSystemUsage(path)
+	if err != nil {
+		return err
 	}
-
-	// Calculate available space in GB
-	availableGB := stat.Bavail * uint64(stat.Bsize) / (1024 * 1024 * 1024)
-
-	if availableGB < requiredGB {
-		return fmt.Errorf("insufficient disk space: %d GB available, %d GB required", availableGB, requiredGB)
+	if available < required {
+		return fmt.Errorf("insufficient disk space: %d MB available, %d MB required", available>>20, required>>20)
 	}
-
 	return nil
 }
 
+// FileSystemUsage returns the size and the space available to unprivileged
+// users, in bytes, of the file system holding path
+func FileSystemUsage(path string) (total, available uint64, err error) {
+	var stat syscall.Statfs_t
+	if err := syscall.Statfs(path, &stat); err != nil {
+		return 0, 0, fmt.Errorf("failed to check disk space: %w", err)
+	}
+	return stat.Blocks * uint64(stat.Bsize), stat.Bavail * uint64(stat.Bsize), nil
+}
+
 // GetDiskUsageGB returns the disk usage of a directory in GB
 func GetDiskUsageGB(path string) (uint64, error) {
 	var size int64
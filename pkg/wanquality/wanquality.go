@@ -0,0 +1,347 @@
+// Package wanquality monitors the WAN link: it pings configured targets on a
+// schedule to measure latency, jitter and packet loss, optionally runs
+// throughput tests with iperf3 or speedtest-cli, stores every measurement in
+// the database and publishes events when the link degrades or recovers.
+package wanquality
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"math"
+	"net"
+	"os/exec"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/thesabbir/hellfire/pkg/bus"
+	"github.com/thesabbir/hellfire/pkg/db"
+	"github.com/thesabbir/hellfire/pkg/logger"
+)
+
+// Speed test tools
+const (
+	ToolIperf3    = "iperf3"
+	ToolSpeedtest = "speedtest"
+)
+
+// Sample kinds
+const (
+	KindPing      = "ping"
+	KindSpeedtest = "speedtest"
+)
+
+// Config controls what is measured and when the link counts as degraded
+type Config struct {
+	Interval      time.Duration // Between ping rounds
+	Targets       []string      // Hosts pinged each round
+	Pings         int           // Echo requests per target and round
+	SpeedInterval time.Duration // Between throughput tests; 0 disables them
+	SpeedTool     string        // iperf3 or speedtest
+	IperfServer   string        // host or host:port, for iperf3
+	MaxLatency    float64       // Milliseconds
+	MaxJitter     float64       // Milliseconds
+	MaxLoss       float64       // Percent
+	Retention     time.Duration // How long samples are kept
+}
+
+// TargetStatus is the latest measurement of a target
+type TargetStatus struct {
+	Target   string              `json:"target"`
+	Degraded bool                `json:"degraded"`
+	Reasons  []string            `json:"reasons,omitempty"`
+	Since    time.Time           `json:"since"` // When the target entered its current state
+	Last     db.WANQualitySample `json:"last"`
+}
+
+// Monitor runs the measurements on a schedule
+type Monitor struct {
+	cfg Config
+
+	mu      sync.Mutex
+	targets map[string]*TargetStatus
+}
+
+// NewMonitor creates a WAN quality monitor
+func NewMonitor(cfg Config) *Monitor {
+	return &Monitor{
+		cfg:     cfg,
+		targets: make(map[string]*TargetStatus),
+	}
+}
+
+// Start runs ping rounds, throughput tests and sample cleanup in the background
+func (m *Monitor) Start() {
+	go func() {
+		ticker := time.NewTicker(m.cfg.Interval)
+		defer ticker.Stop()
+
+		logger.Info("Started WAN quality monitor",
+			"targets", m.cfg.Targets,
+			"interval", m.cfg.Interval)
+
+		for {
+			m.PingRound(context.Background())
+			if m.cfg.Retention > 0 {
+				if _, err := db.CleanupWANQualitySamples(time.Now().Add(-m.cfg.Retention)); err != nil {
+					logger.Warn("Failed to clean up WAN quality samples", "error", err)
+				}
+			}
+			<-ticker.C
+		}
+	}()
+
+	if m.cfg.SpeedInterval > 0 {
+		go func() {
+			ticker := time.NewTicker(m.cfg.SpeedInterval)
+			defer ticker.Stop()
+
+			for range ticker.C {
+				m.SpeedTest(context.Background())
+			}
+		}()
+	}
+}
+
+// Status returns the latest state of every target, in config order
+func (m *Monitor) Status() []TargetStatus {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	statuses := make([]TargetStatus, 0, len(m.cfg.Targets))
+	for _, target := range m.cfg.Targets {
+		if status, ok := m.targets[target]; ok {
+			statuses = append(statuses, *status)
+		}
+	}
+	return statuses
+}
+
+// PingRound pings every target, records the samples and reports targets
+// that became degraded or recovered
+func (m *Monitor) PingRound(ctx context.Context) {
+	for _, target := range m.cfg.Targets {
+		// ping's own deadline keeps slow rounds from overlapping
+		pingCtx, cancel := context.WithTimeout(ctx, time.Duration(m.cfg.Pings+5)*time.Second)
+		sample := Ping(pingCtx, target, m.cfg.Pings)
+		cancel()
+
+		reasons := m.degradation(sample)
+		sample.Degraded = len(reasons) > 0
+		if err := db.CreateWANQualitySample(&sample); err != nil {
+			logger.Warn("Failed to record WAN quality sample", "target", target, "error", err)
+		}
+		m.update(sample, reasons)
+	}
+}
+
+// SpeedTest runs a throughput test with the configured tool and records it
+func (m *Monitor) SpeedTest(ctx context.Context) {
+	ctx, cancel := context.WithTimeout(ctx, 2*time.Minute)
+	defer cancel()
+
+	var sample db.WANQualitySample
+	switch m.cfg.SpeedTool {
+	case ToolIperf3:
+		sample = iperf3(ctx, m.cfg.IperfServer)
+	default:
+		sample = speedtestCLI(ctx)
+	}
+
+	if sample.Error != "" {
+		logger.Warn("WAN speed test failed", "tool", m.cfg.SpeedTool, "error", sample.Error)
+	} else {
+		logger.Info("WAN speed test completed",
+			"download_mbps", sample.DownloadMbps,
+			"upload_mbps", sample.UploadMbps)
+	}
+	if err := db.CreateWANQualitySample(&sample); err != nil {
+		logger.Warn("Failed to record WAN speed test", "error", err)
+	}
+}
+
+// degradation lists the thresholds a ping sample is over
+func (m *Monitor) degradation(sample db.WANQualitySample) []string {
+	if sample.Error != "" {
+		return []string{sample.Error}
+	}
+
+	var reasons []string
+	if m.cfg.MaxLoss > 0 && sample.LossPercent > m.cfg.MaxLoss {
+		reasons = append(reasons, fmt.Sprintf("loss %.1f%% over %.1f%%", sample.LossPercent, m.cfg.MaxLoss))
+	}
+	if m.cfg.MaxLatency > 0 && sample.LatencyMs > m.cfg.MaxLatency {
+		reasons = append(reasons, fmt.Sprintf("latency %.1fms over %.1fms", sample.LatencyMs, m.cfg.MaxLatency))
+	}
+	if m.cfg.MaxJitter > 0 && sample.JitterMs > m.cfg.MaxJitter {
+		reasons = append(reasons, fmt.Sprintf("jitter %.1fms over %.1fms", sample.JitterMs, m.cfg.MaxJitter))
+	}
+	return reasons
+}
+
+// update records a target's latest sample and publishes state changes
+func (m *Monitor) update(sample db.WANQualitySample, reasons []string) {
+	m.mu.Lock()
+	status, seen := m.targets[sample.Target]
+	if !seen {
+		status = &TargetStatus{Target: sample.Target, Since: sample.CreatedAt}
+		m.targets[sample.Target] = status
+	}
+	changed := status.Degraded != sample.Degraded
+	if changed {
+		status.Since = sample.CreatedAt
+	}
+	status.Degraded = sample.Degraded
+	status.Reasons = reasons
+	status.Last = sample
+	event := *status
+	m.mu.Unlock()
+
+	// A healthy first round isn't news
+	if !changed && (seen || !sample.Degraded) {
+		return
+	}
+
+	if sample.Degraded {
+		logger.Warn("WAN quality degraded", "target", sample.Target, "reasons", reasons)
+		bus.Publish(bus.Event{Type: bus.EventWANDegraded, ConfigName: "network", Data: event})
+	} else {
+		logger.Info("WAN quality recovered", "target", sample.Target)
+		bus.Publish(bus.Event{Type: bus.EventWANRecovered, ConfigName: "network", Data: event})
+	}
+}
+
+// Ping sends count echo requests to target and measures the round trips
+func Ping(ctx context.Context, target string, count int) db.WANQualitySample {
+	sample := db.WANQualitySample{Kind: KindPing, Target: target, CreatedAt: time.Now()}
+
+	output, err := exec.CommandContext(ctx, "ping", "-n", "-q", "-c", strconv.Itoa(count),
+		"-i", "0.2", "-W", "1", "--", target).CombinedOutput()
+	// ping exits 1 when some replies are missing; the summary still counts them
+	if parseErr := parsePing(string(output), &sample); parseErr != nil {
+		if err == nil {
+			err = parseErr
+		}
+		sample.Error = fmt.Sprintf("ping %s: %v", target, err)
+		sample.LossPercent = 100
+	}
+	return sample
+}
+
+var (
+	pingLossPattern = regexp.MustCompile(`(\d+) packets transmitted, (\d+) (?:packets )?received`)
+	pingRTTPattern  = regexp.MustCompile(`= ([\d.]+)/([\d.]+)/([\d.]+)/([\d.]+) ms`)
+)
+
+// parsePing reads loss and round trip statistics from ping's quiet summary.
+// iputils reports the mean deviation (mdev) of the round trips, which is
+// used as the jitter.
+func parsePing(output string, sample *db.WANQualitySample) error {
+	loss := pingLossPattern.FindStringSubmatch(output)
+	if loss == nil {
+		return fmt.Errorf("no ping summary")
+	}
+	sent, _ := strconv.Atoi(loss[1])
+	received, _ := strconv.Atoi(loss[2])
+	if sent == 0 {
+		return fmt.Errorf("no packets sent")
+	}
+	sample.LossPercent = math.Round(float64(sent-received)/float64(sent)*1000) / 10
+
+	if rtt := pingRTTPattern.FindStringSubmatch(output); rtt != nil {
+		sample.LatencyMs, _ = strconv.ParseFloat(rtt[2], 64)
+		sample.JitterMs, _ = strconv.ParseFloat(rtt[4], 64)
+	}
+	return nil
+}
+
+// iperf3 measures download (reverse mode) and upload throughput against server
+func iperf3(ctx context.Context, server string) db.WANQualitySample {
+	sample := db.WANQualitySample{Kind: KindSpeedtest, Target: server, CreatedAt: time.Now()}
+
+	host, port, err := net.SplitHostPort(server)
+	if err != nil {
+		host, port = strings.Trim(server, "[]"), ""
+	}
+
+	run := func(reverse bool) (float64, error) {
+		args := []string{"-c", host, "-J", "-t", "5"}
+		if port != "" {
+			args = append(args, "-p", port)
+		}
+		if reverse {
+			args = append(args, "-R")
+		}
+		output, err := exec.CommandContext(ctx, "iperf3", args...).Output()
+		if err != nil {
+			return 0, fmt.Errorf("iperf3: %w", err)
+		}
+		return parseIperf3(output)
+	}
+
+	if sample.DownloadMbps, err = run(true); err == nil {
+		sample.UploadMbps, err = run(false)
+	}
+	if err != nil {
+		sample.Error = err.Error()
+	}
+	return sample
+}
+
+// parseIperf3 returns the received throughput in Mbit/s from iperf3 -J output
+func parseIperf3(data []byte) (float64, error) {
+	var result struct {
+		End struct {
+			SumReceived struct {
+				BitsPerSecond float64 `json:"bits_per_second"`
+			} `json:"sum_received"`
+		} `json:"end"`
+		Error string `json:"error"`
+	}
+	if err := json.Unmarshal(data, &result); err != nil {
+		return 0, fmt.Errorf("failed to parse iperf3 output: %w", err)
+	}
+	if result.Error != "" {
+		return 0, fmt.Errorf("iperf3: %s", result.Error)
+	}
+	return mbps(result.End.SumReceived.BitsPerSecond), nil
+}
+
+// speedtestCLI runs speedtest-cli against the nearest public server
+func speedtestCLI(ctx context.Context) db.WANQualitySample {
+	sample := db.WANQualitySample{Kind: KindSpeedtest, Target: "speedtest", CreatedAt: time.Now()}
+
+	output, err := exec.CommandContext(ctx, "speedtest-cli", "--json", "--secure").Output()
+	if err != nil {
+		sample.Error = fmt.Sprintf("speedtest-cli: %v", err)
+		return sample
+	}
+
+	var result struct {
+		Download float64 `json:"download"` // bits/s
+		Upload   float64 `json:"upload"`
+		Ping     float64 `json:"ping"`
+		Server   struct {
+			Host string `json:"host"`
+		} `json:"server"`
+	}
+	if err := json.Unmarshal(output, &result); err != nil {
+		sample.Error = fmt.Sprintf("failed to parse speedtest-cli output: %v", err)
+		return sample
+	}
+
+	if result.Server.Host != "" {
+		sample.Target = result.Server.Host
+	}
+	sample.DownloadMbps = mbps(result.Download)
+	sample.UploadMbps = mbps(result.Upload)
+	sample.LatencyMs = result.Ping
+	return sample
+}
+
+func mbps(bitsPerSecond float64) float64 {
+	return math.Round(bitsPerSecond/1e4) / 100
+}
@@ -0,0 +1,90 @@
+package wanquality
+
+import (
+	"testing"
+	"time"
+
+	"github.com/thesabbir/hellfire/pkg/bus"
+	"github.com/thesabbir/hellfire/pkg/db"
+)
+
+func TestParsePing(t *testing.T) {
+	output := `PING 1.1.1.1 (1.1.1.1) 56(84) bytes of data.
+
+--- 1.1.1.1 ping statistics ---
+5 packets transmitted, 4 received, 20% packet loss, time 803ms
+rtt min/avg/max/mdev = 10.112/12.504/15.876/2.031 ms
+`
+	var sample db.WANQualitySample
+	if err := parsePing(output, &sample); err != nil {
+		t.Fatalf("parsePing: %v", err)
+	}
+	if sample.LossPercent != 20 || sample.LatencyMs != 12.504 || sample.JitterMs != 2.031 {
+		t.Errorf("unexpected sample: %+v", sample)
+	}
+
+	// No replies at all: no rtt line
+	sample = db.WANQualitySample{}
+	if err := parsePing("3 packets transmitted, 0 received, 100% packet loss, time 2040ms\n", &sample); err != nil {
+		t.Fatalf("parsePing without replies: %v", err)
+	}
+	if sample.LossPercent != 100 {
+		t.Errorf("loss = %v, want 100", sample.LossPercent)
+	}
+
+	if err := parsePing("ping: unknown host", &sample); err == nil {
+		t.Error("expected an error without a summary")
+	}
+}
+
+func TestParseIperf3(t *testing.T) {
+	got, err := parseIperf3([]byte(`{"start":{},"end":{"sum_sent":{"bits_per_second":9.6e7},"sum_received":{"bits_per_second":94123456.7}}}`))
+	if err != nil {
+		t.Fatalf("parseIperf3: %v", err)
+	}
+	if got != 94.12 {
+		t.Errorf("throughput = %v, want 94.12", got)
+	}
+
+	if _, err := parseIperf3([]byte(`{"error":"unable to connect to server"}`)); err == nil {
+		t.Error("expected the iperf3 error to be reported")
+	}
+}
+
+func TestMonitorDegradation(t *testing.T) {
+	events, stop := bus.Watch(8)
+	defer stop()
+
+	m := NewMonitor(Config{Targets: []string{"1.1.1.1"}, MaxLatency: 100, MaxLoss: 5})
+	round := func(latency, loss float64) {
+		sample := db.WANQualitySample{Kind: KindPing, Target: "1.1.1.1", LatencyMs: latency, LossPercent: loss, CreatedAt: time.Now()}
+		reasons := m.degradation(sample)
+		sample.Degraded = len(reasons) > 0
+		m.update(sample, reasons)
+	}
+
+	round(20, 0)
+	round(250, 0)
+	round(260, 10)
+	if status := m.Status(); len(status) != 1 || !status[0].Degraded || len(status[0].Reasons) != 2 {
+		t.Errorf("unexpected status: %+v", status)
+	}
+	round(30, 0)
+
+	// Only the transitions are published, not the healthy first round
+	for _, want := range []bus.EventType{bus.EventWANDegraded, bus.EventWANRecovered} {
+		select {
+		case event := <-events:
+			if event.Type != want {
+				t.Errorf("event = %s, want %s", event.Type, want)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("no %s event", want)
+		}
+	}
+	select {
+	case event := <-events:
+		t.Errorf("unexpected event %s", event.Type)
+	default:
+	}
+}
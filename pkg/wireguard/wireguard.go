@@ -0,0 +1,465 @@
+// Package wireguard reports the state of WireGuard tunnels and provisions
+// their peers. Tunnels are configured in the network config the OpenWrt way:
+// an interface section with proto 'wireguard' and a wireguard_<interface>
+// section per peer. The running state is read from `wg show all dump`.
+package wireguard
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/ecdh"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net"
+	"os/exec"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/thesabbir/hellfire/pkg/secrets"
+	"github.com/thesabbir/hellfire/pkg/uci"
+)
+
+// Proto is the proto of WireGuard interface sections
+const Proto = "wireguard"
+
+var (
+	// ErrUnavailable is returned when the wg tool isn't installed
+	ErrUnavailable = errors.New("wireguard tools not installed")
+
+	ErrNotFound = errors.New("not found")
+	ErrInvalid  = errors.New("invalid wireguard config")
+)
+
+// KeyPair is a Curve25519 key pair, base64 encoded as wg uses them
+type KeyPair struct {
+	PrivateKey string `json:"private_key"`
+	PublicKey  string `json:"public_key"`
+}
+
+// GenerateKeyPair generates a key pair, like `wg genkey | wg pubkey`
+func GenerateKeyPair() (KeyPair, error) {
+	key := make([]byte, 32)
+	if _, err := rand.Read(key); err != nil {
+		return KeyPair{}, fmt.Errorf("failed to generate key: %w", err)
+	}
+	// Clamp as wg genkey does, so the stored key is the one in use
+	key[0] &= 248
+	key[31] = (key[31] & 127) | 64
+
+	privateKey := base64.StdEncoding.EncodeToString(key)
+	publicKey, err := PublicKey(privateKey)
+	if err != nil {
+		return KeyPair{}, err
+	}
+	return KeyPair{PrivateKey: privateKey, PublicKey: publicKey}, nil
+}
+
+// GeneratePresharedKey generates a preshared key, like `wg genpsk`
+func GeneratePresharedKey() (string, error) {
+	key := make([]byte, 32)
+	if _, err := rand.Read(key); err != nil {
+		return "", fmt.Errorf("failed to generate key: %w", err)
+	}
+	return base64.StdEncoding.EncodeToString(key), nil
+}
+
+// PublicKey returns the public key of a base64 private key
+func PublicKey(privateKey string) (string, error) {
+	if !validKey(privateKey) {
+		return "", fmt.Errorf("%w: private key must be 32 bytes of base64", ErrInvalid)
+	}
+	data, _ := base64.StdEncoding.DecodeString(privateKey)
+	key, err := ecdh.X25519().NewPrivateKey(data)
+	if err != nil {
+		return "", fmt.Errorf("%w: %v", ErrInvalid, err)
+	}
+	return base64.StdEncoding.EncodeToString(key.PublicKey().Bytes()), nil
+}
+
+// Interface is the state of a WireGuard interface
+type Interface struct {
+	Name       string `json:"name"`
+	Up         bool   `json:"up"` // Running in the kernel
+	PublicKey  string `json:"public_key,omitempty"`
+	ListenPort int    `json:"listen_port,omitempty"`
+	Peers      []Peer `json:"peers"`
+}
+
+// Peer is the state of a peer of an interface
+type Peer struct {
+	Section             string     `json:"section,omitempty"` // Network config section, when configured
+	Description         string     `json:"description,omitempty"`
+	PublicKey           string     `json:"public_key"`
+	Endpoint            string     `json:"endpoint,omitempty"`
+	AllowedIPs          []string   `json:"allowed_ips"`
+	LatestHandshake     *time.Time `json:"latest_handshake,omitempty"` // Unset if there hasn't been one
+	RxBytes             uint64     `json:"rx_bytes"`
+	TxBytes             uint64     `json:"tx_bytes"`
+	PersistentKeepalive int        `json:"persistent_keepalive,omitempty"` // Seconds
+}
+
+// Dump reads the state of the running interfaces
+func Dump(ctx context.Context) ([]Interface, error) {
+	output, err := exec.CommandContext(ctx, "wg", "show", "all", "dump").Output()
+	if errors.Is(err, exec.ErrNotFound) {
+		return nil, ErrUnavailable
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read wireguard state: %w", err)
+	}
+	return ParseDump(output)
+}
+
+// ParseDump parses the output of `wg show all dump`: a line per interface
+// followed by a line per peer, tab separated. Private and preshared keys
+// are dropped.
+func ParseDump(output []byte) ([]Interface, error) {
+	var interfaces []Interface
+	scanner := bufio.NewScanner(bytes.NewReader(output))
+	for scanner.Scan() {
+		fields := strings.Split(scanner.Text(), "\t")
+		switch len(fields) {
+		case 5: // interface, private key, public key, listen port, fwmark
+			port, _ := strconv.Atoi(fields[3])
+			interfaces = append(interfaces, Interface{
+				Name:       fields[0],
+				Up:         true,
+				PublicKey:  none(fields[2]),
+				ListenPort: port,
+				Peers:      []Peer{},
+			})
+		case 9: // interface, public key, preshared key, endpoint, allowed ips, handshake, rx, tx, keepalive
+			if len(interfaces) == 0 || interfaces[len(interfaces)-1].Name != fields[0] {
+				return nil, fmt.Errorf("peer of unknown interface %s", fields[0])
+			}
+			peer := Peer{
+				PublicKey:  fields[1],
+				Endpoint:   none(fields[3]),
+				AllowedIPs: []string{},
+			}
+			if ips := none(fields[4]); ips != "" {
+				peer.AllowedIPs = strings.Split(ips, ",")
+			}
+			if seconds, _ := strconv.ParseInt(fields[5], 10, 64); seconds > 0 {
+				handshake := time.Unix(seconds, 0)
+				peer.LatestHandshake = &handshake
+			}
+			peer.RxBytes, _ = strconv.ParseUint(fields[6], 10, 64)
+			peer.TxBytes, _ = strconv.ParseUint(fields[7], 10, 64)
+			peer.PersistentKeepalive, _ = strconv.Atoi(fields[8]) // "off" is 0
+
+			last := &interfaces[len(interfaces)-1]
+			last.Peers = append(last.Peers, peer)
+		case 1:
+			if fields[0] != "" {
+				return nil, fmt.Errorf("malformed wg dump line: %q", scanner.Text())
+			}
+		default:
+			return nil, fmt.Errorf("malformed wg dump line with %d fields", len(fields))
+		}
+	}
+	return interfaces, scanner.Err()
+}
+
+// Status returns the WireGuard interfaces of the network config with the
+// running state of their peers, followed by any running interfaces the
+// config doesn't have. Without the wg tool it returns the configured
+// interfaces as down, and ErrUnavailable.
+func Status(ctx context.Context, network *uci.Config) ([]Interface, error) {
+	running, err := Dump(ctx)
+	if err != nil && !errors.Is(err, ErrUnavailable) {
+		return nil, err
+	}
+
+	interfaces := Configured(network)
+	for _, live := range running {
+		i := indexOf(interfaces, live.Name)
+		if i < 0 {
+			interfaces = append(interfaces, live)
+			continue
+		}
+
+		iface := &interfaces[i]
+		iface.Up = true
+		iface.PublicKey = live.PublicKey
+		iface.ListenPort = live.ListenPort
+		for _, peer := range live.Peers {
+			j := peerIndex(iface.Peers, peer.PublicKey)
+			if j < 0 {
+				iface.Peers = append(iface.Peers, peer)
+				continue
+			}
+			peer.Section = iface.Peers[j].Section
+			peer.Description = iface.Peers[j].Description
+			iface.Peers[j] = peer
+		}
+	}
+	return interfaces, err
+}
+
+// Configured returns the WireGuard interfaces and peers of the network
+// config, as down
+func Configured(network *uci.Config) []Interface {
+	interfaces := []Interface{}
+	for _, section := range network.GetSectionsByType("interface") {
+		if proto, _ := section.GetOption("proto"); proto != Proto || section.Name == "" {
+			continue
+		}
+		iface := Interface{Name: section.Name, Peers: []Peer{}}
+		if port, ok := section.GetOption("listen_port"); ok {
+			iface.ListenPort, _ = strconv.Atoi(port)
+		}
+
+		for i, peerSection := range network.GetSectionsByType(PeerType(section.Name)) {
+			peer := Peer{
+				Section:    peerName(peerSection, i),
+				AllowedIPs: peerSection.GetList("allowed_ips"),
+			}
+			peer.Description, _ = peerSection.GetOption("description")
+			peer.PublicKey, _ = peerSection.GetOption("public_key")
+			if keepalive, ok := peerSection.GetOption("persistent_keepalive"); ok {
+				peer.PersistentKeepalive, _ = strconv.Atoi(keepalive)
+			}
+			if peer.AllowedIPs == nil {
+				peer.AllowedIPs = []string{}
+			}
+			iface.Peers = append(iface.Peers, peer)
+		}
+		interfaces = append(interfaces, iface)
+	}
+	return interfaces
+}
+
+// IsInterface reports whether the network config has a WireGuard interface
+func IsInterface(network *uci.Config, iface string) bool {
+	section := network.GetSection("interface", iface)
+	if section == nil {
+		return false
+	}
+	proto, _ := section.GetOption("proto")
+	return proto == Proto
+}
+
+// PeerType is the section type of the peers of an interface
+func PeerType(iface string) string {
+	return "wireguard_" + iface
+}
+
+// Addresses returns the tunnel addresses of an interface section (CIDR)
+func Addresses(section *uci.Section) ([]string, error) {
+	addresses := section.GetList("addresses")
+	for _, address := range addresses {
+		if _, _, err := net.ParseCIDR(address); err != nil {
+			return nil, fmt.Errorf("%w: invalid address %q", ErrInvalid, address)
+		}
+	}
+	return addresses, nil
+}
+
+// Setconf renders the `wg setconf` config of an interface: its private key,
+// listen port and enabled peers. The network config must be decrypted, or
+// masked for a preview (masked keys are rendered as they are). Also returns the allowed IPs of the peers with route_allowed_ips set,
+// which are routed through the interface.
+func Setconf(network *uci.Config, iface string) (string, []string, error) {
+	if !IsInterface(network, iface) {
+		return "", nil, fmt.Errorf("wireguard interface %s: %w", iface, ErrNotFound)
+	}
+	section := network.GetSection("interface", iface)
+
+	var b strings.Builder
+	var routes []string
+	privateKey, _ := section.GetOption("private_key")
+	if _, err := PublicKey(privateKey); err != nil && privateKey != secrets.Mask {
+		return "", nil, fmt.Errorf("private_key: %w", err)
+	}
+	b.WriteString("[Interface]\n")
+	fmt.Fprintf(&b, "PrivateKey = %s\n", privateKey)
+	if port, ok := section.GetOption("listen_port"); ok {
+		if n, err := strconv.Atoi(port); err != nil || n < 1 || n > 65535 {
+			return "", nil, fmt.Errorf("%w: invalid listen_port %q", ErrInvalid, port)
+		}
+		fmt.Fprintf(&b, "ListenPort = %s\n", port)
+	}
+
+	for i, peer := range network.GetSectionsByType(PeerType(iface)) {
+		if disabled, _ := peer.GetOption("disabled"); disabled == "1" {
+			continue
+		}
+		name := peerName(peer, i)
+
+		publicKey, _ := peer.GetOption("public_key")
+		if !validKey(publicKey) {
+			return "", nil, fmt.Errorf("peer %s: %w: invalid public_key", name, ErrInvalid)
+		}
+		fmt.Fprintf(&b, "\n[Peer]\nPublicKey = %s\n", publicKey)
+		if psk, ok := peer.GetOption("preshared_key"); ok {
+			if !validKey(psk) && psk != secrets.Mask {
+				return "", nil, fmt.Errorf("peer %s: %w: invalid preshared_key", name, ErrInvalid)
+			}
+			fmt.Fprintf(&b, "PresharedKey = %s\n", psk)
+		}
+		if host, ok := peer.GetOption("endpoint_host"); ok {
+			port, _ := peer.GetOption("endpoint_port")
+			if port == "" {
+				port = "51820"
+			}
+			fmt.Fprintf(&b, "Endpoint = %s\n", net.JoinHostPort(host, port))
+		}
+
+		allowedIPs := peer.GetList("allowed_ips")
+		for _, prefix := range allowedIPs {
+			if _, _, err := net.ParseCIDR(prefix); err != nil {
+				return "", nil, fmt.Errorf("peer %s: %w: invalid allowed_ips %q", name, ErrInvalid, prefix)
+			}
+		}
+		if len(allowedIPs) > 0 {
+			fmt.Fprintf(&b, "AllowedIPs = %s\n", strings.Join(allowedIPs, ", "))
+		}
+		if route, _ := peer.GetOption("route_allowed_ips"); route == "1" {
+			routes = append(routes, allowedIPs...)
+		}
+
+		if keepalive, ok := peer.GetOption("persistent_keepalive"); ok {
+			if n, err := strconv.Atoi(keepalive); err != nil || n < 0 || n > 65535 {
+				return "", nil, fmt.Errorf("peer %s: %w: invalid persistent_keepalive %q", name, ErrInvalid, keepalive)
+			}
+			fmt.Fprintf(&b, "PersistentKeepalive = %s\n", keepalive)
+		}
+	}
+	return b.String(), routes, nil
+}
+
+// validKey reports whether a key is 32 bytes of base64
+func validKey(key string) bool {
+	data, err := base64.StdEncoding.DecodeString(key)
+	return err == nil && len(data) == 32
+}
+
+// FindPeer finds a peer section of an interface by section name, by
+// description or as @wireguard_<interface>[index], and returns it with its
+// config path reference
+func FindPeer(network *uci.Config, iface, peer string) (*uci.Section, string, error) {
+	if !IsInterface(network, iface) {
+		return nil, "", fmt.Errorf("wireguard interface %s: %w", iface, ErrNotFound)
+	}
+
+	peers := network.GetSectionsByType(PeerType(iface))
+	for i, section := range peers {
+		if name := peerName(section, i); name == peer {
+			return section, name, nil
+		}
+	}
+	for i, section := range peers {
+		if description, _ := section.GetOption("description"); description != "" && description == peer {
+			return section, peerName(section, i), nil
+		}
+	}
+	return nil, "", fmt.Errorf("peer %s of %s: %w", peer, iface, ErrNotFound)
+}
+
+// peerName is the name of a peer section, or its @type[index] reference
+// if it is unnamed
+func peerName(section *uci.Section, index int) string {
+	if section.Name != "" {
+		return section.Name
+	}
+	return fmt.Sprintf("@%s[%d]", section.Type, index)
+}
+
+// ClientOptions are the settings of a client config that the router's
+// config doesn't hold
+type ClientOptions struct {
+	Endpoint   string   // Host or host:port clients connect to; the port defaults to the listen port
+	DNS        []string // DNS servers for the client
+	AllowedIPs []string // Routed through the tunnel; everything if empty
+}
+
+// ClientConfig renders a wg-quick config for a peer, for importing into a
+// mobile or desktop client (e.g. by scanning it as a QR code). The network
+// config must be decrypted, and the peer must have a private_key.
+func ClientConfig(network *uci.Config, iface, peer string, opts ClientOptions) (string, error) {
+	section, _, err := FindPeer(network, iface, peer)
+	if err != nil {
+		return "", err
+	}
+	ifaceSection := network.GetSection("interface", iface)
+
+	privateKey, _ := section.GetOption("private_key")
+	if privateKey == "" {
+		return "", fmt.Errorf("%w: peer %s has no private_key; generate its keys first", ErrInvalid, peer)
+	}
+	serverKey, _ := ifaceSection.GetOption("private_key")
+	serverPublicKey, err := PublicKey(serverKey)
+	if err != nil {
+		return "", fmt.Errorf("interface %s: %w", iface, err)
+	}
+
+	endpoint := opts.Endpoint
+	if endpoint == "" {
+		return "", fmt.Errorf("%w: endpoint required", ErrInvalid)
+	}
+	if _, _, err := net.SplitHostPort(endpoint); err != nil {
+		port, _ := ifaceSection.GetOption("listen_port")
+		if port == "" {
+			return "", fmt.Errorf("%w: interface %s has no listen_port; give the endpoint port", ErrInvalid, iface)
+		}
+		endpoint = net.JoinHostPort(strings.Trim(endpoint, "[]"), port)
+	}
+
+	allowedIPs := opts.AllowedIPs
+	if len(allowedIPs) == 0 {
+		allowedIPs = []string{"0.0.0.0/0", "::/0"}
+	}
+
+	// The peer's allowed IPs on the router are its tunnel addresses
+	var b strings.Builder
+	b.WriteString("[Interface]\n")
+	fmt.Fprintf(&b, "PrivateKey = %s\n", privateKey)
+	if addresses := section.GetList("allowed_ips"); len(addresses) > 0 {
+		fmt.Fprintf(&b, "Address = %s\n", strings.Join(addresses, ", "))
+	}
+	if len(opts.DNS) > 0 {
+		fmt.Fprintf(&b, "DNS = %s\n", strings.Join(opts.DNS, ", "))
+	}
+	b.WriteString("\n[Peer]\n")
+	fmt.Fprintf(&b, "PublicKey = %s\n", serverPublicKey)
+	if psk, _ := section.GetOption("preshared_key"); psk != "" {
+		fmt.Fprintf(&b, "PresharedKey = %s\n", psk)
+	}
+	fmt.Fprintf(&b, "Endpoint = %s\n", endpoint)
+	fmt.Fprintf(&b, "AllowedIPs = %s\n", strings.Join(allowedIPs, ", "))
+	if keepalive, _ := section.GetOption("persistent_keepalive"); keepalive != "" && keepalive != "0" {
+		fmt.Fprintf(&b, "PersistentKeepalive = %s\n", keepalive)
+	}
+	return b.String(), nil
+}
+
+// none returns "" for the "(none)" placeholder of wg dumps
+func none(field string) string {
+	if field == "(none)" {
+		return ""
+	}
+	return field
+}
+
+func indexOf(interfaces []Interface, name string) int {
+	for i, iface := range interfaces {
+		if iface.Name == name {
+			return i
+		}
+	}
+	return -1
+}
+
+func peerIndex(peers []Peer, publicKey string) int {
+	for i, peer := range peers {
+		if peer.PublicKey == publicKey {
+			return i
+		}
+	}
+	return -1
+}
@@ -0,0 +1,113 @@
+package wireguard
+
+import (
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/thesabbir/hellfire/pkg/secrets"
+	"github.com/thesabbir/hellfire/pkg/uci"
+)
+
+func TestPublicKey(t *testing.T) {
+	// RFC 7748 section 6.1
+	private, _ := hex.DecodeString("77076d0a7318a57d3c16c17251b26645df4c2f87ebc0992ab177fba51db92c2a")
+	public, _ := hex.DecodeString("8520f0098930a754748b7ddcb43ef75a0dbf3a0d26381af4eba4a98eaa9b4e6a")
+
+	got, err := PublicKey(base64.StdEncoding.EncodeToString(private))
+	if err != nil || got != base64.StdEncoding.EncodeToString(public) {
+		t.Errorf("PublicKey = %q, %v", got, err)
+	}
+	if _, err := PublicKey("short"); !errors.Is(err, ErrInvalid) {
+		t.Errorf("PublicKey of an invalid key = %v", err)
+	}
+
+	pair, err := GenerateKeyPair()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if public, _ := PublicKey(pair.PrivateKey); public != pair.PublicKey {
+		t.Errorf("generated public key %q doesn't match %q", pair.PublicKey, public)
+	}
+}
+
+func TestParseDump(t *testing.T) {
+	dump := "wg0\tcHJpdmF0ZQ==\tc2VydmVy\t51820\toff\n" +
+		"wg0\tcGhvbmU=\t(none)\t203.0.113.5:40000\t10.8.0.2/32,fd00::2/128\t1700000000\t1024\t2048\t25\n" +
+		"wg0\tbGFwdG9w\tcHNr\t(none)\t(none)\t0\t0\t0\toff\n"
+
+	interfaces, err := ParseDump([]byte(dump))
+	if err != nil {
+		t.Fatalf("ParseDump: %v", err)
+	}
+	if len(interfaces) != 1 || len(interfaces[0].Peers) != 2 {
+		t.Fatalf("interfaces = %+v", interfaces)
+	}
+	iface := interfaces[0]
+	if !iface.Up || iface.PublicKey != "c2VydmVy" || iface.ListenPort != 51820 {
+		t.Errorf("interface = %+v", iface)
+	}
+
+	phone := iface.Peers[0]
+	if phone.Endpoint != "203.0.113.5:40000" || len(phone.AllowedIPs) != 2 || phone.RxBytes != 1024 ||
+		phone.TxBytes != 2048 || phone.PersistentKeepalive != 25 || phone.LatestHandshake == nil ||
+		phone.LatestHandshake.Unix() != 1700000000 {
+		t.Errorf("phone = %+v", phone)
+	}
+	laptop := iface.Peers[1]
+	if laptop.Endpoint != "" || len(laptop.AllowedIPs) != 0 || laptop.LatestHandshake != nil || laptop.PersistentKeepalive != 0 {
+		t.Errorf("laptop = %+v", laptop)
+	}
+
+	if _, err := ParseDump([]byte("wg1\tcGhvbmU=\t(none)\t(none)\t(none)\t0\t0\t0\toff\n")); err == nil {
+		t.Error("expected an error for a peer without its interface")
+	}
+}
+
+func TestSetconf(t *testing.T) {
+	server, _ := GenerateKeyPair()
+	peer, _ := GenerateKeyPair()
+
+	network := uci.NewConfig()
+	iface := uci.NewSection("interface", "wg0")
+	iface.SetOption("proto", Proto)
+	iface.SetOption("private_key", server.PrivateKey)
+	iface.SetOption("listen_port", "51820")
+	network.AddSection(iface)
+
+	phone := uci.NewSection(PeerType("wg0"), "")
+	phone.SetOption("description", "phone")
+	phone.SetOption("public_key", peer.PublicKey)
+	phone.SetOption("route_allowed_ips", "1")
+	phone.AddListValue("allowed_ips", "10.8.0.2/32")
+	network.AddSection(phone)
+
+	conf, routes, err := Setconf(network, "wg0")
+	if err != nil {
+		t.Fatalf("Setconf: %v", err)
+	}
+	if !strings.Contains(conf, "PrivateKey = "+server.PrivateKey) || !strings.Contains(conf, "AllowedIPs = 10.8.0.2/32") {
+		t.Errorf("conf:\n%s", conf)
+	}
+	if len(routes) != 1 || routes[0] != "10.8.0.2/32" {
+		t.Errorf("routes = %v", routes)
+	}
+
+	if section, ref, err := FindPeer(network, "wg0", "phone"); err != nil || section != phone || ref != "@wireguard_wg0[0]" {
+		t.Errorf("FindPeer = %v, %q, %v", section, ref, err)
+	}
+
+	// Previews render masked keys
+	masked := network.Clone()
+	masked.GetSection("interface", "wg0").SetOption("private_key", secrets.Mask)
+	if _, _, err := Setconf(masked, "wg0"); err != nil {
+		t.Errorf("Setconf of a masked config: %v", err)
+	}
+
+	phone.SetOption("public_key", "not-a-key")
+	if _, _, err := Setconf(network, "wg0"); !errors.Is(err, ErrInvalid) {
+		t.Errorf("Setconf with an invalid peer key = %v", err)
+	}
+}
@@ -0,0 +1,23 @@
+// Package web embeds the production build of the web UI (web/dist) so the
+// hf binary can serve it without any files on disk. Run `just web-build`
+// before `go build` to include the UI; without a build only a placeholder
+// is embedded.
+package web
+
+import (
+	"embed"
+	"io/fs"
+)
+
+//go:embed all:dist
+var dist embed.FS
+
+// Dist returns the embedded build, rooted at the dist directory
+func Dist() fs.FS {
+	sub, err := fs.Sub(dist, "dist")
+	if err != nil {
+		// Only fails for an invalid path, which "dist" is not
+		panic(err)
+	}
+	return sub
+}
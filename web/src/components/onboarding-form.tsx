@@ -23,6 +23,7 @@ export function OnboardingForm({
     setError("");
 
     const formData = new FormData(e.currentTarget);
+    const token = formData.get("token") as string;
     const name = formData.get("name") as string;
     const email = formData.get("email") as string;
     const password = formData.get("password") as string;
@@ -37,7 +38,7 @@ export function OnboardingForm({
 
     try {
       const response = await postOnboarding({
-        body: { name, email, password },
+        body: { token, name, email, password },
       });
 
       if (response.error) {
@@ -84,6 +85,24 @@ export function OnboardingForm({
               </div>
             )}
 
+            <div className="space-y-2">
+              <Label htmlFor="onboarding-token">Onboarding Token</Label>
+              <Input
+                id="onboarding-token"
+                name="token"
+                type="text"
+                placeholder="Printed by hf serve at startup"
+                required
+                disabled={loading}
+                autoComplete="off"
+                spellCheck={false}
+                className="h-11 font-mono"
+              />
+              <p className="text-xs text-muted-foreground">
+                Shown on the router's console or in <code>journalctl -u hellfire-api</code>
+              </p>
+            </div>
+
             <div className="space-y-2">
               <Label htmlFor="admin-name">Full Name</Label>
               <Input
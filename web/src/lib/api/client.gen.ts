@@ -22,6 +22,6 @@ export type CreateClientConfig<T extends ClientOptions = ClientOptions2> = (
 
 export const client = createClient(
   createConfig<ClientOptions2>({
-    baseUrl: "http://localhost:8888/api",
+    baseUrl: "http://localhost:8888/api/v1",
   }),
 );
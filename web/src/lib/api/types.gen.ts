@@ -39,6 +39,10 @@ export type MainOnboardingRequest = {
   email: string;
   name: string;
   password: string;
+  /**
+   * One-time token printed by hf serve
+   */
+  token: string;
 };
 
 export type GetAuthCsrfData = {
@@ -435,6 +439,12 @@ export type PostOnboardingErrors = {
   400: {
     [key: string]: string;
   };
+  /**
+   * Unauthorized
+   */
+  401: {
+    [key: string]: string;
+  };
   /**
    * Conflict
    */
@@ -7,6 +7,10 @@ import path from "path";
 // https://vite.dev/config/
 export default defineConfig({
   plugins: [tanstackRouter(), react(), tailwindcss()],
+  // hf serve replaces the placeholder with a per-request CSP nonce
+  html: {
+    cspNonce: "__CSP_NONCE__",
+  },
   resolve: {
     alias: {
       "@": path.resolve(__dirname, "./src"),